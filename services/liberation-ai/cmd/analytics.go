@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/analytics"
+	"liberation-ai/pkg/auth"
+)
+
+// analyticsRoutes registers search analytics reports and exports
+func (s *server) analyticsRoutes(admin *auth.RouteGroup) {
+	admin.GET("/analytics/report", s.analyticsReport)
+	admin.GET("/analytics/export", s.exportAnalytics)
+}
+
+// Search analytics reports and raw event export
+func (s *server) analyticsReport(c *gin.Context) {
+	filter, err := analytics.ParseFilter(c.Query("namespace"), c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	top := 20
+	if t := c.Query("top"); t != "" {
+		if parsed, err := fmt.Sscanf(t, "%d", &top); err != nil || parsed != 1 {
+			top = 20
+		}
+	}
+
+	if c.Query("anonymize") != "true" {
+		c.JSON(http.StatusOK, s.recorder.Report(filter, top))
+		return
+	}
+	export, err := s.recorder.NewExport()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s.recorder.AnonymizedReport(filter, top, export))
+}
+
+func (s *server) exportAnalytics(c *gin.Context) {
+	filter, err := analytics.ParseFilter(c.Query("namespace"), c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "jsonl")
+	switch format {
+	case "jsonl":
+		c.Header("Content-Type", "application/x-ndjson")
+	case "csv":
+		c.Header("Content-Type", "text/csv")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be jsonl or csv"})
+		return
+	}
+	// Anonymized exports can be shared with product teams; each has its own salt
+	if c.Query("anonymize") == "true" {
+		export, err := s.recorder.NewExport()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=search-analytics-%s.%s", export.ID(), format))
+		c.Header("X-Export-ID", export.ID())
+		c.Status(http.StatusOK)
+		if err := s.recorder.ExportAnonymized(c.Writer, filter, format, export); err != nil {
+			c.Error(err)
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=search-analytics.%s", format))
+	c.Status(http.StatusOK)
+
+	if err := s.recorder.Export(c.Writer, filter, format); err != nil {
+		c.Error(err)
+	}
+}
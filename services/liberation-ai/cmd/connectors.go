@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/connectors"
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/types"
+)
+
+// connectorRoutes registers ingestion connectors and their sources
+func (s *server) connectorRoutes(admin *auth.RouteGroup) {
+	admin.GET("/connectors", s.listConnectors)
+	admin.GET("/connectors/sources", s.listSources)
+	admin.POST("/connectors/sources", s.addSource)
+	admin.GET("/connectors/sources/:name", s.getSource)
+	admin.PUT("/connectors/sources/:name/credentials", s.setSourceCredentials)
+	admin.POST("/connectors/sources/:name/sync", s.syncSource)
+	admin.DELETE("/connectors/sources/:name", s.removeSource)
+}
+
+// Ingestion connectors and the sources synced with them; credentials are write-only
+func (s *server) listConnectors(c *gin.Context) {
+	list := s.sources.Connectors(c.Request.Context())
+	c.JSON(http.StatusOK, gin.H{"connectors": list, "count": len(list)})
+}
+
+func (s *server) listSources(c *gin.Context) {
+	list := s.sources.Sources()
+	c.JSON(http.StatusOK, gin.H{"sources": list, "count": len(list)})
+}
+
+func (s *server) addSource(c *gin.Context) {
+	var req connectors.SourceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	status, err := s.sources.Add(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(connectorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, status)
+}
+
+func (s *server) getSource(c *gin.Context) {
+	status, err := s.sources.Source(c.Param("name"))
+	if err != nil {
+		c.JSON(connectorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (s *server) setSourceCredentials(c *gin.Context) {
+	var req struct {
+		Credentials map[string]string `json:"credentials"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	status, err := s.sources.SetCredentials(c.Request.Context(), c.Param("name"), req.Credentials)
+	if err != nil {
+		c.JSON(connectorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// Syncs run in the background; progress shows in the source's last_sync
+func (s *server) syncSource(c *gin.Context) {
+	status, err := s.sources.Source(c.Param("name"))
+	if err == nil && status.Syncing {
+		err = connectors.ErrSyncRunning
+	}
+	if err != nil {
+		c.JSON(connectorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	go s.sources.Sync(context.Background(), status.Name)
+	c.JSON(http.StatusAccepted, gin.H{"source": status.Name, "requested": true})
+}
+
+// purge=true also deletes the documents the source synced
+func (s *server) removeSource(c *gin.Context) {
+	purge := c.Query("purge") == "true"
+	if purge && s.failover != nil && s.failover.Status().Mode != vectorstore.ModePrimary {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": types.ErrStoreDegraded.Error()})
+		return
+	}
+	deleted, err := s.sources.Remove(c.Request.Context(), c.Param("name"), purge)
+	if err != nil {
+		c.JSON(connectorErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"source": c.Param("name"), "deleted": deleted})
+}
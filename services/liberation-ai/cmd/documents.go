@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/budget"
+	"liberation-ai/internal/ingesttoken"
+	"liberation-ai/internal/provisioning"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/internal/residency"
+	"liberation-ai/internal/upload"
+	"liberation-ai/internal/validate"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// documentRoutes registers document and vector writes, and the ingestion tokens for them
+func (s *server) documentRoutes(v1, admin *auth.RouteGroup) {
+	v1.POST("/documents", validate.Body(s.validator.Documents), s.storeDocuments)
+	v1.POST("/documents/stream", s.streamDocuments)
+	v1.GET("/documents/stream", s.listUploads)
+	v1.GET("/documents/stream/:session", s.uploadStatus)
+	v1.POST("/vectors", validate.Body(s.validator.Vectors), s.storeVectors)
+
+	admin.POST("/ingest-tokens", s.mintIngestToken)
+}
+
+// Store text documents
+func (s *server) storeDocuments(c *gin.Context) {
+	var docs []liberation.Document
+	if err := c.ShouldBindJSON(&docs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	namespace := c.Query("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	// Frontends upload with an ingestion token scoped to this namespace
+	_, isService := auth.GetService(c)
+	_, provisioned := provisioning.RequestTenant(c)
+	if !s.ingestTokens.AuthorizeUpload(c, namespace, len(docs), isService || provisioned || s.limiter.IsAdmin(c.GetHeader(ratelimit.APIKeyHeader))) {
+		return
+	}
+
+	if !s.limiter.ReserveEmbeddings(c, len(docs)) {
+		return
+	}
+	if !s.budgets.ReserveWrite(c, namespace, budget.DocumentTokens(docs), int64(len(docs))) {
+		return
+	}
+	if chunks := int64(s.vectorService.ChunkCount(docs)); !s.tenants.ReserveIngest(c, namespace, chunks, chunks) {
+		return
+	}
+	// The token is charged last, so an upload the quotas refuse does not use it up
+	if !s.ingestTokens.ChargeUpload(c, namespace, len(docs)) {
+		return
+	}
+
+	// Archive the raw batch first so it can be re-embedded even if indexing fails
+	if s.archiver.Enabled() {
+		if err := s.residencyPolicy.Check(namespace, residency.BackendArchive); err != nil {
+			c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		key, err := s.archiver.Archive(c.Request.Context(), namespace, docs)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("X-Ingest-Archive", key)
+	}
+
+	response, err := s.vectorService.StoreDocuments(c.Request.Context(), namespace, docs)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), storeErrorBody(err))
+		return
+	}
+	for _, doc := range docs {
+		s.rewriter.Observe(namespace, doc.Title, doc.Content)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Stream a large upload as NDJSON, one document per line. Reading pauses while the
+// embedding queue is full and progress frames are written back as batches are stored.
+// An interrupted upload resumes with ?session=<id>&offset=<line the body starts at>.
+func (s *server) streamDocuments(c *gin.Context) {
+	namespace := c.Query("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+	var offset int64
+	if o := c.Query("offset"); o != "" {
+		if _, err := fmt.Sscanf(o, "%d", &offset); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a line number"})
+			return
+		}
+	}
+
+	// How many documents are coming is unknown, so an ingestion token is checked now
+	// and charged batch by batch
+	_, isService := auth.GetService(c)
+	_, provisioned := provisioning.RequestTenant(c)
+	if !s.ingestTokens.AuthorizeUpload(c, namespace, 0, isService || provisioned || s.limiter.IsAdmin(c.GetHeader(ratelimit.APIKeyHeader))) {
+		return
+	}
+	claims, _ := ingesttoken.RequestClaims(c)
+
+	identity := ratelimit.Identity(c)
+	session, err := s.uploads.Open(c.Query("session"), namespace, identity, offset)
+	switch {
+	case errors.Is(err, upload.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, upload.ErrSessionActive), errors.Is(err, upload.ErrOffsetGap):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Each batch goes through the checks POST /v1/documents makes once per request.
+	// Documents the store rejects are reported and skipped; any other failure stops
+	// the upload, which can be resumed once the quota resets or the store recovers.
+	locale := validate.MatchLocale(c.GetHeader("Accept-Language"))
+	hooks := upload.Hooks{
+		Check: func(doc *liberation.Document) error {
+			if problems := s.validator.Document(doc); len(problems) > 0 {
+				return problems.Localize(locale)
+			}
+			return nil
+		},
+		Store: func(ctx context.Context, docs []liberation.Document) error {
+			if claims != nil {
+				if _, err := s.ingestTokens.Check(claims, namespace, len(docs)); err != nil {
+					return err
+				}
+			}
+			if s.limiter.Enabled() {
+				if _, err := s.limiter.ConsumeEmbeddings(identity, int64(len(docs))); err != nil {
+					return err
+				}
+			}
+			if err := s.budgets.Charge(namespace, budget.DocumentTokens(docs), int64(len(docs)), true); err != nil {
+				return err
+			}
+			chunks := int64(s.vectorService.ChunkCount(docs))
+			if _, err := s.tenants.CheckIngest(ctx, namespace, chunks, chunks); err != nil {
+				return err
+			}
+			if claims != nil {
+				if _, err := s.ingestTokens.Consume(claims, namespace, len(docs)); err != nil {
+					return err
+				}
+			}
+			if s.archiver.Enabled() {
+				if err := s.residencyPolicy.Check(namespace, residency.BackendArchive); err != nil {
+					return upload.Rejected(err)
+				}
+				if _, err := s.archiver.Archive(ctx, namespace, docs); err != nil {
+					return err
+				}
+			}
+			if _, err := s.vectorService.StoreDocuments(ctx, namespace, docs); err != nil {
+				if errors.Is(err, types.ErrSchemaViolation) || errors.Is(err, types.ErrDimensionMismatch) || errors.Is(err, types.ErrResidency) {
+					return upload.Rejected(err)
+				}
+				return err
+			}
+			for _, doc := range docs {
+				s.rewriter.Observe(namespace, doc.Title, doc.Content)
+			}
+			return nil
+		},
+	}
+
+	// Frames are written while the body is still being read; HTTP/2 allows this
+	// already, HTTP/1 has to be asked
+	http.NewResponseController(c.Writer).EnableFullDuplex()
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("X-Upload-Session", session.ID())
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	s.uploads.Run(c.Request.Context(), session, c.Request.Body, offset, hooks, func(frame upload.Frame) error {
+		if err := encoder.Encode(frame); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+}
+
+// The caller's upload sessions, most recent first, to find where to resume
+func (s *server) listUploads(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"sessions": s.uploads.Sessions(ratelimit.Identity(c))})
+}
+
+func (s *server) uploadStatus(c *gin.Context) {
+	status, err := s.uploads.Status(c.Param("session"), ratelimit.Identity(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// Store vectors embedded elsewhere; nothing is embedded, so only the write is metered
+func (s *server) storeVectors(c *gin.Context) {
+	var req types.StoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.provisioner.Allows(c, req.Namespace) {
+		return
+	}
+
+	_, isService := auth.GetService(c)
+	_, provisioned := provisioning.RequestTenant(c)
+	if !s.ingestTokens.AuthorizeUpload(c, req.Namespace, len(req.Vectors), isService || provisioned || s.limiter.IsAdmin(c.GetHeader(ratelimit.APIKeyHeader))) {
+		return
+	}
+	if !s.budgets.ReserveWrite(c, req.Namespace, 0, 0) {
+		return
+	}
+	if !s.tenants.ReserveIngest(c, req.Namespace, int64(len(req.Vectors)), 0) {
+		return
+	}
+	if !s.ingestTokens.ChargeUpload(c, req.Namespace, len(req.Vectors)) {
+		return
+	}
+
+	response, err := s.vectorService.StoreVectors(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), storeErrorBody(err))
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Mint a token a frontend can upload documents to one namespace with
+func (s *server) mintIngestToken(c *gin.Context) {
+	var req ingesttoken.MintRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, err := s.ingestTokens.Mint(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ingesttoken.ErrDisabled) {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, token)
+}
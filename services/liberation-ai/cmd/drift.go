@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/drift"
+	"liberation-ai/pkg/auth"
+
+	"liberation-dryrun"
+)
+
+// driftRoutes registers drift checks and re-embedding campaigns
+func (s *server) driftRoutes(admin *auth.RouteGroup) {
+	admin.GET("/drift", s.driftReport)
+	admin.POST("/drift/check", s.checkDrift)
+	admin.POST("/reembed", s.startReembed)
+	admin.GET("/reembed", s.listReembeds)
+	admin.GET("/reembed/:id", s.getReembed)
+	admin.DELETE("/reembed/:id", s.cancelReembed)
+}
+
+// Embedding drift: the latest check of each namespace, or a check run now
+func (s *server) driftReport(c *gin.Context) {
+	reports := s.detector.Reports()
+	c.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+		"count":   len(reports),
+	})
+}
+
+func (s *server) checkDrift(c *gin.Context) {
+	if namespace := c.Query("namespace"); namespace != "" {
+		c.JSON(http.StatusOK, gin.H{"reports": []drift.Report{s.detector.Check(c.Request.Context(), namespace)}, "count": 1})
+		return
+	}
+	reports := s.detector.CheckAll(c.Request.Context())
+	c.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+		"count":   len(reports),
+	})
+}
+
+// Throttled, cost-capped re-embedding of a whole namespace
+func (s *server) startReembed(c *gin.Context) {
+	var req drift.CampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	campaign, err := s.campaigns.Start(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, drift.ErrCampaignActive) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, campaign)
+}
+
+func (s *server) listReembeds(c *gin.Context) {
+	list := s.campaigns.List()
+	c.JSON(http.StatusOK, gin.H{
+		"campaigns": list,
+		"count":     len(list),
+	})
+}
+
+func (s *server) getReembed(c *gin.Context) {
+	campaign, exists := s.campaigns.Get(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": drift.ErrCampaignNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, campaign)
+}
+
+func (s *server) cancelReembed(c *gin.Context) {
+	if previewDryRun(c, func() (*dryrun.Plan, error) {
+		campaign, exists := s.campaigns.Get(c.Param("id"))
+		if !exists {
+			return nil, drift.ErrCampaignNotFound
+		}
+		plan := dryrun.New("cancel_reembed", campaign.ID)
+		if campaign.Status == drift.CampaignScheduled || campaign.Status == drift.CampaignRunning {
+			return plan.Add("campaigns", "cancel", 1, []string{campaign.ID}).
+				Warn("vectors already re-embedded keep their new embeddings"), nil
+		}
+		return plan.Add("campaigns", "cancel", 0, nil), nil
+	}) {
+		return
+	}
+	campaign, err := s.campaigns.Cancel(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, campaign)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"liberation-ai/internal/drift"
+	"liberation-ai/pkg/types"
+
+	"liberation-dryrun"
+
+	"github.com/gin-gonic/gin"
+)
+
+// previewDryRun answers ?dry_run=true with the plan and reports whether it did. The plan is
+// only built for a preview; handlers return when previewDryRun reports true.
+func previewDryRun(c *gin.Context, plan func() (*dryrun.Plan, error)) bool {
+	if !dryrun.Requested(c.Request) {
+		return false
+	}
+	preview, err := plan()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, drift.ErrCampaignNotFound) || errors.Is(err, types.ErrNamespaceNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return true
+	}
+	c.JSON(http.StatusOK, preview)
+	return true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/encryption"
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/types"
+)
+
+// encryptionRoutes registers data key listing and rotation
+func (s *server) encryptionRoutes(admin *auth.RouteGroup) {
+	admin.GET("/encryption", s.encryptionKeys)
+	admin.POST("/encryption/:namespace/rotate", s.rotateKey)
+}
+
+// Data keys of encrypted namespaces
+func (s *server) encryptionKeys(c *gin.Context) {
+	status := s.vectorService.EncryptionStatus()
+	c.JSON(http.StatusOK, gin.H{"namespaces": status, "count": len(status)})
+}
+
+// Re-encrypt a namespace with a new data key, then forget the old ones
+func (s *server) rotateKey(c *gin.Context) {
+	if !s.vectorService.Encrypted(c.Param("namespace")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": encryption.ErrNotEncrypted.Error()})
+		return
+	}
+	// Re-encrypted vectors must reach the primary
+	if s.failover != nil && s.failover.Status().Mode != vectorstore.ModePrimary {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": types.ErrStoreDegraded.Error()})
+		return
+	}
+
+	// A rotation keeps going if the caller goes away, so old keys are not left half-retired
+	rotation, err := s.vectorService.RotateNamespaceKey(context.WithoutCancel(c.Request.Context()), c.Param("namespace"))
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rotation)
+}
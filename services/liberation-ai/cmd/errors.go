@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/connectors"
+	"liberation-ai/pkg/connector"
+	"liberation-ai/pkg/types"
+)
+
+// storeErrorStatus maps an error from the vector store to the status a handler answers with
+func storeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, types.ErrNotFound), errors.Is(err, types.ErrNamespaceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, types.ErrDimensionMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, types.ErrSchemaViolation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, types.ErrNamespaceExists):
+		return http.StatusConflict
+	case errors.Is(err, types.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, types.ErrResidency):
+		return http.StatusForbidden
+	case errors.Is(err, types.ErrWriteQueueFull), errors.Is(err, types.ErrStoreDegraded):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// storeErrorBody is the response body for an error from the vector store. Metadata that
+// breaks a namespace's schema is listed field by field, as request validation does.
+func storeErrorBody(err error) gin.H {
+	var invalid *types.MetadataError
+	if errors.As(err, &invalid) {
+		return gin.H{"error": types.ErrSchemaViolation.Error(), "fields": invalid.Problems}
+	}
+	return gin.H{"error": err.Error()}
+}
+
+// connectorErrorStatus maps an error from the connector registry, or from a connector it
+// called, to the status a handler answers with
+func connectorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, connectors.ErrSourceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, connectors.ErrSourceExists), errors.Is(err, connectors.ErrSyncRunning):
+		return http.StatusConflict
+	case errors.Is(err, connectors.ErrUnknownConnector), errors.Is(err, connectors.ErrNoKeyring),
+		errors.Is(err, connector.ErrInvalidSource), errors.Is(err, connector.ErrUnauthorized):
+		return http.StatusBadRequest
+	}
+	return storeErrorStatus(err)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryFilters reads metadata filters from query parameters: filter.<key>=<value> matches
+// metadata, as filters do in request bodies, and filter.<key>[gte]=<n> and the other
+// bounds select a numeric range
+func queryFilters(c *gin.Context) map[string]interface{} {
+	var filters map[string]interface{}
+	for key, values := range c.Request.URL.Query() {
+		field, ok := strings.CutPrefix(key, "filter.")
+		if !ok || field == "" {
+			continue
+		}
+		if filters == nil {
+			filters = map[string]interface{}{}
+		}
+		name, bound, isRange := strings.Cut(field, "[")
+		if !isRange || !strings.HasSuffix(bound, "]") || name == "" {
+			filters[field] = values[0]
+			continue
+		}
+		bounds, _ := filters[name].(map[string]interface{})
+		if bounds == nil {
+			bounds = map[string]interface{}{}
+			filters[name] = bounds
+		}
+		bounds[strings.TrimSuffix(bound, "]")] = values[0]
+	}
+	return filters
+}
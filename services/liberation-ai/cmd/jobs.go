@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/pkg/auth"
+
+	"liberation-scheduler"
+)
+
+// jobRoutes registers background job schedules and manual runs
+func (s *server) jobRoutes(admin *auth.RouteGroup) {
+	admin.GET("/jobs", s.listJobs)
+	admin.PUT("/jobs/:name", s.updateJob)
+	admin.POST("/jobs/:name/run", s.runJob)
+}
+
+// Background jobs: schedules, last runs, and manual runs
+func (s *server) listJobs(c *gin.Context) {
+	list := s.jobs.Jobs()
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":  list,
+		"count": len(list),
+	})
+}
+
+func (s *server) updateJob(c *gin.Context) {
+	var update scheduler.Update
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if update.Schedule != nil && *update.Schedule != "" {
+		if _, err := scheduler.Parse(*update.Schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	status, err := s.jobs.Update(c.Request.Context(), c.Param("name"), update)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrUnknownJob) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (s *server) runJob(c *gin.Context) {
+	if err := s.jobs.Trigger(c.Param("name")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"job": c.Param("name"), "requested": true})
+}
@@ -2,28 +2,67 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
-	"runtime"
 	"time"
 
 	"github.com/gin-gonic/gin"
-
-	"liberation-ai/internal/service"
+	"github.com/sirupsen/logrus"
+
+	"liberation-ai/internal/analytics"
+	"liberation-ai/internal/archive"
+	"liberation-ai/internal/budget"
+	"liberation-ai/internal/cli"
+	"liberation-ai/internal/config"
+	"liberation-ai/internal/connectors"
+	"liberation-ai/internal/doctor"
+	"liberation-ai/internal/drift"
+	"liberation-ai/internal/embedcache"
+	"liberation-ai/internal/encryption"
+	"liberation-ai/internal/erasure"
+	"liberation-ai/internal/extract"
+	"liberation-ai/internal/ingesttoken"
+	"liberation-ai/internal/migration"
+	"liberation-ai/internal/personalize"
+	"liberation-ai/internal/provisioning"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/internal/relevance"
+	"liberation-ai/internal/residency"
+	"liberation-ai/internal/rewrite"
+	"liberation-ai/internal/shadow"
+	"liberation-ai/internal/tenancy"
+	"liberation-ai/internal/upload"
+	"liberation-ai/internal/validate"
 	"liberation-ai/internal/vectorstore"
 	"liberation-ai/internal/wizard"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+
+	"liberation-cache"
+	"liberation-dbpool"
+	"liberation-profiling"
+	"liberation-scheduler"
+	"liberation-serviceauth"
 )
 
 var (
 	wizardMode = flag.Bool("init", false, "Run the Liberation AI setup wizard")
 	serve      = flag.Bool("serve", false, "Start the Liberation AI server")
-	config     = flag.String("config", "liberation-ai.yml", "Path to configuration file")
+	configFile = flag.String("config", "liberation-ai.yml", "Path to configuration file")
 	port       = flag.Int("port", 8080, "Port to serve on")
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if runSubcommand(os.Args[1], os.Args[2:]) {
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if *wizardMode {
@@ -40,6 +79,43 @@ func main() {
 	showHelp()
 }
 
+// runSubcommand handles `liberation-ai <command>` style invocations.
+// It returns false when the first argument is not a known subcommand.
+func runSubcommand(name string, args []string) bool {
+	var err error
+
+	switch name {
+	case "init":
+		flag.CommandLine.Parse(args)
+		runSetupWizard()
+	case "serve":
+		flag.CommandLine.Parse(args)
+		runServer()
+	case "ingest":
+		err = cli.RunIngest(args, os.Stdout, os.Stderr)
+	case "search":
+		err = cli.RunSearch(args, os.Stdout, os.Stderr)
+	case "query":
+		err = cli.RunQuery(args, os.Stdin, os.Stdout, os.Stderr)
+	case "doctor":
+		err = cli.RunDoctor(args, os.Stdout, os.Stderr)
+	case "migrate":
+		err = cli.RunMigrate(args, os.Stdout, os.Stderr)
+	case "help":
+		showHelp()
+	default:
+		return false
+	}
+
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		}
+		os.Exit(1)
+	}
+	return true
+}
+
 func runSetupWizard() {
 	fmt.Println("🤖 Liberation AI Setup Wizard")
 	fmt.Println("=============================")
@@ -57,220 +133,421 @@ func runSetupWizard() {
 	fmt.Println("🚀 Liberation AI is ready!")
 	fmt.Println()
 	fmt.Println("Next steps:")
-	fmt.Printf("  liberation-ai serve --config=%s\n", *config)
+	fmt.Printf("  liberation-ai serve --config=%s\n", *configFile)
 	fmt.Println("  curl http://localhost:8080/health")
 	fmt.Println()
 }
 
 func runServer() {
 	fmt.Printf("🚀 Starting Liberation AI server on port %d...\n", *port)
-	fmt.Printf("📄 Config file: %s\n", *config)
-
-	// Initialize vector store (in-memory for demo)
-	store := vectorstore.NewMemoryVectorStore(384)
-	vectorService := service.NewVectorService(store)
+	fmt.Printf("📄 Config file: %s\n", *configFile)
 
-	fmt.Printf("✅ Vector store initialized: memory (384 dimensions)\n")
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
 
-	// Setup Gin server
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.New()
-	r.Use(gin.Recovery())
-
-	// Health endpoint
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "healthy",
-			"service": "liberation-ai",
-			"version": "1.0.0",
-			"uptime":  time.Since(time.Now()).String(),
+	// Dimensions are fixed by the hash embedder the demo server embeds with
+	store, storeName, pools, err := openVectorStore(cfg.VectorStore, 384)
+	if err != nil {
+		fmt.Printf("❌ Vector store: %v\n", err)
+		os.Exit(1)
+	}
+	for _, pool := range pools {
+		pool.Start(context.Background(), func(format string, args ...interface{}) {
+			fmt.Printf("⚠️  "+format+"\n", args...)
 		})
-	})
+	}
+	if len(pools) > 0 {
+		fmt.Printf("✅ Database pool: %d connections, %d kept idle, %d prepared statements\n",
+			cfg.VectorStore.Pool.MaxOpenConns, cfg.VectorStore.Pool.MaxIdleConns, cfg.VectorStore.Pool.StatementCacheSize)
+	}
+	failover, _ := store.(*vectorstore.FailoverStore)
+	if failover != nil {
+		failover.Start(context.Background())
+		fmt.Printf("✅ Vector store fallback: %s, after %d failed health checks\n", cfg.VectorStore.Fallback.Type, cfg.VectorStore.Fallback.FailureThreshold)
+	}
+	vectorService := liberation.New(store, liberation.NewHashEmbedder(384))
 
-	// Ready endpoint
-	r.GET("/ready", func(c *gin.Context) {
-		err := vectorService.Health(c.Request.Context())
-		status := "ready"
+	fmt.Printf("✅ Vector store initialized: %s (384 dimensions)\n", storeName)
+
+	// Listed namespaces are encrypted at rest with data keys wrapped by the master key, which
+	// also seals connector credentials
+	var keyring *encryption.Keyring
+	if cfg.Encryption.Enabled() || cfg.Encryption.MasterKey != "" {
+		keyring, err = encryption.Load(cfg.Encryption)
 		if err != nil {
-			status = "degraded"
+			fmt.Printf("❌ Encryption: %v\n", err)
+			os.Exit(1)
 		}
+	}
+	if cfg.Encryption.Enabled() {
+		vectorService.SetEncryption(keyring)
+		fmt.Printf("✅ Encryption at rest: %d namespaces\n", len(keyring.Namespaces()))
+	}
 
-		c.JSON(http.StatusOK, gin.H{
-			"status":       status,
-			"vector_store": "memory",
-			"healthy":      err == nil,
-		})
-	})
+	// Content already embedded by the same model, in any namespace not excluded, is not
+	// embedded again
+	if err := cfg.EmbeddingCache.Validate(); err != nil {
+		fmt.Printf("❌ Embedding cache: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.EmbeddingCache.Enabled {
+		vectorService.SetEmbeddingCache(embedcache.New(cfg.EmbeddingCache))
+		fmt.Printf("✅ Embedding cache: up to %d embeddings in %d MB, %d namespaces excluded\n",
+			cfg.EmbeddingCache.MaxEntries, cfg.EmbeddingCache.MaxMegabytes, len(cfg.EmbeddingCache.ExcludeNamespaces))
+	}
 
-	// Vector operations
-	v1 := r.Group("/v1")
-	{
-		// Store text documents
-		v1.POST("/documents", func(c *gin.Context) {
-			var docs []service.Document
-			if err := c.ShouldBindJSON(&docs); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-				return
-			}
+	if err := vectorService.SetChunking(cfg.Documents.Chunking); err != nil {
+		fmt.Printf("❌ Documents: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Documents.Size > 0 {
+		fmt.Printf("✅ Chunking: %d characters, %d overlap\n", cfg.Documents.Size, cfg.Documents.Overlap)
+	}
 
-			namespace := c.Query("namespace")
-			if namespace == "" {
-				namespace = "default"
-			}
+	// Declared metadata is checked on every write; indexed fields are indexed now, which
+	// does nothing for indexes that already exist
+	for namespace, schema := range cfg.MetadataSchemas {
+		update, err := vectorService.SetMetadataSchema(context.Background(), namespace, schema)
+		if err != nil {
+			fmt.Printf("❌ Metadata schema for %s: %v\n", namespace, err)
+			os.Exit(1)
+		}
+		for _, warning := range update.Warnings {
+			fmt.Printf("⚠️  Metadata schema for %s: %s\n", namespace, warning)
+		}
+	}
+	if len(cfg.MetadataSchemas) > 0 {
+		fmt.Printf("✅ Metadata schemas: %d namespaces\n", len(cfg.MetadataSchemas))
+	}
 
-			response, err := vectorService.StoreDocuments(c.Request.Context(), namespace, docs)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
+	// Request bodies are checked against each route's schema before any embedding or store work
+	if err := cfg.Validation.Validate(); err != nil {
+		fmt.Printf("❌ Validation: %v\n", err)
+		os.Exit(1)
+	}
+	validator := validate.NewValidator(cfg.Validation, 384)
 
-			c.JSON(http.StatusOK, response)
-		})
+	limiter := ratelimit.NewLimiter(cfg.RateLimits)
+	if limiter.Enabled() {
+		fmt.Printf("✅ Rate limiting: %.0f req/s, %d embeddings/day\n", cfg.RateLimits.RequestsPerSecond, cfg.RateLimits.EmbeddingsPerDay)
+	}
 
-		// Search documents
-		v1.GET("/search", func(c *gin.Context) {
-			query := c.Query("q")
-			if query == "" {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'q' is required"})
-				return
+	// Embedding spend is metered per namespace; budgets are only enforced when enabled
+	budgets := budget.NewManager(cfg.Budgets, budget.NewNotifier(cfg.Budgets))
+	if budgets.Enabled() {
+		// This server embeds with the hash embedder, so the downgrade model is one too;
+		// deployments with real providers register one per configured model
+		if cfg.Budgets.DowngradeModel != "" {
+			if err := vectorService.RegisterEmbedder(cfg.Budgets.DowngradeModel, liberation.NewHashEmbedder(384)); err != nil {
+				fmt.Printf("❌ Budgets: %v\n", err)
+				os.Exit(1)
 			}
+		}
+		vectorService.SelectEmbedder(budgets.Model)
+		// Only this process changes budgets, so the selections are kept in process and
+		// forgotten as soon as a charge or an admin moves a namespace to another model
+		if err := cfg.ModelCache.Validate(); err != nil {
+			fmt.Printf("❌ Model cache: %v\n", err)
+			os.Exit(1)
+		}
+		vectorService.CacheSelections(cache.New[string](cfg.ModelCache, nil))
+		budgets.OnModelChange(vectorService.ForgetSelection)
+		fmt.Printf("✅ Namespace budgets: alerts at %v%%, %s on exhaustion\n", cfg.Budgets.Thresholds, cfg.Budgets.OnExhaustion)
+	}
 
-			namespace := c.Query("namespace")
-			if namespace == "" {
-				namespace = "default"
-			}
+	// Re-embedding campaigns can always be started by admins; drift is only checked when enabled
+	if err := cfg.Drift.Validate(); err != nil {
+		fmt.Printf("❌ Drift: %v\n", err)
+		os.Exit(1)
+	}
+	campaigns := drift.NewCampaigns(cfg.Drift, vectorService, budgets)
+	detector := drift.NewDetector(cfg.Drift, vectorService, budgets, campaigns)
 
-			limit := 10
-			if l := c.Query("limit"); l != "" {
-				if parsed, err := fmt.Sscanf(l, "%d", &limit); err != nil || parsed != 1 {
-					limit = 10
-				}
-			}
+	// Background jobs run on cron schedules; last runs and admin changes survive restarts
+	// when scheduler.state_file is set
+	if err := cfg.Scheduler.Validate(); err != nil {
+		fmt.Printf("❌ Scheduler: %v\n", err)
+		os.Exit(1)
+	}
+	var jobStore scheduler.Store = scheduler.NewMemoryStore()
+	if cfg.Scheduler.StateFile != "" {
+		jobStore = scheduler.NewFileStore(cfg.Scheduler.StateFile)
+	}
+	jobs := scheduler.New(cfg.Scheduler, jobStore)
 
-			response, err := vectorService.SearchText(c.Request.Context(), namespace, query, limit)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
+	if detector.Enabled() {
+		if err := jobs.Register("drift_check", detector.Schedule(), detector.Run); err != nil {
+			fmt.Printf("❌ Scheduler: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Drift detection: %d vectors per namespace every %d minutes, auto refresh %t\n", cfg.Drift.SampleSize, cfg.Drift.IntervalMinutes, cfg.Drift.AutoRefresh)
+	}
 
-			c.JSON(http.StatusOK, response)
-		})
+	recorder := analytics.NewRecorder(cfg.Analytics)
+	if recorder.Enabled() {
+		fmt.Printf("✅ Search analytics: %d day retention\n", cfg.Analytics.RetentionDays)
+	}
 
-		// Get specific vector
-		v1.GET("/vectors/:namespace/:id", func(c *gin.Context) {
-			namespace := c.Param("namespace")
-			id := c.Param("id")
+	booster := relevance.NewBooster(cfg.Relevance)
 
-			vector, err := vectorService.GetVector(c.Request.Context(), namespace, id)
-			if err != nil {
-				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-				return
-			}
+	// Preference vectors learned from each caller's feedback, blended into their queries
+	if err := cfg.Personalization.Validate(); err != nil {
+		fmt.Printf("❌ Personalization: %v\n", err)
+		os.Exit(1)
+	}
+	profiles := personalize.NewProfiles(cfg.Personalization)
+	if cfg.Personalization.Enabled {
+		fmt.Printf("✅ Personalization: weight %.2f after %d signals, %g day half-life\n", cfg.Personalization.Weight, cfg.Personalization.MinSignals, cfg.Personalization.HalfLifeDays)
+	}
 
-			c.JSON(http.StatusOK, vector)
-		})
+	rewriter := rewrite.NewRewriter(cfg.Rewrite)
+	if rewriter.Enabled() {
+		fmt.Printf("✅ Query rewriting: spell check %t, %d namespaces with synonyms\n", cfg.Rewrite.SpellCheck, len(cfg.Rewrite.Synonyms))
+	}
 
-		// List namespaces
-		v1.GET("/namespaces", func(c *gin.Context) {
-			namespaces, err := vectorService.ListNamespaces(c.Request.Context())
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
+	// The server starts without a usable chat provider; /v1/extract then answers 503
+	var chat liberation.ChatProvider
+	if cfg.Extraction.Enabled {
+		if chat, err = liberation.NewChatProvider(cfg.AIProviders.Chat); err != nil {
+			fmt.Printf("⚠️  Structured extraction disabled: %v\n", err)
+		}
+	}
+	extractor := extract.NewExtractor(cfg.Extraction, chat)
+	if extractor.Enabled() {
+		fmt.Printf("✅ Structured extraction: %s %s, up to %d attempts\n", chat.Name(), cfg.AIProviders.Chat.Model, cfg.Extraction.MaxAttempts)
+	}
 
-			c.JSON(http.StatusOK, gin.H{
-				"namespaces": namespaces,
-				"count":      len(namespaces),
-			})
-		})
+	// Namespaces pinned to a region only reach backends that declare that region
+	if err := cfg.Residency.Validate(cfg.ResidencyBackends()); err != nil {
+		fmt.Printf("❌ Residency: %v\n", err)
+		os.Exit(1)
+	}
+	residencyPolicy, err := residency.New(cfg.Residency, cfg.ResidencyBackends())
+	if err != nil {
+		fmt.Printf("❌ Residency: %v\n", err)
+		os.Exit(1)
+	}
+	if residencyPolicy.Enabled() {
+		stores := []string{residency.BackendVectorStore}
+		if cfg.VectorStore.Fallback.Enabled {
+			stores = append(stores, residency.BackendFallback)
+		}
+		vectorService.SetResidency(residencyPolicy, stores)
+		fmt.Printf("✅ Residency: vector store in %s, %d namespaces pinned in the config\n", cfg.Residency.Backends[residency.BackendVectorStore], len(cfg.Residency.Namespaces))
 	}
 
-	// Stats endpoint
-	r.GET("/stats", func(c *gin.Context) {
-		stats, err := vectorService.GetStats(c.Request.Context())
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	// Migrations copy the serving store into migration.destination and resume from their
+	// checkpoint after a failure or restart
+	if err := cfg.Migration.Validate(); err != nil {
+		fmt.Printf("❌ Migration: %v\n", err)
+		os.Exit(1)
+	}
+	var destination types.VectorStore
+	destinationName := ""
+	if cfg.Migration.Destination.Type != "" {
+		if cfg.Migration.Destination.ConnectionURL == "" && (cfg.Migration.Destination.Type == "postgres" || cfg.Migration.Destination.Type == "pgvector") {
+			fmt.Println("❌ Migration: a postgres destination needs its own connection_url")
+			os.Exit(1)
+		}
+		if destination, destinationName, _, err = openVectorStore(cfg.Migration.Destination, 384); err != nil {
+			fmt.Printf("❌ Migration destination: %v\n", err)
+			os.Exit(1)
 		}
+	}
+	migrator, err := migration.New(cfg.Migration.Config, store, destination)
+	if err != nil {
+		fmt.Printf("❌ Migration: %v\n", err)
+		os.Exit(1)
+	}
+	migrator.SetResidency(residencyPolicy)
+	if migrator.Enabled() {
+		fmt.Printf("✅ Migrations: into %s at up to %d vectors/s\n", destinationName, cfg.Migration.VectorsPerSecond)
+	}
 
-		c.JSON(http.StatusOK, stats)
-	})
+	evaluator := shadow.NewEvaluator(cfg.Shadow)
+	for _, subsystem := range []string{shadow.SubsystemRelevance, shadow.SubsystemDiversify} {
+		if evaluator.Enabled(subsystem) {
+			fmt.Printf("✅ Shadow mode: %s rankings are computed and compared but not served\n", subsystem)
+		}
+	}
 
-	// Cost endpoint
-	r.GET("/cost", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"current_month": gin.H{
-				"vector_store": 0,
-				"ai_models":    0,
-				"total":        0,
-			},
-			"projected_month": gin.H{
-				"vector_store": 0,
-				"ai_models":    5,
-				"total":        5,
-			},
-			"savings_vs_enterprise": gin.H{
-				"traditional_cost": 2500,
-				"liberation_cost":  5,
-				"savings":          2495,
-				"savings_percent":  99.8,
-			},
-		})
-	})
+	// Self-check before serving; release deployments refuse to start on failures
+	release := os.Getenv("GIN_MODE") == "release"
+	checkCtx, cancelChecks := context.WithTimeout(context.Background(), 15*time.Second)
+	report := &doctor.Report{}
+	doctor.CheckConfig(checkCtx, cfg, doctor.Options{Release: release, SkipProbes: true}, report)
+	cancelChecks()
+	for _, check := range report.Checks {
+		if check.Status == doctor.StatusWarn || check.Status == doctor.StatusFail {
+			fmt.Printf("⚠️  Startup check %s: %s\n", check.Name, check.Detail)
+		}
+	}
+	if report.Failed() && release {
+		fmt.Println("❌ Refusing to start; run `liberation-ai doctor` for suggested fixes")
+		os.Exit(1)
+	}
+
+	archiver, err := archive.New(cfg.Storage)
+	if err != nil {
+		fmt.Printf("❌ Ingestion archive: %v\n", err)
+		os.Exit(1)
+	}
+	if archiver.Enabled() {
+		fmt.Printf("✅ Ingestion archive: %s, %d day retention\n", archiver.Backend(), cfg.Storage.RetentionDays)
+	}
+	if archiver.Expires() {
+		if err := jobs.Register("archive_cleanup", "@hourly", archiver.Cleanup); err != nil {
+			fmt.Printf("❌ Scheduler: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	// Prometheus metrics endpoint
-	r.GET("/metrics", func(c *gin.Context) {
-		stats, _ := vectorService.GetStats(c.Request.Context())
-		var m runtime.MemStats
-		runtime.ReadMemStats(&m)
-
-		// Generate Prometheus format metrics
-		metrics := fmt.Sprintf(`# HELP liberation_ai_info Information about Liberation AI
-# TYPE liberation_ai_info gauge
-liberation_ai_info{version="1.0.0",service="liberation-ai"} 1
-
-# HELP liberation_ai_uptime_seconds Uptime in seconds
-# TYPE liberation_ai_uptime_seconds counter
-liberation_ai_uptime_seconds %d
-
-# HELP liberation_ai_namespaces_total Total number of namespaces
-# TYPE liberation_ai_namespaces_total gauge
-liberation_ai_namespaces_total %d
-
-# HELP liberation_ai_vectors_total Total number of vectors
-# TYPE liberation_ai_vectors_total gauge
-liberation_ai_vectors_total %d
-
-# HELP liberation_ai_memory_bytes Memory usage in bytes
-# TYPE liberation_ai_memory_bytes gauge
-liberation_ai_memory_bytes %d
-
-# HELP liberation_ai_storage_size_bytes Storage size in bytes
-# TYPE liberation_ai_storage_size_bytes gauge
-liberation_ai_storage_size_bytes %d
-
-# HELP liberation_ai_avg_search_time_ms Average search time in milliseconds
-# TYPE liberation_ai_avg_search_time_ms gauge
-liberation_ai_avg_search_time_ms %d
-`,
-			0, // uptime placeholder
-			stats.TotalNamespaces,
-			stats.TotalVectors,
-			m.Alloc,
-			stats.StorageSize,
-			stats.Performance.AvgSearchTime,
-		)
-
-		c.Header("Content-Type", "text/plain; charset=utf-8")
-		c.String(http.StatusOK, metrics)
+	// Sources registered with ingestion connectors are synced on a schedule
+	if err := cfg.Connectors.Validate(); err != nil {
+		fmt.Printf("❌ Connectors: %v\n", err)
+		os.Exit(1)
+	}
+	var secrets connectors.Secrets
+	if keyring != nil {
+		secrets = keyring
+	}
+	sources, err := connectors.New(cfg.Connectors, vectorService, budgets, secrets)
+	if err != nil {
+		fmt.Printf("❌ Connectors: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Connectors.Enabled() {
+		if err := jobs.Register("connector_sync", cfg.Connectors.Schedule, sources.SyncAll); err != nil {
+			fmt.Printf("❌ Scheduler: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Connectors: %d sidecars, %d sources, synced %s\n", len(cfg.Connectors.Sidecars), len(sources.Sources()), cfg.Connectors.Schedule)
+	}
+
+	if err := jobs.Start(context.Background()); err != nil {
+		fmt.Printf("❌ Scheduler: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Other liberation services call the admin API with short-lived signed tokens
+	var services *serviceauth.Identity
+	if cfg.Services.Enabled() {
+		if services, err = serviceauth.New(cfg.Services); err != nil {
+			fmt.Printf("❌ Service identity: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Service identity: %s, trusting %v\n", services.Name(), services.Trusted())
+	}
+
+	// Hosted tenants' writes are checked against their plans in liberation-auth
+	if err := cfg.Tenancy.Validate(); err != nil {
+		fmt.Printf("❌ Tenancy: %v\n", err)
+		os.Exit(1)
+	}
+	tenants := tenancy.NewMeter(cfg.Tenancy, services, vectorService)
+	if tenants.Enabled() {
+		fmt.Printf("✅ Tenancy: namespaces metered per tenant by %s, fail open %t\n", cfg.Tenancy.AuthURL, cfg.Tenancy.FailOpen)
+	}
+
+	// Organizations created in liberation-auth get a namespace and an API key of their own
+	if err := cfg.Provisioning.Validate(); err != nil {
+		fmt.Printf("❌ Provisioning: %v\n", err)
+		os.Exit(1)
+	}
+	provisioner, err := provisioning.New(cfg.Provisioning, cfg.Tenancy.Separator)
+	if err != nil {
+		fmt.Printf("❌ Provisioning: %v\n", err)
+		os.Exit(1)
+	}
+	if provisioner.Enabled() {
+		fmt.Printf("✅ Provisioning: %d tenants, each with namespace <tenant>%s%s\n", len(provisioner.Tenants()), cfg.Tenancy.Separator, cfg.Provisioning.Namespace)
+	}
+	// Provisioned keys are limited on their own; keys nobody issued share their IP's limits
+	limiter.RecogniseKeys(func(apiKey string) bool {
+		_, ok := provisioner.TenantForKey(apiKey)
+		return ok && provisioner.Enabled()
 	})
 
+	// Accounts deleted in liberation-auth take their vectors and feedback with them
+	if err := cfg.Erasure.Validate(); err != nil {
+		fmt.Printf("❌ Erasure: %v\n", err)
+		os.Exit(1)
+	}
+	eraser := erasure.New(cfg.Erasure, vectorService, recorder)
+
+	ingestTokens := ingesttoken.NewManager(cfg.IngestTokens)
+	if ingestTokens.Enabled() {
+		fmt.Printf("✅ Ingestion tokens: up to %d documents for %ds, required %t\n", cfg.IngestTokens.MaxDocuments, cfg.IngestTokens.MaxTTLSeconds, cfg.IngestTokens.Required)
+	}
+
+	// Streamed uploads share one embedding queue and can be resumed
+	if err := cfg.Uploads.Validate(); err != nil {
+		fmt.Printf("❌ Uploads: %v\n", err)
+		os.Exit(1)
+	}
+	uploads := upload.NewManager(cfg.Uploads)
+
+	if err := cfg.Dashboard.Validate(); err != nil {
+		fmt.Printf("❌ Dashboard: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Dashboard.Enabled {
+		fmt.Println("✅ Dashboard: /dashboard/, read with an admin API key")
+	}
+
+	// pprof on a private listener, and continuous profiling export
+	if err := startProfiling(cfg.Profiling); err != nil {
+		fmt.Printf("❌ Profiling: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Handlers live in the feature files, as methods of the server
+	srv := &server{
+		cfg:             cfg,
+		vectorService:   vectorService,
+		storeName:       storeName,
+		failover:        failover,
+		pools:           pools,
+		validator:       validator,
+		limiter:         limiter,
+		budgets:         budgets,
+		campaigns:       campaigns,
+		detector:        detector,
+		jobs:            jobs,
+		recorder:        recorder,
+		booster:         booster,
+		profiles:        profiles,
+		rewriter:        rewriter,
+		extractor:       extractor,
+		residencyPolicy: residencyPolicy,
+		migrator:        migrator,
+		evaluator:       evaluator,
+		archiver:        archiver,
+		sources:         sources,
+		tenants:         tenants,
+		provisioner:     provisioner,
+		eraser:          eraser,
+		ingestTokens:    ingestTokens,
+		uploads:         uploads,
+	}
+	gin.SetMode(gin.ReleaseMode)
+	r, err := srv.setupRouter(services)
+	if err != nil {
+		fmt.Printf("❌ Routes: %v\n", err)
+		os.Exit(1)
+	}
+
 	fmt.Printf("💡 Health check: http://localhost:%d/health\n", *port)
 	fmt.Printf("📊 Cost tracking: http://localhost:%d/cost\n", *port)
 	fmt.Printf("📈 Statistics: http://localhost:%d/stats\n", *port)
 	fmt.Printf("🔍 Vector operations: http://localhost:%d/v1/\n", *port)
 	fmt.Printf("📄 Store documents: POST http://localhost:%d/v1/documents\n", *port)
 	fmt.Printf("🔍 Search documents: GET http://localhost:%d/v1/search?q=query\n", *port)
+	fmt.Printf("🎫 Quota usage: GET http://localhost:%d/v1/usage\n", *port)
+	fmt.Printf("👍 Search feedback: POST http://localhost:%d/v1/feedback\n", *port)
 	fmt.Println()
 
 	addr := fmt.Sprintf(":%d", *port)
@@ -280,6 +557,79 @@ liberation_ai_avg_search_time_ms %d
 	}
 }
 
+// startProfiling serves pprof on its own listener, which must not be publicly reachable,
+// and starts pushing profiles when a push URL is configured
+func startProfiling(cfg config.ProfilingConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Pprof && cfg.ListenAddr == "" {
+		return fmt.Errorf("pprof needs profiling.listen_addr; it is never served on the API port")
+	}
+	cfg.Apply()
+
+	if cfg.Pprof {
+		listener, err := net.Listen("tcp", cfg.ListenAddr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := http.Serve(listener, profiling.Handler()); err != nil {
+				fmt.Printf("❌ pprof listener stopped: %v\n", err)
+			}
+		}()
+		fmt.Printf("✅ pprof: http://%s%s\n", listener.Addr(), profiling.PathPrefix)
+	}
+	if pusher := profiling.NewPusher(cfg.Config); pusher != nil {
+		pusher.Start(context.Background())
+		fmt.Printf("✅ Continuous profiling: pushing to %s as %s\n", cfg.Push.URL, cfg.Push.AppName)
+	}
+	return nil
+}
+
+// openVectorStore opens the configured store, wrapped with its fallback when one is enabled.
+// Postgres is used when configured; other store types run in memory. The monitors of the
+// Postgres connection pools are returned alongside.
+func openVectorStore(cfg config.VectorStoreConfig, dimensions int) (types.VectorStore, string, []*dbpool.Monitor, error) {
+	if cfg.Type != "postgres" && cfg.Type != "pgvector" {
+		if cfg.Fallback.Enabled {
+			fmt.Println("⚠️  Vector store fallback ignored: the in-memory store has nothing to fail over from")
+		}
+		return liberation.NewMemoryStore(dimensions), "memory", nil, nil
+	}
+	if err := cfg.Pool.Validate(); err != nil {
+		return nil, "", nil, fmt.Errorf("pool: %w", err)
+	}
+
+	dsn := cfg.ConnectionURL
+	if dsn == "" {
+		dsn = os.Getenv("DATABASE_URL")
+	}
+	logger := logrus.New()
+	primary, err := vectorstore.NewPostgresVectorStore(dsn, dimensions, logger)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	pools := []*dbpool.Monitor{primary.SetPool("primary", cfg.Pool)}
+	if !cfg.Fallback.Enabled {
+		return primary, "postgres", pools, nil
+	}
+	if err := cfg.Fallback.Validate(); err != nil {
+		return nil, "", nil, fmt.Errorf("fallback: %w", err)
+	}
+
+	var fallback types.VectorStore = vectorstore.NewMemoryVectorStore(dimensions)
+	if cfg.Fallback.Type == vectorstore.FallbackPostgres {
+		replica, err := vectorstore.NewPostgresReplica(cfg.Fallback.ConnectionURL, dimensions, logger)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("fallback: %w", err)
+		}
+		pools = append(pools, replica.SetPool("fallback", cfg.Pool))
+		fallback = replica
+	}
+	return vectorstore.NewFailoverStore(primary, fallback, cfg.Fallback), "postgres", pools, nil
+}
+
 func showHelp() {
 	fmt.Println("🤖 Liberation AI - Enterprise AI orchestration for $25/month instead of $2500/month")
 	fmt.Println()
@@ -287,14 +637,33 @@ func showHelp() {
 	fmt.Println("  liberation-ai init                    Run setup wizard")
 	fmt.Println("  liberation-ai serve                   Start the AI server")
 	fmt.Println("  liberation-ai serve --port=9000       Start on custom port")
+	fmt.Println("  liberation-ai ingest <path>...        Upload files or directories as documents")
+	fmt.Println("  liberation-ai search <query>          Search a namespace")
+	fmt.Println("  liberation-ai query                   Interactive context retrieval for RAG")
+	fmt.Println("  liberation-ai doctor                  Check configuration and dependencies")
+	fmt.Println("  liberation-ai migrate [namespace...]  Copy the vector store into migration.destination")
 	fmt.Println("  liberation-ai --help                  Show this help")
 	fmt.Println()
+	fmt.Println("Client flags (ingest, search, query; migrate takes all but --namespace):")
+	fmt.Println("  --server=URL        Server URL (default $LIBERATION_AI_URL or http://localhost:8080)")
+	fmt.Println("  --api-key=KEY       API key (default $LIBERATION_AI_API_KEY)")
+	fmt.Println("  --namespace=NAME    Namespace (default \"default\")")
+	fmt.Println("  --json              Machine-readable output for scripting")
+	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  # Quick setup (recommended)")
 	fmt.Println("  liberation-ai init")
 	fmt.Println()
-	fmt.Println("  # Start server")
+	fmt.Println("  # Check the setup, then start the server")
+	fmt.Println("  liberation-ai doctor --config=liberation-ai.yml")
 	fmt.Println("  liberation-ai serve")
 	fmt.Println()
+	fmt.Println("  # Load a knowledge base and search it")
+	fmt.Println("  liberation-ai ingest ./docs --namespace kb")
+	fmt.Println("  liberation-ai search \"how do refunds work\" --namespace kb")
+	fmt.Println()
+	fmt.Println("  # Move to a new vector store; run it again to resume after a failure")
+	fmt.Println("  liberation-ai migrate --vectors-per-second 200")
+	fmt.Println()
 	fmt.Println("Documentation: https://github.com/thegreenfieldoverride/liberation-ai")
 }
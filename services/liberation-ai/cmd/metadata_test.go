@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/validate"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// queueingStore answers metadata updates as a degraded failover store does: queued, or
+// refused once its queue is full
+type queueingStore struct {
+	types.VectorStore
+	err error
+}
+
+func (s queueingStore) UpdateMetadata(ctx context.Context, update *types.MetadataUpdate) (*types.MetadataUpdateResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &types.MetadataUpdateResponse{Store: "queued"}, nil
+}
+
+// metadataServer serves the vector routes from store, holding chunk 0 of doc "guide" as
+// "v1" and chunk 1 as "v2"
+func metadataServer(t *testing.T, store types.VectorStore) (*gin.Engine, *liberation.Service) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	service := liberation.New(store, liberation.NewHashEmbedder(3))
+	_, err := service.StoreVectors(context.Background(), &types.StoreRequest{Namespace: "docs", Vectors: []types.Vector{
+		{ID: "v1", Embedding: []float32{1, 0, 0}, Metadata: map[string]interface{}{
+			types.MetadataDocID: "guide", types.MetadataChunkIndex: 0.0, "title": "Old", "draft": true,
+		}},
+		{ID: "v2", Embedding: []float32{0, 1, 0}, Metadata: map[string]interface{}{
+			types.MetadataDocID: "guide", types.MetadataChunkIndex: 1.0, "title": "Old",
+		}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{vectorService: service, validator: validate.NewValidator(validate.DefaultConfig(), 3)}
+	engine := gin.New()
+	s.vectorRoutes(auth.NewRouter(routePolicies, nil).Group(&engine.RouterGroup).Group("/v1"))
+	return engine, service
+}
+
+func patch(engine *gin.Engine, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func storedMetadata(t *testing.T, service *liberation.Service, id string) map[string]interface{} {
+	t.Helper()
+	vector, err := service.GetVector(context.Background(), "docs", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vector.Metadata
+}
+
+func TestPatchMetadata(t *testing.T) {
+	unchanged := map[string]interface{}{types.MetadataDocID: "guide", types.MetadataChunkIndex: 0.0, "title": "Old", "draft": true}
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+		want   map[string]interface{}
+	}{
+		{
+			name: "merge keeps keys left out", body: `{"metadata": {"title": "New", "lang": "en"}}`, status: http.StatusOK,
+			want: map[string]interface{}{types.MetadataDocID: "guide", types.MetadataChunkIndex: 0.0, "title": "New", "draft": true, "lang": "en"},
+		},
+		{
+			name: "null removes a key when merging", body: `{"metadata": {"draft": null}}`, status: http.StatusOK,
+			want: map[string]interface{}{types.MetadataDocID: "guide", types.MetadataChunkIndex: 0.0, "title": "Old"},
+		},
+		{
+			name: "null of a missing key is no change", body: `{"metadata": {"lang": null}}`, status: http.StatusOK,
+			want: unchanged,
+		},
+		{
+			name: "replace keeps only chunk bookkeeping", body: `{"metadata": {"lang": "en"}, "replace": true}`, status: http.StatusOK,
+			want: map[string]interface{}{types.MetadataDocID: "guide", types.MetadataChunkIndex: 0.0, "lang": "en"},
+		},
+		{
+			name: "replace stores null as a value", body: `{"metadata": {"draft": null}, "replace": true}`, status: http.StatusOK,
+			want: map[string]interface{}{types.MetadataDocID: "guide", types.MetadataChunkIndex: 0.0, "draft": nil},
+		},
+		{
+			name: "empty replace clears the rest", body: `{"metadata": {}, "replace": true}`, status: http.StatusOK,
+			want: map[string]interface{}{types.MetadataDocID: "guide", types.MetadataChunkIndex: 0.0},
+		},
+		{name: "doc_id cannot change", body: `{"metadata": {"doc_id": "other"}}`, status: http.StatusBadRequest, want: unchanged},
+		{name: "chunk_index cannot be removed", body: `{"metadata": {"chunk_index": null}}`, status: http.StatusBadRequest, want: unchanged},
+		{name: "chunk_index cannot be replaced", body: `{"metadata": {"chunk_index": 3}, "replace": true}`, status: http.StatusBadRequest, want: unchanged},
+		{name: "metadata is required", body: `{"replace": true}`, status: http.StatusUnprocessableEntity, want: unchanged},
+		{name: "malformed body", body: `{"metadata": [}`, status: http.StatusBadRequest, want: unchanged},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			engine, service := metadataServer(t, liberation.NewMemoryStore(3))
+			w := patch(engine, "/v1/vectors/docs/v1", tc.body)
+			if w.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if got := storedMetadata(t, service, "v1"); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("metadata %v, want %v", got, tc.want)
+			}
+			// The other chunk of the document is never touched
+			if got := storedMetadata(t, service, "v2")["title"]; got != "Old" {
+				t.Errorf("v2 has title %v", got)
+			}
+		})
+	}
+}
+
+// The path names one vector, so ids and filters in the body cannot select others
+func TestPatchMetadataSelectsThePathOnly(t *testing.T) {
+	engine, service := metadataServer(t, liberation.NewMemoryStore(3))
+	w := patch(engine, "/v1/vectors/docs/v1", `{"ids": ["v2"], "filters": {"title": "Old"}, "metadata": {"title": "New"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var response types.MetadataUpdateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Updated != 1 {
+		t.Errorf("response %+v, %v", response, err)
+	}
+	if got := storedMetadata(t, service, "v2")["title"]; got != "Old" {
+		t.Errorf("v2 has title %v", got)
+	}
+
+	for _, path := range []string{"/v1/vectors/docs/missing", "/v1/vectors/other/v1"} {
+		if w := patch(engine, path, `{"metadata": {"title": "New"}}`); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, w.Code)
+		}
+	}
+}
+
+func TestBulkPatchMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		body    string
+		status  int
+		updated int64
+		titles  [2]string
+	}{
+		{"by ids", `{"ids": ["v2", "missing"], "metadata": {"title": "New"}}`, http.StatusOK, 1, [2]string{"Old", "New"}},
+		{"by filters", `{"filters": {"doc_id": "guide"}, "metadata": {"title": "New"}}`, http.StatusOK, 2, [2]string{"New", "New"}},
+		{"filters matching nothing", `{"filters": {"doc_id": "other"}, "metadata": {"title": "New"}}`, http.StatusOK, 0, [2]string{"Old", "Old"}},
+		{"ids and filters", `{"ids": ["v1"], "filters": {"doc_id": "guide"}, "metadata": {"title": "New"}}`, http.StatusBadRequest, 0, [2]string{"Old", "Old"}},
+		{"neither ids nor filters", `{"metadata": {"title": "New"}}`, http.StatusBadRequest, 0, [2]string{"Old", "Old"}},
+		{"an empty id", `{"ids": ["v1", ""], "metadata": {"title": "New"}}`, http.StatusUnprocessableEntity, 0, [2]string{"Old", "Old"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			engine, service := metadataServer(t, liberation.NewMemoryStore(3))
+			w := patch(engine, "/v1/vectors/docs", tc.body)
+			if w.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if tc.status == http.StatusOK {
+				var response types.MetadataUpdateResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Updated != tc.updated {
+					t.Errorf("response %+v, %v; want %d updated", response, err, tc.updated)
+				}
+			}
+			for i, id := range []string{"v1", "v2"} {
+				if got := storedMetadata(t, service, id)["title"]; got != tc.titles[i] {
+					t.Errorf("%s has title %v, want %s", id, got, tc.titles[i])
+				}
+			}
+		})
+	}
+}
+
+// A degraded store queues updates it cannot check yet, and refuses them once its queue is full
+func TestPatchMetadataWhileDegraded(t *testing.T) {
+	engine, _ := metadataServer(t, queueingStore{VectorStore: liberation.NewMemoryStore(3)})
+	if w := patch(engine, "/v1/vectors/docs/missing", `{"metadata": {"title": "New"}}`); w.Code != http.StatusOK {
+		t.Errorf("queued update: status %d, want 200 as the vector cannot be looked up yet", w.Code)
+	}
+
+	engine, _ = metadataServer(t, queueingStore{VectorStore: liberation.NewMemoryStore(3), err: types.ErrWriteQueueFull})
+	for _, path := range []string{"/v1/vectors/docs/v1", "/v1/vectors/docs"} {
+		w := patch(engine, path, `{"ids": ["v1"], "metadata": {"title": "New"}}`)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s with a full queue: status %d, want 503", path, w.Code)
+		}
+	}
+}
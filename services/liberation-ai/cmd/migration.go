@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/migration"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/types"
+)
+
+// migrationRoutes registers the vector store migration
+func (s *server) migrationRoutes(admin *auth.RouteGroup) {
+	admin.POST("/migration", s.startMigration)
+	admin.GET("/migration", s.migrationStatus)
+	admin.DELETE("/migration", s.cancelMigration)
+}
+
+// Checkpointed, throttled copy of the vector store into migration.destination
+func (s *server) startMigration(c *gin.Context) {
+	var req migration.Request
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	run, err := s.migrator.Start(req)
+	if err != nil {
+		var status int
+		switch {
+		case errors.Is(err, migration.ErrNoDestination):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, migration.ErrMigrationActive), errors.Is(err, migration.ErrUnfinished):
+			status = http.StatusConflict
+		case errors.Is(err, types.ErrNamespaceNotFound), errors.Is(err, types.ErrResidency):
+			status = storeErrorStatus(err)
+		default:
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, run)
+}
+
+func (s *server) migrationStatus(c *gin.Context) {
+	run, err := s.migrator.Get()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+func (s *server) cancelMigration(c *gin.Context) {
+	run, err := s.migrator.Cancel()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/budget"
+	"liberation-ai/internal/provisioning"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/types"
+
+	"liberation-dryrun"
+)
+
+// namespaceRoutes registers namespace listing, clones, budgets and metadata schemas
+func (s *server) namespaceRoutes(v1, admin *auth.RouteGroup) {
+	v1.GET("/namespaces", s.listNamespaces)
+	v1.POST("/namespaces/:namespace/clone", s.cloneNamespace)
+	v1.GET("/clones/:id", s.cloneStatus)
+	v1.GET("/namespaces/:namespace/schema", s.namespaceSchema)
+	v1.GET("/namespaces/:namespace/budget", s.getBudget)
+	v1.PUT("/namespaces/:namespace/budget", s.setBudget)
+	v1.DELETE("/namespaces/:namespace/budget", s.deleteBudget)
+
+	admin.DELETE("/namespaces/:namespace", s.deleteNamespace)
+	admin.GET("/budgets", s.listBudgets)
+	admin.GET("/metadata-schemas", s.listMetadataSchemas)
+	admin.PUT("/metadata-schemas/:namespace", s.setMetadataSchema)
+	admin.DELETE("/metadata-schemas/:namespace", s.deleteMetadataSchema)
+}
+
+// List namespaces
+func (s *server) listNamespaces(c *gin.Context) {
+	namespaces, err := s.vectorService.ListNamespaces(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// A provisioned API key only sees its tenant's namespaces
+	if tenant, ok := provisioning.RequestTenant(c); ok {
+		owned := []string{}
+		for _, namespace := range namespaces {
+			if s.provisioner.Owns(tenant, namespace) {
+				owned = append(owned, namespace)
+			}
+		}
+		namespaces = owned
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"namespaces": namespaces,
+		"count":      len(namespaces),
+	})
+}
+
+// Point-in-time copy of a namespace, without re-embedding
+func (s *server) cloneNamespace(c *gin.Context) {
+	var req struct {
+		Target string `json:"target" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !s.provisioner.Allows(c, req.Target) {
+		return
+	}
+
+	source := c.Param("namespace")
+	if req.Target == source {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target namespace must differ from the source"})
+		return
+	}
+
+	size, err := s.vectorService.NamespaceSize(c.Request.Context(), source)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.limiter.AllowClone(c, size) {
+		return
+	}
+
+	// A clone copies the primary's snapshot, so it waits for the primary to recover
+	if s.failover != nil && s.failover.Status().Mode != vectorstore.ModePrimary {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": types.ErrStoreDegraded.Error()})
+		return
+	}
+
+	job, err := s.vectorService.StartClone(c.Request.Context(), source, req.Target)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	s.rewriter.CopyVocabulary(source, req.Target)
+
+	if c.Query("wait") == "true" {
+		job, err = s.vectorService.WaitForClone(c.Request.Context(), job.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, job)
+		return
+	}
+
+	c.Header("Location", "/v1/clones/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// Clone progress
+func (s *server) cloneStatus(c *gin.Context) {
+	job, exists := s.vectorService.GetCloneJob(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clone job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// The metadata fields a namespace declares, so clients know what writes must carry
+func (s *server) namespaceSchema(c *gin.Context) {
+	schema, ok := s.vectorService.MetadataSchema(c.Param("namespace"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "namespace has no metadata schema"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"namespace": c.Param("namespace"), "schema": schema})
+}
+
+// Namespace budgets, managed by the API key that created them or by an admin
+func (s *server) manageBudget(c *gin.Context) bool {
+	if s.limiter.IsAdmin(c.GetHeader(ratelimit.APIKeyHeader)) || s.budgets.CanManage(c.Param("namespace"), ratelimit.Identity(c)) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "forbidden",
+		"message": "only the budget's owner or an admin may manage it; creating one needs an API key",
+	})
+	return false
+}
+
+func (s *server) getBudget(c *gin.Context) {
+	if !s.manageBudget(c) {
+		return
+	}
+	c.JSON(http.StatusOK, s.budgets.Status(c.Param("namespace")))
+}
+
+func (s *server) setBudget(c *gin.Context) {
+	if !s.manageBudget(c) {
+		return
+	}
+	var req budget.Budget
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Ownership stays with whoever created the budget; admins create unowned budgets
+	namespace := c.Param("namespace")
+	if existing := s.budgets.Status(namespace).Budget; existing != nil {
+		req.Owner = existing.Owner
+	} else if !s.limiter.IsAdmin(c.GetHeader(ratelimit.APIKeyHeader)) {
+		req.Owner = ratelimit.Identity(c)
+	}
+
+	status, err := s.budgets.Put(namespace, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (s *server) deleteBudget(c *gin.Context) {
+	if !s.manageBudget(c) {
+		return
+	}
+	if !s.budgets.Delete(c.Param("namespace")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "namespace has no budget"})
+		return
+	}
+	c.JSON(http.StatusOK, s.budgets.Status(c.Param("namespace")))
+}
+
+// Delete every vector in a namespace
+func (s *server) deleteNamespace(c *gin.Context) {
+	namespace := c.Param("namespace")
+	size, err := s.vectorService.NamespaceSize(c.Request.Context(), namespace)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if size == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": types.ErrNamespaceNotFound.Error()})
+		return
+	}
+
+	if previewDryRun(c, func() (*dryrun.Plan, error) {
+		vectors, err := s.vectorService.ListVectors(c.Request.Context(), namespace, "", dryrun.SampleSize)
+		if err != nil {
+			return nil, err
+		}
+		sample := make([]string, len(vectors))
+		for i, vector := range vectors {
+			sample[i] = vector.ID
+		}
+		return dryrun.New("delete_namespace", namespace).
+			Add("vectors", "delete", size, sample).
+			Warn("synonyms, relevance boosts and budgets of the namespace are kept"), nil
+	}) {
+		return
+	}
+
+	// Deletes must reach the primary, or they would reappear once it recovers
+	if s.failover != nil && s.failover.Status().Mode != vectorstore.ModePrimary {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": types.ErrStoreDegraded.Error()})
+		return
+	}
+
+	deleted, err := s.vectorService.DeleteNamespace(c.Request.Context(), namespace)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error(), "deleted": deleted})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"namespace": namespace, "deleted": deleted})
+}
+
+// Spend and budget state for every namespace
+func (s *server) listBudgets(c *gin.Context) {
+	statuses := s.budgets.All()
+	c.JSON(http.StatusOK, gin.H{
+		"budgets": statuses,
+		"count":   len(statuses),
+	})
+}
+
+// Declared metadata fields per namespace
+func (s *server) listMetadataSchemas(c *gin.Context) {
+	schemas := s.vectorService.MetadataSchemas()
+	c.JSON(http.StatusOK, gin.H{"schemas": schemas, "count": len(schemas)})
+}
+
+func (s *server) setMetadataSchema(c *gin.Context) {
+	var schema types.MetadataSchema
+	if err := c.ShouldBindJSON(&schema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Indexes keep building if the caller goes away
+	update, err := s.vectorService.SetMetadataSchema(context.WithoutCancel(c.Request.Context()), c.Param("namespace"), schema)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, update)
+}
+
+func (s *server) deleteMetadataSchema(c *gin.Context) {
+	if previewDryRun(c, func() (*dryrun.Plan, error) {
+		schema, _ := s.vectorService.MetadataSchema(c.Param("namespace"))
+		sample := make([]string, 0, len(schema.Fields))
+		for name := range schema.Fields {
+			sample = append(sample, name)
+		}
+		sort.Strings(sample)
+		return dryrun.New("delete_metadata_schema", c.Param("namespace")).
+			Add("metadata_fields", "undeclare", int64(len(sample)), sample).
+			Warn("stored metadata and indexes are kept; filters compare values as text again"), nil
+	}) {
+		return
+	}
+	if !s.vectorService.DeleteMetadataSchema(c.Param("namespace")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "namespace has no metadata schema"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"namespace": c.Param("namespace"), "deleted": true})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/personalize"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/types"
+)
+
+// personalizationRoutes registers the caller's own preference profiles
+func (s *server) personalizationRoutes(v1 *auth.RouteGroup) {
+	v1.GET("/personalization", s.listProfiles)
+	v1.POST("/personalization/:namespace/compute", s.computeProfile)
+	v1.DELETE("/personalization/:namespace", s.deleteProfile)
+	v1.DELETE("/personalization", s.deleteProfiles)
+	v1.PUT("/personalization/opt-out", s.optOut)
+}
+
+// The caller's own preference vectors; callers are told apart as for analytics
+func (s *server) listProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, s.profiles.Status(ratelimit.Identity(c)))
+}
+
+// Rebuild a profile from the caller's retained feedback history
+func (s *server) computeProfile(c *gin.Context) {
+	identity, namespace := ratelimit.Identity(c), c.Param("namespace")
+	if !s.profiles.Collects(identity, namespace) {
+		c.JSON(http.StatusConflict, gin.H{"error": "personalization is off for this namespace or you have opted out"})
+		return
+	}
+
+	var history []personalize.Signal
+	for _, event := range s.recorder.FeedbackFor(identity, namespace) {
+		vector, err := s.vectorService.GetVector(c.Request.Context(), namespace, event.DocumentID)
+		if errors.Is(err, types.ErrNotFound) {
+			// Deleted documents no longer shape the profile
+			continue
+		}
+		if err != nil {
+			c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		history = append(history, personalize.Signal{Action: event.Action, Timestamp: event.Timestamp, Embedding: vector.Embedding})
+	}
+	c.JSON(http.StatusOK, s.profiles.Compute(identity, namespace, history))
+}
+
+func (s *server) deleteProfile(c *gin.Context) {
+	deleted := s.profiles.Reset(ratelimit.Identity(c), c.Param("namespace"))
+	c.JSON(http.StatusOK, gin.H{"namespace": c.Param("namespace"), "deleted": deleted})
+}
+
+func (s *server) deleteProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"deleted": s.profiles.Reset(ratelimit.Identity(c), "")})
+}
+
+// Opting out deletes the caller's profiles and stops learning new ones
+func (s *server) optOut(c *gin.Context) {
+	var req struct {
+		OptedOut *bool `json:"opted_out"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.OptedOut == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "opted_out is required"})
+		return
+	}
+	s.profiles.SetOptOut(ratelimit.Identity(c), *req.OptedOut)
+	c.JSON(http.StatusOK, s.profiles.Status(ratelimit.Identity(c)))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/relevance"
+	"liberation-ai/internal/shadow"
+	"liberation-ai/pkg/auth"
+
+	"liberation-dryrun"
+)
+
+// relevanceRoutes registers synonyms, learned boosts and shadowed rankings
+func (s *server) relevanceRoutes(admin *auth.RouteGroup) {
+	admin.GET("/synonyms/:namespace", s.getSynonyms)
+	admin.PUT("/synonyms/:namespace", s.setSynonyms)
+	admin.DELETE("/synonyms/:namespace", s.deleteSynonyms)
+	admin.GET("/relevance/:namespace", s.getBoosts)
+	admin.PUT("/relevance/:namespace", s.setBoosting)
+	admin.DELETE("/relevance/:namespace", s.resetBoosting)
+	admin.GET("/shadow", s.shadowReport)
+	admin.PUT("/shadow/:subsystem", s.setShadow)
+	admin.DELETE("/shadow", s.resetShadow)
+}
+
+// Synonym groups used to expand queries per namespace
+func (s *server) getSynonyms(c *gin.Context) {
+	groups, vocabulary := s.rewriter.Synonyms(c.Param("namespace"))
+	c.JSON(http.StatusOK, gin.H{
+		"namespace":       c.Param("namespace"),
+		"synonyms":        groups,
+		"vocabulary_size": vocabulary,
+	})
+}
+
+func (s *server) setSynonyms(c *gin.Context) {
+	var req struct {
+		Synonyms [][]string `json:"synonyms" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groups, err := s.rewriter.SetSynonyms(c.Param("namespace"), req.Synonyms)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"namespace": c.Param("namespace"), "synonyms": groups})
+}
+
+func (s *server) deleteSynonyms(c *gin.Context) {
+	if previewDryRun(c, func() (*dryrun.Plan, error) {
+		groups, _ := s.rewriter.Synonyms(c.Param("namespace"))
+		sample := make([]string, len(groups))
+		for i, group := range groups {
+			sample[i] = strings.Join(group, ",")
+		}
+		return dryrun.New("delete_synonyms", c.Param("namespace")).
+			Add("synonym_groups", "delete", int64(len(groups)), sample), nil
+	}) {
+		return
+	}
+	if !s.rewriter.DeleteSynonyms(c.Param("namespace")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "namespace has no synonyms"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"namespace": c.Param("namespace"), "synonyms": [][]string{}})
+}
+
+// Learned relevance boosts per namespace
+func (s *server) getBoosts(c *gin.Context) {
+	c.JSON(http.StatusOK, s.booster.Boosts(c.Param("namespace")))
+}
+
+func (s *server) setBoosting(c *gin.Context) {
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	namespace := c.Param("namespace")
+	s.booster.SetEnabled(namespace, *req.Enabled)
+	c.JSON(http.StatusOK, s.booster.Boosts(namespace))
+}
+
+func (s *server) resetBoosting(c *gin.Context) {
+	if previewDryRun(c, func() (*dryrun.Plan, error) {
+		documentID := c.Query("document_id")
+		boosts := s.booster.Boosts(c.Param("namespace"))
+		plan := dryrun.New("reset_relevance", c.Param("namespace"))
+		for _, kind := range []struct {
+			entity string
+			boosts []relevance.Boost
+		}{{"query_boosts", boosts.Queries}, {"document_boosts", boosts.Documents}} {
+			var sample []string
+			for _, boost := range kind.boosts {
+				if documentID == "" || boost.DocumentID == documentID {
+					sample = append(sample, boost.DocumentID)
+				}
+			}
+			plan.Add(kind.entity, "delete", int64(len(sample)), sample)
+		}
+		return plan, nil
+	}) {
+		return
+	}
+	removed := s.booster.Reset(c.Param("namespace"), c.Query("document_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"namespace": c.Param("namespace"),
+		"removed":   removed,
+	})
+}
+
+// Agreement between shadowed ranking subsystems and the served results
+func (s *server) shadowReport(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"subsystems": gin.H{
+			shadow.SubsystemRelevance: s.evaluator.Enabled(shadow.SubsystemRelevance),
+			shadow.SubsystemDiversify: s.evaluator.Enabled(shadow.SubsystemDiversify),
+		},
+		"series": s.evaluator.Report(c.Query("subsystem"), c.Query("namespace")),
+	})
+}
+
+func (s *server) setShadow(c *gin.Context) {
+	subsystem := c.Param("subsystem")
+	if subsystem != shadow.SubsystemRelevance && subsystem != shadow.SubsystemDiversify {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown subsystem"})
+		return
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.evaluator.SetEnabled(subsystem, *req.Enabled)
+	c.JSON(http.StatusOK, gin.H{"subsystem": subsystem, "shadowed": *req.Enabled})
+}
+
+func (s *server) resetShadow(c *gin.Context) {
+	if previewDryRun(c, func() (*dryrun.Plan, error) {
+		series := s.evaluator.Report(c.Query("subsystem"), "")
+		sample := make([]string, len(series))
+		for i, entry := range series {
+			sample[i] = entry.Subsystem + "/" + entry.Namespace
+		}
+		return dryrun.New("reset_shadow", c.Query("subsystem")).
+			Add("shadow_series", "delete", int64(len(series)), sample), nil
+	}) {
+		return
+	}
+	s.evaluator.Reset(c.Query("subsystem"))
+	c.JSON(http.StatusOK, gin.H{"reset": true})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/residency"
+	"liberation-ai/pkg/auth"
+)
+
+// residencyRoutes registers the regions namespaces are pinned to
+func (s *server) residencyRoutes(admin *auth.RouteGroup) {
+	admin.GET("/residency", s.residency)
+	admin.PUT("/residency/namespaces/:namespace", s.pinNamespace)
+	admin.DELETE("/residency/namespaces/:namespace", s.unpinNamespace)
+}
+
+// Data residency: the region of each backend and of each namespace
+func (s *server) residency(c *gin.Context) {
+	if !s.residencyPolicy.Enabled() {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	namespaces, err := s.vectorService.ListNamespaces(c.Request.Context())
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	placements := s.residencyPolicy.Placements(namespaces)
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":        true,
+		"backends":       s.residencyPolicy.Backends(),
+		"default_region": s.cfg.Residency.DefaultRegion,
+		"namespaces":     placements,
+		"count":          len(placements),
+	})
+}
+
+// Pin a namespace to a region. Data already stored is not moved; writes are refused
+// from now on unless every backend is in the region.
+func (s *server) pinNamespace(c *gin.Context) {
+	if !s.residencyPolicy.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "residency is not enabled"})
+		return
+	}
+	var req struct {
+		Region string `json:"region" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.residencyPolicy.Pin(c.Param("namespace"), req.Region); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, residency.ErrInvalidRegion) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, s.residencyPolicy.Place(c.Param("namespace")))
+}
+
+// Remove a namespace's pin, so the config decides its region again
+func (s *server) unpinNamespace(c *gin.Context) {
+	if !s.residencyPolicy.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "residency is not enabled"})
+		return
+	}
+	removed, err := s.residencyPolicy.Unpin(c.Param("namespace"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "namespace is not pinned through the API"})
+		return
+	}
+	c.JSON(http.StatusOK, s.residencyPolicy.Place(c.Param("namespace")))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/pkg/auth"
+
+	"liberation-serviceauth"
+)
+
+// routePolicies says who may call each route. main registers routes through an
+// auth.Router built from this table, which adds the guards for each route's access;
+// a route missing here is refused at startup and fails TestEveryRouteHasAPolicy.
+var routePolicies = []auth.Policy{
+	{Method: http.MethodGet, Path: "/health", Access: auth.Public},
+	{Method: http.MethodGet, Path: "/ready", Access: auth.Public},
+	{Method: http.MethodGet, Path: "/stats", Access: auth.Public},
+	{Method: http.MethodGet, Path: "/cost", Access: auth.Public},
+	{Method: http.MethodGet, Path: "/metrics", Access: auth.Public},
+	// The dashboard page holds no data; it reads /v1/admin/dashboard with an admin key
+	{Method: http.MethodGet, Path: "/dashboard/*file", Access: auth.Public},
+
+	// Writes that frontends make with an ingestion token and services make with their own
+	{Method: http.MethodPost, Path: "/v1/documents", Access: auth.Service},
+	{Method: http.MethodPost, Path: "/v1/documents/stream", Access: auth.Service},
+	{Method: http.MethodPost, Path: "/v1/vectors", Access: auth.Service},
+
+	{Method: http.MethodGet, Path: "/v1/documents/stream", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/documents/stream/:session", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/search", Access: auth.Caller},
+	{Method: http.MethodPost, Path: "/v1/extract", Access: auth.Caller},
+	{Method: http.MethodPost, Path: "/v1/feedback", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/personalization", Access: auth.Caller},
+	{Method: http.MethodPost, Path: "/v1/personalization/:namespace/compute", Access: auth.Caller},
+	{Method: http.MethodDelete, Path: "/v1/personalization/:namespace", Access: auth.Caller},
+	{Method: http.MethodDelete, Path: "/v1/personalization", Access: auth.Caller},
+	{Method: http.MethodPut, Path: "/v1/personalization/opt-out", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/vectors/:namespace/:id", Access: auth.Caller},
+	{Method: http.MethodPatch, Path: "/v1/vectors/:namespace/:id", Access: auth.Caller},
+	{Method: http.MethodPatch, Path: "/v1/vectors/:namespace", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/vectors/:namespace/:id/similar", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/locales", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/namespaces", Access: auth.Caller},
+	{Method: http.MethodPost, Path: "/v1/namespaces/:namespace/clone", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/clones/:id", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/namespaces/:namespace/budget", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/namespaces/:namespace/schema", Access: auth.Caller},
+	{Method: http.MethodPut, Path: "/v1/namespaces/:namespace/budget", Access: auth.Caller},
+	{Method: http.MethodDelete, Path: "/v1/namespaces/:namespace/budget", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/usage", Access: auth.Caller},
+	{Method: http.MethodGet, Path: "/v1/limits", Access: auth.Caller},
+
+	{Method: http.MethodGet, Path: "/v1/admin/usage", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/dashboard", Access: auth.Admin},
+	{Method: http.MethodPost, Path: "/v1/admin/ingest-tokens", Access: auth.Admin},
+	{Method: http.MethodPut, Path: "/v1/admin/quotas/:identity", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/quotas/:identity", Access: auth.Admin},
+	{Method: http.MethodPost, Path: "/v1/admin/erasures", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/provisioning/tenants", Access: auth.Admin},
+	{Method: http.MethodPut, Path: "/v1/admin/provisioning/tenants/:tenant", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/provisioning/tenants/:tenant", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/namespaces/:namespace", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/encryption", Access: auth.Admin},
+	{Method: http.MethodPost, Path: "/v1/admin/encryption/:namespace/rotate", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/residency", Access: auth.Admin},
+	{Method: http.MethodPut, Path: "/v1/admin/residency/namespaces/:namespace", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/residency/namespaces/:namespace", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/connectors", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/connectors/sources", Access: auth.Admin},
+	{Method: http.MethodPost, Path: "/v1/admin/connectors/sources", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/connectors/sources/:name", Access: auth.Admin},
+	{Method: http.MethodPut, Path: "/v1/admin/connectors/sources/:name/credentials", Access: auth.Admin},
+	{Method: http.MethodPost, Path: "/v1/admin/connectors/sources/:name/sync", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/connectors/sources/:name", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/jobs", Access: auth.Admin},
+	{Method: http.MethodPut, Path: "/v1/admin/jobs/:name", Access: auth.Admin},
+	{Method: http.MethodPost, Path: "/v1/admin/jobs/:name/run", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/budgets", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/drift", Access: auth.Admin},
+	{Method: http.MethodPost, Path: "/v1/admin/drift/check", Access: auth.Admin},
+	{Method: http.MethodPost, Path: "/v1/admin/reembed", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/reembed", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/reembed/:id", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/reembed/:id", Access: auth.Admin},
+	{Method: http.MethodPost, Path: "/v1/admin/migration", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/migration", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/migration", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/synonyms/:namespace", Access: auth.Admin},
+	{Method: http.MethodPut, Path: "/v1/admin/synonyms/:namespace", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/synonyms/:namespace", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/metadata-schemas", Access: auth.Admin},
+	{Method: http.MethodPut, Path: "/v1/admin/metadata-schemas/:namespace", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/metadata-schemas/:namespace", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/relevance/:namespace", Access: auth.Admin},
+	{Method: http.MethodPut, Path: "/v1/admin/relevance/:namespace", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/relevance/:namespace", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/shadow", Access: auth.Admin},
+	{Method: http.MethodPut, Path: "/v1/admin/shadow/:subsystem", Access: auth.Admin},
+	{Method: http.MethodDelete, Path: "/v1/admin/shadow", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/analytics/report", Access: auth.Admin},
+	{Method: http.MethodGet, Path: "/v1/admin/analytics/export", Access: auth.Admin},
+}
+
+// setupRouter registers every route through an auth.Router built from routePolicies.
+// The error lists routes registered without a policy, which are not served, and
+// policies no route was registered for.
+func (s *server) setupRouter(services *serviceauth.Identity) (*gin.Engine, error) {
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	router := auth.NewRouter(routePolicies, map[auth.Access][]gin.HandlerFunc{
+		auth.Service: {auth.ServiceIdentityMiddleware(services)},
+		auth.Admin:   {auth.ServiceIdentityMiddleware(services), s.limiter.RequireAdmin()},
+	})
+	routes := router.Group(&r.RouterGroup)
+	s.statusRoutes(routes)
+
+	// Vector operations
+	v1 := routes.Group("/v1", s.provisioner.Middleware(), s.limiter.Middleware())
+	admin := v1.Group("/admin")
+	s.documentRoutes(v1, admin)
+	s.searchRoutes(v1)
+	s.personalizationRoutes(v1)
+	s.vectorRoutes(v1)
+	s.namespaceRoutes(v1, admin)
+	s.usageRoutes(v1, admin)
+	s.tenantRoutes(admin)
+	s.encryptionRoutes(admin)
+	s.residencyRoutes(admin)
+	s.connectorRoutes(admin)
+	s.jobRoutes(admin)
+	s.driftRoutes(admin)
+	s.migrationRoutes(admin)
+	s.relevanceRoutes(admin)
+	s.analyticsRoutes(admin)
+
+	return r, router.Err()
+}
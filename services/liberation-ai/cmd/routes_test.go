@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/config"
+	"liberation-ai/internal/provisioning"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/pkg/auth"
+)
+
+// testServer has what registering the routes needs; handlers are never run
+func testServer() *server {
+	return &server{
+		cfg:         config.Default(),
+		limiter:     ratelimit.NewLimiter(ratelimit.Config{}),
+		provisioner: &provisioning.Provisioner{},
+	}
+}
+
+func TestEveryRouteHasAPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine, err := testServer().setupRouter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies := make(map[string]bool)
+	for _, policy := range routePolicies {
+		policies[policy.Method+" "+policy.Path] = true
+	}
+	routes := make(map[string]bool)
+	for _, route := range engine.Routes() {
+		routes[route.Method+" "+route.Path] = true
+		if !policies[route.Method+" "+route.Path] {
+			t.Errorf("%s %s has no entry in routePolicies", route.Method, route.Path)
+		}
+	}
+	for policy := range policies {
+		if !routes[policy] {
+			t.Errorf("routePolicies lists %s, which is never registered", policy)
+		}
+	}
+}
+
+func TestRoutePoliciesGuardRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	guard := func(access auth.Access) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Writer.Header().Add("X-Guard", string(access))
+		}
+	}
+	router := auth.NewRouter(routePolicies, map[auth.Access][]gin.HandlerFunc{
+		auth.Service: {guard(auth.Service)},
+		auth.Admin:   {guard(auth.Admin)},
+	})
+
+	engine := gin.New()
+	routes := router.Group(&engine.RouterGroup)
+	for _, policy := range routePolicies {
+		routes.Handle(policy.Method, policy.Path, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	}
+	routes.GET("/v1/unlisted", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	if err := router.Err(); err == nil || !strings.Contains(err.Error(), "GET /v1/unlisted has no policy") {
+		t.Fatalf("expected the unlisted route to be reported, got %v", err)
+	}
+
+	for _, policy := range routePolicies {
+		// Caller routes rely on the /v1 group's rate limiter to identify the caller
+		if inV1 := strings.HasPrefix(policy.Path, "/v1/"); inV1 != (policy.Access != auth.Public) {
+			t.Errorf("%s %s is %s but %s under /v1", policy.Method, policy.Path, policy.Access, map[bool]string{true: "is", false: "is not"}[inV1])
+		}
+
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(policy.Method, strings.NewReplacer(":", "x").Replace(policy.Path), nil))
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s %s answered %d", policy.Method, policy.Path, w.Code)
+		}
+		want := ""
+		if policy.Access == auth.Service || policy.Access == auth.Admin {
+			want = string(policy.Access)
+		}
+		if got := w.Header().Get("X-Guard"); got != want {
+			t.Errorf("%s %s ran guard %q, want %q", policy.Method, policy.Path, got, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/unlisted", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("a route without a policy must not be served, got %d", w.Code)
+	}
+}
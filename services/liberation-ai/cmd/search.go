@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/analytics"
+	"liberation-ai/internal/budget"
+	"liberation-ai/internal/extract"
+	"liberation-ai/internal/personalize"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/internal/residency"
+	"liberation-ai/internal/shadow"
+	"liberation-ai/internal/validate"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// searchRoutes registers search, extraction and the feedback that tunes them
+func (s *server) searchRoutes(v1 *auth.RouteGroup) {
+	v1.GET("/search", s.search)
+	v1.POST("/extract", validate.Body(s.validator.Extract), s.extract)
+	v1.POST("/feedback", validate.Body(s.validator.Feedback), s.feedback)
+	v1.GET("/locales", s.locales)
+}
+
+// Search documents
+func (s *server) search(c *gin.Context) {
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query parameter 'q' is required"})
+		return
+	}
+
+	namespace := c.Query("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	limit := 10
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := fmt.Sscanf(l, "%d", &limit); err != nil || parsed != 1 {
+			limit = 10
+		}
+	}
+
+	if !s.limiter.ReserveEmbeddings(c, 1) {
+		return
+	}
+	// Typos are corrected and synonyms added before embedding; analytics and
+	// feedback boosts keep using the query as typed
+	rewritten := s.rewriter.Rewrite(namespace, query)
+	s.budgets.RecordRead(namespace, budget.EstimateTokens(rewritten.Expanded))
+
+	opts := liberation.SearchOptions{
+		Limit:     limit,
+		Diversify: c.Query("diversify") == "true",
+		GroupBy:   c.Query("group_by"),
+		Filters:   queryFilters(c),
+		Rerank: func(response *types.SearchResponse) {
+			s.booster.Apply(namespace, query, response)
+		},
+	}
+	if l := c.Query("lambda"); l != "" {
+		if _, err := fmt.Sscanf(l, "%g", &opts.Lambda); err != nil || opts.Lambda < 0 || opts.Lambda > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lambda must be a number between 0 and 1"})
+			return
+		}
+	}
+	if w := c.Query("window"); w != "" {
+		if _, err := fmt.Sscanf(w, "%d", &opts.Window); err != nil || opts.Window < 0 || opts.Window > s.cfg.Documents.MaxWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("window must be between 0 and %d", s.cfg.Documents.MaxWindow)})
+			return
+		}
+	}
+	// The caller's preference vector nudges the query unless personalize=false
+	if c.Query("personalize") != "false" {
+		opts.Preference, _ = s.profiles.Preference(ratelimit.Identity(c), namespace)
+		opts.PreferenceWeight = s.profiles.Weight()
+	}
+	// Fetch extra candidates so feedback boosts can promote documents from just below the cut
+	if s.booster.EnabledFor(namespace) {
+		opts.Candidates = limit * 2
+	}
+
+	// Shadowed subsystems are evaluated against the served ranking but never change it
+	variants := []liberation.SearchOptions{opts}
+	shadowed := []string{}
+	if s.booster.EnabledFor(namespace) && s.evaluator.Enabled(shadow.SubsystemRelevance) {
+		variants[0].Rerank = nil
+		if s.evaluator.Sample() {
+			variants = append(variants, opts)
+			shadowed = append(shadowed, shadow.SubsystemRelevance)
+		}
+	}
+	if !opts.Diversify && s.evaluator.Enabled(shadow.SubsystemDiversify) && s.evaluator.Sample() {
+		diversified := variants[0]
+		diversified.Diversify = true
+		variants = append(variants, diversified)
+		shadowed = append(shadowed, shadow.SubsystemDiversify)
+	}
+
+	responses, err := s.vectorService.SearchTextVariants(c.Request.Context(), namespace, rewritten.Expanded, variants)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), storeErrorBody(err))
+		return
+	}
+	response := responses[0]
+	for i, subsystem := range shadowed {
+		s.evaluator.Record(subsystem, namespace, analytics.Anonymize(query), shadow.ResultIDs(response.Results), shadow.ResultIDs(responses[i+1].Results))
+	}
+
+	response.QueryID = s.recorder.RecordSearch(namespace, ratelimit.Identity(c), query, response)
+	if c.Query("debug") == "true" {
+		response.Rewrite = &rewritten
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Schema-valid JSON extracted from a namespace's most relevant passages
+func (s *server) extract(c *gin.Context) {
+	var req types.ExtractRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !s.provisioner.Allows(c, req.Namespace) {
+		return
+	}
+
+	schema, err := s.extractor.Prepare(&req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, extract.ErrDisabled) {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Window < 0 || req.Window > s.cfg.Documents.MaxWindow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("window must be between 0 and %d", s.cfg.Documents.MaxWindow)})
+		return
+	}
+	// The retrieved passages are sent to the chat provider
+	if err := s.residencyPolicy.Check(req.Namespace, residency.BackendChat); err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if !s.limiter.ReserveEmbeddings(c, 1) {
+		return
+	}
+	rewritten := s.rewriter.Rewrite(req.Namespace, req.Query)
+	s.budgets.RecordRead(req.Namespace, budget.EstimateTokens(rewritten.Expanded))
+
+	opts := liberation.SearchOptions{
+		Limit:   req.ContextLimit,
+		Filters: req.Filters,
+		Window:  req.Window,
+	}
+	if c.Query("personalize") != "false" {
+		opts.Preference, _ = s.profiles.Preference(ratelimit.Identity(c), req.Namespace)
+		opts.PreferenceWeight = s.profiles.Weight()
+	}
+	retrieved, err := s.vectorService.SearchTextWithOptions(c.Request.Context(), req.Namespace, rewritten.Expanded, opts)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), storeErrorBody(err))
+		return
+	}
+
+	response, err := s.extractor.Extract(c.Request.Context(), schema, &req, retrieved.Results)
+	var invalid *extract.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":    err.Error(),
+			"problems": invalid.Problems,
+			"output":   invalid.Output,
+			"attempts": invalid.Attempts,
+		})
+	case err != nil:
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Record clicks, selections and ratings against a search's query_id
+func (s *server) feedback(c *gin.Context) {
+	var req analytics.FeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	identity := ratelimit.Identity(c)
+	event, err := s.recorder.RecordFeedback(identity, req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, analytics.ErrUnknownQuery) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.booster.Record(*event)
+	// The caller's preference vector moves towards the document, or away on thumbs down
+	if s.profiles.Collects(identity, event.Namespace) {
+		if vector, err := s.vectorService.GetVector(c.Request.Context(), event.Namespace, event.DocumentID); err == nil {
+			s.profiles.Record(identity, event.Namespace, personalize.Signal{Action: event.Action, Timestamp: event.Timestamp, Embedding: vector.Embedding})
+		}
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// Languages validation errors can be reported in
+func (s *server) locales(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"default": validate.DefaultLocale,
+		"matched": validate.MatchLocale(c.GetHeader("Accept-Language")),
+		"locales": validate.Locales,
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"liberation-ai/internal/analytics"
+	"liberation-ai/internal/archive"
+	"liberation-ai/internal/budget"
+	"liberation-ai/internal/config"
+	"liberation-ai/internal/connectors"
+	"liberation-ai/internal/drift"
+	"liberation-ai/internal/erasure"
+	"liberation-ai/internal/extract"
+	"liberation-ai/internal/ingesttoken"
+	"liberation-ai/internal/migration"
+	"liberation-ai/internal/personalize"
+	"liberation-ai/internal/provisioning"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/internal/relevance"
+	"liberation-ai/internal/residency"
+	"liberation-ai/internal/rewrite"
+	"liberation-ai/internal/shadow"
+	"liberation-ai/internal/tenancy"
+	"liberation-ai/internal/upload"
+	"liberation-ai/internal/validate"
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/liberation"
+
+	"liberation-dbpool"
+	"liberation-scheduler"
+)
+
+// server holds what the HTTP handlers share. runServer builds it once the services are
+// configured, and the feature files register their handlers as its methods.
+type server struct {
+	cfg           *config.Config
+	vectorService *liberation.Service
+	storeName     string
+	// failover is nil unless the vector store has a fallback
+	failover *vectorstore.FailoverStore
+	pools    []*dbpool.Monitor
+
+	validator    *validate.Validator
+	limiter      *ratelimit.Limiter
+	budgets      *budget.Manager
+	campaigns    *drift.Campaigns
+	detector     *drift.Detector
+	jobs         *scheduler.Scheduler
+	recorder     *analytics.Recorder
+	booster      *relevance.Booster
+	profiles     *personalize.Profiles
+	rewriter     *rewrite.Rewriter
+	extractor    *extract.Extractor
+	migrator     *migration.Migrator
+	evaluator    *shadow.Evaluator
+	archiver     *archive.Archiver
+	sources      *connectors.Registry
+	tenants      *tenancy.Meter
+	provisioner  *provisioning.Provisioner
+	eraser       *erasure.Eraser
+	ingestTokens *ingesttoken.Manager
+	uploads      *upload.Manager
+
+	residencyPolicy *residency.Policy
+}
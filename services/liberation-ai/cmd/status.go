@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/budget"
+	"liberation-ai/internal/dashboard"
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/auth"
+
+	"liberation-dbpool"
+)
+
+// statusRoutes registers the public health, cost and metrics endpoints
+func (s *server) statusRoutes(routes *auth.RouteGroup) {
+	routes.GET("/health", s.health)
+	routes.GET("/ready", s.ready)
+	routes.GET("/stats", s.stats)
+	routes.GET("/cost", s.cost)
+	// Read-only dashboard for deployments without Grafana; its data is /v1/admin/dashboard
+	routes.GET("/dashboard/*file", dashboard.Handler(s.cfg.Dashboard))
+	routes.GET("/metrics", s.metrics)
+}
+
+// Health endpoint
+func (s *server) health(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "healthy",
+		"service": "liberation-ai",
+		"version": "1.0.0",
+		"uptime":  time.Since(time.Now()).String(),
+	})
+}
+
+// Ready endpoint
+func (s *server) ready(c *gin.Context) {
+	err := s.vectorService.Health(c.Request.Context())
+	status := "ready"
+	if err != nil {
+		status = "degraded"
+	}
+
+	response := gin.H{
+		"status":       status,
+		"vector_store": s.storeName,
+		"healthy":      err == nil,
+	}
+	// Searches still work from the fallback, but writes are only queued
+	if s.failover != nil {
+		state := s.failover.Status()
+		if state.Mode != vectorstore.ModePrimary {
+			response["status"] = "degraded"
+		}
+		response["failover"] = state
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Stats endpoint
+func (s *server) stats(c *gin.Context) {
+	stats, err := s.vectorService.GetStats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// Cost endpoint; model spend is metered embedding usage, projected over the whole month
+func (s *server) cost(c *gin.Context) {
+	spent, projected := monthlySpend(s.budgets)
+	const traditionalCost = 2500
+
+	c.JSON(http.StatusOK, gin.H{
+		"current_month": gin.H{
+			"vector_store": 0,
+			"ai_models":    cents(spent),
+			"total":        cents(spent),
+		},
+		"projected_month": gin.H{
+			"vector_store": 0,
+			"ai_models":    cents(projected),
+			"total":        cents(projected),
+		},
+		"savings_vs_enterprise": gin.H{
+			"traditional_cost": traditionalCost,
+			"liberation_cost":  cents(projected),
+			"savings":          cents(traditionalCost - projected),
+			"savings_percent":  math.Round((traditionalCost-projected)/traditionalCost*1000) / 10,
+		},
+	})
+}
+
+// Prometheus metrics endpoint
+func (s *server) metrics(c *gin.Context) {
+	stats, _ := s.vectorService.GetStats(c.Request.Context())
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	// Generate Prometheus format metrics
+	metrics := fmt.Sprintf(`# HELP liberation_ai_info Information about Liberation AI
+# TYPE liberation_ai_info gauge
+liberation_ai_info{version="1.0.0",service="liberation-ai"} 1
+
+# HELP liberation_ai_uptime_seconds Uptime in seconds
+# TYPE liberation_ai_uptime_seconds counter
+liberation_ai_uptime_seconds %d
+
+# HELP liberation_ai_namespaces_total Total number of namespaces
+# TYPE liberation_ai_namespaces_total gauge
+liberation_ai_namespaces_total %d
+
+# HELP liberation_ai_vectors_total Total number of vectors
+# TYPE liberation_ai_vectors_total gauge
+liberation_ai_vectors_total %d
+
+# HELP liberation_ai_memory_bytes Memory usage in bytes
+# TYPE liberation_ai_memory_bytes gauge
+liberation_ai_memory_bytes %d
+
+# HELP liberation_ai_storage_size_bytes Storage size in bytes
+# TYPE liberation_ai_storage_size_bytes gauge
+liberation_ai_storage_size_bytes %d
+
+# HELP liberation_ai_avg_search_time_ms Average search time in milliseconds
+# TYPE liberation_ai_avg_search_time_ms gauge
+liberation_ai_avg_search_time_ms %d
+`,
+		0, // uptime placeholder
+		stats.TotalNamespaces,
+		stats.TotalVectors,
+		m.Alloc,
+		stats.StorageSize,
+		stats.Performance.AvgSearchTime,
+	)
+	if s.failover != nil {
+		state := s.failover.Status()
+		degraded := 0
+		if state.Mode != vectorstore.ModePrimary {
+			degraded = 1
+		}
+		metrics += fmt.Sprintf(`
+# HELP liberation_ai_vector_store_degraded Whether reads are served by the fallback store
+# TYPE liberation_ai_vector_store_degraded gauge
+liberation_ai_vector_store_degraded %d
+
+# HELP liberation_ai_vector_store_queued_writes Writes waiting for the primary store
+# TYPE liberation_ai_vector_store_queued_writes gauge
+liberation_ai_vector_store_queued_writes %d
+`, degraded, state.QueuedWrites)
+	}
+	if cache := s.vectorService.EmbeddingCacheStats(); cache.Enabled {
+		metrics += fmt.Sprintf(`
+# HELP liberation_ai_embedding_cache_lookups_total Embedding cache lookups, by result
+# TYPE liberation_ai_embedding_cache_lookups_total counter
+liberation_ai_embedding_cache_lookups_total{result="hit"} %d
+liberation_ai_embedding_cache_lookups_total{result="miss"} %d
+liberation_ai_embedding_cache_lookups_total{result="bypassed"} %d
+
+# HELP liberation_ai_embedding_cache_evictions_total Embeddings evicted to stay within the cache limits
+# TYPE liberation_ai_embedding_cache_evictions_total counter
+liberation_ai_embedding_cache_evictions_total %d
+
+# HELP liberation_ai_embedding_cache_entries Embeddings in the cache
+# TYPE liberation_ai_embedding_cache_entries gauge
+liberation_ai_embedding_cache_entries %d
+
+# HELP liberation_ai_embedding_cache_bytes Approximate memory held by the embedding cache
+# TYPE liberation_ai_embedding_cache_bytes gauge
+liberation_ai_embedding_cache_bytes %d
+`, cache.Hits, cache.Misses, cache.Bypassed, cache.Evictions, cache.Entries, cache.Bytes)
+	}
+	var poolMetrics strings.Builder
+	dbpool.WriteMetrics(&poolMetrics, "liberation_ai", s.pools...)
+	metrics += poolMetrics.String()
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.String(http.StatusOK, metrics)
+}
+
+// monthlySpend returns this month's metered embedding spend and its projection over the whole month
+func monthlySpend(budgets *budget.Manager) (spent, projected float64) {
+	spent, elapsed := budgets.MonthToDate()
+	projected = spent
+	if elapsed > 0 {
+		projected = spent / elapsed
+	}
+	return spent, projected
+}
+
+// cents rounds dollars to whole cents
+func cents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
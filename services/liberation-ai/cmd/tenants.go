@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/erasure"
+	"liberation-ai/internal/provisioning"
+	"liberation-ai/internal/residency"
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/types"
+
+	"liberation-dryrun"
+)
+
+// tenantRoutes registers the provisioning and erasure calls liberation-auth makes
+func (s *server) tenantRoutes(admin *auth.RouteGroup) {
+	admin.POST("/erasures", s.erase)
+	admin.GET("/provisioning/tenants", s.listTenants)
+	admin.PUT("/provisioning/tenants/:tenant", s.provisionTenant)
+	admin.DELETE("/provisioning/tenants/:tenant", s.deprovisionTenant)
+}
+
+// Erase a deleted user's vectors and feedback. liberation-auth retries until it gets
+// an answer, and erasing a user twice deletes nothing the second time.
+func (s *server) erase(c *gin.Context) {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Deletes must reach the primary, or they would reappear once it recovers
+	if s.failover != nil && s.failover.Status().Mode != vectorstore.ModePrimary {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": types.ErrStoreDegraded.Error()})
+		return
+	}
+	result, err := s.eraser.Erase(c.Request.Context(), req.UserID)
+	switch {
+	case errors.Is(err, erasure.ErrInvalidUser):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error(), "erased": result})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Namespaces and API keys of organizations, managed by liberation-auth
+func (s *server) listTenants(c *gin.Context) {
+	tenants := s.provisioner.Tenants()
+	for i := range tenants {
+		tenants[i] = tenants[i].Public()
+	}
+	c.JSON(http.StatusOK, gin.H{"tenants": tenants, "count": len(tenants)})
+}
+
+// Provision a tenant, or return it as it is when it already was. The API key is
+// only in the response when it is minted: for a new tenant or with rotate_key.
+func (s *server) provisionTenant(c *gin.Context) {
+	if !s.provisioner.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "provisioning is not enabled"})
+		return
+	}
+	var req struct {
+		RotateKey bool `json:"rotate_key"`
+		// Region pins the tenant's namespace, for tenants that need their data kept in one
+		Region string `json:"region"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Region != "" {
+		if !s.residencyPolicy.Enabled() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "region needs residency to be enabled"})
+			return
+		}
+		if err := residency.ValidateRegion(req.Region); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	_, existed := s.provisioner.Get(c.Param("tenant"))
+	tenant, key, err := s.provisioner.Provision(c.Param("tenant"), req.RotateKey)
+	switch {
+	case errors.Is(err, provisioning.ErrInvalidTenant):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	response := gin.H{"tenant": tenant.Public()}
+	if req.Region != "" {
+		if err := s.residencyPolicy.Pin(tenant.Namespace, req.Region); err != nil {
+			// The tenant exists now, so a minted key must not be lost with the error
+			response["error"] = err.Error()
+			if key != "" {
+				response["api_key"] = key
+			}
+			c.JSON(http.StatusInternalServerError, response)
+			return
+		}
+	}
+	if region := s.residencyPolicy.Region(tenant.Namespace); region != "" {
+		response["region"] = region
+	}
+	if key != "" {
+		response["api_key"] = key
+	}
+	status := http.StatusOK
+	if !existed {
+		status = http.StatusCreated
+	}
+	c.JSON(status, response)
+}
+
+// Deprovision a tenant: its key stops working at once and, unless keep_data=true,
+// every namespace under its prefix is deleted
+func (s *server) deprovisionTenant(c *gin.Context) {
+	tenant := c.Param("tenant")
+	record, ok := s.provisioner.Get(tenant)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": provisioning.ErrNotProvisioned.Error()})
+		return
+	}
+	keepData := c.Query("keep_data") == "true"
+	owned := func() ([]string, error) {
+		namespaces, err := s.vectorService.ListNamespaces(c.Request.Context())
+		if err != nil {
+			return nil, err
+		}
+		list := []string{}
+		for _, namespace := range namespaces {
+			if s.provisioner.Owns(tenant, namespace) {
+				list = append(list, namespace)
+			}
+		}
+		return list, nil
+	}
+
+	if previewDryRun(c, func() (*dryrun.Plan, error) {
+		plan := dryrun.New("deprovision_tenant", tenant).Add("api_keys", "delete", 1, []string{record.KeyHint})
+		if keepData {
+			return plan.Warn("namespaces are kept"), nil
+		}
+		namespaces, err := owned()
+		if err != nil {
+			return nil, err
+		}
+		var vectors int64
+		for _, namespace := range namespaces {
+			size, err := s.vectorService.NamespaceSize(c.Request.Context(), namespace)
+			if err != nil {
+				return nil, err
+			}
+			vectors += size
+		}
+		return plan.Add("namespaces", "delete", int64(len(namespaces)), namespaces).Add("vectors", "delete", vectors, nil), nil
+	}) {
+		return
+	}
+
+	var namespaces []string
+	if !keepData {
+		// Deletes must reach the primary, or they would reappear once it recovers
+		if s.failover != nil && s.failover.Status().Mode != vectorstore.ModePrimary {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": types.ErrStoreDegraded.Error()})
+			return
+		}
+		var err error
+		if namespaces, err = owned(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if _, err := s.provisioner.Deprovision(tenant); err != nil && !errors.Is(err, provisioning.ErrNotProvisioned) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var deleted int64
+	for _, namespace := range namespaces {
+		count, err := s.vectorService.DeleteNamespace(c.Request.Context(), namespace)
+		deleted += count
+		if err != nil {
+			c.JSON(storeErrorStatus(err), gin.H{"error": err.Error(), "tenant": tenant, "deleted": deleted})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"tenant": tenant, "namespaces": namespaces, "deleted": deleted})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/dashboard"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/auth"
+
+	"liberation-dryrun"
+)
+
+// usageRoutes registers quota usage and overrides, and the dashboard's data
+func (s *server) usageRoutes(v1, admin *auth.RouteGroup) {
+	v1.GET("/usage", s.usage)
+	v1.GET("/limits", s.limits)
+
+	admin.GET("/usage", s.adminUsage)
+	admin.GET("/dashboard", s.dashboardData)
+	admin.PUT("/quotas/:identity", s.setQuota)
+	admin.DELETE("/quotas/:identity", s.clearQuota)
+}
+
+// Quota usage for the calling API key or IP
+func (s *server) usage(c *gin.Context) {
+	c.JSON(http.StatusOK, s.limiter.Usage(ratelimit.Identity(c)))
+}
+
+// The request rate and embedding quota that apply to the caller right now, so clients
+// can pace themselves instead of running into 429s
+func (s *server) limits(c *gin.Context) {
+	c.JSON(http.StatusOK, s.limiter.Limits(ratelimit.Identity(c)))
+}
+
+// Admin quota management
+func (s *server) adminUsage(c *gin.Context) {
+	usage := s.limiter.AllUsage()
+	c.JSON(http.StatusOK, gin.H{
+		"usage": usage,
+		"count": len(usage),
+	})
+}
+
+// Everything the dashboard shows, in one read
+func (s *server) dashboardData(c *gin.Context) {
+	if !s.cfg.Dashboard.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dashboard is not enabled"})
+		return
+	}
+	ctx := c.Request.Context()
+	healthErr := s.vectorService.Health(ctx)
+	status := "ready"
+	if healthErr != nil {
+		status = "degraded"
+	}
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	spent, projected := monthlySpend(s.budgets)
+
+	response := gin.H{
+		"service":      "liberation-ai",
+		"status":       status,
+		"vector_store": s.storeName,
+		"healthy":      healthErr == nil,
+		"memory_bytes": memory.Alloc,
+		"cost": gin.H{
+			"month_to_date":   cents(spent),
+			"projected_month": cents(projected),
+		},
+		"usage":           s.limiter.AllUsage(),
+		"refresh_seconds": s.cfg.Dashboard.RefreshSeconds,
+		"generated_at":    time.Now().UTC(),
+	}
+	if s.failover != nil {
+		state := s.failover.Status()
+		if state.Mode != vectorstore.ModePrimary {
+			response["status"] = "degraded"
+		}
+		response["failover"] = state
+	}
+	var vectors map[string]int64
+	if stats, err := s.vectorService.GetStats(ctx); err != nil {
+		response["stats_error"] = err.Error()
+	} else {
+		response["stats"] = stats
+		vectors = stats.NamespaceStats
+	}
+	response["namespaces"] = dashboard.Namespaces(vectors, s.budgets.All())
+	if s.migrator.Enabled() {
+		if run, err := s.migrator.Get(); err == nil {
+			response["migration"] = run
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *server) setQuota(c *gin.Context) {
+	var override ratelimit.Override
+	if err := c.ShouldBindJSON(&override); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	identity := c.Param("identity")
+	s.limiter.SetOverride(identity, override)
+	c.JSON(http.StatusOK, s.limiter.Usage(identity))
+}
+
+func (s *server) clearQuota(c *gin.Context) {
+	identity := c.Param("identity")
+	if previewDryRun(c, func() (*dryrun.Plan, error) {
+		usage := s.limiter.Usage(identity)
+		plan := dryrun.New("clear_quota", identity)
+		if usage.Override != nil {
+			plan.Add("quota_overrides", "delete", 1, []string{identity})
+		} else {
+			plan.Add("quota_overrides", "delete", 0, nil)
+		}
+		if c.Query("reset_usage") == "true" {
+			plan.Add("embeddings_used", "reset", usage.EmbeddingsUsed, nil)
+		}
+		return plan, nil
+	}) {
+		return
+	}
+	s.limiter.ClearOverride(identity)
+	if c.Query("reset_usage") == "true" {
+		s.limiter.ResetUsage(identity)
+	}
+	c.JSON(http.StatusOK, s.limiter.Usage(identity))
+}
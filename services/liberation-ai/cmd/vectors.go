@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/validate"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// vectorRoutes registers reads and metadata updates of stored vectors
+func (s *server) vectorRoutes(v1 *auth.RouteGroup) {
+	v1.GET("/vectors/:namespace/:id", s.getVector)
+	v1.PATCH("/vectors/:namespace/:id", validate.Body(s.validator.MetadataUpdate), s.updateMetadata)
+	v1.PATCH("/vectors/:namespace", validate.Body(s.validator.MetadataUpdate), s.bulkUpdateMetadata)
+	v1.GET("/vectors/:namespace/:id/similar", s.similarVectors)
+}
+
+// Get specific vector
+func (s *server) getVector(c *gin.Context) {
+	namespace := c.Param("namespace")
+	id := c.Param("id")
+
+	vector, err := s.vectorService.GetVector(c.Request.Context(), namespace, id)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, vector)
+}
+
+// Merge into or replace one vector's metadata; the embedding is left as it is
+func (s *server) updateMetadata(c *gin.Context) {
+	var req types.MetadataUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Namespace, req.IDs, req.Filters = c.Param("namespace"), []string{c.Param("id")}, nil
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := s.vectorService.UpdateMetadata(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), storeErrorBody(err))
+		return
+	}
+	if response.Updated == 0 && response.Store != "queued" {
+		c.JSON(http.StatusNotFound, gin.H{"error": types.VectorNotFound(req.Namespace, c.Param("id")).Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Bulk metadata updates, selecting vectors by ids or by metadata filters
+func (s *server) bulkUpdateMetadata(c *gin.Context) {
+	var req types.MetadataUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Namespace = c.Param("namespace")
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := s.vectorService.UpdateMetadata(c.Request.Context(), &req)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), storeErrorBody(err))
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Search by example: the stored vector is the query, so nothing is embedded
+func (s *server) similarVectors(c *gin.Context) {
+	opts := liberation.SimilarOptions{Limit: 10, ExcludeSelf: c.Query("exclude_self") != "false"}
+	if l := c.Query("limit"); l != "" {
+		if _, err := fmt.Sscanf(l, "%d", &opts.Limit); err != nil || opts.Limit < 1 || opts.Limit > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+	}
+	opts.Filters = queryFilters(c)
+
+	example, err := s.vectorService.GetVector(c.Request.Context(), c.Param("namespace"), c.Param("id"))
+	if err != nil {
+		c.JSON(storeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	response, err := s.vectorService.SimilarTo(c.Request.Context(), example, opts)
+	if err != nil {
+		c.JSON(storeErrorStatus(err), storeErrorBody(err))
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
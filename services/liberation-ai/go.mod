@@ -4,6 +4,7 @@ go 1.23.0
 
 require (
 	github.com/gin-gonic/gin v1.11.0
+	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/lib/pq v1.10.9
 	github.com/pgvector/pgvector-go v0.1.1
 	github.com/sirupsen/logrus v1.9.3
@@ -21,7 +22,6 @@ require (
 	github.com/go-playground/validator/v10 v10.27.0 // indirect
 	github.com/goccy/go-json v0.10.2 // indirect
 	github.com/goccy/go-yaml v1.18.0 // indirect
-	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/klauspost/cpuid/v2 v2.3.0 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
@@ -40,7 +40,34 @@ require (
 	golang.org/x/net v0.42.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
-	golang.org/x/text v0.27.0 // indirect
 	golang.org/x/tools v0.34.0 // indirect
 	google.golang.org/protobuf v1.36.9 // indirect
 )
+
+require (
+	golang.org/x/text v0.27.0
+	liberation-anonymize v0.0.0
+	liberation-cache v0.0.0
+	liberation-dbpool v0.0.0
+	liberation-dryrun v0.0.0
+	liberation-profiling v0.0.0
+	liberation-scheduler v0.0.0
+	liberation-serviceauth v0.0.0
+	liberation-storage v0.0.0
+)
+
+replace liberation-storage => ../../shared/storage
+
+replace liberation-anonymize => ../../shared/anonymize
+
+replace liberation-serviceauth => ../../shared/serviceauth
+
+replace liberation-profiling => ../../shared/profiling
+
+replace liberation-dryrun => ../../shared/dryrun
+
+replace liberation-scheduler => ../../shared/scheduler
+
+replace liberation-dbpool => ../../shared/dbpool
+
+replace liberation-cache => ../../shared/cache
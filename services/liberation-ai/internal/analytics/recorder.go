@@ -0,0 +1,310 @@
+package analytics
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"liberation-ai/pkg/types"
+
+	"liberation-anonymize"
+)
+
+var (
+	// ErrUnknownQuery is returned when feedback references a query that was never recorded or has expired
+	ErrUnknownQuery = errors.New("unknown query_id")
+
+	// ErrInvalidAction is returned for feedback actions outside the supported set
+	ErrInvalidAction = errors.New("invalid feedback action")
+)
+
+// Feedback actions accepted by the /v1/feedback endpoint
+const (
+	ActionClick      = "click"
+	ActionSelect     = "select"
+	ActionThumbsUp   = "thumbs_up"
+	ActionThumbsDown = "thumbs_down"
+)
+
+var validActions = map[string]bool{
+	ActionClick:      true,
+	ActionSelect:     true,
+	ActionThumbsUp:   true,
+	ActionThumbsDown: true,
+}
+
+// Patterns scrubbed from query text before it is stored
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	digitsPattern = regexp.MustCompile(`\d{6,}`)
+)
+
+// Config controls search analytics collection
+type Config struct {
+	Enabled          bool    `yaml:"enabled" json:"enabled"`
+	ZeroHitThreshold float64 `yaml:"zero_hit_threshold" json:"zero_hit_threshold"`
+	MaxEvents        int     `yaml:"max_events" json:"max_events"`
+	RetentionDays    int     `yaml:"retention_days" json:"retention_days"`
+	Salt             string  `yaml:"salt" json:"-"`
+	// Anonymization controls reports and exports requested with anonymize=true
+	Anonymization anonymize.Config `yaml:"anonymization" json:"anonymization"`
+}
+
+// DefaultConfig returns analytics settings suitable for a single instance
+func DefaultConfig() Config {
+	return Config{
+		Enabled:          true,
+		ZeroHitThreshold: 0.5,
+		MaxEvents:        100000,
+		RetentionDays:    30,
+		Anonymization:    anonymize.DefaultConfig(),
+	}
+}
+
+// QueryEvent is an anonymized record of a single search
+type QueryEvent struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Namespace string    `json:"namespace"`
+	Query     string    `json:"query"`
+	UserHash  string    `json:"user_hash"`
+	Results   int       `json:"results"`
+	TopScore  float64   `json:"top_score"`
+	ZeroHit   bool      `json:"zero_hit"`
+	LatencyMS int64     `json:"latency_ms"`
+}
+
+// FeedbackRequest is the body accepted by POST /v1/feedback
+type FeedbackRequest struct {
+	QueryID    string `json:"query_id" binding:"required"`
+	DocumentID string `json:"document_id" binding:"required"`
+	Action     string `json:"action" binding:"required"`
+	Rank       int    `json:"rank,omitempty"`
+	// UserID names the end user the feedback came from, for apps that send feedback for
+	// their users with one API key; it is kept only as a salted hash, so the feedback can be
+	// erased with the user
+	UserID string `json:"user_id,omitempty"`
+}
+
+// FeedbackEvent records a user's reaction to a search result
+type FeedbackEvent struct {
+	QueryID    string    `json:"query_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Namespace  string    `json:"namespace"`
+	Query      string    `json:"query"`
+	DocumentID string    `json:"document_id"`
+	Action     string    `json:"action"`
+	Rank       int       `json:"rank,omitempty"`
+	UserHash   string    `json:"user_hash"`
+	// SubjectHash is the hash of the request's user_id, if it named one
+	SubjectHash string `json:"-"`
+}
+
+// Recorder keeps a bounded, in-memory log of searches and feedback
+type Recorder struct {
+	mu       sync.RWMutex
+	config   Config
+	queries  []QueryEvent
+	byID     map[string]int
+	feedback []FeedbackEvent
+	salt     string
+	pruned   time.Time
+	now      func() time.Time
+}
+
+// NewRecorder creates a new analytics recorder.
+// Without a configured salt a random one is generated, so user hashes are stable only per process.
+func NewRecorder(config Config) *Recorder {
+	salt := config.Salt
+	if salt == "" {
+		salt = randomID()
+	}
+	if config.MaxEvents <= 0 {
+		config.MaxEvents = DefaultConfig().MaxEvents
+	}
+
+	return &Recorder{
+		config: config,
+		byID:   make(map[string]int),
+		salt:   salt,
+		now:    time.Now,
+	}
+}
+
+// NewExport starts an anonymized report or export with its own salt
+func (r *Recorder) NewExport() (*anonymize.Export, error) {
+	return anonymize.New(r.config.Anonymization)
+}
+
+// Enabled reports whether analytics are being collected
+func (r *Recorder) Enabled() bool {
+	return r.config.Enabled
+}
+
+// RecordSearch logs a search and returns the query ID clients should send back with feedback
+func (r *Recorder) RecordSearch(namespace, identity, query string, response *types.SearchResponse) string {
+	if !r.Enabled() {
+		return ""
+	}
+
+	event := QueryEvent{
+		ID:        randomID(),
+		Timestamp: r.now().UTC(),
+		Namespace: namespace,
+		Query:     Anonymize(query),
+		UserHash:  r.hashIdentity(identity),
+	}
+
+	if response != nil {
+		event.LatencyMS = response.ProcessingTime
+		for _, result := range response.Results {
+			if result.Score > event.TopScore {
+				event.TopScore = result.Score
+			}
+			if result.Score >= r.config.ZeroHitThreshold {
+				event.Results++
+			}
+		}
+	}
+	event.ZeroHit = event.Results == 0
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pruneLocked()
+	r.queries = append(r.queries, event)
+	r.byID[event.ID] = len(r.queries) - 1
+	return event.ID
+}
+
+// RecordFeedback attaches a click, selection or rating to a previously recorded search
+func (r *Recorder) RecordFeedback(identity string, req FeedbackRequest) (*FeedbackEvent, error) {
+	if !validActions[req.Action] {
+		return nil, ErrInvalidAction
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index, exists := r.byID[req.QueryID]
+	if !exists {
+		return nil, ErrUnknownQuery
+	}
+	query := r.queries[index]
+
+	event := FeedbackEvent{
+		QueryID:    query.ID,
+		Timestamp:  r.now().UTC(),
+		Namespace:  query.Namespace,
+		Query:      query.Query,
+		DocumentID: req.DocumentID,
+		Action:     req.Action,
+		Rank:       req.Rank,
+		UserHash:   r.hashIdentity(identity),
+	}
+	if req.UserID != "" {
+		event.SubjectHash = r.hashIdentity(subjectIdentity(req.UserID))
+	}
+
+	if len(r.feedback) >= r.config.MaxEvents {
+		r.feedback = r.feedback[1:]
+	}
+	r.feedback = append(r.feedback, event)
+	return &event, nil
+}
+
+// FeedbackFor returns the retained feedback a caller gave in a namespace, oldest first
+func (r *Recorder) FeedbackFor(identity, namespace string) []FeedbackEvent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	userHash := r.hashIdentity(identity)
+	var events []FeedbackEvent
+	for _, event := range r.feedback {
+		if event.UserHash == userHash && event.Namespace == namespace {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// ForgetUser deletes the feedback sent with userID and returns how much was deleted
+func (r *Recorder) ForgetUser(userID string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subjectHash := r.hashIdentity(subjectIdentity(userID))
+	kept := r.feedback[:0]
+	for _, event := range r.feedback {
+		if event.SubjectHash != subjectHash {
+			kept = append(kept, event)
+		}
+	}
+	forgotten := len(r.feedback) - len(kept)
+	r.feedback = kept
+	return forgotten
+}
+
+// subjectIdentity keeps user IDs apart from API keys and addresses when hashed
+func subjectIdentity(userID string) string {
+	return "user:" + userID
+}
+
+// Anonymize normalizes query text and strips e-mail addresses and long digit runs
+func Anonymize(query string) string {
+	query = emailPattern.ReplaceAllString(query, "[email]")
+	query = digitsPattern.ReplaceAllString(query, "[number]")
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+// hashIdentity returns a salted, truncated hash so callers can be counted but not identified
+func (r *Recorder) hashIdentity(identity string) string {
+	sum := sha256.Sum256([]byte(r.salt + ":" + identity))
+	return hex.EncodeToString(sum[:8])
+}
+
+// pruneLocked drops events past retention or over capacity and rebuilds the ID index.
+// Retention is applied at most once a minute and capacity is reclaimed a tenth at a time,
+// so the index is not rebuilt on every search.
+func (r *Recorder) pruneLocked() {
+	now := r.now()
+	drop := 0
+	if r.config.RetentionDays > 0 && now.Sub(r.pruned) >= time.Minute {
+		r.pruned = now
+		cutoff := now.UTC().AddDate(0, 0, -r.config.RetentionDays)
+		for drop < len(r.queries) && r.queries[drop].Timestamp.Before(cutoff) {
+			drop++
+		}
+
+		expired := 0
+		for expired < len(r.feedback) && r.feedback[expired].Timestamp.Before(cutoff) {
+			expired++
+		}
+		r.feedback = r.feedback[expired:]
+	}
+	if len(r.queries)-drop >= r.config.MaxEvents {
+		drop = max(drop, len(r.queries)-r.config.MaxEvents+max(r.config.MaxEvents/10, 1))
+	}
+	if drop == 0 {
+		return
+	}
+
+	r.queries = append([]QueryEvent(nil), r.queries[drop:]...)
+	r.byID = make(map[string]int, len(r.queries))
+	for i, event := range r.queries {
+		r.byID[event.ID] = i
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return hex.EncodeToString([]byte(time.Now().String()))[:32]
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,147 @@
+package analytics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"liberation-ai/pkg/types"
+)
+
+// testRecorder records with a clock the test moves
+func testRecorder(config Config) (*Recorder, *time.Time) {
+	config.Salt = "test-salt"
+	r := NewRecorder(config)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	return r, &now
+}
+
+func scored(scores ...float64) *types.SearchResponse {
+	response := &types.SearchResponse{ProcessingTime: 12}
+	for _, score := range scores {
+		response.Results = append(response.Results, types.SearchResult{Score: score})
+	}
+	return response
+}
+
+func TestAnonymize(t *testing.T) {
+	for query, want := range map[string]string{
+		"  Tool   LIBRARY ":                 "tool library",
+		"mail ada.l@example.org about it":   "mail [email] about it",
+		"order 1234567 or 12345":            "order [number] or 12345",
+		"call +44 7700900123 re: Seed Swap": "call +44 [number] re: seed swap",
+	} {
+		if got := Anonymize(query); got != want {
+			t.Errorf("Anonymize(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestRecordSearch(t *testing.T) {
+	r, _ := testRecorder(DefaultConfig())
+	hits := r.RecordSearch("docs", "key-1", "Ladder", scored(0.9, 0.6, 0.2))
+	misses := r.RecordSearch("docs", "key-1", "ladder", scored(0.3))
+	if hits == "" || misses == "" || hits == misses {
+		t.Fatalf("query ids %q and %q", hits, misses)
+	}
+
+	first, second := r.queries[0], r.queries[1]
+	if first.Results != 2 || first.TopScore != 0.9 || first.ZeroHit || first.LatencyMS != 12 {
+		t.Errorf("first search recorded as %+v", first)
+	}
+	if second.Results != 0 || !second.ZeroHit || second.TopScore != 0.3 {
+		t.Errorf("results under the threshold recorded as %+v", second)
+	}
+	if first.Query != "ladder" || first.UserHash == "key-1" || first.UserHash != second.UserHash {
+		t.Errorf("query %q by %q; want normalized text and a stable hash", first.Query, first.UserHash)
+	}
+
+	disabled := DefaultConfig()
+	disabled.Enabled = false
+	off, _ := testRecorder(disabled)
+	if id := off.RecordSearch("docs", "key-1", "ladder", nil); id != "" || len(off.queries) != 0 {
+		t.Errorf("disabled recorder returned %q", id)
+	}
+}
+
+func TestRecordFeedback(t *testing.T) {
+	r, _ := testRecorder(DefaultConfig())
+	queryID := r.RecordSearch("docs", "key-1", "ladder", scored(0.9))
+
+	for _, tc := range []struct {
+		req  FeedbackRequest
+		want error
+	}{
+		{FeedbackRequest{QueryID: queryID, DocumentID: "a", Action: "like"}, ErrInvalidAction},
+		{FeedbackRequest{QueryID: "missing", DocumentID: "a", Action: ActionClick}, ErrUnknownQuery},
+	} {
+		if _, err := r.RecordFeedback("key-1", tc.req); !errors.Is(err, tc.want) {
+			t.Errorf("%+v: %v, want %v", tc.req, err, tc.want)
+		}
+	}
+
+	event, err := r.RecordFeedback("key-1", FeedbackRequest{QueryID: queryID, DocumentID: "a", Action: ActionClick, Rank: 1, UserID: "u-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Namespace != "docs" || event.Query != "ladder" || event.SubjectHash == "" {
+		t.Errorf("feedback recorded as %+v", event)
+	}
+	if _, err := r.RecordFeedback("key-2", FeedbackRequest{QueryID: queryID, DocumentID: "b", Action: ActionThumbsDown}); err != nil {
+		t.Fatal(err)
+	}
+
+	if events := r.FeedbackFor("key-1", "docs"); len(events) != 1 || events[0].DocumentID != "a" {
+		t.Errorf("FeedbackFor key-1: %+v", events)
+	}
+	if events := r.FeedbackFor("key-1", "other"); len(events) != 0 {
+		t.Errorf("FeedbackFor another namespace: %+v", events)
+	}
+
+	// Only feedback sent with the user's ID is erased with them
+	if forgotten := r.ForgetUser("u-2"); forgotten != 0 {
+		t.Errorf("forgot %d events of a user without any", forgotten)
+	}
+	if forgotten := r.ForgetUser("u-1"); forgotten != 1 || len(r.feedback) != 1 || r.feedback[0].DocumentID != "b" {
+		t.Errorf("forgot %d, kept %+v", forgotten, r.feedback)
+	}
+}
+
+func TestPruning(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxEvents = 10
+	config.RetentionDays = 1
+	r, now := testRecorder(config)
+
+	old := r.RecordSearch("docs", "key-1", "old", scored(0.9))
+	if _, err := r.RecordFeedback("key-1", FeedbackRequest{QueryID: old, DocumentID: "a", Action: ActionClick}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A day later the old search and its feedback are past retention
+	*now = now.Add(25 * time.Hour)
+	recent := r.RecordSearch("docs", "key-1", "recent", scored(0.9))
+	if len(r.queries) != 1 || len(r.feedback) != 0 {
+		t.Fatalf("after retention: %d queries, %d feedback", len(r.queries), len(r.feedback))
+	}
+	if _, err := r.RecordFeedback("key-1", FeedbackRequest{QueryID: old, DocumentID: "a", Action: ActionClick}); !errors.Is(err, ErrUnknownQuery) {
+		t.Errorf("feedback on an expired query: %v", err)
+	}
+
+	// At capacity the oldest searches make room, and the index follows them
+	for i := 0; i < 12; i++ {
+		r.RecordSearch("docs", "key-1", "more", scored(0.9))
+	}
+	if len(r.queries) != config.MaxEvents {
+		t.Errorf("%d queries kept, want %d", len(r.queries), config.MaxEvents)
+	}
+	if _, ok := r.byID[recent]; ok {
+		t.Error("the index still lists a dropped query")
+	}
+	for id, i := range r.byID {
+		if r.queries[i].ID != id {
+			t.Fatalf("index points %s at %s", id, r.queries[i].ID)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package analytics
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"liberation-anonymize"
+)
+
+// Filter selects events by namespace and time range; zero values match everything
+type Filter struct {
+	Namespace string
+	From      time.Time
+	To        time.Time
+}
+
+// ParseFilter builds a filter from query parameters; times accept RFC 3339 or YYYY-MM-DD
+func ParseFilter(namespace, from, to string) (Filter, error) {
+	filter := Filter{Namespace: namespace}
+
+	var err error
+	if filter.From, err = parseTime(from); err != nil {
+		return filter, fmt.Errorf("invalid from: %w", err)
+	}
+	if filter.To, err = parseTime(to); err != nil {
+		return filter, fmt.Errorf("invalid to: %w", err)
+	}
+	return filter, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func (f Filter) matches(namespace string, timestamp time.Time) bool {
+	if f.Namespace != "" && f.Namespace != namespace {
+		return false
+	}
+	if !f.From.IsZero() && timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !timestamp.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// QueryCount is a query and how often it was searched
+type QueryCount struct {
+	Query string `json:"query"`
+	Count int    `json:"count"`
+}
+
+// NamespaceStats aggregates activity for one namespace
+type NamespaceStats struct {
+	Queries          int     `json:"queries"`
+	ZeroHitQueries   int     `json:"zero_hit_queries"`
+	ZeroHitRate      float64 `json:"zero_hit_rate"`
+	Feedback         int     `json:"feedback"`
+	ClickThroughRate float64 `json:"click_through_rate"`
+	AvgLatencyMS     float64 `json:"avg_latency_ms"`
+}
+
+// Report summarises search behaviour over a time range
+type Report struct {
+	Namespace         string                     `json:"namespace,omitempty"`
+	From              *time.Time                 `json:"from,omitempty"`
+	To                *time.Time                 `json:"to,omitempty"`
+	TotalQueries      int                        `json:"total_queries"`
+	UniqueQueries     int                        `json:"unique_queries"`
+	UniqueUsers       int                        `json:"unique_users"`
+	ZeroHitQueries    int                        `json:"zero_hit_queries"`
+	ZeroHitRate       float64                    `json:"zero_hit_rate"`
+	ClickThroughRate  float64                    `json:"click_through_rate"`
+	FeedbackByAction  map[string]int             `json:"feedback_by_action"`
+	TopQueries        []QueryCount               `json:"top_queries"`
+	TopZeroHitQueries []QueryCount               `json:"top_zero_hit_queries"`
+	ByNamespace       map[string]*NamespaceStats `json:"by_namespace"`
+	// Anonymization describes how an anonymized report was prepared
+	Anonymization *anonymize.Manifest `json:"anonymization,omitempty"`
+}
+
+// Report aggregates recorded events matching the filter
+func (r *Recorder) Report(filter Filter, top int) *Report {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	report := &Report{
+		Namespace:        filter.Namespace,
+		FeedbackByAction: make(map[string]int),
+		ByNamespace:      make(map[string]*NamespaceStats),
+	}
+	if !filter.From.IsZero() {
+		report.From = &filter.From
+	}
+	if !filter.To.IsZero() {
+		report.To = &filter.To
+	}
+
+	queryCounts := make(map[string]int)
+	zeroHitCounts := make(map[string]int)
+	users := make(map[string]bool)
+	latency := make(map[string]int64)
+
+	stats := func(namespace string) *NamespaceStats {
+		s := report.ByNamespace[namespace]
+		if s == nil {
+			s = &NamespaceStats{}
+			report.ByNamespace[namespace] = s
+		}
+		return s
+	}
+
+	for _, event := range r.queries {
+		if !filter.matches(event.Namespace, event.Timestamp) {
+			continue
+		}
+
+		report.TotalQueries++
+		queryCounts[event.Query]++
+		users[event.UserHash] = true
+		latency[event.Namespace] += event.LatencyMS
+
+		s := stats(event.Namespace)
+		s.Queries++
+		if event.ZeroHit {
+			report.ZeroHitQueries++
+			zeroHitCounts[event.Query]++
+			s.ZeroHitQueries++
+		}
+	}
+
+	// Click-through counts searches that received at least one click or selection
+	clicked := make(map[string]string)
+	for _, event := range r.feedback {
+		if !filter.matches(event.Namespace, event.Timestamp) {
+			continue
+		}
+
+		report.FeedbackByAction[event.Action]++
+		stats(event.Namespace).Feedback++
+		if event.Action == ActionClick || event.Action == ActionSelect {
+			clicked[event.QueryID] = event.Namespace
+		}
+	}
+
+	clicksByNamespace := make(map[string]int)
+	for _, namespace := range clicked {
+		clicksByNamespace[namespace]++
+	}
+
+	for namespace, s := range report.ByNamespace {
+		if s.Queries > 0 {
+			s.ZeroHitRate = ratio(s.ZeroHitQueries, s.Queries)
+			s.ClickThroughRate = ratio(clicksByNamespace[namespace], s.Queries)
+			s.AvgLatencyMS = float64(latency[namespace]) / float64(s.Queries)
+		}
+	}
+
+	report.UniqueQueries = len(queryCounts)
+	report.UniqueUsers = len(users)
+	report.ZeroHitRate = ratio(report.ZeroHitQueries, report.TotalQueries)
+	report.ClickThroughRate = ratio(len(clicked), report.TotalQueries)
+	report.TopQueries = topCounts(queryCounts, top)
+	report.TopZeroHitQueries = topCounts(zeroHitCounts, top)
+
+	return report
+}
+
+// Export writes matching query and feedback events as JSON lines or CSV
+func (r *Recorder) Export(w io.Writer, filter Filter, format string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return writeEvents(w, format, filter, r.queries, r.feedback)
+}
+
+// ExportAnonymized writes matching events like Export, prepared for sharing: user hashes
+// and query IDs become pseudonyms valid in this export only, timestamps are truncated to
+// the hour, and the text of queries searched by fewer than k distinct users is withheld.
+// Namespaces searched by fewer than k users are left out.
+func (r *Recorder) ExportAnonymized(w io.Writer, filter Filter, format string, export *anonymize.Export) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rare := make(map[string]bool)
+	for query, users := range r.distinctUsers(filter, byQuery) {
+		if export.Suppress(len(users)) {
+			rare[query] = true
+		}
+	}
+	small := make(map[string]bool)
+	for namespace, users := range r.distinctUsers(filter, byNamespace) {
+		if export.Suppress(len(users)) {
+			small[namespace] = true
+		}
+	}
+	scrub := func(query string) string {
+		if rare[query] {
+			return ""
+		}
+		return query
+	}
+
+	queries := make([]QueryEvent, 0, len(r.queries))
+	for _, event := range r.queries {
+		if filter.matches(event.Namespace, event.Timestamp) && !small[event.Namespace] {
+			event.ID = export.Pseudonym(event.ID)
+			event.UserHash = export.Pseudonym(event.UserHash)
+			event.Timestamp = event.Timestamp.Truncate(time.Hour)
+			event.Query = scrub(event.Query)
+			queries = append(queries, event)
+		}
+	}
+	feedback := make([]FeedbackEvent, 0, len(r.feedback))
+	for _, event := range r.feedback {
+		if filter.matches(event.Namespace, event.Timestamp) && !small[event.Namespace] {
+			event.QueryID = export.Pseudonym(event.QueryID)
+			event.UserHash = export.Pseudonym(event.UserHash)
+			event.Timestamp = event.Timestamp.Truncate(time.Hour)
+			event.Query = scrub(event.Query)
+			feedback = append(feedback, event)
+		}
+	}
+
+	return writeEvents(w, format, Filter{}, queries, feedback)
+}
+
+// AnonymizedReport is Report prepared for sharing: queries and namespaces searched by fewer
+// than k distinct users are left out, and small counts carry noise, with rates worked out
+// from the noised counts
+func (r *Recorder) AnonymizedReport(filter Filter, top int, export *anonymize.Export) *Report {
+	report := r.Report(filter, 0)
+
+	r.mu.RLock()
+	queryUsers := r.distinctUsers(filter, byQuery)
+	namespaceUsers := r.distinctUsers(filter, byNamespace)
+	r.mu.RUnlock()
+
+	anonymizeCounts := func(counts []QueryCount) []QueryCount {
+		kept := make(map[string]int)
+		for _, count := range counts {
+			if !export.Suppress(len(queryUsers[count.Query])) {
+				kept[count.Query] = export.Count(count.Count)
+			}
+		}
+		return topCounts(kept, top)
+	}
+	report.TopQueries = anonymizeCounts(report.TopQueries)
+	report.TopZeroHitQueries = anonymizeCounts(report.TopZeroHitQueries)
+
+	clicks := int(math.Round(report.ClickThroughRate * float64(report.TotalQueries)))
+	report.TotalQueries = export.Count(report.TotalQueries)
+	report.ZeroHitQueries = export.Count(report.ZeroHitQueries)
+	report.UniqueQueries = export.Count(report.UniqueQueries)
+	report.UniqueUsers = export.Count(report.UniqueUsers)
+	report.ZeroHitRate = noisyRatio(report.ZeroHitQueries, report.TotalQueries)
+	report.ClickThroughRate = noisyRatio(export.Count(clicks), report.TotalQueries)
+	for action, count := range report.FeedbackByAction {
+		report.FeedbackByAction[action] = export.Count(count)
+	}
+
+	for namespace, s := range report.ByNamespace {
+		if export.Suppress(len(namespaceUsers[namespace])) {
+			delete(report.ByNamespace, namespace)
+			continue
+		}
+		clicks := int(math.Round(s.ClickThroughRate * float64(s.Queries)))
+		s.Queries = export.Count(s.Queries)
+		s.ZeroHitQueries = export.Count(s.ZeroHitQueries)
+		s.Feedback = export.Count(s.Feedback)
+		s.ZeroHitRate = noisyRatio(s.ZeroHitQueries, s.Queries)
+		s.ClickThroughRate = noisyRatio(export.Count(clicks), s.Queries)
+	}
+
+	manifest := export.Manifest()
+	report.Anonymization = &manifest
+	return report
+}
+
+// distinctUsers returns the distinct user hashes behind each key of the matching queries;
+// callers hold mu
+func (r *Recorder) distinctUsers(filter Filter, key func(QueryEvent) string) map[string]map[string]bool {
+	users := make(map[string]map[string]bool)
+	for _, event := range r.queries {
+		if filter.matches(event.Namespace, event.Timestamp) {
+			k := key(event)
+			if users[k] == nil {
+				users[k] = make(map[string]bool)
+			}
+			users[k][event.UserHash] = true
+		}
+	}
+	return users
+}
+
+func byQuery(event QueryEvent) string     { return event.Query }
+func byNamespace(event QueryEvent) string { return event.Namespace }
+
+func writeEvents(w io.Writer, format string, filter Filter, queries []QueryEvent, feedback []FeedbackEvent) error {
+	switch format {
+	case "", "jsonl":
+		encoder := json.NewEncoder(w)
+		for _, event := range queries {
+			if filter.matches(event.Namespace, event.Timestamp) {
+				if err := encoder.Encode(map[string]interface{}{"type": "query", "event": event}); err != nil {
+					return err
+				}
+			}
+		}
+		for _, event := range feedback {
+			if filter.matches(event.Namespace, event.Timestamp) {
+				if err := encoder.Encode(map[string]interface{}{"type": "feedback", "event": event}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"type", "timestamp", "namespace", "query_id", "query", "user_hash", "results", "top_score", "zero_hit", "latency_ms", "document_id", "action", "rank"})
+		for _, e := range queries {
+			if filter.matches(e.Namespace, e.Timestamp) {
+				writer.Write([]string{"query", e.Timestamp.Format(time.RFC3339), e.Namespace, e.ID, e.Query, e.UserHash,
+					strconv.Itoa(e.Results), strconv.FormatFloat(e.TopScore, 'f', 4, 64), strconv.FormatBool(e.ZeroHit),
+					strconv.FormatInt(e.LatencyMS, 10), "", "", ""})
+			}
+		}
+		for _, e := range feedback {
+			if filter.matches(e.Namespace, e.Timestamp) {
+				writer.Write([]string{"feedback", e.Timestamp.Format(time.RFC3339), e.Namespace, e.QueryID, e.Query, e.UserHash,
+					"", "", "", "", e.DocumentID, e.Action, strconv.Itoa(e.Rank)})
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("unsupported export format %q (use jsonl or csv)", format)
+	}
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// noisyRatio is ratio for independently noised counts, which can put n above total
+func noisyRatio(n, total int) float64 {
+	return min(ratio(n, total), 1)
+}
+
+func topCounts(counts map[string]int, n int) []QueryCount {
+	result := make([]QueryCount, 0, len(counts))
+	for query, count := range counts {
+		result = append(result, QueryCount{Query: query, Count: count})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Query < result[j].Query
+	})
+
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
@@ -0,0 +1,195 @@
+package analytics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"liberation-anonymize"
+)
+
+// recordActivity records three users searching docs and one searching wiki, an hour apart
+func recordActivity(t *testing.T) (*Recorder, *time.Time) {
+	t.Helper()
+	r, now := testRecorder(DefaultConfig())
+	search := func(namespace, user, query string, scores ...float64) string {
+		id := r.RecordSearch(namespace, user, query, scored(scores...))
+		*now = now.Add(time.Minute)
+		return id
+	}
+	ladder := search("docs", "key-1", "ladder", 0.9)
+	search("docs", "key-2", "ladder", 0.8)
+	search("docs", "key-3", "ladder", 0.1)
+	drill := search("docs", "key-1", "drill", 0.2)
+	*now = now.Add(time.Hour)
+	search("wiki", "key-4", "seed swap", 0.7)
+
+	for _, feedback := range []FeedbackRequest{
+		{QueryID: ladder, DocumentID: "a", Action: ActionClick},
+		{QueryID: ladder, DocumentID: "b", Action: ActionSelect},
+		{QueryID: drill, DocumentID: "c", Action: ActionThumbsDown},
+	} {
+		if _, err := r.RecordFeedback("key-1", feedback); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r, now
+}
+
+func TestReport(t *testing.T) {
+	r, _ := recordActivity(t)
+	report := r.Report(Filter{}, 1)
+
+	if report.TotalQueries != 5 || report.UniqueQueries != 3 || report.UniqueUsers != 4 || report.ZeroHitQueries != 2 {
+		t.Errorf("report %+v", report)
+	}
+	if report.ZeroHitRate != 0.4 || report.ClickThroughRate != 0.2 {
+		t.Errorf("rates %v and %v, want 0.4 and 0.2", report.ZeroHitRate, report.ClickThroughRate)
+	}
+	if !reflect.DeepEqual(report.TopQueries, []QueryCount{{"ladder", 3}}) {
+		t.Errorf("top queries %v", report.TopQueries)
+	}
+	// Ties are broken by the query text
+	if !reflect.DeepEqual(report.TopZeroHitQueries, []QueryCount{{"drill", 1}}) {
+		t.Errorf("top zero-hit queries %v", report.TopZeroHitQueries)
+	}
+	if !reflect.DeepEqual(report.FeedbackByAction, map[string]int{ActionClick: 1, ActionSelect: 1, ActionThumbsDown: 1}) {
+		t.Errorf("feedback %v", report.FeedbackByAction)
+	}
+	docs := report.ByNamespace["docs"]
+	if docs == nil || docs.Queries != 4 || docs.Feedback != 3 || docs.ClickThroughRate != 0.25 || docs.AvgLatencyMS != 12 {
+		t.Errorf("docs stats %+v", docs)
+	}
+
+	wiki := r.Report(Filter{Namespace: "wiki"}, 0)
+	if wiki.TotalQueries != 1 || len(wiki.ByNamespace) != 1 || wiki.FeedbackByAction[ActionClick] != 0 {
+		t.Errorf("wiki report %+v", wiki)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	filter, err := ParseFilter("docs", "2026-03-01", "2026-03-01T12:02:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.From != time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC) || filter.To != time.Date(2026, 3, 1, 12, 2, 0, 0, time.UTC) {
+		t.Errorf("filter %+v", filter)
+	}
+	for _, times := range [][2]string{{"yesterday", ""}, {"", "2026-13-01"}} {
+		if _, err := ParseFilter("", times[0], times[1]); err == nil {
+			t.Errorf("ParseFilter accepted %v", times)
+		}
+	}
+
+	// To is exclusive: the two searches at 12:00 and 12:01 match
+	r, _ := recordActivity(t)
+	if report := r.Report(filter, 0); report.TotalQueries != 2 || report.UniqueUsers != 2 {
+		t.Errorf("filtered report %+v", report)
+	}
+}
+
+func TestExport(t *testing.T) {
+	r, _ := recordActivity(t)
+
+	var jsonl bytes.Buffer
+	if err := r.Export(&jsonl, Filter{Namespace: "docs"}, "jsonl"); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]int{}
+	scanner := bufio.NewScanner(&jsonl)
+	for scanner.Scan() {
+		var line struct {
+			Type  string          `json:"type"`
+			Event json.RawMessage `json:"event"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		types[line.Type]++
+	}
+	if types["query"] != 4 || types["feedback"] != 3 {
+		t.Errorf("jsonl export has %v", types)
+	}
+
+	var out bytes.Buffer
+	if err := r.Export(&out, Filter{Namespace: "wiki"}, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0][0] != "type" || rows[1][0] != "query" || rows[1][4] != "seed swap" {
+		t.Errorf("csv export %v", rows)
+	}
+
+	if err := r.Export(&out, Filter{}, "xml"); err == nil || !strings.Contains(err.Error(), "xml") {
+		t.Errorf("unsupported format: %v", err)
+	}
+}
+
+func TestAnonymizedExport(t *testing.T) {
+	r, _ := recordActivity(t)
+	export, err := anonymize.New(anonymize.Config{K: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := r.ExportAnonymized(&out, Filter{}, "csv", export); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := map[string]int{}
+	for _, row := range rows[1:] {
+		if row[2] != "docs" {
+			t.Errorf("wiki, searched by one user, was exported: %v", row)
+		}
+		if !strings.HasSuffix(row[1], ":00:00Z") {
+			t.Errorf("timestamp %s is not truncated to the hour", row[1])
+		}
+		if row[0] == "query" {
+			queries[row[4]]++
+		}
+		for _, query := range r.queries {
+			if row[3] == query.ID || row[5] == query.UserHash {
+				t.Errorf("row %v keeps an identifier", row)
+			}
+		}
+	}
+	// drill was searched by one user, so its text is withheld
+	if !reflect.DeepEqual(queries, map[string]int{"ladder": 3, "": 1}) {
+		t.Errorf("exported queries %v", queries)
+	}
+}
+
+func TestAnonymizedReport(t *testing.T) {
+	r, _ := recordActivity(t)
+	export, err := anonymize.New(anonymize.Config{K: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := r.AnonymizedReport(Filter{}, 10, export)
+
+	// Without noise the counts are exact; only rare rows are left out
+	if report.TotalQueries != 5 || report.ClickThroughRate != 0.2 {
+		t.Errorf("report %+v", report)
+	}
+	if !reflect.DeepEqual(report.TopQueries, []QueryCount{{"ladder", 3}}) || len(report.TopZeroHitQueries) != 1 {
+		t.Errorf("top queries %v, zero-hit %v", report.TopQueries, report.TopZeroHitQueries)
+	}
+	if _, ok := report.ByNamespace["wiki"]; ok || report.ByNamespace["docs"] == nil {
+		t.Errorf("namespaces %v", report.ByNamespace)
+	}
+	if report.Anonymization == nil {
+		t.Error("no anonymization manifest")
+	}
+}
@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"liberation-storage"
+
+	"liberation-ai/pkg/liberation"
+)
+
+// Config controls archiving of raw ingestion batches to object storage
+type Config struct {
+	storage.Config `yaml:",inline"`
+	Prefix         string `yaml:"prefix"`
+	RetentionDays  int    `yaml:"retention_days"`
+}
+
+// DefaultConfig leaves archiving disabled until a backend is configured
+func DefaultConfig() Config {
+	return Config{
+		Prefix:        "ingest",
+		RetentionDays: 30,
+	}
+}
+
+// Batch is the archived form of a POST /v1/documents request
+type Batch struct {
+	ID         string                `json:"id"`
+	Namespace  string                `json:"namespace"`
+	ReceivedAt time.Time             `json:"received_at"`
+	Documents  []liberation.Document `json:"documents"`
+}
+
+// Archiver writes raw document batches to object storage so namespaces can be
+// re-embedded later without asking clients to upload again
+type Archiver struct {
+	store  storage.Store
+	config Config
+	now    func() time.Time
+}
+
+// New creates an archiver; a nil archiver is returned when no backend is configured
+func New(config Config) (*Archiver, error) {
+	if !config.Enabled() {
+		return nil, nil
+	}
+	store, err := storage.New(config.Config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Prefix == "" {
+		config.Prefix = DefaultConfig().Prefix
+	}
+	return &Archiver{store: store, config: config, now: time.Now}, nil
+}
+
+// Enabled reports whether batches are being archived
+func (a *Archiver) Enabled() bool {
+	return a != nil
+}
+
+// Backend names the storage backend in use
+func (a *Archiver) Backend() string {
+	if a == nil {
+		return ""
+	}
+	return a.store.Backend()
+}
+
+// Archive stores a batch under prefix/namespace/yyyy/mm/dd/id.json and returns its key
+func (a *Archiver) Archive(ctx context.Context, namespace string, docs []liberation.Document) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+
+	batch := Batch{
+		ID:         randomID(),
+		Namespace:  namespace,
+		ReceivedAt: a.now().UTC(),
+		Documents:  docs,
+	}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s.json", strings.Trim(a.config.Prefix, "/"), namespace, batch.ReceivedAt.Format("2006/01/02"), batch.ID)
+	_, err = a.store.Put(ctx, key, bytes.NewReader(data), storage.PutOptions{
+		ContentType: "application/json",
+		Metadata: map[string]string{
+			"namespace": namespace,
+			"documents": fmt.Sprintf("%d", len(docs)),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to archive ingestion batch: %w", err)
+	}
+	return key, nil
+}
+
+// Expires reports whether archived batches are cleaned up after the retention period
+func (a *Archiver) Expires() bool {
+	return a != nil && a.config.RetentionDays > 0
+}
+
+// Cleanup is the archive_cleanup job: it deletes archived batches older than the retention
+// period once
+func (a *Archiver) Cleanup(ctx context.Context) (string, error) {
+	rules := []storage.LifecycleRule{{
+		Prefix: strings.Trim(a.config.Prefix, "/") + "/",
+		MaxAge: time.Duration(a.config.RetentionDays) * 24 * time.Hour,
+	}}
+	removed, err := storage.NewJanitor(a.store, rules, storage.LifecycleHooks{}).Sweep(ctx)
+	return fmt.Sprintf("removed %d archived batches", removed), err
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"liberation-storage"
+
+	"liberation-ai/pkg/liberation"
+)
+
+func testArchiver(t *testing.T, prefix string) (*Archiver, string) {
+	t.Helper()
+	root := t.TempDir()
+	config := DefaultConfig()
+	config.Backend, config.Root, config.Prefix = "local", root, prefix
+	a, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return time.Date(2026, 3, 1, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*3600)) }
+	return a, root
+}
+
+func TestDisabled(t *testing.T) {
+	a, err := New(DefaultConfig())
+	if err != nil || a != nil {
+		t.Fatalf("New without a backend: %v, %v", a, err)
+	}
+	key, err := a.Archive(context.Background(), "docs", []liberation.Document{{ID: "a"}})
+	if key != "" || err != nil || a.Enabled() || a.Expires() || a.Backend() != "" {
+		t.Errorf("a nil archiver archived to %q, %v", key, err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	a, root := testArchiver(t, "/ingest/")
+	if !a.Enabled() || a.Backend() != "local" || !a.Expires() {
+		t.Errorf("archiver enabled %v, backend %q", a.Enabled(), a.Backend())
+	}
+	docs := []liberation.Document{{ID: "a", Title: "Ladder", Content: "lend it"}, {ID: "b", Content: "seed swap"}}
+	key, err := a.Archive(context.Background(), "docs", docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Batches are filed by the day they were received in UTC
+	if !regexp.MustCompile(`^ingest/docs/2026/03/02/[0-9a-f]{24}\.json$`).MatchString(key) {
+		t.Errorf("archived as %q", key)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch Batch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch.Namespace != "docs" || len(batch.Documents) != 2 || batch.Documents[0].Title != "Ladder" || !strings.HasSuffix(key, batch.ID+".json") {
+		t.Errorf("archived batch %+v", batch)
+	}
+	object, err := a.store.Stat(context.Background(), key)
+	if err != nil || object.Metadata["namespace"] != "docs" || object.Metadata["documents"] != "2" {
+		t.Errorf("object %+v, %v", object, err)
+	}
+
+	// Every batch gets its own key
+	if again, _ := a.Archive(context.Background(), "docs", docs); again == key {
+		t.Error("two batches archived under one key")
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	a, root := testArchiver(t, "ingest")
+	ctx := context.Background()
+	old, err := a.Archive(ctx, "docs", []liberation.Document{{ID: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := a.Archive(ctx, "docs", []liberation.Document{{ID: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.store.Put(ctx, "exports/report.json", strings.NewReader("{}"), storage.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	longAgo := time.Now().AddDate(0, 0, -31)
+	for _, key := range []string{old, "exports/report.json"} {
+		if err := os.Chtimes(filepath.Join(root, filepath.FromSlash(key)), longAgo, longAgo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err := a.Cleanup(ctx)
+	if err != nil || summary != "removed 1 archived batches" {
+		t.Errorf("Cleanup: %q, %v", summary, err)
+	}
+	for key, kept := range map[string]bool{old: false, recent: true, "exports/report.json": true} {
+		if _, err := a.store.Stat(ctx, key); (err == nil) != kept {
+			t.Errorf("%s kept %v after cleanup: %v", key, !kept, err)
+		}
+	}
+}
@@ -0,0 +1,533 @@
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"liberation-ai/pkg/liberation"
+)
+
+// Actions taken when a namespace has spent its monthly budget
+const (
+	// ActionDowngrade embeds with the cheaper downgrade model until the hard limit
+	ActionDowngrade = "downgrade"
+	// ActionReadOnly rejects writes; searches keep working
+	ActionReadOnly = "read_only"
+)
+
+// Modes a namespace can be in
+const (
+	ModeNormal     = "normal"
+	ModeDowngraded = "downgraded"
+	ModeReadOnly   = "read_only"
+)
+
+// ErrReadOnly is returned when a write would embed into a namespace that has exhausted its budget
+var ErrReadOnly = errors.New("namespace budget exhausted")
+
+// ErrInvalidBudget is returned for budgets that cannot be enforced as written
+var ErrInvalidBudget = errors.New("invalid budget")
+
+// Model prices an embedding model
+type Model struct {
+	Name                 string  `yaml:"name" json:"name"`
+	CostPerMillionTokens float64 `yaml:"cost_per_million_tokens" json:"cost_per_million_tokens"`
+}
+
+// WebhookConfig posts alerts as JSON, signed with X-Signature-256 when a secret is set
+type WebhookConfig struct {
+	URL    string `yaml:"url" json:"url"`
+	Secret string `yaml:"secret" json:"-"`
+}
+
+// EmailConfig sends alerts to each budget's notify_emails over SMTP
+type EmailConfig struct {
+	SMTPAddr string `yaml:"smtp_addr" json:"smtp_addr"`
+	From     string `yaml:"from" json:"from"`
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"-"`
+}
+
+// Config controls per-namespace embedding budgets
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Models prices the embedding models in use, in US dollars
+	Models []Model `yaml:"models" json:"models"`
+	// DefaultModel is used until a namespace is downgraded; it defaults to the first model
+	DefaultModel string `yaml:"default_model" json:"default_model"`
+	// DowngradeModel is used by namespaces past their budget with on_exhaustion: downgrade
+	DowngradeModel string `yaml:"downgrade_model" json:"downgrade_model"`
+	// Thresholds are the percentages of a budget at which owners are alerted
+	Thresholds []int `yaml:"thresholds" json:"thresholds"`
+	// OnExhaustion is the default action for budgets that do not set one
+	OnExhaustion string `yaml:"on_exhaustion" json:"on_exhaustion"`
+	// HardLimitPercent makes a downgraded namespace read-only once spend reaches it
+	HardLimitPercent int           `yaml:"hard_limit_percent" json:"hard_limit_percent"`
+	Webhook          WebhookConfig `yaml:"webhook" json:"webhook"`
+	Email            EmailConfig   `yaml:"email" json:"email"`
+	// Namespaces seeds budgets at startup; owners and admins can change them at runtime
+	Namespaces map[string]Budget `yaml:"namespaces" json:"-"`
+}
+
+// DefaultConfig leaves budgets off, with the usual 50/80/100% alerts once enabled
+func DefaultConfig() Config {
+	return Config{
+		Models:           []Model{{Name: "all-MiniLM-L6-v2"}},
+		Thresholds:       []int{50, 80, 100},
+		OnExhaustion:     ActionDowngrade,
+		HardLimitPercent: 150,
+	}
+}
+
+// ApplyEnv reads the webhook secret and SMTP password from the environment, so they need
+// not live in the config file
+func (c *Config) ApplyEnv(prefix string) {
+	if secret := os.Getenv(prefix + "WEBHOOK_SECRET"); secret != "" {
+		c.Webhook.Secret = secret
+	}
+	if password := os.Getenv(prefix + "SMTP_PASSWORD"); password != "" {
+		c.Email.Password = password
+	}
+}
+
+// Validate reports settings that would make budgets unenforceable
+func (c Config) Validate() error {
+	prices := make(map[string]bool, len(c.Models))
+	for _, model := range c.Models {
+		if model.Name == "" || model.CostPerMillionTokens < 0 {
+			return fmt.Errorf("models need a name and a non-negative cost_per_million_tokens")
+		}
+		prices[model.Name] = true
+	}
+	if c.DefaultModel != "" && !prices[c.DefaultModel] {
+		return fmt.Errorf("default_model %s is not listed under models", c.DefaultModel)
+	}
+	if c.DowngradeModel != "" && !prices[c.DowngradeModel] {
+		return fmt.Errorf("downgrade_model %s is not listed under models", c.DowngradeModel)
+	}
+	if c.OnExhaustion != ActionDowngrade && c.OnExhaustion != ActionReadOnly {
+		return fmt.Errorf("on_exhaustion must be %s or %s", ActionDowngrade, ActionReadOnly)
+	}
+	if c.HardLimitPercent < 100 {
+		return fmt.Errorf("hard_limit_percent must be at least 100")
+	}
+	return validThresholds(c.Thresholds)
+}
+
+func validThresholds(thresholds []int) error {
+	for _, threshold := range thresholds {
+		if threshold < 1 || threshold > 1000 {
+			return fmt.Errorf("thresholds must be percentages between 1 and 1000")
+		}
+	}
+	return nil
+}
+
+// Budget is a namespace's monthly embedding allowance
+type Budget struct {
+	// MonthlyLimit is in US dollars and resets at the start of each UTC month
+	MonthlyLimit float64 `yaml:"monthly_limit" json:"monthly_limit"`
+	// Thresholds overrides the configured alert percentages
+	Thresholds []int `yaml:"thresholds" json:"thresholds,omitempty"`
+	// OnExhaustion overrides the configured action
+	OnExhaustion string   `yaml:"on_exhaustion" json:"on_exhaustion,omitempty"`
+	NotifyEmails []string `yaml:"notify_emails" json:"notify_emails,omitempty"`
+	// Owner is the rate limit identity that manages the budget; empty leaves it to admins
+	Owner string `yaml:"owner" json:"-"`
+}
+
+// Status is a budget together with this month's spend
+type Status struct {
+	Namespace string `json:"namespace"`
+	*Budget
+	Period string  `json:"period"`
+	Spent  float64 `json:"spent"`
+	Tokens int64   `json:"tokens"`
+	// Embeddings counts texts embedded, documents and queries alike
+	Embeddings  int64     `json:"embeddings"`
+	PercentUsed float64   `json:"percent_used,omitempty"`
+	Alerted     []int     `json:"alerted,omitempty"`
+	Mode        string    `json:"mode"`
+	Model       string    `json:"model"`
+	ResetsAt    time.Time `json:"resets_at"`
+}
+
+// Alert is sent when a namespace crosses one of its thresholds
+type Alert struct {
+	Namespace string    `json:"namespace"`
+	Period    string    `json:"period"`
+	Threshold int       `json:"threshold"`
+	Spent     float64   `json:"spent"`
+	Limit     float64   `json:"limit"`
+	Mode      string    `json:"mode"`
+	Model     string    `json:"model"`
+	Emails    []string  `json:"-"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+// spend tracks one namespace's consumption during a calendar month
+type spend struct {
+	period     string
+	cost       float64
+	tokens     int64
+	embeddings int64
+	alerted    map[int]bool
+}
+
+// Manager meters embedding spend per namespace in memory and enforces budgets
+type Manager struct {
+	mu       sync.Mutex
+	config   Config
+	prices   map[string]float64
+	budgets  map[string]*Budget
+	spend    map[string]*spend
+	notifier Notifier
+	now      func() time.Time
+	// modelChanged is told about namespaces whose model changed; see OnModelChange
+	modelChanged func(namespace string)
+}
+
+// NewManager creates a manager, seeding the budgets listed in the config
+func NewManager(config Config, notifier Notifier) *Manager {
+	prices := make(map[string]float64, len(config.Models))
+	for _, model := range config.Models {
+		prices[model.Name] = model.CostPerMillionTokens
+	}
+	if config.DefaultModel == "" && len(config.Models) > 0 {
+		config.DefaultModel = config.Models[0].Name
+	}
+	if config.HardLimitPercent < 100 {
+		config.HardLimitPercent = DefaultConfig().HardLimitPercent
+	}
+
+	budgets := make(map[string]*Budget, len(config.Namespaces))
+	for namespace, budget := range config.Namespaces {
+		budget := budget
+		budgets[namespace] = &budget
+	}
+
+	return &Manager{
+		config:   config,
+		prices:   prices,
+		budgets:  budgets,
+		spend:    make(map[string]*spend),
+		notifier: notifier,
+		now:      time.Now,
+	}
+}
+
+// OnModelChange calls changed, outside the manager's lock, whenever a charge or a budget
+// change moves a namespace to another model, so caches of Model can be invalidated. A new
+// month lifts downgrades without a call; such caches must also expire.
+func (m *Manager) OnModelChange(changed func(namespace string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.modelChanged = changed
+}
+
+// modelChange returns the call to make after a namespace's model moved from before to its
+// current model, or nil. The caller holds m.mu.
+func (m *Manager) modelChange(namespace, before string) func() {
+	if m.modelChanged == nil || m.model(m.budgets[namespace], m.current(namespace, m.now())) == before {
+		return nil
+	}
+	changed := m.modelChanged
+	return func() { changed(namespace) }
+}
+
+// Enabled reports whether spend is metered and budgets enforced
+func (m *Manager) Enabled() bool {
+	return m.config.Enabled
+}
+
+// EstimateTokens approximates a model's token count for text at four bytes of UTF-8 per token
+func EstimateTokens(text string) int64 {
+	return int64(max((len(text)+3)/4, 1))
+}
+
+// DocumentTokens estimates the tokens embedded for a batch of documents
+func DocumentTokens(docs []liberation.Document) int64 {
+	var tokens int64
+	for _, doc := range docs {
+		tokens += EstimateTokens(doc.Title + " " + doc.Content)
+	}
+	return tokens
+}
+
+// Charge records embedding spend for a namespace. Writes into a read-only namespace are
+// refused with ErrReadOnly and not charged; reads are always charged.
+func (m *Manager) Charge(namespace string, tokens, embeddings int64, write bool) error {
+	m.mu.Lock()
+
+	now := m.now()
+	budget := m.budgets[namespace]
+	usage := m.current(namespace, now)
+	if write && m.mode(budget, usage) == ModeReadOnly {
+		m.mu.Unlock()
+		return ErrReadOnly
+	}
+
+	model := m.model(budget, usage)
+	usage.cost += float64(tokens) * m.prices[model] / 1e6
+	usage.tokens += tokens
+	usage.embeddings += embeddings
+
+	alert := m.dueAlert(namespace, budget, usage, now)
+	changed := m.modelChange(namespace, model)
+	m.mu.Unlock()
+
+	if changed != nil {
+		changed()
+	}
+	if alert != nil && m.notifier != nil {
+		go m.notifier.Notify(*alert)
+	}
+	return nil
+}
+
+// Cost prices tokens embedded with model, in US dollars; "" is the default model
+func (m *Manager) Cost(model string, tokens int64) float64 {
+	if model == "" {
+		model = m.config.DefaultModel
+	}
+	return float64(tokens) * m.prices[model] / 1e6
+}
+
+// Model returns the embedding model a namespace currently uses
+func (m *Manager) Model(namespace string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.model(m.budgets[namespace], m.current(namespace, m.now()))
+}
+
+// CanManage reports whether identity may change the namespace's budget: its owner may,
+// and anyone with an API key may create a budget for a namespace that has none
+func (m *Manager) CanManage(namespace, identity string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	budget := m.budgets[namespace]
+	if budget == nil {
+		return strings.HasPrefix(identity, "key:")
+	}
+	return budget.Owner != "" && budget.Owner == identity
+}
+
+// Status returns the namespace's budget, if any, and this month's spend
+func (m *Manager) Status(namespace string) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.status(namespace, m.now())
+}
+
+// All returns the status of every namespace with a budget or spend this month
+func (m *Manager) All() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	namespaces := make(map[string]bool)
+	for namespace := range m.budgets {
+		namespaces[namespace] = true
+	}
+	for namespace := range m.spend {
+		namespaces[namespace] = true
+	}
+
+	result := make([]Status, 0, len(namespaces))
+	for namespace := range namespaces {
+		result = append(result, m.status(namespace, now))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Namespace < result[j].Namespace
+	})
+	return result
+}
+
+// Put creates or replaces a namespace's budget. Alerts above the new usage are re-armed,
+// so raising a limit also lifts a downgrade or read-only mode.
+func (m *Manager) Put(namespace string, budget Budget) (Status, error) {
+	if budget.MonthlyLimit <= 0 {
+		return Status{}, fmt.Errorf("%w: monthly_limit must be positive", ErrInvalidBudget)
+	}
+	if budget.OnExhaustion != "" && budget.OnExhaustion != ActionDowngrade && budget.OnExhaustion != ActionReadOnly {
+		return Status{}, fmt.Errorf("%w: on_exhaustion must be %s or %s", ErrInvalidBudget, ActionDowngrade, ActionReadOnly)
+	}
+	if err := validThresholds(budget.Thresholds); err != nil {
+		return Status{}, fmt.Errorf("%w: %v", ErrInvalidBudget, err)
+	}
+
+	m.mu.Lock()
+	now := m.now()
+	before := m.model(m.budgets[namespace], m.current(namespace, now))
+	m.budgets[namespace] = &budget
+	usage := m.current(namespace, now)
+	percent := usage.cost / budget.MonthlyLimit * 100
+	for threshold := range usage.alerted {
+		if float64(threshold) > percent {
+			delete(usage.alerted, threshold)
+		}
+	}
+	status := m.status(namespace, now)
+	changed := m.modelChange(namespace, before)
+	m.mu.Unlock()
+
+	if changed != nil {
+		changed()
+	}
+	return status, nil
+}
+
+// Delete removes a namespace's budget; its spend is still metered
+func (m *Manager) Delete(namespace string) bool {
+	m.mu.Lock()
+	before := m.model(m.budgets[namespace], m.current(namespace, m.now()))
+	_, exists := m.budgets[namespace]
+	delete(m.budgets, namespace)
+	changed := m.modelChange(namespace, before)
+	m.mu.Unlock()
+
+	if changed != nil {
+		changed()
+	}
+	return exists
+}
+
+// MonthToDate returns this month's spend across all namespaces and the fraction of the month gone
+func (m *Manager) MonthToDate() (float64, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var total float64
+	for namespace := range m.spend {
+		total += m.current(namespace, now).cost
+	}
+	start := monthStart(now)
+	elapsed := now.Sub(start).Seconds() / start.AddDate(0, 1, 0).Sub(start).Seconds()
+	return total, elapsed
+}
+
+func monthStart(now time.Time) time.Time {
+	year, month, _ := now.UTC().Date()
+	return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// current returns this month's counters for a namespace, rolling them over at the month boundary
+func (m *Manager) current(namespace string, now time.Time) *spend {
+	period := now.UTC().Format("2006-01")
+	usage := m.spend[namespace]
+	if usage == nil || usage.period != period {
+		usage = &spend{period: period, alerted: make(map[int]bool)}
+		m.spend[namespace] = usage
+	}
+	return usage
+}
+
+func (m *Manager) action(budget *Budget) string {
+	action := budget.OnExhaustion
+	if action == "" {
+		action = m.config.OnExhaustion
+	}
+	// Without a model to downgrade to, the only way to stop spending is to stop writing
+	if action == ActionDowngrade && m.config.DowngradeModel == "" {
+		return ActionReadOnly
+	}
+	return action
+}
+
+func (m *Manager) mode(budget *Budget, usage *spend) string {
+	if budget == nil || budget.MonthlyLimit <= 0 || !m.config.Enabled {
+		return ModeNormal
+	}
+	percent := usage.cost / budget.MonthlyLimit * 100
+	switch {
+	case percent < 100:
+		return ModeNormal
+	case m.action(budget) == ActionReadOnly, percent >= float64(m.config.HardLimitPercent):
+		return ModeReadOnly
+	default:
+		return ModeDowngraded
+	}
+}
+
+func (m *Manager) model(budget *Budget, usage *spend) string {
+	if m.mode(budget, usage) == ModeDowngraded {
+		return m.config.DowngradeModel
+	}
+	return m.config.DefaultModel
+}
+
+func (m *Manager) thresholds(budget *Budget) []int {
+	thresholds := budget.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = m.config.Thresholds
+	}
+	if m.action(budget) == ActionDowngrade {
+		thresholds = append(append([]int(nil), thresholds...), m.config.HardLimitPercent)
+	}
+	return thresholds
+}
+
+// dueAlert marks every threshold the namespace has newly crossed and returns an alert for
+// the highest, so one large batch does not send a burst of alerts
+func (m *Manager) dueAlert(namespace string, budget *Budget, usage *spend, now time.Time) *Alert {
+	if budget == nil || budget.MonthlyLimit <= 0 || !m.config.Enabled {
+		return nil
+	}
+	percent := usage.cost / budget.MonthlyLimit * 100
+	crossed := 0
+	for _, threshold := range m.thresholds(budget) {
+		if percent >= float64(threshold) && !usage.alerted[threshold] {
+			usage.alerted[threshold] = true
+			crossed = max(crossed, threshold)
+		}
+	}
+	if crossed == 0 {
+		return nil
+	}
+	return &Alert{
+		Namespace: namespace,
+		Period:    usage.period,
+		Threshold: crossed,
+		Spent:     usage.cost,
+		Limit:     budget.MonthlyLimit,
+		Mode:      m.mode(budget, usage),
+		Model:     m.model(budget, usage),
+		Emails:    append([]string(nil), budget.NotifyEmails...),
+		SentAt:    now,
+	}
+}
+
+func (m *Manager) status(namespace string, now time.Time) Status {
+	budget := m.budgets[namespace]
+	usage := m.current(namespace, now)
+	status := Status{
+		Namespace:  namespace,
+		Period:     usage.period,
+		Spent:      usage.cost,
+		Tokens:     usage.tokens,
+		Embeddings: usage.embeddings,
+		Alerted:    []int{},
+		Mode:       m.mode(budget, usage),
+		Model:      m.model(budget, usage),
+		ResetsAt:   monthStart(now).AddDate(0, 1, 0),
+	}
+	if budget != nil {
+		copied := *budget
+		status.Budget = &copied
+		status.PercentUsed = usage.cost / budget.MonthlyLimit * 100
+	}
+	for threshold := range usage.alerted {
+		status.Alerted = append(status.Alerted, threshold)
+	}
+	sort.Ints(status.Alerted)
+	return status
+}
@@ -0,0 +1,253 @@
+package budget
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"liberation-ai/pkg/liberation"
+)
+
+// alerts collects every alert the manager sends
+type alerts chan Alert
+
+func (a alerts) Notify(alert Alert) {
+	a <- alert
+}
+
+func (a alerts) next(t *testing.T) Alert {
+	t.Helper()
+	select {
+	case alert := <-a:
+		return alert
+	case <-time.After(time.Second):
+		t.Fatal("no alert sent")
+		return Alert{}
+	}
+}
+
+func (a alerts) none(t *testing.T) {
+	t.Helper()
+	select {
+	case alert := <-a:
+		t.Fatalf("unexpected alert %+v", alert)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// testManager prices "large" at $10 and "small" at $1 per million tokens, so 100,000
+// tokens spend a $1 budget
+func testManager(t *testing.T, config Config) (*Manager, alerts, *time.Time) {
+	t.Helper()
+	config.Enabled = true
+	config.Models = []Model{{Name: "large", CostPerMillionTokens: 10}, {Name: "small", CostPerMillionTokens: 1}}
+	if config.OnExhaustion == "" {
+		config.OnExhaustion = ActionDowngrade
+	}
+	if config.HardLimitPercent == 0 {
+		config.HardLimitPercent = 150
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sent := make(alerts, 10)
+	m := NewManager(config, sent)
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	return m, sent, &now
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		valid  bool
+	}{
+		{"default", func(c *Config) {}, true},
+		{"unnamed model", func(c *Config) { c.Models = append(c.Models, Model{CostPerMillionTokens: 1}) }, false},
+		{"negative price", func(c *Config) { c.Models[0].CostPerMillionTokens = -1 }, false},
+		{"unknown default model", func(c *Config) { c.DefaultModel = "ada" }, false},
+		{"unknown downgrade model", func(c *Config) { c.DowngradeModel = "ada" }, false},
+		{"listed downgrade model", func(c *Config) { c.DowngradeModel = c.Models[0].Name }, true},
+		{"unknown action", func(c *Config) { c.OnExhaustion = "ignore" }, false},
+		{"hard limit under 100", func(c *Config) { c.HardLimitPercent = 99 }, false},
+		{"threshold 0", func(c *Config) { c.Thresholds = []int{0, 50} }, false},
+		{"threshold over 1000", func(c *Config) { c.Thresholds = []int{1001} }, false},
+	} {
+		config := DefaultConfig()
+		tc.change(&config)
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	for text, want := range map[string]int64{"": 1, "abc": 1, "abcd": 1, "abcde": 2, "ü": 1, strings.Repeat("x", 400): 100} {
+		if got := EstimateTokens(text); got != want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", text, got, want)
+		}
+	}
+	if got := DocumentTokens([]liberation.Document{{Title: "Ladder", Content: "lend"}, {Content: "seed swap"}}); got != 6 {
+		t.Errorf("DocumentTokens = %d, want 6", got)
+	}
+}
+
+func TestDowngradeThenReadOnly(t *testing.T) {
+	m, sent, _ := testManager(t, Config{DowngradeModel: "small", Thresholds: []int{50, 80, 100}})
+	var changed []string
+	m.OnModelChange(func(namespace string) { changed = append(changed, namespace) })
+	if _, err := m.Put("docs", Budget{MonthlyLimit: 1, NotifyEmails: []string{"owner@example.org"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Charge("docs", 50_000, 5, true); err != nil {
+		t.Fatal(err)
+	}
+	if alert := sent.next(t); alert.Threshold != 50 || alert.Mode != ModeNormal || alert.Model != "large" || alert.Period != "2026-03" || alert.Emails[0] != "owner@example.org" {
+		t.Errorf("first alert %+v", alert)
+	}
+	m.Charge("docs", 1_000, 1, false)
+	sent.none(t)
+
+	// One batch across two thresholds alerts once, for the higher
+	if err := m.Charge("docs", 60_000, 6, true); err != nil {
+		t.Fatal(err)
+	}
+	if alert := sent.next(t); alert.Threshold != 100 || alert.Mode != ModeDowngraded || alert.Model != "small" {
+		t.Errorf("exhaustion alert %+v", alert)
+	}
+	sent.none(t)
+	if m.Model("docs") != "small" || !slices.Equal(changed, []string{"docs"}) {
+		t.Errorf("model %s, changes %v", m.Model("docs"), changed)
+	}
+
+	// Downgraded spend is priced at the cheaper model, up to the hard limit
+	if err := m.Charge("docs", 500_000, 50, true); err != nil {
+		t.Fatal(err)
+	}
+	if alert := sent.next(t); alert.Threshold != 150 || alert.Mode != ModeReadOnly {
+		t.Errorf("hard limit alert %+v", alert)
+	}
+	status := m.Status("docs")
+	if status.Mode != ModeReadOnly || status.Tokens != 611_000 || status.Embeddings != 62 ||
+		!slices.Equal(status.Alerted, []int{50, 80, 100, 150}) || status.PercentUsed < 160 || status.PercentUsed > 162 {
+		t.Errorf("status %+v", status)
+	}
+
+	// Writes are refused and not charged; reads still are
+	if err := m.Charge("docs", 1_000, 1, true); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("write into a read-only namespace: %v", err)
+	}
+	if err := m.Charge("docs", 1_000, 1, false); err != nil {
+		t.Errorf("read from a read-only namespace: %v", err)
+	}
+	if status := m.Status("docs"); status.Tokens != 612_000 {
+		t.Errorf("%d tokens charged", status.Tokens)
+	}
+}
+
+func TestReadOnlyAction(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config Config
+		budget Budget
+	}{
+		{"configured", Config{DowngradeModel: "small", OnExhaustion: ActionReadOnly}, Budget{MonthlyLimit: 1}},
+		{"per budget", Config{DowngradeModel: "small"}, Budget{MonthlyLimit: 1, OnExhaustion: ActionReadOnly}},
+		{"nothing to downgrade to", Config{}, Budget{MonthlyLimit: 1}},
+	} {
+		m, _, _ := testManager(t, tc.config)
+		m.Put("docs", tc.budget)
+		m.Charge("docs", 100_000, 1, true)
+		if status := m.Status("docs"); status.Mode != ModeReadOnly || status.Model != "large" {
+			t.Errorf("%s: %s with %s at 100%%", tc.name, status.Mode, status.Model)
+		}
+	}
+}
+
+func TestPutAndDelete(t *testing.T) {
+	m, sent, _ := testManager(t, Config{DowngradeModel: "small", OnExhaustion: ActionReadOnly})
+	for _, budget := range []Budget{
+		{},
+		{MonthlyLimit: -1},
+		{MonthlyLimit: 1, OnExhaustion: "ignore"},
+		{MonthlyLimit: 1, Thresholds: []int{0}},
+	} {
+		if _, err := m.Put("docs", budget); !errors.Is(err, ErrInvalidBudget) {
+			t.Errorf("put %+v: %v", budget, err)
+		}
+	}
+
+	m.Put("docs", Budget{MonthlyLimit: 1, Thresholds: []int{50, 100}})
+	m.Charge("docs", 100_000, 1, true)
+	if alert := sent.next(t); alert.Threshold != 100 || alert.Mode != ModeReadOnly {
+		t.Errorf("alert %+v", alert)
+	}
+
+	// Raising the limit lifts read-only mode and re-arms the alerts above the new usage
+	status, err := m.Put("docs", Budget{MonthlyLimit: 4, Thresholds: []int{50, 100}})
+	if err != nil || status.Mode != ModeNormal || !slices.Equal(status.Alerted, []int{}) || status.PercentUsed != 25 {
+		t.Errorf("raised budget %+v, %v", status, err)
+	}
+	m.Charge("docs", 100_000, 1, true)
+	if alert := sent.next(t); alert.Threshold != 50 || alert.Limit != 4 {
+		t.Errorf("re-armed alert %+v", alert)
+	}
+
+	// Without a budget spend is metered but never limited
+	if !m.Delete("docs") || m.Delete("docs") {
+		t.Error("Delete reported the wrong budgets")
+	}
+	m.Charge("docs", 1_000_000, 1, true)
+	sent.none(t)
+	if status := m.Status("docs"); status.Budget != nil || status.Mode != ModeNormal || status.Spent < 11.9 {
+		t.Errorf("unbudgeted status %+v", status)
+	}
+}
+
+func TestMonthlyReset(t *testing.T) {
+	m, _, now := testManager(t, Config{})
+	m.Put("docs", Budget{MonthlyLimit: 1})
+	m.Charge("docs", 100_000, 1, true)
+	m.Charge("wiki", 50_000, 1, false)
+
+	total, elapsed := m.MonthToDate()
+	if total < 1.49 || total > 1.51 || elapsed < 0.62 || elapsed > 0.64 {
+		t.Errorf("month to date: %v spent, %v elapsed", total, elapsed)
+	}
+	if status := m.Status("docs"); status.Mode != ModeReadOnly || !status.ResetsAt.Equal(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("status %+v", status)
+	}
+
+	*now = time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	if err := m.Charge("docs", 1_000, 1, true); err != nil {
+		t.Errorf("write in a new month: %v", err)
+	}
+	if status := m.Status("docs"); status.Period != "2026-04" || status.Tokens != 1_000 || status.Mode != ModeNormal {
+		t.Errorf("new month %+v", status)
+	}
+	if all := m.All(); len(all) != 2 || all[0].Namespace != "docs" || all[1].Namespace != "wiki" || all[1].Spent != 0 {
+		t.Errorf("All %+v", all)
+	}
+}
+
+func TestCanManage(t *testing.T) {
+	m, _, _ := testManager(t, Config{Namespaces: map[string]Budget{"docs": {MonthlyLimit: 1, Owner: "key:abc"}, "wiki": {MonthlyLimit: 1}}})
+	for _, tc := range []struct {
+		namespace, identity string
+		want                bool
+	}{
+		{"docs", "key:abc", true},
+		{"docs", "key:def", false},
+		{"wiki", "key:abc", false},
+		{"new", "key:def", true},
+		{"new", "ip:192.0.2.7", false},
+	} {
+		if got := m.CanManage(tc.namespace, tc.identity); got != tc.want {
+			t.Errorf("%s managing %s: %v", tc.identity, tc.namespace, got)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package budget
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReserveWrite charges an ingestion batch to the namespace's budget.
+// It writes a 403 response and returns false when the namespace is read-only.
+func (m *Manager) ReserveWrite(c *gin.Context, namespace string, tokens, embeddings int64) bool {
+	if err := m.Charge(namespace, tokens, embeddings, true); err != nil {
+		status := m.Status(namespace)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":     "budget_exhausted",
+			"message":   "namespace " + namespace + " has used its monthly embedding budget and is read-only",
+			"resets_at": status.ResetsAt,
+		})
+		c.Abort()
+		return false
+	}
+
+	// Tell clients their documents are going to the cheaper model
+	if status := m.Status(namespace); status.Mode != ModeNormal {
+		c.Header("X-Budget-Mode", status.Mode)
+		c.Header("X-Embedding-Model", status.Model)
+	}
+	return true
+}
+
+// RecordRead charges a query embedding; reads are never refused
+func (m *Manager) RecordRead(namespace string, tokens int64) {
+	m.Charge(namespace, tokens, 1, false)
+}
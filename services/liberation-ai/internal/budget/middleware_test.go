@@ -0,0 +1,41 @@
+package budget
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReserveWrite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m, _, _ := testManager(t, Config{DowngradeModel: "small"})
+	m.notifier = nil
+	m.Put("docs", Budget{MonthlyLimit: 1})
+
+	reserve := func(tokens int64) (*httptest.ResponseRecorder, bool) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		ok := m.ReserveWrite(c, "docs", tokens, 1)
+		return w, ok
+	}
+
+	if w, ok := reserve(50_000); !ok || w.Header().Get("X-Budget-Mode") != "" {
+		t.Errorf("within budget: %v, headers %v", ok, w.Header())
+	}
+	if w, ok := reserve(60_000); !ok || w.Header().Get("X-Budget-Mode") != ModeDowngraded || w.Header().Get("X-Embedding-Model") != "small" {
+		t.Errorf("downgraded: %v, headers %v", ok, w.Header())
+	}
+	reserve(1_000_000)
+	w, ok := reserve(1)
+	if ok || w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"budget_exhausted"`) {
+		t.Errorf("read-only: %v, %d %s", ok, w.Code, w.Body)
+	}
+
+	m.RecordRead("docs", 4)
+	if status := m.Status("docs"); status.Embeddings != 4 || status.Tokens != 1_110_004 {
+		t.Errorf("status %+v", status)
+	}
+}
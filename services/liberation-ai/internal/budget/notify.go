@@ -0,0 +1,125 @@
+package budget
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Notifier delivers budget alerts. Delivery is best effort: failures are logged, not retried.
+type Notifier interface {
+	Notify(alert Alert)
+}
+
+// NewNotifier returns a notifier for every channel configured, or nil when there are none
+func NewNotifier(config Config) Notifier {
+	var notifiers multiNotifier
+	if config.Webhook.URL != "" {
+		notifiers = append(notifiers, &webhookNotifier{
+			config: config.Webhook,
+			client: &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+	if config.Email.SMTPAddr != "" && config.Email.From != "" {
+		notifiers = append(notifiers, &emailNotifier{config: config.Email})
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+	return notifiers
+}
+
+type multiNotifier []Notifier
+
+func (m multiNotifier) Notify(alert Alert) {
+	for _, notifier := range m {
+		notifier.Notify(alert)
+	}
+}
+
+// webhookNotifier posts each alert as JSON with an HMAC-SHA256 signature of the body
+type webhookNotifier struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+func (w *webhookNotifier) Notify(alert Alert) {
+	body, err := json.Marshal(struct {
+		Type string `json:"type"`
+		Alert
+	}{Type: "budget_alert", Alert: alert})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("budget webhook for %s: %v", alert.Namespace, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.config.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		log.Printf("budget webhook for %s: %v", alert.Namespace, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("budget webhook for %s returned %d", alert.Namespace, resp.StatusCode)
+	}
+}
+
+// emailNotifier mails each alert to the budget's notify_emails
+type emailNotifier struct {
+	config EmailConfig
+}
+
+func (e *emailNotifier) Notify(alert Alert) {
+	if len(alert.Emails) == 0 {
+		return
+	}
+
+	var auth smtp.Auth
+	if e.config.Username != "" {
+		host, _, err := net.SplitHostPort(e.config.SMTPAddr)
+		if err != nil {
+			log.Printf("budget email for %s: %v", alert.Namespace, err)
+			return
+		}
+		auth = smtp.PlainAuth("", e.config.Username, e.config.Password, host)
+	}
+
+	if err := smtp.SendMail(e.config.SMTPAddr, auth, e.config.From, alert.Emails, alertMessage(e.config.From, alert)); err != nil {
+		log.Printf("budget email for %s: %v", alert.Namespace, err)
+	}
+}
+
+func alertMessage(from string, alert Alert) []byte {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Namespace %s has used %d%% of its %s budget: $%.2f of $%.2f.\r\n\r\n",
+		alert.Namespace, alert.Threshold, alert.Period, alert.Spent, alert.Limit)
+	switch alert.Mode {
+	case ModeDowngraded:
+		fmt.Fprintf(&body, "New documents are now embedded with %s, a cheaper model, until the budget resets or is raised.\r\n", alert.Model)
+	case ModeReadOnly:
+		body.WriteString("The namespace is now read-only: searches work, but new documents are rejected until the budget resets or is raised.\r\n")
+	}
+
+	return []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [liberation-ai] %s at %d%% of its budget\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		from, strings.Join(alert.Emails, ", "), alert.Namespace, alert.Threshold, body.String()))
+}
@@ -0,0 +1,70 @@
+package budget
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewNotifier(t *testing.T) {
+	if n := NewNotifier(DefaultConfig()); n != nil {
+		t.Errorf("notifier without channels: %#v", n)
+	}
+	config := DefaultConfig()
+	config.Webhook.URL = "http://example.org/hook"
+	config.Email = EmailConfig{SMTPAddr: "mail.example.org:25", From: "budgets@example.org"}
+	if n, ok := NewNotifier(config).(multiNotifier); !ok || len(n) != 2 {
+		t.Errorf("notifier %#v", n)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	w := &webhookNotifier{config: WebhookConfig{URL: server.URL, Secret: "s3cret"}, client: server.Client()}
+	w.Notify(Alert{Namespace: "docs", Threshold: 80, Mode: ModeNormal, Emails: []string{"owner@example.org"}})
+
+	r, body := <-received, <-bodies
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if got := r.Header.Get("X-Signature-256"); got != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature %q", got)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["type"] != "budget_alert" || payload["namespace"] != "docs" || payload["threshold"] != 80.0 || payload["Emails"] != nil || strings.Contains(string(body), "owner@") {
+		t.Errorf("payload %s", body)
+	}
+}
+
+func TestAlertMessage(t *testing.T) {
+	message := string(alertMessage("budgets@example.org", Alert{
+		Namespace: "docs", Period: "2026-03", Threshold: 100, Spent: 1.234, Limit: 1,
+		Mode: ModeDowngraded, Model: "small", Emails: []string{"a@example.org", "b@example.org"},
+	}))
+	for _, want := range []string{
+		"To: a@example.org, b@example.org\r\n",
+		"Subject: [liberation-ai] docs at 100% of its budget\r\n",
+		"$1.23 of $1.00",
+		"embedded with small",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message lacks %q:\n%s", want, message)
+		}
+	}
+}
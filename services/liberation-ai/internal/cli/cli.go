@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"liberation-ai/internal/client"
+)
+
+// Options holds the settings shared by every client subcommand
+type Options struct {
+	Server    string
+	APIKey    string
+	Namespace string
+	JSON      bool
+	Timeout   time.Duration
+}
+
+// registerCommon adds the shared client flags to a flag set
+func registerCommon(fs *flag.FlagSet, opts *Options) {
+	registerClient(fs, opts)
+	fs.StringVar(&opts.Namespace, "namespace", "default", "Namespace to operate on")
+}
+
+// registerClient adds the shared client flags other than the namespace, for commands that
+// do not work on a single namespace
+func registerClient(fs *flag.FlagSet, opts *Options) {
+	fs.StringVar(&opts.Server, "server", getEnv("LIBERATION_AI_URL", "http://localhost:8080"), "Liberation AI server URL (env LIBERATION_AI_URL)")
+	fs.StringVar(&opts.APIKey, "api-key", os.Getenv("LIBERATION_AI_API_KEY"), "API key sent as X-API-Key (env LIBERATION_AI_API_KEY)")
+	fs.BoolVar(&opts.JSON, "json", false, "Print machine-readable JSON instead of text")
+	fs.DurationVar(&opts.Timeout, "timeout", 5*time.Minute, "Overall timeout for the command")
+}
+
+// newClient builds an HTTP client from the shared options
+func (o *Options) newClient() *client.Client {
+	return client.NewClient(o.Server, o.APIKey)
+}
+
+// parseArgs parses flags that may appear before or after positional arguments,
+// so both `ingest --namespace kb ./docs` and `ingest ./docs --namespace kb` work
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// newFlagSet creates a flag set whose usage output lists the command synopsis first
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: liberation-ai %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// printJSON writes v to w as indented JSON
+func printJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// snippet shortens text to at most n runes on a single line
+func snippet(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "…"
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"liberation-ai/internal/doctor"
+)
+
+// RunDoctor checks the configuration and its dependencies and prints suggested fixes
+func RunDoctor(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("doctor", "doctor [flags]")
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "liberation-ai.yml", "Path to configuration file")
+	release := flags.Bool("release", getEnv("GIN_MODE", "") == "release", "Apply production expectations (env GIN_MODE=release)")
+	skipProbes := flags.Bool("skip-probes", false, "Do not write a probe object to storage")
+	asJSON := flags.Bool("json", false, "Print machine-readable JSON instead of text")
+	timeout := flags.Duration("timeout", 30*time.Second, "Overall timeout for the checks")
+
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	report := doctor.Run(ctx, *configPath, doctor.Options{Release: *release, SkipProbes: *skipProbes})
+	if *asJSON {
+		if err := printJSON(stdout, report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(stdout, "🩺 liberation-ai doctor")
+		fmt.Fprintln(stdout)
+		report.Print(stdout)
+		fmt.Fprintln(stdout)
+	}
+
+	if report.Failed() {
+		return fmt.Errorf("some checks failed; fix them before starting the server")
+	}
+	if !*asJSON {
+		fmt.Fprintln(stdout, "✅ liberation-ai is ready to start.")
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"liberation-ai/pkg/liberation"
+)
+
+// IngestResult summarises an ingest run
+type IngestResult struct {
+	Namespace  string   `json:"namespace"`
+	Files      int      `json:"files"`
+	Stored     int      `json:"stored"`
+	Failed     int      `json:"failed"`
+	Skipped    []string `json:"skipped,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+	DurationMS int64    `json:"duration_ms"`
+}
+
+// RunIngest uploads files or directory trees as documents
+func RunIngest(args []string, stdout, stderr io.Writer) error {
+	var opts Options
+	flags := newFlagSet("ingest", "ingest <path>... [flags]")
+	flags.SetOutput(stderr)
+	registerCommon(flags, &opts)
+	extensions := flags.String("ext", ".md,.markdown,.txt,.rst", "Comma-separated file extensions to ingest")
+	batchSize := flags.Int("batch-size", 25, "Documents per upload request")
+	maxBytes := flags.Int64("max-bytes", 1<<20, "Skip files larger than this many bytes")
+
+	paths, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		flags.Usage()
+		return fmt.Errorf("at least one path is required")
+	}
+	if *batchSize < 1 {
+		*batchSize = 1
+	}
+
+	result := &IngestResult{Namespace: opts.Namespace}
+	files, err := collectFiles(paths, parseExtensions(*extensions))
+	if err != nil {
+		return err
+	}
+	result.Files = len(files)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	c := opts.newClient()
+	start := time.Now()
+
+	var progressOut io.Writer
+	if !opts.JSON {
+		fmt.Fprintf(stdout, "📄 Ingesting %d files into namespace %q at %s\n", len(files), opts.Namespace, opts.Server)
+		progressOut = stderr
+	}
+	progress := newProgressBar(progressOut, "⬆️  Uploading", len(files))
+
+	batch := make([]liberation.Document, 0, *batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		response, err := c.StoreDocuments(ctx, opts.Namespace, batch)
+		if err != nil {
+			result.Failed += len(batch)
+			result.Errors = append(result.Errors, err.Error())
+		} else {
+			result.Stored += response.Stored
+			result.Failed += response.Failed
+		}
+		progress.Add(len(batch))
+		batch = batch[:0]
+	}
+
+	for _, file := range files {
+		doc, err := readDocument(file, *maxBytes)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %v", file.id, err))
+			progress.Add(1)
+			continue
+		}
+
+		batch = append(batch, *doc)
+		if len(batch) >= *batchSize {
+			flush()
+		}
+	}
+	flush()
+	progress.Finish()
+
+	result.DurationMS = time.Since(start).Milliseconds()
+
+	if opts.JSON {
+		if err := printJSON(stdout, result); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(stdout, "✅ Stored %d documents in %s\n", result.Stored, time.Since(start).Round(time.Millisecond))
+		for _, skipped := range result.Skipped {
+			fmt.Fprintf(stdout, "⏭️  Skipped %s\n", skipped)
+		}
+		for _, e := range result.Errors {
+			fmt.Fprintf(stdout, "❌ %s\n", e)
+		}
+	}
+
+	if result.Failed > 0 {
+		return fmt.Errorf("%d documents failed to upload", result.Failed)
+	}
+	return nil
+}
+
+// sourceFile is a file selected for ingestion
+type sourceFile struct {
+	path string
+	id   string
+}
+
+// collectFiles expands directories and filters files by extension.
+// Document IDs are paths relative to the directory they were found under.
+func collectFiles(paths []string, extensions map[string]bool) ([]sourceFile, error) {
+	var files []sourceFile
+
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", root, err)
+		}
+
+		if !info.IsDir() {
+			files = append(files, sourceFile{path: root, id: filepath.ToSlash(filepath.Base(root))})
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !extensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, sourceFile{path: path, id: filepath.ToSlash(rel)})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+		}
+	}
+
+	return files, nil
+}
+
+// readDocument loads a file as a document, using the file name as its title
+func readDocument(file sourceFile, maxBytes int64) (*liberation.Document, error) {
+	info, err := os.Stat(file.path)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes > 0 && info.Size() > maxBytes {
+		return nil, fmt.Errorf("file is %d bytes, limit is %d", info.Size(), maxBytes)
+	}
+
+	content, err := os.ReadFile(file.path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(content)) == "" {
+		return nil, fmt.Errorf("file is empty")
+	}
+
+	name := filepath.Base(file.path)
+	return &liberation.Document{
+		ID:      file.id,
+		Title:   strings.TrimSuffix(name, filepath.Ext(name)),
+		Content: string(content),
+		Metadata: map[string]interface{}{
+			"source":      file.id,
+			"size_bytes":  info.Size(),
+			"ingested_at": time.Now().UTC().Format(time.RFC3339),
+		},
+	}, nil
+}
+
+func parseExtensions(list string) map[string]bool {
+	extensions := make(map[string]bool)
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions[ext] = true
+	}
+	return extensions
+}
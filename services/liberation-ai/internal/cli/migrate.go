@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"liberation-ai/internal/client"
+	"liberation-ai/internal/migration"
+	"liberation-ai/pkg/types"
+)
+
+// migratePollInterval is how often a watched migration's progress is fetched
+const migratePollInterval = 2 * time.Second
+
+// RunMigrate starts or resumes a vector store migration on the server and follows its
+// progress until it ends. Interrupting the command stops following; the migration goes on.
+func RunMigrate(args []string, stdout, stderr io.Writer) error {
+	var opts Options
+	flags := newFlagSet("migrate", "migrate [namespace...] [flags]")
+	flags.SetOutput(stderr)
+	registerClient(flags, &opts)
+	status := flags.Bool("status", false, "Show the current or last migration without starting one")
+	cancelRun := flags.Bool("cancel", false, "Stop the running migration; starting again resumes it")
+	restart := flags.Bool("restart", false, "Discard the checkpoint and copy everything again")
+	detach := flags.Bool("detach", false, "Start the migration and return without following it")
+	vectorsPerSecond := flags.Int("vectors-per-second", 0, "Throttle reads from the source (default migration.vectors_per_second)")
+	bytesPerSecond := flags.Int64("bytes-per-second", 0, "Also throttle reads by size (default migration.bytes_per_second)")
+
+	namespaces, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if *status && *cancelRun {
+		return fmt.Errorf("--status and --cancel cannot be combined")
+	}
+
+	api := opts.newClient()
+	call := func(fn func(ctx context.Context) (*migration.Migration, error)) (*migration.Migration, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+		defer cancel()
+		return fn(ctx)
+	}
+
+	var run *migration.Migration
+	switch {
+	case *status:
+		run, err = call(api.Migration)
+	case *cancelRun:
+		run, err = call(api.CancelMigration)
+	default:
+		req := migration.Request{Namespaces: namespaces, VectorsPerSecond: *vectorsPerSecond, BytesPerSecond: *bytesPerSecond, Restart: *restart}
+		run, err = call(func(ctx context.Context) (*migration.Migration, error) { return api.StartMigration(ctx, req) })
+		if err == nil && !*detach {
+			run, err = watchMigration(api, opts, run, stdout)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		if err := printJSON(stdout, run); err != nil {
+			return err
+		}
+	} else {
+		printMigration(stdout, run)
+	}
+	if run.Status == types.MigrationFailed {
+		return fmt.Errorf("migration failed: %s", run.Error)
+	}
+	return nil
+}
+
+// watchMigration polls a running migration, drawing a progress bar, until it ends or the
+// command is interrupted
+func watchMigration(api *client.Client, opts Options, run *migration.Migration, stdout io.Writer) (*migration.Migration, error) {
+	interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var out io.Writer
+	if !opts.JSON {
+		out = stdout
+		fmt.Fprintf(stdout, "🚚 %s: copying %d vectors in %d namespaces\n", run.ID, run.VectorsTotal, len(run.Namespaces))
+	}
+	bar := newProgressBar(out, "Migrating", int(run.VectorsTotal))
+	defer bar.Finish()
+
+	for run.Status == types.MigrationRunning {
+		bar.Set(int(run.VectorsMigrated), migrationDetail(run))
+		select {
+		case <-interrupted.Done():
+			if out != nil {
+				fmt.Fprintf(out, "\n⏸️  Stopped following; the migration goes on. Check it with liberation-ai migrate --status")
+			}
+			return run, nil
+		case <-time.After(migratePollInterval):
+		}
+
+		ctx, cancel := context.WithTimeout(interrupted, opts.Timeout)
+		next, err := api.Migration(ctx)
+		cancel()
+		if err != nil {
+			if interrupted.Err() != nil {
+				continue
+			}
+			return nil, err
+		}
+		run = next
+	}
+	bar.Set(int(run.VectorsMigrated), "")
+	return run, nil
+}
+
+// migrationDetail describes a running migration's position, rate and ETA
+func migrationDetail(run *migration.Migration) string {
+	detail := run.CurrentNamespace
+	if run.VectorsPerSecond > 0 {
+		detail += fmt.Sprintf(" %.0f/s, ETA %s", run.VectorsPerSecond, time.Duration(run.ETASeconds)*time.Second)
+	}
+	return detail
+}
+
+// printMigration prints a migration's state namespace by namespace
+func printMigration(w io.Writer, run *migration.Migration) {
+	fmt.Fprintf(w, "🚚 %s: %s, %d/%d vectors (%.0f%%)", run.ID, run.Status, run.VectorsMigrated, run.VectorsTotal, run.PercentComplete)
+	if run.Status == types.MigrationRunning && run.VectorsPerSecond > 0 {
+		fmt.Fprintf(w, ", %.0f vectors/s, ETA %s", run.VectorsPerSecond, time.Duration(run.ETASeconds)*time.Second)
+	}
+	if run.Resumes > 0 {
+		fmt.Fprintf(w, ", resumed %d times", run.Resumes)
+	}
+	fmt.Fprintln(w)
+
+	for _, ns := range run.Namespaces {
+		mark := "⏳"
+		if ns.Done {
+			mark = "✅"
+		}
+		fmt.Fprintf(w, "   %s %-24s %d/%d\n", mark, ns.Namespace, ns.Migrated, ns.Total)
+	}
+	if v := run.Validation; v != nil {
+		fmt.Fprintf(w, "   🔎 Validation: %d sampled, %d matched, %d deleted since copied\n", v.Sampled, v.Matched, v.Skipped)
+		for _, mismatch := range v.Mismatches {
+			fmt.Fprintf(w, "      ❌ %s differs\n", mismatch)
+		}
+	}
+	if run.Error != "" {
+		fmt.Fprintf(w, "   ❌ %s\n", run.Error)
+	}
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const progressWidth = 30
+
+// progressBar renders a single-line progress bar, redrawn in place with carriage returns
+type progressBar struct {
+	out     io.Writer
+	label   string
+	total   int
+	current int
+	detail  string
+	width   int
+}
+
+// newProgressBar creates a progress bar; a nil writer disables output
+func newProgressBar(out io.Writer, label string, total int) *progressBar {
+	p := &progressBar{out: out, label: label, total: total}
+	p.render()
+	return p
+}
+
+// Add advances the bar by n units
+func (p *progressBar) Add(n int) {
+	p.current += n
+	if p.current > p.total {
+		p.current = p.total
+	}
+	p.render()
+}
+
+// Set moves the bar to current and shows detail, such as an ETA, after the counts
+func (p *progressBar) Set(current int, detail string) {
+	p.current = min(current, p.total)
+	p.detail = detail
+	p.render()
+}
+
+// Finish terminates the bar's line
+func (p *progressBar) Finish() {
+	if p.out == nil {
+		return
+	}
+	fmt.Fprintln(p.out)
+}
+
+func (p *progressBar) render() {
+	if p.out == nil {
+		return
+	}
+
+	filled := progressWidth
+	percent := 100
+	if p.total > 0 {
+		filled = p.current * progressWidth / p.total
+		percent = p.current * 100 / p.total
+	}
+
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", progressWidth-filled)
+	line := fmt.Sprintf("\r%s [%s] %3d%% (%d/%d)", p.label, bar, percent, p.current, p.total)
+	if p.detail != "" {
+		line += " " + p.detail
+	}
+	// Pad over the end of a longer line drawn before
+	fmt.Fprintf(p.out, "%-*s", p.width, line)
+	p.width = max(p.width, utf8.RuneCountInString(line))
+}
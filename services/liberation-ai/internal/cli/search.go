@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"liberation-ai/pkg/types"
+)
+
+// ContextPassage is a retrieved passage as printed by the query command
+type ContextPassage struct {
+	ID      string  `json:"id"`
+	Title   string  `json:"title,omitempty"`
+	Score   float64 `json:"score"`
+	Content string  `json:"content"`
+}
+
+// QueryResult is one question and the context retrieved for it
+type QueryResult struct {
+	Question string           `json:"question"`
+	Context  []ContextPassage `json:"context"`
+}
+
+// RunSearch runs a single search and prints the ranked results
+func RunSearch(args []string, stdout, stderr io.Writer) error {
+	var opts Options
+	flags := newFlagSet("search", "search <query> [flags]")
+	flags.SetOutput(stderr)
+	registerCommon(flags, &opts)
+	limit := flags.Int("limit", 10, "Maximum number of results")
+
+	words, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	query := strings.TrimSpace(strings.Join(words, " "))
+	if query == "" {
+		flags.Usage()
+		return fmt.Errorf("a search query is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	response, err := opts.newClient().Search(ctx, opts.Namespace, query, *limit)
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		return printJSON(stdout, response)
+	}
+
+	if len(response.Results) == 0 {
+		fmt.Fprintf(stdout, "🔍 No results for %q in namespace %q\n", query, opts.Namespace)
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "🔍 %d results for %q (%dms)\n\n", len(response.Results), query, response.ProcessingTime)
+	for i, result := range response.Results {
+		passage := toPassage(result)
+		title := passage.Title
+		if title == "" {
+			title = passage.ID
+		}
+		fmt.Fprintf(stdout, "%2d. [%.3f] %s (%s)\n", i+1, passage.Score, title, passage.ID)
+		fmt.Fprintf(stdout, "    %s\n\n", snippet(passage.Content, 160))
+	}
+	return nil
+}
+
+// RunQuery starts an interactive session that retrieves context for each question.
+// A question given on the command line is answered once without prompting.
+func RunQuery(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var opts Options
+	flags := newFlagSet("query", "query [question] [flags]")
+	flags.SetOutput(stderr)
+	registerCommon(flags, &opts)
+	contextLimit := flags.Int("context", 5, "Number of passages to retrieve per question")
+	maxChars := flags.Int("max-chars", 600, "Truncate each passage to this many characters (0 for no limit)")
+
+	words, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	c := opts.newClient()
+	ask := func(question string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+		defer cancel()
+
+		response, err := c.Search(ctx, opts.Namespace, question, *contextLimit)
+		if err != nil {
+			return err
+		}
+
+		result := QueryResult{Question: question, Context: make([]ContextPassage, 0, len(response.Results))}
+		for _, r := range response.Results {
+			passage := toPassage(r)
+			if *maxChars > 0 {
+				passage.Content = snippet(passage.Content, *maxChars)
+			}
+			result.Context = append(result.Context, passage)
+		}
+
+		if opts.JSON {
+			return printJSON(stdout, result)
+		}
+		printContext(stdout, result)
+		return nil
+	}
+
+	if question := strings.TrimSpace(strings.Join(words, " ")); question != "" {
+		return ask(question)
+	}
+
+	if !opts.JSON {
+		fmt.Fprintf(stdout, "💬 Liberation AI query (namespace %q). Type a question, or \"exit\" to quit.\n", opts.Namespace)
+	}
+
+	scanner := bufio.NewScanner(stdin)
+	for {
+		if !opts.JSON {
+			fmt.Fprint(stdout, "\n❓ ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+
+		question := strings.TrimSpace(scanner.Text())
+		switch question {
+		case "":
+			continue
+		case "exit", "quit":
+			return nil
+		}
+
+		if err := ask(question); err != nil {
+			fmt.Fprintf(stderr, "❌ %v\n", err)
+		}
+	}
+
+	if !opts.JSON {
+		fmt.Fprintln(stdout)
+	}
+	return scanner.Err()
+}
+
+func printContext(w io.Writer, result QueryResult) {
+	if len(result.Context) == 0 {
+		fmt.Fprintln(w, "📭 No relevant context found")
+		return
+	}
+
+	fmt.Fprintf(w, "📚 Context for %q:\n", result.Question)
+	for i, passage := range result.Context {
+		title := passage.Title
+		if title == "" {
+			title = passage.ID
+		}
+		fmt.Fprintf(w, "\n[%d] %s (score %.3f)\n%s\n", i+1, title, passage.Score, passage.Content)
+	}
+}
+
+// toPassage extracts the stored document fields from a search result
+func toPassage(result types.SearchResult) ContextPassage {
+	passage := ContextPassage{ID: result.Vector.ID, Score: result.Score}
+	if title, ok := result.Vector.Metadata["title"].(string); ok {
+		passage.Title = title
+	}
+	passage.Content = result.Vector.Text
+	// Vectors stored before chunk text had its own field keep it in metadata
+	if passage.Content == "" {
+		if content, ok := result.Vector.Metadata["content"].(string); ok {
+			passage.Content = content
+		} else if text, ok := result.Vector.Metadata["text"].(string); ok {
+			passage.Content = text
+		}
+	}
+	return passage
+}
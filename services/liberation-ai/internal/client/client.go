@@ -0,0 +1,270 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"liberation-ai/internal/migration"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// Client talks to a running Liberation AI server over HTTP
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	maxRetries int
+
+	mu sync.Mutex
+	// rate is the request bucket the server last reported; nil until it reports one
+	rate *RateLimit
+}
+
+// RateLimit is the state of the client's request bucket from the server's X-RateLimit headers
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	// Reset is when the bucket is full again
+	Reset time.Time
+}
+
+// APIError represents a non-2xx response from the server
+type APIError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// NewClient creates a new client for the server at baseURL
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		maxRetries: 3,
+	}
+}
+
+// StoreDocuments uploads documents into a namespace
+func (c *Client) StoreDocuments(ctx context.Context, namespace string, docs []liberation.Document) (*types.StoreResponse, error) {
+	body, err := json.Marshal(docs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode documents: %w", err)
+	}
+
+	query := url.Values{"namespace": {namespace}}
+	var response types.StoreResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/documents", query, body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Search runs a text search against a namespace
+func (c *Client) Search(ctx context.Context, namespace, query string, limit int) (*types.SearchResponse, error) {
+	params := url.Values{
+		"q":         {query},
+		"namespace": {namespace},
+		"limit":     {strconv.Itoa(limit)},
+	}
+
+	var response types.SearchResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/search", params, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Limits fetches the request rate and embedding quota that apply to this client
+func (c *Client) Limits(ctx context.Context) (*ratelimit.Limits, error) {
+	var limits ratelimit.Limits
+	if err := c.do(ctx, http.MethodGet, "/v1/limits", nil, nil, &limits); err != nil {
+		return nil, err
+	}
+	return &limits, nil
+}
+
+// RateLimit returns the request bucket the server last reported, and false before it has
+func (c *Client) RateLimit() (RateLimit, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rate == nil {
+		return RateLimit{}, false
+	}
+	return *c.rate, true
+}
+
+// StartMigration starts a vector store migration, or resumes the unfinished one
+func (c *Client) StartMigration(ctx context.Context, req migration.Request) (*migration.Migration, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode migration request: %w", err)
+	}
+	var run migration.Migration
+	if err := c.do(ctx, http.MethodPost, "/v1/admin/migration", nil, body, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Migration fetches the progress of the current or last vector store migration
+func (c *Client) Migration(ctx context.Context) (*migration.Migration, error) {
+	var run migration.Migration
+	if err := c.do(ctx, http.MethodGet, "/v1/admin/migration", nil, nil, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// CancelMigration stops the running migration after its current page, keeping its checkpoint
+func (c *Client) CancelMigration(ctx context.Context) (*migration.Migration, error) {
+	var run migration.Migration
+	if err := c.do(ctx, http.MethodDelete, "/v1/admin/migration", nil, nil, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Health checks that the server is reachable
+func (c *Client) Health(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/health", nil, nil, nil)
+}
+
+// do performs a request, retrying when the server asks us to slow down
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte, out interface{}) error {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if err := c.pace(ctx); err != nil {
+			return err
+		}
+		err := c.doOnce(ctx, method, endpoint, body, out)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+
+		apiErr, ok := err.(*APIError)
+		if !ok || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RetryAfter > time.Minute {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(apiErr.RetryAfter):
+		}
+	}
+
+	return lastErr
+}
+
+// pace waits for a token to come back when the server reported an empty bucket, so the
+// client slows down instead of being refused. The bucket refills evenly until Reset, so one
+// token takes the time left divided by the bucket's size.
+func (c *Client) pace(ctx context.Context) error {
+	rate, ok := c.RateLimit()
+	if !ok || rate.Remaining > 0 || rate.Limit <= 0 {
+		return nil
+	}
+	wait := time.Until(rate.Reset) / time.Duration(rate.Limit)
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+	}
+	// The token that came back is this request's, until its response reports the bucket again
+	c.mu.Lock()
+	if c.rate != nil && c.rate.Remaining == 0 {
+		c.rate.Remaining = 1
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// observe keeps the request bucket reported in a response's headers
+func (c *Client) observe(header http.Header) {
+	limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	reset, _ := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rate = &RateLimit{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)}
+}
+
+func (c *Client) doOnce(ctx context.Context, method, endpoint string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set(ratelimit.APIKeyHeader, c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	c.observe(resp.Header)
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+
+		var errBody types.ErrorResponse
+		if json.Unmarshal(data, &errBody) == nil && errBody.Error != "" {
+			apiErr.Message = errBody.Error
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+func TestRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(ratelimit.APIKeyHeader); key != "key-1" {
+			t.Errorf("%s %s sent API key %q", r.Method, r.URL.Path, key)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/documents":
+			var docs []liberation.Document
+			if err := json.NewDecoder(r.Body).Decode(&docs); err != nil || len(docs) != 2 {
+				t.Errorf("documents %v, %v", docs, err)
+			}
+			if r.Header.Get("Content-Type") != "application/json" || r.URL.Query().Get("namespace") != "docs" {
+				t.Errorf("store sent %v with query %s", r.Header, r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode(types.StoreResponse{Stored: len(docs), Store: "memory"})
+		case "GET /v1/search":
+			if q := r.URL.Query(); q.Get("q") != "tool library" || q.Get("namespace") != "docs" || q.Get("limit") != "5" {
+				t.Errorf("search query %s", r.URL.RawQuery)
+			}
+			json.NewEncoder(w).Encode(types.SearchResponse{Results: []types.SearchResult{{Vector: types.Vector{ID: "a"}, Score: 0.9}}})
+		case "GET /health":
+			w.WriteHeader(http.StatusOK)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL+"/", "key-1")
+	ctx := context.Background()
+	stored, err := c.StoreDocuments(ctx, "docs", []liberation.Document{{ID: "a"}, {ID: "b"}})
+	if err != nil || stored.Stored != 2 {
+		t.Errorf("StoreDocuments: %+v, %v", stored, err)
+	}
+	results, err := c.Search(ctx, "docs", "tool library", 5)
+	if err != nil || len(results.Results) != 1 || results.Results[0].Vector.ID != "a" {
+		t.Errorf("Search: %+v, %v", results, err)
+	}
+	if err := c.Health(ctx); err != nil {
+		t.Errorf("Health: %v", err)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"error body", `{"error": "namespace is required"}`, "namespace is required"},
+		{"plain text", "  upstream timed out\n", "upstream timed out"},
+		{"json without an error", `{"detail": "x"}`, `{"detail": "x"}`},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, tc.body)
+		}))
+		_, err := NewClient(server.URL, "").Search(context.Background(), "docs", "q", 1)
+		server.Close()
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != tc.message {
+			t.Errorf("%s: %v, want 400 with %q", tc.name, err, tc.message)
+		}
+	}
+}
+
+// countingServer answers 429 with retryAfter for the first refusals requests, then 200
+func countingServer(refusals int32, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= refusals {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	return server, &calls
+}
+
+func TestRetriesWhenRefused(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		refusals   int32
+		retryAfter string
+		calls      int32
+		ok         bool
+	}{
+		{"retried until accepted", 2, "0", 3, true},
+		{"gives up after the retries", 10, "0", 4, false},
+		{"a long wait is not retried", 1, "120", 1, false},
+	} {
+		server, calls := countingServer(tc.refusals, tc.retryAfter)
+		err := NewClient(server.URL, "").Health(context.Background())
+		server.Close()
+
+		if (err == nil) != tc.ok || calls.Load() != tc.calls {
+			t.Errorf("%s: %v after %d calls, want %d", tc.name, err, calls.Load(), tc.calls)
+		}
+		var apiErr *APIError
+		if !tc.ok && (!errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests) {
+			t.Errorf("%s: error %v, want the 429", tc.name, err)
+		}
+	}
+
+	// Other errors are never retried
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	if err := NewClient(server.URL, "").Health(context.Background()); err == nil || calls.Load() != 1 {
+		t.Errorf("503: %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestRetryWaitIsCancelled(t *testing.T) {
+	server, calls := countingServer(10, "30")
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := NewClient(server.URL, "").Health(ctx); !errors.Is(err, context.DeadlineExceeded) || calls.Load() != 1 {
+		t.Errorf("%v after %d calls, want the deadline after one", err, calls.Load())
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, "")
+	if _, ok := c.RateLimit(); ok {
+		t.Error("a rate limit before any response")
+	}
+	if err := c.Health(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rate, ok := c.RateLimit()
+	if !ok || rate.Limit != 60 || rate.Remaining != 0 || rate.Reset.Unix() != reset {
+		t.Fatalf("rate limit %+v, %v", rate, ok)
+	}
+
+	// With the bucket empty for an hour the next request waits a minute for its token, so a
+	// shorter deadline ends it before anything is sent
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Health(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("request with an empty bucket: %v", err)
+	}
+
+	// A bucket that has already reset is not waited for
+	c.mu.Lock()
+	c.rate.Reset = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	if err := c.pace(ctx); err != nil {
+		t.Errorf("pace after the reset: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"liberation-ai/internal/analytics"
+	"liberation-ai/internal/archive"
+	"liberation-ai/internal/budget"
+	"liberation-ai/internal/connectors"
+	"liberation-ai/internal/dashboard"
+	"liberation-ai/internal/drift"
+	"liberation-ai/internal/embedcache"
+	"liberation-ai/internal/encryption"
+	"liberation-ai/internal/erasure"
+	"liberation-ai/internal/extract"
+	"liberation-ai/internal/ingesttoken"
+	"liberation-ai/internal/migration"
+	"liberation-ai/internal/personalize"
+	"liberation-ai/internal/provisioning"
+	"liberation-ai/internal/ratelimit"
+	"liberation-ai/internal/relevance"
+	"liberation-ai/internal/residency"
+	"liberation-ai/internal/rewrite"
+	"liberation-ai/internal/shadow"
+	"liberation-ai/internal/tenancy"
+	"liberation-ai/internal/upload"
+	"liberation-ai/internal/validate"
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/auth"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+
+	"liberation-cache"
+	"liberation-dbpool"
+	"liberation-profiling"
+	"liberation-scheduler"
+	"liberation-serviceauth"
+)
+
+// Config represents the subset of liberation-ai.yml used by the server
+type Config struct {
+	VectorStore VectorStoreConfig `yaml:"vector_store"`
+	Documents   DocumentsConfig   `yaml:"documents"`
+	Auth        AuthConfig        `yaml:"auth"`
+	RateLimits  ratelimit.Config  `yaml:"rate_limits"`
+	Analytics   analytics.Config  `yaml:"analytics"`
+	Relevance   relevance.Config  `yaml:"relevance"`
+	// Personalization blends a preference vector learned from each caller's feedback into their queries
+	Personalization personalize.Config `yaml:"personalization"`
+	Storage         archive.Config     `yaml:"storage"`
+	Shadow          shadow.Config      `yaml:"shadow"`
+	Budgets         budget.Config      `yaml:"budgets"`
+	Rewrite         rewrite.Config     `yaml:"query_rewrite"`
+	AIProviders     AIProvidersConfig  `yaml:"ai_providers"`
+	Extraction      extract.Config     `yaml:"extraction"`
+	// Drift re-embeds samples to notice model updates and runs re-embedding campaigns
+	Drift drift.Config `yaml:"drift"`
+	// IngestTokens lets frontends upload documents to one namespace with a short-lived token
+	IngestTokens ingesttoken.Config `yaml:"ingest_tokens"`
+	// Services lets other liberation services call the admin API with signed tokens
+	Services serviceauth.Config `yaml:"service_identity"`
+	// Validation limits the documents, vectors and metadata /v1 requests may carry
+	Validation validate.Config `yaml:"validation"`
+	// Profiling serves pprof on a private listener and pushes profiles continuously
+	Profiling ProfilingConfig `yaml:"profiling"`
+	// Scheduler overrides the schedules of background jobs such as drift_check
+	Scheduler scheduler.Config `yaml:"scheduler"`
+	// Tenancy meters hosted tenants' namespaces against their plans in liberation-auth
+	Tenancy tenancy.Config `yaml:"tenancy"`
+	// Provisioning gives organizations created in liberation-auth a namespace and API key
+	Provisioning provisioning.Config `yaml:"provisioning"`
+	// Erasure decides which vectors and feedback are deleted with a user's account
+	Erasure erasure.Config `yaml:"erasure"`
+	// MetadataSchemas declares the metadata fields of namespaces; writes are checked against
+	// them and filters on number fields compare numerically
+	MetadataSchemas map[string]types.MetadataSchema `yaml:"metadata_schemas"`
+	// Encryption encrypts the embeddings, text and metadata of listed namespaces at rest
+	Encryption encryption.Config `yaml:"encryption"`
+	// Connectors keep namespaces in sync with outside sources such as wikis and drives
+	Connectors connectors.Config `yaml:"connectors"`
+	// Uploads controls streamed NDJSON uploads: batching, the shared embedding queue and
+	// how long sessions can be resumed
+	Uploads upload.Config `yaml:"uploads"`
+	// EmbeddingCache reuses the embeddings of content already embedded by the same model
+	EmbeddingCache embedcache.Config `yaml:"embedding_cache"`
+	// Residency declares the region of each backend and pins namespaces to regions
+	Residency residency.Config `yaml:"residency"`
+	// ModelCache remembers which embedding model each namespace uses under its budget
+	ModelCache cache.Config `yaml:"model_cache"`
+	// Migration copies the vector store into another store through /v1/admin/migration
+	Migration MigrationConfig `yaml:"migration"`
+	// Dashboard serves a read-only status page at /dashboard/ for deployments without Grafana
+	Dashboard dashboard.Config `yaml:"dashboard"`
+
+	// Path is the file the configuration was read from; empty when defaults were used
+	Path string `yaml:"-"`
+}
+
+// VectorStoreConfig describes the vector_store section written by the setup wizard
+type VectorStoreConfig struct {
+	Type           string `yaml:"type"`
+	ConnectionURL  string `yaml:"connection_url"`
+	Dimensions     int    `yaml:"dimensions"`
+	TableName      string `yaml:"table_name"`
+	CollectionName string `yaml:"collection_name"`
+	// Fallback serves reads while the primary store is unhealthy
+	Fallback vectorstore.FailoverConfig `yaml:"fallback"`
+	// Pool sizes the Postgres connection pools of the store and its replica fallback
+	Pool dbpool.Config `yaml:"pool"`
+}
+
+// MigrationConfig adds the store migrations copy into; without a destination type,
+// migrations cannot be started
+type MigrationConfig struct {
+	migration.Config `yaml:",inline"`
+	Destination      VectorStoreConfig `yaml:"destination"`
+}
+
+// DocumentsConfig controls how documents are split into chunks and how much neighbouring
+// text searches may return
+type DocumentsConfig struct {
+	liberation.Chunking `yaml:",inline"`
+	// MaxWindow caps the window parameter of searches and extractions
+	MaxWindow int `yaml:"max_window"`
+}
+
+// AIProvidersConfig describes the ai_providers section written by the setup wizard.
+// Embeddings are configured separately; only the chat provider is used by the server.
+type AIProvidersConfig struct {
+	Chat liberation.ChatConfig `yaml:"chat"`
+}
+
+// AuthConfig describes the auth section written by the setup wizard
+type AuthConfig struct {
+	Provider auth.ProviderConfig `yaml:"provider"`
+	Optional bool                `yaml:"optional"`
+	Enabled  bool                `yaml:"enabled"`
+}
+
+// ProfilingConfig adds the admin-only listener pprof is served on
+type ProfilingConfig struct {
+	profiling.Config `yaml:",inline"`
+	// ListenAddr must be a private address, e.g. 127.0.0.1:6060; pprof is never served on the API port
+	ListenAddr string `yaml:"listen_addr"`
+}
+
+// Default returns the configuration used when no config file is present
+func Default() *Config {
+	return &Config{
+		VectorStore:     VectorStoreConfig{Type: "memory", Dimensions: 384, Fallback: vectorstore.DefaultFailoverConfig(), Pool: dbpool.DefaultConfig()},
+		Documents:       DocumentsConfig{MaxWindow: 3},
+		Auth:            AuthConfig{Provider: auth.ProviderConfig{Type: "noauth", Enabled: true}},
+		RateLimits:      ratelimit.DefaultConfig(),
+		Analytics:       analytics.DefaultConfig(),
+		Relevance:       relevance.DefaultConfig(),
+		Personalization: personalize.DefaultConfig(),
+		Storage:         archive.DefaultConfig(),
+		Shadow:          shadow.DefaultConfig(),
+		Budgets:         budget.DefaultConfig(),
+		Rewrite:         rewrite.DefaultConfig(),
+		Extraction:      extract.DefaultConfig(),
+		IngestTokens:    ingesttoken.DefaultConfig(),
+		Drift:           drift.DefaultConfig(),
+		Validation:      validate.DefaultConfig(),
+		Services:        serviceauth.Config{Name: "liberation-ai"},
+		Tenancy:         tenancy.DefaultConfig(),
+		Provisioning:    provisioning.DefaultConfig(),
+		Erasure:         erasure.DefaultConfig(),
+		Encryption:      encryption.DefaultConfig(),
+		Connectors:      connectors.DefaultConfig(),
+		Uploads:         upload.DefaultConfig(),
+		EmbeddingCache:  embedcache.DefaultConfig(),
+		Residency:       residency.DefaultConfig(),
+		ModelCache:      cache.Config{Name: "models", MaxEntries: 10000, LocalTTLSeconds: 60},
+		Migration:       MigrationConfig{Config: migration.DefaultConfig(), Destination: VectorStoreConfig{Dimensions: 384, Fallback: vectorstore.DefaultFailoverConfig(), Pool: dbpool.DefaultConfig()}},
+		Profiling:       ProfilingConfig{Config: profiling.Config{Push: profiling.PushConfig{AppName: "liberation-ai"}}},
+		Dashboard:       dashboard.DefaultConfig(),
+	}
+}
+
+// Load reads the configuration file at path, falling back to defaults if it does not exist.
+// Storage settings can be overridden with LIBERATION_STORAGE_* environment variables, and
+// budget notification secrets with LIBERATION_BUDGET_*, the drift webhook secret with
+// LIBERATION_DRIFT_WEBHOOK_SECRET, service keys with
+// LIBERATION_SERVICE_IDENTITY_*, profiling settings with LIBERATION_PROFILING_*, job
+// schedules with LIBERATION_JOB_*, the tenancy auth URL with LIBERATION_TENANCY_AUTH_URL
+// the ingestion token secret with LIBERATION_INGEST_TOKEN_SECRET and encryption master keys
+// with LIBERATION_ENCRYPTION_* and connector sidecar tokens with LIBERATION_CONNECTOR_*, so
+// credentials need not live in the file. Database pool sizes can be tuned per deployment with
+// LIBERATION_DB_POOL_*.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	default:
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+		cfg.Path = path
+	}
+
+	cfg.Storage.ApplyEnv("LIBERATION_STORAGE_")
+	cfg.Budgets.ApplyEnv("LIBERATION_BUDGET_")
+	cfg.Services.ApplyEnv("LIBERATION_SERVICE_IDENTITY_")
+	cfg.Profiling.ApplyEnv("LIBERATION_PROFILING_")
+	cfg.IngestTokens.ApplyEnv("LIBERATION_INGEST_TOKEN_")
+	cfg.Drift.ApplyEnv("LIBERATION_DRIFT_")
+	cfg.Scheduler.ApplyEnv("LIBERATION_JOB_")
+	cfg.Tenancy.ApplyEnv("LIBERATION_TENANCY_")
+	cfg.Encryption.ApplyEnv("LIBERATION_ENCRYPTION_")
+	cfg.Connectors.ApplyEnv("LIBERATION_CONNECTOR_")
+	cfg.VectorStore.Pool.ApplyEnv("LIBERATION_DB_POOL_")
+	if value, ok := os.LookupEnv("LIBERATION_PROFILING_LISTEN_ADDR"); ok {
+		cfg.Profiling.ListenAddr = value
+	}
+	return cfg, nil
+}
+
+// ResidencyBackends lists the backends this configuration sends namespace data to, each of
+// which must declare its region once residency is enabled
+func (c *Config) ResidencyBackends() []string {
+	backends := []string{residency.BackendVectorStore, residency.BackendEmbeddings}
+	if c.VectorStore.Fallback.Enabled {
+		backends = append(backends, residency.BackendFallback)
+	}
+	if c.Extraction.Enabled {
+		backends = append(backends, residency.BackendChat)
+	}
+	if c.Storage.Enabled() {
+		backends = append(backends, residency.BackendArchive)
+	}
+	if c.Migration.Destination.Type != "" {
+		backends = append(backends, residency.BackendMigration)
+	}
+	return backends
+}
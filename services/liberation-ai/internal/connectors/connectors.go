@@ -0,0 +1,519 @@
+// Package connectors keeps namespaces in sync with outside sources through ingestion
+// connectors (see pkg/connector).
+//
+// Connectors are either compiled in, like the built-in directory connector, or run as
+// sidecars listed in the config file; admins cannot point a source at any other address.
+// Sources are registered at runtime with their settings and credentials. Credentials are
+// sealed with a data key of their own in the encryption keyring, are only opened for calls
+// to the source's own connector and are never returned by the API. Deleting a source
+// deletes its key.
+package connectors
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"liberation-ai/pkg/connector"
+	"liberation-ai/pkg/connector/directory"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+var (
+	// ErrUnknownConnector is returned for sources of a connector that is not configured
+	ErrUnknownConnector = errors.New("unknown connector")
+
+	// ErrSourceNotFound is returned for unknown source names
+	ErrSourceNotFound = errors.New("source not found")
+
+	// ErrSourceExists is returned when a source name is already taken
+	ErrSourceExists = errors.New("source already exists")
+
+	// ErrSyncRunning is returned when a source is already being synced
+	ErrSyncRunning = errors.New("source is already being synced")
+
+	// ErrNoKeyring is returned for credentials when no master key is configured to seal them
+	ErrNoKeyring = errors.New("credentials need LIBERATION_ENCRYPTION_MASTER_KEY to be set")
+)
+
+// SidecarConfig locates a connector running as a separate process
+type SidecarConfig struct {
+	URL string `yaml:"url"`
+	// Token authenticates calls to the sidecar; it is read from
+	// LIBERATION_CONNECTOR_<NAME>_TOKEN rather than the config file
+	Token string `yaml:"-"`
+}
+
+// Config lists the available connectors and how sources are synced
+type Config struct {
+	// Sidecars are connectors serving the sidecar contract, by connector name
+	Sidecars map[string]SidecarConfig `yaml:"sidecars"`
+	// DirectoryRoots enables the built-in directory connector for sources below them
+	DirectoryRoots []string `yaml:"directory_roots"`
+	// StateFile keeps sources, sealed credentials and item checksums; empty keeps them in memory
+	StateFile string `yaml:"state_file"`
+	// Schedule syncs every source as the connector_sync job
+	Schedule string `yaml:"schedule"`
+	// BatchSize is how many fetched documents are embedded and stored at a time
+	BatchSize int `yaml:"batch_size"`
+}
+
+// DefaultConfig has no connectors; once some are configured, sources sync hourly
+func DefaultConfig() Config {
+	return Config{
+		StateFile: "data/connectors.json",
+		Schedule:  "@hourly",
+		BatchSize: 25,
+	}
+}
+
+// ApplyEnv reads each sidecar's token from prefix + <NAME>_TOKEN
+func (c *Config) ApplyEnv(prefix string) {
+	for name, sidecar := range c.Sidecars {
+		variable := prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name)) + "_TOKEN"
+		if token := os.Getenv(variable); token != "" {
+			sidecar.Token = token
+			c.Sidecars[name] = sidecar
+		}
+	}
+}
+
+// Enabled reports whether any connector is available
+func (c Config) Enabled() bool {
+	return len(c.Sidecars) > 0 || len(c.DirectoryRoots) > 0
+}
+
+// Validate reports malformed sidecar addresses and directory roots
+func (c Config) Validate() error {
+	for name, sidecar := range c.Sidecars {
+		if name == "" || name == "directory" {
+			return fmt.Errorf("sidecar connector name %q is reserved", name)
+		}
+		parsed, err := url.Parse(sidecar.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("sidecar %s: url must be an http(s) URL", name)
+		}
+	}
+	for _, root := range c.DirectoryRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("directory root %q must be absolute", root)
+		}
+	}
+	if c.BatchSize < 1 {
+		return fmt.Errorf("batch_size must be at least 1")
+	}
+	return nil
+}
+
+// Secrets seals credentials; *encryption.Keyring implements it
+type Secrets interface {
+	SealSecret(name string, plaintext []byte) ([]byte, int, error)
+	OpenSecret(name string, version int, sealed []byte) ([]byte, error)
+	DeleteSecret(name string) error
+}
+
+// Sink stores and deletes synced documents; *liberation.Service implements it
+type Sink interface {
+	StoreDocuments(ctx context.Context, namespace string, docs []liberation.Document) (*types.StoreResponse, error)
+	DeleteDocuments(ctx context.Context, namespace string, ids []string) error
+}
+
+// Meter records embedding spend against namespace budgets; *budget.Manager implements it
+type Meter interface {
+	Charge(namespace string, tokens, embeddings int64, write bool) error
+}
+
+// SourceRequest registers a source
+type SourceRequest struct {
+	Name        string            `json:"name"`
+	Connector   string            `json:"connector"`
+	Namespace   string            `json:"namespace"`
+	Settings    map[string]string `json:"settings,omitempty"`
+	Credentials map[string]string `json:"credentials,omitempty"`
+}
+
+// Status describes a source; credentials are listed by name only
+type Status struct {
+	Name        string            `json:"name"`
+	Connector   string            `json:"connector"`
+	Namespace   string            `json:"namespace"`
+	Settings    map[string]string `json:"settings,omitempty"`
+	Credentials []string          `json:"credentials,omitempty"`
+	Items       int               `json:"items"`
+	Syncing     bool              `json:"syncing"`
+	CreatedAt   time.Time         `json:"created_at"`
+	LastSync    *SyncResult       `json:"last_sync,omitempty"`
+}
+
+// ConnectorStatus describes an available connector, or why it cannot be reached
+type ConnectorStatus struct {
+	Name    string          `json:"name"`
+	Sidecar string          `json:"sidecar,omitempty"`
+	Info    *connector.Info `json:"info,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// source is a registered source as kept in the state file
+type source struct {
+	Name            string            `json:"name"`
+	Connector       string            `json:"connector"`
+	Namespace       string            `json:"namespace"`
+	Settings        map[string]string `json:"settings,omitempty"`
+	CredentialNames []string          `json:"credential_names,omitempty"`
+	Sealed          string            `json:"sealed,omitempty"`
+	KeyVersion      int               `json:"key_version,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	// Checksums are those of the items as last stored, by item ID
+	Checksums map[string]string `json:"checksums"`
+	LastSync  *SyncResult       `json:"last_sync,omitempty"`
+}
+
+// Registry holds the available connectors and registered sources. It is safe for concurrent use.
+type Registry struct {
+	config     Config
+	connectors map[string]connector.Connector
+	sidecars   map[string]string
+	sink       Sink
+	meter      Meter
+	secrets    Secrets
+
+	mu      sync.Mutex
+	sources map[string]*source
+	syncing map[string]bool
+}
+
+// New creates the registry with the configured connectors and loads registered sources.
+// secrets may be nil, in which case sources with credentials are refused.
+func New(config Config, sink Sink, meter Meter, secrets Secrets) (*Registry, error) {
+	r := &Registry{
+		config:     config,
+		connectors: make(map[string]connector.Connector),
+		sidecars:   make(map[string]string),
+		sink:       sink,
+		meter:      meter,
+		secrets:    secrets,
+		sources:    make(map[string]*source),
+		syncing:    make(map[string]bool),
+	}
+	if len(config.DirectoryRoots) > 0 {
+		r.connectors["directory"] = directory.New(config.DirectoryRoots...)
+	}
+	for name, sidecar := range config.Sidecars {
+		r.connectors[name] = connector.NewRemote(sidecar.URL, sidecar.Token)
+		r.sidecars[name] = sidecar.URL
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Register adds a connector compiled into the server, replacing any of the same name
+func (r *Registry) Register(name string, c connector.Connector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connectors[name] = c
+	delete(r.sidecars, name)
+}
+
+func (r *Registry) load() error {
+	if r.config.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(r.config.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var sources []*source
+	if err := json.Unmarshal(data, &sources); err != nil {
+		return fmt.Errorf("reading %s: %w", r.config.StateFile, err)
+	}
+	for _, s := range sources {
+		if s.Checksums == nil {
+			s.Checksums = make(map[string]string)
+		}
+		r.sources[s.Name] = s
+	}
+	return nil
+}
+
+// saveLocked writes every source to the state file. The caller holds mu.
+func (r *Registry) saveLocked() error {
+	if r.config.StateFile == "" {
+		return nil
+	}
+	sources := make([]*source, 0, len(r.sources))
+	for _, s := range r.sources {
+		sources = append(sources, s)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	data, err := json.MarshalIndent(sources, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.config.StateFile), 0o755); err != nil {
+		return err
+	}
+	temp := r.config.StateFile + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temp, r.config.StateFile)
+}
+
+// Connectors describes every available connector, asking sidecars for their info
+func (r *Registry) Connectors(ctx context.Context) []ConnectorStatus {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.connectors))
+	for name := range r.connectors {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	statuses := make([]ConnectorStatus, 0, len(names))
+	for _, name := range names {
+		c, sidecar := r.connector(name)
+		status := ConnectorStatus{Name: name, Sidecar: sidecar}
+		if info, err := c.Info(ctx); err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Info = &info
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (r *Registry) connector(name string) (connector.Connector, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connectors[name], r.sidecars[name]
+}
+
+// Sources describes every registered source in name order
+func (r *Registry) Sources() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]Status, 0, len(r.sources))
+	for _, s := range r.sources {
+		statuses = append(statuses, r.statusLocked(s))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Source describes one source
+func (r *Registry) Source(name string) (Status, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.sources[name]
+	if s == nil {
+		return Status{}, ErrSourceNotFound
+	}
+	return r.statusLocked(s), nil
+}
+
+func (r *Registry) statusLocked(s *source) Status {
+	return Status{
+		Name:        s.Name,
+		Connector:   s.Connector,
+		Namespace:   s.Namespace,
+		Settings:    s.Settings,
+		Credentials: s.CredentialNames,
+		Items:       len(s.Checksums),
+		Syncing:     r.syncing[s.Name],
+		CreatedAt:   s.CreatedAt,
+		LastSync:    s.LastSync,
+	}
+}
+
+// Add registers a source after checking its settings and credentials against the
+// connector's info. It is synced on the next scheduled run or when Sync is called.
+func (r *Registry) Add(ctx context.Context, req SourceRequest) (Status, error) {
+	if req.Name == "" || req.Namespace == "" {
+		return Status{}, fmt.Errorf("%w: name and namespace are required", connector.ErrInvalidSource)
+	}
+	c, _ := r.connector(req.Connector)
+	if c == nil {
+		return Status{}, fmt.Errorf("%w %q", ErrUnknownConnector, req.Connector)
+	}
+	if err := r.check(ctx, c, req.Name, req.Settings, req.Credentials); err != nil {
+		return Status{}, err
+	}
+
+	r.mu.Lock()
+	exists := r.sources[req.Name] != nil
+	r.mu.Unlock()
+	if exists {
+		return Status{}, ErrSourceExists
+	}
+
+	s := &source{
+		Name:      req.Name,
+		Connector: req.Connector,
+		Namespace: req.Namespace,
+		Settings:  req.Settings,
+		CreatedAt: time.Now().UTC(),
+		Checksums: make(map[string]string),
+	}
+	if err := r.seal(s, req.Credentials); err != nil {
+		return Status{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sources[req.Name] != nil {
+		return Status{}, ErrSourceExists
+	}
+	r.sources[req.Name] = s
+	if err := r.saveLocked(); err != nil {
+		return Status{}, err
+	}
+	return r.statusLocked(s), nil
+}
+
+// SetCredentials replaces a source's credentials, e.g. after a token was rotated
+func (r *Registry) SetCredentials(ctx context.Context, name string, credentials map[string]string) (Status, error) {
+	r.mu.Lock()
+	existing := r.sources[name]
+	if existing == nil {
+		r.mu.Unlock()
+		return Status{}, ErrSourceNotFound
+	}
+	updated := *existing
+	r.mu.Unlock()
+
+	c, _ := r.connector(updated.Connector)
+	if c == nil {
+		return Status{}, fmt.Errorf("%w %q", ErrUnknownConnector, updated.Connector)
+	}
+	if err := r.check(ctx, c, name, updated.Settings, credentials); err != nil {
+		return Status{}, err
+	}
+	if err := r.seal(&updated, credentials); err != nil {
+		return Status{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.sources[name]
+	if s == nil {
+		return Status{}, ErrSourceNotFound
+	}
+	s.CredentialNames, s.Sealed, s.KeyVersion = updated.CredentialNames, updated.Sealed, updated.KeyVersion
+	if err := r.saveLocked(); err != nil {
+		return Status{}, err
+	}
+	return r.statusLocked(s), nil
+}
+
+// Remove unregisters a source and deletes the key its credentials were sealed with. With
+// purge, the documents it synced are deleted from its namespace too.
+func (r *Registry) Remove(ctx context.Context, name string, purge bool) (int, error) {
+	r.mu.Lock()
+	s := r.sources[name]
+	if s == nil {
+		r.mu.Unlock()
+		return 0, ErrSourceNotFound
+	}
+	if r.syncing[name] {
+		r.mu.Unlock()
+		return 0, ErrSyncRunning
+	}
+	ids := make([]string, 0, len(s.Checksums))
+	for id := range s.Checksums {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+
+	if purge && len(ids) > 0 {
+		if err := r.sink.DeleteDocuments(ctx, s.Namespace, ids); err != nil {
+			return 0, err
+		}
+	}
+	if r.secrets != nil {
+		if err := r.secrets.DeleteSecret(secretName(name)); err != nil {
+			return 0, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sources, name)
+	if !purge {
+		ids = nil
+	}
+	return len(ids), r.saveLocked()
+}
+
+// check validates a source against its connector's info
+func (r *Registry) check(ctx context.Context, c connector.Connector, name string, settings, credentials map[string]string) error {
+	info, err := c.Info(ctx)
+	if err != nil {
+		return err
+	}
+	if len(credentials) > 0 && r.secrets == nil {
+		return ErrNoKeyring
+	}
+	return info.Validate(connector.Source{Name: name, Settings: settings, Credentials: credentials})
+}
+
+// seal encrypts credentials into s, or clears them when there are none
+func (r *Registry) seal(s *source, credentials map[string]string) error {
+	s.CredentialNames, s.Sealed, s.KeyVersion = nil, "", 0
+	if len(credentials) == 0 {
+		return nil
+	}
+	plaintext, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+	sealed, version, err := r.secrets.SealSecret(secretName(s.Name), plaintext)
+	if err != nil {
+		return err
+	}
+	for name := range credentials {
+		s.CredentialNames = append(s.CredentialNames, name)
+	}
+	sort.Strings(s.CredentialNames)
+	s.Sealed, s.KeyVersion = base64.StdEncoding.EncodeToString(sealed), version
+	return nil
+}
+
+// open decrypts a source's credentials for one sync
+func (r *Registry) open(s *source) (map[string]string, error) {
+	if s.Sealed == "" {
+		return nil, nil
+	}
+	if r.secrets == nil {
+		return nil, ErrNoKeyring
+	}
+	sealed, err := base64.StdEncoding.DecodeString(s.Sealed)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := r.secrets.OpenSecret(secretName(s.Name), s.KeyVersion, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("credentials of %s cannot be opened: %w", s.Name, err)
+	}
+	var credentials map[string]string
+	return credentials, json.Unmarshal(plaintext, &credentials)
+}
+
+func secretName(source string) string {
+	return "connector/" + source
+}
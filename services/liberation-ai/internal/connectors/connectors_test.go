@@ -0,0 +1,248 @@
+package connectors
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"liberation-ai/internal/encryption"
+	"liberation-ai/pkg/connector"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// tokenConnector takes a token credential and records the source it is listed with
+type tokenConnector struct {
+	listed connector.Source
+}
+
+func (c *tokenConnector) Info(ctx context.Context) (connector.Info, error) {
+	return connector.Info{
+		Name:        "wiki",
+		Protocol:    1,
+		Settings:    []connector.Field{{Name: "space"}},
+		Credentials: []connector.Field{{Name: "token"}, {Name: "user"}},
+	}, nil
+}
+
+func (c *tokenConnector) List(ctx context.Context, req connector.ListRequest) (*connector.ListResponse, error) {
+	c.listed = req.Source
+	return &connector.ListResponse{}, nil
+}
+
+func (c *tokenConnector) Checksum(ctx context.Context, req connector.ChecksumRequest) (*connector.ChecksumResponse, error) {
+	return &connector.ChecksumResponse{}, nil
+}
+
+func (c *tokenConnector) Fetch(ctx context.Context, req connector.FetchRequest) (*connector.Document, error) {
+	return nil, connector.ErrNotFound
+}
+
+type discardSink struct{}
+
+func (discardSink) StoreDocuments(ctx context.Context, namespace string, docs []liberation.Document) (*types.StoreResponse, error) {
+	return &types.StoreResponse{}, nil
+}
+
+func (discardSink) DeleteDocuments(ctx context.Context, namespace string, ids []string) error {
+	return nil
+}
+
+func masterKey(t *testing.T) string {
+	t.Helper()
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func testKeyring(t *testing.T, master, file string) *encryption.Keyring {
+	t.Helper()
+	keyring, err := encryption.Load(encryption.Config{MasterKey: master, KeyringFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// testRegistry keeps its state in dir and seals credentials with secrets
+func testRegistry(t *testing.T, dir string, secrets Secrets) (*Registry, *tokenConnector) {
+	t.Helper()
+	config := DefaultConfig()
+	config.StateFile = filepath.Join(dir, "connectors.json")
+	r, err := New(config, discardSink{}, nil, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki := &tokenConnector{}
+	r.Register("wiki", wiki)
+	return r, wiki
+}
+
+func addWiki(t *testing.T, r *Registry, name string, credentials map[string]string) Status {
+	t.Helper()
+	status, err := r.Add(context.Background(), SourceRequest{
+		Name: name, Connector: "wiki", Namespace: "docs",
+		Settings: map[string]string{"space": "ENG"}, Credentials: credentials,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestCredentialsAreSealed(t *testing.T) {
+	dir := t.TempDir()
+	keyring := testKeyring(t, masterKey(t), filepath.Join(dir, "keyring.json"))
+	r, wiki := testRegistry(t, dir, keyring)
+
+	status := addWiki(t, r, "eng-wiki", map[string]string{"token": "s3cret-token", "user": "bot"})
+	if !slices.Equal(status.Credentials, []string{"token", "user"}) {
+		t.Errorf("credentials listed as %v, want their names only", status.Credentials)
+	}
+	state, err := os.ReadFile(filepath.Join(dir, "connectors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(state), "s3cret-token") || strings.Contains(string(state), "bot") {
+		t.Errorf("state file holds plaintext credentials: %s", state)
+	}
+
+	// Credentials are only opened for the sync, and survive a restart
+	reopened, wiki := testRegistry(t, dir, keyring)
+	if _, err := reopened.Sync(context.Background(), "eng-wiki"); err != nil {
+		t.Fatal(err)
+	}
+	if wiki.listed.Credentials["token"] != "s3cret-token" || wiki.listed.Credentials["user"] != "bot" {
+		t.Errorf("connector was listed with %v", wiki.listed.Credentials)
+	}
+	if wiki.listed.Settings["space"] != "ENG" {
+		t.Errorf("connector was listed with settings %v", wiki.listed.Settings)
+	}
+
+	// Rotated credentials replace the old ones
+	if _, err := reopened.SetCredentials(context.Background(), "eng-wiki", map[string]string{"token": "rotated"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Sync(context.Background(), "eng-wiki"); err != nil {
+		t.Fatal(err)
+	}
+	if len(wiki.listed.Credentials) != 1 || wiki.listed.Credentials["token"] != "rotated" {
+		t.Errorf("connector was listed with %v after rotation", wiki.listed.Credentials)
+	}
+	// The data keys cannot be unwrapped with another master key
+	if _, err := encryption.Load(encryption.Config{MasterKey: masterKey(t), KeyringFile: filepath.Join(dir, "keyring.json")}); err == nil {
+		t.Error("keyring loaded with the wrong master key")
+	}
+}
+
+func TestCredentialsNeedAKeyring(t *testing.T) {
+	r, _ := testRegistry(t, t.TempDir(), nil)
+	_, err := r.Add(context.Background(), SourceRequest{
+		Name: "eng-wiki", Connector: "wiki", Namespace: "docs", Credentials: map[string]string{"token": "t"},
+	})
+	if !errors.Is(err, ErrNoKeyring) {
+		t.Errorf("adding credentials without a keyring: %v, want ErrNoKeyring", err)
+	}
+
+	// Sources without credentials need none
+	status := addWiki(t, r, "public-wiki", nil)
+	if len(status.Credentials) != 0 {
+		t.Errorf("credentials %v listed for a source without any", status.Credentials)
+	}
+}
+
+func TestCredentialsDoNotOpen(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// corrupt changes the stored source, or returns a registry with different secrets
+		corrupt func(t *testing.T, dir string, r *Registry) *Registry
+		want    error
+	}{
+		{
+			name: "wrong data key",
+			corrupt: func(t *testing.T, dir string, r *Registry) *Registry {
+				// Another keyring holds a version 1 key for the same source
+				other := testKeyring(t, masterKey(t), filepath.Join(dir, "other-keyring.json"))
+				if _, _, err := other.SealSecret(secretName("eng-wiki"), []byte("{}")); err != nil {
+					t.Fatal(err)
+				}
+				reopened, _ := testRegistry(t, dir, other)
+				return reopened
+			},
+		},
+		{
+			name: "tampered ciphertext",
+			corrupt: func(t *testing.T, dir string, r *Registry) *Registry {
+				s := r.sources["eng-wiki"]
+				sealed, _ := base64.StdEncoding.DecodeString(s.Sealed)
+				sealed[len(sealed)-1] ^= 0x01
+				s.Sealed = base64.StdEncoding.EncodeToString(sealed)
+				return r
+			},
+		},
+		{
+			name: "sealed for another source",
+			corrupt: func(t *testing.T, dir string, r *Registry) *Registry {
+				addWiki(t, r, "other-wiki", map[string]string{"token": "other"})
+				r.sources["eng-wiki"].Sealed = r.sources["other-wiki"].Sealed
+				return r
+			},
+		},
+		{
+			name: "unknown key version",
+			corrupt: func(t *testing.T, dir string, r *Registry) *Registry {
+				r.sources["eng-wiki"].KeyVersion++
+				return r
+			},
+			want: encryption.ErrUnknownKey,
+		},
+		{
+			name: "key deleted with its source",
+			corrupt: func(t *testing.T, dir string, r *Registry) *Registry {
+				sealed := *r.sources["eng-wiki"]
+				if _, err := r.Remove(context.Background(), "eng-wiki", false); err != nil {
+					t.Fatal(err)
+				}
+				r.sources["eng-wiki"] = &sealed
+				return r
+			},
+			want: encryption.ErrUnknownKey,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			keyring := testKeyring(t, masterKey(t), filepath.Join(dir, "keyring.json"))
+			r, _ := testRegistry(t, dir, keyring)
+			addWiki(t, r, "eng-wiki", map[string]string{"token": "s3cret-token"})
+
+			r = tc.corrupt(t, dir, r)
+			credentials, err := r.open(r.sources["eng-wiki"])
+			if err == nil {
+				t.Fatalf("credentials opened as %v", credentials)
+			}
+			if !strings.Contains(err.Error(), "credentials of eng-wiki cannot be opened") {
+				t.Errorf("error %q does not name the source", err)
+			}
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("error %v, want %v", err, tc.want)
+			}
+			if strings.Contains(err.Error(), "s3cret-token") {
+				t.Errorf("error leaks the credential: %v", err)
+			}
+
+			// The sync fails rather than calling the connector without credentials
+			result, err := r.Sync(context.Background(), "eng-wiki")
+			if err == nil || result.Error == "" {
+				t.Errorf("sync with unopenable credentials: %v, %+v", err, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"liberation-ai/internal/budget"
+	"liberation-ai/pkg/connector"
+	"liberation-ai/pkg/liberation"
+)
+
+// listPage is the page size asked of connectors
+const listPage = 100
+
+// maxSyncErrors bounds the item errors kept in a sync result
+const maxSyncErrors = 10
+
+// SyncResult reports one sync of a source
+type SyncResult struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Listed counts the items the source holds
+	Listed int `json:"listed"`
+	// Stored counts new or changed items fetched and stored
+	Stored    int `json:"stored"`
+	Unchanged int `json:"unchanged"`
+	// Deleted counts items gone from the source whose documents were deleted
+	Deleted int `json:"deleted"`
+	// Failed counts items that could not be fetched or stored; they are retried next time
+	Failed int      `json:"failed"`
+	Errors []string `json:"errors,omitempty"`
+	// Error is why the sync stopped early; deletions are skipped unless the listing completed
+	Error string `json:"error,omitempty"`
+}
+
+func (s *SyncResult) fail(format string, args ...interface{}) {
+	s.Failed++
+	if len(s.Errors) < maxSyncErrors {
+		s.Errors = append(s.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// SyncAll syncs every source in turn; it is the connector_sync job
+func (r *Registry) SyncAll(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.sources))
+	for name := range r.sources {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	var stored, deleted int
+	var failed []string
+	for _, name := range names {
+		result, err := r.Sync(ctx, name)
+		if errors.Is(err, ErrSyncRunning) || errors.Is(err, ErrSourceNotFound) {
+			continue
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+		if result != nil {
+			stored += result.Stored
+			deleted += result.Deleted
+		}
+	}
+	summary := fmt.Sprintf("%d sources: %d documents stored, %d deleted", len(names), stored, deleted)
+	if len(failed) > 0 {
+		return summary, fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return summary, nil
+}
+
+// Sync brings a source's namespace up to date: items whose checksum changed are fetched and
+// stored, and items that disappeared from the source are deleted
+func (r *Registry) Sync(ctx context.Context, name string) (*SyncResult, error) {
+	r.mu.Lock()
+	s := r.sources[name]
+	if s == nil {
+		r.mu.Unlock()
+		return nil, ErrSourceNotFound
+	}
+	if r.syncing[name] {
+		r.mu.Unlock()
+		return nil, ErrSyncRunning
+	}
+	r.syncing[name] = true
+	c := r.connectors[s.Connector]
+	snapshot := *s
+	stored := make(map[string]string, len(s.Checksums))
+	for id, checksum := range s.Checksums {
+		stored[id] = checksum
+	}
+	r.mu.Unlock()
+
+	result := &SyncResult{StartedAt: time.Now().UTC()}
+	checksums, err := r.sync(ctx, c, &snapshot, stored, result)
+	result.FinishedAt = time.Now().UTC()
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.syncing, name)
+	// The source may have been removed while it synced
+	if current := r.sources[name]; current != nil {
+		current.Checksums = checksums
+		current.LastSync = result
+		if saveErr := r.saveLocked(); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+	return result, err
+}
+
+// sync runs the lifecycle hooks and returns the checksums of what is now stored
+func (r *Registry) sync(ctx context.Context, c connector.Connector, s *source, stored map[string]string, result *SyncResult) (map[string]string, error) {
+	if c == nil {
+		return stored, fmt.Errorf("%w %q", ErrUnknownConnector, s.Connector)
+	}
+	credentials, err := r.open(s)
+	if err != nil {
+		return stored, err
+	}
+	src := connector.Source{Name: s.Name, Settings: s.Settings, Credentials: credentials}
+
+	seen := make(map[string]bool)
+	var changed []connector.Item
+	cursor := ""
+	for {
+		page, err := c.List(ctx, connector.ListRequest{Source: src, Cursor: cursor, Limit: listPage})
+		if err != nil {
+			return stored, fmt.Errorf("listing: %w", err)
+		}
+
+		// Items listed without a checksum are fingerprinted in one call per page
+		var unknown []string
+		for _, item := range page.Items {
+			if item.Checksum == "" {
+				unknown = append(unknown, item.ID)
+			}
+		}
+		var fingerprints map[string]string
+		if len(unknown) > 0 {
+			response, err := c.Checksum(ctx, connector.ChecksumRequest{Source: src, IDs: unknown})
+			if err != nil {
+				return stored, fmt.Errorf("checksums: %w", err)
+			}
+			fingerprints = response.Checksums
+		}
+
+		for _, item := range page.Items {
+			if item.Checksum == "" {
+				checksum, exists := fingerprints[item.ID]
+				if !exists {
+					// Gone between listing and fingerprinting
+					continue
+				}
+				item.Checksum = checksum
+			}
+			seen[item.ID] = true
+			result.Listed++
+			if stored[item.ID] == item.Checksum {
+				result.Unchanged++
+				continue
+			}
+			changed = append(changed, item)
+		}
+
+		if page.NextCursor == "" || page.NextCursor == cursor {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	// Fetched documents are stored in batches; a failed batch is retried on the next sync
+	batch := make([]liberation.Document, 0, r.config.BatchSize)
+	checksums := make([]string, 0, r.config.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		defer func() { batch, checksums = batch[:0], checksums[:0] }()
+		if r.meter != nil {
+			if err := r.meter.Charge(s.Namespace, budget.DocumentTokens(batch), int64(len(batch)), true); err != nil {
+				return err
+			}
+		}
+		if _, err := r.sink.StoreDocuments(ctx, s.Namespace, batch); err != nil {
+			for _, doc := range batch {
+				result.fail("%s: %v", doc.ID, err)
+			}
+			return nil
+		}
+		for i, doc := range batch {
+			stored[doc.ID] = checksums[i]
+			result.Stored++
+		}
+		return nil
+	}
+
+	for _, item := range changed {
+		doc, err := c.Fetch(ctx, connector.FetchRequest{Source: src, ID: item.ID})
+		if errors.Is(err, connector.ErrNotFound) {
+			delete(seen, item.ID)
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, connector.ErrUnauthorized) || ctx.Err() != nil {
+				return stored, err
+			}
+			result.fail("%s: %v", item.ID, err)
+			continue
+		}
+
+		metadata := make(map[string]interface{}, len(doc.Metadata)+2)
+		for key, value := range doc.Metadata {
+			metadata[key] = value
+		}
+		metadata["connector"] = s.Connector
+		metadata["connector_source"] = s.Name
+		title := doc.Title
+		if title == "" {
+			title = item.Title
+		}
+		checksum := doc.Checksum
+		if checksum == "" {
+			checksum = item.Checksum
+		}
+		batch = append(batch, liberation.Document{ID: item.ID, Title: title, Content: doc.Content, Metadata: metadata})
+		checksums = append(checksums, checksum)
+		if len(batch) >= r.config.BatchSize {
+			if err := flush(); err != nil {
+				return stored, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return stored, err
+	}
+
+	// Only a complete listing shows what was deleted
+	var gone []string
+	for id := range stored {
+		if !seen[id] {
+			gone = append(gone, id)
+		}
+	}
+	if len(gone) > 0 {
+		sort.Strings(gone)
+		if err := r.sink.DeleteDocuments(ctx, s.Namespace, gone); err != nil {
+			return stored, fmt.Errorf("deleting: %w", err)
+		}
+		for _, id := range gone {
+			delete(stored, id)
+		}
+		result.Deleted = len(gone)
+	}
+	return stored, nil
+}
@@ -0,0 +1,178 @@
+// The dashboard reads GET /v1/admin/dashboard with an admin API key kept in sessionStorage,
+// and refreshes on the interval the server asks for. Values are written with textContent
+// only; namespace and identity names come from callers.
+(function () {
+  "use strict";
+
+  var keyName = "liberation-ai-dashboard-key";
+  var endpoint = "/v1/admin/dashboard";
+  var timer = null;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function cell(row, text, className) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    if (className) {
+      td.className = className;
+    }
+    row.appendChild(td);
+    return td;
+  }
+
+  function number(value) {
+    return Number(value || 0).toLocaleString(undefined, { maximumFractionDigits: 2 });
+  }
+
+  function dollars(value) {
+    return "$" + Number(value || 0).toFixed(2);
+  }
+
+  function bytes(value) {
+    var units = ["B", "KB", "MB", "GB", "TB"];
+    var i = 0;
+    value = Number(value || 0);
+    while (value >= 1024 && i < units.length - 1) {
+      value /= 1024;
+      i++;
+    }
+    return value.toFixed(i === 0 ? 0 : 1) + " " + units[i];
+  }
+
+  function list(id, entries) {
+    var dl = $(id);
+    dl.replaceChildren();
+    entries.forEach(function (entry) {
+      var dt = document.createElement("dt");
+      var dd = document.createElement("dd");
+      dt.textContent = entry[0];
+      dd.textContent = entry[1];
+      dl.append(dt, dd);
+    });
+  }
+
+  function showSignIn(message) {
+    clearTimeout(timer);
+    sessionStorage.removeItem(keyName);
+    $("dashboard").hidden = true;
+    $("sign-out").hidden = true;
+    $("sign-in").hidden = false;
+    $("sign-in-error").textContent = message || "";
+    $("status").textContent = "signed out";
+    $("status").className = "badge";
+  }
+
+  function render(data) {
+    $("status").textContent = data.status;
+    $("status").className = "badge " + (data.status === "ready" ? "ok" : "bad");
+
+    var stats = data.stats || {};
+    var health = [
+      ["Vector store", data.vector_store + (data.healthy ? ", healthy" : ", unhealthy")],
+      ["Vectors", number(stats.total_vectors) + " in " + number(stats.total_namespaces) + " namespaces"],
+      ["Storage", bytes(stats.storage_size_bytes)],
+      ["Memory", bytes(data.memory_bytes)]
+    ];
+    if (data.stats_error) {
+      health.push(["Stats", data.stats_error]);
+    }
+    if (stats.performance) {
+      health.push(["Average search", number(stats.performance.avg_search_time_ms) + " ms"]);
+    }
+    if (data.failover) {
+      health.push(["Failover", data.failover.mode + ", " + number(data.failover.queued_writes) + " writes queued"]);
+    }
+    if (data.migration) {
+      health.push(["Migration", data.migration.status + ", " + number(data.migration.percent_complete) + "%"]);
+    }
+    list("health", health);
+
+    var cost = data.cost || {};
+    list("cost", [
+      ["Month to date", dollars(cost.month_to_date)],
+      ["Projected for the month", dollars(cost.projected_month)]
+    ]);
+
+    var namespaces = $("namespaces");
+    namespaces.replaceChildren();
+    if (!data.namespaces || data.namespaces.length === 0) {
+      cell(namespaces.insertRow(), "No namespaces yet.").colSpan = 6;
+    }
+    (data.namespaces || []).forEach(function (ns) {
+      var row = namespaces.insertRow();
+      cell(row, ns.namespace);
+      cell(row, number(ns.vectors), "number");
+      cell(row, dollars(ns.spent), "number");
+      var budget = ns.monthly_limit ? dollars(ns.monthly_limit) + " (" + number(ns.percent_used) + "%)" : "";
+      if (ns.mode && ns.mode !== "normal") {
+        budget += " " + ns.mode;
+      }
+      cell(row, budget);
+      cell(row, number(ns.embeddings), "number");
+      cell(row, number(ns.tokens), "number");
+    });
+
+    var usage = $("usage");
+    usage.replaceChildren();
+    if (!data.usage || data.usage.length === 0) {
+      cell(usage.insertRow(), "No callers yet.").colSpan = 4;
+    }
+    (data.usage || []).forEach(function (u) {
+      var row = usage.insertRow();
+      cell(row, u.key_hint ? u.key_hint + " (" + u.identity + ")" : u.identity);
+      cell(row, number(u.embeddings_used), "number");
+      cell(row, u.unlimited ? "unlimited" : number(u.embeddings_limit), "number");
+      cell(row, number(u.requests_per_second), "number");
+    });
+
+    $("updated").textContent = "Updated " + new Date(data.generated_at).toLocaleTimeString();
+    $("sign-in").hidden = true;
+    $("sign-out").hidden = false;
+    $("dashboard").hidden = false;
+  }
+
+  function load() {
+    var key = sessionStorage.getItem(keyName);
+    if (!key) {
+      showSignIn();
+      return;
+    }
+    fetch(endpoint, { headers: { "X-API-Key": key }, cache: "no-store" })
+      .then(function (response) {
+        if (response.status === 401 || response.status === 403) {
+          showSignIn("That key is not an admin API key.");
+          return null;
+        }
+        if (!response.ok) {
+          throw new Error("The dashboard endpoint answered " + response.status);
+        }
+        return response.json();
+      })
+      .then(function (data) {
+        if (data) {
+          render(data);
+          timer = setTimeout(load, (data.refresh_seconds || 15) * 1000);
+        }
+      })
+      .catch(function (err) {
+        $("status").textContent = "unreachable";
+        $("status").className = "badge bad";
+        $("updated").textContent = err.message;
+        timer = setTimeout(load, 15000);
+      });
+  }
+
+  $("sign-in").addEventListener("submit", function (event) {
+    event.preventDefault();
+    sessionStorage.setItem(keyName, $("key").value.trim());
+    $("key").value = "";
+    load();
+  });
+  $("sign-out").addEventListener("click", function () {
+    showSignIn();
+  });
+
+  load();
+})();
@@ -0,0 +1,116 @@
+// Package dashboard serves a small read-only status page for self-hosters who do not run
+// Grafana: store health, vector counts and this month's embedding spend per namespace.
+//
+// The page, its script and its stylesheet are built into the binary. They hold no data: the
+// page asks for an admin API key, keeps it in the tab's sessionStorage and reads
+// GET /v1/admin/dashboard with it, so the page can be served to anyone.
+package dashboard
+
+import (
+	"embed"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/budget"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// Config controls the dashboard
+type Config struct {
+	// Enabled serves the page at /dashboard/ and its data at /v1/admin/dashboard
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// RefreshSeconds is how often the page reloads its data
+	RefreshSeconds int `yaml:"refresh_seconds" json:"refresh_seconds"`
+}
+
+// DefaultConfig leaves the dashboard off, refreshing every 15 seconds once enabled
+func DefaultConfig() Config {
+	return Config{RefreshSeconds: 15}
+}
+
+// Validate checks the refresh interval
+func (c Config) Validate() error {
+	if c.Enabled && c.RefreshSeconds < 1 {
+		return fmt.Errorf("dashboard.refresh_seconds must be at least 1")
+	}
+	return nil
+}
+
+// Handler serves the page's files under a /*file route; /dashboard/ is the page itself.
+// Everything answers 404 while the dashboard is disabled.
+func Handler(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := strings.TrimPrefix(c.Param("file"), "/")
+		if name == "" {
+			name = "index.html"
+		}
+		if !config.Enabled || strings.Contains(name, "..") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		data, err := assets.ReadFile(path.Join("assets", name))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Content-Security-Policy", "default-src 'self'")
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("X-Frame-Options", "DENY")
+		c.Data(http.StatusOK, mime.TypeByExtension(path.Ext(name)), data)
+	}
+}
+
+// Namespace is one row of the dashboard's namespace table
+type Namespace struct {
+	Namespace string `json:"namespace"`
+	Vectors   int64  `json:"vectors"`
+	// Spent is this month's embedding spend in US dollars
+	Spent      float64 `json:"spent"`
+	Tokens     int64   `json:"tokens"`
+	Embeddings int64   `json:"embeddings"`
+	// MonthlyLimit and PercentUsed are set for namespaces with a budget
+	MonthlyLimit float64 `json:"monthly_limit,omitempty"`
+	PercentUsed  float64 `json:"percent_used,omitempty"`
+	Mode         string  `json:"mode,omitempty"`
+}
+
+// Namespaces joins the store's vector counts with the month's spend, one row per namespace
+// that has vectors or spend, sorted by name
+func Namespaces(vectors map[string]int64, spend []budget.Status) []Namespace {
+	rows := make(map[string]*Namespace, len(vectors))
+	row := func(namespace string) *Namespace {
+		if rows[namespace] == nil {
+			rows[namespace] = &Namespace{Namespace: namespace}
+		}
+		return rows[namespace]
+	}
+	for namespace, count := range vectors {
+		row(namespace).Vectors = count
+	}
+	for _, status := range spend {
+		r := row(status.Namespace)
+		r.Spent, r.Tokens, r.Embeddings = status.Spent, status.Tokens, status.Embeddings
+		r.PercentUsed, r.Mode = status.PercentUsed, status.Mode
+		if status.Budget != nil {
+			r.MonthlyLimit = status.MonthlyLimit
+		}
+	}
+
+	result := make([]Namespace, 0, len(rows))
+	for _, r := range rows {
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Namespace < result[j].Namespace
+	})
+	return result
+}
@@ -0,0 +1,319 @@
+package drift
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"liberation-ai/internal/budget"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// Campaign states
+const (
+	CampaignScheduled = "scheduled"
+	CampaignRunning   = "running"
+	CampaignCompleted = "completed"
+	// CampaignCapped stopped before the next batch would have gone over max_cost
+	CampaignCapped    = "capped"
+	CampaignCancelled = "cancelled"
+	CampaignFailed    = "failed"
+)
+
+// maxBatch bounds the vectors re-embedded in one call to the embedding provider
+const maxBatch = 100
+
+var (
+	// ErrCampaignActive is returned when a namespace already has a scheduled or running campaign
+	ErrCampaignActive = errors.New("namespace already has an active re-embedding campaign")
+
+	// ErrCampaignNotFound is returned for unknown campaign IDs
+	ErrCampaignNotFound = errors.New("re-embedding campaign not found")
+)
+
+// CampaignRequest schedules the re-embedding of a whole namespace
+type CampaignRequest struct {
+	Namespace string `json:"namespace"`
+	// StartAt delays the campaign, e.g. to a quiet hour; zero starts it now
+	StartAt time.Time `json:"start_at,omitempty"`
+	// VectorsPerSecond throttles the campaign; zero uses vectors_per_second
+	VectorsPerSecond int `json:"vectors_per_second,omitempty"`
+	// MaxCost caps the campaign's spend in US dollars; zero uses max_campaign_cost, the most allowed
+	MaxCost float64 `json:"max_cost,omitempty"`
+	// After resumes from an earlier campaign's cursor, skipping vectors with IDs up to it
+	After string `json:"after,omitempty"`
+}
+
+// Campaign reports a re-embedding campaign's progress
+type Campaign struct {
+	ID               string    `json:"id"`
+	Namespace        string    `json:"namespace"`
+	Status           string    `json:"status"`
+	StartAt          time.Time `json:"start_at"`
+	VectorsPerSecond int       `json:"vectors_per_second"`
+	MaxCost          float64   `json:"max_cost"`
+	Refreshed        int64     `json:"refreshed"`
+	// Skipped counts vectors stored without text and those changed while the campaign ran
+	Skipped        int64      `json:"skipped"`
+	Tokens         int64      `json:"tokens"`
+	Cost           float64    `json:"cost"`
+	MeanSimilarity float64    `json:"mean_similarity"`
+	Cursor         string     `json:"cursor,omitempty"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+type campaign struct {
+	campaign      Campaign
+	cancel        context.CancelFunc
+	similaritySum float64
+}
+
+// Campaigns runs re-embedding campaigns in the background, one per namespace at a time.
+// Campaigns are kept in memory: one interrupted by a restart can be resumed by starting
+// another with its cursor as after.
+type Campaigns struct {
+	config  Config
+	service *liberation.Service
+	meter   Meter
+	now     func() time.Time
+
+	mu        sync.Mutex
+	campaigns map[string]*campaign
+}
+
+// NewCampaigns creates a campaign scheduler
+func NewCampaigns(config Config, service *liberation.Service, meter Meter) *Campaigns {
+	return &Campaigns{
+		config:    config,
+		service:   service,
+		meter:     meter,
+		now:       time.Now,
+		campaigns: make(map[string]*campaign),
+	}
+}
+
+// Start schedules a campaign, filling in the configured throttle and cost cap
+func (c *Campaigns) Start(req CampaignRequest) (*Campaign, error) {
+	if req.Namespace == "" {
+		return nil, fmt.Errorf("namespace is required")
+	}
+	if req.VectorsPerSecond == 0 {
+		req.VectorsPerSecond = c.config.VectorsPerSecond
+	}
+	if req.MaxCost == 0 {
+		req.MaxCost = c.config.MaxCampaignCost
+	}
+	if req.VectorsPerSecond < 0 {
+		return nil, fmt.Errorf("vectors_per_second must be positive")
+	}
+	if req.MaxCost < 0 || req.MaxCost > c.config.MaxCampaignCost {
+		return nil, fmt.Errorf("max_cost must be between 0 and %.2f", c.config.MaxCampaignCost)
+	}
+	now := c.now()
+	if req.StartAt.Before(now) {
+		req.StartAt = now
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, existing := range c.campaigns {
+		if existing.campaign.Namespace == req.Namespace && active(existing.campaign.Status) {
+			return nil, ErrCampaignActive
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &campaign{
+		campaign: Campaign{
+			ID:               newCampaignID(),
+			Namespace:        req.Namespace,
+			Status:           CampaignScheduled,
+			StartAt:          req.StartAt,
+			VectorsPerSecond: req.VectorsPerSecond,
+			MaxCost:          req.MaxCost,
+			Cursor:           req.After,
+		},
+		cancel: cancel,
+	}
+	c.campaigns[run.campaign.ID] = run
+	go c.run(ctx, run)
+
+	snapshot := run.campaign
+	return &snapshot, nil
+}
+
+// Get returns a campaign's current state
+func (c *Campaigns) Get(id string) (*Campaign, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	run, exists := c.campaigns[id]
+	if !exists {
+		return nil, false
+	}
+	snapshot := run.campaign
+	return &snapshot, true
+}
+
+// List returns every campaign, most recently scheduled first
+func (c *Campaigns) List() []Campaign {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	campaigns := make([]Campaign, 0, len(c.campaigns))
+	for _, run := range c.campaigns {
+		campaigns = append(campaigns, run.campaign)
+	}
+	sort.Slice(campaigns, func(i, j int) bool {
+		return campaigns[i].StartAt.After(campaigns[j].StartAt)
+	})
+	return campaigns
+}
+
+// Cancel stops a scheduled or running campaign after its current batch. Vectors already
+// refreshed keep their new embeddings.
+func (c *Campaigns) Cancel(id string) (*Campaign, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	run, exists := c.campaigns[id]
+	if !exists {
+		return nil, ErrCampaignNotFound
+	}
+	if active(run.campaign.Status) {
+		run.cancel()
+		c.finish(run, CampaignCancelled, nil)
+	}
+	snapshot := run.campaign
+	return &snapshot, nil
+}
+
+func (c *Campaigns) run(ctx context.Context, run *campaign) {
+	defer run.cancel()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Until(run.campaign.StartAt)):
+	}
+
+	c.mu.Lock()
+	if !active(run.campaign.Status) {
+		c.mu.Unlock()
+		return
+	}
+	startedAt := c.now()
+	run.campaign.Status = CampaignRunning
+	run.campaign.StartedAt = &startedAt
+	namespace, cursor := run.campaign.Namespace, run.campaign.Cursor
+	rate, maxCost := run.campaign.VectorsPerSecond, run.campaign.MaxCost
+	c.mu.Unlock()
+
+	batchSize := min(rate, maxBatch)
+	var spent float64
+	for {
+		batchStart := time.Now()
+		batch, err := c.service.ListVectors(ctx, namespace, cursor, batchSize)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.end(run, CampaignFailed, err)
+			return
+		}
+		if len(batch) == 0 {
+			c.end(run, CampaignCompleted, nil)
+			return
+		}
+
+		var tokens int64
+		var cost float64
+		for _, vector := range batch {
+			if vector.Text == "" {
+				continue
+			}
+			embedded := budget.EstimateTokens(liberation.EmbeddingText(vector))
+			model, _ := vector.Metadata["embedding_model"].(string)
+			tokens += embedded
+			cost += c.meter.Cost(model, embedded)
+		}
+		if spent+cost > maxCost {
+			c.end(run, CampaignCapped, fmt.Errorf("the next %d vectors would take spend to $%.4f, over the $%.4f cap", len(batch), spent+cost, maxCost))
+			return
+		}
+
+		refreshed, err := c.service.Refresh(ctx, namespace, batch)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.end(run, CampaignFailed, err)
+			}
+			return
+		}
+		spent += cost
+		c.meter.Charge(namespace, tokens, int64(len(refreshed)), false)
+		cursor = batch[len(batch)-1].ID
+		c.progress(run, batch, refreshed, tokens, cost, cursor)
+
+		// Throttle to the campaign's rate, so live traffic keeps most of the provider's capacity
+		wait := time.Duration(float64(len(batch))/float64(rate)*float64(time.Second)) - time.Since(batchStart)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Campaigns) progress(run *campaign, batch []types.Vector, refreshed []liberation.Reembedding, tokens int64, cost float64, cursor string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, r := range refreshed {
+		run.similaritySum += r.Similarity
+	}
+	run.campaign.Refreshed += int64(len(refreshed))
+	run.campaign.Skipped += int64(len(batch) - len(refreshed))
+	run.campaign.Tokens += tokens
+	run.campaign.Cost += cost
+	run.campaign.Cursor = cursor
+	if run.campaign.Refreshed > 0 {
+		run.campaign.MeanSimilarity = run.similaritySum / float64(run.campaign.Refreshed)
+	}
+}
+
+func (c *Campaigns) end(run *campaign, status string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if active(run.campaign.Status) {
+		c.finish(run, status, err)
+	}
+}
+
+// finish must be called with mu held
+func (c *Campaigns) finish(run *campaign, status string, err error) {
+	completedAt := c.now()
+	run.campaign.Status = status
+	run.campaign.CompletedAt = &completedAt
+	if err != nil {
+		run.campaign.Error = err.Error()
+	}
+}
+
+func active(status string) bool {
+	return status == CampaignScheduled || status == CampaignRunning
+}
+
+func newCampaignID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "reembed_" + hex.EncodeToString(b)
+}
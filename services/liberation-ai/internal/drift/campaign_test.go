@@ -0,0 +1,137 @@
+package drift
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"liberation-ai/pkg/types"
+)
+
+func waitForCampaign(t *testing.T, campaigns *Campaigns, id string) *Campaign {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		campaign, ok := campaigns.Get(id)
+		if !ok {
+			t.Fatalf("campaign %s not found", id)
+		}
+		if !active(campaign.Status) {
+			return campaign
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("campaign still %s", campaign.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testCampaigns(t *testing.T, count int) (*Campaigns, *angledEmbedder, *meter) {
+	t.Helper()
+	embedder := &angledEmbedder{similarity: 1}
+	service := testService(t, embedder, "docs", count)
+	config := DefaultConfig()
+	config.VectorsPerSecond = 1000
+	m := &meter{}
+	return NewCampaigns(config, service, m), embedder, m
+}
+
+func TestStartRefuses(t *testing.T) {
+	campaigns, _, _ := testCampaigns(t, 1)
+	for _, tc := range []struct {
+		name string
+		req  CampaignRequest
+	}{
+		{"no namespace", CampaignRequest{}},
+		{"negative rate", CampaignRequest{Namespace: "docs", VectorsPerSecond: -1}},
+		{"negative cost", CampaignRequest{Namespace: "docs", MaxCost: -1}},
+		{"over the cost cap", CampaignRequest{Namespace: "docs", MaxCost: 5.01}},
+	} {
+		if campaign, err := campaigns.Start(tc.req); err == nil {
+			t.Errorf("%s: started %+v", tc.name, campaign)
+		}
+	}
+
+	later, err := campaigns.Start(CampaignRequest{Namespace: "docs", StartAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if later.Status != CampaignScheduled || later.VectorsPerSecond != 1000 || later.MaxCost != 5 {
+		t.Errorf("scheduled %+v", later)
+	}
+	if _, err := campaigns.Start(CampaignRequest{Namespace: "docs"}); !errors.Is(err, ErrCampaignActive) {
+		t.Errorf("second campaign: %v", err)
+	}
+
+	cancelled, err := campaigns.Cancel(later.ID)
+	if err != nil || cancelled.Status != CampaignCancelled || cancelled.CompletedAt == nil {
+		t.Errorf("Cancel: %+v, %v", cancelled, err)
+	}
+	if again, err := campaigns.Cancel(later.ID); err != nil || again.Status != CampaignCancelled {
+		t.Errorf("cancelling twice: %+v, %v", again, err)
+	}
+	if _, err := campaigns.Cancel("reembed_missing"); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("unknown campaign: %v", err)
+	}
+
+	// A cancelled campaign no longer blocks its namespace
+	next, err := campaigns.Start(CampaignRequest{Namespace: "docs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCampaign(t, campaigns, next.ID)
+	if list := campaigns.List(); len(list) != 2 || list[0].ID != later.ID {
+		t.Errorf("campaigns, latest start first: %+v", list)
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	campaigns, embedder, m := testCampaigns(t, 5)
+	service := campaigns.service
+	service.StoreVectors(context.Background(), &types.StoreRequest{Namespace: "docs", Vectors: []types.Vector{{ID: "v9", Embedding: []float32{1, 0, 0}}}})
+	embedder.drift(0.6)
+
+	// Resuming after v1 leaves v0 and v1 alone
+	started, err := campaigns.Start(CampaignRequest{Namespace: "docs", VectorsPerSecond: 2, After: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	campaign := waitForCampaign(t, campaigns, started.ID)
+	if campaign.Status != CampaignCompleted || campaign.Refreshed != 3 || campaign.Skipped != 1 || campaign.Cursor != "v9" ||
+		campaign.Tokens != 6 || math.Abs(campaign.Cost-0.006) > 1e-9 || math.Abs(campaign.MeanSimilarity-0.6) > 1e-6 ||
+		campaign.StartedAt == nil || campaign.CompletedAt == nil {
+		t.Errorf("campaign %+v", campaign)
+	}
+	if m.tokens("docs") != 6 {
+		t.Errorf("%d tokens charged", m.tokens("docs"))
+	}
+
+	for id, refreshed := range map[string]bool{"v0": false, "v1": false, "v2": true, "v4": true} {
+		vector, err := service.GetVector(context.Background(), "docs", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := math.Abs(float64(vector.Embedding[0])-0.6) < 1e-6; got != refreshed {
+			t.Errorf("%s refreshed %v: %v", id, got, vector.Embedding)
+		}
+	}
+}
+
+func TestCampaignCapped(t *testing.T) {
+	campaigns, _, m := testCampaigns(t, 3)
+
+	// Each vector costs $0.002, so the first batch of two fits under $0.005 and the second does not
+	started, err := campaigns.Start(CampaignRequest{Namespace: "docs", VectorsPerSecond: 2, MaxCost: 0.005})
+	if err != nil {
+		t.Fatal(err)
+	}
+	campaign := waitForCampaign(t, campaigns, started.ID)
+	if campaign.Status != CampaignCapped || campaign.Refreshed != 2 || campaign.Cursor != "v1" || campaign.Error == "" {
+		t.Errorf("capped campaign %+v", campaign)
+	}
+	if m.tokens("docs") != 4 {
+		t.Errorf("%d tokens charged", m.tokens("docs"))
+	}
+}
@@ -0,0 +1,308 @@
+// Package drift notices when an embedding model starts producing different vectors for
+// the same text, and refreshes namespaces embedded with the old weights.
+//
+// Providers sometimes update a model's weights behind an unchanged name. Vectors stored
+// before the update then sit in a slightly different space from queries embedded after
+// it, and search quality decays without any error. The detector re-embeds a fixed sample
+// of each namespace on a schedule and compares the new embeddings with the stored ones;
+// when they have moved further than max_drift it alerts, and with auto_refresh set it
+// starts a re-embedding campaign.
+package drift
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"liberation-ai/internal/budget"
+	"liberation-ai/pkg/liberation"
+)
+
+// Config controls drift checks and re-embedding campaigns
+type Config struct {
+	// Enabled runs drift checks; campaigns can be started by admins either way
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// IntervalMinutes is how often each namespace's sample is re-embedded
+	IntervalMinutes int `yaml:"interval_minutes" json:"interval_minutes"`
+	// SampleSize is how many vectors per namespace are re-embedded by each check
+	SampleSize int `yaml:"sample_size" json:"sample_size"`
+	// Namespaces limits checks to these namespaces; empty checks every namespace
+	Namespaces []string `yaml:"namespaces" json:"namespaces,omitempty"`
+	// MaxDrift is the largest tolerated drop in mean cosine similarity between stored and
+	// fresh embeddings of the same text
+	MaxDrift float64 `yaml:"max_drift" json:"max_drift"`
+	// AutoRefresh starts a campaign with the defaults below when a namespace drifts
+	AutoRefresh bool `yaml:"auto_refresh" json:"auto_refresh"`
+	// VectorsPerSecond throttles campaigns that do not set their own rate
+	VectorsPerSecond int `yaml:"vectors_per_second" json:"vectors_per_second"`
+	// MaxCampaignCost caps each campaign's embedding spend, in US dollars
+	MaxCampaignCost float64              `yaml:"max_campaign_cost" json:"max_campaign_cost"`
+	Webhook         budget.WebhookConfig `yaml:"webhook" json:"webhook"`
+}
+
+// DefaultConfig leaves checks off; once enabled, 50 vectors per namespace are checked
+// every six hours and a 2% drop in similarity alerts
+func DefaultConfig() Config {
+	return Config{
+		IntervalMinutes:  360,
+		SampleSize:       50,
+		MaxDrift:         0.02,
+		VectorsPerSecond: 20,
+		MaxCampaignCost:  5,
+	}
+}
+
+// ApplyEnv reads the webhook secret from prefix + WEBHOOK_SECRET, so it need not live in the config file
+func (c *Config) ApplyEnv(prefix string) {
+	if secret := os.Getenv(prefix + "WEBHOOK_SECRET"); secret != "" {
+		c.Webhook.Secret = secret
+	}
+}
+
+// Validate reports settings that would make checks or campaigns misbehave
+func (c Config) Validate() error {
+	switch {
+	case c.IntervalMinutes <= 0:
+		return fmt.Errorf("interval_minutes must be positive")
+	case c.SampleSize < 1 || c.SampleSize > 1000:
+		return fmt.Errorf("sample_size must be between 1 and 1000")
+	case c.MaxDrift <= 0 || c.MaxDrift >= 1:
+		return fmt.Errorf("max_drift must be between 0 and 1")
+	case c.VectorsPerSecond <= 0:
+		return fmt.Errorf("vectors_per_second must be positive")
+	case c.MaxCampaignCost < 0:
+		return fmt.Errorf("max_campaign_cost must not be negative")
+	}
+	return nil
+}
+
+// Meter prices re-embedding and records it against namespace budgets
+type Meter interface {
+	Cost(model string, tokens int64) float64
+	Charge(namespace string, tokens, embeddings int64, write bool) error
+}
+
+// Report is the outcome of one drift check
+type Report struct {
+	Namespace string `json:"namespace"`
+	// Sampled counts the vectors re-embedded; vectors stored without text cannot be
+	Sampled int `json:"sampled"`
+	// MeanSimilarity, P05Similarity and MinSimilarity summarise the cosine similarity
+	// between each stored embedding and a fresh one of the same text
+	MeanSimilarity float64 `json:"mean_similarity"`
+	P05Similarity  float64 `json:"p05_similarity"`
+	MinSimilarity  float64 `json:"min_similarity"`
+	// Drift is 1 - MeanSimilarity: 0 while the model is unchanged
+	Drift     float64   `json:"drift"`
+	Drifted   bool      `json:"drifted"`
+	Cost      float64   `json:"cost"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Summarize turns per-vector similarities into a report's distribution
+func Summarize(similarities []float64) (mean, p05, lowest float64) {
+	if len(similarities) == 0 {
+		return 0, 0, 0
+	}
+	sorted := append([]float64(nil), similarities...)
+	sort.Float64s(sorted)
+	for _, similarity := range sorted {
+		mean += similarity
+	}
+	return mean / float64(len(sorted)), sorted[len(sorted)*5/100], sorted[0]
+}
+
+// Detector re-embeds samples on a schedule and keeps the latest report per namespace
+type Detector struct {
+	config    Config
+	service   *liberation.Service
+	meter     Meter
+	campaigns *Campaigns
+	client    *http.Client
+	now       func() time.Time
+
+	mu      sync.Mutex
+	reports map[string]Report
+}
+
+// NewDetector creates a detector; campaigns, when set, are started for drifted namespaces
+// if auto_refresh is on
+func NewDetector(config Config, service *liberation.Service, meter Meter, campaigns *Campaigns) *Detector {
+	return &Detector{
+		config:    config,
+		service:   service,
+		meter:     meter,
+		campaigns: campaigns,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		now:       time.Now,
+		reports:   make(map[string]Report),
+	}
+}
+
+// Enabled reports whether scheduled checks run
+func (d *Detector) Enabled() bool {
+	return d.config.Enabled
+}
+
+// Schedule is the drift_check job's built-in schedule: every interval_minutes
+func (d *Detector) Schedule() string {
+	return fmt.Sprintf("@every %dm", d.config.IntervalMinutes)
+}
+
+// Run is the drift_check job: it checks every namespace once and summarises the reports
+func (d *Detector) Run(ctx context.Context) (string, error) {
+	reports := d.CheckAll(ctx)
+	if reports == nil {
+		return "", fmt.Errorf("namespaces could not be listed")
+	}
+	drifted, failed := 0, 0
+	for _, report := range reports {
+		if report.Drifted {
+			drifted++
+		}
+		if report.Error != "" {
+			failed++
+		}
+	}
+	return fmt.Sprintf("checked %d namespaces: %d drifted, %d failed", len(reports), drifted, failed), nil
+}
+
+// CheckAll checks the configured namespaces, or every namespace when none are configured
+func (d *Detector) CheckAll(ctx context.Context) []Report {
+	namespaces := d.config.Namespaces
+	if len(namespaces) == 0 {
+		var err error
+		if namespaces, err = d.service.ListNamespaces(ctx); err != nil {
+			log.Printf("drift: listing namespaces: %v", err)
+			return nil
+		}
+	}
+
+	reports := make([]Report, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		if ctx.Err() != nil {
+			break
+		}
+		reports = append(reports, d.Check(ctx, namespace))
+	}
+	return reports
+}
+
+// Check re-embeds a namespace's sample and compares it with the stored embeddings. The
+// sample is the first sample_size vectors by ID, so successive checks compare the same
+// texts. A namespace is alerted on when it first drifts, not again on every check.
+func (d *Detector) Check(ctx context.Context, namespace string) Report {
+	report := Report{Namespace: namespace, CheckedAt: d.now()}
+
+	sample, err := d.service.ListVectors(ctx, namespace, "", d.config.SampleSize)
+	if err == nil {
+		var reembedded []liberation.Reembedding
+		if reembedded, err = d.service.Reembed(ctx, sample); err == nil {
+			similarities := make([]float64, len(reembedded))
+			var tokens int64
+			for i, r := range reembedded {
+				similarities[i] = r.Similarity
+				embedded := budget.EstimateTokens(liberation.EmbeddingText(r.Vector))
+				tokens += embedded
+				report.Cost += d.meter.Cost(r.Model, embedded)
+			}
+			report.Sampled = len(reembedded)
+			report.MeanSimilarity, report.P05Similarity, report.MinSimilarity = Summarize(similarities)
+			if report.Sampled > 0 {
+				report.Drift = 1 - report.MeanSimilarity
+				report.Drifted = report.Drift > d.config.MaxDrift
+				d.meter.Charge(namespace, tokens, int64(report.Sampled), false)
+			}
+		}
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	wasDrifted := d.reports[namespace].Drifted
+	d.reports[namespace] = report
+	d.mu.Unlock()
+
+	if report.Drifted && !wasDrifted {
+		log.Printf("drift: namespace %s has drifted %.4f (mean similarity %.4f over %d vectors)", namespace, report.Drift, report.MeanSimilarity, report.Sampled)
+		campaign := d.autoRefresh(namespace)
+		if d.config.Webhook.URL != "" {
+			go d.notify(report, campaign)
+		}
+	}
+	return report
+}
+
+// Reports returns the latest check of every namespace checked so far
+func (d *Detector) Reports() []Report {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	reports := make([]Report, 0, len(d.reports))
+	for _, report := range d.reports {
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Namespace < reports[j].Namespace
+	})
+	return reports
+}
+
+// autoRefresh starts a campaign for a drifted namespace when configured to, returning its ID
+func (d *Detector) autoRefresh(namespace string) string {
+	if !d.config.AutoRefresh || d.campaigns == nil {
+		return ""
+	}
+	campaign, err := d.campaigns.Start(CampaignRequest{Namespace: namespace})
+	if err != nil {
+		log.Printf("drift: refreshing %s: %v", namespace, err)
+		return ""
+	}
+	return campaign.ID
+}
+
+// notify posts a drift alert as JSON, signed like budget alerts. Delivery is best effort.
+func (d *Detector) notify(report Report, campaign string) {
+	body, err := json.Marshal(struct {
+		Type string `json:"type"`
+		Report
+		Campaign string `json:"campaign,omitempty"`
+	}{Type: "embedding_drift", Report: report, Campaign: campaign})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.config.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("drift webhook for %s: %v", report.Namespace, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.config.Webhook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(d.config.Webhook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		log.Printf("drift webhook for %s: %v", report.Namespace, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("drift webhook for %s returned %d", report.Namespace, resp.StatusCode)
+	}
+}
@@ -0,0 +1,227 @@
+package drift
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// angledEmbedder embeds every text at the same angle from [1, 0, 0], so vectors stored as
+// [1, 0, 0] re-embed with exactly the configured cosine similarity
+type angledEmbedder struct {
+	mu         sync.Mutex
+	similarity float64
+}
+
+func (a *angledEmbedder) Dimensions() int { return 3 }
+
+func (a *angledEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	a.mu.Lock()
+	similarity := a.similarity
+	a.mu.Unlock()
+	embeddings := make([][]float32, len(texts))
+	for i := range texts {
+		embeddings[i] = []float32{float32(similarity), float32(math.Sqrt(1 - similarity*similarity)), 0}
+	}
+	return embeddings, nil
+}
+
+func (a *angledEmbedder) drift(similarity float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.similarity = similarity
+}
+
+// meter prices each token at a tenth of a cent and keeps the tokens charged
+type meter struct {
+	mu      sync.Mutex
+	charged map[string]int64
+}
+
+func (m *meter) Cost(model string, tokens int64) float64 {
+	return float64(tokens) / 1000
+}
+
+func (m *meter) Charge(namespace string, tokens, embeddings int64, write bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.charged == nil {
+		m.charged = make(map[string]int64)
+	}
+	m.charged[namespace] += tokens
+	return nil
+}
+
+func (m *meter) tokens(namespace string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.charged[namespace]
+}
+
+// testService stores count vectors, "v0" to "v<count-1>", in namespace, all with text "ladder"
+func testService(t *testing.T, embedder *angledEmbedder, namespace string, count int) *liberation.Service {
+	t.Helper()
+	service := liberation.New(liberation.NewMemoryStore(3), embedder)
+	storeUnit(t, service, namespace, count)
+	return service
+}
+
+func storeUnit(t *testing.T, service *liberation.Service, namespace string, count int) {
+	t.Helper()
+	vectors := make([]types.Vector, count)
+	for i := range vectors {
+		vectors[i] = types.Vector{ID: "v" + string(rune('0'+i)), Text: "ladder", Embedding: []float32{1, 0, 0}}
+	}
+	if _, err := service.StoreVectors(context.Background(), &types.StoreRequest{Namespace: namespace, Vectors: vectors}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		valid  bool
+	}{
+		{"default", func(c *Config) {}, true},
+		{"no interval", func(c *Config) { c.IntervalMinutes = 0 }, false},
+		{"empty sample", func(c *Config) { c.SampleSize = 0 }, false},
+		{"huge sample", func(c *Config) { c.SampleSize = 1001 }, false},
+		{"no drift allowed", func(c *Config) { c.MaxDrift = 0 }, false},
+		{"any drift allowed", func(c *Config) { c.MaxDrift = 1 }, false},
+		{"unthrottled", func(c *Config) { c.VectorsPerSecond = 0 }, false},
+		{"negative cost cap", func(c *Config) { c.MaxCampaignCost = -1 }, false},
+		{"free campaigns only", func(c *Config) { c.MaxCampaignCost = 0 }, true},
+	} {
+		config := DefaultConfig()
+		tc.change(&config)
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	if mean, p05, lowest := Summarize(nil); mean != 0 || p05 != 0 || lowest != 0 {
+		t.Errorf("empty summary %v %v %v", mean, p05, lowest)
+	}
+
+	similarities := make([]float64, 20)
+	for i := range similarities {
+		similarities[i] = 1
+	}
+	similarities[7], similarities[13] = 0.5, 0.7
+	mean, p05, lowest := Summarize(similarities)
+	if math.Abs(mean-0.96) > 1e-9 || p05 != 0.7 || lowest != 0.5 {
+		t.Errorf("summary %v %v %v", mean, p05, lowest)
+	}
+	if similarities[7] != 0.5 {
+		t.Error("Summarize sorted its input")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	alerts := make(chan map[string]interface{}, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var alert map[string]interface{}
+		json.Unmarshal(body, &alert)
+		if r.Header.Get("X-Signature-256") == "" {
+			t.Error("unsigned drift alert")
+		}
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	embedder := &angledEmbedder{similarity: 1}
+	service := testService(t, embedder, "docs", 5)
+	storeUnit(t, service, "wiki", 2)
+	service.StoreVectors(context.Background(), &types.StoreRequest{Namespace: "docs", Vectors: []types.Vector{{ID: "untexted", Embedding: []float32{1, 0, 0}}}})
+
+	config := DefaultConfig()
+	config.Enabled, config.SampleSize, config.MaxDrift = true, 4, 0.05
+	config.Webhook.URL, config.Webhook.Secret = server.URL, "s3cret"
+	m := &meter{}
+	d := NewDetector(config, service, m, nil)
+	checkedAt := time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return checkedAt }
+
+	// The sample is the first four by ID; the vector without text is among them but cannot be re-embedded
+	report := d.Check(context.Background(), "docs")
+	if report.Sampled != 3 || math.Abs(report.Drift) > 1e-6 || report.Drifted || !report.CheckedAt.Equal(checkedAt) || report.Error != "" {
+		t.Errorf("unchanged model reported %+v", report)
+	}
+	// "ladder" is two tokens; reads are charged, not refused
+	if math.Abs(report.Cost-0.006) > 1e-9 || m.tokens("docs") != 6 {
+		t.Errorf("cost %v, %d tokens charged", report.Cost, m.tokens("docs"))
+	}
+
+	embedder.drift(0.9)
+	summary, err := d.Run(context.Background())
+	if err != nil || summary != "checked 2 namespaces: 2 drifted, 0 failed" {
+		t.Errorf("Run: %q, %v", summary, err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case alert := <-alerts:
+			if alert["type"] != "embedding_drift" || alert["drifted"] != true || alert["campaign"] != nil {
+				t.Errorf("alert %v", alert)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no drift alert")
+		}
+	}
+
+	// A namespace still drifted is not alerted on again
+	if report := d.Check(context.Background(), "docs"); !report.Drifted || math.Abs(report.Drift-0.1) > 1e-6 {
+		t.Errorf("drifted model reported %+v", report)
+	}
+	select {
+	case alert := <-alerts:
+		t.Errorf("alerted twice: %v", alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	reports := d.Reports()
+	if len(reports) != 2 || reports[0].Namespace != "docs" || reports[1].Namespace != "wiki" || reports[1].Sampled != 2 {
+		t.Errorf("reports %+v", reports)
+	}
+}
+
+func TestAutoRefresh(t *testing.T) {
+	embedder := &angledEmbedder{similarity: 1}
+	service := testService(t, embedder, "docs", 3)
+	config := DefaultConfig()
+	config.Enabled, config.AutoRefresh, config.Namespaces = true, true, []string{"docs"}
+	config.VectorsPerSecond = 1000
+	m := &meter{}
+	campaigns := NewCampaigns(config, service, m)
+	d := NewDetector(config, service, m, campaigns)
+
+	embedder.drift(0.9)
+	if reports := d.CheckAll(context.Background()); len(reports) != 1 || !reports[0].Drifted {
+		t.Fatalf("reports %+v", reports)
+	}
+	started := campaigns.List()
+	if len(started) != 1 || started[0].Namespace != "docs" {
+		t.Fatalf("campaigns %+v", started)
+	}
+	finished := waitForCampaign(t, campaigns, started[0].ID)
+	if finished.Status != CampaignCompleted || finished.Refreshed != 3 {
+		t.Errorf("auto refresh %+v", finished)
+	}
+
+	// The refreshed namespace matches the model again
+	if report := d.Check(context.Background(), "docs"); report.Drifted || math.Abs(report.Drift) > 1e-6 {
+		t.Errorf("after refreshing: %+v", report)
+	}
+}
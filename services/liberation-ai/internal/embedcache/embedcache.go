@@ -0,0 +1,188 @@
+// Package embedcache remembers embeddings by the model that made them and a hash of the text
+// embedded, so content ingested into several namespaces, or searched for again, is embedded
+// once.
+//
+// The cache is shared by every namespace. A hit only tells the caller that someone embedded
+// the same text with the same model before, but that is itself a signal between tenants, so
+// namespaces holding private content can be excluded: they neither read nor fill the cache.
+package embedcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Config bounds the embedding cache
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MaxEntries caps how many embeddings are kept
+	MaxEntries int `yaml:"max_entries" json:"max_entries"`
+	// MaxMegabytes caps the memory the embeddings take; the least recently used are evicted
+	// first when either limit is reached
+	MaxMegabytes int `yaml:"max_megabytes" json:"max_megabytes"`
+	// ExcludeNamespaces never read or fill the cache. Encrypted namespaces are always excluded.
+	ExcludeNamespaces []string `yaml:"exclude_namespaces" json:"exclude_namespaces"`
+}
+
+// DefaultConfig caches up to 100,000 embeddings in at most 256 MB
+func DefaultConfig() Config {
+	return Config{Enabled: true, MaxEntries: 100000, MaxMegabytes: 256, ExcludeNamespaces: []string{}}
+}
+
+// Validate reports limits that would keep nothing
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxEntries < 1 || c.MaxMegabytes < 1 {
+		return fmt.Errorf("max_entries and max_megabytes must be positive")
+	}
+	return nil
+}
+
+// Stats describes the cache's contents and how often it was useful
+type Stats struct {
+	Enabled bool  `json:"enabled"`
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	// Evictions counts embeddings dropped to stay within the limits
+	Evictions int64 `json:"evictions"`
+	// Bypassed counts texts of excluded namespaces, embedded without the cache
+	Bypassed int64 `json:"bypassed"`
+}
+
+// key identifies content embedded by a model. The text itself is not kept.
+type key struct {
+	model string
+	sum   [sha256.Size]byte
+}
+
+type entry struct {
+	key       key
+	embedding []float32
+}
+
+// Cache is a least-recently-used cache of embeddings. It is safe for concurrent use.
+type Cache struct {
+	config   Config
+	maxBytes int64
+
+	mu       sync.Mutex
+	excluded map[string]bool
+	entries  map[key]*list.Element
+	// order holds *entry, most recently used first
+	order *list.List
+	bytes int64
+
+	hits, misses, evictions, bypassed atomic.Int64
+}
+
+// New creates an empty cache
+func New(config Config) *Cache {
+	c := &Cache{
+		config:   config,
+		maxBytes: int64(config.MaxMegabytes) << 20,
+		excluded: make(map[string]bool, len(config.ExcludeNamespaces)),
+		entries:  make(map[key]*list.Element),
+		order:    list.New(),
+	}
+	for _, namespace := range config.ExcludeNamespaces {
+		c.excluded[namespace] = true
+	}
+	return c
+}
+
+// Exclude keeps a namespace out of the cache from now on
+func (c *Cache) Exclude(namespace string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.excluded[namespace] = true
+}
+
+// Excluded reports whether a namespace bypasses the cache
+func (c *Cache) Excluded(namespace string) bool {
+	if !c.config.Enabled {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.excluded[namespace]
+}
+
+// Get returns a copy of the embedding model made of text for a namespace, if it is cached
+func (c *Cache) Get(namespace, model, text string) ([]float32, bool) {
+	if c.Excluded(namespace) {
+		c.bypassed.Add(1)
+		return nil, false
+	}
+	k := key{model: model, sum: sha256.Sum256([]byte(text))}
+
+	c.mu.Lock()
+	element, ok := c.entries[k]
+	if !ok {
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	embedding := append([]float32(nil), element.Value.(*entry).embedding...)
+	c.mu.Unlock()
+
+	c.hits.Add(1)
+	return embedding, true
+}
+
+// Put remembers the embedding model made of text for a namespace, unless the namespace is
+// excluded
+func (c *Cache) Put(namespace, model, text string, embedding []float32) {
+	if c.Excluded(namespace) {
+		return
+	}
+	k := key{model: model, sum: sha256.Sum256([]byte(text))}
+	stored := append([]float32(nil), embedding...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[k]; ok {
+		// A re-embedding replaces what the model produced before
+		old := element.Value.(*entry)
+		c.bytes += entrySize(stored) - entrySize(old.embedding)
+		old.embedding = stored
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[k] = c.order.PushFront(&entry{key: k, embedding: stored})
+		c.bytes += entrySize(stored)
+	}
+	for c.order.Len() > c.config.MaxEntries || (c.bytes > c.maxBytes && c.order.Len() > 1) {
+		oldest := c.order.Remove(c.order.Back()).(*entry)
+		delete(c.entries, oldest.key)
+		c.bytes -= entrySize(oldest.embedding)
+		c.evictions.Add(1)
+	}
+}
+
+// Stats returns the cache's size and counters
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	entries, bytes := c.order.Len(), c.bytes
+	c.mu.Unlock()
+	return Stats{
+		Enabled:   c.config.Enabled,
+		Entries:   entries,
+		Bytes:     bytes,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Bypassed:  c.bypassed.Load(),
+	}
+}
+
+// entrySize approximates an entry's memory: its floats plus the key and list bookkeeping
+func entrySize(embedding []float32) int64 {
+	return int64(4*len(embedding)) + 128
+}
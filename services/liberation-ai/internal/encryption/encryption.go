@@ -0,0 +1,477 @@
+// Package encryption keeps the data keys of namespaces whose vectors are encrypted at rest,
+// and of secrets such as connector credentials.
+//
+// Each encrypted namespace has its own AES-256-GCM data keys. The keys are stored in the
+// keyring file wrapped with a master key that never touches disk; it is read from
+// LIBERATION_ENCRYPTION_MASTER_KEY. A namespace can hold several key versions while it is
+// being rotated: new writes use the current one and older versions are kept until nothing
+// is encrypted with them. A master key is rotated by moving the old key to
+// LIBERATION_ENCRYPTION_PREVIOUS_MASTER_KEYS; data keys are rewrapped when the keyring is loaded.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotEncrypted is returned for namespaces that are not configured for encryption
+	ErrNotEncrypted = errors.New("namespace is not encrypted")
+
+	// ErrUnknownKey is returned for data sealed with a key version the keyring does not hold
+	ErrUnknownKey = errors.New("unknown data key version")
+)
+
+// secretPrefix keeps the data keys of secrets apart from those of namespaces in the keyring file
+const secretPrefix = "secret:"
+
+// Config lists the encrypted namespaces and where their wrapped data keys are kept
+type Config struct {
+	// Namespaces are encrypted at rest: embeddings, chunk text and metadata other than
+	// doc_id and chunk_index
+	Namespaces []string `yaml:"namespaces"`
+	// KeyringFile holds the wrapped data keys; empty keeps them in memory, for tests only
+	KeyringFile string `yaml:"keyring_file"`
+	// MasterKey is 32 bytes, base64-encoded, and wraps the data keys
+	MasterKey string `yaml:"-"`
+	// PreviousMasterKeys still unwrap data keys wrapped before the master key was rotated
+	PreviousMasterKeys []string `yaml:"-"`
+}
+
+// DefaultConfig encrypts nothing, keeping the keyring next to the job state once enabled
+func DefaultConfig() Config {
+	return Config{KeyringFile: "data/keyring.json"}
+}
+
+// ApplyEnv reads the master key from prefix + MASTER_KEY and comma-separated retired master
+// keys from prefix + PREVIOUS_MASTER_KEYS, so neither lives in the config file
+func (c *Config) ApplyEnv(prefix string) {
+	if key := os.Getenv(prefix + "MASTER_KEY"); key != "" {
+		c.MasterKey = key
+	}
+	if keys := os.Getenv(prefix + "PREVIOUS_MASTER_KEYS"); keys != "" {
+		c.PreviousMasterKeys = strings.Split(keys, ",")
+	}
+}
+
+// Enabled reports whether any namespace is encrypted
+func (c Config) Enabled() bool {
+	return len(c.Namespaces) > 0
+}
+
+// Validate reports missing or malformed keys
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.MasterKey == "" {
+		return fmt.Errorf("namespaces are encrypted but no master key is configured")
+	}
+	for _, key := range append([]string{c.MasterKey}, c.PreviousMasterKeys...) {
+		if _, err := parseMasterKey(key); err != nil {
+			return err
+		}
+	}
+	for _, namespace := range c.Namespaces {
+		if namespace == "" {
+			return fmt.Errorf("encrypted namespace names must not be empty")
+		}
+		if strings.HasPrefix(namespace, secretPrefix) {
+			return fmt.Errorf("encrypted namespace %q must not start with %q", namespace, secretPrefix)
+		}
+	}
+	return nil
+}
+
+// masterKey wraps data keys; its ID names it in the keyring without revealing it
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func parseMasterKey(encoded string) (masterKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(raw) != 32 {
+		return masterKey{}, fmt.Errorf("master keys must be 32 bytes, base64-encoded")
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return masterKey{}, err
+	}
+	sum := sha256.Sum256(raw)
+	return masterKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
+
+// storedKey is a data key as written to the keyring file
+type storedKey struct {
+	Version   int       `json:"version"`
+	MasterKey string    `json:"master_key"`
+	Wrapped   string    `json:"wrapped"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// namespaceKeys are a namespace's data key versions; the highest is current
+type namespaceKeys struct {
+	stored []storedKey
+	aeads  map[int]cipher.AEAD
+}
+
+func (k *namespaceKeys) current() int {
+	return k.stored[len(k.stored)-1].Version
+}
+
+// KeyStatus describes a namespace's data keys for the admin API
+type KeyStatus struct {
+	Namespace string `json:"namespace"`
+	Current   int    `json:"current_version"`
+	// Versions are every data key still held, oldest first
+	Versions []int `json:"versions"`
+}
+
+// Keyring holds the unwrapped data keys of encrypted namespaces. It is safe for concurrent use.
+type Keyring struct {
+	path       string
+	master     masterKey
+	namespaces map[string]bool
+
+	mu   sync.RWMutex
+	keys map[string]*namespaceKeys
+}
+
+// Load reads the keyring file, creating data keys for encrypted namespaces that have none
+// and rewrapping keys still wrapped with a previous master key
+func Load(config Config) (*Keyring, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	master, err := parseMasterKey(config.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	masters := map[string]masterKey{master.id: master}
+	for _, encoded := range config.PreviousMasterKeys {
+		previous, _ := parseMasterKey(encoded)
+		masters[previous.id] = previous
+	}
+
+	k := &Keyring{
+		path:       config.KeyringFile,
+		master:     master,
+		namespaces: make(map[string]bool, len(config.Namespaces)),
+		keys:       make(map[string]*namespaceKeys),
+	}
+	for _, namespace := range config.Namespaces {
+		k.namespaces[namespace] = true
+	}
+
+	stored, err := k.read()
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	for namespace, versions := range stored {
+		keys := &namespaceKeys{aeads: make(map[int]cipher.AEAD)}
+		for _, key := range versions {
+			wrapper, ok := masters[key.MasterKey]
+			if !ok {
+				return nil, fmt.Errorf("data key %d of %s is wrapped with master key %s, which is not configured", key.Version, namespace, key.MasterKey)
+			}
+			wrapped, err := base64.StdEncoding.DecodeString(key.Wrapped)
+			if err != nil {
+				return nil, fmt.Errorf("data key %d of %s: %w", key.Version, namespace, err)
+			}
+			raw, err := open(wrapper.aead, wrapped, []byte(namespace))
+			if err != nil {
+				return nil, fmt.Errorf("data key %d of %s cannot be unwrapped: %w", key.Version, namespace, err)
+			}
+			if key.MasterKey != master.id {
+				if key, err = k.wrap(namespace, key.Version, raw); err != nil {
+					return nil, err
+				}
+				changed = true
+			}
+			if keys.aeads[key.Version], err = newAEAD(raw); err != nil {
+				return nil, err
+			}
+			keys.stored = append(keys.stored, key)
+		}
+		if len(keys.stored) > 0 {
+			sort.Slice(keys.stored, func(i, j int) bool { return keys.stored[i].Version < keys.stored[j].Version })
+			k.keys[namespace] = keys
+		}
+	}
+
+	for _, namespace := range config.Namespaces {
+		if k.keys[namespace] == nil {
+			if err := k.addKey(namespace); err != nil {
+				return nil, err
+			}
+			changed = true
+		}
+	}
+	if changed {
+		if err := k.save(); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// wrap encrypts a data key with the current master key, bound to its namespace
+func (k *Keyring) wrap(namespace string, version int, raw []byte) (storedKey, error) {
+	wrapped, err := seal(k.master.aead, raw, []byte(namespace))
+	if err != nil {
+		return storedKey{}, err
+	}
+	return storedKey{
+		Version:   version,
+		MasterKey: k.master.id,
+		Wrapped:   base64.StdEncoding.EncodeToString(wrapped),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// addKey makes a new data key the namespace's current one. The caller holds mu or owns k.
+func (k *Keyring) addKey(namespace string) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	keys := k.keys[namespace]
+	if keys == nil {
+		keys = &namespaceKeys{aeads: make(map[int]cipher.AEAD)}
+		k.keys[namespace] = keys
+	}
+	version := 1
+	if len(keys.stored) > 0 {
+		version = keys.current() + 1
+	}
+	key, err := k.wrap(namespace, version, raw)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return err
+	}
+	keys.stored = append(keys.stored, key)
+	keys.aeads[version] = aead
+	return nil
+}
+
+func (k *Keyring) read() (map[string][]storedKey, error) {
+	stored := map[string][]storedKey{}
+	if k.path == "" {
+		return stored, nil
+	}
+	data, err := os.ReadFile(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return stored, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", k.path, err)
+	}
+	return stored, nil
+}
+
+// save writes every namespace's wrapped keys, including namespaces no longer configured,
+// whose vectors stay readable if they are encrypted again. The caller holds mu or owns k.
+func (k *Keyring) save() error {
+	if k.path == "" {
+		return nil
+	}
+	stored := make(map[string][]storedKey, len(k.keys))
+	for namespace, keys := range k.keys {
+		stored[namespace] = keys.stored
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o755); err != nil {
+		return err
+	}
+	temp := k.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temp, k.path)
+}
+
+// Encrypted reports whether writes to namespace are encrypted
+func (k *Keyring) Encrypted(namespace string) bool {
+	return k != nil && k.namespaces[namespace]
+}
+
+// Namespaces lists the encrypted namespaces in name order
+func (k *Keyring) Namespaces() []string {
+	namespaces := make([]string, 0, len(k.namespaces))
+	for namespace := range k.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// Seal encrypts plaintext with the namespace's current data key and returns the key version.
+// additional is authenticated but not encrypted; the same bytes must be passed to Open.
+func (k *Keyring) Seal(namespace string, plaintext, additional []byte) ([]byte, int, error) {
+	if !k.Encrypted(namespace) {
+		return nil, 0, ErrNotEncrypted
+	}
+	k.mu.RLock()
+	keys := k.keys[namespace]
+	version := keys.current()
+	aead := keys.aeads[version]
+	k.mu.RUnlock()
+
+	sealed, err := seal(aead, plaintext, additional)
+	return sealed, version, err
+}
+
+// Open decrypts data sealed with the given version of the namespace's data key. Namespaces
+// that are no longer encrypted can still be read while the keyring holds their keys.
+func (k *Keyring) Open(namespace string, version int, sealed, additional []byte) ([]byte, error) {
+	k.mu.RLock()
+	var aead cipher.AEAD
+	if keys := k.keys[namespace]; keys != nil {
+		aead = keys.aeads[version]
+	}
+	k.mu.RUnlock()
+	if aead == nil {
+		return nil, fmt.Errorf("%w %d for %s", ErrUnknownKey, version, namespace)
+	}
+	return open(aead, sealed, additional)
+}
+
+// Rotate adds a new data key for namespace and makes it current, returning its version.
+// Data sealed with older versions stays readable until they are retired.
+func (k *Keyring) Rotate(namespace string) (int, error) {
+	if !k.Encrypted(namespace) {
+		return 0, ErrNotEncrypted
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.addKey(namespace); err != nil {
+		return 0, err
+	}
+	return k.keys[namespace].current(), k.save()
+}
+
+// Retire forgets every data key of namespace older than version. The caller must have
+// re-encrypted everything sealed with them.
+func (k *Keyring) Retire(namespace string, version int) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	keys := k.keys[namespace]
+	if keys == nil {
+		return ErrNotEncrypted
+	}
+	kept := keys.stored[:0]
+	for _, key := range keys.stored {
+		if key.Version < version && key.Version != keys.current() {
+			delete(keys.aeads, key.Version)
+			continue
+		}
+		kept = append(kept, key)
+	}
+	keys.stored = kept
+	return k.save()
+}
+
+// Status describes the data keys of every encrypted namespace
+func (k *Keyring) Status() []KeyStatus {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	statuses := make([]KeyStatus, 0, len(k.namespaces))
+	for _, namespace := range k.Namespaces() {
+		keys := k.keys[namespace]
+		status := KeyStatus{Namespace: namespace, Current: keys.current()}
+		for _, key := range keys.stored {
+			status.Versions = append(status.Versions, key.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// SealSecret encrypts a secret, such as a connector's credentials, with a data key of its
+// own that is created on first use. The secret is bound to its name: it opens under no other.
+func (k *Keyring) SealSecret(name string, plaintext []byte) ([]byte, int, error) {
+	id := secretPrefix + name
+	k.mu.Lock()
+	if k.keys[id] == nil {
+		if err := k.addKey(id); err != nil {
+			k.mu.Unlock()
+			return nil, 0, err
+		}
+		if err := k.save(); err != nil {
+			delete(k.keys, id)
+			k.mu.Unlock()
+			return nil, 0, err
+		}
+	}
+	keys := k.keys[id]
+	version := keys.current()
+	aead := keys.aeads[version]
+	k.mu.Unlock()
+
+	sealed, err := seal(aead, plaintext, []byte(id))
+	return sealed, version, err
+}
+
+// OpenSecret decrypts a secret sealed with SealSecret
+func (k *Keyring) OpenSecret(name string, version int, sealed []byte) ([]byte, error) {
+	return k.Open(secretPrefix+name, version, sealed, []byte(secretPrefix+name))
+}
+
+// DeleteSecret forgets the data keys of a secret, so copies of it can no longer be opened
+func (k *Keyring) DeleteSecret(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys[secretPrefix+name] == nil {
+		return nil
+	}
+	delete(k.keys, secretPrefix+name)
+	return k.save()
+}
@@ -0,0 +1,136 @@
+// Package erasure deletes what liberation-ai holds about a user when liberation-auth deletes
+// the account.
+//
+// liberation-auth calls the erasure admin API with its service identity and retries until it
+// gets an answer, so erasing a user is idempotent: erasing them again finds nothing left and
+// succeeds. Vectors belong to a user when their metadata names the user under UserKey, and
+// feedback when it was sent with the user's ID. Chat keeps no conversation history, so there
+// is nothing to erase for it.
+package erasure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"liberation-ai/pkg/types"
+)
+
+// ErrInvalidUser is returned for an empty user ID
+var ErrInvalidUser = errors.New("user_id is required")
+
+// Config controls which data counts as a user's
+type Config struct {
+	// UserKey is the metadata field that names the user a vector belongs to
+	UserKey string `yaml:"user_key" json:"user_key"`
+	// Namespaces limits the search for the user's vectors; empty searches every namespace
+	Namespaces []string `yaml:"namespaces" json:"namespaces"`
+	// BatchSize is how many vectors are read from a namespace at a time
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+}
+
+// DefaultConfig finds a user's vectors by their user_id metadata in every namespace
+func DefaultConfig() Config {
+	return Config{UserKey: "user_id", BatchSize: 500}
+}
+
+// Validate reports settings that would erase nothing
+func (c Config) Validate() error {
+	if strings.TrimSpace(c.UserKey) == "" {
+		return fmt.Errorf("user_key must name a metadata field")
+	}
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("batch_size must be positive")
+	}
+	return nil
+}
+
+// Store is the part of the vector service erasure reads and deletes through
+type Store interface {
+	ListNamespaces(ctx context.Context) ([]string, error)
+	ListVectors(ctx context.Context, namespace, after string, limit int) ([]types.Vector, error)
+	DeleteVectors(ctx context.Context, namespace string, ids []string) error
+}
+
+// Feedback forgets the search feedback a user sent
+type Feedback interface {
+	ForgetUser(userID string) int
+}
+
+// Result is what an erasure deleted
+type Result struct {
+	UserID   string `json:"user_id"`
+	Vectors  int    `json:"vectors"`
+	Feedback int    `json:"feedback"`
+	// Namespaces lists the namespaces vectors were deleted from
+	Namespaces []string  `json:"namespaces"`
+	ErasedAt   time.Time `json:"erased_at"`
+}
+
+// Eraser deletes a user's vectors and feedback
+type Eraser struct {
+	config   Config
+	store    Store
+	feedback Feedback
+}
+
+// New creates an eraser over the vector service and the analytics recorder
+func New(config Config, store Store, feedback Feedback) *Eraser {
+	return &Eraser{config: config, store: store, feedback: feedback}
+}
+
+// Erase deletes every vector whose metadata names userID and every piece of feedback sent
+// with it. A failure part way leaves the rest for the next attempt.
+func (e *Eraser) Erase(ctx context.Context, userID string) (Result, error) {
+	result := Result{UserID: userID, Namespaces: []string{}}
+	if strings.TrimSpace(userID) == "" {
+		return result, ErrInvalidUser
+	}
+	namespaces := e.config.Namespaces
+	if len(namespaces) == 0 {
+		var err error
+		if namespaces, err = e.store.ListNamespaces(ctx); err != nil {
+			return result, err
+		}
+	}
+
+	for _, namespace := range namespaces {
+		var owned []string
+		after := ""
+		for {
+			page, err := e.store.ListVectors(ctx, namespace, after, e.config.BatchSize)
+			if err != nil {
+				return result, fmt.Errorf("%s: %w", namespace, err)
+			}
+			for _, vector := range page {
+				if owner, ok := vector.Metadata[e.config.UserKey]; ok && fmt.Sprint(owner) == userID {
+					owned = append(owned, vector.ID)
+				}
+			}
+			if len(page) < e.config.BatchSize {
+				break
+			}
+			after = page[len(page)-1].ID
+		}
+		if len(owned) == 0 {
+			continue
+		}
+		// IDs are collected first, so deleting does not shift the pages still to be read
+		for start := 0; start < len(owned); start += e.config.BatchSize {
+			batch := owned[start:min(start+e.config.BatchSize, len(owned))]
+			if err := e.store.DeleteVectors(ctx, namespace, batch); err != nil {
+				return result, fmt.Errorf("%s: %w", namespace, err)
+			}
+			result.Vectors += len(batch)
+		}
+		result.Namespaces = append(result.Namespaces, namespace)
+	}
+
+	if e.feedback != nil {
+		result.Feedback = e.feedback.ForgetUser(userID)
+	}
+	result.ErasedAt = time.Now().UTC()
+	return result, nil
+}
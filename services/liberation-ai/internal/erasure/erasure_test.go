@@ -0,0 +1,130 @@
+package erasure
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// feedback counts the users forgotten
+type feedback map[string]int
+
+func (f feedback) ForgetUser(userID string) int {
+	forgotten := f[userID]
+	delete(f, userID)
+	return forgotten
+}
+
+// failingDeletes refuses to delete from one namespace
+type failingDeletes struct {
+	Store
+	namespace string
+}
+
+func (f failingDeletes) DeleteVectors(ctx context.Context, namespace string, ids []string) error {
+	if namespace == f.namespace {
+		return errors.New("store unreachable")
+	}
+	return f.Store.DeleteVectors(ctx, namespace, ids)
+}
+
+// testStore stores vectors "<namespace>-<i>" in each namespace, owned in turn by ana,
+// ben and nobody
+func testStore(t *testing.T, counts map[string]int) *liberation.Service {
+	t.Helper()
+	service := liberation.New(liberation.NewMemoryStore(3), nil)
+	owners := []interface{}{"ana", "ben", nil}
+	for namespace, count := range counts {
+		vectors := make([]types.Vector, count)
+		for i := range vectors {
+			vectors[i] = types.Vector{ID: namespace + "-" + string(rune('a'+i)), Embedding: []float32{1, 0, 0}}
+			if owner := owners[i%len(owners)]; owner != nil {
+				vectors[i].Metadata = map[string]interface{}{"user_id": owner}
+			}
+		}
+		if _, err := service.StoreVectors(context.Background(), &types.StoreRequest{Namespace: namespace, Vectors: vectors}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return service
+}
+
+func remaining(t *testing.T, service *liberation.Service, namespace string) int {
+	t.Helper()
+	vectors, err := service.ListVectors(context.Background(), namespace, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(vectors)
+}
+
+func TestValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (Config{UserKey: " ", BatchSize: 1}).Validate(); err == nil {
+		t.Error("a blank user key was accepted")
+	}
+	if err := (Config{UserKey: "user_id"}).Validate(); err == nil {
+		t.Error("a zero batch size was accepted")
+	}
+}
+
+func TestErase(t *testing.T) {
+	service := testStore(t, map[string]int{"docs": 7, "wiki": 2, "empty": 0})
+	sent := feedback{"ana": 4, "ben": 1}
+	// Pages of two make the owned vectors span several pages and delete batches
+	e := New(Config{UserKey: "user_id", BatchSize: 2}, service, sent)
+
+	if _, err := e.Erase(context.Background(), " "); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("blank user: %v", err)
+	}
+
+	result, err := e.Erase(context.Background(), "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Vectors != 4 || result.Feedback != 4 || strings.Join(result.Namespaces, ",") != "docs,wiki" || result.ErasedAt.IsZero() {
+		t.Errorf("erased %+v", result)
+	}
+	if remaining(t, service, "docs") != 4 || remaining(t, service, "wiki") != 1 {
+		t.Errorf("left %d in docs, %d in wiki", remaining(t, service, "docs"), remaining(t, service, "wiki"))
+	}
+
+	// Erasing again finds nothing and succeeds
+	again, err := e.Erase(context.Background(), "ana")
+	if err != nil || again.Vectors != 0 || again.Feedback != 0 || again.Namespaces == nil || len(again.Namespaces) != 0 {
+		t.Errorf("erasing again: %+v, %v", again, err)
+	}
+}
+
+func TestEraseConfiguredNamespaces(t *testing.T) {
+	service := testStore(t, map[string]int{"docs": 3, "wiki": 3})
+	e := New(Config{UserKey: "user_id", BatchSize: 10, Namespaces: []string{"wiki"}}, service, nil)
+	result, err := e.Erase(context.Background(), "ben")
+	if err != nil || result.Vectors != 1 || strings.Join(result.Namespaces, ",") != "wiki" {
+		t.Errorf("erased %+v, %v", result, err)
+	}
+	if remaining(t, service, "docs") != 3 {
+		t.Error("a namespace outside the configured ones was erased")
+	}
+}
+
+func TestEraseFailure(t *testing.T) {
+	service := testStore(t, map[string]int{"docs": 3, "wiki": 3})
+	sent := feedback{"ana": 2}
+	e := New(Config{UserKey: "user_id", BatchSize: 10}, failingDeletes{Store: service, namespace: "wiki"}, sent)
+
+	result, err := e.Erase(context.Background(), "ana")
+	if err == nil || !strings.HasPrefix(err.Error(), "wiki: ") {
+		t.Fatalf("Erase: %+v, %v", result, err)
+	}
+	// What was deleted before the failure is reported; feedback waits for the retry
+	if result.Vectors != 1 || sent["ana"] != 2 {
+		t.Errorf("partial erasure %+v, feedback %v", result, sent)
+	}
+}
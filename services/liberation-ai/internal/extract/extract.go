@@ -0,0 +1,243 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// ErrDisabled is returned when extraction is off or no chat provider is configured
+var ErrDisabled = errors.New("structured extraction is not enabled")
+
+// Config controls POST /v1/extract
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MaxAttempts is how many times the model is asked before giving up on a schema-valid reply
+	MaxAttempts int `yaml:"max_attempts" json:"max_attempts"`
+	// ContextLimit is the default number of chunks retrieved; requests may ask for up to MaxContextLimit
+	ContextLimit    int `yaml:"context_limit" json:"context_limit"`
+	MaxContextLimit int `yaml:"max_context_limit" json:"max_context_limit"`
+	// MaxContextChars caps the retrieved text sent to the model; later chunks are truncated first
+	MaxContextChars int `yaml:"max_context_chars" json:"max_context_chars"`
+	// MaxSchemaBytes caps the size of the submitted schema
+	MaxSchemaBytes int `yaml:"max_schema_bytes" json:"max_schema_bytes"`
+	// MaxTokens caps each model reply
+	MaxTokens      int `yaml:"max_tokens" json:"max_tokens"`
+	TimeoutSeconds int `yaml:"timeout_seconds" json:"timeout_seconds"`
+}
+
+// DefaultConfig leaves extraction off; it needs a chat provider under ai_providers.chat
+func DefaultConfig() Config {
+	return Config{
+		MaxAttempts:     3,
+		ContextLimit:    5,
+		MaxContextLimit: 20,
+		MaxContextChars: 12000,
+		MaxSchemaBytes:  16384,
+		MaxTokens:       1024,
+		TimeoutSeconds:  60,
+	}
+}
+
+// ValidationError reports that no attempt produced JSON matching the schema
+type ValidationError struct {
+	Attempts int
+	Problems []string
+	// Output is the model's last reply
+	Output string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("model output did not match the schema after %d attempts", e.Attempts)
+}
+
+// Extractor asks a chat model for schema-valid JSON grounded in retrieved chunks
+type Extractor struct {
+	config Config
+	chat   liberation.ChatProvider
+}
+
+// NewExtractor creates an extractor; chat may be nil when no provider is configured
+func NewExtractor(config Config, chat liberation.ChatProvider) *Extractor {
+	defaults := DefaultConfig()
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.ContextLimit <= 0 {
+		config.ContextLimit = defaults.ContextLimit
+	}
+	if config.MaxContextLimit < config.ContextLimit {
+		config.MaxContextLimit = config.ContextLimit
+	}
+	if config.MaxContextChars <= 0 {
+		config.MaxContextChars = defaults.MaxContextChars
+	}
+	if config.MaxSchemaBytes <= 0 {
+		config.MaxSchemaBytes = defaults.MaxSchemaBytes
+	}
+	if config.TimeoutSeconds <= 0 {
+		config.TimeoutSeconds = defaults.TimeoutSeconds
+	}
+	return &Extractor{config: config, chat: chat}
+}
+
+// Enabled reports whether extraction requests are served
+func (e *Extractor) Enabled() bool {
+	return e.config.Enabled && e.chat != nil
+}
+
+// Prepare validates a request, fills in its defaults and compiles its schema
+func (e *Extractor) Prepare(req *types.ExtractRequest) (*Schema, error) {
+	if !e.Enabled() {
+		return nil, ErrDisabled
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	if len(req.Schema) == 0 {
+		return nil, fmt.Errorf("schema is required")
+	}
+	if len(req.Schema) > e.config.MaxSchemaBytes {
+		return nil, fmt.Errorf("schema is larger than %d bytes", e.config.MaxSchemaBytes)
+	}
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+	switch {
+	case req.ContextLimit <= 0:
+		req.ContextLimit = e.config.ContextLimit
+	case req.ContextLimit > e.config.MaxContextLimit:
+		return nil, fmt.Errorf("context_limit may be at most %d", e.config.MaxContextLimit)
+	}
+	return CompileSchema(req.Schema)
+}
+
+// Extract asks the model for JSON matching schema, using results as its only source.
+// Replies that are not valid JSON or break the schema are sent back with the problems
+// listed, up to MaxAttempts times.
+func (e *Extractor) Extract(ctx context.Context, schema *Schema, req *types.ExtractRequest, results []types.SearchResult) (*types.ExtractResponse, error) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(e.config.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	prompt, used := e.userPrompt(req, results)
+	completion := liberation.Completion{
+		System:    systemPrompt(schema),
+		Messages:  []liberation.ChatMessage{{Role: "user", Content: prompt}},
+		MaxTokens: e.config.MaxTokens,
+		JSON:      true,
+	}
+
+	response := &types.ExtractResponse{
+		Sources:  make([]types.ExtractSource, used),
+		Provider: e.chat.Name(),
+	}
+	for i, result := range results[:used] {
+		title, _ := result.Vector.Metadata["title"].(string)
+		response.Sources[i] = types.ExtractSource{ID: result.Vector.ID, Title: title, Score: result.Score}
+	}
+
+	var problems []string
+	var output string
+	for attempt := 1; attempt <= e.config.MaxAttempts; attempt++ {
+		reply, err := e.chat.Complete(ctx, completion)
+		if err != nil {
+			return nil, err
+		}
+		response.Attempts = attempt
+		response.Model = reply.Model
+		response.TokensUsed += reply.TokensUsed
+		output = reply.Text
+
+		var data json.RawMessage
+		data, problems = parse(schema, output)
+		if len(problems) == 0 {
+			response.Data = data
+			response.ProcessingTime = time.Since(start).Milliseconds()
+			return response, nil
+		}
+
+		completion.Messages = append(completion.Messages,
+			liberation.ChatMessage{Role: "assistant", Content: output},
+			liberation.ChatMessage{Role: "user", Content: "That reply does not match the schema:\n- " + strings.Join(problems, "\n- ") +
+				"\nReply again with only the corrected JSON."},
+		)
+	}
+
+	return nil, &ValidationError{Attempts: e.config.MaxAttempts, Problems: problems, Output: output}
+}
+
+// parse decodes a reply and validates it, returning the compacted JSON on success
+func parse(schema *Schema, output string) (json.RawMessage, []string) {
+	text := strings.TrimSpace(output)
+	// Models sometimes wrap JSON in a Markdown code fence despite JSON mode
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(strings.TrimPrefix(text, "```json"), "```")
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return nil, []string{"reply is not valid JSON: " + err.Error()}
+	}
+	if problems := schema.Validate(value); len(problems) > 0 {
+		return nil, problems
+	}
+
+	// Compact the reply itself rather than re-encoding, so large numbers keep their precision
+	var data bytes.Buffer
+	if err := json.Compact(&data, []byte(text)); err != nil {
+		return nil, []string{err.Error()}
+	}
+	return data.Bytes(), nil
+}
+
+func systemPrompt(schema *Schema) string {
+	return "You extract structured data from documents. Reply with a single JSON value that conforms to this JSON Schema:\n\n" +
+		string(schema.Source) +
+		"\n\nUse only facts stated in the numbered context passages. When the context does not contain a value, " +
+		"use null if the schema allows it and otherwise the closest value the schema permits. Do not add commentary."
+}
+
+// userPrompt lists the request and as many passages as fit in MaxContextChars, returning
+// how many passages it used
+func (e *Extractor) userPrompt(req *types.ExtractRequest, results []types.SearchResult) (string, int) {
+	var prompt strings.Builder
+	if req.Instructions != "" {
+		prompt.WriteString(req.Instructions)
+		prompt.WriteString("\n\n")
+	}
+	fmt.Fprintf(&prompt, "Request: %s\n\nContext:\n", req.Query)
+
+	budget, used := e.config.MaxContextChars, 0
+	for i, result := range results {
+		if budget <= 0 {
+			break
+		}
+		text := result.Vector.Text
+		if len(result.Window) > 0 {
+			parts := make([]string, len(result.Window))
+			for j, chunk := range result.Window {
+				parts[j] = chunk.Text
+			}
+			text = strings.Join(parts, "\n")
+		}
+		if runes := []rune(text); len(runes) > budget {
+			text = string(runes[:budget])
+		}
+		budget -= len([]rune(text))
+		fmt.Fprintf(&prompt, "[%d] (id %s) %s\n", i+1, result.Vector.ID, text)
+		used++
+	}
+	if used == 0 {
+		prompt.WriteString("(no matching passages)\n")
+	}
+	return prompt.String(), used
+}
@@ -0,0 +1,146 @@
+package extract
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// scriptedChat replies with its texts in turn and keeps every completion it was asked for
+type scriptedChat struct {
+	replies     []string
+	completions []liberation.Completion
+}
+
+func (s *scriptedChat) Name() string { return "scripted" }
+
+func (s *scriptedChat) Complete(ctx context.Context, completion liberation.Completion) (*liberation.ChatReply, error) {
+	s.completions = append(s.completions, completion)
+	if len(s.replies) == 0 {
+		return nil, errors.New("out of replies")
+	}
+	text := s.replies[0]
+	s.replies = s.replies[1:]
+	return &liberation.ChatReply{Text: text, Model: "scripted-1", TokensUsed: 10}, nil
+}
+
+func passage(id, title, text string, score float64) types.SearchResult {
+	return types.SearchResult{Vector: types.Vector{ID: id, Text: text, Metadata: map[string]interface{}{"title": title}}, Score: score}
+}
+
+func TestPrepare(t *testing.T) {
+	if _, err := NewExtractor(Config{Enabled: true}, nil).Prepare(&types.ExtractRequest{}); !errors.Is(err, ErrDisabled) {
+		t.Errorf("without a chat provider: %v", err)
+	}
+	if _, err := NewExtractor(DefaultConfig(), &scriptedChat{}).Prepare(&types.ExtractRequest{}); !errors.Is(err, ErrDisabled) {
+		t.Errorf("disabled: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.Enabled, config.MaxSchemaBytes = true, 64
+	e := NewExtractor(config, &scriptedChat{})
+	schema := json.RawMessage(`{"type": "object"}`)
+	for _, tc := range []struct {
+		name string
+		req  types.ExtractRequest
+		want string
+	}{
+		{"no query", types.ExtractRequest{Query: " ", Schema: schema}, "query is required"},
+		{"no schema", types.ExtractRequest{Query: "dal"}, "schema is required"},
+		{"schema too large", types.ExtractRequest{Query: "dal", Schema: json.RawMessage(`{"description": "` + strings.Repeat("x", 64) + `"}`)}, "schema is larger than 64 bytes"},
+		{"context limit too large", types.ExtractRequest{Query: "dal", Schema: schema, ContextLimit: 21}, "context_limit may be at most 20"},
+		{"bad schema", types.ExtractRequest{Query: "dal", Schema: json.RawMessage(`{"oneOf": []}`)}, `#: unsupported schema keyword "oneOf"`},
+	} {
+		if _, err := e.Prepare(&tc.req); err == nil || err.Error() != tc.want {
+			t.Errorf("%s: %v, want %q", tc.name, err, tc.want)
+		}
+	}
+
+	req := &types.ExtractRequest{Query: "dal", Schema: schema}
+	if compiled, err := e.Prepare(req); err != nil || compiled.Types[0] != "object" || req.Namespace != "default" || req.ContextLimit != 5 {
+		t.Errorf("prepared %+v: %v", req, err)
+	}
+}
+
+func TestExtractRetries(t *testing.T) {
+	chat := &scriptedChat{replies: []string{
+		"Here you go: {",
+		`{"name": "Dal"}`,
+		"```json\n{\n  \"name\": \"Dal\",\n  \"servings\": 12345678901234567890\n}\n```",
+	}}
+	config := DefaultConfig()
+	config.Enabled = true
+	e := NewExtractor(config, chat)
+	schema, err := CompileSchema(json.RawMessage(`{"type": "object", "required": ["name", "servings"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := e.Extract(context.Background(), schema, &types.ExtractRequest{Query: "dal"}, []types.SearchResult{
+		passage("dal#0", "Dal", "Serves four.", 0.9),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response.Data) != `{"name":"Dal","servings":12345678901234567890}` || response.Attempts != 3 || response.TokensUsed != 30 ||
+		response.Provider != "scripted" || response.Model != "scripted-1" || len(response.Sources) != 1 || response.Sources[0].Title != "Dal" {
+		t.Errorf("response %+v, data %s", response, response.Data)
+	}
+
+	// Each retry replays the conversation with the problems listed
+	last := chat.completions[2]
+	if !last.JSON || len(last.Messages) != 5 || last.Messages[3].Content != `{"name": "Dal"}` ||
+		!strings.Contains(last.Messages[4].Content, `- /: missing required property "servings"`) ||
+		!strings.Contains(last.Messages[2].Content, "reply is not valid JSON") {
+		t.Errorf("third completion %+v", last)
+	}
+	if !strings.Contains(last.System, `"required": ["name", "servings"]`) {
+		t.Errorf("system prompt lacks the schema: %s", last.System)
+	}
+}
+
+func TestExtractGivesUp(t *testing.T) {
+	config := DefaultConfig()
+	config.Enabled, config.MaxAttempts = true, 2
+	schema, _ := CompileSchema(json.RawMessage(`{"type": "string"}`))
+
+	e := NewExtractor(config, &scriptedChat{replies: []string{"1", "2", `"three"`}})
+	_, err := e.Extract(context.Background(), schema, &types.ExtractRequest{Query: "dal"}, nil)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || invalid.Attempts != 2 || invalid.Output != "2" || invalid.Problems[0] != "/: expected string, got integer" {
+		t.Errorf("after two bad replies: %v", err)
+	}
+
+	e = NewExtractor(config, &scriptedChat{})
+	if _, err := e.Extract(context.Background(), schema, &types.ExtractRequest{Query: "dal"}, nil); err == nil || err.Error() != "out of replies" {
+		t.Errorf("provider failure: %v", err)
+	}
+}
+
+func TestUserPrompt(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxContextChars = 12
+	e := NewExtractor(config, nil)
+
+	windowed := passage("b#1", "", "ignored", 0.8)
+	windowed.Window = []types.Chunk{{Text: "b0"}, {Text: "b1"}}
+	prompt, used := e.userPrompt(&types.ExtractRequest{Query: "dal", Instructions: "Be terse."}, []types.SearchResult{
+		passage("a", "", "abcdefgh", 0.9),
+		windowed,
+		passage("c", "", "never sent", 0.7),
+	})
+	// The second passage is cut to the four characters left
+	want := "Be terse.\n\nRequest: dal\n\nContext:\n[1] (id a) abcdefgh\n[2] (id b#1) b0\nb\n"
+	if prompt != want || used != 2 {
+		t.Errorf("prompt %q using %d passages, want %q", prompt, used, want)
+	}
+
+	if prompt, used := e.userPrompt(&types.ExtractRequest{Query: "dal"}, nil); used != 0 || !strings.HasSuffix(prompt, "(no matching passages)\n") {
+		t.Errorf("empty prompt %q", prompt)
+	}
+}
@@ -0,0 +1,314 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema. The supported subset is what extraction prompts need:
+// type, properties, required, additionalProperties, items, enum, const, the numeric, string
+// and array bounds, and pattern. Schemas using other validation keywords, such as $ref or
+// oneOf, are rejected rather than partially enforced.
+type Schema struct {
+	Types                []string
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *bool
+	Items                *Schema
+	Enum                 []interface{}
+	Const                interface{}
+	HasConst             bool
+	Minimum, Maximum     *float64
+	MinLength, MaxLength *int
+	MinItems, MaxItems   *int
+	Pattern              *regexp.Regexp
+
+	// Source is the schema as submitted, for the prompt
+	Source json.RawMessage
+}
+
+// annotations are keywords with no effect on validation
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "format": true,
+}
+
+// CompileSchema parses a JSON Schema document
+func CompileSchema(source json.RawMessage) (*Schema, error) {
+	var raw interface{}
+	if err := json.Unmarshal(source, &raw); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	schema, err := compile(raw, "#")
+	if err != nil {
+		return nil, err
+	}
+	schema.Source = source
+	return schema, nil
+}
+
+func compile(raw interface{}, path string) (*Schema, error) {
+	node, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object", path)
+	}
+
+	schema := &Schema{}
+	for keyword, value := range node {
+		var err error
+		switch keyword {
+		case "type":
+			schema.Types, err = compileTypes(value)
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s/properties: must be an object", path)
+			}
+			schema.Properties = make(map[string]*Schema, len(props))
+			for name, prop := range props {
+				if schema.Properties[name], err = compile(prop, path+"/properties/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			schema.Required, err = stringList(value)
+		case "additionalProperties":
+			allowed, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s/additionalProperties: only true or false is supported", path)
+			}
+			schema.AdditionalProperties = &allowed
+		case "items":
+			if schema.Items, err = compile(value, path+"/items"); err != nil {
+				return nil, err
+			}
+		case "enum":
+			values, ok := value.([]interface{})
+			if !ok || len(values) == 0 {
+				return nil, fmt.Errorf("%s/enum: must be a non-empty array", path)
+			}
+			schema.Enum = values
+		case "const":
+			schema.Const, schema.HasConst = value, true
+		case "minimum":
+			schema.Minimum, err = number(value)
+		case "maximum":
+			schema.Maximum, err = number(value)
+		case "minLength":
+			schema.MinLength, err = count(value)
+		case "maxLength":
+			schema.MaxLength, err = count(value)
+		case "minItems":
+			schema.MinItems, err = count(value)
+		case "maxItems":
+			schema.MaxItems, err = count(value)
+		case "pattern":
+			expr, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/pattern: must be a string", path)
+			}
+			schema.Pattern, err = regexp.Compile(expr)
+		default:
+			if !annotations[keyword] {
+				return nil, fmt.Errorf("%s: unsupported schema keyword %q", path, keyword)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", path, keyword, err)
+		}
+	}
+	return schema, nil
+}
+
+func compileTypes(value interface{}) ([]string, error) {
+	types, err := stringList(value)
+	if name, ok := value.(string); ok {
+		types, err = []string{name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range types {
+		switch name {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return nil, fmt.Errorf("unknown type %q", name)
+		}
+	}
+	return types, nil
+}
+
+func stringList(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+	list := make([]string, len(items))
+	for i, item := range items {
+		if list[i], ok = item.(string); !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+	}
+	return list, nil
+}
+
+func number(value interface{}) (*float64, error) {
+	n, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("must be a number")
+	}
+	return &n, nil
+}
+
+func count(value interface{}) (*int, error) {
+	n, ok := value.(float64)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return nil, fmt.Errorf("must be a non-negative integer")
+	}
+	c := int(n)
+	return &c, nil
+}
+
+// Validate checks a decoded JSON value against the schema, returning one message per
+// violation, each prefixed with the JSON pointer of the offending value
+func (s *Schema) Validate(value interface{}) []string {
+	var problems []string
+	s.validate(value, "", &problems)
+	return problems
+}
+
+func (s *Schema) validate(value interface{}, pointer string, problems *[]string) {
+	report := func(format string, args ...interface{}) {
+		location := pointer
+		if location == "" {
+			location = "/"
+		}
+		*problems = append(*problems, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.Types) > 0 && !matchesType(value, s.Types) {
+		report("expected %s, got %s", strings.Join(s.Types, " or "), typeOf(value))
+		return
+	}
+	if s.HasConst && !equal(value, s.Const) {
+		report("must be %s", encode(s.Const))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, option := range s.Enum {
+			if equal(value, option) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options := make([]string, len(s.Enum))
+			for i, option := range s.Enum {
+				options[i] = encode(option)
+			}
+			report("must be one of %s", strings.Join(options, ", "))
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			report("must be at least %g", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			report("must be at most %g", *s.Maximum)
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			report("must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			report("must be at most %d characters", *s.MaxLength)
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(v) {
+			report("must match %s", s.Pattern)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			report("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			report("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, pointer+"/"+strconv.Itoa(i), problems)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				report("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := pointer + "/" + escapePointer(name)
+			if prop, ok := s.Properties[name]; ok {
+				prop.validate(v[name], child, problems)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				report("unexpected property %q", name)
+			}
+		}
+	}
+}
+
+func matchesType(value interface{}, types []string) bool {
+	actual := typeOf(value)
+	for _, name := range types {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// equal compares decoded JSON values structurally
+func equal(a, b interface{}) bool {
+	return encode(a) == encode(b)
+}
+
+func encode(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,97 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const recipeSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"title": "Recipe",
+	"type": "object",
+	"required": ["name", "servings"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 2, "maxLength": 20},
+		"servings": {"type": "integer", "minimum": 1, "maximum": 12},
+		"tags": {"type": "array", "maxItems": 2, "items": {"enum": ["vegan", "quick"]}},
+		"code": {"type": ["string", "null"], "pattern": "^[A-Z]{3}$"},
+		"kind": {"const": "recipe"},
+		"a/b": {"type": "boolean"}
+	}
+}`
+
+func TestCompileSchema(t *testing.T) {
+	schema, err := CompileSchema(json.RawMessage(recipeSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Properties) != 6 || *schema.AdditionalProperties || *schema.Properties["servings"].Maximum != 12 || string(schema.Source) != recipeSchema {
+		t.Errorf("compiled %+v", schema)
+	}
+
+	for source, want := range map[string]string{
+		`[]`:                                   "#: schema must be an object",
+		`{"type": "float"}`:                    `#/type: unknown type "float"`,
+		`{"type": 3}`:                          "#/type: must be an array of strings",
+		`{"properties": []}`:                   "#/properties: must be an object",
+		`{"properties": {"a": {"oneOf": []}}}`: `#/properties/a: unsupported schema keyword "oneOf"`,
+		`{"items": {"$ref": "#"}}`:             `#/items: unsupported schema keyword "$ref"`,
+		`{"additionalProperties": {}}`:         "#/additionalProperties: only true or false is supported",
+		`{"enum": []}`:                         "#/enum: must be a non-empty array",
+		`{"minLength": 1.5}`:                   "#/minLength: must be a non-negative integer",
+		`{"maxItems": -1}`:                     "#/maxItems: must be a non-negative integer",
+		`{"minimum": "1"}`:                     "#/minimum: must be a number",
+		`{"pattern": "("}`:                     "#/pattern: error parsing regexp",
+		`{"required": ["a", 1]}`:               "#/required: must be an array of strings",
+		`{"type": "object"`:                    "schema is not valid JSON",
+	} {
+		if _, err := CompileSchema(json.RawMessage(source)); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("CompileSchema(%s): %v, want %q", source, err, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema, err := CompileSchema(json.RawMessage(recipeSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		value string
+		want  []string
+	}{
+		{`{"name": "Dal", "servings": 4, "tags": ["vegan"], "code": null, "kind": "recipe", "a/b": true}`, nil},
+		{`{"name": "Dal", "servings": 4, "code": "DAL"}`, nil},
+		{`[]`, []string{"/: expected object, got array"}},
+		{`{}`, []string{`/: missing required property "name"`, `/: missing required property "servings"`}},
+		{`{"name": "D", "servings": 4.5}`, []string{"/name: must be at least 2 characters", "/servings: expected integer, got number"}},
+		{`{"name": "Dal", "servings": 40, "extra": 1}`, []string{`/: unexpected property "extra"`, "/servings: must be at most 12"}},
+		{`{"name": "Dal", "servings": 0, "tags": ["vegan", "slow", "quick"]}`, []string{
+			"/servings: must be at least 1", "/tags: must have at most 2 items", `/tags/1: must be one of "vegan", "quick"`,
+		}},
+		{`{"name": "Dal", "servings": 2, "code": "dal", "kind": "menu", "a/b": "yes"}`, []string{
+			"/a~1b: expected boolean, got string", `/code: must match ^[A-Z]{3}$`, `/kind: must be "recipe"`,
+		}},
+	} {
+		var value interface{}
+		if err := json.Unmarshal([]byte(tc.value), &value); err != nil {
+			t.Fatal(err)
+		}
+		if got := schema.Validate(value); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("Validate(%s) = %q, want %q", tc.value, got, tc.want)
+		}
+	}
+
+	// Integers are numbers, and an empty schema accepts anything
+	number, _ := CompileSchema(json.RawMessage(`{"type": "number"}`))
+	if problems := number.Validate(3.0); problems != nil {
+		t.Errorf("3 is not a number: %v", problems)
+	}
+	anything, _ := CompileSchema(json.RawMessage(`{"description": "any"}`))
+	if problems := anything.Validate(map[string]interface{}{"a": []interface{}{nil}}); problems != nil {
+		t.Errorf("empty schema: %v", problems)
+	}
+}
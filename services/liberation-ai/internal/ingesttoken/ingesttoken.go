@@ -0,0 +1,263 @@
+// Package ingesttoken mints and verifies narrow upload tokens, so a frontend can write
+// documents into one namespace without holding an API key.
+//
+// Tokens are HS256 JWTs signed with a secret only liberation-ai holds. They name a single
+// namespace, cap how many documents may be uploaded with them and expire within
+// max_ttl_seconds. Verification needs no database: the signature and claims are checked
+// in process, and the documents uploaded are counted in memory per token.
+package ingesttoken
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenType is the JWT typ header of ingestion tokens, which tells them apart from user tokens
+const TokenType = "ingest+jwt"
+
+// Issuer and Audience are fixed; tokens are minted and accepted only by liberation-ai
+const (
+	Issuer   = "liberation-ai"
+	Audience = "liberation-ai:ingest"
+)
+
+var (
+	// ErrDisabled is returned when no signing secret is configured
+	ErrDisabled = errors.New("ingestion tokens are not configured")
+
+	// ErrInvalidToken is returned for malformed, forged or expired tokens
+	ErrInvalidToken = errors.New("invalid ingestion token")
+
+	// ErrWrongNamespace is returned when a token is used outside its namespace
+	ErrWrongNamespace = errors.New("ingestion token is not valid for this namespace")
+
+	// ErrCapReached is returned when an upload would go over the token's document cap
+	ErrCapReached = errors.New("ingestion token document cap reached")
+)
+
+// Config controls minting and verifying ingestion tokens
+type Config struct {
+	// Secret signs tokens; at least 32 bytes. Tokens are off without one.
+	Secret string `yaml:"secret" json:"-"`
+	// MaxTTLSeconds is the longest lifetime a token may be minted with
+	MaxTTLSeconds int `yaml:"max_ttl_seconds" json:"max_ttl_seconds"`
+	// MaxDocuments is the largest document cap a token may carry
+	MaxDocuments int `yaml:"max_documents" json:"max_documents"`
+	// Required makes POST /v1/documents refuse callers without a token or an admin key
+	Required bool `yaml:"required" json:"required"`
+}
+
+// DefaultConfig leaves tokens off, with hour-long tokens of up to 100 documents once enabled
+func DefaultConfig() Config {
+	return Config{MaxTTLSeconds: 3600, MaxDocuments: 100}
+}
+
+// ApplyEnv reads the signing secret from prefix + SECRET, so it need not live in the config file
+func (c *Config) ApplyEnv(prefix string) {
+	if secret := os.Getenv(prefix + "SECRET"); secret != "" {
+		c.Secret = secret
+	}
+}
+
+// Enabled reports whether tokens can be minted and verified
+func (c Config) Enabled() bool {
+	return c.Secret != ""
+}
+
+// Validate reports settings that would make tokens unsafe or unusable
+func (c Config) Validate() error {
+	switch {
+	case c.Secret != "" && len(c.Secret) < 32:
+		return fmt.Errorf("secret must be at least 32 bytes")
+	case c.MaxTTLSeconds <= 0 || c.MaxDocuments <= 0:
+		return fmt.Errorf("max_ttl_seconds and max_documents must be positive")
+	case c.Required && c.Secret == "":
+		return fmt.Errorf("required is set but no secret is configured")
+	}
+	return nil
+}
+
+// Claims are the contents of an ingestion token
+type Claims struct {
+	jwt.RegisteredClaims
+	// Namespace is the only namespace the token may write to
+	Namespace string `json:"ns"`
+	// MaxDocuments caps the documents uploaded with the token, across requests
+	MaxDocuments int `json:"max_docs"`
+}
+
+// MintRequest asks for a token; TTLSeconds and MaxDocuments default to the configured maximums
+type MintRequest struct {
+	Namespace    string `json:"namespace"`
+	MaxDocuments int    `json:"max_documents"`
+	TTLSeconds   int    `json:"ttl_seconds"`
+	// Subject records who the token was minted for, e.g. a user ID; it is not checked
+	Subject string `json:"subject,omitempty"`
+}
+
+// MintResponse carries a new token
+type MintResponse struct {
+	Token        string    `json:"token"`
+	ID           string    `json:"id"`
+	Namespace    string    `json:"namespace"`
+	MaxDocuments int       `json:"max_documents"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Manager mints and verifies tokens and counts what each has uploaded
+type Manager struct {
+	config Config
+	now    func() time.Time
+
+	mu sync.Mutex
+	// used counts documents uploaded per token ID until the token expires
+	used map[string]usage
+}
+
+type usage struct {
+	documents int
+	expires   time.Time
+}
+
+// NewManager creates a manager; it mints nothing while the config has no secret
+func NewManager(config Config) *Manager {
+	return &Manager{config: config, now: time.Now, used: map[string]usage{}}
+}
+
+// Enabled reports whether tokens can be minted and verified
+func (m *Manager) Enabled() bool {
+	return m.config.Enabled()
+}
+
+// Required reports whether document uploads need a token or an admin key
+func (m *Manager) Required() bool {
+	return m.config.Required
+}
+
+// Mint signs a token for one namespace
+func (m *Manager) Mint(req MintRequest) (*MintResponse, error) {
+	if !m.Enabled() {
+		return nil, ErrDisabled
+	}
+	if req.Namespace == "" {
+		return nil, fmt.Errorf("namespace is required")
+	}
+	if req.MaxDocuments == 0 {
+		req.MaxDocuments = m.config.MaxDocuments
+	}
+	if req.TTLSeconds == 0 {
+		req.TTLSeconds = m.config.MaxTTLSeconds
+	}
+	if req.MaxDocuments < 0 || req.MaxDocuments > m.config.MaxDocuments {
+		return nil, fmt.Errorf("max_documents must be between 1 and %d", m.config.MaxDocuments)
+	}
+	if req.TTLSeconds < 0 || req.TTLSeconds > m.config.MaxTTLSeconds {
+		return nil, fmt.Errorf("ttl_seconds must be between 1 and %d", m.config.MaxTTLSeconds)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := m.now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Subject:   req.Subject,
+			Audience:  jwt.ClaimStrings{Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(req.TTLSeconds) * time.Second)),
+			ID:        hex.EncodeToString(id),
+		},
+		Namespace:    req.Namespace,
+		MaxDocuments: req.MaxDocuments,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["typ"] = TokenType
+	signed, err := token.SignedString([]byte(m.config.Secret))
+	if err != nil {
+		return nil, err
+	}
+	return &MintResponse{
+		Token:        signed,
+		ID:           claims.ID,
+		Namespace:    claims.Namespace,
+		MaxDocuments: claims.MaxDocuments,
+		ExpiresAt:    claims.ExpiresAt.Time,
+	}, nil
+}
+
+// Verify checks a token's signature, type, audience and expiry
+func (m *Manager) Verify(token string) (*Claims, error) {
+	if !m.Enabled() {
+		return nil, ErrDisabled
+	}
+	claims := &Claims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Header["typ"] != TokenType {
+			return nil, fmt.Errorf("not an ingestion token")
+		}
+		return []byte(m.config.Secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(m.now),
+	)
+	if err != nil || !parsed.Valid || claims.ID == "" || claims.Namespace == "" || claims.MaxDocuments <= 0 {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// IsToken reports whether a bearer token claims to be an ingestion token, without verifying it
+func IsToken(token string) bool {
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	return err == nil && parsed.Header["typ"] == TokenType
+}
+
+// Check reports whether documents could be charged to a token used in namespace, and how
+// many documents it has left, without charging them
+func (m *Manager) Check(claims *Claims, namespace string, documents int) (remaining int, err error) {
+	return m.charge(claims, namespace, documents, false)
+}
+
+// Consume charges documents to a token used in namespace. The count is kept per replica,
+// so behind a load balancer a token can upload up to its cap on each replica.
+func (m *Manager) Consume(claims *Claims, namespace string, documents int) (remaining int, err error) {
+	return m.charge(claims, namespace, documents, true)
+}
+
+func (m *Manager) charge(claims *Claims, namespace string, documents int, commit bool) (int, error) {
+	if claims.Namespace != namespace {
+		return 0, ErrWrongNamespace
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for id, u := range m.used {
+		if now.After(u.expires) {
+			delete(m.used, id)
+		}
+	}
+	u := m.used[claims.ID]
+	if u.documents+documents > claims.MaxDocuments {
+		return claims.MaxDocuments - u.documents, ErrCapReached
+	}
+	if !commit {
+		return claims.MaxDocuments - u.documents, nil
+	}
+	u.documents += documents
+	u.expires = claims.ExpiresAt.Time
+	m.used[claims.ID] = u
+	return claims.MaxDocuments - u.documents, nil
+}
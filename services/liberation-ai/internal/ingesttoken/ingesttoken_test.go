@@ -0,0 +1,142 @@
+package ingesttoken
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestManager(secret string, now *time.Time) *Manager {
+	config := DefaultConfig()
+	config.Secret = secret
+	m := NewManager(config)
+	m.now = func() time.Time { return *now }
+	return m
+}
+
+// sign builds a token with the manager's claims but a chosen typ, method and secret
+func sign(t *testing.T, claims Claims, typ string, method jwt.SigningMethod, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["typ"] = typ
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	m := newTestManager(testSecret, &now)
+	minted, err := m.Mint(MintRequest{Namespace: "feedback", MaxDocuments: 5, TTLSeconds: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := m.Verify(minted.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forger := newTestManager(strings.Repeat("x", 32), &now)
+	forged, err := forger.Mint(MintRequest{Namespace: "feedback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Swapping in another namespace keeps the original signature, which no longer matches
+	parts := strings.Split(minted.Token, ".")
+	otherNamespace := *claims
+	otherNamespace.Namespace = "other"
+	otherPayload := strings.Split(sign(t, otherNamespace, TokenType, jwt.SigningMethodHS256, []byte(testSecret)), ".")[1]
+	otherAudience := *claims
+	otherAudience.Audience = jwt.ClaimStrings{"liberation-auth"}
+
+	tests := []struct {
+		name    string
+		token   string
+		advance time.Duration
+		wantErr bool
+	}{
+		{name: "minted", token: minted.Token},
+		{name: "just before expiry", token: minted.Token, advance: 599 * time.Second},
+		{name: "expired", token: minted.Token, advance: 601 * time.Second, wantErr: true},
+		{name: "forged signature", token: forged.Token, wantErr: true},
+		{name: "tampered claims", token: parts[0] + "." + otherPayload + "." + parts[2], wantErr: true},
+		{name: "user token typ", token: sign(t, *claims, "JWT", jwt.SigningMethodHS256, []byte(testSecret)), wantErr: true},
+		{name: "unsigned", token: sign(t, *claims, TokenType, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType), wantErr: true},
+		{name: "wrong audience", token: sign(t, otherAudience, TokenType, jwt.SigningMethodHS256, []byte(testSecret)), wantErr: true},
+		{name: "no cap", token: sign(t, Claims{RegisteredClaims: claims.RegisteredClaims, Namespace: "feedback"}, TokenType, jwt.SigningMethodHS256, []byte(testSecret)), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC).Add(tt.advance)
+			_, err := m.Verify(tt.token)
+			if tt.wantErr && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got %v, want ErrInvalidToken", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("got %v, want a valid token", err)
+			}
+		})
+	}
+}
+
+func TestConsume(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	m := newTestManager(testSecret, &now)
+	minted, err := m.Mint(MintRequest{Namespace: "feedback", MaxDocuments: 5, TTLSeconds: 600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := m.Verify(minted.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Steps run in order against the same token
+	tests := []struct {
+		name          string
+		namespace     string
+		documents     int
+		checkOnly     bool
+		wantRemaining int
+		wantErr       error
+	}{
+		{name: "wrong namespace", namespace: "other", documents: 1, wantErr: ErrWrongNamespace},
+		{name: "check charges nothing", namespace: "feedback", documents: 5, checkOnly: true, wantRemaining: 5},
+		{name: "first upload", namespace: "feedback", documents: 3, wantRemaining: 2},
+		{name: "over the cap", namespace: "feedback", documents: 3, wantRemaining: 2, wantErr: ErrCapReached},
+		{name: "check over the cap", namespace: "feedback", documents: 3, checkOnly: true, wantRemaining: 2, wantErr: ErrCapReached},
+		{name: "up to the cap", namespace: "feedback", documents: 2, wantRemaining: 0},
+		{name: "exhausted", namespace: "feedback", documents: 1, wantRemaining: 0, wantErr: ErrCapReached},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consume := m.Consume
+			if tt.checkOnly {
+				consume = m.Check
+			}
+			remaining, err := consume(claims, tt.namespace, tt.documents)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != ErrWrongNamespace && remaining != tt.wantRemaining {
+				t.Errorf("got %d remaining, want %d", remaining, tt.wantRemaining)
+			}
+		})
+	}
+
+	// The count is forgotten once the token has expired
+	now = now.Add(time.Hour)
+	if _, err := m.Consume(&Claims{RegisteredClaims: jwt.RegisteredClaims{ID: "other", ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}, Namespace: "feedback", MaxDocuments: 1}, "feedback", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.used[claims.ID]; ok {
+		t.Error("the expired token's count was kept")
+	}
+}
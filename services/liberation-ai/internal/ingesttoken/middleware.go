@@ -0,0 +1,92 @@
+package ingesttoken
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/pkg/auth"
+)
+
+// claimsKey holds the token AuthorizeUpload accepted, for ChargeUpload
+const claimsKey = "ingest_token_claims"
+
+// AuthorizeUpload checks the request's ingestion token, if any, against an upload of
+// documents into namespace. Nothing is charged yet: ChargeUpload does that once the upload's
+// other reservations have succeeded. trusted callers (admin keys and services) need no token
+// even when tokens are required. It writes an error response and returns false when the
+// upload is refused.
+func (m *Manager) AuthorizeUpload(c *gin.Context, namespace string, documents int, trusted bool) bool {
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" || !IsToken(token) {
+		if m.config.Required && !trusted {
+			m.challenge(c, "", "an ingestion token is required to upload documents")
+			return false
+		}
+		return true
+	}
+
+	claims, err := m.Verify(token)
+	if err != nil {
+		m.challenge(c, auth.BearerErrorInvalidToken, err.Error())
+		return false
+	}
+	remaining, err := m.Check(claims, namespace, documents)
+	if err != nil {
+		refuse(c, claims, documents, remaining, err)
+		return false
+	}
+	c.Set(claimsKey, claims)
+	c.Header("X-Ingest-Documents-Remaining", strconv.Itoa(remaining))
+	return true
+}
+
+// ChargeUpload charges documents to the token AuthorizeUpload accepted, if there was one.
+// It writes a 403 response and returns false when concurrent uploads used up the cap since.
+func (m *Manager) ChargeUpload(c *gin.Context, namespace string, documents int) bool {
+	claims, ok := RequestClaims(c)
+	if !ok {
+		return true
+	}
+	remaining, err := m.Consume(claims, namespace, documents)
+	if err != nil {
+		refuse(c, claims, documents, remaining, err)
+		return false
+	}
+	c.Header("X-Ingest-Documents-Remaining", strconv.Itoa(remaining))
+	return true
+}
+
+// RequestClaims returns the ingestion token AuthorizeUpload accepted for the request
+func RequestClaims(c *gin.Context) (*Claims, bool) {
+	claims, ok := c.Get(claimsKey)
+	if !ok {
+		return nil, false
+	}
+	return claims.(*Claims), true
+}
+
+// refuse answers 403 for an upload outside the token's namespace or over its cap
+func refuse(c *gin.Context, claims *Claims, documents, remaining int, err error) {
+	if errors.Is(err, ErrWrongNamespace) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden", "message": fmt.Sprintf("%v: token is for %s", err, claims.Namespace)})
+	} else {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "quota_exceeded",
+			"message": fmt.Sprintf("%v: %d of %d documents left, request has %d", err, remaining, claims.MaxDocuments, documents),
+		})
+	}
+	c.Abort()
+}
+
+// challenge answers 401; code is empty when no token was presented, as RFC 6750 asks
+func (m *Manager) challenge(c *gin.Context, code, description string) {
+	auth.WriteBearerError(c, auth.BearerChallenge{
+		Error:            code,
+		ErrorDescription: description,
+	}, "ingest")
+}
@@ -0,0 +1,62 @@
+package ingesttoken
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUploadsRefusedAfterAuthorizationKeepTheCap(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	m := newTestManager(testSecret, &now)
+	minted, err := m.Mint(MintRequest{Namespace: "feedback", MaxDocuments: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler stands in for POST /v1/documents, whose quota refuses the first upload
+	quotaLeft := false
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/documents", func(c *gin.Context) {
+		if !m.AuthorizeUpload(c, c.Query("namespace"), 2, false) {
+			return
+		}
+		if !quotaLeft {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "quota_exceeded"})
+			return
+		}
+		if !m.ChargeUpload(c, c.Query("namespace"), 2) {
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	upload := func(namespace string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/documents?namespace="+namespace, nil)
+		req.Header.Set("Authorization", "Bearer "+minted.Token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := upload("feedback"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want the quota's 429", w.Code)
+	}
+	quotaLeft = true
+	w := upload("feedback")
+	if w.Code != http.StatusOK {
+		t.Fatalf("the refused upload used up the cap: got %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("X-Ingest-Documents-Remaining"); got != "0" {
+		t.Errorf("X-Ingest-Documents-Remaining %q, want 0", got)
+	}
+	if w := upload("feedback"); w.Code != http.StatusForbidden {
+		t.Errorf("upload past the cap: got %d, want 403", w.Code)
+	}
+	if w := upload("other"); w.Code != http.StatusForbidden {
+		t.Errorf("upload to another namespace: got %d, want 403", w.Code)
+	}
+}
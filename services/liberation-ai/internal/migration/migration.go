@@ -0,0 +1,573 @@
+// Package migration copies every vector of the serving store into another store in the
+// background, one page at a time, without restarting from scratch when something fails.
+//
+// Each namespace is paged through in ID order. After every page is written to the
+// destination, the namespace's cursor is saved to the state file, so a migration that failed,
+// was cancelled or was interrupted by a restart resumes after the last page it copied. Writes
+// are upserts, so a page copied twice does no harm. Reads are throttled by vectors and bytes
+// per second, leaving the source database capacity for live traffic. Once every namespace is
+// copied, a random sample of the copied vectors is read back from both stores and compared.
+package migration
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"liberation-ai/internal/residency"
+	"liberation-ai/pkg/types"
+)
+
+// maxMismatches bounds the differing vectors listed in a validation report
+const maxMismatches = 20
+
+var (
+	// ErrNoDestination is returned when migration.destination is not configured
+	ErrNoDestination = errors.New("no migration destination is configured")
+
+	// ErrMigrationActive is returned when a migration is already running
+	ErrMigrationActive = errors.New("a migration is already running")
+
+	// ErrNoMigration is returned when nothing has been migrated yet
+	ErrNoMigration = errors.New("no migration has been started")
+
+	// ErrUnfinished is returned when a new migration would discard an unfinished one's checkpoint
+	ErrUnfinished = errors.New("an unfinished migration of other namespaces would be discarded; resume it or pass restart")
+)
+
+// Config describes the migration section, apart from the destination store
+type Config struct {
+	// StateFile keeps the checkpoint; empty keeps it in memory, so a restart loses it
+	StateFile string `yaml:"state_file" json:"state_file"`
+	// BatchSize is how many vectors are read and written at a time
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+	// VectorsPerSecond throttles reads from the source
+	VectorsPerSecond int `yaml:"vectors_per_second" json:"vectors_per_second"`
+	// BytesPerSecond also throttles reads by the approximate size of the vectors; 0 does not
+	BytesPerSecond int64 `yaml:"bytes_per_second" json:"bytes_per_second"`
+	// ValidationSamples is how many copied vectors are compared with the source afterwards
+	ValidationSamples int `yaml:"validation_samples" json:"validation_samples"`
+}
+
+// DefaultConfig copies 500 vectors a second in pages of 100 and checks 100 of them afterwards
+func DefaultConfig() Config {
+	return Config{
+		StateFile:         "data/migration.json",
+		BatchSize:         100,
+		VectorsPerSecond:  500,
+		ValidationSamples: 100,
+	}
+}
+
+// Validate reports settings that cannot work
+func (c Config) Validate() error {
+	if c.BatchSize <= 0 || c.VectorsPerSecond <= 0 {
+		return fmt.Errorf("batch_size and vectors_per_second must be positive")
+	}
+	if c.BytesPerSecond < 0 || c.ValidationSamples < 0 {
+		return fmt.Errorf("bytes_per_second and validation_samples must not be negative")
+	}
+	return nil
+}
+
+// Request starts a migration, or resumes the unfinished one
+type Request struct {
+	// Namespaces limits the migration; empty migrates every namespace
+	Namespaces []string `json:"namespaces,omitempty"`
+	// VectorsPerSecond and BytesPerSecond override the configured throttle
+	VectorsPerSecond int   `json:"vectors_per_second,omitempty"`
+	BytesPerSecond   int64 `json:"bytes_per_second,omitempty"`
+	// Restart discards the checkpoint and copies everything again
+	Restart bool `json:"restart,omitempty"`
+}
+
+// Namespace is one namespace's checkpoint
+type Namespace struct {
+	Namespace string `json:"namespace"`
+	// Total is the namespace's size when the migration started
+	Total    int64  `json:"total"`
+	Migrated int64  `json:"migrated"`
+	Cursor   string `json:"cursor,omitempty"`
+	Done     bool   `json:"done"`
+}
+
+// Sample is a copied vector chosen for validation
+type Sample struct {
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+}
+
+// Validation compares sampled vectors in both stores
+type Validation struct {
+	Sampled int `json:"sampled"`
+	Matched int `json:"matched"`
+	// Skipped counts samples deleted from the source since they were copied
+	Skipped    int      `json:"skipped"`
+	Mismatches []string `json:"mismatches,omitempty"`
+	Passed     bool     `json:"passed"`
+}
+
+// Migration reports a migration's progress; it is also the checkpoint kept in the state file
+type Migration struct {
+	ID               string                `json:"id"`
+	Status           types.MigrationStatus `json:"status"`
+	Namespaces       []Namespace           `json:"namespaces"`
+	CurrentNamespace string                `json:"current_namespace,omitempty"`
+	VectorsTotal     int64                 `json:"vectors_total"`
+	VectorsMigrated  int64                 `json:"vectors_migrated"`
+	BytesMigrated    int64                 `json:"bytes_migrated"`
+	PercentComplete  float64               `json:"percent_complete"`
+	// VectorsPerSecond is the rate since the migration last started or resumed
+	VectorsPerSecond float64 `json:"vectors_per_second"`
+	// ETASeconds estimates the time left at that rate
+	ETASeconds int64 `json:"eta_seconds"`
+	// Throttle is the rate the source is read at
+	Throttle   Throttle    `json:"throttle"`
+	Samples    []Sample    `json:"samples,omitempty"`
+	Validation *Validation `json:"validation,omitempty"`
+	// Resumes counts how many times the migration was picked up from its checkpoint
+	Resumes     int        `json:"resumes"`
+	StartedAt   time.Time  `json:"started_at"`
+	ResumedAt   time.Time  `json:"resumed_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Throttle caps how fast the source is read
+type Throttle struct {
+	VectorsPerSecond int   `json:"vectors_per_second"`
+	BytesPerSecond   int64 `json:"bytes_per_second,omitempty"`
+}
+
+// Migrator runs one migration at a time from source to destination. It is safe for
+// concurrent use.
+type Migrator struct {
+	config      Config
+	source      types.VectorStore
+	destination types.VectorStore
+	residency   *residency.Policy
+
+	mu      sync.Mutex
+	current *Migration
+	// migratedAtResume is VectorsMigrated when the migration last started, for its rate
+	migratedAtResume int64
+	cancel           context.CancelFunc
+}
+
+// New loads the checkpoint from the state file. destination may be nil, in which case
+// migrations cannot be started. A migration that was running when the process stopped is
+// reported as failed until it is resumed.
+func New(config Config, source, destination types.VectorStore) (*Migrator, error) {
+	m := &Migrator{config: config, source: source, destination: destination}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if m.current != nil && m.current.Status == types.MigrationRunning {
+		m.current.Status = types.MigrationFailed
+		m.current.Error = "interrupted by a restart; start the migration again to resume it"
+	}
+	return m, nil
+}
+
+// SetResidency refuses to migrate namespaces pinned to a region the destination is not in
+func (m *Migrator) SetResidency(policy *residency.Policy) {
+	m.residency = policy
+}
+
+// Enabled reports whether a destination is configured
+func (m *Migrator) Enabled() bool {
+	return m.destination != nil
+}
+
+// Start resumes the unfinished migration from its checkpoint, or starts a new one when the
+// last migration completed or req.Restart is set
+func (m *Migrator) Start(req Request) (*Migration, error) {
+	if m.destination == nil {
+		return nil, ErrNoDestination
+	}
+	if req.VectorsPerSecond < 0 || req.BytesPerSecond < 0 {
+		return nil, fmt.Errorf("vectors_per_second and bytes_per_second must not be negative")
+	}
+	throttle := Throttle{VectorsPerSecond: m.config.VectorsPerSecond, BytesPerSecond: m.config.BytesPerSecond}
+	if req.VectorsPerSecond > 0 {
+		throttle.VectorsPerSecond = req.VectorsPerSecond
+	}
+	if req.BytesPerSecond > 0 {
+		throttle.BytesPerSecond = req.BytesPerSecond
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current != nil && m.current.Status == types.MigrationRunning {
+		return nil, ErrMigrationActive
+	}
+
+	now := time.Now().UTC()
+	resume := m.current != nil && m.current.Status != types.MigrationCompleted && !req.Restart
+	if resume && len(req.Namespaces) > 0 && !sameNamespaces(m.current.Namespaces, req.Namespaces) {
+		return nil, ErrUnfinished
+	}
+	if resume {
+		m.current.Resumes++
+	} else {
+		planned, err := m.plan(req.Namespaces)
+		if err != nil {
+			return nil, err
+		}
+		m.current = &Migration{ID: newMigrationID(), Namespaces: planned, StartedAt: now}
+		for _, ns := range planned {
+			m.current.VectorsTotal += ns.Total
+		}
+	}
+	// Pins can change between resumes, so every namespace still to copy is checked each time
+	for _, ns := range m.current.Namespaces {
+		if !ns.Done {
+			if err := m.residency.Check(ns.Namespace, residency.BackendMigration); err != nil {
+				return nil, err
+			}
+		}
+	}
+	m.current.Status = types.MigrationRunning
+	m.current.Throttle = throttle
+	m.current.ResumedAt = now
+	m.current.UpdatedAt = now
+	m.current.CompletedAt = nil
+	m.current.Error = ""
+	m.current.Validation = nil
+	m.migratedAtResume = m.current.VectorsMigrated
+	if err := m.saveLocked(); err != nil {
+		return nil, fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	go m.run(ctx, m.current)
+
+	snapshot := m.snapshotLocked()
+	return &snapshot, nil
+}
+
+// Get returns the current or last migration
+func (m *Migrator) Get() (*Migration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current == nil {
+		return nil, ErrNoMigration
+	}
+	snapshot := m.snapshotLocked()
+	return &snapshot, nil
+}
+
+// Cancel stops a running migration after its current page. The checkpoint is kept, so
+// starting again resumes it.
+func (m *Migrator) Cancel() (*Migration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current == nil {
+		return nil, ErrNoMigration
+	}
+	if m.current.Status == types.MigrationRunning {
+		m.cancel()
+		m.finishLocked(m.current, types.MigrationCancelled, nil)
+	}
+	snapshot := m.snapshotLocked()
+	return &snapshot, nil
+}
+
+// plan lists the namespaces to migrate with their sizes
+func (m *Migrator) plan(namespaces []string) ([]Namespace, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	stats, err := m.source.Stats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count vectors: %w", err)
+	}
+	if len(namespaces) == 0 {
+		if namespaces, err = m.source.ListNamespaces(ctx); err != nil {
+			return nil, fmt.Errorf("failed to list namespaces: %w", err)
+		}
+	}
+	planned := make([]Namespace, 0, len(namespaces))
+	for _, name := range namespaces {
+		total, exists := stats.NamespaceStats[name]
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", types.ErrNamespaceNotFound, name)
+		}
+		planned = append(planned, Namespace{Namespace: name, Total: total})
+	}
+	return planned, nil
+}
+
+func (m *Migrator) run(ctx context.Context, run *Migration) {
+	for i := range run.Namespaces {
+		m.mu.Lock()
+		ns := run.Namespaces[i]
+		run.CurrentNamespace = ns.Namespace
+		throttle := run.Throttle
+		m.mu.Unlock()
+		if ns.Done {
+			continue
+		}
+
+		for {
+			pageStart := time.Now()
+			page, err := m.source.List(ctx, ns.Namespace, ns.Cursor, m.config.BatchSize)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				m.end(run, fmt.Errorf("reading %s after %q: %w", ns.Namespace, ns.Cursor, err))
+				return
+			}
+			if len(page) == 0 {
+				if err := m.checkpoint(run, i, nil, 0); err != nil {
+					m.end(run, err)
+					return
+				}
+				break
+			}
+
+			response, err := m.destination.Store(ctx, &types.StoreRequest{Namespace: ns.Namespace, Vectors: page})
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil && response.Failed > 0 {
+				err = fmt.Errorf("the destination refused %d of %d vectors", response.Failed, len(page))
+			}
+			if err != nil {
+				m.end(run, fmt.Errorf("writing %s after %q: %w", ns.Namespace, ns.Cursor, err))
+				return
+			}
+
+			size := pageSize(page)
+			if err := m.checkpoint(run, i, page, size); err != nil {
+				m.end(run, err)
+				return
+			}
+			ns.Cursor = page[len(page)-1].ID
+
+			// Throttle to the slower of the two rates, so live traffic keeps most of the source
+			wait := time.Duration(float64(len(page)) / float64(throttle.VectorsPerSecond) * float64(time.Second))
+			if throttle.BytesPerSecond > 0 {
+				wait = max(wait, time.Duration(float64(size)/float64(throttle.BytesPerSecond)*float64(time.Second)))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait - time.Since(pageStart)):
+			}
+		}
+	}
+
+	validation := m.validate(ctx, run)
+	if ctx.Err() != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if run.Status != types.MigrationRunning {
+		return
+	}
+	run.Validation = validation
+	run.CurrentNamespace = ""
+	if !validation.Passed {
+		m.finishLocked(run, types.MigrationFailed, fmt.Errorf("validation: %d of %d sampled vectors differ between the stores", len(validation.Mismatches), validation.Sampled))
+		return
+	}
+	m.finishLocked(run, types.MigrationCompleted, nil)
+}
+
+// checkpoint records a copied page, or the end of a namespace when page is empty, and saves it
+func (m *Migrator) checkpoint(run *Migration, index int, page []types.Vector, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if run.Status != types.MigrationRunning {
+		return nil
+	}
+
+	ns := &run.Namespaces[index]
+	if len(page) == 0 {
+		ns.Done = true
+	} else {
+		for _, vector := range page {
+			run.VectorsMigrated++
+			m.sampleLocked(run, Sample{Namespace: ns.Namespace, ID: vector.ID})
+		}
+		ns.Migrated += int64(len(page))
+		ns.Cursor = page[len(page)-1].ID
+		run.BytesMigrated += size
+	}
+	run.UpdatedAt = time.Now().UTC()
+	if err := m.saveLocked(); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// sampleLocked keeps a uniform random sample of every vector copied, across resumes
+func (m *Migrator) sampleLocked(run *Migration, sample Sample) {
+	if len(run.Samples) < m.config.ValidationSamples {
+		run.Samples = append(run.Samples, sample)
+		return
+	}
+	if slot := mathrand.Int63n(run.VectorsMigrated); slot < int64(len(run.Samples)) {
+		run.Samples[slot] = sample
+	}
+}
+
+// validate compares the sampled vectors in both stores
+func (m *Migrator) validate(ctx context.Context, run *Migration) *Validation {
+	m.mu.Lock()
+	samples := slices.Clone(run.Samples)
+	m.mu.Unlock()
+
+	validation := &Validation{Sampled: len(samples)}
+	for _, sample := range samples {
+		original, err := m.source.Get(ctx, sample.Namespace, sample.ID)
+		if err != nil {
+			validation.Skipped++
+			continue
+		}
+		copied, err := m.destination.Get(ctx, sample.Namespace, sample.ID)
+		if err == nil && sameVector(original, copied) {
+			validation.Matched++
+			continue
+		}
+		if len(validation.Mismatches) < maxMismatches {
+			validation.Mismatches = append(validation.Mismatches, sample.Namespace+"/"+sample.ID)
+		}
+	}
+	validation.Passed = validation.Matched+validation.Skipped == validation.Sampled
+	return validation
+}
+
+func (m *Migrator) end(run *Migration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if run.Status == types.MigrationRunning {
+		m.finishLocked(run, types.MigrationFailed, err)
+	}
+}
+
+// finishLocked must be called with mu held
+func (m *Migrator) finishLocked(run *Migration, status types.MigrationStatus, err error) {
+	completedAt := time.Now().UTC()
+	run.Status = status
+	run.CompletedAt = &completedAt
+	run.UpdatedAt = completedAt
+	if err != nil {
+		run.Error = err.Error()
+	}
+	// A checkpoint that cannot be saved here was saved after the last page, which is enough to resume
+	m.saveLocked()
+}
+
+// snapshotLocked copies the migration and fills in its rate and ETA
+func (m *Migrator) snapshotLocked() Migration {
+	snapshot := *m.current
+	snapshot.Namespaces = slices.Clone(m.current.Namespaces)
+	snapshot.Samples = nil
+	if snapshot.VectorsTotal > 0 {
+		snapshot.PercentComplete = min(100, float64(snapshot.VectorsMigrated)*100/float64(snapshot.VectorsTotal))
+	}
+	if snapshot.Status == types.MigrationRunning {
+		if elapsed := time.Since(snapshot.ResumedAt).Seconds(); elapsed > 0 {
+			snapshot.VectorsPerSecond = float64(snapshot.VectorsMigrated-m.migratedAtResume) / elapsed
+		}
+		if remaining := snapshot.VectorsTotal - snapshot.VectorsMigrated; remaining > 0 && snapshot.VectorsPerSecond > 0 {
+			snapshot.ETASeconds = int64(float64(remaining) / snapshot.VectorsPerSecond)
+		}
+	}
+	return snapshot
+}
+
+func (m *Migrator) load() error {
+	if m.config.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.config.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read migration checkpoint: %w", err)
+	}
+	var migration Migration
+	if err := json.Unmarshal(data, &migration); err != nil {
+		return fmt.Errorf("failed to parse migration checkpoint: %w", err)
+	}
+	m.current = &migration
+	return nil
+}
+
+// saveLocked writes the checkpoint to the state file. The caller holds mu.
+func (m *Migrator) saveLocked() error {
+	if m.config.StateFile == "" || m.current == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.current, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.config.StateFile), 0o755); err != nil {
+		return err
+	}
+	temp := m.config.StateFile + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temp, m.config.StateFile)
+}
+
+// pageSize approximates the bytes read for a page: embeddings, text and metadata
+func pageSize(page []types.Vector) int64 {
+	var size int64
+	for _, vector := range page {
+		metadata, _ := json.Marshal(vector.Metadata)
+		size += int64(4*len(vector.Embedding) + len(vector.Text) + len(metadata))
+	}
+	return size
+}
+
+// sameVector compares what a migration copies. Metadata is compared as JSON, since stores
+// decode numbers differently.
+func sameVector(a, b *types.Vector) bool {
+	if a.Text != b.Text || !slices.Equal(a.Embedding, b.Embedding) {
+		return false
+	}
+	left, _ := json.Marshal(a.Metadata)
+	right, _ := json.Marshal(b.Metadata)
+	return string(left) == string(right)
+}
+
+func sameNamespaces(planned []Namespace, requested []string) bool {
+	if len(planned) != len(requested) {
+		return false
+	}
+	for _, ns := range planned {
+		if !slices.Contains(requested, ns.Namespace) {
+			return false
+		}
+	}
+	return true
+}
+
+func newMigrationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "migration_" + hex.EncodeToString(b)
+}
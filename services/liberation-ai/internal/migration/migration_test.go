@@ -0,0 +1,182 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/types"
+)
+
+// flakyStore writes half of its failAt-th page and then fails, like a store that drops the
+// connection mid-batch. It records the first ID of every page it is asked to write.
+type flakyStore struct {
+	*vectorstore.MemoryVectorStore
+
+	mu         sync.Mutex
+	failAt     int
+	calls      int
+	pageStarts []string
+}
+
+func (s *flakyStore) Store(ctx context.Context, req *types.StoreRequest) (*types.StoreResponse, error) {
+	s.mu.Lock()
+	s.calls++
+	s.pageStarts = append(s.pageStarts, req.Vectors[0].ID)
+	fail := s.calls == s.failAt
+	s.mu.Unlock()
+
+	if fail {
+		half := *req
+		half.Vectors = req.Vectors[:len(req.Vectors)/2]
+		s.MemoryVectorStore.Store(ctx, &half)
+		return nil, errors.New("connection reset")
+	}
+	return s.MemoryVectorStore.Store(ctx, req)
+}
+
+// corruptingStore changes the text of one vector as it is written
+type corruptingStore struct {
+	*vectorstore.MemoryVectorStore
+	id string
+}
+
+func (s *corruptingStore) Store(ctx context.Context, req *types.StoreRequest) (*types.StoreResponse, error) {
+	copied := *req
+	copied.Vectors = slices.Clone(req.Vectors)
+	for i := range copied.Vectors {
+		if copied.Vectors[i].ID == s.id {
+			copied.Vectors[i].Text += " (truncated)"
+		}
+	}
+	return s.MemoryVectorStore.Store(ctx, &copied)
+}
+
+func newSource(t *testing.T, sizes map[string]int) *vectorstore.MemoryVectorStore {
+	t.Helper()
+	source := vectorstore.NewMemoryVectorStore(2)
+	for namespace, size := range sizes {
+		vectors := make([]types.Vector, size)
+		for i := range vectors {
+			vectors[i] = types.Vector{
+				ID:        fmt.Sprintf("%s-%03d", namespace, i),
+				Embedding: []float32{float32(i), 1},
+				Text:      fmt.Sprintf("document %d", i),
+				Metadata:  map[string]interface{}{"n": i},
+			}
+		}
+		if _, err := source.Store(context.Background(), &types.StoreRequest{Namespace: namespace, Vectors: vectors}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+func testConfig(t *testing.T) Config {
+	config := DefaultConfig()
+	config.StateFile = filepath.Join(t.TempDir(), "migration.json")
+	config.BatchSize = 5
+	config.VectorsPerSecond = 1_000_000
+	return config
+}
+
+// waitFor returns the migration once it has stopped running
+func waitFor(t *testing.T, m *Migrator) *Migration {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		migration, err := m.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migration.Status != types.MigrationRunning {
+			return migration
+		}
+	}
+	t.Fatal("the migration did not finish")
+	return nil
+}
+
+func TestResumeAfterMidBatchFailure(t *testing.T) {
+	config := testConfig(t)
+	source := newSource(t, map[string]int{"a": 25})
+	destination := &flakyStore{MemoryVectorStore: vectorstore.NewMemoryVectorStore(2), failAt: 4}
+
+	m, err := New(config, source, destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Start(Request{}); err != nil {
+		t.Fatal(err)
+	}
+	failed := waitFor(t, m)
+	if failed.Status != types.MigrationFailed || !strings.Contains(failed.Error, "connection reset") {
+		t.Fatalf("status %s, error %q; want failed on the fourth page", failed.Status, failed.Error)
+	}
+	checkpoint := failed.Namespaces[0]
+	if checkpoint.Migrated != 15 || checkpoint.Cursor != "a-014" || checkpoint.Done {
+		t.Fatalf("checkpoint %+v, want the first three pages", checkpoint)
+	}
+
+	// A restart picks the checkpoint up from the state file and rewrites the half-written page
+	m, err = New(config, source, destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Start(Request{}); err != nil {
+		t.Fatal(err)
+	}
+	done := waitFor(t, m)
+	if done.Status != types.MigrationCompleted {
+		t.Fatalf("status %s, error %q; want completed", done.Status, done.Error)
+	}
+	if done.Resumes != 1 || done.VectorsMigrated != 25 || !done.Validation.Passed {
+		t.Errorf("resumes %d, migrated %d, validation %+v; want 1 resume and all 25 vectors", done.Resumes, done.VectorsMigrated, done.Validation)
+	}
+	if want := []string{"a-000", "a-005", "a-010", "a-015", "a-015", "a-020"}; !slices.Equal(destination.pageStarts, want) {
+		t.Errorf("pages written from %v, want %v", destination.pageStarts, want)
+	}
+	stats, err := destination.Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.NamespaceStats["a"] != 25 {
+		t.Errorf("destination has %d vectors, want 25", stats.NamespaceStats["a"])
+	}
+}
+
+func TestSamplingMismatchFailsTheMigration(t *testing.T) {
+	config := testConfig(t)
+	source := newSource(t, map[string]int{"a": 12, "b": 8})
+	destination := &corruptingStore{MemoryVectorStore: vectorstore.NewMemoryVectorStore(2), id: "b-003"}
+
+	m, err := New(config, source, destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Start(Request{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every vector is sampled, since there are fewer than validation_samples
+	migration := waitFor(t, m)
+	if migration.Status != types.MigrationFailed || migration.CompletedAt == nil {
+		t.Fatalf("status %s; want the migration failed by validation", migration.Status)
+	}
+	if !strings.Contains(migration.Error, "validation") {
+		t.Errorf("error %q, want it to name the validation", migration.Error)
+	}
+	validation := migration.Validation
+	if validation == nil || validation.Passed || validation.Sampled != 20 || validation.Matched != 19 {
+		t.Fatalf("validation %+v, want 19 of 20 samples matched", validation)
+	}
+	if !slices.Equal(validation.Mismatches, []string{"b/b-003"}) {
+		t.Errorf("mismatches %v, want b/b-003", validation.Mismatches)
+	}
+}
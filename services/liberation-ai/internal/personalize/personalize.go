@@ -0,0 +1,366 @@
+// Package personalize learns a preference vector per user and namespace from search
+// feedback, to be blended into that user's query embeddings.
+//
+// A profile is a decayed, weighted sum of the embeddings of documents the user clicked,
+// selected or rated: thumbs up counts fully, clicks and selections half, and thumbs down
+// pulls away. Profiles are kept in memory under a salted hash of the caller's identity,
+// never the identity itself. Users can reset their profiles or opt out, which deletes them
+// and stops their feedback from being collected.
+package personalize
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"liberation-ai/internal/analytics"
+)
+
+// Config controls how profiles are learned and how much they move queries
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Weight is the share of the blended query taken from the profile, between 0 and 1
+	Weight float64 `yaml:"weight" json:"weight"`
+	// MinSignals is how much feedback a profile needs before it is applied
+	MinSignals int `yaml:"min_signals" json:"min_signals"`
+	// HalfLifeDays halves the weight of feedback every this many days
+	HalfLifeDays float64 `yaml:"half_life_days" json:"half_life_days"`
+	// RetentionDays deletes profiles that have had no feedback for this long
+	RetentionDays int `yaml:"retention_days" json:"retention_days"`
+	// MaxUsers bounds memory; the least recently updated users are dropped first
+	MaxUsers int `yaml:"max_users" json:"max_users"`
+	// Namespaces overrides Enabled for individual namespaces
+	Namespaces map[string]bool `yaml:"namespaces" json:"namespaces,omitempty"`
+}
+
+// DefaultConfig leaves personalization off, with a light touch once enabled
+func DefaultConfig() Config {
+	return Config{
+		Weight:        0.2,
+		MinSignals:    3,
+		HalfLifeDays:  30,
+		RetentionDays: 90,
+		MaxUsers:      100000,
+	}
+}
+
+// Validate reports settings that would make profiles useless or unbounded
+func (c Config) Validate() error {
+	switch {
+	case c.Weight < 0 || c.Weight > 1:
+		return fmt.Errorf("weight must be between 0 and 1")
+	case c.MinSignals < 1:
+		return fmt.Errorf("min_signals must be at least 1")
+	case c.HalfLifeDays <= 0 || c.RetentionDays <= 0:
+		return fmt.Errorf("half_life_days and retention_days must be positive")
+	case c.MaxUsers < 1:
+		return fmt.Errorf("max_users must be at least 1")
+	}
+	return nil
+}
+
+// actionWeights is how far each feedback action pulls a profile towards the document
+var actionWeights = map[string]float64{
+	analytics.ActionThumbsUp:   1,
+	analytics.ActionClick:      0.5,
+	analytics.ActionSelect:     0.5,
+	analytics.ActionThumbsDown: -1,
+}
+
+// Signal is one piece of feedback with the embedding of the document it was about
+type Signal struct {
+	Action    string
+	Timestamp time.Time
+	Embedding []float32
+}
+
+// Profile describes a user's preference in one namespace
+type Profile struct {
+	Namespace string    `json:"namespace"`
+	Signals   int       `json:"signals"`
+	Updated   time.Time `json:"updated"`
+	// Active is true once the profile has enough feedback to be blended into queries
+	Active bool `json:"active"`
+}
+
+// Status is what a user can see about their own personalization
+type Status struct {
+	Enabled  bool      `json:"enabled"`
+	OptedOut bool      `json:"opted_out"`
+	Weight   float64   `json:"weight"`
+	Profiles []Profile `json:"profiles"`
+}
+
+type profile struct {
+	sum     []float64
+	signals int
+	updated time.Time
+}
+
+type user struct {
+	profiles map[string]*profile
+	updated  time.Time
+}
+
+// Profiles keeps the preference vectors of every user. It is safe for concurrent use.
+type Profiles struct {
+	mu       sync.RWMutex
+	config   Config
+	users    map[string]*user
+	optedOut map[string]bool
+	toggles  map[string]bool
+	salt     string
+	pruned   time.Time
+	now      func() time.Time
+}
+
+// NewProfiles creates an empty, in-memory profile store. Users are keyed with a random
+// salt, so profiles cannot be matched to identities outside this process.
+func NewProfiles(config Config) *Profiles {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	toggles := make(map[string]bool, len(config.Namespaces))
+	for namespace, enabled := range config.Namespaces {
+		toggles[namespace] = enabled
+	}
+	return &Profiles{
+		config:   config,
+		users:    make(map[string]*user),
+		optedOut: make(map[string]bool),
+		toggles:  toggles,
+		salt:     hex.EncodeToString(salt),
+		now:      time.Now,
+	}
+}
+
+// Weight is the share of a personalized query taken from the profile
+func (p *Profiles) Weight() float64 {
+	return p.config.Weight
+}
+
+// EnabledFor reports whether profiles are learned and applied in a namespace
+func (p *Profiles) EnabledFor(namespace string) bool {
+	if enabled, exists := p.toggles[namespace]; exists {
+		return enabled
+	}
+	return p.config.Enabled
+}
+
+// Collects reports whether feedback from identity in namespace should be recorded
+func (p *Profiles) Collects(identity, namespace string) bool {
+	if !p.EnabledFor(namespace) {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return !p.optedOut[p.key(identity)]
+}
+
+func (p *Profiles) key(identity string) string {
+	sum := sha256.Sum256([]byte(p.salt + ":" + identity))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Record folds one piece of feedback into identity's profile for namespace
+func (p *Profiles) Record(identity, namespace string, signal Signal) {
+	if !p.Collects(identity, namespace) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pruneLocked()
+	key := p.key(identity)
+	u := p.users[key]
+	if u == nil {
+		u = &user{profiles: make(map[string]*profile)}
+		p.users[key] = u
+	}
+	pr := u.profiles[namespace]
+	if pr == nil {
+		pr = &profile{}
+		u.profiles[namespace] = pr
+	}
+	if p.add(pr, signal) && pr.updated.After(u.updated) {
+		u.updated = pr.updated
+	}
+}
+
+// add decays the profile to the signal's time and adds the signal, reporting whether it counted
+func (p *Profiles) add(pr *profile, signal Signal) bool {
+	weight, ok := actionWeights[signal.Action]
+	norm := norm(signal.Embedding)
+	if !ok || norm == 0 || (pr.sum != nil && len(signal.Embedding) != len(pr.sum)) {
+		return false
+	}
+	if pr.sum == nil {
+		pr.sum = make([]float64, len(signal.Embedding))
+	}
+
+	// Feedback replayed out of order is decayed itself rather than decaying newer feedback
+	halfLife := p.config.HalfLifeDays * 24
+	if age := pr.updated.Sub(signal.Timestamp).Hours(); age > 0 {
+		weight *= math.Pow(0.5, age/halfLife)
+	} else {
+		decay := math.Pow(0.5, -age/halfLife)
+		for i := range pr.sum {
+			pr.sum[i] *= decay
+		}
+		pr.updated = signal.Timestamp
+	}
+	for i, value := range signal.Embedding {
+		pr.sum[i] += weight * float64(value) / norm
+	}
+	pr.signals++
+	return true
+}
+
+// Preference returns identity's unit preference vector for namespace, or false when the
+// user has opted out or has not given enough feedback there yet
+func (p *Profiles) Preference(identity, namespace string) ([]float32, bool) {
+	if !p.EnabledFor(namespace) || p.config.Weight == 0 {
+		return nil, false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key := p.key(identity)
+	u := p.users[key]
+	if p.optedOut[key] || u == nil {
+		return nil, false
+	}
+	pr := u.profiles[namespace]
+	if pr == nil || pr.signals < p.config.MinSignals {
+		return nil, false
+	}
+
+	var length float64
+	for _, value := range pr.sum {
+		length += value * value
+	}
+	if length == 0 {
+		return nil, false
+	}
+	length = math.Sqrt(length)
+	preference := make([]float32, len(pr.sum))
+	for i, value := range pr.sum {
+		preference[i] = float32(value / length)
+	}
+	return preference, true
+}
+
+// Compute rebuilds identity's profile for namespace from its feedback history, replacing
+// what was learned incrementally
+func (p *Profiles) Compute(identity, namespace string, history []Signal) Profile {
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Timestamp.Before(history[j].Timestamp) })
+	p.Reset(identity, namespace)
+	for _, signal := range history {
+		p.Record(identity, namespace, signal)
+	}
+	for _, profile := range p.Status(identity).Profiles {
+		if profile.Namespace == namespace {
+			return profile
+		}
+	}
+	return Profile{Namespace: namespace}
+}
+
+// Reset deletes identity's profile for namespace, or every profile when namespace is empty,
+// and returns how many were deleted
+func (p *Profiles) Reset(identity, namespace string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := p.key(identity)
+	u := p.users[key]
+	if u == nil {
+		return 0
+	}
+	if namespace == "" {
+		delete(p.users, key)
+		return len(u.profiles)
+	}
+	if _, exists := u.profiles[namespace]; !exists {
+		return 0
+	}
+	delete(u.profiles, namespace)
+	if len(u.profiles) == 0 {
+		delete(p.users, key)
+	}
+	return 1
+}
+
+// SetOptOut stops or resumes personalization for identity. Opting out deletes its profiles.
+func (p *Profiles) SetOptOut(identity string, optedOut bool) {
+	if optedOut {
+		p.Reset(identity, "")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if optedOut {
+		p.optedOut[p.key(identity)] = true
+	} else {
+		delete(p.optedOut, p.key(identity))
+	}
+}
+
+// Status describes identity's profiles, newest first
+func (p *Profiles) Status(identity string) Status {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key := p.key(identity)
+	status := Status{Enabled: p.config.Enabled, OptedOut: p.optedOut[key], Weight: p.config.Weight, Profiles: []Profile{}}
+	if u := p.users[key]; u != nil {
+		for namespace, pr := range u.profiles {
+			status.Profiles = append(status.Profiles, Profile{
+				Namespace: namespace,
+				Signals:   pr.signals,
+				Updated:   pr.updated,
+				Active:    pr.signals >= p.config.MinSignals,
+			})
+		}
+	}
+	sort.Slice(status.Profiles, func(i, j int) bool { return status.Profiles[i].Updated.After(status.Profiles[j].Updated) })
+	return status
+}
+
+// pruneLocked drops users past retention at most once a minute, and the least recently
+// updated tenth when over capacity, so neither runs on every piece of feedback
+func (p *Profiles) pruneLocked() {
+	now := p.now()
+	if now.Sub(p.pruned) >= time.Minute {
+		p.pruned = now
+		cutoff := now.AddDate(0, 0, -p.config.RetentionDays)
+		for key, u := range p.users {
+			if u.updated.Before(cutoff) {
+				delete(p.users, key)
+			}
+		}
+	}
+	if len(p.users) < p.config.MaxUsers {
+		return
+	}
+
+	keys := make([]string, 0, len(p.users))
+	for key := range p.users {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return p.users[keys[i]].updated.Before(p.users[keys[j]].updated) })
+	for _, key := range keys[:len(keys)-p.config.MaxUsers+max(p.config.MaxUsers/10, 1)] {
+		delete(p.users, key)
+	}
+}
+
+func norm(embedding []float32) float64 {
+	var sum float64
+	for _, value := range embedding {
+		sum += float64(value) * float64(value)
+	}
+	return math.Sqrt(sum)
+}
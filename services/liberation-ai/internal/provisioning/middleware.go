@@ -0,0 +1,74 @@
+package provisioning
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/ratelimit"
+)
+
+// tenantKey holds the tenant of a request made with a provisioned API key
+const tenantKey = "provisioned_tenant"
+
+// Middleware confines requests made with a provisioned API key to the key's tenant. A
+// namespace in the path or query must be one of the tenant's; a request naming none is
+// given the tenant's namespace. Unknown keys with the provisioned prefix, such as keys of
+// deprovisioned tenants, are refused. Other callers are not affected.
+func (p *Provisioner) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(ratelimit.APIKeyHeader)
+		if !p.Enabled() || !strings.HasPrefix(key, KeyPrefix) {
+			c.Next()
+			return
+		}
+		tenant, ok := p.TenantForKey(key)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":   "invalid_api_key",
+				"message": "the API key is unknown or was revoked",
+			})
+			return
+		}
+		c.Set(tenantKey, tenant)
+
+		if namespace := c.Param("namespace"); namespace != "" && !p.Allows(c, namespace) {
+			return
+		}
+		query := c.Request.URL.Query()
+		if namespace := query.Get("namespace"); namespace != "" {
+			if !p.Allows(c, namespace) {
+				return
+			}
+		} else if record, ok := p.Get(tenant); ok {
+			query.Set("namespace", record.Namespace)
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		c.Next()
+	}
+}
+
+// RequestTenant returns the tenant of a request made with a provisioned API key
+func RequestTenant(c *gin.Context) (string, bool) {
+	tenant, ok := c.Get(tenantKey)
+	if !ok {
+		return "", false
+	}
+	return tenant.(string), true
+}
+
+// Allows reports whether the request may use namespace, writing a 403 response when it may
+// not. Only requests made with a provisioned API key are restricted; handlers call it for
+// namespaces named in the body.
+func (p *Provisioner) Allows(c *gin.Context, namespace string) bool {
+	tenant, ok := RequestTenant(c)
+	if !ok || p.Owns(tenant, namespace) {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":   "forbidden",
+		"message": "the API key of tenant " + tenant + " cannot use namespace " + namespace,
+	})
+	return false
+}
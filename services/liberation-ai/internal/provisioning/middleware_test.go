@@ -0,0 +1,64 @@
+package provisioning
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/ratelimit"
+)
+
+func TestMiddleware(t *testing.T) {
+	p := testProvisioner(t, "")
+	_, key, err := p.Provision("acme", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(p.Middleware())
+	handler := func(c *gin.Context) {
+		if namespace := c.GetHeader("X-Body-Namespace"); namespace != "" && !p.Allows(c, namespace) {
+			return
+		}
+		tenant, _ := RequestTenant(c)
+		c.JSON(http.StatusOK, gin.H{"tenant": tenant, "namespace": c.Query("namespace")})
+	}
+	router.GET("/v1/search", handler)
+	router.GET("/v1/namespaces/:namespace", handler)
+	get := func(path, apiKey, bodyNamespace string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(ratelimit.APIKeyHeader, apiKey)
+		req.Header.Set("X-Body-Namespace", bodyNamespace)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tc := range []struct {
+		name, path, key, bodyNamespace string
+		code                           int
+		body                           string
+	}{
+		{"tenant's namespace", "/v1/search?namespace=acme/tools", key, "", http.StatusOK, `"namespace":"acme/tools","tenant":"acme"`},
+		{"namespace filled in", "/v1/search", key, "", http.StatusOK, `"namespace":"acme/default"`},
+		{"other tenant's query", "/v1/search?namespace=beta/default", key, "", http.StatusForbidden, "cannot use namespace beta/default"},
+		{"other tenant's path", "/v1/namespaces/beta", key, "", http.StatusForbidden, "cannot use namespace beta"},
+		{"other tenant's body", "/v1/search", key, "beta/default", http.StatusForbidden, "forbidden"},
+		{"revoked key", "/v1/search", KeyPrefix + "revoked", "", http.StatusUnauthorized, "invalid_api_key"},
+		{"other keys", "/v1/search?namespace=beta/default", "sk_operator", "beta/default", http.StatusOK, `"tenant":""`},
+	} {
+		if w := get(tc.path, tc.key, tc.bodyNamespace); w.Code != tc.code || !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("%s: %d %s", tc.name, w.Code, w.Body)
+		}
+	}
+
+	p.config.Enabled = false
+	if w := get("/v1/search?namespace=beta/default", KeyPrefix+"revoked", ""); w.Code != http.StatusOK {
+		t.Errorf("disabled provisioning: %d %s", w.Code, w.Body)
+	}
+}
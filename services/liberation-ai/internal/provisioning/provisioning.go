@@ -0,0 +1,257 @@
+// Package provisioning gives each organization created in liberation-auth a namespace and an
+// API key of its own, without an operator setting them up by hand.
+//
+// liberation-auth calls the provisioning admin API with its service identity when a tenant is
+// created or deleted, and reconciles its tenants against the list here on a schedule, so a
+// missed call is repaired later. Provisioning is idempotent: provisioning a tenant again
+// returns its existing record and no key. Keys are shown once, when minted, and only their
+// SHA-256 is kept. A provisioned key is confined to its tenant's namespaces.
+package provisioning
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyPrefix starts every provisioned API key, so they are recognisable in logs and scanners
+const KeyPrefix = "lai_"
+
+var (
+	// ErrInvalidTenant is returned for tenant IDs liberation-auth would not have created
+	ErrInvalidTenant = errors.New("tenant IDs are lowercase letters, digits and dashes")
+
+	// ErrNotProvisioned is returned for tenants that have no namespace here
+	ErrNotProvisioned = errors.New("tenant is not provisioned")
+)
+
+// tenantPattern matches liberation-auth's tenant IDs
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Config controls provisioning
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Namespace is the namespace created for each tenant, under the tenant's prefix
+	Namespace string `yaml:"namespace" json:"namespace"`
+	// StateFile keeps provisioned tenants and their key hashes; empty keeps them in memory
+	StateFile string `yaml:"state_file" json:"state_file"`
+}
+
+// DefaultConfig leaves provisioning off; once enabled, tenant acme gets namespace acme/default
+func DefaultConfig() Config {
+	return Config{Namespace: "default", StateFile: "data/provisioning.json"}
+}
+
+// Validate reports a namespace that could not sit under a tenant prefix
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Namespace == "" || strings.ContainsAny(c.Namespace, "/ ") {
+		return fmt.Errorf("namespace must be a single non-empty name")
+	}
+	return nil
+}
+
+// Tenant is a provisioned organization
+type Tenant struct {
+	Tenant    string `json:"tenant"`
+	Namespace string `json:"namespace"`
+	// KeyHint is the start of the API key, enough to recognise it
+	KeyHint       string    `json:"key_hint"`
+	KeyHash       string    `json:"key_hash,omitempty"`
+	ProvisionedAt time.Time `json:"provisioned_at"`
+	KeyIssuedAt   time.Time `json:"key_issued_at"`
+}
+
+// Public is the tenant as the API shows it, without the key's hash
+func (t Tenant) Public() Tenant {
+	t.KeyHash = ""
+	return t
+}
+
+// Provisioner keeps provisioned tenants and resolves their API keys. It is safe for
+// concurrent use.
+type Provisioner struct {
+	config    Config
+	separator string
+
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+	// keys maps key hashes to tenant IDs
+	keys map[string]string
+}
+
+// New loads provisioned tenants from the state file. separator is the tenancy separator
+// that splits namespaces into tenant and name.
+func New(config Config, separator string) (*Provisioner, error) {
+	p := &Provisioner{
+		config:    config,
+		separator: separator,
+		tenants:   make(map[string]*Tenant),
+		keys:      make(map[string]string),
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Enabled reports whether tenants can be provisioned
+func (p *Provisioner) Enabled() bool {
+	return p.config.Enabled
+}
+
+// Provision gives a tenant its namespace and an API key. A tenant that is already
+// provisioned keeps its key, and no key is returned, unless rotate asks for a new one.
+func (p *Provisioner) Provision(tenant string, rotate bool) (Tenant, string, error) {
+	if !tenantPattern.MatchString(tenant) {
+		return Tenant{}, "", ErrInvalidTenant
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now().UTC()
+	record, exists := p.tenants[tenant]
+	if exists && !rotate {
+		return *record, "", nil
+	}
+	key, err := newKey()
+	if err != nil {
+		return Tenant{}, "", err
+	}
+	if !exists {
+		record = &Tenant{Tenant: tenant, Namespace: tenant + p.separator + p.config.Namespace, ProvisionedAt: now}
+		p.tenants[tenant] = record
+	}
+	delete(p.keys, record.KeyHash)
+	record.KeyHash = hashKey(key)
+	record.KeyHint = key[:len(KeyPrefix)+6]
+	record.KeyIssuedAt = now
+	p.keys[record.KeyHash] = tenant
+	if err := p.saveLocked(); err != nil {
+		return Tenant{}, "", err
+	}
+	return *record, key, nil
+}
+
+// Deprovision forgets a tenant and revokes its key. Its data is left to the caller.
+func (p *Provisioner) Deprovision(tenant string) (Tenant, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	record, ok := p.tenants[tenant]
+	if !ok {
+		return Tenant{}, ErrNotProvisioned
+	}
+	delete(p.tenants, tenant)
+	delete(p.keys, record.KeyHash)
+	return *record, p.saveLocked()
+}
+
+// Get returns a provisioned tenant
+func (p *Provisioner) Get(tenant string) (Tenant, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	record, ok := p.tenants[tenant]
+	if !ok {
+		return Tenant{}, false
+	}
+	return *record, true
+}
+
+// Tenants lists provisioned tenants by ID
+func (p *Provisioner) Tenants() []Tenant {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	list := make([]Tenant, 0, len(p.tenants))
+	for _, record := range p.tenants {
+		list = append(list, *record)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tenant < list[j].Tenant })
+	return list
+}
+
+// TenantForKey returns the tenant an API key was provisioned for
+func (p *Provisioner) TenantForKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, KeyPrefix) {
+		return "", false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	tenant, ok := p.keys[hashKey(key)]
+	return tenant, ok
+}
+
+// Owns reports whether a namespace lies under a tenant's prefix
+func (p *Provisioner) Owns(tenant, namespace string) bool {
+	return strings.HasPrefix(namespace, tenant+p.separator)
+}
+
+func newKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return KeyPrefix + hex.EncodeToString(secret), nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *Provisioner) load() error {
+	if p.config.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(p.config.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var tenants []*Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return fmt.Errorf("reading %s: %w", p.config.StateFile, err)
+	}
+	for _, record := range tenants {
+		p.tenants[record.Tenant] = record
+		p.keys[record.KeyHash] = record.Tenant
+	}
+	return nil
+}
+
+// saveLocked writes every tenant to the state file. The caller holds mu.
+func (p *Provisioner) saveLocked() error {
+	if p.config.StateFile == "" {
+		return nil
+	}
+	tenants := make([]*Tenant, 0, len(p.tenants))
+	for _, record := range p.tenants {
+		tenants = append(tenants, record)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Tenant < tenants[j].Tenant })
+	data, err := json.MarshalIndent(tenants, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.config.StateFile), 0o755); err != nil {
+		return err
+	}
+	temp := p.config.StateFile + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temp, p.config.StateFile)
+}
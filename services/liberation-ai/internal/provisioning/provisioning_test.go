@@ -0,0 +1,130 @@
+package provisioning
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testProvisioner(t *testing.T, stateFile string) *Provisioner {
+	t.Helper()
+	config := DefaultConfig()
+	config.Enabled, config.StateFile = true, stateFile
+	p, err := New(config, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestValidate(t *testing.T) {
+	for namespace, valid := range map[string]bool{"default": true, "": false, "a/b": false, "my docs": false} {
+		config := DefaultConfig()
+		config.Enabled, config.Namespace = true, namespace
+		if err := config.Validate(); (err == nil) != valid {
+			t.Errorf("namespace %q: %v", namespace, err)
+		}
+	}
+}
+
+func TestProvision(t *testing.T) {
+	p := testProvisioner(t, "")
+	for _, tenant := range []string{"", "Acme", "-acme", "acme/tools", strings.Repeat("a", 64)} {
+		if _, _, err := p.Provision(tenant, false); !errors.Is(err, ErrInvalidTenant) {
+			t.Errorf("tenant %q: %v", tenant, err)
+		}
+	}
+
+	record, key, err := p.Provision("acme", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Namespace != "acme/default" || !strings.HasPrefix(key, KeyPrefix) || !strings.HasPrefix(key, record.KeyHint) || len(record.KeyHint) != len(KeyPrefix)+6 {
+		t.Errorf("provisioned %+v with key %q", record, key)
+	}
+	if strings.Contains(record.KeyHash, key) || record.Public().KeyHash != "" {
+		t.Errorf("key kept in the clear: %+v", record)
+	}
+	if tenant, ok := p.TenantForKey(key); !ok || tenant != "acme" {
+		t.Errorf("TenantForKey: %q, %v", tenant, ok)
+	}
+
+	// Provisioning again is idempotent and shows no key
+	again, none, err := p.Provision("acme", false)
+	if err != nil || none != "" || again.KeyHash != record.KeyHash {
+		t.Errorf("provisioning again: %+v, %q, %v", again, none, err)
+	}
+
+	// Rotating mints a new key and revokes the old one
+	rotated, newKey, err := p.Provision("acme", true)
+	if err != nil || newKey == "" || newKey == key || !rotated.ProvisionedAt.Equal(record.ProvisionedAt) {
+		t.Fatalf("rotating: %+v, %q, %v", rotated, newKey, err)
+	}
+	if _, ok := p.TenantForKey(key); ok {
+		t.Error("the rotated key still resolves")
+	}
+	if tenant, ok := p.TenantForKey(newKey); !ok || tenant != "acme" {
+		t.Errorf("new key: %q, %v", tenant, ok)
+	}
+	if _, ok := p.TenantForKey("sk_" + newKey[len(KeyPrefix):]); ok {
+		t.Error("a key without the prefix resolved")
+	}
+
+	if _, err := p.Deprovision("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.TenantForKey(newKey); ok {
+		t.Error("a deprovisioned tenant's key still resolves")
+	}
+	if _, err := p.Deprovision("acme"); !errors.Is(err, ErrNotProvisioned) {
+		t.Errorf("deprovisioning twice: %v", err)
+	}
+}
+
+func TestOwns(t *testing.T) {
+	p := testProvisioner(t, "")
+	for namespace, owned := range map[string]bool{"acme/default": true, "acme/tools": true, "acme": false, "acmecorp/default": false} {
+		if got := p.Owns("acme", namespace); got != owned {
+			t.Errorf("Owns(acme, %q) = %v", namespace, got)
+		}
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state", "provisioning.json")
+	p := testProvisioner(t, stateFile)
+	_, beta, err := p.Provision("beta", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.Provision("acme", false); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), beta) {
+		t.Error("the state file holds a key")
+	}
+
+	reloaded := testProvisioner(t, stateFile)
+	if tenants := reloaded.Tenants(); len(tenants) != 2 || tenants[0].Tenant != "acme" || tenants[1].Tenant != "beta" {
+		t.Errorf("reloaded tenants %+v", tenants)
+	}
+	if tenant, ok := reloaded.TenantForKey(beta); !ok || tenant != "beta" {
+		t.Errorf("reloaded key: %q, %v", tenant, ok)
+	}
+
+	if err := os.WriteFile(stateFile, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.Enabled, config.StateFile = true, stateFile
+	if _, err := New(config, "/"); err == nil {
+		t.Error("a corrupt state file was loaded")
+	}
+}
@@ -0,0 +1,422 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets and past days' usage are dropped
+const sweepInterval = time.Minute
+
+// ErrQuotaExceeded is returned when a caller has used up its daily embedding quota
+var ErrQuotaExceeded = errors.New("embedding quota exceeded")
+
+// Config controls request rate limits and embedding quotas
+type Config struct {
+	Enabled           bool     `yaml:"enabled" json:"enabled"`
+	RequestsPerSecond float64  `yaml:"requests_per_second" json:"requests_per_second"`
+	Burst             int      `yaml:"burst" json:"burst"`
+	EmbeddingsPerDay  int64    `yaml:"embeddings_per_day" json:"embeddings_per_day"`
+	MaxCloneVectors   int64    `yaml:"max_clone_vectors" json:"max_clone_vectors"`
+	AdminKeys         []string `yaml:"admin_keys" json:"-"`
+}
+
+// DefaultConfig returns limits suitable for a small self-hosted deployment
+func DefaultConfig() Config {
+	return Config{
+		Enabled:           true,
+		RequestsPerSecond: 10,
+		Burst:             20,
+		EmbeddingsPerDay:  10000,
+		MaxCloneVectors:   100000,
+	}
+}
+
+// Override replaces the default limits for a single caller
+type Override struct {
+	RequestsPerSecond float64    `json:"requests_per_second,omitempty"`
+	Burst             int        `json:"burst,omitempty"`
+	EmbeddingsPerDay  int64      `json:"embeddings_per_day,omitempty"`
+	Unlimited         bool       `json:"unlimited,omitempty"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+}
+
+// Usage reports the limits and consumption for a single caller
+type Usage struct {
+	Identity string `json:"identity"`
+	// KeyHint is the start of the API key behind a key identity
+	KeyHint             string    `json:"key_hint,omitempty"`
+	RequestsPerSecond   float64   `json:"requests_per_second"`
+	Burst               int       `json:"burst"`
+	EmbeddingsUsed      int64     `json:"embeddings_used"`
+	EmbeddingsLimit     int64     `json:"embeddings_limit"`
+	EmbeddingsRemaining int64     `json:"embeddings_remaining"`
+	Unlimited           bool      `json:"unlimited"`
+	ResetsAt            time.Time `json:"resets_at"`
+	Override            *Override `json:"override,omitempty"`
+}
+
+// RequestStatus is the state of a caller's request bucket after a request. Limit is the
+// bucket's size and Remaining the whole requests left in it; ResetsAt is when it is full again.
+type RequestStatus struct {
+	Allowed   bool      `json:"-"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+	// Wait is how long a refused request should wait before trying again
+	Wait      time.Duration `json:"-"`
+	Unlimited bool          `json:"unlimited"`
+}
+
+// Limits is everything that throttles a caller, for clients that pace themselves
+type Limits struct {
+	// Enabled is false when the server enforces no limits at all
+	Enabled bool `json:"enabled"`
+	Usage
+	Requests RequestStatus `json:"requests"`
+}
+
+// bucket is a token bucket refilled continuously at the configured rate
+type bucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// dailyUsage tracks embeddings generated during a single UTC day
+type dailyUsage struct {
+	day        time.Time
+	embeddings int64
+}
+
+// Limiter enforces per-caller token buckets and daily embedding quotas in memory
+type Limiter struct {
+	mu        sync.Mutex
+	config    Config
+	buckets   map[string]*bucket
+	usage     map[string]*dailyUsage
+	overrides map[string]Override
+	// hints are the key hints of key identities, for usage reports
+	hints  map[string]string
+	admins map[string]bool
+	// known recognises API keys other than admin keys, such as provisioned ones
+	known func(apiKey string) bool
+	// lastSweep is when idle state was last dropped; see sweepLocked
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLimiter creates a new in-memory limiter
+func NewLimiter(config Config) *Limiter {
+	admins := make(map[string]bool, len(config.AdminKeys))
+	for _, key := range config.AdminKeys {
+		admins[key] = true
+	}
+
+	return &Limiter{
+		config:    config,
+		buckets:   make(map[string]*bucket),
+		usage:     make(map[string]*dailyUsage),
+		overrides: make(map[string]Override),
+		hints:     make(map[string]string),
+		admins:    admins,
+		now:       time.Now,
+	}
+}
+
+// Enabled reports whether limits are enforced
+func (l *Limiter) Enabled() bool {
+	return l.config.Enabled
+}
+
+// IsAdmin reports whether the API key may manage overrides
+func (l *Limiter) IsAdmin(apiKey string) bool {
+	return apiKey != "" && l.admins[apiKey]
+}
+
+// RecogniseKeys lets keys that known accepts have limits of their own, as admin keys do
+func (l *Limiter) RecogniseKeys(known func(apiKey string) bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.known = known
+}
+
+// Recognises reports whether an API key is limited on its own rather than with its IP address
+func (l *Limiter) Recognises(apiKey string) bool {
+	if l.IsAdmin(apiKey) {
+		return true
+	}
+	l.mu.Lock()
+	known := l.known
+	l.mu.Unlock()
+	return known != nil && known(apiKey)
+}
+
+// Allow takes one request token for the identity, returning how long to wait when none are left
+func (l *Limiter) Allow(identity string) (bool, time.Duration) {
+	status := l.Take(identity)
+	return status.Allowed, status.Wait
+}
+
+// Take takes one request token for the identity and reports the bucket's state afterwards
+func (l *Limiter) Take(identity string) RequestStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.requestLocked(identity, 1)
+}
+
+// Limits returns the identity's request bucket and embedding quota without taking a token
+func (l *Limiter) Limits(identity string) Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits := Limits{Enabled: l.config.Enabled, Usage: l.usageLocked(identity, l.now())}
+	if limits.Enabled {
+		limits.Requests = l.requestLocked(identity, 0)
+	} else {
+		limits.Requests = RequestStatus{Unlimited: true}
+	}
+	return limits
+}
+
+// requestLocked refills the identity's bucket and takes cost tokens from it when enough are left
+func (l *Limiter) requestLocked(identity string, cost float64) RequestStatus {
+	rate, burst, unlimited := l.requestLimits(identity)
+	if unlimited || rate <= 0 {
+		return RequestStatus{Allowed: true, Unlimited: true}
+	}
+
+	now := l.now()
+	l.sweepLocked(now)
+	b := l.buckets[identity]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), lastRefill: now}
+		if cost > 0 {
+			l.buckets[identity] = b
+		}
+	}
+
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	b.tokens = math.Min(float64(burst), b.tokens+elapsed*rate)
+	b.lastRefill = now
+
+	status := RequestStatus{Allowed: b.tokens >= cost, Limit: burst}
+	if status.Allowed {
+		b.tokens -= cost
+	} else {
+		status.Wait = time.Duration((cost - b.tokens) / rate * float64(time.Second))
+	}
+	status.Remaining = int(b.tokens)
+	status.ResetsAt = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	return status
+}
+
+// ConsumeEmbeddings records n embeddings against the identity's daily quota.
+// When the quota would be exceeded nothing is recorded and the time until reset is returned.
+func (l *Limiter) ConsumeEmbeddings(identity string, n int64) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+	usage := l.currentUsage(identity, now)
+
+	limit, unlimited := l.embeddingLimit(identity)
+	if !unlimited && limit > 0 && usage.embeddings+n > limit {
+		return usage.day.Add(24 * time.Hour).Sub(now), ErrQuotaExceeded
+	}
+
+	usage.embeddings += n
+	return 0, nil
+}
+
+// Usage returns the current limits and consumption for the identity
+func (l *Limiter) Usage(identity string) Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.usageLocked(identity, l.now())
+}
+
+// AllUsage returns usage for every identity seen today, sorted by identity
+func (l *Limiter) AllUsage() []Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	identities := make(map[string]bool)
+	for identity := range l.usage {
+		identities[identity] = true
+	}
+	for identity := range l.overrides {
+		identities[identity] = true
+	}
+
+	result := make([]Usage, 0, len(identities))
+	for identity := range identities {
+		result = append(result, l.usageLocked(identity, now))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Identity < result[j].Identity
+	})
+	return result
+}
+
+// RememberHint records the key hint shown in usage reports for a key identity
+func (l *Limiter) RememberHint(identity, hint string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.hints[identity] = hint
+}
+
+// SetOverride replaces the limits for an identity until cleared or expired
+func (l *Limiter) SetOverride(identity string, override Override) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.overrides[identity] = override
+	delete(l.buckets, identity) // Start from a full bucket under the new limits
+}
+
+// ClearOverride restores the default limits for an identity
+func (l *Limiter) ClearOverride(identity string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.overrides, identity)
+	delete(l.buckets, identity)
+}
+
+// ResetUsage clears the embedding counter for an identity
+func (l *Limiter) ResetUsage(identity string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.usage, identity)
+}
+
+// sweepLocked drops state that no longer holds anything, at most once per sweepInterval:
+// buckets that have refilled, which a new full bucket replaces exactly, usage from earlier
+// days, and the hints of identities with neither left. The caller holds mu.
+func (l *Limiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for identity, b := range l.buckets {
+		rate, burst, unlimited := l.requestLimits(identity)
+		if unlimited || rate <= 0 || b.tokens+now.Sub(b.lastRefill).Seconds()*rate >= float64(burst) {
+			delete(l.buckets, identity)
+		}
+	}
+	day := now.UTC().Truncate(24 * time.Hour)
+	for identity, usage := range l.usage {
+		if !usage.day.Equal(day) {
+			delete(l.usage, identity)
+		}
+	}
+	for identity := range l.hints {
+		_, bucket := l.buckets[identity]
+		_, usage := l.usage[identity]
+		_, override := l.overrides[identity]
+		if !bucket && !usage && !override {
+			delete(l.hints, identity)
+		}
+	}
+}
+
+// activeOverride returns the override for identity if one is set and not expired
+func (l *Limiter) activeOverride(identity string) (Override, bool) {
+	override, exists := l.overrides[identity]
+	if !exists {
+		return Override{}, false
+	}
+	if override.ExpiresAt != nil && l.now().After(*override.ExpiresAt) {
+		delete(l.overrides, identity)
+		return Override{}, false
+	}
+	return override, true
+}
+
+func (l *Limiter) requestLimits(identity string) (float64, int, bool) {
+	rate := l.config.RequestsPerSecond
+	burst := l.config.Burst
+
+	if override, ok := l.activeOverride(identity); ok {
+		if override.Unlimited {
+			return 0, 0, true
+		}
+		if override.RequestsPerSecond > 0 {
+			rate = override.RequestsPerSecond
+		}
+		if override.Burst > 0 {
+			burst = override.Burst
+		}
+	}
+
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return rate, burst, false
+}
+
+func (l *Limiter) embeddingLimit(identity string) (int64, bool) {
+	limit := l.config.EmbeddingsPerDay
+
+	if override, ok := l.activeOverride(identity); ok {
+		if override.Unlimited {
+			return 0, true
+		}
+		if override.EmbeddingsPerDay > 0 {
+			limit = override.EmbeddingsPerDay
+		}
+	}
+
+	return limit, limit <= 0
+}
+
+// currentUsage returns today's counter for identity, rolling it over at UTC midnight
+func (l *Limiter) currentUsage(identity string, now time.Time) *dailyUsage {
+	day := now.UTC().Truncate(24 * time.Hour)
+
+	usage := l.usage[identity]
+	if usage == nil || !usage.day.Equal(day) {
+		usage = &dailyUsage{day: day}
+		l.usage[identity] = usage
+	}
+	return usage
+}
+
+func (l *Limiter) usageLocked(identity string, now time.Time) Usage {
+	usage := l.currentUsage(identity, now)
+	rate, burst, unlimitedRequests := l.requestLimits(identity)
+	limit, unlimitedEmbeddings := l.embeddingLimit(identity)
+
+	result := Usage{
+		Identity:          identity,
+		KeyHint:           l.hints[identity],
+		RequestsPerSecond: rate,
+		Burst:             burst,
+		EmbeddingsUsed:    usage.embeddings,
+		EmbeddingsLimit:   limit,
+		Unlimited:         unlimitedRequests && unlimitedEmbeddings,
+		ResetsAt:          usage.day.Add(24 * time.Hour),
+	}
+
+	if !unlimitedEmbeddings {
+		result.EmbeddingsRemaining = max(limit-usage.embeddings, 0)
+	}
+
+	if override, ok := l.activeOverride(identity); ok {
+		result.Override = &override
+	}
+
+	return result
+}
@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIdleStateIsEvicted(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(Config{Enabled: true, RequestsPerSecond: 1, Burst: 10, EmbeddingsPerDay: 100})
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		identity := fmt.Sprintf("ip:192.0.2.%d", i)
+		limiter.RememberHint(identity, "hint…")
+		limiter.Take(identity)
+		if _, err := limiter.ConsumeEmbeddings(identity, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limiter.SetOverride("ip:198.51.100.1", Override{EmbeddingsPerDay: 1000})
+
+	// Within the day the buckets refill, and they go once the next sweep is due
+	now = now.Add(2 * sweepInterval)
+	limiter.Take("ip:203.0.113.1")
+	if got := len(limiter.buckets); got != 1 {
+		t.Errorf("%d buckets after they refilled, want only the new caller's", got)
+	}
+	if got := len(limiter.usage); got != 100 {
+		t.Errorf("%d usage counters, want today's 100 kept", got)
+	}
+	if got := limiter.Usage("ip:192.0.2.1").EmbeddingsUsed; got != 1 {
+		t.Errorf("embeddings used %d, want 1 after a sweep", got)
+	}
+
+	// The next day yesterday's usage and the hints that went with it are dropped
+	now = now.Add(24 * time.Hour)
+	limiter.Take("ip:203.0.113.1")
+	if got := len(limiter.usage); got != 0 {
+		t.Errorf("%d usage counters from yesterday, want 0", got)
+	}
+	if got := len(limiter.hints); got != 0 {
+		t.Errorf("%d hints without state, want 0", got)
+	}
+	if _, ok := limiter.overrides["ip:198.51.100.1"]; !ok {
+		t.Error("an override was dropped by the sweep")
+	}
+}
@@ -0,0 +1,181 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/pkg/auth"
+)
+
+// APIKeyHeader is the header clients use to identify themselves for quota purposes
+const APIKeyHeader = "X-API-Key"
+
+// identityKey holds the identity the middleware resolved for a request
+const identityKey = "ratelimit_identity"
+
+// Identity returns the rate limit identity the middleware resolved for a request: the API
+// key's hash for keys the limiter recognises, otherwise the client IP
+func Identity(c *gin.Context) string {
+	if identity, ok := c.Get(identityKey); ok {
+		return identity.(string)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// KeyIdentity is the identity of an API key. It is keyed by the key's SHA-256, so usage
+// reports and overrides never carry the key itself.
+func KeyIdentity(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return "key:" + hex.EncodeToString(sum[:8])
+}
+
+// KeyHint is the start of an API key, enough for an operator to recognise it. Short keys
+// show less, so a hint never gives away most of a key.
+func KeyHint(apiKey string) string {
+	return apiKey[:min(10, len(apiKey)/4)] + "…"
+}
+
+// identify resolves and records a request's identity. Only recognised keys get limits of
+// their own; any other key is limited with the rest of its IP address, so sending a new
+// key with each request earns no new bucket or quota.
+func (l *Limiter) identify(c *gin.Context) string {
+	identity := "ip:" + c.ClientIP()
+	if apiKey := c.GetHeader(APIKeyHeader); apiKey != "" && l.Recognises(apiKey) {
+		identity = KeyIdentity(apiKey)
+		l.RememberHint(identity, KeyHint(apiKey))
+	}
+	c.Set(identityKey, identity)
+	return identity
+}
+
+// Middleware resolves the caller's identity and enforces its request rate. Every response
+// carries the caller's X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset, so
+// clients can slow down before they are refused.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity := l.identify(c)
+		if !l.Enabled() {
+			c.Next()
+			return
+		}
+
+		status := l.Take(identity)
+		SetRequestHeaders(c, status)
+		if !status.Allowed {
+			tooManyRequests(c, status.Wait, "rate_limit_exceeded", "too many requests")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// SetRequestHeaders writes a request bucket's state; unlimited callers get no headers
+func SetRequestHeaders(c *gin.Context, status RequestStatus) {
+	if status.Unlimited {
+		return
+	}
+	c.Header("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(resetSeconds(status.ResetsAt), 10))
+}
+
+// SetQuotaHeaders writes a caller's daily embedding quota; unlimited callers get no headers
+func SetQuotaHeaders(c *gin.Context, usage Usage) {
+	if usage.EmbeddingsLimit <= 0 {
+		return
+	}
+	c.Header("X-Quota-Limit", strconv.FormatInt(usage.EmbeddingsLimit, 10))
+	c.Header("X-Quota-Remaining", strconv.FormatInt(usage.EmbeddingsRemaining, 10))
+	c.Header("X-Quota-Reset", strconv.FormatInt(resetSeconds(usage.ResetsAt), 10))
+}
+
+// resetSeconds rounds a reset time up to the Unix second, so clients never retry too early
+func resetSeconds(at time.Time) int64 {
+	seconds := at.Unix()
+	if at.Nanosecond() > 0 {
+		seconds++
+	}
+	return seconds
+}
+
+// ReserveEmbeddings charges n embeddings to the caller's daily quota.
+// It writes a 429 response and returns false when the quota is exhausted.
+func (l *Limiter) ReserveEmbeddings(c *gin.Context, n int) bool {
+	if !l.Enabled() || n <= 0 {
+		return true
+	}
+
+	identity := Identity(c)
+	wait, err := l.ConsumeEmbeddings(identity, int64(n))
+	usage := l.Usage(identity)
+	SetQuotaHeaders(c, usage)
+	if err != nil {
+		tooManyRequests(c, wait, "quota_exceeded",
+			fmt.Sprintf("daily embedding quota exceeded: %d of %d used, request needs %d",
+				usage.EmbeddingsUsed, usage.EmbeddingsLimit, n))
+		return false
+	}
+
+	return true
+}
+
+// AllowClone checks a namespace copy of the given size against the clone limit.
+// Admin keys are exempt. It writes a 403 response and returns false when the clone is too large.
+func (l *Limiter) AllowClone(c *gin.Context, vectors int64) bool {
+	limit := l.config.MaxCloneVectors
+	if !l.Enabled() || limit <= 0 || vectors <= limit || l.IsAdmin(c.GetHeader(APIKeyHeader)) {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "quota_exceeded",
+		"message": fmt.Sprintf("namespace has %d vectors, clone limit is %d", vectors, limit),
+	})
+	c.Abort()
+	return false
+}
+
+// RequireAdmin restricts a route to callers presenting a configured admin key.
+// Services authenticated by auth.ServiceIdentityMiddleware are admitted as well.
+func (l *Limiter) RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := auth.GetService(c); ok {
+			c.Next()
+			return
+		}
+
+		if !l.IsAdmin(c.GetHeader(APIKeyHeader)) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":   "forbidden",
+				"message": "admin API key required",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func tooManyRequests(c *gin.Context, wait time.Duration, code, message string) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       code,
+		"message":     message,
+		"retry_after": retryAfter,
+	})
+	c.Abort()
+}
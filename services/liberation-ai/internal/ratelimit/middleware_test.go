@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter(limiter *Limiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/v1/usage", limiter.Middleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, limiter.Usage(Identity(c)))
+	})
+	return router
+}
+
+func get(router *gin.Engine, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/v1/usage", nil)
+	req.RemoteAddr = "192.0.2.7:4321"
+	if apiKey != "" {
+		req.Header.Set(APIKeyHeader, apiKey)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUnrecognisedKeysShareTheirIPBucket(t *testing.T) {
+	limiter := NewLimiter(Config{Enabled: true, RequestsPerSecond: 0.001, Burst: 2})
+	router := newTestRouter(limiter)
+
+	for i := 0; i < 2; i++ {
+		if w := get(router, fmt.Sprintf("random-%d", i)); w.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d, want 200", i, w.Code)
+		}
+	}
+	if w := get(router, "random-2"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("a fresh key got a fresh bucket: got %d, want 429", w.Code)
+	}
+	if w := get(router, ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("the IP's bucket should be empty: got %d, want 429", w.Code)
+	}
+}
+
+func TestRecognisedKeysAreLimitedOnTheirOwn(t *testing.T) {
+	const adminKey, tenantKey = "admin-secret-key-0123", "lai_0123456789abcdef"
+	limiter := NewLimiter(Config{Enabled: true, RequestsPerSecond: 0.001, Burst: 1, AdminKeys: []string{adminKey}})
+	limiter.RecogniseKeys(func(apiKey string) bool { return apiKey == tenantKey })
+	router := newTestRouter(limiter)
+
+	if w := get(router, ""); w.Code != http.StatusOK {
+		t.Fatalf("IP request: got %d, want 200", w.Code)
+	}
+	for _, key := range []string{adminKey, tenantKey} {
+		w := get(router, key)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s shares the IP bucket: got %d, want 200", key, w.Code)
+		}
+		var usage Usage
+		if err := json.Unmarshal(w.Body.Bytes(), &usage); err != nil {
+			t.Fatal(err)
+		}
+		if usage.Identity != KeyIdentity(key) || strings.Contains(usage.Identity, key) {
+			t.Errorf("identity %q, want the key's hash %q", usage.Identity, KeyIdentity(key))
+		}
+		if !strings.HasPrefix(key, strings.TrimSuffix(usage.KeyHint, "…")) || len(usage.KeyHint) >= len(key) {
+			t.Errorf("key hint %q should be a short prefix of the key", usage.KeyHint)
+		}
+	}
+
+	for _, usage := range limiter.AllUsage() {
+		for _, key := range []string{adminKey, tenantKey} {
+			if strings.Contains(usage.Identity, key) || strings.Contains(usage.KeyHint, key) {
+				t.Errorf("usage report carries the key %q: %+v", key, usage)
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package relevance
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"liberation-ai/internal/analytics"
+	"liberation-ai/pkg/types"
+)
+
+// Config controls how user feedback re-weights search results
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Weight is the largest score adjustment a document can receive for a query
+	Weight float64 `yaml:"weight" json:"weight"`
+	// PriorStrength damps boosts until enough feedback has accumulated
+	PriorStrength float64 `yaml:"prior_strength" json:"prior_strength"`
+	// Namespaces overrides Enabled for individual namespaces
+	Namespaces map[string]bool `yaml:"namespaces" json:"namespaces,omitempty"`
+}
+
+// DefaultConfig returns conservative boosting settings
+func DefaultConfig() Config {
+	return Config{
+		Enabled:       true,
+		Weight:        0.1,
+		PriorStrength: 3,
+	}
+}
+
+// Signals counts the feedback received for a document
+type Signals struct {
+	Up      int       `json:"up"`
+	Down    int       `json:"down"`
+	Clicks  int       `json:"clicks"`
+	Updated time.Time `json:"updated"`
+}
+
+// Boost is a learned adjustment for a document, optionally specific to a query
+type Boost struct {
+	Query      string  `json:"query,omitempty"`
+	DocumentID string  `json:"document_id"`
+	Boost      float64 `json:"boost"`
+	Signals    Signals `json:"signals"`
+}
+
+// NamespaceBoosts describes the learned state of one namespace
+type NamespaceBoosts struct {
+	Namespace string  `json:"namespace"`
+	Enabled   bool    `json:"enabled"`
+	Queries   []Boost `json:"queries"`
+	Documents []Boost `json:"documents"`
+}
+
+type queryKey struct {
+	query string
+	docID string
+}
+
+type namespaceState struct {
+	queries   map[queryKey]*Signals
+	documents map[string]*Signals
+}
+
+// Booster learns per-(query, document) relevance from feedback and applies it at ranking time
+type Booster struct {
+	mu         sync.RWMutex
+	config     Config
+	namespaces map[string]*namespaceState
+	toggles    map[string]bool
+	now        func() time.Time
+}
+
+// NewBooster creates a new in-memory relevance booster
+func NewBooster(config Config) *Booster {
+	toggles := make(map[string]bool, len(config.Namespaces))
+	for namespace, enabled := range config.Namespaces {
+		toggles[namespace] = enabled
+	}
+	if config.PriorStrength <= 0 {
+		config.PriorStrength = DefaultConfig().PriorStrength
+	}
+
+	return &Booster{
+		config:     config,
+		namespaces: make(map[string]*namespaceState),
+		toggles:    toggles,
+		now:        time.Now,
+	}
+}
+
+// EnabledFor reports whether boosting applies to a namespace
+func (b *Booster) EnabledFor(namespace string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.enabledLocked(namespace)
+}
+
+// SetEnabled turns boosting on or off for a single namespace
+func (b *Booster) SetEnabled(namespace string, enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.toggles[namespace] = enabled
+}
+
+// Record folds a feedback event into the learned signals
+func (b *Booster) Record(event analytics.FeedbackEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.namespaces[event.Namespace]
+	if state == nil {
+		state = &namespaceState{
+			queries:   make(map[queryKey]*Signals),
+			documents: make(map[string]*Signals),
+		}
+		b.namespaces[event.Namespace] = state
+	}
+
+	key := queryKey{query: event.Query, docID: event.DocumentID}
+	if state.queries[key] == nil {
+		state.queries[key] = &Signals{}
+	}
+	if state.documents[event.DocumentID] == nil {
+		state.documents[event.DocumentID] = &Signals{}
+	}
+
+	for _, signals := range []*Signals{state.queries[key], state.documents[event.DocumentID]} {
+		switch event.Action {
+		case analytics.ActionThumbsUp:
+			signals.Up++
+		case analytics.ActionThumbsDown:
+			signals.Down++
+		case analytics.ActionClick, analytics.ActionSelect:
+			signals.Clicks++
+		}
+		signals.Updated = event.Timestamp
+	}
+}
+
+// Apply adjusts result scores with learned boosts and re-sorts them.
+// Query-specific signals count fully; document-wide signals count at half weight.
+func (b *Booster) Apply(namespace, query string, response *types.SearchResponse) {
+	if response == nil || len(response.Results) == 0 {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if !b.enabledLocked(namespace) {
+		return
+	}
+	state := b.namespaces[namespace]
+	if state == nil {
+		return
+	}
+
+	normalized := analytics.Anonymize(query)
+	changed := false
+	for i := range response.Results {
+		docID := response.Results[i].Vector.ID
+
+		boost := 0.0
+		if signals := state.queries[queryKey{query: normalized, docID: docID}]; signals != nil {
+			boost += b.score(signals)
+		}
+		if signals := state.documents[docID]; signals != nil {
+			boost += b.score(signals) / 2
+		}
+
+		if boost != 0 {
+			response.Results[i].Score += boost
+			changed = true
+		}
+	}
+
+	if changed {
+		sort.SliceStable(response.Results, func(i, j int) bool {
+			return response.Results[i].Score > response.Results[j].Score
+		})
+	}
+}
+
+// Boosts lists the learned adjustments for a namespace, strongest first
+func (b *Booster) Boosts(namespace string) NamespaceBoosts {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	result := NamespaceBoosts{
+		Namespace: namespace,
+		Enabled:   b.enabledLocked(namespace),
+		Queries:   []Boost{},
+		Documents: []Boost{},
+	}
+
+	state := b.namespaces[namespace]
+	if state == nil {
+		return result
+	}
+
+	for key, signals := range state.queries {
+		result.Queries = append(result.Queries, Boost{Query: key.query, DocumentID: key.docID, Boost: b.score(signals), Signals: *signals})
+	}
+	for docID, signals := range state.documents {
+		result.Documents = append(result.Documents, Boost{DocumentID: docID, Boost: b.score(signals) / 2, Signals: *signals})
+	}
+
+	byMagnitude := func(boosts []Boost) {
+		sort.Slice(boosts, func(i, j int) bool {
+			return abs(boosts[i].Boost) > abs(boosts[j].Boost)
+		})
+	}
+	byMagnitude(result.Queries)
+	byMagnitude(result.Documents)
+
+	return result
+}
+
+// Reset forgets learned signals for a namespace, or for one document when documentID is set.
+// It returns the number of signal entries removed.
+func (b *Booster) Reset(namespace, documentID string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.namespaces[namespace]
+	if state == nil {
+		return 0
+	}
+
+	if documentID == "" {
+		removed := len(state.queries) + len(state.documents)
+		delete(b.namespaces, namespace)
+		return removed
+	}
+
+	removed := 0
+	for key := range state.queries {
+		if key.docID == documentID {
+			delete(state.queries, key)
+			removed++
+		}
+	}
+	if _, exists := state.documents[documentID]; exists {
+		delete(state.documents, documentID)
+		removed++
+	}
+	return removed
+}
+
+func (b *Booster) enabledLocked(namespace string) bool {
+	if enabled, exists := b.toggles[namespace]; exists {
+		return enabled
+	}
+	return b.config.Enabled
+}
+
+// score maps signals to [-Weight, Weight], shrinking towards zero while evidence is thin.
+// Clicks are a weaker positive signal than an explicit thumbs up.
+func (b *Booster) score(signals *Signals) float64 {
+	positive := float64(signals.Up) + 0.5*float64(signals.Clicks)
+	negative := float64(signals.Down)
+	total := positive + negative
+	if total == 0 {
+		return 0
+	}
+	return b.config.Weight * (positive - negative) / (total + b.config.PriorStrength)
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
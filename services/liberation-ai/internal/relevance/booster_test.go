@@ -0,0 +1,154 @@
+package relevance
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"liberation-ai/internal/analytics"
+	"liberation-ai/pkg/types"
+)
+
+func feedback(namespace, query, docID, action string) analytics.FeedbackEvent {
+	return analytics.FeedbackEvent{
+		Namespace: namespace, Query: query, DocumentID: docID, Action: action,
+		Timestamp: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func results(scores map[string]float64, order ...string) *types.SearchResponse {
+	response := &types.SearchResponse{}
+	for _, id := range order {
+		response.Results = append(response.Results, types.SearchResult{Vector: types.Vector{ID: id}, Score: scores[id]})
+	}
+	return response
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestScore(t *testing.T) {
+	b := NewBooster(DefaultConfig())
+	for _, tc := range []struct {
+		signals Signals
+		want    float64
+	}{
+		{Signals{}, 0},
+		{Signals{Up: 1}, 0.025},
+		{Signals{Down: 1}, -0.025},
+		{Signals{Clicks: 2}, 0.025},
+		{Signals{Up: 3, Down: 3}, 0},
+		{Signals{Up: 97}, 0.097},
+		{Signals{Down: 1000}, -0.1 * 1000 / 1003},
+	} {
+		if got := b.score(&tc.signals); !near(got, tc.want) {
+			t.Errorf("score(%+v) = %v, want %v", tc.signals, got, tc.want)
+		}
+	}
+
+	// A missing or non-positive prior strength falls back to the default
+	if b := NewBooster(Config{Weight: 0.1}); b.config.PriorStrength != DefaultConfig().PriorStrength {
+		t.Errorf("prior strength %v", b.config.PriorStrength)
+	}
+}
+
+func TestApplyReranks(t *testing.T) {
+	b := NewBooster(DefaultConfig())
+	for i := 0; i < 3; i++ {
+		b.Record(feedback("docs", "ladder", "b", analytics.ActionThumbsUp))
+	}
+	b.Record(feedback("docs", "ladder", "a", analytics.ActionThumbsDown))
+
+	scores := map[string]float64{"a": 0.8, "b": 0.75, "c": 0.77}
+	response := results(scores, "a", "b", "c")
+	b.Apply("docs", "  LADDER ", response)
+
+	// b: the query boost, 0.1 * 3/6, and half its document boost; a loses the same way
+	want := []struct {
+		id    string
+		score float64
+	}{{"b", 0.75 + 0.05 + 0.025}, {"c", 0.77}, {"a", 0.8 - 0.025 - 0.0125}}
+	for i, w := range want {
+		if got := response.Results[i]; got.Vector.ID != w.id || !near(got.Score, w.score) {
+			t.Errorf("result %d is %s with %v, want %s with %v", i, got.Vector.ID, got.Score, w.id, w.score)
+		}
+	}
+
+	// Other queries only get the document-wide half
+	other := results(scores, "a", "b", "c")
+	b.Apply("docs", "drill", other)
+	if !near(other.Results[0].Score, 0.7875) || other.Results[1].Vector.ID != "b" || !near(other.Results[1].Score, 0.775) {
+		t.Errorf("other query ranked %+v", other.Results)
+	}
+
+	// Nothing is learned across namespaces
+	untouched := results(scores, "a", "b", "c")
+	b.Apply("wiki", "ladder", untouched)
+	if untouched.Results[0].Vector.ID != "a" || untouched.Results[0].Score != 0.8 {
+		t.Errorf("wiki results changed: %+v", untouched.Results)
+	}
+	b.Apply("docs", "ladder", nil)
+}
+
+func TestToggles(t *testing.T) {
+	config := DefaultConfig()
+	config.Namespaces = map[string]bool{"docs": false}
+	b := NewBooster(config)
+	b.Record(feedback("docs", "ladder", "b", analytics.ActionThumbsUp))
+	b.Record(feedback("wiki", "ladder", "b", analytics.ActionThumbsUp))
+
+	if b.EnabledFor("docs") || !b.EnabledFor("wiki") {
+		t.Errorf("enabled: docs %v, wiki %v", b.EnabledFor("docs"), b.EnabledFor("wiki"))
+	}
+	response := results(map[string]float64{"a": 0.8, "b": 0.78}, "a", "b")
+	b.Apply("docs", "ladder", response)
+	if response.Results[0].Vector.ID != "a" || response.Results[1].Score != 0.78 {
+		t.Errorf("a disabled namespace was boosted: %+v", response.Results)
+	}
+
+	// Signals are still learned while disabled, and apply once enabled
+	b.SetEnabled("docs", true)
+	b.Apply("docs", "ladder", response)
+	if response.Results[0].Vector.ID != "b" {
+		t.Errorf("enabled namespace not boosted: %+v", response.Results)
+	}
+	b.SetEnabled("wiki", false)
+	if b.EnabledFor("wiki") || b.Boosts("wiki").Enabled {
+		t.Error("wiki still enabled")
+	}
+}
+
+func TestBoostsAndReset(t *testing.T) {
+	b := NewBooster(DefaultConfig())
+	b.Record(feedback("docs", "ladder", "a", analytics.ActionClick))
+	b.Record(feedback("docs", "drill", "a", analytics.ActionThumbsDown))
+	for i := 0; i < 5; i++ {
+		b.Record(feedback("docs", "drill", "b", analytics.ActionThumbsDown))
+	}
+
+	boosts := b.Boosts("docs")
+	if len(boosts.Queries) != 3 || len(boosts.Documents) != 2 {
+		t.Fatalf("boosts %+v", boosts)
+	}
+	if first := boosts.Queries[0]; first.Query != "drill" || first.DocumentID != "b" || first.Signals.Down != 5 || !near(first.Boost, -0.0625) {
+		t.Errorf("strongest query boost %+v", first)
+	}
+	if first := boosts.Documents[0]; first.DocumentID != "b" || !near(first.Boost, -0.03125) {
+		t.Errorf("strongest document boost %+v", first)
+	}
+	if empty := b.Boosts("wiki"); empty.Queries == nil || len(empty.Documents) != 0 {
+		t.Errorf("unknown namespace: %+v", empty)
+	}
+
+	// One document: its two query entries and its document entry
+	if removed := b.Reset("docs", "a"); removed != 3 {
+		t.Errorf("reset a removed %d", removed)
+	}
+	if removed := b.Reset("docs", ""); removed != 2 {
+		t.Errorf("reset docs removed %d", removed)
+	}
+	if removed := b.Reset("docs", ""); removed != 0 {
+		t.Errorf("second reset removed %d", removed)
+	}
+}
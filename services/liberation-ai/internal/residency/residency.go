@@ -0,0 +1,327 @@
+// Package residency pins namespaces to regions, so customers who need EU-only storage get it.
+//
+// Every backend that receives a namespace's data declares the region it keeps data in: the
+// vector store and its fallback, the embedding provider, the chat provider and the ingestion
+// archive. A namespace pinned to a region may only reach backends in that region; writes that
+// would send its data anywhere else are refused with ErrResidency before anything leaves the
+// process. Namespaces are pinned in the config file, by name or by prefix, or at runtime
+// through the admin API. Unpinned namespaces reach every backend.
+package residency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"liberation-ai/pkg/types"
+)
+
+// Backends that receive namespace data
+const (
+	BackendVectorStore = "vector_store"
+	BackendFallback    = "fallback"
+	BackendEmbeddings  = "embeddings"
+	BackendChat        = "chat"
+	BackendArchive     = "archive"
+	// BackendMigration is the store vector store migrations copy into
+	BackendMigration = "migration"
+)
+
+// Where a namespace's region comes from
+const (
+	SourcePinned  = "pinned"
+	SourceConfig  = "config"
+	SourceDefault = "default"
+)
+
+// ErrInvalidRegion is returned for region names that are not lowercase slugs
+var ErrInvalidRegion = errors.New("regions are lowercase letters, digits and dashes, such as eu or eu-west-1")
+
+var regionPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+
+// Config declares the regions of backends and pins namespaces to regions
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Backends maps each backend (vector_store, fallback, embeddings, chat, archive) to the
+	// region it keeps data in. Every backend in use must be declared once residency is enabled.
+	Backends map[string]string `yaml:"backends" json:"backends"`
+	// Namespaces pins namespaces to regions; a name ending in * pins every namespace with
+	// that prefix, such as acme/*
+	Namespaces map[string]string `yaml:"namespaces" json:"namespaces"`
+	// DefaultRegion pins namespaces that are not listed; empty leaves them unpinned
+	DefaultRegion string `yaml:"default_region" json:"default_region"`
+	// StateFile keeps namespaces pinned through the admin API; empty keeps them in memory
+	StateFile string `yaml:"state_file" json:"state_file"`
+}
+
+// DefaultConfig leaves residency off; pins made through the admin API are kept next to the
+// job state
+func DefaultConfig() Config {
+	return Config{StateFile: "data/residency.json"}
+}
+
+// Validate reports malformed regions and, for the backends in use, undeclared ones
+func (c Config) Validate(inUse []string) error {
+	if !c.Enabled {
+		return nil
+	}
+	for _, backend := range inUse {
+		if c.Backends[backend] == "" {
+			return fmt.Errorf("backend %s is in use but declares no region; add it under residency.backends", backend)
+		}
+	}
+	for backend, region := range c.Backends {
+		if !regionPattern.MatchString(region) {
+			return fmt.Errorf("backend %s: %w", backend, ErrInvalidRegion)
+		}
+	}
+	for namespace, region := range c.Namespaces {
+		if namespace == "" || namespace == "*" {
+			return fmt.Errorf("pinned namespaces need a name or prefix; use default_region to pin everything")
+		}
+		if !regionPattern.MatchString(region) {
+			return fmt.Errorf("namespace %s: %w", namespace, ErrInvalidRegion)
+		}
+	}
+	if c.DefaultRegion != "" && !regionPattern.MatchString(c.DefaultRegion) {
+		return fmt.Errorf("default_region: %w", ErrInvalidRegion)
+	}
+	return nil
+}
+
+// ValidateRegion returns ErrInvalidRegion for names that are not region slugs
+func ValidateRegion(region string) error {
+	if !regionPattern.MatchString(region) {
+		return ErrInvalidRegion
+	}
+	return nil
+}
+
+// Placement is where a namespace's data may go and whether the backends in use comply
+type Placement struct {
+	Namespace string `json:"namespace"`
+	// Region is empty for unpinned namespaces
+	Region string `json:"region,omitempty"`
+	Source string `json:"source,omitempty"`
+	// Violations lists the backends in use that are in another region; writes to the
+	// namespace are refused while any are
+	Violations []string `json:"violations,omitempty"`
+}
+
+// Compliant reports whether every backend in use is in the namespace's region
+func (p Placement) Compliant() bool {
+	return len(p.Violations) == 0
+}
+
+// MarshalJSON adds compliant to the placement
+func (p Placement) MarshalJSON() ([]byte, error) {
+	type placement Placement
+	return json.Marshal(struct {
+		placement
+		Compliant bool `json:"compliant"`
+	}{placement(p), p.Compliant()})
+}
+
+// Policy resolves namespaces' regions and checks backends against them. It is safe for
+// concurrent use. A nil policy allows everything.
+type Policy struct {
+	config Config
+	// inUse are the backends this deployment sends data to
+	inUse []string
+
+	mu     sync.RWMutex
+	pinned map[string]string
+}
+
+// New loads namespaces pinned at runtime from the state file. inUse lists the backends
+// this deployment sends data to. It returns nil when residency is disabled.
+func New(config Config, inUse []string) (*Policy, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	p := &Policy{config: config, inUse: inUse, pinned: make(map[string]string)}
+	if config.StateFile == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(config.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.pinned); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", config.StateFile, err)
+	}
+	return p, nil
+}
+
+// Enabled reports whether namespaces can be pinned
+func (p *Policy) Enabled() bool {
+	return p != nil
+}
+
+// Backends returns the declared region of each backend in use
+func (p *Policy) Backends() map[string]string {
+	backends := make(map[string]string, len(p.inUse))
+	for _, backend := range p.inUse {
+		backends[backend] = p.config.Backends[backend]
+	}
+	return backends
+}
+
+// Place resolves a namespace's region: a runtime pin, then the config's exact name, then
+// its longest matching prefix, then the default region
+func (p *Policy) Place(namespace string) Placement {
+	placement := Placement{Namespace: namespace}
+	if p == nil {
+		return placement
+	}
+	p.mu.RLock()
+	region, pinned := p.pinned[namespace]
+	p.mu.RUnlock()
+
+	switch {
+	case pinned:
+		placement.Region, placement.Source = region, SourcePinned
+	case p.config.Namespaces[namespace] != "":
+		placement.Region, placement.Source = p.config.Namespaces[namespace], SourceConfig
+	default:
+		longest := -1
+		for pattern, region := range p.config.Namespaces {
+			prefix, ok := strings.CutSuffix(pattern, "*")
+			if ok && strings.HasPrefix(namespace, prefix) && len(prefix) > longest {
+				placement.Region, placement.Source, longest = region, SourceConfig, len(prefix)
+			}
+		}
+		if longest < 0 && p.config.DefaultRegion != "" {
+			placement.Region, placement.Source = p.config.DefaultRegion, SourceDefault
+		}
+	}
+	if placement.Region != "" {
+		for _, backend := range p.inUse {
+			if p.config.Backends[backend] != placement.Region {
+				placement.Violations = append(placement.Violations, backend)
+			}
+		}
+	}
+	return placement
+}
+
+// Region returns the region a namespace is pinned to; empty when it is not
+func (p *Policy) Region(namespace string) string {
+	return p.Place(namespace).Region
+}
+
+// Check returns an error wrapping types.ErrResidency when the namespace is pinned to a
+// region and any of the backends keeps data elsewhere. Backends not in use are ignored.
+func (p *Policy) Check(namespace string, backends ...string) error {
+	if p == nil {
+		return nil
+	}
+	region := p.Region(namespace)
+	if region == "" {
+		return nil
+	}
+	for _, backend := range backends {
+		if !p.uses(backend) {
+			continue
+		}
+		if declared := p.config.Backends[backend]; declared != region {
+			return fmt.Errorf("%w: %s is pinned to %s but %s is in %s", types.ErrResidency, namespace, region, backend, declared)
+		}
+	}
+	return nil
+}
+
+// CheckCopy refuses copying a pinned namespace's data into a namespace pinned elsewhere
+// or not pinned at all
+func (p *Policy) CheckCopy(source, target string) error {
+	if p == nil {
+		return nil
+	}
+	from, to := p.Region(source), p.Region(target)
+	if from != "" && from != to {
+		return fmt.Errorf("%w: %s is pinned to %s and %s is not", types.ErrResidency, source, from, target)
+	}
+	return nil
+}
+
+func (p *Policy) uses(backend string) bool {
+	for _, inUse := range p.inUse {
+		if inUse == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// Pin pins a namespace to a region, overriding the config. Existing data is not moved;
+// pinning only governs where the namespace's data goes from now on.
+func (p *Policy) Pin(namespace, region string) error {
+	if err := ValidateRegion(region); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pinned[namespace] = region
+	return p.saveLocked()
+}
+
+// Unpin removes a runtime pin, so the config decides the namespace's region again. It
+// reports whether there was one.
+func (p *Policy) Unpin(namespace string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.pinned[namespace]; !ok {
+		return false, nil
+	}
+	delete(p.pinned, namespace)
+	return true, p.saveLocked()
+}
+
+// Placements resolves the given namespaces and those pinned at runtime, sorted by name
+func (p *Policy) Placements(namespaces []string) []Placement {
+	seen := make(map[string]bool, len(namespaces))
+	p.mu.RLock()
+	all := append([]string(nil), namespaces...)
+	for namespace := range p.pinned {
+		all = append(all, namespace)
+	}
+	p.mu.RUnlock()
+	sort.Strings(all)
+
+	placements := []Placement{}
+	for _, namespace := range all {
+		if !seen[namespace] {
+			seen[namespace] = true
+			placements = append(placements, p.Place(namespace))
+		}
+	}
+	return placements
+}
+
+// saveLocked writes the runtime pins to the state file. The caller holds mu.
+func (p *Policy) saveLocked() error {
+	if p.config.StateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.pinned, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.config.StateFile), 0o755); err != nil {
+		return err
+	}
+	temp := p.config.StateFile + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temp, p.config.StateFile)
+}
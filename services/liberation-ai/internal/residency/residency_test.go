@@ -0,0 +1,182 @@
+package residency
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"liberation-ai/pkg/types"
+)
+
+var inUse = []string{BackendVectorStore, BackendEmbeddings, BackendChat}
+
+// testConfig keeps vectors and embeddings in the EU and sends chat to the US
+func testConfig(stateFile string) Config {
+	return Config{
+		Enabled: true,
+		Backends: map[string]string{
+			BackendVectorStore: "eu", BackendEmbeddings: "eu", BackendChat: "us", BackendArchive: "us",
+		},
+		Namespaces: map[string]string{"acme/*": "eu", "acme/us/*": "us", "gdpr": "eu"},
+		StateFile:  stateFile,
+	}
+}
+
+func testPolicy(t *testing.T, config Config) *Policy {
+	t.Helper()
+	p, err := New(config, inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		valid  bool
+	}{
+		{"valid", func(c *Config) {}, true},
+		{"disabled", func(c *Config) { c.Enabled, c.Backends = false, nil }, true},
+		{"undeclared backend", func(c *Config) { delete(c.Backends, BackendChat) }, false},
+		{"unused backend undeclared", func(c *Config) { delete(c.Backends, BackendArchive) }, true},
+		{"malformed backend region", func(c *Config) { c.Backends[BackendArchive] = "EU West" }, false},
+		{"pinned everything", func(c *Config) { c.Namespaces["*"] = "eu" }, false},
+		{"malformed namespace region", func(c *Config) { c.Namespaces["gdpr"] = "eu_1" }, false},
+		{"malformed default", func(c *Config) { c.DefaultRegion = "1eu" }, false},
+		{"default", func(c *Config) { c.DefaultRegion = "eu-west-1" }, true},
+	} {
+		config := testConfig("")
+		tc.change(&config)
+		if err := config.Validate(inUse); (err == nil) != tc.valid {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	p, err := New(Config{}, inUse)
+	if err != nil || p != nil || p.Enabled() {
+		t.Fatalf("disabled policy %v, %v", p, err)
+	}
+	if placement := p.Place("gdpr"); placement.Region != "" || !placement.Compliant() {
+		t.Errorf("nil policy placed %+v", placement)
+	}
+	if p.Check("gdpr", BackendChat) != nil || p.CheckCopy("gdpr", "other") != nil {
+		t.Error("a nil policy refused")
+	}
+}
+
+func TestPlace(t *testing.T) {
+	config := testConfig("")
+	config.DefaultRegion = "us"
+	p := testPolicy(t, config)
+	if err := p.Pin("acme/us/eu-only", "eu"); err != nil {
+		t.Fatal(err)
+	}
+
+	for namespace, want := range map[string]Placement{
+		"gdpr":            {Region: "eu", Source: SourceConfig, Violations: []string{BackendChat}},
+		"acme/tools":      {Region: "eu", Source: SourceConfig, Violations: []string{BackendChat}},
+		"acme/us/tools":   {Region: "us", Source: SourceConfig, Violations: []string{BackendVectorStore, BackendEmbeddings}},
+		"acme/us/eu-only": {Region: "eu", Source: SourcePinned, Violations: []string{BackendChat}},
+		"other":           {Region: "us", Source: SourceDefault, Violations: []string{BackendVectorStore, BackendEmbeddings}},
+	} {
+		got := p.Place(namespace)
+		if got.Namespace != namespace || got.Region != want.Region || got.Source != want.Source || strings.Join(got.Violations, ",") != strings.Join(want.Violations, ",") {
+			t.Errorf("Place(%q) = %+v", namespace, got)
+		}
+	}
+
+	data, err := json.Marshal(p.Place("gdpr"))
+	if err != nil || !strings.Contains(string(data), `"compliant":false`) || !strings.Contains(string(data), `"source":"config"`) {
+		t.Errorf("placement JSON %s, %v", data, err)
+	}
+	if backends := p.Backends(); len(backends) != 3 || backends[BackendChat] != "us" {
+		t.Errorf("backends %v", backends)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := testPolicy(t, testConfig(""))
+
+	if err := p.Check("gdpr", BackendVectorStore, BackendEmbeddings); err != nil {
+		t.Errorf("EU backends: %v", err)
+	}
+	err := p.Check("gdpr", BackendVectorStore, BackendChat)
+	if !errors.Is(err, types.ErrResidency) || !strings.Contains(err.Error(), "gdpr is pinned to eu but chat is in us") {
+		t.Errorf("US chat: %v", err)
+	}
+	// The archive is declared in the US but not in use
+	if err := p.Check("gdpr", BackendArchive); err != nil {
+		t.Errorf("unused backend: %v", err)
+	}
+	if err := p.Check("unpinned", BackendChat); err != nil {
+		t.Errorf("unpinned namespace: %v", err)
+	}
+
+	for _, tc := range []struct {
+		source, target string
+		allowed        bool
+	}{
+		{"gdpr", "acme/tools", true},
+		{"gdpr", "acme/us/tools", false},
+		{"gdpr", "unpinned", false},
+		{"unpinned", "gdpr", true},
+	} {
+		if err := p.CheckCopy(tc.source, tc.target); (err == nil) != tc.allowed || (err != nil && !errors.Is(err, types.ErrResidency)) {
+			t.Errorf("copying %s to %s: %v", tc.source, tc.target, err)
+		}
+	}
+}
+
+func TestPin(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state", "residency.json")
+	p := testPolicy(t, testConfig(stateFile))
+
+	if err := p.Pin("docs", "EU"); !errors.Is(err, ErrInvalidRegion) {
+		t.Errorf("malformed region: %v", err)
+	}
+	if err := p.Pin("gdpr", "us"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin("docs", "eu"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pins survive a restart
+	reloaded := testPolicy(t, testConfig(stateFile))
+	if placement := reloaded.Place("gdpr"); placement.Region != "us" || placement.Source != SourcePinned {
+		t.Errorf("reloaded pin %+v", placement)
+	}
+	placements := reloaded.Placements([]string{"gdpr", "acme/tools", "unpinned"})
+	var names []string
+	for _, placement := range placements {
+		names = append(names, placement.Namespace)
+	}
+	if strings.Join(names, ",") != "acme/tools,docs,gdpr,unpinned" {
+		t.Errorf("placements %v", names)
+	}
+
+	// Unpinning hands the namespace back to the config
+	if removed, err := reloaded.Unpin("gdpr"); !removed || err != nil {
+		t.Errorf("Unpin: %v, %v", removed, err)
+	}
+	if removed, err := reloaded.Unpin("gdpr"); removed || err != nil {
+		t.Errorf("unpinning twice: %v, %v", removed, err)
+	}
+	if placement := reloaded.Place("gdpr"); placement.Region != "eu" || placement.Source != SourceConfig {
+		t.Errorf("unpinned placement %+v", placement)
+	}
+
+	if err := os.WriteFile(stateFile, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(testConfig(stateFile), inUse); err == nil {
+		t.Error("a corrupt state file was loaded")
+	}
+}
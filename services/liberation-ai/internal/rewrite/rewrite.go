@@ -0,0 +1,322 @@
+package rewrite
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"liberation-ai/pkg/types"
+)
+
+// Config controls query spell-correction and synonym expansion
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// SpellCheck corrects query words that are not in the namespace's vocabulary
+	SpellCheck bool `yaml:"spell_check" json:"spell_check"`
+	// MinWordLength leaves shorter words alone; they have too many close neighbours
+	MinWordLength int `yaml:"min_word_length" json:"min_word_length"`
+	// MaxEdits is the largest edit distance corrected, for words of eight letters or more.
+	// Shorter words are corrected by at most one edit.
+	MaxEdits int `yaml:"max_edits" json:"max_edits"`
+	// MinFrequency is how often a word must occur in a namespace to be a correction
+	MinFrequency int `yaml:"min_frequency" json:"min_frequency"`
+	// MaxVocabulary caps the distinct words remembered per namespace
+	MaxVocabulary int `yaml:"max_vocabulary" json:"max_vocabulary"`
+	// Synonyms seeds each namespace's synonym groups; every term in a group expands to the others
+	Synonyms map[string][][]string `yaml:"synonyms" json:"-"`
+}
+
+// DefaultConfig leaves rewriting off; once enabled, typos are corrected conservatively
+func DefaultConfig() Config {
+	return Config{
+		SpellCheck:    true,
+		MinWordLength: 4,
+		MaxEdits:      2,
+		MinFrequency:  2,
+		MaxVocabulary: 50000,
+	}
+}
+
+// Rewriter learns each namespace's vocabulary from ingested documents and rewrites queries
+// against it before they are embedded
+type Rewriter struct {
+	mu       sync.RWMutex
+	config   Config
+	vocab    map[string]map[string]int
+	synonyms map[string][][]string
+}
+
+// NewRewriter creates a rewriter, seeding the synonym groups listed in the config
+func NewRewriter(config Config) *Rewriter {
+	defaults := DefaultConfig()
+	if config.MinWordLength <= 0 {
+		config.MinWordLength = defaults.MinWordLength
+	}
+	if config.MaxEdits <= 0 {
+		config.MaxEdits = defaults.MaxEdits
+	}
+	if config.MaxVocabulary <= 0 {
+		config.MaxVocabulary = defaults.MaxVocabulary
+	}
+
+	synonyms := make(map[string][][]string, len(config.Synonyms))
+	for namespace, groups := range config.Synonyms {
+		if normalized, err := normalizeGroups(groups); err == nil {
+			synonyms[namespace] = normalized
+		}
+	}
+
+	return &Rewriter{
+		config:   config,
+		vocab:    make(map[string]map[string]int),
+		synonyms: synonyms,
+	}
+}
+
+// Enabled reports whether queries are rewritten
+func (r *Rewriter) Enabled() bool {
+	return r.config.Enabled
+}
+
+// Observe adds the words of ingested texts to a namespace's vocabulary
+func (r *Rewriter) Observe(namespace string, texts ...string) {
+	if !r.config.Enabled || !r.config.SpellCheck {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	vocab := r.vocab[namespace]
+	if vocab == nil {
+		vocab = make(map[string]int)
+		r.vocab[namespace] = vocab
+	}
+	for _, text := range texts {
+		for _, word := range words(text) {
+			// Once full, only words already known keep counting
+			if _, known := vocab[word]; known || len(vocab) < r.config.MaxVocabulary {
+				vocab[word]++
+			}
+		}
+	}
+}
+
+// CopyVocabulary gives a cloned namespace its source's vocabulary, since its documents are
+// copied without passing through ingestion
+func (r *Rewriter) CopyVocabulary(source, target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if vocab := r.vocab[source]; vocab != nil {
+		copied := make(map[string]int, len(vocab))
+		for word, count := range vocab {
+			copied[word] = count
+		}
+		r.vocab[target] = copied
+	}
+}
+
+// Rewrite corrects and expands a query for a namespace. The result always carries the text
+// to embed in Expanded, which is the original query when nothing changed.
+func (r *Rewriter) Rewrite(namespace, query string) types.QueryRewrite {
+	result := types.QueryRewrite{Original: query, Corrected: query, Expanded: query}
+	if !r.config.Enabled {
+		return result
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tokens := words(query)
+	groups := r.synonyms[namespace]
+	if r.config.SpellCheck {
+		known := make(map[string]bool)
+		for _, group := range groups {
+			for _, term := range group {
+				for _, word := range words(term) {
+					known[word] = true
+				}
+			}
+		}
+
+		vocab := r.vocab[namespace]
+		for i, token := range tokens {
+			if known[token] {
+				continue
+			}
+			if correction := r.correct(vocab, token); correction != "" {
+				if result.Corrections == nil {
+					result.Corrections = make(map[string]string)
+				}
+				result.Corrections[token] = correction
+				tokens[i] = correction
+			}
+		}
+		if len(result.Corrections) > 0 {
+			result.Corrected = strings.Join(tokens, " ")
+			result.Expanded = result.Corrected
+		}
+	}
+
+	var additions []string
+	present := " " + strings.Join(tokens, " ") + " "
+	for _, group := range groups {
+		var matched string
+		for _, term := range group {
+			if strings.Contains(present, " "+term+" ") {
+				matched = term
+				break
+			}
+		}
+		if matched == "" {
+			continue
+		}
+		for _, term := range group {
+			if !strings.Contains(present, " "+term+" ") {
+				if result.Synonyms == nil {
+					result.Synonyms = make(map[string][]string)
+				}
+				result.Synonyms[matched] = append(result.Synonyms[matched], term)
+				additions = append(additions, term)
+				present += term + " "
+			}
+		}
+	}
+	if len(additions) > 0 {
+		result.Expanded = result.Corrected + " " + strings.Join(additions, " ")
+	}
+	return result
+}
+
+// correct returns the closest frequent vocabulary word to token, or "" when the token is
+// known, too short, or has no close match
+func (r *Rewriter) correct(vocab map[string]int, token string) string {
+	length := len([]rune(token))
+	if length < r.config.MinWordLength || vocab[token] > 0 || strings.IndexFunc(token, unicode.IsDigit) >= 0 {
+		return ""
+	}
+	maxEdits := 1
+	if length >= 8 {
+		maxEdits = r.config.MaxEdits
+	}
+
+	best, bestDistance, bestCount := "", maxEdits+1, 0
+	for word, count := range vocab {
+		if count < r.config.MinFrequency {
+			continue
+		}
+		distance := editDistance(token, word, maxEdits)
+		if distance < bestDistance || (distance == bestDistance && (count > bestCount || (count == bestCount && word < best))) {
+			best, bestDistance, bestCount = word, distance, count
+		}
+	}
+	if bestDistance > maxEdits {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the optimal string alignment distance between a and b: insertions,
+// deletions, substitutions and adjacent transpositions. It returns limit+1 once the
+// distance is known to exceed limit.
+func editDistance(a, b string, limit int) int {
+	s, t := []rune(a), []rune(b)
+	if diff := len(s) - len(t); diff > limit || -diff > limit {
+		return limit + 1
+	}
+
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	curr := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(t)]
+}
+
+// Synonyms returns a namespace's synonym groups and how many words its vocabulary holds
+func (r *Rewriter) Synonyms(namespace string) ([][]string, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	groups := r.synonyms[namespace]
+	if groups == nil {
+		groups = [][]string{}
+	}
+	return groups, len(r.vocab[namespace])
+}
+
+// SetSynonyms replaces a namespace's synonym groups
+func (r *Rewriter) SetSynonyms(namespace string, groups [][]string) ([][]string, error) {
+	normalized, err := normalizeGroups(groups)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.synonyms[namespace] = normalized
+	return normalized, nil
+}
+
+// DeleteSynonyms removes a namespace's synonym groups, reporting whether it had any
+func (r *Rewriter) DeleteSynonyms(namespace string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.synonyms[namespace]
+	delete(r.synonyms, namespace)
+	return exists
+}
+
+// normalizeGroups lowercases terms, collapses their whitespace and drops duplicates
+func normalizeGroups(groups [][]string) ([][]string, error) {
+	normalized := make([][]string, 0, len(groups))
+	for i, group := range groups {
+		seen := make(map[string]bool, len(group))
+		terms := make([]string, 0, len(group))
+		for _, term := range group {
+			term = strings.Join(words(term), " ")
+			if term != "" && !seen[term] {
+				seen[term] = true
+				terms = append(terms, term)
+			}
+		}
+		if len(terms) < 2 {
+			return nil, fmt.Errorf("synonym group %d needs at least two distinct terms", i+1)
+		}
+		sort.Strings(terms)
+		normalized = append(normalized, terms)
+	}
+	return normalized, nil
+}
+
+// words splits text into lowercase words of letters and digits
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,163 @@
+package rewrite
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func testRewriter(synonyms map[string][][]string) *Rewriter {
+	config := DefaultConfig()
+	config.Enabled = true
+	config.Synonyms = synonyms
+	r := NewRewriter(config)
+	for i := 0; i < 2; i++ {
+		r.Observe("garden", "The garden ladder, seedling trays and bikes.", "Compost: 3 bins")
+	}
+	r.Observe("garden", "gardener")
+	return r
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"ladder", "ladder", 2, 0},
+		{"ladr", "ladder", 2, 2},
+		{"seedlign", "seedling", 2, 1},
+		{"garden", "gardn", 2, 1},
+		{"kitten", "sitting", 3, 3},
+		{"kitten", "sitting", 2, 3},
+		{"a", "abcd", 2, 3},
+		{"größe", "grösse", 2, 2},
+		{"", "ab", 2, 2},
+	} {
+		if got := editDistance(tc.a, tc.b, tc.limit); got != tc.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tc.a, tc.b, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestSpellCheck(t *testing.T) {
+	r := testRewriter(nil)
+	for _, tc := range []struct {
+		query, corrected string
+		corrections      map[string]string
+	}{
+		// Short words allow one edit, words of eight letters or more two
+		{"Gardn LADR seedlign", "garden ladr seedling", map[string]string{"gardn": "garden", "seedlign": "seedling"}},
+		{"seedlnig", "seedling", map[string]string{"seedlnig": "seedling"}},
+		{"garden ladder", "garden ladder", nil},
+		// Too short, digits, or only seen once
+		{"teh", "teh", nil},
+		{"bin3", "bin3", nil},
+		{"gardenerr", "gardenerr", nil},
+	} {
+		got := r.Rewrite("garden", tc.query)
+		if got.Original != tc.query || got.Corrected != tc.corrected || got.Expanded != tc.corrected || fmt.Sprint(got.Corrections) != fmt.Sprint(tc.corrections) {
+			t.Errorf("Rewrite(%q) = %+v", tc.query, got)
+		}
+		if tc.corrections == nil && got.Corrected != tc.query {
+			t.Errorf("Rewrite(%q) changed the query to %q", tc.query, got.Corrected)
+		}
+	}
+
+	// Other namespaces learn nothing from this one
+	if got := r.Rewrite("kitchen", "gardn"); got.Corrections != nil {
+		t.Errorf("corrected in another namespace: %+v", got)
+	}
+}
+
+func TestSynonyms(t *testing.T) {
+	r := testRewriter(map[string][][]string{
+		"garden": {{"Bike", "bicycle", "bike"}, {"tool library", "lending  shed"}},
+		"broken": {{"only"}},
+	})
+
+	got := r.Rewrite("garden", "bike from the tool library")
+	if got.Corrections != nil || got.Expanded != "bike from the tool library bicycle lending shed" {
+		t.Errorf("expanded to %+v", got)
+	}
+	if !slices.Equal(got.Synonyms["bike"], []string{"bicycle"}) || !slices.Equal(got.Synonyms["tool library"], []string{"lending shed"}) {
+		t.Errorf("synonyms %v", got.Synonyms)
+	}
+	// Terms already in the query are not added again, and corrections are expanded too
+	if got := r.Rewrite("garden", "bicycle bike gardn"); got.Expanded != "bicycle bike garden" || got.Synonyms != nil {
+		t.Errorf("rewrote to %+v", got)
+	}
+
+	groups, words := r.Synonyms("garden")
+	if fmt.Sprint(groups) != "[[bicycle bike] [lending shed tool library]]" || words != 11 {
+		t.Errorf("garden synonyms %v with %d words", groups, words)
+	}
+	if groups, _ := r.Synonyms("broken"); groups == nil || len(groups) != 0 {
+		t.Errorf("an invalid seeded group was kept: %v", groups)
+	}
+
+	if _, err := r.SetSynonyms("garden", [][]string{{"hoe", " HOE "}}); err == nil {
+		t.Error("a group of one distinct term was accepted")
+	}
+	if groups, err := r.SetSynonyms("garden", [][]string{{"Hose", "pipe"}}); err != nil || fmt.Sprint(groups) != "[[hose pipe]]" {
+		t.Errorf("SetSynonyms: %v, %v", groups, err)
+	}
+	if got := r.Rewrite("garden", "bike"); got.Synonyms != nil {
+		t.Errorf("replaced groups still expand: %+v", got)
+	}
+	if !r.DeleteSynonyms("garden") || r.DeleteSynonyms("garden") {
+		t.Error("DeleteSynonyms reported the wrong namespaces")
+	}
+}
+
+func TestSynonymsAreNotCorrected(t *testing.T) {
+	// "bike" is one edit from the frequent "bikes", but a synonym term is a known word
+	if got := testRewriter(nil).Rewrite("garden", "bike"); got.Corrected != "bikes" {
+		t.Errorf("without synonyms: %+v", got)
+	}
+	if got := testRewriter(map[string][][]string{"garden": {{"bike", "cycle"}}}).Rewrite("garden", "bike"); got.Corrections != nil {
+		t.Errorf("with synonyms: %+v", got)
+	}
+}
+
+func TestVocabulary(t *testing.T) {
+	config := DefaultConfig()
+	config.Enabled, config.MaxVocabulary = true, 2
+	r := NewRewriter(config)
+	r.Observe("garden", "ladder hose ladder hose", "rake ladder rake")
+	if _, words := r.Synonyms("garden"); words != 2 {
+		t.Errorf("vocabulary of %d words, capped at 2", words)
+	}
+	if got := r.Rewrite("garden", "laddr rakr"); got.Corrected != "ladder rakr" {
+		t.Errorf("known words stopped counting: %+v", got)
+	}
+
+	r.CopyVocabulary("garden", "copy")
+	r.CopyVocabulary("missing", "other")
+	if got := r.Rewrite("copy", "hosr"); got.Corrected != "hose" {
+		t.Errorf("copied vocabulary corrected %+v", got)
+	}
+	r.vocab["copy"]["hose"] = 0
+	if got := r.Rewrite("garden", "hosr"); got.Corrected != "hose" {
+		t.Errorf("changing the copy changed its source: %+v", got)
+	}
+	if _, words := r.Synonyms("other"); words != 0 {
+		t.Errorf("copied %d words from nothing", words)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	r := NewRewriter(DefaultConfig())
+	r.Observe("garden", "ladder ladder")
+	if got := r.Rewrite("garden", "laddr"); got.Expanded != "laddr" || got.Corrections != nil || r.Enabled() {
+		t.Errorf("disabled rewriter rewrote %+v", got)
+	}
+
+	config := DefaultConfig()
+	config.Enabled, config.SpellCheck = true, false
+	r = NewRewriter(config)
+	r.Observe("garden", "ladder ladder")
+	if got := r.Rewrite("garden", "laddr"); got.Corrections != nil {
+		t.Errorf("spell check off corrected %+v", got)
+	}
+}
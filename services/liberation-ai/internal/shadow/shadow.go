@@ -0,0 +1,282 @@
+package shadow
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"liberation-ai/pkg/types"
+)
+
+// Subsystems that can run in shadow mode
+const (
+	// SubsystemRelevance computes feedback-boosted rankings but serves unboosted results
+	SubsystemRelevance = "relevance"
+	// SubsystemDiversify computes MMR-diversified rankings for searches that did not ask for them
+	SubsystemDiversify = "diversify"
+)
+
+// bucketRetention bounds the hourly history kept for dashboards
+const bucketRetention = 7 * 24 * time.Hour
+
+// Config controls which ranking subsystems are evaluated in shadow mode
+type Config struct {
+	// Subsystems maps a subsystem name to whether it runs in shadow mode
+	Subsystems map[string]bool `yaml:"subsystems" json:"subsystems"`
+	// SampleRate is the fraction of searches that also compute shadow rankings
+	SampleRate float64 `yaml:"sample_rate" json:"sample_rate"`
+	// TopK is the depth at which live and shadow rankings are compared
+	TopK int `yaml:"top_k" json:"top_k"`
+	// LogDisagreements logs every search whose shadow top result differs from the live one
+	LogDisagreements bool `yaml:"log_disagreements" json:"log_disagreements"`
+}
+
+// DefaultConfig leaves every subsystem live
+func DefaultConfig() Config {
+	return Config{SampleRate: 1, TopK: 10}
+}
+
+// Counts accumulates comparisons between live and shadow rankings
+type Counts struct {
+	Comparisons int `json:"comparisons"`
+	// TopMatches counts comparisons where both rankings put the same document first
+	TopMatches int `json:"top_matches"`
+	// ExactMatches counts comparisons with identical top-k order
+	ExactMatches int `json:"exact_matches"`
+	// OverlapSum adds up the share of the live top-k also in the shadow top-k
+	OverlapSum float64 `json:"-"`
+}
+
+func (c *Counts) add(other Counts) {
+	c.Comparisons += other.Comparisons
+	c.TopMatches += other.TopMatches
+	c.ExactMatches += other.ExactMatches
+	c.OverlapSum += other.OverlapSum
+}
+
+// Agreement is a set of counts expressed as rates, for dashboards
+type Agreement struct {
+	Comparisons  int     `json:"comparisons"`
+	TopAgreement float64 `json:"top_agreement"`
+	ExactOrder   float64 `json:"exact_order"`
+	MeanOverlap  float64 `json:"mean_overlap"`
+}
+
+func (c Counts) agreement() Agreement {
+	if c.Comparisons == 0 {
+		return Agreement{}
+	}
+	n := float64(c.Comparisons)
+	return Agreement{
+		Comparisons:  c.Comparisons,
+		TopAgreement: float64(c.TopMatches) / n,
+		ExactOrder:   float64(c.ExactMatches) / n,
+		MeanOverlap:  c.OverlapSum / n,
+	}
+}
+
+// Compare scores one shadow ranking against the live ranking, looking at the top k of each
+func Compare(live, shadow []string, k int) Counts {
+	if k <= 0 {
+		k = len(live)
+	}
+	live, shadow = head(live, k), head(shadow, k)
+
+	counts := Counts{Comparisons: 1}
+	if len(live) == 0 && len(shadow) == 0 {
+		counts.TopMatches, counts.ExactMatches, counts.OverlapSum = 1, 1, 1
+		return counts
+	}
+	if len(live) > 0 && len(shadow) > 0 && live[0] == shadow[0] {
+		counts.TopMatches = 1
+	}
+
+	exact := len(live) == len(shadow)
+	inShadow := make(map[string]bool, len(shadow))
+	for i, id := range shadow {
+		inShadow[id] = true
+		if exact && live[i] != id {
+			exact = false
+		}
+	}
+	if exact {
+		counts.ExactMatches = 1
+	}
+
+	shared := 0
+	for _, id := range live {
+		if inShadow[id] {
+			shared++
+		}
+	}
+	if size := max(len(live), len(shadow)); size > 0 {
+		counts.OverlapSum = float64(shared) / float64(size)
+	}
+	return counts
+}
+
+// ResultIDs lists the vector IDs of search results in ranked order
+func ResultIDs(results []types.SearchResult) []string {
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.Vector.ID
+	}
+	return ids
+}
+
+func head(ids []string, k int) []string {
+	if len(ids) > k {
+		return ids[:k]
+	}
+	return ids
+}
+
+type seriesKey struct {
+	subsystem string
+	namespace string
+}
+
+// Evaluator decides which searches run shadow rankings and keeps the agreement statistics.
+// Shadow results are never served.
+type Evaluator struct {
+	mu      sync.Mutex
+	config  Config
+	toggles map[string]bool
+	series  map[seriesKey]map[time.Time]*Counts
+	random  func() float64
+	now     func() time.Time
+}
+
+// NewEvaluator creates an evaluator; subsystems can also be toggled at runtime
+func NewEvaluator(config Config) *Evaluator {
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		config.SampleRate = 1
+	}
+	if config.TopK <= 0 {
+		config.TopK = DefaultConfig().TopK
+	}
+	toggles := make(map[string]bool, len(config.Subsystems))
+	for subsystem, enabled := range config.Subsystems {
+		toggles[subsystem] = enabled
+	}
+	return &Evaluator{
+		config:  config,
+		toggles: toggles,
+		series:  make(map[seriesKey]map[time.Time]*Counts),
+		random:  rand.Float64,
+		now:     time.Now,
+	}
+}
+
+// Enabled reports whether a subsystem runs in shadow mode
+func (e *Evaluator) Enabled(subsystem string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.toggles[subsystem]
+}
+
+// SetEnabled moves a subsystem in or out of shadow mode
+func (e *Evaluator) SetEnabled(subsystem string, enabled bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.toggles[subsystem] = enabled
+}
+
+// Sample reports whether this search should compute shadow rankings
+func (e *Evaluator) Sample() bool {
+	return e.random() < e.config.SampleRate
+}
+
+// Record compares a shadow ranking with the live ranking that was served
+func (e *Evaluator) Record(subsystem, namespace, query string, live, shadow []string) Counts {
+	counts := Compare(live, shadow, e.config.TopK)
+	if e.config.LogDisagreements && counts.TopMatches == 0 {
+		log.Printf("shadow %s disagrees in %s for %q: live %v, shadow %v", subsystem, namespace, query, head(live, 3), head(shadow, 3))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	key := seriesKey{subsystem: subsystem, namespace: namespace}
+	buckets := e.series[key]
+	if buckets == nil {
+		buckets = make(map[time.Time]*Counts)
+		e.series[key] = buckets
+	}
+	hour := now.Truncate(time.Hour)
+	if buckets[hour] == nil {
+		buckets[hour] = &Counts{}
+		for bucket := range buckets {
+			if now.Sub(bucket) > bucketRetention {
+				delete(buckets, bucket)
+			}
+		}
+	}
+	buckets[hour].add(counts)
+	return counts
+}
+
+// Point is one hour of agreement data
+type Point struct {
+	Hour time.Time `json:"hour"`
+	Agreement
+}
+
+// Series is the agreement history for one subsystem in one namespace
+type Series struct {
+	Subsystem string    `json:"subsystem"`
+	Namespace string    `json:"namespace"`
+	Shadowed  bool      `json:"shadowed"`
+	Total     Agreement `json:"total"`
+	Hourly    []Point   `json:"hourly"`
+}
+
+// Report summarises agreement with the live path, optionally for one subsystem or namespace
+func (e *Evaluator) Report(subsystem, namespace string) []Series {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	report := []Series{}
+	for key, buckets := range e.series {
+		if (subsystem != "" && key.subsystem != subsystem) || (namespace != "" && key.namespace != namespace) {
+			continue
+		}
+
+		series := Series{Subsystem: key.subsystem, Namespace: key.namespace, Shadowed: e.toggles[key.subsystem], Hourly: []Point{}}
+		var total Counts
+		for hour, counts := range buckets {
+			total.add(*counts)
+			series.Hourly = append(series.Hourly, Point{Hour: hour, Agreement: counts.agreement()})
+		}
+		sort.Slice(series.Hourly, func(i, j int) bool {
+			return series.Hourly[i].Hour.Before(series.Hourly[j].Hour)
+		})
+		series.Total = total.agreement()
+		report = append(report, series)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Subsystem != report[j].Subsystem {
+			return report[i].Subsystem < report[j].Subsystem
+		}
+		return report[i].Namespace < report[j].Namespace
+	})
+	return report
+}
+
+// Reset forgets collected comparisons for a subsystem, or for every subsystem when empty
+func (e *Evaluator) Reset(subsystem string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for key := range e.series {
+		if subsystem == "" || key.subsystem == subsystem {
+			delete(e.series, key)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package shadow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		live, shadow []string
+		k            int
+		want         Counts
+	}{
+		{"identical", []string{"a", "b", "c"}, []string{"a", "b", "c"}, 3, Counts{1, 1, 1, 1}},
+		{"both empty", nil, nil, 3, Counts{1, 1, 1, 1}},
+		{"same top, swapped tail", []string{"a", "b", "c"}, []string{"a", "c", "b"}, 3, Counts{1, 1, 0, 1}},
+		{"new top", []string{"a", "b"}, []string{"c", "a"}, 2, Counts{1, 0, 0, 0.5}},
+		{"disjoint", []string{"a"}, []string{"b"}, 3, Counts{1, 0, 0, 0}},
+		{"shadow is shorter", []string{"a", "b", "c", "d"}, []string{"a", "b"}, 10, Counts{1, 1, 0, 0.5}},
+		{"only the top k count", []string{"a", "b", "x"}, []string{"a", "b", "y"}, 2, Counts{1, 1, 1, 1}},
+		{"k 0 compares the whole live ranking", []string{"a", "b", "x"}, []string{"a", "b", "y"}, 0, Counts{1, 1, 0, 2.0 / 3}},
+		{"nothing live", nil, []string{"a"}, 3, Counts{1, 0, 0, 0}},
+	} {
+		if got := Compare(tc.live, tc.shadow, tc.k); got != tc.want {
+			t.Errorf("%s: %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+	e := NewEvaluator(Config{SampleRate: 0.25})
+	for draw, want := range map[float64]bool{0: true, 0.2: true, 0.25: false, 0.9: false} {
+		e.random = func() float64 { return draw }
+		if got := e.Sample(); got != want {
+			t.Errorf("draw %v sampled %v, want %v", draw, got, want)
+		}
+	}
+
+	// Rates outside (0, 1] sample every search
+	for _, rate := range []float64{0, -1, 2} {
+		if e := NewEvaluator(Config{SampleRate: rate}); e.config.SampleRate != 1 || e.config.TopK != 10 {
+			t.Errorf("rate %v configured as %+v", rate, e.config)
+		}
+	}
+}
+
+func TestToggles(t *testing.T) {
+	e := NewEvaluator(Config{Subsystems: map[string]bool{SubsystemRelevance: true}})
+	if !e.Enabled(SubsystemRelevance) || e.Enabled(SubsystemDiversify) {
+		t.Errorf("relevance %v, diversify %v", e.Enabled(SubsystemRelevance), e.Enabled(SubsystemDiversify))
+	}
+	e.SetEnabled(SubsystemRelevance, false)
+	e.SetEnabled(SubsystemDiversify, true)
+	if e.Enabled(SubsystemRelevance) || !e.Enabled(SubsystemDiversify) {
+		t.Error("toggles not applied")
+	}
+}
+
+func TestReport(t *testing.T) {
+	e := NewEvaluator(Config{TopK: 2, Subsystems: map[string]bool{SubsystemRelevance: true}})
+	now := time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	e.Record(SubsystemRelevance, "docs", "ladder", []string{"a", "b"}, []string{"a", "b"})
+	e.Record(SubsystemRelevance, "docs", "drill", []string{"a", "b"}, []string{"b", "a"})
+	now = now.Add(time.Hour)
+	e.Record(SubsystemRelevance, "docs", "ladder", []string{"a", "b"}, []string{"c", "d"})
+	e.Record(SubsystemRelevance, "wiki", "seeds", []string{"a"}, []string{"a"})
+	e.Record(SubsystemDiversify, "docs", "ladder", []string{"a"}, []string{"a"})
+
+	report := e.Report(SubsystemRelevance, "docs")
+	if len(report) != 1 {
+		t.Fatalf("report %+v", report)
+	}
+	docs := report[0]
+	if !docs.Shadowed || docs.Total.Comparisons != 3 || docs.Total.TopAgreement != 1.0/3 || docs.Total.MeanOverlap != 2.0/3 {
+		t.Errorf("docs totals %+v", docs)
+	}
+	if len(docs.Hourly) != 2 || !docs.Hourly[0].Hour.Equal(time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)) ||
+		docs.Hourly[0].Comparisons != 2 || docs.Hourly[0].ExactOrder != 0.5 || docs.Hourly[1].TopAgreement != 0 {
+		t.Errorf("docs hourly %+v", docs.Hourly)
+	}
+
+	all := e.Report("", "")
+	var order []string
+	for _, series := range all {
+		order = append(order, series.Subsystem+"/"+series.Namespace)
+	}
+	if len(order) != 3 || order[0] != "diversify/docs" || order[1] != "relevance/docs" || order[2] != "relevance/wiki" || all[0].Shadowed {
+		t.Errorf("full report %v", order)
+	}
+
+	// Hours older than a week are dropped when a new hour starts
+	now = now.Add(8 * 24 * time.Hour)
+	e.Record(SubsystemRelevance, "docs", "ladder", []string{"a"}, []string{"a"})
+	if hourly := e.Report(SubsystemRelevance, "docs")[0].Hourly; len(hourly) != 1 {
+		t.Errorf("%d hours kept after a week", len(hourly))
+	}
+
+	e.Reset(SubsystemRelevance)
+	if report := e.Report("", ""); len(report) != 1 || report[0].Subsystem != SubsystemDiversify {
+		t.Errorf("after resetting relevance: %+v", report)
+	}
+	e.Reset("")
+	if report := e.Report("", ""); report == nil || len(report) != 0 {
+		t.Errorf("after resetting everything: %+v", report)
+	}
+}
@@ -0,0 +1,102 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReserveIngest reports a write of vectors, embedding embeddings texts, to the namespace's
+// tenant. It writes a 403 response and returns false when the tenant is unknown or has run
+// out of grace on an exceeded limit. Within the grace period the write goes ahead with an
+// X-Quota-Warning header. Namespaces outside any tenant are not metered.
+func (m *Meter) ReserveIngest(c *gin.Context, namespace string, vectors, embeddings int64) bool {
+	warning, err := m.CheckIngest(c.Request.Context(), namespace, vectors, embeddings)
+	var quota *QuotaError
+	switch {
+	case errors.Is(err, ErrUnknownTenant):
+		tenant, _ := m.Tenant(namespace)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "unknown_tenant",
+			"message": "namespace " + namespace + " belongs to tenant " + tenant + ", which does not exist",
+		})
+		c.Abort()
+		return false
+	case errors.As(err, &quota):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "quota_exceeded",
+			"message": quota.Error(),
+			"quota":   quota.Decision,
+		})
+		c.Abort()
+		return false
+	case err != nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "quota_unavailable",
+			"message": "tenant quotas cannot be checked right now; try again shortly",
+		})
+		c.Abort()
+		return false
+	}
+	if warning != "" {
+		c.Header("X-Quota-Warning", warning)
+	}
+	return true
+}
+
+// CheckIngest is ReserveIngest for writes made outside a request, such as the batches of a
+// streamed upload. It returns ErrUnknownTenant, a *QuotaError or ErrQuotaUnavailable when
+// the write is refused, and a warning while an exceeded limit is within its grace period.
+func (m *Meter) CheckIngest(ctx context.Context, namespace string, vectors, embeddings int64) (string, error) {
+	tenant, ok := m.Tenant(namespace)
+	if !ok {
+		return "", nil
+	}
+
+	current, err := m.TenantVectors(ctx, tenant)
+	if err != nil {
+		return "", m.unavailable(tenant, err)
+	}
+	type usage struct {
+		metric  string
+		amount  int64
+		current *int64
+	}
+	reports := []usage{{MetricVectors, vectors, &current}}
+	if embeddings > 0 {
+		reports = append(reports, usage{MetricEmbeddings, embeddings, nil})
+	}
+
+	var warnings []string
+	for _, report := range reports {
+		decision, err := m.Report(ctx, tenant, report.metric, report.amount, report.current)
+		if errors.Is(err, ErrUnknownTenant) {
+			return "", err
+		}
+		if err != nil {
+			return "", m.unavailable(tenant, err)
+		}
+		if !decision.Allowed {
+			return "", &QuotaError{Tenant: tenant, Decision: decision}
+		}
+		if decision.OverLimit && decision.GraceEndsAt != nil {
+			warnings = append(warnings, fmt.Sprintf("%s over limit of %d; refused after %s", decision.Metric, decision.Limit, decision.GraceEndsAt.Format(time.RFC3339)))
+		}
+	}
+	return strings.Join(warnings, "; "), nil
+}
+
+// unavailable lets the write through when fail_open is set, and otherwise refuses it
+func (m *Meter) unavailable(tenant string, err error) error {
+	log.Printf("Tenant metering of %s failed: %v", tenant, err)
+	if m.config.FailOpen {
+		return nil
+	}
+	return ErrQuotaUnavailable
+}
@@ -0,0 +1,126 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckIngest(t *testing.T) {
+	graceEnds := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	auth := &authServer{answer: func(report usage) (int, Decision) {
+		switch {
+		case strings.Contains(report.Path, "/ghost/"):
+			return http.StatusNotFound, Decision{}
+		case strings.Contains(report.Path, "/full/"):
+			return http.StatusOK, Decision{Metric: report.Metric, Limit: 5, Used: 5, OverLimit: true}
+		case report.Metric == MetricEmbeddings:
+			return http.StatusOK, Decision{Metric: report.Metric, Limit: 100, Used: 120, Allowed: true, OverLimit: true, GraceEndsAt: &graceEnds}
+		}
+		return allow(report)
+	}}
+	m := testMeter(t, auth, counts{"coop/tools": 3, "coop/seeds": 4})
+	ctx := context.Background()
+
+	// Namespaces outside any tenant are not reported
+	if warning, err := m.CheckIngest(ctx, "tools", 2, 2); warning != "" || err != nil || len(auth.received()) != 0 {
+		t.Errorf("untenanted namespace: %q, %v", warning, err)
+	}
+
+	// Vectors go with the tenant's current total; embeddings only when there are some
+	if warning, err := m.CheckIngest(ctx, "coop/tools", 2, 0); warning != "" || err != nil {
+		t.Errorf("within quota: %q, %v", warning, err)
+	}
+	if reports := auth.received(); len(reports) != 1 || reports[0].Metric != MetricVectors || *reports[0].Current != 7 {
+		t.Errorf("reports %+v", reports)
+	}
+
+	warning, err := m.CheckIngest(ctx, "coop/tools", 2, 2)
+	if err != nil || warning != "embeddings over limit of 100; refused after 2026-04-01T00:00:00Z" {
+		t.Errorf("in grace: %q, %v", warning, err)
+	}
+
+	var quota *QuotaError
+	if _, err := m.CheckIngest(ctx, "full/tools", 1, 1); !errors.As(err, &quota) || quota.Tenant != "full" || quota.Decision.Limit != 5 {
+		t.Errorf("over quota: %v", err)
+	}
+	if _, err := m.CheckIngest(ctx, "ghost/tools", 1, 1); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("unknown tenant: %v", err)
+	}
+}
+
+func TestCheckIngestUnavailable(t *testing.T) {
+	auth := &authServer{answer: func(report usage) (int, Decision) {
+		return http.StatusInternalServerError, Decision{}
+	}}
+	m := testMeter(t, auth, counts{})
+	if _, err := m.CheckIngest(context.Background(), "coop/tools", 1, 0); err != nil {
+		t.Errorf("fail open: %v", err)
+	}
+
+	m.config.FailOpen = false
+	if _, err := m.CheckIngest(context.Background(), "coop/tools", 1, 0); !errors.Is(err, ErrQuotaUnavailable) {
+		t.Errorf("fail closed: %v", err)
+	}
+	unreachable := testMeter(t, &authServer{answer: allow}, counts(nil))
+	unreachable.config.FailOpen = false
+	if _, err := unreachable.CheckIngest(context.Background(), "coop/tools", 1, 0); !errors.Is(err, ErrQuotaUnavailable) {
+		t.Errorf("store unreachable: %v", err)
+	}
+}
+
+func TestReserveIngest(t *testing.T) {
+	down := false
+	auth := &authServer{answer: func(report usage) (int, Decision) {
+		switch {
+		case down:
+			return http.StatusServiceUnavailable, Decision{}
+		case strings.Contains(report.Path, "/ghost/"):
+			return http.StatusNotFound, Decision{}
+		case strings.Contains(report.Path, "/full/"):
+			return http.StatusOK, Decision{Metric: report.Metric, Limit: 5, Used: 5, OverLimit: true}
+		}
+		return allow(report)
+	}}
+	m := testMeter(t, auth, counts{})
+	m.config.FailOpen = false
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/vectors", func(c *gin.Context) {
+		if !m.ReserveIngest(c, c.Query("namespace"), 1, 0) {
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	store := func(namespace string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/vectors?namespace="+namespace, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for namespace, want := range map[string]struct {
+		code  int
+		error string
+	}{
+		"coop/tools":  {http.StatusOK, ""},
+		"ghost/tools": {http.StatusForbidden, "unknown_tenant"},
+		"full/tools":  {http.StatusForbidden, "quota_exceeded"},
+	} {
+		if w := store(namespace); w.Code != want.code || !strings.Contains(w.Body.String(), want.error) {
+			t.Errorf("%s: %d %s", namespace, w.Code, w.Body)
+		}
+	}
+
+	down = true
+	if w := store("coop/tools"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "quota_unavailable") {
+		t.Errorf("liberation-auth down: %d %s", w.Code, w.Body)
+	}
+}
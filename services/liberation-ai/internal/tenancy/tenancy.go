@@ -0,0 +1,193 @@
+// Package tenancy meters the namespaces of hosted tenants against their plans.
+//
+// In a hosted deployment each tenant's namespaces share a prefix: with the default
+// separator, acme/kb and acme/support belong to tenant acme. liberation-auth keeps the
+// tenants, their plans and their usage, so billing reads one place. Before each write the
+// meter reports the tenant's vectors and embeddings to liberation-auth, which decides
+// whether the plan allows them and records the usage when it does.
+package tenancy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"liberation-ai/pkg/types"
+
+	"liberation-serviceauth"
+)
+
+// Metrics reported to liberation-auth
+const (
+	MetricVectors    = "vectors"
+	MetricEmbeddings = "embeddings"
+)
+
+// ErrUnknownTenant is returned for a namespace prefix liberation-auth has no tenant for
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// ErrQuotaUnavailable is returned when quotas cannot be checked and fail_open is off
+var ErrQuotaUnavailable = errors.New("tenant quotas cannot be checked right now")
+
+// QuotaError is returned for a write that would take a tenant past a limit
+type QuotaError struct {
+	Tenant   string
+	Decision Decision
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant %s has reached its limit of %d %s", e.Tenant, e.Decision.Limit, e.Decision.Metric)
+}
+
+// Config controls tenant metering
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// AuthURL is liberation-auth's base URL, e.g. http://liberation-auth:8081
+	AuthURL string `yaml:"auth_url" json:"auth_url"`
+	// Separator splits a namespace into its tenant and the rest. Namespaces without it are
+	// not metered.
+	Separator string `yaml:"separator" json:"separator"`
+	// TimeoutSeconds bounds each report to liberation-auth
+	TimeoutSeconds int `yaml:"timeout_seconds" json:"timeout_seconds"`
+	// FailOpen lets writes through when liberation-auth cannot be reached. Quotas are soft,
+	// so this is the default; turn it off to refuse writes instead.
+	FailOpen bool `yaml:"fail_open" json:"fail_open"`
+}
+
+// DefaultConfig leaves metering off; once enabled, acme/kb belongs to tenant acme and
+// writes go ahead when liberation-auth does not answer within two seconds
+func DefaultConfig() Config {
+	return Config{Separator: "/", TimeoutSeconds: 2, FailOpen: true}
+}
+
+// ApplyEnv reads prefix + AUTH_URL, so the same file works across environments
+func (c *Config) ApplyEnv(prefix string) {
+	if value, ok := os.LookupEnv(prefix + "AUTH_URL"); ok {
+		c.AuthURL = value
+	}
+}
+
+// Validate reports settings that would stop the meter from reaching liberation-auth
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	parsed, err := url.Parse(c.AuthURL)
+	switch {
+	case c.AuthURL == "" || err != nil || parsed.Host == "" || parsed.Scheme != "http" && parsed.Scheme != "https":
+		return fmt.Errorf("auth_url must be liberation-auth's http(s) URL")
+	case c.Separator == "":
+		return fmt.Errorf("separator must not be empty")
+	case c.TimeoutSeconds < 1:
+		return fmt.Errorf("timeout_seconds must be at least 1")
+	}
+	return nil
+}
+
+// Decision is liberation-auth's answer to a usage report
+type Decision struct {
+	Metric string `json:"metric"`
+	// Limit is 0 when the tenant's plan does not cap the metric
+	Limit     int64 `json:"limit"`
+	Used      int64 `json:"used"`
+	Allowed   bool  `json:"allowed"`
+	OverLimit bool  `json:"over_limit"`
+	// GraceEndsAt is when an exceeded limit starts refusing writes
+	GraceEndsAt *time.Time `json:"grace_ends_at,omitempty"`
+}
+
+// Stats counts the vectors of every namespace
+type Stats interface {
+	GetStats(ctx context.Context) (*types.VectorStoreStats, error)
+}
+
+// Meter reports tenant usage to liberation-auth
+type Meter struct {
+	config Config
+	stats  Stats
+	client *http.Client
+}
+
+// NewMeter creates a meter. Reports are signed with the service identity, which
+// liberation-auth must trust; without one they are sent unsigned and refused.
+func NewMeter(config Config, identity *serviceauth.Identity, stats Stats) *Meter {
+	config.AuthURL = strings.TrimSuffix(config.AuthURL, "/")
+	client := &http.Client{Timeout: time.Duration(config.TimeoutSeconds) * time.Second}
+	if identity != nil {
+		client.Transport = identity.Transport("liberation-auth", nil)
+	}
+	return &Meter{config: config, stats: stats, client: client}
+}
+
+// Enabled reports whether writes are metered
+func (m *Meter) Enabled() bool {
+	return m.config.Enabled
+}
+
+// Tenant returns the tenant a namespace belongs to
+func (m *Meter) Tenant(namespace string) (string, bool) {
+	if !m.config.Enabled {
+		return "", false
+	}
+	tenant, _, found := strings.Cut(namespace, m.config.Separator)
+	return tenant, found && tenant != ""
+}
+
+// TenantVectors counts the vectors stored across a tenant's namespaces
+func (m *Meter) TenantVectors(ctx context.Context, tenant string) (int64, error) {
+	stats, err := m.stats.GetStats(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	prefix := tenant + m.config.Separator
+	for namespace, count := range stats.NamespaceStats {
+		if strings.HasPrefix(namespace, prefix) {
+			total += count
+		}
+	}
+	return total, nil
+}
+
+// Report asks liberation-auth whether a tenant's usage of metric may grow by amount, and has
+// it recorded if so. Vectors are reported with current, the tenant's total before the write;
+// embeddings are added to the month's count.
+func (m *Meter) Report(ctx context.Context, tenant, metric string, amount int64, current *int64) (Decision, error) {
+	body, err := json.Marshal(map[string]interface{}{"metric": metric, "amount": amount, "current": current})
+	if err != nil {
+		return Decision{}, err
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/auth/admin/tenants/%s/usage", m.config.AuthURL, url.PathEscape(tenant))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return Decision{}, fmt.Errorf("reporting usage of %s: %w", tenant, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Decision{}, ErrUnknownTenant
+	default:
+		return Decision{}, fmt.Errorf("reporting usage of %s: liberation-auth answered %s", tenant, resp.Status)
+	}
+	var result struct {
+		Quota Decision `json:"quota"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Decision{}, fmt.Errorf("reporting usage of %s: %w", tenant, err)
+	}
+	return result.Quota, nil
+}
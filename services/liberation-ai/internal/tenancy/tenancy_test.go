@@ -0,0 +1,168 @@
+package tenancy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"liberation-ai/pkg/types"
+)
+
+// counts is a Stats holding fixed namespace counts
+type counts map[string]int64
+
+func (c counts) GetStats(ctx context.Context) (*types.VectorStoreStats, error) {
+	if c == nil {
+		return nil, errors.New("store unreachable")
+	}
+	return &types.VectorStoreStats{NamespaceStats: c}, nil
+}
+
+// usage is a usage report received by authServer
+type usage struct {
+	Path    string `json:"-"`
+	Metric  string `json:"metric"`
+	Amount  int64  `json:"amount"`
+	Current *int64 `json:"current"`
+}
+
+// authServer answers usage reports with answer, and keeps the reports it received
+type authServer struct {
+	mu      sync.Mutex
+	reports []usage
+	answer  func(report usage) (int, Decision)
+}
+
+func (a *authServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var report usage
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil || r.Method != http.MethodPost {
+		http.Error(w, "bad report", http.StatusBadRequest)
+		return
+	}
+	report.Path = r.URL.EscapedPath()
+	a.mu.Lock()
+	a.reports = append(a.reports, report)
+	a.mu.Unlock()
+
+	status, decision := a.answer(report)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"quota": decision})
+}
+
+func (a *authServer) received() []usage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]usage(nil), a.reports...)
+}
+
+// testMeter meters against a liberation-auth served by auth
+func testMeter(t *testing.T, auth *authServer, stats Stats) *Meter {
+	t.Helper()
+	server := httptest.NewServer(auth)
+	t.Cleanup(server.Close)
+	config := DefaultConfig()
+	config.Enabled, config.AuthURL = true, server.URL+"/"
+	return NewMeter(config, nil, stats)
+}
+
+func allow(report usage) (int, Decision) {
+	return http.StatusOK, Decision{Metric: report.Metric, Allowed: true}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*Config)
+		valid  bool
+	}{
+		{"disabled", func(c *Config) { c.AuthURL = "" }, true},
+		{"enabled", func(c *Config) { c.Enabled = true }, true},
+		{"no URL", func(c *Config) { c.Enabled, c.AuthURL = true, "" }, false},
+		{"not HTTP", func(c *Config) { c.Enabled, c.AuthURL = true, "ftp://auth" }, false},
+		{"no host", func(c *Config) { c.Enabled, c.AuthURL = true, "http://" }, false},
+		{"no separator", func(c *Config) { c.Enabled, c.Separator = true, "" }, false},
+		{"no timeout", func(c *Config) { c.Enabled, c.TimeoutSeconds = true, 0 }, false},
+	} {
+		config := DefaultConfig()
+		config.AuthURL = "http://liberation-auth:8080"
+		tc.change(&config)
+		if err := config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestTenant(t *testing.T) {
+	m := NewMeter(Config{Enabled: true, Separator: "/", TimeoutSeconds: 1}, nil, nil)
+	for namespace, want := range map[string]string{
+		"coop/tools":       "coop",
+		"coop/tools/drill": "coop",
+		"tools":            "",
+		"/tools":           "",
+	} {
+		if tenant, ok := m.Tenant(namespace); ok != (want != "") || (ok && tenant != want) {
+			t.Errorf("Tenant(%q) = %q, %v", namespace, tenant, ok)
+		}
+	}
+
+	disabled := NewMeter(DefaultConfig(), nil, nil)
+	if tenant, ok := disabled.Tenant("coop/tools"); ok || tenant != "" || disabled.Enabled() {
+		t.Errorf("disabled meter found tenant %q", tenant)
+	}
+}
+
+func TestTenantVectors(t *testing.T) {
+	m := NewMeter(Config{Enabled: true, Separator: "/", TimeoutSeconds: 1}, nil, counts{
+		"coop/tools": 3, "coop/seeds": 4, "cooperative/tools": 10, "coop": 20,
+	})
+	if total, err := m.TenantVectors(context.Background(), "coop"); err != nil || total != 7 {
+		t.Errorf("TenantVectors: %d, %v", total, err)
+	}
+
+	unreachable := NewMeter(Config{Enabled: true, Separator: "/", TimeoutSeconds: 1}, nil, counts(nil))
+	if _, err := unreachable.TenantVectors(context.Background(), "coop"); err == nil {
+		t.Error("a failing store was counted")
+	}
+}
+
+func TestReport(t *testing.T) {
+	auth := &authServer{answer: func(report usage) (int, Decision) {
+		switch {
+		case strings.Contains(report.Path, "ghost"):
+			return http.StatusNotFound, Decision{}
+		case strings.Contains(report.Path, "broken"):
+			return http.StatusBadGateway, Decision{}
+		}
+		return http.StatusOK, Decision{Metric: report.Metric, Limit: 10, Used: 9 + report.Amount, Allowed: true, OverLimit: true}
+	}}
+	m := testMeter(t, auth, nil)
+	ctx := context.Background()
+
+	current := int64(9)
+	decision, err := m.Report(ctx, "tool library", MetricVectors, 2, &current)
+	if err != nil || decision.Used != 11 || !decision.Allowed || !decision.OverLimit {
+		t.Errorf("Report: %+v, %v", decision, err)
+	}
+	if _, err := m.Report(ctx, "tool library", MetricEmbeddings, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	reports := auth.received()
+	if len(reports) != 2 || reports[0].Path != "/api/v1/auth/admin/tenants/tool%20library/usage" {
+		t.Fatalf("reports %+v", reports)
+	}
+	if reports[0].Current == nil || *reports[0].Current != 9 || reports[1].Current != nil || reports[1].Metric != MetricEmbeddings {
+		t.Errorf("reports %+v", reports)
+	}
+
+	if _, err := m.Report(ctx, "ghost", MetricVectors, 1, nil); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("unknown tenant: %v", err)
+	}
+	if _, err := m.Report(ctx, "broken", MetricVectors, 1, nil); err == nil || errors.Is(err, ErrUnknownTenant) || !strings.Contains(err.Error(), "502") {
+		t.Errorf("failing liberation-auth: %v", err)
+	}
+}
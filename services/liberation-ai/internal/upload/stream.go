@@ -0,0 +1,300 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"liberation-ai/pkg/liberation"
+)
+
+// Frame types, in the order an upload writes them
+const (
+	FrameSession  = "session"
+	FrameProgress = "progress"
+	FrameDone     = "done"
+	FrameError    = "error"
+)
+
+// maxFrameErrors bounds the rejected lines listed in one frame; the rest are only counted
+const maxFrameErrors = 100
+
+// LineError is a line rejected for good; the upload goes on without it
+type LineError struct {
+	Line  int64  `json:"line"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// Frame is one line of a streamed upload's response
+type Frame struct {
+	Type string `json:"type"`
+	Status
+	// Received counts the lines read from this request's body, including any skipped
+	// because the session had already committed them
+	Received int64 `json:"received"`
+	// Paused is set while reading waits for room in the embedding queue
+	Paused bool `json:"paused,omitempty"`
+	Queued int  `json:"queued"`
+	// Errors lists the lines rejected since the previous frame
+	Errors []LineError `json:"errors,omitempty"`
+	// Error is why the upload stopped; it can be resumed from Committed
+	Error string `json:"error,omitempty"`
+}
+
+type rejectedError struct{ err error }
+
+func (e *rejectedError) Error() string { return e.err.Error() }
+func (e *rejectedError) Unwrap() error { return e.err }
+
+// Rejected marks an error from Hooks.Store as final for its batch: the batch's documents are
+// counted as failed and the upload goes on. Any other error stops the upload.
+func Rejected(err error) error {
+	return &rejectedError{err: err}
+}
+
+// Hooks are what an upload does with its documents
+type Hooks struct {
+	// Check rejects a single document, e.g. one that fails request validation
+	Check func(doc *liberation.Document) error
+	// Store embeds and stores a batch
+	Store func(ctx context.Context, docs []liberation.Document) error
+}
+
+// batch is a run of lines handed from the reader to the store
+type batch struct {
+	docs []liberation.Document
+	// docLines is the line of each document, for reporting rejected batches
+	docLines []int64
+	lines    int64
+	errors   []LineError
+}
+
+// progress is the state frames are written from, shared by the reader, the store and the ticker
+type progress struct {
+	mu       sync.Mutex
+	manager  *Manager
+	write    func(Frame) error
+	status   Status
+	received int64
+	paused   bool
+	errors   []LineError
+}
+
+func (p *progress) update(fn func()) {
+	p.mu.Lock()
+	fn()
+	p.mu.Unlock()
+}
+
+func (p *progress) reject(errors []LineError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range errors {
+		if len(p.errors) < maxFrameErrors {
+			p.errors = append(p.errors, e)
+		}
+	}
+}
+
+func (p *progress) send(frameType, reason string) (Frame, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	frame := Frame{
+		Type:     frameType,
+		Status:   p.status,
+		Received: p.received,
+		Paused:   p.paused,
+		Queued:   p.manager.Queued(),
+		Errors:   p.errors,
+		Error:    reason,
+	}
+	p.errors = nil
+	return frame, p.write(frame)
+}
+
+// Run reads body, whose first line is line offset of the session's upload, and stores its
+// documents. Lines the session has already committed are skipped. Frames are written as the
+// upload goes; the last one, of type done or error, is also returned. The session is
+// released for resuming when Run returns.
+func (m *Manager) Run(ctx context.Context, s *Session, body io.Reader, offset int64, hooks Hooks, write func(Frame) error) Frame {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m.mu.Lock()
+	start := s.committed
+	p := &progress{manager: m, write: write, status: m.statusLocked(s)}
+	m.mu.Unlock()
+	if _, err := p.send(FrameSession, ""); err != nil {
+		cancel()
+	}
+
+	// Progress is reported on a timer rather than per batch, so slow clients are not flooded
+	ticker := time.NewTicker(time.Duration(m.config.ProgressSeconds) * time.Second)
+	stopTicker := make(chan struct{})
+	tickerDone := make(chan struct{})
+	go func() {
+		defer close(tickerDone)
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := p.send(FrameProgress, ""); err != nil {
+					cancel()
+				}
+			case <-stopTicker:
+				return
+			}
+		}
+	}()
+
+	// The store runs alongside the reader; it owns fatal until done is closed
+	work := make(chan *batch, 1)
+	done := make(chan struct{})
+	var fatal error
+	go func() {
+		defer close(done)
+		for b := range work {
+			stored, rejected := int64(0), b.errors
+			if fatal == nil && len(b.docs) > 0 {
+				err := hooks.Store(ctx, b.docs)
+				var final *rejectedError
+				switch {
+				case err == nil:
+					stored = int64(len(b.docs))
+				case errors.As(err, &final):
+					for i, doc := range b.docs {
+						rejected = append(rejected, LineError{Line: b.docLines[i], ID: doc.ID, Error: final.Error()})
+					}
+				default:
+					fatal = err
+					cancel()
+				}
+			}
+			<-m.slots
+			if fatal != nil {
+				continue
+			}
+			status := m.commit(s, b.lines, stored, int64(len(rejected)))
+			p.reject(rejected)
+			p.update(func() { p.status = status })
+		}
+	}()
+
+	current := &batch{}
+	// enqueue hands the current batch to the store, pausing while the shared queue is full
+	enqueue := func() bool {
+		if current.lines == 0 {
+			return true
+		}
+		select {
+		case m.slots <- struct{}{}:
+		default:
+			p.update(func() { p.paused = true })
+			select {
+			case m.slots <- struct{}{}:
+			case <-ctx.Done():
+				return false
+			}
+			p.update(func() { p.paused = false })
+		}
+		select {
+		case work <- current:
+		case <-ctx.Done():
+			<-m.slots
+			return false
+		}
+		current = &batch{}
+		return true
+	}
+
+	reader := bufio.NewReaderSize(body, 64<<10)
+	line := offset
+	var readErr error
+	for ctx.Err() == nil {
+		data, tooLong, err := readLine(reader, m.config.MaxLineBytes)
+		if tooLong || len(bytes.TrimSpace(data)) > 0 {
+			p.update(func() { p.received++ })
+			if line >= start {
+				current.lines++
+				var doc liberation.Document
+				switch {
+				case tooLong:
+					current.errors = append(current.errors, LineError{Line: line, Error: fmt.Sprintf("line is longer than %d bytes", m.config.MaxLineBytes)})
+				case json.Unmarshal(data, &doc) != nil:
+					current.errors = append(current.errors, LineError{Line: line, Error: "line is not a JSON document"})
+				default:
+					if problem := hooks.Check(&doc); problem != nil {
+						current.errors = append(current.errors, LineError{Line: line, ID: doc.ID, Error: problem.Error()})
+					} else {
+						current.docs = append(current.docs, doc)
+						current.docLines = append(current.docLines, line)
+					}
+				}
+				if current.lines >= int64(m.config.BatchSize) && !enqueue() {
+					break
+				}
+			}
+			line++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+	if readErr == nil && ctx.Err() == nil {
+		enqueue()
+	}
+	close(work)
+	<-done
+
+	close(stopTicker)
+	<-tickerDone
+	ticker.Stop()
+
+	status := m.release(s)
+	p.update(func() { p.status, p.paused = status, false })
+	var reason string
+	switch {
+	case fatal != nil:
+		reason = fatal.Error()
+	case readErr != nil:
+		reason = "reading upload: " + readErr.Error()
+	case ctx.Err() != nil:
+		reason = "upload interrupted"
+	}
+	frameType := FrameDone
+	if reason != "" {
+		frameType = FrameError
+	}
+	frame, _ := p.send(frameType, reason)
+	return frame
+}
+
+// readLine reads up to the next newline. Lines longer than max are consumed without being
+// buffered and reported as too long.
+func readLine(r *bufio.Reader, max int) ([]byte, bool, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLong {
+			if len(line)+len(chunk) > max+1 {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err != bufio.ErrBufferFull {
+			return line, tooLong, err
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package upload streams large document uploads. The request body is newline-delimited JSON,
+// one document per line, read and stored in batches while progress frames are written back
+// on the same response.
+//
+// Embedding is the slow step, so batches wait in a queue shared by every upload. When it is
+// full, uploads stop reading their bodies until a batch finishes, which pushes back on
+// clients through TCP flow control instead of buffering the corpus in memory.
+//
+// Every upload belongs to a session. Its committed count is the number of lines that have
+// been stored or rejected for good, in order; an upload cut short resumes by sending the
+// session ID with the remaining lines.
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSessionNotFound is returned for unknown or expired sessions, and for sessions of
+	// another caller or namespace
+	ErrSessionNotFound = errors.New("upload session not found")
+
+	// ErrSessionActive is returned when a session is still receiving another upload
+	ErrSessionActive = errors.New("upload session is already receiving an upload")
+
+	// ErrOffsetGap is returned when an upload resumes past the session's committed line
+	ErrOffsetGap = errors.New("upload offset is past the committed line")
+)
+
+// Config controls batching, flow control and how long sessions can be resumed
+type Config struct {
+	// BatchSize is how many documents are embedded and stored at a time
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+	// QueueBatches is how many batches, across every upload, may be waiting or embedding;
+	// uploads pause reading when it is full
+	QueueBatches int `yaml:"queue_batches" json:"queue_batches"`
+	// ProgressSeconds is how often a progress frame is written
+	ProgressSeconds int `yaml:"progress_seconds" json:"progress_seconds"`
+	// SessionTTLMinutes is how long after its last upload a session can be resumed
+	SessionTTLMinutes int `yaml:"session_ttl_minutes" json:"session_ttl_minutes"`
+	// MaxLineBytes rejects longer lines; they are reported and skipped
+	MaxLineBytes int `yaml:"max_line_bytes" json:"max_line_bytes"`
+}
+
+// DefaultConfig embeds 25 documents at a time with up to 8 batches queued, reports every
+// two seconds and keeps sessions for a day
+func DefaultConfig() Config {
+	return Config{
+		BatchSize:         25,
+		QueueBatches:      8,
+		ProgressSeconds:   2,
+		SessionTTLMinutes: 24 * 60,
+		MaxLineBytes:      2 << 20,
+	}
+}
+
+// Validate reports settings that would stall every upload
+func (c Config) Validate() error {
+	if c.BatchSize < 1 || c.QueueBatches < 1 || c.ProgressSeconds < 1 || c.SessionTTLMinutes < 1 || c.MaxLineBytes < 1 {
+		return fmt.Errorf("batch_size, queue_batches, progress_seconds, session_ttl_minutes and max_line_bytes must be positive")
+	}
+	return nil
+}
+
+// Status describes a session
+type Status struct {
+	SessionID string `json:"session_id"`
+	Namespace string `json:"namespace"`
+	// Committed is the line the next upload of this session starts at
+	Committed int64     `json:"committed"`
+	Stored    int64     `json:"stored"`
+	Failed    int64     `json:"failed"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Session is an upload that can be resumed
+type Session struct {
+	id        string
+	namespace string
+	owner     string
+	committed int64
+	stored    int64
+	failed    int64
+	active    bool
+	created   time.Time
+	updated   time.Time
+}
+
+// ID is what the upload is resumed with
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Manager holds the sessions and the embedding queue shared by every upload. It is safe for
+// concurrent use.
+type Manager struct {
+	config Config
+	// slots holds a token for every queued or embedding batch
+	slots chan struct{}
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	now      func() time.Time
+}
+
+// NewManager creates a manager with an empty queue
+func NewManager(config Config) *Manager {
+	return &Manager{
+		config:   config,
+		slots:    make(chan struct{}, config.QueueBatches),
+		sessions: make(map[string]*Session),
+		now:      time.Now,
+	}
+}
+
+// Queued is how many batches are waiting or embedding across every upload
+func (m *Manager) Queued() int {
+	return len(m.slots)
+}
+
+// Open starts an upload into namespace for owner. An empty id creates a session; otherwise
+// the upload resumes that session with a body whose first line is line offset of the upload.
+func (m *Manager) Open(id, namespace, owner string, offset int64) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneLocked()
+
+	now := m.now()
+	if id == "" {
+		if offset != 0 {
+			return nil, ErrOffsetGap
+		}
+		raw := make([]byte, 16)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		s := &Session{id: hex.EncodeToString(raw), namespace: namespace, owner: owner, active: true, created: now, updated: now}
+		m.sessions[s.id] = s
+		return s, nil
+	}
+
+	s := m.sessions[id]
+	switch {
+	case s == nil || s.owner != owner || s.namespace != namespace:
+		return nil, ErrSessionNotFound
+	case s.active:
+		return nil, ErrSessionActive
+	case offset > s.committed:
+		return nil, fmt.Errorf("%w: offset %d, committed %d", ErrOffsetGap, offset, s.committed)
+	}
+	s.active = true
+	s.updated = now
+	return s, nil
+}
+
+// Status describes owner's session id
+func (m *Manager) Status(id, owner string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneLocked()
+	s := m.sessions[id]
+	if s == nil || s.owner != owner {
+		return Status{}, ErrSessionNotFound
+	}
+	return m.statusLocked(s), nil
+}
+
+// Sessions describes owner's sessions, most recently updated first
+func (m *Manager) Sessions(owner string) []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneLocked()
+	statuses := []Status{}
+	for _, s := range m.sessions {
+		if s.owner == owner {
+			statuses = append(statuses, m.statusLocked(s))
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].UpdatedAt.After(statuses[j].UpdatedAt) })
+	return statuses
+}
+
+func (m *Manager) statusLocked(s *Session) Status {
+	return Status{
+		SessionID: s.id,
+		Namespace: s.namespace,
+		Committed: s.committed,
+		Stored:    s.stored,
+		Failed:    s.failed,
+		Active:    s.active,
+		CreatedAt: s.created,
+		UpdatedAt: s.updated,
+	}
+}
+
+// pruneLocked forgets idle sessions past their TTL. The caller holds mu.
+func (m *Manager) pruneLocked() {
+	cutoff := m.now().Add(-time.Duration(m.config.SessionTTLMinutes) * time.Minute)
+	for id, s := range m.sessions {
+		if !s.active && s.updated.Before(cutoff) {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+// commit records a finished batch of lines
+func (m *Manager) commit(s *Session, lines, stored, failed int64) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.committed += lines
+	s.stored += stored
+	s.failed += failed
+	s.updated = m.now()
+	return m.statusLocked(s)
+}
+
+// release ends the session's upload so it can be resumed
+func (m *Manager) release(s *Session) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.active = false
+	s.updated = m.now()
+	return m.statusLocked(s)
+}
@@ -0,0 +1,173 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"liberation-ai/pkg/liberation"
+)
+
+func testManager(now *time.Time) *Manager {
+	config := DefaultConfig()
+	config.BatchSize = 2
+	config.SessionTTLMinutes = 60
+	m := NewManager(config)
+	m.now = func() time.Time { return *now }
+	return m
+}
+
+// lines returns documents from..to-1 as an NDJSON body
+func lines(from, to int) string {
+	var body strings.Builder
+	for i := from; i < to; i++ {
+		fmt.Fprintf(&body, `{"id": "doc-%d", "content": "document %d"}`+"\n", i, i)
+	}
+	return body.String()
+}
+
+// recorder stores documents in memory, failing the upload after failAfter batches if set
+type recorder struct {
+	stored    []string
+	batches   int
+	failAfter int
+}
+
+func (r *recorder) hooks() Hooks {
+	return Hooks{
+		Check: func(doc *liberation.Document) error { return nil },
+		Store: func(ctx context.Context, docs []liberation.Document) error {
+			if r.failAfter > 0 && r.batches == r.failAfter {
+				return errors.New("store unavailable")
+			}
+			r.batches++
+			for _, doc := range docs {
+				r.stored = append(r.stored, doc.ID)
+			}
+			return nil
+		},
+	}
+}
+
+func run(m *Manager, s *Session, body string, offset int64, r *recorder) Frame {
+	return m.Run(context.Background(), s, strings.NewReader(body), offset, r.hooks(), func(Frame) error { return nil })
+}
+
+func TestOverlappingResumeSkipsCommittedLines(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	m := testManager(&now)
+	r := &recorder{failAfter: 2}
+
+	s, err := m.Open("", "docs", "ip:192.0.2.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := run(m, s, lines(0, 10), 0, r)
+	if frame.Type != FrameError || frame.Committed != 4 {
+		t.Fatalf("frame %+v, want an error after two batches of two", frame)
+	}
+
+	// The client resends from line 2, overlapping the two committed lines it was unsure of
+	r.failAfter = 0
+	s, err = m.Open(s.ID(), "docs", "ip:192.0.2.1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame = run(m, s, lines(2, 10), 2, r)
+	if frame.Type != FrameDone || frame.Committed != 10 || frame.Stored != 10 || frame.Received != 8 {
+		t.Fatalf("frame %+v, want 10 committed and stored after receiving 8 lines", frame)
+	}
+	want := []string{"doc-0", "doc-1", "doc-2", "doc-3", "doc-4", "doc-5", "doc-6", "doc-7", "doc-8", "doc-9"}
+	if !slices.Equal(r.stored, want) {
+		t.Errorf("stored %v, want every document once", r.stored)
+	}
+
+	// Resending everything from the start stores nothing again
+	s, err = m.Open(s.ID(), "docs", "ip:192.0.2.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame = run(m, s, lines(0, 10), 0, r); frame.Stored != 10 || len(r.stored) != 10 {
+		t.Errorf("frame %+v after %d stores, want nothing stored twice", frame, len(r.stored))
+	}
+}
+
+func TestOutOfOrderOffsetsAreRefused(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	m := testManager(&now)
+
+	if _, err := m.Open("", "docs", "ip:192.0.2.1", 3); !errors.Is(err, ErrOffsetGap) {
+		t.Fatalf("a new session at offset 3: got %v, want ErrOffsetGap", err)
+	}
+
+	s, err := m.Open("", "docs", "ip:192.0.2.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Open(s.ID(), "docs", "ip:192.0.2.1", 0); !errors.Is(err, ErrSessionActive) {
+		t.Fatalf("a second upload into an active session: got %v, want ErrSessionActive", err)
+	}
+	run(m, s, lines(0, 4), 0, &recorder{})
+
+	tests := []struct {
+		name      string
+		namespace string
+		owner     string
+		offset    int64
+		want      error
+	}{
+		{name: "past the committed line", namespace: "docs", owner: "ip:192.0.2.1", offset: 5, want: ErrOffsetGap},
+		{name: "another caller", namespace: "docs", owner: "ip:192.0.2.2", offset: 4, want: ErrSessionNotFound},
+		{name: "another namespace", namespace: "other", owner: "ip:192.0.2.1", offset: 4, want: ErrSessionNotFound},
+		{name: "at the committed line", namespace: "docs", owner: "ip:192.0.2.1", offset: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Open(s.ID(), tt.namespace, tt.owner, tt.offset)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdleSessionsExpire(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	m := testManager(&now)
+
+	idle, err := m.Open("", "docs", "ip:192.0.2.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(m, idle, lines(0, 2), 0, &recorder{})
+	active, err := m.Open("", "docs", "ip:192.0.2.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Just inside the TTL the idle session can still be resumed and is listed
+	now = now.Add(59 * time.Minute)
+	if got := len(m.Sessions("ip:192.0.2.1")); got != 2 {
+		t.Fatalf("%d sessions listed, want 2", got)
+	}
+	if status, err := m.Status(idle.ID(), "ip:192.0.2.1"); err != nil || status.Committed != 2 {
+		t.Fatalf("status %+v, %v; want 2 lines committed", status, err)
+	}
+
+	// Past it the idle session is gone, while one still receiving an upload is kept
+	now = now.Add(2 * time.Minute)
+	if _, err := m.Open(idle.ID(), "docs", "ip:192.0.2.1", 2); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("resuming an expired session: got %v, want ErrSessionNotFound", err)
+	}
+	if _, err := m.Status(idle.ID(), "ip:192.0.2.1"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("status of an expired session: got %v, want ErrSessionNotFound", err)
+	}
+	sessions := m.Sessions("ip:192.0.2.1")
+	if len(sessions) != 1 || sessions[0].SessionID != active.ID() || !sessions[0].Active {
+		t.Errorf("sessions %+v, want only the active one", sessions)
+	}
+}
@@ -0,0 +1,133 @@
+package validate
+
+import (
+	"fmt"
+
+	"golang.org/x/text/language"
+)
+
+// DefaultLocale is used when Accept-Language names no supported locale
+const DefaultLocale = "en"
+
+// Locale is a language validation errors are available in
+type Locale struct {
+	Tag  string `json:"tag"`
+	Name string `json:"name"`
+}
+
+// Locales lists the supported locales, the default first
+var Locales = []Locale{
+	{Tag: "en", Name: "English"},
+	{Tag: "es", Name: "Español"},
+	{Tag: "fr", Name: "Français"},
+	{Tag: "de", Name: "Deutsch"},
+}
+
+var localeMatcher = func() language.Matcher {
+	tags := make([]language.Tag, len(Locales))
+	for i, locale := range Locales {
+		tags[i] = language.MustParse(locale.Tag)
+	}
+	return language.NewMatcher(tags)
+}()
+
+// catalogs translate the English messages, which are their own keys. A message missing from
+// a catalog is reported in English.
+var catalogs = map[string]map[string]string{
+	"es": {
+		"request body failed validation":                   "el cuerpo de la solicitud no superó la validación",
+		"is required":                                      "es obligatorio",
+		"is empty":                                         "está vacío",
+		"must not be empty":                                "no debe estar vacío",
+		"must not be negative":                             "no debe ser negativo",
+		"content or title is required":                     "se requiere contenido o título",
+		"is larger than %d bytes":                          "ocupa más de %d bytes",
+		"is longer than %d bytes":                          "es más largo que %d bytes",
+		"may list at most %d vectors":                      "puede incluir como máximo %d vectores",
+		"holds %d items; at most %d may be stored at once": "contiene %d elementos; se pueden guardar como máximo %d a la vez",
+		"%q appears more than once in the batch":           "%q aparece más de una vez en el lote",
+		"has %d dimensions; the store holds %d":            "tiene %d dimensiones; el almacén guarda %d",
+		"is not a finite number":                           "no es un número finito",
+		"is all zeros":                                     "son todo ceros",
+		"has %d keys; at most %d are allowed":              "tiene %d claves; se permiten como máximo %d",
+		"keys must not be empty":                           "las claves no deben estar vacías",
+		"cannot be encoded as JSON: %v":                    "no se puede codificar como JSON: %v",
+		"is %d bytes as JSON; at most %d are allowed":      "ocupa %d bytes como JSON; se permiten como máximo %d",
+	},
+	"fr": {
+		"request body failed validation":                   "le corps de la requête n'a pas passé la validation",
+		"is required":                                      "est obligatoire",
+		"is empty":                                         "est vide",
+		"must not be empty":                                "ne doit pas être vide",
+		"must not be negative":                             "ne doit pas être négatif",
+		"content or title is required":                     "un contenu ou un titre est requis",
+		"is larger than %d bytes":                          "dépasse %d octets",
+		"is longer than %d bytes":                          "est plus long que %d octets",
+		"may list at most %d vectors":                      "peut lister au plus %d vecteurs",
+		"holds %d items; at most %d may be stored at once": "contient %d éléments ; au plus %d peuvent être enregistrés à la fois",
+		"%q appears more than once in the batch":           "%q apparaît plusieurs fois dans le lot",
+		"has %d dimensions; the store holds %d":            "a %d dimensions ; le stockage en contient %d",
+		"is not a finite number":                           "n'est pas un nombre fini",
+		"is all zeros":                                     "ne contient que des zéros",
+		"has %d keys; at most %d are allowed":              "a %d clés ; au plus %d sont autorisées",
+		"keys must not be empty":                           "les clés ne doivent pas être vides",
+		"cannot be encoded as JSON: %v":                    "ne peut pas être encodé en JSON : %v",
+		"is %d bytes as JSON; at most %d are allowed":      "fait %d octets en JSON ; au plus %d sont autorisés",
+	},
+	"de": {
+		"request body failed validation":                   "der Anfrageinhalt hat die Prüfung nicht bestanden",
+		"is required":                                      "ist erforderlich",
+		"is empty":                                         "ist leer",
+		"must not be empty":                                "darf nicht leer sein",
+		"must not be negative":                             "darf nicht negativ sein",
+		"content or title is required":                     "Inhalt oder Titel ist erforderlich",
+		"is larger than %d bytes":                          "ist größer als %d Bytes",
+		"is longer than %d bytes":                          "ist länger als %d Bytes",
+		"may list at most %d vectors":                      "darf höchstens %d Vektoren enthalten",
+		"holds %d items; at most %d may be stored at once": "enthält %d Einträge; höchstens %d können auf einmal gespeichert werden",
+		"%q appears more than once in the batch":           "%q kommt mehrfach im Stapel vor",
+		"has %d dimensions; the store holds %d":            "hat %d Dimensionen; der Speicher enthält %d",
+		"is not a finite number":                           "ist keine endliche Zahl",
+		"is all zeros":                                     "besteht nur aus Nullen",
+		"has %d keys; at most %d are allowed":              "hat %d Schlüssel; höchstens %d sind erlaubt",
+		"keys must not be empty":                           "Schlüssel dürfen nicht leer sein",
+		"cannot be encoded as JSON: %v":                    "kann nicht als JSON kodiert werden: %v",
+		"is %d bytes as JSON; at most %d are allowed":      "ist als JSON %d Bytes groß; höchstens %d sind erlaubt",
+	},
+}
+
+// MatchLocale picks the supported locale an Accept-Language header prefers
+func MatchLocale(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+	_, index, confidence := localeMatcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+	return Locales[index].Tag
+}
+
+// Translate formats an English message in a locale
+func Translate(locale, message string, args ...interface{}) string {
+	if translated, ok := catalogs[locale][message]; ok {
+		message = translated
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Localize returns the errors with their messages in a locale
+func (e Errors) Localize(locale string) Errors {
+	localized := make(Errors, len(e))
+	for i, problem := range e {
+		localized[i] = problem
+		if problem.format != "" {
+			localized[i].Message = Translate(locale, problem.format, problem.args...)
+		}
+	}
+	return localized
+}
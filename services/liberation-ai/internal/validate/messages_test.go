@@ -0,0 +1,60 @@
+package validate
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMatchLocale(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                     "en",
+		"fr-CA":                "fr",
+		"es-419,es;q=0.9":      "es",
+		"ja, de;q=0.5":         "de",
+		"ja":                   "en",
+		"not a language list!": "en",
+	} {
+		if got := MatchLocale(header); got != want {
+			t.Errorf("MatchLocale(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if got := Translate("fr", "has %d keys; at most %d are allowed", 3, 2); got != "a 3 clés ; au plus 2 sont autorisées" {
+		t.Errorf("French: %q", got)
+	}
+	if got := Translate("de", "an untranslated message"); got != "an untranslated message" {
+		t.Errorf("missing translation: %q", got)
+	}
+	if got := Translate("en", "is larger than %d bytes", 16); got != "is larger than 16 bytes" {
+		t.Errorf("English: %q", got)
+	}
+
+	var problems Errors
+	problems.add("id", "is required")
+	problems = append(problems, FieldError{Field: "raw", Message: "kept as is"})
+	localized := problems.Localize("es")
+	if localized[0].Message != "es obligatorio" || localized[1].Message != "kept as is" || problems[0].Message != "is required" {
+		t.Errorf("localized %+v from %+v", localized, problems)
+	}
+}
+
+// Every catalog translates the same messages, with the same verbs in the same order
+func TestCatalogs(t *testing.T) {
+	verbs := regexp.MustCompile(`%[a-z]`)
+	for _, locale := range Locales[1:] {
+		catalog := catalogs[locale.Tag]
+		if len(catalog) != len(catalogs["es"]) {
+			t.Errorf("%s translates %d messages, es %d", locale.Tag, len(catalog), len(catalogs["es"]))
+		}
+		for message, translated := range catalog {
+			if _, ok := catalogs["es"][message]; !ok {
+				t.Errorf("%s translates %q, which es does not", locale.Tag, message)
+			}
+			if got, want := verbs.FindAllString(translated, -1), verbs.FindAllString(message, -1); len(got) != len(want) || (len(got) > 0 && got[0] != want[0]) {
+				t.Errorf("%s: %q has verbs %v, %q has %v", locale.Tag, translated, got, message, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Package validate checks /v1 request bodies against per-route schemas before any embedding
+// provider or vector store work begins.
+//
+// A malformed document used to fail deep inside the store, or worse, be stored: a missing ID
+// overwrote another document's chunks, and an embedding of the wrong length failed only when
+// Postgres rejected it. Each route now declares what its body must look like, and requests
+// that break the schema are answered 422 with one error per offending field, in the language
+// the request's Accept-Language prefers.
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/analytics"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+// maxErrors bounds the field errors reported for one request
+const maxErrors = 50
+
+// Config limits what a request may carry
+type Config struct {
+	// MaxBatch is the most documents or vectors one request may store
+	MaxBatch int `yaml:"max_batch" json:"max_batch"`
+	// MaxIDLength is the longest document or vector ID accepted, in bytes
+	MaxIDLength int `yaml:"max_id_length" json:"max_id_length"`
+	// MaxContentBytes is the largest document content or vector text accepted
+	MaxContentBytes int `yaml:"max_content_bytes" json:"max_content_bytes"`
+	// MaxMetadataKeys and MaxMetadataBytes limit each metadata object, measured as JSON
+	MaxMetadataKeys  int `yaml:"max_metadata_keys" json:"max_metadata_keys"`
+	MaxMetadataBytes int `yaml:"max_metadata_bytes" json:"max_metadata_bytes"`
+}
+
+// DefaultConfig accepts batches of up to 1000 documents of 1 MiB, each with up to 64
+// metadata keys in 16 KiB
+func DefaultConfig() Config {
+	return Config{
+		MaxBatch:         1000,
+		MaxIDLength:      256,
+		MaxContentBytes:  1 << 20,
+		MaxMetadataKeys:  64,
+		MaxMetadataBytes: 16 << 10,
+	}
+}
+
+// Validate reports limits that would reject every request
+func (c Config) Validate() error {
+	if c.MaxBatch <= 0 || c.MaxIDLength <= 0 || c.MaxContentBytes <= 0 || c.MaxMetadataKeys <= 0 || c.MaxMetadataBytes <= 0 {
+		return fmt.Errorf("max_batch, max_id_length, max_content_bytes, max_metadata_keys and max_metadata_bytes must be positive")
+	}
+	return nil
+}
+
+// FieldError is one problem with one field of a request body
+type FieldError struct {
+	// Field is the field's path in the body, e.g. "vectors[2].embedding"; empty for the body itself
+	Field   string `json:"field"`
+	Message string `json:"message"`
+
+	// format and args build Message, so it can be translated
+	format string
+	args   []interface{}
+}
+
+// Errors lists a request's field errors
+type Errors []FieldError
+
+// Error implements error
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, problem := range e {
+		if problem.Field == "" {
+			messages[i] = problem.Message
+		} else {
+			messages[i] = problem.Field + ": " + problem.Message
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *Errors) add(field, format string, args ...interface{}) {
+	if len(*e) < maxErrors {
+		*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...), format: format, args: args})
+	}
+}
+
+// Validator holds the schemas of the /v1 routes
+type Validator struct {
+	config Config
+	// dimensions is the length every stored embedding must have
+	dimensions int
+}
+
+// NewValidator creates a validator for a store of the given embedding dimensions
+func NewValidator(config Config, dimensions int) *Validator {
+	return &Validator{config: config, dimensions: dimensions}
+}
+
+// Body validates a route's JSON body with check before the route's handler runs. Bodies
+// that do not decode into T are passed on, so the handler rejects them as it always has;
+// bodies that decode but break the schema are answered 422 with every problem found, in the
+// locale Accept-Language selects.
+func Body[T any](check func(*T) Errors) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req T
+		if json.Unmarshal(body, &req) != nil {
+			return
+		}
+		if problems := check(&req); len(problems) > 0 {
+			locale := MatchLocale(c.GetHeader("Accept-Language"))
+			c.Header("Content-Language", locale)
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+				"error":  Translate(locale, "request body failed validation"),
+				"fields": problems.Localize(locale),
+			})
+		}
+	}
+}
+
+// Documents checks a POST /v1/documents batch: every document needs a unique ID and some
+// title or content, within the configured sizes
+func (v *Validator) Documents(docs *[]liberation.Document) Errors {
+	var problems Errors
+	v.batch(&problems, "", len(*docs))
+	seen := make(map[string]bool, len(*docs))
+	for i, doc := range *docs {
+		v.document(&problems, fmt.Sprintf("[%d]", i), &doc, seen)
+	}
+	return problems
+}
+
+// Document checks one document of a streamed upload as Documents checks each of a batch
+func (v *Validator) Document(doc *liberation.Document) Errors {
+	var problems Errors
+	v.document(&problems, "", doc, map[string]bool{})
+	return problems
+}
+
+func (v *Validator) document(problems *Errors, field string, doc *liberation.Document, seen map[string]bool) {
+	prefix := field
+	if prefix != "" {
+		prefix += "."
+	}
+	v.id(problems, prefix+"id", doc.ID, seen)
+	if strings.TrimSpace(doc.Title) == "" && strings.TrimSpace(doc.Content) == "" {
+		problems.add(prefix+"content", "content or title is required")
+	}
+	if len(doc.Content) > v.config.MaxContentBytes {
+		problems.add(prefix+"content", "is larger than %d bytes", v.config.MaxContentBytes)
+	}
+	v.metadata(problems, prefix+"metadata", doc.Metadata)
+}
+
+// Vectors checks a POST /v1/vectors request: every vector needs a unique ID and a finite,
+// non-zero embedding of the store's dimensions
+func (v *Validator) Vectors(req *types.StoreRequest) Errors {
+	var problems Errors
+	if req.Namespace == "" {
+		problems.add("namespace", "is required")
+	}
+	v.batch(&problems, "vectors", len(req.Vectors))
+	seen := make(map[string]bool, len(req.Vectors))
+	for i, vector := range req.Vectors {
+		field := fmt.Sprintf("vectors[%d]", i)
+		v.id(&problems, field+".id", vector.ID, seen)
+		v.embedding(&problems, field+".embedding", vector.Embedding)
+		if len(vector.Text) > v.config.MaxContentBytes {
+			problems.add(field+".text", "is larger than %d bytes", v.config.MaxContentBytes)
+		}
+		v.metadata(&problems, field+".metadata", vector.Metadata)
+	}
+	return problems
+}
+
+// MetadataUpdate checks the size of a PATCH /v1/vectors metadata update; which vectors it
+// selects is checked by the update itself
+func (v *Validator) MetadataUpdate(req *types.MetadataUpdate) Errors {
+	var problems Errors
+	if req.Metadata == nil {
+		problems.add("metadata", "is required")
+	}
+	v.metadata(&problems, "metadata", req.Metadata)
+	if len(req.IDs) > v.config.MaxBatch {
+		problems.add("ids", "may list at most %d vectors", v.config.MaxBatch)
+	}
+	for i, id := range req.IDs {
+		if id == "" {
+			problems.add(fmt.Sprintf("ids[%d]", i), "is empty")
+		}
+	}
+	return problems
+}
+
+// Extract checks a POST /v1/extract request has a query and a schema
+func (v *Validator) Extract(req *types.ExtractRequest) Errors {
+	var problems Errors
+	if strings.TrimSpace(req.Query) == "" {
+		problems.add("query", "is required")
+	}
+	if len(req.Schema) == 0 {
+		problems.add("schema", "is required")
+	}
+	if req.ContextLimit < 0 {
+		problems.add("context_limit", "must not be negative")
+	}
+	return problems
+}
+
+// Feedback checks a POST /v1/feedback event names a search, a document and an action
+func (v *Validator) Feedback(req *analytics.FeedbackRequest) Errors {
+	var problems Errors
+	for field, value := range map[string]string{"query_id": req.QueryID, "document_id": req.DocumentID, "action": req.Action} {
+		if value == "" {
+			problems.add(field, "is required")
+		}
+	}
+	if req.Rank < 0 {
+		problems.add("rank", "must not be negative")
+	}
+	return problems
+}
+
+func (v *Validator) batch(problems *Errors, field string, size int) {
+	switch {
+	case size == 0:
+		problems.add(field, "must not be empty")
+	case size > v.config.MaxBatch:
+		problems.add(field, "holds %d items; at most %d may be stored at once", size, v.config.MaxBatch)
+	}
+}
+
+// id checks an ID is present, short enough and not repeated in its batch
+func (v *Validator) id(problems *Errors, field, id string, seen map[string]bool) {
+	switch {
+	case strings.TrimSpace(id) == "":
+		problems.add(field, "is required")
+	case len(id) > v.config.MaxIDLength:
+		problems.add(field, "is longer than %d bytes", v.config.MaxIDLength)
+	case seen[id]:
+		problems.add(field, "%q appears more than once in the batch", id)
+	}
+	seen[id] = true
+}
+
+func (v *Validator) embedding(problems *Errors, field string, embedding []float32) {
+	if len(embedding) != v.dimensions {
+		problems.add(field, "has %d dimensions; the store holds %d", len(embedding), v.dimensions)
+		return
+	}
+	zero := true
+	for i, value := range embedding {
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			problems.add(fmt.Sprintf("%s[%d]", field, i), "is not a finite number")
+			return
+		}
+		zero = zero && value == 0
+	}
+	// A zero vector has no direction, so its cosine similarity to anything is undefined
+	if zero {
+		problems.add(field, "is all zeros")
+	}
+}
+
+func (v *Validator) metadata(problems *Errors, field string, metadata map[string]interface{}) {
+	if len(metadata) > v.config.MaxMetadataKeys {
+		problems.add(field, "has %d keys; at most %d are allowed", len(metadata), v.config.MaxMetadataKeys)
+	}
+	if _, ok := metadata[""]; ok {
+		problems.add(field, "keys must not be empty")
+	}
+	if len(metadata) == 0 {
+		return
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		problems.add(field, "cannot be encoded as JSON: %v", err)
+		return
+	}
+	if len(encoded) > v.config.MaxMetadataBytes {
+		problems.add(field, "is %d bytes as JSON; at most %d are allowed", len(encoded), v.config.MaxMetadataBytes)
+	}
+}
@@ -0,0 +1,174 @@
+package validate
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-ai/internal/analytics"
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+func testValidator() *Validator {
+	config := DefaultConfig()
+	config.MaxBatch, config.MaxIDLength, config.MaxContentBytes = 3, 8, 16
+	config.MaxMetadataKeys, config.MaxMetadataBytes = 2, 24
+	return NewValidator(config, 3)
+}
+
+// fields lists the fields with errors, in the order they were reported
+func fields(problems Errors) []string {
+	out := make([]string, len(problems))
+	for i, problem := range problems {
+		out[i] = problem.Field
+	}
+	return out
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Error(err)
+	}
+	config := DefaultConfig()
+	config.MaxMetadataBytes = 0
+	if err := config.Validate(); err == nil {
+		t.Error("a zero limit was accepted")
+	}
+}
+
+func TestDocuments(t *testing.T) {
+	v := testValidator()
+	for _, tc := range []struct {
+		name string
+		docs []liberation.Document
+		want string
+	}{
+		{"valid", []liberation.Document{{ID: "a", Content: "lend"}, {ID: "b", Title: "Drill"}}, ""},
+		{"empty batch", nil, "must not be empty"},
+		{"too many", []liberation.Document{{ID: "a", Title: "x"}, {ID: "b", Title: "x"}, {ID: "c", Title: "x"}, {ID: "d", Title: "x"}},
+			"holds 4 items; at most 3 may be stored at once"},
+		{"missing ID and text", []liberation.Document{{ID: " ", Title: " "}},
+			"[0].id: is required; [0].content: content or title is required"},
+		{"repeated and long IDs", []liberation.Document{{ID: "a", Title: "x"}, {ID: "a", Title: "x"}, {ID: "too-long-id", Title: "x"}},
+			`[1].id: "a" appears more than once in the batch; [2].id: is longer than 8 bytes`},
+		{"large content", []liberation.Document{{ID: "a", Content: strings.Repeat("x", 17)}}, "[0].content: is larger than 16 bytes"},
+		{"metadata", []liberation.Document{{ID: "a", Title: "x", Metadata: map[string]interface{}{"": 1, "b": 2, "c": 3}}},
+			"[0].metadata: has 3 keys; at most 2 are allowed; [0].metadata: keys must not be empty"},
+		{"large metadata", []liberation.Document{{ID: "a", Title: "x", Metadata: map[string]interface{}{"a": strings.Repeat("x", 20)}}},
+			"[0].metadata: is 28 bytes as JSON; at most 24 are allowed"},
+		{"unencodable metadata", []liberation.Document{{ID: "a", Title: "x", Metadata: map[string]interface{}{"n": math.Inf(1)}}},
+			"[0].metadata: cannot be encoded as JSON: json: unsupported value: +Inf"},
+	} {
+		if got := v.Documents(&tc.docs); (tc.want == "" && got != nil) || (tc.want != "" && got.Error() != tc.want) {
+			t.Errorf("%s: %v, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	if problems := v.Document(&liberation.Document{ID: "a"}); problems.Error() != "content: content or title is required" {
+		t.Errorf("streamed document: %v", problems)
+	}
+}
+
+func TestVectors(t *testing.T) {
+	v := testValidator()
+	req := &types.StoreRequest{Vectors: []types.Vector{
+		{ID: "a", Embedding: []float32{1, 0, 0}, Text: strings.Repeat("x", 17)},
+		{ID: "b", Embedding: []float32{1, 0}},
+		{ID: "c", Embedding: []float32{0, float32(math.NaN()), 0}},
+	}}
+	want := []string{"namespace", "vectors[0].text", "vectors[1].embedding", "vectors[2].embedding[1]"}
+	if got := fields(v.Vectors(req)); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("fields %v, want %v", got, want)
+	}
+
+	zero := &types.StoreRequest{Namespace: "docs", Vectors: []types.Vector{{ID: "a", Embedding: []float32{0, 0, 0}}}}
+	if problems := v.Vectors(zero); problems.Error() != "vectors[0].embedding: is all zeros" {
+		t.Errorf("zero vector: %v", problems)
+	}
+	valid := &types.StoreRequest{Namespace: "docs", Vectors: []types.Vector{{ID: "a", Embedding: []float32{0, 0, 1}, Metadata: map[string]interface{}{"a": 1}}}}
+	if problems := v.Vectors(valid); problems != nil {
+		t.Errorf("valid vectors: %v", problems)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	v := testValidator()
+	if problems := v.MetadataUpdate(&types.MetadataUpdate{IDs: []string{"a", "", "b", "c"}}); problems.Error() != "metadata: is required; ids: may list at most 3 vectors; ids[1]: is empty" {
+		t.Errorf("metadata update: %v", problems)
+	}
+	if problems := v.MetadataUpdate(&types.MetadataUpdate{Filters: map[string]interface{}{"a": 1}, Metadata: map[string]interface{}{"a": nil}}); problems != nil {
+		t.Errorf("valid metadata update: %v", problems)
+	}
+
+	if problems := v.Extract(&types.ExtractRequest{Query: " ", ContextLimit: -1}); problems.Error() != "query: is required; schema: is required; context_limit: must not be negative" {
+		t.Errorf("extract: %v", problems)
+	}
+
+	got := fields(v.Feedback(&analytics.FeedbackRequest{Action: "click", Rank: -1}))
+	sort.Strings(got)
+	if strings.Join(got, ",") != "document_id,query_id,rank" {
+		t.Errorf("feedback fields %v", got)
+	}
+}
+
+func TestTooManyErrors(t *testing.T) {
+	config := DefaultConfig()
+	v := NewValidator(config, 3)
+	docs := make([]liberation.Document, 60)
+	if problems := v.Documents(&docs); len(problems) != maxErrors {
+		t.Errorf("%d errors reported", len(problems))
+	}
+}
+
+func TestBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	v := testValidator()
+	router := gin.New()
+	router.POST("/v1/vectors", Body(v.Vectors), func(c *gin.Context) {
+		var req types.StoreRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "handler rejected the body"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"stored": len(req.Vectors)})
+	})
+	post := func(body, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/vectors", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Language", acceptLanguage)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The handler still reads the body after validation
+	if w := post(`{"namespace": "docs", "vectors": [{"id": "a", "embedding": [1, 0, 0]}]}`, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"stored":1`) {
+		t.Errorf("valid body: %d %s", w.Code, w.Body)
+	}
+	// Bodies that do not decode are left to the handler
+	if w := post(`{"vectors": "none"}`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed body: %d %s", w.Code, w.Body)
+	}
+
+	w := post(`{"vectors": [{"id": "a", "embedding": [1, 0]}]}`, "de-CH, en;q=0.5")
+	var response struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnprocessableEntity || w.Header().Get("Content-Language") != "de" || response.Error != "der Anfrageinhalt hat die Prüfung nicht bestanden" {
+		t.Errorf("invalid body: %d %s", w.Code, w.Body)
+	}
+	if len(response.Fields) != 2 || response.Fields[1].Field != "vectors[0].embedding" || response.Fields[1].Message != "hat 2 Dimensionen; der Speicher enthält 3" {
+		t.Errorf("field errors %+v", response.Fields)
+	}
+}
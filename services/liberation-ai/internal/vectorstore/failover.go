@@ -0,0 +1,414 @@
+package vectorstore
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"liberation-ai/pkg/types"
+)
+
+// Failover modes
+const (
+	ModePrimary   = "primary"
+	ModeDegraded  = "degraded"
+	ModeResyncing = "resyncing"
+)
+
+// Fallback store types
+const (
+	FallbackMemory   = "memory"
+	FallbackPostgres = "postgres"
+)
+
+// FailoverConfig describes the vector_store.fallback section
+type FailoverConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Type is memory, an embedded copy kept in step with every write, or postgres, a
+	// read-only replica at ConnectionURL
+	Type          string `yaml:"type" json:"type"`
+	ConnectionURL string `yaml:"connection_url" json:"-"`
+	// CheckIntervalSeconds is how often the primary's health is checked
+	CheckIntervalSeconds int `yaml:"check_interval_seconds" json:"check_interval_seconds"`
+	// FailureThreshold is how many failed checks in a row switch reads to the fallback
+	FailureThreshold int `yaml:"failure_threshold" json:"failure_threshold"`
+	// MaxQueuedWrites bounds the writes held for the primary while it is down
+	MaxQueuedWrites int `yaml:"max_queued_writes" json:"max_queued_writes"`
+}
+
+// DefaultFailoverConfig leaves failover off; once enabled it keeps an embedded copy
+func DefaultFailoverConfig() FailoverConfig {
+	return FailoverConfig{
+		Type:                 FallbackMemory,
+		CheckIntervalSeconds: 10,
+		FailureThreshold:     3,
+		MaxQueuedWrites:      10000,
+	}
+}
+
+// Validate reports settings that cannot work
+func (c FailoverConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.Type {
+	case FallbackMemory:
+	case FallbackPostgres:
+		if c.ConnectionURL == "" {
+			return fmt.Errorf("a postgres fallback needs a connection_url for the replica")
+		}
+	default:
+		return fmt.Errorf("unknown fallback type %q", c.Type)
+	}
+	if c.CheckIntervalSeconds <= 0 || c.FailureThreshold <= 0 || c.MaxQueuedWrites <= 0 {
+		return fmt.Errorf("check_interval_seconds, failure_threshold and max_queued_writes must be positive")
+	}
+	return nil
+}
+
+// FailoverStatus describes which store is serving and what is waiting for the primary
+type FailoverStatus struct {
+	Mode         string    `json:"mode"`
+	Fallback     string    `json:"fallback"`
+	Since        time.Time `json:"since"`
+	QueuedWrites int       `json:"queued_writes"`
+	LastError    string    `json:"last_error,omitempty"`
+	Failovers    int       `json:"failovers"`
+}
+
+// queuedWrite is a write accepted while the primary was down, replayed in order on recovery
+type queuedWrite struct {
+	store    *types.StoreRequest
+	metadata *types.MetadataUpdate
+	delete   []string
+	ns       string
+}
+
+// FailoverStore serves from a primary store and switches reads to a fallback when the
+// primary's health checks fail. Writes made meanwhile are queued and replayed to the
+// primary once it recovers; reads return to the primary only when the queue is empty.
+type FailoverStore struct {
+	primary  types.VectorStore
+	fallback types.VectorStore
+	config   FailoverConfig
+	// mirror applies every write to the fallback too, so an embedded copy stays current
+	mirror bool
+
+	mu        sync.Mutex
+	mode      string
+	since     time.Time
+	failures  int
+	queue     []queuedWrite
+	lastError string
+	failovers int
+
+	stop chan struct{}
+}
+
+// NewFailoverStore wraps primary with fallback. Call Start to begin health checks.
+func NewFailoverStore(primary, fallback types.VectorStore, config FailoverConfig) *FailoverStore {
+	return &FailoverStore{
+		primary:  primary,
+		fallback: fallback,
+		config:   config,
+		mirror:   config.Type == FallbackMemory,
+		mode:     ModePrimary,
+		since:    time.Now(),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start seeds an embedded fallback from the primary, then checks the primary's health
+// until Close
+func (f *FailoverStore) Start(ctx context.Context) {
+	go func() {
+		if f.mirror {
+			if result, err := f.primary.Migrate(ctx, f.fallback); err != nil {
+				log.Printf("vector store fallback: seeding from primary failed: %v", err)
+			} else if len(result.Errors) > 0 {
+				log.Printf("vector store fallback: seeding from primary: %v", result.Errors)
+			}
+		}
+
+		ticker := time.NewTicker(time.Duration(f.config.CheckIntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				f.check(ctx)
+			}
+		}
+	}()
+}
+
+// check probes the primary, failing over after enough consecutive failures and resyncing
+// once it answers again
+func (f *FailoverStore) check(ctx context.Context) {
+	checkCtx, cancel := context.WithTimeout(ctx, time.Duration(f.config.CheckIntervalSeconds)*time.Second)
+	err := f.primary.Health(checkCtx)
+	cancel()
+
+	f.mu.Lock()
+	if err != nil {
+		f.failures++
+		f.lastError = err.Error()
+		if f.mode == ModePrimary && f.failures >= f.config.FailureThreshold {
+			f.setMode(ModeDegraded)
+			f.failovers++
+			log.Printf("vector store: primary failed %d health checks, serving from %s fallback: %v", f.failures, f.config.Type, err)
+		}
+		f.mu.Unlock()
+		return
+	}
+	f.failures = 0
+	degraded := f.mode == ModeDegraded
+	if degraded {
+		f.setMode(ModeResyncing)
+	}
+	f.mu.Unlock()
+
+	if degraded {
+		f.resync(ctx)
+	}
+}
+
+// resync replays queued writes to the primary in order, then switches reads back to it
+func (f *FailoverStore) resync(ctx context.Context) {
+	replayed := 0
+	for {
+		f.mu.Lock()
+		if len(f.queue) == 0 {
+			f.setMode(ModePrimary)
+			f.lastError = ""
+			f.mu.Unlock()
+			log.Printf("vector store: primary recovered, %d queued writes replayed", replayed)
+			return
+		}
+		write := f.queue[0]
+		f.mu.Unlock()
+
+		var err error
+		switch {
+		case write.store != nil:
+			_, err = f.primary.Store(ctx, write.store)
+		case write.metadata != nil:
+			_, err = f.primary.UpdateMetadata(ctx, write.metadata)
+		default:
+			err = f.primary.Delete(ctx, write.ns, write.delete)
+		}
+
+		f.mu.Lock()
+		if err != nil {
+			f.setMode(ModeDegraded)
+			f.lastError = fmt.Sprintf("resync: %v", err)
+			f.mu.Unlock()
+			log.Printf("vector store: resync stopped with %d writes still queued: %v", len(f.queue), err)
+			return
+		}
+		f.queue = f.queue[1:]
+		f.mu.Unlock()
+		replayed++
+	}
+}
+
+// setMode must be called with mu held
+func (f *FailoverStore) setMode(mode string) {
+	f.mode = mode
+	f.since = time.Now()
+}
+
+// Status reports the serving mode and the writes waiting for the primary
+func (f *FailoverStore) Status() FailoverStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return FailoverStatus{
+		Mode:         f.mode,
+		Fallback:     f.config.Type,
+		Since:        f.since,
+		QueuedWrites: len(f.queue),
+		LastError:    f.lastError,
+		Failovers:    f.failovers,
+	}
+}
+
+// reader returns the store that currently serves reads
+func (f *FailoverStore) reader() types.VectorStore {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.mode == ModePrimary {
+		return f.primary
+	}
+	return f.fallback
+}
+
+// enqueue queues a write for the primary, reporting false when it is serving again.
+// Writes are queued while resyncing too, so they are replayed after the ones before them.
+func (f *FailoverStore) enqueue(write queuedWrite) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.mode == ModePrimary {
+		return false, nil
+	}
+	if len(f.queue) >= f.config.MaxQueuedWrites {
+		return true, types.ErrWriteQueueFull
+	}
+	f.queue = append(f.queue, write)
+	return true, nil
+}
+
+// Store implements VectorStore.Store
+func (f *FailoverStore) Store(ctx context.Context, req *types.StoreRequest) (*types.StoreResponse, error) {
+	queued, err := f.enqueue(queuedWrite{store: req})
+	if err != nil {
+		return nil, err
+	}
+	if !queued {
+		response, err := f.primary.Store(ctx, req)
+		if err == nil && f.mirror {
+			f.fallback.Store(ctx, req)
+		}
+		return response, err
+	}
+
+	// An embedded copy takes the write now so searches see it; a replica catches up by replication
+	if f.mirror {
+		return f.fallback.Store(ctx, req)
+	}
+	return &types.StoreResponse{Stored: len(req.Vectors), Store: "queued"}, nil
+}
+
+// Delete implements VectorStore.Delete
+func (f *FailoverStore) Delete(ctx context.Context, namespace string, ids []string) error {
+	queued, err := f.enqueue(queuedWrite{ns: namespace, delete: ids})
+	if err != nil {
+		return err
+	}
+	if !queued {
+		err := f.primary.Delete(ctx, namespace, ids)
+		if err == nil && f.mirror {
+			f.fallback.Delete(ctx, namespace, ids)
+		}
+		return err
+	}
+
+	if f.mirror {
+		return f.fallback.Delete(ctx, namespace, ids)
+	}
+	return nil
+}
+
+// UpdateMetadata implements VectorStore.UpdateMetadata; while degraded the update is queued
+// like any other write, and the count comes from the fallback when it mirrors the primary
+func (f *FailoverStore) UpdateMetadata(ctx context.Context, update *types.MetadataUpdate) (*types.MetadataUpdateResponse, error) {
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+	queued, err := f.enqueue(queuedWrite{metadata: update})
+	if err != nil {
+		return nil, err
+	}
+	if !queued {
+		response, err := f.primary.UpdateMetadata(ctx, update)
+		if err == nil && f.mirror {
+			f.fallback.UpdateMetadata(ctx, update)
+		}
+		return response, err
+	}
+
+	if f.mirror {
+		return f.fallback.UpdateMetadata(ctx, update)
+	}
+	return &types.MetadataUpdateResponse{Store: "queued"}, nil
+}
+
+// IndexMetadata implements types.MetadataIndexer on the primary; a replica fallback
+// follows the primary's indexes and an embedded one has none
+func (f *FailoverStore) IndexMetadata(ctx context.Context, field string, fieldType types.FieldType) error {
+	if indexer, ok := f.primary.(types.MetadataIndexer); ok {
+		return indexer.IndexMetadata(ctx, field, fieldType)
+	}
+	return nil
+}
+
+// Search implements VectorStore.Search
+func (f *FailoverStore) Search(ctx context.Context, req *types.SearchRequest) (*types.SearchResponse, error) {
+	return f.reader().Search(ctx, req)
+}
+
+// Get implements VectorStore.Get
+func (f *FailoverStore) Get(ctx context.Context, namespace string, id string) (*types.Vector, error) {
+	return f.reader().Get(ctx, namespace, id)
+}
+
+// List implements VectorStore.List
+func (f *FailoverStore) List(ctx context.Context, namespace, after string, limit int) ([]types.Vector, error) {
+	return f.reader().List(ctx, namespace, after, limit)
+}
+
+// Chunks implements VectorStore.Chunks
+func (f *FailoverStore) Chunks(ctx context.Context, namespace, docID string, from, to int) ([]types.Vector, error) {
+	return f.reader().Chunks(ctx, namespace, docID, from, to)
+}
+
+// ListNamespaces implements VectorStore.ListNamespaces
+func (f *FailoverStore) ListNamespaces(ctx context.Context) ([]string, error) {
+	return f.reader().ListNamespaces(ctx)
+}
+
+// Stats implements VectorStore.Stats
+func (f *FailoverStore) Stats(ctx context.Context) (*types.VectorStoreStats, error) {
+	return f.reader().Stats(ctx)
+}
+
+// Migrate implements VectorStore.Migrate; it needs the primary
+func (f *FailoverStore) Migrate(ctx context.Context, destination types.VectorStore) (*types.MigrationResult, error) {
+	if f.Status().Mode != ModePrimary {
+		return nil, types.ErrStoreDegraded
+	}
+	return f.primary.Migrate(ctx, destination)
+}
+
+// Clone implements VectorStore.Clone; it needs the primary, whose snapshot is authoritative
+func (f *FailoverStore) Clone(ctx context.Context, source, target string, progress func(copied, total int64)) (int64, error) {
+	if f.Status().Mode != ModePrimary {
+		return 0, types.ErrStoreDegraded
+	}
+	copied, err := f.primary.Clone(ctx, source, target, progress)
+	if err == nil && f.mirror {
+		f.fallback.Clone(ctx, source, target, nil)
+	}
+	return copied, err
+}
+
+// Health implements VectorStore.Health. A healthy fallback keeps the store healthy;
+// Status tells callers they are being served in degraded mode.
+func (f *FailoverStore) Health(ctx context.Context) error {
+	if f.Status().Mode == ModePrimary {
+		return f.primary.Health(ctx)
+	}
+	return f.fallback.Health(ctx)
+}
+
+// Close stops health checks and closes both stores
+func (f *FailoverStore) Close() error {
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+
+	err := f.primary.Close()
+	if fallbackErr := f.fallback.Close(); err == nil {
+		err = fallbackErr
+	}
+	return err
+}
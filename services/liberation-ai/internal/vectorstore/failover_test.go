@@ -0,0 +1,299 @@
+package vectorstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"liberation-ai/pkg/types"
+)
+
+// flakyStore is a memory store whose health checks, and optionally writes, fail on demand.
+// It logs the writes it applies, in order.
+type flakyStore struct {
+	*MemoryVectorStore
+
+	mu         sync.Mutex
+	down       error
+	writesFail error
+	writes     []string
+}
+
+func newFlakyStore() *flakyStore {
+	return &flakyStore{MemoryVectorStore: NewMemoryVectorStore(3)}
+}
+
+func (s *flakyStore) fail(down, writes error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down, s.writesFail = down, writes
+}
+
+func (s *flakyStore) log(write string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writesFail != nil {
+		return s.writesFail
+	}
+	s.writes = append(s.writes, write)
+	return nil
+}
+
+func (s *flakyStore) applied() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.writes)
+}
+
+func (s *flakyStore) Health(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.down
+}
+
+func (s *flakyStore) Store(ctx context.Context, req *types.StoreRequest) (*types.StoreResponse, error) {
+	ids := make([]string, len(req.Vectors))
+	for i, vector := range req.Vectors {
+		ids[i] = vector.ID
+	}
+	if err := s.log("store " + strings.Join(ids, ",")); err != nil {
+		return nil, err
+	}
+	return s.MemoryVectorStore.Store(ctx, req)
+}
+
+func (s *flakyStore) Delete(ctx context.Context, namespace string, ids []string) error {
+	if err := s.log("delete " + strings.Join(ids, ",")); err != nil {
+		return err
+	}
+	return s.MemoryVectorStore.Delete(ctx, namespace, ids)
+}
+
+func (s *flakyStore) UpdateMetadata(ctx context.Context, update *types.MetadataUpdate) (*types.MetadataUpdateResponse, error) {
+	if err := s.log("metadata " + strings.Join(update.IDs, ",")); err != nil {
+		return nil, err
+	}
+	return s.MemoryVectorStore.UpdateMetadata(ctx, update)
+}
+
+func testFailover(fallbackType string, maxQueued int) (*FailoverStore, *flakyStore, *MemoryVectorStore) {
+	config := DefaultFailoverConfig()
+	config.Enabled = true
+	config.Type = fallbackType
+	config.FailureThreshold = 2
+	config.MaxQueuedWrites = maxQueued
+	primary, fallback := newFlakyStore(), NewMemoryVectorStore(3)
+	return NewFailoverStore(primary, fallback, config), primary, fallback
+}
+
+func storeRequest(ids ...string) *types.StoreRequest {
+	req := &types.StoreRequest{Namespace: "docs"}
+	for _, id := range ids {
+		req.Vectors = append(req.Vectors, types.Vector{ID: id, Embedding: []float32{1, 0, 0}, Metadata: map[string]interface{}{"tag": "new"}})
+	}
+	return req
+}
+
+// degrade fails the primary's health checks until the store fails over
+func degrade(t *testing.T, f *FailoverStore, primary *flakyStore) {
+	t.Helper()
+	primary.fail(errors.New("connection refused"), nil)
+	for i := 0; i < f.config.FailureThreshold; i++ {
+		f.check(context.Background())
+	}
+	if mode := f.Status().Mode; mode != ModeDegraded {
+		t.Fatalf("mode %s after %d failed checks, want degraded", mode, f.config.FailureThreshold)
+	}
+}
+
+func TestFailoverConfigValidate(t *testing.T) {
+	valid := DefaultFailoverConfig()
+	valid.Enabled = true
+	for _, tc := range []struct {
+		name   string
+		change func(c *FailoverConfig)
+		ok     bool
+	}{
+		{"default", func(c *FailoverConfig) {}, true},
+		{"disabled is never checked", func(c *FailoverConfig) { c.Enabled, c.Type = false, "mystery" }, true},
+		{"replica with url", func(c *FailoverConfig) { c.Type, c.ConnectionURL = FallbackPostgres, "postgres://replica/ai" }, true},
+		{"replica without url", func(c *FailoverConfig) { c.Type = FallbackPostgres }, false},
+		{"unknown type", func(c *FailoverConfig) { c.Type = "qdrant" }, false},
+		{"no threshold", func(c *FailoverConfig) { c.FailureThreshold = 0 }, false},
+		{"no queue", func(c *FailoverConfig) { c.MaxQueuedWrites = 0 }, false},
+	} {
+		config := valid
+		tc.change(&config)
+		if err := config.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: Validate() = %v", tc.name, err)
+		}
+	}
+}
+
+func TestFailoverAfterThreshold(t *testing.T) {
+	f, primary, _ := testFailover(FallbackMemory, 10)
+	ctx := context.Background()
+	if _, err := f.Store(ctx, storeRequest("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	primary.fail(errors.New("connection refused"), nil)
+	f.check(ctx)
+	if status := f.Status(); status.Mode != ModePrimary || status.LastError != "connection refused" {
+		t.Fatalf("one failed check: %+v, want still primary with the error noted", status)
+	}
+	f.check(ctx)
+	status := f.Status()
+	if status.Mode != ModeDegraded || status.Failovers != 1 || status.Fallback != FallbackMemory {
+		t.Fatalf("after the threshold: %+v", status)
+	}
+
+	// The embedded copy, kept in step before the outage, serves reads
+	if _, err := f.Get(ctx, "docs", "before"); err != nil {
+		t.Errorf("reading from the fallback: %v", err)
+	}
+	if err := f.Health(ctx); err != nil {
+		t.Errorf("a healthy fallback keeps the store healthy: %v", err)
+	}
+	if _, err := f.Migrate(ctx, NewMemoryVectorStore(3)); !errors.Is(err, types.ErrStoreDegraded) {
+		t.Errorf("Migrate while degraded: %v", err)
+	}
+	if _, err := f.Clone(ctx, "docs", "copy", nil); !errors.Is(err, types.ErrStoreDegraded) {
+		t.Errorf("Clone while degraded: %v", err)
+	}
+}
+
+func TestQueuedWritesReplayInOrder(t *testing.T) {
+	f, primary, fallback := testFailover(FallbackMemory, 10)
+	ctx := context.Background()
+	if _, err := f.Store(ctx, storeRequest("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	degrade(t, f, primary)
+
+	if _, err := f.Store(ctx, storeRequest("c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.UpdateMetadata(ctx, &types.MetadataUpdate{Namespace: "docs", IDs: []string{"a", "c"}, Metadata: map[string]interface{}{"tag": "edited"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Delete(ctx, "docs", []string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Store(ctx, storeRequest("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.UpdateMetadata(ctx, &types.MetadataUpdate{Namespace: "docs", Metadata: map[string]interface{}{}}); err == nil {
+		t.Error("an invalid update was queued")
+	}
+
+	if status := f.Status(); status.QueuedWrites != 4 {
+		t.Errorf("%d writes queued, want 4", status.QueuedWrites)
+	}
+	if applied := primary.applied(); len(applied) != 1 {
+		t.Errorf("the primary took writes while down: %v", applied)
+	}
+	// Searches see the writes at once through the embedded copy
+	if vector, err := fallback.Get(ctx, "docs", "c"); err != nil || vector.Metadata["tag"] != "edited" {
+		t.Errorf("fallback has %+v, %v", vector, err)
+	}
+
+	primary.fail(nil, nil)
+	f.check(ctx)
+	want := []string{"store a,b", "store c", "metadata a,c", "delete b", "store b"}
+	if applied := primary.applied(); !slices.Equal(applied, want) {
+		t.Errorf("replayed %v, want %v", applied, want)
+	}
+	status := f.Status()
+	if status.Mode != ModePrimary || status.QueuedWrites != 0 || status.LastError != "" {
+		t.Errorf("after recovery: %+v", status)
+	}
+	for id, tag := range map[string]string{"a": "edited", "b": "new", "c": "edited"} {
+		vector, err := primary.MemoryVectorStore.Get(ctx, "docs", id)
+		if err != nil || vector.Metadata["tag"] != tag {
+			t.Errorf("primary has %s as %+v, %v; want tag %s", id, vector, err, tag)
+		}
+	}
+}
+
+func TestResyncStopsAtAFailedWrite(t *testing.T) {
+	f, primary, _ := testFailover(FallbackMemory, 10)
+	ctx := context.Background()
+	degrade(t, f, primary)
+	for _, id := range []string{"a", "b"} {
+		if _, err := f.Store(ctx, storeRequest(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Healthy again, but writes still fail: nothing is lost and reads stay on the fallback
+	primary.fail(nil, errors.New("disk full"))
+	f.check(ctx)
+	status := f.Status()
+	if status.Mode != ModeDegraded || status.QueuedWrites != 2 || !strings.Contains(status.LastError, "resync: disk full") {
+		t.Fatalf("after a failed replay: %+v", status)
+	}
+
+	primary.fail(nil, nil)
+	f.check(ctx)
+	if applied := primary.applied(); !slices.Equal(applied, []string{"store a", "store b"}) {
+		t.Errorf("replayed %v", applied)
+	}
+	if status := f.Status(); status.Mode != ModePrimary || status.Failovers != 1 {
+		t.Errorf("after recovery: %+v", status)
+	}
+}
+
+func TestWriteQueueIsBounded(t *testing.T) {
+	f, primary, _ := testFailover(FallbackMemory, 2)
+	ctx := context.Background()
+	degrade(t, f, primary)
+	for i := 0; i < 2; i++ {
+		if _, err := f.Store(ctx, storeRequest(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.Store(ctx, storeRequest("overflow")); !errors.Is(err, types.ErrWriteQueueFull) {
+		t.Errorf("Store with a full queue: %v", err)
+	}
+	if err := f.Delete(ctx, "docs", []string{"0"}); !errors.Is(err, types.ErrWriteQueueFull) {
+		t.Errorf("Delete with a full queue: %v", err)
+	}
+	if _, err := f.Get(ctx, "docs", "overflow"); err == nil {
+		t.Error("a refused write reached the fallback")
+	}
+}
+
+func TestReplicaFallbackOnlyQueues(t *testing.T) {
+	f, primary, fallback := testFailover(FallbackPostgres, 10)
+	ctx := context.Background()
+	if _, err := f.Store(ctx, storeRequest("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fallback.Get(ctx, "docs", "a"); err == nil {
+		t.Error("writes were mirrored to a replica, which catches up by replication")
+	}
+	degrade(t, f, primary)
+
+	response, err := f.Store(ctx, storeRequest("b"))
+	if err != nil || response.Store != "queued" || response.Stored != 1 {
+		t.Errorf("Store while degraded: %+v, %v", response, err)
+	}
+	update, err := f.UpdateMetadata(ctx, &types.MetadataUpdate{Namespace: "docs", IDs: []string{"a"}, Metadata: map[string]interface{}{"tag": "x"}})
+	if err != nil || update.Store != "queued" {
+		t.Errorf("UpdateMetadata while degraded: %+v, %v", update, err)
+	}
+	if _, err := fallback.Get(ctx, "docs", "b"); err == nil {
+		t.Error("a queued write reached the replica")
+	}
+
+	primary.fail(nil, nil)
+	f.check(ctx)
+	if applied := primary.applied(); !slices.Equal(applied, []string{"store a", "store b", "metadata a"}) {
+		t.Errorf("replayed %v", applied)
+	}
+}
@@ -74,7 +74,7 @@ func (m *MemoryVectorStore) Search(ctx context.Context, req *types.SearchRequest
 	defer m.mu.RUnlock()
 
 	if len(req.Embedding) != m.dimensions {
-		return nil, fmt.Errorf("query dimension mismatch: expected %d, got %d", m.dimensions, len(req.Embedding))
+		return nil, &types.DimensionError{Expected: m.dimensions, Got: len(req.Embedding), Query: true}
 	}
 
 	namespace := m.vectors[req.Namespace]
@@ -99,22 +99,8 @@ func (m *MemoryVectorStore) Search(ctx context.Context, req *types.SearchRequest
 		}
 
 		// Apply metadata filters
-		if len(req.Filters) > 0 {
-			matches := true
-			for key, value := range req.Filters {
-				if vector.Metadata == nil {
-					matches = false
-					break
-				}
-				vectorValue, exists := vector.Metadata[key]
-				if !exists || fmt.Sprintf("%v", vectorValue) != fmt.Sprintf("%v", value) {
-					matches = false
-					break
-				}
-			}
-			if !matches {
-				continue
-			}
+		if !types.MatchesFilters(vector.Metadata, req.Filters) {
+			continue
 		}
 
 		result := types.SearchResult{
@@ -150,12 +136,12 @@ func (m *MemoryVectorStore) Get(ctx context.Context, namespace string, id string
 
 	namespaceVectors := m.vectors[namespace]
 	if namespaceVectors == nil {
-		return nil, fmt.Errorf("vector not found: %s/%s", namespace, id)
+		return nil, types.VectorNotFound(namespace, id)
 	}
 
 	vector := namespaceVectors[id]
 	if vector == nil {
-		return nil, fmt.Errorf("vector not found: %s/%s", namespace, id)
+		return nil, types.VectorNotFound(namespace, id)
 	}
 
 	// Return a copy
@@ -163,6 +149,43 @@ func (m *MemoryVectorStore) Get(ctx context.Context, namespace string, id string
 	return &vectorCopy, nil
 }
 
+// UpdateMetadata implements VectorStore.UpdateMetadata. Each updated vector gets a new
+// metadata map, so copies handed out by Get and Search are not changed underneath callers.
+func (m *MemoryVectorStore) UpdateMetadata(ctx context.Context, update *types.MetadataUpdate) (*types.MetadataUpdateResponse, error) {
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	namespaceVectors := m.vectors[update.Namespace]
+	var selected []*types.Vector
+	if len(update.IDs) > 0 {
+		for _, id := range update.IDs {
+			if vector := namespaceVectors[id]; vector != nil {
+				selected = append(selected, vector)
+			}
+		}
+	} else {
+		for _, vector := range namespaceVectors {
+			if types.MatchesFilters(vector.Metadata, update.Filters) {
+				selected = append(selected, vector)
+			}
+		}
+	}
+
+	for _, vector := range selected {
+		vector.Metadata = update.Apply(vector.Metadata)
+	}
+
+	return &types.MetadataUpdateResponse{
+		Updated:        int64(len(selected)),
+		ProcessingTime: time.Since(start).Milliseconds(),
+		Store:          "memory",
+	}, nil
+}
+
 // Delete implements VectorStore.Delete
 func (m *MemoryVectorStore) Delete(ctx context.Context, namespace string, ids []string) error {
 	m.mu.Lock()
@@ -230,6 +253,52 @@ func (m *MemoryVectorStore) Stats(ctx context.Context) (*types.VectorStoreStats,
 	}, nil
 }
 
+// List implements VectorStore.List
+func (m *MemoryVectorStore) List(ctx context.Context, namespace, after string, limit int) ([]types.Vector, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.vectors[namespace]))
+	for id := range m.vectors[namespace] {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	vectors := make([]types.Vector, 0, len(ids))
+	for _, id := range ids {
+		vectors = append(vectors, *m.vectors[namespace][id])
+	}
+	return vectors, nil
+}
+
+// Chunks implements VectorStore.Chunks
+func (m *MemoryVectorStore) Chunks(ctx context.Context, namespace, docID string, from, to int) ([]types.Vector, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var chunks []types.Vector
+	for _, vector := range m.vectors[namespace] {
+		if fmt.Sprintf("%v", vector.Metadata[types.MetadataDocID]) != docID {
+			continue
+		}
+		if index, ok := vector.ChunkIndex(); ok && index >= from && index <= to {
+			chunks = append(chunks, *vector)
+		}
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		a, _ := chunks[i].ChunkIndex()
+		b, _ := chunks[j].ChunkIndex()
+		return a < b
+	})
+	return chunks, nil
+}
+
 // Health implements VectorStore.Health
 func (m *MemoryVectorStore) Health(ctx context.Context) error {
 	// Memory store is always healthy if initialized
@@ -289,6 +358,50 @@ func (m *MemoryVectorStore) Migrate(ctx context.Context, destination types.Vecto
 	}, nil
 }
 
+// Clone implements VectorStore.Clone
+func (m *MemoryVectorStore) Clone(ctx context.Context, source, target string, progress func(copied, total int64)) (int64, error) {
+	// Holding the write lock for the whole copy gives a consistent snapshot
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vectors := m.vectors[source]
+	if len(vectors) == 0 {
+		return 0, types.ErrNamespaceNotFound
+	}
+	if len(m.vectors[target]) > 0 {
+		return 0, types.ErrNamespaceExists
+	}
+
+	const batchSize = 1000
+	total := int64(len(vectors))
+	clone := make(map[string]*types.Vector, len(vectors))
+
+	for id, vector := range vectors {
+		if len(clone)%batchSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+			if progress != nil && len(clone) > 0 {
+				progress(int64(len(clone)), total)
+			}
+		}
+
+		vectorCopy := *vector
+		vectorCopy.Namespace = target
+		vectorCopy.Metadata = make(map[string]interface{}, len(vector.Metadata))
+		for key, value := range vector.Metadata {
+			vectorCopy.Metadata[key] = value
+		}
+		clone[id] = &vectorCopy
+	}
+
+	m.vectors[target] = clone
+	if progress != nil {
+		progress(total, total)
+	}
+	return total, nil
+}
+
 // cosineSimilarity calculates cosine similarity between two vectors
 func (m *MemoryVectorStore) cosineSimilarity(a, b []float32) float64 {
 	if len(a) != len(b) {
@@ -2,8 +2,10 @@ package vectorstore
 
 import (
 	"context"
+	"crypto/sha1"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,14 +15,18 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"liberation-ai/pkg/types"
+	"liberation-dbpool"
 )
 
 // PostgresVectorStore implements VectorStore using PostgreSQL with pgvector
 type PostgresVectorStore struct {
-	db         *sql.DB
-	logger     *logrus.Logger
-	dimensions int
-	tableName  string
+	db            *sql.DB
+	logger        *logrus.Logger
+	dimensions    int
+	tableName     string
+	compressAbove int
+	// statements keeps the search, get and chunk queries prepared; nil runs them directly
+	statements *dbpool.StatementCache
 }
 
 // NewPostgresVectorStore creates a new PostgreSQL vector store
@@ -31,10 +37,11 @@ func NewPostgresVectorStore(connectionURL string, dimensions int, logger *logrus
 	}
 
 	store := &PostgresVectorStore{
-		db:         db,
-		logger:     logger,
-		dimensions: dimensions,
-		tableName:  "vectors",
+		db:            db,
+		logger:        logger,
+		dimensions:    dimensions,
+		tableName:     "vectors",
+		compressAbove: DefaultCompressTextAbove,
 	}
 
 	// Initialize the store
@@ -45,6 +52,23 @@ func NewPostgresVectorStore(connectionURL string, dimensions int, logger *logrus
 	return store, nil
 }
 
+// NewPostgresReplica opens a read-only replica of a liberation-ai table. The schema is
+// left alone, since replicas reject DDL; writes to it fail.
+func NewPostgresReplica(connectionURL string, dimensions int, logger *logrus.Logger) (*PostgresVectorStore, error) {
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres replica: %w", err)
+	}
+
+	return &PostgresVectorStore{
+		db:            db,
+		logger:        logger,
+		dimensions:    dimensions,
+		tableName:     "vectors",
+		compressAbove: DefaultCompressTextAbove,
+	}, nil
+}
+
 // initialize sets up the database schema and extensions
 func (p *PostgresVectorStore) initialize() error {
 	ctx := context.Background()
@@ -57,11 +81,14 @@ func (p *PostgresVectorStore) initialize() error {
 	// Create vectors table
 	createTableSQL := fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS %s (
-			id TEXT PRIMARY KEY,
+			id TEXT NOT NULL,
 			namespace TEXT NOT NULL,
 			embedding vector(%d) NOT NULL,
 			metadata JSONB,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			text BYTEA,
+			text_compressed BOOLEAN NOT NULL DEFAULT FALSE,
+			PRIMARY KEY (namespace, id)
 		)
 	`, p.tableName, p.dimensions)
 
@@ -69,11 +96,26 @@ func (p *PostgresVectorStore) initialize() error {
 		return fmt.Errorf("failed to create vectors table: %w", err)
 	}
 
+	// Tables created before chunk text was stored alongside vectors
+	addTextSQL := fmt.Sprintf(`
+		ALTER TABLE %s
+			ADD COLUMN IF NOT EXISTS text BYTEA,
+			ADD COLUMN IF NOT EXISTS text_compressed BOOLEAN NOT NULL DEFAULT FALSE
+	`, p.tableName)
+	if _, err := p.db.ExecContext(ctx, addTextSQL); err != nil {
+		return fmt.Errorf("failed to add text columns: %w", err)
+	}
+
+	if err := p.ensureNamespacedPrimaryKey(ctx); err != nil {
+		return err
+	}
+
 	// Create indexes for performance
 	indexes := []string{
 		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_namespace ON %s (namespace)", p.tableName, p.tableName),
 		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_embedding ON %s USING ivfflat (embedding vector_cosine_ops) WITH (lists = 100)", p.tableName, p.tableName),
 		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_metadata ON %s USING GIN (metadata)", p.tableName, p.tableName),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_doc_id ON %s (namespace, (metadata->>'doc_id'))", p.tableName, p.tableName),
 	}
 
 	for _, indexSQL := range indexes {
@@ -87,6 +129,72 @@ func (p *PostgresVectorStore) initialize() error {
 	return nil
 }
 
+// SetTextCompression gzips chunk text longer than above bytes; 0 stores all text uncompressed.
+// It only affects vectors stored afterwards.
+func (p *PostgresVectorStore) SetTextCompression(above int) {
+	p.compressAbove = above
+}
+
+// SetPool sizes the store's connection pool and prepares its hot queries, returning a
+// monitor of the pool under name
+func (p *PostgresVectorStore) SetPool(name string, config dbpool.Config) *dbpool.Monitor {
+	config.Apply(p.db)
+	if config.StatementCacheSize > 0 {
+		p.statements = dbpool.NewStatementCache(p.db, config.StatementCacheSize)
+	}
+	return dbpool.NewMonitor(name, p.db, config, p.statements)
+}
+
+// hotQueries runs a query through the statement cache when there is one
+func (p *PostgresVectorStore) hotQueries() interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+} {
+	if p.statements != nil {
+		return p.statements
+	}
+	return p.db
+}
+
+// readText decodes a row's chunk text. Rows stored before text had its own column keep it
+// in metadata.
+func (p *PostgresVectorStore) readText(id string, data []byte, compressed bool, metadata map[string]interface{}) string {
+	if data == nil {
+		text, _ := metadata["text"].(string)
+		return text
+	}
+	text, err := decodeText(data, compressed)
+	if err != nil {
+		p.logger.Errorf("Failed to decompress text for vector %s: %v", id, err)
+	}
+	return text
+}
+
+// ensureNamespacedPrimaryKey upgrades tables created with a primary key on id alone,
+// which let the same document ID in two namespaces overwrite each other
+func (p *PostgresVectorStore) ensureNamespacedPrimaryKey(ctx context.Context) error {
+	var columns int
+	countSQL := `
+		SELECT COUNT(*)
+		FROM information_schema.key_column_usage
+		WHERE table_name = $1 AND constraint_name = $1 || '_pkey'
+	`
+	if err := p.db.QueryRowContext(ctx, countSQL, p.tableName).Scan(&columns); err != nil {
+		return fmt.Errorf("failed to inspect primary key: %w", err)
+	}
+	if columns != 1 {
+		return nil
+	}
+
+	alterSQL := fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s_pkey, ADD PRIMARY KEY (namespace, id)", p.tableName, p.tableName)
+	if _, err := p.db.ExecContext(ctx, alterSQL); err != nil {
+		return fmt.Errorf("failed to upgrade primary key to (namespace, id): %w", err)
+	}
+
+	p.logger.Info("Upgraded vectors primary key to (namespace, id)")
+	return nil
+}
+
 // ensurePgvectorExtension checks and enables the pgvector extension
 func (p *PostgresVectorStore) ensurePgvectorExtension(ctx context.Context) error {
 	// Check if extension exists
@@ -124,7 +232,7 @@ func (p *PostgresVectorStore) Store(ctx context.Context, req *types.StoreRequest
 	// Validate dimensions
 	for _, vector := range req.Vectors {
 		if len(vector.Embedding) != p.dimensions {
-			return nil, fmt.Errorf("vector dimension mismatch: expected %d, got %d", p.dimensions, len(vector.Embedding))
+			return nil, &types.DimensionError{Expected: p.dimensions, Got: len(vector.Embedding)}
 		}
 	}
 
@@ -136,12 +244,14 @@ func (p *PostgresVectorStore) Store(ctx context.Context, req *types.StoreRequest
 	defer tx.Rollback()
 
 	insertSQL := fmt.Sprintf(`
-		INSERT INTO %s (id, namespace, embedding, metadata, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT (id) DO UPDATE SET
+		INSERT INTO %s (id, namespace, embedding, metadata, created_at, text, text_compressed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (namespace, id) DO UPDATE SET
 			embedding = EXCLUDED.embedding,
 			metadata = EXCLUDED.metadata,
-			created_at = EXCLUDED.created_at
+			created_at = EXCLUDED.created_at,
+			text = EXCLUDED.text,
+			text_compressed = EXCLUDED.text_compressed
 	`, p.tableName)
 
 	stmt, err := tx.PrepareContext(ctx, insertSQL)
@@ -161,8 +271,18 @@ func (p *PostgresVectorStore) Store(ctx context.Context, req *types.StoreRequest
 			continue
 		}
 
+		text, compressed, err := encodeText(vector.Text, p.compressAbove)
+		if err != nil {
+			p.logger.Errorf("Failed to compress text for vector %s: %v", vector.ID, err)
+			failed++
+			continue
+		}
+
 		pgVector := pgvector.NewVector(vector.Embedding)
-		_, err = stmt.ExecContext(ctx, vector.ID, req.Namespace, pgVector, metadataJSON, vector.CreatedAt)
+		_, err = stmt.ExecContext(ctx, vector.ID, req.Namespace, pgVector, metadataJSON, vector.CreatedAt, text, compressed)
+		if err := storageError(err); errors.Is(err, types.ErrQuotaExceeded) {
+			return nil, fmt.Errorf("failed to insert vector %s: %w", vector.ID, err)
+		}
 		if err != nil {
 			p.logger.Errorf("Failed to insert vector %s: %v", vector.ID, err)
 			failed++
@@ -172,7 +292,7 @@ func (p *PostgresVectorStore) Store(ctx context.Context, req *types.StoreRequest
 	}
 
 	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", storageError(err))
 	}
 
 	return &types.StoreResponse{
@@ -189,22 +309,16 @@ func (p *PostgresVectorStore) Search(ctx context.Context, req *types.SearchReque
 	start := time.Now()
 
 	if len(req.Embedding) != p.dimensions {
-		return nil, fmt.Errorf("query dimension mismatch: expected %d, got %d", p.dimensions, len(req.Embedding))
+		return nil, &types.DimensionError{Expected: p.dimensions, Got: len(req.Embedding), Query: true}
 	}
 
 	// Build the search query with filters
 	whereClause := "WHERE namespace = $1"
 	args := []interface{}{req.Namespace, pgvector.NewVector(req.Embedding)}
-	argIndex := 3
 
 	// Add metadata filters
-	if len(req.Filters) > 0 {
-		for key, value := range req.Filters {
-			whereClause += fmt.Sprintf(" AND metadata->>'%s' = $%d", key, argIndex)
-			args = append(args, value)
-			argIndex++
-		}
-	}
+	whereClause, args = filterClause(whereClause, args, req.Filters)
+	argIndex := len(args) + 1
 
 	// Add similarity threshold
 	if req.Threshold > 0 {
@@ -214,7 +328,7 @@ func (p *PostgresVectorStore) Search(ctx context.Context, req *types.SearchReque
 	}
 
 	searchSQL := fmt.Sprintf(`
-		SELECT id, embedding, metadata, created_at, (1 - (embedding <=> $2)) as similarity
+		SELECT id, embedding, metadata, created_at, text, text_compressed, (1 - (embedding <=> $2)) as similarity
 		FROM %s
 		%s
 		ORDER BY embedding <=> $2
@@ -223,7 +337,7 @@ func (p *PostgresVectorStore) Search(ctx context.Context, req *types.SearchReque
 
 	args = append(args, req.Limit)
 
-	rows, err := p.db.QueryContext(ctx, searchSQL, args...)
+	rows, err := p.hotQueries().QueryContext(ctx, searchSQL, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute search query: %w", err)
 	}
@@ -236,10 +350,12 @@ func (p *PostgresVectorStore) Search(ctx context.Context, req *types.SearchReque
 			embedding    pgvector.Vector
 			metadataJSON []byte
 			createdAt    time.Time
+			text         []byte
+			compressed   bool
 			similarity   float64
 		)
 
-		if err := rows.Scan(&id, &embedding, &metadataJSON, &createdAt, &similarity); err != nil {
+		if err := rows.Scan(&id, &embedding, &metadataJSON, &createdAt, &text, &compressed, &similarity); err != nil {
 			p.logger.Errorf("Failed to scan search result: %v", err)
 			continue
 		}
@@ -256,6 +372,7 @@ func (p *PostgresVectorStore) Search(ctx context.Context, req *types.SearchReque
 			Metadata:  metadata,
 			Namespace: req.Namespace,
 			CreatedAt: createdAt,
+			Text:      p.readText(id, text, compressed, metadata),
 		}
 
 		result := types.SearchResult{
@@ -290,10 +407,123 @@ func (p *PostgresVectorStore) Delete(ctx context.Context, namespace string, ids
 	return nil
 }
 
+// metadataText is the expression filters compare a metadata field's text with
+func metadataText(field string) string {
+	return fmt.Sprintf("(metadata->>%s)", pq.QuoteLiteral(field))
+}
+
+// metadataNumber is the expression numeric filters compare a metadata field with. Values
+// that are not numbers are NULL rather than an error, so vectors stored before a field was
+// declared a number are skipped instead of failing the query.
+func metadataNumber(field string) string {
+	key := pq.QuoteLiteral(field)
+	return fmt.Sprintf("(CASE WHEN jsonb_typeof(metadata->%s) = 'number' THEN (metadata->>%s)::numeric END)", key, key)
+}
+
+// filterClause adds a condition per metadata filter to where, and its values to args. The
+// conditions use metadataText and metadataNumber, which declared fields are indexed on.
+func filterClause(where string, args []interface{}, filters map[string]interface{}) (string, []interface{}) {
+	for key, value := range filters {
+		numbers, ok := value.(types.NumberFilter)
+		if !ok {
+			args = append(args, fmt.Sprintf("%v", value))
+			where += fmt.Sprintf(" AND %s = $%d", metadataText(key), len(args))
+			continue
+		}
+		bounds := numbers.Bounds()
+		if len(bounds) == 0 {
+			where += fmt.Sprintf(" AND %s IS NOT NULL", metadataNumber(key))
+		}
+		for _, bound := range bounds {
+			args = append(args, bound.Value)
+			where += fmt.Sprintf(" AND %s %s $%d", metadataNumber(key), bound.Op, len(args))
+		}
+	}
+	return where, args
+}
+
+// IndexMetadata implements types.MetadataIndexer with an index on the namespace and the
+// field's filter expression. Namespaces declaring the same field with the same type share
+// it. It is built concurrently, so writes carry on while it builds.
+func (p *PostgresVectorStore) IndexMetadata(ctx context.Context, field string, fieldType types.FieldType) error {
+	expression := metadataText(field)
+	if fieldType == types.FieldNumber {
+		expression = metadataNumber(field)
+	}
+	sum := sha1.Sum([]byte(string(fieldType) + ":" + field))
+	name := fmt.Sprintf("idx_%s_meta_%x", p.tableName, sum[:8])
+
+	indexSQL := fmt.Sprintf("CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s (namespace, %s)", name, p.tableName, expression)
+	if _, err := p.db.ExecContext(ctx, indexSQL); err != nil {
+		return fmt.Errorf("failed to index metadata field %s: %w", field, err)
+	}
+	return nil
+}
+
+// storageError marks a write refused because the database is out of disk space, so callers
+// can tell it from other failures with errors.Is(err, types.ErrQuotaExceeded)
+func storageError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "53100" {
+		return fmt.Errorf("%w: %w", types.ErrQuotaExceeded, err)
+	}
+	return err
+}
+
+// UpdateMetadata implements VectorStore.UpdateMetadata with a single JSONB update, so
+// embeddings and chunk text are never read or rewritten
+func (p *PostgresVectorStore) UpdateMetadata(ctx context.Context, update *types.MetadataUpdate) (*types.MetadataUpdateResponse, error) {
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	// Merging drops keys patched to null; replacing keeps the chunk bookkeeping
+	values := make(map[string]interface{}, len(update.Metadata))
+	var removed []string
+	for key, value := range update.Metadata {
+		if value == nil && !update.Replace {
+			removed = append(removed, key)
+			continue
+		}
+		values[key] = value
+	}
+	patch, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	args := []interface{}{update.Namespace, string(patch)}
+	setClause := fmt.Sprintf("metadata = $2::jsonb || jsonb_strip_nulls(jsonb_build_object('%s', metadata->'%s', '%s', metadata->'%s'))",
+		types.MetadataDocID, types.MetadataDocID, types.MetadataChunkIndex, types.MetadataChunkIndex)
+	if !update.Replace {
+		setClause = "metadata = (COALESCE(metadata, '{}'::jsonb) || $2::jsonb) - $3::text[]"
+		args = append(args, pq.Array(removed))
+	}
+
+	whereClause := "WHERE namespace = $1"
+	if len(update.IDs) > 0 {
+		whereClause += fmt.Sprintf(" AND id = ANY($%d)", len(args)+1)
+		args = append(args, pq.Array(update.IDs))
+	}
+	whereClause, args = filterClause(whereClause, args, update.Filters)
+
+	result, err := p.db.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s %s", p.tableName, setClause, whereClause), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update metadata: %w", storageError(err))
+	}
+	updated, _ := result.RowsAffected()
+
+	return &types.MetadataUpdateResponse{
+		Updated:        updated,
+		ProcessingTime: time.Since(start).Milliseconds(),
+		Store:          "postgres",
+	}, nil
+}
+
 // Get implements VectorStore.Get
 func (p *PostgresVectorStore) Get(ctx context.Context, namespace string, id string) (*types.Vector, error) {
 	getSQL := fmt.Sprintf(`
-		SELECT id, embedding, metadata, created_at
+		SELECT id, embedding, metadata, created_at, text, text_compressed
 		FROM %s
 		WHERE namespace = $1 AND id = $2
 	`, p.tableName)
@@ -303,12 +533,14 @@ func (p *PostgresVectorStore) Get(ctx context.Context, namespace string, id stri
 		embedding    pgvector.Vector
 		metadataJSON []byte
 		createdAt    time.Time
+		text         []byte
+		compressed   bool
 	)
 
-	err := p.db.QueryRowContext(ctx, getSQL, namespace, id).Scan(&vectorID, &embedding, &metadataJSON, &createdAt)
+	err := p.hotQueries().QueryRowContext(ctx, getSQL, namespace, id).Scan(&vectorID, &embedding, &metadataJSON, &createdAt, &text, &compressed)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("vector not found: %s/%s", namespace, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, types.VectorNotFound(namespace, id)
 		}
 		return nil, fmt.Errorf("failed to get vector: %w", err)
 	}
@@ -325,6 +557,7 @@ func (p *PostgresVectorStore) Get(ctx context.Context, namespace string, id stri
 		Metadata:  metadata,
 		Namespace: namespace,
 		CreatedAt: createdAt,
+		Text:      p.readText(vectorID, text, compressed, metadata),
 	}, nil
 }
 
@@ -402,6 +635,112 @@ func (p *PostgresVectorStore) Stats(ctx context.Context) (*types.VectorStoreStat
 	}, nil
 }
 
+// List implements VectorStore.List, paging on the (namespace, id) primary key
+func (p *PostgresVectorStore) List(ctx context.Context, namespace, after string, limit int) ([]types.Vector, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	listSQL := fmt.Sprintf(`
+		SELECT id, embedding, metadata, created_at, text, text_compressed
+		FROM %s
+		WHERE namespace = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3
+	`, p.tableName)
+
+	rows, err := p.db.QueryContext(ctx, listSQL, namespace, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vectors: %w", err)
+	}
+	defer rows.Close()
+
+	var vectors []types.Vector
+	for rows.Next() {
+		var (
+			id           string
+			embedding    pgvector.Vector
+			metadataJSON []byte
+			createdAt    time.Time
+			text         []byte
+			compressed   bool
+		)
+
+		if err := rows.Scan(&id, &embedding, &metadataJSON, &createdAt, &text, &compressed); err != nil {
+			return nil, fmt.Errorf("failed to scan vector: %w", err)
+		}
+
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
+			metadata = make(map[string]interface{})
+		}
+
+		vectors = append(vectors, types.Vector{
+			ID:        id,
+			Embedding: embedding.Slice(),
+			Metadata:  metadata,
+			Namespace: namespace,
+			CreatedAt: createdAt,
+			Text:      p.readText(id, text, compressed, metadata),
+		})
+	}
+
+	return vectors, rows.Err()
+}
+
+// Chunks implements VectorStore.Chunks
+func (p *PostgresVectorStore) Chunks(ctx context.Context, namespace, docID string, from, to int) ([]types.Vector, error) {
+	// The CASE guards the cast, so rows with a non-numeric chunk_index are skipped rather than failing the query
+	chunksSQL := fmt.Sprintf(`
+		SELECT id, embedding, metadata, created_at, text, text_compressed
+		FROM (
+			SELECT *, CASE WHEN metadata->>'chunk_index' ~ '^-?[0-9]{1,9}$'
+				THEN (metadata->>'chunk_index')::int END AS chunk_index
+			FROM %s
+			WHERE namespace = $1 AND metadata->>'doc_id' = $2
+		) chunks
+		WHERE chunk_index BETWEEN $3 AND $4
+		ORDER BY chunk_index
+	`, p.tableName)
+
+	rows, err := p.hotQueries().QueryContext(ctx, chunksSQL, namespace, docID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []types.Vector
+	for rows.Next() {
+		var (
+			id           string
+			embedding    pgvector.Vector
+			metadataJSON []byte
+			createdAt    time.Time
+			text         []byte
+			compressed   bool
+		)
+
+		if err := rows.Scan(&id, &embedding, &metadataJSON, &createdAt, &text, &compressed); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk: %w", err)
+		}
+
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
+			metadata = make(map[string]interface{})
+		}
+
+		chunks = append(chunks, types.Vector{
+			ID:        id,
+			Embedding: embedding.Slice(),
+			Metadata:  metadata,
+			Namespace: namespace,
+			CreatedAt: createdAt,
+			Text:      p.readText(id, text, compressed, metadata),
+		})
+	}
+
+	return chunks, rows.Err()
+}
+
 // Health implements VectorStore.Health
 func (p *PostgresVectorStore) Health(ctx context.Context) error {
 	return p.db.PingContext(ctx)
@@ -409,9 +748,83 @@ func (p *PostgresVectorStore) Health(ctx context.Context) error {
 
 // Close implements VectorStore.Close
 func (p *PostgresVectorStore) Close() error {
+	if p.statements != nil {
+		p.statements.Close()
+	}
 	return p.db.Close()
 }
 
+// Clone implements VectorStore.Clone.
+// Vectors are copied server-side with INSERT..SELECT in batches inside one REPEATABLE READ
+// transaction, so the copy reflects a single snapshot and appears atomically on commit.
+func (p *PostgresVectorStore) Clone(ctx context.Context, source, target string, progress func(copied, total int64)) (int64, error) {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var total int64
+	countSQL := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE namespace = $1", p.tableName)
+	if err := tx.QueryRowContext(ctx, countSQL, source).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count source vectors: %w", err)
+	}
+	if total == 0 {
+		return 0, types.ErrNamespaceNotFound
+	}
+
+	var exists bool
+	existsSQL := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE namespace = $1)", p.tableName)
+	if err := tx.QueryRowContext(ctx, existsSQL, target).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("failed to check target namespace: %w", err)
+	}
+	if exists {
+		return 0, types.ErrNamespaceExists
+	}
+
+	// Keyset pagination on id keeps each batch an index range scan
+	cloneSQL := fmt.Sprintf(`
+		WITH batch AS (
+			SELECT id, embedding, metadata, created_at, text, text_compressed
+			FROM %[1]s
+			WHERE namespace = $1 AND id > $3
+			ORDER BY id
+			LIMIT $4
+		), inserted AS (
+			INSERT INTO %[1]s (id, namespace, embedding, metadata, created_at, text, text_compressed)
+			SELECT id, $2, embedding, metadata, created_at, text, text_compressed FROM batch
+			RETURNING id
+		)
+		SELECT COUNT(*), COALESCE(MAX(id), '') FROM inserted
+	`, p.tableName)
+
+	const batchSize = 5000
+	var copied int64
+	lastID := ""
+
+	for {
+		var n int64
+		if err := tx.QueryRowContext(ctx, cloneSQL, source, target, lastID, batchSize).Scan(&n, &lastID); err != nil {
+			return copied, fmt.Errorf("failed to copy vectors: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+
+		copied += n
+		if progress != nil {
+			progress(copied, total)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit clone: %w", err)
+	}
+
+	p.logger.Infof("Cloned %d vectors from namespace %s to %s", copied, source, target)
+	return copied, nil
+}
+
 // Migrate implements VectorStore.Migrate
 func (p *PostgresVectorStore) Migrate(ctx context.Context, destination types.VectorStore) (*types.MigrationResult, error) {
 	start := time.Now()
@@ -450,7 +863,7 @@ func (p *PostgresVectorStore) Migrate(ctx context.Context, destination types.Vec
 func (p *PostgresVectorStore) migrateNamespace(ctx context.Context, namespace string, destination types.VectorStore) (int64, error) {
 	// Get all vectors in this namespace
 	selectSQL := fmt.Sprintf(`
-		SELECT id, embedding, metadata, created_at
+		SELECT id, embedding, metadata, created_at, text, text_compressed
 		FROM %s
 		WHERE namespace = $1
 		ORDER BY created_at
@@ -472,9 +885,11 @@ func (p *PostgresVectorStore) migrateNamespace(ctx context.Context, namespace st
 			embedding    pgvector.Vector
 			metadataJSON []byte
 			createdAt    time.Time
+			text         []byte
+			compressed   bool
 		)
 
-		if err := rows.Scan(&id, &embedding, &metadataJSON, &createdAt); err != nil {
+		if err := rows.Scan(&id, &embedding, &metadataJSON, &createdAt, &text, &compressed); err != nil {
 			return migrated, fmt.Errorf("failed to scan vector: %w", err)
 		}
 
@@ -489,6 +904,7 @@ func (p *PostgresVectorStore) migrateNamespace(ctx context.Context, namespace st
 			Metadata:  metadata,
 			Namespace: namespace,
 			CreatedAt: createdAt,
+			Text:      p.readText(id, text, compressed, metadata),
 		}
 
 		vectors = append(vectors, vector)
@@ -0,0 +1,53 @@
+package vectorstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// DefaultCompressTextAbove is the chunk size in bytes above which stores gzip chunk text
+const DefaultCompressTextAbove = 1024
+
+// encodeText gzips text longer than above bytes; above <= 0 never compresses.
+// Text that does not shrink is stored as is.
+func encodeText(text string, above int) ([]byte, bool, error) {
+	if text == "" {
+		return nil, false, nil
+	}
+	if above <= 0 || len(text) <= above {
+		return []byte(text), false, nil
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write([]byte(text)); err != nil {
+		return nil, false, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, false, err
+	}
+	if compressed.Len() >= len(text) {
+		return []byte(text), false, nil
+	}
+	return compressed.Bytes(), true, nil
+}
+
+// decodeText reverses encodeText
+func decodeText(data []byte, compressed bool) (string, error) {
+	if !compressed {
+		return string(data), nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
@@ -0,0 +1,40 @@
+package vectorstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeText(t *testing.T) {
+	repetitive := strings.Repeat("lend the ladder to the tool library ", 100)
+	for _, tc := range []struct {
+		name       string
+		text       string
+		above      int
+		compressed bool
+	}{
+		{"empty", "", 10, false},
+		{"short", "a ladder", 10, false},
+		{"at the limit", strings.Repeat("x", 10), 10, false},
+		{"compression off", repetitive, 0, false},
+		{"long", repetitive, DefaultCompressTextAbove, true},
+		// Gzip's header outweighs the savings on text this short and varied
+		{"does not shrink", "qwertyuiopasdfghjklzxcvbnm", 10, false},
+	} {
+		data, compressed, err := encodeText(tc.text, tc.above)
+		if err != nil || compressed != tc.compressed {
+			t.Errorf("%s: compressed %v, %v", tc.name, compressed, err)
+			continue
+		}
+		if compressed && len(data) >= len(tc.text) {
+			t.Errorf("%s: compressed to %d of %d bytes", tc.name, len(data), len(tc.text))
+		}
+		if text, err := decodeText(data, compressed); err != nil || text != tc.text {
+			t.Errorf("%s: decoded %q, %v", tc.name, text, err)
+		}
+	}
+
+	if _, err := decodeText([]byte("not gzip"), true); err == nil {
+		t.Error("decoded corrupt text")
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RFC 6750 bearer token error codes
+const (
+	BearerErrorInvalidRequest    = "invalid_request"
+	BearerErrorInvalidToken      = "invalid_token"
+	BearerErrorInsufficientScope = "insufficient_scope"
+)
+
+// DefaultRealm is advertised in WWW-Authenticate challenges when none is configured
+const DefaultRealm = "liberation-ai"
+
+// BearerChallenge describes an RFC 6750 WWW-Authenticate challenge
+type BearerChallenge struct {
+	Realm            string
+	Error            string
+	ErrorDescription string
+	Scope            string
+}
+
+// String renders the challenge as a WWW-Authenticate header value
+func (b BearerChallenge) String() string {
+	realm := b.Realm
+	if realm == "" {
+		realm = DefaultRealm
+	}
+
+	params := []string{"realm=" + quoteAuthParam(realm)}
+	if b.Error != "" {
+		params = append(params, "error="+quoteAuthParam(b.Error))
+		if b.ErrorDescription != "" {
+			params = append(params, "error_description="+quoteAuthParam(b.ErrorDescription))
+		}
+	}
+	if b.Scope != "" {
+		params = append(params, "scope="+quoteAuthParam(b.Scope))
+	}
+
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// Status returns the HTTP status RFC 6750 prescribes for the challenge's error code
+func (b BearerChallenge) Status() int {
+	switch b.Error {
+	case BearerErrorInvalidRequest:
+		return http.StatusBadRequest
+	case BearerErrorInsufficientScope:
+		return http.StatusForbidden
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// WriteBearerError sets the WWW-Authenticate header, writes a JSON error body and aborts the request
+func WriteBearerError(c *gin.Context, challenge BearerChallenge, provider string) {
+	c.Header("WWW-Authenticate", challenge.String())
+
+	code := challenge.Error
+	if code == "" {
+		code = "unauthorized"
+	}
+
+	body := gin.H{
+		"error":             code,
+		"error_description": challenge.ErrorDescription,
+		"message":           challenge.ErrorDescription,
+		"provider":          provider,
+	}
+	if challenge.Scope != "" {
+		body["scope"] = challenge.Scope
+	}
+
+	c.JSON(challenge.Status(), body)
+	c.Abort()
+}
+
+// ChallengeForError maps a token validation error to a bearer challenge with a denial reason
+func ChallengeForError(realm string, err error) BearerChallenge {
+	challenge := BearerChallenge{
+		Realm:            realm,
+		Error:            BearerErrorInvalidToken,
+		ErrorDescription: "the access token is invalid",
+	}
+
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		switch authErr.Code {
+		case ErrCodeExpiredToken:
+			challenge.ErrorDescription = "the access token expired"
+		case ErrCodeInsufficientPerm:
+			challenge.Error = BearerErrorInsufficientScope
+			challenge.ErrorDescription = authErr.Message
+		default:
+			challenge.ErrorDescription = authErr.Message
+		}
+		return challenge
+	}
+
+	return challenge
+}
+
+// quoteAuthParam renders a quoted-string, escaping backslashes and quotes
+func quoteAuthParam(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
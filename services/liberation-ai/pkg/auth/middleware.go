@@ -2,6 +2,7 @@ package auth
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,6 +11,7 @@ import (
 type AuthMiddleware struct {
 	provider AuthProvider
 	optional bool
+	realm    string
 }
 
 // NewAuthMiddleware creates a new auth middleware
@@ -17,9 +19,16 @@ func NewAuthMiddleware(provider AuthProvider, optional bool) *AuthMiddleware {
 	return &AuthMiddleware{
 		provider: provider,
 		optional: optional,
+		realm:    DefaultRealm,
 	}
 }
 
+// WithRealm sets the realm advertised in WWW-Authenticate challenges
+func (m *AuthMiddleware) WithRealm(realm string) *AuthMiddleware {
+	m.realm = realm
+	return m
+}
+
 // RequireAuth creates middleware that requires authentication
 func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return m.authHandler(false)
@@ -40,7 +49,19 @@ func (m *AuthMiddleware) authHandler(optional bool) gin.HandlerFunc {
 				c.Next()
 				return
 			}
-			m.unauthorizedResponse(c, "missing authorization header")
+			m.challenge(c, BearerChallenge{ErrorDescription: "missing authorization header"})
+			return
+		}
+
+		if !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
+			if optional {
+				c.Next()
+				return
+			}
+			m.challenge(c, BearerChallenge{
+				Error:            BearerErrorInvalidRequest,
+				ErrorDescription: "authorization header must use the Bearer scheme",
+			})
 			return
 		}
 
@@ -51,7 +72,7 @@ func (m *AuthMiddleware) authHandler(optional bool) gin.HandlerFunc {
 				c.Next()
 				return
 			}
-			m.unauthorizedResponse(c, err.Error())
+			m.challenge(c, ChallengeForError(m.realm, err))
 			return
 		}
 
@@ -70,7 +91,7 @@ func (m *AuthMiddleware) RequirePermission(resource Resource, action Action) gin
 		// Get auth context
 		authCtx, exists := m.getAuthContext(c)
 		if !exists {
-			m.forbiddenResponse(c, "authentication required")
+			m.challenge(c, BearerChallenge{ErrorDescription: "authentication required"})
 			return
 		}
 
@@ -100,7 +121,7 @@ func (m *AuthMiddleware) RequireRole(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authCtx, exists := m.getAuthContext(c)
 		if !exists {
-			m.forbiddenResponse(c, "authentication required")
+			m.challenge(c, BearerChallenge{ErrorDescription: "authentication required"})
 			return
 		}
 
@@ -132,7 +153,7 @@ func (m *AuthMiddleware) RequireScope(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authCtx, exists := m.getAuthContext(c)
 		if !exists {
-			m.forbiddenResponse(c, "authentication required")
+			m.challenge(c, BearerChallenge{ErrorDescription: "authentication required"})
 			return
 		}
 
@@ -151,7 +172,11 @@ func (m *AuthMiddleware) RequireScope(scopes ...string) gin.HandlerFunc {
 		}
 
 		if !hasScope {
-			m.forbiddenResponse(c, "insufficient scope")
+			m.challenge(c, BearerChallenge{
+				Error:            BearerErrorInsufficientScope,
+				ErrorDescription: "insufficient scope",
+				Scope:            strings.Join(scopes, " "),
+			})
 			return
 		}
 
@@ -197,13 +222,9 @@ func (m *AuthMiddleware) getAuthContext(c *gin.Context) (*AuthContext, bool) {
 	return GetAuthContext(c)
 }
 
-func (m *AuthMiddleware) unauthorizedResponse(c *gin.Context, message string) {
-	c.JSON(http.StatusUnauthorized, gin.H{
-		"error":    "unauthorized",
-		"message":  message,
-		"provider": m.provider.Name(),
-	})
-	c.Abort()
+func (m *AuthMiddleware) challenge(c *gin.Context, challenge BearerChallenge) {
+	challenge.Realm = m.realm
+	WriteBearerError(c, challenge, m.provider.Name())
 }
 
 func (m *AuthMiddleware) forbiddenResponse(c *gin.Context, message string) {
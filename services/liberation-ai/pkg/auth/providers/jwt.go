@@ -5,8 +5,10 @@ import (
 	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -36,12 +38,31 @@ type JWTConfig struct {
 // JWTClaims represents the claims in a JWT token
 type JWTClaims struct {
 	jwt.RegisteredClaims
-	Email       string   `json:"email,omitempty"`
-	Name        string   `json:"name,omitempty"`
-	Picture     string   `json:"picture,omitempty"`
-	Roles       []string `json:"roles,omitempty"`
-	Scopes      []string `json:"scope,omitempty"`
-	Permissions []string `json:"permissions,omitempty"`
+	Email       string    `json:"email,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Picture     string    `json:"picture,omitempty"`
+	Roles       []string  `json:"roles,omitempty"`
+	Scopes      ScopeList `json:"scope,omitempty"`
+	Permissions []string  `json:"permissions,omitempty"`
+}
+
+// ScopeList accepts the scope claim either as an OAuth space-delimited string or a JSON array
+type ScopeList []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *ScopeList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*s = list
+		return nil
+	}
+
+	var spaced string
+	if err := json.Unmarshal(data, &spaced); err != nil {
+		return fmt.Errorf("scope must be a string or array of strings: %w", err)
+	}
+	*s = strings.Fields(spaced)
+	return nil
 }
 
 // NewJWTProvider creates a new JWT provider
@@ -84,6 +105,9 @@ func (p *JWTProvider) ValidateToken(ctx context.Context, tokenString string) (*a
 	// Parse and validate the token
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, p.getKeyFunc)
 	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, auth.NewAuthError(auth.ErrCodeExpiredToken, "token expired", err.Error())
+		}
 		return nil, auth.NewAuthError(auth.ErrCodeInvalidToken, "invalid token", err.Error())
 	}
 
@@ -103,7 +127,7 @@ func (p *JWTProvider) ValidateToken(ctx context.Context, tokenString string) (*a
 	}
 
 	// Check audience
-	if p.audience != "" && !claims.VerifyAudience(p.audience, true) {
+	if p.audience != "" && !slices.Contains(claims.Audience, p.audience) {
 		return nil, auth.NewAuthError(auth.ErrCodeInvalidToken, "invalid audience", fmt.Sprintf("expected %s", p.audience))
 	}
 
@@ -114,7 +138,7 @@ func (p *JWTProvider) ValidateToken(ctx context.Context, tokenString string) (*a
 		Name:    claims.Name,
 		Picture: claims.Picture,
 		Roles:   claims.Roles,
-		Scopes:  claims.Scopes,
+		Scopes:  []string(claims.Scopes),
 	}
 
 	// Build auth context
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Access says who a route admits
+type Access string
+
+const (
+	// Public routes admit anyone and are not rate limited: health, readiness, stats and metrics
+	Public Access = "public"
+	// Caller routes admit anyone, identified by API key or address for rate limits and quotas
+	Caller Access = "caller"
+	// Service routes also verify trusted services' tokens, which stand in for an ingestion token
+	Service Access = "service"
+	// Admin routes need an admin API key or a trusted service's token
+	Admin Access = "admin"
+)
+
+// Policy is one route's authorization requirement
+type Policy struct {
+	Method string
+	Path   string
+	Access Access
+}
+
+func (p Policy) key() string {
+	return p.Method + " " + p.Path
+}
+
+// Router registers gin routes from a policy table. Each route runs the guards for its
+// policy's access before its own handlers, so protection cannot be forgotten at the call
+// site. A route without a policy is not registered; Err reports it, along with policies
+// no route was registered for.
+type Router struct {
+	policies map[string]Policy
+	guards   map[Access][]gin.HandlerFunc
+	used     map[string]bool
+	errs     []string
+}
+
+// NewRouter builds a router from a policy table and the middleware guarding each access
+func NewRouter(policies []Policy, guards map[Access][]gin.HandlerFunc) *Router {
+	rt := &Router{
+		policies: make(map[string]Policy, len(policies)),
+		guards:   guards,
+		used:     make(map[string]bool, len(policies)),
+	}
+	for _, policy := range policies {
+		switch policy.Access {
+		case Public, Caller, Service, Admin:
+		default:
+			rt.errs = append(rt.errs, fmt.Sprintf("%s has unknown access %q", policy.key(), policy.Access))
+		}
+		if _, exists := rt.policies[policy.key()]; exists {
+			rt.errs = append(rt.errs, policy.key()+" has more than one policy")
+		}
+		rt.policies[policy.key()] = policy
+	}
+	return rt
+}
+
+// Group registers routes under a gin group through the router
+func (rt *Router) Group(group *gin.RouterGroup) *RouteGroup {
+	return &RouteGroup{router: rt, group: group}
+}
+
+// Err lists routes registered without a policy and policies without a route
+func (rt *Router) Err() error {
+	errs := append([]string(nil), rt.errs...)
+	for key := range rt.policies {
+		if !rt.used[key] {
+			errs = append(errs, key+" has a policy but no route")
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Strings(errs)
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// RouteGroup is a gin group whose routes are registered through a Router
+type RouteGroup struct {
+	router *Router
+	group  *gin.RouterGroup
+}
+
+// Group creates a subgroup; handlers run for every route in it, before the route's guards
+func (g *RouteGroup) Group(relativePath string, handlers ...gin.HandlerFunc) *RouteGroup {
+	return &RouteGroup{router: g.router, group: g.group.Group(relativePath, handlers...)}
+}
+
+// Handle registers a route behind the guards its policy requires
+func (g *RouteGroup) Handle(method, relativePath string, handlers ...gin.HandlerFunc) {
+	key := method + " " + strings.TrimSuffix(g.group.BasePath(), "/") + relativePath
+	policy, ok := g.router.policies[key]
+	if !ok {
+		g.router.errs = append(g.router.errs, key+" has no policy")
+		return
+	}
+	g.router.used[key] = true
+	chain := append(append([]gin.HandlerFunc(nil), g.router.guards[policy.Access]...), handlers...)
+	g.group.Handle(method, relativePath, chain...)
+}
+
+func (g *RouteGroup) GET(relativePath string, handlers ...gin.HandlerFunc) {
+	g.Handle(http.MethodGet, relativePath, handlers...)
+}
+
+func (g *RouteGroup) POST(relativePath string, handlers ...gin.HandlerFunc) {
+	g.Handle(http.MethodPost, relativePath, handlers...)
+}
+
+func (g *RouteGroup) PUT(relativePath string, handlers ...gin.HandlerFunc) {
+	g.Handle(http.MethodPut, relativePath, handlers...)
+}
+
+func (g *RouteGroup) PATCH(relativePath string, handlers ...gin.HandlerFunc) {
+	g.Handle(http.MethodPatch, relativePath, handlers...)
+}
+
+func (g *RouteGroup) DELETE(relativePath string, handlers ...gin.HandlerFunc) {
+	g.Handle(http.MethodDelete, relativePath, handlers...)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+
+	"liberation-serviceauth"
+)
+
+// serviceKey holds the calling service's name once its token is verified
+const serviceKey = "service"
+
+// ServiceIdentityMiddleware accepts short-lived tokens minted by trusted liberation services.
+// Requests without a service token pass through to the route's usual authentication;
+// invalid service tokens are rejected. A nil identity disables service tokens.
+func ServiceIdentityMiddleware(identity *serviceauth.Identity) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if identity == nil {
+			c.Next()
+			return
+		}
+
+		claims, err := identity.VerifyRequest(c.Request)
+		if errors.Is(err, serviceauth.ErrNotServiceToken) {
+			c.Next()
+			return
+		}
+		if err != nil {
+			WriteBearerError(c, BearerChallenge{
+				Error:            BearerErrorInvalidToken,
+				ErrorDescription: err.Error(),
+			}, "service")
+			return
+		}
+
+		c.Set(serviceKey, claims.Issuer)
+		c.Next()
+	}
+}
+
+// GetService returns the service that authenticated the request with a service token
+func GetService(c *gin.Context) (string, bool) {
+	service, exists := c.Get(serviceKey)
+	if !exists {
+		return "", false
+	}
+
+	name, ok := service.(string)
+	return name, ok
+}
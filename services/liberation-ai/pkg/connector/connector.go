@@ -0,0 +1,148 @@
+// Package connector is the SDK for ingestion connectors, which keep a namespace in sync with
+// an outside source such as a wiki, a drive or a ticket tracker.
+//
+// A connector implements three lifecycle hooks: List pages through the source's items, Checksum
+// reports a cheap fingerprint of items so unchanged ones are skipped, and Fetch returns an
+// item's text. Liberation AI calls them on a schedule, stores what changed and deletes what
+// disappeared. Connectors are stateless: every call carries the source's settings and its
+// credentials, which Liberation AI keeps encrypted and only decrypts for the call.
+//
+// Connectors written in Go can be compiled into the server. Any other connector runs as a
+// sidecar that serves the HTTP contract implemented by Handler:
+//
+//	http.ListenAndServe(":9090", connector.Handler(notion.New(), os.Getenv("CONNECTOR_TOKEN")))
+//
+// The directory subpackage is a reference implementation.
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ProtocolVersion is the version of the sidecar contract this package speaks
+const ProtocolVersion = 1
+
+var (
+	// ErrNotFound is returned by Fetch for items that no longer exist in the source
+	ErrNotFound = errors.New("item not found")
+
+	// ErrInvalidSource is returned when a source's settings or credentials are missing or malformed
+	ErrInvalidSource = errors.New("invalid source")
+
+	// ErrUnauthorized is returned when the outside service rejects the source's credentials
+	ErrUnauthorized = errors.New("credentials rejected")
+)
+
+// Connector is implemented by every ingestion connector. Implementations must be safe for
+// concurrent use.
+type Connector interface {
+	// Info describes the connector and the settings and credentials a source needs
+	Info(ctx context.Context) (Info, error)
+	// List returns one page of the source's items; an empty cursor asks for the first page
+	List(ctx context.Context, req ListRequest) (*ListResponse, error)
+	// Checksum fingerprints items; items missing from the response no longer exist
+	Checksum(ctx context.Context, req ChecksumRequest) (*ChecksumResponse, error)
+	// Fetch returns an item's content, or ErrNotFound
+	Fetch(ctx context.Context, req FetchRequest) (*Document, error)
+}
+
+// Field describes a setting or credential a source is configured with
+type Field struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// Info describes a connector
+type Info struct {
+	Name     string `json:"name"`
+	Version  string `json:"version,omitempty"`
+	Protocol int    `json:"protocol"`
+	// Settings are stored in the clear and shown to admins, e.g. a workspace ID
+	Settings []Field `json:"settings,omitempty"`
+	// Credentials are encrypted at rest and never shown again once saved, e.g. an API token
+	Credentials []Field `json:"credentials,omitempty"`
+}
+
+// Validate checks that source has every required setting and credential and nothing unknown
+func (i Info) Validate(source Source) error {
+	check := func(kind string, fields []Field, values map[string]string) error {
+		known := make(map[string]bool, len(fields))
+		for _, field := range fields {
+			known[field.Name] = true
+			if field.Required && values[field.Name] == "" {
+				return fmt.Errorf("%w: %s %q is required", ErrInvalidSource, kind, field.Name)
+			}
+		}
+		for name := range values {
+			if !known[name] {
+				return fmt.Errorf("%w: unknown %s %q", ErrInvalidSource, kind, name)
+			}
+		}
+		return nil
+	}
+	if err := check("setting", i.Settings, source.Settings); err != nil {
+		return err
+	}
+	return check("credential", i.Credentials, source.Credentials)
+}
+
+// Source is the configuration of one connected source, sent with every call
+type Source struct {
+	Name        string            `json:"name"`
+	Settings    map[string]string `json:"settings,omitempty"`
+	Credentials map[string]string `json:"credentials,omitempty"`
+}
+
+// Item is one document in a source's listing
+type Item struct {
+	ID    string `json:"id"`
+	Title string `json:"title,omitempty"`
+	// Checksum changes whenever the item's content does; empty asks for one with Checksum
+	Checksum string    `json:"checksum,omitempty"`
+	Modified time.Time `json:"modified,omitempty"`
+}
+
+// ListRequest asks for one page of a source's items
+type ListRequest struct {
+	Source Source `json:"source"`
+	Cursor string `json:"cursor,omitempty"`
+	// Limit is a hint; connectors may return fewer items, or more when their API pages differently
+	Limit int `json:"limit,omitempty"`
+}
+
+// ListResponse is one page of items; an empty NextCursor ends the listing
+type ListResponse struct {
+	Items      []Item `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ChecksumRequest asks for the fingerprints of items
+type ChecksumRequest struct {
+	Source Source   `json:"source"`
+	IDs    []string `json:"ids"`
+}
+
+// ChecksumResponse maps item IDs to their checksums
+type ChecksumResponse struct {
+	Checksums map[string]string `json:"checksums"`
+}
+
+// FetchRequest asks for one item's content
+type FetchRequest struct {
+	Source Source `json:"source"`
+	ID     string `json:"id"`
+}
+
+// Document is an item's content, stored as a document with the item's ID
+type Document struct {
+	ID       string                 `json:"id"`
+	Title    string                 `json:"title,omitempty"`
+	Content  string                 `json:"content"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// Checksum is the item's checksum at the time it was fetched
+	Checksum string `json:"checksum,omitempty"`
+}
@@ -0,0 +1,193 @@
+// Package directory is the reference ingestion connector: it keeps a namespace in sync with
+// the text files under a directory. It needs no credentials; connectors for hosted services
+// declare theirs in Info and read them from the request's Source.
+package directory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"liberation-ai/pkg/connector"
+)
+
+// defaultExtensions matches the files `liberation-ai ingest` picks up
+const defaultExtensions = ".md,.markdown,.txt,.rst"
+
+// maxFileBytes skips files too large to be useful as a single document
+const maxFileBytes = 1 << 20
+
+// Connector lists, fingerprints and reads files under a source's root
+type Connector struct {
+	roots []string
+}
+
+// New creates the connector. Sources may only read below one of roots, so admins cannot
+// point a source at the rest of the filesystem; without roots, every directory is allowed.
+func New(roots ...string) *Connector {
+	cleaned := make([]string, len(roots))
+	for i, root := range roots {
+		cleaned[i] = filepath.Clean(root)
+	}
+	return &Connector{roots: cleaned}
+}
+
+// Info describes the connector's settings
+func (c *Connector) Info(ctx context.Context) (connector.Info, error) {
+	return connector.Info{
+		Name:     "directory",
+		Version:  "1.0.0",
+		Protocol: connector.ProtocolVersion,
+		Settings: []connector.Field{
+			{Name: "root", Description: "Directory to ingest", Required: true},
+			{Name: "extensions", Description: "Comma-separated file extensions, default " + defaultExtensions},
+		},
+	}, nil
+}
+
+// root checks the source's root against the allowed roots
+func (c *Connector) root(source connector.Source) (string, error) {
+	if source.Settings["root"] == "" {
+		return "", fmt.Errorf("%w: root is required", connector.ErrInvalidSource)
+	}
+	root := filepath.Clean(source.Settings["root"])
+	if len(c.roots) == 0 {
+		return root, nil
+	}
+	for _, allowed := range c.roots {
+		if rel, err := filepath.Rel(allowed, root); err == nil && filepath.IsLocal(rel) {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is outside the allowed directories", connector.ErrInvalidSource, root)
+}
+
+// List returns the files under the root in path order; the cursor is the last path returned
+func (c *Connector) List(ctx context.Context, req connector.ListRequest) (*connector.ListResponse, error) {
+	root, err := c.root(req.Source)
+	if err != nil {
+		return nil, err
+	}
+	extensions := parseExtensions(req.Source.Settings["extensions"])
+
+	var items []connector.Item
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !extensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxFileBytes {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(path)
+		items = append(items, connector.Item{
+			ID:       filepath.ToSlash(rel),
+			Title:    strings.TrimSuffix(name, filepath.Ext(name)),
+			Modified: info.ModTime().UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", connector.ErrInvalidSource, err)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	start := sort.Search(len(items), func(i int) bool { return items[i].ID > req.Cursor })
+	items = items[start:]
+	response := &connector.ListResponse{Items: items}
+	if req.Limit > 0 && len(items) > req.Limit {
+		response.Items = items[:req.Limit]
+		response.NextCursor = items[req.Limit-1].ID
+	}
+	return response, nil
+}
+
+// Checksum hashes each file's content; missing files are left out
+func (c *Connector) Checksum(ctx context.Context, req connector.ChecksumRequest) (*connector.ChecksumResponse, error) {
+	response := &connector.ChecksumResponse{Checksums: make(map[string]string, len(req.IDs))}
+	for _, id := range req.IDs {
+		content, err := c.read(req.Source, id)
+		if err == connector.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		response.Checksums[id] = checksum(content)
+	}
+	return response, nil
+}
+
+// Fetch reads one file
+func (c *Connector) Fetch(ctx context.Context, req connector.FetchRequest) (*connector.Document, error) {
+	content, err := c.read(req.Source, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(req.ID)
+	return &connector.Document{
+		ID:       req.ID,
+		Title:    strings.TrimSuffix(name, filepath.Ext(name)),
+		Content:  string(content),
+		Metadata: map[string]interface{}{"source": req.ID},
+		Checksum: checksum(content),
+	}, nil
+}
+
+// read loads a file by ID, refusing IDs that would leave the root
+func (c *Connector) read(source connector.Source, id string) ([]byte, error) {
+	root, err := c.root(source)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsLocal(filepath.FromSlash(id)) {
+		return nil, connector.ErrNotFound
+	}
+	content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(id)))
+	if os.IsNotExist(err) {
+		return nil, connector.ErrNotFound
+	}
+	return content, err
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func parseExtensions(list string) map[string]bool {
+	if list == "" {
+		list = defaultExtensions
+	}
+	extensions := make(map[string]bool)
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions[ext] = true
+	}
+	return extensions
+}
@@ -0,0 +1,214 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBody bounds sidecar requests and responses; a fetched document is the largest
+const maxBody = 32 << 20
+
+// Sidecar contract paths. Every call but info is a POST with a JSON body, and every call
+// carries "Authorization: Bearer <token>" when the sidecar is configured with a token.
+const (
+	PathInfo     = "/v1/info"
+	PathList     = "/v1/list"
+	PathChecksum = "/v1/checksum"
+	PathFetch    = "/v1/fetch"
+)
+
+// errorBody is returned with every non-2xx response
+type errorBody struct {
+	Error string `json:"error"`
+	// Code is not_found, invalid_source or unauthorized for the errors of this package
+	Code string `json:"code,omitempty"`
+}
+
+var errorCodes = map[string]error{
+	"not_found":      ErrNotFound,
+	"invalid_source": ErrInvalidSource,
+	"unauthorized":   ErrUnauthorized,
+}
+
+// Handler serves a connector over the sidecar contract. Requests without the token are
+// refused, since they carry credentials; an empty token accepts every request and is only
+// suitable when the sidecar is reachable from Liberation AI alone.
+func Handler(c Connector, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+PathInfo, func(w http.ResponseWriter, r *http.Request) {
+		info, err := c.Info(r.Context())
+		info.Protocol = ProtocolVersion
+		respond(w, info, err)
+	})
+	mux.HandleFunc("POST "+PathList, func(w http.ResponseWriter, r *http.Request) {
+		var req ListRequest
+		if decode(w, r, &req) {
+			response, err := c.List(r.Context(), req)
+			respond(w, response, err)
+		}
+	})
+	mux.HandleFunc("POST "+PathChecksum, func(w http.ResponseWriter, r *http.Request) {
+		var req ChecksumRequest
+		if decode(w, r, &req) {
+			response, err := c.Checksum(r.Context(), req)
+			respond(w, response, err)
+		}
+	})
+	mux.HandleFunc("POST "+PathFetch, func(w http.ResponseWriter, r *http.Request) {
+		var req FetchRequest
+		if decode(w, r, &req) {
+			response, err := c.Fetch(r.Context(), req)
+			respond(w, response, err)
+		}
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, errorBody{Error: "missing or wrong sidecar token"})
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, into interface{}) bool {
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBody)).Decode(into); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: err.Error()})
+		return false
+	}
+	return true
+}
+
+func respond(w http.ResponseWriter, response interface{}, err error) {
+	if err == nil {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+	status, code := http.StatusBadGateway, ""
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status, code = http.StatusNotFound, "not_found"
+	case errors.Is(err, ErrInvalidSource):
+		status, code = http.StatusBadRequest, "invalid_source"
+	case errors.Is(err, ErrUnauthorized):
+		status, code = http.StatusForbidden, "unauthorized"
+	}
+	writeJSON(w, status, errorBody{Error: err.Error(), Code: code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Remote is a connector running as a sidecar, called over the contract served by Handler
+type Remote struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewRemote creates a client for the sidecar at baseURL
+func NewRemote(baseURL, token string) *Remote {
+	return &Remote{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// Info asks the sidecar to describe itself and checks that it speaks this protocol
+func (r *Remote) Info(ctx context.Context) (Info, error) {
+	var info Info
+	if err := r.do(ctx, http.MethodGet, PathInfo, nil, &info); err != nil {
+		return info, err
+	}
+	if info.Protocol != ProtocolVersion {
+		return info, fmt.Errorf("sidecar %s speaks connector protocol %d, not %d", r.baseURL, info.Protocol, ProtocolVersion)
+	}
+	return info, nil
+}
+
+// List calls the sidecar's list hook
+func (r *Remote) List(ctx context.Context, req ListRequest) (*ListResponse, error) {
+	var response ListResponse
+	if err := r.do(ctx, http.MethodPost, PathList, req, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Checksum calls the sidecar's checksum hook
+func (r *Remote) Checksum(ctx context.Context, req ChecksumRequest) (*ChecksumResponse, error) {
+	var response ChecksumResponse
+	if err := r.do(ctx, http.MethodPost, PathChecksum, req, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Fetch calls the sidecar's fetch hook
+func (r *Remote) Fetch(ctx context.Context, req FetchRequest) (*Document, error) {
+	var response Document
+	if err := r.do(ctx, http.MethodPost, PathFetch, req, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (r *Remote) do(ctx context.Context, method, path string, body, into interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("connector sidecar unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var failure errorBody
+		if json.Unmarshal(data, &failure) != nil || failure.Error == "" {
+			failure.Error = strings.TrimSpace(string(data))
+		}
+		if known, ok := errorCodes[failure.Code]; ok {
+			detail := strings.TrimPrefix(failure.Error, known.Error()+": ")
+			if detail == known.Error() {
+				return known
+			}
+			return fmt.Errorf("%w: %s", known, detail)
+		}
+		return fmt.Errorf("connector sidecar returned %d: %s", resp.StatusCode, failure.Error)
+	}
+	return json.Unmarshal(data, into)
+}
@@ -0,0 +1,254 @@
+package liberation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ChatProvider generates replies from a chat model.
+// Implementations must be safe for concurrent use.
+type ChatProvider interface {
+	Complete(ctx context.Context, completion Completion) (*ChatReply, error)
+	Name() string
+}
+
+// ChatMessage is one turn of a conversation; Role is "user" or "assistant"
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Completion asks a chat model to continue a conversation
+type Completion struct {
+	System      string
+	Messages    []ChatMessage
+	Temperature float64
+	MaxTokens   int
+	// JSON asks the model to reply with a single JSON value
+	JSON bool
+}
+
+// ChatReply is a model's reply to a Completion
+type ChatReply struct {
+	Text       string
+	Model      string
+	TokensUsed int
+}
+
+// ChatConfig describes the ai_providers.chat section written by the setup wizard
+type ChatConfig struct {
+	// Provider is google, openai or ollama; any OpenAI-compatible server works as openai with a base_url
+	Provider  string `yaml:"provider"`
+	Model     string `yaml:"model"`
+	APIKeyEnv string `yaml:"api_key_env"`
+	BaseURL   string `yaml:"base_url"`
+}
+
+// NewChatProvider creates the chat provider described by config
+func NewChatProvider(config ChatConfig) (ChatProvider, error) {
+	if config.Model == "" {
+		return nil, fmt.Errorf("chat provider %q needs a model", config.Provider)
+	}
+	apiKey := ""
+	if config.APIKeyEnv != "" {
+		apiKey = os.Getenv(config.APIKeyEnv)
+	}
+	client := &http.Client{Timeout: 2 * time.Minute}
+
+	switch config.Provider {
+	case "google":
+		if apiKey == "" {
+			return nil, fmt.Errorf("google chat provider needs an API key in $%s", config.APIKeyEnv)
+		}
+		baseURL := config.BaseURL
+		if baseURL == "" {
+			baseURL = "https://generativelanguage.googleapis.com/v1beta"
+		}
+		return &geminiChat{baseURL: strings.TrimSuffix(baseURL, "/"), model: config.Model, apiKey: apiKey, client: client}, nil
+	case "openai", "ollama":
+		baseURL := config.BaseURL
+		switch {
+		case baseURL != "":
+		case config.Provider == "ollama":
+			baseURL = "http://localhost:11434/v1"
+		default:
+			baseURL = "https://api.openai.com/v1"
+		}
+		if apiKey == "" && config.Provider == "openai" && config.BaseURL == "" {
+			return nil, fmt.Errorf("openai chat provider needs an API key in $%s", config.APIKeyEnv)
+		}
+		return &openAIChat{name: config.Provider, baseURL: strings.TrimSuffix(baseURL, "/"), model: config.Model, apiKey: apiKey, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported chat provider %q", config.Provider)
+	}
+}
+
+// openAIChat talks to the OpenAI chat completions API or a server compatible with it
+type openAIChat struct {
+	name    string
+	baseURL string
+	model   string
+	apiKey  string
+	client  *http.Client
+}
+
+// Name implements ChatProvider.Name
+func (o *openAIChat) Name() string {
+	return o.name
+}
+
+// Complete implements ChatProvider.Complete
+func (o *openAIChat) Complete(ctx context.Context, completion Completion) (*ChatReply, error) {
+	messages := make([]ChatMessage, 0, len(completion.Messages)+1)
+	if completion.System != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: completion.System})
+	}
+	messages = append(messages, completion.Messages...)
+
+	body := map[string]interface{}{
+		"model":       o.model,
+		"messages":    messages,
+		"temperature": completion.Temperature,
+	}
+	if completion.MaxTokens > 0 {
+		body["max_tokens"] = completion.MaxTokens
+	}
+	if completion.JSON {
+		body["response_format"] = map[string]string{"type": "json_object"}
+	}
+
+	var response struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message ChatMessage `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	headers := map[string]string{}
+	if o.apiKey != "" {
+		headers["Authorization"] = "Bearer " + o.apiKey
+	}
+	if err := postJSON(ctx, o.client, o.baseURL+"/chat/completions", headers, body, &response); err != nil {
+		return nil, fmt.Errorf("%s chat: %w", o.name, err)
+	}
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("%s chat: no choices in response", o.name)
+	}
+
+	model := response.Model
+	if model == "" {
+		model = o.model
+	}
+	return &ChatReply{Text: response.Choices[0].Message.Content, Model: model, TokensUsed: response.Usage.TotalTokens}, nil
+}
+
+// geminiChat talks to the Gemini generateContent API
+type geminiChat struct {
+	baseURL string
+	model   string
+	apiKey  string
+	client  *http.Client
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+// Name implements ChatProvider.Name
+func (g *geminiChat) Name() string {
+	return "google"
+}
+
+// Complete implements ChatProvider.Complete
+func (g *geminiChat) Complete(ctx context.Context, completion Completion) (*ChatReply, error) {
+	contents := make([]geminiContent, len(completion.Messages))
+	for i, message := range completion.Messages {
+		// Gemini calls the assistant "model"
+		role := message.Role
+		if role == "assistant" {
+			role = "model"
+		}
+		contents[i] = geminiContent{Role: role, Parts: []geminiPart{{Text: message.Content}}}
+	}
+
+	generation := map[string]interface{}{"temperature": completion.Temperature}
+	if completion.MaxTokens > 0 {
+		generation["maxOutputTokens"] = completion.MaxTokens
+	}
+	if completion.JSON {
+		generation["responseMimeType"] = "application/json"
+	}
+	body := map[string]interface{}{
+		"contents":         contents,
+		"generationConfig": generation,
+	}
+	if completion.System != "" {
+		body["systemInstruction"] = geminiContent{Parts: []geminiPart{{Text: completion.System}}}
+	}
+
+	var response struct {
+		Candidates []struct {
+			Content geminiContent `json:"content"`
+		} `json:"candidates"`
+		UsageMetadata struct {
+			TotalTokenCount int `json:"totalTokenCount"`
+		} `json:"usageMetadata"`
+	}
+	endpoint := g.baseURL + "/models/" + url.PathEscape(g.model) + ":generateContent"
+	if err := postJSON(ctx, g.client, endpoint, map[string]string{"x-goog-api-key": g.apiKey}, body, &response); err != nil {
+		return nil, fmt.Errorf("google chat: %w", err)
+	}
+	if len(response.Candidates) == 0 {
+		return nil, fmt.Errorf("google chat: no candidates in response")
+	}
+
+	var text strings.Builder
+	for _, part := range response.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	return &ChatReply{Text: text.String(), Model: g.model, TokensUsed: response.UsageMetadata.TotalTokenCount}, nil
+}
+
+// postJSON posts body as JSON and decodes a successful response into out
+func postJSON(ctx context.Context, client *http.Client, endpoint string, headers map[string]string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,148 @@
+package liberation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// chatServer answers every request with reply and keeps the last request's path, headers and body
+func chatServer(t *testing.T, status int, reply string) (*httptest.Server, *http.Request, map[string]interface{}) {
+	t.Helper()
+	var received http.Request
+	body := map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = *r
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	t.Cleanup(server.Close)
+	return server, &received, body
+}
+
+var jsonCompletion = Completion{
+	System:    "Reply in JSON.",
+	Messages:  []ChatMessage{{Role: "user", Content: "dal?"}, {Role: "assistant", Content: "{}"}, {Role: "user", Content: "again"}},
+	MaxTokens: 64,
+	JSON:      true,
+}
+
+func TestNewChatProvider(t *testing.T) {
+	t.Setenv("TEST_CHAT_KEY", "secret")
+	for _, tc := range []struct {
+		config ChatConfig
+		want   string
+	}{
+		{ChatConfig{Provider: "openai"}, `chat provider "openai" needs a model`},
+		{ChatConfig{Provider: "openai", Model: "gpt", APIKeyEnv: "UNSET_CHAT_KEY"}, "openai chat provider needs an API key in $UNSET_CHAT_KEY"},
+		{ChatConfig{Provider: "google", Model: "gemini"}, "google chat provider needs an API key in $"},
+		{ChatConfig{Provider: "claude", Model: "x"}, `unsupported chat provider "claude"`},
+	} {
+		if _, err := NewChatProvider(tc.config); err == nil || err.Error() != tc.want {
+			t.Errorf("%+v: %v, want %q", tc.config, err, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		config        ChatConfig
+		name, baseURL string
+	}{
+		{ChatConfig{Provider: "ollama", Model: "llama"}, "ollama", "http://localhost:11434/v1"},
+		{ChatConfig{Provider: "openai", Model: "gpt", APIKeyEnv: "TEST_CHAT_KEY"}, "openai", "https://api.openai.com/v1"},
+		{ChatConfig{Provider: "openai", Model: "local", BaseURL: "http://llm.internal/v1/"}, "openai", "http://llm.internal/v1"},
+	} {
+		provider, err := NewChatProvider(tc.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chat := provider.(*openAIChat); chat.Name() != tc.name || chat.baseURL != tc.baseURL {
+			t.Errorf("%+v: %s at %s", tc.config, chat.Name(), chat.baseURL)
+		}
+	}
+	if provider, err := NewChatProvider(ChatConfig{Provider: "google", Model: "gemini", APIKeyEnv: "TEST_CHAT_KEY"}); err != nil || provider.Name() != "google" {
+		t.Errorf("google: %v, %v", provider, err)
+	}
+}
+
+func TestOpenAIChat(t *testing.T) {
+	server, received, body := chatServer(t, http.StatusOK,
+		`{"model": "gpt-served", "choices": [{"message": {"role": "assistant", "content": "{\"a\": 1}"}}], "usage": {"total_tokens": 42}}`)
+	chat := &openAIChat{name: "openai", baseURL: server.URL, model: "gpt", apiKey: "secret", client: server.Client()}
+
+	reply, err := chat.Complete(context.Background(), jsonCompletion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Text != `{"a": 1}` || reply.Model != "gpt-served" || reply.TokensUsed != 42 {
+		t.Errorf("reply %+v", reply)
+	}
+	if received.URL.Path != "/chat/completions" || received.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("posted to %s with %v", received.URL.Path, received.Header)
+	}
+	// The system prompt leads the conversation
+	messages := body["messages"].([]interface{})
+	if len(messages) != 4 || messages[0].(map[string]interface{})["role"] != "system" || body["max_tokens"] != 64.0 ||
+		body["response_format"].(map[string]interface{})["type"] != "json_object" || body["model"] != "gpt" {
+		t.Errorf("request body %v", body)
+	}
+}
+
+func TestOpenAIChatErrors(t *testing.T) {
+	server, _, _ := chatServer(t, http.StatusTooManyRequests, "  slow down  ")
+	chat := &openAIChat{name: "ollama", baseURL: server.URL, model: "llama", client: server.Client()}
+	if _, err := chat.Complete(context.Background(), Completion{}); err == nil || err.Error() != "ollama chat: status 429: slow down" {
+		t.Errorf("rate limited: %v", err)
+	}
+
+	server, received, _ := chatServer(t, http.StatusOK, `{"choices": []}`)
+	chat = &openAIChat{name: "ollama", baseURL: server.URL, model: "llama", client: server.Client()}
+	if _, err := chat.Complete(context.Background(), Completion{}); err == nil || err.Error() != "ollama chat: no choices in response" {
+		t.Errorf("no choices: %v", err)
+	}
+	if received.Header.Get("Authorization") != "" {
+		t.Error("sent an Authorization header without a key")
+	}
+}
+
+func TestGeminiChat(t *testing.T) {
+	server, received, body := chatServer(t, http.StatusOK,
+		`{"candidates": [{"content": {"parts": [{"text": "{\"a\""}, {"text": ": 1}"}]}}], "usageMetadata": {"totalTokenCount": 7}}`)
+	chat := &geminiChat{baseURL: server.URL, model: "gemini pro", apiKey: "secret", client: server.Client()}
+
+	reply, err := chat.Complete(context.Background(), jsonCompletion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Text != `{"a": 1}` || reply.Model != "gemini pro" || reply.TokensUsed != 7 {
+		t.Errorf("reply %+v", reply)
+	}
+	if received.URL.EscapedPath() != "/models/gemini%20pro:generateContent" || received.Header.Get("x-goog-api-key") != "secret" {
+		t.Errorf("posted to %s with %v", received.URL.EscapedPath(), received.Header)
+	}
+
+	// The assistant is called "model", and the system prompt is an instruction of its own
+	contents := body["contents"].([]interface{})
+	generation := body["generationConfig"].(map[string]interface{})
+	if len(contents) != 3 || contents[1].(map[string]interface{})["role"] != "model" ||
+		generation["responseMimeType"] != "application/json" || generation["maxOutputTokens"] != 64.0 ||
+		!strings.Contains(encodeJSON(body["systemInstruction"]), "Reply in JSON.") {
+		t.Errorf("request body %v", body)
+	}
+
+	empty, _, _ := chatServer(t, http.StatusOK, `{"candidates": []}`)
+	chat = &geminiChat{baseURL: empty.URL, model: "gemini", client: empty.Client()}
+	if _, err := chat.Complete(context.Background(), Completion{}); err == nil || err.Error() != "google chat: no candidates in response" {
+		t.Errorf("no candidates: %v", err)
+	}
+}
+
+func encodeJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,127 @@
+package liberation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"liberation-ai/pkg/types"
+)
+
+// Chunking splits long documents into overlapping chunks stored as separate vectors, so
+// searches match the passage rather than the whole document
+type Chunking struct {
+	// Size is the longest chunk in characters; 0 stores each document as a single vector
+	Size int `yaml:"chunk_size" json:"chunk_size"`
+	// Overlap is roughly how many characters consecutive chunks share
+	Overlap int `yaml:"chunk_overlap" json:"chunk_overlap"`
+}
+
+// Validate reports settings that cannot produce chunks
+func (c Chunking) Validate() error {
+	if c.Size < 0 || c.Overlap < 0 {
+		return fmt.Errorf("chunk_size and chunk_overlap must not be negative")
+	}
+	if c.Size > 0 && c.Overlap >= c.Size {
+		return fmt.Errorf("chunk_overlap (%d) must be smaller than chunk_size (%d)", c.Overlap, c.Size)
+	}
+	return nil
+}
+
+// SetChunking sets how StoreDocuments splits documents. Call it before storing documents.
+func (s *Service) SetChunking(chunking Chunking) error {
+	if err := chunking.Validate(); err != nil {
+		return err
+	}
+	s.chunking = chunking
+	return nil
+}
+
+// ChunkCount returns how many vectors StoreDocuments would write for docs, one per chunk
+func (s *Service) ChunkCount(docs []Document) int {
+	count := 0
+	for _, doc := range docs {
+		count += len(splitText(doc.Content, s.chunking.Size, s.chunking.Overlap))
+	}
+	return count
+}
+
+// splitText breaks text into chunks of at most size runes, preferring to end each chunk at
+// whitespace, with each chunk starting about overlap runes before the previous one ended
+func splitText(text string, size, overlap int) []string {
+	runes := []rune(strings.TrimSpace(text))
+	if size <= 0 || len(runes) <= size {
+		return []string{string(runes)}
+	}
+
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := min(start+size, len(runes))
+		if end < len(runes) {
+			// Back up to a word boundary, unless that would give up more than a fifth of the chunk
+			for cut := end; cut > end-size/5; cut-- {
+				if unicode.IsSpace(runes[cut]) {
+					end = cut
+					break
+				}
+			}
+		}
+		chunks = append(chunks, strings.TrimSpace(string(runes[start:end])))
+		if end == len(runes) {
+			break
+		}
+
+		// Start the next chunk at the beginning of a word too
+		next := max(end-overlap, start+1)
+		for next < end && !unicode.IsSpace(runes[next-1]) {
+			next++
+		}
+		start = next
+	}
+	return chunks
+}
+
+// pruneChunks deletes a document's vectors left over from an earlier version that was split
+// differently
+func (s *Service) pruneChunks(ctx context.Context, namespace, docID string, keep map[string]bool) error {
+	existing, err := s.store.Chunks(ctx, namespace, docID, 0, math.MaxInt32)
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	for _, vector := range existing {
+		if !keep[vector.ID] {
+			stale = append(stale, vector.ID)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	return s.store.Delete(ctx, namespace, stale)
+}
+
+// expandWindow attaches each result's chunk and up to window chunks either side of it
+func (s *Service) expandWindow(ctx context.Context, namespace string, results []types.SearchResult, window int) error {
+	for i := range results {
+		vector := &results[i].Vector
+		docID, hasDoc := vector.Metadata[types.MetadataDocID]
+		index, hasIndex := vector.ChunkIndex()
+		if !hasDoc || !hasIndex {
+			continue
+		}
+
+		chunks, err := s.store.Chunks(ctx, namespace, fmt.Sprintf("%v", docID), index-window, index+window)
+		if err != nil {
+			return fmt.Errorf("failed to expand window for %s: %w", vector.ID, err)
+		}
+		results[i].Window = make([]types.Chunk, 0, len(chunks))
+		for _, chunk := range chunks {
+			chunkIndex, _ := chunk.ChunkIndex()
+			results[i].Window = append(results[i].Window, types.Chunk{ID: chunk.ID, Index: chunkIndex, Text: chunk.Text})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package liberation
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"liberation-ai/pkg/types"
+)
+
+func TestChunkingValidate(t *testing.T) {
+	for _, tc := range []struct {
+		chunking Chunking
+		valid    bool
+	}{
+		{Chunking{}, true},
+		{Chunking{Size: 100, Overlap: 20}, true},
+		{Chunking{Overlap: 20}, true},
+		{Chunking{Size: -1}, false},
+		{Chunking{Size: 100, Overlap: -1}, false},
+		{Chunking{Size: 100, Overlap: 100}, false},
+	} {
+		if err := tc.chunking.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%+v: %v", tc.chunking, err)
+		}
+	}
+
+	service := New(NewMemoryStore(3), NewHashEmbedder(3))
+	if err := service.SetChunking(Chunking{Size: 10, Overlap: 10}); err == nil || service.chunking.Size != 0 {
+		t.Errorf("invalid chunking set: %v", err)
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	if chunks := splitText("  short text  ", 0, 0); !slices.Equal(chunks, []string{"short text"}) {
+		t.Errorf("unchunked: %q", chunks)
+	}
+	if chunks := splitText("", 10, 2); !slices.Equal(chunks, []string{""}) {
+		t.Errorf("empty: %q", chunks)
+	}
+
+	text := "the tool library lends ladders drills and saws to every household on the street for free"
+	chunks := splitText(text, 30, 10)
+	want := []string{
+		"the tool library lends ladders",
+		"ladders drills and saws to",
+		"saws to every household on the",
+		"on the street for free",
+	}
+	if !slices.Equal(chunks, want) {
+		t.Errorf("chunks %q, want %q", chunks, want)
+	}
+	words := strings.Fields(text)
+	for _, chunk := range chunks {
+		if len(chunk) > 30 || !slices.Contains(words, strings.Fields(chunk)[0]) {
+			t.Errorf("chunk %q is too long or starts mid-word", chunk)
+		}
+	}
+
+	// Without whitespace nearby, chunks are cut at the size; sizes count runes, not bytes
+	for _, chunk := range splitText(strings.Repeat("ü", 25), 10, 3) {
+		if n := utf8.RuneCountInString(chunk); n > 10 || !utf8.ValidString(chunk) {
+			t.Errorf("chunk %q of %d runes", chunk, n)
+		}
+	}
+}
+
+func TestStoreDocumentsChunks(t *testing.T) {
+	ctx := context.Background()
+	service := New(NewMemoryStore(3), NewHashEmbedder(3))
+	if err := service.SetChunking(Chunking{Size: 30, Overlap: 10}); err != nil {
+		t.Fatal(err)
+	}
+	long := Document{ID: "guide", Title: "Guide", Content: "the tool library lends ladders drills and saws to every household on the street for free"}
+	docs := []Document{long, {ID: "faq", Content: "ask first"}}
+	if count := service.ChunkCount(docs); count != 5 {
+		t.Errorf("ChunkCount = %d", count)
+	}
+	if _, err := service.StoreDocuments(ctx, "docs", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	chunk, err := service.GetVector(ctx, "docs", "guide#1")
+	if err != nil || chunk.Text != "ladders drills and saws to" || chunk.Metadata[types.MetadataDocID] != "guide" || chunk.Metadata["title"] != "Guide" {
+		t.Fatalf("second chunk %+v, %v", chunk, err)
+	}
+	if index, ok := chunk.ChunkIndex(); !ok || index != 1 {
+		t.Errorf("chunk index %v", chunk.Metadata[types.MetadataChunkIndex])
+	}
+	// A short document is a single vector under its own ID, still tagged as chunk 0
+	if faq, err := service.GetVector(ctx, "docs", "faq"); err != nil || faq.Metadata[types.MetadataDocID] != "faq" {
+		t.Errorf("short document %+v, %v", faq, err)
+	}
+
+	// Storing a shorter version removes the chunks it no longer has
+	long.Content = "the tool library lends ladders drills and saws"
+	if _, err := service.StoreDocuments(ctx, "docs", []Document{long}); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := service.NamespaceSize(ctx, "docs"); size != 3 {
+		t.Errorf("%d vectors after shrinking the guide to two chunks", size)
+	}
+	if _, err := service.GetVector(ctx, "docs", "guide#3"); err == nil {
+		t.Error("a stale chunk was kept")
+	}
+}
+
+func TestSearchWindow(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryStore(3)
+	service := New(memory, NewHashEmbedder(3))
+	service.SetChunking(Chunking{Size: 30, Overlap: 10})
+	if _, err := service.StoreDocuments(ctx, "docs", []Document{{ID: "guide", Content: "the tool library lends ladders drills and saws to every household on the street for free"}}); err != nil {
+		t.Fatal(err)
+	}
+	middle, _ := service.GetVector(ctx, "docs", "guide#2")
+	first, _ := service.GetVector(ctx, "docs", "guide#0")
+	loose := types.SearchResult{Vector: types.Vector{ID: "loose", Metadata: map[string]interface{}{}}}
+	service.store = &cannedStore{VectorStore: memory, results: []types.SearchResult{{Vector: *middle}, {Vector: *first}, loose}}
+
+	response, err := service.SearchTextWithOptions(ctx, "docs", "ladder", SearchOptions{Window: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var windows [][]int
+	for _, result := range response.Results {
+		var indexes []int
+		for _, chunk := range result.Window {
+			indexes = append(indexes, chunk.Index)
+		}
+		windows = append(windows, indexes)
+	}
+	// The window is clipped at the start of the document; vectors without chunks get none
+	if len(windows) != 3 || !slices.Equal(windows[0], []int{1, 2, 3}) || !slices.Equal(windows[1], []int{0, 1}) || windows[2] != nil {
+		t.Errorf("windows %v", windows)
+	}
+	if text := response.Results[0].Window[1].Text; text != middle.Text {
+		t.Errorf("window chunk text %q", text)
+	}
+}
@@ -0,0 +1,158 @@
+package liberation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"liberation-ai/pkg/types"
+)
+
+// CloneJob reports the progress of a namespace clone
+type CloneJob struct {
+	ID              string                `json:"id"`
+	Source          string                `json:"source"`
+	Target          string                `json:"target"`
+	Status          types.MigrationStatus `json:"status"`
+	VectorsTotal    int64                 `json:"vectors_total"`
+	VectorsCopied   int64                 `json:"vectors_copied"`
+	PercentComplete float64               `json:"percent_complete"`
+	StartedAt       time.Time             `json:"started_at"`
+	CompletedAt     *time.Time            `json:"completed_at,omitempty"`
+	Error           string                `json:"error,omitempty"`
+}
+
+type cloneJob struct {
+	job  CloneJob
+	done chan struct{}
+}
+
+// NamespaceSize returns the number of vectors stored in a namespace
+func (s *Service) NamespaceSize(ctx context.Context, namespace string) (int64, error) {
+	stats, err := s.store.Stats(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get store stats: %w", err)
+	}
+	return stats.NamespaceStats[namespace], nil
+}
+
+// StartClone copies a namespace in the background without re-embedding its documents
+func (s *Service) StartClone(ctx context.Context, source, target string) (*CloneJob, error) {
+	if target == "" || target == source {
+		return nil, fmt.Errorf("target namespace must be set and differ from the source")
+	}
+	if err := s.residency.CheckCopy(source, target); err != nil {
+		return nil, err
+	}
+	if err := s.checkResidency(target); err != nil {
+		return nil, err
+	}
+
+	stats, err := s.store.Stats(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get store stats: %w", err)
+	}
+	total := stats.NamespaceStats[source]
+	if total == 0 {
+		return nil, types.ErrNamespaceNotFound
+	}
+	if stats.NamespaceStats[target] > 0 {
+		return nil, types.ErrNamespaceExists
+	}
+
+	s.jobsMu.Lock()
+	for _, existing := range s.jobs {
+		if existing.job.Target == target && existing.job.Status == types.MigrationRunning {
+			s.jobsMu.Unlock()
+			return nil, types.ErrNamespaceExists
+		}
+	}
+
+	job := &cloneJob{
+		job: CloneJob{
+			ID:           newJobID(),
+			Source:       source,
+			Target:       target,
+			Status:       types.MigrationRunning,
+			VectorsTotal: total,
+			StartedAt:    time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+	s.jobs[job.job.ID] = job
+	snapshot := job.job
+	s.jobsMu.Unlock()
+
+	go s.runClone(job)
+
+	return &snapshot, nil
+}
+
+// GetCloneJob returns the current state of a clone job
+func (s *Service) GetCloneJob(id string) (*CloneJob, bool) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return nil, false
+	}
+	snapshot := job.job
+	return &snapshot, true
+}
+
+// WaitForClone blocks until the clone job finishes or ctx is done
+func (s *Service) WaitForClone(ctx context.Context, id string) (*CloneJob, error) {
+	s.jobsMu.Lock()
+	job, exists := s.jobs[id]
+	s.jobsMu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("clone job %s not found", id)
+	}
+
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+	}
+
+	snapshot, _ := s.GetCloneJob(id)
+	return snapshot, nil
+}
+
+func (s *Service) runClone(job *cloneJob) {
+	defer close(job.done)
+
+	copied, err := s.store.Clone(context.Background(), job.job.Source, job.job.Target, func(copied, total int64) {
+		s.jobsMu.Lock()
+		defer s.jobsMu.Unlock()
+
+		job.job.VectorsCopied = copied
+		job.job.VectorsTotal = total
+		if total > 0 {
+			job.job.PercentComplete = float64(copied) / float64(total) * 100
+		}
+	})
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	completedAt := time.Now()
+	job.job.CompletedAt = &completedAt
+	if err != nil {
+		job.job.Status = types.MigrationFailed
+		job.job.Error = err.Error()
+		return
+	}
+
+	job.job.Status = types.MigrationCompleted
+	job.job.VectorsCopied = copied
+	job.job.PercentComplete = 100
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "clone_" + hex.EncodeToString(b)
+}
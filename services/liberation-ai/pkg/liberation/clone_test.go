@@ -0,0 +1,139 @@
+package liberation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"liberation-ai/pkg/types"
+)
+
+// failingCloneStore fails every clone partway
+type failingCloneStore struct {
+	VectorStore
+}
+
+func (s failingCloneStore) Clone(ctx context.Context, source, target string, progress func(copied, total int64)) (int64, error) {
+	progress(1, 3)
+	return 0, errors.New("disk full")
+}
+
+func storeNumbered(t *testing.T, service *Service, namespace string, n int) {
+	t.Helper()
+	vectors := make([]types.Vector, n)
+	for i := range vectors {
+		vectors[i] = types.Vector{ID: fmt.Sprint(i), Embedding: []float32{1, float32(i), 0}, Metadata: map[string]interface{}{"n": i}}
+	}
+	if _, err := service.StoreVectors(context.Background(), &types.StoreRequest{Namespace: namespace, Vectors: vectors}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	ctx := context.Background()
+	service := New(NewMemoryStore(3), NewHashEmbedder(3))
+	storeNumbered(t, service, "source", 50)
+
+	job, err := service.StartClone(ctx, "source", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != types.MigrationRunning || job.VectorsTotal != 50 || job.ID == "" {
+		t.Errorf("started as %+v", job)
+	}
+	finished, err := service.WaitForClone(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished.Status != types.MigrationCompleted || finished.VectorsCopied != 50 || finished.PercentComplete != 100 || finished.CompletedAt == nil {
+		t.Errorf("finished as %+v", finished)
+	}
+	if size, _ := service.NamespaceSize(ctx, "copy"); size != 50 {
+		t.Errorf("copy holds %d vectors", size)
+	}
+
+	// The copy is independent of its source
+	vector, err := service.GetVector(ctx, "copy", "7")
+	if err != nil || vector.Namespace != "copy" || vector.Metadata["n"] != 7 || vector.Embedding[1] != 7 {
+		t.Fatalf("copied vector %+v, %v", vector, err)
+	}
+	if _, err := service.UpdateMetadata(ctx, &types.MetadataUpdate{Namespace: "copy", IDs: []string{"7"}, Metadata: map[string]interface{}{"n": "edited"}}); err != nil {
+		t.Fatal(err)
+	}
+	if source, _ := service.GetVector(ctx, "source", "7"); source.Metadata["n"] != 7 {
+		t.Errorf("editing the copy changed the source to %v", source.Metadata)
+	}
+
+	if _, err := service.WaitForClone(ctx, "clone_missing"); err == nil {
+		t.Error("waited for an unknown job")
+	}
+	if _, ok := service.GetCloneJob("clone_missing"); ok {
+		t.Error("found an unknown job")
+	}
+}
+
+func TestStartCloneRefuses(t *testing.T) {
+	ctx := context.Background()
+	service := New(NewMemoryStore(3), NewHashEmbedder(3))
+	storeNumbered(t, service, "source", 3)
+	storeNumbered(t, service, "taken", 1)
+
+	for _, tc := range []struct {
+		source, target string
+		want           error
+	}{
+		{"source", "", nil},
+		{"source", "source", nil},
+		{"missing", "copy", types.ErrNamespaceNotFound},
+		{"source", "taken", types.ErrNamespaceExists},
+	} {
+		job, err := service.StartClone(ctx, tc.source, tc.target)
+		if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+			t.Errorf("clone %q to %q: %+v, %v; want %v", tc.source, tc.target, job, err, tc.want)
+		}
+	}
+}
+
+func TestCloneFailure(t *testing.T) {
+	ctx := context.Background()
+	service := New(failingCloneStore{NewMemoryStore(3)}, NewHashEmbedder(3))
+	storeNumbered(t, service, "source", 3)
+
+	job, err := service.StartClone(ctx, "source", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished, _ := service.WaitForClone(ctx, job.ID)
+	if finished.Status != types.MigrationFailed || finished.Error != "disk full" || finished.VectorsCopied != 1 || finished.CompletedAt == nil {
+		t.Errorf("failed clone reported as %+v", finished)
+	}
+}
+
+func TestMemoryCloneProgress(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(3)
+	service := New(store, NewHashEmbedder(3))
+	storeNumbered(t, service, "source", 2500)
+
+	var reports [][2]int64
+	copied, err := store.Clone(ctx, "source", "copy", func(copied, total int64) {
+		reports = append(reports, [2]int64{copied, total})
+	})
+	if err != nil || copied != 2500 {
+		t.Fatalf("Clone: %d, %v", copied, err)
+	}
+	want := [][2]int64{{1000, 2500}, {2000, 2500}, {2500, 2500}}
+	if fmt.Sprint(reports) != fmt.Sprint(want) {
+		t.Errorf("progress %v, want %v", reports, want)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := store.Clone(cancelled, "source", "other", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled clone: %v", err)
+	}
+	if size, _ := service.NamespaceSize(ctx, "other"); size != 0 {
+		t.Errorf("a cancelled clone left %d vectors", size)
+	}
+}
@@ -0,0 +1,249 @@
+package liberation
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"liberation-ai/pkg/types"
+)
+
+// DefaultMMRLambda balances relevance against novelty when diversifying results
+const DefaultMMRLambda = 0.7
+
+// SearchOptions controls how text search results are retrieved and post-processed
+type SearchOptions struct {
+	Limit int
+	// Candidates is the minimum number of results fetched from the store before post-processing
+	Candidates int
+	// Diversify re-orders results with maximal marginal relevance
+	Diversify bool
+	// Lambda weighs relevance (1.0) against novelty (0.0) for MMR
+	Lambda float64
+	// GroupBy collapses results sharing a metadata value, keeping the best per group.
+	// "doc_id" falls back to the vector ID for vectors without a doc_id.
+	GroupBy string
+	// Rerank adjusts candidate scores before grouping and diversification
+	Rerank func(*types.SearchResponse)
+	// Filters restricts retrieval to vectors with matching metadata. Candidates are retrieved
+	// once for all variants, so only the first variant's filters apply.
+	Filters map[string]interface{}
+	// Window attaches up to this many neighbouring chunks either side of each result.
+	// Only the first variant, the one served, is expanded.
+	Window int
+	// Preference is blended into the query embedding, taking PreferenceWeight of it, e.g. a
+	// user's profile vector. As with Filters, only the first variant's preference applies.
+	Preference       []float32
+	PreferenceWeight float64
+}
+
+// SearchTextWithOptions searches for similar text, then applies reranking, grouping and MMR
+func (s *Service) SearchTextWithOptions(ctx context.Context, namespace, query string, opts SearchOptions) (*types.SearchResponse, error) {
+	responses, err := s.SearchTextVariants(ctx, namespace, query, []SearchOptions{opts})
+	if err != nil {
+		return nil, err
+	}
+	return responses[0], nil
+}
+
+// SearchTextVariants embeds and retrieves once, then post-processes the candidates separately
+// for each set of options. Used to compute shadow rankings alongside the one that is served.
+func (s *Service) SearchTextVariants(ctx context.Context, namespace, query string, variants []SearchOptions) ([]*types.SearchResponse, error) {
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("at least one set of search options is required")
+	}
+
+	candidates := 0
+	for i := range variants {
+		opts := &variants[i]
+		if opts.Limit <= 0 {
+			opts.Limit = 10
+		}
+		if opts.Lambda < 0 || opts.Lambda > 1 {
+			return nil, fmt.Errorf("lambda must be between 0 and 1")
+		}
+		if opts.Diversify && opts.Lambda == 0 {
+			opts.Lambda = DefaultMMRLambda
+		}
+
+		// Grouping and diversification need a deeper pool to choose from
+		candidates = max(candidates, opts.Candidates, opts.Limit)
+		if opts.Diversify || opts.GroupBy != "" {
+			candidates = max(candidates, opts.Limit*4)
+		}
+	}
+
+	// Filters are checked before the query is embedded, so a rejected search costs nothing
+	filters, err := s.typeFilters(namespace, variants[0].Filters)
+	if err != nil {
+		return nil, err
+	}
+	embedding, _, err := s.embed(ctx, namespace, query)
+	if err != nil {
+		return nil, err
+	}
+	personalized := false
+	if preference := variants[0].Preference; len(preference) == len(embedding) && variants[0].PreferenceWeight > 0 {
+		embedding, personalized = BlendEmbedding(embedding, preference, variants[0].PreferenceWeight), true
+	}
+
+	retrieved, err := s.store.Search(ctx, &types.SearchRequest{
+		Namespace: namespace,
+		Embedding: embedding,
+		Limit:     candidates,
+		Filters:   filters,
+		Threshold: 0.7, // Similarity threshold
+	})
+	if err != nil {
+		return nil, err
+	}
+	retrieved.Personalized = personalized
+
+	responses := make([]*types.SearchResponse, len(variants))
+	for i, opts := range variants {
+		response := *retrieved
+		response.Results = append([]types.SearchResult(nil), retrieved.Results...)
+		postProcess(&response, opts)
+		responses[i] = &response
+	}
+
+	if window := variants[0].Window; window > 0 {
+		if err := s.expandWindow(ctx, namespace, responses[0].Results, window); err != nil {
+			return nil, err
+		}
+	}
+	return responses, nil
+}
+
+// postProcess applies reranking, grouping and MMR to a response's candidates
+func postProcess(response *types.SearchResponse, opts SearchOptions) {
+	if opts.Rerank != nil {
+		opts.Rerank(response)
+	}
+	if opts.GroupBy != "" {
+		response.Results = GroupResults(response.Results, opts.GroupBy)
+	}
+	if opts.Diversify {
+		response.Results = MMR(response.Results, opts.Limit, opts.Lambda)
+	}
+	if len(response.Results) > opts.Limit {
+		response.Results = response.Results[:opts.Limit]
+	}
+}
+
+// GroupResults keeps the highest-scoring result per group and records the IDs it absorbed.
+// Results must already be sorted by score.
+func GroupResults(results []types.SearchResult, field string) []types.SearchResult {
+	grouped := make([]types.SearchResult, 0, len(results))
+	index := make(map[string]int)
+
+	for _, result := range results {
+		key := groupKey(result.Vector, field)
+		if i, exists := index[key]; exists {
+			grouped[i].Collapsed = append(grouped[i].Collapsed, result.Vector.ID)
+			continue
+		}
+
+		index[key] = len(grouped)
+		result.Collapsed = nil
+		grouped = append(grouped, result)
+	}
+
+	return grouped
+}
+
+func groupKey(vector types.Vector, field string) string {
+	if value, exists := vector.Metadata[field]; exists && value != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	if field == "doc_id" {
+		return vector.ID
+	}
+	// Vectors without the field are never grouped together
+	return "\x00" + vector.ID
+}
+
+// MMR selects up to limit results by maximal marginal relevance:
+// each pick maximises lambda*relevance - (1-lambda)*max similarity to results already picked.
+func MMR(results []types.SearchResult, limit int, lambda float64) []types.SearchResult {
+	if limit <= 0 || limit > len(results) {
+		limit = len(results)
+	}
+
+	selected := make([]types.SearchResult, 0, limit)
+	remaining := append([]types.SearchResult(nil), results...)
+
+	// maxSimilarity[i] tracks the closest selected result to remaining[i]
+	maxSimilarity := make([]float64, len(remaining))
+
+	for len(selected) < limit && len(remaining) > 0 {
+		best := 0
+		bestScore := math.Inf(-1)
+		for i, candidate := range remaining {
+			score := lambda*candidate.Score - (1-lambda)*maxSimilarity[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		pick := remaining[best]
+		selected = append(selected, pick)
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		maxSimilarity = append(maxSimilarity[:best], maxSimilarity[best+1:]...)
+
+		for i, candidate := range remaining {
+			maxSimilarity[i] = math.Max(maxSimilarity[i], cosineSimilarity(pick.Vector.Embedding, candidate.Vector.Embedding))
+		}
+	}
+
+	return selected
+}
+
+// BlendEmbedding mixes a unit-length preference into a query embedding: the result is the
+// normalized sum of (1-weight) of the normalized query and weight of the preference
+func BlendEmbedding(query, preference []float32, weight float64) []float32 {
+	var length float64
+	for _, value := range query {
+		length += float64(value) * float64(value)
+	}
+	if length == 0 || len(query) != len(preference) {
+		return query
+	}
+	length = math.Sqrt(length)
+
+	blended := make([]float32, len(query))
+	var blendedLength float64
+	for i := range query {
+		value := (1-weight)*float64(query[i])/length + weight*float64(preference[i])
+		blended[i] = float32(value)
+		blendedLength += value * value
+	}
+	if blendedLength == 0 {
+		return query
+	}
+	blendedLength = math.Sqrt(blendedLength)
+	for i := range blended {
+		blended[i] = float32(float64(blended[i]) / blendedLength)
+	}
+	return blended
+}
+
+// cosineSimilarity calculates cosine similarity between two embeddings
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dotProduct, normA, normB float64
+	for i := range a {
+		dotProduct += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return dotProduct / (math.Sqrt(normA) * math.Sqrt(normB))
+}
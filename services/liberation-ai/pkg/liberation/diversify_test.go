@@ -0,0 +1,153 @@
+package liberation
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+
+	"liberation-ai/pkg/types"
+)
+
+// cannedStore answers every search with the same results and keeps the last request
+type cannedStore struct {
+	VectorStore
+	results []types.SearchResult
+	request *types.SearchRequest
+}
+
+func (s *cannedStore) Search(ctx context.Context, req *types.SearchRequest) (*types.SearchResponse, error) {
+	s.request = req
+	return &types.SearchResponse{Results: append([]types.SearchResult(nil), s.results...), Store: "canned"}, nil
+}
+
+func result(id string, score float64, embedding []float32, metadata map[string]interface{}) types.SearchResult {
+	return types.SearchResult{Vector: types.Vector{ID: id, Embedding: embedding, Metadata: metadata}, Score: score}
+}
+
+func ids(results []types.SearchResult) []string {
+	out := make([]string, len(results))
+	for i, r := range results {
+		out[i] = r.Vector.ID
+	}
+	return out
+}
+
+func TestGroupResults(t *testing.T) {
+	results := []types.SearchResult{
+		result("guide#0", 0.9, nil, map[string]interface{}{"doc_id": "guide", "lang": "en"}),
+		result("faq", 0.8, nil, map[string]interface{}{"lang": "de"}),
+		result("guide#2", 0.7, nil, map[string]interface{}{"doc_id": "guide", "lang": "en"}),
+		result("notes", 0.6, nil, nil),
+		result("guide#1", 0.5, nil, map[string]interface{}{"doc_id": "guide"}),
+	}
+	results[0].Collapsed = []string{"stale"}
+
+	grouped := GroupResults(results, "doc_id")
+	if !slices.Equal(ids(grouped), []string{"guide#0", "faq", "notes"}) {
+		t.Errorf("grouped by doc_id: %v", ids(grouped))
+	}
+	if !slices.Equal(grouped[0].Collapsed, []string{"guide#2", "guide#1"}) || grouped[1].Collapsed != nil {
+		t.Errorf("collapsed %v and %v", grouped[0].Collapsed, grouped[1].Collapsed)
+	}
+
+	// Vectors without the field are never grouped together, even with each other
+	byLang := GroupResults(results, "lang")
+	if !slices.Equal(ids(byLang), []string{"guide#0", "faq", "notes", "guide#1"}) {
+		t.Errorf("grouped by lang: %v", ids(byLang))
+	}
+}
+
+func TestMMR(t *testing.T) {
+	results := []types.SearchResult{
+		result("a", 0.9, []float32{1, 0}, nil),
+		result("a-copy", 0.89, []float32{1, 0}, nil),
+		result("b", 0.7, []float32{0, 1}, nil),
+	}
+	for _, tc := range []struct {
+		limit  int
+		lambda float64
+		want   []string
+	}{
+		{0, 0.5, []string{"a", "b", "a-copy"}},
+		{2, 0.5, []string{"a", "b"}},
+		{10, 1, []string{"a", "a-copy", "b"}},
+	} {
+		if got := ids(MMR(results, tc.limit, tc.lambda)); !slices.Equal(got, tc.want) {
+			t.Errorf("MMR(limit %d, lambda %v) = %v, want %v", tc.limit, tc.lambda, got, tc.want)
+		}
+	}
+	if !slices.Equal(ids(results), []string{"a", "a-copy", "b"}) {
+		t.Errorf("MMR reordered its input: %v", ids(results))
+	}
+}
+
+func TestBlendEmbedding(t *testing.T) {
+	query, preference := []float32{3, 4}, []float32{1, 0}
+	for _, tc := range []struct {
+		weight float64
+		want   []float32
+	}{
+		{0, []float32{0.6, 0.8}},
+		{1, []float32{1, 0}},
+		{0.5, []float32{float32(0.8 / math.Sqrt(0.8)), float32(0.4 / math.Sqrt(0.8))}},
+	} {
+		got := BlendEmbedding(query, preference, tc.weight)
+		for i := range got {
+			if math.Abs(float64(got[i]-tc.want[i])) > 1e-6 {
+				t.Errorf("weight %v: %v, want %v", tc.weight, got, tc.want)
+				break
+			}
+		}
+	}
+	if got := BlendEmbedding([]float32{0, 0}, preference, 0.5); !slices.Equal(got, []float32{0, 0}) {
+		t.Errorf("zero query blended to %v", got)
+	}
+	if got := BlendEmbedding(query, []float32{1}, 0.5); !slices.Equal(got, query) {
+		t.Errorf("mismatched preference blended to %v", got)
+	}
+}
+
+func TestSearchTextVariants(t *testing.T) {
+	store := &cannedStore{results: []types.SearchResult{
+		result("guide#0", 0.9, []float32{1, 0, 0}, map[string]interface{}{"doc_id": "guide"}),
+		result("guide#1", 0.85, []float32{1, 0, 0}, map[string]interface{}{"doc_id": "guide"}),
+		result("faq", 0.8, []float32{0, 1, 0}, nil),
+		result("notes", 0.75, []float32{0, 0, 1}, nil),
+	}}
+	service := New(store, NewHashEmbedder(3))
+	ctx := context.Background()
+
+	if _, err := service.SearchTextVariants(ctx, "docs", "ladder", nil); err == nil {
+		t.Error("no variants accepted")
+	}
+	if _, err := service.SearchTextWithOptions(ctx, "docs", "ladder", SearchOptions{Lambda: 1.5}); err == nil {
+		t.Error("lambda 1.5 accepted")
+	}
+
+	responses, err := service.SearchTextVariants(ctx, "docs", "ladder", []SearchOptions{
+		{Limit: 2, GroupBy: "doc_id", Filters: map[string]interface{}{"lang": "en"}},
+		{Limit: 3, Rerank: func(r *types.SearchResponse) { slices.Reverse(r.Results) }},
+		{Limit: 3, Diversify: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Grouping and diversification fetch four times the limit, once for every variant
+	if store.request.Limit != 12 || store.request.Filters["lang"] != "en" {
+		t.Errorf("store searched with %+v", store.request)
+	}
+	for i, want := range [][]string{
+		{"guide#0", "faq"},
+		{"notes", "faq", "guide#1"},
+		{"guide#0", "faq", "notes"},
+	} {
+		if got := ids(responses[i].Results); !slices.Equal(got, want) {
+			t.Errorf("variant %d: %v, want %v", i, got, want)
+		}
+	}
+	// Each variant post-processes its own copy of the candidates
+	if !slices.Equal(ids(store.results), []string{"guide#0", "guide#1", "faq", "notes"}) || responses[0].Results[0].Collapsed[0] != "guide#1" {
+		t.Errorf("candidates shared between variants: %v", ids(store.results))
+	}
+}
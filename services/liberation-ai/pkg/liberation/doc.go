@@ -0,0 +1,14 @@
+// Package liberation embeds the Liberation AI vector service in-process.
+//
+// Applications that only need search can use it instead of running the HTTP server:
+//
+//	svc := liberation.New(liberation.NewMemoryStore(384), liberation.NewHashEmbedder(384))
+//	defer svc.Close()
+//	svc.StoreDocuments(ctx, "kb", []liberation.Document{{ID: "refunds", Content: "..."}})
+//	results, err := svc.SearchText(ctx, "kb", "how do refunds work", 5)
+//
+// A Service is safe for concurrent use. Close waits for the calls in progress, then closes
+// the store; later calls fail with ErrClosed.
+//
+// The liberation-ai server is a thin HTTP wrapper around the same Service.
+package liberation
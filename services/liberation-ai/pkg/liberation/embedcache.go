@@ -0,0 +1,104 @@
+package liberation
+
+import (
+	"context"
+	"fmt"
+
+	"liberation-ai/internal/embedcache"
+	"liberation-ai/internal/residency"
+)
+
+// Embedding cache types, so embedding applications can configure the cache without
+// importing internal packages
+type (
+	// EmbeddingCache is a least-recently-used cache of embeddings, safe for concurrent use
+	EmbeddingCache = embedcache.Cache
+	// EmbeddingCacheConfig bounds the cache by entries and memory, and excludes namespaces
+	EmbeddingCacheConfig = embedcache.Config
+	// EmbeddingCacheStats describes the cache's contents and how often it was useful
+	EmbeddingCacheStats = embedcache.Stats
+)
+
+// DefaultEmbeddingCacheConfig caches up to 100,000 embeddings in at most 256 MB
+func DefaultEmbeddingCacheConfig() EmbeddingCacheConfig {
+	return embedcache.DefaultConfig()
+}
+
+// NewEmbeddingCache creates an embedding cache, which can be shared by several services
+func NewEmbeddingCache(config EmbeddingCacheConfig) (*EmbeddingCache, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return embedcache.New(config), nil
+}
+
+// SetEmbeddingCache reuses embeddings of text already embedded by the same model, in any
+// namespace the cache does not exclude. Encrypted namespaces are excluded as well.
+func (s *Service) SetEmbeddingCache(cache *EmbeddingCache) {
+	s.embedCache = cache
+}
+
+// EmbeddingCacheStats describes the embedding cache; the zero value when there is none
+func (s *Service) EmbeddingCacheStats() EmbeddingCacheStats {
+	if s.embedCache == nil {
+		return EmbeddingCacheStats{}
+	}
+	return s.embedCache.Stats()
+}
+
+// embedTexts embeds texts with the namespace's model, taking what it can from the embedding
+// cache. Texts repeated within the batch are embedded once. It returns the model's name,
+// which is empty for the default.
+func (s *Service) embedTexts(ctx context.Context, namespace string, texts []string) ([][]float32, string, error) {
+	// Queries are data too, so searches of a pinned namespace are held to its region as well
+	if err := s.residency.Check(namespace, residency.BackendEmbeddings); err != nil {
+		return nil, "", err
+	}
+	embedder, model := s.embedderFor(namespace)
+	cache := s.embedCache
+	if cache != nil && s.Encrypted(namespace) {
+		cache.Exclude(namespace)
+	}
+
+	embeddings := make([][]float32, len(texts))
+	var missing []string
+	// wanted maps each text still to embed to the positions waiting for it
+	wanted := make(map[string][]int)
+	for i, text := range texts {
+		if cache != nil {
+			if embedding, ok := cache.Get(namespace, model, text); ok {
+				embeddings[i] = embedding
+				continue
+			}
+		}
+		if _, ok := wanted[text]; !ok {
+			missing = append(missing, text)
+		}
+		wanted[text] = append(wanted[text], i)
+	}
+	if len(missing) == 0 {
+		return embeddings, model, nil
+	}
+
+	fresh, err := embedder.Embed(ctx, missing)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(fresh) != len(missing) {
+		return nil, "", fmt.Errorf("embedding provider returned %d embeddings for %d texts", len(fresh), len(missing))
+	}
+	for i, text := range missing {
+		for n, position := range wanted[text] {
+			if n == 0 {
+				embeddings[position] = fresh[i]
+			} else {
+				// Each vector gets its own slice, so one can be changed without the others
+				embeddings[position] = append([]float32(nil), fresh[i]...)
+			}
+		}
+		if cache != nil {
+			cache.Put(namespace, model, text, fresh[i])
+		}
+	}
+	return embeddings, model, nil
+}
@@ -0,0 +1,61 @@
+package liberation
+
+import "context"
+
+// EmbeddingProvider turns text into embeddings.
+// Implementations must be safe for concurrent use and return one embedding per input text.
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	Dimensions() int
+}
+
+// HashEmbedder creates simple hash-based embeddings for demos and tests.
+// In production, use a real embedding model like sentence-transformers.
+type HashEmbedder struct {
+	dimensions int
+}
+
+// NewHashEmbedder creates a hash embedder producing vectors of the given size
+func NewHashEmbedder(dimensions int) *HashEmbedder {
+	return &HashEmbedder{dimensions: dimensions}
+}
+
+// Dimensions implements EmbeddingProvider.Dimensions
+func (h *HashEmbedder) Dimensions() int {
+	return h.dimensions
+}
+
+// Embed implements EmbeddingProvider.Embed
+func (h *HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = h.embed(text)
+	}
+	return embeddings, nil
+}
+
+func (h *HashEmbedder) embed(text string) []float32 {
+	embedding := make([]float32, h.dimensions)
+
+	// Convert text to bytes for hashing
+	textBytes := []byte(text)
+
+	// Generate embedding using a simple algorithm
+	for i := 0; i < h.dimensions; i++ {
+		var sum float32
+		for j, b := range textBytes {
+			// Simple hash function combining character values and positions
+			sum += float32(b) * float32(j+1) * float32(i+1)
+		}
+		// Normalize to [-1, 1] range
+		embedding[i] = (sum / 1000000.0) - 0.5
+		if embedding[i] > 1.0 {
+			embedding[i] = 1.0
+		}
+		if embedding[i] < -1.0 {
+			embedding[i] = -1.0
+		}
+	}
+
+	return embedding
+}
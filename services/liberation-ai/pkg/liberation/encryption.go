@@ -0,0 +1,490 @@
+package liberation
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"liberation-ai/internal/encryption"
+	"liberation-ai/pkg/types"
+)
+
+// Encryption types, so embedding applications can load a keyring without importing
+// internal packages
+type (
+	// Keyring holds the data keys of encrypted namespaces, wrapped by a master key
+	Keyring = encryption.Keyring
+	// EncryptionConfig lists the encrypted namespaces, the master key and the keyring file
+	EncryptionConfig = encryption.Config
+	// KeyStatus describes the data keys of one encrypted namespace
+	KeyStatus = encryption.KeyStatus
+)
+
+var (
+	// ErrNotEncrypted is returned when rotating the key of a namespace that is not encrypted
+	ErrNotEncrypted = encryption.ErrNotEncrypted
+	// ErrUnknownKey is returned for vectors sealed with a data key the keyring does not hold
+	ErrUnknownKey = encryption.ErrUnknownKey
+)
+
+// DefaultEncryptionConfig encrypts nothing and keeps the keyring in data/keyring.json
+func DefaultEncryptionConfig() EncryptionConfig {
+	return encryption.DefaultConfig()
+}
+
+// LoadKeyring opens the keyring file, creating data keys for namespaces that have none.
+// An empty KeyringFile keeps the keys in memory, so they are lost when the process exits.
+func LoadKeyring(config EncryptionConfig) (*Keyring, error) {
+	return encryption.Load(config)
+}
+
+// Metadata keys of encrypted vectors. doc_id and chunk_index stay readable so documents'
+// chunks can still be found and pruned; everything else is in the sealed payload.
+const (
+	metadataSealed     = "_sealed"
+	metadataKeyVersion = "_key_version"
+)
+
+// encryptedPage is how many vectors are decrypted at a time when scanning a namespace
+const encryptedPage = 500
+
+// encryptedStore seals the vectors of encrypted namespaces before they reach the store
+// and opens them on the way back. The store only ever sees a placeholder embedding, so
+// searches of those namespaces scan and score the decrypted vectors here.
+type encryptedStore struct {
+	types.VectorStore
+	keyring *encryption.Keyring
+
+	// rotating serializes re-encryption, so two rotations cannot retire each other's keys
+	rotating sync.Mutex
+}
+
+// KeyRotation reports a namespace's move to a new data key
+type KeyRotation struct {
+	Namespace string `json:"namespace"`
+	Version   int    `json:"key_version"`
+	// Reencrypted counts the vectors sealed with an older key, or not sealed at all, that
+	// were rewritten with the new one
+	Reencrypted    int64 `json:"reencrypted"`
+	ProcessingTime int64 `json:"processing_time_ms"`
+}
+
+// SetEncryption encrypts the embeddings, text and metadata of the keyring's namespaces at
+// rest. Vectors stored before a namespace was encrypted are served as they are until its
+// key is rotated.
+func (s *Service) SetEncryption(keyring *Keyring) {
+	s.store = &encryptedStore{VectorStore: s.store, keyring: keyring}
+}
+
+// Encrypted reports whether a namespace's vectors are encrypted at rest
+func (s *Service) Encrypted(namespace string) bool {
+	encrypted, ok := s.store.(*encryptedStore)
+	return ok && encrypted.keyring.Encrypted(namespace)
+}
+
+// EncryptionStatus describes the data keys of every encrypted namespace
+func (s *Service) EncryptionStatus() []KeyStatus {
+	if encrypted, ok := s.store.(*encryptedStore); ok {
+		return encrypted.keyring.Status()
+	}
+	return []KeyStatus{}
+}
+
+// RotateNamespaceKey gives an encrypted namespace a new data key, re-encrypts its vectors
+// with it and then forgets the older keys. Vectors written during the rotation are sealed
+// with the new key and left alone, but a write landing between a page being read and
+// rewritten is lost, so rotate while the namespace is quiet.
+func (s *Service) RotateNamespaceKey(ctx context.Context, namespace string) (*KeyRotation, error) {
+	encrypted, ok := s.store.(*encryptedStore)
+	if !ok || !encrypted.keyring.Encrypted(namespace) {
+		return nil, fmt.Errorf("%w: %s", ErrNotEncrypted, namespace)
+	}
+	return encrypted.rotate(ctx, namespace)
+}
+
+// additionalData binds a sealed payload to the vector it was written for, so it cannot be
+// copied onto another vector
+func additionalData(namespace, id string) []byte {
+	return []byte(namespace + "\x00" + id)
+}
+
+// sealVector returns the form of vector the store keeps: readable chunk bookkeeping, a
+// placeholder embedding of the same size and everything else sealed
+func (e *encryptedStore) sealVector(namespace string, vector types.Vector) (types.Vector, error) {
+	metadata := make(map[string]interface{}, 4)
+	private := make(map[string]interface{}, len(vector.Metadata))
+	for key, value := range vector.Metadata {
+		switch key {
+		case types.MetadataDocID, types.MetadataChunkIndex:
+			metadata[key] = value
+		case metadataSealed, metadataKeyVersion:
+		default:
+			private[key] = value
+		}
+	}
+	encodedMetadata, err := json.Marshal(private)
+	if err != nil {
+		return types.Vector{}, fmt.Errorf("failed to encode metadata of %s: %w", vector.ID, err)
+	}
+
+	// Embedding floats, then the text's length and the text, then the metadata JSON
+	payload := make([]byte, 0, 8+4*len(vector.Embedding)+len(vector.Text)+len(encodedMetadata))
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(len(vector.Embedding)))
+	for _, value := range vector.Embedding {
+		payload = binary.LittleEndian.AppendUint32(payload, math.Float32bits(value))
+	}
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(len(vector.Text)))
+	payload = append(payload, vector.Text...)
+	payload = append(payload, encodedMetadata...)
+
+	sealed, version, err := e.keyring.Seal(namespace, payload, additionalData(namespace, vector.ID))
+	if err != nil {
+		return types.Vector{}, err
+	}
+	metadata[metadataSealed] = base64.StdEncoding.EncodeToString(sealed)
+	metadata[metadataKeyVersion] = version
+
+	placeholder := make([]float32, len(vector.Embedding))
+	if len(placeholder) > 0 {
+		placeholder[0] = 1
+	}
+	return types.Vector{
+		ID:        vector.ID,
+		Embedding: placeholder,
+		Metadata:  metadata,
+		Namespace: namespace,
+		CreatedAt: vector.CreatedAt,
+	}, nil
+}
+
+// keyVersion returns the data key version a stored vector was sealed with, or false for
+// vectors stored in the clear
+func keyVersion(vector types.Vector) (int, bool) {
+	if _, sealed := vector.Metadata[metadataSealed]; !sealed {
+		return 0, false
+	}
+	switch version := vector.Metadata[metadataKeyVersion].(type) {
+	case int:
+		return version, true
+	case float64:
+		// Metadata decoded from JSON
+		return int(version), true
+	}
+	return 0, true
+}
+
+// openVector restores a stored vector. Vectors that were never sealed are returned unchanged.
+func (e *encryptedStore) openVector(vector types.Vector) (types.Vector, error) {
+	version, sealed := keyVersion(vector)
+	if !sealed {
+		return vector, nil
+	}
+	encoded, _ := vector.Metadata[metadataSealed].(string)
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return types.Vector{}, fmt.Errorf("vector %s/%s has a malformed sealed payload", vector.Namespace, vector.ID)
+	}
+	payload, err := e.keyring.Open(vector.Namespace, version, ciphertext, additionalData(vector.Namespace, vector.ID))
+	if err != nil {
+		return types.Vector{}, fmt.Errorf("failed to decrypt vector %s/%s: %w", vector.Namespace, vector.ID, err)
+	}
+
+	malformed := func() error {
+		return fmt.Errorf("vector %s/%s decrypted to a malformed payload", vector.Namespace, vector.ID)
+	}
+	if len(payload) < 4 {
+		return types.Vector{}, malformed()
+	}
+	dimensions := int(binary.LittleEndian.Uint32(payload))
+	payload = payload[4:]
+	if len(payload) < 4*dimensions+4 {
+		return types.Vector{}, malformed()
+	}
+	embedding := make([]float32, dimensions)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(payload[4*i:]))
+	}
+	payload = payload[4*dimensions:]
+	textLength := int(binary.LittleEndian.Uint32(payload))
+	payload = payload[4:]
+	if len(payload) < textLength {
+		return types.Vector{}, malformed()
+	}
+
+	metadata := make(map[string]interface{})
+	if err := json.Unmarshal(payload[textLength:], &metadata); err != nil {
+		return types.Vector{}, malformed()
+	}
+	for _, key := range []string{types.MetadataDocID, types.MetadataChunkIndex} {
+		if value, ok := vector.Metadata[key]; ok {
+			metadata[key] = value
+		}
+	}
+
+	vector.Embedding = embedding
+	vector.Text = string(payload[:textLength])
+	vector.Metadata = metadata
+	return vector, nil
+}
+
+func (e *encryptedStore) openVectors(vectors []types.Vector) ([]types.Vector, error) {
+	for i := range vectors {
+		opened, err := e.openVector(vectors[i])
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = opened
+	}
+	return vectors, nil
+}
+
+// scan passes every vector of an encrypted namespace, decrypted, to visit a page at a time
+func (e *encryptedStore) scan(ctx context.Context, namespace string, visit func([]types.Vector) error) error {
+	after := ""
+	for {
+		page, err := e.VectorStore.List(ctx, namespace, after, encryptedPage)
+		if err != nil || len(page) == 0 {
+			return err
+		}
+		after = page[len(page)-1].ID
+		opened, err := e.openVectors(page)
+		if err != nil {
+			return err
+		}
+		if err := visit(opened); err != nil {
+			return err
+		}
+		if len(page) < encryptedPage {
+			return nil
+		}
+	}
+}
+
+// Store implements VectorStore.Store, sealing vectors of encrypted namespaces
+func (e *encryptedStore) Store(ctx context.Context, req *types.StoreRequest) (*types.StoreResponse, error) {
+	if !e.keyring.Encrypted(req.Namespace) {
+		return e.VectorStore.Store(ctx, req)
+	}
+	sealed := &types.StoreRequest{Namespace: req.Namespace, Vectors: make([]types.Vector, len(req.Vectors))}
+	for i, vector := range req.Vectors {
+		var err error
+		if sealed.Vectors[i], err = e.sealVector(req.Namespace, vector); err != nil {
+			return nil, err
+		}
+	}
+	return e.VectorStore.Store(ctx, sealed)
+}
+
+// Search implements VectorStore.Search. Encrypted namespaces are searched exhaustively:
+// every vector is decrypted, filtered and scored, keeping the best Limit as it goes.
+func (e *encryptedStore) Search(ctx context.Context, req *types.SearchRequest) (*types.SearchResponse, error) {
+	if !e.keyring.Encrypted(req.Namespace) {
+		return e.VectorStore.Search(ctx, req)
+	}
+	start := time.Now()
+	results := []types.SearchResult{}
+	best := func() {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+		if req.Limit > 0 && len(results) > req.Limit {
+			results = results[:req.Limit]
+		}
+	}
+
+	err := e.scan(ctx, req.Namespace, func(page []types.Vector) error {
+		for _, vector := range page {
+			if len(vector.Embedding) != len(req.Embedding) {
+				return &types.DimensionError{Expected: len(vector.Embedding), Got: len(req.Embedding), Query: true}
+			}
+			if !types.MatchesFilters(vector.Metadata, req.Filters) {
+				continue
+			}
+			similarity := cosineSimilarity(req.Embedding, vector.Embedding)
+			if req.Threshold > 0 && similarity < req.Threshold {
+				continue
+			}
+			results = append(results, types.SearchResult{Vector: vector, Score: similarity, Distance: 1 - similarity})
+		}
+		best()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &types.SearchResponse{
+		Results:        results,
+		ProcessingTime: time.Since(start).Milliseconds(),
+		Store:          "encrypted",
+	}, nil
+}
+
+// Get implements VectorStore.Get
+func (e *encryptedStore) Get(ctx context.Context, namespace, id string) (*types.Vector, error) {
+	vector, err := e.VectorStore.Get(ctx, namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := e.openVector(*vector)
+	if err != nil {
+		return nil, err
+	}
+	return &opened, nil
+}
+
+// List implements VectorStore.List
+func (e *encryptedStore) List(ctx context.Context, namespace, after string, limit int) ([]types.Vector, error) {
+	vectors, err := e.VectorStore.List(ctx, namespace, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	return e.openVectors(vectors)
+}
+
+// Chunks implements VectorStore.Chunks
+func (e *encryptedStore) Chunks(ctx context.Context, namespace, docID string, from, to int) ([]types.Vector, error) {
+	vectors, err := e.VectorStore.Chunks(ctx, namespace, docID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return e.openVectors(vectors)
+}
+
+// UpdateMetadata implements VectorStore.UpdateMetadata. Sealed metadata cannot be changed in
+// the store, so the selected vectors are decrypted, updated and sealed again.
+func (e *encryptedStore) UpdateMetadata(ctx context.Context, update *types.MetadataUpdate) (*types.MetadataUpdateResponse, error) {
+	if !e.keyring.Encrypted(update.Namespace) {
+		return e.VectorStore.UpdateMetadata(ctx, update)
+	}
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	var updated int64
+	write := func(selected []types.Vector) error {
+		if len(selected) == 0 {
+			return nil
+		}
+		for i := range selected {
+			selected[i].Metadata = update.Apply(selected[i].Metadata)
+		}
+		if _, err := e.Store(ctx, &types.StoreRequest{Namespace: update.Namespace, Vectors: selected}); err != nil {
+			return err
+		}
+		updated += int64(len(selected))
+		return nil
+	}
+
+	if len(update.IDs) > 0 {
+		var selected []types.Vector
+		for _, id := range update.IDs {
+			vector, err := e.Get(ctx, update.Namespace, id)
+			if errors.Is(err, types.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			selected = append(selected, *vector)
+		}
+		if err := write(selected); err != nil {
+			return nil, err
+		}
+	} else {
+		err := e.scan(ctx, update.Namespace, func(page []types.Vector) error {
+			var selected []types.Vector
+			for _, vector := range page {
+				if types.MatchesFilters(vector.Metadata, update.Filters) {
+					selected = append(selected, vector)
+				}
+			}
+			return write(selected)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &types.MetadataUpdateResponse{
+		Updated:        updated,
+		ProcessingTime: time.Since(start).Milliseconds(),
+		Store:          "encrypted",
+	}, nil
+}
+
+// Clone implements VectorStore.Clone. Sealed vectors are bound to their namespace and key,
+// so encrypted namespaces cannot be copied by the store.
+func (e *encryptedStore) Clone(ctx context.Context, source, target string, progress func(copied, total int64)) (int64, error) {
+	for _, namespace := range []string{source, target} {
+		if e.keyring.Encrypted(namespace) {
+			return 0, fmt.Errorf("namespace %s is encrypted and cannot be cloned", namespace)
+		}
+	}
+	return e.VectorStore.Clone(ctx, source, target, progress)
+}
+
+// IndexMetadata implements types.MetadataIndexer when the wrapped store does
+func (e *encryptedStore) IndexMetadata(ctx context.Context, field string, fieldType types.FieldType) error {
+	if indexer, ok := e.VectorStore.(types.MetadataIndexer); ok {
+		return indexer.IndexMetadata(ctx, field, fieldType)
+	}
+	return nil
+}
+
+// rotate moves a namespace to a new data key, rewriting every vector not yet sealed with it
+func (e *encryptedStore) rotate(ctx context.Context, namespace string) (*KeyRotation, error) {
+	e.rotating.Lock()
+	defer e.rotating.Unlock()
+	start := time.Now()
+
+	version, err := e.keyring.Rotate(namespace)
+	if err != nil {
+		return nil, err
+	}
+	rotation := &KeyRotation{Namespace: namespace, Version: version}
+
+	after := ""
+	for {
+		page, err := e.VectorStore.List(ctx, namespace, after, encryptedPage)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+		after = page[len(page)-1].ID
+
+		var stale []types.Vector
+		for _, vector := range page {
+			if current, sealed := keyVersion(vector); sealed && current >= version {
+				continue
+			}
+			opened, err := e.openVector(vector)
+			if err != nil {
+				return nil, err
+			}
+			stale = append(stale, opened)
+		}
+		if len(stale) > 0 {
+			if _, err := e.Store(ctx, &types.StoreRequest{Namespace: namespace, Vectors: stale}); err != nil {
+				return nil, err
+			}
+			rotation.Reencrypted += int64(len(stale))
+		}
+		if len(page) < encryptedPage {
+			break
+		}
+	}
+
+	if err := e.keyring.Retire(namespace, version); err != nil {
+		return nil, err
+	}
+	rotation.ProcessingTime = time.Since(start).Milliseconds()
+	return rotation, nil
+}
@@ -0,0 +1,92 @@
+package liberation_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"liberation-ai/pkg/liberation"
+	"liberation-ai/pkg/types"
+)
+
+func ExampleService_SetEncryption() {
+	ctx := context.Background()
+	config := liberation.DefaultEncryptionConfig()
+	config.Namespaces = []string{"private"}
+	config.KeyringFile = ""
+	config.MasterKey = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	keyring, err := liberation.LoadKeyring(config)
+	if err != nil {
+		panic(err)
+	}
+
+	store := liberation.NewMemoryStore(384)
+	svc := liberation.New(store, liberation.NewHashEmbedder(384))
+	svc.SetEncryption(keyring)
+	if _, err := svc.StoreText(ctx, "private", "note", "the garden key is under the blue pot", nil); err != nil {
+		panic(err)
+	}
+
+	// The store only holds the sealed vector; the service opens it again
+	sealed, _ := store.Get(ctx, "private", "note")
+	results, _ := svc.SearchText(ctx, "private", "where is the garden key", 1)
+	fmt.Printf("stored text %q\n", sealed.Text)
+	fmt.Println("found:", results.Results[0].Vector.Text)
+	for _, status := range svc.EncryptionStatus() {
+		fmt.Println(status.Namespace, "key version", status.Current)
+	}
+	// Output:
+	// stored text ""
+	// found: the garden key is under the blue pot
+	// private key version 1
+}
+
+func ExampleService_SetEmbeddingCache() {
+	ctx := context.Background()
+	cache, err := liberation.NewEmbeddingCache(liberation.DefaultEmbeddingCacheConfig())
+	if err != nil {
+		panic(err)
+	}
+
+	// Services sharing a cache embed text another one already embedded only once
+	first := liberation.New(liberation.NewMemoryStore(384), liberation.NewHashEmbedder(384))
+	second := liberation.New(liberation.NewMemoryStore(384), liberation.NewHashEmbedder(384))
+	first.SetEmbeddingCache(cache)
+	second.SetEmbeddingCache(cache)
+	first.StoreText(ctx, "kb", "rides", "rides to the clinic leave at nine", nil)
+	second.StoreText(ctx, "kb", "rides", "rides to the clinic leave at nine", nil)
+
+	stats := second.EmbeddingCacheStats()
+	fmt.Println("entries", stats.Entries, "hits", stats.Hits, "misses", stats.Misses)
+	// Output:
+	// entries 1 hits 1 misses 1
+}
+
+func ExampleService_SetResidency() {
+	ctx := context.Background()
+	policy, err := liberation.NewResidencyPolicy(liberation.ResidencyConfig{
+		Enabled: true,
+		Backends: map[string]string{
+			liberation.BackendVectorStore: "us",
+			liberation.BackendEmbeddings:  "eu",
+		},
+		Namespaces: map[string]string{"eu/*": "eu"},
+	}, []string{liberation.BackendVectorStore, liberation.BackendEmbeddings})
+	if err != nil {
+		panic(err)
+	}
+
+	svc := liberation.New(liberation.NewMemoryStore(384), liberation.NewHashEmbedder(384))
+	svc.SetResidency(policy, []string{liberation.BackendVectorStore})
+
+	// The store is in the US, so a namespace pinned to the EU cannot be written to
+	_, err = svc.StoreText(ctx, "eu/members", "ada", "member since 2021", nil)
+	fmt.Println(errors.Is(err, types.ErrResidency))
+	_, err = svc.StoreText(ctx, "us/members", "ada", "member since 2021", nil)
+	fmt.Println(err)
+	// Output:
+	// true
+	// <nil>
+}
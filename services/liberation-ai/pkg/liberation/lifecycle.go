@@ -0,0 +1,195 @@
+package liberation
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"liberation-ai/pkg/types"
+)
+
+// ErrClosed is returned by calls made after the service was closed
+var ErrClosed = errors.New("liberation: service is closed")
+
+// guardedStore counts the store calls in progress, so closing the store can wait for them.
+// Once closed, every call fails with ErrClosed instead of reaching the store.
+type guardedStore struct {
+	types.VectorStore
+
+	mu       sync.Mutex
+	idle     *sync.Cond
+	inFlight int
+	closed   bool
+}
+
+// indexingGuardedStore is a guardedStore for stores that index metadata fields
+type indexingGuardedStore struct {
+	*guardedStore
+	indexer types.MetadataIndexer
+}
+
+func guard(store types.VectorStore) types.VectorStore {
+	g := &guardedStore{VectorStore: store}
+	g.idle = sync.NewCond(&g.mu)
+	if indexer, ok := store.(types.MetadataIndexer); ok {
+		return &indexingGuardedStore{guardedStore: g, indexer: indexer}
+	}
+	return g
+}
+
+// Close waits for the store calls in progress, including running clones, then closes the
+// store. Calls made afterwards return ErrClosed, and closing again does nothing.
+func (s *Service) Close() error {
+	return s.store.Close()
+}
+
+func (g *guardedStore) enter() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrClosed
+	}
+	g.inFlight++
+	return nil
+}
+
+func (g *guardedStore) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.inFlight--
+	if g.inFlight == 0 {
+		g.idle.Broadcast()
+	}
+}
+
+// Close implements VectorStore.Close
+func (g *guardedStore) Close() error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil
+	}
+	g.closed = true
+	for g.inFlight > 0 {
+		g.idle.Wait()
+	}
+	g.mu.Unlock()
+	return g.VectorStore.Close()
+}
+
+// Store implements VectorStore.Store
+func (g *guardedStore) Store(ctx context.Context, req *types.StoreRequest) (*types.StoreResponse, error) {
+	if err := g.enter(); err != nil {
+		return nil, err
+	}
+	defer g.leave()
+	return g.VectorStore.Store(ctx, req)
+}
+
+// Search implements VectorStore.Search
+func (g *guardedStore) Search(ctx context.Context, req *types.SearchRequest) (*types.SearchResponse, error) {
+	if err := g.enter(); err != nil {
+		return nil, err
+	}
+	defer g.leave()
+	return g.VectorStore.Search(ctx, req)
+}
+
+// Delete implements VectorStore.Delete
+func (g *guardedStore) Delete(ctx context.Context, namespace string, ids []string) error {
+	if err := g.enter(); err != nil {
+		return err
+	}
+	defer g.leave()
+	return g.VectorStore.Delete(ctx, namespace, ids)
+}
+
+// Get implements VectorStore.Get
+func (g *guardedStore) Get(ctx context.Context, namespace string, id string) (*types.Vector, error) {
+	if err := g.enter(); err != nil {
+		return nil, err
+	}
+	defer g.leave()
+	return g.VectorStore.Get(ctx, namespace, id)
+}
+
+// ListNamespaces implements VectorStore.ListNamespaces
+func (g *guardedStore) ListNamespaces(ctx context.Context) ([]string, error) {
+	if err := g.enter(); err != nil {
+		return nil, err
+	}
+	defer g.leave()
+	return g.VectorStore.ListNamespaces(ctx)
+}
+
+// Stats implements VectorStore.Stats
+func (g *guardedStore) Stats(ctx context.Context) (*types.VectorStoreStats, error) {
+	if err := g.enter(); err != nil {
+		return nil, err
+	}
+	defer g.leave()
+	return g.VectorStore.Stats(ctx)
+}
+
+// Migrate implements VectorStore.Migrate
+func (g *guardedStore) Migrate(ctx context.Context, destination types.VectorStore) (*types.MigrationResult, error) {
+	if err := g.enter(); err != nil {
+		return nil, err
+	}
+	defer g.leave()
+	return g.VectorStore.Migrate(ctx, destination)
+}
+
+// Clone implements VectorStore.Clone
+func (g *guardedStore) Clone(ctx context.Context, source, target string, progress func(copied, total int64)) (int64, error) {
+	if err := g.enter(); err != nil {
+		return 0, err
+	}
+	defer g.leave()
+	return g.VectorStore.Clone(ctx, source, target, progress)
+}
+
+// UpdateMetadata implements VectorStore.UpdateMetadata
+func (g *guardedStore) UpdateMetadata(ctx context.Context, update *types.MetadataUpdate) (*types.MetadataUpdateResponse, error) {
+	if err := g.enter(); err != nil {
+		return nil, err
+	}
+	defer g.leave()
+	return g.VectorStore.UpdateMetadata(ctx, update)
+}
+
+// List implements VectorStore.List
+func (g *guardedStore) List(ctx context.Context, namespace, after string, limit int) ([]types.Vector, error) {
+	if err := g.enter(); err != nil {
+		return nil, err
+	}
+	defer g.leave()
+	return g.VectorStore.List(ctx, namespace, after, limit)
+}
+
+// Chunks implements VectorStore.Chunks
+func (g *guardedStore) Chunks(ctx context.Context, namespace, docID string, from, to int) ([]types.Vector, error) {
+	if err := g.enter(); err != nil {
+		return nil, err
+	}
+	defer g.leave()
+	return g.VectorStore.Chunks(ctx, namespace, docID, from, to)
+}
+
+// Health implements VectorStore.Health
+func (g *guardedStore) Health(ctx context.Context) error {
+	if err := g.enter(); err != nil {
+		return err
+	}
+	defer g.leave()
+	return g.VectorStore.Health(ctx)
+}
+
+// IndexMetadata implements types.MetadataIndexer
+func (g *indexingGuardedStore) IndexMetadata(ctx context.Context, field string, fieldType types.FieldType) error {
+	if err := g.enter(); err != nil {
+		return err
+	}
+	defer g.leave()
+	return g.indexer.IndexMetadata(ctx, field, fieldType)
+}
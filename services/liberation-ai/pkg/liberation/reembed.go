@@ -0,0 +1,136 @@
+package liberation
+
+import (
+	"context"
+	"fmt"
+
+	"liberation-ai/pkg/types"
+)
+
+// Reembedding is a stored vector embedded again from its text
+type Reembedding struct {
+	// Vector is a copy of the stored vector carrying the new embedding
+	Vector types.Vector
+	// Model is the model that produced the new embedding, empty for the default
+	Model string
+	// Similarity is the cosine similarity between the stored and the new embedding
+	Similarity float64
+}
+
+// ListVectors pages through a namespace in ID order, starting after the given ID
+func (s *Service) ListVectors(ctx context.Context, namespace, after string, limit int) ([]types.Vector, error) {
+	return s.store.List(ctx, namespace, after, limit)
+}
+
+// EmbeddingText returns the text a stored vector was embedded from: its title and chunk,
+// combined as StoreDocuments combines them
+func EmbeddingText(vector types.Vector) string {
+	title, _ := vector.Metadata["title"].(string)
+	switch {
+	case title == "":
+		return vector.Text
+	case vector.Text == "":
+		return title
+	}
+	return title + " " + vector.Text
+}
+
+// Reembed embeds vectors again from their stored text, each with the model named in its
+// embedding_model metadata, or the default model when it names none or one no longer
+// registered. Vectors stored without text are skipped. Nothing is written.
+func (s *Service) Reembed(ctx context.Context, vectors []types.Vector) ([]Reembedding, error) {
+	byModel := make(map[string][]int)
+	for i, vector := range vectors {
+		if vector.Text == "" {
+			continue
+		}
+		model, _ := vector.Metadata["embedding_model"].(string)
+		if s.registered(model) == nil {
+			model = ""
+		}
+		byModel[model] = append(byModel[model], i)
+	}
+
+	var reembedded []Reembedding
+	for model, indexes := range byModel {
+		embedder := s.registered(model)
+		if embedder == nil {
+			embedder = s.embedder
+		}
+
+		texts := make([]string, len(indexes))
+		for i, index := range indexes {
+			texts[i] = EmbeddingText(vectors[index])
+		}
+		embeddings, err := embedder.Embed(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-embed vectors: %w", err)
+		}
+		if len(embeddings) != len(texts) {
+			return nil, fmt.Errorf("embedding provider returned %d embeddings for %d texts", len(embeddings), len(texts))
+		}
+
+		for i, index := range indexes {
+			vector := vectors[index]
+			// Re-embedding bypasses the cache, which then holds what the model makes today
+			if s.embedCache != nil && !s.Encrypted(vector.Namespace) {
+				s.embedCache.Put(vector.Namespace, model, texts[i], embeddings[i])
+			}
+			similarity := cosineSimilarity(vector.Embedding, embeddings[i])
+			vector.Embedding = embeddings[i]
+			vector.Metadata = copyMetadata(vector.Metadata)
+			if model == "" {
+				delete(vector.Metadata, "embedding_model")
+			}
+			reembedded = append(reembedded, Reembedding{Vector: vector, Model: model, Similarity: similarity})
+		}
+	}
+	return reembedded, nil
+}
+
+// Refresh re-embeds vectors and stores the new embeddings in their place, keeping each
+// vector's ID, text and creation time. Each vector is read again just before it is
+// written: one re-uploaded or deleted since it was listed is skipped, and metadata
+// updated meanwhile is kept. It returns the vectors refreshed.
+func (s *Service) Refresh(ctx context.Context, namespace string, vectors []types.Vector) ([]Reembedding, error) {
+	if err := s.checkResidency(namespace); err != nil {
+		return nil, err
+	}
+	reembedded, err := s.Reembed(ctx, vectors)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := reembedded[:0]
+	var updated []types.Vector
+	for _, r := range reembedded {
+		current, err := s.store.Get(ctx, namespace, r.Vector.ID)
+		if err != nil || current.Text != r.Vector.Text || !current.CreatedAt.Equal(r.Vector.CreatedAt) {
+			continue
+		}
+		r.Vector.Metadata = copyMetadata(current.Metadata)
+		if r.Model == "" {
+			delete(r.Vector.Metadata, "embedding_model")
+		}
+		refreshed = append(refreshed, r)
+		updated = append(updated, r.Vector)
+	}
+	if len(updated) == 0 {
+		return refreshed, nil
+	}
+
+	if _, err := s.store.Store(ctx, &types.StoreRequest{Namespace: namespace, Vectors: updated}); err != nil {
+		return nil, err
+	}
+	return refreshed, nil
+}
+
+// registered returns the embedder registered under name, or nil; "" is never registered
+func (s *Service) registered(name string) EmbeddingProvider {
+	if name == "" {
+		return nil
+	}
+	s.embeddersMu.RLock()
+	defer s.embeddersMu.RUnlock()
+	return s.embedders[name]
+}
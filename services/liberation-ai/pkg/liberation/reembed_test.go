@@ -0,0 +1,142 @@
+package liberation
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"liberation-ai/pkg/types"
+)
+
+// angledEmbedder embeds every text at the same angle from [1, 0, 0], so a vector stored
+// as [1, 0, 0] re-embeds with exactly the configured cosine similarity
+type angledEmbedder struct {
+	similarity float64
+}
+
+func (a *angledEmbedder) Dimensions() int { return 3 }
+
+func (a *angledEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i := range texts {
+		embeddings[i] = []float32{float32(a.similarity), float32(math.Sqrt(1 - a.similarity*a.similarity)), 0}
+	}
+	return embeddings, nil
+}
+
+func storeUnitVectors(t *testing.T, service *Service) {
+	t.Helper()
+	unit := func(id, text string, metadata map[string]interface{}) types.Vector {
+		return types.Vector{ID: id, Text: text, Embedding: []float32{1, 0, 0}, Metadata: metadata}
+	}
+	if _, err := service.StoreVectors(context.Background(), &types.StoreRequest{Namespace: "docs", Vectors: []types.Vector{
+		unit("a", "lend it", map[string]interface{}{"title": "Ladder"}),
+		unit("b", "swap", map[string]interface{}{"embedding_model": "other"}),
+		unit("c", "sow", map[string]interface{}{"embedding_model": "retired"}),
+		unit("d", "", nil),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmbeddingText(t *testing.T) {
+	for _, tc := range []struct {
+		vector types.Vector
+		want   string
+	}{
+		{types.Vector{Text: "lend it", Metadata: map[string]interface{}{"title": "Ladder"}}, "Ladder lend it"},
+		{types.Vector{Text: "lend it"}, "lend it"},
+		{types.Vector{Metadata: map[string]interface{}{"title": "Ladder"}}, "Ladder"},
+		{types.Vector{Text: "lend it", Metadata: map[string]interface{}{"title": 3}}, "lend it"},
+	} {
+		if got := EmbeddingText(tc.vector); got != tc.want {
+			t.Errorf("EmbeddingText(%+v) = %q, want %q", tc.vector, got, tc.want)
+		}
+	}
+}
+
+func TestReembed(t *testing.T) {
+	ctx := context.Background()
+	service := New(NewMemoryStore(3), &angledEmbedder{similarity: 1})
+	if err := service.RegisterEmbedder("other", &angledEmbedder{similarity: 0.6}); err != nil {
+		t.Fatal(err)
+	}
+	storeUnitVectors(t, service)
+	service.embedder = &angledEmbedder{similarity: 0.8}
+
+	vectors, err := service.ListVectors(ctx, "docs", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reembedded, err := service.Reembed(ctx, vectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each vector is re-embedded with its own model; unknown models fall back to the default
+	byID := make(map[string]Reembedding)
+	for _, r := range reembedded {
+		byID[r.Vector.ID] = r
+	}
+	for id, want := range map[string]struct {
+		model      string
+		similarity float64
+		tagged     bool
+	}{
+		"a": {"", 0.8, false},
+		"b": {"other", 0.6, true},
+		"c": {"", 0.8, false},
+	} {
+		r := byID[id]
+		_, tagged := r.Vector.Metadata["embedding_model"]
+		if r.Model != want.model || math.Abs(r.Similarity-want.similarity) > 1e-6 || tagged != want.tagged {
+			t.Errorf("%s re-embedded as %+v", id, r)
+		}
+	}
+	if len(reembedded) != 3 {
+		t.Errorf("%d vectors re-embedded; the one without text should be skipped", len(reembedded))
+	}
+
+	// Nothing is written
+	if stored, _ := service.GetVector(ctx, "docs", "c"); stored.Embedding[0] != 1 || stored.Metadata["embedding_model"] != "retired" {
+		t.Errorf("re-embedding changed the stored vector to %+v", stored)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	service := New(NewMemoryStore(3), &angledEmbedder{similarity: 1})
+	storeUnitVectors(t, service)
+	listed, err := service.ListVectors(ctx, "docs", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := service.GetVector(ctx, "docs", "a")
+
+	// Between listing and refreshing: a's metadata is edited, b is re-uploaded and c deleted
+	if _, err := service.UpdateMetadata(ctx, &types.MetadataUpdate{Namespace: "docs", IDs: []string{"a"}, Metadata: map[string]interface{}{"reviewed": true}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.StoreVectors(ctx, &types.StoreRequest{Namespace: "docs", Vectors: []types.Vector{{ID: "b", Text: "swap seeds", Embedding: []float32{1, 0, 0}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeleteVectors(ctx, "docs", []string{"c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	service.embedder = &angledEmbedder{similarity: 0.8}
+	refreshed, err := service.Refresh(ctx, "docs", listed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refreshed) != 1 || refreshed[0].Vector.ID != "a" {
+		t.Fatalf("refreshed %+v", refreshed)
+	}
+	after, _ := service.GetVector(ctx, "docs", "a")
+	if math.Abs(float64(after.Embedding[0])-0.8) > 1e-6 || after.Metadata["reviewed"] != true || after.Text != "lend it" || !after.CreatedAt.Equal(before.CreatedAt) {
+		t.Errorf("refreshed vector %+v", after)
+	}
+	if b, _ := service.GetVector(ctx, "docs", "b"); b.Embedding[0] != 1 {
+		t.Errorf("the re-uploaded vector was overwritten: %+v", b)
+	}
+}
@@ -0,0 +1,48 @@
+package liberation
+
+import (
+	"liberation-ai/internal/residency"
+)
+
+// Residency types, so embedding applications can pin namespaces to regions without
+// importing internal packages
+type (
+	// ResidencyPolicy decides which backends a namespace's data may reach
+	ResidencyPolicy = residency.Policy
+	// ResidencyConfig declares the region of each backend and pins namespaces to regions
+	ResidencyConfig = residency.Config
+	// Placement is a namespace's region and where that region comes from
+	Placement = residency.Placement
+)
+
+// Backends a namespace's data can reach, as named in ResidencyConfig.Backends
+const (
+	BackendVectorStore = residency.BackendVectorStore
+	BackendFallback    = residency.BackendFallback
+	BackendEmbeddings  = residency.BackendEmbeddings
+	BackendChat        = residency.BackendChat
+	BackendArchive     = residency.BackendArchive
+)
+
+// NewResidencyPolicy checks config and builds its policy. inUse names the backends the
+// application sends data to, each of which must declare a region. A disabled config gives a
+// nil policy, which lets every namespace reach every backend.
+func NewResidencyPolicy(config ResidencyConfig, inUse []string) (*ResidencyPolicy, error) {
+	if err := config.Validate(inUse); err != nil {
+		return nil, err
+	}
+	return residency.New(config, inUse)
+}
+
+// SetResidency refuses writes and embeddings that would send a pinned namespace's data to
+// a backend outside its region. stores names the backends every write reaches: the vector
+// store, and its fallback when writes are copied to one.
+func (s *Service) SetResidency(policy *ResidencyPolicy, stores []string) {
+	s.residency = policy
+	s.residencyStores = stores
+}
+
+// checkResidency checks that a write to namespace stays in its region
+func (s *Service) checkResidency(namespace string) error {
+	return s.residency.Check(namespace, s.residencyStores...)
+}
@@ -0,0 +1,210 @@
+package liberation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"liberation-ai/pkg/types"
+)
+
+// SchemaChange is how one field of a namespace's schema differs from its previous schema
+type SchemaChange struct {
+	Field string `json:"field"`
+	// Change is added, removed, retyped, required, optional, indexed or unindexed
+	Change string          `json:"change"`
+	From   types.FieldType `json:"from,omitempty"`
+	To     types.FieldType `json:"to,omitempty"`
+}
+
+// SchemaUpdate reports a schema that was set and what changed
+type SchemaUpdate struct {
+	Namespace string               `json:"namespace"`
+	Schema    types.MetadataSchema `json:"schema"`
+	Changes   []SchemaChange       `json:"changes"`
+	// Warnings lists changes that vectors already stored may not satisfy, and indexes that
+	// could not be built
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// SetMetadataSchema declares the metadata fields of a namespace. From then on writes to it
+// are checked against the schema, filters on its number fields compare numerically, and its
+// indexed fields are indexed by stores that support it.
+//
+// Stored vectors are never rewritten or rejected, so a schema can change at any time. A
+// change that existing vectors may not satisfy, such as a new required field, only applies
+// to later writes; numeric filters skip stored values that are not numbers.
+func (s *Service) SetMetadataSchema(ctx context.Context, namespace string, schema types.MetadataSchema) (*SchemaUpdate, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace is required")
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]types.MetadataField, len(schema.Fields))
+	for name, field := range schema.Fields {
+		fields[name] = field
+	}
+	schema.Fields = fields
+
+	s.schemasMu.Lock()
+	previous := s.schemas[namespace]
+	s.schemas[namespace] = schema
+	s.schemasMu.Unlock()
+
+	update := &SchemaUpdate{Namespace: namespace, Schema: schema, Changes: diffSchemas(previous, schema)}
+	for _, change := range update.Changes {
+		switch change.Change {
+		case "retyped":
+			update.Warnings = append(update.Warnings, fmt.Sprintf("%s changed from %s to %s; stored vectors keep their values", change.Field, change.From, change.To))
+		case "added":
+			// Restarts declare every schema afresh, so only changes to a schema are warned about
+			if schema.Fields[change.Field].Required && previous.Fields != nil {
+				update.Warnings = append(update.Warnings, fmt.Sprintf("%s is now required; stored vectors without it are kept", change.Field))
+			}
+		case "required":
+			update.Warnings = append(update.Warnings, fmt.Sprintf("%s is now required; stored vectors without it are kept", change.Field))
+		}
+	}
+
+	indexer, ok := s.store.(types.MetadataIndexer)
+	if !ok {
+		return update, nil
+	}
+	for _, name := range sortedFields(schema) {
+		field := schema.Fields[name]
+		if !field.Indexed {
+			continue
+		}
+		if err := indexer.IndexMetadata(ctx, name, field.Type); err != nil {
+			update.Warnings = append(update.Warnings, err.Error())
+		}
+	}
+	return update, nil
+}
+
+// MetadataSchema returns the schema of a namespace
+func (s *Service) MetadataSchema(namespace string) (types.MetadataSchema, bool) {
+	s.schemasMu.RLock()
+	defer s.schemasMu.RUnlock()
+	schema, ok := s.schemas[namespace]
+	return schema, ok
+}
+
+// MetadataSchemas returns the schema of every namespace that has one
+func (s *Service) MetadataSchemas() map[string]types.MetadataSchema {
+	s.schemasMu.RLock()
+	defer s.schemasMu.RUnlock()
+	schemas := make(map[string]types.MetadataSchema, len(s.schemas))
+	for namespace, schema := range s.schemas {
+		schemas[namespace] = schema
+	}
+	return schemas
+}
+
+// DeleteMetadataSchema stops checking a namespace's metadata and reports whether it had a
+// schema. Stored vectors and indexes are kept.
+func (s *Service) DeleteMetadataSchema(namespace string) bool {
+	s.schemasMu.Lock()
+	defer s.schemasMu.Unlock()
+	_, ok := s.schemas[namespace]
+	delete(s.schemas, namespace)
+	return ok
+}
+
+// schemaFor returns a namespace's schema, or nil when it has none
+func (s *Service) schemaFor(namespace string) *types.MetadataSchema {
+	schema, ok := s.MetadataSchema(namespace)
+	if !ok {
+		return nil
+	}
+	return &schema
+}
+
+// checkMetadata checks the metadata of vectors about to be stored against the namespace's
+// schema. path names where each one is in the request, e.g. "vectors[2].metadata.".
+func (s *Service) checkMetadata(namespace string, path func(i int) string, metadata ...map[string]interface{}) error {
+	schema := s.schemaFor(namespace)
+	if schema == nil {
+		return nil
+	}
+	problems := &types.MetadataError{}
+	for i, fields := range metadata {
+		schema.Check(problems, path(i), fields)
+	}
+	return problems.Err()
+}
+
+// checkUpdate checks a metadata update, and types its filters, against the namespace's schema
+func (s *Service) checkUpdate(update *types.MetadataUpdate) error {
+	filters, err := s.typeFilters(update.Namespace, update.Filters)
+	if err != nil {
+		return err
+	}
+	update.Filters = filters
+
+	schema := s.schemaFor(update.Namespace)
+	if schema == nil {
+		return nil
+	}
+	problems := &types.MetadataError{}
+	if update.Replace {
+		schema.Check(problems, "metadata.", update.Metadata)
+	} else {
+		schema.CheckPatch(problems, "metadata.", update.Metadata)
+	}
+	return problems.Err()
+}
+
+// typeFilters turns filters on numeric fields into types.NumberFilters, per the namespace's schema
+func (s *Service) typeFilters(namespace string, filters map[string]interface{}) (map[string]interface{}, error) {
+	return types.TypeFilters(s.schemaFor(namespace), filters)
+}
+
+// diffSchemas lists the fields added, removed or changed between two schemas
+func diffSchemas(previous, next types.MetadataSchema) []SchemaChange {
+	changes := []SchemaChange{}
+	names := sortedFields(next)
+	for _, name := range sortedFields(previous) {
+		if _, ok := next.Fields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		before, existed := previous.Fields[name]
+		after, exists := next.Fields[name]
+		switch {
+		case !existed:
+			changes = append(changes, SchemaChange{Field: name, Change: "added", To: after.Type})
+			continue
+		case !exists:
+			changes = append(changes, SchemaChange{Field: name, Change: "removed", From: before.Type})
+			continue
+		}
+		if before.Type != after.Type {
+			changes = append(changes, SchemaChange{Field: name, Change: "retyped", From: before.Type, To: after.Type})
+		}
+		switch {
+		case after.Required && !before.Required:
+			changes = append(changes, SchemaChange{Field: name, Change: "required"})
+		case before.Required && !after.Required:
+			changes = append(changes, SchemaChange{Field: name, Change: "optional"})
+		}
+		switch {
+		case after.Indexed && !before.Indexed:
+			changes = append(changes, SchemaChange{Field: name, Change: "indexed"})
+		case before.Indexed && !after.Indexed:
+			changes = append(changes, SchemaChange{Field: name, Change: "unindexed"})
+		}
+	}
+	return changes
+}
+
+func sortedFields(schema types.MetadataSchema) []string {
+	names := make([]string, 0, len(schema.Fields))
+	for name := range schema.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
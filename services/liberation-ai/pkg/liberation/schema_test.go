@@ -0,0 +1,192 @@
+package liberation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"liberation-ai/pkg/types"
+)
+
+// indexingStore records the fields it is asked to index, failing for unindexable ones
+type indexingStore struct {
+	VectorStore
+	indexed []string
+}
+
+func (s *indexingStore) IndexMetadata(ctx context.Context, field string, fieldType types.FieldType) error {
+	if field == "broken" {
+		return fmt.Errorf("index on %s could not be built", field)
+	}
+	s.indexed = append(s.indexed, field+" "+string(fieldType))
+	return nil
+}
+
+func catalogSchema() types.MetadataSchema {
+	return types.MetadataSchema{Fields: map[string]types.MetadataField{
+		"title": {Type: types.FieldString, Required: true},
+		"price": {Type: types.FieldNumber, Indexed: true},
+	}}
+}
+
+func problemsOf(t *testing.T, err error) []string {
+	t.Helper()
+	var invalid *types.MetadataError
+	if !errors.As(err, &invalid) || !errors.Is(err, types.ErrSchemaViolation) {
+		t.Fatalf("error %v, want a schema violation", err)
+	}
+	var problems []string
+	for _, problem := range invalid.Problems {
+		problems = append(problems, problem.Field+": "+problem.Message)
+	}
+	return problems
+}
+
+func TestSchemaRejectsWrites(t *testing.T) {
+	ctx := context.Background()
+	service := New(NewMemoryStore(3), NewHashEmbedder(3))
+	if _, err := service.SetMetadataSchema(ctx, "catalog", catalogSchema()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := service.StoreVectors(ctx, &types.StoreRequest{Namespace: "catalog", Vectors: []types.Vector{
+		{ID: "ok", Embedding: []float32{1, 0, 0}, Metadata: map[string]interface{}{"title": "Drill", "price": 4.0}},
+		{ID: "bad", Embedding: []float32{0, 1, 0}, Metadata: map[string]interface{}{"price": "4"}},
+	}})
+	want := []string{"vectors[1].metadata.price: must be a number, not a string", "vectors[1].metadata.title: is required"}
+	if problems := problemsOf(t, err); !reflect.DeepEqual(problems, want) {
+		t.Errorf("problems %v, want %v", problems, want)
+	}
+	if size, _ := service.NamespaceSize(ctx, "catalog"); size != 0 {
+		t.Errorf("%d vectors stored from a rejected batch", size)
+	}
+
+	_, err = service.StoreDocuments(ctx, "catalog", []Document{{ID: "doc", Title: "Ladder", Content: "a ladder to lend", Metadata: map[string]interface{}{"price": "1"}}})
+	if problems := problemsOf(t, err); !reflect.DeepEqual(problems, []string{"[0].metadata.price: must be a number, not a string"}) {
+		t.Errorf("document problems %v", problems)
+	}
+
+	// Other namespaces are unchecked
+	if _, err := service.StoreVectors(ctx, &types.StoreRequest{Namespace: "scratch", Vectors: []types.Vector{
+		{ID: "any", Embedding: []float32{1, 0, 0}, Metadata: map[string]interface{}{"price": "free"}},
+	}}); err != nil {
+		t.Errorf("unchecked namespace: %v", err)
+	}
+
+	if _, err := service.StoreVectors(ctx, &types.StoreRequest{Namespace: "catalog", Vectors: []types.Vector{
+		{ID: "drill", Embedding: []float32{1, 0, 0}, Metadata: map[string]interface{}{"title": "Drill", "price": 4.0}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		update types.MetadataUpdate
+		want   []string
+	}{
+		{"merge removing a required field", types.MetadataUpdate{Metadata: map[string]interface{}{"title": nil}}, []string{"metadata.title: is required and cannot be removed"}},
+		{"merge with a wrong type", types.MetadataUpdate{Metadata: map[string]interface{}{"price": true}}, []string{"metadata.price: must be a number, not a boolean"}},
+		{"replace leaving out a required field", types.MetadataUpdate{Metadata: map[string]interface{}{"price": 5.0}, Replace: true}, []string{"metadata.title: is required"}},
+		{"filter with a wrong type", types.MetadataUpdate{Filters: map[string]interface{}{"price": "cheap"}, Metadata: map[string]interface{}{"price": 5.0}}, []string{"filters.price: must be a number, not cheap"}},
+	} {
+		update := tc.update
+		update.Namespace = "catalog"
+		if update.Filters == nil {
+			update.IDs = []string{"drill"}
+		}
+		_, err := service.UpdateMetadata(ctx, &update)
+		if problems := problemsOf(t, err); !reflect.DeepEqual(problems, tc.want) {
+			t.Errorf("%s: problems %v, want %v", tc.name, problems, tc.want)
+		}
+	}
+	if vector, _ := service.GetVector(ctx, "catalog", "drill"); vector.Metadata["title"] != "Drill" || vector.Metadata["price"] != 4.0 {
+		t.Errorf("a rejected update changed %v", vector.Metadata)
+	}
+
+	// A patch leaving the required field out keeps it, and typed filters select by number
+	update := &types.MetadataUpdate{Namespace: "catalog", Filters: map[string]interface{}{"price": "4"}, Metadata: map[string]interface{}{"price": 5.0}}
+	if response, err := service.UpdateMetadata(ctx, update); err != nil || response.Updated != 1 {
+		t.Errorf("valid patch: %+v, %v", response, err)
+	}
+
+	// Once the schema is deleted the namespace is unchecked again
+	if !service.DeleteMetadataSchema("catalog") || service.DeleteMetadataSchema("catalog") {
+		t.Error("DeleteMetadataSchema should report the schema once")
+	}
+	if _, err := service.UpdateMetadata(ctx, &types.MetadataUpdate{Namespace: "catalog", IDs: []string{"drill"}, Metadata: map[string]interface{}{"title": nil}}); err != nil {
+		t.Errorf("update after deleting the schema: %v", err)
+	}
+}
+
+func TestSetMetadataSchema(t *testing.T) {
+	ctx := context.Background()
+	store := &indexingStore{VectorStore: NewMemoryStore(3)}
+	service := New(store, NewHashEmbedder(3))
+
+	for _, tc := range []struct {
+		namespace string
+		schema    types.MetadataSchema
+	}{
+		{"", catalogSchema()},
+		{"catalog", types.MetadataSchema{}},
+		{"catalog", types.MetadataSchema{Fields: map[string]types.MetadataField{"price": {Type: "money"}}}},
+	} {
+		if _, err := service.SetMetadataSchema(ctx, tc.namespace, tc.schema); err == nil {
+			t.Errorf("schema %+v for %q was accepted", tc.schema, tc.namespace)
+		}
+	}
+	if _, ok := service.MetadataSchema("catalog"); ok {
+		t.Fatal("a rejected schema was set")
+	}
+
+	// The first schema warns about nothing, even for required fields
+	first, err := service.SetMetadataSchema(ctx, "catalog", catalogSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Warnings) != 0 || len(first.Changes) != 2 {
+		t.Errorf("first schema: %+v", first)
+	}
+	if !reflect.DeepEqual(store.indexed, []string{"price number"}) {
+		t.Errorf("indexed %v", store.indexed)
+	}
+
+	next := types.MetadataSchema{Fields: map[string]types.MetadataField{
+		"title":  {Type: types.FieldString},
+		"price":  {Type: types.FieldString},
+		"stock":  {Type: types.FieldNumber, Required: true},
+		"broken": {Type: types.FieldString, Indexed: true},
+	}}
+	update, err := service.SetMetadataSchema(ctx, "catalog", next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantChanges := []SchemaChange{
+		{Field: "broken", Change: "added", To: types.FieldString},
+		{Field: "price", Change: "retyped", From: types.FieldNumber, To: types.FieldString},
+		{Field: "price", Change: "unindexed"},
+		{Field: "stock", Change: "added", To: types.FieldNumber},
+		{Field: "title", Change: "optional"},
+	}
+	if !reflect.DeepEqual(update.Changes, wantChanges) {
+		t.Errorf("changes %+v, want %+v", update.Changes, wantChanges)
+	}
+	warnings := strings.Join(update.Warnings, "\n")
+	for _, want := range []string{
+		"price changed from number to string",
+		"stock is now required",
+		"index on broken could not be built",
+	} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("warnings %q do not mention %q", warnings, want)
+		}
+	}
+
+	// The schema set is a copy; changing the caller's map later has no effect
+	next.Fields["title"] = types.MetadataField{Type: types.FieldNumber}
+	if schema, _ := service.MetadataSchema("catalog"); schema.Fields["title"].Type != types.FieldString {
+		t.Error("the stored schema shares the caller's map")
+	}
+}
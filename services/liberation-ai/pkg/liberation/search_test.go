@@ -0,0 +1,302 @@
+package liberation
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"liberation-ai/pkg/types"
+)
+
+// Allocation budgets for a search over searchBenchmarkDocuments documents, per call. They sit
+// a little above what the code allocates today; raise them deliberately, with the benchmark
+// numbers in the commit.
+const (
+	searchBenchmarkDocuments = 1000
+	searchAllocBudget        = 30
+	diversifiedAllocBudget   = 34
+)
+
+func newSearchFixture(tb testing.TB) *Service {
+	tb.Helper()
+	service := New(NewMemoryStore(384), NewHashEmbedder(384))
+	if _, err := service.StoreDocuments(context.Background(), "bench", benchmarkDocuments()); err != nil {
+		tb.Fatal(err)
+	}
+	return service
+}
+
+func benchmarkDocuments() []Document {
+	docs := make([]Document, searchBenchmarkDocuments)
+	for i := range docs {
+		docs[i] = Document{
+			ID:       fmt.Sprintf("doc-%d", i),
+			Title:    fmt.Sprintf("Chapter %d", i),
+			Content:  fmt.Sprintf("mutual aid network %d shares tools, seeds and rides across the valley", i),
+			Metadata: map[string]interface{}{"region": fmt.Sprintf("valley-%d", i%10)},
+		}
+	}
+	return docs
+}
+
+// newEncryptedService returns a service whose "bench" namespace is encrypted at rest
+func newEncryptedService(tb testing.TB) *Service {
+	tb.Helper()
+	keyring, err := LoadKeyring(EncryptionConfig{
+		Namespaces: []string{"bench"},
+		MasterKey:  base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	service := New(NewMemoryStore(384), NewHashEmbedder(384))
+	service.SetEncryption(keyring)
+	return service
+}
+
+func TestEncryptedNamespace(t *testing.T) {
+	service := newEncryptedService(t)
+	ctx := context.Background()
+	if _, err := service.StoreDocuments(ctx, "bench", benchmarkDocuments()); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := service.store.(*encryptedStore).VectorStore.Get(ctx, "bench", "doc-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Text != "" || stored.Metadata["region"] != nil || stored.Metadata["doc_id"] != "doc-7" {
+		t.Errorf("stored vector is not sealed: %+v", stored.Metadata)
+	}
+
+	plain := newSearchFixture(t)
+	for _, svc := range []*Service{plain, service} {
+		if _, err := svc.UpdateMetadata(ctx, &types.MetadataUpdate{Namespace: "bench", Filters: map[string]interface{}{"region": "valley-7"}, Metadata: map[string]interface{}{"reviewed": true}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := plain.SearchTextWithOptions(ctx, "bench", "seed library", SearchOptions{Limit: 5, Filters: map[string]interface{}{"reviewed": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := service.SearchTextWithOptions(ctx, "bench", "seed library", SearchOptions{Limit: 5, Filters: map[string]interface{}{"reviewed": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Results) != len(want.Results) || len(got.Results) == 0 {
+		t.Fatalf("encrypted search returned %d results, plain search %d", len(got.Results), len(want.Results))
+	}
+	for i := range want.Results {
+		if got.Results[i].Vector.ID != want.Results[i].Vector.ID || got.Results[i].Vector.Text != want.Results[i].Vector.Text {
+			t.Errorf("result %d: got %s, want %s", i, got.Results[i].Vector.ID, want.Results[i].Vector.ID)
+		}
+	}
+
+	rotation, err := service.RotateNamespaceKey(ctx, "bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotation.Version != 2 || rotation.Reencrypted != searchBenchmarkDocuments {
+		t.Errorf("rotation = %+v", rotation)
+	}
+	if status := service.EncryptionStatus(); len(status) != 1 || len(status[0].Versions) != 1 {
+		t.Errorf("old data keys were not retired: %+v", status)
+	}
+	vector, err := service.GetVector(ctx, "bench", "doc-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vector.Metadata["region"] != "valley-7" || vector.Metadata["reviewed"] != true {
+		t.Errorf("metadata after rotation = %v", vector.Metadata)
+	}
+}
+
+func TestSearchAllocationBudgets(t *testing.T) {
+	service := newSearchFixture(t)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name   string
+		budget float64
+		opts   SearchOptions
+	}{
+		{"search", searchAllocBudget, SearchOptions{Limit: 10}},
+		{"diversified search", diversifiedAllocBudget, SearchOptions{Limit: 10, Diversify: true}},
+	} {
+		allocs := testing.AllocsPerRun(20, func() {
+			if _, err := service.SearchTextWithOptions(ctx, "bench", "seed library", tc.opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs > tc.budget {
+			t.Errorf("%s allocates %.0f times per call, budget is %.0f", tc.name, allocs, tc.budget)
+		}
+	}
+}
+
+func TestPersonalizedSearch(t *testing.T) {
+	ctx := context.Background()
+	embedder := NewHashEmbedder(4)
+	service := New(NewMemoryStore(4), embedder)
+	embeddings, err := embedder.Embed(ctx, []string{"seed library"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := BlendEmbedding(embeddings[0], embeddings[0], 0)
+
+	// taste is a unit vector orthogonal to the query; "nearby" leans towards it
+	taste := []float32{1, 0, 0, 0}
+	for i := range taste {
+		taste[i] -= query[0] * query[i]
+	}
+	taste = BlendEmbedding(taste, taste, 0)
+	nearby := make([]float32, 4)
+	for i := range nearby {
+		nearby[i] = 0.9*query[i] + 0.45*taste[i]
+	}
+	if _, err := service.StoreVectors(ctx, &types.StoreRequest{Namespace: "kb", Vectors: []types.Vector{
+		{ID: "exact", Embedding: query}, {ID: "nearby", Embedding: nearby},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		opts  SearchOptions
+		first string
+	}{
+		{SearchOptions{Limit: 2}, "exact"},
+		{SearchOptions{Limit: 2, Preference: taste, PreferenceWeight: 0.5}, "nearby"},
+	} {
+		response, err := service.SearchTextWithOptions(ctx, "kb", "seed library", tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Results) == 0 || response.Results[0].Vector.ID != tc.first || response.Personalized != (tc.opts.Preference != nil) {
+			t.Errorf("weight %.1f: personalized %t, results %+v", tc.opts.PreferenceWeight, response.Personalized, response.Results)
+		}
+	}
+
+	if blended := BlendEmbedding([]float32{3, 4}, []float32{0, 1, 0}, 0.5); blended[0] != 3 {
+		t.Error("a preference of another size should be ignored")
+	}
+}
+
+// countingEmbedder counts the texts it is asked to embed
+type countingEmbedder struct {
+	*HashEmbedder
+	texts int
+}
+
+func (c *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	c.texts += len(texts)
+	return c.HashEmbedder.Embed(ctx, texts)
+}
+
+func TestEmbeddingCache(t *testing.T) {
+	ctx := context.Background()
+	embedder := &countingEmbedder{HashEmbedder: NewHashEmbedder(4)}
+	service := New(NewMemoryStore(4), embedder)
+	config := DefaultEmbeddingCacheConfig()
+	config.ExcludeNamespaces = []string{"private"}
+	cache, err := NewEmbeddingCache(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.SetEmbeddingCache(cache)
+
+	docs := []Document{{ID: "a", Content: "seed library"}, {ID: "b", Content: "seed library"}, {ID: "c", Content: "tool share"}}
+	for _, tc := range []struct {
+		namespace string
+		embedded  int
+	}{
+		{"first", 2},   // the repeated text is embedded once
+		{"second", 0},  // all of it is cached
+		{"private", 2}, // excluded namespaces embed everything
+	} {
+		embedder.texts = 0
+		if _, err := service.StoreDocuments(ctx, tc.namespace, docs); err != nil {
+			t.Fatal(err)
+		}
+		if embedder.texts != tc.embedded {
+			t.Errorf("%s: embedded %d texts, want %d", tc.namespace, embedder.texts, tc.embedded)
+		}
+	}
+
+	stats := service.EmbeddingCacheStats()
+	if stats.Entries != 2 || stats.Hits != 3 || stats.Misses != 3 || stats.Bypassed != 3 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	a, _ := service.GetVector(ctx, "second", "a")
+	b, _ := service.GetVector(ctx, "second", "b")
+	a.Embedding[0] = 42
+	if b.Embedding[0] == 42 {
+		t.Error("vectors made from the same cached embedding share its slice")
+	}
+}
+
+// Benchmarks for vector search: go test ./pkg/liberation -run '^$' -bench Search -benchmem.
+// The Encrypted variants measure what encryption at rest costs: an encrypted namespace is
+// decrypted and scored in full on every search.
+
+func BenchmarkSearch(b *testing.B) {
+	service := newSearchFixture(b)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := service.SearchText(ctx, "bench", "seed library", 10); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSearchDiversified(b *testing.B) {
+	service := newSearchFixture(b)
+	ctx := context.Background()
+	opts := SearchOptions{Limit: 10, Diversify: true}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := service.SearchTextWithOptions(ctx, "bench", "seed library", opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSearchEncrypted(b *testing.B) {
+	service := newEncryptedService(b)
+	ctx := context.Background()
+	if _, err := service.StoreDocuments(ctx, "bench", benchmarkDocuments()); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := service.SearchText(ctx, "bench", "seed library", 10); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStoreDocuments(b *testing.B) {
+	benchmarkStoreDocuments(b, New(NewMemoryStore(384), NewHashEmbedder(384)))
+}
+
+func BenchmarkStoreDocumentsEncrypted(b *testing.B) {
+	benchmarkStoreDocuments(b, newEncryptedService(b))
+}
+
+func benchmarkStoreDocuments(b *testing.B, service *Service) {
+	ctx := context.Background()
+	docs := benchmarkDocuments()[:100]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := service.StoreDocuments(ctx, "bench", docs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
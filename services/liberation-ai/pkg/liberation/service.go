@@ -0,0 +1,383 @@
+package liberation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"liberation-ai/internal/embedcache"
+	"liberation-ai/internal/residency"
+	"liberation-ai/pkg/types"
+
+	"liberation-cache"
+)
+
+// Service provides high-level vector operations.
+// It is safe for concurrent use provided its store and embedding provider are.
+type Service struct {
+	store    types.VectorStore
+	embedder EmbeddingProvider
+
+	embeddersMu sync.RWMutex
+	embedders   map[string]EmbeddingProvider
+	selector    func(namespace string) string
+	// selections remembers the selector's answers; nil asks it every time
+	selections *cache.Cache[string]
+
+	chunking Chunking
+
+	// embedCache reuses embeddings of repeated content; nil embeds everything
+	embedCache *embedcache.Cache
+
+	// residency keeps pinned namespaces' data in their region; nil allows everything
+	residency       *residency.Policy
+	residencyStores []string
+
+	schemasMu sync.RWMutex
+	schemas   map[string]types.MetadataSchema
+
+	jobsMu sync.Mutex
+	jobs   map[string]*cloneJob
+}
+
+// New creates a new vector service backed by store, embedding text with embedder. The
+// service owns the store from then on: Close closes it.
+func New(store types.VectorStore, embedder EmbeddingProvider) *Service {
+	return &Service{
+		store:     guard(store),
+		embedder:  embedder,
+		embedders: make(map[string]EmbeddingProvider),
+		schemas:   make(map[string]types.MetadataSchema),
+		jobs:      make(map[string]*cloneJob),
+	}
+}
+
+// RegisterEmbedder makes another embedding model available by name. It must produce
+// vectors of the same size as the default embedder, since namespaces share an index.
+func (s *Service) RegisterEmbedder(name string, provider EmbeddingProvider) error {
+	if name == "" {
+		return fmt.Errorf("embedding model name is required")
+	}
+	if provider.Dimensions() != s.embedder.Dimensions() {
+		return fmt.Errorf("embedding model %s produces %d dimensions, the default produces %d", name, provider.Dimensions(), s.embedder.Dimensions())
+	}
+
+	s.embeddersMu.Lock()
+	defer s.embeddersMu.Unlock()
+	s.embedders[name] = provider
+	return nil
+}
+
+// SelectEmbedder sets how the model for a namespace is chosen. Names that are not
+// registered, including "", use the default embedder.
+func (s *Service) SelectEmbedder(selector func(namespace string) string) {
+	s.embeddersMu.Lock()
+	defer s.embeddersMu.Unlock()
+	s.selector = selector
+}
+
+// CacheSelections remembers which model each namespace uses, so embedding does not consult
+// the selector every time. Whatever changes a namespace's model must call ForgetSelection.
+func (s *Service) CacheSelections(selections *cache.Cache[string]) {
+	s.embeddersMu.Lock()
+	defer s.embeddersMu.Unlock()
+	s.selections = selections
+}
+
+// ForgetSelection makes the next embedding for namespace ask the selector again
+func (s *Service) ForgetSelection(namespace string) {
+	s.embeddersMu.RLock()
+	selections := s.selections
+	s.embeddersMu.RUnlock()
+	if selections != nil {
+		selections.Invalidate(context.Background(), namespace)
+	}
+}
+
+// embedderFor returns the embedder for a namespace and its name, which is empty for the default
+func (s *Service) embedderFor(namespace string) (EmbeddingProvider, string) {
+	s.embeddersMu.RLock()
+	defer s.embeddersMu.RUnlock()
+
+	if s.selector != nil {
+		name := s.selectModel(namespace)
+		if provider, ok := s.embedders[name]; ok {
+			return provider, name
+		}
+	}
+	return s.embedder, ""
+}
+
+// selectModel asks the selector, or the cache of its answers, for the namespace's model.
+// The caller holds embeddersMu.
+func (s *Service) selectModel(namespace string) string {
+	if s.selections == nil {
+		return s.selector(namespace)
+	}
+	selector := s.selector
+	// The selector cannot fail, and without a remote tier neither can the cache
+	name, _ := s.selections.Get(context.Background(), namespace, func(context.Context) (string, error) {
+		return selector(namespace), nil
+	})
+	return name
+}
+
+// StoreText stores text with generated embeddings
+func (s *Service) StoreText(ctx context.Context, namespace, id, text string, metadata map[string]interface{}) (*types.StoreResponse, error) {
+	if err := s.checkMetadata(namespace, func(int) string { return "metadata." }, metadata); err != nil {
+		return nil, err
+	}
+	if err := s.checkResidency(namespace); err != nil {
+		return nil, err
+	}
+	embedding, model, err := s.embed(ctx, namespace, text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy metadata so tagging the model does not modify the caller's map
+	vector := types.Vector{
+		ID:        id,
+		Embedding: embedding,
+		Metadata:  copyMetadata(metadata),
+		Namespace: namespace,
+		CreatedAt: time.Now(),
+		Text:      text,
+	}
+	if model != "" {
+		vector.Metadata["embedding_model"] = model
+	}
+
+	req := &types.StoreRequest{
+		Namespace: namespace,
+		Vectors:   []types.Vector{vector},
+	}
+
+	return s.store.Store(ctx, req)
+}
+
+// SearchText searches for similar text
+func (s *Service) SearchText(ctx context.Context, namespace, query string, limit int) (*types.SearchResponse, error) {
+	return s.SearchTextWithOptions(ctx, namespace, query, SearchOptions{Limit: limit})
+}
+
+// GetVector retrieves a specific vector
+func (s *Service) GetVector(ctx context.Context, namespace, id string) (*types.Vector, error) {
+	return s.store.Get(ctx, namespace, id)
+}
+
+// ListNamespaces returns all namespaces
+func (s *Service) ListNamespaces(ctx context.Context) ([]string, error) {
+	return s.store.ListNamespaces(ctx)
+}
+
+// GetStats returns vector store statistics
+func (s *Service) GetStats(ctx context.Context) (*types.VectorStoreStats, error) {
+	return s.store.Stats(ctx)
+}
+
+// Health checks the vector store health
+func (s *Service) Health(ctx context.Context) error {
+	if s.store == nil {
+		return fmt.Errorf("vector store not initialized")
+	}
+	return s.store.Health(ctx)
+}
+
+// StoreVectors stores multiple vectors at once
+func (s *Service) StoreVectors(ctx context.Context, req *types.StoreRequest) (*types.StoreResponse, error) {
+	metadata := make([]map[string]interface{}, len(req.Vectors))
+	for i, vector := range req.Vectors {
+		metadata[i] = vector.Metadata
+	}
+	if err := s.checkMetadata(req.Namespace, func(i int) string { return fmt.Sprintf("vectors[%d].metadata.", i) }, metadata...); err != nil {
+		return nil, err
+	}
+	if err := s.checkResidency(req.Namespace); err != nil {
+		return nil, err
+	}
+	return s.store.Store(ctx, req)
+}
+
+// SearchVectors performs vector similarity search
+func (s *Service) SearchVectors(ctx context.Context, req *types.SearchRequest) (*types.SearchResponse, error) {
+	filters, err := s.typeFilters(req.Namespace, req.Filters)
+	if err != nil {
+		return nil, err
+	}
+	typed := *req
+	typed.Filters = filters
+	return s.store.Search(ctx, &typed)
+}
+
+// UpdateMetadata changes stored vectors' metadata without re-embedding or re-sending them
+func (s *Service) UpdateMetadata(ctx context.Context, update *types.MetadataUpdate) (*types.MetadataUpdateResponse, error) {
+	if err := s.checkUpdate(update); err != nil {
+		return nil, err
+	}
+	if err := s.checkResidency(update.Namespace); err != nil {
+		return nil, err
+	}
+	return s.store.UpdateMetadata(ctx, update)
+}
+
+// DeleteVectors deletes vectors by IDs
+func (s *Service) DeleteVectors(ctx context.Context, namespace string, ids []string) error {
+	return s.store.Delete(ctx, namespace, ids)
+}
+
+// DeleteDocuments deletes documents stored with StoreDocuments, with all of their chunks
+func (s *Service) DeleteDocuments(ctx context.Context, namespace string, ids []string) error {
+	for _, id := range ids {
+		if err := s.pruneChunks(ctx, namespace, id, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteNamespace deletes every vector in a namespace, a page at a time, and returns how
+// many it deleted
+func (s *Service) DeleteNamespace(ctx context.Context, namespace string) (int64, error) {
+	var deleted int64
+	after := ""
+	for {
+		page, err := s.store.List(ctx, namespace, after, 1000)
+		if err != nil || len(page) == 0 {
+			return deleted, err
+		}
+		ids := make([]string, len(page))
+		for i, vector := range page {
+			ids[i] = vector.ID
+		}
+		if err := s.store.Delete(ctx, namespace, ids); err != nil {
+			return deleted, err
+		}
+		deleted += int64(len(ids))
+		after = ids[len(ids)-1]
+	}
+}
+
+// Document is a piece of text stored as a single vector
+type Document struct {
+	ID       string                 `json:"id"`
+	Title    string                 `json:"title,omitempty"`
+	Content  string                 `json:"content"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// StoreDocuments stores documents with automatic text embedding. With chunking set, long
+// documents are split into chunks stored as "<id>#<n>", each tagged with doc_id and chunk_index.
+func (s *Service) StoreDocuments(ctx context.Context, namespace string, docs []Document) (*types.StoreResponse, error) {
+	// Checked before anything is embedded, so a rejected batch costs nothing
+	metadata := make([]map[string]interface{}, len(docs))
+	for i, doc := range docs {
+		metadata[i] = copyMetadata(doc.Metadata)
+		metadata[i]["title"] = doc.Title
+	}
+	if err := s.checkMetadata(namespace, func(i int) string { return fmt.Sprintf("[%d].metadata.", i) }, metadata...); err != nil {
+		return nil, err
+	}
+	if err := s.checkResidency(namespace); err != nil {
+		return nil, err
+	}
+
+	var vectors []types.Vector
+	var texts []string
+	chunked := make(map[string]map[string]bool)
+
+	for _, doc := range docs {
+		chunks := splitText(doc.Content, s.chunking.Size, s.chunking.Overlap)
+		for i, chunk := range chunks {
+			metadata := copyMetadata(doc.Metadata)
+			metadata["title"] = doc.Title
+
+			id := doc.ID
+			if len(chunks) > 1 {
+				id = fmt.Sprintf("%s#%d", doc.ID, i)
+				metadata[types.MetadataDocID] = doc.ID
+				metadata[types.MetadataChunkIndex] = i
+			} else if _, exists := metadata[types.MetadataDocID]; !exists {
+				// Callers that chunk documents themselves set doc_id and chunk_index
+				metadata[types.MetadataDocID] = doc.ID
+				metadata[types.MetadataChunkIndex] = 0
+			}
+			if s.chunking.Size > 0 {
+				if chunked[doc.ID] == nil {
+					chunked[doc.ID] = make(map[string]bool)
+				}
+				chunked[doc.ID][id] = true
+			}
+
+			// Combine title and content for embedding
+			text := doc.Title
+			if text != "" && chunk != "" {
+				text += " " + chunk
+			} else if text == "" {
+				text = chunk
+			}
+			texts = append(texts, text)
+
+			vectors = append(vectors, types.Vector{
+				ID:        id,
+				Metadata:  metadata,
+				Namespace: namespace,
+				CreatedAt: time.Now(),
+				Text:      chunk,
+			})
+		}
+	}
+
+	embeddings, model, err := s.embedTexts(ctx, namespace, texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed documents: %w", err)
+	}
+	for i := range vectors {
+		vectors[i].Embedding = embeddings[i]
+		// Vectors from a non-default model are tagged so they can be found and re-embedded
+		if model != "" {
+			vectors[i].Metadata["embedding_model"] = model
+		}
+	}
+
+	req := &types.StoreRequest{
+		Namespace: namespace,
+		Vectors:   vectors,
+	}
+
+	response, err := s.store.Store(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// A document that now splits differently would otherwise keep its old chunks
+	for docID, keep := range chunked {
+		if err := s.pruneChunks(ctx, namespace, docID, keep); err != nil {
+			return nil, fmt.Errorf("failed to remove old chunks of %s: %w", docID, err)
+		}
+	}
+	return response, nil
+}
+
+// SearchDocuments searches for similar documents
+func (s *Service) SearchDocuments(ctx context.Context, namespace, query string, limit int) (*types.SearchResponse, error) {
+	return s.SearchText(ctx, namespace, query, limit)
+}
+
+// embed generates the embedding for a single text with the namespace's model
+func (s *Service) embed(ctx context.Context, namespace, text string) ([]float32, string, error) {
+	embeddings, model, err := s.embedTexts(ctx, namespace, []string{text})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to embed text: %w", err)
+	}
+	return embeddings[0], model, nil
+}
+
+func copyMetadata(metadata map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(metadata)+3)
+	for key, value := range metadata {
+		result[key] = value
+	}
+	return result
+}
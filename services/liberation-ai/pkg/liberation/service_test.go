@@ -0,0 +1,226 @@
+package liberation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/types"
+)
+
+// Run with -race: embedding applications call one Service from many goroutines
+func TestConcurrentStoreSearchDelete(t *testing.T) {
+	ctx := context.Background()
+	service := New(NewMemoryStore(64), NewHashEmbedder(64))
+	if err := service.SetChunking(Chunking{Size: 40, Overlap: 10}); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewEmbeddingCache(DefaultEmbeddingCacheConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.SetEmbeddingCache(cache)
+
+	const workers, rounds = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				// Every worker writes the same text too, so the cache is shared between them
+				docs := []Document{
+					{ID: fmt.Sprintf("w%d-%d", w, i), Content: fmt.Sprintf("worker %d round %d lends a ladder and a drill to the tool library", w, i)},
+					{ID: fmt.Sprintf("w%d-%d-shared", w, i), Content: "seed swap every saturday at the community garden"},
+				}
+				if _, err := service.StoreDocuments(ctx, "shared", docs); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := service.SearchDocuments(ctx, "shared", "tool library ladder", 5); err != nil {
+					errs <- err
+					return
+				}
+				if err := service.DeleteDocuments(ctx, "shared", []string{docs[1].ID}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Only the first document of every round is left, with all of its chunks
+	want := workers * rounds * service.ChunkCount([]Document{{Content: "worker 0 round 0 lends a ladder and a drill to the tool library"}})
+	size, err := service.NamespaceSize(ctx, "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(want) {
+		t.Errorf("%d vectors left, want %d", size, want)
+	}
+	if stats := service.EmbeddingCacheStats(); stats.Hits == 0 {
+		t.Errorf("cache stats %+v, want the shared text reused", stats)
+	}
+}
+
+// durableStore keeps its vectors through Close, like a database would
+type durableStore struct {
+	*vectorstore.MemoryVectorStore
+	closes int
+}
+
+func (d *durableStore) Close() error {
+	d.closes++
+	return nil
+}
+
+func TestCloseAndReopen(t *testing.T) {
+	ctx := context.Background()
+	store := &durableStore{MemoryVectorStore: vectorstore.NewMemoryVectorStore(64)}
+	service := New(store, NewHashEmbedder(64))
+	if _, err := service.StoreText(ctx, "kb", "rides", "rides to the clinic leave at nine", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Close(); err != nil || store.closes != 1 {
+		t.Fatalf("second close: %v after %d store closes, want it to do nothing", err, store.closes)
+	}
+	if _, err := service.SearchText(ctx, "kb", "clinic rides", 1); !errors.Is(err, ErrClosed) {
+		t.Errorf("search after close: got %v, want ErrClosed", err)
+	}
+	if _, err := service.StoreText(ctx, "kb", "seeds", "seed swap on saturday", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("store after close: got %v, want ErrClosed", err)
+	}
+	if err := service.Health(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("health after close: got %v, want ErrClosed", err)
+	}
+
+	// A new service over the same store serves what the closed one stored
+	reopened := New(store, NewHashEmbedder(64))
+	defer reopened.Close()
+	results, err := reopened.SearchText(ctx, "kb", "rides to the clinic leave at nine", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Results) != 1 || results.Results[0].Vector.ID != "rides" {
+		t.Errorf("results %+v, want the vector stored before closing", results.Results)
+	}
+	if _, err := reopened.GetVector(ctx, "kb", "seeds"); !errors.Is(err, types.ErrNotFound) {
+		t.Errorf("the write refused after close was stored: %v", err)
+	}
+}
+
+// blockingStore holds searches until released
+type blockingStore struct {
+	*vectorstore.MemoryVectorStore
+	started chan struct{}
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (b *blockingStore) Search(ctx context.Context, req *types.SearchRequest) (*types.SearchResponse, error) {
+	close(b.started)
+	<-b.release
+	return b.MemoryVectorStore.Search(ctx, req)
+}
+
+func (b *blockingStore) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestCloseWaitsForCallsInProgress(t *testing.T) {
+	ctx := context.Background()
+	store := &blockingStore{
+		MemoryVectorStore: vectorstore.NewMemoryVectorStore(64),
+		started:           make(chan struct{}),
+		release:           make(chan struct{}),
+		closed:            make(chan struct{}),
+	}
+	service := New(store, NewHashEmbedder(64))
+
+	searched := make(chan error, 1)
+	go func() {
+		_, err := service.SearchText(ctx, "kb", "anything", 1)
+		searched <- err
+	}()
+	<-store.started
+
+	closed := make(chan error, 1)
+	go func() { closed <- service.Close() }()
+	select {
+	case <-store.closed:
+		t.Fatal("the store was closed while a search was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// New calls are refused while Close waits
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := service.GetVector(ctx, "kb", "x"); errors.Is(err, ErrClosed) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("calls were still accepted after Close was called")
+		}
+	}
+
+	close(store.release)
+	if err := <-searched; err != nil {
+		t.Errorf("the search in progress failed: %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-store.closed:
+	default:
+		t.Error("Close returned without closing the store")
+	}
+}
+
+func TestCloseWaitsForRunningClones(t *testing.T) {
+	ctx := context.Background()
+	service := New(NewMemoryStore(64), NewHashEmbedder(64))
+	docs := make([]Document, 200)
+	for i := range docs {
+		docs[i] = Document{ID: fmt.Sprintf("doc-%d", i), Content: strings.Repeat("mutual aid ", i%7+1)}
+	}
+	if _, err := service.StoreDocuments(ctx, "source", docs); err != nil {
+		t.Fatal(err)
+	}
+	job, err := service.StartClone(ctx, "source", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The clone either finished before Close or was refused, never cut off halfway
+	finished, _ := service.WaitForClone(ctx, job.ID)
+	switch finished.Status {
+	case types.MigrationCompleted:
+		if finished.VectorsCopied != 200 {
+			t.Errorf("clone copied %d vectors, want 200", finished.VectorsCopied)
+		}
+	case types.MigrationFailed:
+		if !strings.Contains(finished.Error, ErrClosed.Error()) {
+			t.Errorf("clone failed with %q, want it refused as closed", finished.Error)
+		}
+	default:
+		t.Errorf("clone is %s after Close returned", finished.Status)
+	}
+}
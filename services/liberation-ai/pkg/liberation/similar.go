@@ -0,0 +1,85 @@
+package liberation
+
+import (
+	"context"
+	"fmt"
+
+	"liberation-ai/pkg/types"
+)
+
+// SimilarOptions controls a search-by-example
+type SimilarOptions struct {
+	Limit int
+	// ExcludeSelf leaves out the example and, when it is a chunk, the rest of its document
+	ExcludeSelf bool
+	// Filters restricts results to vectors with matching metadata
+	Filters map[string]interface{}
+}
+
+// Similar finds vectors near a stored one, using its embedding as the query
+func (s *Service) Similar(ctx context.Context, namespace, id string, opts SimilarOptions) (*types.SearchResponse, error) {
+	example, err := s.store.Get(ctx, namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.SimilarTo(ctx, example, opts)
+}
+
+// SimilarTo searches the example's namespace with its stored embedding, so nothing is
+// re-embedded. Results carry no embeddings, so callers can show related documents without
+// being handed the vectors themselves.
+func (s *Service) SimilarTo(ctx context.Context, example *types.Vector, opts SimilarOptions) (*types.SearchResponse, error) {
+	if len(example.Embedding) == 0 {
+		return nil, fmt.Errorf("vector %s/%s has no stored embedding", example.Namespace, example.ID)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 10
+	}
+
+	// The example and its sibling chunks would otherwise take the top places
+	docID, _ := example.Metadata[types.MetadataDocID].(string)
+	candidates := opts.Limit
+	if opts.ExcludeSelf {
+		candidates++
+		if docID != "" {
+			candidates = opts.Limit * 4
+		}
+	}
+
+	filters, err := s.typeFilters(example.Namespace, opts.Filters)
+	if err != nil {
+		return nil, err
+	}
+	retrieved, err := s.store.Search(ctx, &types.SearchRequest{
+		Namespace: example.Namespace,
+		Embedding: example.Embedding,
+		Limit:     candidates,
+		Filters:   filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := retrieved.Results[:0]
+	for _, result := range retrieved.Results {
+		if opts.ExcludeSelf && isSameSource(result.Vector, example.ID, docID) {
+			continue
+		}
+		result.Vector.Embedding = nil
+		results = append(results, result)
+		if len(results) == opts.Limit {
+			break
+		}
+	}
+	retrieved.Results = results
+	return retrieved, nil
+}
+
+// isSameSource reports whether a result is the example itself or a chunk of its document
+func isSameSource(vector types.Vector, id, docID string) bool {
+	if vector.ID == id {
+		return true
+	}
+	other, _ := vector.Metadata[types.MetadataDocID].(string)
+	return docID != "" && other == docID
+}
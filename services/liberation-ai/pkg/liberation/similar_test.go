@@ -0,0 +1,66 @@
+package liberation
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"liberation-ai/pkg/types"
+)
+
+func TestSimilar(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryStore(3)
+	chunk := func(id, docID string) types.Vector {
+		return types.Vector{ID: id, Embedding: []float32{1, 0, 0}, Metadata: map[string]interface{}{types.MetadataDocID: docID}}
+	}
+	if _, err := memory.Store(ctx, &types.StoreRequest{Namespace: "docs", Vectors: []types.Vector{
+		chunk("guide#0", "guide"), {ID: "note", Embedding: []float32{0, 1, 0}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	store := &cannedStore{VectorStore: memory, results: []types.SearchResult{
+		{Vector: chunk("guide#0", "guide")},
+		{Vector: chunk("faq", "faq")},
+		{Vector: chunk("guide#1", "guide")},
+		{Vector: types.Vector{ID: "note", Embedding: []float32{0, 1, 0}}},
+		{Vector: chunk("tips", "tips")},
+	}}
+	service := New(store, NewHashEmbedder(3))
+
+	for _, tc := range []struct {
+		id         string
+		opts       SimilarOptions
+		candidates int
+		want       []string
+	}{
+		{"guide#0", SimilarOptions{Limit: 2}, 2, []string{"guide#0", "faq"}},
+		// Chunks leave out their whole document, so more candidates are fetched
+		{"guide#0", SimilarOptions{Limit: 2, ExcludeSelf: true}, 8, []string{"faq", "note"}},
+		{"note", SimilarOptions{Limit: 3, ExcludeSelf: true}, 4, []string{"guide#0", "faq", "guide#1"}},
+		{"note", SimilarOptions{ExcludeSelf: true}, 11, []string{"guide#0", "faq", "guide#1", "tips"}},
+	} {
+		response, err := service.Similar(ctx, "docs", tc.id, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(response.Results); !slices.Equal(got, tc.want) || store.request.Limit != tc.candidates {
+			t.Errorf("similar to %s with %+v: %v from %d candidates, want %v from %d", tc.id, tc.opts, got, store.request.Limit, tc.want, tc.candidates)
+		}
+		for _, result := range response.Results {
+			if result.Vector.Embedding != nil {
+				t.Errorf("%s returned with its embedding", result.Vector.ID)
+			}
+		}
+	}
+	if store.request.Namespace != "docs" || !slices.Equal(store.request.Embedding, []float32{0, 1, 0}) {
+		t.Errorf("searched with %+v", store.request)
+	}
+
+	if _, err := service.Similar(ctx, "docs", "missing", SimilarOptions{}); err == nil {
+		t.Error("found vectors similar to a missing one")
+	}
+	if _, err := service.SimilarTo(ctx, &types.Vector{ID: "bare", Namespace: "docs"}, SimilarOptions{}); err == nil {
+		t.Error("searched without an embedding")
+	}
+}
@@ -0,0 +1,36 @@
+package liberation
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"liberation-ai/internal/vectorstore"
+	"liberation-ai/pkg/types"
+)
+
+// Aliases so embedding applications only need to import this package
+type (
+	VectorStore    = types.VectorStore
+	Vector         = types.Vector
+	SearchResponse = types.SearchResponse
+	SearchResult   = types.SearchResult
+	StoreResponse  = types.StoreResponse
+)
+
+// NewMemoryStore creates an in-memory vector store
+func NewMemoryStore(dimensions int) VectorStore {
+	return vectorstore.NewMemoryVectorStore(dimensions)
+}
+
+// NewPostgresStore creates a PostgreSQL + pgvector store, creating its schema if needed.
+// Chunk text over 1 KiB is stored gzipped.
+func NewPostgresStore(connectionURL string, dimensions int, logger *logrus.Logger) (VectorStore, error) {
+	return vectorstore.NewPostgresVectorStore(connectionURL, dimensions, logger)
+}
+
+// SetTextCompression sets the chunk size in bytes above which a Postgres store gzips chunk
+// text; 0 stores it uncompressed. Other stores keep text uncompressed and are unaffected.
+func SetTextCompression(store VectorStore, above int) {
+	if compressor, ok := store.(interface{ SetTextCompression(int) }); ok {
+		compressor.SetTextCompression(above)
+	}
+}
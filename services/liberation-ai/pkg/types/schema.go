@@ -0,0 +1,349 @@
+package types
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrSchemaViolation is matched by every MetadataError
+var ErrSchemaViolation = errors.New("metadata does not match the namespace schema")
+
+// FieldType is the type of a declared metadata field
+type FieldType string
+
+// Metadata field types
+const (
+	FieldString  FieldType = "string"
+	FieldNumber  FieldType = "number"
+	FieldBoolean FieldType = "boolean"
+)
+
+// MetadataField declares one metadata field of a namespace
+type MetadataField struct {
+	Type FieldType `yaml:"type" json:"type"`
+	// Required rejects writes that leave the field out
+	Required bool `yaml:"required" json:"required,omitempty"`
+	// Indexed asks the store for an index on the field, for faster filtering
+	Indexed bool `yaml:"indexed" json:"indexed,omitempty"`
+}
+
+// MetadataSchema declares the metadata fields of a namespace. Fields that are not declared
+// are stored unchecked, so a schema can start with the fields that matter and grow.
+type MetadataSchema struct {
+	Fields map[string]MetadataField `yaml:"fields" json:"fields"`
+}
+
+// Validate checks every field has a known type and a name that can be indexed
+func (s MetadataSchema) Validate() error {
+	if len(s.Fields) == 0 {
+		return errors.New("a schema declares at least one field")
+	}
+	for name, field := range s.Fields {
+		if name == "" || strings.ContainsAny(name, "'\"\\\x00") {
+			return fmt.Errorf("field name %q is empty or contains quotes", name)
+		}
+		switch field.Type {
+		case FieldString, FieldNumber, FieldBoolean:
+		default:
+			return fmt.Errorf("field %s has unknown type %q; use string, number or boolean", name, field.Type)
+		}
+	}
+	return nil
+}
+
+// MetadataIndexer is implemented by stores that can index declared metadata fields
+type MetadataIndexer interface {
+	// IndexMetadata indexes field for filters within a namespace; it does nothing when the
+	// index already exists
+	IndexMetadata(ctx context.Context, field string, fieldType FieldType) error
+}
+
+// SchemaProblem is one metadata value that breaks a schema
+type SchemaProblem struct {
+	// Field is the value's path in the request, e.g. "vectors[2].metadata.price"
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// MetadataError lists the metadata values of a request that break its namespace's schema
+type MetadataError struct {
+	Problems []SchemaProblem
+}
+
+func (e *MetadataError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		messages[i] = problem.Field + ": " + problem.Message
+	}
+	return ErrSchemaViolation.Error() + ": " + strings.Join(messages, "; ")
+}
+
+// Is lets errors.Is match a MetadataError against ErrSchemaViolation
+func (e *MetadataError) Is(target error) bool {
+	return target == ErrSchemaViolation
+}
+
+func (e *MetadataError) add(field, format string, args ...interface{}) {
+	e.Problems = append(e.Problems, SchemaProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns the error, or nil when nothing was wrong
+func (e *MetadataError) Err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Check adds a problem to problems for every declared field of metadata that is missing
+// while required or holds a value of the wrong type. path prefixes each field's name.
+func (s MetadataSchema) Check(problems *MetadataError, path string, metadata map[string]interface{}) {
+	for _, name := range s.names() {
+		field := s.Fields[name]
+		value, ok := metadata[name]
+		switch {
+		case !ok || value == nil:
+			if field.Required {
+				problems.add(path+name, "is required")
+			}
+		case !field.Type.accepts(value):
+			problems.add(path+name, "must be a %s, not %s", field.Type, kindOf(value))
+		}
+	}
+}
+
+// CheckPatch is Check for a metadata update that is merged into stored metadata: fields
+// left out keep their stored values, and a null removes the field unless it is required
+func (s MetadataSchema) CheckPatch(problems *MetadataError, path string, patch map[string]interface{}) {
+	for _, name := range s.names() {
+		field := s.Fields[name]
+		value, ok := patch[name]
+		switch {
+		case !ok:
+		case value == nil:
+			if field.Required {
+				problems.add(path+name, "is required and cannot be removed")
+			}
+		case !field.Type.accepts(value):
+			problems.add(path+name, "must be a %s, not %s", field.Type, kindOf(value))
+		}
+	}
+}
+
+// names lists the declared fields in order, so problems are reported in a stable order
+func (s MetadataSchema) names() []string {
+	names := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// kindOf names the JSON type of a metadata value, for messages
+func kindOf(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	}
+	if _, ok := Number(value); ok {
+		return "a number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func (t FieldType) accepts(value interface{}) bool {
+	switch t {
+	case FieldString:
+		_, ok := value.(string)
+		return ok
+	case FieldNumber:
+		_, ok := Number(value)
+		return ok
+	case FieldBoolean:
+		_, ok := value.(bool)
+		return ok
+	}
+	return false
+}
+
+// Number returns value as a float64 when it is a number, as decoded from JSON or set by
+// Go code. Strings are not numbers, even when they hold one.
+func Number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// NumberFilter matches metadata values that are numbers within its bounds. Unset bounds
+// are open. In filters it is written as an object of bounds, e.g. {"gte": 10, "lt": 20}.
+type NumberFilter struct {
+	Eq  *float64 `json:"eq,omitempty"`
+	Gt  *float64 `json:"gt,omitempty"`
+	Gte *float64 `json:"gte,omitempty"`
+	Lt  *float64 `json:"lt,omitempty"`
+	Lte *float64 `json:"lte,omitempty"`
+}
+
+// Matches reports whether value is a number within the bounds
+func (f NumberFilter) Matches(value interface{}) bool {
+	n, ok := Number(value)
+	return ok &&
+		(f.Eq == nil || n == *f.Eq) &&
+		(f.Gt == nil || n > *f.Gt) &&
+		(f.Gte == nil || n >= *f.Gte) &&
+		(f.Lt == nil || n < *f.Lt) &&
+		(f.Lte == nil || n <= *f.Lte)
+}
+
+// Bound is one bound of a NumberFilter, with its SQL comparison operator
+type Bound struct {
+	Op    string
+	Value float64
+}
+
+// Bounds lists the filter's set bounds
+func (f NumberFilter) Bounds() []Bound {
+	var bounds []Bound
+	for _, bound := range []struct {
+		op    string
+		value *float64
+	}{{"=", f.Eq}, {">", f.Gt}, {">=", f.Gte}, {"<", f.Lt}, {"<=", f.Lte}} {
+		if bound.value != nil {
+			bounds = append(bounds, Bound{Op: bound.op, Value: *bound.value})
+		}
+	}
+	return bounds
+}
+
+// parseNumberFilter reads a filter value written as an object of bounds
+func parseNumberFilter(value map[string]interface{}) (NumberFilter, error) {
+	var filter NumberFilter
+	if len(value) == 0 {
+		return filter, errors.New("needs at least one of eq, gt, gte, lt and lte")
+	}
+	for op, raw := range value {
+		n, ok := Number(raw)
+		if !ok {
+			if s, isString := raw.(string); isString {
+				parsed, err := strconv.ParseFloat(s, 64)
+				n, ok = parsed, err == nil
+			}
+		}
+		if !ok {
+			return filter, fmt.Errorf("%s must be a number", op)
+		}
+		switch op {
+		case "eq":
+			filter.Eq = &n
+		case "gt":
+			filter.Gt = &n
+		case "gte":
+			filter.Gte = &n
+		case "lt":
+			filter.Lt = &n
+		case "lte":
+			filter.Lte = &n
+		default:
+			return filter, fmt.Errorf("unknown bound %q; use eq, gt, gte, lt or lte", op)
+		}
+	}
+	return filter, nil
+}
+
+// TypeFilters returns filters with values for numeric fields turned into NumberFilters,
+// which stores compare numerically. Objects of bounds are ranges whether or not the field
+// is declared; a declared number field also compares plain values as numbers, so "10" from
+// a query string matches 10. schema may be nil.
+func TypeFilters(schema *MetadataSchema, filters map[string]interface{}) (map[string]interface{}, error) {
+	if len(filters) == 0 {
+		return filters, nil
+	}
+	problems := &MetadataError{}
+	typed := make(map[string]interface{}, len(filters))
+	for key, value := range filters {
+		var field MetadataField
+		declared := false
+		if schema != nil {
+			field, declared = schema.Fields[key]
+		}
+		path := "filters." + key
+
+		switch v := value.(type) {
+		case NumberFilter:
+			typed[key] = v
+			continue
+		case map[string]interface{}:
+			if declared && field.Type != FieldNumber {
+				problems.add(path, "is a %s field, so it cannot be filtered by range", field.Type)
+				continue
+			}
+			filter, err := parseNumberFilter(v)
+			if err != nil {
+				problems.add(path, "%v", err)
+				continue
+			}
+			typed[key] = filter
+			continue
+		}
+
+		switch {
+		case declared && field.Type == FieldNumber:
+			n, ok := Number(value)
+			if s, isString := value.(string); isString {
+				parsed, err := strconv.ParseFloat(s, 64)
+				n, ok = parsed, err == nil
+			}
+			if !ok {
+				problems.add(path, "must be a number, not %v", value)
+				continue
+			}
+			typed[key] = NumberFilter{Eq: &n}
+		case declared && field.Type == FieldBoolean:
+			if s, isString := value.(string); isString {
+				parsed, err := strconv.ParseBool(s)
+				if err != nil {
+					problems.add(path, "must be true or false, not %q", s)
+					continue
+				}
+				value = parsed
+			}
+			if _, ok := value.(bool); !ok {
+				problems.add(path, "must be true or false, not %v", value)
+				continue
+			}
+			typed[key] = value
+		default:
+			typed[key] = value
+		}
+	}
+	if err := problems.Err(); err != nil {
+		return nil, err
+	}
+	return typed, nil
+}
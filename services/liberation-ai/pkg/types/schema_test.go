@@ -0,0 +1,164 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testSchema() MetadataSchema {
+	return MetadataSchema{Fields: map[string]MetadataField{
+		"title":  {Type: FieldString, Required: true},
+		"price":  {Type: FieldNumber, Indexed: true},
+		"public": {Type: FieldBoolean},
+	}}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		fields map[string]MetadataField
+		ok     bool
+	}{
+		{"every type", testSchema().Fields, true},
+		{"no fields", nil, false},
+		{"unknown type", map[string]MetadataField{"price": {Type: "decimal"}}, false},
+		{"no type", map[string]MetadataField{"price": {}}, false},
+		{"empty name", map[string]MetadataField{"": {Type: FieldString}}, false},
+		{"quoted name", map[string]MetadataField{`ti"tle`: {Type: FieldString}}, false},
+		{"single quoted name", map[string]MetadataField{"it's": {Type: FieldString}}, false},
+		{"backslash", map[string]MetadataField{`a\b`: {Type: FieldString}}, false},
+	} {
+		if err := (MetadataSchema{Fields: tc.fields}).Validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: Validate() = %v", tc.name, err)
+		}
+	}
+}
+
+func TestSchemaCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		metadata map[string]interface{}
+		want     []SchemaProblem
+	}{
+		{"valid", map[string]interface{}{"title": "Tools", "price": 2.5, "public": true}, nil},
+		{"optional fields left out", map[string]interface{}{"title": "Tools"}, nil},
+		{"undeclared fields are unchecked", map[string]interface{}{"title": "Tools", "tags": []interface{}{1, "a"}}, nil},
+		{"numbers set by Go code", map[string]interface{}{"title": "Tools", "price": int64(3)}, nil},
+		{"numbers decoded with UseNumber", map[string]interface{}{"title": "Tools", "price": json.Number("3.5")}, nil},
+		{"required missing", map[string]interface{}{}, []SchemaProblem{{"vectors[1].metadata.title", "is required"}}},
+		{"required null", map[string]interface{}{"title": nil}, []SchemaProblem{{"vectors[1].metadata.title", "is required"}}},
+		{
+			name:     "wrong types, in field order",
+			metadata: map[string]interface{}{"title": 7.0, "price": "10", "public": "yes"},
+			want: []SchemaProblem{
+				{"vectors[1].metadata.price", "must be a number, not a string"},
+				{"vectors[1].metadata.public", "must be a boolean, not a string"},
+				{"vectors[1].metadata.title", "must be a string, not a number"},
+			},
+		},
+		{"objects", map[string]interface{}{"title": map[string]interface{}{"en": "Tools"}}, []SchemaProblem{{"vectors[1].metadata.title", "must be a string, not an object"}}},
+	} {
+		problems := &MetadataError{}
+		testSchema().Check(problems, "vectors[1].metadata.", tc.metadata)
+		if !reflect.DeepEqual(problems.Problems, tc.want) {
+			t.Errorf("%s: problems %v, want %v", tc.name, problems.Problems, tc.want)
+		}
+	}
+}
+
+func TestSchemaCheckPatch(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		patch map[string]interface{}
+		want  []SchemaProblem
+	}{
+		{"required fields left out keep their values", map[string]interface{}{"price": 4.0}, nil},
+		{"optional fields can be removed", map[string]interface{}{"price": nil, "public": nil}, nil},
+		{"required fields cannot be removed", map[string]interface{}{"title": nil}, []SchemaProblem{{"metadata.title", "is required and cannot be removed"}}},
+		{"wrong type", map[string]interface{}{"public": 1.0}, []SchemaProblem{{"metadata.public", "must be a boolean, not a number"}}},
+	} {
+		problems := &MetadataError{}
+		testSchema().CheckPatch(problems, "metadata.", tc.patch)
+		if !reflect.DeepEqual(problems.Problems, tc.want) {
+			t.Errorf("%s: problems %v, want %v", tc.name, problems.Problems, tc.want)
+		}
+	}
+}
+
+func TestMetadataError(t *testing.T) {
+	problems := &MetadataError{}
+	if err := problems.Err(); err != nil {
+		t.Errorf("Err() with no problems = %v", err)
+	}
+	testSchema().Check(problems, "metadata.", map[string]interface{}{"price": "free"})
+	err := problems.Err()
+	if !errors.Is(err, ErrSchemaViolation) {
+		t.Errorf("%v does not match ErrSchemaViolation", err)
+	}
+	want := "metadata does not match the namespace schema: metadata.price: must be a number, not a string; metadata.title: is required"
+	if err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+}
+
+func TestTypeFilters(t *testing.T) {
+	schema := testSchema()
+	ten, five, twenty := 10.0, 5.0, 20.0
+	for _, tc := range []struct {
+		name    string
+		filters map[string]interface{}
+		want    map[string]interface{}
+		problem string
+	}{
+		{"strings are kept", map[string]interface{}{"title": "Tools"}, map[string]interface{}{"title": "Tools"}, ""},
+		{"number fields compare numbers", map[string]interface{}{"price": "10"}, map[string]interface{}{"price": NumberFilter{Eq: &ten}}, ""},
+		{"boolean fields parse query strings", map[string]interface{}{"public": "true"}, map[string]interface{}{"public": true}, ""},
+		{"ranges", map[string]interface{}{"price": map[string]interface{}{"gte": 5.0, "lt": "20"}}, map[string]interface{}{"price": NumberFilter{Gte: &five, Lt: &twenty}}, ""},
+		{"ranges on undeclared fields", map[string]interface{}{"stock": map[string]interface{}{"eq": 10.0}}, map[string]interface{}{"stock": NumberFilter{Eq: &ten}}, ""},
+		{"undeclared strings are not numbers", map[string]interface{}{"stock": "10"}, map[string]interface{}{"stock": "10"}, ""},
+		{"not a number", map[string]interface{}{"price": "cheap"}, nil, "filters.price: must be a number, not cheap"},
+		{"not a boolean", map[string]interface{}{"public": "maybe"}, nil, `filters.public: must be true or false, not "maybe"`},
+		{"range on a string field", map[string]interface{}{"title": map[string]interface{}{"gt": 1.0}}, nil, "filters.title: is a string field, so it cannot be filtered by range"},
+		{"unknown bound", map[string]interface{}{"price": map[string]interface{}{"above": 1.0}}, nil, `filters.price: unknown bound "above"; use eq, gt, gte, lt or lte`},
+		{"bound not a number", map[string]interface{}{"price": map[string]interface{}{"gt": "x"}}, nil, "filters.price: gt must be a number"},
+		{"no bounds", map[string]interface{}{"price": map[string]interface{}{}}, nil, "filters.price: needs at least one of eq, gt, gte, lt and lte"},
+	} {
+		typed, err := TypeFilters(&schema, tc.filters)
+		if tc.problem != "" {
+			var invalid *MetadataError
+			if !errors.As(err, &invalid) || len(invalid.Problems) != 1 || invalid.Problems[0].Field+": "+invalid.Problems[0].Message != tc.problem {
+				t.Errorf("%s: error %v, want %s", tc.name, err, tc.problem)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(typed, tc.want) {
+			t.Errorf("%s: filters %v, %v; want %v", tc.name, typed, err, tc.want)
+		}
+	}
+
+	// Without a schema only ranges are typed
+	typed, err := TypeFilters(nil, map[string]interface{}{"price": "10", "public": "true"})
+	if err != nil || typed["price"] != "10" || typed["public"] != "true" {
+		t.Errorf("without a schema: %v, %v", typed, err)
+	}
+}
+
+func TestNumberFilterMatches(t *testing.T) {
+	five, ten := 5.0, 10.0
+	filter := NumberFilter{Gt: &five, Lte: &ten}
+	for _, tc := range []struct {
+		value interface{}
+		want  bool
+	}{
+		{5.0, false}, {5.5, true}, {10, true}, {int64(11), false}, {"7", false}, {nil, false},
+	} {
+		if got := filter.Matches(tc.value); got != tc.want {
+			t.Errorf("Matches(%#v) = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+	if bounds := filter.Bounds(); !reflect.DeepEqual(bounds, []Bound{{">", 5}, {"<=", 10}}) {
+		t.Errorf("Bounds() = %v", bounds)
+	}
+}
@@ -2,9 +2,68 @@ package types
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 )
 
+var (
+	// ErrNamespaceNotFound is returned when an operation targets a namespace with no vectors
+	ErrNamespaceNotFound = errors.New("namespace not found")
+
+	// ErrNamespaceExists is returned when an operation would overwrite an existing namespace
+	ErrNamespaceExists = errors.New("namespace already exists")
+
+	// ErrStoreDegraded is returned for operations that need the primary vector store while
+	// a fallback is serving
+	ErrStoreDegraded = errors.New("primary vector store is unavailable")
+
+	// ErrWriteQueueFull is returned when the primary vector store is unavailable and no more
+	// writes can be queued for it
+	ErrWriteQueueFull = errors.New("primary vector store is unavailable and the write queue is full")
+
+	// ErrNotFound is returned when a vector does not exist
+	ErrNotFound = errors.New("vector not found")
+
+	// ErrDimensionMismatch is matched by every DimensionError
+	ErrDimensionMismatch = errors.New("dimension mismatch")
+
+	// ErrQuotaExceeded is returned when a write does not fit, because a quota is used up or
+	// the store has run out of space
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrResidency is returned when a write would send a namespace's data to a backend
+	// outside the region the namespace is pinned to
+	ErrResidency = errors.New("data residency violation")
+)
+
+// VectorNotFound returns an error wrapping ErrNotFound that names the missing vector
+func VectorNotFound(namespace, id string) error {
+	return fmt.Errorf("%w: %s/%s", ErrNotFound, namespace, id)
+}
+
+// DimensionError is returned for an embedding whose length differs from the store's
+type DimensionError struct {
+	Expected int
+	Got      int
+	// Query is set when the embedding was a search query rather than a stored vector
+	Query bool
+}
+
+func (e *DimensionError) Error() string {
+	kind := "vector"
+	if e.Query {
+		kind = "query"
+	}
+	return fmt.Sprintf("%s dimension mismatch: expected %d, got %d", kind, e.Expected, e.Got)
+}
+
+// Is lets errors.Is match a DimensionError against ErrDimensionMismatch
+func (e *DimensionError) Is(target error) bool {
+	return target == ErrDimensionMismatch
+}
+
 // Vector represents a single vector with metadata
 type Vector struct {
 	ID        string                 `json:"id"`
@@ -12,6 +71,33 @@ type Vector struct {
 	Metadata  map[string]interface{} `json:"metadata"`
 	Namespace string                 `json:"namespace"`
 	CreatedAt time.Time              `json:"created_at"`
+	// Text is the raw text of the chunk the embedding was made from
+	Text string `json:"text,omitempty"`
+}
+
+// Metadata keys identifying a chunk's place in its document
+const (
+	MetadataDocID      = "doc_id"
+	MetadataChunkIndex = "chunk_index"
+)
+
+// ChunkIndex returns the vector's position in its document, or false when it has none
+func (v *Vector) ChunkIndex() (int, bool) {
+	switch index := v.Metadata[MetadataChunkIndex].(type) {
+	case int:
+		return index, true
+	case float64:
+		// Metadata decoded from JSON
+		return int(index), true
+	}
+	return 0, false
+}
+
+// Chunk is a neighbouring chunk of a search result's document
+type Chunk struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Text  string `json:"text"`
 }
 
 // SearchRequest represents a vector search query
@@ -26,9 +112,13 @@ type SearchRequest struct {
 
 // SearchResult represents a single search result
 type SearchResult struct {
-	Vector   Vector  `json:"vector"`
-	Score    float64 `json:"score"`
-	Distance float64 `json:"distance"`
+	Vector    Vector   `json:"vector"`
+	Score     float64  `json:"score"`
+	Distance  float64  `json:"distance"`
+	Collapsed []string `json:"collapsed,omitempty"`
+	// Window holds this chunk and its neighbours in its document, in order, when requested.
+	// Neighbouring chunks may overlap.
+	Window []Chunk `json:"window,omitempty"`
 }
 
 // SearchResponse represents the complete search response
@@ -37,6 +127,21 @@ type SearchResponse struct {
 	ProcessingTime int64          `json:"processing_time_ms"`
 	Store          string         `json:"store"`
 	Cost           float64        `json:"cost"`
+	QueryID        string         `json:"query_id,omitempty"`
+	Rewrite        *QueryRewrite  `json:"rewrite,omitempty"`
+	// Personalized is set when the caller's preference vector was blended into the query
+	Personalized bool `json:"personalized,omitempty"`
+}
+
+// QueryRewrite shows how a query was spell-corrected and expanded with synonyms before embedding
+type QueryRewrite struct {
+	Original  string `json:"original"`
+	Corrected string `json:"corrected"`
+	Expanded  string `json:"expanded"`
+	// Corrections maps each misspelt word to its replacement
+	Corrections map[string]string `json:"corrections,omitempty"`
+	// Synonyms maps each matched term to the synonyms appended for it
+	Synonyms map[string][]string `json:"synonyms,omitempty"`
 }
 
 // StoreRequest represents a request to store vectors
@@ -54,6 +159,89 @@ type StoreResponse struct {
 	Cost           float64 `json:"cost"`
 }
 
+// MetadataUpdate changes the metadata of stored vectors without touching their embeddings
+type MetadataUpdate struct {
+	Namespace string `json:"namespace"`
+	// IDs selects vectors by ID; Filters selects them by metadata, as in search. Exactly one is set.
+	IDs     []string               `json:"ids,omitempty"`
+	Filters map[string]interface{} `json:"filters,omitempty"`
+	// Metadata is merged into each vector's metadata, where a null value removes the key,
+	// or with Replace set becomes its whole metadata
+	Metadata map[string]interface{} `json:"metadata"`
+	Replace  bool                   `json:"replace,omitempty"`
+}
+
+// Validate checks the update selects vectors and leaves chunk bookkeeping alone. doc_id and
+// chunk_index cannot be changed; a replace keeps them.
+func (u *MetadataUpdate) Validate() error {
+	switch {
+	case u.Namespace == "":
+		return errors.New("namespace is required")
+	case len(u.IDs) == 0 && len(u.Filters) == 0:
+		return errors.New("ids or filters is required")
+	case len(u.IDs) > 0 && len(u.Filters) > 0:
+		return errors.New("set ids or filters, not both")
+	case u.Metadata == nil:
+		return errors.New("metadata is required")
+	}
+	for _, key := range []string{MetadataDocID, MetadataChunkIndex} {
+		if _, ok := u.Metadata[key]; ok {
+			return fmt.Errorf("%s is managed by chunking and cannot be updated", key)
+		}
+	}
+	return nil
+}
+
+// Apply returns metadata with the update merged in, or replaced keeping doc_id and
+// chunk_index. metadata itself is not changed.
+func (u *MetadataUpdate) Apply(metadata map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(metadata)+len(u.Metadata))
+	if u.Replace {
+		for _, key := range []string{MetadataDocID, MetadataChunkIndex} {
+			if value, ok := metadata[key]; ok {
+				result[key] = value
+			}
+		}
+	} else {
+		for key, value := range metadata {
+			result[key] = value
+		}
+	}
+	for key, value := range u.Metadata {
+		if value == nil && !u.Replace {
+			delete(result, key)
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// MatchesFilters reports whether metadata has every filtered key with an equal value, or
+// for a NumberFilter a number within its bounds
+func MatchesFilters(metadata, filters map[string]interface{}) bool {
+	for key, value := range filters {
+		vectorValue, exists := metadata[key]
+		if numbers, ok := value.(NumberFilter); ok {
+			if !numbers.Matches(vectorValue) {
+				return false
+			}
+			continue
+		}
+		if !exists || fmt.Sprintf("%v", vectorValue) != fmt.Sprintf("%v", value) {
+			return false
+		}
+	}
+	return true
+}
+
+// MetadataUpdateResponse reports how many vectors an update changed
+type MetadataUpdateResponse struct {
+	Updated        int64  `json:"updated"`
+	ProcessingTime int64  `json:"processing_time_ms"`
+	Store          string `json:"store"`
+}
+
 // VectorStore interface defines the contract for vector storage implementations
 type VectorStore interface {
 	// Store vectors in the specified namespace
@@ -77,6 +265,19 @@ type VectorStore interface {
 	// Migrate data to another store
 	Migrate(ctx context.Context, destination VectorStore) (*MigrationResult, error)
 
+	// Clone copies every vector in source into the empty namespace target from a single consistent snapshot,
+	// reporting progress after each batch
+	Clone(ctx context.Context, source, target string, progress func(copied, total int64)) (int64, error)
+
+	// UpdateMetadata merges into or replaces the metadata of the selected vectors in place
+	UpdateMetadata(ctx context.Context, update *MetadataUpdate) (*MetadataUpdateResponse, error)
+
+	// List returns up to limit vectors of a namespace with IDs after after, ordered by ID, for paging through it
+	List(ctx context.Context, namespace, after string, limit int) ([]Vector, error)
+
+	// Chunks returns the vectors of document docID whose chunk_index lies in [from, to], ordered by index
+	Chunks(ctx context.Context, namespace, docID string, from, to int) ([]Vector, error)
+
 	// Health check
 	Health(ctx context.Context) error
 
@@ -223,6 +424,36 @@ type ChatResponse struct {
 	TokensUsed     int            `json:"tokens_used"`
 }
 
+// ExtractRequest asks for JSON matching a schema, filled in from a namespace's documents
+type ExtractRequest struct {
+	Query        string                 `json:"query"`
+	Namespace    string                 `json:"namespace,omitempty"`
+	Schema       json.RawMessage        `json:"schema"`
+	Instructions string                 `json:"instructions,omitempty"`
+	ContextLimit int                    `json:"context_limit,omitempty"`
+	Filters      map[string]interface{} `json:"filters,omitempty"`
+	// Window adds up to this many neighbouring chunks either side of each passage
+	Window int `json:"window,omitempty"`
+}
+
+// ExtractResponse carries schema-valid JSON and the passages it was extracted from
+type ExtractResponse struct {
+	Data           json.RawMessage `json:"data"`
+	Sources        []ExtractSource `json:"sources"`
+	Attempts       int             `json:"attempts"`
+	Provider       string          `json:"provider"`
+	Model          string          `json:"model"`
+	ProcessingTime int64           `json:"processing_time_ms"`
+	TokensUsed     int             `json:"tokens_used"`
+}
+
+// ExtractSource identifies a passage given to the model
+type ExtractSource struct {
+	ID    string  `json:"id"`
+	Title string  `json:"title,omitempty"`
+	Score float64 `json:"score"`
+}
+
 // HealthStatus represents the health status of the service
 type HealthStatus struct {
 	Status     string                     `json:"status"`
@@ -0,0 +1,801 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Account lifecycle stages, in the order inactivity moves an account through them
+const (
+	lifecycleActive     = "active"
+	lifecycleWarned     = "warned"
+	lifecycleLocked     = "locked"
+	lifecycleAnonymized = "anonymized"
+)
+
+// Lifecycle actions; each one moves an account to the stage of the same rank
+const (
+	lifecycleActionWarn       = "warn"
+	lifecycleActionLock       = "lock"
+	lifecycleActionAnonymize  = "anonymize"
+	lifecycleActionReactivate = "reactivate"
+	lifecycleActionDigest     = "digest"
+	lifecycleActionExempt     = "exempt"
+	lifecycleActionUnexempt   = "unexempt"
+)
+
+// lifecycleStageRank orders stages; an action only ever moves an account forward
+var lifecycleStageRank = map[string]int{
+	lifecycleActive:     0,
+	lifecycleWarned:     1,
+	lifecycleLocked:     2,
+	lifecycleAnonymized: 3,
+}
+
+var lifecycleActionStage = map[string]string{
+	lifecycleActionWarn:      lifecycleWarned,
+	lifecycleActionLock:      lifecycleLocked,
+	lifecycleActionAnonymize: lifecycleAnonymized,
+}
+
+// lifecycleBatchSize bounds how many accounts one run acts on
+const lifecycleBatchSize = 500
+
+var lifecycleActionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_lifecycle_actions_total",
+	Help: "Account lifecycle actions taken by the scheduled job, by action.",
+}, []string{"action"})
+
+// LifecycleAction applies once an account has been inactive for Months
+type LifecycleAction struct {
+	Action string `json:"action"`
+	Months int    `json:"months"`
+}
+
+// LifecycleConfig controls activity digests and what happens to inactive accounts
+type LifecycleConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	// Actions are ordered by stage; anonymize is only taken when configured explicitly
+	Actions []LifecycleAction
+	// WarningNotice is the minimum time between a warning and locking or anonymizing
+	WarningNotice time.Duration
+	// ExemptRoles are never warned, locked or anonymized
+	ExemptRoles []string
+	// DigestInterval is how often opted-in users get an activity digest; zero disables digests
+	DigestInterval time.Duration
+}
+
+// DefaultLifecycleConfig reads LIFECYCLE_* and DIGEST_INTERVAL from the environment
+func DefaultLifecycleConfig() (LifecycleConfig, error) {
+	config := LifecycleConfig{Enabled: getEnv("LIFECYCLE_ENABLED", "false") == "true"}
+
+	var err error
+	if config.Interval, err = time.ParseDuration(getEnv("LIFECYCLE_INTERVAL", "24h")); err != nil || config.Interval <= 0 {
+		return config, fmt.Errorf("LIFECYCLE_INTERVAL must be a positive duration")
+	}
+	if config.Actions, err = parseLifecycleActions(getEnv("LIFECYCLE_ACTIONS", "warn:12,lock:24")); err != nil {
+		return config, fmt.Errorf("LIFECYCLE_ACTIONS: %w", err)
+	}
+	if config.WarningNotice, err = time.ParseDuration(getEnv("LIFECYCLE_WARNING_NOTICE", "720h")); err != nil || config.WarningNotice < 0 {
+		return config, fmt.Errorf("LIFECYCLE_WARNING_NOTICE must be a duration such as 720h")
+	}
+	for _, role := range strings.Split(getEnv("LIFECYCLE_EXEMPT_ROLES", "admin"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			config.ExemptRoles = append(config.ExemptRoles, role)
+		}
+	}
+	if interval := getEnv("DIGEST_INTERVAL", ""); interval != "" {
+		if config.DigestInterval, err = time.ParseDuration(interval); err != nil || config.DigestInterval <= 0 {
+			return config, fmt.Errorf("DIGEST_INTERVAL must be a positive duration such as 168h")
+		}
+	}
+	return config, nil
+}
+
+// parseLifecycleActions reads "warn:12,lock:24,anonymize:36" (months of inactivity)
+func parseLifecycleActions(value string) ([]LifecycleAction, error) {
+	var actions []LifecycleAction
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, months, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("%q must look like action:months", entry)
+		}
+		if _, known := lifecycleActionStage[name]; !known {
+			return nil, fmt.Errorf("unknown action %q; use warn, lock or anonymize", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s is listed twice", name)
+		}
+		seen[name] = true
+		n, err := strconv.Atoi(months)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%s needs a positive number of months", name)
+		}
+		actions = append(actions, LifecycleAction{Action: name, Months: n})
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		return lifecycleStageRank[lifecycleActionStage[actions[i].Action]] < lifecycleStageRank[lifecycleActionStage[actions[j].Action]]
+	})
+	for i := 1; i < len(actions); i++ {
+		if actions[i].Months <= actions[i-1].Months {
+			return nil, fmt.Errorf("%s must come later than %s", actions[i].Action, actions[i-1].Action)
+		}
+	}
+	return actions, nil
+}
+
+// nextAction decides what the job does to an account that has been inactive since lastActive.
+// Accounts move one stage per run, so a warning always goes out before a lock when both are configured.
+func (config LifecycleConfig) nextAction(stage string, lastActive, stageChanged, now time.Time) (LifecycleAction, bool) {
+	if stage == lifecycleWarned && lastActive.After(stageChanged) {
+		return LifecycleAction{Action: lifecycleActionReactivate}, true
+	}
+	for _, action := range config.Actions {
+		if lifecycleStageRank[lifecycleActionStage[action.Action]] <= lifecycleStageRank[stage] {
+			continue
+		}
+		if now.Before(lastActive.AddDate(0, action.Months, 0)) {
+			return LifecycleAction{}, false
+		}
+		if stage == lifecycleWarned && now.Before(stageChanged.Add(config.WarningNotice)) {
+			return LifecycleAction{}, false
+		}
+		return action, true
+	}
+	return LifecycleAction{}, false
+}
+
+// upcoming is the next configured action for an account and when it becomes due
+func (config LifecycleConfig) upcoming(stage string, lastActive time.Time) (LifecycleAction, time.Time, bool) {
+	for _, action := range config.Actions {
+		if lifecycleStageRank[lifecycleActionStage[action.Action]] > lifecycleStageRank[stage] {
+			return action, lastActive.AddDate(0, action.Months, 0), true
+		}
+	}
+	return LifecycleAction{}, time.Time{}, false
+}
+
+// LifecycleService computes account activity, sends digests and applies inactivity actions
+type LifecycleService struct {
+	as       *AuthService
+	config   LifecycleConfig
+	notifier Notifier
+}
+
+// NewLifecycleService returns nil when lifecycle jobs are disabled
+func NewLifecycleService(as *AuthService, config LifecycleConfig, notifier Notifier) *LifecycleService {
+	if !config.Enabled {
+		return nil
+	}
+	return &LifecycleService{as: as, config: config, notifier: notifier}
+}
+
+// lifecyclePlanItem is one action a run took, or would take in a dry run
+type lifecyclePlanItem struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Username   string    `json:"username"`
+	Action     string    `json:"action"`
+	LastActive time.Time `json:"last_active_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// lifecycleRun summarises one pass of the job
+type lifecycleRun struct {
+	DryRun     bool                `json:"dry_run"`
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt time.Time           `json:"finished_at"`
+	Computed   int64               `json:"accounts_computed"`
+	Digests    int                 `json:"digests_sent"`
+	Actions    []lifecyclePlanItem `json:"actions"`
+}
+
+// RunJob is the account_lifecycle background job
+func (s *LifecycleService) RunJob(ctx context.Context) (string, error) {
+	run, err := s.run(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d accounts computed, %d digests, %d actions", run.Computed, run.Digests, len(run.Actions)), nil
+}
+
+// run computes activity, sends due digests, then applies due lifecycle actions
+func (s *LifecycleService) run(ctx context.Context, dryRun bool) (*lifecycleRun, error) {
+	run := &lifecycleRun{DryRun: dryRun, StartedAt: time.Now()}
+
+	computed, err := s.computeActivity(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("computing activity: %w", err)
+	}
+	run.Computed = computed
+
+	if !dryRun && s.config.DigestInterval > 0 {
+		if run.Digests, err = s.sendDigests(ctx); err != nil {
+			log.Printf("Activity digests stopped early: %v", err)
+		}
+	}
+
+	candidates, err := s.candidates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing inactive accounts: %w", err)
+	}
+	for _, candidate := range candidates {
+		action, due := s.config.nextAction(candidate.stage, candidate.lastActive, candidate.stageChanged, run.StartedAt)
+		if !due {
+			continue
+		}
+		item := lifecyclePlanItem{UserID: candidate.userID, Username: candidate.username, Action: action.Action, LastActive: candidate.lastActive}
+		if !dryRun {
+			if err := s.apply(ctx, candidate, action, "system", "inactivity"); err != nil {
+				item.Error = err.Error()
+				log.Printf("Lifecycle action %s failed for user %s: %v", action.Action, candidate.userID, err)
+			} else {
+				lifecycleActionsTotal.WithLabelValues(action.Action).Inc()
+			}
+		}
+		run.Actions = append(run.Actions, item)
+	}
+
+	run.FinishedAt = time.Now()
+	return run, nil
+}
+
+// computeActivity refreshes last_active_at from logins and token use, for every account
+func (s *LifecycleService) computeActivity(ctx context.Context) (int64, error) {
+	result, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO user_activity (user_id, last_active_at, stage_changed_at, computed_at)
+		SELECT u.id, GREATEST(u.created_at, u.last_login_at, t.last_token), NOW(), NOW()
+		FROM users u
+		LEFT JOIN (
+			SELECT user_id, MAX(GREATEST(created_at, last_used)) AS last_token
+			FROM oauth_access_tokens WHERE user_id IS NOT NULL GROUP BY user_id
+		) t ON t.user_id = u.id
+		ON CONFLICT (user_id) DO UPDATE
+		SET last_active_at = GREATEST(user_activity.last_active_at, EXCLUDED.last_active_at), computed_at = NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+type lifecycleCandidate struct {
+	userID       uuid.UUID
+	username     string
+	email        string
+	stage        string
+	lastActive   time.Time
+	stageChanged time.Time
+}
+
+// candidates lists accounts that may be due an action: inactive past the first threshold,
+// or warned and active again. Exempt accounts and roles are left out.
+func (s *LifecycleService) candidates(ctx context.Context) ([]lifecycleCandidate, error) {
+	if len(s.config.Actions) == 0 {
+		return nil, nil
+	}
+	cutoff := time.Now().AddDate(0, -s.config.Actions[0].Months, 0)
+
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT a.user_id, u.username, u.email, a.stage, a.last_active_at, a.stage_changed_at
+		FROM user_activity a JOIN users u ON u.id = a.user_id
+		WHERE a.stage <> $1
+			AND (a.last_active_at < $2 OR (a.stage = $3 AND a.last_active_at > a.stage_changed_at))
+			AND NOT EXISTS (SELECT 1 FROM lifecycle_exemptions e
+				WHERE e.user_id = a.user_id AND (e.expires_at IS NULL OR e.expires_at > NOW()))
+			AND NOT EXISTS (SELECT 1 FROM user_roles r WHERE r.user_id = a.user_id AND r.role = ANY($4))
+		ORDER BY a.last_active_at
+		LIMIT $5`,
+		lifecycleAnonymized, cutoff, lifecycleWarned, pq.Array(s.config.ExemptRoles), lifecycleBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []lifecycleCandidate
+	for rows.Next() {
+		var candidate lifecycleCandidate
+		if err := rows.Scan(&candidate.userID, &candidate.username, s.as.pii.scan(piiUserEmail, &candidate.email), &candidate.stage,
+			&candidate.lastActive, &candidate.stageChanged); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// apply takes one action and records it in the audit trail
+func (s *LifecycleService) apply(ctx context.Context, candidate lifecycleCandidate, action LifecycleAction, actor, reason string) error {
+	details := map[string]interface{}{
+		"previous_stage": candidate.stage,
+		"last_active_at": candidate.lastActive,
+	}
+	if action.Months > 0 {
+		details["inactive_months"] = action.Months
+	}
+
+	switch action.Action {
+	case lifecycleActionWarn:
+		// The warning is delivered before the stage changes so a failed delivery is retried next run
+		next, due, _ := s.config.upcoming(lifecycleWarned, candidate.lastActive)
+		earliest := time.Now().Add(s.config.WarningNotice)
+		if due.Before(earliest) {
+			due = earliest
+		}
+		err := s.notifier.Notify(ctx, Notification{
+			Type:     "inactivity_warning",
+			UserID:   candidate.userID,
+			Email:    candidate.email,
+			Username: candidate.username,
+			Data:     map[string]interface{}{"last_active_at": candidate.lastActive, "next_action": next.Action, "next_action_after": due},
+		})
+		if err != nil {
+			return fmt.Errorf("delivering warning: %w", err)
+		}
+		details["next_action"] = next.Action
+		return s.transition(ctx, candidate.userID, lifecycleWarned, action.Action, actor, reason, details, nil)
+
+	case lifecycleActionLock:
+		if err := s.transition(ctx, candidate.userID, lifecycleLocked, action.Action, actor, reason, details, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `UPDATE users SET is_active = false, updated_at = NOW() WHERE id = $1`, candidate.userID); err != nil {
+				return err
+			}
+			return revokeUserTokens(ctx, tx, candidate.userID)
+		}); err != nil {
+			return err
+		}
+		s.as.publishRevocation(RevocationEvent{Type: revocationUser, UserID: candidate.userID.String()})
+		if err := s.notifier.Notify(ctx, Notification{
+			Type: "account_locked", UserID: candidate.userID, Email: candidate.email, Username: candidate.username,
+			Data: map[string]interface{}{"last_active_at": candidate.lastActive},
+		}); err != nil {
+			log.Printf("Failed to notify user %s of account lock: %v", candidate.userID, err)
+		}
+		return nil
+
+	case lifecycleActionAnonymize:
+		var avatarKey sql.NullString
+		if err := s.transition(ctx, candidate.userID, lifecycleAnonymized, action.Action, actor, reason, details, func(tx *sql.Tx) error {
+			var err error
+			if avatarKey, err = s.as.anonymizeUser(ctx, tx, candidate.userID); err != nil {
+				return err
+			}
+			// Downstream services erase the user through the deletion saga
+			return s.as.deletions.start(ctx, tx, candidate.userID, actor, reason)
+		}); err != nil {
+			return err
+		}
+		s.as.publishRevocation(RevocationEvent{Type: revocationUser, UserID: candidate.userID.String()})
+		s.as.forgetProfiles(ctx, candidate.userID)
+		if avatarKey.Valid && s.as.files != nil {
+			if err := s.as.files.store.Delete(ctx, avatarKey.String); err != nil {
+				log.Printf("Failed to delete avatar object %s: %v", avatarKey.String, err)
+			}
+		}
+		return nil
+
+	case lifecycleActionReactivate:
+		return s.transition(ctx, candidate.userID, lifecycleActive, action.Action, actor, reason, details, nil)
+	}
+	return fmt.Errorf("unknown lifecycle action %q", action.Action)
+}
+
+// transition moves an account to stage, runs change in the same transaction and records the event
+func (s *LifecycleService) transition(ctx context.Context, userID uuid.UUID, stage, action, actor, reason string,
+	details map[string]interface{}, change func(tx *sql.Tx) error) error {
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if change != nil {
+		if err := change(tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE user_activity SET stage = $2, stage_changed_at = NOW() WHERE user_id = $1`, userID, stage); err != nil {
+		return err
+	}
+	if err := recordLifecycleEvent(ctx, tx, userID, action, actor, reason, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordLifecycleEvent appends to the lifecycle audit trail
+func recordLifecycleEvent(ctx context.Context, db sqlExecer, userID uuid.UUID, action, actor, reason string, details map[string]interface{}) error {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO account_lifecycle_events (id, user_id, action, actor, reason, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())`, uuid.New(), userID, action, actor, reason, encoded)
+	return err
+}
+
+// revokeUserTokens revokes every access and refresh token held by a user
+func revokeUserTokens(ctx context.Context, tx *sql.Tx, userID uuid.UUID) error {
+	for _, table := range []string{"oauth_access_tokens", "oauth_refresh_tokens"} {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %s SET is_revoked = true, revoked_at = NOW() WHERE user_id = $1 AND is_revoked = false`, table), userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendDigests notifies opted-in users whose last digest is older than DigestInterval
+func (s *LifecycleService) sendDigests(ctx context.Context) (int, error) {
+	since := time.Now().Add(-s.config.DigestInterval)
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT a.user_id, u.username, u.email, a.last_active_at, COALESCE(a.last_digest_at, $1),
+			(SELECT COUNT(*) FROM oauth_access_tokens t
+				WHERE t.user_id = a.user_id AND t.created_at >= COALESCE(a.last_digest_at, $1)),
+			(SELECT COUNT(DISTINCT t.client_id) FROM oauth_access_tokens t
+				WHERE t.user_id = a.user_id AND t.is_revoked = false AND t.expires_at > NOW())
+		FROM user_activity a JOIN users u ON u.id = a.user_id
+		WHERE a.digest_opt_in AND a.stage = $2 AND (a.last_digest_at IS NULL OR a.last_digest_at < $1)
+		LIMIT $3`, since, lifecycleActive, lifecycleBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var digests []Notification
+	for rows.Next() {
+		var n Notification
+		var lastActive, periodStart time.Time
+		var tokensIssued, applications int
+		if err := rows.Scan(&n.UserID, &n.Username, s.as.pii.scan(piiUserEmail, &n.Email), &lastActive, &periodStart, &tokensIssued, &applications); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		n.Type = "activity_digest"
+		n.Data = map[string]interface{}{
+			"period_start":         periodStart,
+			"period_end":           time.Now(),
+			"last_active_at":       lastActive,
+			"tokens_issued":        tokensIssued,
+			"authorized_apps":      applications,
+			"unsubscribe_endpoint": "/api/v1/auth/me/activity-digest",
+		}
+		digests = append(digests, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, digest := range digests {
+		if err := s.notifier.Notify(ctx, digest); err != nil {
+			return sent, err
+		}
+		if _, err := s.as.db.ExecContext(ctx, `UPDATE user_activity SET last_digest_at = NOW() WHERE user_id = $1`, digest.UserID); err != nil {
+			return sent, err
+		}
+		lifecycleActionsTotal.WithLabelValues(lifecycleActionDigest).Inc()
+		sent++
+	}
+	return sent, nil
+}
+
+// User API
+
+// GetActivity returns the caller's activity record and the next inactivity action, if any
+func (s *LifecycleService) GetActivity(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var stage string
+	var lastActive time.Time
+	var digestOptIn bool
+	var lastDigest sql.NullTime
+	err := s.as.db.QueryRowContext(c.Request.Context(), `
+		SELECT stage, last_active_at, digest_opt_in, last_digest_at FROM user_activity WHERE user_id = $1`, userID).
+		Scan(&stage, &lastActive, &digestOptIn, &lastDigest)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Not computed yet; the account is new
+		stage, lastActive = lifecycleActive, time.Now()
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to load activity"})
+		return
+	}
+
+	response := gin.H{
+		"stage":          stage,
+		"last_active_at": lastActive,
+		"digest": gin.H{
+			"enabled":   digestOptIn,
+			"available": s.config.DigestInterval > 0,
+		},
+	}
+	if lastDigest.Valid {
+		response["digest"].(gin.H)["last_sent_at"] = lastDigest.Time
+	}
+	if next, due, ok := s.config.upcoming(stage, lastActive); ok {
+		response["next_action"] = gin.H{"action": next.Action, "after": due}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SetActivityDigest opts the caller in to or out of periodic activity digests
+func (s *LifecycleService) SetActivityDigest(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "enabled is required"})
+		return
+	}
+	if *req.Enabled && s.config.DigestInterval == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "digests_disabled", "error_description": "Activity digests are not enabled on this server"})
+		return
+	}
+
+	_, err := s.as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO user_activity (user_id, last_active_at, stage_changed_at, digest_opt_in, computed_at)
+		VALUES ($1, NOW(), NOW(), $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET digest_opt_in = $2`, userID, *req.Enabled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to update digest preference"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": *req.Enabled})
+}
+
+// Admin API
+
+func lifecycleAdminID(c *gin.Context) string {
+	if adminID, ok := c.Get("user_id"); ok {
+		if id, ok := adminID.(uuid.UUID); ok {
+			return id.String()
+		}
+	}
+	return "admin"
+}
+
+// AdminRunLifecycle runs the job now; ?dry_run=true reports what it would do without acting
+func (s *LifecycleService) AdminRunLifecycle(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	run, err := s.run(c.Request.Context(), dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Account lifecycle run requested by %s (dry run: %t): %d actions", lifecycleAdminID(c), dryRun, len(run.Actions))
+	c.JSON(http.StatusOK, run)
+}
+
+// AdminListLifecycleEvents returns the audit trail, newest first, optionally for one user or action
+func (s *LifecycleService) AdminListLifecycleEvents(c *gin.Context) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		args = append(args, id)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if action := c.Query("action"); action != "" {
+		args = append(args, action)
+		conditions = append(conditions, fmt.Sprintf("action = $%d", len(args)))
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	rows, err := s.as.db.QueryContext(c.Request.Context(), fmt.Sprintf(`
+		SELECT id, user_id, action, actor, reason, details, created_at
+		FROM account_lifecycle_events WHERE %s
+		ORDER BY created_at DESC LIMIT $%d`, strings.Join(conditions, " AND "), len(args)), args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load lifecycle events"})
+		return
+	}
+	defer rows.Close()
+
+	events := []gin.H{}
+	for rows.Next() {
+		var id, userID uuid.UUID
+		var action, actor, reason string
+		var details []byte
+		var createdAt time.Time
+		if err := rows.Scan(&id, &userID, &action, &actor, &reason, &details, &createdAt); err != nil {
+			continue
+		}
+		events = append(events, gin.H{
+			"id": id, "user_id": userID, "action": action, "actor": actor,
+			"reason": reason, "details": json.RawMessage(details), "created_at": createdAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// AdminListLifecycleExemptions lists accounts the job leaves alone
+func (s *LifecycleService) AdminListLifecycleExemptions(c *gin.Context) {
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `
+		SELECT e.user_id, u.username, e.reason, e.created_by, e.created_at, e.expires_at
+		FROM lifecycle_exemptions e LEFT JOIN users u ON u.id = e.user_id
+		ORDER BY e.created_at DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exemptions"})
+		return
+	}
+	defer rows.Close()
+
+	exemptions := []gin.H{}
+	for rows.Next() {
+		var userID uuid.UUID
+		var username sql.NullString
+		var reason, createdBy string
+		var createdAt time.Time
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&userID, &username, &reason, &createdBy, &createdAt, &expiresAt); err != nil {
+			continue
+		}
+		exemption := gin.H{"user_id": userID, "username": username.String, "reason": reason, "created_by": createdBy, "created_at": createdAt}
+		if expiresAt.Valid {
+			exemption["expires_at"] = expiresAt.Time
+		}
+		exemptions = append(exemptions, exemption)
+	}
+	c.JSON(http.StatusOK, gin.H{"exemptions": exemptions, "exempt_roles": s.config.ExemptRoles})
+}
+
+// AdminPutLifecycleExemption exempts an account, optionally until expires_at
+func (s *LifecycleService) AdminPutLifecycleExemption(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req struct {
+		Reason    string     `json:"reason" binding:"required"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format; reason is required"})
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	actor := lifecycleAdminID(c)
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exemption"})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO lifecycle_exemptions (user_id, reason, created_by, created_at, expires_at)
+		SELECT id, $2, $3, NOW(), $4 FROM users WHERE id = $1
+		ON CONFLICT (user_id) DO UPDATE SET reason = $2, created_by = $3, created_at = NOW(), expires_at = $4`,
+		userID, req.Reason, actor, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exemption"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := recordLifecycleEvent(ctx, tx, userID, lifecycleActionExempt, actor, req.Reason, map[string]interface{}{"expires_at": req.ExpiresAt}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exemption"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exemption"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "reason": req.Reason, "expires_at": req.ExpiresAt})
+}
+
+// AdminDeleteLifecycleExemption makes an account subject to the lifecycle job again
+func (s *LifecycleService) AdminDeleteLifecycleExemption(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	ctx := c.Request.Context()
+	result, err := s.as.db.ExecContext(ctx, `DELETE FROM lifecycle_exemptions WHERE user_id = $1`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove exemption"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Exemption not found"})
+		return
+	}
+	if err := recordLifecycleEvent(ctx, s.as.db, userID, lifecycleActionUnexempt, lifecycleAdminID(c), "", nil); err != nil {
+		log.Printf("Failed to record removal of lifecycle exemption for %s: %v", userID, err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// AdminReactivateUser unlocks an account locked for inactivity. Anonymized accounts cannot be restored.
+func (s *LifecycleService) AdminReactivateUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	c.ShouldBindJSON(&req)
+
+	var stage string
+	err = s.as.db.QueryRowContext(c.Request.Context(), `SELECT stage FROM user_activity WHERE user_id = $1`, userID).Scan(&stage)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No lifecycle record for this user"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load lifecycle record"})
+		return
+	}
+	switch stage {
+	case lifecycleAnonymized:
+		c.JSON(http.StatusConflict, gin.H{"error": "Anonymized accounts cannot be reactivated"})
+		return
+	case lifecycleActive:
+		c.JSON(http.StatusOK, gin.H{"user_id": userID, "stage": stage})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err = s.transition(ctx, userID, lifecycleActive, lifecycleActionReactivate, lifecycleAdminID(c), req.Reason,
+		map[string]interface{}{"previous_stage": stage}, func(tx *sql.Tx) error {
+			// Restart the inactivity clock so the account is not locked again on the next run
+			if _, err := tx.ExecContext(ctx, `UPDATE user_activity SET last_active_at = NOW() WHERE user_id = $1`, userID); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `UPDATE users SET is_active = true, updated_at = NOW() WHERE id = $1`, userID)
+			return err
+		})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reactivate user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "stage": lifecycleActive})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AccountLifecycleTestSuite struct {
+	suite.Suite
+	config LifecycleConfig
+	now    time.Time
+}
+
+func (suite *AccountLifecycleTestSuite) SetupTest() {
+	actions, err := parseLifecycleActions("lock:24, warn:12")
+	suite.Require().NoError(err)
+	suite.config = LifecycleConfig{Enabled: true, Actions: actions, WarningNotice: 30 * 24 * time.Hour}
+	suite.now = time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (suite *AccountLifecycleTestSuite) monthsAgo(months int) time.Time {
+	return suite.now.AddDate(0, -months, 0)
+}
+
+func (suite *AccountLifecycleTestSuite) TestActionsAreOrderedByStage() {
+	suite.Equal([]LifecycleAction{{Action: "warn", Months: 12}, {Action: "lock", Months: 24}}, suite.config.Actions)
+
+	_, err := parseLifecycleActions("warn:12,lock:6")
+	suite.Error(err, "lock before warn")
+	_, err = parseLifecycleActions("warn:12,warn:18")
+	suite.Error(err)
+	_, err = parseLifecycleActions("delete:12")
+	suite.Error(err)
+	_, err = parseLifecycleActions("lock:0")
+	suite.Error(err)
+}
+
+func (suite *AccountLifecycleTestSuite) TestAccountsMoveOneStageAtATime() {
+	_, due := suite.config.nextAction(lifecycleActive, suite.monthsAgo(11), suite.monthsAgo(11), suite.now)
+	suite.False(due)
+
+	// Inactive long enough to lock, but an active account is warned first
+	action, due := suite.config.nextAction(lifecycleActive, suite.monthsAgo(30), suite.monthsAgo(30), suite.now)
+	suite.True(due)
+	suite.Equal(lifecycleActionWarn, action.Action)
+
+	// The lock waits for the warning notice period
+	_, due = suite.config.nextAction(lifecycleWarned, suite.monthsAgo(30), suite.now.Add(-24*time.Hour), suite.now)
+	suite.False(due)
+	action, due = suite.config.nextAction(lifecycleWarned, suite.monthsAgo(30), suite.monthsAgo(2), suite.now)
+	suite.True(due)
+	suite.Equal(lifecycleActionLock, action.Action)
+
+	// Anonymize is never implied
+	_, due = suite.config.nextAction(lifecycleLocked, suite.monthsAgo(120), suite.monthsAgo(96), suite.now)
+	suite.False(due)
+}
+
+func (suite *AccountLifecycleTestSuite) TestActivityAfterWarningReactivates() {
+	action, due := suite.config.nextAction(lifecycleWarned, suite.now.Add(-time.Hour), suite.monthsAgo(1), suite.now)
+	suite.True(due)
+	suite.Equal(lifecycleActionReactivate, action.Action)
+}
+
+func (suite *AccountLifecycleTestSuite) TestSettings() {
+	suite.T().Setenv("LIFECYCLE_ACTIONS", "warn:6,lock:12,anonymize:36")
+	suite.T().Setenv("DIGEST_INTERVAL", "168h")
+	config, err := DefaultLifecycleConfig()
+	suite.Require().NoError(err)
+	suite.Len(config.Actions, 3)
+	suite.Equal(168*time.Hour, config.DigestInterval)
+	suite.Equal([]string{"admin"}, config.ExemptRoles)
+
+	suite.T().Setenv("DIGEST_INTERVAL", "weekly")
+	_, err = DefaultLifecycleConfig()
+	suite.Error(err)
+}
+
+func TestAccountLifecycleTestSuite(t *testing.T) {
+	suite.Run(t, new(AccountLifecycleTestSuite))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AdminListenerConfig controls where the admin API is served. By default it shares
+// the public listener; setting ADMIN_LISTEN_ADDR moves it to a second listener
+// that can be bound to a private interface and protected with TLS or mTLS.
+type AdminListenerConfig struct {
+	// Addr is the admin listener address, e.g. "10.0.0.5:9443"; empty keeps single-listener mode
+	Addr         string
+	TLSCertFile  string
+	TLSKeyFile   string
+	ClientCAFile string
+	// AllowedNetworks restricts admin routes by the connecting address, in either mode
+	AllowedNetworks []*net.IPNet
+}
+
+// DefaultAdminListenerConfig reads admin listener settings from the environment
+func DefaultAdminListenerConfig() (AdminListenerConfig, error) {
+	config := AdminListenerConfig{
+		Addr:         getEnv("ADMIN_LISTEN_ADDR", ""),
+		TLSCertFile:  getEnv("ADMIN_TLS_CERT_FILE", ""),
+		TLSKeyFile:   getEnv("ADMIN_TLS_KEY_FILE", ""),
+		ClientCAFile: getEnv("ADMIN_TLS_CLIENT_CA_FILE", ""),
+	}
+
+	networks, err := parseNetworks(getEnv("ADMIN_ALLOWED_IPS", ""))
+	if err != nil {
+		return config, fmt.Errorf("ADMIN_ALLOWED_IPS: %w", err)
+	}
+	config.AllowedNetworks = networks
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return config, fmt.Errorf("ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE must be set together")
+	}
+	if config.ClientCAFile != "" && config.TLSCertFile == "" {
+		return config, fmt.Errorf("ADMIN_TLS_CLIENT_CA_FILE requires ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE")
+	}
+	if config.TLSCertFile != "" && config.Addr == "" {
+		return config, fmt.Errorf("ADMIN_TLS_* settings require ADMIN_LISTEN_ADDR")
+	}
+	return config, nil
+}
+
+// Separate reports whether the admin API has its own listener
+func (c AdminListenerConfig) Separate() bool {
+	return c.Addr != ""
+}
+
+// parseNetworks parses a comma separated list of CIDRs and bare IPs
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// AdminAllowlistMiddleware rejects requests from addresses outside the allowed networks.
+// It checks the connecting address rather than X-Forwarded-For, which clients control.
+func AdminAllowlistMiddleware(networks []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if remoteAddrIn(c.Request, networks) {
+			c.Next()
+			return
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "forbidden",
+			"error_description": "Admin API is not available from this address",
+		})
+		c.Abort()
+	}
+}
+
+// remoteAddrIn reports whether a request's connecting address lies in one of the networks
+func remoteAddrIn(r *http.Request, networks []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setupAdminRouter builds the router for the dedicated admin listener
+func setupAdminRouter(authService *AuthService) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(RequestTimeoutMiddleware(authService.queryTimeouts.RequestTimeout))
+	r.Use(LoggingMiddleware())
+	r.Use(SecurityHeadersMiddleware())
+
+	routes := newRouteGroup(&r.RouterGroup, authService)
+	routes.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"service":   "auth-service-admin",
+			"status":    "healthy",
+			"store":     authService.storeInfo(),
+			"timestamp": time.Now().Unix(),
+		})
+	})
+	if authService.probe != nil {
+		routes.GET("/health/deep", authService.probe.Handler)
+	}
+	routes.GET("/metrics", gin.WrapH(metricsHandler()))
+	routes.GET("/metrics-docs", MetricsDocs(authService.slo, prometheus.DefaultGatherer))
+	registerProfilingRoutes(routes, authService)
+
+	registerAdminRoutes(routes.Group("/api/v1/auth/admin"), authService)
+	registerDashboardRoutes(routes, authService)
+	return r
+}
+
+// newAdminServer creates the admin HTTP server, requiring client certificates when a CA is configured
+func newAdminServer(config AdminListenerConfig, handler http.Handler) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:           config.Addr,
+		Handler:        handler,
+		ReadTimeout:    time.Second * 15,
+		WriteTimeout:   time.Second * 15,
+		IdleTimeout:    time.Second * 60,
+		MaxHeaderBytes: 1 << 20, // 1MB
+	}
+	if config.TLSCertFile == "" {
+		return srv, nil
+	}
+
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("%s contains no PEM certificates", config.ClientCAFile)
+		}
+		srv.TLSConfig.ClientCAs = pool
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return srv, nil
+}
+
+// startAdminServer serves the admin API on its own listener
+func startAdminServer(authService *AuthService) (*http.Server, error) {
+	config := authService.adminListener
+	srv, err := newAdminServer(config, setupAdminRouter(authService))
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		var err error
+		if config.TLSCertFile != "" {
+			log.Printf("Admin API starting on %s (TLS, client certificates required: %t)", config.Addr, config.ClientCAFile != "")
+			err = srv.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			log.Printf("Admin API starting on %s", config.Addr)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start admin server: %v", err)
+		}
+	}()
+	return srv, nil
+}
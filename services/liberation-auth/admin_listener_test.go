@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type AdminListenerTestSuite struct {
+	suite.Suite
+	authService *AuthService
+}
+
+func (suite *AdminListenerTestSuite) SetupSuite() {
+	gin.SetMode(gin.TestMode)
+
+	jwtManager, err := NewJWTManager("test-secret", "test-issuer")
+	suite.Require().NoError(err)
+	suite.authService = &AuthService{jwt: jwtManager}
+}
+
+func (suite *AdminListenerTestSuite) request(router *gin.Engine, remoteAddr string) int {
+	req := httptest.NewRequest("GET", "/api/v1/auth/admin/users", nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func (suite *AdminListenerTestSuite) TestParseNetworks() {
+	networks, err := parseNetworks("10.0.0.0/8, 192.168.1.5,::1")
+	suite.Require().NoError(err)
+	suite.Len(networks, 3)
+	suite.Equal("192.168.1.5/32", networks[1].String())
+	suite.Equal("::1/128", networks[2].String())
+
+	_, err = parseNetworks("10.0.0.0/8,intranet")
+	suite.Error(err)
+}
+
+func (suite *AdminListenerTestSuite) TestSettingsAreValidated() {
+	suite.T().Setenv("ADMIN_TLS_CERT_FILE", "admin.crt")
+	_, err := DefaultAdminListenerConfig()
+	suite.Error(err, "certificate without key")
+
+	suite.T().Setenv("ADMIN_TLS_KEY_FILE", "admin.key")
+	_, err = DefaultAdminListenerConfig()
+	suite.Error(err, "TLS without a separate listener")
+
+	suite.T().Setenv("ADMIN_LISTEN_ADDR", "127.0.0.1:9443")
+	config, err := DefaultAdminListenerConfig()
+	suite.NoError(err)
+	suite.True(config.Separate())
+}
+
+func (suite *AdminListenerTestSuite) TestSeparateListenerRemovesPublicAdminRoutes() {
+	service := *suite.authService
+	service.adminListener = AdminListenerConfig{Addr: "127.0.0.1:9443"}
+
+	suite.Equal(http.StatusNotFound, suite.request(setupRouter(&service), "203.0.113.7:5000"))
+	suite.Equal(http.StatusUnauthorized, suite.request(setupAdminRouter(&service), "10.0.0.2:5000"))
+}
+
+func (suite *AdminListenerTestSuite) TestAllowlistChecksConnectingAddress() {
+	networks, err := parseNetworks("10.0.0.0/8")
+	suite.Require().NoError(err)
+	service := *suite.authService
+	service.adminListener = AdminListenerConfig{AllowedNetworks: networks}
+	router := setupRouter(&service)
+
+	suite.Equal(http.StatusUnauthorized, suite.request(router, "10.1.2.3:5000"))
+	suite.Equal(http.StatusForbidden, suite.request(router, "203.0.113.7:5000"))
+
+	// A forged forwarding header does not get a request past the allowlist
+	req := httptest.NewRequest("GET", "/api/v1/auth/admin/users", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	req.Header.Set("X-Forwarded-For", "10.1.2.3")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func TestAdminListenerTestSuite(t *testing.T) {
+	suite.Run(t, new(AdminListenerTestSuite))
+}
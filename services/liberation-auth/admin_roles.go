@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Admin roles. admin predates the sub-roles and keeps every permission, like superadmin.
+const (
+	adminRoleLegacy   = "admin"
+	adminRoleSuper    = "superadmin"
+	adminRoleReadOnly = "readonly_admin"
+	adminRoleSupport  = "support"
+	adminRoleSecurity = "security"
+)
+
+// Admin permissions, each covering a group of admin endpoints
+const (
+	permUsersRead          = "users:read"
+	permUsersWrite         = "users:write"
+	permUsersResetMFA      = "users:reset_mfa"
+	permRolesManage        = "roles:manage"
+	permSecurityEventsRead = "security_events:read"
+	permTokensRead         = "tokens:read"
+	permTokensRevoke       = "tokens:revoke"
+	// Everything else in the admin API: clients, branding, policies, jobs, tenants and the like
+	permConfigRead  = "config:read"
+	permConfigWrite = "config:write"
+)
+
+// allAdminPermissions are held by superadmins
+var allAdminPermissions = []string{
+	permUsersRead, permUsersWrite, permUsersResetMFA, permRolesManage,
+	permSecurityEventsRead, permTokensRead, permTokensRevoke, permConfigRead, permConfigWrite,
+}
+
+// adminRolePermissions are the built-in admin roles. A user holding several gets the union.
+var adminRolePermissions = map[string][]string{
+	adminRoleLegacy:   allAdminPermissions,
+	adminRoleSuper:    allAdminPermissions,
+	adminRoleReadOnly: {permUsersRead, permSecurityEventsRead, permTokensRead, permConfigRead},
+	adminRoleSupport:  {permUsersRead, permUsersResetMFA},
+	adminRoleSecurity: {permSecurityEventsRead, permTokensRead, permTokensRevoke},
+}
+
+// adminRoutePermissions maps admin endpoints, relative to the admin group, to the permission
+// they need. Endpoints not listed need config:read for GET and config:write otherwise.
+var adminRoutePermissions = map[string]string{
+	"GET /search":                                permUsersRead,
+	"GET /users":                                 permUsersRead,
+	"GET /users/:user_id":                        permUsersRead,
+	"GET /users/:user_id/consent-history":        permUsersRead,
+	"GET /users/:user_id/moderation":             permUsersRead,
+	"GET /invites":                               permUsersRead,
+	"GET /lifecycle/events":                      permUsersRead,
+	"GET /lifecycle/exemptions":                  permUsersRead,
+	"GET /signup-reviews":                        permUsersRead,
+	"GET /recovery/requests":                     permUsersRead,
+	"GET /recovery/requests/:request_id":         permUsersRead,
+	"PUT /users/:user_id":                        permUsersWrite,
+	"POST /users/:user_id/reactivate":            permUsersWrite,
+	"PUT /lifecycle/exemptions/:user_id":         permUsersWrite,
+	"DELETE /lifecycle/exemptions/:user_id":      permUsersWrite,
+	"POST /signup-reviews/:user_id/approve":      permUsersWrite,
+	"POST /signup-reviews/:user_id/reject":       permUsersWrite,
+	"PUT /users/:user_id/spam-label":             permUsersWrite,
+	"POST /recovery/requests/:request_id/cancel": permUsersWrite,
+	"POST /users/:user_id/mfa/reset":             permUsersResetMFA,
+	"POST /users/:user_id/roles":                 permRolesManage,
+	"DELETE /users/:user_id/roles/:role":         permRolesManage,
+	"GET /security-events":                       permSecurityEventsRead,
+	"GET /security-events/export":                permSecurityEventsRead,
+	"GET /dashboard":                             permSecurityEventsRead,
+	"GET /moderation/events":                     permSecurityEventsRead,
+	"GET /recovery/abuse":                        permSecurityEventsRead,
+	"GET /oauth/tokens":                          permTokensRead,
+	"GET /oauth/revocations/:job_id":             permTokensRead,
+	"GET /oauth/revocations/:job_id/report":      permTokensRead,
+	"DELETE /oauth/tokens/:token_id":             permTokensRevoke,
+	"POST /oauth/revocations":                    permTokensRevoke,
+	// Snapshots only read the database, so read-only admins can take them for auditors
+	"POST /compliance/snapshots": permConfigRead,
+	// Erasing a user is a users:write action; support can follow the deletion
+	"POST /users/:user_id/erase":          permUsersWrite,
+	"POST /users/:user_id/deletion/retry": permUsersWrite,
+	"GET /users/:user_id/deletion":        permUsersRead,
+	"GET /user-deletions":                 permUsersRead,
+	// Pinning a user to a region changes where their data may be written
+	"GET /residency":                permConfigRead,
+	"GET /users/:user_id/residency": permUsersRead,
+	"PUT /users/:user_id/residency": permUsersWrite,
+}
+
+// Context keys set by AdminPermissionMiddleware for handlers and audit entries
+const (
+	adminPermissionKey = "admin_permission"
+	adminRolesKey      = "admin_roles"
+)
+
+// adminPermissionFor is the permission an admin endpoint needs
+func adminPermissionFor(method, route string) string {
+	if permission, ok := adminRoutePermissions[method+" "+route]; ok {
+		return permission
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return permConfigRead
+	}
+	return permConfigWrite
+}
+
+// adminPermissions is the union of the permissions of the admin roles among roles
+func adminPermissions(roles []string) map[string]bool {
+	granted := make(map[string]bool)
+	for _, role := range roles {
+		for _, permission := range adminRolePermissions[role] {
+			granted[permission] = true
+		}
+	}
+	return granted
+}
+
+// adminRoles keeps the admin roles among a user's roles
+func adminRoles(roles []string) []string {
+	held := []string{}
+	for _, role := range roles {
+		if _, ok := adminRolePermissions[role]; ok {
+			held = append(held, role)
+		}
+	}
+	sort.Strings(held)
+	return held
+}
+
+// AdminPermissionMiddleware checks the caller's admin roles against the permission the
+// endpoint needs. basePath is the admin group's path, which routes are listed relative to.
+// Trusted services act as superadmins. Refusals are recorded as admin_access_denied events.
+func AdminPermissionMiddleware(authService *AuthService, basePath string) gin.HandlerFunc {
+	basePath = strings.TrimRight(basePath, "/")
+	return func(c *gin.Context) {
+		permission := adminPermissionFor(c.Request.Method, strings.TrimPrefix(c.FullPath(), basePath))
+		c.Set(adminPermissionKey, permission)
+		if isServiceCall(c) {
+			c.Next()
+			return
+		}
+
+		value, exists := c.Get("user_id")
+		userID, err := uuid.Parse(fmt.Sprint(value))
+		if !exists || err != nil {
+			setBearerChallenge(c, "", "", "")
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":             "unauthorized",
+				"error_description": "User authentication required",
+			})
+			c.Abort()
+			return
+		}
+
+		roles, err := authService.getUserRoles(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to check admin roles"})
+			c.Abort()
+			return
+		}
+		held := adminRoles(roles)
+		c.Set(adminRolesKey, held)
+		if !adminPermissions(held)[permission] {
+			authService.recordSecurityEvent(c, &userID, securityEventAdminAccessDenied, adminAuditDetails(c, map[string]interface{}{
+				"method": c.Request.Method,
+				"route":  c.FullPath(),
+			}))
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "insufficient_permissions",
+				"error_description": fmt.Sprintf("The %s permission is required", permission),
+				"permission":        permission,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminAuditDetails adds the permission an admin endpoint checked, and the admin roles that
+// were checked against it, to the details of a security event
+func adminAuditDetails(c *gin.Context, details map[string]interface{}) map[string]interface{} {
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	if permission, ok := c.Get(adminPermissionKey); ok {
+		details["permission"] = permission
+	}
+	if roles, ok := c.Get(adminRolesKey); ok {
+		details["admin_roles"] = roles
+	}
+	if service, ok := c.Get(serviceIdentityKey); ok {
+		details["service"] = service
+	}
+	return details
+}
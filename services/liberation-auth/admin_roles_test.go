@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"liberation-serviceauth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type AdminRolesTestSuite struct {
+	suite.Suite
+}
+
+func (suite *AdminRolesTestSuite) TestRolesGrantTheirPermissions() {
+	support := adminPermissions([]string{"user", adminRoleSupport})
+	suite.True(support[permUsersRead])
+	suite.True(support[permUsersResetMFA])
+	suite.False(support[permUsersWrite])
+	suite.False(support[permRolesManage])
+	suite.False(support[permSecurityEventsRead])
+
+	security := adminPermissions([]string{adminRoleSecurity})
+	suite.True(security[permSecurityEventsRead])
+	suite.True(security[permTokensRevoke])
+	suite.False(security[permUsersRead])
+
+	readOnly := adminPermissions([]string{adminRoleReadOnly})
+	for _, permission := range allAdminPermissions {
+		suite.Equal(permission == permUsersRead || permission == permSecurityEventsRead || permission == permTokensRead || permission == permConfigRead,
+			readOnly[permission], permission)
+	}
+
+	for _, role := range []string{adminRoleSuper, adminRoleLegacy} {
+		suite.Len(adminPermissions([]string{role}), len(allAdminPermissions), role)
+	}
+	suite.Empty(adminPermissions([]string{"user", "moderator"}))
+}
+
+func (suite *AdminRolesTestSuite) TestSeveralRolesCombine() {
+	granted := adminPermissions([]string{adminRoleSupport, adminRoleSecurity})
+	suite.True(granted[permUsersResetMFA])
+	suite.True(granted[permTokensRevoke])
+	suite.Equal([]string{adminRoleSecurity, adminRoleSupport}, adminRoles([]string{"user", adminRoleSupport, adminRoleSecurity}))
+	suite.Equal([]string{}, adminRoles([]string{"user"}))
+}
+
+func (suite *AdminRolesTestSuite) TestRoutePermissions() {
+	suite.Equal(permUsersResetMFA, adminPermissionFor(http.MethodPost, "/users/:user_id/mfa/reset"))
+	suite.Equal(permTokensRevoke, adminPermissionFor(http.MethodPost, "/oauth/revocations"))
+	suite.Equal(permSecurityEventsRead, adminPermissionFor(http.MethodGet, "/security-events"))
+	suite.Equal(permRolesManage, adminPermissionFor(http.MethodDelete, "/users/:user_id/roles/:role"))
+
+	// Endpoints not listed fall back to the config permissions
+	suite.Equal(permConfigRead, adminPermissionFor(http.MethodGet, "/oauth/clients"))
+	suite.Equal(permConfigWrite, adminPermissionFor(http.MethodPut, "/oauth/clients/:client_id"))
+	suite.Equal(permConfigWrite, adminPermissionFor(http.MethodPost, "/not-yet-listed"), "unknown writes need superadmin")
+}
+
+func (suite *AdminRolesTestSuite) TestMiddleware() {
+	gin.SetMode(gin.TestMode)
+	public, private, err := ed25519.GenerateKey(nil)
+	suite.Require().NoError(err)
+	authService := &AuthService{
+		services: serviceauth.NewIdentity("liberation-auth", nil, map[string]ed25519.PublicKey{"liberation-ai": public}),
+	}
+	router := gin.New()
+	admin := router.Group("/api/v1/auth/admin")
+	admin.Use(ServiceIdentityMiddleware(authService), AdminPermissionMiddleware(authService, admin.BasePath()))
+	admin.POST("/oauth/revocations", func(c *gin.Context) {
+		c.JSON(http.StatusOK, adminAuditDetails(c, nil))
+	})
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/admin/oauth/revocations", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	suite.Equal(http.StatusUnauthorized, serve("").Code)
+
+	token, err := serviceauth.NewIdentity("liberation-ai", private, nil).Mint("liberation-auth")
+	suite.Require().NoError(err)
+	w := serve(token)
+	suite.Equal(http.StatusOK, w.Code, "trusted services act as superadmins")
+	suite.JSONEq(`{"permission": "tokens:revoke", "service": "liberation-ai"}`, w.Body.String())
+}
+
+func TestAdminRolesTestSuite(t *testing.T) {
+	suite.Run(t, new(AdminRolesTestSuite))
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Entity types admin search covers, in the order equally ranked groups are listed
+const (
+	searchTypeUsers   = "users"
+	searchTypeClients = "clients"
+	searchTypeTokens  = "tokens"
+	searchTypeEvents  = "events"
+)
+
+var adminSearchTypes = []string{searchTypeUsers, searchTypeClients, searchTypeTokens, searchTypeEvents}
+
+// Match ranks, best first
+const (
+	searchRankExact    = 3
+	searchRankPrefix   = 2
+	searchRankContains = 1
+)
+
+// What a query looks like, which decides the fields it is matched against
+const (
+	searchKindID    = "id"
+	searchKindEmail = "email"
+	searchKindIP    = "ip"
+	searchKindText  = "text"
+)
+
+// searchMinLength keeps one-letter queries from scanning every username
+const searchMinLength = 2
+
+// ipPrefixPattern is the start of a dotted IPv4 address, e.g. "203.0.113."
+var ipPrefixPattern = regexp.MustCompile(`^\d{1,3}(\.\d{0,3}){1,3}$`)
+
+// AdminSearchConfig controls who sees personal data in admin search results
+type AdminSearchConfig struct {
+	// PIIRoles may see email and IP addresses in full; other admins get them masked.
+	// Trusted services always see them.
+	PIIRoles []string
+}
+
+// DefaultAdminSearchConfig reads ADMIN_SEARCH_PII_ROLES, a comma-separated list
+func DefaultAdminSearchConfig() AdminSearchConfig {
+	var config AdminSearchConfig
+	for _, role := range strings.Split(getEnv("ADMIN_SEARCH_PII_ROLES", "admin,superadmin,security"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			config.PIIRoles = append(config.PIIRoles, role)
+		}
+	}
+	return config
+}
+
+// adminSearchQuery is a search box entry, classified
+type adminSearchQuery struct {
+	Text string
+	Kind string
+	// ID is set for UUIDs, which may name a user, client, token or event
+	ID *uuid.UUID
+	// IPPrefix is set for partial IPv4 addresses, which match by prefix
+	IPPrefix bool
+}
+
+func parseAdminSearchQuery(q string) adminSearchQuery {
+	query := adminSearchQuery{Text: strings.TrimSpace(q), Kind: searchKindText}
+	switch {
+	case query.Text == "":
+	case strings.Contains(query.Text, "@"):
+		query.Kind = searchKindEmail
+	case net.ParseIP(query.Text) != nil:
+		query.Kind = searchKindIP
+	case ipPrefixPattern.MatchString(query.Text) && strings.Count(query.Text, ".") >= 2:
+		query.Kind = searchKindIP
+		query.IPPrefix = true
+	default:
+		if id, err := uuid.Parse(query.Text); err == nil {
+			query.Kind = searchKindID
+			query.ID = &id
+		}
+	}
+	return query
+}
+
+// likeEscape quotes LIKE wildcards so user input matches literally
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// maskEmail keeps the first letter and the domain, e.g. "j***@example.com"
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	return local[:1] + "***@" + domain
+}
+
+// maskIP keeps the network an address is in: /24 for IPv4 and /48 for IPv6
+func maskIP(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// adminSearchHit is one matching entity
+type adminSearchHit struct {
+	ID   string `json:"id"`
+	Rank int    `json:"rank"`
+	// Matched names the field the query matched
+	Matched string                 `json:"matched"`
+	Summary map[string]interface{} `json:"summary"`
+}
+
+// adminSearchGroup is a page of one entity type's matches
+type adminSearchGroup struct {
+	Type     string           `json:"type"`
+	Results  []adminSearchHit `json:"results"`
+	HasMore  bool             `json:"has_more"`
+	NextPage int              `json:"next_page,omitempty"`
+}
+
+func (g adminSearchGroup) bestRank() int {
+	if len(g.Results) == 0 {
+		return 0
+	}
+	return g.Results[0].Rank
+}
+
+// searchPage is the slice of each group a request asks for
+type searchPage struct {
+	Page  int
+	Limit int
+}
+
+func (p searchPage) offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// group trims a fetched page of limit+1 rows and notes whether there are more
+func (p searchPage) group(searchType string, hits []adminSearchHit) adminSearchGroup {
+	group := adminSearchGroup{Type: searchType, Results: hits}
+	if len(hits) > p.Limit {
+		group.Results = hits[:p.Limit]
+		group.HasMore = true
+		group.NextPage = p.Page + 1
+	}
+	if group.Results == nil {
+		group.Results = []adminSearchHit{}
+	}
+	return group
+}
+
+// adminSearcher finds one entity type, returning up to page.Limit+1 hits best first
+type adminSearcher func(ctx context.Context, q adminSearchQuery, page searchPage, showPII bool) ([]adminSearchHit, error)
+
+func (as *AuthService) adminSearchers() map[string]adminSearcher {
+	return map[string]adminSearcher{
+		searchTypeUsers:   as.searchUsers,
+		searchTypeClients: as.searchClients,
+		searchTypeTokens:  as.searchTokens,
+		searchTypeEvents:  as.searchEvents,
+	}
+}
+
+// searchUsers matches user IDs and usernames, and email addresses exactly. Emails are looked
+// up by blind index too, so they are found whether or not PII encryption is on.
+func (as *AuthService) searchUsers(ctx context.Context, q adminSearchQuery, page searchPage, showPII bool) ([]adminSearchHit, error) {
+	if q.Kind == searchKindIP {
+		return nil, nil
+	}
+	email, text := "", ""
+	switch q.Kind {
+	case searchKindEmail:
+		email = q.Text
+	case searchKindText:
+		text = q.Text
+	}
+	rows, err := as.db.QueryContext(ctx, `
+		SELECT id, username, email, COALESCE(display_name, ''), is_active, created_at, rank FROM (
+			SELECT *, CASE
+				WHEN id = $1 OR $2 <> '' OR lower(username) = lower($4) THEN 3
+				WHEN username ILIKE $5::text || '%' THEN 2
+				ELSE 1 END AS rank
+			FROM users
+			WHERE id = $1
+				OR ($2 <> '' AND (lower(email) = lower($2) OR email_bidx = ANY($3)))
+				OR ($4 <> '' AND username ILIKE '%' || $5::text || '%')
+		) matches
+		ORDER BY rank DESC, created_at DESC
+		LIMIT $6 OFFSET $7`,
+		q.ID, email, pq.Array(as.pii.lookupIndexes(piiUserEmail, email)), text, likeEscape(text), page.Limit+1, page.offset())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matched := map[string]string{searchKindID: "id", searchKindEmail: "email", searchKindText: "username"}[q.Kind]
+	var hits []adminSearchHit
+	for rows.Next() {
+		var id uuid.UUID
+		var username, userEmail, displayName string
+		var isActive bool
+		var createdAt time.Time
+		var rank int
+		if err := rows.Scan(&id, &username, as.pii.scan(piiUserEmail, &userEmail), &displayName, &isActive, &createdAt, &rank); err != nil {
+			return nil, err
+		}
+		if !showPII {
+			userEmail = maskEmail(userEmail)
+		}
+		hits = append(hits, adminSearchHit{ID: id.String(), Rank: rank, Matched: matched, Summary: map[string]interface{}{
+			"username": username, "email": userEmail, "display_name": displayName, "is_active": isActive, "created_at": createdAt,
+		}})
+	}
+	return hits, rows.Err()
+}
+
+// searchClients matches client IDs and names
+func (as *AuthService) searchClients(ctx context.Context, q adminSearchQuery, page searchPage, _ bool) ([]adminSearchHit, error) {
+	if q.Kind != searchKindID && q.Kind != searchKindText {
+		return nil, nil
+	}
+	text := ""
+	if q.Kind == searchKindText {
+		text = q.Text
+	}
+	rows, err := as.db.QueryContext(ctx, `
+		SELECT client_id, client_name, is_first_party, is_active, created_at, rank FROM (
+			SELECT *, CASE
+				WHEN client_id = $1 OR lower(client_name) = lower($2) THEN 3
+				WHEN client_name ILIKE $3::text || '%' THEN 2
+				ELSE 1 END AS rank
+			FROM oauth_clients
+			WHERE client_id = $1 OR ($2 <> '' AND client_name ILIKE '%' || $3::text || '%')
+		) matches
+		ORDER BY rank DESC, created_at DESC
+		LIMIT $4 OFFSET $5`, q.ID, text, likeEscape(text), page.Limit+1, page.offset())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matched := "client_name"
+	if q.Kind == searchKindID {
+		matched = "client_id"
+	}
+	var hits []adminSearchHit
+	for rows.Next() {
+		var clientID uuid.UUID
+		var clientName string
+		var isFirstParty, isActive bool
+		var createdAt time.Time
+		var rank int
+		if err := rows.Scan(&clientID, &clientName, &isFirstParty, &isActive, &createdAt, &rank); err != nil {
+			return nil, err
+		}
+		hits = append(hits, adminSearchHit{ID: clientID.String(), Rank: rank, Matched: matched, Summary: map[string]interface{}{
+			"client_name": clientName, "is_first_party": isFirstParty, "is_active": isActive, "created_at": createdAt,
+		}})
+	}
+	return hits, rows.Err()
+}
+
+// searchTokens matches access and refresh token IDs, a pasted token itself, and the address
+// an access token was issued to. Tokens are only ever matched exactly.
+func (as *AuthService) searchTokens(ctx context.Context, q adminSearchQuery, page searchPage, showPII bool) ([]adminSearchHit, error) {
+	values := []string{}
+	if q.Kind == searchKindText && len(q.Text) >= 16 {
+		values = as.tokenStorage.lookupValues(q.Text)
+	}
+	ip, ipPrefix := "", ""
+	if q.Kind == searchKindIP && q.IPPrefix {
+		ipPrefix = likeEscape(q.Text)
+	} else if q.Kind == searchKindIP {
+		ip = q.Text
+	}
+	if q.ID == nil && len(values) == 0 && ip == "" && ipPrefix == "" {
+		return nil, nil
+	}
+	rows, err := as.db.QueryContext(ctx, `
+		SELECT kind, id, user_id, client_id, is_revoked, expires_at, created_at, ip_address, matched FROM (
+			SELECT 'access' AS kind, id, user_id, client_id, is_revoked, expires_at, created_at,
+				COALESCE(ip_address, '') AS ip_address,
+				CASE WHEN id = $1 THEN 'id' WHEN token = ANY($2) THEN 'token' ELSE 'ip_address' END AS matched
+			FROM oauth_access_tokens
+			WHERE id = $1 OR token = ANY($2)
+				OR ($3 <> '' AND ip_address = $3)
+				OR ($4 <> '' AND ip_address LIKE $4::text || '%')
+			UNION ALL
+			SELECT 'refresh', id, user_id, client_id, is_revoked, expires_at, created_at, '',
+				CASE WHEN id = $1 THEN 'id' ELSE 'token' END
+			FROM oauth_refresh_tokens
+			WHERE id = $1 OR token = ANY($2)
+		) matches
+		ORDER BY created_at DESC
+		LIMIT $5 OFFSET $6`, q.ID, pq.Array(values), ip, ipPrefix, page.Limit+1, page.offset())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []adminSearchHit
+	for rows.Next() {
+		var kind, address, matched string
+		var id, userID, clientID uuid.UUID
+		var isRevoked bool
+		var expiresAt, createdAt time.Time
+		if err := rows.Scan(&kind, &id, &userID, &clientID, &isRevoked, &expiresAt, &createdAt, &address, &matched); err != nil {
+			return nil, err
+		}
+		rank := searchRankExact
+		if q.IPPrefix {
+			rank = searchRankPrefix
+		}
+		if !showPII {
+			address = maskIP(address)
+		}
+		hits = append(hits, adminSearchHit{ID: id.String(), Rank: rank, Matched: matched, Summary: map[string]interface{}{
+			"token_type": kind, "user_id": userID, "client_id": clientID, "is_revoked": isRevoked,
+			"expires_at": expiresAt, "created_at": createdAt, "ip_address": address,
+		}})
+	}
+	return hits, rows.Err()
+}
+
+// searchEvents matches security event IDs, the user an event is about, and its address
+func (as *AuthService) searchEvents(ctx context.Context, q adminSearchQuery, page searchPage, showPII bool) ([]adminSearchHit, error) {
+	if q.Kind != searchKindID && q.Kind != searchKindIP {
+		return nil, nil
+	}
+	ip, ipPrefix := "", ""
+	if q.IPPrefix {
+		ipPrefix = likeEscape(q.Text)
+	} else if q.Kind == searchKindIP {
+		ip = q.Text
+	}
+	rows, err := as.db.QueryContext(ctx, `
+		SELECT id, user_id, event_type, ip_address, created_at,
+			CASE WHEN id = $1 THEN 'id' WHEN user_id = $1 THEN 'user_id' ELSE 'ip_address' END
+		FROM security_events
+		WHERE id = $1 OR user_id = $1
+			OR ($2 <> '' AND ip_address = $2)
+			OR ($3 <> '' AND ip_address LIKE $3::text || '%')
+		ORDER BY (id = $1) IS TRUE DESC, created_at DESC
+		LIMIT $4 OFFSET $5`, q.ID, ip, ipPrefix, page.Limit+1, page.offset())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []adminSearchHit
+	for rows.Next() {
+		var id uuid.UUID
+		var userID *uuid.UUID
+		var eventType, address, matched string
+		var createdAt time.Time
+		if err := rows.Scan(&id, &userID, &eventType, &address, &createdAt, &matched); err != nil {
+			return nil, err
+		}
+		rank := searchRankExact
+		if q.IPPrefix {
+			rank = searchRankPrefix
+		}
+		if !showPII {
+			address = maskIP(address)
+		}
+		hits = append(hits, adminSearchHit{ID: id.String(), Rank: rank, Matched: matched, Summary: map[string]interface{}{
+			"user_id": userID, "event_type": eventType, "ip_address": address, "created_at": createdAt,
+		}})
+	}
+	return hits, rows.Err()
+}
+
+// canSeeSearchPII reports whether the caller may see email and IP addresses in full
+func (as *AuthService) canSeeSearchPII(c *gin.Context) bool {
+	if isServiceCall(c) {
+		return true
+	}
+	value, _ := c.Get("user_id")
+	userID, err := uuid.Parse(fmt.Sprint(value))
+	if err != nil {
+		return false
+	}
+	roles, err := as.getUserRoles(c.Request.Context(), userID)
+	if err != nil {
+		return false
+	}
+	for _, role := range as.adminSearch.PIIRoles {
+		if contains(roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchTypes reads ?type, a comma-separated subset of the entity types, defaulting to all
+func searchTypes(value string) ([]string, error) {
+	if value == "" {
+		return adminSearchTypes, nil
+	}
+	var types []string
+	for _, t := range strings.Split(value, ",") {
+		t = strings.TrimSpace(t)
+		if !contains(adminSearchTypes, t) {
+			return nil, fmt.Errorf("type must be one of %s", strings.Join(adminSearchTypes, ", "))
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// AdminSearch finds users, clients, tokens and security events from one query box. The
+// query is classified as an ID, email address, IP address (or the start of one) or text,
+// and each entity type is matched on the fields that kind of query can name. Results are
+// grouped by type, groups with the best matches first, and paged per group with
+// ?page and ?limit.
+func (as *AuthService) AdminSearch(c *gin.Context) {
+	q := parseAdminSearchQuery(c.Query("q"))
+	if len(q.Text) < searchMinLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("q must be at least %d characters", searchMinLength)})
+		return
+	}
+	types, err := searchTypes(c.Query("type"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page := searchPage{Page: 1, Limit: 20}
+	if n, err := strconv.Atoi(c.Query("page")); err == nil && n > 0 {
+		page.Page = n
+	}
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 {
+		page.Limit = n
+	}
+	if page.Limit > 100 {
+		page.Limit = 100
+	}
+
+	showPII := as.canSeeSearchPII(c)
+	searchers := as.adminSearchers()
+	groups := make([]adminSearchGroup, 0, len(types))
+	for _, searchType := range types {
+		hits, err := searchers[searchType](c.Request.Context(), q, page, showPII)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search " + searchType})
+			return
+		}
+		groups = append(groups, page.group(searchType, hits))
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].bestRank() > groups[j].bestRank()
+	})
+
+	// The query itself may be an email or IP address, so only its kind is recorded
+	if value, ok := c.Get("user_id"); ok {
+		if adminID, err := uuid.Parse(fmt.Sprint(value)); err == nil {
+			as.recordSecurityEvent(c, &adminID, securityEventAdminSearch, adminAuditDetails(c, map[string]interface{}{"kind": q.Kind, "redacted": !showPII}))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":    q.Text,
+		"kind":     q.Kind,
+		"redacted": !showPII,
+		"page":     page.Page,
+		"limit":    page.Limit,
+		"groups":   groups,
+	})
+}
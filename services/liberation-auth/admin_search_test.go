@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type AdminSearchTestSuite struct {
+	suite.Suite
+}
+
+func (suite *AdminSearchTestSuite) TestParseQuery() {
+	q := parseAdminSearchQuery(" 9b2f8c1e-4a8d-4c3e-9f0a-6d1b2c3d4e5f ")
+	suite.Equal(searchKindID, q.Kind)
+	suite.Require().NotNil(q.ID)
+	suite.Equal("9b2f8c1e-4a8d-4c3e-9f0a-6d1b2c3d4e5f", q.ID.String())
+
+	suite.Equal(searchKindEmail, parseAdminSearchQuery("Quill@Example.com").Kind)
+	suite.Equal(searchKindText, parseAdminSearchQuery("quill_writes").Kind)
+	suite.Equal(searchKindText, parseAdminSearchQuery("2024").Kind, "a bare number is a username")
+
+	q = parseAdminSearchQuery("203.0.113.7")
+	suite.Equal(searchKindIP, q.Kind)
+	suite.False(q.IPPrefix)
+	q = parseAdminSearchQuery("2001:db8::1")
+	suite.Equal(searchKindIP, q.Kind)
+	suite.False(q.IPPrefix)
+	q = parseAdminSearchQuery("203.0.113.")
+	suite.Equal(searchKindIP, q.Kind)
+	suite.True(q.IPPrefix)
+	suite.Equal(searchKindText, parseAdminSearchQuery("1.5").Kind, "two numbers are too vague to be an address")
+}
+
+func (suite *AdminSearchTestSuite) TestLikeEscape() {
+	suite.Equal(`quill\_writes`, likeEscape("quill_writes"))
+	suite.Equal(`100\%`, likeEscape("100%"))
+	suite.Equal(`a\\b`, likeEscape(`a\b`))
+}
+
+func (suite *AdminSearchTestSuite) TestRedaction() {
+	suite.Equal("q***@example.com", maskEmail("quill@example.com"))
+	suite.Equal("***", maskEmail("not-an-address"))
+	suite.Equal("203.0.113.0/24", maskIP("203.0.113.7"))
+	suite.Equal("2001:db8:abcd::/48", maskIP("2001:db8:abcd:12::1"))
+	suite.Empty(maskIP(""))
+}
+
+func (suite *AdminSearchTestSuite) TestSearchTypes() {
+	types, err := searchTypes("")
+	suite.Require().NoError(err)
+	suite.Equal(adminSearchTypes, types)
+
+	types, err = searchTypes("tokens, events")
+	suite.Require().NoError(err)
+	suite.Equal([]string{searchTypeTokens, searchTypeEvents}, types)
+
+	_, err = searchTypes("users,sessions")
+	suite.Error(err)
+}
+
+func (suite *AdminSearchTestSuite) TestPaging() {
+	page := searchPage{Page: 2, Limit: 2}
+	suite.Equal(2, page.offset())
+
+	group := page.group(searchTypeUsers, []adminSearchHit{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+	suite.Len(group.Results, 2)
+	suite.True(group.HasMore)
+	suite.Equal(3, group.NextPage)
+
+	group = page.group(searchTypeUsers, nil)
+	suite.NotNil(group.Results, "empty groups list no results rather than null")
+	suite.False(group.HasMore)
+}
+
+func (suite *AdminSearchTestSuite) TestConfig() {
+	suite.Equal([]string{"admin", "superadmin", "security"}, DefaultAdminSearchConfig().PIIRoles)
+	suite.T().Setenv("ADMIN_SEARCH_PII_ROLES", " trust-safety ,, admin")
+	suite.Equal([]string{"trust-safety", "admin"}, DefaultAdminSearchConfig().PIIRoles)
+}
+
+func (suite *AdminSearchTestSuite) TestRejectsVagueQueries() {
+	gin.SetMode(gin.TestMode)
+	as := &AuthService{}
+	r := gin.New()
+	r.GET("/admin/search", as.AdminSearch)
+
+	for _, target := range []string{"/admin/search", "/admin/search?q=+q+", "/admin/search?q=quill&type=sessions"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		suite.Equal(http.StatusBadRequest, w.Code, target)
+	}
+}
+
+func TestAdminSearchTestSuite(t *testing.T) {
+	suite.Run(t, new(AdminSearchTestSuite))
+}
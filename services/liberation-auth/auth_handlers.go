@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -8,7 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 // Basic auth handlers for testing OAuth2/OIDC functionality
@@ -20,39 +23,40 @@ func (as *AuthService) Register(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
 		return
 	}
-
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	username, usernameErr := as.checkUsername(c.Request.Context(), req.Username)
+	if usernameErr != nil {
+		as.rejectUsername(c, usernameErr)
 		return
 	}
+	req.Username = username
 
-	// Create user
-	userID := uuid.New()
-	now := time.Now()
-
-	// Insert user into database
-	query := `
-		INSERT INTO users (id, username, email, password_hash, display_name, is_active, is_verified, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, true, false, $6, $7)`
+	// Invite codes arrive with the signup link rather than in the shared request model
+	inviteCode := c.Query("invite")
+	if inviteCode == "" {
+		inviteCode = c.GetHeader("X-Invite-Code")
+	}
 
-	_, err = as.db.Exec(query, userID, req.Username, req.Email, string(hashedPassword), req.DisplayName, now, now)
-	if err != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "user_exists"})
+	// Signups like known spam solve a CAPTCHA or wait for review
+	held, ok := as.spam.screenRegistration(c, signupText(req.Username, req.DisplayName, req.Email), inviteCode != "")
+	if !ok {
 		return
 	}
 
-	// Generate tokens
-	accessToken, err := as.jwt.GenerateToken(userID, "nuclear-ao3", []string{"user"}, 30*24*time.Hour) // 30 days
+	// Hash password
+	hashedPassword, err := as.passwords.generate(c.Request.Context(), req.Password)
+	if err == errPasswordHashOverloaded {
+		rejectOverloaded(c)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "token_generation_failed"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
 		return
 	}
 
-	// Return user and tokens
+	// Create user
+	now := time.Now()
 	user := &models.User{
-		ID:          userID,
+		ID:          uuid.New(),
 		Username:    req.Username,
 		Email:       req.Email,
 		DisplayName: req.DisplayName,
@@ -62,6 +66,26 @@ func (as *AuthService) Register(c *gin.Context) {
 		UpdatedAt:   now,
 	}
 
+	if _, err := as.createUserWithInvite(c.Request.Context(), user, string(hashedPassword), inviteCode, registrationStageComplete, held); err != nil {
+		registrationErrorResponse(c, err)
+		return
+	}
+	if held != nil {
+		as.recordSecurityEvent(c, &user.ID, securityEventRegistered, map[string]interface{}{"held_for_review": true, "spam_score": held.Score})
+		respondPendingReview(c, user)
+		return
+	}
+	as.recordSecurityEvent(c, &user.ID, securityEventRegistered, nil)
+
+	// Generate tokens
+	accessToken, err := as.issueUserToken(c.Request.Context(), user.ID, 30*24*time.Hour, nil) // 30 days
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token_generation_failed"})
+		return
+	}
+
+	// Return user and tokens
+
 	c.JSON(http.StatusCreated, models.AuthResponse{
 		User:         user,
 		AccessToken:  accessToken,
@@ -84,25 +108,46 @@ func (as *AuthService) Login(c *gin.Context) {
 	var passwordHash string
 	query := `
 		SELECT id, username, email, password_hash, display_name, is_active, is_verified, created_at, updated_at
-		FROM users WHERE email = $1`
+		FROM users WHERE email = $1 OR email_bidx = ANY($2)`
 
-	err := as.db.QueryRow(query, req.Email).Scan(
-		&user.ID, &user.Username, &user.Email, &passwordHash, &user.DisplayName,
+	err := as.db.QueryRowContext(c.Request.Context(), query, req.Email, pq.Array(as.pii.lookupIndexes(piiUserEmail, req.Email))).Scan(
+		&user.ID, &user.Username, as.pii.scan(piiUserEmail, &user.Email), &passwordHash, &user.DisplayName,
 		&user.IsActive, &user.IsVerified, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
+		as.recordSecurityEvent(c, nil, securityEventLoginFailed, map[string]interface{}{"reason": "unknown_account"})
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_credentials"})
 		return
 	}
 
 	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+	if err := as.passwords.compare(c.Request.Context(), passwordHash, req.Password); err != nil {
+		if err == errPasswordHashOverloaded {
+			rejectOverloaded(c)
+			return
+		}
+		as.recordSecurityEvent(c, &user.ID, securityEventLoginFailed, map[string]interface{}{"reason": "bad_password"})
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_credentials"})
 		return
 	}
 
+	// Locked accounts (e.g. by the inactivity lifecycle) need an admin to reactivate them
+	if !user.IsActive {
+		if as.spam.pendingReview(c.Request.Context(), user.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "account_pending_review", "error_description": "This account is waiting for a moderator to review it"})
+			return
+		}
+		as.recordSecurityEvent(c, &user.ID, securityEventLoginLocked, nil)
+		c.JSON(http.StatusForbidden, gin.H{"error": "account_locked", "error_description": "This account is locked; contact an administrator to reactivate it"})
+		return
+	}
+
+	// auth_time, max_age and prompt=login are all measured from the last login
+	as.db.ExecContext(c.Request.Context(), `UPDATE users SET last_login_at = NOW() WHERE id = $1`, user.ID)
+	as.recordSecurityEvent(c, &user.ID, securityEventLoginSucceeded, nil)
+
 	// Generate access token
-	accessToken, err := as.jwt.GenerateToken(user.ID, "nuclear-ao3", []string{"user"}, 30*24*time.Hour) // 30 days
+	accessToken, err := as.issueUserToken(c.Request.Context(), user.ID, 30*24*time.Hour, nil) // 30 days
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "token_generation_failed"})
 		return
@@ -150,7 +195,7 @@ func (as *AuthService) RefreshToken(c *gin.Context) {
 	}
 
 	// Generate new access token (shorter TTL since it can be refreshed)
-	accessToken, err := as.jwt.GenerateToken(userID, "nuclear-ao3", []string{"user"}, 15*time.Minute)
+	accessToken, err := as.issueUserToken(c.Request.Context(), userID, 15*time.Minute, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "token_generation_failed"})
 		return
@@ -168,7 +213,52 @@ func (as *AuthService) RequestPasswordReset(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "password reset requested"})
 }
 
+// ConfirmPasswordReset sets a new password from a reset link, such as the one imported users
+// are sent. Like any password change, it signs the user out everywhere.
 func (as *AuthService) ConfirmPasswordReset(c *gin.Context) {
+	var req models.ResetPasswordConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" || len(req.NewPassword) < 8 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	if req.NewPassword != req.ConfirmPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password_mismatch"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	// Hash before spending the token, so a request shed under load can be retried
+	hashedPassword, err := as.passwords.generate(ctx, req.NewPassword)
+	if err == errPasswordHashOverloaded {
+		rejectOverloaded(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	var userID uuid.UUID
+	err = as.db.QueryRowContext(ctx, `
+		DELETE FROM password_reset_tokens WHERE token_hash = $1 AND expires_at > NOW()
+		RETURNING user_id`, hashStoredToken(req.Token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_token", "error_description": "The reset link is invalid or has expired"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	epoch, err := as.setPassword(ctx, userID, string(hashedPassword))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	as.signedOutEverywhere(userID, epoch)
+	as.recordSecurityEvent(c, &userID, securityEventPasswordReset, map[string]interface{}{"method": "email_link"})
+
 	c.JSON(http.StatusOK, gin.H{"message": "password reset confirmed"})
 }
 
@@ -193,8 +283,65 @@ func (as *AuthService) UpdateProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "profile updated"})
 }
 
+// ChangePassword sets a new password after checking the current one. Like a reset, it
+// signs the user out everywhere; the caller gets a fresh token so this device stays signed in.
 func (as *AuthService) ChangePassword(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "password changed"})
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.NewPassword) < 8 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	if req.NewPassword != req.ConfirmPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password_mismatch"})
+		return
+	}
+	userID := c.MustGet("user_id").(uuid.UUID)
+	ctx := c.Request.Context()
+
+	var passwordHash string
+	if err := as.db.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&passwordHash); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_credentials"})
+		return
+	}
+	if err := as.passwords.compare(ctx, passwordHash, req.CurrentPassword); err != nil {
+		if err == errPasswordHashOverloaded {
+			rejectOverloaded(c)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_current_password"})
+		return
+	}
+
+	hashedPassword, err := as.passwords.generate(ctx, req.NewPassword)
+	if err == errPasswordHashOverloaded {
+		rejectOverloaded(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	epoch, err := as.setPassword(ctx, userID, string(hashedPassword))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	as.signedOutEverywhere(userID, epoch)
+	as.recordSecurityEvent(c, &userID, securityEventPasswordChanged, nil)
+
+	expiresIn := 30 * 24 * time.Hour
+	accessToken, err := as.issueUserToken(ctx, userID, expiresIn, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token_generation_failed"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "password changed",
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_at":   time.Now().Add(expiresIn).Unix(),
+	})
 }
 
 func (as *AuthService) GetSessions(c *gin.Context) {
@@ -202,11 +349,21 @@ func (as *AuthService) GetSessions(c *gin.Context) {
 }
 
 func (as *AuthService) RevokeSession(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
-}
+	sessionID := c.Param("session_id")
+	key := fmt.Sprintf("session:%s", sessionID)
+
+	// Only the session's owner may revoke it
+	if owner, err := as.redis.Get(context.Background(), key).Result(); err == nil {
+		if userID, _ := c.Get("user_id"); owner == fmt.Sprint(userID) {
+			as.redis.Del(context.Background(), key)
+			as.publishRevocation(RevocationEvent{Type: revocationSession, ID: sessionID, UserID: owner})
+			if id, err := uuid.Parse(owner); err == nil {
+				as.recordSecurityEvent(c, &id, securityEventSessionRevoked, nil)
+			}
+		}
+	}
 
-func (as *AuthService) GetSecurityEvents(c *gin.Context) {
-	c.JSON(http.StatusOK, []models.SecurityEvent{})
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
 }
 
 func (as *AuthService) ListUsers(c *gin.Context) {
@@ -228,11 +385,3 @@ func (as *AuthService) GrantRole(c *gin.Context) {
 func (as *AuthService) RevokeRole(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "role revoked"})
 }
-
-func (as *AuthService) GetAllSecurityEvents(c *gin.Context) {
-	c.JSON(http.StatusOK, []models.SecurityEvent{})
-}
-
-func (as *AuthService) GetAuthMetrics(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"metrics": "data"})
-}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -10,7 +11,7 @@ import (
 )
 
 // getUserByID retrieves a user by their ID
-func (as *AuthService) getUserByID(userID uuid.UUID) (*models.User, error) {
+func (as *AuthService) getUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
 	var user models.User
 	query := `
 		SELECT id, username, email, display_name, bio, location, website, 
@@ -18,9 +19,9 @@ func (as *AuthService) getUserByID(userID uuid.UUID) (*models.User, error) {
 		FROM users 
 		WHERE id = $1`
 
-	err := as.db.QueryRow(query, userID).Scan(
-		&user.ID, &user.Username, &user.Email, &user.DisplayName,
-		&user.Bio, &user.Location, &user.Website,
+	err := as.db.QueryRowContext(ctx, query, userID).Scan(
+		&user.ID, &user.Username, as.pii.scan(piiUserEmail, &user.Email), &user.DisplayName,
+		&user.Bio, as.pii.scan(piiUserLocation, &user.Location), &user.Website,
 		&user.IsActive, &user.IsVerified, &user.LastLoginAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// authRequestTTL is how long a user has to log in before the request must be restarted
+	authRequestTTL = 10 * time.Minute
+	// authRequestGrace keeps expired and consumed requests around long enough to explain failures
+	authRequestGrace = 30 * time.Minute
+
+	authRequestBindingCookie = "auth_req_binding"
+	authRequestPendingKey    = "auth_req:pending"
+)
+
+var (
+	errAuthRequestNotFound = errors.New("authorization request not found")
+	errAuthRequestExpired  = errors.New("authorization request expired")
+	errAuthRequestConsumed = errors.New("authorization request already used")
+	errAuthRequestMismatch = errors.New("authorization request belongs to another session or client")
+)
+
+var authRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_authorization_requests_total",
+	Help: "Authorization requests parked for login, by outcome (created, consumed, replayed, expired, mismatched, abandoned).",
+}, []string{"outcome"})
+
+var authRequestCompletionSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "liberation_auth_authorization_request_completion_seconds",
+	Help:    "Time between parking an authorization request and resuming it after login.",
+	Buckets: []float64{5, 15, 30, 60, 120, 300, 600},
+})
+
+// lastAuthRequestSweep rate-limits abandoned request sweeps to one per minute per process
+var lastAuthRequestSweep atomic.Int64
+
+// storedAuthorizationRequest is the envelope kept in Redis while the user logs in
+type storedAuthorizationRequest struct {
+	Request     models.AuthorizeRequest `json:"request"`
+	Prompt      authorizePrompt         `json:"prompt"`
+	ClientID    string                  `json:"client_id"`
+	BindingHash string                  `json:"binding_hash"`
+	CreatedAt   time.Time               `json:"created_at"`
+	ExpiresAt   time.Time               `json:"expires_at"`
+}
+
+// Authorization request handling
+
+// storeAuthorizationRequest parks an authorization request until the user has logged in.
+// The request is bound to the browser that started it through an HttpOnly cookie.
+func (as *AuthService) storeAuthorizationRequest(c *gin.Context, req models.AuthorizeRequest, prompt authorizePrompt) (string, time.Time, error) {
+	ctx := c.Request.Context()
+	as.sweepAbandonedAuthorizationRequests(ctx)
+
+	binding, err := c.Cookie(authRequestBindingCookie)
+	if err != nil || binding == "" {
+		if binding, err = generateAuthRequestBinding(); err != nil {
+			return "", time.Time{}, err
+		}
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(authRequestBindingCookie, binding, int((authRequestTTL + authRequestGrace).Seconds()), "/auth", "", c.Request.TLS != nil, true)
+
+	now := time.Now()
+	stored := storedAuthorizationRequest{
+		Request:     req,
+		Prompt:      prompt,
+		ClientID:    req.ClientID,
+		BindingHash: hashAuthRequestBinding(binding),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(authRequestTTL),
+	}
+	storedJSON, err := json.Marshal(stored)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	requestID := uuid.New().String()
+	pipe := as.redis.TxPipeline()
+	pipe.Set(ctx, fmt.Sprintf("auth_req:%s", requestID), storedJSON, authRequestTTL+authRequestGrace)
+	pipe.ZAdd(ctx, authRequestPendingKey, redis.Z{Score: float64(stored.ExpiresAt.Unix()), Member: requestID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store authorization request: %w", err)
+	}
+
+	authRequestsTotal.WithLabelValues("created").Inc()
+	return requestID, stored.ExpiresAt, nil
+}
+
+// peekAuthorizationRequest reads a parked request without consuming it
+func (as *AuthService) peekAuthorizationRequest(ctx context.Context, requestID string) (*storedAuthorizationRequest, error) {
+	storedJSON, err := as.redis.Get(ctx, fmt.Sprintf("auth_req:%s", requestID)).Result()
+	if err == redis.Nil {
+		if used, _ := as.redis.Exists(ctx, fmt.Sprintf("auth_req_used:%s", requestID)).Result(); used > 0 {
+			return nil, errAuthRequestConsumed
+		}
+		return nil, errAuthRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored storedAuthorizationRequest
+	if err := json.Unmarshal([]byte(storedJSON), &stored); err != nil {
+		return nil, err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return &stored, errAuthRequestExpired
+	}
+	return &stored, nil
+}
+
+// consumeAuthorizationRequest atomically takes a parked request so it can be resumed exactly once.
+// The caller's browser binding and, when given, client_id must match the stored request. They
+// are checked before the request is spent, so a request presented by another session or client
+// stays usable by the browser that started it.
+func (as *AuthService) consumeAuthorizationRequest(c *gin.Context, requestID, clientID string, userID uuid.UUID) (*storedAuthorizationRequest, error) {
+	ctx := c.Request.Context()
+	key := fmt.Sprintf("auth_req:%s", requestID)
+	usedKey := fmt.Sprintf("auth_req_used:%s", requestID)
+	binding, _ := c.Cookie(authRequestBindingCookie)
+
+	var stored storedAuthorizationRequest
+	err := as.redis.Watch(ctx, func(tx *redis.Tx) error {
+		storedJSON, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(storedJSON), &stored); err != nil {
+			return err
+		}
+		if time.Now().After(stored.ExpiresAt) {
+			return errAuthRequestExpired
+		}
+		bindingMatches := binding != "" &&
+			subtle.ConstantTimeCompare([]byte(hashAuthRequestBinding(binding)), []byte(stored.BindingHash)) == 1
+		if !bindingMatches || stored.Request.ClientID != stored.ClientID || (clientID != "" && clientID != stored.ClientID) {
+			return errAuthRequestMismatch
+		}
+
+		// Remember the request was spent so replays can be told apart from unknown requests
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.Set(ctx, usedKey, userID.String(), authRequestGrace)
+			pipe.ZRem(ctx, authRequestPendingKey, requestID)
+			return nil
+		})
+		return err
+	}, key)
+
+	switch {
+	case errors.Is(err, redis.Nil), errors.Is(err, redis.TxFailedErr):
+		// Gone, or taken by a concurrent resume between our read and our delete
+		if used, _ := as.redis.Exists(ctx, usedKey).Result(); used > 0 {
+			authRequestsTotal.WithLabelValues("replayed").Inc()
+			log.Printf("Replayed authorization request %s rejected for user %s", requestID, userID)
+			return nil, errAuthRequestConsumed
+		}
+		return nil, errAuthRequestNotFound
+	case errors.Is(err, errAuthRequestExpired):
+		authRequestsTotal.WithLabelValues("expired").Inc()
+		return nil, err
+	case errors.Is(err, errAuthRequestMismatch):
+		authRequestsTotal.WithLabelValues("mismatched").Inc()
+		log.Printf("Authorization request %s presented by a different session or client for user %s", requestID, userID)
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+
+	authRequestsTotal.WithLabelValues("consumed").Inc()
+	authRequestCompletionSeconds.Observe(time.Since(stored.CreatedAt).Seconds())
+	return &stored, nil
+}
+
+// sweepAbandonedAuthorizationRequests counts parked requests that expired without ever being resumed.
+// ZREM decides ownership, so each request is counted once even with several replicas sweeping.
+func (as *AuthService) sweepAbandonedAuthorizationRequests(ctx context.Context) {
+	now := time.Now()
+	last := lastAuthRequestSweep.Load()
+	if now.Unix()-last < 60 || !lastAuthRequestSweep.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+
+	expired, err := as.redis.ZRangeByScore(ctx, authRequestPendingKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("%d", now.Unix()),
+	}).Result()
+	if err != nil {
+		return
+	}
+
+	for _, requestID := range expired {
+		if removed, err := as.redis.ZRem(ctx, authRequestPendingKey, requestID).Result(); err == nil && removed > 0 {
+			authRequestsTotal.WithLabelValues("abandoned").Inc()
+		}
+	}
+}
+
+// Authorization request endpoints
+
+// GetAuthorizationRequest lets the login page show which client is asking and how long is left
+func (as *AuthService) GetAuthorizationRequest(c *gin.Context) {
+	requestID := c.Param("request_id")
+
+	stored, err := as.peekAuthorizationRequest(c.Request.Context(), requestID)
+	switch {
+	case errors.Is(err, errAuthRequestExpired):
+		c.JSON(http.StatusGone, gin.H{
+			"error":             "authorization_request_expired",
+			"error_description": "The sign-in request expired, please return to the application and start again",
+			"expired_at":        stored.ExpiresAt.Unix(),
+			"cancel_url":        authRequestCancelURL(stored.Request),
+		})
+		return
+	case errors.Is(err, errAuthRequestConsumed):
+		c.JSON(http.StatusGone, gin.H{
+			"error":             "authorization_request_used",
+			"error_description": "The sign-in request has already been completed",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":             "invalid_request",
+			"error_description": "Unknown authorization request",
+		})
+		return
+	}
+
+	response := gin.H{
+		"auth_request": requestID,
+		"client_id":    stored.ClientID,
+		"scope":        stored.Request.Scope,
+		"expires_at":   stored.ExpiresAt.Unix(),
+		"expires_in":   int(time.Until(stored.ExpiresAt).Seconds()),
+		"resume_url":   "/auth/authorize/resume?auth_request=" + url.QueryEscape(requestID),
+		"cancel_url":   authRequestCancelURL(stored.Request),
+	}
+	if client, err := as.getClientByID(c.Request.Context(), stored.ClientID); err == nil {
+		response["client_name"] = client.Name
+		response["logo_url"] = client.LogoURL
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResumeAuthorization continues a parked authorization request once the user has logged in
+func (as *AuthService) ResumeAuthorization(c *gin.Context) {
+	requestID := c.Query("auth_request")
+	if requestID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "auth_request is required",
+		})
+		return
+	}
+
+	// Check authentication before consuming so an unauthenticated hit cannot burn the request
+	userID := as.getAuthenticatedUser(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":             "login_required",
+			"error_description": "Log in before resuming the authorization request",
+			"login_url":         "/login?auth_request=" + url.QueryEscape(requestID),
+		})
+		return
+	}
+
+	stored, err := as.consumeAuthorizationRequest(c, requestID, c.Query("client_id"), *userID)
+	switch {
+	case errors.Is(err, errAuthRequestExpired):
+		c.JSON(http.StatusGone, gin.H{
+			"error":             "authorization_request_expired",
+			"error_description": "The sign-in request expired, please return to the application and start again",
+		})
+		return
+	case errors.Is(err, errAuthRequestConsumed):
+		c.JSON(http.StatusGone, gin.H{
+			"error":             "authorization_request_used",
+			"error_description": "The sign-in request has already been completed",
+		})
+		return
+	case errors.Is(err, errAuthRequestMismatch):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "access_denied",
+			"error_description": "The sign-in request was started in a different browser session",
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "Unknown authorization request",
+		})
+		return
+	}
+
+	// Re-run the full validation: the client may have been disabled while the user was logging in
+	as.authorize(c, stored.Request, stored.Prompt.resumed(stored.CreatedAt))
+}
+
+func authRequestCancelURL(req models.AuthorizeRequest) string {
+	cancelURL := req.RedirectURI + "?error=access_denied&error_description=" + url.QueryEscape("Authorization request expired")
+	if req.State != "" {
+		cancelURL += "&state=" + url.QueryEscape(req.State)
+	}
+	return cancelURL
+}
+
+func generateAuthRequestBinding() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate request binding: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashAuthRequestBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuthorizationRequestTestSuite struct {
+	suite.Suite
+	redis *redis.Client
+	as    *AuthService
+}
+
+func (suite *AuthorizationRequestTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	server := miniredis.RunT(suite.T())
+	suite.redis = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	suite.as = &AuthService{redis: suite.redis}
+}
+
+func (suite *AuthorizationRequestTestSuite) TearDownTest() {
+	suite.redis.Close()
+}
+
+// browser returns a request context carrying the binding cookie, if any
+func (suite *AuthorizationRequestTestSuite) browser(binding string) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/auth/authorize/resume", nil)
+	if binding != "" {
+		c.Request.AddCookie(&http.Cookie{Name: authRequestBindingCookie, Value: binding})
+	}
+	return c, recorder
+}
+
+// park stores a request for client-1 and returns its ID and the binding set on the browser
+func (suite *AuthorizationRequestTestSuite) park() (string, string) {
+	c, recorder := suite.browser("")
+	requestID, _, err := suite.as.storeAuthorizationRequest(c, models.AuthorizeRequest{ClientID: "client-1", RedirectURI: "https://app.example/callback", State: "xyz"}, authorizePrompt{})
+	suite.Require().NoError(err)
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == authRequestBindingCookie {
+			return requestID, cookie.Value
+		}
+	}
+	suite.FailNow("no binding cookie was set")
+	return "", ""
+}
+
+func (suite *AuthorizationRequestTestSuite) consume(requestID, binding, clientID string) (*storedAuthorizationRequest, error) {
+	c, _ := suite.browser(binding)
+	return suite.as.consumeAuthorizationRequest(c, requestID, clientID, uuid.New())
+}
+
+func (suite *AuthorizationRequestTestSuite) TestRequestIsConsumedOnce() {
+	requestID, binding := suite.park()
+
+	stored, err := suite.consume(requestID, binding, "client-1")
+	suite.Require().NoError(err)
+	suite.Equal("xyz", stored.Request.State)
+
+	_, err = suite.consume(requestID, binding, "client-1")
+	suite.ErrorIs(err, errAuthRequestConsumed)
+	_, err = suite.as.peekAuthorizationRequest(context.Background(), requestID)
+	suite.ErrorIs(err, errAuthRequestConsumed)
+	suite.Zero(suite.redis.ZScore(context.Background(), authRequestPendingKey, requestID).Val())
+}
+
+func (suite *AuthorizationRequestTestSuite) TestConcurrentResumesConsumeOnce() {
+	requestID, binding := suite.park()
+
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := suite.consume(requestID, binding, "")
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	consumed := 0
+	for err := range results {
+		if err == nil {
+			consumed++
+			continue
+		}
+		suite.ErrorIs(err, errAuthRequestConsumed)
+	}
+	suite.Equal(1, consumed)
+}
+
+func (suite *AuthorizationRequestTestSuite) TestExpiredRequestIsRefused() {
+	requestID, binding := suite.park()
+	key := fmt.Sprintf("auth_req:%s", requestID)
+	var stored storedAuthorizationRequest
+	suite.Require().NoError(json.Unmarshal([]byte(suite.redis.Get(context.Background(), key).Val()), &stored))
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	expired, _ := json.Marshal(stored)
+	suite.Require().NoError(suite.redis.Set(context.Background(), key, expired, authRequestGrace).Err())
+
+	_, err := suite.consume(requestID, binding, "client-1")
+	suite.ErrorIs(err, errAuthRequestExpired)
+	peeked, err := suite.as.peekAuthorizationRequest(context.Background(), requestID)
+	suite.ErrorIs(err, errAuthRequestExpired)
+	suite.Equal("client-1", peeked.ClientID, "the login page still explains where to go back to")
+}
+
+func (suite *AuthorizationRequestTestSuite) TestMismatchLeavesRequestUsable() {
+	requestID, binding := suite.park()
+	_, otherBinding := suite.park()
+
+	for _, tc := range []struct {
+		name     string
+		binding  string
+		clientID string
+	}{
+		{"no binding cookie", "", "client-1"},
+		{"another browser's binding", otherBinding, "client-1"},
+		{"another client", binding, "client-2"},
+	} {
+		_, err := suite.consume(requestID, tc.binding, tc.clientID)
+		suite.ErrorIs(err, errAuthRequestMismatch, tc.name)
+	}
+
+	// None of the attempts above spent the request
+	stored, err := suite.consume(requestID, binding, "client-1")
+	suite.Require().NoError(err)
+	suite.Equal("client-1", stored.ClientID)
+}
+
+func (suite *AuthorizationRequestTestSuite) TestUnknownRequest() {
+	_, err := suite.consume(uuid.New().String(), "binding", "")
+	suite.ErrorIs(err, errAuthRequestNotFound)
+}
+
+func TestAuthorizationRequestTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthorizationRequestTestSuite))
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"liberation-dryrun"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Batch revocation job states
+const (
+	revocationJobRunning   = "running"
+	revocationJobCompleted = "completed"
+	revocationJobFailed    = "failed"
+)
+
+const (
+	defaultRevocationBatchSize = 500
+	maxRevocationBatchSize     = 5000
+)
+
+var batchRevokedTokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_batch_revoked_tokens_total",
+	Help: "Tokens revoked by admin batch revocation jobs, by kind (access, refresh).",
+}, []string{"kind"})
+
+// revocationCriteria selects the tokens a batch revocation applies to. Every set field must match.
+type revocationCriteria struct {
+	UserID      *uuid.UUID `json:"user_id,omitempty"`
+	ClientID    *uuid.UUID `json:"client_id,omitempty"`
+	Scope       string     `json:"scope,omitempty"`
+	IssuedAfter *time.Time `json:"issued_after,omitempty"`
+	// IssuedBefore defaults to the job start so tokens issued during the incident
+	// response (e.g. after a password reset) are left alone
+	IssuedBefore *time.Time `json:"issued_before,omitempty"`
+}
+
+// validate rejects criteria that would revoke every token in the system
+func (rc revocationCriteria) validate() error {
+	if rc.UserID == nil && rc.ClientID == nil && rc.Scope == "" && rc.IssuedAfter == nil {
+		return fmt.Errorf("at least one of user_id, client_id, scope or issued_after is required")
+	}
+	if rc.IssuedAfter != nil && rc.IssuedBefore != nil && !rc.IssuedAfter.Before(*rc.IssuedBefore) {
+		return fmt.Errorf("issued_after must be before issued_before")
+	}
+	return nil
+}
+
+// where builds the SQL condition for the criteria; both token tables share these columns
+func (rc revocationCriteria) where() (string, []interface{}) {
+	conditions := []string{"is_revoked = false"}
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if rc.UserID != nil {
+		add("user_id = $%d", *rc.UserID)
+	}
+	if rc.ClientID != nil {
+		add("client_id = $%d", *rc.ClientID)
+	}
+	if rc.Scope != "" {
+		add("$%d = ANY(scopes)", rc.Scope)
+	}
+	if rc.IssuedAfter != nil {
+		add("created_at >= $%d", *rc.IssuedAfter)
+	}
+	if rc.IssuedBefore != nil {
+		add("created_at < $%d", *rc.IssuedBefore)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// revocationJob tracks one batch revocation and accumulates its incident report
+type revocationJob struct {
+	ID             uuid.UUID          `json:"job_id"`
+	Criteria       revocationCriteria `json:"criteria"`
+	Reason         string             `json:"reason"`
+	DryRun         bool               `json:"dry_run"`
+	BatchSize      int                `json:"batch_size"`
+	Status         string             `json:"status"`
+	AccessRevoked  int                `json:"access_tokens_revoked"`
+	RefreshRevoked int                `json:"refresh_tokens_revoked"`
+	Batches        int                `json:"batches"`
+	RequestedBy    *uuid.UUID         `json:"requested_by,omitempty"`
+	StartedAt      time.Time          `json:"started_at"`
+	FinishedAt     *time.Time         `json:"finished_at,omitempty"`
+	Error          string             `json:"error,omitempty"`
+
+	users   map[uuid.UUID]int
+	clients map[uuid.UUID]*revocationClientCount
+}
+
+type revocationClientCount struct {
+	ClientID      uuid.UUID `json:"client_id"`
+	ClientName    string    `json:"client_name,omitempty"`
+	AccessTokens  int       `json:"access_tokens"`
+	RefreshTokens int       `json:"refresh_tokens"`
+}
+
+// revokedToken is one row returned by a revocation batch
+type revokedToken struct {
+	id       uuid.UUID
+	userID   *uuid.UUID
+	clientID uuid.UUID
+}
+
+func (job *revocationJob) record(tokens []revokedToken, refresh bool) {
+	for _, token := range tokens {
+		if token.userID != nil {
+			job.users[*token.userID]++
+		}
+		count, ok := job.clients[token.clientID]
+		if !ok {
+			count = &revocationClientCount{ClientID: token.clientID}
+			job.clients[token.clientID] = count
+		}
+		if refresh {
+			count.RefreshTokens++
+		} else {
+			count.AccessTokens++
+		}
+	}
+}
+
+// revocationEvents turns a batch of revoked access tokens into cache invalidation events:
+// one per user and client pair, and one per token issued without a user
+func revocationEvents(tokens []revokedToken) []RevocationEvent {
+	var events []RevocationEvent
+	seen := map[string]bool{}
+	for _, token := range tokens {
+		if token.userID == nil {
+			events = append(events, RevocationEvent{Type: revocationToken, ID: token.id.String()})
+			continue
+		}
+		key := token.userID.String() + "/" + token.clientID.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		events = append(events, RevocationEvent{Type: revocationGrant, UserID: token.userID.String(), ClientID: token.clientID.String()})
+	}
+	return events
+}
+
+// report is the incident report artifact for a finished job
+func (job *revocationJob) report() gin.H {
+	users := make([]uuid.UUID, 0, len(job.users))
+	for userID := range job.users {
+		users = append(users, userID)
+	}
+	clients := make([]*revocationClientCount, 0, len(job.clients))
+	for _, count := range job.clients {
+		clients = append(clients, count)
+	}
+
+	report := gin.H{
+		"job":              job,
+		"affected_users":   users,
+		"affected_clients": clients,
+		"generated_at":     time.Now(),
+	}
+	if job.FinishedAt != nil {
+		report["duration_seconds"] = job.FinishedAt.Sub(job.StartedAt).Seconds()
+	}
+	return report
+}
+
+// saveRevocationJob records the job's progress, and its report once finished
+func (as *AuthService) saveRevocationJob(ctx context.Context, job *revocationJob) error {
+	criteria, err := json.Marshal(job.Criteria)
+	if err != nil {
+		return err
+	}
+	var report []byte
+	if job.Status != revocationJobRunning {
+		if report, err = json.Marshal(job.report()); err != nil {
+			return err
+		}
+	}
+
+	_, err = as.db.ExecContext(ctx, `
+		INSERT INTO token_revocation_jobs (id, criteria, reason, dry_run, status, access_revoked, refresh_revoked,
+			batches, requested_by, started_at, finished_at, error, report)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (id) DO UPDATE SET status = $5, access_revoked = $6, refresh_revoked = $7,
+			batches = $8, finished_at = $11, error = $12, report = $13`,
+		job.ID, criteria, job.Reason, job.DryRun, job.Status, job.AccessRevoked, job.RefreshRevoked,
+		job.Batches, job.RequestedBy, job.StartedAt, job.FinishedAt, job.Error, report)
+	return err
+}
+
+// revokeBatch revokes up to batchSize matching tokens in table and returns them
+func (as *AuthService) revokeBatch(ctx context.Context, table string, criteria revocationCriteria, batchSize int) ([]revokedToken, error) {
+	where, args := criteria.where()
+	args = append(args, batchSize)
+	query := fmt.Sprintf(`
+		UPDATE %[1]s SET is_revoked = true, revoked_at = NOW()
+		WHERE id IN (SELECT id FROM %[1]s WHERE %[2]s LIMIT $%[3]d FOR UPDATE SKIP LOCKED)
+		RETURNING id, user_id, client_id`, table, where, len(args))
+
+	rows, err := as.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []revokedToken
+	for rows.Next() {
+		var token revokedToken
+		if err := rows.Scan(&token.id, &token.userID, &token.clientID); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// previewRevocation fills a dry-run job with the tokens that would be revoked
+func (as *AuthService) previewRevocation(ctx context.Context, job *revocationJob) error {
+	where, args := job.Criteria.where()
+	for _, table := range []string{"oauth_access_tokens", "oauth_refresh_tokens"} {
+		rows, err := as.db.QueryContext(ctx, fmt.Sprintf(`SELECT id, user_id, client_id FROM %s WHERE %s`, table, where), args...)
+		if err != nil {
+			return err
+		}
+		var tokens []revokedToken
+		for rows.Next() {
+			var token revokedToken
+			if err := rows.Scan(&token.id, &token.userID, &token.clientID); err != nil {
+				rows.Close()
+				return err
+			}
+			tokens = append(tokens, token)
+		}
+		rows.Close()
+
+		refresh := table == "oauth_refresh_tokens"
+		job.record(tokens, refresh)
+		if refresh {
+			job.RefreshRevoked = len(tokens)
+		} else {
+			job.AccessRevoked = len(tokens)
+		}
+	}
+	return nil
+}
+
+// runRevocationJob revokes matching access tokens, then refresh tokens, one batch at a time.
+// Each access token batch is broadcast before the next one starts so replicas stop
+// honouring cached validations as the job progresses.
+func (as *AuthService) runRevocationJob(ctx context.Context, job *revocationJob) {
+	finish := func(err error) {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Status = revocationJobCompleted
+		if err != nil {
+			job.Status = revocationJobFailed
+			job.Error = err.Error()
+			log.Printf("Batch revocation %s failed after %d batches: %v", job.ID, job.Batches, err)
+		}
+		for clientID, count := range job.clients {
+			if client, err := as.getClientByID(ctx, clientID.String()); err == nil {
+				count.ClientName = client.Name
+			}
+		}
+		if err := as.saveRevocationJob(ctx, job); err != nil {
+			log.Printf("Failed to save batch revocation %s: %v", job.ID, err)
+		}
+	}
+
+	if job.DryRun {
+		finish(as.previewRevocation(ctx, job))
+		return
+	}
+
+	for _, table := range []string{"oauth_access_tokens", "oauth_refresh_tokens"} {
+		refresh := table == "oauth_refresh_tokens"
+		for {
+			tokens, err := as.revokeBatch(ctx, table, job.Criteria, job.BatchSize)
+			if err != nil {
+				finish(err)
+				return
+			}
+			if len(tokens) == 0 {
+				break
+			}
+
+			job.Batches++
+			job.record(tokens, refresh)
+			if refresh {
+				job.RefreshRevoked += len(tokens)
+				batchRevokedTokensTotal.WithLabelValues("refresh").Add(float64(len(tokens)))
+			} else {
+				job.AccessRevoked += len(tokens)
+				batchRevokedTokensTotal.WithLabelValues("access").Add(float64(len(tokens)))
+				for _, event := range revocationEvents(tokens) {
+					as.publishRevocation(event)
+				}
+			}
+			if err := as.saveRevocationJob(ctx, job); err != nil {
+				log.Printf("Failed to record progress of batch revocation %s: %v", job.ID, err)
+			}
+		}
+	}
+	finish(nil)
+}
+
+// Admin API
+
+// AdminBatchRevoke starts a batch revocation and returns the job to poll for progress
+func (as *AuthService) AdminBatchRevoke(c *gin.Context) {
+	var req struct {
+		revocationCriteria
+		Reason    string `json:"reason" binding:"required"`
+		DryRun    bool   `json:"dry_run"`
+		BatchSize int    `json:"batch_size"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format; reason is required"})
+		return
+	}
+	if err := req.revocationCriteria.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// ?dry_run=true works here as on other destructive admin endpoints
+	req.DryRun = req.DryRun || dryrun.Requested(c.Request)
+	if req.BatchSize <= 0 {
+		req.BatchSize = defaultRevocationBatchSize
+	}
+	if req.BatchSize > maxRevocationBatchSize {
+		req.BatchSize = maxRevocationBatchSize
+	}
+
+	job := &revocationJob{
+		ID:        uuid.New(),
+		Criteria:  req.revocationCriteria,
+		Reason:    req.Reason,
+		DryRun:    req.DryRun,
+		BatchSize: req.BatchSize,
+		Status:    revocationJobRunning,
+		StartedAt: time.Now(),
+		users:     map[uuid.UUID]int{},
+		clients:   map[uuid.UUID]*revocationClientCount{},
+	}
+	if job.Criteria.IssuedBefore == nil {
+		job.Criteria.IssuedBefore = &job.StartedAt
+	}
+	if adminID, ok := c.Get("user_id"); ok {
+		if id, ok := adminID.(uuid.UUID); ok {
+			job.RequestedBy = &id
+		}
+	}
+	if err := as.saveRevocationJob(c.Request.Context(), job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start batch revocation"})
+		return
+	}
+	log.Printf("Batch revocation %s started (dry run: %t): %s", job.ID, job.DryRun, job.Reason)
+
+	started := *job
+
+	// The job outlives the request; it runs to completion even if the admin disconnects
+	go as.runRevocationJob(context.Background(), job)
+
+	c.Header("Location", fmt.Sprintf("%s/%s", strings.TrimSuffix(c.Request.URL.Path, "/"), job.ID))
+	c.JSON(http.StatusAccepted, gin.H{"job": started})
+}
+
+// AdminGetBatchRevocation reports a job's progress
+func (as *AuthService) AdminGetBatchRevocation(c *gin.Context) {
+	jobID, err := uuid.Parse(c.Param("job_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	job := &revocationJob{ID: jobID}
+	var criteria []byte
+	var finishedAt sql.NullTime
+	var jobError sql.NullString
+	err = as.db.QueryRowContext(c.Request.Context(), `
+		SELECT criteria, reason, dry_run, status, access_revoked, refresh_revoked, batches,
+			requested_by, started_at, finished_at, error
+		FROM token_revocation_jobs WHERE id = $1`, jobID).
+		Scan(&criteria, &job.Reason, &job.DryRun, &job.Status, &job.AccessRevoked, &job.RefreshRevoked,
+			&job.Batches, &job.RequestedBy, &job.StartedAt, &finishedAt, &jobError)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batch revocation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load batch revocation"})
+		return
+	}
+	json.Unmarshal(criteria, &job.Criteria)
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	job.Error = jobError.String
+
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// AdminGetBatchRevocationReport downloads the incident report of a finished job
+func (as *AuthService) AdminGetBatchRevocationReport(c *gin.Context) {
+	jobID, err := uuid.Parse(c.Param("job_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	var status string
+	var report []byte
+	err = as.db.QueryRowContext(c.Request.Context(), `SELECT status, report FROM token_revocation_jobs WHERE id = $1`, jobID).Scan(&status, &report)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batch revocation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load batch revocation"})
+		return
+	}
+	if status == revocationJobRunning || report == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "The report is available once the job has finished"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="revocation-%s.json"`, jobID))
+	c.Data(http.StatusOK, "application/json", report)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type BatchRevocationTestSuite struct {
+	suite.Suite
+}
+
+func (suite *BatchRevocationTestSuite) TestCriteriaMustNarrowTheRevocation() {
+	suite.Error(revocationCriteria{}.validate(), "would revoke every token")
+
+	now := time.Now()
+	earlier := now.Add(-24 * time.Hour)
+	suite.NoError(revocationCriteria{Scope: "works:manage", IssuedAfter: &earlier}.validate())
+	suite.Error(revocationCriteria{IssuedAfter: &now, IssuedBefore: &earlier}.validate())
+}
+
+func (suite *BatchRevocationTestSuite) TestWhereNumbersPlaceholders() {
+	clientID := uuid.New()
+	since := time.Now().Add(-24 * time.Hour)
+	where, args := revocationCriteria{ClientID: &clientID, Scope: "works:manage", IssuedAfter: &since}.where()
+
+	suite.Equal("is_revoked = false AND client_id = $1 AND $2 = ANY(scopes) AND created_at >= $3", where)
+	suite.Equal([]interface{}{clientID, "works:manage", since}, args)
+}
+
+func (suite *BatchRevocationTestSuite) TestEventsAreGroupedByGrant() {
+	userID, clientID := uuid.New(), uuid.New()
+	serviceToken := uuid.New()
+	events := revocationEvents([]revokedToken{
+		{id: uuid.New(), userID: &userID, clientID: clientID},
+		{id: uuid.New(), userID: &userID, clientID: clientID},
+		{id: serviceToken, clientID: clientID},
+	})
+
+	suite.Require().Len(events, 2)
+	suite.Equal(RevocationEvent{Type: revocationGrant, UserID: userID.String(), ClientID: clientID.String()}, events[0])
+	suite.Equal(RevocationEvent{Type: revocationToken, ID: serviceToken.String()}, events[1])
+}
+
+func (suite *BatchRevocationTestSuite) TestReportCountsPerClient() {
+	userID, clientID := uuid.New(), uuid.New()
+	job := &revocationJob{users: map[uuid.UUID]int{}, clients: map[uuid.UUID]*revocationClientCount{}}
+	job.record([]revokedToken{{id: uuid.New(), userID: &userID, clientID: clientID}}, false)
+	job.record([]revokedToken{{id: uuid.New(), userID: &userID, clientID: clientID}}, true)
+
+	report := job.report()
+	suite.Equal([]uuid.UUID{userID}, report["affected_users"])
+	clients := report["affected_clients"].([]*revocationClientCount)
+	suite.Require().Len(clients, 1)
+	suite.Equal(1, clients[0].AccessTokens)
+	suite.Equal(1, clients[0].RefreshTokens)
+}
+
+func TestBatchRevocationTestSuite(t *testing.T) {
+	suite.Run(t, new(BatchRevocationTestSuite))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RFC 6750 bearer token error codes
+const (
+	bearerErrorInvalidRequest    = "invalid_request"
+	bearerErrorInvalidToken      = "invalid_token"
+	bearerErrorInsufficientScope = "insufficient_scope"
+)
+
+var (
+	errAccessTokenInvalid = errors.New("invalid access token")
+	errAccessTokenExpired = errors.New("access token expired")
+)
+
+// bearerRealm is advertised in WWW-Authenticate challenges
+var bearerRealm = getEnv("AUTH_REALM", "liberation-auth")
+
+// setBearerChallenge sets an RFC 6750 WWW-Authenticate header.
+// errorCode is empty when the request carried no credentials at all.
+func setBearerChallenge(c *gin.Context, errorCode, description, scope string) {
+	params := []string{fmt.Sprintf("realm=%s", quoteAuthParam(bearerRealm))}
+	if errorCode != "" {
+		params = append(params, fmt.Sprintf("error=%s", quoteAuthParam(errorCode)))
+	}
+	if description != "" && errorCode != "" {
+		params = append(params, fmt.Sprintf("error_description=%s", quoteAuthParam(description)))
+	}
+	if scope != "" {
+		params = append(params, fmt.Sprintf("scope=%s", quoteAuthParam(scope)))
+	}
+
+	c.Header("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+}
+
+// abortWithBearerError writes a bearer challenge and the matching JSON error body
+func abortWithBearerError(c *gin.Context, status int, errorCode, description, scope string) {
+	setBearerChallenge(c, errorCode, description, scope)
+
+	body := gin.H{
+		"error":             errorCode,
+		"error_description": description,
+	}
+	if scope != "" {
+		body["scope"] = scope
+	}
+
+	c.JSON(status, body)
+	c.Abort()
+}
+
+// jwtDenialReason explains why a JWT access token was rejected without leaking validation internals
+func jwtDenialReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "The access token expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return "The access token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "The access token is malformed"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return "The access token signature is invalid"
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return "The access token is not intended for this resource"
+	case errors.Is(err, errSignedOut):
+		return "The access token was signed out"
+	case errors.Is(err, errTokenSubject):
+		return "Invalid user ID in token"
+	default:
+		return "Token validation failed"
+	}
+}
+
+// accessTokenDenialReason explains why an opaque access token was rejected
+func accessTokenDenialReason(err error) string {
+	if errors.Is(err, errAccessTokenExpired) {
+		return "The access token expired"
+	}
+	return "The access token is invalid or has been revoked"
+}
+
+// quoteAuthParam renders a quoted-string, escaping backslashes and quotes
+func quoteAuthParam(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
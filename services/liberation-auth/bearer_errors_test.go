@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type BearerErrorTestSuite struct {
+	suite.Suite
+	realm string
+}
+
+func (suite *BearerErrorTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.realm = bearerRealm
+	bearerRealm = "archive"
+}
+
+func (suite *BearerErrorTestSuite) TearDownTest() {
+	bearerRealm = suite.realm
+}
+
+func (suite *BearerErrorTestSuite) TestChallenge() {
+	for _, tc := range []struct {
+		name                          string
+		errorCode, description, scope string
+		want                          string
+	}{
+		{"no credentials", "", "", "", `Bearer realm="archive"`},
+		{"a description needs an error code", "", "ignored", "", `Bearer realm="archive"`},
+		{"invalid token", bearerErrorInvalidToken, "The access token expired", "",
+			`Bearer realm="archive", error="invalid_token", error_description="The access token expired"`},
+		{"insufficient scope", bearerErrorInsufficientScope, "Scope works:write is required", "works:write",
+			`Bearer realm="archive", error="insufficient_scope", error_description="Scope works:write is required", scope="works:write"`},
+		{"quotes and backslashes are escaped", bearerErrorInvalidRequest, `bad "token" \ here`, "",
+			`Bearer realm="archive", error="invalid_request", error_description="bad \"token\" \\ here"`},
+	} {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		setBearerChallenge(c, tc.errorCode, tc.description, tc.scope)
+		suite.Equal(tc.want, recorder.Header().Get("WWW-Authenticate"), tc.name)
+	}
+}
+
+func (suite *BearerErrorTestSuite) TestAbortWritesMatchingBody() {
+	for _, tc := range []struct {
+		status                        int
+		errorCode, description, scope string
+	}{
+		{http.StatusBadRequest, bearerErrorInvalidRequest, "Bearer token required", ""},
+		{http.StatusUnauthorized, bearerErrorInvalidToken, "The access token was signed out", ""},
+		{http.StatusForbidden, bearerErrorInsufficientScope, "Profile scope required", "openid profile"},
+	} {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		abortWithBearerError(c, tc.status, tc.errorCode, tc.description, tc.scope)
+
+		suite.True(c.IsAborted(), tc.errorCode)
+		suite.Equal(tc.status, recorder.Code, tc.errorCode)
+		suite.Contains(recorder.Header().Get("WWW-Authenticate"), fmt.Sprintf(`error="%s"`, tc.errorCode))
+		var body map[string]string
+		suite.Require().NoError(json.Unmarshal(recorder.Body.Bytes(), &body))
+		suite.Equal(tc.errorCode, body["error"])
+		suite.Equal(tc.description, body["error_description"])
+		suite.Equal(tc.scope, body["scope"])
+	}
+}
+
+func (suite *BearerErrorTestSuite) TestDenialReasons() {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("parse: %w", jwt.ErrTokenExpired), "The access token expired"},
+		{jwt.ErrTokenNotValidYet, "The access token is not valid yet"},
+		{jwt.ErrTokenMalformed, "The access token is malformed"},
+		{jwt.ErrTokenSignatureInvalid, "The access token signature is invalid"},
+		{jwt.ErrTokenUnverifiable, "The access token signature is invalid"},
+		{jwt.ErrTokenInvalidAudience, "The access token is not intended for this resource"},
+		{errSignedOut, "The access token was signed out"},
+		{errTokenSubject, "Invalid user ID in token"},
+		{errors.New("key lookup failed: kid k-9"), "Token validation failed"},
+	} {
+		suite.Equal(tc.want, jwtDenialReason(tc.err), tc.err.Error())
+	}
+
+	suite.Equal("The access token expired", accessTokenDenialReason(fmt.Errorf("lookup: %w", errAccessTokenExpired)))
+	suite.Equal("The access token is invalid or has been revoked", accessTokenDenialReason(errAccessTokenInvalid))
+}
+
+// The sign-out epoch lookup failing is the server's problem, not the token's
+func (suite *BearerErrorTestSuite) TestJWTErrors() {
+	for _, tc := range []struct {
+		err       error
+		status    int
+		challenge bool
+	}{
+		{jwt.ErrTokenExpired, http.StatusUnauthorized, true},
+		{errSignedOut, http.StatusUnauthorized, true},
+		{errSignOutEpochUnavailable, http.StatusServiceUnavailable, false},
+	} {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		abortWithJWTError(c, tc.err)
+		suite.Equal(tc.status, recorder.Code, tc.err.Error())
+		suite.Equal(tc.challenge, recorder.Header().Get("WWW-Authenticate") != "", tc.err.Error())
+	}
+}
+
+func TestBearerErrorTestSuite(t *testing.T) {
+	suite.Run(t, new(BearerErrorTestSuite))
+}
@@ -0,0 +1,607 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"liberation-storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// defaultBrandingID is the profile applied to clients without one of their own
+	defaultBrandingID  = "default"
+	brandingLogoPrefix = "branding/"
+	// brandingChannel tells other replicas to drop cached branding after an admin change
+	brandingChannel = "auth:branding"
+)
+
+var brandingIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var brandingColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+var brandingLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_branding_lookups_total",
+	Help: "Branding resolved for hosted pages, by result (hit, loaded, fallback).",
+}, []string{"result"})
+
+// Branding is what hosted login and consent pages are styled with. Empty fields in a
+// client's profile fall back to the default profile, then to BRANDING_* settings.
+type Branding struct {
+	ID              string `json:"id"`
+	Name            string `json:"name,omitempty"`
+	LogoURL         string `json:"logo_url,omitempty"`
+	PrimaryColor    string `json:"primary_color,omitempty"`
+	AccentColor     string `json:"accent_color,omitempty"`
+	BackgroundColor string `json:"background_color,omitempty"`
+	TextColor       string `json:"text_color,omitempty"`
+	SupportURL      string `json:"support_url,omitempty"`
+	SupportEmail    string `json:"support_email,omitempty"`
+	PrivacyURL      string `json:"privacy_url,omitempty"`
+	TermsURL        string `json:"terms_url,omitempty"`
+}
+
+// validate checks an admin-supplied profile
+func (b Branding) validate() error {
+	for field, color := range map[string]string{
+		"primary_color": b.PrimaryColor, "accent_color": b.AccentColor,
+		"background_color": b.BackgroundColor, "text_color": b.TextColor,
+	} {
+		if color != "" && !brandingColorPattern.MatchString(color) {
+			return fmt.Errorf("%s must be a hex color such as #4f46e5", field)
+		}
+	}
+	for field, link := range map[string]string{"support_url": b.SupportURL, "privacy_url": b.PrivacyURL, "terms_url": b.TermsURL} {
+		if link == "" {
+			continue
+		}
+		parsed, err := url.Parse(link)
+		if err != nil || parsed.Host == "" || parsed.Scheme != "https" && !isLoopbackHost(parsed.Hostname()) {
+			return fmt.Errorf("%s must be an https URL", field)
+		}
+	}
+	if b.SupportEmail != "" {
+		if _, err := mail.ParseAddress(b.SupportEmail); err != nil {
+			return fmt.Errorf("support_email must be an email address")
+		}
+	}
+	if len(b.Name) > 100 {
+		return fmt.Errorf("name must be at most 100 characters")
+	}
+	return nil
+}
+
+// overlay fills the receiver's empty fields from fallback
+func (b Branding) overlay(fallback Branding) Branding {
+	fill := func(value *string, other string) {
+		if *value == "" {
+			*value = other
+		}
+	}
+	fill(&b.ID, fallback.ID)
+	fill(&b.Name, fallback.Name)
+	fill(&b.LogoURL, fallback.LogoURL)
+	fill(&b.PrimaryColor, fallback.PrimaryColor)
+	fill(&b.AccentColor, fallback.AccentColor)
+	fill(&b.BackgroundColor, fallback.BackgroundColor)
+	fill(&b.TextColor, fallback.TextColor)
+	fill(&b.SupportURL, fallback.SupportURL)
+	fill(&b.SupportEmail, fallback.SupportEmail)
+	fill(&b.PrivacyURL, fallback.PrivacyURL)
+	fill(&b.TermsURL, fallback.TermsURL)
+	return b
+}
+
+// BrandingConfig holds the built-in branding and how long resolved branding is cached
+type BrandingConfig struct {
+	Fallback Branding
+	CacheTTL time.Duration
+	// PublicURL is where logo links point
+	PublicURL string
+}
+
+// DefaultBrandingConfig reads BRANDING_NAME, BRANDING_PRIMARY_COLOR, BRANDING_ACCENT_COLOR,
+// BRANDING_BACKGROUND_COLOR, BRANDING_TEXT_COLOR, BRANDING_SUPPORT_URL, BRANDING_SUPPORT_EMAIL
+// and BRANDING_CACHE_TTL. These apply wherever no profile says otherwise.
+func DefaultBrandingConfig() (BrandingConfig, error) {
+	config := BrandingConfig{
+		Fallback: Branding{
+			ID:              "builtin",
+			Name:            getEnv("BRANDING_NAME", "Liberation Auth"),
+			PrimaryColor:    getEnv("BRANDING_PRIMARY_COLOR", "#4f46e5"),
+			AccentColor:     getEnv("BRANDING_ACCENT_COLOR", "#16a34a"),
+			BackgroundColor: getEnv("BRANDING_BACKGROUND_COLOR", "#ffffff"),
+			TextColor:       getEnv("BRANDING_TEXT_COLOR", "#111827"),
+			SupportURL:      getEnv("BRANDING_SUPPORT_URL", ""),
+			SupportEmail:    getEnv("BRANDING_SUPPORT_EMAIL", ""),
+		},
+		PublicURL: strings.TrimSuffix(getEnv("PUBLIC_URL", "http://localhost:"+getEnv("PORT", "8081")), "/"),
+	}
+	if err := config.Fallback.validate(); err != nil {
+		return config, fmt.Errorf("BRANDING_* settings: %w", err)
+	}
+	var err error
+	if config.CacheTTL, err = time.ParseDuration(getEnv("BRANDING_CACHE_TTL", "5m")); err != nil || config.CacheTTL < 0 {
+		return config, fmt.Errorf("BRANDING_CACHE_TTL must be a duration such as 5m, or 0 to disable the cache")
+	}
+	return config, nil
+}
+
+// BrandingService stores branding profiles, attaches them to OAuth clients and resolves what
+// a hosted page should look like. A profile can be shared by every client of one tenant.
+type BrandingService struct {
+	as     *AuthService
+	config BrandingConfig
+
+	mu    sync.Mutex
+	cache map[string]cachedBranding
+}
+
+type cachedBranding struct {
+	branding Branding
+	loadedAt time.Time
+}
+
+func NewBrandingService(as *AuthService, config BrandingConfig) *BrandingService {
+	return &BrandingService{as: as, config: config, cache: map[string]cachedBranding{}}
+}
+
+// resolve returns the branding for a client, or the default branding when clientID is nil.
+// Lookups that fail fall back to the built-in branding rather than failing the page. A nil
+// service resolves to no branding.
+func (s *BrandingService) resolve(ctx context.Context, clientID *uuid.UUID) Branding {
+	if s == nil {
+		return Branding{}
+	}
+	key := defaultBrandingID
+	if clientID != nil {
+		key = clientID.String()
+	}
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < s.config.CacheTTL {
+		brandingLookups.WithLabelValues("hit").Inc()
+		return cached.branding
+	}
+
+	branding, err := s.load(ctx, clientID)
+	if err != nil {
+		log.Printf("Failed to load branding for %s: %v", key, err)
+		brandingLookups.WithLabelValues("fallback").Inc()
+		return s.config.Fallback
+	}
+	brandingLookups.WithLabelValues("loaded").Inc()
+	if s.config.CacheTTL > 0 {
+		s.mu.Lock()
+		s.cache[key] = cachedBranding{branding: branding, loadedAt: time.Now()}
+		s.mu.Unlock()
+	}
+	return branding
+}
+
+// load reads the client's profile and the default profile and layers them over the fallback.
+// A client's registered logo_url is used when its profile has no logo of its own.
+func (s *BrandingService) load(ctx context.Context, clientID *uuid.UUID) (Branding, error) {
+	branding := s.config.Fallback
+	if profile, err := s.profile(ctx, defaultBrandingID); err != nil {
+		return branding, err
+	} else if profile != nil {
+		branding = profile.overlay(branding)
+	}
+	if clientID == nil {
+		return branding, nil
+	}
+
+	var brandingID sql.NullString
+	var clientLogo sql.NullString
+	err := s.as.db.QueryRowContext(ctx, `
+		SELECT cb.branding_id, oc.logo_url FROM oauth_clients oc
+		LEFT JOIN client_branding cb ON cb.client_id = oc.client_id
+		WHERE oc.client_id = $1`, *clientID).Scan(&brandingID, &clientLogo)
+	if errors.Is(err, sql.ErrNoRows) {
+		return branding, nil
+	}
+	if err != nil {
+		return branding, err
+	}
+	client := Branding{LogoURL: clientLogo.String}
+	if brandingID.Valid {
+		profile, err := s.profile(ctx, brandingID.String)
+		if err != nil {
+			return branding, err
+		}
+		if profile != nil {
+			client = profile.overlay(client)
+		}
+	}
+	return client.overlay(branding), nil
+}
+
+// profile loads a stored profile, with its logo as a versioned link; nil when there is none
+func (s *BrandingService) profile(ctx context.Context, id string) (*Branding, error) {
+	profile := &Branding{ID: id}
+	var logoKey sql.NullString
+	var updatedAt time.Time
+	err := s.as.db.QueryRowContext(ctx, `
+		SELECT name, primary_color, accent_color, background_color, text_color,
+			support_url, support_email, privacy_url, terms_url, logo_key, updated_at
+		FROM branding_profiles WHERE id = $1`, id).Scan(
+		&profile.Name, &profile.PrimaryColor, &profile.AccentColor, &profile.BackgroundColor, &profile.TextColor,
+		&profile.SupportURL, &profile.SupportEmail, &profile.PrivacyURL, &profile.TermsURL, &logoKey, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The version changes with every update, so browsers never show a replaced logo
+	if logoKey.Valid {
+		profile.LogoURL = fmt.Sprintf("%s/api/v1/auth/branding/%s/logo?v=%d", s.config.PublicURL, id, updatedAt.Unix())
+	}
+	return profile, nil
+}
+
+// invalidate drops cached branding here and on the other replicas
+func (s *BrandingService) invalidate() {
+	s.clear()
+	if s.as.redis == nil {
+		return
+	}
+	if err := s.as.redis.Publish(context.Background(), brandingChannel, instanceID).Err(); err != nil {
+		log.Printf("Failed to broadcast branding change: %v", err)
+	}
+}
+
+func (s *BrandingService) clear() {
+	s.mu.Lock()
+	s.cache = map[string]cachedBranding{}
+	s.mu.Unlock()
+}
+
+// runBrandingListener clears cached branding when another replica changes it. Missed messages
+// only delay a change by BRANDING_CACHE_TTL.
+func (s *BrandingService) runBrandingListener(ctx context.Context) {
+	if s.as.redis == nil || s.config.CacheTTL == 0 {
+		return
+	}
+	pubsub := s.as.redis.Subscribe(ctx, brandingChannel)
+	defer pubsub.Close()
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			// A (re)subscription may have missed changes
+			s.clear()
+		case *redis.Message:
+			if m.Payload != instanceID {
+				s.clear()
+			}
+		}
+	}
+}
+
+// GetBranding serves the branding for ?client_id=, or the default branding, to hosted pages
+// and first-party frontends
+func (s *BrandingService) GetBranding(c *gin.Context) {
+	var clientID *uuid.UUID
+	if value := c.Query("client_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "Invalid client ID"})
+			return
+		}
+		clientID = &id
+	}
+	branding := s.resolve(c.Request.Context(), clientID)
+
+	body, _ := json.Marshal(branding)
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=60")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// GetBrandingLogo redirects to a short-lived signed URL for a profile's logo
+func (s *BrandingService) GetBrandingLogo(c *gin.Context) {
+	if s.as.files == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "No logo set"})
+		return
+	}
+	var key sql.NullString
+	err := s.as.db.QueryRowContext(c.Request.Context(), `SELECT logo_key FROM branding_profiles WHERE id = $1`, c.Param("branding_id")).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !key.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "No logo set"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to load logo"})
+		return
+	}
+	ttl := s.as.files.config.AvatarLinkTTL
+	url, _, err := s.as.files.signedURL(c.Request.Context(), key.String, ttl)
+	if err != nil {
+		c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to sign logo URL"})
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ttl.Seconds()/2)))
+	c.Redirect(http.StatusFound, url)
+}
+
+// AdminListBranding lists stored profiles and the clients attached to each
+func (s *BrandingService) AdminListBranding(c *gin.Context) {
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `
+		SELECT p.id, COALESCE(ARRAY_AGG(cb.client_id::text) FILTER (WHERE cb.client_id IS NOT NULL), '{}')
+		FROM branding_profiles p LEFT JOIN client_branding cb ON cb.branding_id = p.id
+		GROUP BY p.id ORDER BY p.id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load branding"})
+		return
+	}
+	type listed struct {
+		id      string
+		clients []string
+	}
+	var ids []listed
+	for rows.Next() {
+		var entry listed
+		if err := rows.Scan(&entry.id, pq.Array(&entry.clients)); err != nil {
+			continue
+		}
+		ids = append(ids, entry)
+	}
+	rows.Close()
+
+	profiles := []gin.H{}
+	for _, entry := range ids {
+		profile, err := s.profile(c.Request.Context(), entry.id)
+		if err != nil || profile == nil {
+			continue
+		}
+		profiles = append(profiles, gin.H{"branding": profile, "clients": entry.clients})
+	}
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles, "fallback": s.config.Fallback})
+}
+
+// AdminPutBranding creates or replaces a profile. The "default" profile applies to every
+// client without one of its own.
+func (s *BrandingService) AdminPutBranding(c *gin.Context) {
+	id := c.Param("branding_id")
+	if !brandingIDPattern.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Branding IDs are lowercase letters, digits and dashes"})
+		return
+	}
+	var branding Branding
+	if err := c.ShouldBindJSON(&branding); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := branding.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	_, err := s.as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO branding_profiles (id, name, primary_color, accent_color, background_color, text_color,
+			support_url, support_email, privacy_url, terms_url, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW())
+		ON CONFLICT (id) DO UPDATE SET name = $2, primary_color = $3, accent_color = $4, background_color = $5,
+			text_color = $6, support_url = $7, support_email = $8, privacy_url = $9, terms_url = $10,
+			updated_by = $11, updated_at = NOW()`,
+		id, branding.Name, branding.PrimaryColor, branding.AccentColor, branding.BackgroundColor, branding.TextColor,
+		branding.SupportURL, branding.SupportEmail, branding.PrivacyURL, branding.TermsURL, adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save branding"})
+		return
+	}
+	s.invalidate()
+
+	stored, err := s.profile(c.Request.Context(), id)
+	if err != nil || stored == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load branding"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"branding": stored})
+}
+
+// AdminDeleteBranding removes a profile; its clients fall back to the default profile
+func (s *BrandingService) AdminDeleteBranding(c *gin.Context) {
+	id := c.Param("branding_id")
+	if s.as.previewDryRun(c, "delete_branding", id, []string{"The profile's logo, if it has one, is deleted from storage, and its clients fall back to the default branding"},
+		dryRunQuery{entity: "branding_profiles", action: "delete", id: "id", args: []interface{}{id},
+			from: "FROM branding_profiles WHERE id = $1", missing: "Branding not found"},
+		dryRunQuery{entity: "client_branding", action: "detach", id: "client_id", args: []interface{}{id},
+			from: "FROM client_branding WHERE branding_id = $1"}) {
+		return
+	}
+	var logoKey sql.NullString
+	err := s.as.db.QueryRowContext(c.Request.Context(), `DELETE FROM branding_profiles WHERE id = $1 RETURNING logo_key`, id).Scan(&logoKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branding not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete branding"})
+		return
+	}
+	s.invalidate()
+	s.deleteLogoObject(c.Request.Context(), logoKey)
+	c.Status(http.StatusNoContent)
+}
+
+// AdminPutBrandingLogo stores a PNG, JPEG, GIF or WebP logo for a profile, sent as the raw
+// body or a multipart "logo" field
+func (s *BrandingService) AdminPutBrandingLogo(c *gin.Context) {
+	if s.as.files == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Logo uploads need STORAGE_BACKEND to be configured"})
+		return
+	}
+	id := c.Param("branding_id")
+	data, err := s.as.files.readUpload(c, "logo", s.as.files.config.AvatarMaxBytes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	contentType := http.DetectContentType(data)
+	extension, ok := avatarTypes[contentType]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Logo must be a PNG, JPEG, GIF or WebP image"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := fmt.Sprintf("%s%s/%s%s", brandingLogoPrefix, id, randomObjectID(), extension)
+	if _, err := s.as.files.store.Put(ctx, key, bytes.NewReader(data), storage.PutOptions{
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Metadata:    map[string]string{"branding-id": id},
+	}); err != nil {
+		log.Printf("Failed to store logo for branding %s: %v", id, err)
+		c.JSON(storageErrorStatus(err), gin.H{"error": "Failed to store logo"})
+		return
+	}
+
+	var previous sql.NullString
+	err = s.as.db.QueryRowContext(ctx, `
+		WITH old AS (SELECT logo_key FROM branding_profiles WHERE id = $1)
+		UPDATE branding_profiles SET logo_key = $2, updated_at = NOW() WHERE id = $1
+		RETURNING (SELECT logo_key FROM old)`, id, key).Scan(&previous)
+	if err != nil {
+		s.as.files.store.Delete(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Branding not found; create it before uploading a logo"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save logo"})
+		return
+	}
+	s.invalidate()
+	s.deleteLogoObject(ctx, previous)
+
+	stored, err := s.profile(ctx, id)
+	if err != nil || stored == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load branding"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"branding": stored})
+}
+
+// AdminDeleteBrandingLogo removes a profile's logo
+func (s *BrandingService) AdminDeleteBrandingLogo(c *gin.Context) {
+	var previous sql.NullString
+	err := s.as.db.QueryRowContext(c.Request.Context(), `
+		WITH old AS (SELECT logo_key FROM branding_profiles WHERE id = $1)
+		UPDATE branding_profiles SET logo_key = NULL, updated_at = NOW() WHERE id = $1
+		RETURNING (SELECT logo_key FROM old)`, c.Param("branding_id")).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branding not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete logo"})
+		return
+	}
+	s.invalidate()
+	s.deleteLogoObject(c.Request.Context(), previous)
+	c.Status(http.StatusNoContent)
+}
+
+func (s *BrandingService) deleteLogoObject(ctx context.Context, key sql.NullString) {
+	if !key.Valid || s.as.files == nil {
+		return
+	}
+	if err := s.as.files.store.Delete(ctx, key.String); err != nil {
+		log.Printf("Failed to delete logo object %s: %v", key.String, err)
+	}
+}
+
+// AdminGetClientBranding shows the branding a client's pages resolve to
+func (s *BrandingService) AdminGetClientBranding(c *gin.Context) {
+	client, ok := s.as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+	var brandingID sql.NullString
+	err := s.as.db.QueryRowContext(c.Request.Context(), `SELECT branding_id FROM client_branding WHERE client_id = $1`, client.ID).Scan(&brandingID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load client branding"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"branding_id": brandingID.String, "resolved": s.resolve(c.Request.Context(), &client.ID)})
+}
+
+// AdminPutClientBranding attaches a profile to a client
+func (s *BrandingService) AdminPutClientBranding(c *gin.Context) {
+	client, ok := s.as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		BrandingID string `json:"branding_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "branding_id is required"})
+		return
+	}
+	result, err := s.as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO client_branding (client_id, branding_id, updated_at)
+		SELECT $1, id, NOW() FROM branding_profiles WHERE id = $2
+		ON CONFLICT (client_id) DO UPDATE SET branding_id = $2, updated_at = NOW()`, client.ID, req.BrandingID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save client branding"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branding not found"})
+		return
+	}
+	s.invalidate()
+	c.JSON(http.StatusOK, gin.H{"branding_id": req.BrandingID, "resolved": s.resolve(c.Request.Context(), &client.ID)})
+}
+
+// AdminDeleteClientBranding detaches a client's profile, so it uses the default again
+func (s *BrandingService) AdminDeleteClientBranding(c *gin.Context) {
+	client, ok := s.as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+	if _, err := s.as.db.ExecContext(c.Request.Context(), `DELETE FROM client_branding WHERE client_id = $1`, client.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete client branding"})
+		return
+	}
+	s.invalidate()
+	c.Status(http.StatusNoContent)
+}
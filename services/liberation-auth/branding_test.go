@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type BrandingTestSuite struct {
+	suite.Suite
+}
+
+func (suite *BrandingTestSuite) TestValidate() {
+	suite.NoError(Branding{Name: "Archive", PrimaryColor: "#AA00ff", SupportURL: "https://help.example.org", SupportEmail: "help@example.org"}.validate())
+	suite.NoError(Branding{TermsURL: "http://localhost:3000/terms"}.validate())
+
+	for _, branding := range []Branding{
+		{PrimaryColor: "red"},
+		{TextColor: "#fff"},
+		{SupportURL: "http://help.example.org"},
+		{PrivacyURL: "javascript:alert(1)"},
+		{SupportEmail: "not an address"},
+		{Name: strings.Repeat("x", 101)},
+	} {
+		suite.Error(branding.validate(), "%+v", branding)
+	}
+}
+
+func (suite *BrandingTestSuite) TestFieldsFallBackOneByOne() {
+	builtin := Branding{ID: "builtin", Name: "Liberation Auth", PrimaryColor: "#4f46e5", TextColor: "#111827"}
+	defaults := Branding{ID: "default", Name: "Archive", SupportURL: "https://help.example.org"}
+	client := Branding{ID: "zine-club", PrimaryColor: "#ff0000", LogoURL: "https://zines.example.org/logo.png"}
+
+	resolved := client.overlay(defaults.overlay(builtin))
+	suite.Equal(Branding{
+		ID: "zine-club", Name: "Archive", LogoURL: "https://zines.example.org/logo.png",
+		PrimaryColor: "#ff0000", TextColor: "#111827", SupportURL: "https://help.example.org",
+	}, resolved)
+}
+
+func (suite *BrandingTestSuite) TestCachedBrandingIsServedWithValidators() {
+	gin.SetMode(gin.TestMode)
+	s := NewBrandingService(&AuthService{}, BrandingConfig{CacheTTL: time.Minute})
+	clientID := uuid.New()
+	s.cache[clientID.String()] = cachedBranding{branding: Branding{ID: "zine-club", Name: "Zine Club"}, loadedAt: time.Now()}
+
+	router := gin.New()
+	router.GET("/branding", s.GetBranding)
+	get := func(query, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/branding"+query, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("?client_id="+clientID.String(), "")
+	suite.Require().Equal(http.StatusOK, w.Code)
+	var branding Branding
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &branding))
+	suite.Equal("Zine Club", branding.Name)
+	suite.NotEmpty(w.Header().Get("ETag"))
+
+	suite.Equal(http.StatusNotModified, get("?client_id="+clientID.String(), w.Header().Get("ETag")).Code)
+	suite.Equal(http.StatusBadRequest, get("?client_id=nope", "").Code)
+
+	// Admin changes drop the cache so the next lookup reloads
+	s.clear()
+	suite.Empty(s.cache)
+}
+
+func (suite *BrandingTestSuite) TestNilServiceResolvesToNothing() {
+	var s *BrandingService
+	suite.Equal(Branding{}, s.resolve(context.Background(), nil))
+}
+
+func (suite *BrandingTestSuite) TestConfig() {
+	config, err := DefaultBrandingConfig()
+	suite.Require().NoError(err)
+	suite.Equal("Liberation Auth", config.Fallback.Name)
+	suite.Equal(5*time.Minute, config.CacheTTL)
+
+	suite.T().Setenv("BRANDING_PRIMARY_COLOR", "purple")
+	_, err = DefaultBrandingConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("BRANDING_PRIMARY_COLOR", "#663399")
+	suite.T().Setenv("BRANDING_CACHE_TTL", "-1s")
+	_, err = DefaultBrandingConfig()
+	suite.Error(err)
+}
+
+func TestBrandingTestSuite(t *testing.T) {
+	suite.Run(t, new(BrandingTestSuite))
+}
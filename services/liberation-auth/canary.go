@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// canaryNamespace derives the fixed IDs of the canary user and client, so every run and
+// every replica uses the same two rows
+var canaryNamespace = uuid.MustParse("9b2e4c1a-7d3f-5e6a-8b9c-0d1e2f3a4b5c")
+
+// canaryRedirectURI is registered for the canary client; the canary reads the code from the
+// redirect and never follows it
+const canaryRedirectURI = "https://canary.invalid/callback"
+
+var (
+	canaryRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "liberation_auth_canary_runs_total",
+		Help: "Synthetic authorization-code flows run by the canary, by outcome (healthy, unhealthy).",
+	}, []string{"outcome"})
+	canaryStepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "liberation_auth_canary_step_duration_seconds",
+		Help:    "Latency of each step of the canary's authorization-code flow, by step and outcome.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"step", "outcome"})
+	canaryLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "liberation_auth_canary_last_success_timestamp_seconds",
+		Help: "When the canary last completed the whole flow; alert when it falls behind.",
+	})
+)
+
+// CanaryConfig controls the canary, which runs a complete authorization-code flow with PKCE
+// against the service's own endpoints
+type CanaryConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	// BaseURL is where the canary reaches the service, through the same listener as clients
+	BaseURL string
+	Timeout time.Duration
+	// History is how many runs are kept for /admin/canary
+	History int
+	Email   string
+}
+
+// DefaultCanaryConfig reads CANARY_* from the environment
+func DefaultCanaryConfig() (CanaryConfig, error) {
+	config := CanaryConfig{
+		Enabled: getEnv("CANARY_ENABLED", "false") == "true",
+		BaseURL: strings.TrimSuffix(getEnv("CANARY_BASE_URL", "http://127.0.0.1:"+getEnv("PORT", "8081")), "/"),
+		Email:   getEnv("CANARY_EMAIL", "canary@canary.invalid"),
+	}
+	if parsed, err := url.Parse(config.BaseURL); err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return config, fmt.Errorf("CANARY_BASE_URL must be an http(s) URL")
+	}
+	var err error
+	if config.Interval, err = time.ParseDuration(getEnv("CANARY_INTERVAL", "1m")); err != nil || config.Interval < 10*time.Second {
+		return config, fmt.Errorf("CANARY_INTERVAL must be a duration of at least 10s")
+	}
+	if config.Timeout, err = time.ParseDuration(getEnv("CANARY_TIMEOUT", "10s")); err != nil || config.Timeout <= 0 {
+		return config, fmt.Errorf("CANARY_TIMEOUT must be a positive duration")
+	}
+	if config.History, err = strconv.Atoi(getEnv("CANARY_HISTORY", "100")); err != nil || config.History < 1 {
+		return config, fmt.Errorf("CANARY_HISTORY must be a positive number of runs")
+	}
+	return config, nil
+}
+
+// CanaryService signs in as a synthetic user and walks a dedicated client through login,
+// authorization, code exchange, userinfo, refresh and revocation, timing each step. It runs
+// as the canary background job, so one replica at a time drives it.
+type CanaryService struct {
+	as     *AuthService
+	config CanaryConfig
+	client *http.Client
+	// keyFor verifies ID tokens
+	keyFor   func(keyID interface{}) (*rsa.PublicKey, error)
+	userID   uuid.UUID
+	clientID uuid.UUID
+}
+
+// NewCanaryService returns nil when the canary is disabled
+func NewCanaryService(as *AuthService, config CanaryConfig) *CanaryService {
+	if !config.Enabled {
+		return nil
+	}
+	return newCanary(as, config, as.jwt.verificationKey)
+}
+
+func newCanary(as *AuthService, config CanaryConfig, keyFor func(keyID interface{}) (*rsa.PublicKey, error)) *CanaryService {
+	return &CanaryService{
+		as:     as,
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			// The code is read from the authorization redirect, which points nowhere
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		keyFor:   keyFor,
+		userID:   uuid.NewSHA1(canaryNamespace, []byte("user")),
+		clientID: uuid.NewSHA1(canaryNamespace, []byte("client")),
+	}
+}
+
+// canaryCredentials are the canary's secrets for one run
+type canaryCredentials struct {
+	password     string
+	clientSecret string
+}
+
+func randomCanarySecret() string {
+	secret := make([]byte, 24)
+	rand.Read(secret)
+	return hex.EncodeToString(secret)
+}
+
+// ensureFixtures creates the canary user and client, or gives them fresh secrets. Minimum
+// bcrypt cost keeps frequent runs cheap; verification still goes through the normal comparison.
+// The client is trusted, so authorization skips the consent screen.
+func (s *CanaryService) ensureFixtures(ctx context.Context) (canaryCredentials, error) {
+	creds := canaryCredentials{password: randomCanarySecret(), clientSecret: randomCanarySecret()}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(creds.password), bcrypt.MinCost)
+	if err != nil {
+		return creds, err
+	}
+	secretHash, err := bcrypt.GenerateFromPassword([]byte(creds.clientSecret), bcrypt.MinCost)
+	if err != nil {
+		return creds, err
+	}
+
+	now := time.Now()
+	if _, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO users (id, username, email, email_bidx, password_hash, display_name, is_active, is_verified, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, 'Canary', true, true, $6, $6)
+		ON CONFLICT (id) DO UPDATE SET email = EXCLUDED.email, email_bidx = EXCLUDED.email_bidx,
+			password_hash = EXCLUDED.password_hash, is_active = true, is_verified = true, updated_at = $6`,
+		s.userID, "canary_"+s.userID.String()[:8], s.as.pii.value(piiUserEmail, s.config.Email), s.as.pii.index(piiUserEmail, s.config.Email),
+		string(passwordHash), now); err != nil {
+		return creds, fmt.Errorf("canary user: %w", err)
+	}
+	if _, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO oauth_clients (
+			client_id, client_secret, client_name, description, website, logo_url,
+			redirect_uris, scopes, grant_types, response_types, is_public, is_confidential,
+			is_trusted, is_first_party, owner_id, access_token_ttl, refresh_token_ttl,
+			is_active, created_at, updated_at
+		) VALUES ($1, $2, 'Canary', 'Synthetic OAuth flow monitor', '', '', $3, $4, $5, $6, false, true, true, false, $7, 300, 300, true, $8, $8)
+		ON CONFLICT (client_id) DO UPDATE SET
+			client_secret = EXCLUDED.client_secret, redirect_uris = EXCLUDED.redirect_uris, scopes = EXCLUDED.scopes,
+			is_trusted = true, is_active = true, updated_at = EXCLUDED.updated_at`,
+		s.clientID, string(secretHash), pq.Array([]string{canaryRedirectURI}),
+		pq.Array([]string{"openid", "profile", "read"}), pq.Array([]string{"authorization_code", "refresh_token"}),
+		pq.Array([]string{"code"}), s.userID, now); err != nil {
+		return creds, fmt.Errorf("canary client: %w", err)
+	}
+	// Each run sets a new secret, which replicas must not check against the last one
+	s.as.forgetClient(ctx, s.clientID.String())
+	return creds, nil
+}
+
+// cleanup removes the codes and tokens a run left behind, even if the run timed out
+func (s *CanaryService) cleanup() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+	for _, table := range []string{"authorization_codes", "oauth_refresh_tokens", "oauth_access_tokens"} {
+		if _, err := s.as.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE client_id = $1`, s.clientID); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// pkcePair returns a code verifier and its S256 challenge
+func pkcePair() (verifier, challenge string) {
+	random := make([]byte, 32)
+	rand.Read(random)
+	verifier = base64.RawURLEncoding.EncodeToString(random)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Run runs the flow once and records it; it is the canary background job
+func (s *CanaryService) Run(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+	started := time.Now()
+	result := healthProbeResult{Status: "healthy", CheckedAt: started}
+
+	creds, err := s.ensureFixtures(ctx)
+	result.Steps = append(result.Steps, canaryStep("fixtures", started, err))
+	if err == nil {
+		result.Steps = append(result.Steps, s.flow(ctx, creds)...)
+		cleanupStarted := time.Now()
+		result.Steps = append(result.Steps, canaryStep("cleanup", cleanupStarted, s.cleanup()))
+	}
+	result.DurationMS = msSince(started)
+
+	var failed *healthProbeStep
+	for i, step := range result.Steps {
+		outcome := "ok"
+		if !step.OK {
+			outcome = "failed"
+			if failed == nil {
+				failed = &result.Steps[i]
+			}
+		}
+		canaryStepDuration.WithLabelValues(step.Name, outcome).Observe(step.DurationMS / 1000)
+	}
+	if failed != nil {
+		result.Status = "unhealthy"
+	} else {
+		canaryLastSuccess.Set(float64(time.Now().Unix()))
+	}
+	canaryRunsTotal.WithLabelValues(result.Status).Inc()
+
+	if err := s.record(result); err != nil {
+		return "", fmt.Errorf("failed to record canary run: %w", err)
+	}
+	if failed != nil {
+		return "", fmt.Errorf("canary failed at %s: %s", failed.Name, failed.Error)
+	}
+	return fmt.Sprintf("flow completed in %.0fms", result.DurationMS), nil
+}
+
+func canaryStep(name string, started time.Time, err error) healthProbeStep {
+	step := healthProbeStep{Name: name, OK: err == nil, DurationMS: msSince(started)}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	return step
+}
+
+// flow walks the canary client through the authorization-code flow over HTTP. It stops at
+// the first step that fails.
+func (s *CanaryService) flow(ctx context.Context, creds canaryCredentials) []healthProbeStep {
+	var steps []healthProbeStep
+	step := func(name string, fn func() error) bool {
+		started := time.Now()
+		err := fn()
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		steps = append(steps, canaryStep(name, started, err))
+		return err == nil
+	}
+
+	verifier, challenge := pkcePair()
+	state, nonce := randomCanarySecret(), randomCanarySecret()
+	var session, code string
+	var tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		IDToken      string `json:"id_token"`
+	}
+	clientAuth := url.Values{"client_id": {s.clientID.String()}, "client_secret": {creds.clientSecret}}
+
+	_ = step("login", func() error {
+		var response struct {
+			AccessToken string `json:"access_token"`
+		}
+		body, _ := json.Marshal(gin.H{"email": s.config.Email, "password": creds.password})
+		if err := s.call(ctx, http.MethodPost, "/api/v1/auth/login", "application/json", string(body), "", &response); err != nil {
+			return err
+		}
+		session = response.AccessToken
+		return nil
+	}) && step("authorize", func() error {
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {s.clientID.String()},
+			"redirect_uri":          {canaryRedirectURI},
+			"scope":                 {"openid profile read"},
+			"state":                 {state},
+			"nonce":                 {nonce},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.BaseURL+"/auth/authorize?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+session)
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			return fmt.Errorf("authorize returned %d without a redirect", resp.StatusCode)
+		}
+		if !strings.HasPrefix(location.String(), canaryRedirectURI) {
+			return fmt.Errorf("authorize redirected to %s instead of the client", location.Path)
+		}
+		params := location.Query()
+		if params.Get("error") != "" {
+			return fmt.Errorf("authorize returned %s: %s", params.Get("error"), params.Get("error_description"))
+		}
+		if params.Get("state") != state {
+			return fmt.Errorf("authorize returned the wrong state")
+		}
+		if code = params.Get("code"); code == "" {
+			return fmt.Errorf("authorize returned no code")
+		}
+		return nil
+	}) && step("token", func() error {
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {canaryRedirectURI}, "code_verifier": {verifier}}
+		for name, value := range clientAuth {
+			form[name] = value
+		}
+		if err := s.call(ctx, http.MethodPost, "/auth/token", "application/x-www-form-urlencoded", form.Encode(), "", &tokens); err != nil {
+			return err
+		}
+		if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+			return fmt.Errorf("token response lacks an access or refresh token")
+		}
+		return s.checkIDToken(tokens.IDToken, nonce)
+	}) && step("userinfo", func() error {
+		var response struct {
+			Sub string `json:"sub"`
+		}
+		if err := s.call(ctx, http.MethodGet, "/auth/userinfo", "", "", tokens.AccessToken, &response); err != nil {
+			return err
+		}
+		if response.Sub != s.userID.String() {
+			return fmt.Errorf("userinfo returned subject %q", response.Sub)
+		}
+		return nil
+	}) && step("refresh", func() error {
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}
+		for name, value := range clientAuth {
+			form[name] = value
+		}
+		if err := s.call(ctx, http.MethodPost, "/auth/token", "application/x-www-form-urlencoded", form.Encode(), "", &tokens); err != nil {
+			return err
+		}
+		if tokens.AccessToken == "" {
+			return fmt.Errorf("refresh returned no access token")
+		}
+		return nil
+	}) && step("revoke", func() error {
+		form := url.Values{"token": {tokens.RefreshToken}, "token_type_hint": {"refresh_token"}}
+		for name, value := range clientAuth {
+			form[name] = value
+		}
+		return s.call(ctx, http.MethodPost, "/auth/revoke", "application/x-www-form-urlencoded", form.Encode(), "", nil)
+	})
+	return steps
+}
+
+// checkIDToken verifies the ID token's signature, subject, audience and nonce
+func (s *CanaryService) checkIDToken(idToken, nonce string) error {
+	if idToken == "" {
+		return fmt.Errorf("token response lacks an ID token")
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return s.keyFor(token.Header["kid"])
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithAudience(s.clientID.String()), jwt.WithSubject(s.userID.String())); err != nil {
+		return fmt.Errorf("ID token: %w", err)
+	}
+	if claims["nonce"] != nonce {
+		return fmt.Errorf("ID token carries the wrong nonce")
+	}
+	return nil
+}
+
+// call sends one request to the service and decodes a 200 response into out
+func (s *CanaryService) call(ctx context.Context, method, path, contentType, body, bearer string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.config.BaseURL+path, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// record stores a run and drops runs beyond the configured history
+func (s *CanaryService) record(result healthProbeResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+	steps, err := json.Marshal(result.Steps)
+	if err != nil {
+		return err
+	}
+	if _, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO canary_runs (checked_at, status, duration_ms, steps) VALUES ($1, $2, $3, $4)`,
+		result.CheckedAt, result.Status, result.DurationMS, steps); err != nil {
+		return err
+	}
+	_, err = s.as.db.ExecContext(ctx, `
+		DELETE FROM canary_runs WHERE id NOT IN (SELECT id FROM canary_runs ORDER BY checked_at DESC LIMIT $1)`, s.config.History)
+	return err
+}
+
+// AdminGetCanary shows recent canary runs, newest first, with each step's latency
+func (s *CanaryService) AdminGetCanary(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return
+	}
+	limit = min(limit, s.config.History)
+
+	ctx := c.Request.Context()
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT checked_at, status, duration_ms, steps FROM canary_runs ORDER BY checked_at DESC LIMIT $1`, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load canary runs"})
+		return
+	}
+	defer rows.Close()
+	runs := []healthProbeResult{}
+	for rows.Next() {
+		var run healthProbeResult
+		var steps []byte
+		if err := rows.Scan(&run.CheckedAt, &run.Status, &run.DurationMS, &steps); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load canary runs"})
+			return
+		}
+		json.Unmarshal(steps, &run.Steps)
+		runs = append(runs, run)
+	}
+
+	var lastSuccess sql.NullTime
+	s.as.db.QueryRowContext(ctx, `SELECT MAX(checked_at) FROM canary_runs WHERE status = 'healthy'`).Scan(&lastSuccess)
+	response := gin.H{
+		"base_url":  s.config.BaseURL,
+		"interval":  s.config.Interval.String(),
+		"client_id": s.clientID,
+		"user_id":   s.userID,
+		"runs":      runs,
+	}
+	if lastSuccess.Valid {
+		response["last_success_at"] = lastSuccess.Time
+	}
+	if len(runs) > 0 {
+		response["status"] = runs[0].Status
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/suite"
+)
+
+type CanaryTestSuite struct {
+	suite.Suite
+	key *rsa.PrivateKey
+}
+
+func (suite *CanaryTestSuite) SetupSuite() {
+	gin.SetMode(gin.TestMode)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	suite.key = key
+}
+
+func (suite *CanaryTestSuite) TestConfig() {
+	config, err := DefaultCanaryConfig()
+	suite.Require().NoError(err)
+	suite.False(config.Enabled)
+	suite.Equal("http://127.0.0.1:8081", config.BaseURL)
+	suite.Equal(time.Minute, config.Interval)
+	suite.Equal(100, config.History)
+	suite.Nil(NewCanaryService(&AuthService{}, config), "the canary is off by default")
+
+	suite.T().Setenv("CANARY_BASE_URL", "https://auth.example.com/")
+	config, err = DefaultCanaryConfig()
+	suite.Require().NoError(err)
+	suite.Equal("https://auth.example.com", config.BaseURL)
+
+	suite.T().Setenv("CANARY_INTERVAL", "1s")
+	_, err = DefaultCanaryConfig()
+	suite.ErrorContains(err, "CANARY_INTERVAL", "a canary every second would load the service")
+
+	suite.T().Setenv("CANARY_INTERVAL", "1m")
+	suite.T().Setenv("CANARY_BASE_URL", "auth.example.com")
+	_, err = DefaultCanaryConfig()
+	suite.ErrorContains(err, "CANARY_BASE_URL")
+}
+
+func (suite *CanaryTestSuite) TestPKCEPair() {
+	verifier, challenge := pkcePair()
+	sum := sha256.Sum256([]byte(verifier))
+	suite.Equal(base64.RawURLEncoding.EncodeToString(sum[:]), challenge)
+	suite.Len(verifier, 43)
+	other, _ := pkcePair()
+	suite.NotEqual(verifier, other)
+}
+
+// fakeAuthServer answers the canary's requests the way the real endpoints do. breakStep makes
+// one endpoint fail.
+func (suite *CanaryTestSuite) fakeAuthServer(canary *CanaryService, creds canaryCredentials, breakStep string) *httptest.Server {
+	var challenge, nonce string
+	router := gin.New()
+	router.POST("/api/v1/auth/login", func(c *gin.Context) {
+		var req struct{ Email, Password string }
+		c.ShouldBindJSON(&req)
+		if req.Password != creds.password || breakStep == "login" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_credentials"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"access_token": "session-jwt"})
+	})
+	router.GET("/auth/authorize", func(c *gin.Context) {
+		suite.Equal("Bearer session-jwt", c.GetHeader("Authorization"))
+		suite.Equal("S256", c.Query("code_challenge_method"))
+		challenge, nonce = c.Query("code_challenge"), c.Query("nonce")
+		target := c.Query("redirect_uri") + "?" + url.Values{"code": {"the-code"}, "state": {c.Query("state")}}.Encode()
+		if breakStep == "authorize" {
+			target = c.Query("redirect_uri") + "?error=server_error&error_description=boom"
+		}
+		c.Redirect(http.StatusFound, target)
+	})
+	router.POST("/auth/token", func(c *gin.Context) {
+		suite.Equal(creds.clientSecret, c.PostForm("client_secret"))
+		switch c.PostForm("grant_type") {
+		case "authorization_code":
+			sum := sha256.Sum256([]byte(c.PostForm("code_verifier")))
+			if c.PostForm("code") != "the-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+				return
+			}
+			idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"sub": canary.userID.String(), "aud": canary.clientID.String(), "nonce": nonce,
+				"exp": time.Now().Add(time.Hour).Unix(),
+			})
+			idToken.Header["kid"] = "current"
+			signed, _ := idToken.SignedString(suite.key)
+			c.JSON(http.StatusOK, gin.H{"access_token": "access-1", "refresh_token": "refresh-1", "id_token": signed})
+		case "refresh_token":
+			suite.Equal("refresh-1", c.PostForm("refresh_token"))
+			c.JSON(http.StatusOK, gin.H{"access_token": "access-2", "refresh_token": "refresh-2"})
+		}
+	})
+	router.GET("/auth/userinfo", func(c *gin.Context) {
+		suite.Equal("Bearer access-1", c.GetHeader("Authorization"))
+		c.JSON(http.StatusOK, gin.H{"sub": canary.userID.String()})
+	})
+	router.POST("/auth/revoke", func(c *gin.Context) {
+		suite.Equal("refresh-2", c.PostForm("token"), "the rotated refresh token is revoked")
+		c.Status(http.StatusOK)
+	})
+	return httptest.NewServer(router)
+}
+
+func (suite *CanaryTestSuite) canary() *CanaryService {
+	return newCanary(&AuthService{}, CanaryConfig{Timeout: 5 * time.Second, History: 10, Email: "canary@canary.invalid"},
+		func(keyID interface{}) (*rsa.PublicKey, error) { return &suite.key.PublicKey, nil })
+}
+
+func (suite *CanaryTestSuite) TestFlow() {
+	canary := suite.canary()
+	creds := canaryCredentials{password: randomCanarySecret(), clientSecret: randomCanarySecret()}
+	server := suite.fakeAuthServer(canary, creds, "")
+	defer server.Close()
+	canary.config.BaseURL = server.URL
+
+	steps := canary.flow(context.Background(), creds)
+	var names []string
+	for _, step := range steps {
+		suite.True(step.OK, "%s: %s", step.Name, step.Error)
+		names = append(names, step.Name)
+	}
+	suite.Equal([]string{"login", "authorize", "token", "userinfo", "refresh", "revoke"}, names)
+}
+
+func (suite *CanaryTestSuite) TestFlowStopsAtTheFailingStep() {
+	canary := suite.canary()
+	creds := canaryCredentials{password: randomCanarySecret(), clientSecret: randomCanarySecret()}
+	server := suite.fakeAuthServer(canary, creds, "authorize")
+	defer server.Close()
+	canary.config.BaseURL = server.URL
+
+	steps := canary.flow(context.Background(), creds)
+	suite.Require().Len(steps, 2)
+	suite.True(steps[0].OK)
+	suite.False(steps[1].OK)
+	suite.Contains(steps[1].Error, "server_error: boom")
+}
+
+func (suite *CanaryTestSuite) TestIDTokenChecks() {
+	canary := suite.canary()
+	sign := func(claims jwt.MapClaims) string {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(suite.key)
+		suite.Require().NoError(err)
+		return signed
+	}
+	valid := jwt.MapClaims{"sub": canary.userID.String(), "aud": canary.clientID.String(), "nonce": "n"}
+	suite.NoError(canary.checkIDToken(sign(valid), "n"))
+	suite.ErrorContains(canary.checkIDToken(sign(valid), "other"), "nonce")
+	suite.Error(canary.checkIDToken(sign(jwt.MapClaims{"sub": "someone", "aud": canary.clientID.String(), "nonce": "n"}), "n"))
+	suite.Error(canary.checkIDToken(sign(jwt.MapClaims{"sub": canary.userID.String(), "aud": "other-client", "nonce": "n"}), "n"))
+	suite.ErrorContains(canary.checkIDToken("", "n"), "lacks an ID token")
+}
+
+func TestCanary(t *testing.T) {
+	suite.Run(t, new(CanaryTestSuite))
+}
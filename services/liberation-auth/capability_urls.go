@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var capabilityVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_capability_verifications_total",
+	Help: "Capability URL checks by resource servers, by outcome (allowed, malformed, expired, revoked, used_up, out_of_scope).",
+}, []string{"outcome"})
+
+// Capability permissions. Beta readers read a draft and may leave comments; nothing more.
+const (
+	capabilityRead    = "read"
+	capabilityComment = "comment"
+)
+
+var capabilityPermissions = []string{capabilityRead, capabilityComment}
+
+// capabilityResourcePattern accepts path-like identifiers such as works/123/chapters/4
+var capabilityResourcePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*(/[A-Za-z0-9_.-]+)*$`)
+
+// validCapabilityResource also refuses . and .. segments, so works/123/../../admin cannot
+// pass as something below works/123
+func validCapabilityResource(resource string) bool {
+	return len(resource) <= 256 && capabilityResourcePattern.MatchString(resource) && path.Clean(resource) == resource
+}
+
+// capabilityTokenPrefix marks capability tokens so they are not mistaken for access tokens
+const capabilityTokenPrefix = "cap_"
+
+var (
+	errCapabilityMalformed  = errors.New("malformed")
+	errCapabilityExpired    = errors.New("expired")
+	errCapabilityRevoked    = errors.New("revoked")
+	errCapabilityUsedUp     = errors.New("used_up")
+	errCapabilityOutOfScope = errors.New("out_of_scope")
+)
+
+// CapabilityConfig controls signed, expiring capability URLs that give people without an
+// account access to one resource, such as a draft shared with a beta reader
+type CapabilityConfig struct {
+	// Secret is the HMAC key for capability tokens; without it capability URLs are off.
+	// Changing it invalidates every outstanding link.
+	Secret []byte
+	// BaseURL prefixes the resource to build the link handed out
+	BaseURL          string
+	DefaultTTL       time.Duration
+	MaxTTL           time.Duration
+	MaxActivePerUser int
+}
+
+// DefaultCapabilityConfig reads CAPABILITY_SECRET, CAPABILITY_BASE_URL, CAPABILITY_DEFAULT_TTL,
+// CAPABILITY_MAX_TTL and CAPABILITY_GRANTS_PER_USER from the environment
+func DefaultCapabilityConfig() (CapabilityConfig, error) {
+	config := CapabilityConfig{
+		Secret:  []byte(getEnv("CAPABILITY_SECRET", "")),
+		BaseURL: strings.TrimSuffix(getEnv("CAPABILITY_BASE_URL", getEnv("BASE_URL", "https://ao3.example.com")), "/"),
+	}
+	if len(config.Secret) > 0 && len(config.Secret) < 32 {
+		return config, fmt.Errorf("CAPABILITY_SECRET must be at least 32 bytes")
+	}
+	var err error
+	if config.DefaultTTL, err = time.ParseDuration(getEnv("CAPABILITY_DEFAULT_TTL", "168h")); err != nil || config.DefaultTTL <= 0 {
+		return config, fmt.Errorf("CAPABILITY_DEFAULT_TTL must be a positive duration")
+	}
+	if config.MaxTTL, err = time.ParseDuration(getEnv("CAPABILITY_MAX_TTL", "720h")); err != nil || config.MaxTTL < config.DefaultTTL {
+		return config, fmt.Errorf("CAPABILITY_MAX_TTL must be a duration no shorter than CAPABILITY_DEFAULT_TTL")
+	}
+	if config.MaxActivePerUser, err = strconv.Atoi(getEnv("CAPABILITY_GRANTS_PER_USER", "50")); err != nil || config.MaxActivePerUser <= 0 {
+		return config, fmt.Errorf("CAPABILITY_GRANTS_PER_USER must be a positive integer")
+	}
+	return config, nil
+}
+
+// CapabilityGrant is a link granting access to one resource without an account
+type CapabilityGrant struct {
+	ID          uuid.UUID  `json:"id"`
+	GrantedBy   uuid.UUID  `json:"granted_by"`
+	Resource    string     `json:"resource"`
+	Permissions []string   `json:"permissions"`
+	Note        string     `json:"note,omitempty"`
+	MaxUses     *int       `json:"max_uses,omitempty"`
+	Uses        int        `json:"uses"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// CapabilityGrantEvent is one line of a grant's audit trail
+type CapabilityGrantEvent struct {
+	Event     string    `json:"event"`
+	Detail    string    `json:"detail,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CapabilityService mints and checks capability URLs
+type CapabilityService struct {
+	as     *AuthService
+	config CapabilityConfig
+}
+
+// NewCapabilityService returns nil when no CAPABILITY_SECRET is configured
+func NewCapabilityService(as *AuthService, config CapabilityConfig) *CapabilityService {
+	if len(config.Secret) == 0 {
+		return nil
+	}
+	return &CapabilityService{as: as, config: config}
+}
+
+// sign builds a token carrying the grant ID and expiry. The MAC lets forged or tampered
+// tokens be rejected without a database lookup; the grant row stays authoritative.
+func (s *CapabilityService) sign(id uuid.UUID, expiresAt time.Time) string {
+	payload := make([]byte, 24)
+	copy(payload, id[:])
+	binary.BigEndian.PutUint64(payload[16:], uint64(expiresAt.Unix()))
+	mac := hmac.New(sha256.New, s.config.Secret)
+	mac.Write(payload)
+	return capabilityTokenPrefix + base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parse checks a token's MAC and expiry and returns the grant it names
+func (s *CapabilityService) parse(token string, now time.Time) (uuid.UUID, error) {
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, capabilityTokenPrefix), ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || !strings.HasPrefix(token, capabilityTokenPrefix) || err != nil || len(payload) != 24 {
+		return uuid.Nil, errCapabilityMalformed
+	}
+	given, err := base64.RawURLEncoding.DecodeString(signature)
+	mac := hmac.New(sha256.New, s.config.Secret)
+	mac.Write(payload)
+	if err != nil || !hmac.Equal(given, mac.Sum(nil)) {
+		return uuid.Nil, errCapabilityMalformed
+	}
+	if now.Unix() >= int64(binary.BigEndian.Uint64(payload[16:])) {
+		return uuid.Nil, errCapabilityExpired
+	}
+	id, _ := uuid.FromBytes(payload[:16])
+	return id, nil
+}
+
+// link is the URL handed to the reader
+func (s *CapabilityService) link(resource, token string) string {
+	return s.config.BaseURL + "/" + resource + "?cap=" + url.QueryEscape(token)
+}
+
+const capabilityGrantColumns = `id, granted_by, resource, permissions, note, max_uses, uses, expires_at, revoked_at, last_used_at, created_at`
+
+func scanCapabilityGrant(row interface{ Scan(...interface{}) error }) (*CapabilityGrant, error) {
+	grant := &CapabilityGrant{}
+	var maxUses sql.NullInt64
+	err := row.Scan(&grant.ID, &grant.GrantedBy, &grant.Resource, pq.Array(&grant.Permissions), &grant.Note,
+		&maxUses, &grant.Uses, &grant.ExpiresAt, &grant.RevokedAt, &grant.LastUsedAt, &grant.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if maxUses.Valid {
+		uses := int(maxUses.Int64)
+		grant.MaxUses = &uses
+	}
+	return grant, nil
+}
+
+// audit appends to a grant's trail. The trail is best effort: a failed write never turns an
+// allowed request into a denied one.
+func (s *CapabilityService) audit(ctx context.Context, grantID uuid.UUID, event, detail string, r *http.Request) {
+	ip, userAgent := "", ""
+	if r != nil {
+		ip, userAgent = GetClientIP(r), r.UserAgent()
+	}
+	s.as.db.ExecContext(ctx, `
+		INSERT INTO capability_grant_events (grant_id, event, detail, ip_address, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())`, grantID, event, detail, ip, userAgent)
+}
+
+// verify checks a token against the resource and permission being requested and, when it is
+// allowed, counts the use. r is the request being authorized, for the audit trail.
+func (s *CapabilityService) verify(ctx context.Context, token, resource, permission string, r *http.Request) (*CapabilityGrant, error) {
+	id, err := s.parse(token, time.Now())
+	if err != nil {
+		capabilityVerifications.WithLabelValues(err.Error()).Inc()
+		return nil, err
+	}
+	if !validCapabilityResource(resource) {
+		capabilityVerifications.WithLabelValues(errCapabilityOutOfScope.Error()).Inc()
+		s.audit(ctx, id, "denied", errCapabilityOutOfScope.Error()+": "+permission+" "+resource, r)
+		return nil, errCapabilityOutOfScope
+	}
+
+	// Counting the use and checking it is allowed happen in one statement, so concurrent
+	// readers cannot exceed max_uses
+	grant, err := scanCapabilityGrant(s.as.db.QueryRowContext(ctx, `
+		UPDATE capability_grants SET uses = uses + 1, last_used_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW() AND (max_uses IS NULL OR uses < max_uses)
+			AND (resource = $2 OR left($2, length(resource) + 1) = resource || '/') AND $3 = ANY(permissions)
+		RETURNING `+capabilityGrantColumns, id, resource, permission))
+	if err == nil {
+		capabilityVerifications.WithLabelValues("allowed").Inc()
+		s.audit(ctx, id, "used", permission+" "+resource, r)
+		return grant, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// Work out why, for the audit trail and the metric
+	grant, err = scanCapabilityGrant(s.as.db.QueryRowContext(ctx, `SELECT `+capabilityGrantColumns+` FROM capability_grants WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		capabilityVerifications.WithLabelValues(errCapabilityRevoked.Error()).Inc()
+		return nil, errCapabilityRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	reason := errCapabilityOutOfScope
+	switch {
+	case grant.RevokedAt != nil:
+		reason = errCapabilityRevoked
+	case !grant.ExpiresAt.After(time.Now()):
+		reason = errCapabilityExpired
+	case grant.MaxUses != nil && grant.Uses >= *grant.MaxUses:
+		reason = errCapabilityUsedUp
+	}
+	capabilityVerifications.WithLabelValues(reason.Error()).Inc()
+	s.audit(ctx, id, "denied", reason.Error()+": "+permission+" "+resource, r)
+	return nil, reason
+}
+
+// CreateGrant mints a capability URL for a resource. liberation-auth does not know who owns
+// what, so the grant records its grantor and resource servers check the grantor still has
+// access to the resource when they accept it.
+func (s *CapabilityService) CreateGrant(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req struct {
+		Resource       string   `json:"resource" binding:"required"`
+		Permissions    []string `json:"permissions"`
+		ExpiresInHours int      `json:"expires_in_hours"`
+		MaxUses        *int     `json:"max_uses"`
+		Note           string   `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	if !validCapabilityResource(req.Resource) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "resource must be a path such as works/123"})
+		return
+	}
+	if len(req.Permissions) == 0 {
+		req.Permissions = []string{capabilityRead}
+	}
+	for _, permission := range req.Permissions {
+		if !contains(capabilityPermissions, permission) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "permissions must be read or comment"})
+			return
+		}
+	}
+	if req.MaxUses != nil && *req.MaxUses <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "max_uses must be positive"})
+		return
+	}
+	ttl := s.config.DefaultTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if ttl > s.config.MaxTTL {
+		ttl = s.config.MaxTTL
+	}
+
+	var active int
+	err := s.as.db.QueryRowContext(c.Request.Context(), `
+		SELECT COUNT(*) FROM capability_grants
+		WHERE granted_by = $1 AND revoked_at IS NULL AND expires_at > NOW()`, userID).Scan(&active)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create capability URL"})
+		return
+	}
+	if active >= s.config.MaxActivePerUser {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":             "too_many_grants",
+			"error_description": fmt.Sprintf("You can have at most %d active capability URLs; revoke one first", s.config.MaxActivePerUser),
+		})
+		return
+	}
+
+	id := uuid.New()
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	grant, err := scanCapabilityGrant(s.as.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO capability_grants (id, granted_by, resource, permissions, note, max_uses, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		RETURNING `+capabilityGrantColumns, id, userID, req.Resource, pq.Array(req.Permissions), req.Note, req.MaxUses, expiresAt))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create capability URL"})
+		return
+	}
+	s.audit(c.Request.Context(), id, "created", strings.Join(req.Permissions, " ")+" "+req.Resource, c.Request)
+
+	token := s.sign(id, expiresAt)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, gin.H{
+		"grant": grant,
+		"token": token,
+		"url":   s.link(req.Resource, token),
+	})
+}
+
+// ListGrants returns the caller's grants, optionally for one resource
+func (s *CapabilityService) ListGrants(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `
+		SELECT `+capabilityGrantColumns+` FROM capability_grants
+		WHERE granted_by = $1 AND ($2 = '' OR resource = $2)
+		ORDER BY created_at DESC LIMIT 500`, userID, c.Query("resource"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch capability URLs"})
+		return
+	}
+	defer rows.Close()
+	grants := []*CapabilityGrant{}
+	for rows.Next() {
+		grant, err := scanCapabilityGrant(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch capability URLs"})
+			return
+		}
+		grants = append(grants, grant)
+	}
+	c.JSON(http.StatusOK, gin.H{"grants": grants})
+}
+
+// loadOwnedGrant fetches the :grant_id grant if the caller granted it
+func (s *CapabilityService) loadOwnedGrant(c *gin.Context) (*CapabilityGrant, bool) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	grantID, err := uuid.Parse(c.Param("grant_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return nil, false
+	}
+	grant, err := scanCapabilityGrant(s.as.db.QueryRowContext(c.Request.Context(), `
+		SELECT `+capabilityGrantColumns+` FROM capability_grants WHERE id = $1 AND granted_by = $2`, grantID, userID))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Capability URL not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch capability URL"})
+		return nil, false
+	}
+	return grant, true
+}
+
+// RevokeGrant stops a capability URL working straight away
+func (s *CapabilityService) RevokeGrant(c *gin.Context) {
+	grant, ok := s.loadOwnedGrant(c)
+	if !ok {
+		return
+	}
+	result, err := s.as.db.ExecContext(c.Request.Context(), `UPDATE capability_grants SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, grant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke capability URL"})
+		return
+	}
+	if revoked, _ := result.RowsAffected(); revoked > 0 {
+		s.audit(c.Request.Context(), grant.ID, "revoked", "", c.Request)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "capability URL revoked"})
+}
+
+// GetGrantAudit returns a grant with its audit trail, newest first
+func (s *CapabilityService) GetGrantAudit(c *gin.Context) {
+	grant, ok := s.loadOwnedGrant(c)
+	if !ok {
+		return
+	}
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `
+		SELECT event, detail, ip_address, user_agent, created_at FROM capability_grant_events
+		WHERE grant_id = $1 ORDER BY created_at DESC LIMIT 500`, grant.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit trail"})
+		return
+	}
+	defer rows.Close()
+	events := []CapabilityGrantEvent{}
+	for rows.Next() {
+		var event CapabilityGrantEvent
+		if err := rows.Scan(&event.Event, &event.Detail, &event.IPAddress, &event.UserAgent, &event.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit trail"})
+			return
+		}
+		events = append(events, event)
+	}
+	c.JSON(http.StatusOK, gin.H{"grant": grant, "events": events})
+}
+
+// Verify is the introspection endpoint for capability tokens. Resource servers authenticate
+// as an OAuth client and ask whether a token allows a permission on a resource; an inactive
+// answer does not say why.
+func (s *CapabilityService) Verify(c *gin.Context) {
+	var req struct {
+		ClientID     string `json:"client_id" form:"client_id"`
+		ClientSecret string `json:"client_secret" form:"client_secret"`
+		Token        string `json:"token" form:"token" binding:"required"`
+		Resource     string `json:"resource" form:"resource" binding:"required"`
+		Permission   string `json:"permission" form:"permission"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token and resource are required"})
+		return
+	}
+	if _, err := s.as.authenticateClient(req.ClientID, req.ClientSecret, c.Request); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client", "error_description": "Client authentication failed"})
+		return
+	}
+	if req.Permission == "" {
+		req.Permission = capabilityRead
+	}
+
+	c.Header("Cache-Control", "no-store")
+	grant, err := s.verify(c.Request.Context(), req.Token, req.Resource, req.Permission, c.Request)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"active":      true,
+		"grant_id":    grant.ID,
+		"granted_by":  grant.GrantedBy,
+		"resource":    grant.Resource,
+		"permissions": grant.Permissions,
+		"exp":         grant.ExpiresAt.Unix(),
+	})
+}
+
+// capabilityPermissionFor maps a request method to the permission it needs
+func capabilityPermissionFor(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return capabilityRead
+	case http.MethodPost:
+		return capabilityComment
+	}
+	return ""
+}
+
+// ForwardCapability answers a reverse proxy's subrequest, so a resource server behind Traefik
+// or nginx accepts capability URLs without code of its own. The resource is the path of the original URL and the
+// permission follows X-Forwarded-Method; 200 carries the grant in X-Capability-* headers.
+func (s *CapabilityService) ForwardCapability(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	original := forwardedURL(c.Request)
+	if original == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "X-Forwarded-Host or X-Original-URL is required"})
+		return
+	}
+	method := c.GetHeader("X-Forwarded-Method")
+	if method == "" {
+		method = c.GetHeader("X-Original-Method")
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	token := c.GetHeader("X-Capability")
+	if token == "" {
+		token = original.Query().Get("cap")
+	}
+	permission := capabilityPermissionFor(method)
+	if token == "" || permission == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "capability_required"})
+		return
+	}
+
+	grant, err := s.verify(c.Request.Context(), token, strings.Trim(original.Path, "/"), permission, c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_capability"})
+		return
+	}
+	c.Header("X-Capability-Grant", grant.ID.String())
+	c.Header("X-Capability-Granted-By", grant.GrantedBy.String())
+	c.Header("X-Capability-Permissions", strings.Join(grant.Permissions, ","))
+	c.Status(http.StatusOK)
+}
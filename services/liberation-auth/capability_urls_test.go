@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type CapabilityURLTestSuite struct {
+	suite.Suite
+	service *CapabilityService
+}
+
+func (suite *CapabilityURLTestSuite) SetupTest() {
+	suite.service = NewCapabilityService(&AuthService{}, CapabilityConfig{
+		Secret:  []byte(strings.Repeat("k", 32)),
+		BaseURL: "https://ao3.example.com",
+	})
+}
+
+func (suite *CapabilityURLTestSuite) TestTokenRoundTrip() {
+	id := uuid.New()
+	token := suite.service.sign(id, time.Now().Add(time.Hour))
+	suite.True(strings.HasPrefix(token, capabilityTokenPrefix))
+
+	parsed, err := suite.service.parse(token, time.Now())
+	suite.Require().NoError(err)
+	suite.Equal(id, parsed)
+
+	suite.Equal("https://ao3.example.com/works/123?cap="+token, suite.service.link("works/123", token))
+}
+
+func (suite *CapabilityURLTestSuite) TestExpiredTokensAreRejected() {
+	token := suite.service.sign(uuid.New(), time.Now().Add(time.Minute))
+	_, err := suite.service.parse(token, time.Now().Add(2*time.Minute))
+	suite.ErrorIs(err, errCapabilityExpired)
+}
+
+func (suite *CapabilityURLTestSuite) TestForgedTokensAreRejected() {
+	token := suite.service.sign(uuid.New(), time.Now().Add(time.Hour))
+	payload, signature, _ := strings.Cut(strings.TrimPrefix(token, capabilityTokenPrefix), ".")
+
+	// A payload with a later expiry, under the original signature
+	extended := suite.service.sign(uuid.New(), time.Now().Add(1000*time.Hour))
+	extendedPayload, _, _ := strings.Cut(strings.TrimPrefix(extended, capabilityTokenPrefix), ".")
+
+	other := NewCapabilityService(&AuthService{}, CapabilityConfig{Secret: []byte(strings.Repeat("o", 32))})
+	for _, forged := range []string{
+		"",
+		"cap_",
+		strings.TrimPrefix(token, capabilityTokenPrefix),
+		capabilityTokenPrefix + extendedPayload + "." + signature,
+		capabilityTokenPrefix + payload + "." + signature[1:],
+		other.sign(uuid.New(), time.Now().Add(time.Hour)),
+	} {
+		_, err := suite.service.parse(forged, time.Now())
+		suite.ErrorIs(err, errCapabilityMalformed, forged)
+	}
+}
+
+func (suite *CapabilityURLTestSuite) TestForwardRejectsMissingOrBadTokensWithoutDatabase() {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Any("/auth/capabilities/forward", suite.service.ForwardCapability)
+
+	for _, headers := range []map[string]string{
+		{"X-Forwarded-Host": "drafts.example.com", "X-Forwarded-Uri": "/works/123"},
+		{"X-Forwarded-Host": "drafts.example.com", "X-Forwarded-Uri": "/works/123?cap=cap_bogus.sig"},
+		{"X-Forwarded-Host": "drafts.example.com", "X-Forwarded-Uri": "/works/123", "X-Forwarded-Method": "DELETE",
+			"X-Capability": suite.service.sign(uuid.New(), time.Now().Add(time.Hour))},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/auth/capabilities/forward", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		suite.Equal(http.StatusUnauthorized, w.Code)
+		suite.Equal("no-store", w.Header().Get("Cache-Control"))
+	}
+}
+
+func (suite *CapabilityURLTestSuite) TestPermissionsFollowTheMethod() {
+	suite.Equal(capabilityRead, capabilityPermissionFor(http.MethodGet))
+	suite.Equal(capabilityRead, capabilityPermissionFor(http.MethodHead))
+	suite.Equal(capabilityComment, capabilityPermissionFor(http.MethodPost))
+	suite.Empty(capabilityPermissionFor(http.MethodDelete))
+}
+
+func (suite *CapabilityURLTestSuite) TestResourceIdentifiers() {
+	for _, resource := range []string{"works/123", "works/123/chapters/4", "series/9", "works/123/cover.v2.png"} {
+		suite.True(validCapabilityResource(resource), resource)
+	}
+	for _, resource := range []string{"", "/works/123", "works/123/", "works/123/../../admin", "works/./123", "works/1 2", strings.Repeat("a", 257)} {
+		suite.False(validCapabilityResource(resource), resource)
+	}
+}
+
+func (suite *CapabilityURLTestSuite) TestConfig() {
+	suite.T().Setenv("CAPABILITY_SECRET", "")
+	config, err := DefaultCapabilityConfig()
+	suite.Require().NoError(err)
+	suite.Nil(NewCapabilityService(&AuthService{}, config))
+
+	suite.T().Setenv("CAPABILITY_SECRET", "short")
+	_, err = DefaultCapabilityConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("CAPABILITY_SECRET", strings.Repeat("s", 32))
+	suite.T().Setenv("CAPABILITY_MAX_TTL", "1h")
+	_, err = DefaultCapabilityConfig()
+	suite.Error(err)
+
+	_, err = suite.service.parse("cap_x.y", time.Now())
+	suite.ErrorIs(err, errCapabilityMalformed)
+}
+
+func TestCapabilityURLs(t *testing.T) {
+	suite.Run(t, new(CapabilityURLTestSuite))
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"nuclear-ao3/shared/models"
+)
+
+// Where a claim mapping is applied
+const (
+	claimTargetIDToken     = "id_token"
+	claimTargetUserInfo    = "userinfo"
+	claimTargetAccessToken = "access_token" // introspection responses
+)
+
+// policyClaims are the user claims a claims policy can allow or hide
+var policyClaims = []string{
+	"name", "preferred_username", "profile", "email", "email_verified", "updated_at", "locale",
+	"ao3_username", "ao3_display_name", "ao3_roles", "ao3_join_date", "ao3_work_count", "ao3_bookmark_count",
+}
+
+// firstPartyClaims are only released to first-party clients unless a policy allows them
+var firstPartyClaims = []string{"ao3_roles"}
+
+// protectedClaims carry protocol meaning and can never be set by a mapping
+var protectedClaims = []string{
+	"iss", "sub", "aud", "exp", "iat", "nbf", "auth_time", "nonce", "azp", "jti", "at_hash", "c_hash",
+	"active", "scope", "client_id", "username", "token_type",
+}
+
+// ClaimMapping adds one claim at issuance. Exactly one of From, Value or Template is set.
+type ClaimMapping struct {
+	Claim string `json:"claim"`
+	// From copies an existing claim, including one the policy does not release directly
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+	// Template is a text/template rendered with .Claims, .Scopes, .ClientID and .ClientName
+	Template string `json:"template,omitempty"`
+	// Scope restricts the mapping to tokens granted this scope
+	Scope string `json:"scope,omitempty"`
+	// Targets lists where the claim appears; empty means the ID token and userinfo
+	Targets []string `json:"targets,omitempty"`
+}
+
+// ClaimsPolicy controls which claims a client receives
+type ClaimsPolicy struct {
+	ClientID uuid.UUID `json:"client_id"`
+	// AllowedClaims lists the user claims released to the client; nil uses the default for its kind
+	AllowedClaims []string       `json:"allowed_claims"`
+	Mappings      []ClaimMapping `json:"mappings"`
+	UpdatedBy     *uuid.UUID     `json:"updated_by,omitempty"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+// claimTemplateData is what mapping templates can see. Claims are the ones left after
+// the user's consent choices, so a template cannot reveal a withheld claim.
+type claimTemplateData struct {
+	Claims     map[string]interface{}
+	Scopes     []string
+	ClientID   string
+	ClientName string
+}
+
+var claimTemplateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"has":   contains,
+}
+
+// defaultAllowedClaims is the claim set for clients without an explicit policy
+func defaultAllowedClaims(firstParty bool) []string {
+	if firstParty {
+		return policyClaims
+	}
+	var allowed []string
+	for _, claim := range policyClaims {
+		if !contains(firstPartyClaims, claim) {
+			allowed = append(allowed, claim)
+		}
+	}
+	return allowed
+}
+
+// validate checks a policy before it is stored
+func (p *ClaimsPolicy) validate() error {
+	for _, claim := range p.AllowedClaims {
+		if !contains(policyClaims, claim) {
+			return fmt.Errorf("allowed_claims: unknown claim %q", claim)
+		}
+	}
+	for i, mapping := range p.Mappings {
+		if mapping.Claim == "" {
+			return fmt.Errorf("mappings[%d]: claim is required", i)
+		}
+		if contains(protectedClaims, mapping.Claim) {
+			return fmt.Errorf("mappings[%d]: %q is a protocol claim and cannot be mapped", i, mapping.Claim)
+		}
+		sources := 0
+		for _, set := range []bool{mapping.From != "", mapping.Value != nil, mapping.Template != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("mappings[%d]: exactly one of from, value or template is required", i)
+		}
+		if mapping.Template != "" {
+			if _, err := template.New(mapping.Claim).Funcs(claimTemplateFuncs).Parse(mapping.Template); err != nil {
+				return fmt.Errorf("mappings[%d]: %w", i, err)
+			}
+		}
+		for _, target := range mapping.Targets {
+			switch target {
+			case claimTargetIDToken, claimTargetUserInfo, claimTargetAccessToken:
+			default:
+				return fmt.Errorf("mappings[%d]: unknown target %q", i, target)
+			}
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether a mapping is evaluated for a target and granted scopes
+func (m ClaimMapping) appliesTo(target string, scopes []string) bool {
+	if m.Scope != "" && !contains(scopes, m.Scope) {
+		return false
+	}
+	if len(m.Targets) == 0 {
+		return target == claimTargetIDToken || target == claimTargetUserInfo
+	}
+	return contains(m.Targets, target)
+}
+
+// evaluate returns the mapped value, or nil if the mapping yields nothing
+func (m ClaimMapping) evaluate(data claimTemplateData) (interface{}, error) {
+	switch {
+	case m.From != "":
+		return data.Claims[m.From], nil
+	case m.Template != "":
+		tmpl, err := template.New(m.Claim).Funcs(claimTemplateFuncs).Option("missingkey=zero").Parse(m.Template)
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return nil, err
+		}
+		if out.Len() == 0 {
+			return nil, nil
+		}
+		return out.String(), nil
+	default:
+		return m.Value, nil
+	}
+}
+
+// apply filters claims to the allowed set and adds the mapped ones.
+// Mappings read the claims before filtering so a policy can expose, say,
+// ao3_roles under another name without releasing the original claim.
+func (p *ClaimsPolicy) apply(target string, data claimTemplateData, allowed []string) {
+	mapped := map[string]interface{}{}
+	for _, mapping := range p.Mappings {
+		if !mapping.appliesTo(target, data.Scopes) {
+			continue
+		}
+		value, err := mapping.evaluate(data)
+		if err != nil {
+			log.Printf("claims policy for client %s: mapping %q failed: %v", data.ClientID, mapping.Claim, err)
+			continue
+		}
+		if value != nil {
+			mapped[mapping.Claim] = value
+		}
+	}
+
+	for claim := range data.Claims {
+		if contains(policyClaims, claim) && !contains(allowed, claim) {
+			delete(data.Claims, claim)
+		}
+	}
+	for claim, value := range mapped {
+		data.Claims[claim] = value
+	}
+}
+
+// getClaimsPolicy returns the stored policy for a client, or nil if it uses the defaults
+func (as *AuthService) getClaimsPolicy(ctx context.Context, clientID uuid.UUID) (*ClaimsPolicy, error) {
+	policy := &ClaimsPolicy{ClientID: clientID}
+	var allowed []string
+	var mappings []byte
+	err := as.db.QueryRowContext(ctx, `
+		SELECT allowed_claims, mappings, updated_by, updated_at
+		FROM client_claim_policies WHERE client_id = $1`, clientID).
+		Scan(pq.Array(&allowed), &mappings, &policy.UpdatedBy, &policy.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy.AllowedClaims = allowed
+	if err := json.Unmarshal(mappings, &policy.Mappings); err != nil {
+		return nil, fmt.Errorf("invalid mappings for client %s: %w", clientID, err)
+	}
+	return policy, nil
+}
+
+// applyClaimsPolicy shapes the claims issued to a client at token issuance
+func (as *AuthService) applyClaimsPolicy(ctx context.Context, clientID uuid.UUID, target string, scopes []string, claims map[string]interface{}) {
+	if as.db == nil {
+		return
+	}
+	client, err := as.getClientByID(ctx, clientID.String())
+	if err != nil {
+		return
+	}
+	policy, err := as.getClaimsPolicy(ctx, clientID)
+	if err != nil {
+		log.Printf("Failed to load claims policy for client %s: %v", clientID, err)
+	}
+	if policy == nil {
+		policy = &ClaimsPolicy{ClientID: clientID}
+	}
+
+	allowed := policy.AllowedClaims
+	if allowed == nil {
+		allowed = defaultAllowedClaims(client.IsFirstParty)
+	}
+	policy.apply(target, claimTemplateData{
+		Claims:     claims,
+		Scopes:     scopes,
+		ClientID:   clientID.String(),
+		ClientName: client.Name,
+	}, allowed)
+}
+
+// claimsMap turns a response struct into a claims map so a policy can be applied to it
+func claimsMap(response interface{}) map[string]interface{} {
+	claims := map[string]interface{}{}
+	if encoded, err := json.Marshal(response); err == nil {
+		json.Unmarshal(encoded, &claims)
+	}
+	return claims
+}
+
+// Admin API
+
+func (as *AuthService) AdminGetClaimsPolicy(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	policy, err := as.getClaimsPolicy(c.Request.Context(), client.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load claims policy"})
+		return
+	}
+	if policy == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"policy":         ClaimsPolicy{ClientID: client.ID, AllowedClaims: defaultAllowedClaims(client.IsFirstParty), Mappings: []ClaimMapping{}},
+			"default":        true,
+			"is_first_party": client.IsFirstParty,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"policy": policy, "default": false, "is_first_party": client.IsFirstParty})
+}
+
+func (as *AuthService) AdminPutClaimsPolicy(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	var policy ClaimsPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if err := policy.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if policy.Mappings == nil {
+		policy.Mappings = []ClaimMapping{}
+	}
+	mappings, err := json.Marshal(policy.Mappings)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode mappings"})
+		return
+	}
+
+	var allowed interface{}
+	if policy.AllowedClaims != nil {
+		allowed = pq.Array(policy.AllowedClaims)
+	}
+	adminID, _ := c.Get("user_id")
+	_, err = as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO client_claim_policies (client_id, allowed_claims, mappings, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (client_id)
+		DO UPDATE SET allowed_claims = $2, mappings = $3, updated_by = $4, updated_at = NOW()`,
+		client.ID, allowed, mappings, adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save claims policy"})
+		return
+	}
+
+	stored, err := as.getClaimsPolicy(c.Request.Context(), client.ID)
+	if err != nil || stored == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load claims policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"policy": stored, "default": false, "is_first_party": client.IsFirstParty})
+}
+
+func (as *AuthService) AdminDeleteClaimsPolicy(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	if _, err := as.db.ExecContext(c.Request.Context(), `DELETE FROM client_claim_policies WHERE client_id = $1`, client.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete claims policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Claims policy removed; client uses the default claims"})
+}
+
+// adminOAuthClient resolves the client a per-client policy request is about
+func (as *AuthService) adminOAuthClient(c *gin.Context) (*models.OAuthClient, bool) {
+	clientUUID, err := uuid.Parse(c.Param("client_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return nil, false
+	}
+	client, err := as.getClientByID(c.Request.Context(), clientUUID.String())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return nil, false
+	}
+	return client, true
+}
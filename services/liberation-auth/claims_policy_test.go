@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ClaimsPolicyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ClaimsPolicyTestSuite) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":          "user-1",
+		"email":        "reader@example.com",
+		"ao3_username": "reader",
+		"ao3_roles":    []string{"user", "tag_wrangler"},
+	}
+}
+
+func (suite *ClaimsPolicyTestSuite) TestThirdPartyDefaultHidesFirstPartyClaims() {
+	claims := suite.claims()
+	policy := &ClaimsPolicy{}
+	policy.apply(claimTargetIDToken, claimTemplateData{Claims: claims}, defaultAllowedClaims(false))
+
+	suite.NotContains(claims, "ao3_roles")
+	suite.Contains(claims, "ao3_username")
+	suite.Contains(claims, "sub")
+
+	claims = suite.claims()
+	policy.apply(claimTargetIDToken, claimTemplateData{Claims: claims}, defaultAllowedClaims(true))
+	suite.Contains(claims, "ao3_roles")
+}
+
+func (suite *ClaimsPolicyTestSuite) TestMappingsReadClaimsBeforeFiltering() {
+	claims := suite.claims()
+	policy := &ClaimsPolicy{Mappings: []ClaimMapping{
+		{Claim: "groups", From: "ao3_roles"},
+		{Claim: "handle", Template: `{{upper (index .Claims "ao3_username")}}@{{.ClientName}}`},
+		{Claim: "tier", Value: "beta", Scope: "write"},
+		{Claim: "api_user", Value: true, Targets: []string{claimTargetAccessToken}},
+	}}
+	policy.apply(claimTargetIDToken, claimTemplateData{Claims: claims, Scopes: []string{"openid"}, ClientName: "reader-app"},
+		[]string{"ao3_username"})
+
+	suite.NotContains(claims, "ao3_roles")
+	suite.NotContains(claims, "email")
+	suite.Equal([]string{"user", "tag_wrangler"}, claims["groups"])
+	suite.Equal("READER@reader-app", claims["handle"])
+	suite.NotContains(claims, "tier", "scope-restricted mapping")
+	suite.NotContains(claims, "api_user", "access-token-only mapping")
+}
+
+func (suite *ClaimsPolicyTestSuite) TestValidation() {
+	suite.NoError((&ClaimsPolicy{AllowedClaims: []string{"email"}, Mappings: []ClaimMapping{{Claim: "groups", From: "ao3_roles"}}}).validate())
+
+	suite.Error((&ClaimsPolicy{AllowedClaims: []string{"password"}}).validate())
+	suite.Error((&ClaimsPolicy{Mappings: []ClaimMapping{{Claim: "sub", Value: "admin"}}}).validate(), "protocol claim")
+	suite.Error((&ClaimsPolicy{Mappings: []ClaimMapping{{Claim: "groups"}}}).validate(), "no source")
+	suite.Error((&ClaimsPolicy{Mappings: []ClaimMapping{{Claim: "groups", From: "ao3_roles", Value: "x"}}}).validate(), "two sources")
+	suite.Error((&ClaimsPolicy{Mappings: []ClaimMapping{{Claim: "groups", Template: "{{.Claims"}}}).validate())
+	suite.Error((&ClaimsPolicy{Mappings: []ClaimMapping{{Claim: "groups", Value: 1, Targets: []string{"refresh_token"}}}}).validate())
+}
+
+func TestClaimsPolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(ClaimsPolicyTestSuite))
+}
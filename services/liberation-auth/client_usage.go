@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// clientUsageDay is the date format for rollup days and the from/to query parameters
+const clientUsageDay = "2006-01-02"
+
+// clientUsageBackfill is how far back the first rollup reaches
+const clientUsageBackfill = 30 * 24 * time.Hour
+
+// ClientUsage is one client's activity on one UTC day
+type ClientUsage struct {
+	Day              string `json:"day"`
+	Authorizations   int    `json:"authorizations"`
+	AuthorizingUsers int    `json:"authorizing_users"`
+	TokenGrants      int    `json:"token_grants"`
+	// ActiveTokens is the number of unexpired, unrevoked access tokens at the end of the day,
+	// or now for today
+	ActiveTokens  int `json:"active_tokens"`
+	Revocations   int `json:"revocations"`
+	TokenRequests int `json:"token_requests"`
+	TokenErrors   int `json:"token_errors"`
+	// ErrorRate is the share of token requests that failed
+	ErrorRate float64 `json:"error_rate"`
+}
+
+func errorRate(requests, failures int) float64 {
+	if requests == 0 {
+		return 0
+	}
+	return float64(failures) / float64(requests)
+}
+
+// clientUsageKey holds a day's token endpoint counters in Redis. Requests and errors leave no
+// row in the database, so they are counted here and copied into the rollup.
+func clientUsageKey(day time.Time) string {
+	return "client_usage:" + day.UTC().Format(clientUsageDay)
+}
+
+// tokenRequestClientID is the client a token request claims to be, from Basic auth or the form
+func tokenRequestClientID(r *http.Request) string {
+	if clientID, _, ok := r.BasicAuth(); ok {
+		return clientID
+	}
+	return r.PostFormValue("client_id")
+}
+
+// CountTokenRequests counts token endpoint requests and failures per client for the usage
+// rollup. Only well-formed client IDs are counted; the rollup ignores those of unknown clients.
+func (as *AuthService) CountTokenRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if as.redis == nil {
+			return
+		}
+		clientID, err := uuid.Parse(tokenRequestClientID(c.Request))
+		if err != nil {
+			return
+		}
+		key := clientUsageKey(time.Now())
+		pipe := as.redis.Pipeline()
+		pipe.HIncrBy(context.Background(), key, clientID.String()+":requests", 1)
+		if c.Writer.Status() >= http.StatusBadRequest {
+			pipe.HIncrBy(context.Background(), key, clientID.String()+":errors", 1)
+		}
+		// Kept long enough for a rollup after a few days of downtime to still find them
+		pipe.Expire(context.Background(), key, 10*24*time.Hour)
+		pipe.Exec(context.Background())
+	}
+}
+
+// RollupClientUsage recomputes the daily usage rows from the day before the newest one up to
+// today, so the last complete day is final once the job has run after midnight UTC. The first
+// run backfills clientUsageBackfill from the token tables.
+func (as *AuthService) RollupClientUsage(ctx context.Context) (string, error) {
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+
+	start := today.Add(-clientUsageBackfill)
+	var newest sql.NullTime
+	if err := as.db.QueryRowContext(ctx, `SELECT MAX(day) FROM client_usage_daily`).Scan(&newest); err != nil {
+		return "", err
+	}
+	if newest.Valid && newest.Time.AddDate(0, 0, -1).After(start) {
+		start = newest.Time.UTC().AddDate(0, 0, -1)
+	}
+
+	rows := 0
+	for day := start; !day.After(today); day = day.AddDate(0, 0, 1) {
+		written, err := as.rollupClientUsageDay(ctx, day, now)
+		if err != nil {
+			return "", fmt.Errorf("rolling up %s: %w", day.Format(clientUsageDay), err)
+		}
+		rows += written
+	}
+	return fmt.Sprintf("%d client-days from %s", rows, start.Format(clientUsageDay)), nil
+}
+
+func (as *AuthService) rollupClientUsageDay(ctx context.Context, day, now time.Time) (int, error) {
+	end := day.AddDate(0, 0, 1)
+	snapshot := end
+	if now.Before(end) {
+		snapshot = now
+	}
+
+	result, err := as.db.ExecContext(ctx, `
+		INSERT INTO client_usage_daily (client_id, day, authorizations, authorizing_users, token_grants, active_tokens, revocations, updated_at)
+		SELECT c.client_id, $1::date,
+			(SELECT COUNT(*) FROM authorization_codes a WHERE a.client_id = c.client_id AND a.created_at >= $1 AND a.created_at < $2),
+			(SELECT COUNT(DISTINCT a.user_id) FROM authorization_codes a WHERE a.client_id = c.client_id AND a.created_at >= $1 AND a.created_at < $2),
+			(SELECT COUNT(*) FROM oauth_access_tokens t WHERE t.client_id = c.client_id AND t.created_at >= $1 AND t.created_at < $2),
+			(SELECT COUNT(*) FROM oauth_access_tokens t WHERE t.client_id = c.client_id AND t.created_at < $3 AND t.expires_at > $3
+				AND (t.revoked_at IS NULL OR t.revoked_at > $3)),
+			(SELECT COUNT(*) FROM oauth_access_tokens t WHERE t.client_id = c.client_id AND t.revoked_at >= $1 AND t.revoked_at < $2),
+			NOW()
+		FROM oauth_clients c
+		WHERE c.created_at < $2
+		ON CONFLICT (client_id, day) DO UPDATE SET
+			authorizations = EXCLUDED.authorizations, authorizing_users = EXCLUDED.authorizing_users,
+			token_grants = EXCLUDED.token_grants, active_tokens = EXCLUDED.active_tokens,
+			revocations = EXCLUDED.revocations, updated_at = NOW()`, day, end, snapshot)
+	if err != nil {
+		return 0, err
+	}
+	written, _ := result.RowsAffected()
+
+	if as.redis == nil {
+		return int(written), nil
+	}
+	counters, err := as.redis.HGetAll(ctx, clientUsageKey(day)).Result()
+	if err != nil {
+		return 0, err
+	}
+	requests, failures := parseClientUsageCounters(counters)
+	for clientID, count := range requests {
+		if _, err := as.db.ExecContext(ctx, `
+			UPDATE client_usage_daily SET token_requests = $3, token_errors = $4
+			WHERE client_id = $1 AND day = $2::date`, clientID, day, count, failures[clientID]); err != nil {
+			return 0, err
+		}
+	}
+	return int(written), nil
+}
+
+// parseClientUsageCounters splits the Redis hash into requests and failures per client
+func parseClientUsageCounters(counters map[string]string) (requests, failures map[uuid.UUID]int) {
+	requests, failures = map[uuid.UUID]int{}, map[uuid.UUID]int{}
+	for field, value := range counters {
+		id, metric, ok := strings.Cut(field, ":")
+		clientID, err := uuid.Parse(id)
+		count, countErr := strconv.Atoi(value)
+		if !ok || err != nil || countErr != nil {
+			continue
+		}
+		switch metric {
+		case "requests":
+			requests[clientID] = count
+		case "errors":
+			failures[clientID] = count
+		}
+	}
+	return requests, failures
+}
+
+// parseUsageRange reads from and to (inclusive UTC days), defaulting to the last seven days
+func parseUsageRange(fromParam, toParam string, now time.Time) (time.Time, time.Time, error) {
+	to := now.UTC().Truncate(24 * time.Hour)
+	if toParam != "" {
+		parsed, err := time.Parse(clientUsageDay, toParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be a date such as 2024-05-31")
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -6)
+	if fromParam != "" {
+		parsed, err := time.Parse(clientUsageDay, fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from must be a date such as 2024-05-01")
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	if to.Sub(from) > 366*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("the range is limited to a year")
+	}
+	return from, to, nil
+}
+
+// GetClientUsage returns a client's daily usage to its owner (or an admin) for a date range.
+// ?format=csv, or Accept: text/csv, downloads it as CSV.
+func (as *AuthService) GetClientUsage(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	clientID, err := uuid.Parse(c.Param("client_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+	client, err := as.getClientByID(c.Request.Context(), clientID.String())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+	if (client.OwnerID == nil || *client.OwnerID != userID) && !as.userHasRole(c.Request.Context(), userID, "admin") {
+		// Other people's clients are indistinguishable from missing ones
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+
+	from, to, err := parseUsageRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+
+	rows, err := as.db.QueryContext(c.Request.Context(), `
+		SELECT day, authorizations, authorizing_users, token_grants, active_tokens, revocations, token_requests, token_errors
+		FROM client_usage_daily WHERE client_id = $1 AND day BETWEEN $2::date AND $3::date
+		ORDER BY day`, clientID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+	defer rows.Close()
+	usage := []ClientUsage{}
+	var totals ClientUsage
+	for rows.Next() {
+		var day time.Time
+		var u ClientUsage
+		if err := rows.Scan(&day, &u.Authorizations, &u.AuthorizingUsers, &u.TokenGrants, &u.ActiveTokens,
+			&u.Revocations, &u.TokenRequests, &u.TokenErrors); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+			return
+		}
+		u.Day = day.Format(clientUsageDay)
+		u.ErrorRate = errorRate(u.TokenRequests, u.TokenErrors)
+		usage = append(usage, u)
+		totals.Authorizations += u.Authorizations
+		totals.TokenGrants += u.TokenGrants
+		totals.Revocations += u.Revocations
+		totals.TokenRequests += u.TokenRequests
+		totals.TokenErrors += u.TokenErrors
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+
+	if c.Query("format") == "csv" || strings.Contains(c.GetHeader("Accept"), "text/csv") {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="client-usage-%s-%s-%s.csv"`,
+			clientID, from.Format(clientUsageDay), to.Format(clientUsageDay)))
+		c.Status(http.StatusOK)
+		writeClientUsageCSV(c.Writer, usage)
+		return
+	}
+
+	// Distinct users do not add up across days, so the total is left out
+	c.JSON(http.StatusOK, gin.H{
+		"client_id": clientID,
+		"from":      from.Format(clientUsageDay),
+		"to":        to.Format(clientUsageDay),
+		"days":      usage,
+		"totals": gin.H{
+			"authorizations": totals.Authorizations,
+			"token_grants":   totals.TokenGrants,
+			"revocations":    totals.Revocations,
+			"token_requests": totals.TokenRequests,
+			"token_errors":   totals.TokenErrors,
+			"error_rate":     errorRate(totals.TokenRequests, totals.TokenErrors),
+		},
+	})
+}
+
+func writeClientUsageCSV(w http.ResponseWriter, usage []ClientUsage) {
+	out := csv.NewWriter(w)
+	out.Write([]string{"day", "authorizations", "authorizing_users", "token_grants", "active_tokens", "revocations", "token_requests", "token_errors", "error_rate"})
+	for _, u := range usage {
+		out.Write([]string{
+			u.Day, strconv.Itoa(u.Authorizations), strconv.Itoa(u.AuthorizingUsers), strconv.Itoa(u.TokenGrants),
+			strconv.Itoa(u.ActiveTokens), strconv.Itoa(u.Revocations), strconv.Itoa(u.TokenRequests),
+			strconv.Itoa(u.TokenErrors), strconv.FormatFloat(u.ErrorRate, 'f', 4, 64),
+		})
+	}
+	out.Flush()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type ClientUsageTestSuite struct {
+	suite.Suite
+	redis *redis.Client
+}
+
+func (suite *ClientUsageTestSuite) SetupTest() {
+	server := miniredis.RunT(suite.T())
+	suite.redis = redis.NewClient(&redis.Options{Addr: server.Addr()})
+}
+
+func (suite *ClientUsageTestSuite) TearDownTest() {
+	suite.redis.Close()
+}
+
+func (suite *ClientUsageTestSuite) TestTokenRequestsAreCountedPerClient() {
+	gin.SetMode(gin.TestMode)
+	as := &AuthService{redis: suite.redis}
+	router := gin.New()
+	router.POST("/auth/token", as.CountTokenRequests(), func(c *gin.Context) {
+		if c.PostForm("grant_type") != "client_credentials" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{})
+	})
+
+	clientID := uuid.New()
+	post := func(form url.Values, basic bool) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if basic {
+			req.SetBasicAuth(clientID.String(), "secret")
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	post(url.Values{"grant_type": {"client_credentials"}, "client_id": {clientID.String()}}, false)
+	post(url.Values{"grant_type": {"client_credentials"}}, true)
+	post(url.Values{"grant_type": {"password"}, "client_id": {clientID.String()}}, false)
+	post(url.Values{"grant_type": {"password"}, "client_id": {"not-a-client"}}, false)
+
+	counters, err := suite.redis.HGetAll(context.Background(), clientUsageKey(time.Now())).Result()
+	suite.Require().NoError(err)
+	requests, failures := parseClientUsageCounters(counters)
+	suite.Equal(map[uuid.UUID]int{clientID: 3}, requests)
+	suite.Equal(map[uuid.UUID]int{clientID: 1}, failures)
+	suite.Positive(suite.redis.TTL(context.Background(), clientUsageKey(time.Now())).Val())
+}
+
+func (suite *ClientUsageTestSuite) TestUsageRange() {
+	now := time.Date(2024, 6, 10, 15, 0, 0, 0, time.UTC)
+	from, to, err := parseUsageRange("", "", now)
+	suite.Require().NoError(err)
+	suite.Equal("2024-06-04", from.Format(clientUsageDay))
+	suite.Equal("2024-06-10", to.Format(clientUsageDay))
+
+	from, to, err = parseUsageRange("2024-05-01", "2024-05-31", now)
+	suite.Require().NoError(err)
+	suite.Equal(30*24*time.Hour, to.Sub(from))
+
+	for _, bad := range [][2]string{{"2024-06-01", "2024-05-01"}, {"2023-01-01", "2024-06-01"}, {"June 1", ""}, {"", "tomorrow"}} {
+		_, _, err := parseUsageRange(bad[0], bad[1], now)
+		suite.Error(err, bad)
+	}
+}
+
+func (suite *ClientUsageTestSuite) TestCSVExport() {
+	w := httptest.NewRecorder()
+	writeClientUsageCSV(w, []ClientUsage{
+		{Day: "2024-06-09", Authorizations: 12, AuthorizingUsers: 9, TokenGrants: 20, ActiveTokens: 15, TokenRequests: 40, TokenErrors: 2, ErrorRate: errorRate(40, 2)},
+		{Day: "2024-06-10"},
+	})
+	suite.Equal(strings.Join([]string{
+		"day,authorizations,authorizing_users,token_grants,active_tokens,revocations,token_requests,token_errors,error_rate",
+		"2024-06-09,12,9,20,15,0,40,2,0.0500",
+		"2024-06-10,0,0,0,0,0,0,0,0.0000",
+		"",
+	}, "\n"), w.Body.String())
+}
+
+func TestClientUsage(t *testing.T) {
+	suite.Run(t, new(ClientUsageTestSuite))
+}
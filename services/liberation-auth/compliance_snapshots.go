@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"liberation-storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	compliancePrefix = "compliance/"
+	// complianceManifest lists a snapshot's files with their hashes; complianceSignature is
+	// that manifest signed as a JWS with the token signing key
+	complianceManifest  = "manifest.json"
+	complianceSignature = "manifest.jws"
+)
+
+var complianceSnapshotIDPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[0-9a-f]{16}$`)
+
+var complianceSnapshotsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_compliance_snapshots_total",
+	Help: "Compliance snapshots requested by admins, by outcome (created, failed).",
+}, []string{"outcome"})
+
+// complianceDataset is one table of a compliance snapshot. Arrays are joined with spaces, as
+// OAuth joins scopes, so the CSV and JSON files carry the same values.
+type complianceDataset struct {
+	name  string
+	query string
+}
+
+// complianceDatasets are exported in this order. Client secrets are never exported.
+var complianceDatasets = []complianceDataset{
+	{"clients", `
+		SELECT client_id, client_name, owner_id, array_to_string(redirect_uris, ' ') AS redirect_uris,
+			array_to_string(scopes, ' ') AS scopes, array_to_string(grant_types, ' ') AS grant_types,
+			is_public, is_first_party, is_trusted, is_active, created_at, updated_at
+		FROM oauth_clients ORDER BY client_id`},
+	{"consents", `
+		SELECT id, user_id, client_id, array_to_string(scopes, ' ') AS scopes,
+			array_to_string(declined_scopes, ' ') AS declined_scopes, granted_at, expires_at, is_revoked
+		FROM user_consents ORDER BY id`},
+	{"role_assignments", `SELECT user_id, role FROM user_roles ORDER BY user_id, role`},
+}
+
+// complianceTable holds a dataset's rows as text; NULLs are nil
+type complianceTable struct {
+	columns []string
+	rows    [][]*string
+}
+
+// complianceFile is a file of a snapshot as the manifest records it
+type complianceFile struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Rows        int    `json:"rows"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// complianceManifestBody describes a snapshot. CapturedAt is the start of the transaction every
+// dataset was read in, so all files show the database as it was at that instant.
+type complianceManifestBody struct {
+	SnapshotID  string           `json:"snapshot_id"`
+	Issuer      string           `json:"issuer"`
+	CapturedAt  time.Time        `json:"captured_at"`
+	Isolation   string           `json:"isolation"`
+	RequestedBy string           `json:"requested_by,omitempty"`
+	Files       []complianceFile `json:"files"`
+}
+
+// AdminCreateComplianceSnapshot exports every client, consent and role assignment from one
+// read-only repeatable-read transaction, as JSON and CSV files in storage next to a signed
+// manifest. ?format=json or ?format=csv limits the files to one format.
+func (s *FileService) AdminCreateComplianceSnapshot(c *gin.Context) {
+	formats := []string{"json", "csv"}
+	switch format := c.Query("format"); format {
+	case "":
+	case "json", "csv":
+		formats = []string{format}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "format must be json or csv"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	capturedAt, tables, err := s.readComplianceTables(ctx)
+	if err != nil {
+		complianceSnapshotsTotal.WithLabelValues("failed").Inc()
+		log.Printf("Failed to read compliance snapshot: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to read snapshot"})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	manifest := complianceManifestBody{
+		SnapshotID: capturedAt.UTC().Format("20060102T150405Z") + "-" + randomObjectID(),
+		Issuer:     s.as.jwt.issuer,
+		CapturedAt: capturedAt.UTC(),
+		Isolation:  "repeatable read",
+		Files:      []complianceFile{},
+	}
+	if adminID != nil {
+		manifest.RequestedBy = fmt.Sprint(adminID)
+	}
+	prefix := compliancePrefix + manifest.SnapshotID + "/"
+	put := func(name, contentType string, data []byte) error {
+		_, err := s.store.Put(ctx, prefix+name, bytes.NewReader(data), storage.PutOptions{
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			Metadata:    map[string]string{"snapshot-id": manifest.SnapshotID},
+		})
+		return err
+	}
+
+	for _, dataset := range complianceDatasets {
+		table := tables[dataset.name]
+		for _, format := range formats {
+			data, contentType, err := table.encode(format)
+			if err == nil {
+				err = put(dataset.name+"."+format, contentType, data)
+			}
+			if err != nil {
+				complianceSnapshotsTotal.WithLabelValues("failed").Inc()
+				log.Printf("Failed to store compliance snapshot %s: %v", manifest.SnapshotID, err)
+				c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to store snapshot"})
+				return
+			}
+			sum := sha256.Sum256(data)
+			manifest.Files = append(manifest.Files, complianceFile{
+				Name:        dataset.name + "." + format,
+				ContentType: contentType,
+				Rows:        len(table.rows),
+				Size:        int64(len(data)),
+				SHA256:      hex.EncodeToString(sum[:]),
+			})
+		}
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		complianceSnapshotsTotal.WithLabelValues("failed").Inc()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to encode manifest"})
+		return
+	}
+	privateKey, keyID := s.as.jwt.signingKey()
+	signature, err := signComplianceManifest(manifestJSON, privateKey, keyID)
+	if err == nil {
+		if err = put(complianceManifest, "application/json", manifestJSON); err == nil {
+			err = put(complianceSignature, "application/jose", []byte(signature))
+		}
+	}
+	if err != nil {
+		complianceSnapshotsTotal.WithLabelValues("failed").Inc()
+		log.Printf("Failed to store compliance manifest %s: %v", manifest.SnapshotID, err)
+		c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to store manifest"})
+		return
+	}
+	complianceSnapshotsTotal.WithLabelValues("created").Inc()
+	log.Printf("Admin %v created compliance snapshot %s", adminID, manifest.SnapshotID)
+
+	c.JSON(http.StatusCreated, s.describeComplianceSnapshot(ctx, manifest, signature))
+}
+
+// readComplianceTables reads every dataset in one read-only repeatable-read transaction and
+// returns when that transaction began
+func (s *FileService) readComplianceTables(ctx context.Context) (time.Time, map[string]*complianceTable, error) {
+	tx, err := s.as.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer tx.Rollback()
+
+	var capturedAt time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT NOW()`).Scan(&capturedAt); err != nil {
+		return time.Time{}, nil, err
+	}
+	tables := make(map[string]*complianceTable, len(complianceDatasets))
+	for _, dataset := range complianceDatasets {
+		table, err := readComplianceTable(ctx, tx, dataset.query)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("%s: %w", dataset.name, err)
+		}
+		tables[dataset.name] = table
+	}
+	return capturedAt, tables, tx.Commit()
+}
+
+func readComplianceTable(ctx context.Context, tx *sql.Tx, query string) (*complianceTable, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	table := &complianceTable{columns: columns, rows: [][]*string{}}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		targets := make([]interface{}, len(columns))
+		for i := range values {
+			targets[i] = &values[i]
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		row := make([]*string, len(columns))
+		for i, value := range values {
+			if value.Valid {
+				text := value.String
+				row[i] = &text
+			}
+		}
+		table.rows = append(table.rows, row)
+	}
+	return table, rows.Err()
+}
+
+// encode writes the table as a JSON array of objects or as CSV with a header row. NULLs are
+// null in JSON and empty in CSV.
+func (t *complianceTable) encode(format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if format == "csv" {
+		out := csv.NewWriter(&buf)
+		out.Write(t.columns)
+		for _, row := range t.rows {
+			record := make([]string, len(row))
+			for i, value := range row {
+				if value != nil {
+					record[i] = *value
+				}
+			}
+			out.Write(record)
+		}
+		out.Flush()
+		return buf.Bytes(), "text/csv; charset=utf-8", out.Error()
+	}
+
+	objects := make([]map[string]*string, 0, len(t.rows))
+	for _, row := range t.rows {
+		object := make(map[string]*string, len(row))
+		for i, value := range row {
+			object[t.columns[i]] = value
+		}
+		objects = append(objects, object)
+	}
+	data, err := json.MarshalIndent(objects, "", "  ")
+	return data, "application/json", err
+}
+
+// signComplianceManifest signs the manifest's exact bytes as a compact RS256 JWS, verifiable
+// against the JWKS like any token the service issues
+func signComplianceManifest(manifest []byte, key *rsa.PrivateKey, keyID string) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": keyID, "cty": "json"})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(manifest)
+	signature, err := jwt.SigningMethodRS256.Sign(signingInput, key)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verifyComplianceManifest checks that a JWS signs exactly these manifest bytes. keyFor finds
+// the public key of a key ID.
+func verifyComplianceManifest(manifest []byte, signature string, keyFor func(keyID interface{}) (*rsa.PublicKey, error)) error {
+	parts := strings.Split(signature, ".")
+	if len(parts) != 3 {
+		return errors.New("signature is not a compact JWS")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(rawHeader, &header) != nil || header.Alg != "RS256" {
+		return errors.New("signature header is not RS256")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !bytes.Equal(payload, manifest) {
+		return errors.New("signature does not cover this manifest")
+	}
+	rawSignature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.New("signature is not base64url")
+	}
+	key, err := keyFor(header.Kid)
+	if err != nil {
+		return err
+	}
+	return jwt.SigningMethodRS256.Verify(parts[0]+"."+parts[1], rawSignature, key)
+}
+
+// describeComplianceSnapshot is a snapshot with download links for its files and manifest
+func (s *FileService) describeComplianceSnapshot(ctx context.Context, manifest complianceManifestBody, signature string) gin.H {
+	prefix := compliancePrefix + manifest.SnapshotID + "/"
+	links := gin.H{}
+	var expiresAt time.Time
+	for _, name := range append([]string{complianceManifest, complianceSignature}, fileNames(manifest.Files)...) {
+		if url, expires, err := s.signedURL(ctx, prefix+name, s.config.ExportLinkTTL); err == nil {
+			links[name], expiresAt = url, expires
+		}
+	}
+	return gin.H{
+		"snapshot_id":    manifest.SnapshotID,
+		"manifest":       manifest,
+		"signature":      signature,
+		"download_urls":  links,
+		"urls_expire_at": expiresAt,
+	}
+}
+
+func fileNames(files []complianceFile) []string {
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return names
+}
+
+// AdminListComplianceSnapshots lists stored snapshots, newest first
+func (s *FileService) AdminListComplianceSnapshots(c *gin.Context) {
+	objects, err := s.store.List(c.Request.Context(), compliancePrefix)
+	if err != nil {
+		c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to list snapshots"})
+		return
+	}
+	snapshots := []gin.H{}
+	for _, object := range objects {
+		id, name, ok := strings.Cut(strings.TrimPrefix(object.Key, compliancePrefix), "/")
+		if !ok || name != complianceManifest {
+			continue
+		}
+		snapshots = append(snapshots, gin.H{"snapshot_id": id, "created_at": object.LastModified})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i]["snapshot_id"].(string) > snapshots[j]["snapshot_id"].(string)
+	})
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+// AdminGetComplianceSnapshot returns a snapshot with fresh download links after verifying it:
+// the manifest's signature and every file's hash are checked against what is stored now
+func (s *FileService) AdminGetComplianceSnapshot(c *gin.Context) {
+	id := c.Param("snapshot_id")
+	if !complianceSnapshotIDPattern.MatchString(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "Snapshot not found"})
+		return
+	}
+	ctx := c.Request.Context()
+	prefix := compliancePrefix + id + "/"
+	manifestJSON, err := s.readObject(ctx, prefix+complianceManifest)
+	if err != nil {
+		c.JSON(storageErrorStatus(err), gin.H{"error": "not_found", "error_description": "Snapshot not found"})
+		return
+	}
+	var manifest complianceManifestBody
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Manifest is unreadable"})
+		return
+	}
+	signature, err := s.readObject(ctx, prefix+complianceSignature)
+	if err != nil {
+		c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to read signature"})
+		return
+	}
+
+	problems := []string{}
+	if err := verifyComplianceManifest(manifestJSON, string(signature), s.as.jwt.verificationKey); err != nil {
+		problems = append(problems, "manifest: "+err.Error())
+	}
+	for _, file := range manifest.Files {
+		data, err := s.readObject(ctx, prefix+file.Name)
+		if err != nil {
+			problems = append(problems, file.Name+": "+err.Error())
+			continue
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != file.SHA256 {
+			problems = append(problems, file.Name+": sha256 does not match the manifest")
+		}
+	}
+
+	response := s.describeComplianceSnapshot(ctx, manifest, string(signature))
+	response["verified"] = len(problems) == 0
+	if len(problems) > 0 {
+		response["problems"] = problems
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *FileService) readObject(ctx context.Context, key string) ([]byte, error) {
+	body, _, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
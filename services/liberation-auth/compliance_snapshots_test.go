@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ComplianceSnapshotsTestSuite struct {
+	suite.Suite
+}
+
+func complianceText(value string) *string {
+	return &value
+}
+
+func (suite *ComplianceSnapshotsTestSuite) TestEncode() {
+	table := &complianceTable{
+		columns: []string{"user_id", "role", "note"},
+		rows: [][]*string{
+			{complianceText("u1"), complianceText("admin"), nil},
+			{complianceText("u2"), complianceText("reader, writer"), complianceText(`said "hi"`)},
+		},
+	}
+
+	data, contentType, err := table.encode("csv")
+	suite.Require().NoError(err)
+	suite.Equal("text/csv; charset=utf-8", contentType)
+	suite.Equal("user_id,role,note\nu1,admin,\nu2,\"reader, writer\",\"said \"\"hi\"\"\"\n", string(data))
+
+	data, contentType, err = table.encode("json")
+	suite.Require().NoError(err)
+	suite.Equal("application/json", contentType)
+	var objects []map[string]*string
+	suite.Require().NoError(json.Unmarshal(data, &objects))
+	suite.Len(objects, 2)
+	suite.Nil(objects[0]["note"], "NULL stays null in JSON")
+	suite.Equal("reader, writer", *objects[1]["role"])
+
+	empty := &complianceTable{columns: []string{"user_id"}, rows: [][]*string{}}
+	data, _, err = empty.encode("json")
+	suite.Require().NoError(err)
+	suite.Equal("[]", string(data))
+}
+
+func (suite *ComplianceSnapshotsTestSuite) TestManifestSignature() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	keyFor := func(keyID interface{}) (*rsa.PublicKey, error) {
+		switch keyID {
+		case "current":
+			return &key.PublicKey, nil
+		case "other":
+			return &other.PublicKey, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %v", keyID)
+	}
+
+	manifest := []byte(`{"snapshot_id": "20261017T120000Z-0123456789abcdef", "files": []}`)
+	signature, err := signComplianceManifest(manifest, key, "current")
+	suite.Require().NoError(err)
+	suite.Len(strings.Split(signature, "."), 3)
+	suite.NoError(verifyComplianceManifest(manifest, signature, keyFor))
+
+	tampered := []byte(strings.Replace(string(manifest), "[]", `[{"name": "extra.csv"}]`, 1))
+	suite.ErrorContains(verifyComplianceManifest(tampered, signature, keyFor), "does not cover")
+
+	forged, err := signComplianceManifest(manifest, other, "current")
+	suite.Require().NoError(err)
+	suite.Error(verifyComplianceManifest(manifest, forged, keyFor), "signed with a key other than the one named")
+
+	retired, err := signComplianceManifest(manifest, other, "retired")
+	suite.Require().NoError(err)
+	suite.ErrorContains(verifyComplianceManifest(manifest, retired, keyFor), "unknown signing key")
+
+	suite.Error(verifyComplianceManifest(manifest, "not-a-jws", keyFor))
+}
+
+func (suite *ComplianceSnapshotsTestSuite) TestSnapshotIDs() {
+	suite.True(complianceSnapshotIDPattern.MatchString("20261017T120000Z-" + randomObjectID()))
+	suite.False(complianceSnapshotIDPattern.MatchString("../exports/user"))
+}
+
+func TestComplianceSnapshots(t *testing.T) {
+	suite.Run(t, new(ComplianceSnapshotsTestSuite))
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Tables whose rows are versioned in config_history by the record_config_history trigger
+const (
+	historyTableClients  = "oauth_clients"
+	historyTableConsents = "user_consents"
+)
+
+// historyActorSetting is the transaction setting the trigger reads changed_by from
+const historyActorSetting = "liberation_auth.actor"
+
+// historyIgnoredFields change on every write and would make every version look different
+var historyIgnoredFields = []string{"updated_at"}
+
+// historyVersion is one recorded state of a row. Operation is INSERT, UPDATE or DELETE, or
+// SNAPSHOT for the state rows were in when history started.
+type historyVersion struct {
+	Key       string                 `json:"key"`
+	Version   int                    `json:"version"`
+	Operation string                 `json:"operation"`
+	ChangedAt time.Time              `json:"changed_at"`
+	ChangedBy *uuid.UUID             `json:"changed_by,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	// Changes lists the fields that differ from the previous version
+	Changes map[string]historyChange `json:"changes,omitempty"`
+}
+
+// historyChange is one field's value before and after a change
+type historyChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// diffHistoryRows lists the fields that differ between two states of a row
+func diffHistoryRows(before, after map[string]interface{}) map[string]historyChange {
+	changes := map[string]historyChange{}
+	for field, value := range after {
+		if contains(historyIgnoredFields, field) {
+			continue
+		}
+		if previous, ok := before[field]; !ok || !reflect.DeepEqual(previous, value) {
+			changes[field] = historyChange{From: before[field], To: value}
+		}
+	}
+	for field, previous := range before {
+		if _, ok := after[field]; !ok && !contains(historyIgnoredFields, field) {
+			changes[field] = historyChange{From: previous}
+		}
+	}
+	return changes
+}
+
+// withChanges fills in each version's changes from the one before it. versions must be in
+// ascending order for one key.
+func withChanges(versions []historyVersion) {
+	for i := range versions {
+		var previous map[string]interface{}
+		if i > 0 {
+			previous = versions[i-1].Data
+		}
+		versions[i].Changes = diffHistoryRows(previous, versions[i].Data)
+	}
+}
+
+// historyVersions loads the versions of rows in a table whose key matches, oldest first.
+// where and args narrow the query further; their placeholders start at $2.
+func (as *AuthService) historyVersions(ctx context.Context, table, where string, args ...interface{}) ([]historyVersion, error) {
+	rows, err := as.db.QueryContext(ctx, `
+		SELECT row_key, version, operation, row_data, changed_at, changed_by FROM config_history
+		WHERE table_name = $1 AND `+where+`
+		ORDER BY row_key, version`, append([]interface{}{table}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := []historyVersion{}
+	for rows.Next() {
+		var version historyVersion
+		var data []byte
+		var changedBy uuid.NullUUID
+		if err := rows.Scan(&version.Key, &version.Version, &version.Operation, &data, &version.ChangedAt, &changedBy); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &version.Data); err != nil {
+			return nil, err
+		}
+		if changedBy.Valid {
+			version.ChangedBy = &changedBy.UUID
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// historyAsOf picks the version of each key that was current at a time, leaving out rows that
+// had been deleted by then. versions must be in ascending order per key.
+func historyAsOf(versions []historyVersion, at time.Time) []historyVersion {
+	current := map[string]historyVersion{}
+	for _, version := range versions {
+		if !version.ChangedAt.After(at) {
+			current[version.Key] = version
+		}
+	}
+	result := []historyVersion{}
+	for _, version := range current {
+		if version.Operation != "DELETE" {
+			result = append(result, version)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// parseHistoryTime reads a timestamp parameter as RFC 3339
+func parseHistoryTime(value string) (time.Time, error) {
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamps are RFC 3339, like 2024-05-01T00:00:00Z")
+	}
+	return at, nil
+}
+
+// adminClientHistory loads every version of the client named in the route, answering the
+// request itself when it cannot
+func (as *AuthService) adminClientHistory(c *gin.Context) (uuid.UUID, []historyVersion, bool) {
+	clientID, err := uuid.Parse(c.Param("client_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return clientID, nil, false
+	}
+	versions, err := as.historyVersions(c.Request.Context(), historyTableClients, "row_key = $2", clientID.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load client history"})
+		return clientID, nil, false
+	}
+	if len(versions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No history for this client"})
+		return clientID, nil, false
+	}
+	return clientID, versions, true
+}
+
+// findVersion returns the version with the given number
+func findVersion(versions []historyVersion, number int) (historyVersion, bool) {
+	for _, version := range versions {
+		if version.Version == number {
+			return version, true
+		}
+	}
+	return historyVersion{}, false
+}
+
+// AdminGetClientHistory lists a client's versions, newest first, with what each one changed
+func (as *AuthService) AdminGetClientHistory(c *gin.Context) {
+	clientID, versions, ok := as.adminClientHistory(c)
+	if !ok {
+		return
+	}
+	withChanges(versions)
+	summary := make([]historyVersion, len(versions))
+	for i, version := range versions {
+		version.Data = nil
+		summary[len(versions)-1-i] = version
+	}
+	c.JSON(http.StatusOK, gin.H{"client_id": clientID, "versions": summary})
+}
+
+// AdminGetClientVersion shows a client as one version recorded it
+func (as *AuthService) AdminGetClientVersion(c *gin.Context) {
+	_, versions, ok := as.adminClientHistory(c)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+	version, found := findVersion(versions, number)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+	c.JSON(http.StatusOK, version)
+}
+
+// AdminGetClientAsOf shows a client as it was configured at ?at
+func (as *AuthService) AdminGetClientAsOf(c *gin.Context) {
+	at, err := parseHistoryTime(c.Query("at"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	_, versions, ok := as.adminClientHistory(c)
+	if !ok {
+		return
+	}
+	current := historyAsOf(versions, at)
+	if len(current) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The client did not exist at that time, or history does not go back that far", "history_starts_at": versions[0].ChangedAt})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"at": at, "version": current[0]})
+}
+
+// AdminDiffClientVersions compares two versions of a client; ?to defaults to the latest
+func (as *AuthService) AdminDiffClientVersions(c *gin.Context) {
+	_, versions, ok := as.adminClientHistory(c)
+	if !ok {
+		return
+	}
+	from, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a version number"})
+		return
+	}
+	to := versions[len(versions)-1].Version
+	if c.Query("to") != "" {
+		if to, err = strconv.Atoi(c.Query("to")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a version number"})
+			return
+		}
+	}
+	before, foundFrom := findVersion(versions, from)
+	after, foundTo := findVersion(versions, to)
+	if !foundFrom || !foundTo {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "changes": diffHistoryRows(before.Data, after.Data)})
+}
+
+// AdminRestoreClientVersion puts a client's configuration back to a recorded version. The
+// secret, owner and creation time are kept, and the restore is itself a new version.
+func (as *AuthService) AdminRestoreClientVersion(c *gin.Context) {
+	clientID, versions, ok := as.adminClientHistory(c)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+	version, found := findVersion(versions, number)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+	data, err := json.Marshal(version.Data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read version"})
+		return
+	}
+	ctx := c.Request.Context()
+	adminID, _ := c.Get("user_id")
+
+	tx, err := as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore client"})
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT set_config($1, $2, true)`, historyActorSetting, fmt.Sprint(adminID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore client"})
+		return
+	}
+	// Fields a version predates keep their current values
+	result, err := tx.ExecContext(ctx, `
+		UPDATE oauth_clients c SET
+			client_name = COALESCE(r.client_name, c.client_name),
+			description = COALESCE(r.description, c.description),
+			website = COALESCE(r.website, c.website),
+			logo_url = COALESCE(r.logo_url, c.logo_url),
+			redirect_uris = COALESCE(r.redirect_uris, c.redirect_uris),
+			scopes = COALESCE(r.scopes, c.scopes),
+			grant_types = COALESCE(r.grant_types, c.grant_types),
+			response_types = COALESCE(r.response_types, c.response_types),
+			is_public = COALESCE(r.is_public, c.is_public),
+			is_confidential = COALESCE(r.is_confidential, c.is_confidential),
+			is_trusted = COALESCE(r.is_trusted, c.is_trusted),
+			is_first_party = COALESCE(r.is_first_party, c.is_first_party),
+			access_token_ttl = COALESCE(r.access_token_ttl, c.access_token_ttl),
+			refresh_token_ttl = COALESCE(r.refresh_token_ttl, c.refresh_token_ttl),
+			is_active = COALESCE(r.is_active, c.is_active),
+			updated_at = NOW()
+		FROM jsonb_populate_record(NULL::oauth_clients, $2::jsonb) r
+		WHERE c.client_id = $1`, clientID, string(data))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore client"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The client no longer exists and cannot be restored"})
+		return
+	}
+	var restored int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(version), 0) FROM config_history WHERE table_name = $1 AND row_key = $2`,
+		historyTableClients, clientID.String()).Scan(&restored); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore client"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore client"})
+		return
+	}
+	as.publishRevocation(RevocationEvent{Type: revocationClient, ClientID: clientID.String()})
+	as.forgetClient(ctx, clientID.String())
+	c.JSON(http.StatusOK, gin.H{"client_id": clientID, "restored_version": number, "version": restored})
+}
+
+// AdminGetConsentHistory lists the versions of a user's consents, optionally for one
+// ?client_id. With ?at it shows the consents that were in force at that time instead.
+func (as *AuthService) AdminGetConsentHistory(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	// Consent versions are keyed by user and client
+	where, args := "row_key LIKE $2", []interface{}{userID.String() + "/%"}
+	if clientID := c.Query("client_id"); clientID != "" {
+		parsed, err := uuid.Parse(clientID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+			return
+		}
+		where, args = "row_key = $2", []interface{}{userID.String() + "/" + parsed.String()}
+	}
+	var at time.Time
+	if c.Query("at") != "" {
+		if at, err = parseHistoryTime(c.Query("at")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	versions, err := as.historyVersions(c.Request.Context(), historyTableConsents, where, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load consent history"})
+		return
+	}
+	if !at.IsZero() {
+		inForce := []historyVersion{}
+		for _, version := range historyAsOf(versions, at) {
+			if revoked, _ := version.Data["is_revoked"].(bool); !revoked {
+				inForce = append(inForce, version)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"user_id": userID, "at": at, "consents": inForce})
+		return
+	}
+
+	byKey := map[string][]historyVersion{}
+	var keys []string
+	for _, version := range versions {
+		if _, ok := byKey[version.Key]; !ok {
+			keys = append(keys, version.Key)
+		}
+		byKey[version.Key] = append(byKey[version.Key], version)
+	}
+	all := []historyVersion{}
+	for _, key := range keys {
+		withChanges(byKey[key])
+		all = append(all, byKey[key]...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].ChangedAt.After(all[j].ChangedAt) })
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "versions": all})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigHistoryTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ConfigHistoryTestSuite) TestDiff() {
+	before := map[string]interface{}{"client_name": "Reader", "scopes": []interface{}{"read"}, "updated_at": "2024-05-01T00:00:00", "logo_url": "https://example.com/a.png"}
+	after := map[string]interface{}{"client_name": "Reader", "scopes": []interface{}{"read", "write"}, "updated_at": "2024-06-01T00:00:00", "website": "https://example.com"}
+
+	changes := diffHistoryRows(before, after)
+	suite.Equal(map[string]historyChange{
+		"scopes":   {From: []interface{}{"read"}, To: []interface{}{"read", "write"}},
+		"website":  {To: "https://example.com"},
+		"logo_url": {From: "https://example.com/a.png"},
+	}, changes, "updated_at changes on every write and is not a change")
+
+	suite.Empty(diffHistoryRows(before, before))
+	suite.Len(diffHistoryRows(nil, after), 3, "a first version changes every field")
+}
+
+func (suite *ConfigHistoryTestSuite) TestWithChanges() {
+	versions := []historyVersion{
+		{Version: 1, Data: map[string]interface{}{"is_active": true}},
+		{Version: 2, Data: map[string]interface{}{"is_active": false}},
+	}
+	withChanges(versions)
+	suite.Equal(historyChange{To: true}, versions[0].Changes["is_active"])
+	suite.Equal(historyChange{From: true, To: false}, versions[1].Changes["is_active"])
+}
+
+func (suite *ConfigHistoryTestSuite) TestAsOf() {
+	may := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	versions := []historyVersion{
+		{Key: "a", Version: 1, Operation: "INSERT", ChangedAt: may},
+		{Key: "a", Version: 2, Operation: "UPDATE", ChangedAt: may.AddDate(0, 1, 0)},
+		{Key: "b", Version: 1, Operation: "INSERT", ChangedAt: may},
+		{Key: "b", Version: 2, Operation: "DELETE", ChangedAt: may.AddDate(0, 0, 10)},
+	}
+
+	current := historyAsOf(versions, may.AddDate(0, 0, 5))
+	suite.Require().Len(current, 2)
+	suite.Equal(1, current[0].Version)
+	suite.Equal("b", current[1].Key)
+
+	current = historyAsOf(versions, may.AddDate(0, 2, 0))
+	suite.Require().Len(current, 1, "deleted rows are gone")
+	suite.Equal(2, current[0].Version)
+
+	suite.Empty(historyAsOf(versions, may.Add(-time.Second)), "nothing existed before history started")
+	suite.Len(historyAsOf(versions, may), 2, "a version is in force from the moment it was recorded")
+}
+
+func (suite *ConfigHistoryTestSuite) TestParseTime() {
+	at, err := parseHistoryTime("2024-05-01T12:00:00+02:00")
+	suite.Require().NoError(err)
+	suite.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), at.UTC())
+
+	_, err = parseHistoryTime("last month")
+	suite.Error(err)
+}
+
+func (suite *ConfigHistoryTestSuite) TestRejectsInvalidRequests() {
+	gin.SetMode(gin.TestMode)
+	as := &AuthService{}
+	r := gin.New()
+	r.GET("/admin/oauth/clients/:client_id/history", as.AdminGetClientHistory)
+	r.GET("/admin/oauth/clients/:client_id/as-of", as.AdminGetClientAsOf)
+	r.GET("/admin/users/:user_id/consent-history", as.AdminGetConsentHistory)
+
+	for _, target := range []string{
+		"/admin/oauth/clients/not-a-uuid/history",
+		"/admin/oauth/clients/9b2f8c1e-4a8d-4c3e-9f0a-6d1b2c3d4e5f/as-of?at=yesterday",
+		"/admin/users/not-a-uuid/consent-history",
+		"/admin/users/9b2f8c1e-4a8d-4c3e-9f0a-6d1b2c3d4e5f/consent-history?client_id=reader",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		suite.Equal(http.StatusBadRequest, w.Code, target)
+	}
+}
+
+func TestConfigHistoryTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigHistoryTestSuite))
+}
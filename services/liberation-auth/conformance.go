@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// conformanceNamespace derives the fixed IDs of conformance fixtures, so the
+// suite configuration stays valid across restarts and database resets
+var conformanceNamespace = uuid.MustParse("4f1d2c3b-8a6e-5b7f-9c0d-1e2f3a4b5c6d")
+
+// Conformance clients: the basic profile needs two confidential clients, the PKCE profile two public ones
+var conformanceClients = []struct {
+	Name   string
+	Public bool
+}{
+	{Name: "client"},
+	{Name: "client2"},
+	{Name: "public", Public: true},
+	{Name: "public2", Public: true},
+}
+
+// ConformanceConfig controls conformance mode, which lets the OpenID Foundation
+// conformance suite drive the OAuth2/OIDC endpoints without a browser. It signs
+// every authorization request in as a fixed test user, so it must never be
+// enabled on a real deployment.
+type ConformanceConfig struct {
+	Enabled bool
+	// KeyFile holds the RSA signing key; it is created on first start so the
+	// JWKS and key ID stay the same between runs
+	KeyFile      string
+	Username     string
+	Email        string
+	ClientSecret string
+}
+
+// DefaultConformanceConfig reads conformance settings from the environment
+func DefaultConformanceConfig() ConformanceConfig {
+	return ConformanceConfig{
+		Enabled:      getEnv("CONFORMANCE_MODE", "false") == "true",
+		KeyFile:      getEnv("CONFORMANCE_KEY_FILE", "conformance-signing-key.pem"),
+		Username:     getEnv("CONFORMANCE_USERNAME", "conformance"),
+		Email:        getEnv("CONFORMANCE_EMAIL", "conformance@example.com"),
+		ClientSecret: getEnv("CONFORMANCE_CLIENT_SECRET", "conformance-client-secret"),
+	}
+}
+
+// ConformanceService exposes the test hooks used by the conformance harness
+type ConformanceService struct {
+	as     *AuthService
+	config ConformanceConfig
+	userID uuid.UUID
+}
+
+// NewConformanceService returns nil when conformance mode is disabled
+func NewConformanceService(as *AuthService, config ConformanceConfig) (*ConformanceService, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	cs := &ConformanceService{
+		as:     as,
+		config: config,
+		userID: uuid.NewSHA1(conformanceNamespace, []byte("user")),
+	}
+	if err := cs.ensureUser(); err != nil {
+		return nil, fmt.Errorf("failed to create conformance user: %w", err)
+	}
+	return cs, nil
+}
+
+// conformanceClientID returns the fixed client ID of a conformance client
+func conformanceClientID(name string) uuid.UUID {
+	return uuid.NewSHA1(conformanceNamespace, []byte("client:"+name))
+}
+
+// loadOrCreateSigningKey reads a PEM-encoded RSA key, generating and saving one if the file does not exist
+func loadOrCreateSigningKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s does not contain a PEM block", path)
+		}
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %v", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	if err := os.WriteFile(path, encoded, 0o600); err != nil {
+		return nil, err
+	}
+	return privateKey, nil
+}
+
+func (cs *ConformanceService) ensureUser() error {
+	// The password is random; the test user only ever signs in through autoLogin
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return err
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = cs.as.db.Exec(`
+		INSERT INTO users (id, username, email, email_bidx, password_hash, display_name, is_active, is_verified, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, true, true, $7, $7)
+		ON CONFLICT (id) DO UPDATE SET is_active = true, is_verified = true, updated_at = $7`,
+		cs.userID, cs.config.Username, cs.as.pii.value(piiUserEmail, cs.config.Email), cs.as.pii.index(piiUserEmail, cs.config.Email),
+		string(passwordHash), "Conformance Tester", now)
+	return err
+}
+
+// autoLogin authenticates every request without credentials as the conformance user
+func (cs *ConformanceService) autoLogin(c *gin.Context) *uuid.UUID {
+	userID := cs.userID
+	c.Set("user_id", userID)
+	return &userID
+}
+
+// ConformanceSetupRequest lists the redirect URIs the suite will use for this run
+type ConformanceSetupRequest struct {
+	RedirectURIs []string `json:"redirect_uris" binding:"required,min=1"`
+}
+
+// Setup creates or updates the conformance clients and returns the values the suite needs.
+// Clients are trusted so the authorization endpoint skips the consent screen.
+func (cs *ConformanceService) Setup(c *gin.Context) {
+	var req ConformanceSetupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": err.Error(),
+		})
+		return
+	}
+
+	secretHash, err := bcrypt.GenerateFromPassword([]byte(cs.config.ClientSecret), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	clients := gin.H{}
+	now := time.Now()
+	for _, fixture := range conformanceClients {
+		clientID := conformanceClientID(fixture.Name)
+		secret, authMethod := string(secretHash), "client_secret_basic"
+		if fixture.Public {
+			secret, authMethod = "", "none"
+		}
+
+		_, err := cs.as.db.ExecContext(c.Request.Context(), `
+			INSERT INTO oauth_clients (
+				client_id, client_secret, client_name, description, website, logo_url,
+				redirect_uris, scopes, grant_types, response_types, is_public, is_confidential,
+				is_trusted, is_first_party, owner_id, access_token_ttl, refresh_token_ttl,
+				is_active, created_at, updated_at
+			) VALUES ($1, $2, $3, '', '', '', $4, $5, $6, $7, $8, $9, true, false, $10, 3600, 2592000, true, $11, $11)
+			ON CONFLICT (client_id) DO UPDATE SET
+				client_secret = EXCLUDED.client_secret, redirect_uris = EXCLUDED.redirect_uris,
+				is_trusted = true, is_active = true, updated_at = EXCLUDED.updated_at`,
+			clientID, secret, "Conformance "+fixture.Name,
+			pq.Array(req.RedirectURIs), pq.Array([]string{"openid", "profile", "email", "read"}),
+			pq.Array([]string{"authorization_code", "refresh_token"}), pq.Array([]string{"code"}),
+			fixture.Public, !fixture.Public, cs.userID, now)
+		if err != nil {
+			log.Printf("Failed to create conformance client %s: %v", fixture.Name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":             "server_error",
+				"error_description": "Failed to create conformance clients",
+			})
+			return
+		}
+
+		cs.as.forgetClient(c.Request.Context(), clientID.String())
+
+		client := gin.H{"client_id": clientID.String(), "token_endpoint_auth_method": authMethod}
+		if !fixture.Public {
+			client["client_secret"] = cs.config.ClientSecret
+		}
+		clients[fixture.Name] = client
+	}
+
+	_, keyID := cs.as.jwt.signingKey()
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":  getEnv("BASE_URL", "https://ao3.example.com"),
+		"kid":     keyID,
+		"user":    gin.H{"sub": cs.userID.String(), "username": cs.config.Username, "email": cs.config.Email},
+		"clients": clients,
+	})
+}
+
+// Reset removes codes, tokens and consents issued to the conformance clients so each test plan starts clean
+func (cs *ConformanceService) Reset(c *gin.Context) {
+	clientIDs := make([]string, 0, len(conformanceClients))
+	for _, fixture := range conformanceClients {
+		clientIDs = append(clientIDs, conformanceClientID(fixture.Name).String())
+	}
+
+	for _, table := range []string{"authorization_codes", "oauth_refresh_tokens", "oauth_access_tokens", "user_consents"} {
+		if _, err := cs.as.db.ExecContext(c.Request.Context(), `DELETE FROM `+table+` WHERE client_id::text = ANY($1)`, pq.Array(clientIDs)); err != nil {
+			log.Printf("Failed to reset %s: %v", table, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":             "server_error",
+				"error_description": "Failed to reset conformance state",
+			})
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
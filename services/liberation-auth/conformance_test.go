@@ -0,0 +1,275 @@
+//go:build conformance
+
+package main
+
+// Runs the OpenID Foundation conformance suite against an in-process liberation-auth.
+//
+//	CONFORMANCE_SUITE_URL=https://localhost.emobix.co.uk:8443 \
+//	CONFORMANCE_ISSUER=http://host.docker.internal:8081 \
+//	go test -tags conformance -run TestConformance -timeout 60m .
+//
+// Every test module of every profile becomes a sub-test, so a single profile can be
+// run with e.g. -run TestConformance/pkce. See OAUTH_TESTING.md for details.
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceProfile is one test plan run against the service
+type conformanceProfile struct {
+	Name     string
+	PlanName string
+	Variant  map[string]string
+	Clients  [2]string
+}
+
+var conformanceProfiles = []conformanceProfile{
+	{
+		Name:     "basic",
+		PlanName: "oidcc-basic-certification-test-plan",
+		Variant:  map[string]string{"server_metadata": "discovery", "client_registration": "static_client"},
+		Clients:  [2]string{"client", "client2"},
+	},
+	{
+		Name:     "pkce",
+		PlanName: "oidcc-test-plan",
+		Variant: map[string]string{
+			"client_auth_type": "none", "response_type": "code", "response_mode": "default",
+			"server_metadata": "discovery", "client_registration": "static_client",
+		},
+		Clients: [2]string{"public", "public2"},
+	},
+}
+
+// suiteClient talks to the conformance suite's REST API
+type suiteClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func (sc *suiteClient) do(method, path string, query url.Values, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	endpoint := sc.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+sc.token)
+	}
+
+	resp, err := sc.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s returned %d: %s", method, path, resp.StatusCode, detail)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type suitePlan struct {
+	ID      string `json:"id"`
+	Modules []struct {
+		TestModule string            `json:"testModule"`
+		Variant    map[string]string `json:"variant"`
+	} `json:"modules"`
+}
+
+type suiteTestInfo struct {
+	Status string `json:"status"`
+	Result string `json:"result"`
+}
+
+type suiteLogEntry struct {
+	Src    string `json:"src"`
+	Msg    string `json:"msg"`
+	Result string `json:"result"`
+}
+
+// conformanceSetup mirrors the response of POST /conformance/setup
+type conformanceSetup struct {
+	Issuer  string `json:"issuer"`
+	Clients map[string]struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	} `json:"clients"`
+}
+
+func TestConformance(t *testing.T) {
+	suiteURL := strings.TrimSuffix(os.Getenv("CONFORMANCE_SUITE_URL"), "/")
+	if suiteURL == "" {
+		t.Skip("CONFORMANCE_SUITE_URL not set")
+	}
+	listenAddr := getEnv("CONFORMANCE_LISTEN_ADDR", ":8081")
+	issuer := strings.TrimSuffix(getEnv("CONFORMANCE_ISSUER", "http://host.docker.internal:8081"), "/")
+	moduleTimeout, err := time.ParseDuration(getEnv("CONFORMANCE_MODULE_TIMEOUT", "5m"))
+	require.NoError(t, err, "invalid CONFORMANCE_MODULE_TIMEOUT")
+
+	// Discovery, token issuer and key material must all agree for the suite's checks
+	t.Setenv("CONFORMANCE_MODE", "true")
+	t.Setenv("BASE_URL", issuer)
+	t.Setenv("JWT_ISSUER", issuer)
+
+	authService := NewAuthService()
+	defer authService.Close()
+
+	listener, err := net.Listen("tcp", listenAddr)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: setupRouter(authService)}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+	localURL := "http://" + listener.Addr().String()
+
+	oidf := &suiteClient{
+		baseURL: suiteURL,
+		token:   os.Getenv("CONFORMANCE_TOKEN"),
+		http: &http.Client{
+			Timeout: 30 * time.Second,
+			// The suite's docker-compose setup uses a self-signed certificate
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: getEnv("CONFORMANCE_SUITE_INSECURE", "true") == "true",
+			}},
+		},
+	}
+
+	var redirectURIs []string
+	for _, profile := range conformanceProfiles {
+		redirectURIs = append(redirectURIs, fmt.Sprintf("%s/test/a/%s/callback", suiteURL, conformanceAlias(profile)))
+	}
+	var setup conformanceSetup
+	local := &suiteClient{baseURL: localURL, http: &http.Client{Timeout: 30 * time.Second}}
+	require.NoError(t, local.do(http.MethodPost, "/conformance/setup", nil, map[string]interface{}{"redirect_uris": redirectURIs}, &setup))
+
+	for _, profile := range conformanceProfiles {
+		profile := profile
+		t.Run(profile.Name, func(t *testing.T) {
+			require.NoError(t, local.do(http.MethodPost, "/conformance/reset", nil, nil, nil))
+			runConformancePlan(t, oidf, profile, setup, moduleTimeout)
+		})
+	}
+}
+
+func conformanceAlias(profile conformanceProfile) string {
+	return "liberation-auth-" + profile.Name
+}
+
+func runConformancePlan(t *testing.T, oidf *suiteClient, profile conformanceProfile, setup conformanceSetup, moduleTimeout time.Duration) {
+	alias := conformanceAlias(profile)
+	config := map[string]interface{}{
+		"alias":       alias,
+		"description": "liberation-auth " + profile.Name,
+		"server":      map[string]string{"discoveryUrl": setup.Issuer + "/.well-known/openid-configuration"},
+		// Conformance mode authenticates and consents without a page, so the suite's
+		// headless browser only has to wait for the redirect back to its callback
+		"browser": []map[string]interface{}{{
+			"match": setup.Issuer + "/auth/authorize*",
+			"tasks": []map[string]interface{}{{
+				"task":     "Verify Complete",
+				"match":    "*/test/a/" + alias + "/callback*",
+				"commands": [][]interface{}{{"wait", "id", "submission_complete", 10}},
+			}},
+		}},
+	}
+	for i, name := range profile.Clients {
+		client, ok := setup.Clients[name]
+		require.True(t, ok, "conformance client %s was not created", name)
+		key := "client"
+		if i > 0 {
+			key = "client2"
+		}
+		entry := map[string]string{"client_id": client.ClientID}
+		if client.ClientSecret != "" {
+			entry["client_secret"] = client.ClientSecret
+		}
+		config[key] = entry
+	}
+
+	variant, _ := json.Marshal(profile.Variant)
+	var plan suitePlan
+	require.NoError(t, oidf.do(http.MethodPost, "/api/plan", url.Values{
+		"planName": {profile.PlanName},
+		"variant":  {string(variant)},
+	}, config, &plan))
+	t.Logf("created plan %s: %s/plan-detail.html?plan=%s", plan.ID, oidf.baseURL, plan.ID)
+
+	for _, module := range plan.Modules {
+		module := module
+		t.Run(module.TestModule, func(t *testing.T) {
+			query := url.Values{"test": {module.TestModule}, "plan": {plan.ID}}
+			if len(module.Variant) > 0 {
+				moduleVariant, _ := json.Marshal(module.Variant)
+				query.Set("variant", string(moduleVariant))
+			}
+			var runner struct {
+				ID string `json:"id"`
+			}
+			require.NoError(t, oidf.do(http.MethodPost, "/api/runner", query, nil, &runner))
+
+			info := waitForConformanceModule(t, oidf, runner.ID, moduleTimeout)
+			switch info.Result {
+			case "PASSED", "SKIPPED":
+			case "WARNING", "REVIEW":
+				t.Logf("%s finished with %s; see %s/log-detail.html?log=%s", module.TestModule, info.Result, oidf.baseURL, runner.ID)
+			default:
+				var entries []suiteLogEntry
+				if err := oidf.do(http.MethodGet, "/api/log/"+runner.ID, nil, nil, &entries); err == nil {
+					for _, entry := range entries {
+						if entry.Result == "FAILURE" {
+							t.Errorf("%s: %s", entry.Src, entry.Msg)
+						}
+					}
+				}
+				t.Errorf("%s finished with status %s, result %s; see %s/log-detail.html?log=%s",
+					module.TestModule, info.Status, info.Result, oidf.baseURL, runner.ID)
+			}
+		})
+	}
+}
+
+func waitForConformanceModule(t *testing.T, oidf *suiteClient, testID string, timeout time.Duration) suiteTestInfo {
+	deadline := time.Now().Add(timeout)
+	for {
+		var info suiteTestInfo
+		require.NoError(t, oidf.do(http.MethodGet, "/api/info/"+testID, nil, nil, &info))
+		if info.Status == "FINISHED" || info.Status == "INTERRUPTED" {
+			return info
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("test %s still %s after %s", testID, info.Status, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
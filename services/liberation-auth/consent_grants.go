@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"nuclear-ao3/shared/models"
+)
+
+// ConsentConfig controls how long consent lasts before the user is asked again
+type ConsentConfig struct {
+	// SensitiveScopes expire after SensitiveTTL so access to them is reconfirmed periodically
+	SensitiveScopes []string
+	SensitiveTTL    time.Duration
+	// TTL applies to consent without sensitive scopes; zero keeps it until revoked
+	TTL time.Duration
+}
+
+// DefaultConsentConfig reads consent settings from the environment
+func DefaultConsentConfig() (ConsentConfig, error) {
+	config := ConsentConfig{
+		SensitiveScopes: strings.FieldsFunc(getEnv("CONSENT_SENSITIVE_SCOPES", "write,works:manage,comments:write,bookmarks:manage,collections:manage"), func(r rune) bool {
+			return r == ',' || r == ' '
+		}),
+	}
+
+	var err error
+	if config.SensitiveTTL, err = time.ParseDuration(getEnv("CONSENT_SENSITIVE_TTL", "2160h")); err != nil {
+		return config, fmt.Errorf("CONSENT_SENSITIVE_TTL: %w", err)
+	}
+	if config.TTL, err = time.ParseDuration(getEnv("CONSENT_TTL", "0")); err != nil {
+		return config, fmt.Errorf("CONSENT_TTL: %w", err)
+	}
+	return config, nil
+}
+
+// expiresAt returns when consent to scopes granted at grantedAt lapses, or nil if it does not
+func (cc ConsentConfig) expiresAt(grantedAt time.Time, scopes []string) *time.Time {
+	ttl := cc.TTL
+	for _, scope := range scopes {
+		if contains(cc.SensitiveScopes, scope) && cc.SensitiveTTL > 0 && (ttl == 0 || cc.SensitiveTTL < ttl) {
+			ttl = cc.SensitiveTTL
+			break
+		}
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	expiresAt := grantedAt.Add(ttl)
+	return &expiresAt
+}
+
+// withholdableClaims maps the claims a user may hide from a client to the
+// ID token and userinfo fields they remove
+var withholdableClaims = map[string][]string{
+	"email": {"email", "email_verified"},
+	"name":  {"name", "ao3_display_name"},
+	"roles": {"ao3_roles"},
+}
+
+// withholdableClaimNames lists the claims offered on the consent screen
+func withholdableClaimNames() []string {
+	return []string{"email", "name", "roles"}
+}
+
+// consentDecision is what the user chose on the consent screen
+type consentDecision struct {
+	Approved bool
+	// Scopes the user approved; nil approves everything requested
+	Scopes         []string
+	WithheldClaims []string
+}
+
+// consentDecisionFromForm reads the consent form: repeated or space separated
+// "scope" fields select a subset, "withhold_claim" fields (or hide_email=true) hide claims
+func consentDecisionFromForm(c *gin.Context) consentDecision {
+	decision := consentDecision{Approved: c.PostForm("approved") == "true"}
+
+	if values, ok := c.GetPostFormArray("scope"); ok {
+		decision.Scopes = []string{}
+		for _, value := range values {
+			decision.Scopes = append(decision.Scopes, strings.Fields(value)...)
+		}
+	}
+
+	for _, claim := range c.PostFormArray("withhold_claim") {
+		if _, ok := withholdableClaims[claim]; ok && !contains(decision.WithheldClaims, claim) {
+			decision.WithheldClaims = append(decision.WithheldClaims, claim)
+		}
+	}
+	if c.PostForm("hide_email") == "true" && !contains(decision.WithheldClaims, "email") {
+		decision.WithheldClaims = append(decision.WithheldClaims, "email")
+	}
+	return decision
+}
+
+// grantedScopes splits the requested scopes into granted and declined ones.
+// openid cannot be declined on its own: without it the request is not OIDC at all.
+func (d consentDecision) grantedScopes(requested []string) (granted, declined []string) {
+	for _, scope := range requested {
+		if d.Scopes == nil || contains(d.Scopes, scope) || scope == "openid" {
+			granted = append(granted, scope)
+		} else {
+			declined = append(declined, scope)
+		}
+	}
+	return granted, declined
+}
+
+// consentGrant is a user's standing consent for one client
+type consentGrant struct {
+	Scopes         []string
+	DeclinedScopes []string
+	WithheldClaims []string
+}
+
+// getConsentGrant returns the user's active consent for a client, or nil if there is none
+func (as *AuthService) getConsentGrant(ctx context.Context, userID, clientID uuid.UUID) *consentGrant {
+	if as.db == nil {
+		return nil
+	}
+
+	grant := &consentGrant{}
+	err := as.db.QueryRowContext(ctx, `
+		SELECT scopes, declined_scopes, withheld_claims FROM user_consents
+		WHERE user_id = $1 AND client_id = $2 AND is_revoked = false
+		AND (expires_at IS NULL OR expires_at > NOW())`, userID, clientID).
+		Scan(pq.Array(&grant.Scopes), pq.Array(&grant.DeclinedScopes), pq.Array(&grant.WithheldClaims))
+	if err != nil {
+		return nil
+	}
+	return grant
+}
+
+// covers reports whether the user has already decided on every requested scope
+func (g *consentGrant) covers(requested []string) bool {
+	for _, scope := range requested {
+		if !contains(g.Scopes, scope) && !contains(g.DeclinedScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// effectiveScopes drops the scopes the user declined from a request
+func (g *consentGrant) effectiveScopes(requested []string) []string {
+	var scopes []string
+	for _, scope := range requested {
+		if !contains(g.DeclinedScopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// storeUserConsent merges a consent decision into the user's standing consent for the client
+func (as *AuthService) storeUserConsent(ctx context.Context, userID, clientID uuid.UUID, granted, declined, withheldClaims []string) error {
+	scopes := append([]string{}, granted...)
+	declinedScopes := append([]string{}, declined...)
+	if existing := as.getConsentGrant(ctx, userID, clientID); existing != nil {
+		// Earlier decisions about scopes not asked for this time still stand
+		for _, scope := range existing.Scopes {
+			if !contains(scopes, scope) && !contains(declinedScopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		for _, scope := range existing.DeclinedScopes {
+			if !contains(scopes, scope) && !contains(declinedScopes, scope) {
+				declinedScopes = append(declinedScopes, scope)
+			}
+		}
+	}
+	if withheldClaims == nil {
+		withheldClaims = []string{}
+	}
+
+	// An expired record is invisible above, so re-consent starts from this decision alone
+	now := time.Now()
+	query := `
+		INSERT INTO user_consents (id, user_id, client_id, scopes, declined_scopes, withheld_claims, granted_at, expires_at, is_revoked)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, false)
+		ON CONFLICT (user_id, client_id)
+		DO UPDATE SET scopes = $4, declined_scopes = $5, withheld_claims = $6, granted_at = $7, expires_at = $8, is_revoked = false`
+
+	_, err := as.db.ExecContext(ctx, query, uuid.New(), userID, clientID, pq.Array(scopes), pq.Array(declinedScopes),
+		pq.Array(withheldClaims), now, as.consent.expiresAt(now, scopes))
+	return err
+}
+
+// withholdClaims removes the claims the user hid from this client
+func (as *AuthService) withholdClaims(ctx context.Context, userID, clientID uuid.UUID, claims map[string]interface{}) {
+	grant := as.getConsentGrant(ctx, userID, clientID)
+	if grant == nil {
+		return
+	}
+	for _, claim := range grant.WithheldClaims {
+		for _, field := range withholdableClaims[claim] {
+			delete(claims, field)
+		}
+	}
+}
+
+// withholdUserInfo clears the userinfo fields the user hid from this client
+func (as *AuthService) withholdUserInfo(ctx context.Context, userID, clientID uuid.UUID, userInfo *models.UserInfoResponse) {
+	grant := as.getConsentGrant(ctx, userID, clientID)
+	if grant == nil {
+		return
+	}
+	if contains(grant.WithheldClaims, "email") {
+		userInfo.Email = ""
+		userInfo.EmailVerified = false
+	}
+	if contains(grant.WithheldClaims, "name") {
+		userInfo.Name = ""
+		userInfo.AO3DisplayName = ""
+	}
+	if contains(grant.WithheldClaims, "roles") {
+		userInfo.AO3Roles = nil
+	}
+}
+
+// grantedTokenResponse tells clients what the user declined so they can adapt
+type grantedTokenResponse struct {
+	models.TokenResponse
+	WithheldScopes []string `json:"withheld_scopes,omitempty"`
+	WithheldClaims []string `json:"withheld_claims,omitempty"`
+}
+
+// tokenResponseWithConsent adds the scopes and claims withheld from a token to its response
+func (as *AuthService) tokenResponseWithConsent(ctx context.Context, response models.TokenResponse, userID, clientID uuid.UUID) grantedTokenResponse {
+	granted := grantedTokenResponse{TokenResponse: response}
+	if grant := as.getConsentGrant(ctx, userID, clientID); grant != nil {
+		granted.WithheldScopes = grant.DeclinedScopes
+		granted.WithheldClaims = grant.WithheldClaims
+	}
+	return granted
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type ConsentGrantTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ConsentGrantTestSuite) decisionFrom(form url.Values) consentDecision {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/auth/consent/abc", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return consentDecisionFromForm(c)
+}
+
+func (suite *ConsentGrantTestSuite) TestApprovingWithoutSelectionGrantsEverything() {
+	decision := suite.decisionFrom(url.Values{"approved": {"true"}})
+	granted, declined := decision.grantedScopes([]string{"openid", "profile", "email"})
+
+	suite.True(decision.Approved)
+	suite.Equal([]string{"openid", "profile", "email"}, granted)
+	suite.Empty(declined)
+}
+
+func (suite *ConsentGrantTestSuite) TestSelectedSubsetIsGranted() {
+	decision := suite.decisionFrom(url.Values{
+		"approved":       {"true"},
+		"scope":          {"profile read"},
+		"withhold_claim": {"roles", "unknown"},
+		"hide_email":     {"true"},
+	})
+	granted, declined := decision.grantedScopes([]string{"openid", "profile", "email", "read", "write"})
+
+	suite.Equal([]string{"openid", "profile", "read"}, granted, "openid stays granted when requested")
+	suite.Equal([]string{"email", "write"}, declined)
+	suite.Equal([]string{"roles", "email"}, decision.WithheldClaims)
+}
+
+func (suite *ConsentGrantTestSuite) TestStandingGrantCoversDeclinedScopes() {
+	grant := &consentGrant{Scopes: []string{"openid", "profile"}, DeclinedScopes: []string{"email"}}
+
+	suite.True(grant.covers([]string{"openid", "email"}), "a declined scope does not prompt again")
+	suite.False(grant.covers([]string{"openid", "write"}))
+	suite.Equal([]string{"openid", "profile"}, grant.effectiveScopes([]string{"openid", "profile", "email"}))
+}
+
+func TestConsentGrantTestSuite(t *testing.T) {
+	suite.Run(t, new(ConsentGrantTestSuite))
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSRFConfig configures signed double-submit cookie protection for browser form endpoints
+type CSRFConfig struct {
+	Secret         []byte
+	TrustedOrigins []string
+	CookieName     string
+	HeaderName     string
+	FieldName      string
+	SecureCookie   bool
+	// TrustedProxies are the reverse proxies whose X-Forwarded-Proto tells the service's own
+	// origin; the header is ignored from anyone else
+	TrustedProxies []*net.IPNet
+}
+
+// DefaultCSRFConfig reads CSRF settings from the environment.
+// CSRF_TRUSTED_ORIGINS is a comma-separated list; the service's own origin is always trusted.
+// TRUSTED_PROXIES lists the IPs or CIDRs of reverse proxies in front of the service.
+func DefaultCSRFConfig() (CSRFConfig, error) {
+	config := CSRFConfig{
+		Secret: []byte(getEnv("CSRF_SECRET", getEnv("JWT_SECRET", "your-super-secret-jwt-key-change-this-in-production"))),
+		TrustedOrigins: parseOrigins(getEnv("CSRF_TRUSTED_ORIGINS",
+			"http://localhost:3000,http://localhost:3001,https://nuclear-ao3.com,https://www.nuclear-ao3.com")),
+		CookieName:   "csrf_token",
+		HeaderName:   "X-CSRF-Token",
+		FieldName:    "csrf_token",
+		SecureCookie: getEnv("GIN_MODE", "debug") == "release",
+	}
+	proxies, err := parseNetworks(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		return config, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	config.TrustedProxies = proxies
+	return config, nil
+}
+
+// CSRFMiddleware protects cookie-authenticated form endpoints.
+// Safe methods receive a token cookie; unsafe methods must come from a trusted origin and
+// echo the cookie in the X-CSRF-Token header or csrf_token form field. Requests carrying a
+// valid bearer token are exempt because browsers never attach one automatically. Tokens are
+// bound to the browser's session, so a new one is issued when the session changes.
+func CSRFMiddleware(authService *AuthService, config CSRFConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		binding := csrfBinding(c)
+		cookieToken, _ := c.Cookie(config.CookieName)
+		hasValidCookie := cookieToken != "" && verifyCSRFToken(config.Secret, binding, cookieToken)
+
+		token := cookieToken
+		if !hasValidCookie {
+			token = generateCSRFToken(config.Secret, binding)
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(config.CookieName, token, 0, "/", "", config.SecureCookie || c.Request.TLS != nil, false)
+		}
+		c.Set("csrf_token", token)
+		c.Header(config.HeaderName, token)
+
+		if isSafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		if tokenString := extractBearerToken(c.GetHeader("Authorization")); tokenString != "" {
+			if _, err := authService.jwt.ValidateToken(tokenString); err == nil {
+				c.Next()
+				return
+			}
+		}
+
+		if !requestOriginTrusted(c.Request, config.TrustedOrigins, config.TrustedProxies) {
+			abortCSRF(c, "csrf_origin_rejected", "Request origin is not trusted")
+			return
+		}
+
+		submitted := c.GetHeader(config.HeaderName)
+		if submitted == "" {
+			submitted = c.PostForm(config.FieldName)
+		}
+		if !hasValidCookie || submitted == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(cookieToken)) != 1 {
+			abortCSRF(c, "csrf_token_invalid", "Missing or invalid CSRF token")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// TrustedOriginMiddleware rejects unsafe cross-site requests by Origin/Referer alone.
+// It guards JSON endpoints such as login that have no session to forge but can still be
+// abused to log a victim into an attacker's account.
+func TrustedOriginMiddleware(config CSRFConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isSafeMethod(c.Request.Method) && !requestOriginTrusted(c.Request, config.TrustedOrigins, config.TrustedProxies) {
+			abortCSRF(c, "csrf_origin_rejected", "Request origin is not trusted")
+			return
+		}
+		c.Next()
+	}
+}
+
+// CSRFToken returns the token issued by CSRFMiddleware for pages that submit via JavaScript
+func CSRFToken(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"csrf_token": c.GetString("csrf_token")})
+}
+
+func abortCSRF(c *gin.Context, errorCode, description string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":             errorCode,
+		"error_description": description,
+	})
+	c.Abort()
+}
+
+// requestOriginTrusted checks Origin, falling back to Referer. Requests with neither are
+// allowed through here and left to the token check.
+func requestOriginTrusted(r *http.Request, trusted []string, proxies []*net.IPNet) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return true
+		}
+		parsed, err := url.Parse(referer)
+		if err != nil || parsed.Host == "" {
+			return false
+		}
+		origin = parsed.Scheme + "://" + parsed.Host
+	}
+	if origin == "null" {
+		return false
+	}
+
+	origin = strings.TrimSuffix(strings.ToLower(origin), "/")
+	if origin == requestOrigin(r, proxies) {
+		return true
+	}
+	for _, allowed := range trusted {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// requestOrigin is the service's own origin as the request reached it. X-Forwarded-Proto is
+// only believed from a trusted proxy; anyone else could claim https for a plain-http origin.
+func requestOrigin(r *http.Request, proxies []*net.IPNet) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" && remoteAddrIn(r, proxies) {
+		scheme = strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+	}
+	return scheme + "://" + strings.ToLower(r.Host)
+}
+
+func parseOrigins(value string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// csrfBinding is what a browser's CSRF token is bound to: its session, or before sign-in the
+// parked authorization request. Both are HttpOnly cookies another site can't read.
+func csrfBinding(c *gin.Context) string {
+	if sessionID, err := c.Cookie("session_id"); err == nil && sessionID != "" {
+		return "session:" + sessionID
+	}
+	if binding, err := c.Cookie(authRequestBindingCookie); err == nil && binding != "" {
+		return "authreq:" + binding
+	}
+	return ""
+}
+
+// generateCSRFToken returns nonce.signature, with the signature covering the browser's
+// binding. A token fetched by an attacker is bound to the attacker's session, so planting
+// it as a cookie in a victim's browser, from a sibling subdomain, does not work.
+func generateCSRFToken(secret []byte, binding string) string {
+	nonce := make([]byte, 32)
+	rand.Read(nonce)
+	encoded := base64.RawURLEncoding.EncodeToString(nonce)
+	return encoded + "." + signCSRFNonce(secret, binding, encoded)
+}
+
+func verifyCSRFToken(secret []byte, binding, token string) bool {
+	nonce, signature, ok := strings.Cut(token, ".")
+	if !ok || nonce == "" {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(signCSRFNonce(secret, binding, nonce)))
+}
+
+func signCSRFNonce(secret []byte, binding, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("csrf:" + binding + ":" + nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type CSRFTestSuite struct {
+	suite.Suite
+	authService *AuthService
+	router      *gin.Engine
+}
+
+func (suite *CSRFTestSuite) SetupSuite() {
+	gin.SetMode(gin.TestMode)
+
+	jwtManager, err := NewJWTManager("test-secret", "test-issuer")
+	suite.Require().NoError(err)
+	suite.authService = &AuthService{jwt: jwtManager}
+
+	config := CSRFConfig{
+		Secret:         []byte("csrf-test-secret"),
+		TrustedOrigins: []string{"https://app.example.com"},
+		CookieName:     "csrf_token",
+		HeaderName:     "X-CSRF-Token",
+		FieldName:      "csrf_token",
+	}
+
+	suite.router = gin.New()
+	csrf := CSRFMiddleware(suite.authService, config)
+	suite.router.GET("/form", csrf, CSRFToken)
+	suite.router.POST("/form", csrf, func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	suite.router.POST("/login", TrustedOriginMiddleware(config), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+}
+
+// issueToken performs a GET and returns the CSRF cookie it set
+func (suite *CSRFTestSuite) issueToken() *http.Cookie {
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, httptest.NewRequest("GET", "/form", nil))
+	suite.Equal(http.StatusOK, w.Code)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "csrf_token" {
+			suite.False(cookie.HttpOnly, "double-submit cookie must be readable by page scripts")
+			return cookie
+		}
+	}
+	suite.FailNow("csrf cookie not issued")
+	return nil
+}
+
+func (suite *CSRFTestSuite) post(path string, cookie *http.Cookie, form url.Values, headers map[string]string) int {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func (suite *CSRFTestSuite) TestFormFieldAndHeaderAccepted() {
+	cookie := suite.issueToken()
+
+	suite.Equal(http.StatusOK, suite.post("/form", cookie, url.Values{"csrf_token": {cookie.Value}}, nil))
+	suite.Equal(http.StatusOK, suite.post("/form", cookie, nil, map[string]string{"X-CSRF-Token": cookie.Value}))
+}
+
+func (suite *CSRFTestSuite) TestMissingOrMismatchedTokenRejected() {
+	cookie := suite.issueToken()
+	other := suite.issueToken()
+
+	suite.Equal(http.StatusForbidden, suite.post("/form", cookie, nil, nil))
+	suite.Equal(http.StatusForbidden, suite.post("/form", cookie, url.Values{"csrf_token": {other.Value}}, nil))
+	suite.Equal(http.StatusForbidden, suite.post("/form", nil, url.Values{"csrf_token": {cookie.Value}}, nil))
+}
+
+func (suite *CSRFTestSuite) TestForgedCookieRejected() {
+	forged := &http.Cookie{Name: "csrf_token", Value: "attacker.planted"}
+
+	suite.Equal(http.StatusForbidden, suite.post("/form", forged, url.Values{"csrf_token": {forged.Value}}, nil))
+}
+
+func (suite *CSRFTestSuite) TestTokenBoundToSession() {
+	attacker := &http.Cookie{Name: "session_id", Value: "attacker-session"}
+	victim := &http.Cookie{Name: "session_id", Value: "victim-session"}
+
+	// A token fetched in the attacker's own session
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/form", nil)
+	req.AddCookie(attacker)
+	suite.router.ServeHTTP(w, req)
+	var planted *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "csrf_token" {
+			planted = cookie
+		}
+	}
+	suite.Require().NotNil(planted)
+
+	post := func(session *http.Cookie) int {
+		req := httptest.NewRequest("POST", "/form", strings.NewReader(url.Values{"csrf_token": {planted.Value}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(planted)
+		if session != nil {
+			req.AddCookie(session)
+		}
+		w := httptest.NewRecorder()
+		suite.router.ServeHTTP(w, req)
+		return w.Code
+	}
+	suite.Equal(http.StatusOK, post(attacker))
+	suite.Equal(http.StatusForbidden, post(victim), "a planted token is bound to the attacker's session")
+	suite.Equal(http.StatusForbidden, post(nil))
+	suite.Equal(http.StatusForbidden, suite.post("/form", suite.issueToken(), url.Values{"csrf_token": {planted.Value}}, nil))
+}
+
+func (suite *CSRFTestSuite) TestForwardedProtoOnlyFromTrustedProxies() {
+	proxies, err := parseNetworks("10.0.0.0/8")
+	suite.Require().NoError(err)
+	router := gin.New()
+	router.POST("/login", TrustedOriginMiddleware(CSRFConfig{TrustedProxies: proxies}), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	post := func(remoteAddr string) int {
+		req := httptest.NewRequest("POST", "http://auth.example.com/login", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Origin", "https://auth.example.com")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	suite.Equal(http.StatusOK, post("10.1.2.3:5000"))
+	suite.Equal(http.StatusForbidden, post("203.0.113.9:5000"), "X-Forwarded-Proto from a client is ignored")
+}
+
+func (suite *CSRFTestSuite) TestUntrustedOriginRejected() {
+	cookie := suite.issueToken()
+	form := url.Values{"csrf_token": {cookie.Value}}
+
+	suite.Equal(http.StatusForbidden, suite.post("/form", cookie, form, map[string]string{"Origin": "https://evil.example.com"}))
+	suite.Equal(http.StatusForbidden, suite.post("/form", cookie, form, map[string]string{"Origin": "null"}))
+	suite.Equal(http.StatusForbidden, suite.post("/form", cookie, form, map[string]string{"Referer": "https://evil.example.com/page"}))
+	suite.Equal(http.StatusOK, suite.post("/form", cookie, form, map[string]string{"Origin": "https://app.example.com"}))
+	suite.Equal(http.StatusOK, suite.post("/form", cookie, form, map[string]string{"Origin": "http://example.com"}))
+}
+
+func (suite *CSRFTestSuite) TestValidBearerTokenExempt() {
+	token, err := suite.authService.jwt.GenerateToken(uuid.New(), "test", []string{"read"}, time.Minute)
+	suite.Require().NoError(err)
+
+	suite.Equal(http.StatusOK, suite.post("/form", nil, nil, map[string]string{"Authorization": "Bearer " + token}))
+	suite.Equal(http.StatusForbidden, suite.post("/form", nil, nil, map[string]string{"Authorization": "Bearer not-a-jwt"}))
+}
+
+func (suite *CSRFTestSuite) TestLoginOriginCheck() {
+	suite.Equal(http.StatusOK, suite.post("/login", nil, nil, nil))
+	suite.Equal(http.StatusForbidden, suite.post("/login", nil, nil, map[string]string{"Origin": "https://evil.example.com"}))
+}
+
+func TestCSRFTestSuite(t *testing.T) {
+	suite.Run(t, new(CSRFTestSuite))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dashboardAssets are the page, script and stylesheet of the dashboard. The page holds no
+// data; it asks for an admin access token and reads GET /api/v1/auth/admin/dashboard with it.
+//
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+// DashboardConfig controls the read-only dashboard served at /dashboard/
+type DashboardConfig struct {
+	Enabled bool
+	// Events is how many of the latest security events are shown
+	Events int
+	// Refresh is how often the page reloads its data
+	Refresh time.Duration
+}
+
+// DefaultDashboardConfig reads DASHBOARD_ENABLED (false), DASHBOARD_SECURITY_EVENTS (20) and
+// DASHBOARD_REFRESH (15s)
+func DefaultDashboardConfig() (DashboardConfig, error) {
+	config := DashboardConfig{Enabled: getEnv("DASHBOARD_ENABLED", "false") == "true"}
+	var err error
+	if config.Events, err = strconv.Atoi(getEnv("DASHBOARD_SECURITY_EVENTS", "20")); err != nil || config.Events < 1 || config.Events > 500 {
+		return config, fmt.Errorf("DASHBOARD_SECURITY_EVENTS must be between 1 and 500")
+	}
+	if config.Refresh, err = time.ParseDuration(getEnv("DASHBOARD_REFRESH", "15s")); err != nil || config.Refresh < time.Second {
+		return config, fmt.Errorf("DASHBOARD_REFRESH must be a duration of at least 1s")
+	}
+	return config, nil
+}
+
+// DashboardService serves a small status page for deployments without Grafana: readiness,
+// a handful of key metrics and the latest security events. It is served next to the admin
+// API, so it follows the admin listener, and its data needs security_events:read.
+type DashboardService struct {
+	as     *AuthService
+	config DashboardConfig
+}
+
+// NewDashboardService returns nil when the dashboard is disabled
+func NewDashboardService(as *AuthService, config DashboardConfig) *DashboardService {
+	if !config.Enabled {
+		return nil
+	}
+	return &DashboardService{as: as, config: config}
+}
+
+// registerDashboardRoutes serves the dashboard page on the router serving the admin API
+func registerDashboardRoutes(routes routeGroup, authService *AuthService) {
+	if authService.dashboard != nil {
+		routes.GET("/dashboard/*file", authService.dashboard.Page)
+	}
+}
+
+// Page serves the dashboard's static files; /dashboard/ is the page itself
+func (ds *DashboardService) Page(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("file"), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	if strings.Contains(name, "..") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+		return
+	}
+	data, err := dashboardAssets.ReadFile(path.Join("dashboard", name))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, mime.TypeByExtension(path.Ext(name)), data)
+}
+
+// dashboardMetricSpec picks a metric for the dashboard, summed over every label but by
+type dashboardMetricSpec struct {
+	name  string
+	title string
+	by    string
+}
+
+// dashboardMetricSpecs are the metrics the dashboard shows; the full set is on /metrics
+var dashboardMetricSpecs = []dashboardMetricSpec{
+	{oauthRequestDurationMetric, "OAuth requests", "endpoint"},
+	{"liberation_auth_token_cache_lookups_total", "Access token validations", "result"},
+	{"liberation_auth_authorization_requests_total", "Authorization requests", "outcome"},
+	{"liberation_auth_security_events_published_total", "Security events recorded", "path"},
+	{"liberation_auth_security_event_backlog", "Security events waiting to be written", ""},
+	{"liberation_auth_password_hash_shed_total", "Password hashes refused", "reason"},
+	{"liberation_auth_requests_aborted_total", "Requests aborted", "reason"},
+	{"liberation_auth_db_pool_connections", "Database connections", "state"},
+	{"liberation_auth_health_probe_runs_total", "Deep health probe runs", "outcome"},
+	{"liberation_auth_canary_runs_total", "Canary runs", "outcome"},
+}
+
+// dashboardMetric is one metric as the dashboard shows it. Histograms count observations,
+// and add their average in seconds.
+type dashboardMetric struct {
+	Name           string             `json:"name"`
+	Title          string             `json:"title"`
+	Total          float64            `json:"total"`
+	By             map[string]float64 `json:"by,omitempty"`
+	AverageSeconds float64            `json:"average_seconds,omitempty"`
+}
+
+// dashboardMetrics reads the dashboard's metrics from a gatherer, leaving out those this
+// instance has not exported yet
+func dashboardMetrics(gatherer prometheus.Gatherer) ([]dashboardMetric, error) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]int, len(families))
+	for i, family := range families {
+		byName[family.GetName()] = i
+	}
+
+	metrics := []dashboardMetric{}
+	for _, spec := range dashboardMetricSpecs {
+		i, ok := byName[spec.name]
+		if !ok {
+			continue
+		}
+		metric := dashboardMetric{Name: spec.name, Title: spec.title}
+		if spec.by != "" {
+			metric.By = map[string]float64{}
+		}
+		var sum float64
+		histogram := false
+		for _, m := range families[i].GetMetric() {
+			var value float64
+			switch {
+			case m.GetHistogram() != nil:
+				value = float64(m.GetHistogram().GetSampleCount())
+				sum += m.GetHistogram().GetSampleSum()
+				histogram = true
+			case m.GetCounter() != nil:
+				value = m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				value = m.GetGauge().GetValue()
+			}
+			metric.Total += value
+			if spec.by == "" {
+				continue
+			}
+			for _, label := range m.GetLabel() {
+				if label.GetName() == spec.by {
+					metric.By[label.GetValue()] += value
+				}
+			}
+		}
+		if histogram && metric.Total > 0 {
+			metric.AverageSeconds = sum / metric.Total
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// AdminGetDashboard returns what the dashboard shows. A part that cannot be read is
+// reported in place, so the page still shows the rest while the database is down.
+func (ds *DashboardService) AdminGetDashboard(c *gin.Context) {
+	ctx := c.Request.Context()
+	status, checks := ds.as.readinessStatus(ctx)
+	response := gin.H{
+		"service":         "auth-service",
+		"status":          status,
+		"checks":          checks,
+		"store":           ds.as.storeInfo(),
+		"refresh_seconds": int(ds.config.Refresh.Seconds()),
+		"generated_at":    time.Now().UTC(),
+	}
+
+	if metrics, err := dashboardMetrics(prometheus.DefaultGatherer); err != nil {
+		response["metrics_error"] = err.Error()
+	} else {
+		response["metrics"] = metrics
+	}
+
+	if ds.as.db != nil {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if events, err := ds.as.listSecurityEvents(ctx, nil, "", ds.config.Events); err != nil {
+			response["security_events_error"] = "failed to list security events"
+		} else {
+			response["security_events"] = events
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,143 @@
+// The dashboard reads GET /api/v1/auth/admin/dashboard with an admin access token kept in
+// sessionStorage, and refreshes on the interval the server asks for. Values are written
+// with textContent only; security events carry user agents and other caller input.
+(function () {
+  "use strict";
+
+  var tokenKey = "liberation-auth-dashboard-token";
+  var endpoint = "/api/v1/auth/admin/dashboard";
+  var timer = null;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function cell(row, text, className) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    if (className) {
+      td.className = className;
+    }
+    row.appendChild(td);
+    return td;
+  }
+
+  function number(value) {
+    return Number(value).toLocaleString(undefined, { maximumFractionDigits: 2 });
+  }
+
+  function breakdown(by) {
+    return Object.keys(by || {}).sort().map(function (key) {
+      return key + ": " + number(by[key]);
+    }).join(", ");
+  }
+
+  function showSignIn(message) {
+    clearTimeout(timer);
+    sessionStorage.removeItem(tokenKey);
+    $("dashboard").hidden = true;
+    $("sign-out").hidden = true;
+    $("sign-in").hidden = false;
+    $("sign-in-error").textContent = message || "";
+    $("status").textContent = "signed out";
+    $("status").className = "badge";
+  }
+
+  function render(data) {
+    $("status").textContent = data.status;
+    $("status").className = "badge " + (data.status === "ready" ? "ok" : "bad");
+
+    var checks = $("checks");
+    checks.replaceChildren();
+    var entries = Object.assign({}, data.checks || {});
+    entries.store = (data.store && data.store.backend) || "";
+    Object.keys(entries).forEach(function (name) {
+      var dt = document.createElement("dt");
+      var dd = document.createElement("dd");
+      dt.textContent = name;
+      dd.textContent = entries[name];
+      checks.append(dt, dd);
+    });
+
+    var metrics = $("metrics");
+    metrics.replaceChildren();
+    if (data.metrics_error) {
+      cell(metrics.insertRow(), data.metrics_error, "error").colSpan = 3;
+    }
+    (data.metrics || []).forEach(function (metric) {
+      var row = metrics.insertRow();
+      cell(row, metric.title).title = metric.name;
+      var total = number(metric.total);
+      if (metric.average_seconds) {
+        total += " (avg " + number(metric.average_seconds * 1000) + " ms)";
+      }
+      cell(row, total, "number");
+      cell(row, breakdown(metric.by));
+    });
+
+    var events = $("events");
+    events.replaceChildren();
+    if (data.security_events_error) {
+      cell(events.insertRow(), data.security_events_error, "error").colSpan = 4;
+    } else if (!data.security_events) {
+      cell(events.insertRow(), "Security events are kept in the database, which is not configured.").colSpan = 4;
+    } else if (data.security_events.length === 0) {
+      cell(events.insertRow(), "No security events yet.").colSpan = 4;
+    }
+    (data.security_events || []).forEach(function (event) {
+      var row = events.insertRow();
+      cell(row, new Date(event.created_at).toLocaleString());
+      cell(row, event.event_type);
+      cell(row, event.user_id || "");
+      cell(row, event.ip_address || "");
+    });
+
+    $("updated").textContent = "Updated " + new Date(data.generated_at).toLocaleTimeString();
+    $("sign-in").hidden = true;
+    $("sign-out").hidden = false;
+    $("dashboard").hidden = false;
+  }
+
+  function load() {
+    var token = sessionStorage.getItem(tokenKey);
+    if (!token) {
+      showSignIn();
+      return;
+    }
+    fetch(endpoint, { headers: { Authorization: "Bearer " + token }, cache: "no-store" })
+      .then(function (response) {
+        if (response.status === 401 || response.status === 403) {
+          showSignIn("That token is not an admin token with security_events:read, or it has expired.");
+          return null;
+        }
+        if (!response.ok) {
+          throw new Error("The dashboard endpoint answered " + response.status);
+        }
+        return response.json();
+      })
+      .then(function (data) {
+        if (data) {
+          render(data);
+          timer = setTimeout(load, (data.refresh_seconds || 15) * 1000);
+        }
+      })
+      .catch(function (err) {
+        $("status").textContent = "unreachable";
+        $("status").className = "badge bad";
+        $("updated").textContent = err.message;
+        timer = setTimeout(load, 15000);
+      });
+  }
+
+  $("sign-in").addEventListener("submit", function (event) {
+    event.preventDefault();
+    sessionStorage.setItem(tokenKey, $("token").value.trim());
+    $("token").value = "";
+    load();
+  });
+  $("sign-out").addEventListener("click", function () {
+    showSignIn();
+  });
+
+  load();
+})();
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type DashboardTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DashboardTestSuite) SetupSuite() {
+	gin.SetMode(gin.TestMode)
+}
+
+func (suite *DashboardTestSuite) TestConfig() {
+	config, err := DefaultDashboardConfig()
+	suite.Require().NoError(err)
+	suite.False(config.Enabled)
+	suite.Equal(20, config.Events)
+	suite.Equal(15*time.Second, config.Refresh)
+	suite.Nil(NewDashboardService(&AuthService{}, config))
+
+	suite.T().Setenv("DASHBOARD_ENABLED", "true")
+	config, err = DefaultDashboardConfig()
+	suite.Require().NoError(err)
+	suite.NotNil(NewDashboardService(&AuthService{}, config))
+
+	for name, value := range map[string]string{
+		"DASHBOARD_SECURITY_EVENTS": "0",
+		"DASHBOARD_REFRESH":         "100ms",
+	} {
+		suite.Run(name, func() {
+			suite.T().Setenv(name, value)
+			_, err := DefaultDashboardConfig()
+			suite.Error(err)
+		})
+	}
+}
+
+func (suite *DashboardTestSuite) TestPageIsServedFromEmbeddedAssets() {
+	as := &AuthService{}
+	as.dashboard = NewDashboardService(as, DashboardConfig{Enabled: true, Events: 20, Refresh: time.Second})
+	router := gin.New()
+	registerDashboardRoutes(newRouteGroup(&router.RouterGroup, as), as)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	page := get("/dashboard/")
+	suite.Equal(http.StatusOK, page.Code)
+	suite.Contains(page.Header().Get("Content-Type"), "text/html")
+	suite.Contains(page.Body.String(), `src="dashboard.js"`)
+
+	script := get("/dashboard/dashboard.js")
+	suite.Equal(http.StatusOK, script.Code)
+	suite.Contains(script.Header().Get("Content-Type"), "javascript")
+	suite.Contains(script.Body.String(), "/api/v1/auth/admin/dashboard")
+
+	suite.Equal(http.StatusMovedPermanently, get("/dashboard").Code)
+	suite.Equal(http.StatusNotFound, get("/dashboard/missing.js").Code)
+	suite.Equal(http.StatusNotFound, get("/dashboard/../main.go").Code)
+}
+
+func (suite *DashboardTestSuite) TestMetricsAreSummedByLabel() {
+	registry := prometheus.NewRegistry()
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: oauthRequestDurationMetric}, []string{"endpoint", "status"})
+	lookups := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "liberation_auth_token_cache_lookups_total"}, []string{"result"})
+	registry.MustRegister(durations, lookups)
+	durations.WithLabelValues("token", "2xx").Observe(0.1)
+	durations.WithLabelValues("token", "4xx").Observe(0.3)
+	durations.WithLabelValues("jwks", "2xx").Observe(0.2)
+	lookups.WithLabelValues("miss").Add(3)
+
+	metrics, err := dashboardMetrics(registry)
+	suite.Require().NoError(err)
+	suite.Require().Len(metrics, 2)
+
+	suite.Equal("OAuth requests", metrics[0].Title)
+	suite.Equal(3.0, metrics[0].Total)
+	suite.Equal(map[string]float64{"token": 2, "jwks": 1}, metrics[0].By)
+	suite.InDelta(0.2, metrics[0].AverageSeconds, 1e-9)
+
+	suite.Equal(3.0, metrics[1].Total)
+	suite.Equal(map[string]float64{"miss": 3}, metrics[1].By)
+	suite.Zero(metrics[1].AverageSeconds)
+}
+
+func (suite *DashboardTestSuite) TestDataReportsReadinessAndMetrics() {
+	server := miniredis.RunT(suite.T())
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	suite.T().Cleanup(func() { rdb.Close() })
+	as := &AuthService{redis: rdb}
+	as.dashboard = NewDashboardService(as, DashboardConfig{Enabled: true, Events: 20, Refresh: 30 * time.Second})
+	tokenCacheLookups.WithLabelValues("miss").Inc()
+
+	router := gin.New()
+	router.GET("/dashboard", as.dashboard.AdminGetDashboard)
+	fetch := func() map[string]interface{} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+		suite.Require().Equal(http.StatusOK, w.Code)
+		var body map[string]interface{}
+		suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+
+	body := fetch()
+	suite.Equal("ready", body["status"])
+	suite.Equal(map[string]interface{}{"redis": "ok"}, body["checks"])
+	suite.Equal(30.0, body["refresh_seconds"])
+	// Without a database there are no security events to show
+	suite.NotContains(body, "security_events")
+
+	var titles []string
+	for _, metric := range body["metrics"].([]interface{}) {
+		titles = append(titles, metric.(map[string]interface{})["title"].(string))
+	}
+	suite.Contains(titles, "Access token validations")
+
+	// An unreachable dependency is shown, not returned as an error
+	server.Close()
+	body = fetch()
+	suite.Equal("unavailable", body["status"])
+	suite.NotEqual("ok", body["checks"].(map[string]interface{})["redis"])
+}
+
+func TestDashboardTestSuite(t *testing.T) {
+	suite.Run(t, new(DashboardTestSuite))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"liberation-dbpool"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultDatabasePoolConfig reads the pool settings from DB_POOL_MAX_OPEN_CONNS,
+// DB_POOL_MAX_IDLE_CONNS, DB_POOL_CONN_MAX_LIFETIME_SECONDS, DB_POOL_CONN_MAX_IDLE_TIME_SECONDS,
+// DB_POOL_STATEMENT_CACHE_SIZE, DB_POOL_WAIT_WARNING_MILLIS and DB_POOL_CHECK_INTERVAL_SECONDS
+func DefaultDatabasePoolConfig() (dbpool.Config, error) {
+	config := dbpool.DefaultConfig()
+	config.ApplyEnv("DB_POOL_")
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("DB_POOL_: %w", err)
+	}
+	return config, nil
+}
+
+// querier runs queries on the pool, directly or through prepared statements
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// hotQueries runs the queries behind every authenticated request through the statement
+// cache, or directly when there is none
+func (as *AuthService) hotQueries() querier {
+	if as.statements != nil {
+		return as.statements
+	}
+	return as.db
+}
+
+// dbPoolCollector exports a pool monitor's stats to Prometheus
+type dbPoolCollector struct {
+	monitor *dbpool.Monitor
+
+	connections, maxOpen, utilization, waits, waitSeconds, closed, statements *prometheus.Desc
+}
+
+func newDBPoolCollector(monitor *dbpool.Monitor) *dbPoolCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc("liberation_auth_db_pool_"+name, help, append([]string{"pool"}, labels...), nil)
+	}
+	return &dbPoolCollector{
+		monitor:     monitor,
+		connections: desc("connections", "Connections by state", "state"),
+		maxOpen:     desc("max_open_connections", "Most connections the pool may open"),
+		utilization: desc("utilization_ratio", "Connections in use over the most the pool may open"),
+		waits:       desc("waits_total", "Requests that waited for a connection"),
+		waitSeconds: desc("wait_seconds_total", "Time spent waiting for connections"),
+		closed:      desc("closed_total", "Connections closed by the pool, by limit reached", "reason"),
+		statements:  desc("statement_cache_total", "Prepared statement lookups, by result", "result"),
+	}
+}
+
+func (c *dbPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{c.connections, c.maxOpen, c.utilization, c.waits, c.waitSeconds, c.closed, c.statements} {
+		ch <- desc
+	}
+}
+
+func (c *dbPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.monitor.Stats()
+	metric := func(desc *prometheus.Desc, kind prometheus.ValueType, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, kind, value, append([]string{s.Name}, labels...)...)
+	}
+	metric(c.connections, prometheus.GaugeValue, float64(s.InUse), "in_use")
+	metric(c.connections, prometheus.GaugeValue, float64(s.Idle), "idle")
+	metric(c.maxOpen, prometheus.GaugeValue, float64(s.MaxOpen))
+	metric(c.utilization, prometheus.GaugeValue, s.Utilization)
+	metric(c.waits, prometheus.CounterValue, float64(s.WaitTotal))
+	metric(c.waitSeconds, prometheus.CounterValue, s.WaitSecondsTotal)
+	metric(c.closed, prometheus.CounterValue, float64(s.MaxIdleClosedTotal), "max_idle")
+	metric(c.closed, prometheus.CounterValue, float64(s.MaxIdleTimeClosedTotal), "max_idle_time")
+	metric(c.closed, prometheus.CounterValue, float64(s.MaxLifetimeClosedTotal), "max_lifetime")
+	metric(c.statements, prometheus.CounterValue, float64(s.StatementHitsTotal), "hit")
+	metric(c.statements, prometheus.CounterValue, float64(s.StatementMissesTotal), "miss")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"liberation-dbpool"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/suite"
+)
+
+type DBPoolTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DBPoolTestSuite) TestConfigFromEnvironment() {
+	config, err := DefaultDatabasePoolConfig()
+	suite.Require().NoError(err)
+	suite.Equal(dbpool.DefaultConfig(), config)
+
+	suite.T().Setenv("DB_POOL_MAX_OPEN_CONNS", "60")
+	suite.T().Setenv("DB_POOL_MAX_IDLE_CONNS", "20")
+	config, err = DefaultDatabasePoolConfig()
+	suite.Require().NoError(err)
+	suite.Equal(60, config.MaxOpenConns)
+	suite.Equal(20, config.MaxIdleConns)
+
+	suite.T().Setenv("DB_POOL_MAX_IDLE_CONNS", "80")
+	_, err = DefaultDatabasePoolConfig()
+	suite.ErrorContains(err, "max_idle_conns")
+}
+
+func (suite *DBPoolTestSuite) TestCollector() {
+	db, err := sql.Open("postgres", "postgres://localhost/unused?sslmode=disable")
+	suite.Require().NoError(err)
+	defer db.Close()
+	config := dbpool.DefaultConfig()
+	config.Apply(db)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newDBPoolCollector(dbpool.NewMonitor("primary", db, config, dbpool.NewStatementCache(db, 10))))
+	families, err := registry.Gather()
+	suite.Require().NoError(err)
+
+	samples := map[string]int{}
+	for _, family := range families {
+		samples[family.GetName()] = len(family.GetMetric())
+		suite.Equal("primary", family.GetMetric()[0].GetLabel()[0].GetValue())
+	}
+	suite.Equal(map[string]int{
+		"liberation_auth_db_pool_connections":           2,
+		"liberation_auth_db_pool_max_open_connections":  1,
+		"liberation_auth_db_pool_utilization_ratio":     1,
+		"liberation_auth_db_pool_waits_total":           1,
+		"liberation_auth_db_pool_wait_seconds_total":    1,
+		"liberation_auth_db_pool_closed_total":          3,
+		"liberation_auth_db_pool_statement_cache_total": 2,
+	}, samples)
+}
+
+func (suite *DBPoolTestSuite) TestHotQueriesWithoutCache() {
+	db, err := sql.Open("postgres", "postgres://localhost/unused?sslmode=disable")
+	suite.Require().NoError(err)
+	defer db.Close()
+
+	suite.Same(db, (&AuthService{db: db}).hotQueries())
+	statements := dbpool.NewStatementCache(db, 10)
+	suite.Same(statements, (&AuthService{db: db, statements: statements}).hotQueries())
+}
+
+func TestDBPoolTestSuite(t *testing.T) {
+	suite.Run(t, new(DBPoolTestSuite))
+}
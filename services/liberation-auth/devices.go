@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Platforms a device can register as
+const (
+	devicePlatformIOS     = "ios"
+	devicePlatformAndroid = "android"
+)
+
+// deviceRevokedReason marks refresh tokens revoked because their device was signed out
+const deviceRevokedReason = "device_revoked"
+
+// Limits on what a device reports about itself
+const (
+	maxDeviceNameLength       = 100
+	maxDeviceAppVersionLength = 50
+	maxDevicePushTokenLength  = 4096
+)
+
+var devicesRegistered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_devices_registered_total",
+	Help: "Devices registered on token issuance, by platform.",
+}, []string{"platform"})
+
+// DeviceConfig controls device registration for mobile clients
+type DeviceConfig struct {
+	Enabled bool
+	// PushWebhookURL receives new-device push notifications for the operator's push gateway;
+	// they are logged without it
+	PushWebhookURL    string
+	PushWebhookSecret string
+}
+
+// DefaultDeviceConfig reads DEVICES_ENABLED, DEVICE_PUSH_WEBHOOK_URL and DEVICE_PUSH_WEBHOOK_SECRET
+func DefaultDeviceConfig() DeviceConfig {
+	return DeviceConfig{
+		Enabled:           getEnv("DEVICES_ENABLED", "true") == "true",
+		PushWebhookURL:    getEnv("DEVICE_PUSH_WEBHOOK_URL", ""),
+		PushWebhookSecret: getEnv("DEVICE_PUSH_WEBHOOK_SECRET", ""),
+	}
+}
+
+// deviceRegistration is what an app tells the token endpoint about the device it runs on
+type deviceRegistration struct {
+	Platform   string
+	Name       string
+	AppVersion string
+	PushToken  string
+}
+
+// parseDeviceRegistration reads the device_* token request parameters. It returns nil when
+// the request does not register a device.
+func parseDeviceRegistration(c *gin.Context) (*deviceRegistration, error) {
+	device := &deviceRegistration{
+		Platform:   strings.ToLower(strings.TrimSpace(c.PostForm("device_platform"))),
+		Name:       strings.TrimSpace(c.PostForm("device_name")),
+		AppVersion: strings.TrimSpace(c.PostForm("device_app_version")),
+		PushToken:  strings.TrimSpace(c.PostForm("device_push_token")),
+	}
+	if device.Platform == "" {
+		if device.Name != "" || device.AppVersion != "" || device.PushToken != "" {
+			return nil, fmt.Errorf("device_platform is required to register a device")
+		}
+		return nil, nil
+	}
+	if device.Platform != devicePlatformIOS && device.Platform != devicePlatformAndroid {
+		return nil, fmt.Errorf("device_platform must be %s or %s", devicePlatformIOS, devicePlatformAndroid)
+	}
+	if len(device.Name) > maxDeviceNameLength {
+		return nil, fmt.Errorf("device_name must be at most %d characters", maxDeviceNameLength)
+	}
+	if len(device.AppVersion) > maxDeviceAppVersionLength {
+		return nil, fmt.Errorf("device_app_version must be at most %d characters", maxDeviceAppVersionLength)
+	}
+	if len(device.PushToken) > maxDevicePushTokenLength {
+		return nil, fmt.Errorf("device_push_token must be at most %d characters", maxDevicePushTokenLength)
+	}
+	return device, nil
+}
+
+// Device is a registered device as its owner sees it. Push tokens are never returned.
+type Device struct {
+	ID          uuid.UUID `json:"id"`
+	ClientID    uuid.UUID `json:"client_id"`
+	ClientName  string    `json:"client_name"`
+	Platform    string    `json:"platform"`
+	Name        string    `json:"name"`
+	AppVersion  string    `json:"app_version"`
+	PushEnabled bool      `json:"push_enabled"`
+	IPAddress   string    `json:"ip_address"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// DeviceService registers the devices mobile apps sign in on and binds their refresh tokens
+// to them, so a user can sign out a lost phone without touching their other sessions
+type DeviceService struct {
+	as       *AuthService
+	config   DeviceConfig
+	notifier Notifier
+}
+
+// NewDeviceService returns nil when DEVICES_ENABLED is off
+func NewDeviceService(as *AuthService, config DeviceConfig) *DeviceService {
+	if !config.Enabled {
+		return nil
+	}
+	var notifier Notifier = &LogNotifier{}
+	if config.PushWebhookURL != "" {
+		notifier = &WebhookNotifier{
+			URL:        config.PushWebhookURL,
+			Secret:     config.PushWebhookSecret,
+			httpClient: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return &DeviceService{as: as, config: config, notifier: notifier}
+}
+
+// fromRequest reads the device a token request registers. It returns nil when devices are
+// disabled or the request registers none, and false after answering an invalid request.
+func (s *DeviceService) fromRequest(c *gin.Context) (*deviceRegistration, bool) {
+	if s == nil {
+		return nil, true
+	}
+	device, err := parseDeviceRegistration(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{Error: "invalid_request", ErrorDescription: err.Error()})
+		return nil, false
+	}
+	return device, true
+}
+
+// register records the device a user signed in on and binds the new refresh token to it.
+// Signing in again on a device with the same push token reuses its record. The user's other
+// devices are told about a new one by push.
+func (s *DeviceService) register(c *gin.Context, device *deviceRegistration, userID, clientID, refreshTokenID uuid.UUID) {
+	if s == nil || device == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	deviceID := uuid.New()
+	created := true
+	if device.PushToken != "" {
+		err := s.as.db.QueryRowContext(ctx, `
+			UPDATE user_devices SET name = $4, app_version = $5, ip_address = $6, last_seen_at = NOW()
+			WHERE user_id = $1 AND client_id = $2 AND push_token = $3 AND revoked_at IS NULL
+			RETURNING id`,
+			userID, clientID, device.PushToken, device.Name, device.AppVersion, c.ClientIP()).Scan(&deviceID)
+		switch {
+		case err == nil:
+			created = false
+		case !errors.Is(err, sql.ErrNoRows):
+			log.Printf("Failed to look up device of user %s: %v", userID, err)
+			return
+		}
+	}
+	if created {
+		if _, err := s.as.db.ExecContext(ctx, `
+			INSERT INTO user_devices (id, user_id, client_id, platform, name, app_version, push_token, ip_address, created_at, last_seen_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, NOW(), NOW())`,
+			deviceID, userID, clientID, device.Platform, device.Name, device.AppVersion, device.PushToken, c.ClientIP()); err != nil {
+			log.Printf("Failed to register device of user %s: %v", userID, err)
+			return
+		}
+	}
+	if _, err := s.as.db.ExecContext(ctx, `UPDATE oauth_refresh_tokens SET device_id = $2 WHERE id = $1`, refreshTokenID, deviceID); err != nil {
+		log.Printf("Failed to bind refresh token to device %s: %v", deviceID, err)
+		return
+	}
+	if !created {
+		return
+	}
+
+	devicesRegistered.WithLabelValues(device.Platform).Inc()
+	s.as.recordSecurityEvent(c, &userID, securityEventDeviceRegistered, map[string]interface{}{
+		"device_id": deviceID.String(), "client_id": clientID.String(), "platform": device.Platform, "name": device.Name,
+	})
+	data := map[string]interface{}{
+		"device_id":  deviceID,
+		"client_id":  clientID,
+		"platform":   device.Platform,
+		"name":       device.Name,
+		"ip_address": c.ClientIP(),
+	}
+	go s.notifyNewDevice(userID, deviceID, data)
+}
+
+// boundDevice returns the device a refresh token is bound to, if any, and false when that
+// device has been signed out
+func (s *DeviceService) boundDevice(ctx context.Context, refreshTokenID uuid.UUID) (uuid.NullUUID, bool) {
+	var deviceID uuid.NullUUID
+	if s == nil {
+		return deviceID, true
+	}
+	var revokedAt sql.NullTime
+	err := s.as.db.QueryRowContext(ctx, `
+		SELECT d.id, d.revoked_at FROM oauth_refresh_tokens t JOIN user_devices d ON d.id = t.device_id
+		WHERE t.id = $1`, refreshTokenID).Scan(&deviceID, &revokedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return deviceID, true
+	case err != nil:
+		log.Printf("Failed to load device of refresh token %s: %v", refreshTokenID, err)
+		return uuid.NullUUID{}, true
+	}
+	return deviceID, !revokedAt.Valid
+}
+
+// rebind binds a refreshed token to its predecessor's device and records what the app
+// reports now, such as a new app version or push token
+func (s *DeviceService) rebind(c *gin.Context, deviceID uuid.NullUUID, refreshTokenID uuid.UUID) {
+	if s == nil || !deviceID.Valid {
+		return
+	}
+	ctx := c.Request.Context()
+	if _, err := s.as.db.ExecContext(ctx, `UPDATE oauth_refresh_tokens SET device_id = $2 WHERE id = $1`, refreshTokenID, deviceID.UUID); err != nil {
+		log.Printf("Failed to bind refresh token to device %s: %v", deviceID.UUID, err)
+	}
+	appVersion := strings.TrimSpace(c.PostForm("device_app_version"))
+	pushToken := strings.TrimSpace(c.PostForm("device_push_token"))
+	if len(appVersion) > maxDeviceAppVersionLength || len(pushToken) > maxDevicePushTokenLength {
+		appVersion, pushToken = "", ""
+	}
+	if _, err := s.as.db.ExecContext(ctx, `
+		UPDATE user_devices SET last_seen_at = NOW(), ip_address = $2,
+			app_version = COALESCE(NULLIF($3, ''), app_version), push_token = COALESCE(NULLIF($4, ''), push_token)
+		WHERE id = $1`,
+		deviceID.UUID, c.ClientIP(), appVersion, pushToken); err != nil {
+		log.Printf("Failed to update device %s: %v", deviceID.UUID, err)
+	}
+}
+
+// notifyNewDevice pushes a new-device alert to the user's other devices that accept push
+func (s *DeviceService) notifyNewDevice(userID, deviceID uuid.UUID, data map[string]interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT id, platform, push_token FROM user_devices
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND push_token IS NOT NULL`, userID, deviceID)
+	if err != nil {
+		log.Printf("Failed to load devices of user %s: %v", userID, err)
+		return
+	}
+	defer rows.Close()
+	var targets []map[string]interface{}
+	for rows.Next() {
+		var id uuid.UUID
+		var platform, pushToken string
+		if err := rows.Scan(&id, &platform, &pushToken); err != nil {
+			log.Printf("Failed to load devices of user %s: %v", userID, err)
+			return
+		}
+		targets = append(targets, map[string]interface{}{"device_id": id, "platform": platform, "push_token": pushToken})
+	}
+	if len(targets) == 0 {
+		return
+	}
+	data["push_targets"] = targets
+	if err := s.notifier.Notify(ctx, Notification{Type: "new_device", UserID: userID, Data: data}); err != nil {
+		log.Printf("Failed to push new device notification to user %s: %v", userID, err)
+	}
+}
+
+// ListDevices lists the caller's signed-in devices, most recently seen first
+func (s *DeviceService) ListDevices(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `
+		SELECT d.id, d.client_id, COALESCE(c.name, ''), d.platform, d.name, d.app_version,
+			d.push_token IS NOT NULL, d.ip_address, d.created_at, d.last_seen_at
+		FROM user_devices d LEFT JOIN oauth_clients c ON c.id = d.client_id
+		WHERE d.user_id = $1 AND d.revoked_at IS NULL
+		ORDER BY d.last_seen_at DESC`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load devices"})
+		return
+	}
+	defer rows.Close()
+	devices := []Device{}
+	for rows.Next() {
+		var device Device
+		if err := rows.Scan(&device.ID, &device.ClientID, &device.ClientName, &device.Platform, &device.Name, &device.AppVersion,
+			&device.PushEnabled, &device.IPAddress, &device.CreatedAt, &device.LastSeenAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load devices"})
+			return
+		}
+		devices = append(devices, device)
+	}
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// RevokeDevice signs a device out: its refresh tokens and their access tokens are revoked,
+// and it gets no more push notifications
+func (s *DeviceService) RevokeDevice(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	deviceID, err := uuid.Parse(c.Param("device_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device"})
+		return
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx, `
+		UPDATE user_devices SET revoked_at = NOW(), push_token = NULL
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, deviceID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE oauth_refresh_tokens SET is_revoked = true, revoked_at = NOW(), revoked_reason = $2
+		WHERE device_id = $1 AND is_revoked = false
+		RETURNING access_token_id`, deviceID, deviceRevokedReason)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device"})
+		return
+	}
+	var accessIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device"})
+			return
+		}
+		accessIDs = append(accessIDs, id)
+	}
+	rows.Close()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE oauth_access_tokens SET is_revoked = true, revoked_at = NOW()
+		WHERE id = ANY($1) AND is_revoked = false`, pq.Array(accessIDs)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device"})
+		return
+	}
+
+	for _, id := range accessIDs {
+		s.as.publishRevocation(RevocationEvent{Type: revocationToken, ID: id.String()})
+	}
+	s.as.recordSecurityEvent(c, &userID, securityEventDeviceRevoked, map[string]interface{}{"device_id": deviceID.String()})
+	c.JSON(http.StatusOK, gin.H{"message": "Device signed out", "revoked_tokens": len(accessIDs)})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type DeviceTestSuite struct {
+	suite.Suite
+}
+
+// tokenRequest builds a token endpoint request carrying form
+func (suite *DeviceTestSuite) tokenRequest(form url.Values) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c, w
+}
+
+func (suite *DeviceTestSuite) TestParseRegistration() {
+	c, _ := suite.tokenRequest(url.Values{"grant_type": {"authorization_code"}})
+	device, err := parseDeviceRegistration(c)
+	suite.NoError(err)
+	suite.Nil(device, "requests without device parameters register nothing")
+
+	c, _ = suite.tokenRequest(url.Values{
+		"device_platform": {" iOS "}, "device_name": {"Quill's iPhone"}, "device_app_version": {"4.2.0"}, "device_push_token": {"apns-token"},
+	})
+	device, err = parseDeviceRegistration(c)
+	suite.Require().NoError(err)
+	suite.Equal(&deviceRegistration{Platform: devicePlatformIOS, Name: "Quill's iPhone", AppVersion: "4.2.0", PushToken: "apns-token"}, device)
+}
+
+func (suite *DeviceTestSuite) TestRejectsInvalidRegistration() {
+	for _, form := range []url.Values{
+		{"device_push_token": {"fcm-token"}},
+		{"device_platform": {"windows-phone"}},
+		{"device_platform": {"android"}, "device_name": {strings.Repeat("a", maxDeviceNameLength+1)}},
+		{"device_platform": {"android"}, "device_push_token": {strings.Repeat("a", maxDevicePushTokenLength+1)}},
+	} {
+		c, _ := suite.tokenRequest(form)
+		_, err := parseDeviceRegistration(c)
+		suite.Error(err, form.Encode())
+	}
+
+	c, w := suite.tokenRequest(url.Values{"device_platform": {"windows-phone"}})
+	_, ok := NewDeviceService(&AuthService{}, DeviceConfig{Enabled: true}).fromRequest(c)
+	suite.False(ok)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "invalid_request")
+}
+
+func (suite *DeviceTestSuite) TestConfig() {
+	config := DefaultDeviceConfig()
+	suite.True(config.Enabled)
+	suite.IsType(&LogNotifier{}, NewDeviceService(&AuthService{}, config).notifier, "pushes are logged without a webhook")
+
+	config.PushWebhookURL = "https://push.internal/notify"
+	suite.IsType(&WebhookNotifier{}, NewDeviceService(&AuthService{}, config).notifier)
+
+	suite.T().Setenv("DEVICES_ENABLED", "false")
+	suite.Nil(NewDeviceService(&AuthService{}, DefaultDeviceConfig()))
+}
+
+func (suite *DeviceTestSuite) TestDisabled() {
+	var devices *DeviceService
+	c, _ := suite.tokenRequest(url.Values{"device_platform": {"windows-phone"}})
+	device, ok := devices.fromRequest(c)
+	suite.True(ok, "parameters are ignored while devices are disabled")
+	suite.Nil(device)
+
+	deviceID, active := devices.boundDevice(context.Background(), uuid.New())
+	suite.True(active)
+	suite.False(deviceID.Valid)
+	devices.register(c, &deviceRegistration{Platform: devicePlatformIOS}, uuid.New(), uuid.New(), uuid.New())
+	devices.rebind(c, deviceID, uuid.New())
+}
+
+func TestDeviceTestSuite(t *testing.T) {
+	suite.Run(t, new(DeviceTestSuite))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DoctorTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DoctorTestSuite) statusOf(report *doctorReport, name string) string {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check.Status
+		}
+	}
+	return ""
+}
+
+func (suite *DoctorTestSuite) TestClockSkewThresholds() {
+	now := time.Now()
+	report := &doctorReport{}
+
+	report.addClockSkew("small", now.Add(500*time.Millisecond), now, now)
+	report.addClockSkew("noticeable", now.Add(-5*time.Second), now, now)
+	report.addClockSkew("broken", now.Add(2*time.Minute), now, now)
+
+	suite.Equal(checkOK, suite.statusOf(report, "small"))
+	suite.Equal(checkWarn, suite.statusOf(report, "noticeable"))
+	suite.Equal(checkFail, suite.statusOf(report, "broken"))
+	suite.True(report.failed())
+}
+
+func (suite *DoctorTestSuite) TestPlaceholderSecretFailsOnlyInRelease() {
+	suite.T().Setenv("JWT_SECRET", "")
+	suite.T().Setenv("GIN_MODE", "debug")
+	report := &doctorReport{}
+	checkConfiguration(report)
+	suite.Equal(checkWarn, suite.statusOf(report, "jwt secret"))
+
+	suite.T().Setenv("GIN_MODE", "release")
+	report = &doctorReport{}
+	checkConfiguration(report)
+	suite.Equal(checkFail, suite.statusOf(report, "jwt secret"))
+}
+
+func (suite *DoctorTestSuite) TestInvalidSettingsAreReported() {
+	suite.T().Setenv("TOKEN_CACHE_TTL", "thirty")
+	suite.T().Setenv("CSRF_TRUSTED_ORIGINS", "nuclear-ao3.com")
+	suite.T().Setenv("STORAGE_BACKEND", "ftp")
+
+	report := &doctorReport{}
+	checkConfiguration(report)
+
+	suite.Equal(checkFail, suite.statusOf(report, "token cache"))
+	suite.Equal(checkFail, suite.statusOf(report, "csrf origins"))
+	suite.Equal(checkFail, suite.statusOf(report, "file storage"))
+}
+
+func (suite *DoctorTestSuite) TestConformanceModeFailsInRelease() {
+	suite.T().Setenv("CONFORMANCE_MODE", "true")
+	suite.T().Setenv("GIN_MODE", "debug")
+	report := &doctorReport{}
+	checkConfiguration(report)
+	suite.Equal(checkWarn, suite.statusOf(report, "conformance mode"))
+
+	suite.T().Setenv("GIN_MODE", "release")
+	report = &doctorReport{}
+	checkConfiguration(report)
+	suite.Equal(checkFail, suite.statusOf(report, "conformance mode"))
+}
+
+func TestDoctorTestSuite(t *testing.T) {
+	suite.Run(t, new(DoctorTestSuite))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"liberation-dryrun"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dryRunQuery counts and samples one kind of row a destructive operation would change.
+// from is the operation's own FROM and WHERE clauses; id is the column listed as examples.
+type dryRunQuery struct {
+	entity string
+	action string
+	from   string
+	id     string
+	args   []interface{}
+	// missing, when set, makes the plan fail with this message if nothing matches, for the
+	// query selecting the operation's target
+	missing string
+}
+
+// errDryRunTargetMissing is returned when the operation's target does not exist
+type errDryRunTargetMissing string
+
+func (e errDryRunTargetMissing) Error() string { return string(e) }
+
+// plan counts and samples each query into a plan
+func (as *AuthService) plan(ctx context.Context, operation, target string, queries ...dryRunQuery) (*dryrun.Plan, error) {
+	plan := dryrun.New(operation, target)
+	for _, q := range queries {
+		var count int64
+		if err := as.db.QueryRowContext(ctx, "SELECT COUNT(*) "+q.from, q.args...).Scan(&count); err != nil {
+			return nil, fmt.Errorf("counting %s: %w", q.entity, err)
+		}
+		if count == 0 && q.missing != "" {
+			return nil, errDryRunTargetMissing(q.missing)
+		}
+		sample := []string{}
+		if count > 0 {
+			rows, err := as.db.QueryContext(ctx, fmt.Sprintf("SELECT %s::text %s ORDER BY 1 LIMIT %d", q.id, q.from, dryrun.SampleSize), q.args...)
+			if err != nil {
+				return nil, fmt.Errorf("sampling %s: %w", q.entity, err)
+			}
+			for rows.Next() {
+				var id string
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				sample = append(sample, id)
+			}
+			rows.Close()
+		}
+		plan.Add(q.entity, q.action, count, sample)
+	}
+	return plan, nil
+}
+
+// previewDryRun answers ?dry_run=true with the operation's plan and reports whether it did.
+// Handlers call it before changing anything and return when it reports true.
+func (as *AuthService) previewDryRun(c *gin.Context, operation, target string, warnings []string, queries ...dryRunQuery) bool {
+	if !dryrun.Requested(c.Request) {
+		return false
+	}
+	plan, err := as.plan(c.Request.Context(), operation, target, queries...)
+	var missing errDryRunTargetMissing
+	if errors.As(err, &missing) {
+		c.JSON(http.StatusNotFound, gin.H{"error": missing.Error()})
+		return true
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to plan " + operation})
+		return true
+	}
+	for _, warning := range warnings {
+		plan.Warn(warning)
+	}
+	c.JSON(http.StatusOK, plan)
+	return true
+}
+
+// liveTokenQueries select the unrevoked, unexpired access and refresh tokens matching where,
+// which refers to the token table's columns and to args
+func liveTokenQueries(action, where string, args ...interface{}) []dryRunQuery {
+	return []dryRunQuery{
+		{entity: "access_tokens", action: action, id: "id", args: args,
+			from: "FROM oauth_access_tokens WHERE is_revoked = false AND expires_at > NOW() AND " + where},
+		{entity: "refresh_tokens", action: action, id: "id", args: args,
+			from: "FROM oauth_refresh_tokens WHERE is_revoked = false AND expires_at > NOW() AND " + where},
+	}
+}
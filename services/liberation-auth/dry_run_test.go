@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type DryRunTestSuite struct {
+	suite.Suite
+}
+
+func (suite *DryRunTestSuite) TestWithoutDryRunHandlersProceed() {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodDelete, "/admin/oauth/clients/1?dry_run=false", nil)
+
+	// No database is needed: nothing is planned unless a dry run is requested
+	as := &AuthService{}
+	suite.False(as.previewDryRun(c, "delete_client", "1", nil, liveTokenQueries("invalidate", "client_id = $1", "1")...))
+}
+
+func (suite *DryRunTestSuite) TestLiveTokenQueriesSkipDeadTokens() {
+	queries := liveTokenQueries("revoke", "user_id = $1", "user-1")
+	suite.Require().Len(queries, 2)
+	for i, table := range []string{"oauth_access_tokens", "oauth_refresh_tokens"} {
+		suite.True(strings.HasPrefix(queries[i].from, "FROM "+table+" WHERE "))
+		suite.Contains(queries[i].from, "is_revoked = false AND expires_at > NOW() AND user_id = $1")
+		suite.Equal([]interface{}{"user-1"}, queries[i].args)
+		suite.Equal("revoke", queries[i].action)
+	}
+}
+
+func TestDryRunTestSuite(t *testing.T) {
+	suite.Run(t, new(DryRunTestSuite))
+}
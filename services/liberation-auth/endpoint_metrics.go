@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const oauthRequestDurationMetric = "liberation_auth_oauth_request_duration_seconds"
+
+// oauthLatencyBuckets are the histogram's bucket bounds; latency SLO thresholds must be one of them
+var oauthLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+var oauthRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    oauthRequestDurationMetric,
+	Help:    "OAuth endpoint latency by endpoint (authorize, token, introspect, userinfo, jwks), token grant type and status class. Samples carry the trace ID of requests with a traceparent header as an exemplar.",
+	Buckets: oauthLatencyBuckets,
+}, []string{"endpoint", "grant_type", "status"})
+
+// oauthMetricGrantTypes bounds the grant_type label; anything else is counted as "other"
+var oauthMetricGrantTypes = map[string]bool{
+	"authorization_code": true,
+	"refresh_token":      true,
+	"client_credentials": true,
+}
+
+// traceparentPattern matches a W3C Trace Context traceparent header
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// traceIDFromRequest returns the trace ID of the request's traceparent header, set by the
+// ingress or the calling service, or "" when there is none
+func traceIDFromRequest(r *http.Request) string {
+	match := traceparentPattern.FindStringSubmatch(strings.TrimSpace(r.Header.Get("traceparent")))
+	if match == nil || match[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	return match[1]
+}
+
+// statusClass turns a status code into a bounded label value such as "2xx"
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// OAuthMetricsMiddleware times requests to one OAuth endpoint. Token requests are labelled
+// with their grant type. A request with a traceparent header leaves its trace ID as an
+// exemplar, so a slow bucket on a dashboard links to a trace of a request that landed in it.
+func OAuthMetricsMiddleware(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		grantType := ""
+		if endpoint == "token" {
+			// The handler has parsed the form by now, so this reads no body
+			grantType = c.Request.PostFormValue("grant_type")
+			if !oauthMetricGrantTypes[grantType] {
+				grantType = "other"
+			}
+		}
+		observer := oauthRequestDuration.WithLabelValues(endpoint, grantType, statusClass(c.Writer.Status()))
+		observeWithTrace(observer, time.Since(start).Seconds(), traceIDFromRequest(c.Request))
+	}
+}
+
+// observeWithTrace records value with traceID as its exemplar when there is one
+func observeWithTrace(observer prometheus.Observer, value float64, traceID string) {
+	if exemplars, ok := observer.(prometheus.ExemplarObserver); ok && traceID != "" {
+		exemplars.ObserveWithExemplar(value, prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	observer.Observe(value)
+}
+
+// metricsHandler serves the default registry, in OpenMetrics to scrapers that ask for it,
+// since only OpenMetrics carries exemplars
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+}
+
+// SLOConfig holds the objectives the published burn-rate rules are derived from
+type SLOConfig struct {
+	// Availability is the share of requests to each endpoint that must not fail with a 5xx
+	Availability float64
+	// LatencyObjective is the share of requests that must finish within their endpoint's threshold
+	LatencyObjective float64
+	// LatencyThresholds are per-endpoint latency thresholds, each one of oauthLatencyBuckets
+	LatencyThresholds map[string]time.Duration
+}
+
+// oauthMetricEndpoints are the endpoints OAuthMetricsMiddleware is installed on
+var oauthMetricEndpoints = []string{"authorize", "token", "introspect", "userinfo", "jwks"}
+
+// DefaultSLOConfig reads OAUTH_SLO_AVAILABILITY, OAUTH_SLO_LATENCY_OBJECTIVE and
+// OAUTH_SLO_LATENCY, a list of endpoint=duration thresholds such as "token=250ms"
+func DefaultSLOConfig() (SLOConfig, error) {
+	config := SLOConfig{
+		Availability:     0.999,
+		LatencyObjective: 0.95,
+		LatencyThresholds: map[string]time.Duration{
+			"authorize":  250 * time.Millisecond,
+			"token":      100 * time.Millisecond,
+			"introspect": 50 * time.Millisecond,
+			"userinfo":   100 * time.Millisecond,
+			"jwks":       50 * time.Millisecond,
+		},
+	}
+	for name, target := range map[string]*float64{
+		"OAUTH_SLO_AVAILABILITY":      &config.Availability,
+		"OAUTH_SLO_LATENCY_OBJECTIVE": &config.LatencyObjective,
+	} {
+		raw := getEnv(name, "")
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value <= 0 || value >= 1 {
+			return config, fmt.Errorf("%s must be a ratio between 0 and 1, such as 0.999, got %q", name, raw)
+		}
+		*target = value
+	}
+
+	if raw := getEnv("OAUTH_SLO_LATENCY", ""); raw != "" {
+		for _, entry := range strings.Split(raw, ",") {
+			endpoint, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if _, known := config.LatencyThresholds[endpoint]; !ok || !known {
+				return config, fmt.Errorf("OAUTH_SLO_LATENCY entries must be <endpoint>=<duration> for one of %s, got %q", strings.Join(oauthMetricEndpoints, ", "), entry)
+			}
+			threshold, err := time.ParseDuration(value)
+			if err != nil {
+				return config, fmt.Errorf("OAUTH_SLO_LATENCY threshold for %s: %v", endpoint, err)
+			}
+			config.LatencyThresholds[endpoint] = threshold
+		}
+	}
+	for endpoint, threshold := range config.LatencyThresholds {
+		if !isLatencyBucket(threshold) {
+			return config, fmt.Errorf("latency threshold %s for %s is not a histogram bucket; use one of %v seconds", threshold, endpoint, oauthLatencyBuckets)
+		}
+	}
+	return config, nil
+}
+
+func isLatencyBucket(threshold time.Duration) bool {
+	for _, bucket := range oauthLatencyBuckets {
+		if bucket == threshold.Seconds() {
+			return true
+		}
+	}
+	return false
+}
+
+// sloWindows are the burn-rate windows: the short ones page on fast burns, the long ones
+// ticket on slow burns (5m with 1h and 30m with 6h, as in the SRE workbook)
+var sloWindows = []string{"5m", "30m", "1h", "6h"}
+
+// RecordingRule is one Prometheus recording rule
+type RecordingRule struct {
+	Record string            `json:"record" yaml:"record"`
+	Expr   string            `json:"expr" yaml:"expr"`
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// RuleGroup is a Prometheus rule group
+type RuleGroup struct {
+	Name  string          `json:"name" yaml:"name"`
+	Rules []RecordingRule `json:"rules" yaml:"rules"`
+}
+
+// RuleFile is a Prometheus rule file, ready for rule_files
+type RuleFile struct {
+	Groups []RuleGroup `json:"groups" yaml:"groups"`
+}
+
+// leMatcher matches a bucket bound whether it is exposed in the Prometheus text format
+// ("1") or in OpenMetrics ("1.0")
+func leMatcher(bound float64) string {
+	formatted := strconv.FormatFloat(bound, 'f', -1, 64)
+	if !strings.Contains(formatted, ".") {
+		return fmt.Sprintf(`le=~"%s|%s.0"`, formatted, formatted)
+	}
+	return fmt.Sprintf(`le="%s"`, formatted)
+}
+
+// RecordingRules derives request rate, error ratio, slow ratio and burn-rate rules for
+// every endpoint and window. A burn rate of 1 spends the error budget exactly over the SLO
+// period; 14.4 over both 5m and 1h spends 2% of a 30-day budget in an hour.
+func (cfg SLOConfig) RecordingRules() RuleFile {
+	errorBudget := strconv.FormatFloat(1-cfg.Availability, 'g', 6, 64)
+	latencyBudget := strconv.FormatFloat(1-cfg.LatencyObjective, 'g', 6, 64)
+	count := oauthRequestDurationMetric + "_count"
+	bucket := oauthRequestDurationMetric + "_bucket"
+
+	var groups []RuleGroup
+	for _, window := range sloWindows {
+		group := RuleGroup{Name: "liberation_auth_oauth_slo_" + window}
+		group.Rules = append(group.Rules,
+			RecordingRule{
+				Record: "liberation_auth:oauth_requests:rate" + window,
+				Expr:   fmt.Sprintf(`sum by (endpoint, grant_type) (rate(%s[%s]))`, count, window),
+			},
+			RecordingRule{
+				Record: "liberation_auth:oauth_error_ratio:rate" + window,
+				Expr: fmt.Sprintf(`sum by (endpoint) (rate(%s{status="5xx"}[%s])) / sum by (endpoint) (rate(%s[%s]))`,
+					count, window, count, window),
+			},
+			RecordingRule{
+				Record: "liberation_auth:oauth_availability_burn_rate:rate" + window,
+				Expr:   fmt.Sprintf(`liberation_auth:oauth_error_ratio:rate%s / %s`, window, errorBudget),
+			},
+		)
+		for _, endpoint := range oauthMetricEndpoints {
+			threshold := cfg.LatencyThresholds[endpoint].Seconds()
+			group.Rules = append(group.Rules, RecordingRule{
+				Record: "liberation_auth:oauth_slow_ratio:rate" + window,
+				Expr: fmt.Sprintf(`1 - sum by (endpoint) (rate(%s{endpoint="%s", %s}[%s])) / sum by (endpoint) (rate(%s{endpoint="%s"}[%s]))`,
+					bucket, endpoint, leMatcher(threshold), window, count, endpoint, window),
+				Labels: map[string]string{"threshold_seconds": strconv.FormatFloat(threshold, 'f', -1, 64)},
+			})
+		}
+		group.Rules = append(group.Rules, RecordingRule{
+			Record: "liberation_auth:oauth_latency_burn_rate:rate" + window,
+			Expr:   fmt.Sprintf(`liberation_auth:oauth_slow_ratio:rate%s / %s`, window, latencyBudget),
+		})
+		groups = append(groups, group)
+	}
+	return RuleFile{Groups: groups}
+}
+
+// metricDoc describes one exposed metric family
+type metricDoc struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Help   string   `json:"help"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+// documentMetrics lists the metric families gatherer has samples for
+func documentMetrics(gatherer prometheus.Gatherer) ([]metricDoc, error) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]metricDoc, 0, len(families))
+	for _, family := range families {
+		doc := metricDoc{
+			Name: family.GetName(),
+			Type: strings.ToLower(family.GetType().String()),
+			Help: family.GetHelp(),
+		}
+		seen := map[string]bool{}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if !seen[label.GetName()] {
+					seen[label.GetName()] = true
+					doc.Labels = append(doc.Labels, label.GetName())
+				}
+			}
+		}
+		sort.Strings(doc.Labels)
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// MetricsDocs serves the metrics reference and the SLO recording rules for ops. With
+// ?format=yaml it returns only the rules, as a file Prometheus can load.
+func MetricsDocs(slo SLOConfig, gatherer prometheus.Gatherer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rules := slo.RecordingRules()
+		if c.Query("format") == "yaml" {
+			c.YAML(http.StatusOK, rules)
+			return
+		}
+
+		metrics, err := documentMetrics(gatherer)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to gather metrics"})
+			return
+		}
+		thresholds := make(map[string]float64, len(slo.LatencyThresholds))
+		for endpoint, threshold := range slo.LatencyThresholds {
+			thresholds[endpoint] = threshold.Seconds()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"metrics": metrics,
+			"slo": gin.H{
+				"availability":              slo.Availability,
+				"latency_objective":         slo.LatencyObjective,
+				"latency_threshold_seconds": thresholds,
+				"windows":                   sloWindows,
+			},
+			"exemplars":       "Scrape /metrics with Accept: application/openmetrics-text to get trace_id exemplars on " + oauthRequestDurationMetric,
+			"recording_rules": rules,
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/suite"
+)
+
+type EndpointMetricsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *EndpointMetricsTestSuite) TestTraceIDFromTraceparent() {
+	r := httptest.NewRequest(http.MethodGet, "/auth/jwks", nil)
+	suite.Empty(traceIDFromRequest(r))
+
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	suite.Equal("4bf92f3577b34da6a3ce929d0e0e4736", traceIDFromRequest(r))
+
+	r.Header.Set("traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	suite.Empty(traceIDFromRequest(r), "an all-zero trace ID is invalid")
+	r.Header.Set("traceparent", "not-a-trace")
+	suite.Empty(traceIDFromRequest(r))
+}
+
+func (suite *EndpointMetricsTestSuite) TestStatusClass() {
+	suite.Equal("2xx", statusClass(http.StatusOK))
+	suite.Equal("4xx", statusClass(http.StatusBadRequest))
+	suite.Equal("5xx", statusClass(http.StatusServiceUnavailable))
+	suite.Equal("unknown", statusClass(0))
+}
+
+func (suite *EndpointMetricsTestSuite) TestTokenRequestsRecordGrantTypeAndExemplar() {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/auth/token", OAuthMetricsMiddleware("token"), func(c *gin.Context) {
+		c.Status(http.StatusBadRequest)
+	})
+
+	traceID := "0af7651916cd43dd8448eb211c80319c"
+	for _, grantType := range []string{"refresh_token", "urn:example:made-up"} {
+		form := url.Values{"grant_type": {grantType}}
+		req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("traceparent", "00-"+traceID+"-b7ad6b7169203331-01")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	suite.Require().NoError(err)
+	seen := map[string]bool{}
+	for _, family := range families {
+		if family.GetName() != oauthRequestDurationMetric {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["endpoint"] != "token" || labels["status"] != "4xx" {
+				continue
+			}
+			seen[labels["grant_type"]] = true
+			var exemplars []string
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				for _, label := range bucket.GetExemplar().GetLabel() {
+					exemplars = append(exemplars, label.GetName()+"="+label.GetValue())
+				}
+			}
+			suite.Contains(exemplars, "trace_id="+traceID)
+		}
+	}
+	suite.True(seen["refresh_token"])
+	suite.True(seen["other"], "unknown grant types share one label value")
+	suite.False(seen["urn:example:made-up"])
+}
+
+func (suite *EndpointMetricsTestSuite) TestSLOConfig() {
+	config, err := DefaultSLOConfig()
+	suite.Require().NoError(err)
+	suite.Equal(0.999, config.Availability)
+	suite.Equal(100*time.Millisecond, config.LatencyThresholds["token"])
+
+	suite.T().Setenv("OAUTH_SLO_LATENCY", "token=250ms, jwks=1s")
+	config, err = DefaultSLOConfig()
+	suite.Require().NoError(err)
+	suite.Equal(250*time.Millisecond, config.LatencyThresholds["token"])
+	suite.Equal(time.Second, config.LatencyThresholds["jwks"])
+
+	suite.T().Setenv("OAUTH_SLO_LATENCY", "token=200ms")
+	_, err = DefaultSLOConfig()
+	suite.Error(err, "thresholds must be bucket bounds")
+	suite.T().Setenv("OAUTH_SLO_LATENCY", "revoke=100ms")
+	_, err = DefaultSLOConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("OAUTH_SLO_LATENCY", "")
+	suite.T().Setenv("OAUTH_SLO_AVAILABILITY", "99.9")
+	_, err = DefaultSLOConfig()
+	suite.Error(err, "objectives are ratios")
+}
+
+func (suite *EndpointMetricsTestSuite) TestRecordingRules() {
+	config, err := DefaultSLOConfig()
+	suite.Require().NoError(err)
+	config.LatencyThresholds["jwks"] = time.Second
+
+	rules := config.RecordingRules()
+	suite.Len(rules.Groups, len(sloWindows))
+	exprs := map[string][]string{}
+	for _, rule := range rules.Groups[0].Rules {
+		exprs[rule.Record] = append(exprs[rule.Record], rule.Expr)
+	}
+	suite.Equal([]string{"liberation_auth:oauth_error_ratio:rate5m / 0.001"}, exprs["liberation_auth:oauth_availability_burn_rate:rate5m"])
+	suite.Equal([]string{"liberation_auth:oauth_slow_ratio:rate5m / 0.05"}, exprs["liberation_auth:oauth_latency_burn_rate:rate5m"])
+
+	slow := exprs["liberation_auth:oauth_slow_ratio:rate5m"]
+	suite.Len(slow, len(oauthMetricEndpoints))
+	suite.Contains(slow[1], `endpoint="token", le="0.1"`)
+	suite.Contains(slow[4], `endpoint="jwks", le=~"1|1.0"`, "whole-second bounds match both exposition formats")
+}
+
+func (suite *EndpointMetricsTestSuite) TestMetricsDocs() {
+	gin.SetMode(gin.TestMode)
+	config, err := DefaultSLOConfig()
+	suite.Require().NoError(err)
+	r := gin.New()
+	r.GET("/metrics-docs", MetricsDocs(config, prometheus.DefaultGatherer))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics-docs", nil))
+	suite.Equal(http.StatusOK, w.Code)
+	var body struct {
+		Metrics        []metricDoc `json:"metrics"`
+		RecordingRules RuleFile    `json:"recording_rules"`
+	}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	suite.NotEmpty(body.Metrics)
+	suite.Len(body.RecordingRules.Groups, len(sloWindows))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics-docs?format=yaml", nil))
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "groups:")
+	suite.Contains(w.Body.String(), "record: liberation_auth:oauth_availability_burn_rate:rate6h")
+}
+
+func TestEndpointMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(EndpointMetricsTestSuite))
+}
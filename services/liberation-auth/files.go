@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"liberation-storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	avatarPrefix = "avatars/"
+	exportPrefix = "exports/"
+	// exportCooldown limits how often one user can generate a data export
+	exportCooldown = 10 * time.Minute
+)
+
+// avatarTypes maps accepted (sniffed) image types to file extensions
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var storageObjectsExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_storage_objects_expired_total",
+	Help: "Objects removed by storage lifecycle rules, by prefix.",
+}, []string{"prefix"})
+
+var dataExportsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_data_exports_total",
+	Help: "Personal data exports requested by users, by outcome (created, throttled, failed).",
+}, []string{"outcome"})
+
+// FileConfig controls avatar and export storage
+type FileConfig struct {
+	Storage storage.Config
+	// MountPath serves signed URLs when the local backend is used
+	MountPath       string
+	AvatarMaxBytes  int64
+	AvatarLinkTTL   time.Duration
+	ExportLinkTTL   time.Duration
+	ExportRetention time.Duration
+}
+
+// DefaultFileConfig reads storage settings from STORAGE_* and related variables.
+// File features stay disabled unless STORAGE_BACKEND is set.
+func DefaultFileConfig() FileConfig {
+	config := FileConfig{
+		MountPath:       "/files",
+		AvatarMaxBytes:  2 << 20,
+		AvatarLinkTTL:   15 * time.Minute,
+		ExportLinkTTL:   time.Hour,
+		ExportRetention: 24 * time.Hour,
+	}
+	config.Storage.Root = "./data/files"
+	config.Storage.BaseURL = getEnv("PUBLIC_URL", "http://localhost:"+getEnv("PORT", "8081")) + config.MountPath
+	config.Storage.SigningSecret = getEnv("JWT_SECRET", "")
+	config.Storage.ApplyEnv("STORAGE_")
+
+	if maxBytes, err := strconv.ParseInt(getEnv("AVATAR_MAX_BYTES", ""), 10, 64); err == nil && maxBytes > 0 {
+		config.AvatarMaxBytes = maxBytes
+	}
+	if ttl, err := time.ParseDuration(getEnv("EXPORT_LINK_TTL", "")); err == nil && ttl > 0 {
+		config.ExportLinkTTL = ttl
+	}
+	if retention, err := time.ParseDuration(getEnv("EXPORT_RETENTION", "")); err == nil && retention > 0 {
+		config.ExportRetention = retention
+	}
+	return config
+}
+
+// FileService stores user avatars and personal data exports in object storage
+type FileService struct {
+	as     *AuthService
+	store  storage.Store
+	config FileConfig
+}
+
+// NewFileService creates the file service; it returns nil when no backend is configured
+func NewFileService(as *AuthService, config FileConfig) (*FileService, error) {
+	if !config.Storage.Enabled() {
+		return nil, nil
+	}
+	store, err := storage.New(config.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &FileService{as: as, store: store, config: config}, nil
+}
+
+// LocalHandler returns the handler serving signed URLs for the local backend, or nil
+func (s *FileService) LocalHandler() http.Handler {
+	if local, ok := s.store.(*storage.LocalStore); ok {
+		return http.StripPrefix(s.config.MountPath, local)
+	}
+	return nil
+}
+
+// SweepExports deletes expired exports once; it runs as the export_cleanup background job
+func (s *FileService) SweepExports(ctx context.Context) (string, error) {
+	rules := []storage.LifecycleRule{{Prefix: exportPrefix, MaxAge: s.config.ExportRetention}}
+	janitor := storage.NewJanitor(s.store, rules, storage.LifecycleHooks{
+		AfterDelete: func(ctx context.Context, object storage.Object) {
+			storageObjectsExpired.WithLabelValues(exportPrefix).Inc()
+		},
+	})
+	removed, err := janitor.Sweep(ctx)
+	return fmt.Sprintf("removed %d expired exports", removed), err
+}
+
+// UploadAvatar replaces the caller's avatar with the request body (raw image or multipart "avatar" field)
+func (s *FileService) UploadAvatar(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	if s.as.residency.refuse(c, userID, residencyStorage) {
+		return
+	}
+	data, err := s.readAvatar(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	contentType := http.DetectContentType(data)
+	extension, ok := avatarTypes[contentType]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "invalid_request", "error_description": "Avatar must be a PNG, JPEG, GIF or WebP image"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	key := fmt.Sprintf("%s%s/%s%s", avatarPrefix, userID, randomObjectID(), extension)
+	if _, err := s.store.Put(ctx, key, bytes.NewReader(data), storage.PutOptions{
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Metadata:    map[string]string{"user-id": userID.String()},
+	}); err != nil {
+		log.Printf("Failed to store avatar for %s: %v", userID, err)
+		c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to store avatar"})
+		return
+	}
+
+	var previous sql.NullString
+	err = s.as.db.QueryRowContext(c.Request.Context(), `
+		WITH old AS (SELECT object_key FROM user_avatars WHERE user_id = $1)
+		INSERT INTO user_avatars (user_id, object_key, content_type, size_bytes, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET object_key = $2, content_type = $3, size_bytes = $4, updated_at = NOW()
+		RETURNING (SELECT object_key FROM old)`,
+		userID, key, contentType, len(data)).Scan(&previous)
+	if err != nil {
+		s.store.Delete(ctx, key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to save avatar"})
+		return
+	}
+	if previous.Valid && previous.String != key {
+		if err := s.store.Delete(ctx, previous.String); err != nil {
+			log.Printf("Failed to delete previous avatar %s: %v", previous.String, err)
+		}
+	}
+
+	url, expiresAt, err := s.signedURL(ctx, key, s.config.AvatarLinkTTL)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"content_type": contentType, "size": len(data)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"avatar_url":   url,
+		"expires_at":   expiresAt,
+		"content_type": contentType,
+		"size":         len(data),
+	})
+}
+
+// DeleteAvatar removes the caller's avatar
+func (s *FileService) DeleteAvatar(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var key string
+	err := s.as.db.QueryRowContext(c.Request.Context(), `DELETE FROM user_avatars WHERE user_id = $1 RETURNING object_key`, userID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "No avatar set"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to delete avatar"})
+		return
+	}
+	if err := s.store.Delete(c.Request.Context(), key); err != nil {
+		log.Printf("Failed to delete avatar object %s: %v", key, err)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetAvatar redirects to a short-lived signed URL for a user's avatar
+func (s *FileService) GetAvatar(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "Invalid user ID"})
+		return
+	}
+
+	var key string
+	err = s.as.db.QueryRowContext(c.Request.Context(), `SELECT object_key FROM user_avatars WHERE user_id = $1`, userID).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": "No avatar set"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to load avatar"})
+		return
+	}
+
+	url, _, err := s.signedURL(c.Request.Context(), key, s.config.AvatarLinkTTL)
+	if err != nil {
+		c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to sign avatar URL"})
+		return
+	}
+	// Let browsers reuse the redirect for part of the link lifetime
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(s.config.AvatarLinkTTL.Seconds()/2)))
+	c.Redirect(http.StatusFound, url)
+}
+
+// CreateExport writes the caller's personal data to storage and returns a download link
+func (s *FileService) CreateExport(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	ctx := c.Request.Context()
+	if s.as.residency.refuse(c, userID, residencyStorage) {
+		return
+	}
+
+	if s.as.redis != nil {
+		key := fmt.Sprintf("export_cooldown:%s", userID)
+		allowed, err := s.as.redis.SetNX(ctx, key, "1", exportCooldown).Result()
+		if err == nil && !allowed {
+			dataExportsTotal.WithLabelValues("throttled").Inc()
+			wait, err := s.as.redis.TTL(ctx, key).Result()
+			if err != nil || wait <= 0 {
+				wait = exportCooldown
+			}
+			headers := RateLimitHeaders{Limit: 1, Remaining: 0, Reset: time.Now().Add(wait).Unix(), Tier: "export"}
+			headers.apply(c, true)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate_limited", "error_description": "An export was generated recently; use the existing link or try again later"})
+			return
+		}
+	}
+
+	export, err := s.collectExport(c.Request.Context(), userID)
+	if err != nil {
+		dataExportsTotal.WithLabelValues("failed").Inc()
+		log.Printf("Failed to collect export for %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to collect account data"})
+		return
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		dataExportsTotal.WithLabelValues("failed").Inc()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to encode account data"})
+		return
+	}
+
+	exportID := time.Now().UTC().Format("20060102T150405Z") + "-" + randomObjectID()
+	key := fmt.Sprintf("%s%s/%s.json", exportPrefix, userID, exportID)
+	object, err := s.store.Put(ctx, key, bytes.NewReader(data), storage.PutOptions{
+		ContentType: "application/json",
+		Size:        int64(len(data)),
+		Metadata:    map[string]string{"user-id": userID.String()},
+	})
+	if err != nil {
+		dataExportsTotal.WithLabelValues("failed").Inc()
+		log.Printf("Failed to store export for %s: %v", userID, err)
+		c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to store export"})
+		return
+	}
+	dataExportsTotal.WithLabelValues("created").Inc()
+
+	url, expiresAt, err := s.signedURL(ctx, key, s.config.ExportLinkTTL)
+	if err != nil {
+		c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to sign download URL"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"export_id":    exportID,
+		"download_url": url,
+		"expires_at":   expiresAt,
+		"size":         object.Size,
+		"deleted_at":   time.Now().Add(s.config.ExportRetention),
+	})
+}
+
+// ListExports lists the caller's exports that have not been cleaned up yet
+func (s *FileService) ListExports(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	ctx := c.Request.Context()
+
+	prefix := fmt.Sprintf("%s%s/", exportPrefix, userID)
+	objects, err := s.store.List(ctx, prefix)
+	if err != nil {
+		c.JSON(storageErrorStatus(err), gin.H{"error": "server_error", "error_description": "Failed to list exports"})
+		return
+	}
+
+	exports := []gin.H{}
+	for _, object := range objects {
+		url, expiresAt, err := s.signedURL(ctx, object.Key, s.config.ExportLinkTTL)
+		if err != nil {
+			continue
+		}
+		exports = append(exports, gin.H{
+			"export_id":    strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), ".json"),
+			"created_at":   object.LastModified,
+			"size":         object.Size,
+			"download_url": url,
+			"expires_at":   expiresAt,
+			"deleted_at":   object.LastModified.Add(s.config.ExportRetention),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"exports": exports})
+}
+
+// collectExport gathers everything the auth service stores about a user
+func (s *FileService) collectExport(ctx context.Context, userID uuid.UUID) (gin.H, error) {
+	user, err := s.as.getUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := s.as.getUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	invites, err := s.as.queryInvites(ctx, `WHERE created_by = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	consents := []gin.H{}
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT client_id, scopes, granted_at, expires_at, is_revoked
+		FROM user_consents WHERE user_id = $1 ORDER BY granted_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var clientID uuid.UUID
+		var scopes []string
+		var grantedAt time.Time
+		var expiresAt *time.Time
+		var revoked bool
+		if err := rows.Scan(&clientID, pq.Array(&scopes), &grantedAt, &expiresAt, &revoked); err != nil {
+			return nil, err
+		}
+		consents = append(consents, gin.H{
+			"client_id":  clientID,
+			"scopes":     scopes,
+			"granted_at": grantedAt,
+			"expires_at": expiresAt,
+			"revoked":    revoked,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	export := gin.H{
+		"generated_at": time.Now().UTC(),
+		"user":         user,
+		"roles":        roles,
+		"consents":     consents,
+		"invites":      invites,
+	}
+
+	var phone string
+	var verifiedAt *time.Time
+	if err := s.as.db.QueryRowContext(ctx, `SELECT phone, verified_at FROM user_phone_numbers WHERE user_id = $1`, userID).Scan(s.as.pii.scan(piiPhone, &phone), &verifiedAt); err == nil {
+		export["phone"] = gin.H{"number": phone, "verified_at": verifiedAt}
+	}
+	var stage string
+	var completedAt *time.Time
+	if err := s.as.db.QueryRowContext(ctx, `SELECT stage, completed_at FROM user_registrations WHERE user_id = $1`, userID).Scan(&stage, &completedAt); err == nil {
+		export["registration"] = gin.H{"stage": stage, "completed_at": completedAt}
+	}
+	var avatarType string
+	var avatarUpdated time.Time
+	if err := s.as.db.QueryRowContext(ctx, `SELECT content_type, updated_at FROM user_avatars WHERE user_id = $1`, userID).Scan(&avatarType, &avatarUpdated); err == nil {
+		export["avatar"] = gin.H{"content_type": avatarType, "updated_at": avatarUpdated}
+	}
+	return export, nil
+}
+
+func (s *FileService) readAvatar(c *gin.Context) ([]byte, error) {
+	return s.readUpload(c, "avatar", s.config.AvatarMaxBytes)
+}
+
+// readUpload reads an image sent as the raw body or as the named multipart file field
+func (s *FileService) readUpload(c *gin.Context, field string, maxBytes int64) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		file, _, err := c.Request.FormFile(field)
+		if err != nil {
+			return nil, fmt.Errorf("multipart uploads must include a %s file field", field)
+		}
+		defer file.Close()
+		body = file
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be at most %d bytes", field, maxBytes)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s image is required", field)
+	}
+	return data, nil
+}
+
+// storageErrorStatus maps a failed storage call to a response status. Full storage is 507,
+// so clients know retrying will not help until space is freed; anything unrecognised is
+// treated as the backend being unavailable.
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, storage.ErrInvalidKey):
+		return http.StatusBadRequest
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+func (s *FileService) signedURL(ctx context.Context, key string, ttl time.Duration) (string, time.Time, error) {
+	url, err := s.store.SignedURL(ctx, key, http.MethodGet, ttl)
+	if err != nil {
+		log.Printf("Failed to sign URL for %s: %v", key, err)
+		return "", time.Time{}, err
+	}
+	return url, time.Now().Add(ttl), nil
+}
+
+func randomObjectID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var forwardAuthRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_forward_auth_requests_total",
+	Help: "Forward-auth checks by outcome (allowed, unauthenticated, forbidden).",
+}, []string{"outcome"})
+
+// ForwardAuthConfig controls /auth/forward, the check endpoint for reverse proxies
+// (Traefik forwardAuth, nginx auth_request) protecting apps that know nothing of OAuth
+type ForwardAuthConfig struct {
+	Enabled bool
+	// LoginURL is where unauthenticated users are sent; the original URL is appended as rd
+	LoginURL string
+	// ReturnDomains are the hosts, and their subdomains, that rd may point back to
+	ReturnDomains []string
+}
+
+// DefaultForwardAuthConfig reads FORWARD_AUTH_ENABLED, FORWARD_AUTH_LOGIN_URL and
+// FORWARD_AUTH_RETURN_DOMAINS from the environment
+func DefaultForwardAuthConfig() ForwardAuthConfig {
+	return ForwardAuthConfig{
+		Enabled:  getEnv("FORWARD_AUTH_ENABLED", "false") == "true",
+		LoginURL: getEnv("FORWARD_AUTH_LOGIN_URL", getEnv("BASE_URL", "https://ao3.example.com")+"/login"),
+		ReturnDomains: strings.FieldsFunc(getEnv("FORWARD_AUTH_RETURN_DOMAINS", ""), func(r rune) bool {
+			return r == ',' || r == ' '
+		}),
+	}
+}
+
+// forwardedURL reconstructs the URL the user asked the proxy for. nginx passes it whole in
+// X-Original-URL; Traefik splits it across X-Forwarded-Proto, -Host and -Uri.
+func forwardedURL(r *http.Request) *url.URL {
+	if original := r.Header.Get("X-Original-URL"); original != "" {
+		if parsed, err := url.Parse(original); err == nil && parsed.Host != "" {
+			return parsed
+		}
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		return nil
+	}
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme != "http" {
+		scheme = "https"
+	}
+	parsed, err := url.Parse(scheme + "://" + host + r.Header.Get("X-Forwarded-Uri"))
+	if err != nil {
+		return nil
+	}
+	return parsed
+}
+
+// loginURL sends the user to log in, returning to the original URL only if its host is
+// one of the configured return domains, so the endpoint cannot be used as an open redirect
+func (cfg ForwardAuthConfig) loginURL(original *url.URL) string {
+	if original == nil || (original.Scheme != "https" && original.Scheme != "http") {
+		return cfg.LoginURL
+	}
+	host := strings.ToLower(original.Hostname())
+	for _, domain := range cfg.ReturnDomains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			separator := "?"
+			if strings.Contains(cfg.LoginURL, "?") {
+				separator = "&"
+			}
+			return cfg.LoginURL + separator + "rd=" + url.QueryEscape(original.String())
+		}
+	}
+	return cfg.LoginURL
+}
+
+// forwardIdentity is who a forward-auth request belongs to
+type forwardIdentity struct {
+	userID uuid.UUID
+	// scopes is nil for session cookies and first-party JWTs, which carry the user's full access
+	scopes []string
+	set    scopeSet
+	// accessTokenID is the OAuth access token presented, if any; its audience is loaded on demand
+	accessTokenID *uuid.UUID
+	// session is set for the session cookie, which is not bound to any audience
+	session bool
+}
+
+// forwardIdentity authenticates the request the proxy forwarded: an OAuth access token,
+// a first-party JWT or the session cookie, in that order
+func (as *AuthService) forwardIdentity(c *gin.Context) *forwardIdentity {
+	if token := extractBearerToken(c.GetHeader("Authorization")); token != "" {
+		if accessToken, scopes, err := as.validateAccessTokenScopes(c.Request.Context(), token); err == nil {
+			if accessToken.UserID == nil {
+				return nil
+			}
+			return &forwardIdentity{userID: *accessToken.UserID, scopes: accessToken.Scopes, set: scopes, accessTokenID: &accessToken.ID}
+		}
+		if _, userID, err := as.validateUserJWT(c.Request.Context(), token, as.audience.firstParty()); err == nil {
+			return &forwardIdentity{userID: userID}
+		}
+		return nil
+	}
+
+	if sessionID, err := c.Cookie("session_id"); err == nil {
+		if userID := as.getUserFromSession(sessionID); userID != nil {
+			return &forwardIdentity{userID: *userID, session: true}
+		}
+	}
+	return nil
+}
+
+// forwardAudienceAllowed checks the audience query parameter: OAuth access tokens must be
+// issued for it and first-party JWTs only pass for the first-party audience
+func (as *AuthService) forwardAudienceAllowed(c *gin.Context, identity *forwardIdentity, audience string) (bool, error) {
+	switch {
+	case identity.session:
+		return true, nil
+	case identity.accessTokenID != nil:
+		bound, err := as.accessTokenAudience(c.Request.Context(), *identity.accessTokenID)
+		if err != nil {
+			return false, err
+		}
+		return as.audience.allows(bound, audience), nil
+	default:
+		return audience == as.audience.firstParty(), nil
+	}
+}
+
+// ForwardAuth answers a reverse proxy's subrequest. 200 carries the identity in X-User,
+// X-User-ID, X-Roles and X-Scopes for the proxy to copy upstream; 401 carries the login
+// URL. Optional role and scope query parameters make the check require them (403 if missing);
+// an optional audience parameter rejects bearer tokens issued for other resource servers (401).
+func (as *AuthService) ForwardAuth(config ForwardAuthConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+
+		identity := as.forwardIdentity(c)
+		if identity == nil {
+			as.forwardUnauthenticated(c, config)
+			return
+		}
+		user, err := as.getUserByID(c.Request.Context(), identity.userID)
+		if err != nil || !user.IsActive {
+			as.forwardUnauthenticated(c, config)
+			return
+		}
+		if audience := c.Query("audience"); audience != "" {
+			allowed, err := as.forwardAudienceAllowed(c, identity, audience)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+				return
+			}
+			if !allowed {
+				forwardAuthRequests.WithLabelValues("unauthenticated").Inc()
+				abortWithBearerError(c, http.StatusUnauthorized, bearerErrorInvalidToken, "The access token is not intended for this resource", "")
+				return
+			}
+		}
+		roles, err := as.getUserRoles(c.Request.Context(), user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+
+		for _, role := range c.QueryArray("role") {
+			if !contains(roles, role) {
+				forwardAuthRequests.WithLabelValues("forbidden").Inc()
+				c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_role", "error_description": "Role " + role + " is required"})
+				return
+			}
+		}
+		for _, scope := range c.QueryArray("scope") {
+			if identity.scopes != nil && !identity.set.has(scope) {
+				forwardAuthRequests.WithLabelValues("forbidden").Inc()
+				abortWithBearerError(c, http.StatusForbidden, bearerErrorInsufficientScope, "Scope "+scope+" is required", scope)
+				return
+			}
+		}
+
+		forwardAuthRequests.WithLabelValues("allowed").Inc()
+		c.Header("X-User", user.Username)
+		c.Header("X-User-ID", user.ID.String())
+		c.Header("X-Roles", strings.Join(roles, ","))
+		c.Header("X-Scopes", strings.Join(identity.scopes, " "))
+		c.Status(http.StatusOK)
+	}
+}
+
+func (as *AuthService) forwardUnauthenticated(c *gin.Context, config ForwardAuthConfig) {
+	forwardAuthRequests.WithLabelValues("unauthenticated").Inc()
+	loginURL := config.loginURL(forwardedURL(c.Request))
+	setBearerChallenge(c, "", "", "")
+	c.Header("X-Login-URL", loginURL)
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error":             "login_required",
+		"error_description": "Log in to continue",
+		"login_url":         loginURL,
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type ForwardAuthTestSuite struct {
+	suite.Suite
+	config ForwardAuthConfig
+	router *gin.Engine
+}
+
+func (suite *ForwardAuthTestSuite) SetupSuite() {
+	gin.SetMode(gin.TestMode)
+
+	suite.config = ForwardAuthConfig{
+		Enabled:       true,
+		LoginURL:      "https://auth.example.com/login",
+		ReturnDomains: []string{"example.com"},
+	}
+	suite.router = gin.New()
+	suite.router.Any("/auth/forward", (&AuthService{}).ForwardAuth(suite.config))
+}
+
+func (suite *ForwardAuthTestSuite) TestForwardedURL() {
+	nginx := httptest.NewRequest(http.MethodGet, "/auth/forward", nil)
+	nginx.Header.Set("X-Original-URL", "https://wiki.example.com/page?id=1")
+	suite.Equal("https://wiki.example.com/page?id=1", forwardedURL(nginx).String())
+
+	traefik := httptest.NewRequest(http.MethodGet, "/auth/forward", nil)
+	traefik.Header.Set("X-Forwarded-Proto", "http")
+	traefik.Header.Set("X-Forwarded-Host", "grafana.example.com")
+	traefik.Header.Set("X-Forwarded-Uri", "/d/abc")
+	suite.Equal("http://grafana.example.com/d/abc", forwardedURL(traefik).String())
+
+	suite.Nil(forwardedURL(httptest.NewRequest(http.MethodGet, "/auth/forward", nil)))
+}
+
+func (suite *ForwardAuthTestSuite) TestLoginURLOnlyReturnsToKnownDomains() {
+	inside, _ := url.Parse("https://wiki.example.com/page")
+	suite.Equal("https://auth.example.com/login?rd="+url.QueryEscape("https://wiki.example.com/page"), suite.config.loginURL(inside))
+
+	apex, _ := url.Parse("https://example.com/")
+	suite.Contains(suite.config.loginURL(apex), "rd=")
+
+	outside, _ := url.Parse("https://example.com.evil.net/page")
+	suite.Equal("https://auth.example.com/login", suite.config.loginURL(outside))
+
+	script, _ := url.Parse("javascript://example.com/%0aalert(1)")
+	suite.Equal("https://auth.example.com/login", suite.config.loginURL(script))
+	suite.Equal("https://auth.example.com/login", suite.config.loginURL(nil))
+}
+
+func (suite *ForwardAuthTestSuite) TestUnauthenticatedGetsLoginURL() {
+	req := httptest.NewRequest(http.MethodGet, "/auth/forward", nil)
+	req.Header.Set("X-Forwarded-Host", "wiki.example.com")
+	req.Header.Set("X-Forwarded-Uri", "/page")
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+
+	suite.Equal(http.StatusUnauthorized, w.Code)
+	suite.Equal("no-store", w.Header().Get("Cache-Control"))
+	suite.NotEmpty(w.Header().Get("WWW-Authenticate"))
+	suite.Empty(w.Header().Get("X-User"))
+
+	expected := "https://auth.example.com/login?rd=" + url.QueryEscape("https://wiki.example.com/page")
+	suite.Equal(expected, w.Header().Get("X-Login-URL"))
+	var body map[string]string
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &body))
+	suite.Equal("login_required", body["error"])
+	suite.Equal(expected, body["login_url"])
+}
+
+func TestForwardAuth(t *testing.T) {
+	suite.Run(t, new(ForwardAuthTestSuite))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// GuestTokenConfig controls anonymous read-only tokens for visitors who have not registered
+type GuestTokenConfig struct {
+	Enabled bool
+	TTL     time.Duration
+	// Scopes is fixed; guests cannot request anything else
+	Scopes []string
+	// PerIPLimit caps issuance per client IP within PerIPWindow
+	PerIPLimit  int
+	PerIPWindow time.Duration
+}
+
+// DefaultGuestTokenConfig reads guest token settings from the environment
+func DefaultGuestTokenConfig() GuestTokenConfig {
+	ttl, err := time.ParseDuration(getEnv("GUEST_TOKEN_TTL", "30m"))
+	if err != nil || ttl <= 0 {
+		ttl = 30 * time.Minute
+	}
+	limit, err := strconv.Atoi(getEnv("GUEST_TOKEN_RATE_LIMIT", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+
+	return GuestTokenConfig{
+		Enabled:     getEnv("GUEST_TOKENS_ENABLED", "false") == "true",
+		TTL:         ttl,
+		Scopes:      strings.Fields(getEnv("GUEST_TOKEN_SCOPES", "read")),
+		PerIPLimit:  limit,
+		PerIPWindow: time.Hour,
+	}
+}
+
+// guestToken is the Redis record behind an opaque guest access token
+type guestToken struct {
+	ID            uuid.UUID `json:"id"`
+	Subject       string    `json:"sub"`
+	CorrelationID string    `json:"correlation_id"`
+	Scopes        []string  `json:"scopes"`
+	IPAddress     string    `json:"ip_address"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// guestTokenKey stores guests under a hash so Redis contents cannot be replayed as tokens
+func guestTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "guest_token:" + hex.EncodeToString(sum[:])
+}
+
+// IssueGuestToken creates a short-lived anonymous token with the fixed guest scopes
+func (as *AuthService) IssueGuestToken(config GuestTokenConfig) gin.HandlerFunc {
+	limiter := &RateLimitManager{redisClient: as.redis, serviceName: "auth-service"}
+	limit := RateLimitConfig{Tier: "guest", Requests: config.PerIPLimit, Window: config.PerIPWindow}
+
+	return func(c *gin.Context) {
+		clientIP := GetClientIP(c.Request)
+		headers, err := limiter.checkLimitWithConfig(fmt.Sprintf("rate_limit:auth-service:guest:%s", clientIP), limit)
+		headers.apply(c, err != nil)
+		if err != nil {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":             "rate_limit_exceeded",
+				"error_description": "Too many guest tokens requested from this address",
+				"reset":             headers.Reset,
+				"retry_after":       headers.RetryAfter(),
+			})
+			return
+		}
+
+		tokenStr, err := generateSecureToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":             "server_error",
+				"error_description": "Failed to generate token",
+			})
+			return
+		}
+
+		now := time.Now()
+		id := uuid.New()
+		guest := guestToken{
+			ID:            id,
+			Subject:       "guest:" + id.String(),
+			CorrelationID: uuid.New().String(),
+			Scopes:        config.Scopes,
+			IPAddress:     clientIP,
+			CreatedAt:     now,
+			ExpiresAt:     now.Add(config.TTL),
+		}
+
+		guestJSON, _ := json.Marshal(guest)
+		if err := as.redis.Set(c.Request.Context(), guestTokenKey(tokenStr), guestJSON, config.TTL).Err(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":             "server_error",
+				"error_description": "Failed to store token",
+			})
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"access_token":   tokenStr,
+			"token_type":     "Bearer",
+			"expires_in":     int(config.TTL.Seconds()),
+			"scope":          strings.Join(guest.Scopes, " "),
+			"correlation_id": guest.CorrelationID,
+		})
+	}
+}
+
+// UpgradeGuestToken exchanges a guest token for a user token once the visitor has logged in.
+// The guest token is consumed and its correlation ID carried into the new token.
+func (as *AuthService) UpgradeGuestToken(c *gin.Context) {
+	var req struct {
+		GuestToken string `json:"guest_token" form:"guest_token" binding:"required"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": "guest_token is required",
+		})
+		return
+	}
+
+	userID := c.MustGet("user_id").(uuid.UUID)
+	ctx := c.Request.Context()
+
+	guestJSON, err := as.redis.GetDel(ctx, guestTokenKey(req.GuestToken)).Result()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_grant",
+			"error_description": "Guest token is invalid, expired or already upgraded",
+		})
+		return
+	}
+
+	var guest guestToken
+	if err := json.Unmarshal([]byte(guestJSON), &guest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_grant",
+			"error_description": "Guest token is invalid, expired or already upgraded",
+		})
+		return
+	}
+
+	// Keep the guest-to-user link so pre-registration activity can be attributed later
+	as.redis.Set(ctx, fmt.Sprintf("guest_link:%s", guest.CorrelationID), userID.String(), 30*24*time.Hour)
+	log.Printf("Guest %s upgraded to user %s (correlation %s)", guest.Subject, userID, guest.CorrelationID)
+
+	expiresIn := 30 * 24 * time.Hour
+	accessToken, err := as.issueUserToken(ctx, userID, expiresIn, map[string]interface{}{
+		"correlation_id": guest.CorrelationID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token_generation_failed"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":   accessToken,
+		"token_type":     "Bearer",
+		"expires_in":     int(expiresIn.Seconds()),
+		"correlation_id": guest.CorrelationID,
+	})
+}
+
+// lookupGuestToken returns the guest record for an opaque token, or nil if it is unknown or expired
+func (as *AuthService) lookupGuestToken(ctx context.Context, token string) *guestToken {
+	guestJSON, err := as.redis.Get(ctx, guestTokenKey(token)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Failed to look up guest token: %v", err)
+		}
+		return nil
+	}
+
+	var guest guestToken
+	if err := json.Unmarshal([]byte(guestJSON), &guest); err != nil || time.Now().After(guest.ExpiresAt) {
+		return nil
+	}
+	return &guest
+}
+
+// revokeGuestToken deletes a guest token, reporting whether one existed
+func (as *AuthService) revokeGuestToken(ctx context.Context, token string) bool {
+	deleted, err := as.redis.Del(ctx, guestTokenKey(token)).Result()
+	return err == nil && deleted > 0
+}
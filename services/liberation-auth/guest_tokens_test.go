@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type GuestTokenTestSuite struct {
+	suite.Suite
+	server *miniredis.Miniredis
+	redis  *redis.Client
+	as     *AuthService
+	router *gin.Engine
+	userID uuid.UUID
+}
+
+func (suite *GuestTokenTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.server = miniredis.RunT(suite.T())
+	suite.redis = redis.NewClient(&redis.Options{Addr: suite.server.Addr()})
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	// The user's sign-out epoch is cached, so upgrades need no database
+	suite.as = &AuthService{
+		redis:      suite.redis,
+		jwt:        NewJWTManagerWithKey(key, "https://auth.example.org"),
+		audience:   AudienceConfig{FirstParty: "archive"},
+		tokenCache: newTokenCache(time.Minute, 100),
+	}
+	suite.userID = uuid.New()
+	suite.as.tokenCache.putEpoch(suite.userID, 1)
+
+	suite.router = gin.New()
+	suite.router.POST("/auth/guest", suite.as.IssueGuestToken(GuestTokenConfig{
+		Enabled: true, TTL: 30 * time.Minute, Scopes: []string{"read"}, PerIPLimit: 2, PerIPWindow: time.Hour,
+	}))
+	suite.router.POST("/guest/upgrade", func(c *gin.Context) {
+		c.Set("user_id", suite.userID)
+	}, suite.as.UpgradeGuestToken)
+}
+
+func (suite *GuestTokenTestSuite) TearDownTest() {
+	suite.redis.Close()
+}
+
+func (suite *GuestTokenTestSuite) issue(ip string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(http.MethodPost, "/auth/guest", nil)
+	req.RemoteAddr = ip + ":40000"
+	recorder := httptest.NewRecorder()
+	suite.router.ServeHTTP(recorder, req)
+	var body map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return recorder, body
+}
+
+func (suite *GuestTokenTestSuite) upgrade(token string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req := httptest.NewRequest(http.MethodPost, "/guest/upgrade", strings.NewReader(url.Values{"guest_token": {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	suite.router.ServeHTTP(recorder, req)
+	var body map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return recorder, body
+}
+
+func (suite *GuestTokenTestSuite) TestIssuance() {
+	recorder, body := suite.issue("192.0.2.1")
+	suite.Require().Equal(http.StatusOK, recorder.Code)
+	suite.Equal("no-store", recorder.Header().Get("Cache-Control"))
+	suite.Equal("read", body["scope"])
+	suite.Equal(float64(1800), body["expires_in"])
+
+	token := body["access_token"].(string)
+	suite.False(suite.server.Exists("guest_token:"+token), "tokens are stored under their hash")
+	suite.True(suite.server.Exists(guestTokenKey(token)))
+
+	guest := suite.as.lookupGuestToken(context.Background(), token)
+	suite.Require().NotNil(guest)
+	suite.Equal([]string{"read"}, guest.Scopes)
+	suite.Equal("192.0.2.1", guest.IPAddress)
+	suite.Equal(body["correlation_id"], guest.CorrelationID)
+	suite.True(strings.HasPrefix(guest.Subject, "guest:"))
+
+	suite.server.FastForward(31 * time.Minute)
+	suite.Nil(suite.as.lookupGuestToken(context.Background(), token), "guest tokens expire")
+}
+
+func (suite *GuestTokenTestSuite) TestIssuanceIsCappedPerIP() {
+	for i := 0; i < 2; i++ {
+		recorder, _ := suite.issue("192.0.2.1")
+		suite.Require().Equal(http.StatusOK, recorder.Code)
+	}
+	recorder, body := suite.issue("192.0.2.1")
+	suite.Equal(http.StatusTooManyRequests, recorder.Code)
+	suite.Equal("rate_limit_exceeded", body["error"])
+	suite.NotEmpty(recorder.Header().Get("Retry-After"))
+
+	recorder, _ = suite.issue("192.0.2.2")
+	suite.Equal(http.StatusOK, recorder.Code, "other addresses have their own allowance")
+}
+
+func (suite *GuestTokenTestSuite) TestUpgradeConsumesTheGuestToken() {
+	_, issued := suite.issue("192.0.2.1")
+	token := issued["access_token"].(string)
+
+	recorder, body := suite.upgrade(token)
+	suite.Require().Equal(http.StatusOK, recorder.Code, recorder.Body.String())
+	suite.Equal(issued["correlation_id"], body["correlation_id"])
+	_, userID, err := suite.as.validateUserJWT(context.Background(), body["access_token"].(string), "archive")
+	suite.Require().NoError(err)
+	suite.Equal(suite.userID, userID)
+	linked, err := suite.server.Get("guest_link:" + issued["correlation_id"].(string))
+	suite.Require().NoError(err)
+	suite.Equal(suite.userID.String(), linked)
+
+	suite.Nil(suite.as.lookupGuestToken(context.Background(), token), "the guest token no longer works")
+	recorder, body = suite.upgrade(token)
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	suite.Equal("invalid_grant", body["error"])
+}
+
+func (suite *GuestTokenTestSuite) TestUpgradeRejectsUnknownTokens() {
+	recorder, body := suite.upgrade("not-a-guest-token")
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	suite.Equal("invalid_grant", body["error"])
+
+	recorder, body = suite.upgrade("")
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	suite.Equal("invalid_request", body["error"])
+}
+
+func (suite *GuestTokenTestSuite) TestRevocation() {
+	_, issued := suite.issue("192.0.2.1")
+	token := issued["access_token"].(string)
+
+	suite.True(suite.as.revokeGuestToken(context.Background(), token))
+	suite.Nil(suite.as.lookupGuestToken(context.Background(), token))
+	suite.False(suite.as.revokeGuestToken(context.Background(), token))
+}
+
+func TestGuestTokenTestSuite(t *testing.T) {
+	suite.Run(t, new(GuestTokenTestSuite))
+}
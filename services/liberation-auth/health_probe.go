@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// healthProbeTables are copied into the sandbox schema; the probe's connections
+// can only see these, so a probe run never writes to the real tables
+var healthProbeTables = []string{"users", "oauth_clients", "oauth_access_tokens", "oauth_refresh_tokens", "client_claim_policies"}
+
+var healthProbeRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_health_probe_runs_total",
+	Help: "Synthetic /health/deep probe runs, by outcome (healthy, unhealthy).",
+}, []string{"outcome"})
+
+var schemaNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// HealthProbeConfig controls the synthetic end-to-end probe behind /health/deep
+type HealthProbeConfig struct {
+	// Token must be presented as a bearer token; the probe is disabled without one
+	Token  string
+	Schema string
+	// Timeout bounds one probe run
+	Timeout time.Duration
+	// MinInterval serves the previous result to callers arriving sooner, so the probe cannot be used to load the service
+	MinInterval time.Duration
+}
+
+// DefaultHealthProbeConfig reads probe settings from the environment
+func DefaultHealthProbeConfig() (HealthProbeConfig, error) {
+	config := HealthProbeConfig{
+		Token:  getEnv("HEALTH_PROBE_TOKEN", ""),
+		Schema: getEnv("HEALTH_PROBE_SCHEMA", "health_probe"),
+	}
+	if !schemaNamePattern.MatchString(config.Schema) || config.Schema == "public" {
+		return config, fmt.Errorf("HEALTH_PROBE_SCHEMA must be a lowercase identifier other than public")
+	}
+
+	var err error
+	if config.Timeout, err = time.ParseDuration(getEnv("HEALTH_PROBE_TIMEOUT", "10s")); err != nil {
+		return config, fmt.Errorf("HEALTH_PROBE_TIMEOUT: %w", err)
+	}
+	if config.MinInterval, err = time.ParseDuration(getEnv("HEALTH_PROBE_MIN_INTERVAL", "10s")); err != nil {
+		return config, fmt.Errorf("HEALTH_PROBE_MIN_INTERVAL: %w", err)
+	}
+	return config, nil
+}
+
+// sandboxDSN restricts a connection string's search_path to the sandbox schema
+func sandboxDSN(dbURL, schema string) (string, error) {
+	if strings.HasPrefix(dbURL, "postgres://") || strings.HasPrefix(dbURL, "postgresql://") {
+		parsed, err := url.Parse(dbURL)
+		if err != nil {
+			return "", err
+		}
+		query := parsed.Query()
+		query.Set("search_path", schema)
+		parsed.RawQuery = query.Encode()
+		return parsed.String(), nil
+	}
+	return dbURL + " search_path=" + schema, nil
+}
+
+// HealthProbe runs login, token issuance and introspection against a sandbox schema
+type HealthProbe struct {
+	config  HealthProbeConfig
+	db      *sql.DB // the service's own pool, used to prepare the sandbox
+	sandbox *sql.DB
+	// service is a copy of the auth service bound to the sandbox pool
+	service *AuthService
+	router  *gin.Engine
+
+	mu         sync.Mutex
+	prepared   bool
+	lastRun    time.Time
+	lastResult healthProbeResult
+}
+
+type healthProbeStep struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type healthProbeResult struct {
+	Status     string            `json:"status"`
+	Steps      []healthProbeStep `json:"steps"`
+	DurationMS float64           `json:"duration_ms"`
+	CheckedAt  time.Time         `json:"checked_at"`
+}
+
+// NewHealthProbe creates the probe; it returns nil when HEALTH_PROBE_TOKEN is unset
+func NewHealthProbe(as *AuthService, dbURL string, config HealthProbeConfig) (*HealthProbe, error) {
+	if config.Token == "" {
+		return nil, nil
+	}
+	dsn, err := sandboxDSN(dbURL, config.Schema)
+	if err != nil {
+		return nil, err
+	}
+	sandbox, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	sandbox.SetMaxOpenConns(2)
+	sandbox.SetMaxIdleConns(1)
+
+	// The probe drives the real handlers, with the sandbox pool and no shared cache
+	probeService := *as
+	probeService.db = sandbox
+	probeService.tokenCache = nil
+	probeService.sharedTokens = nil
+	probeService.caches = nil
+	probeService.conformance = nil
+
+	router := gin.New()
+	router.POST("/login", probeService.Login)
+	router.GET("/me", JWTAuthMiddleware(&probeService), probeService.GetProfile)
+	router.POST("/introspect", probeService.Introspect)
+
+	return &HealthProbe{config: config, db: as.db, sandbox: sandbox, service: &probeService, router: router}, nil
+}
+
+// Handler serves /health/deep
+func (hp *HealthProbe) Handler(c *gin.Context) {
+	token := extractBearerToken(c.GetHeader("Authorization"))
+	if subtle.ConstantTimeCompare([]byte(token), []byte(hp.config.Token)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized", "error_description": "A valid probe token is required"})
+		return
+	}
+
+	result := hp.check(c.Request.Context())
+	status := http.StatusOK
+	if result.Status != "healthy" {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, result)
+}
+
+// check runs the probe, or returns the last result if it ran within MinInterval
+func (hp *HealthProbe) check(ctx context.Context) healthProbeResult {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	if !hp.lastRun.IsZero() && time.Since(hp.lastRun) < hp.config.MinInterval {
+		return hp.lastResult
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hp.config.Timeout)
+	defer cancel()
+	hp.lastResult = hp.run(ctx)
+	hp.lastRun = time.Now()
+	healthProbeRunsTotal.WithLabelValues(hp.lastResult.Status).Inc()
+	return hp.lastResult
+}
+
+func (hp *HealthProbe) run(ctx context.Context) (result healthProbeResult) {
+	started := time.Now()
+	result = healthProbeResult{Status: "healthy", CheckedAt: started}
+	defer func() { result.DurationMS = msSince(started) }()
+
+	step := func(name string, fn func() error) bool {
+		stepStarted := time.Now()
+		err := fn()
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		s := healthProbeStep{Name: name, OK: err == nil, DurationMS: msSince(stepStarted)}
+		if err != nil {
+			s.Error = err.Error()
+			result.Status = "unhealthy"
+		}
+		result.Steps = append(result.Steps, s)
+		return err == nil
+	}
+
+	if !step("sandbox", func() error { return hp.prepare(ctx) }) {
+		return result
+	}
+
+	run := newProbeRun()
+	defer step("cleanup", func() error { return hp.cleanup(run) })
+
+	var jwtToken, accessToken string
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"create_user", func() error { return hp.createFixtures(ctx, run) }},
+		{"login", func() error {
+			var response struct {
+				AccessToken string `json:"access_token"`
+			}
+			body, _ := json.Marshal(gin.H{"email": run.email, "password": run.password})
+			if err := hp.call(ctx, "POST", "/login", "application/json", body, "", &response); err != nil {
+				return err
+			}
+			jwtToken = response.AccessToken
+			return nil
+		}},
+		{"authenticate", func() error {
+			return hp.call(ctx, "GET", "/me", "", nil, jwtToken, nil)
+		}},
+		{"issue_token", func() error {
+			token, _, err := hp.service.generateTokens(ctx, run.userID, run.clientID, []string{"read"}, nil, "127.0.0.1", "health-probe")
+			if err == nil {
+				accessToken = token.Token
+			}
+			return err
+		}},
+		{"introspect", func() error {
+			var response struct {
+				Active bool   `json:"active"`
+				Sub    string `json:"sub"`
+			}
+			form := url.Values{"token": {accessToken}, "client_id": {run.clientID.String()}, "client_secret": {run.clientSecret}}
+			if err := hp.call(ctx, "POST", "/introspect", "application/x-www-form-urlencoded", []byte(form.Encode()), "", &response); err != nil {
+				return err
+			}
+			if !response.Active || response.Sub != run.userID.String() {
+				return fmt.Errorf("token introspected as inactive or for the wrong subject")
+			}
+			return nil
+		}},
+	}
+	for _, s := range steps {
+		if !step(s.name, s.fn) {
+			break
+		}
+	}
+	return result
+}
+
+// prepare creates the sandbox schema and tables once per process
+func (hp *HealthProbe) prepare(ctx context.Context) error {
+	if hp.prepared {
+		return nil
+	}
+	schema := pq.QuoteIdentifier(hp.config.Schema)
+	if _, err := hp.db.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+schema); err != nil {
+		return err
+	}
+	for _, table := range healthProbeTables {
+		statement := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s (LIKE public.%s INCLUDING ALL)", schema, table, table)
+		if _, err := hp.db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	// Sandboxes created before email blind indexes existed lack the column
+	if _, err := hp.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s.users ADD COLUMN IF NOT EXISTS email_bidx TEXT", schema)); err != nil {
+		return fmt.Errorf("users: %w", err)
+	}
+	hp.prepared = true
+	return nil
+}
+
+// probeRun holds the ephemeral user and client of one run
+type probeRun struct {
+	userID       uuid.UUID
+	clientID     uuid.UUID
+	email        string
+	password     string
+	clientSecret string
+}
+
+func newProbeRun() *probeRun {
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	run := &probeRun{userID: uuid.New(), clientID: uuid.New(), password: hex.EncodeToString(secret)}
+	rand.Read(secret)
+	run.clientSecret = hex.EncodeToString(secret)
+	run.email = fmt.Sprintf("probe-%s@health.invalid", run.userID.String()[:8])
+	return run
+}
+
+// createFixtures inserts the run's user and client. Minimum bcrypt cost keeps frequent probes cheap;
+// verification still goes through the normal comparison.
+func (hp *HealthProbe) createFixtures(ctx context.Context, run *probeRun) error {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(run.password), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	secretHash, err := bcrypt.GenerateFromPassword([]byte(run.clientSecret), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = hp.sandbox.ExecContext(ctx, `
+		INSERT INTO users (id, username, email, email_bidx, password_hash, display_name, is_active, is_verified, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, 'Health Probe', true, true, $6, $6)`,
+		run.userID, "probe_"+run.userID.String()[:8], hp.service.pii.value(piiUserEmail, run.email), hp.service.pii.index(piiUserEmail, run.email),
+		string(passwordHash), now)
+	if err != nil {
+		return err
+	}
+	_, err = hp.sandbox.ExecContext(ctx, `
+		INSERT INTO oauth_clients (
+			client_id, client_secret, client_name, description, website, logo_url,
+			redirect_uris, scopes, grant_types, response_types, is_public, is_confidential,
+			is_trusted, is_first_party, owner_id, access_token_ttl, refresh_token_ttl,
+			is_active, created_at, updated_at
+		) VALUES ($1, $2, 'Health Probe', '', '', '', $3, $4, $5, $6, false, true, true, true, $7, 300, 300, true, $8, $8)`,
+		run.clientID, string(secretHash), pq.Array([]string{"https://health.invalid/callback"}),
+		pq.Array([]string{"read"}), pq.Array([]string{"authorization_code"}), pq.Array([]string{"code"}),
+		run.userID, now)
+	return err
+}
+
+// cleanup removes everything the run created, even if the request context has ended
+func (hp *HealthProbe) cleanup(run *probeRun) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hp.config.Timeout)
+	defer cancel()
+
+	for _, statement := range []string{
+		`DELETE FROM oauth_refresh_tokens WHERE client_id = $1`,
+		`DELETE FROM oauth_access_tokens WHERE client_id = $1`,
+		`DELETE FROM oauth_clients WHERE client_id = $1`,
+	} {
+		if _, err := hp.sandbox.ExecContext(ctx, statement, run.clientID); err != nil {
+			return err
+		}
+	}
+	_, err := hp.sandbox.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, run.userID)
+	return err
+}
+
+// call sends one request through the probe router and decodes a 200 response into out
+func (hp *HealthProbe) call(ctx context.Context, method, path, contentType string, body []byte, bearer string, out interface{}) error {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body)).WithContext(ctx)
+	req.RemoteAddr = "127.0.0.1:0"
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	w := httptest.NewRecorder()
+	hp.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("%s %s returned %d", method, path, w.Code)
+	}
+	if out != nil {
+		return json.Unmarshal(w.Body.Bytes(), out)
+	}
+	return nil
+}
+
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type HealthProbeTestSuite struct {
+	suite.Suite
+}
+
+func (suite *HealthProbeTestSuite) TestSandboxDSNRestrictsSearchPath() {
+	dsn, err := sandboxDSN("postgres://ao3:secret@db/ao3?sslmode=disable", "health_probe")
+	suite.Require().NoError(err)
+	suite.Equal("postgres://ao3:secret@db/ao3?search_path=health_probe&sslmode=disable", dsn)
+
+	dsn, err = sandboxDSN("host=db dbname=ao3 search_path=public", "health_probe")
+	suite.Require().NoError(err)
+	suite.Equal("host=db dbname=ao3 search_path=public search_path=health_probe", dsn, "the last setting wins")
+}
+
+func (suite *HealthProbeTestSuite) TestSchemaMustNotBePublic() {
+	suite.T().Setenv("HEALTH_PROBE_SCHEMA", "public")
+	_, err := DefaultHealthProbeConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("HEALTH_PROBE_SCHEMA", "probe; DROP SCHEMA public")
+	_, err = DefaultHealthProbeConfig()
+	suite.Error(err)
+}
+
+func (suite *HealthProbeTestSuite) TestProbeTokenIsRequired() {
+	gin.SetMode(gin.TestMode)
+	probe := &HealthProbe{config: HealthProbeConfig{Token: "probe-token", MinInterval: time.Minute}}
+	router := gin.New()
+	router.GET("/health/deep", probe.Handler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health/deep", nil))
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	// A recent result is served without running the probe again
+	probe.lastRun = time.Now()
+	probe.lastResult = healthProbeResult{Status: "unhealthy", Steps: []healthProbeStep{{Name: "login", Error: "POST /login returned 500"}}}
+	req := httptest.NewRequest("GET", "/health/deep", nil)
+	req.Header.Set("Authorization", "Bearer probe-token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	suite.Equal(http.StatusServiceUnavailable, w.Code)
+	suite.Contains(w.Body.String(), "POST /login returned 500")
+}
+
+func TestHealthProbeTestSuite(t *testing.T) {
+	suite.Run(t, new(HealthProbeTestSuite))
+}
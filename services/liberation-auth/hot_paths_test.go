@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+
+	"nuclear-ao3/shared/models"
+)
+
+// Allocation budgets for the request hot paths, per call. They sit a little above what the
+// code allocates today; raise them deliberately, with the benchmark numbers in the commit.
+const (
+	tokenIssuanceAllocBudget   = 80
+	tokenValidationAllocBudget = 64
+	cachedTokenAllocBudget     = 4
+	scopeCheckAllocBudget      = 0
+)
+
+type hotPathFixture struct {
+	jwt         *JWTManager
+	authService *AuthService
+	userID      uuid.UUID
+	scopes      []string
+	jwtToken    string
+	opaqueToken string
+}
+
+func newHotPathFixture(tb testing.TB) *hotPathFixture {
+	tb.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	fixture := &hotPathFixture{
+		jwt:         NewJWTManagerWithKey(key, "https://auth.example.org"),
+		authService: &AuthService{tokenCache: newTokenCache(time.Hour, 1000)},
+		userID:      uuid.New(),
+		scopes:      []string{"read", "write", "works:manage"},
+		opaqueToken: "opaque-access-token",
+	}
+	if fixture.jwtToken, err = fixture.jwt.GenerateToken(fixture.userID, "liberation-ai", fixture.scopes, time.Hour); err != nil {
+		tb.Fatal(err)
+	}
+
+	// Introspection and bearer checks are answered from the token cache after the first lookup
+	fixture.authService.tokenCache.putToken(fixture.opaqueToken, &models.OAuthAccessToken{
+		ID:        uuid.New(),
+		ClientID:  uuid.New(),
+		UserID:    &fixture.userID,
+		Scopes:    fixture.scopes,
+		TokenType: "Bearer",
+		ExpiresAt: time.Now().Add(time.Hour),
+		CreatedAt: time.Now(),
+	})
+	return fixture
+}
+
+type HotPathTestSuite struct {
+	suite.Suite
+	fixture *hotPathFixture
+}
+
+func (suite *HotPathTestSuite) SetupSuite() {
+	suite.fixture = newHotPathFixture(suite.T())
+}
+
+func (suite *HotPathTestSuite) assertAllocs(name string, budget float64, fn func()) {
+	allocs := testing.AllocsPerRun(100, fn)
+	suite.LessOrEqualf(allocs, budget, "%s allocates %.0f times per call, budget is %.0f", name, allocs, budget)
+}
+
+func (suite *HotPathTestSuite) TestTokenIssuanceStaysWithinBudget() {
+	f := suite.fixture
+	suite.assertAllocs("GenerateToken", tokenIssuanceAllocBudget, func() {
+		f.jwt.GenerateToken(f.userID, "liberation-ai", f.scopes, time.Hour)
+	})
+}
+
+func (suite *HotPathTestSuite) TestTokenValidationStaysWithinBudget() {
+	f := suite.fixture
+	suite.assertAllocs("ValidateTokenForAudience", tokenValidationAllocBudget, func() {
+		f.jwt.ValidateTokenForAudience(f.jwtToken, "liberation-ai")
+	})
+}
+
+func (suite *HotPathTestSuite) TestCachedIntrospectionStaysWithinBudget() {
+	f := suite.fixture
+	ctx := context.Background()
+	_, scopes, err := f.authService.validateAccessTokenScopes(ctx, f.opaqueToken)
+	suite.Require().NoError(err)
+
+	suite.assertAllocs("validateAccessTokenScopes", cachedTokenAllocBudget, func() {
+		f.authService.validateAccessTokenScopes(ctx, f.opaqueToken)
+	})
+	suite.assertAllocs("scopeSet.hasAll", scopeCheckAllocBudget, func() {
+		scopes.hasAll("read", "works:manage")
+	})
+}
+
+func TestHotPathTestSuite(t *testing.T) {
+	suite.Run(t, new(HotPathTestSuite))
+}
+
+// Benchmarks for the request hot paths: go test -run '^$' -bench 'HotPath' -benchmem
+
+func BenchmarkHotPathTokenIssuance(b *testing.B) {
+	f := newHotPathFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.jwt.GenerateToken(f.userID, "liberation-ai", f.scopes, time.Hour); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHotPathTokenValidation(b *testing.B) {
+	f := newHotPathFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.jwt.ValidateTokenForAudience(f.jwtToken, "liberation-ai"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHotPathCachedIntrospection(b *testing.B) {
+	f := newHotPathFixture(b)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, scopes, err := f.authService.validateAccessTokenScopes(ctx, f.opaqueToken); err != nil || !scopes.has("read") {
+				b.Error("cached token was not accepted")
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"nuclear-ao3/shared/models"
+)
+
+// maxIntrospectBatch is the most tokens one batch introspection request may carry
+const maxIntrospectBatch = 100
+
+var batchIntrospectedTokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_batch_introspected_tokens_total",
+	Help: "Tokens checked by batch introspection, by result (active, inactive).",
+}, []string{"result"})
+
+// introspectBatchRequest is the body of POST /auth/introspect/batch. Clients authenticate
+// as they do for single introspection, in the body or with HTTP Basic.
+type introspectBatchRequest struct {
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Tokens       []string `json:"tokens"`
+}
+
+// validate rejects empty, oversized and malformed batches before the client is authenticated
+func (req introspectBatchRequest) validate() error {
+	if len(req.Tokens) == 0 {
+		return fmt.Errorf("tokens must list at least one token")
+	}
+	if len(req.Tokens) > maxIntrospectBatch {
+		return fmt.Errorf("at most %d tokens may be introspected per request", maxIntrospectBatch)
+	}
+	for i, token := range req.Tokens {
+		if token == "" {
+			return fmt.Errorf("tokens[%d] is empty", i)
+		}
+	}
+	return nil
+}
+
+// introspectionRateLimit is the bucket a batch is charged to: the authenticated client's,
+// at its tier, where each token counts as one request
+func introspectionRateLimit(client *models.OAuthClient) (string, RateLimitConfig) {
+	info := &ClientRateLimitInfo{
+		ClientID:     client.ID.String(),
+		IsFirstParty: client.IsFirstParty,
+		IsTrusted:    client.IsTrusted,
+	}
+	config := info.GetRateLimitConfig()
+	return fmt.Sprintf("rate_limit:auth-service:%s:%s", config.Tier, info.ClientID), config
+}
+
+// IntrospectBatch introspects up to maxIntrospectBatch tokens in one round trip. Results
+// come back in the order of the request, each exactly as /auth/introspect would return it.
+func (as *AuthService) IntrospectBatch() gin.HandlerFunc {
+	limiter := &RateLimitManager{redisClient: as.redis, serviceName: "auth-service"}
+
+	return func(c *gin.Context) {
+		var req introspectBatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":             "invalid_request",
+				"error_description": "Invalid batch introspection request",
+			})
+			return
+		}
+		if err := req.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":             "invalid_request",
+				"error_description": err.Error(),
+			})
+			return
+		}
+
+		introspector, err := as.authenticateClient(req.ClientID, req.ClientSecret, c.Request)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":             "invalid_client",
+				"error_description": "Client authentication failed",
+			})
+			return
+		}
+
+		key, limit := introspectionRateLimit(introspector)
+		headers, err := limiter.checkLimitWithCost(key, limit, len(req.Tokens))
+		headers.apply(c, err != nil)
+		if err != nil {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":             "rate_limit_exceeded",
+				"error_description": "Too many requests. Please try again later.",
+				"limit":             headers.Limit,
+				"reset":             headers.Reset,
+				"tier":              headers.Tier,
+				"retry_after":       headers.RetryAfter(),
+			})
+			return
+		}
+
+		server, err := as.resourceServerForClient(c.Request.Context(), introspector.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":             "server_error",
+				"error_description": "Failed to load resource server",
+			})
+			return
+		}
+
+		// Fan-in callers often repeat a token; each distinct token is looked up once
+		seen := make(map[string]interface{}, len(req.Tokens))
+		results := make([]interface{}, len(req.Tokens))
+		for i, token := range req.Tokens {
+			result, ok := seen[token]
+			if !ok {
+				result = as.introspectToken(c.Request.Context(), server, token)
+				seen[token] = result
+			}
+			results[i] = result
+
+			if introspectionActive(result) {
+				batchIntrospectedTokensTotal.WithLabelValues("active").Inc()
+			} else {
+				batchIntrospectedTokensTotal.WithLabelValues("inactive").Inc()
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"results": results})
+	}
+}
+
+// introspectionActive reports whether an introspectToken result is for an active token
+func introspectionActive(result interface{}) bool {
+	switch result := result.(type) {
+	case gin.H:
+		return result["active"] == true
+	case map[string]interface{}:
+		return result["active"] == true
+	case models.IntrospectResponse:
+		return result.Active
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+	"nuclear-ao3/shared/models"
+)
+
+type IntrospectBatchTestSuite struct {
+	suite.Suite
+}
+
+func (suite *IntrospectBatchTestSuite) TestBatchSizeIsBounded() {
+	suite.Error(introspectBatchRequest{}.validate())
+	suite.Error(introspectBatchRequest{Tokens: []string{"a", ""}}.validate())
+	suite.NoError(introspectBatchRequest{Tokens: []string{"a", "b"}}.validate())
+
+	tokens := strings.Split(strings.Repeat("t,", maxIntrospectBatch), ",")
+	suite.NoError(introspectBatchRequest{Tokens: tokens[:maxIntrospectBatch]}.validate())
+	suite.Error(introspectBatchRequest{Tokens: append(tokens[:maxIntrospectBatch], "t")}.validate())
+}
+
+func (suite *IntrospectBatchTestSuite) TestBatchIsChargedToTheClientTier() {
+	client := &models.OAuthClient{ID: uuid.New(), IsTrusted: true}
+	key, limit := introspectionRateLimit(client)
+
+	suite.Equal(RateLimitTierTrusted, limit.Tier)
+	suite.Equal("rate_limit:auth-service:trusted:"+client.ID.String(), key)
+}
+
+func (suite *IntrospectBatchTestSuite) TestEveryTokenCountsAgainstTheLimit() {
+	server := miniredis.RunT(suite.T())
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	limiter := &RateLimitManager{redisClient: client, serviceName: "auth-service"}
+	limit := RateLimitConfig{Tier: RateLimitTierPublic, Requests: 10, Window: time.Minute}
+
+	headers, err := limiter.checkLimitWithCost("rate_limit:test", limit, 8)
+	suite.Require().NoError(err)
+	suite.Equal(2, headers.Remaining)
+
+	// A batch that does not fit is refused whole, leaving the rest of the window usable
+	headers, err = limiter.checkLimitWithCost("rate_limit:test", limit, 3)
+	suite.Error(err)
+	suite.Equal(2, headers.Remaining)
+
+	_, err = limiter.checkLimitWithCost("rate_limit:test", limit, 2)
+	suite.NoError(err)
+}
+
+func (suite *IntrospectBatchTestSuite) TestActiveResultsAreRecognised() {
+	suite.True(introspectionActive(gin.H{"active": true, "guest": true}))
+	suite.True(introspectionActive(map[string]interface{}{"active": true}))
+	suite.False(introspectionActive(models.IntrospectResponse{Active: false}))
+}
+
+func TestIntrospectBatchTestSuite(t *testing.T) {
+	suite.Run(t, new(IntrospectBatchTestSuite))
+}
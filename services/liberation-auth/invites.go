@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	errInviteInvalid  = errors.New("invite is invalid, expired, revoked or used up")
+	errInviteRequired = errors.New("registration requires an invite")
+)
+
+// RegistrationConfig controls who may sign up and how invites behave
+type RegistrationConfig struct {
+	InviteOnly bool
+	// InviteBaseURL is the signup page invite links point at
+	InviteBaseURL string
+	// MaxActiveInvitesPerUser limits invites non-admins can have outstanding
+	MaxActiveInvitesPerUser int
+	// MaxUsesPerInvite caps max_uses on invites created by non-admins
+	MaxUsesPerInvite int
+	DefaultInviteTTL time.Duration
+}
+
+// DefaultRegistrationConfig reads registration settings from the environment
+func DefaultRegistrationConfig() RegistrationConfig {
+	maxActive, err := strconv.Atoi(getEnv("INVITES_PER_USER", "5"))
+	if err != nil || maxActive < 0 {
+		maxActive = 5
+	}
+
+	return RegistrationConfig{
+		InviteOnly:              getEnv("REGISTRATION_MODE", "open") == "invite_only",
+		InviteBaseURL:           getEnv("INVITE_BASE_URL", "https://nuclear-ao3.com/signup"),
+		MaxActiveInvitesPerUser: maxActive,
+		MaxUsesPerInvite:        10,
+		DefaultInviteTTL:        7 * 24 * time.Hour,
+	}
+}
+
+// Invite is an invitation to register, optionally presetting roles
+type Invite struct {
+	ID         uuid.UUID  `json:"id"`
+	CodePrefix string     `json:"code_prefix"`
+	CreatedBy  uuid.UUID  `json:"created_by"`
+	Email      string     `json:"email,omitempty"`
+	Roles      []string   `json:"roles"`
+	Note       string     `json:"note,omitempty"`
+	MaxUses    int        `json:"max_uses"`
+	Uses       int        `json:"uses"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Status     string     `json:"status"`
+}
+
+// InviteRedemption records a user who signed up with an invite
+type InviteRedemption struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Username   string    `json:"username"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+// CreateInviteRequest is the body for creating an invite
+type CreateInviteRequest struct {
+	MaxUses        int      `json:"max_uses"`
+	ExpiresInHours int      `json:"expires_in_hours"`
+	Roles          []string `json:"roles"`
+	Email          string   `json:"email"`
+	Note           string   `json:"note"`
+}
+
+const inviteColumns = `id, code_prefix, created_by, COALESCE(email, ''), roles, note, max_uses, uses, expires_at, revoked_at, created_at`
+
+// CreateInvite issues a new invite link. Only admins may preset roles.
+func (as *AuthService) CreateInvite(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	isAdmin := as.userHasRole(c.Request.Context(), userID, "admin")
+	if len(req.Roles) > 0 && !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "insufficient_permissions",
+			"error_description": "Only admins can create invites with preset roles",
+		})
+		return
+	}
+
+	if req.MaxUses <= 0 {
+		req.MaxUses = 1
+	}
+	if !isAdmin {
+		if req.MaxUses > as.registration.MaxUsesPerInvite {
+			req.MaxUses = as.registration.MaxUsesPerInvite
+		}
+
+		var active int
+		err := as.db.QueryRowContext(c.Request.Context(), `
+			SELECT COUNT(*) FROM user_invites
+			WHERE created_by = $1 AND revoked_at IS NULL AND uses < max_uses
+				AND (expires_at IS NULL OR expires_at > NOW())`, userID).Scan(&active)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check invite limit"})
+			return
+		}
+		if active >= as.registration.MaxActiveInvitesPerUser {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "invite_limit_reached",
+				"error_description": fmt.Sprintf("You can have at most %d active invites", as.registration.MaxActiveInvitesPerUser),
+			})
+			return
+		}
+	}
+
+	ttl := as.registration.DefaultInviteTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	code, err := generateInviteCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	invite := Invite{
+		ID:         uuid.New(),
+		CodePrefix: code[:6],
+		CreatedBy:  userID,
+		Email:      strings.ToLower(strings.TrimSpace(req.Email)),
+		Roles:      req.Roles,
+		Note:       req.Note,
+		MaxUses:    req.MaxUses,
+		ExpiresAt:  &expiresAt,
+		CreatedAt:  time.Now(),
+		Status:     "active",
+	}
+	if invite.Roles == nil {
+		invite.Roles = []string{}
+	}
+
+	_, err = as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO user_invites (id, code_hash, code_prefix, created_by, email, roles, note, max_uses, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10)`,
+		invite.ID, hashInviteCode(code), invite.CodePrefix, invite.CreatedBy, invite.Email,
+		pq.Array(invite.Roles), invite.Note, invite.MaxUses, invite.ExpiresAt, invite.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	// The code is only ever shown here; we store a hash
+	c.JSON(http.StatusCreated, gin.H{
+		"invite": invite,
+		"code":   code,
+		"url":    as.registration.InviteBaseURL + "?invite=" + url.QueryEscape(code),
+	})
+}
+
+// ListInvites returns the caller's invites
+func (as *AuthService) ListInvites(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	invites, err := as.queryInvites(c.Request.Context(), `WHERE created_by = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// AdminListInvites returns all invites, newest first
+func (as *AuthService) AdminListInvites(c *gin.Context) {
+	invites, err := as.queryInvites(c.Request.Context(), `ORDER BY created_at DESC LIMIT 500`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// GetInvite returns an invite with the users who redeemed it
+func (as *AuthService) GetInvite(c *gin.Context) {
+	invite, ok := as.loadOwnedInvite(c)
+	if !ok {
+		return
+	}
+
+	rows, err := as.db.QueryContext(c.Request.Context(), `
+		SELECT r.user_id, COALESCE(u.username, ''), r.redeemed_at
+		FROM user_invite_redemptions r
+		LEFT JOIN users u ON u.id = r.user_id
+		WHERE r.invite_id = $1
+		ORDER BY r.redeemed_at`, invite.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invite usage"})
+		return
+	}
+	defer rows.Close()
+
+	redemptions := []InviteRedemption{}
+	for rows.Next() {
+		var r InviteRedemption
+		if err := rows.Scan(&r.UserID, &r.Username, &r.RedeemedAt); err == nil {
+			redemptions = append(redemptions, r)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invite":      invite,
+		"redemptions": redemptions,
+	})
+}
+
+// RevokeInvite stops an invite from being redeemed
+func (as *AuthService) RevokeInvite(c *gin.Context) {
+	invite, ok := as.loadOwnedInvite(c)
+	if !ok {
+		return
+	}
+
+	if _, err := as.db.ExecContext(c.Request.Context(), `UPDATE user_invites SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, invite.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "invite revoked"})
+}
+
+// CheckInvite lets the signup page validate a code before asking for details
+func (as *AuthService) CheckInvite(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	invites, err := as.queryInvites(c.Request.Context(), `WHERE code_hash = $1`, hashInviteCode(req.Code))
+	if err != nil || len(invites) == 0 || invites[0].Status != "active" {
+		c.JSON(http.StatusOK, gin.H{"valid": false})
+		return
+	}
+
+	invite := invites[0]
+	c.JSON(http.StatusOK, gin.H{
+		"valid":          true,
+		"expires_at":     invite.ExpiresAt,
+		"remaining_uses": invite.MaxUses - invite.Uses,
+		"email_locked":   invite.Email != "",
+	})
+}
+
+// redeemInvite consumes one use of an invite inside the registration transaction and applies its roles
+func redeemInvite(ctx context.Context, tx *sql.Tx, code, email string, userID uuid.UUID) (*uuid.UUID, error) {
+	var inviteID uuid.UUID
+	var roles []string
+	err := tx.QueryRowContext(ctx, `
+		UPDATE user_invites SET uses = uses + 1
+		WHERE code_hash = $1 AND revoked_at IS NULL AND uses < max_uses
+			AND (expires_at IS NULL OR expires_at > NOW())
+			AND (email IS NULL OR email = $2)
+		RETURNING id, roles`, hashInviteCode(code), strings.ToLower(strings.TrimSpace(email))).Scan(&inviteID, pq.Array(&roles))
+	if err == sql.ErrNoRows {
+		return nil, errInviteInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO user_invite_redemptions (invite_id, user_id) VALUES ($1, $2)`, inviteID, userID); err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userID, role); err != nil {
+			return nil, err
+		}
+	}
+
+	return &inviteID, nil
+}
+
+// loadOwnedInvite fetches the :invite_id invite, allowing only its creator or an admin
+func (as *AuthService) loadOwnedInvite(c *gin.Context) (*Invite, bool) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	inviteID, err := uuid.Parse(c.Param("invite_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+		return nil, false
+	}
+
+	invites, err := as.queryInvites(c.Request.Context(), `WHERE id = $1`, inviteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invite"})
+		return nil, false
+	}
+	if len(invites) == 0 || (invites[0].CreatedBy != userID && !as.userHasRole(c.Request.Context(), userID, "admin")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return nil, false
+	}
+
+	return &invites[0], true
+}
+
+func (as *AuthService) queryInvites(ctx context.Context, where string, args ...interface{}) ([]Invite, error) {
+	rows, err := as.db.QueryContext(ctx, `SELECT `+inviteColumns+` FROM user_invites `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		var invite Invite
+		if err := rows.Scan(&invite.ID, &invite.CodePrefix, &invite.CreatedBy, &invite.Email, pq.Array(&invite.Roles),
+			&invite.Note, &invite.MaxUses, &invite.Uses, &invite.ExpiresAt, &invite.RevokedAt, &invite.CreatedAt); err != nil {
+			return nil, err
+		}
+		invite.Status = inviteStatus(&invite)
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func inviteStatus(invite *Invite) string {
+	switch {
+	case invite.RevokedAt != nil:
+		return "revoked"
+	case invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt):
+		return "expired"
+	case invite.Uses >= invite.MaxUses:
+		return "used"
+	default:
+		return "active"
+	}
+}
+
+func (as *AuthService) userHasRole(ctx context.Context, userID uuid.UUID, role string) bool {
+	roles, err := as.getUserRoles(ctx, userID)
+	return err == nil && contains(roles, role)
+}
+
+func generateInviteCode() (string, error) {
+	b := make([]byte, 15)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// hashInviteCode normalises codes so they survive being retyped by hand
+func hashInviteCode(code string) string {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestInviteStatus(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	for _, tc := range []struct {
+		invite Invite
+		want   string
+	}{
+		{Invite{MaxUses: 1, ExpiresAt: &future}, "active"},
+		{Invite{MaxUses: 1}, "active"},
+		{Invite{MaxUses: 1, ExpiresAt: &past}, "expired"},
+		{Invite{MaxUses: 2, Uses: 2, ExpiresAt: &future}, "used"},
+		{Invite{MaxUses: 1, Uses: 1, ExpiresAt: &past, RevokedAt: &past}, "revoked"},
+	} {
+		if got := inviteStatus(&tc.invite); got != tc.want {
+			t.Errorf("%+v: status %s, want %s", tc.invite, got, tc.want)
+		}
+	}
+
+	if hashInviteCode(" abcd-efgh ") != hashInviteCode("ABCDEFGH") {
+		t.Error("retyped codes should match regardless of case, dashes and spaces")
+	}
+}
+
+// InviteTestSuite runs against TEST_DATABASE_URL
+type InviteTestSuite struct {
+	suite.Suite
+	as     *AuthService
+	router *gin.Engine
+	owner  uuid.UUID
+}
+
+func (suite *InviteTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	db := openTestDatabase(suite.T())
+	suite.as = &AuthService{db: db, registration: RegistrationConfig{
+		InviteOnly: true, InviteBaseURL: "https://archive.example/signup",
+		MaxActiveInvitesPerUser: 2, MaxUsesPerInvite: 3, DefaultInviteTTL: 24 * time.Hour,
+	}}
+	suite.owner = createTestUser(suite.T(), db)
+	suite.T().Cleanup(func() {
+		db.Exec(`DELETE FROM user_invites WHERE created_by = $1`, suite.owner)
+	})
+
+	suite.router = gin.New()
+	suite.router.Use(func(c *gin.Context) {
+		if id, err := uuid.Parse(c.GetHeader("X-Test-User")); err == nil {
+			c.Set("user_id", id)
+		}
+	})
+	suite.router.POST("/invites", suite.as.CreateInvite)
+	suite.router.DELETE("/invites/:invite_id", suite.as.RevokeInvite)
+	suite.router.POST("/invites/check", suite.as.CheckInvite)
+}
+
+func (suite *InviteTestSuite) do(method, path string, userID uuid.UUID, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", userID.String())
+	recorder := httptest.NewRecorder()
+	suite.router.ServeHTTP(recorder, req)
+	var response map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	return recorder, response
+}
+
+// create issues an invite as the owner and returns its code and ID
+func (suite *InviteTestSuite) create(req CreateInviteRequest) (string, string) {
+	recorder, body := suite.do(http.MethodPost, "/invites", suite.owner, req)
+	suite.Require().Equal(http.StatusCreated, recorder.Code, recorder.Body.String())
+	return body["code"].(string), body["invite"].(map[string]interface{})["id"].(string)
+}
+
+func (suite *InviteTestSuite) check(code string) bool {
+	_, body := suite.do(http.MethodPost, "/invites/check", suite.owner, gin.H{"code": code})
+	return body["valid"] == true
+}
+
+// redeem registers a new user with the invite, as registration does
+func (suite *InviteTestSuite) redeem(code, email string) error {
+	userID := createTestUser(suite.T(), suite.as.db)
+	tx, err := suite.as.db.BeginTx(context.Background(), nil)
+	suite.Require().NoError(err)
+	defer tx.Rollback()
+	if _, err := redeemInvite(context.Background(), tx, code, email, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (suite *InviteTestSuite) TestCreation() {
+	recorder, body := suite.do(http.MethodPost, "/invites", suite.owner, CreateInviteRequest{MaxUses: 50, Email: " Friend@Example.com "})
+	suite.Require().Equal(http.StatusCreated, recorder.Code, recorder.Body.String())
+	code := body["code"].(string)
+	invite := body["invite"].(map[string]interface{})
+	suite.Equal("https://archive.example/signup?invite="+code, body["url"])
+	suite.Equal(code[:6], invite["code_prefix"])
+	suite.Equal(float64(3), invite["max_uses"], "non-admins are capped at MaxUsesPerInvite")
+	suite.Equal("friend@example.com", invite["email"])
+
+	var stored string
+	suite.Require().NoError(suite.as.db.QueryRow(`SELECT code_hash FROM user_invites WHERE id = $1`, invite["id"]).Scan(&stored))
+	suite.Equal(hashInviteCode(code), stored, "only a hash of the code is kept")
+	suite.True(suite.check(code))
+
+	recorder, body = suite.do(http.MethodPost, "/invites", suite.owner, CreateInviteRequest{Roles: []string{"moderator"}})
+	suite.Equal(http.StatusForbidden, recorder.Code, "only admins preset roles")
+	suite.Equal("insufficient_permissions", body["error"])
+
+	suite.create(CreateInviteRequest{})
+	recorder, body = suite.do(http.MethodPost, "/invites", suite.owner, CreateInviteRequest{})
+	suite.Equal(http.StatusForbidden, recorder.Code)
+	suite.Equal("invite_limit_reached", body["error"])
+}
+
+func (suite *InviteTestSuite) TestExpiry() {
+	code, id := suite.create(CreateInviteRequest{ExpiresInHours: 1})
+	_, err := suite.as.db.Exec(`UPDATE user_invites SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, id)
+	suite.Require().NoError(err)
+
+	suite.False(suite.check(code))
+	suite.ErrorIs(suite.redeem(code, "friend@example.com"), errInviteInvalid)
+	invites, err := suite.as.queryInvites(context.Background(), `WHERE id = $1`, id)
+	suite.Require().NoError(err)
+	suite.Equal("expired", invites[0].Status)
+}
+
+func (suite *InviteTestSuite) TestSingleRedemption() {
+	code, id := suite.create(CreateInviteRequest{MaxUses: 1, Email: "friend@example.com"})
+
+	suite.ErrorIs(suite.redeem(code, "someone-else@example.com"), errInviteInvalid, "the invite is locked to its email")
+	suite.NoError(suite.redeem(code, "Friend@example.com"))
+	suite.ErrorIs(suite.redeem(code, "friend@example.com"), errInviteInvalid, "a one-use invite is spent")
+	suite.False(suite.check(code))
+
+	invites, err := suite.as.queryInvites(context.Background(), `WHERE id = $1`, id)
+	suite.Require().NoError(err)
+	suite.Equal(1, invites[0].Uses)
+	suite.Equal("used", invites[0].Status)
+	var redemptions int
+	suite.Require().NoError(suite.as.db.QueryRow(`SELECT COUNT(*) FROM user_invite_redemptions WHERE invite_id = $1`, id).Scan(&redemptions))
+	suite.Equal(1, redemptions)
+}
+
+func (suite *InviteTestSuite) TestRevocation() {
+	code, id := suite.create(CreateInviteRequest{MaxUses: 3})
+
+	stranger := createTestUser(suite.T(), suite.as.db)
+	recorder, _ := suite.do(http.MethodDelete, "/invites/"+id, stranger, nil)
+	suite.Equal(http.StatusNotFound, recorder.Code, "only the creator or an admin may revoke")
+	suite.True(suite.check(code))
+
+	recorder, _ = suite.do(http.MethodDelete, "/invites/"+id, suite.owner, nil)
+	suite.Require().Equal(http.StatusOK, recorder.Code)
+	suite.False(suite.check(code))
+	suite.ErrorIs(suite.redeem(code, "friend@example.com"), errInviteInvalid)
+
+	// A revoked invite no longer counts towards the creator's limit
+	suite.create(CreateInviteRequest{})
+	suite.create(CreateInviteRequest{})
+}
+
+func TestInviteTestSuite(t *testing.T) {
+	suite.Run(t, new(InviteTestSuite))
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Decisions an issuance policy can return
+const (
+	issuanceAllow = "allow"
+	issuanceDeny  = "deny"
+)
+
+// What happens to token requests while the policy service cannot be reached
+const (
+	issuanceFailOpen   = "open"
+	issuanceFailClosed = "closed"
+)
+
+// issuancePolicyMaxBody bounds policy responses; decisions are small
+const issuancePolicyMaxBody = 64 << 10
+
+var issuancePolicyDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_issuance_policy_decisions_total",
+	Help: "Token requests checked with the issuance policy, by grant type and outcome (allowed, reduced, denied, failed_open, failed_closed).",
+}, []string{"grant_type", "outcome"})
+
+var issuancePolicyDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "liberation_auth_issuance_policy_duration_seconds",
+	Help:    "Time taken by the issuance policy service to decide.",
+	Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+})
+
+// IssuancePolicyConfig controls the hook consulted before the token endpoint issues tokens
+type IssuancePolicyConfig struct {
+	// URL receives each grant; the hook is off without it
+	URL string
+	// Secret signs requests in X-Signature-256
+	Secret  string
+	Timeout time.Duration
+	// Failure is open or closed: whether tokens are issued while the policy service is down
+	Failure string
+	// GrantTypes are the grants checked; the others skip the hook
+	GrantTypes []string
+}
+
+// DefaultIssuancePolicyConfig reads ISSUANCE_POLICY_URL, ISSUANCE_POLICY_SECRET,
+// ISSUANCE_POLICY_TIMEOUT, ISSUANCE_POLICY_FAILURE and ISSUANCE_POLICY_GRANT_TYPES
+func DefaultIssuancePolicyConfig() (IssuancePolicyConfig, error) {
+	config := IssuancePolicyConfig{
+		URL:        getEnv("ISSUANCE_POLICY_URL", ""),
+		Secret:     getEnv("ISSUANCE_POLICY_SECRET", ""),
+		Failure:    getEnv("ISSUANCE_POLICY_FAILURE", issuanceFailClosed),
+		GrantTypes: strings.Fields(strings.ReplaceAll(getEnv("ISSUANCE_POLICY_GRANT_TYPES", "authorization_code,refresh_token,client_credentials"), ",", " ")),
+	}
+	if config.URL == "" {
+		return config, nil
+	}
+	if parsed, err := url.Parse(config.URL); err != nil || parsed.Host == "" {
+		return config, fmt.Errorf("ISSUANCE_POLICY_URL must be an absolute URL")
+	}
+	if len(config.Secret) < 32 {
+		return config, fmt.Errorf("ISSUANCE_POLICY_SECRET must be at least 32 characters")
+	}
+	if config.Failure != issuanceFailOpen && config.Failure != issuanceFailClosed {
+		return config, fmt.Errorf("ISSUANCE_POLICY_FAILURE must be open or closed")
+	}
+	var err error
+	if config.Timeout, err = time.ParseDuration(getEnv("ISSUANCE_POLICY_TIMEOUT", "500ms")); err != nil || config.Timeout <= 0 {
+		return config, fmt.Errorf("ISSUANCE_POLICY_TIMEOUT must be a positive duration")
+	}
+	for _, grantType := range config.GrantTypes {
+		switch grantType {
+		case "authorization_code", "refresh_token", "client_credentials":
+		default:
+			return config, fmt.Errorf("ISSUANCE_POLICY_GRANT_TYPES: unknown grant type %q", grantType)
+		}
+	}
+	return config, nil
+}
+
+// issuanceRequest is the grant a policy decides on
+type issuanceRequest struct {
+	GrantType   string     `json:"grant_type"`
+	ClientID    uuid.UUID  `json:"client_id"`
+	ClientName  string     `json:"client_name"`
+	FirstParty  bool       `json:"first_party"`
+	UserID      *uuid.UUID `json:"user_id,omitempty"`
+	Roles       []string   `json:"roles,omitempty"`
+	Scopes      []string   `json:"scopes"`
+	Audience    []string   `json:"audience"`
+	IPAddress   string     `json:"ip_address"`
+	UserAgent   string     `json:"user_agent"`
+	RequestedAt int64      `json:"requested_at"`
+}
+
+// issuanceDecision is the policy's answer. Scopes, when present, replace the requested ones,
+// but can only narrow them: scopes the grant did not ask for are dropped.
+type issuanceDecision struct {
+	Decision string   `json:"decision"`
+	Scopes   []string `json:"scopes,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+}
+
+// IssuancePolicy asks an external policy service, such as OPA, whether a grant may be issued
+type IssuancePolicy struct {
+	as     *AuthService
+	config IssuancePolicyConfig
+	client *http.Client
+}
+
+// NewIssuancePolicy returns nil when ISSUANCE_POLICY_URL is not set
+func NewIssuancePolicy(as *AuthService, config IssuancePolicyConfig) *IssuancePolicy {
+	if config.URL == "" {
+		return nil
+	}
+	return &IssuancePolicy{as: as, config: config, client: &http.Client{Timeout: config.Timeout}}
+}
+
+// ask posts a grant to the policy service. The body is wrapped in "input" and the decision
+// may come back under "result", so OPA's data API can be pointed at directly.
+func (p *IssuancePolicy) ask(ctx context.Context, grant issuanceRequest) (issuanceDecision, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": grant})
+	if err != nil {
+		return issuanceDecision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return issuanceDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	mac := hmac.New(sha256.New, []byte(p.config.Secret))
+	mac.Write(body)
+	req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	started := time.Now()
+	resp, err := p.client.Do(req)
+	issuancePolicyDuration.Observe(time.Since(started).Seconds())
+	if err != nil {
+		return issuanceDecision{}, fmt.Errorf("failed to reach issuance policy: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return issuanceDecision{}, fmt.Errorf("issuance policy returned %d", resp.StatusCode)
+	}
+
+	var response struct {
+		issuanceDecision
+		Result *issuanceDecision `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, issuancePolicyMaxBody)).Decode(&response); err != nil {
+		return issuanceDecision{}, fmt.Errorf("issuance policy returned an invalid decision: %w", err)
+	}
+	decision := response.issuanceDecision
+	if response.Result != nil {
+		decision = *response.Result
+	}
+	if decision.Decision != issuanceAllow && decision.Decision != issuanceDeny {
+		return issuanceDecision{}, fmt.Errorf("issuance policy returned decision %q; expected allow or deny", decision.Decision)
+	}
+	return decision, nil
+}
+
+// intersectScopes keeps the granted scopes a policy allowed, in the grant's order
+func intersectScopes(requested, allowed []string) []string {
+	narrowed := []string{}
+	for _, scope := range requested {
+		if contains(allowed, scope) {
+			narrowed = append(narrowed, scope)
+		}
+	}
+	return narrowed
+}
+
+// check consults the policy before a grant is issued and returns the scopes to issue. It
+// returns false when it has answered the request: the grant was denied, every scope was
+// taken away, or the policy could not be reached and the hook fails closed. A nil policy
+// allows everything.
+func (p *IssuancePolicy) check(c *gin.Context, grantType string, client *models.OAuthClient, userID *uuid.UUID, scopes, audience []string) ([]string, bool) {
+	if p == nil || !contains(p.config.GrantTypes, grantType) {
+		return scopes, true
+	}
+	grant := issuanceRequest{
+		GrantType:   grantType,
+		ClientID:    client.ID,
+		ClientName:  client.Name,
+		FirstParty:  client.IsFirstParty,
+		UserID:      userID,
+		Scopes:      scopes,
+		Audience:    nonNilAudience(audience),
+		IPAddress:   c.ClientIP(),
+		UserAgent:   c.GetHeader("User-Agent"),
+		RequestedAt: time.Now().Unix(),
+	}
+	if userID != nil {
+		grant.Roles, _ = p.as.getUserRoles(c.Request.Context(), *userID)
+	}
+
+	decision, err := p.ask(c.Request.Context(), grant)
+	if err != nil {
+		log.Printf("Issuance policy unavailable for %s grant to client %s: %v", grantType, client.ID, err)
+		if p.config.Failure == issuanceFailOpen {
+			issuancePolicyDecisions.WithLabelValues(grantType, "failed_open").Inc()
+			return scopes, true
+		}
+		issuancePolicyDecisions.WithLabelValues(grantType, "failed_closed").Inc()
+		c.JSON(http.StatusServiceUnavailable, models.TokenErrorResponse{
+			Error:            "temporarily_unavailable",
+			ErrorDescription: "Token issuance is temporarily unavailable; try again shortly",
+		})
+		return nil, false
+	}
+
+	if decision.Decision == issuanceDeny {
+		issuancePolicyDecisions.WithLabelValues(grantType, "denied").Inc()
+		p.recordDenial(c, grantType, client, userID, decision.Reason)
+		description := decision.Reason
+		if description == "" {
+			description = "Token issuance was denied by policy"
+		}
+		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{Error: "invalid_grant", ErrorDescription: description})
+		return nil, false
+	}
+	if decision.Scopes == nil {
+		issuancePolicyDecisions.WithLabelValues(grantType, "allowed").Inc()
+		return scopes, true
+	}
+
+	narrowed := intersectScopes(scopes, decision.Scopes)
+	if len(narrowed) == 0 {
+		issuancePolicyDecisions.WithLabelValues(grantType, "denied").Inc()
+		p.recordDenial(c, grantType, client, userID, decision.Reason)
+		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
+			Error:            "invalid_scope",
+			ErrorDescription: "None of the requested scopes are allowed by policy",
+		})
+		return nil, false
+	}
+	outcome := "allowed"
+	if len(narrowed) < len(scopes) {
+		outcome = "reduced"
+	}
+	issuancePolicyDecisions.WithLabelValues(grantType, outcome).Inc()
+	return narrowed, true
+}
+
+// authorizeGrant applies the local scopes policy and then the issuance policy hook to a
+// grant the token endpoint is about to issue, returning the scopes to issue. It returns
+// false when it has answered the request.
+func (as *AuthService) authorizeGrant(c *gin.Context, grantType string, client *models.OAuthClient, userID *uuid.UUID, scopes, audience []string) ([]string, bool) {
+	granted := as.policies.grantScopes(c.Request.Context(), grantType, client.ID, userID, scopes)
+	if len(granted) == 0 && len(scopes) > 0 {
+		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
+			Error:            "invalid_scope",
+			ErrorDescription: "None of the requested scopes are allowed by policy",
+		})
+		return nil, false
+	}
+	return as.issuancePolicy.check(c, grantType, client, userID, granted, audience)
+}
+
+// recordDenial keeps denied user grants in the user's security events
+func (p *IssuancePolicy) recordDenial(c *gin.Context, grantType string, client *models.OAuthClient, userID *uuid.UUID, reason string) {
+	if userID == nil {
+		return
+	}
+	p.as.recordSecurityEvent(c, userID, securityEventIssuanceDenied, map[string]interface{}{
+		"grant_type": grantType, "client_id": client.ID, "reason": reason,
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+const issuanceTestSecret = "issuance-policy-secret-of-32-chars!"
+
+type IssuancePolicyTestSuite struct {
+	suite.Suite
+	// respond answers each policy request; it sees the decoded input
+	respond  func(w http.ResponseWriter, input issuanceRequest)
+	server   *httptest.Server
+	requests int
+}
+
+func (suite *IssuancePolicyTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.requests = 0
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.requests++
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(issuanceTestSecret))
+		mac.Write(body)
+		suite.Equal("sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Signature-256"))
+
+		var request struct {
+			Input issuanceRequest `json:"input"`
+		}
+		suite.Require().NoError(json.Unmarshal(body, &request))
+		suite.respond(w, request.Input)
+	}))
+}
+
+func (suite *IssuancePolicyTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *IssuancePolicyTestSuite) policy(failure string) *IssuancePolicy {
+	return NewIssuancePolicy(&AuthService{}, IssuancePolicyConfig{
+		URL: suite.server.URL, Secret: issuanceTestSecret, Timeout: 200 * time.Millisecond,
+		Failure: failure, GrantTypes: []string{"client_credentials", "refresh_token"},
+	})
+}
+
+// check runs a grant of read and write to a third-party client through the policy
+func (suite *IssuancePolicyTestSuite) check(policy *IssuancePolicy, grantType string) ([]string, bool, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/oauth/token", nil)
+	client := &models.OAuthClient{ID: uuid.New(), Name: "Reader App"}
+	scopes, ok := policy.check(c, grantType, client, nil, []string{"read", "write"}, nil)
+	return scopes, ok, w
+}
+
+func (suite *IssuancePolicyTestSuite) TestConfig() {
+	config, err := DefaultIssuancePolicyConfig()
+	suite.Require().NoError(err)
+	suite.Nil(NewIssuancePolicy(&AuthService{}, config), "the hook is off without a URL")
+
+	suite.T().Setenv("ISSUANCE_POLICY_URL", "https://opa.internal/v1/data/auth/issuance")
+	_, err = DefaultIssuancePolicyConfig()
+	suite.ErrorContains(err, "ISSUANCE_POLICY_SECRET")
+
+	suite.T().Setenv("ISSUANCE_POLICY_SECRET", issuanceTestSecret)
+	config, err = DefaultIssuancePolicyConfig()
+	suite.Require().NoError(err)
+	suite.Equal(issuanceFailClosed, config.Failure)
+	suite.Equal(500*time.Millisecond, config.Timeout)
+	suite.Equal([]string{"authorization_code", "refresh_token", "client_credentials"}, config.GrantTypes)
+
+	suite.T().Setenv("ISSUANCE_POLICY_FAILURE", "sometimes")
+	_, err = DefaultIssuancePolicyConfig()
+	suite.Error(err)
+	suite.T().Setenv("ISSUANCE_POLICY_FAILURE", "")
+	suite.T().Setenv("ISSUANCE_POLICY_GRANT_TYPES", "password")
+	_, err = DefaultIssuancePolicyConfig()
+	suite.Error(err)
+}
+
+func (suite *IssuancePolicyTestSuite) TestNarrowScopes() {
+	suite.Equal([]string{"read"}, intersectScopes([]string{"read", "write"}, []string{"admin", "read"}), "a policy cannot add scopes")
+	suite.Equal([]string{}, intersectScopes([]string{"write"}, []string{"read"}))
+}
+
+func (suite *IssuancePolicyTestSuite) TestAllow() {
+	suite.respond = func(w http.ResponseWriter, input issuanceRequest) {
+		suite.Equal("client_credentials", input.GrantType)
+		suite.Equal("Reader App", input.ClientName)
+		suite.Equal([]string{"read", "write"}, input.Scopes)
+		w.Write([]byte(`{"decision": "allow"}`))
+	}
+	scopes, ok, _ := suite.check(suite.policy(issuanceFailClosed), "client_credentials")
+	suite.True(ok)
+	suite.Equal([]string{"read", "write"}, scopes)
+}
+
+func (suite *IssuancePolicyTestSuite) TestReduceThroughOPAResult() {
+	suite.respond = func(w http.ResponseWriter, _ issuanceRequest) {
+		w.Write([]byte(`{"result": {"decision": "allow", "scopes": ["read", "admin"]}}`))
+	}
+	scopes, ok, _ := suite.check(suite.policy(issuanceFailClosed), "client_credentials")
+	suite.True(ok)
+	suite.Equal([]string{"read"}, scopes)
+
+	suite.respond = func(w http.ResponseWriter, _ issuanceRequest) {
+		w.Write([]byte(`{"result": {"decision": "allow", "scopes": []}}`))
+	}
+	_, ok, w := suite.check(suite.policy(issuanceFailClosed), "client_credentials")
+	suite.False(ok)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "invalid_scope")
+}
+
+func (suite *IssuancePolicyTestSuite) TestDeny() {
+	suite.respond = func(w http.ResponseWriter, _ issuanceRequest) {
+		w.Write([]byte(`{"decision": "deny", "reason": "Client is suspended pending review"}`))
+	}
+	_, ok, w := suite.check(suite.policy(issuanceFailOpen), "client_credentials")
+	suite.False(ok)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "invalid_grant")
+	suite.Contains(w.Body.String(), "Client is suspended pending review")
+}
+
+func (suite *IssuancePolicyTestSuite) TestFailure() {
+	suite.respond = func(w http.ResponseWriter, _ issuanceRequest) {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	_, ok, w := suite.check(suite.policy(issuanceFailClosed), "client_credentials")
+	suite.False(ok)
+	suite.Equal(http.StatusServiceUnavailable, w.Code)
+	suite.Contains(w.Body.String(), "temporarily_unavailable")
+
+	scopes, ok, _ := suite.check(suite.policy(issuanceFailOpen), "client_credentials")
+	suite.True(ok)
+	suite.Equal([]string{"read", "write"}, scopes)
+
+	suite.respond = func(w http.ResponseWriter, _ issuanceRequest) {
+		w.Write([]byte(`{"decision": "maybe"}`))
+	}
+	_, ok, _ = suite.check(suite.policy(issuanceFailClosed), "client_credentials")
+	suite.False(ok, "an unknown decision is a failure, not an allow")
+
+	suite.respond = func(w http.ResponseWriter, _ issuanceRequest) {
+		time.Sleep(300 * time.Millisecond)
+	}
+	_, ok, w = suite.check(suite.policy(issuanceFailClosed), "client_credentials")
+	suite.False(ok)
+	suite.Equal(http.StatusServiceUnavailable, w.Code, "a slow policy times out")
+}
+
+func (suite *IssuancePolicyTestSuite) TestUncheckedGrantTypes() {
+	suite.respond = func(w http.ResponseWriter, _ issuanceRequest) {
+		w.Write([]byte(`{"decision": "deny"}`))
+	}
+	scopes, ok, _ := suite.check(suite.policy(issuanceFailClosed), "authorization_code")
+	suite.True(ok)
+	suite.Equal([]string{"read", "write"}, scopes)
+	suite.Zero(suite.requests)
+
+	scopes, ok, _ = suite.check(nil, "client_credentials")
+	suite.True(ok)
+	suite.Equal([]string{"read", "write"}, scopes, "without a policy everything is allowed")
+}
+
+func TestIssuancePolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(IssuancePolicyTestSuite))
+}
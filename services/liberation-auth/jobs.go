@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"liberation-scheduler"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	backgroundJobRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "liberation_auth_background_job_runs_total",
+		Help: "Background job runs on this instance, by job and outcome (success, failure).",
+	}, []string{"job", "outcome"})
+	backgroundJobLeader = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "liberation_auth_background_job_leader",
+		Help: "1 while this instance holds the lease for a background job.",
+	}, []string{"job"})
+)
+
+// JobConfig controls leader election and schedules for background jobs
+type JobConfig struct {
+	// LeaseTTL is how long a leader keeps a job without renewing; a crashed leader
+	// is replaced within this time. Leases are renewed every LeaseTTL/3.
+	LeaseTTL time.Duration
+	// Schedules override each job's built-in interval, from JOB_<NAME>_SCHEDULE,
+	// _JITTER_SECONDS, _CATCH_UP and _ENABLED
+	Schedules scheduler.Config
+}
+
+// DefaultJobConfig reads JOB_LEASE_TTL and per-job schedules from the environment
+func DefaultJobConfig() (JobConfig, error) {
+	ttl, err := time.ParseDuration(getEnv("JOB_LEASE_TTL", "30s"))
+	if err != nil || ttl < 3*time.Second {
+		return JobConfig{}, fmt.Errorf("JOB_LEASE_TTL must be a duration of at least 3s")
+	}
+	config := JobConfig{LeaseTTL: ttl}
+	config.Schedules.ApplyEnv("JOB_")
+	if err := config.Schedules.Validate(); err != nil {
+		return JobConfig{}, err
+	}
+	return config, nil
+}
+
+// grace is how late a run may start and still be on time. A crashed leader is only
+// replaced after LeaseTTL, so runs are never counted as missed before that.
+func (c JobConfig) grace() time.Duration {
+	if c.LeaseTTL > scheduler.DefaultGrace {
+		return c.LeaseTTL
+	}
+	return scheduler.DefaultGrace
+}
+
+// jobFunc runs a job once and returns a short summary for /admin/jobs
+type jobFunc func(ctx context.Context) (string, error)
+
+type backgroundJob struct {
+	name string
+	// timing is the job's built-in schedule with the environment's overrides applied;
+	// admins may override it again in job_leases
+	timing  scheduler.Timing
+	run     jobFunc
+	trigger chan struct{}
+}
+
+// timingFor applies the overrides stored with the job's lease
+func (job *backgroundJob) timingFor(schedule sql.NullString, enabled sql.NullBool) scheduler.Timing {
+	timing := job.timing
+	if enabled.Valid {
+		timing.Enabled = enabled.Bool
+	}
+	if schedule.Valid && schedule.String != "" {
+		// Stored schedules are validated when they are set
+		if overridden, err := timing.WithSpec(schedule.String); err == nil {
+			timing = overridden
+		}
+	}
+	return timing
+}
+
+// JobRunner runs each registered job on exactly one replica. Replicas compete for a
+// lease row per job in job_leases; the holder renews it and runs the job when it is due.
+// Lease times use the database clock so replicas with skewed clocks agree.
+type JobRunner struct {
+	db     *sql.DB
+	config JobConfig
+	mu     sync.Mutex
+	jobs   map[string]*backgroundJob
+	order  []string
+}
+
+// NewJobRunner creates a runner; jobs are added with Register before Start
+func NewJobRunner(db *sql.DB, config JobConfig) *JobRunner {
+	return &JobRunner{db: db, config: config, jobs: map[string]*backgroundJob{}}
+}
+
+// Register adds a job that runs every interval on the current leader, unless its schedule
+// is overridden
+func (r *JobRunner) Register(name string, interval time.Duration, run jobFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.jobs[name]; exists {
+		panic("background job registered twice: " + name)
+	}
+	// Overrides were validated by DefaultJobConfig, so only a bad interval fails here
+	timing, err := r.config.Schedules.Timing(name, "@every "+interval.String())
+	if err != nil {
+		panic(err)
+	}
+	r.jobs[name] = &backgroundJob{name: name, timing: timing, run: run, trigger: make(chan struct{}, 1)}
+	r.order = append(r.order, name)
+}
+
+// Start competes for every registered job until ctx is cancelled
+func (r *JobRunner) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, name := range r.order {
+		go r.loop(ctx, r.jobs[name])
+	}
+}
+
+// jobDue decides whether a job should run and which scheduled time the run covers. A run
+// requested since the last one goes ahead early, even for a disabled job, and keeps the
+// job's place in its schedule.
+func jobDue(timing scheduler.Timing, lease *jobLease, grace time.Duration) (time.Time, bool) {
+	last := lease.lastScheduled
+	if !last.Valid {
+		// Rows from before schedules were recorded only know when the job last ran
+		last = lease.lastRun
+	}
+	if lease.requested.Valid && (!lease.lastRun.Valid || lease.requested.Time.After(lease.lastRun.Time)) {
+		if last.Valid {
+			return last.Time, true
+		}
+		return lease.now, true
+	}
+	if !timing.Enabled {
+		return time.Time{}, false
+	}
+	return timing.Due(last.Time, lease.now, grace)
+}
+
+func (r *JobRunner) loop(ctx context.Context, job *backgroundJob) {
+	ticker := time.NewTicker(r.config.LeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		if lease, err := r.acquire(ctx, job.name); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to acquire lease for job %s: %v", job.name, err)
+			}
+		} else if lease != nil {
+			if covers, due := jobDue(job.timingFor(lease.schedule, lease.enabled), lease, r.config.grace()); due {
+				r.execute(ctx, job, covers)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			r.release(ctx, job.name)
+			return
+		case <-ticker.C:
+		case <-job.trigger:
+		}
+	}
+}
+
+type jobLease struct {
+	lastRun       sql.NullTime
+	lastScheduled sql.NullTime
+	requested     sql.NullTime
+	schedule      sql.NullString
+	enabled       sql.NullBool
+	now           time.Time
+}
+
+// acquire takes or renews the lease; it returns nil when another instance holds it
+func (r *JobRunner) acquire(ctx context.Context, name string) (*jobLease, error) {
+	var lease jobLease
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO job_leases (job, owner, expires_at, acquired_at)
+		VALUES ($1, $2, NOW() + $3 * INTERVAL '1 millisecond', NOW())
+		ON CONFLICT (job) DO UPDATE
+		SET owner = $2, expires_at = EXCLUDED.expires_at,
+			acquired_at = CASE WHEN job_leases.owner = $2 THEN job_leases.acquired_at ELSE NOW() END
+		WHERE job_leases.owner = $2 OR job_leases.owner IS NULL OR job_leases.expires_at < NOW()
+		RETURNING last_run_at, last_scheduled_for, run_requested_at, schedule, enabled, NOW()`,
+		name, instanceID, r.config.LeaseTTL.Milliseconds()).Scan(&lease.lastRun, &lease.lastScheduled, &lease.requested,
+		&lease.schedule, &lease.enabled, &lease.now)
+	if errors.Is(err, sql.ErrNoRows) {
+		backgroundJobLeader.WithLabelValues(name).Set(0)
+		return nil, nil
+	}
+	if err != nil {
+		backgroundJobLeader.WithLabelValues(name).Set(0)
+		return nil, err
+	}
+	backgroundJobLeader.WithLabelValues(name).Set(1)
+	return &lease, nil
+}
+
+// release gives the lease up on shutdown so another replica takes over without waiting for it to expire
+func (r *JobRunner) release(ctx context.Context, name string) {
+	r.db.ExecContext(ctx, `UPDATE job_leases SET expires_at = NOW() WHERE job = $1 AND owner = $2`, name, instanceID)
+	backgroundJobLeader.WithLabelValues(name).Set(0)
+}
+
+// execute runs the job for the scheduled time covers while renewing the lease; losing the
+// lease (e.g. to a steal) cancels the run
+func (r *JobRunner) execute(ctx context.Context, job *backgroundJob, covers time.Time) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var started time.Time
+	if err := r.db.QueryRowContext(ctx, `
+		UPDATE job_leases SET running_since = NOW() WHERE job = $1 RETURNING running_since`, job.name).Scan(&started); err != nil {
+		log.Printf("Failed to start job %s: %v", job.name, err)
+		return
+	}
+
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(r.config.LeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if lease, err := r.acquire(runCtx, job.name); err == nil && lease == nil {
+				log.Printf("Lost the lease for job %s; stopping this run", job.name)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	clock := time.Now()
+	detail, err := job.run(runCtx)
+	duration := time.Since(clock)
+	cancel()
+	<-renewed
+
+	status, errText := "success", ""
+	if err != nil {
+		status, errText = "failure", err.Error()
+		log.Printf("Background job %s failed after %s: %v", job.name, duration.Round(time.Millisecond), err)
+	}
+	backgroundJobRunsTotal.WithLabelValues(job.name, status).Inc()
+
+	// Not bound to ctx: a run cut short by shutdown is still recorded
+	if _, err := r.db.Exec(`
+		UPDATE job_leases SET running_since = NULL, last_run_at = $2, last_run_by = $3, last_status = $4,
+			last_error = $5, last_detail = $6, last_duration_ms = $7, last_scheduled_for = $8
+		WHERE job = $1`,
+		job.name, started, instanceID, status, errText, detail, duration.Milliseconds(), covers); err != nil {
+		log.Printf("Failed to record run of job %s: %v", job.name, err)
+	}
+}
+
+// Admin API
+
+// AdminListJobs shows each job's schedule, which instance owns it and how its last run went
+func (r *JobRunner) AdminListJobs(c *gin.Context) {
+	rows, err := r.db.QueryContext(c.Request.Context(), `
+		SELECT job, owner, expires_at, expires_at > NOW(), acquired_at, running_since, run_requested_at,
+			last_run_at, last_run_by, last_status, last_error, last_detail, last_duration_ms,
+			last_scheduled_for, schedule, enabled, NOW()
+		FROM job_leases`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load jobs"})
+		return
+	}
+	defer rows.Close()
+
+	leases := map[string]gin.H{}
+	stored := map[string]*jobLease{}
+	for rows.Next() {
+		var name string
+		var owner, lastRunBy, lastStatus, lastError, lastDetail sql.NullString
+		var expiresAt time.Time
+		var active bool
+		var acquiredAt, runningSince sql.NullTime
+		var lastDuration sql.NullInt64
+		var stats jobLease
+		if err := rows.Scan(&name, &owner, &expiresAt, &active, &acquiredAt, &runningSince, &stats.requested,
+			&stats.lastRun, &lastRunBy, &lastStatus, &lastError, &lastDetail, &lastDuration,
+			&stats.lastScheduled, &stats.schedule, &stats.enabled, &stats.now); err != nil {
+			continue
+		}
+		requested, lastRun := stats.requested, stats.lastRun
+		stored[name] = &stats
+		lease := gin.H{"lease_expires_at": expiresAt, "lease_active": active && owner.Valid}
+		if owner.Valid && active {
+			lease["owner"] = owner.String
+			lease["owned_by_this_instance"] = owner.String == instanceID
+		}
+		optional := map[string]interface{}{
+			"acquired_at": acquiredAt, "running_since": runningSince, "run_requested_at": requested,
+			"last_run_at": lastRun, "last_run_by": lastRunBy, "last_status": lastStatus,
+			"last_error": lastError, "last_detail": lastDetail, "last_duration_ms": lastDuration,
+		}
+		for key, value := range optional {
+			switch v := value.(type) {
+			case sql.NullTime:
+				if v.Valid {
+					lease[key] = v.Time
+				}
+			case sql.NullString:
+				if v.Valid && v.String != "" {
+					lease[key] = v.String
+				}
+			case sql.NullInt64:
+				if v.Valid {
+					lease[key] = v.Int64
+				}
+			}
+		}
+		leases[name] = lease
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := []gin.H{}
+	for _, name := range r.order {
+		lease := stored[name]
+		if lease == nil {
+			lease = &jobLease{now: time.Now()}
+		}
+		job := r.describe(r.jobs[name], lease)
+		for key, value := range leases[name] {
+			job[key] = value
+		}
+		jobs = append(jobs, job)
+	}
+	c.JSON(http.StatusOK, gin.H{"instance": instanceID, "lease_ttl": r.config.LeaseTTL.String(), "jobs": jobs})
+}
+
+// describe shows a job's schedule once the overrides stored with its lease are applied
+func (r *JobRunner) describe(job *backgroundJob, lease *jobLease) gin.H {
+	timing := job.timingFor(lease.schedule, lease.enabled)
+	described := gin.H{
+		"name":                job.name,
+		"schedule":            timing.Spec,
+		"schedule_overridden": lease.schedule.Valid && lease.schedule.String != "",
+		"catch_up":            timing.CatchUp,
+		"jitter_seconds":      timing.Jitter.Seconds(),
+		"enabled":             timing.Enabled,
+	}
+	if lease.lastScheduled.Valid {
+		described["last_scheduled_for"] = lease.lastScheduled.Time
+	}
+	if timing.Enabled {
+		last := lease.lastScheduled
+		if !last.Valid {
+			last = lease.lastRun
+		}
+		if next := timing.Next(last.Time, lease.now, r.config.grace()); !next.IsZero() {
+			described["next_run_at"] = next
+		}
+	}
+	return described
+}
+
+func nullBoolText(value sql.NullBool) string {
+	if !value.Valid {
+		return ""
+	}
+	return fmt.Sprint(value.Bool)
+}
+
+func (r *JobRunner) lookup(c *gin.Context) *backgroundJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.jobs[c.Param("name")]
+	if job == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown job"})
+	}
+	return job
+}
+
+// AdminTriggerJob asks the current leader to run a job as soon as it next checks its lease
+func (r *JobRunner) AdminTriggerJob(c *gin.Context) {
+	job := r.lookup(c)
+	if job == nil {
+		return
+	}
+	_, err := r.db.ExecContext(c.Request.Context(), `
+		INSERT INTO job_leases (job, run_requested_at) VALUES ($1, NOW())
+		ON CONFLICT (job) DO UPDATE SET run_requested_at = NOW()`, job.name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request run"})
+		return
+	}
+	// Wake the local loop; if this instance is the leader the run starts immediately
+	select {
+	case job.trigger <- struct{}{}:
+	default:
+	}
+	c.JSON(http.StatusAccepted, gin.H{"job": job.name, "requested_by_instance": instanceID})
+}
+
+// AdminUpdateJob replaces a job's schedule or enables or disables it on every replica. An
+// empty schedule restores the built-in or environment-configured one.
+func (r *JobRunner) AdminUpdateJob(c *gin.Context) {
+	job := r.lookup(c)
+	if job == nil {
+		return
+	}
+	var update scheduler.Update
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	var schedule sql.NullString
+	if update.Schedule != nil && *update.Schedule != "" {
+		if _, err := scheduler.Parse(*update.Schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		schedule = sql.NullString{String: *update.Schedule, Valid: true}
+	}
+	var enabled sql.NullBool
+	if update.Enabled != nil {
+		enabled = sql.NullBool{Bool: *update.Enabled, Valid: true}
+	}
+
+	lease := jobLease{}
+	err := r.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO job_leases (job, schedule, enabled) VALUES ($1, $2, $3)
+		ON CONFLICT (job) DO UPDATE
+		SET schedule = CASE WHEN $4 THEN EXCLUDED.schedule ELSE job_leases.schedule END,
+			enabled = CASE WHEN $5 THEN EXCLUDED.enabled ELSE job_leases.enabled END
+		RETURNING last_run_at, last_scheduled_for, schedule, enabled, NOW()`,
+		job.name, schedule, enabled, update.Schedule != nil, update.Enabled != nil).Scan(
+		&lease.lastRun, &lease.lastScheduled, &lease.schedule, &lease.enabled, &lease.now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update job"})
+		return
+	}
+	log.Printf("Job %s updated: schedule override %q, enabled override %q", job.name, lease.schedule.String, nullBoolText(lease.enabled))
+
+	// Wake the local loop; other replicas pick the change up at their next renewal
+	select {
+	case job.trigger <- struct{}{}:
+	default:
+	}
+	c.JSON(http.StatusOK, r.describe(job, &lease))
+}
+
+// AdminStealJob moves a job's lease to the instance serving the request. The previous
+// leader notices at its next renewal and cancels any run in progress.
+func (r *JobRunner) AdminStealJob(c *gin.Context) {
+	job := r.lookup(c)
+	if job == nil {
+		return
+	}
+	var previous sql.NullString
+	err := r.db.QueryRowContext(c.Request.Context(), `
+		WITH old AS (SELECT owner FROM job_leases WHERE job = $1)
+		INSERT INTO job_leases (job, owner, expires_at, acquired_at)
+		VALUES ($1, $2, NOW() + $3 * INTERVAL '1 millisecond', NOW())
+		ON CONFLICT (job) DO UPDATE SET owner = $2, expires_at = EXCLUDED.expires_at, acquired_at = NOW()
+		RETURNING (SELECT owner FROM old)`, job.name, instanceID, r.config.LeaseTTL.Milliseconds()).Scan(&previous)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to take over job"})
+		return
+	}
+	log.Printf("Job %s taken over by %s (previous owner %q)", job.name, instanceID, previous.String)
+	select {
+	case job.trigger <- struct{}{}:
+	default:
+	}
+	c.JSON(http.StatusOK, gin.H{"job": job.name, "owner": instanceID, "previous_owner": previous.String})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"liberation-scheduler"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type JobRunnerTestSuite struct {
+	suite.Suite
+}
+
+func (suite *JobRunnerTestSuite) TestJobDue() {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) sql.NullTime { return sql.NullTime{Time: now.Add(d), Valid: true} }
+	never := sql.NullTime{}
+	hourly, err := scheduler.Config{}.Timing("rollup", "@every 1h")
+	suite.Require().NoError(err)
+	due := func(timing scheduler.Timing, lastRun, requested sql.NullTime) bool {
+		_, due := jobDue(timing, &jobLease{lastRun: lastRun, requested: requested, now: now}, time.Minute)
+		return due
+	}
+
+	suite.True(due(hourly, never, never), "a job that never ran is due")
+	suite.False(due(hourly, at(-30*time.Minute), never))
+	suite.True(due(hourly, at(-time.Hour), never))
+	suite.True(due(hourly, at(-30*time.Minute), at(-time.Minute)), "a requested run goes ahead early")
+	suite.False(due(hourly, at(-30*time.Minute), at(-40*time.Minute)), "a request is used up by the next run")
+
+	disabled := hourly
+	disabled.Enabled = false
+	suite.False(due(disabled, at(-2*time.Hour), never), "disabled jobs only run on request")
+	suite.True(due(disabled, at(-2*time.Hour), at(-time.Minute)))
+
+	// A requested run keeps the job's place in its schedule
+	covers, ok := jobDue(hourly, &jobLease{lastRun: at(-20 * time.Minute), lastScheduled: at(-21 * time.Minute), requested: at(-time.Minute), now: now}, time.Minute)
+	suite.True(ok)
+	suite.Equal(now.Add(-21*time.Minute), covers)
+}
+
+func (suite *JobRunnerTestSuite) TestStoredOverrides() {
+	config := JobConfig{LeaseTTL: 30 * time.Second}
+	runner := NewJobRunner(nil, config)
+	runner.Register("export_cleanup", 15*time.Minute, nil)
+	job := runner.jobs["export_cleanup"]
+	suite.Equal("@every 15m0s", job.timing.Spec)
+
+	timing := job.timingFor(sql.NullString{String: "0 3 * * *", Valid: true}, sql.NullBool{Bool: false, Valid: true})
+	suite.Equal("0 3 * * *", timing.Spec)
+	suite.False(timing.Enabled)
+
+	// A schedule that no longer parses falls back to the configured one
+	suite.Equal("@every 15m0s", job.timingFor(sql.NullString{String: "whenever", Valid: true}, sql.NullBool{}).Spec)
+}
+
+func (suite *JobRunnerTestSuite) TestScheduleSettings() {
+	suite.T().Setenv("JOB_EXPORT_CLEANUP_SCHEDULE", "0 3 * * *")
+	config, err := DefaultJobConfig()
+	suite.Require().NoError(err)
+	runner := NewJobRunner(nil, config)
+	runner.Register("export_cleanup", 15*time.Minute, nil)
+	suite.Equal("0 3 * * *", runner.jobs["export_cleanup"].timing.Spec)
+
+	suite.T().Setenv("JOB_EXPORT_CLEANUP_SCHEDULE", "0 25 * * *")
+	_, err = DefaultJobConfig()
+	suite.Error(err)
+}
+
+func (suite *JobRunnerTestSuite) TestLeaseSettings() {
+	suite.T().Setenv("JOB_LEASE_TTL", "1s")
+	_, err := DefaultJobConfig()
+	suite.Error(err, "renewing every 333ms would hammer the database")
+
+	suite.T().Setenv("JOB_LEASE_TTL", "1m")
+	config, err := DefaultJobConfig()
+	suite.Require().NoError(err)
+	suite.Equal(time.Minute, config.LeaseTTL)
+}
+
+func (suite *JobRunnerTestSuite) TestJobsRegisterOnce() {
+	runner := NewJobRunner(nil, JobConfig{LeaseTTL: 30 * time.Second})
+	runner.Register("export_cleanup", time.Minute, nil)
+	suite.Panics(func() { runner.Register("export_cleanup", time.Minute, nil) })
+}
+
+func TestJobRunnerTestSuite(t *testing.T) {
+	suite.Run(t, new(JobRunnerTestSuite))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type JWTAccessTokenTestSuite struct {
+	suite.Suite
+	as *AuthService
+}
+
+func (suite *JWTAccessTokenTestSuite) SetupTest() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	suite.as = &AuthService{
+		jwt:      NewJWTManagerWithKey(key, "https://auth.example.org"),
+		audience: AudienceConfig{FirstParty: "liberation-platform"},
+	}
+}
+
+// jwksKey rebuilds a public key from the JWKS, the way a resource server would
+func (suite *JWTAccessTokenTestSuite) jwksKey(keyID string) *rsa.PublicKey {
+	for _, key := range suite.as.jwt.GetJWKS()["keys"].([]map[string]interface{}) {
+		if key["kid"] != keyID {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key["n"].(string))
+		suite.Require().NoError(err)
+		e, err := base64.RawURLEncoding.DecodeString(key["e"].(string))
+		suite.Require().NoError(err)
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	suite.FailNow("kid not in the JWKS", keyID)
+	return nil
+}
+
+func (suite *JWTAccessTokenTestSuite) sign(token *models.OAuthAccessToken, audience []string) (*jwt.Token, jwt.MapClaims) {
+	signed, err := suite.as.jwt.GenerateAccessToken(suite.as.accessTokenClaims(context.Background(), token, audience))
+	suite.Require().NoError(err)
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(signed, claims, func(t *jwt.Token) (interface{}, error) {
+		return suite.jwksKey(t.Header["kid"].(string)), nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	suite.Require().NoError(err)
+	return parsed, claims
+}
+
+func (suite *JWTAccessTokenTestSuite) TestUserTokenFollowsRFC9068() {
+	userID, clientID := uuid.New(), uuid.New()
+	now := time.Now()
+	token := &models.OAuthAccessToken{
+		ID: uuid.New(), UserID: &userID, ClientID: clientID, Scopes: []string{"read", "write"},
+		ExpiresAt: now.Add(time.Hour), CreatedAt: now,
+	}
+
+	parsed, claims := suite.sign(token, []string{"https://api.example.org"})
+	suite.Equal("at+jwt", parsed.Header["typ"])
+	suite.Equal("RS256", parsed.Header["alg"])
+
+	suite.Equal("https://auth.example.org", claims["iss"])
+	suite.Equal(userID.String(), claims["sub"])
+	suite.Equal("https://api.example.org", claims["aud"])
+	suite.Equal(clientID.String(), claims["client_id"])
+	suite.Equal(token.ID.String(), claims["jti"])
+	suite.Equal("read write", claims["scope"])
+	suite.Equal(float64(token.ExpiresAt.Unix()), claims["exp"])
+	suite.Equal(float64(now.Unix()), claims["iat"])
+}
+
+func (suite *JWTAccessTokenTestSuite) TestClientCredentialsTokenNamesTheClient() {
+	clientID := uuid.New()
+	token := &models.OAuthAccessToken{
+		ID: uuid.New(), ClientID: clientID, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now(),
+	}
+
+	_, claims := suite.sign(token, nil)
+	suite.Equal(clientID.String(), claims["sub"])
+	suite.Equal("liberation-platform", claims["aud"])
+	suite.NotContains(claims, "scope")
+}
+
+func (suite *JWTAccessTokenTestSuite) TestAccessTokensAreNotFirstPartyTokens() {
+	userID := uuid.New()
+	signed, err := suite.as.jwt.GenerateAccessToken(suite.as.accessTokenClaims(context.Background(), &models.OAuthAccessToken{
+		ID: uuid.New(), UserID: &userID, ClientID: uuid.New(), ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now(),
+	}, nil))
+	suite.Require().NoError(err)
+
+	_, err = suite.as.jwt.ValidateToken(signed)
+	suite.Error(err)
+	_, err = suite.as.jwt.ValidateTokenForAudience(signed, "liberation-platform")
+	suite.Error(err)
+
+	// First-party tokens from the same key still validate
+	session, err := suite.as.jwt.GenerateToken(userID, "liberation-platform", []string{"read"}, time.Hour)
+	suite.Require().NoError(err)
+	_, err = suite.as.jwt.ValidateTokenForAudience(session, "liberation-platform")
+	suite.NoError(err)
+}
+
+func (suite *JWTAccessTokenTestSuite) TestFormats() {
+	suite.True(validAccessTokenFormat("opaque"))
+	suite.True(validAccessTokenFormat("jwt"))
+	suite.False(validAccessTokenFormat(""))
+	suite.False(validAccessTokenFormat("JWT"))
+}
+
+func TestJWTAccessTokenTestSuite(t *testing.T) {
+	suite.Run(t, new(JWTAccessTokenTestSuite))
+}
@@ -3,11 +3,13 @@ package main
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -16,10 +18,14 @@ import (
 
 // JWTManager handles JWT token operations
 type JWTManager struct {
+	mu         sync.RWMutex
 	privateKey *rsa.PrivateKey
 	publicKey  *rsa.PublicKey
 	issuer     string
 	keyID      string
+	// previousKey still verifies tokens signed before the last rotation
+	previousKey   *rsa.PublicKey
+	previousKeyID string
 }
 
 // NewJWTManager creates a new JWT manager
@@ -39,8 +45,70 @@ func NewJWTManager(secret, issuer string) (*JWTManager, error) {
 	}, nil
 }
 
+// NewJWTManagerWithKey creates a JWT manager that signs with an existing key.
+// The key ID is the RFC 7638 thumbprint, so it is stable across restarts.
+func NewJWTManagerWithKey(privateKey *rsa.PrivateKey, issuer string) *JWTManager {
+	return &JWTManager{
+		privateKey: privateKey,
+		publicKey:  &privateKey.PublicKey,
+		issuer:     issuer,
+		keyID:      rsaThumbprint(&privateKey.PublicKey),
+	}
+}
+
+// rsaThumbprint is the RFC 7638 JWK thumbprint of an RSA public key
+func rsaThumbprint(publicKey *rsa.PublicKey) string {
+	thumbprintInput := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+		encodeRSAPublicKeyComponent(big.NewInt(int64(publicKey.E))),
+		encodeRSAPublicKeyComponent(publicKey.N))
+	thumbprint := sha256.Sum256([]byte(thumbprintInput))
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:])
+}
+
+// Rotate starts signing with a new key. The outgoing key keeps verifying tokens until the
+// next rotation and stays in the JWKS, so tokens issued just before a rotation still work.
+func (jm *JWTManager) Rotate(privateKey *rsa.PrivateKey) {
+	keyID := rsaThumbprint(&privateKey.PublicKey)
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	if keyID == jm.keyID {
+		return
+	}
+	jm.previousKey, jm.previousKeyID = jm.publicKey, jm.keyID
+	jm.privateKey, jm.publicKey, jm.keyID = privateKey, &privateKey.PublicKey, keyID
+}
+
+// signingKey returns the current private key and its key ID
+func (jm *JWTManager) signingKey() (*rsa.PrivateKey, string) {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+	return jm.privateKey, jm.keyID
+}
+
+// verificationKey finds the public key for a token's kid; tokens without one are checked
+// against the current key
+func (jm *JWTManager) verificationKey(keyID interface{}) (*rsa.PublicKey, error) {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+	switch keyID {
+	case nil, jm.keyID:
+		return jm.publicKey, nil
+	case jm.previousKeyID:
+		if jm.previousKey != nil {
+			return jm.previousKey, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %v", keyID)
+}
+
 // GenerateToken creates a new JWT token
 func (jm *JWTManager) GenerateToken(userID uuid.UUID, audience string, scopes []string, expiresIn time.Duration) (string, error) {
+	return jm.GenerateTokenWithClaims(userID, audience, scopes, expiresIn, nil)
+}
+
+// GenerateTokenWithClaims creates a new JWT token carrying additional private claims.
+// Registered claims always win over extra claims with the same name.
+func (jm *JWTManager) GenerateTokenWithClaims(userID uuid.UUID, audience string, scopes []string, expiresIn time.Duration, extra map[string]interface{}) (string, error) {
 	now := time.Now()
 	claims := jwt.MapClaims{
 		"iss":   jm.issuer,
@@ -53,21 +121,61 @@ func (jm *JWTManager) GenerateToken(userID uuid.UUID, audience string, scopes []
 		"scope": scopes,
 		"typ":   "Bearer",
 	}
+	for name, value := range extra {
+		if _, reserved := claims[name]; !reserved {
+			claims[name] = value
+		}
+	}
+
+	privateKey, keyID := jm.signingKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
 
+	return token.SignedString(privateKey)
+}
+
+// GenerateAccessToken signs an RFC 9068 access token with the given claims; iss is always
+// this issuer. The at+jwt typ header keeps it from validating as a first-party token.
+func (jm *JWTManager) GenerateAccessToken(claims jwt.MapClaims) (string, error) {
+	claims["iss"] = jm.issuer
+
+	privateKey, keyID := jm.signingKey()
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	token.Header["kid"] = jm.keyID
+	token.Header["typ"] = accessTokenJWTType
+	token.Header["kid"] = keyID
+
+	return token.SignedString(privateKey)
+}
 
-	return token.SignedString(jm.privateKey)
+// TokenClaims are the claims of a validated token
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	Scope []string `json:"scope,omitempty"`
+	// SignOutEpoch is the user's sign-out epoch when the token was issued; tokens issued
+	// before epochs existed carry none and count as epoch 0 (see signout_epoch.go)
+	SignOutEpoch int64 `json:"sep,omitempty"`
 }
 
 // ValidateToken validates and parses a JWT token
-func (jm *JWTManager) ValidateToken(tokenString string) (*jwt.RegisteredClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+func (jm *JWTManager) ValidateToken(tokenString string) (*TokenClaims, error) {
+	return jm.validate(tokenString)
+}
+
+// ValidateTokenForAudience validates a JWT token and requires audience among its aud values
+func (jm *JWTManager) ValidateTokenForAudience(tokenString, audience string) (*TokenClaims, error) {
+	return jm.validate(tokenString, jwt.WithAudience(audience))
+}
+
+func (jm *JWTManager) validate(tokenString string, options ...jwt.ParserOption) (*TokenClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return jm.publicKey, nil
-	})
+		if token.Header["typ"] == accessTokenJWTType {
+			return nil, fmt.Errorf("OAuth access tokens are not first-party tokens")
+		}
+		return jm.verificationKey(token.Header["kid"])
+	}, options...)
 
 	if err != nil {
 		return nil, err
@@ -77,7 +185,7 @@ func (jm *JWTManager) ValidateToken(tokenString string) (*jwt.RegisteredClaims,
 		return nil, fmt.Errorf("invalid token")
 	}
 
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	claims, ok := token.Claims.(*TokenClaims)
 	if !ok {
 		return nil, fmt.Errorf("invalid token claims")
 	}
@@ -87,12 +195,14 @@ func (jm *JWTManager) ValidateToken(tokenString string) (*jwt.RegisteredClaims,
 
 // GetPublicKey returns the public key for token verification
 func (jm *JWTManager) GetPublicKey() *rsa.PublicKey {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
 	return jm.publicKey
 }
 
 // GetPublicKeyPEM returns the public key in PEM format
 func (jm *JWTManager) GetPublicKeyPEM() (string, error) {
-	pubKeyBytes, err := x509.MarshalPKIXPublicKey(jm.publicKey)
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(jm.GetPublicKey())
 	if err != nil {
 		return "", err
 	}
@@ -105,19 +215,25 @@ func (jm *JWTManager) GetPublicKeyPEM() (string, error) {
 	return string(pubKeyPEM), nil
 }
 
-// GetJWKS returns the JSON Web Key Set for the public key
+// GetJWKS returns the JSON Web Key Set: the current key, then the one it replaced
 func (jm *JWTManager) GetJWKS() map[string]interface{} {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+	keys := []map[string]interface{}{jwk(jm.keyID, jm.publicKey)}
+	if jm.previousKey != nil {
+		keys = append(keys, jwk(jm.previousKeyID, jm.previousKey))
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+func jwk(keyID string, publicKey *rsa.PublicKey) map[string]interface{} {
 	return map[string]interface{}{
-		"keys": []map[string]interface{}{
-			{
-				"kty": "RSA",
-				"use": "sig",
-				"alg": "RS256",
-				"kid": jm.keyID,
-				"n":   encodeRSAPublicKeyComponent(jm.publicKey.N),
-				"e":   encodeRSAPublicKeyComponent(big.NewInt(int64(jm.publicKey.E))),
-			},
-		},
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": keyID,
+		"n":   encodeRSAPublicKeyComponent(publicKey.N),
+		"e":   encodeRSAPublicKeyComponent(big.NewInt(int64(publicKey.E))),
 	}
 }
 
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Where the cost of new password hashes came from
+const (
+	passwordHashSourceConfigured = "configured"
+	passwordHashSourceCalibrated = "calibrated"
+	passwordHashSourcePersisted  = "persisted"
+)
+
+// calibrationRuns is how many hashes are timed at each cost; the fastest counts, so a
+// scheduler hiccup during startup does not lower the cost
+const calibrationRuns = 3
+
+var passwordHashCost = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "liberation_auth_password_hash_cost",
+	Help: "bcrypt cost of new password hashes",
+})
+
+// passwordHashParameters are the settings new password hashes are made with. Existing
+// hashes keep the cost they were made with; bcrypt records it in the hash.
+type passwordHashParameters struct {
+	Algorithm string
+	Cost      int
+	Source    string
+	// TargetLatency, MeasuredLatency, Host and CalibratedAt describe the calibration, if any
+	TargetLatency   time.Duration
+	MeasuredLatency time.Duration
+	Host            string
+	CalibratedAt    *time.Time
+}
+
+func configuredPasswordHashParameters(config PasswordHashConfig) passwordHashParameters {
+	cost := config.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return passwordHashParameters{Algorithm: "bcrypt", Cost: cost, Source: passwordHashSourceConfigured}
+}
+
+// calibrateBcryptCost returns the highest cost between minCost and maxCost that measure
+// times within target, and how long it took. Each step doubles the work, so the search
+// stops at the first cost over target. minCost is returned even when it is too slow.
+func calibrateBcryptCost(target time.Duration, minCost, maxCost int, measure func(cost int) time.Duration) (int, time.Duration) {
+	chosen, measured := minCost, measure(minCost)
+	for cost := minCost + 1; cost <= maxCost; cost++ {
+		elapsed := measure(cost)
+		if elapsed > target {
+			break
+		}
+		chosen, measured = cost, elapsed
+	}
+	return chosen, measured
+}
+
+// measureBcrypt times the fastest of a few hashes at cost
+func measureBcrypt(cost int) time.Duration {
+	password := []byte("liberation-auth calibration")
+	fastest := time.Duration(0)
+	for i := 0; i < calibrationRuns; i++ {
+		start := time.Now()
+		bcrypt.GenerateFromPassword(password, cost)
+		if elapsed := time.Since(start); fastest == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+	return fastest
+}
+
+// tunePasswordHashing decides the cost of new hashes. With a target latency, the cost
+// persisted for that target is reused so every replica and restart hashes alike; the host
+// is benchmarked only when none was persisted, the target changed or a recalibration was
+// asked for. The first replica to persist a cost wins.
+func (as *AuthService) tunePasswordHashing(ctx context.Context) {
+	h := as.passwords
+	config := h.config
+	defer func() { passwordHashCost.Set(float64(h.parameters.Cost)) }()
+	if config.TargetLatency == 0 {
+		return
+	}
+
+	persisted, err := as.loadPasswordHashParameters(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Could not load persisted password hash cost: %v", err)
+	}
+	if err == nil && !config.Recalibrate && persisted.TargetLatency == config.TargetLatency &&
+		persisted.Cost >= config.MinCost && persisted.Cost <= config.MaxCost {
+		h.parameters = persisted
+		return
+	}
+
+	cost, measured := calibrateBcryptCost(config.TargetLatency, config.MinCost, config.MaxCost, measureBcrypt)
+	host, _ := os.Hostname()
+	// Postgres keeps microseconds, so the stored time can be told apart from another replica's
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	h.parameters = passwordHashParameters{
+		Algorithm:       "bcrypt",
+		Cost:            cost,
+		Source:          passwordHashSourceCalibrated,
+		TargetLatency:   config.TargetLatency,
+		MeasuredLatency: measured,
+		Host:            host,
+		CalibratedAt:    &now,
+	}
+	log.Printf("Calibrated bcrypt cost %d: %s per hash against a %s target", cost, measured.Round(time.Millisecond), config.TargetLatency)
+	if measured > config.TargetLatency {
+		log.Printf("bcrypt cost %d is the lowest allowed but misses the %s target; consider more CPU or a lower PASSWORD_HASH_MIN_COST", cost, config.TargetLatency)
+	}
+
+	// A recalibration or a new target replaces the persisted cost; otherwise another replica
+	// may have persisted one meanwhile, and that one is used instead
+	replace := err == nil
+	if err := as.savePasswordHashParameters(ctx, h.parameters, replace); err != nil {
+		log.Printf("Could not persist password hash cost: %v", err)
+		return
+	}
+	if !replace {
+		if winner, err := as.loadPasswordHashParameters(ctx); err == nil && winner.CalibratedAt != nil && !winner.CalibratedAt.Equal(now) {
+			h.parameters = winner
+		}
+	}
+}
+
+func (as *AuthService) loadPasswordHashParameters(ctx context.Context) (passwordHashParameters, error) {
+	params := passwordHashParameters{Algorithm: "bcrypt", Source: passwordHashSourcePersisted}
+	var targetMillis, measuredMillis int64
+	var calibratedAt time.Time
+	err := as.db.QueryRowContext(ctx, `
+		SELECT cost, target_latency_ms, measured_latency_ms, host, calibrated_at
+		FROM password_hash_parameters WHERE algorithm = $1`, params.Algorithm).
+		Scan(&params.Cost, &targetMillis, &measuredMillis, &params.Host, &calibratedAt)
+	if err != nil {
+		return params, err
+	}
+	params.TargetLatency = time.Duration(targetMillis) * time.Millisecond
+	params.MeasuredLatency = time.Duration(measuredMillis) * time.Millisecond
+	calibratedAt = calibratedAt.UTC()
+	params.CalibratedAt = &calibratedAt
+	return params, nil
+}
+
+func (as *AuthService) savePasswordHashParameters(ctx context.Context, params passwordHashParameters, replace bool) error {
+	conflict := `DO NOTHING`
+	if replace {
+		conflict = `DO UPDATE SET cost = EXCLUDED.cost, target_latency_ms = EXCLUDED.target_latency_ms,
+			measured_latency_ms = EXCLUDED.measured_latency_ms, host = EXCLUDED.host, calibrated_at = EXCLUDED.calibrated_at`
+	}
+	_, err := as.db.ExecContext(ctx, `
+		INSERT INTO password_hash_parameters (algorithm, cost, target_latency_ms, measured_latency_ms, host, calibrated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (algorithm) `+conflict,
+		params.Algorithm, params.Cost, params.TargetLatency.Milliseconds(), params.MeasuredLatency.Milliseconds(),
+		params.Host, *params.CalibratedAt)
+	return err
+}
+
+// AdminGetConfig reports settings the service chose at startup rather than read verbatim
+// from the environment
+func (as *AuthService) AdminGetConfig(c *gin.Context) {
+	h := as.passwords
+	kdf := gin.H{
+		"algorithm": h.parameters.Algorithm,
+		"cost":      h.parameters.Cost,
+		"source":    h.parameters.Source,
+		"min_cost":  h.config.MinCost,
+		"max_cost":  h.config.MaxCost,
+	}
+	if h.config.TargetLatency > 0 {
+		kdf["target_latency_ms"] = h.config.TargetLatency.Milliseconds()
+	}
+	if h.parameters.CalibratedAt != nil {
+		kdf["measured_latency_ms"] = h.parameters.MeasuredLatency.Milliseconds()
+		kdf["host"] = h.parameters.Host
+		kdf["calibrated_at"] = h.parameters.CalibratedAt
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"password_hashing": gin.H{
+			"workers":           h.config.Workers,
+			"queue_size":        h.config.QueueSize,
+			"latency_budget_ms": h.config.LatencyBudget.Milliseconds(),
+			"kdf":               kdf,
+		},
+	})
+}
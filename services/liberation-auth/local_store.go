@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// localStoreTradeoffs explain what single-binary mode gives up; they are shown on /health
+var localStoreTradeoffs = []string{
+	"single replica only: rate limits, sessions and authorization requests are not shared between processes",
+	"writes since the last snapshot are lost if the process crashes",
+	"snapshots keep strings, hashes, sets, sorted sets and streams; stream consumer groups are recreated on start",
+	"revocation broadcasts only reach this process",
+}
+
+// LocalStoreConfig configures the in-process store used when REDIS_URL is unset
+type LocalStoreConfig struct {
+	// SnapshotFile keeps keys across restarts; empty keeps them in memory only
+	SnapshotFile     string
+	SnapshotInterval time.Duration
+}
+
+// DefaultLocalStoreConfig reads LOCAL_STORE_FILE and LOCAL_STORE_SNAPSHOT_INTERVAL
+func DefaultLocalStoreConfig() (LocalStoreConfig, error) {
+	config := LocalStoreConfig{SnapshotFile: getEnv("LOCAL_STORE_FILE", "liberation-auth-store.json")}
+	if config.SnapshotFile == "none" {
+		config.SnapshotFile = ""
+	}
+	var err error
+	if config.SnapshotInterval, err = time.ParseDuration(getEnv("LOCAL_STORE_SNAPSHOT_INTERVAL", "30s")); err != nil || config.SnapshotInterval <= 0 {
+		return config, fmt.Errorf("LOCAL_STORE_SNAPSHOT_INTERVAL must be a positive duration")
+	}
+	return config, nil
+}
+
+// LocalStore is an embedded Redis-compatible server on a loopback port, so every
+// Redis-backed feature works unchanged in a single process
+type LocalStore struct {
+	config LocalStoreConfig
+	server *miniredis.Miniredis
+	stop   context.CancelFunc
+	done   sync.WaitGroup
+	// mu serialises snapshots so the final one on Close is never overwritten by a stale one
+	mu sync.Mutex
+}
+
+// localStoreEntry is one key in a snapshot; only the value types the service uses are kept.
+// Streams keep their entries but not their consumer groups: the security event writer
+// deletes what it has written, so recreating its group from the start replays the rest.
+type localStoreEntry struct {
+	Type      string             `json:"type"`
+	Value     string             `json:"value,omitempty"`
+	Members   map[string]float64 `json:"members,omitempty"`
+	Fields    map[string]string  `json:"fields,omitempty"`
+	Elements  []string           `json:"elements,omitempty"`
+	Stream    []localStreamEntry `json:"stream,omitempty"`
+	ExpiresAt *time.Time         `json:"expires_at,omitempty"`
+}
+
+type localStreamEntry struct {
+	ID     string   `json:"id"`
+	Values []string `json:"values"`
+}
+
+// StartLocalStore starts the embedded server and restores the last snapshot
+func StartLocalStore(config LocalStoreConfig) (*LocalStore, error) {
+	store := &LocalStore{config: config, server: miniredis.NewMiniRedis()}
+	if err := store.restore(); err != nil {
+		return nil, fmt.Errorf("restoring %s: %w", config.SnapshotFile, err)
+	}
+	if err := store.server.StartAddr("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	store.stop = stop
+	store.done.Add(1)
+	go store.maintain(ctx)
+	return store, nil
+}
+
+// Addr is the loopback address to point the Redis client at
+func (s *LocalStore) Addr() string {
+	return s.server.Addr()
+}
+
+// maintain expires keys in real time (the embedded server only counts TTLs down when told to)
+// and writes periodic snapshots
+func (s *LocalStore) maintain(ctx context.Context) {
+	defer s.done.Done()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	last, lastSnapshot := time.Now(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			s.server.FastForward(now.Sub(last))
+			last = now
+			if now.Sub(lastSnapshot) >= s.config.SnapshotInterval {
+				if err := s.snapshot(); err != nil {
+					log.Printf("Local store snapshot failed: %v", err)
+				}
+				lastSnapshot = now
+			}
+		}
+	}
+}
+
+// snapshot writes every key to SnapshotFile, replacing the previous snapshot atomically
+func (s *LocalStore) snapshot() error {
+	if s.config.SnapshotFile == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entries := map[string]localStoreEntry{}
+	skipped := map[string]int{}
+	for _, key := range s.server.Keys() {
+		entry := localStoreEntry{Type: s.server.Type(key)}
+		switch entry.Type {
+		case "string":
+			value, err := s.server.Get(key)
+			if err != nil {
+				continue
+			}
+			entry.Value = value
+		case "zset":
+			members, err := s.server.SortedSet(key)
+			if err != nil {
+				continue
+			}
+			entry.Members = members
+		case "hash":
+			fields, err := s.server.HKeys(key)
+			if err != nil {
+				continue
+			}
+			entry.Fields = make(map[string]string, len(fields))
+			for _, field := range fields {
+				entry.Fields[field] = s.server.HGet(key, field)
+			}
+		case "set":
+			elements, err := s.server.Members(key)
+			if err != nil {
+				continue
+			}
+			entry.Elements = elements
+		case "stream":
+			stream, err := s.server.Stream(key)
+			if err != nil {
+				continue
+			}
+			for _, item := range stream {
+				entry.Stream = append(entry.Stream, localStreamEntry{ID: item.ID, Values: item.Values})
+			}
+		default:
+			skipped[entry.Type]++
+			continue
+		}
+		if ttl := s.server.TTL(key); ttl > 0 {
+			expiresAt := now.Add(ttl)
+			entry.ExpiresAt = &expiresAt
+		}
+		entries[key] = entry
+	}
+	for keyType, count := range skipped {
+		log.Printf("Local store snapshot skipped %d %s keys; they will not survive a restart", count, keyType)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.config.SnapshotFile), ".liberation-auth-store-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.config.SnapshotFile)
+}
+
+// restore loads the snapshot, skipping keys that expired while the service was down
+func (s *LocalStore) restore() error {
+	if s.config.SnapshotFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.config.SnapshotFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries map[string]localStoreEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for key, entry := range entries {
+		if entry.ExpiresAt != nil && !entry.ExpiresAt.After(now) {
+			continue
+		}
+		switch entry.Type {
+		case "string":
+			if err := s.server.Set(key, entry.Value); err != nil {
+				return err
+			}
+		case "zset":
+			for member, score := range entry.Members {
+				if _, err := s.server.ZAdd(key, score, member); err != nil {
+					return err
+				}
+			}
+		case "hash":
+			for field, value := range entry.Fields {
+				s.server.HSet(key, field, value)
+			}
+		case "set":
+			if _, err := s.server.SetAdd(key, entry.Elements...); err != nil {
+				return err
+			}
+		case "stream":
+			for _, item := range entry.Stream {
+				if _, err := s.server.XAdd(key, item.ID, item.Values); err != nil {
+					return err
+				}
+			}
+		}
+		if entry.ExpiresAt != nil {
+			s.server.SetTTL(key, entry.ExpiresAt.Sub(now))
+		}
+	}
+	return nil
+}
+
+// Close writes a final snapshot and stops the server
+func (s *LocalStore) Close() {
+	s.stop()
+	s.done.Wait()
+	if err := s.snapshot(); err != nil {
+		log.Printf("Final local store snapshot failed: %v", err)
+	}
+	s.server.Close()
+}
+
+// healthInfo describes the store for the /health payload
+func (s *LocalStore) healthInfo() map[string]interface{} {
+	persistence := "memory only; all keys are lost on restart"
+	if s.config.SnapshotFile != "" {
+		persistence = fmt.Sprintf("snapshot to %s every %s", s.config.SnapshotFile, s.config.SnapshotInterval)
+	}
+	return map[string]interface{}{
+		"backend":     "local",
+		"persistence": persistence,
+		"tradeoffs":   localStoreTradeoffs,
+	}
+}
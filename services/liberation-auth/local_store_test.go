@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type LocalStoreTestSuite struct {
+	suite.Suite
+	config LocalStoreConfig
+}
+
+func (suite *LocalStoreTestSuite) SetupTest() {
+	suite.config = LocalStoreConfig{
+		SnapshotFile:     filepath.Join(suite.T().TempDir(), "store.json"),
+		SnapshotInterval: time.Hour,
+	}
+}
+
+func (suite *LocalStoreTestSuite) start() (*LocalStore, *redis.Client) {
+	store, err := StartLocalStore(suite.config)
+	suite.Require().NoError(err)
+	client := redis.NewClient(&redis.Options{Addr: store.Addr()})
+	return store, client
+}
+
+func (suite *LocalStoreTestSuite) TestKeysSurviveRestart() {
+	ctx := context.Background()
+	store, client := suite.start()
+	suite.Require().NoError(client.Set(ctx, "session:abc", "user-1", time.Hour).Err())
+	suite.Require().NoError(client.Set(ctx, "consent:gone", "{}", time.Second).Err())
+	suite.Require().NoError(client.ZAdd(ctx, authRequestPendingKey, redis.Z{Score: 42, Member: "req-1"}).Err())
+	client.Close()
+	store.Close()
+
+	// The short-lived key expires while the service is down
+	time.Sleep(1100 * time.Millisecond)
+
+	store, client = suite.start()
+	defer store.Close()
+	defer client.Close()
+
+	suite.Equal("user-1", client.Get(ctx, "session:abc").Val())
+	suite.Greater(client.TTL(ctx, "session:abc").Val(), 58*time.Minute)
+	suite.Equal(int64(0), client.Exists(ctx, "consent:gone").Val())
+	suite.Equal([]string{"req-1"}, client.ZRange(ctx, authRequestPendingKey, 0, -1).Val())
+}
+
+// Client usage counters are hashes and the shared token cache indexes its keys in sets
+func (suite *LocalStoreTestSuite) TestHashesAndSetsSurviveRestart() {
+	ctx := context.Background()
+	store, client := suite.start()
+	day := time.Now().UTC()
+	suite.Require().NoError(client.HIncrBy(ctx, clientUsageKey(day), "client-1:requests", 3).Err())
+	suite.Require().NoError(client.HIncrBy(ctx, clientUsageKey(day), "client-1:errors", 1).Err())
+	suite.Require().NoError(client.Expire(ctx, clientUsageKey(day), time.Hour).Err())
+	suite.Require().NoError(client.SAdd(ctx, sharedTokenIndex("user", "user-1"), "token_cache:a", "token_cache:b").Err())
+	client.Close()
+	store.Close()
+
+	store, client = suite.start()
+	defer store.Close()
+	defer client.Close()
+
+	suite.Equal(map[string]string{"client-1:requests": "3", "client-1:errors": "1"}, client.HGetAll(ctx, clientUsageKey(day)).Val())
+	suite.Greater(client.TTL(ctx, clientUsageKey(day)).Val(), 58*time.Minute)
+	suite.ElementsMatch([]string{"token_cache:a", "token_cache:b"}, client.SMembers(ctx, sharedTokenIndex("user", "user-1")).Val())
+}
+
+// Security events wait in a stream until the writer has inserted them
+func (suite *LocalStoreTestSuite) TestUnwrittenSecurityEventsSurviveRestart() {
+	ctx := context.Background()
+	config := SecurityEventConfig{Stream: "security_events", Group: "security-event-writers", BatchSize: 10, HighWater: 100, ClaimIdle: time.Minute, Block: 10 * time.Millisecond}
+	store, client := suite.start()
+	publisher := &securityEventPipeline{redis: client, config: config, consumer: "a"}
+	suite.Require().NoError(client.XGroupCreateMkStream(ctx, config.Stream, config.Group, "0").Err())
+	var ids []uuid.UUID
+	for i := 0; i < 3; i++ {
+		event := securityEvent{ID: uuid.New(), Type: securityEventLoginFailed, CreatedAt: time.Now()}
+		suite.Require().NoError(publisher.publish(ctx, event))
+		ids = append(ids, event.ID)
+	}
+	// One was read but not written before the process stopped
+	suite.Require().NoError(client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: config.Group, Consumer: "a", Streams: []string{config.Stream, ">"}, Count: 1, Block: -1,
+	}).Err())
+	client.Close()
+	store.Close()
+
+	store, client = suite.start()
+	defer store.Close()
+	defer client.Close()
+
+	var mu sync.Mutex
+	written := map[uuid.UUID]bool{}
+	writer := &securityEventPipeline{redis: client, config: config, consumer: "a", insert: func(ctx context.Context, events []securityEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, event := range events {
+			written[event.ID] = true
+		}
+		return nil
+	}}
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		writer.Run(runCtx)
+		close(done)
+	}()
+	suite.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(written) == len(ids)
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	for _, id := range ids {
+		suite.True(written[id], "event %s was lost", id)
+	}
+}
+
+func (suite *LocalStoreTestSuite) TestTTLsCountDownInRealTime() {
+	ctx := context.Background()
+	suite.config.SnapshotFile = ""
+	store, client := suite.start()
+	defer store.Close()
+	defer client.Close()
+
+	suite.Require().NoError(client.Set(ctx, "rate_limit:test", "1", time.Second).Err())
+	suite.Eventually(func() bool {
+		return client.Exists(ctx, "rate_limit:test").Val() == 0
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestLocalStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(LocalStoreTestSuite))
+}
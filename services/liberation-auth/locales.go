@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/text/language"
+)
+
+// defaultLocale is used when neither the user's preference nor Accept-Language matches a catalog
+const defaultLocale = "en"
+
+// Locale is a language the service has messages in
+type Locale struct {
+	Tag string `json:"tag"`
+	// Name is the language's name in that language
+	Name string `json:"name"`
+}
+
+// supportedLocales have a message catalog, the default first
+var supportedLocales = []Locale{
+	{Tag: "en", Name: "English"},
+	{Tag: "es", Name: "Español"},
+	{Tag: "fr", Name: "Français"},
+	{Tag: "de", Name: "Deutsch"},
+}
+
+var localeMatcher = func() language.Matcher {
+	tags := make([]language.Tag, len(supportedLocales))
+	for i, locale := range supportedLocales {
+		tags[i] = language.Make(locale.Tag)
+	}
+	return language.NewMatcher(tags)
+}()
+
+// messageCatalogs hold the user-facing messages by locale and key. English is complete;
+// a key missing from another catalog falls back to it.
+var messageCatalogs = map[string]map[string]string{
+	"en": {
+		"username.length":                        "Names are %d-%d characters long",
+		"username.edges":                         "Names must start and end with a letter or digit",
+		"username.scripts":                       "Names cannot mix letters from different scripts",
+		"username.characters":                    "Names may only contain letters, digits",
+		"username.characters_punctuation":        "Names may only contain letters, digits and %s",
+		"username.script_characters":             "Names may only contain letters and digits from one script",
+		"username.script_characters_punctuation": "Names may only contain letters and digits from one script and %s",
+		"username.reserved":                      "This name is reserved",
+		"username.unavailable":                   "This name is not available",
+		"username.check_failed":                  "Could not check name availability",
+		"locale.unsupported":                     "%s is not a supported locale",
+
+		"consent.prompt": "%s would like to access your account",
+
+		"notification.inactivity_warning":          "We haven't seen you in a while. Sign in to keep your account active.",
+		"notification.account_locked":              "Your account was locked after a long period of inactivity.",
+		"notification.password_reset_required":     "Your account was moved to a new service. Choose a new password to sign in.",
+		"notification.new_device":                  "A new device signed in to your account.",
+		"notification.recovery_contact_invited":    "You were asked to be a trusted contact for account recovery.",
+		"notification.recovery_contacts_changed":   "Your trusted contacts for account recovery changed.",
+		"notification.recovery_approval_requested": "Someone who trusts you is recovering their account and needs your approval.",
+		"notification.recovery_requested":          "Account recovery was requested through your trusted contacts.",
+		"notification.recovery_failed":             "An account recovery request expired without enough approvals.",
+		"notification.recovery_completed":          "Your account was recovered through your trusted contacts.",
+		"notification.recovery_approved":           "Your trusted contacts approved your account recovery.",
+		"notification.recovery_denied":             "A trusted contact denied your account recovery.",
+		"notification.recovery_cancelled":          "An account recovery request was cancelled.",
+	},
+	"es": {
+		"username.length":                        "Los nombres tienen entre %d y %d caracteres",
+		"username.edges":                         "Los nombres deben empezar y terminar con una letra o un dígito",
+		"username.scripts":                       "Los nombres no pueden mezclar letras de distintos alfabetos",
+		"username.characters":                    "Los nombres solo pueden contener letras y dígitos",
+		"username.characters_punctuation":        "Los nombres solo pueden contener letras, dígitos y %s",
+		"username.script_characters":             "Los nombres solo pueden contener letras y dígitos de un mismo alfabeto",
+		"username.script_characters_punctuation": "Los nombres solo pueden contener letras y dígitos de un mismo alfabeto y %s",
+		"username.reserved":                      "Este nombre está reservado",
+		"username.unavailable":                   "Este nombre no está disponible",
+		"username.check_failed":                  "No se pudo comprobar si el nombre está disponible",
+		"locale.unsupported":                     "%s no es un idioma admitido",
+
+		"consent.prompt":                   "%s quiere acceder a tu cuenta",
+		"consent.scope.openid":             "Confirmar tu identidad",
+		"consent.scope.profile":            "Ver tu nombre de usuario, nombre visible y perfil",
+		"consent.scope.email":              "Ver tu dirección de correo electrónico",
+		"consent.scope.read":               "Leer tus obras, marcadores y colecciones",
+		"consent.scope.write":              "Crear y editar contenido en tu nombre",
+		"consent.scope.works:manage":       "Publicar, editar y eliminar tus obras",
+		"consent.scope.comments:write":     "Publicar comentarios en tu nombre",
+		"consent.scope.bookmarks:manage":   "Crear y eliminar tus marcadores",
+		"consent.scope.collections:manage": "Gestionar tus colecciones",
+
+		"notification.inactivity_warning":          "Hace tiempo que no te vemos. Inicia sesión para mantener tu cuenta activa.",
+		"notification.account_locked":              "Tu cuenta se bloqueó tras un largo periodo de inactividad.",
+		"notification.password_reset_required":     "Tu cuenta se trasladó a un nuevo servicio. Elige una contraseña nueva para iniciar sesión.",
+		"notification.new_device":                  "Un dispositivo nuevo inició sesión en tu cuenta.",
+		"notification.recovery_contact_invited":    "Te pidieron ser contacto de confianza para recuperar una cuenta.",
+		"notification.recovery_contacts_changed":   "Tus contactos de confianza para recuperar la cuenta cambiaron.",
+		"notification.recovery_approval_requested": "Alguien que confía en ti está recuperando su cuenta y necesita tu aprobación.",
+		"notification.recovery_requested":          "Se solicitó recuperar tu cuenta a través de tus contactos de confianza.",
+		"notification.recovery_failed":             "Una solicitud de recuperación caducó sin suficientes aprobaciones.",
+		"notification.recovery_completed":          "Tu cuenta se recuperó a través de tus contactos de confianza.",
+		"notification.recovery_approved":           "Tus contactos de confianza aprobaron la recuperación de tu cuenta.",
+		"notification.recovery_denied":             "Un contacto de confianza rechazó la recuperación de tu cuenta.",
+		"notification.recovery_cancelled":          "Se canceló una solicitud de recuperación de cuenta.",
+	},
+	"fr": {
+		"username.length":                        "Les noms comptent de %d à %d caractères",
+		"username.edges":                         "Les noms doivent commencer et finir par une lettre ou un chiffre",
+		"username.scripts":                       "Les noms ne peuvent pas mélanger les lettres de plusieurs écritures",
+		"username.characters":                    "Les noms ne peuvent contenir que des lettres et des chiffres",
+		"username.characters_punctuation":        "Les noms ne peuvent contenir que des lettres, des chiffres et %s",
+		"username.script_characters":             "Les noms ne peuvent contenir que des lettres et des chiffres d'une seule écriture",
+		"username.script_characters_punctuation": "Les noms ne peuvent contenir que des lettres et des chiffres d'une seule écriture et %s",
+		"username.reserved":                      "Ce nom est réservé",
+		"username.unavailable":                   "Ce nom n'est pas disponible",
+		"username.check_failed":                  "Impossible de vérifier la disponibilité du nom",
+		"locale.unsupported":                     "%s n'est pas une langue prise en charge",
+
+		"consent.prompt":                   "%s souhaite accéder à votre compte",
+		"consent.scope.openid":             "Confirmer votre identité",
+		"consent.scope.profile":            "Voir votre nom d'utilisateur, votre nom affiché et votre profil",
+		"consent.scope.email":              "Voir votre adresse e-mail",
+		"consent.scope.read":               "Lire vos œuvres, favoris et collections",
+		"consent.scope.write":              "Créer et modifier du contenu en votre nom",
+		"consent.scope.works:manage":       "Publier, modifier et supprimer vos œuvres",
+		"consent.scope.comments:write":     "Publier des commentaires en votre nom",
+		"consent.scope.bookmarks:manage":   "Créer et supprimer vos favoris",
+		"consent.scope.collections:manage": "Gérer vos collections",
+
+		"notification.inactivity_warning":          "Cela fait longtemps ! Connectez-vous pour garder votre compte actif.",
+		"notification.account_locked":              "Votre compte a été verrouillé après une longue période d'inactivité.",
+		"notification.password_reset_required":     "Votre compte a été transféré vers un nouveau service. Choisissez un nouveau mot de passe pour vous connecter.",
+		"notification.new_device":                  "Un nouvel appareil s'est connecté à votre compte.",
+		"notification.recovery_contact_invited":    "On vous a demandé d'être un contact de confiance pour la récupération d'un compte.",
+		"notification.recovery_contacts_changed":   "Vos contacts de confiance pour la récupération du compte ont changé.",
+		"notification.recovery_approval_requested": "Une personne qui vous fait confiance récupère son compte et a besoin de votre accord.",
+		"notification.recovery_requested":          "La récupération de votre compte a été demandée via vos contacts de confiance.",
+		"notification.recovery_failed":             "Une demande de récupération a expiré sans assez d'approbations.",
+		"notification.recovery_completed":          "Votre compte a été récupéré grâce à vos contacts de confiance.",
+		"notification.recovery_approved":           "Vos contacts de confiance ont approuvé la récupération de votre compte.",
+		"notification.recovery_denied":             "Un contact de confiance a refusé la récupération de votre compte.",
+		"notification.recovery_cancelled":          "Une demande de récupération de compte a été annulée.",
+	},
+	"de": {
+		"username.length":                        "Namen sind %d bis %d Zeichen lang",
+		"username.edges":                         "Namen müssen mit einem Buchstaben oder einer Ziffer beginnen und enden",
+		"username.scripts":                       "Namen dürfen keine Buchstaben verschiedener Schriften mischen",
+		"username.characters":                    "Namen dürfen nur Buchstaben und Ziffern enthalten",
+		"username.characters_punctuation":        "Namen dürfen nur Buchstaben, Ziffern und %s enthalten",
+		"username.script_characters":             "Namen dürfen nur Buchstaben und Ziffern einer Schrift enthalten",
+		"username.script_characters_punctuation": "Namen dürfen nur Buchstaben und Ziffern einer Schrift und %s enthalten",
+		"username.reserved":                      "Dieser Name ist reserviert",
+		"username.unavailable":                   "Dieser Name ist nicht verfügbar",
+		"username.check_failed":                  "Die Verfügbarkeit des Namens konnte nicht geprüft werden",
+		"locale.unsupported":                     "%s ist keine unterstützte Sprache",
+
+		"consent.prompt":                   "%s möchte auf dein Konto zugreifen",
+		"consent.scope.openid":             "Deine Identität bestätigen",
+		"consent.scope.profile":            "Deinen Benutzernamen, Anzeigenamen und dein Profil sehen",
+		"consent.scope.email":              "Deine E-Mail-Adresse sehen",
+		"consent.scope.read":               "Deine Werke, Lesezeichen und Sammlungen lesen",
+		"consent.scope.write":              "Inhalte in deinem Namen erstellen und bearbeiten",
+		"consent.scope.works:manage":       "Deine Werke veröffentlichen, bearbeiten und löschen",
+		"consent.scope.comments:write":     "Kommentare in deinem Namen schreiben",
+		"consent.scope.bookmarks:manage":   "Deine Lesezeichen anlegen und löschen",
+		"consent.scope.collections:manage": "Deine Sammlungen verwalten",
+
+		"notification.inactivity_warning":          "Wir haben dich länger nicht gesehen. Melde dich an, damit dein Konto aktiv bleibt.",
+		"notification.account_locked":              "Dein Konto wurde nach langer Inaktivität gesperrt.",
+		"notification.password_reset_required":     "Dein Konto ist in einen neuen Dienst umgezogen. Wähle ein neues Passwort, um dich anzumelden.",
+		"notification.new_device":                  "Ein neues Gerät hat sich bei deinem Konto angemeldet.",
+		"notification.recovery_contact_invited":    "Du wurdest gebeten, Vertrauenskontakt für eine Kontowiederherstellung zu sein.",
+		"notification.recovery_contacts_changed":   "Deine Vertrauenskontakte für die Kontowiederherstellung haben sich geändert.",
+		"notification.recovery_approval_requested": "Jemand, der dir vertraut, stellt sein Konto wieder her und braucht deine Zustimmung.",
+		"notification.recovery_requested":          "Über deine Vertrauenskontakte wurde eine Wiederherstellung deines Kontos angefordert.",
+		"notification.recovery_failed":             "Eine Wiederherstellungsanfrage ist ohne genügend Zustimmungen abgelaufen.",
+		"notification.recovery_completed":          "Dein Konto wurde über deine Vertrauenskontakte wiederhergestellt.",
+		"notification.recovery_approved":           "Deine Vertrauenskontakte haben die Wiederherstellung deines Kontos genehmigt.",
+		"notification.recovery_denied":             "Ein Vertrauenskontakt hat die Wiederherstellung deines Kontos abgelehnt.",
+		"notification.recovery_cancelled":          "Eine Anfrage zur Kontowiederherstellung wurde abgebrochen.",
+	},
+}
+
+// matchLocale returns the supported locale that best matches the first candidate naming one.
+// Candidates are a stored preference or an Accept-Language header; empty ones are skipped.
+func matchLocale(candidates ...string) string {
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		tags, _, err := language.ParseAcceptLanguage(candidate)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+		_, index, confidence := localeMatcher.Match(tags...)
+		if confidence != language.No {
+			return supportedLocales[index].Tag
+		}
+	}
+	return defaultLocale
+}
+
+// supportedLocale reports whether a tag names a locale with a catalog, returning its canonical form
+func supportedLocale(tag string) (string, bool) {
+	for _, locale := range supportedLocales {
+		if strings.EqualFold(locale.Tag, tag) {
+			return locale.Tag, true
+		}
+	}
+	return "", false
+}
+
+// lookupMessage finds a message in a locale's catalog, falling back to English
+func lookupMessage(locale, key string) (string, bool) {
+	if message, ok := messageCatalogs[locale][key]; ok {
+		return message, true
+	}
+	message, ok := messageCatalogs[defaultLocale][key]
+	return message, ok
+}
+
+// translate formats a catalog message in a locale; unknown keys are returned as they are
+func translate(locale, key string, args ...interface{}) string {
+	message, ok := lookupMessage(locale, key)
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// userLocale returns a user's preferred locale, or "" when they have not chosen one
+func (as *AuthService) userLocale(ctx context.Context, userID uuid.UUID) string {
+	if as.db == nil {
+		return ""
+	}
+	var preferred sql.NullString
+	if err := as.db.QueryRowContext(ctx, `SELECT preferred_locale FROM user_preferences WHERE user_id = $1`, userID).Scan(&preferred); err != nil {
+		return ""
+	}
+	return preferred.String
+}
+
+// localeFor picks the locale of a response: the user's preference when there is a user,
+// then the request's Accept-Language. It sets Content-Language to match.
+func (as *AuthService) localeFor(c *gin.Context, userID *uuid.UUID) string {
+	preferred := ""
+	if userID != nil {
+		preferred = as.userLocale(c.Request.Context(), *userID)
+	}
+	locale := matchLocale(preferred, c.GetHeader("Accept-Language"))
+	c.Header("Content-Language", locale)
+	return locale
+}
+
+// requestLocale is localeFor with the authenticated user, if any
+func (as *AuthService) requestLocale(c *gin.Context) string {
+	if value, ok := c.Get("user_id"); ok {
+		if userID, ok := value.(uuid.UUID); ok {
+			return as.localeFor(c, &userID)
+		}
+	}
+	return as.localeFor(c, nil)
+}
+
+// ListLocales lists the supported locales and the one Accept-Language selects
+func (as *AuthService) ListLocales(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"default": defaultLocale,
+		"matched": as.localeFor(c, nil),
+		"locales": supportedLocales,
+	})
+}
+
+// GetLocale returns the user's preferred locale, null when unset, and the one in effect
+func (as *AuthService) GetLocale(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	preferred := as.userLocale(c.Request.Context(), userID)
+	c.JSON(http.StatusOK, gin.H{"preferred_locale": nullableLocale(preferred), "locale": as.localeFor(c, &userID)})
+}
+
+// SetLocale stores the user's preferred locale; null or "" clears it, leaving Accept-Language to decide
+func (as *AuthService) SetLocale(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	var req struct {
+		PreferredLocale *string `json:"preferred_locale"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "preferred_locale is required"})
+		return
+	}
+
+	preferred := ""
+	if req.PreferredLocale != nil && *req.PreferredLocale != "" {
+		tag, ok := supportedLocale(*req.PreferredLocale)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":             "unsupported_locale",
+				"error_description": translate(as.requestLocale(c), "locale.unsupported", *req.PreferredLocale),
+				"locales":           supportedLocales,
+			})
+			return
+		}
+		preferred = tag
+	}
+
+	_, err := as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO user_preferences (user_id, preferred_locale, created_at, updated_at)
+		VALUES ($1, NULLIF($2, ''), NOW(), NOW())
+		ON CONFLICT (user_id) DO UPDATE SET preferred_locale = NULLIF($2, ''), updated_at = NOW()`, userID, preferred)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to update locale preference"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"preferred_locale": nullableLocale(preferred), "locale": as.localeFor(c, &userID)})
+}
+
+func nullableLocale(locale string) interface{} {
+	if locale == "" {
+		return nil
+	}
+	return locale
+}
+
+// localizedNotifier adds the recipient's locale and a message from the catalog in it to
+// every notification, so the operator's mailer can render it in the user's language
+type localizedNotifier struct {
+	Notifier
+	as *AuthService
+}
+
+// localizeNotifications wraps a notifier so its notifications carry the recipient's locale
+func (as *AuthService) localizeNotifications(notifier Notifier) Notifier {
+	return &localizedNotifier{Notifier: notifier, as: as}
+}
+
+func (n *localizedNotifier) Notify(ctx context.Context, notification Notification) error {
+	notification.Locale = matchLocale(n.as.userLocale(ctx, notification.UserID))
+	if message, ok := lookupMessage(notification.Locale, "notification."+notification.Type); ok {
+		notification.Message = message
+	}
+	return n.Notifier.Notify(ctx, notification)
+}
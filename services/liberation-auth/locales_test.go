@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type LocalesTestSuite struct {
+	suite.Suite
+}
+
+func (suite *LocalesTestSuite) TestMatchLocale() {
+	suite.Equal("fr", matchLocale("", "fr-CA,fr;q=0.9,en;q=0.5"))
+	suite.Equal("de", matchLocale("de", "es"))
+	suite.Equal("es", matchLocale("not a locale", "es-MX"))
+	suite.Equal("en", matchLocale("ja"))
+	suite.Equal("en", matchLocale())
+}
+
+func (suite *LocalesTestSuite) TestSupportedLocale() {
+	tag, ok := supportedLocale("DE")
+	suite.True(ok)
+	suite.Equal("de", tag)
+
+	_, ok = supportedLocale("pt-BR")
+	suite.False(ok)
+}
+
+func (suite *LocalesTestSuite) TestCatalogsCoverEnglish() {
+	for _, locale := range supportedLocales {
+		for key := range messageCatalogs[locale.Tag] {
+			_, ok := messageCatalogs[defaultLocale][key]
+			if !ok && strings.HasPrefix(key, "consent.scope.") {
+				continue
+			}
+			suite.Truef(ok, "%s has %s, which English lacks", locale.Tag, key)
+		}
+	}
+}
+
+func (suite *LocalesTestSuite) TestTranslate() {
+	suite.Equal("Los nombres tienen entre 3 y 40 caracteres", translate("es", "username.length", 3, 40))
+	suite.Equal("Names are 3-40 characters long", translate("ja", "username.length", 3, 40))
+	suite.Equal("no.such.message", translate("fr", "no.such.message"))
+
+	_, ok := lookupMessage("en", "consent.scope.email")
+	suite.False(ok, "English scope descriptions come from the scope definitions")
+}
+
+func (suite *LocalesTestSuite) TestUsernameErrorsFollowAcceptLanguage() {
+	gin.SetMode(gin.TestMode)
+	as := &AuthService{}
+	err := newUsernameError("username_reserved", "username.reserved")
+	suite.Equal("This name is reserved", err.Error())
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/register", nil)
+	c.Request.Header.Set("Accept-Language", "de-AT, en;q=0.5")
+	as.rejectUsername(c, err)
+
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	suite.Equal("de", recorder.Header().Get("Content-Language"))
+	var body map[string]string
+	suite.Require().NoError(json.Unmarshal(recorder.Body.Bytes(), &body))
+	suite.Equal("username_reserved", body["error"])
+	suite.Equal("Dieser Name ist reserviert", body["error_description"])
+}
+
+type capturingNotifier struct {
+	notifications []Notification
+}
+
+func (n *capturingNotifier) Name() string { return "capture" }
+
+func (n *capturingNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+func (suite *LocalesTestSuite) TestNotificationsCarryLocaleAndMessage() {
+	captured := &capturingNotifier{}
+	notifier := (&AuthService{}).localizeNotifications(captured)
+	suite.Equal("capture", notifier.Name())
+
+	suite.Require().NoError(notifier.Notify(context.Background(), Notification{Type: "account_locked", UserID: uuid.New()}))
+	suite.Require().NoError(notifier.Notify(context.Background(), Notification{Type: "custom", UserID: uuid.New()}))
+
+	suite.Require().Len(captured.notifications, 2)
+	suite.Equal("en", captured.notifications[0].Locale)
+	suite.Equal("Your account was locked after a long period of inactivity.", captured.notifications[0].Message)
+	suite.Equal("en", captured.notifications[1].Locale)
+	suite.Empty(captured.notifications[1].Message)
+}
+
+func TestLocalesTestSuite(t *testing.T) {
+	suite.Run(t, new(LocalesTestSuite))
+}
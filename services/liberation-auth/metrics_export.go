@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"liberation-anonymize"
+
+	"github.com/gin-gonic/gin"
+)
+
+// securityEventExportLimit caps the rows of one security event export
+const securityEventExportLimit = 100000
+
+// DefaultAnalyticsExportConfig reads ANALYTICS_EXPORT_K, ANALYTICS_EXPORT_EPSILON and
+// ANALYTICS_EXPORT_NOISE_BELOW, which apply to exports requested with anonymize=true
+func DefaultAnalyticsExportConfig() (anonymize.Config, error) {
+	config := anonymize.DefaultConfig()
+	config.ApplyEnv("ANALYTICS_EXPORT_")
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("ANALYTICS_EXPORT_*: %w", err)
+	}
+	return config, nil
+}
+
+// AuthMetricsDay counts one type of security event on one UTC day
+type AuthMetricsDay struct {
+	Day       string `json:"day"`
+	EventType string `json:"event_type"`
+	Events    int    `json:"events"`
+	Users     int    `json:"users"`
+}
+
+// queryAuthMetrics counts security events per day and type between from and to, inclusive
+func (as *AuthService) queryAuthMetrics(ctx context.Context, from, to time.Time) ([]AuthMetricsDay, error) {
+	rows, err := as.db.QueryContext(ctx, `
+		SELECT date_trunc('day', created_at), event_type, COUNT(*), COUNT(DISTINCT user_id)
+		FROM security_events
+		WHERE created_at >= $1 AND created_at < $2
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := []AuthMetricsDay{}
+	for rows.Next() {
+		var day time.Time
+		var m AuthMetricsDay
+		if err := rows.Scan(&day, &m.EventType, &m.Events, &m.Users); err != nil {
+			return nil, err
+		}
+		m.Day = day.UTC().Format(clientUsageDay)
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// anonymizeAuthMetrics drops the days and types shared by fewer than k users and noises the
+// remaining counts
+func anonymizeAuthMetrics(export *anonymize.Export, metrics []AuthMetricsDay) []AuthMetricsDay {
+	kept := []AuthMetricsDay{}
+	for _, m := range metrics {
+		if export.Suppress(m.Users) {
+			continue
+		}
+		m.Events = export.Count(m.Events)
+		m.Users = export.Count(m.Users)
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+// newAnalyticsExport starts an anonymized export when ?anonymize=true, or returns nil
+func (as *AuthService) newAnalyticsExport(c *gin.Context) (*anonymize.Export, error) {
+	if c.Query("anonymize") != "true" {
+		return nil, nil
+	}
+	export, err := anonymize.New(as.analyticsExport)
+	if err != nil {
+		return nil, err
+	}
+	c.Header("X-Export-ID", export.ID())
+	return export, nil
+}
+
+// GetAuthMetrics counts security events per UTC day and type for a date range. With
+// anonymize=true, rows of fewer than k users are left out and small counts are noised.
+// ?format=csv, or Accept: text/csv, downloads the rows as CSV.
+func (as *AuthService) GetAuthMetrics(c *gin.Context) {
+	from, to, err := parseUsageRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	export, err := as.newAnalyticsExport(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export"})
+		return
+	}
+
+	metrics, err := as.queryAuthMetrics(c.Request.Context(), from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch metrics"})
+		return
+	}
+	if export != nil {
+		metrics = anonymizeAuthMetrics(export, metrics)
+	}
+
+	if c.Query("format") == "csv" || strings.Contains(c.GetHeader("Accept"), "text/csv") {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="auth-metrics-%s-%s.csv"`,
+			from.Format(clientUsageDay), to.Format(clientUsageDay)))
+		c.Status(http.StatusOK)
+		out := csv.NewWriter(c.Writer)
+		out.Write([]string{"day", "event_type", "events", "users"})
+		for _, m := range metrics {
+			out.Write([]string{m.Day, m.EventType, strconv.Itoa(m.Events), strconv.Itoa(m.Users)})
+		}
+		out.Flush()
+		return
+	}
+
+	response := gin.H{
+		"from": from.Format(clientUsageDay),
+		"to":   to.Format(clientUsageDay),
+		"days": metrics,
+	}
+	if export != nil {
+		response["anonymization"] = export.Manifest()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// exportedSecurityEvent is one row of a security event export. Anonymized rows carry a
+// pseudonym instead of the user ID, no event ID, address, user agent or details, and the
+// hour instead of the exact time.
+type exportedSecurityEvent struct {
+	ID        string                 `json:"id,omitempty"`
+	UserID    string                 `json:"user_id,omitempty"`
+	EventType string                 `json:"event_type"`
+	IPAddress string                 `json:"ip_address,omitempty"`
+	UserAgent string                 `json:"user_agent,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// exportSecurityEvent prepares an event for export, anonymizing it when export is set
+func exportSecurityEvent(export *anonymize.Export, event securityEvent) exportedSecurityEvent {
+	var userID string
+	if event.UserID != nil {
+		userID = event.UserID.String()
+	}
+	if export != nil {
+		return exportedSecurityEvent{
+			UserID:    export.Pseudonym(userID),
+			EventType: event.Type,
+			CreatedAt: event.CreatedAt.UTC().Truncate(time.Hour),
+		}
+	}
+	return exportedSecurityEvent{
+		ID:        event.ID.String(),
+		UserID:    userID,
+		EventType: event.Type,
+		IPAddress: event.IPAddress,
+		UserAgent: event.UserAgent,
+		Details:   event.Details,
+		CreatedAt: event.CreatedAt.UTC(),
+	}
+}
+
+// AdminExportSecurityEvents streams security events between from and to as JSON lines, or
+// CSV with ?format=csv, oldest first and capped at securityEventExportLimit rows. With
+// anonymize=true, events of a day and type shared by fewer than k users are left out.
+func (as *AuthService) AdminExportSecurityEvents(c *gin.Context) {
+	from, to, err := parseUsageRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "jsonl")
+	if format != "jsonl" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "format must be jsonl or csv"})
+		return
+	}
+	export, err := as.newAnalyticsExport(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export"})
+		return
+	}
+
+	// Each event carries the distinct users of its day and type, for k-anonymity
+	rows, err := as.db.QueryContext(c.Request.Context(), `
+		SELECT e.id, e.user_id, e.event_type, e.ip_address, e.user_agent, e.details, e.created_at, g.users
+		FROM security_events e
+		JOIN (
+			SELECT date_trunc('day', created_at) AS day, event_type, COUNT(DISTINCT user_id) AS users
+			FROM security_events
+			WHERE created_at >= $1 AND created_at < $2
+			GROUP BY 1, 2
+		) g ON g.day = date_trunc('day', e.created_at) AND g.event_type = e.event_type
+		WHERE e.created_at >= $1 AND e.created_at < $2
+		ORDER BY e.created_at, e.id
+		LIMIT $3`, from, to.AddDate(0, 0, 1), securityEventExportLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export security events"})
+		return
+	}
+	defer rows.Close()
+
+	name := fmt.Sprintf("security-events-%s-%s", from.Format(clientUsageDay), to.Format(clientUsageDay))
+	if export != nil {
+		name = "security-events-" + export.ID()
+	}
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Status(http.StatusOK)
+
+	write, flush := securityEventWriter(c.Writer, format)
+	defer flush()
+	suppressed := map[string]bool{}
+	for rows.Next() {
+		var event securityEvent
+		var details []byte
+		var users int
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.IPAddress, &event.UserAgent, &details, &event.CreatedAt, &users); err != nil {
+			// Headers are already sent; a truncated file is the only signal left
+			return
+		}
+		if export != nil {
+			// Suppression is counted once per group, not per event
+			group := event.CreatedAt.UTC().Format(clientUsageDay) + "\x00" + event.Type
+			if hidden, seen := suppressed[group]; seen {
+				if hidden {
+					continue
+				}
+			} else if suppressed[group] = export.Suppress(users); suppressed[group] {
+				continue
+			}
+		} else {
+			json.Unmarshal(details, &event.Details)
+		}
+		write(exportSecurityEvent(export, event))
+	}
+}
+
+// securityEventWriter returns functions that write one event in the given format and flush
+// what has been written
+func securityEventWriter(w io.Writer, format string) (func(exportedSecurityEvent), func()) {
+	if format == "jsonl" {
+		encoder := json.NewEncoder(w)
+		return func(event exportedSecurityEvent) { encoder.Encode(event) }, func() {}
+	}
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "user_id", "event_type", "ip_address", "user_agent", "created_at"})
+	return func(event exportedSecurityEvent) {
+		out.Write([]string{event.ID, event.UserID, event.EventType, event.IPAddress, event.UserAgent, event.CreatedAt.Format(time.RFC3339)})
+	}, out.Flush
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"liberation-anonymize"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsExportTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MetricsExportTestSuite) TestConfigFromEnv() {
+	suite.T().Setenv("ANALYTICS_EXPORT_K", "10")
+	suite.T().Setenv("ANALYTICS_EXPORT_EPSILON", "0.5")
+	config, err := DefaultAnalyticsExportConfig()
+	suite.Require().NoError(err)
+	suite.Equal(anonymize.Config{K: 10, Epsilon: 0.5, NoiseBelow: 100}, config)
+
+	suite.T().Setenv("ANALYTICS_EXPORT_K", "1")
+	_, err = DefaultAnalyticsExportConfig()
+	suite.Error(err, "k of 1 identifies users")
+}
+
+func (suite *MetricsExportTestSuite) TestMetricsBelowKAreSuppressed() {
+	export, err := anonymize.New(anonymize.Config{K: 3})
+	suite.Require().NoError(err)
+
+	metrics := anonymizeAuthMetrics(export, []AuthMetricsDay{
+		{Day: "2024-06-01", EventType: "login_success", Events: 40, Users: 12},
+		{Day: "2024-06-01", EventType: "password_reset", Events: 2, Users: 2},
+		{Day: "2024-06-02", EventType: "login_success", Events: 30, Users: 3},
+	})
+	suite.Equal([]AuthMetricsDay{
+		{Day: "2024-06-01", EventType: "login_success", Events: 40, Users: 12},
+		{Day: "2024-06-02", EventType: "login_success", Events: 30, Users: 3},
+	}, metrics, "epsilon 0 keeps counts exact")
+	suite.Equal(1, export.Manifest().Suppressed)
+}
+
+func (suite *MetricsExportTestSuite) TestAnonymizedEventsCarryNoIdentifiers() {
+	userID := uuid.New()
+	event := securityEvent{
+		ID:        uuid.New(),
+		UserID:    &userID,
+		Type:      "login_failure",
+		IPAddress: "203.0.113.7",
+		UserAgent: "curl/8.0",
+		Details:   map[string]interface{}{"email": "reader@example.com"},
+		CreatedAt: time.Date(2024, 6, 1, 14, 37, 12, 0, time.UTC),
+	}
+
+	plain := exportSecurityEvent(nil, event)
+	suite.Equal(userID.String(), plain.UserID)
+	suite.Equal("203.0.113.7", plain.IPAddress)
+
+	first, err := anonymize.New(anonymize.DefaultConfig())
+	suite.Require().NoError(err)
+	second, err := anonymize.New(anonymize.DefaultConfig())
+	suite.Require().NoError(err)
+
+	exported := exportSecurityEvent(first, event)
+	suite.Equal(exportedSecurityEvent{
+		UserID:    first.Pseudonym(userID.String()),
+		EventType: "login_failure",
+		CreatedAt: time.Date(2024, 6, 1, 14, 0, 0, 0, time.UTC),
+	}, exported)
+	_, err = uuid.Parse(exported.UserID)
+	suite.Error(err, "pseudonyms are not user IDs")
+	suite.NotEqual(exported.UserID, exportSecurityEvent(second, event).UserID, "pseudonyms differ between exports")
+
+	event.UserID = nil
+	suite.Empty(exportSecurityEvent(first, event).UserID)
+}
+
+func (suite *MetricsExportTestSuite) TestInvalidRequestsAreRejectedBeforeQuerying() {
+	gin.SetMode(gin.TestMode)
+	as := &AuthService{analyticsExport: anonymize.DefaultConfig()}
+	router := gin.New()
+	router.GET("/admin/metrics", as.GetAuthMetrics)
+	router.GET("/admin/security-events/export", as.AdminExportSecurityEvents)
+
+	for _, target := range []string{
+		"/admin/metrics?from=2024-06-10&to=2024-06-01",
+		"/admin/security-events/export?from=June",
+		"/admin/security-events/export?format=xml",
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		suite.Equal(http.StatusBadRequest, recorder.Code, target)
+		suite.Empty(recorder.Header().Get("X-Export-ID"), target)
+	}
+}
+
+func TestMetricsExportTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsExportTestSuite))
+}
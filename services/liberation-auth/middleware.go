@@ -40,6 +40,8 @@ type ClientRateLimitInfo struct {
 	IsAdmin      bool          `json:"is_admin"`
 	Scopes       []string      `json:"scopes"`
 	UserID       string        `json:"user_id,omitempty"`
+	// scopes is Scopes as a bitmap; ExtractOAuthInfo fills it once per request
+	scopes scopeSet
 }
 
 type RateLimitHeaders struct {
@@ -91,7 +93,10 @@ func GetDefaultRateLimitConfigs() map[RateLimitTier]RateLimitConfig {
 }
 
 func (info *ClientRateLimitInfo) DetermineRateLimitTier() RateLimitTier {
-	if info.IsAdmin || containsScope(info.Scopes, "admin") || containsScope(info.Scopes, "tags:wrangle") {
+	if info.scopes.registry == nil && len(info.Scopes) > 0 {
+		info.scopes = scopeBits.set(info.Scopes)
+	}
+	if info.IsAdmin || info.scopes.hasAny("admin", "tags:wrangle") {
 		return RateLimitTierAdmin
 	}
 	if info.IsFirstParty {
@@ -112,15 +117,6 @@ func (info *ClientRateLimitInfo) GetRateLimitConfig() RateLimitConfig {
 	return configs[tier]
 }
 
-func containsScope(scopes []string, target string) bool {
-	for _, scope := range scopes {
-		if scope == target {
-			return true
-		}
-	}
-	return false
-}
-
 func (h *RateLimitHeaders) ToHeaders() map[string]string {
 	return map[string]string{
 		"X-RateLimit-Limit":     fmt.Sprintf("%d", h.Limit),
@@ -130,6 +126,21 @@ func (h *RateLimitHeaders) ToHeaders() map[string]string {
 	}
 }
 
+// RetryAfter is the whole seconds left until the window resets, at least one
+func (h *RateLimitHeaders) RetryAfter() int {
+	return max(int(time.Until(time.Unix(h.Reset, 0)).Seconds()+0.999), 1)
+}
+
+// apply writes the X-RateLimit headers, and Retry-After when the request was refused
+func (h *RateLimitHeaders) apply(c *gin.Context, refused bool) {
+	for key, value := range h.ToHeaders() {
+		c.Header(key, value)
+	}
+	if refused {
+		c.Header("Retry-After", strconv.Itoa(h.RetryAfter()))
+	}
+}
+
 func ExtractOAuthInfo(r *http.Request) *ClientRateLimitInfo {
 	info := &ClientRateLimitInfo{
 		Tier: RateLimitTierAnonymous,
@@ -159,6 +170,7 @@ func ExtractOAuthInfo(r *http.Request) *ClientRateLimitInfo {
 
 	if scopes := r.Header.Get("X-OAuth-Scopes"); scopes != "" {
 		info.Scopes = strings.Split(scopes, ",")
+		info.scopes = scopeBits.set(info.Scopes)
 	}
 
 	if isFirstParty := r.Header.Get("X-Client-First-Party"); isFirstParty == "true" {
@@ -195,19 +207,41 @@ func GetClientIP(r *http.Request) string {
 }
 
 func (rlm *RateLimitManager) CheckRateLimit(clientInfo *ClientRateLimitInfo, clientIP string) (*RateLimitHeaders, error) {
-	config := clientInfo.GetRateLimitConfig()
+	key, config := rlm.rateLimitKey(clientInfo, clientIP)
+	return rlm.checkLimitWithConfig(key, config)
+}
 
-	var key string
+// rateLimitKey returns the counter and limits of the caller's tier
+func (rlm *RateLimitManager) rateLimitKey(clientInfo *ClientRateLimitInfo, clientIP string) (string, RateLimitConfig) {
+	config := clientInfo.GetRateLimitConfig()
 	if clientInfo.DetermineRateLimitTier() == RateLimitTierAnonymous {
-		key = fmt.Sprintf("rate_limit:%s:%s:%s", rlm.serviceName, string(config.Tier), clientIP)
-	} else {
-		key = fmt.Sprintf("rate_limit:%s:%s:%s", rlm.serviceName, string(config.Tier), clientInfo.ClientID)
+		return fmt.Sprintf("rate_limit:%s:%s:%s", rlm.serviceName, string(config.Tier), clientIP), config
 	}
+	return fmt.Sprintf("rate_limit:%s:%s:%s", rlm.serviceName, string(config.Tier), clientInfo.ClientID), config
+}
 
-	return rlm.checkLimitWithConfig(key, config)
+// peekLimit reports what is left of key's window without charging a request
+func (rlm *RateLimitManager) peekLimit(ctx context.Context, key string, config RateLimitConfig) *RateLimitHeaders {
+	windowEnd := time.Now().Truncate(config.Window).Add(config.Window)
+	used, err := rlm.redisClient.Get(ctx, key).Int()
+	if err != nil && err != redis.Nil {
+		log.Printf("Redis error reading rate limit: %v", err)
+	}
+	return &RateLimitHeaders{
+		Limit:     config.Requests,
+		Remaining: max(config.Requests-used, 0),
+		Reset:     windowEnd.Unix(),
+		Tier:      string(config.Tier),
+	}
 }
 
 func (rlm *RateLimitManager) checkLimitWithConfig(key string, config RateLimitConfig) (*RateLimitHeaders, error) {
+	return rlm.checkLimitWithCost(key, config, 1)
+}
+
+// checkLimitWithCost charges cost requests against key, refusing all of them when they do
+// not fit in what is left of the window
+func (rlm *RateLimitManager) checkLimitWithCost(key string, config RateLimitConfig, cost int) (*RateLimitHeaders, error) {
 	ctx := context.Background()
 	now := time.Now()
 	windowStart := now.Truncate(config.Window)
@@ -220,7 +254,7 @@ func (rlm *RateLimitManager) checkLimitWithConfig(key string, config RateLimitCo
 		log.Printf("Redis error in rate limiting: %v", err)
 		return &RateLimitHeaders{
 			Limit:     config.Requests,
-			Remaining: config.Requests - 1,
+			Remaining: config.Requests - cost,
 			Reset:     windowEnd.Unix(),
 			Tier:      string(config.Tier),
 		}, nil
@@ -233,17 +267,17 @@ func (rlm *RateLimitManager) checkLimitWithConfig(key string, config RateLimitCo
 		}
 	}
 
-	if currentCount >= config.Requests {
+	if currentCount+cost > config.Requests {
 		return &RateLimitHeaders{
 			Limit:     config.Requests,
-			Remaining: 0,
+			Remaining: max(config.Requests-currentCount, 0),
 			Reset:     windowEnd.Unix(),
 			Tier:      string(config.Tier),
 		}, fmt.Errorf("rate limit exceeded")
 	}
 
 	pipe = rlm.redisClient.Pipeline()
-	pipe.Incr(ctx, key)
+	pipe.IncrBy(ctx, key, int64(cost))
 	pipe.Expire(ctx, key, config.Window)
 	_, err = pipe.Exec(ctx)
 	if err != nil {
@@ -252,7 +286,7 @@ func (rlm *RateLimitManager) checkLimitWithConfig(key string, config RateLimitCo
 
 	return &RateLimitHeaders{
 		Limit:     config.Requests,
-		Remaining: config.Requests - currentCount - 1,
+		Remaining: config.Requests - currentCount - cost,
 		Reset:     windowEnd.Unix(),
 		Tier:      string(config.Tier),
 	}, nil
@@ -261,6 +295,12 @@ func (rlm *RateLimitManager) checkLimitWithConfig(key string, config RateLimitCo
 // JWTAuthMiddleware validates JWT tokens
 func JWTAuthMiddleware(authService *AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// ServiceIdentityMiddleware has already authenticated a calling service
+		if isServiceCall(c) {
+			c.Next()
+			return
+		}
+
 		// Check for test user header (for testing only)
 		if testUserID := c.GetHeader("X-Test-User-ID"); testUserID != "" && gin.Mode() == gin.TestMode {
 			if userID, err := uuid.Parse(testUserID); err == nil {
@@ -272,6 +312,7 @@ func JWTAuthMiddleware(authService *AuthService) gin.HandlerFunc {
 
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
+			setBearerChallenge(c, "", "", "")
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error":             "missing_authorization_header",
 				"error_description": "Authorization header is required",
@@ -282,31 +323,13 @@ func JWTAuthMiddleware(authService *AuthService) gin.HandlerFunc {
 
 		tokenString := extractBearerToken(authHeader)
 		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":             "invalid_authorization_header",
-				"error_description": "Bearer token required",
-			})
-			c.Abort()
-			return
-		}
-
-		claims, err := authService.jwt.ValidateToken(tokenString)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":             "invalid_token",
-				"error_description": "Token validation failed",
-			})
-			c.Abort()
+			abortWithBearerError(c, http.StatusBadRequest, bearerErrorInvalidRequest, "Bearer token required", "")
 			return
 		}
 
-		userID, err := uuid.Parse(claims.Subject)
+		claims, userID, err := authService.validateUserJWT(c.Request.Context(), tokenString, authService.audience.firstParty())
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":             "invalid_token",
-				"error_description": "Invalid user ID in token",
-			})
-			c.Abort()
+			abortWithJWTError(c, err)
 			return
 		}
 
@@ -319,8 +342,15 @@ func JWTAuthMiddleware(authService *AuthService) gin.HandlerFunc {
 // RequireRoleMiddleware checks if user has required role
 func RequireRoleMiddleware(requiredRole string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Trusted services act as admins; which services are trusted is deployment configuration
+		if isServiceCall(c) {
+			c.Next()
+			return
+		}
+
 		_, exists := c.Get("user_id")
 		if !exists {
+			setBearerChallenge(c, "", "", "")
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error":             "unauthorized",
 				"error_description": "User authentication required",
@@ -368,9 +398,7 @@ func RateLimitMiddleware(redis *redis.Client) gin.HandlerFunc {
 		headers, err := rateLimitManager.CheckRateLimit(clientInfo, clientIP)
 		if err != nil {
 			// Add rate limit headers even on error
-			for key, value := range headers.ToHeaders() {
-				c.Header(key, value)
-			}
+			headers.apply(c, true)
 
 			// Return 429 Too Many Requests with OAuth-aware messaging
 			c.JSON(http.StatusTooManyRequests, gin.H{
@@ -379,15 +407,14 @@ func RateLimitMiddleware(redis *redis.Client) gin.HandlerFunc {
 				"limit":             headers.Limit,
 				"reset":             headers.Reset,
 				"tier":              headers.Tier,
+				"retry_after":       headers.RetryAfter(),
 			})
 			c.Abort()
 			return
 		}
 
 		// Add rate limit headers to response
-		for key, value := range headers.ToHeaders() {
-			c.Header(key, value)
-		}
+		headers.apply(c, false)
 
 		c.Next()
 	}
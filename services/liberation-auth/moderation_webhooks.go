@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Moderation actions an external decision can be mapped to
+const (
+	moderationSuspend          = "suspend"
+	moderationUnsuspend        = "unsuspend"
+	moderationRevokeTokens     = "revoke_tokens"
+	moderationRestrictScopes   = "restrict_scopes"
+	moderationUnrestrictScopes = "unrestrict_scopes"
+)
+
+// Outcomes recorded in moderation_events
+const (
+	moderationApplied = "applied"
+	moderationIgnored = "ignored"
+)
+
+// moderationMaxBody bounds webhook payloads; decisions are small
+const moderationMaxBody = 64 << 10
+
+var moderationEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_moderation_events_total",
+	Help: "Moderation webhook deliveries, by outcome (applied, ignored, duplicate, rejected, failed).",
+}, []string{"outcome"})
+
+// ModerationStep is one internal action an external decision maps to
+type ModerationStep struct {
+	Action string `json:"action"`
+	// Scopes for restrict_scopes and unrestrict_scopes; empty takes them from the event
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// ModerationConfig controls the inbound webhook for trust & safety decisions
+type ModerationConfig struct {
+	// Secret signs deliveries; the webhook is disabled without one
+	Secret string
+	// Actions maps the sender's action names to what they do here
+	Actions map[string][]ModerationStep
+}
+
+// DefaultModerationConfig reads MODERATION_WEBHOOK_SECRET and MODERATION_ACTIONS
+func DefaultModerationConfig() (ModerationConfig, error) {
+	config := ModerationConfig{Secret: getEnv("MODERATION_WEBHOOK_SECRET", "")}
+	if config.Secret != "" && len(config.Secret) < 32 {
+		return config, fmt.Errorf("MODERATION_WEBHOOK_SECRET must be at least 32 characters")
+	}
+	var err error
+	config.Actions, err = parseModerationActions(getEnv("MODERATION_ACTIONS",
+		"ban=suspend+revoke_tokens,unban=unsuspend,mute=restrict_scopes:write,unmute=unrestrict_scopes"))
+	if err != nil {
+		return config, fmt.Errorf("MODERATION_ACTIONS: %w", err)
+	}
+	return config, nil
+}
+
+// parseModerationActions reads "ban=suspend+revoke_tokens,mute=restrict_scopes:write works:manage":
+// each external action maps to one or more steps joined by +, and the scope steps may name
+// the scopes they apply to, separated by spaces
+func parseModerationActions(value string) (map[string][]ModerationStep, error) {
+	actions := map[string][]ModerationStep{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, steps, ok := strings.Cut(entry, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return nil, fmt.Errorf("%q must look like name=action[+action]", entry)
+		}
+		if _, duplicate := actions[name]; duplicate {
+			return nil, fmt.Errorf("%s is listed twice", name)
+		}
+		for _, step := range strings.Split(steps, "+") {
+			action, scopes, _ := strings.Cut(strings.TrimSpace(step), ":")
+			switch action {
+			case moderationSuspend, moderationUnsuspend, moderationRevokeTokens:
+				if scopes != "" {
+					return nil, fmt.Errorf("%s takes no scopes", action)
+				}
+			case moderationRestrictScopes, moderationUnrestrictScopes:
+			default:
+				return nil, fmt.Errorf("unknown action %q; use suspend, unsuspend, revoke_tokens, restrict_scopes or unrestrict_scopes", action)
+			}
+			actions[name] = append(actions[name], ModerationStep{Action: action, Scopes: strings.Fields(scopes)})
+		}
+	}
+	return actions, nil
+}
+
+// moderationEvent is a decision delivered by the trust & safety tool
+type moderationEvent struct {
+	// EventID is the sender's ID for the decision; redeliveries reuse it
+	EventID  string     `json:"event_id"`
+	Action   string     `json:"action"`
+	UserID   *uuid.UUID `json:"user_id,omitempty"`
+	Username string     `json:"username,omitempty"`
+	Scopes   []string   `json:"scopes,omitempty"`
+	Reason   string     `json:"reason,omitempty"`
+	Source   string     `json:"source,omitempty"`
+}
+
+func (e moderationEvent) validate() error {
+	switch {
+	case e.EventID == "" || len(e.EventID) > 200:
+		return fmt.Errorf("event_id is required and at most 200 characters")
+	case e.Action == "":
+		return fmt.Errorf("action is required")
+	case e.UserID == nil && e.Username == "":
+		return fmt.Errorf("user_id or username is required")
+	}
+	return nil
+}
+
+// ModerationService applies moderation decisions from external systems
+type ModerationService struct {
+	as     *AuthService
+	config ModerationConfig
+}
+
+// NewModerationService returns nil when no webhook secret is configured
+func NewModerationService(as *AuthService, config ModerationConfig) *ModerationService {
+	if config.Secret == "" {
+		return nil
+	}
+	return &ModerationService{as: as, config: config}
+}
+
+// verifySignature checks X-Signature-256, sha256=<hex hmac of the body>, the same scheme
+// outbound notification webhooks are signed with
+func (s *ModerationService) verifySignature(body []byte, header string) bool {
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil || !strings.HasPrefix(header, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.config.Secret))
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// ReceiveModerationEvent applies a signed moderation decision. Each event_id is applied at
+// most once; redeliveries get the recorded outcome back. Decisions that cannot be applied
+// (an unmapped action or unknown user) are recorded as ignored rather than failed, so the
+// sender does not retry them forever.
+func (s *ModerationService) ReceiveModerationEvent(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, moderationMaxBody+1))
+	if err != nil || len(body) > moderationMaxBody {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "payload_too_large"})
+		return
+	}
+	if !s.verifySignature(body, c.GetHeader("X-Signature-256")) {
+		moderationEventsTotal.WithLabelValues("rejected").Inc()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_signature"})
+		return
+	}
+	var event moderationEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "Body must be a JSON moderation event"})
+		return
+	}
+	if err := event.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+
+	result, duplicate, err := s.apply(c.Request.Context(), event)
+	if err != nil {
+		moderationEventsTotal.WithLabelValues("failed").Inc()
+		log.Printf("Failed to apply moderation event %s: %v", event.EventID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply moderation event"})
+		return
+	}
+	if duplicate {
+		moderationEventsTotal.WithLabelValues("duplicate").Inc()
+		c.JSON(http.StatusOK, gin.H{"event": result, "duplicate": true})
+		return
+	}
+	moderationEventsTotal.WithLabelValues(result.Status).Inc()
+	if result.UserID != nil && result.Status == moderationApplied {
+		s.as.recordSecurityEvent(c, result.UserID, securityEventModerated, map[string]interface{}{
+			"event_id": event.EventID, "action": event.Action, "applied": result.Applied, "source": event.Source,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"event": result})
+}
+
+// moderationRecord is a row of moderation_events
+type moderationRecord struct {
+	EventID    string     `json:"event_id"`
+	Action     string     `json:"action"`
+	UserID     *uuid.UUID `json:"user_id,omitempty"`
+	Status     string     `json:"status"`
+	Applied    []string   `json:"applied"`
+	Detail     string     `json:"detail,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	ReceivedAt time.Time  `json:"received_at"`
+}
+
+// apply records and applies an event in one transaction, so a failure leaves nothing behind
+// and the sender's retry starts over. Concurrent deliveries of one event serialise on the
+// event_id primary key.
+func (s *ModerationService) apply(ctx context.Context, event moderationEvent) (moderationRecord, bool, error) {
+	record := moderationRecord{
+		EventID: event.EventID, Action: event.Action, Status: moderationApplied, Applied: []string{},
+		Reason: event.Reason, Source: event.Source, ReceivedAt: time.Now(),
+	}
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return record, false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO moderation_events (event_id, action, status, reason, source, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (event_id) DO NOTHING`,
+		event.EventID, event.Action, record.Status, event.Reason, event.Source, record.ReceivedAt)
+	if err != nil {
+		return record, false, err
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		tx.Rollback()
+		existing, err := s.event(ctx, event.EventID)
+		return existing, true, err
+	}
+
+	steps, mapped := s.config.Actions[event.Action]
+	userID, err := s.resolveUser(ctx, tx, event)
+	switch {
+	case err != nil:
+		return record, false, err
+	case !mapped:
+		record.Status, record.Detail = moderationIgnored, fmt.Sprintf("action %q is not mapped in MODERATION_ACTIONS", event.Action)
+	case userID == nil:
+		record.Status, record.Detail = moderationIgnored, "unknown user"
+	default:
+		record.UserID = userID
+		for _, step := range steps {
+			if err := s.applyStep(ctx, tx, *userID, step, event); err != nil {
+				return record, false, fmt.Errorf("%s: %w", step.Action, err)
+			}
+			record.Applied = append(record.Applied, step.Action)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE moderation_events SET user_id = $2, status = $3, applied = $4, detail = $5 WHERE event_id = $1`,
+		event.EventID, record.UserID, record.Status, pq.Array(record.Applied), record.Detail); err != nil {
+		return record, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return record, false, err
+	}
+
+	// Tokens were revoked in the transaction; drop them from every replica's cache too
+	if record.Status == moderationApplied {
+		for _, step := range steps {
+			if step.Action != moderationUnsuspend && step.Action != moderationUnrestrictScopes {
+				s.as.publishRevocation(RevocationEvent{Type: revocationUser, UserID: userID.String()})
+				break
+			}
+		}
+	}
+	return record, false, nil
+}
+
+// resolveUser finds the account an event refers to; nil when there is none
+func (s *ModerationService) resolveUser(ctx context.Context, tx *sql.Tx, event moderationEvent) (*uuid.UUID, error) {
+	var userID uuid.UUID
+	var err error
+	if event.UserID != nil {
+		err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1`, *event.UserID).Scan(&userID)
+	} else {
+		err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE LOWER(username) = LOWER($1)`, event.Username).Scan(&userID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &userID, nil
+}
+
+// applyStep carries out one action inside the event's transaction
+func (s *ModerationService) applyStep(ctx context.Context, tx *sql.Tx, userID uuid.UUID, step ModerationStep, event moderationEvent) error {
+	scopes := step.Scopes
+	if len(scopes) == 0 {
+		scopes = event.Scopes
+	}
+
+	switch step.Action {
+	case moderationSuspend:
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET is_active = false, updated_at = NOW() WHERE id = $1`, userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO user_moderation (user_id, suspended, last_event_id, updated_at) VALUES ($1, true, $2, NOW())
+			ON CONFLICT (user_id) DO UPDATE SET suspended = true, last_event_id = $2, updated_at = NOW()`, userID, event.EventID)
+		return err
+
+	case moderationUnsuspend:
+		// Only lift suspensions moderation imposed; accounts locked for other reasons stay locked
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE users SET is_active = true, updated_at = NOW()
+			WHERE id = $1 AND EXISTS (SELECT 1 FROM user_moderation WHERE user_id = $1 AND suspended)`, userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE user_moderation SET suspended = false, last_event_id = $2, updated_at = NOW() WHERE user_id = $1`, userID, event.EventID)
+		return err
+
+	case moderationRevokeTokens:
+		return revokeUserTokens(ctx, tx, userID)
+
+	case moderationRestrictScopes:
+		if len(scopes) == 0 {
+			return fmt.Errorf("no scopes given in MODERATION_ACTIONS or the event")
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO user_moderation (user_id, restricted_scopes, last_event_id, updated_at) VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (user_id) DO UPDATE SET
+				restricted_scopes = ARRAY(SELECT DISTINCT unnest(user_moderation.restricted_scopes || $2::text[])),
+				last_event_id = $3, updated_at = NOW()`, userID, pq.Array(scopes), event.EventID); err != nil {
+			return err
+		}
+		// Live tokens carrying a restricted scope are revoked; new ones are issued without it
+		for _, table := range []string{"oauth_access_tokens", "oauth_refresh_tokens"} {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+				UPDATE %s SET is_revoked = true, revoked_at = NOW()
+				WHERE user_id = $1 AND is_revoked = false AND scopes && $2::text[]`, table), userID, pq.Array(scopes)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case moderationUnrestrictScopes:
+		// Without scopes every restriction is lifted
+		_, err := tx.ExecContext(ctx, `
+			UPDATE user_moderation SET
+				restricted_scopes = CASE WHEN cardinality($2::text[]) = 0 THEN '{}'
+					ELSE ARRAY(SELECT unnest(restricted_scopes) EXCEPT SELECT unnest($2::text[])) END,
+				last_event_id = $3, updated_at = NOW()
+			WHERE user_id = $1`, userID, pq.Array(scopes), event.EventID)
+		return err
+	}
+	return fmt.Errorf("unknown action %q", step.Action)
+}
+
+// event loads a recorded event by the sender's ID
+func (s *ModerationService) event(ctx context.Context, eventID string) (moderationRecord, error) {
+	var record moderationRecord
+	var userID uuid.NullUUID
+	err := s.as.db.QueryRowContext(ctx, `
+		SELECT event_id, action, user_id, status, applied, detail, reason, source, received_at
+		FROM moderation_events WHERE event_id = $1`, eventID).Scan(
+		&record.EventID, &record.Action, &userID, &record.Status, pq.Array(&record.Applied),
+		&record.Detail, &record.Reason, &record.Source, &record.ReceivedAt)
+	if userID.Valid {
+		record.UserID = &userID.UUID
+	}
+	return record, err
+}
+
+// allowedScopes drops scopes moderation has restricted for a user from a grant
+func (s *ModerationService) allowedScopes(ctx context.Context, userID uuid.UUID, scopes []string) ([]string, error) {
+	var restricted []string
+	err := s.as.db.QueryRowContext(ctx, `SELECT restricted_scopes FROM user_moderation WHERE user_id = $1`, userID).Scan(pq.Array(&restricted))
+	if errors.Is(err, sql.ErrNoRows) || err == nil && len(restricted) == 0 {
+		return scopes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	blocked := make(map[string]bool, len(restricted))
+	for _, scope := range restricted {
+		blocked[scope] = true
+	}
+	allowed := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !blocked[scope] {
+			allowed = append(allowed, scope)
+		}
+	}
+	return allowed, nil
+}
+
+// AdminListModerationEvents lists processed moderation events, newest first
+func (s *ModerationService) AdminListModerationEvents(c *gin.Context) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		args = append(args, id)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if status := c.Query("status"); status != "" {
+		args = append(args, status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	rows, err := s.as.db.QueryContext(c.Request.Context(), fmt.Sprintf(`
+		SELECT event_id, action, user_id, status, applied, detail, reason, source, received_at
+		FROM moderation_events WHERE %s
+		ORDER BY received_at DESC LIMIT $%d`, strings.Join(conditions, " AND "), len(args)), args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load moderation events"})
+		return
+	}
+	defer rows.Close()
+
+	events := []moderationRecord{}
+	for rows.Next() {
+		var record moderationRecord
+		var userID uuid.NullUUID
+		if err := rows.Scan(&record.EventID, &record.Action, &userID, &record.Status, pq.Array(&record.Applied),
+			&record.Detail, &record.Reason, &record.Source, &record.ReceivedAt); err != nil {
+			continue
+		}
+		if userID.Valid {
+			record.UserID = &userID.UUID
+		}
+		events = append(events, record)
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// AdminGetUserModeration shows a user's current suspension and scope restrictions
+func (s *ModerationService) AdminGetUserModeration(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var suspended bool
+	var restricted []string
+	var lastEventID sql.NullString
+	var updatedAt time.Time
+	err = s.as.db.QueryRowContext(c.Request.Context(), `
+		SELECT suspended, restricted_scopes, last_event_id, updated_at FROM user_moderation WHERE user_id = $1`, userID).Scan(
+		&suspended, pq.Array(&restricted), &lastEventID, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusOK, gin.H{"user_id": userID, "suspended": false, "restricted_scopes": []string{}})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load moderation state"})
+		return
+	}
+	if restricted == nil {
+		restricted = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID, "suspended": suspended, "restricted_scopes": restricted,
+		"last_event_id": lastEventID.String, "updated_at": updatedAt,
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+const moderationTestSecret = "moderation-webhook-secret-for-tests"
+
+type ModerationWebhookTestSuite struct {
+	suite.Suite
+	router *gin.Engine
+}
+
+func (suite *ModerationWebhookTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	s := NewModerationService(&AuthService{}, ModerationConfig{Secret: moderationTestSecret})
+	suite.router = gin.New()
+	suite.router.POST("/webhooks/moderation", s.ReceiveModerationEvent)
+}
+
+func moderationSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (suite *ModerationWebhookTestSuite) post(body, signature string) int {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/moderation", strings.NewReader(body))
+	if signature != "" {
+		req.Header.Set("X-Signature-256", signature)
+	}
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func (suite *ModerationWebhookTestSuite) TestUnsignedDeliveriesAreRejected() {
+	body := `{"event_id": "evt_1", "action": "ban", "username": "troll"}`
+	suite.Equal(http.StatusUnauthorized, suite.post(body, ""))
+	suite.Equal(http.StatusUnauthorized, suite.post(body, moderationSignature("some-other-secret", body)))
+	suite.Equal(http.StatusUnauthorized, suite.post(body, strings.TrimPrefix(moderationSignature(moderationTestSecret, body), "sha256=")))
+	suite.Equal(http.StatusUnauthorized, suite.post(body+" ", moderationSignature(moderationTestSecret, body)), "the signature covers the exact body")
+}
+
+func (suite *ModerationWebhookTestSuite) TestMalformedEventsAreRejectedBeforeTheDatabase() {
+	for _, body := range []string{
+		`not json`,
+		`{"action": "ban", "username": "troll"}`,
+		`{"event_id": "evt_1", "username": "troll"}`,
+		`{"event_id": "evt_1", "action": "ban"}`,
+		`{"event_id": "` + strings.Repeat("x", 201) + `", "action": "ban", "username": "troll"}`,
+	} {
+		suite.Equal(http.StatusBadRequest, suite.post(body, moderationSignature(moderationTestSecret, body)), body)
+	}
+	oversized := strings.Repeat(" ", moderationMaxBody+1)
+	suite.Equal(http.StatusRequestEntityTooLarge, suite.post(oversized, moderationSignature(moderationTestSecret, oversized)))
+}
+
+func (suite *ModerationWebhookTestSuite) TestActionMapping() {
+	actions, err := parseModerationActions("ban=suspend+revoke_tokens, mute=restrict_scopes:write works:manage, unmute=unrestrict_scopes")
+	suite.Require().NoError(err)
+	suite.Equal([]ModerationStep{{Action: moderationSuspend, Scopes: []string{}}, {Action: moderationRevokeTokens, Scopes: []string{}}}, actions["ban"])
+	suite.Equal([]ModerationStep{{Action: moderationRestrictScopes, Scopes: []string{"write", "works:manage"}}}, actions["mute"])
+	suite.Empty(actions["unmute"][0].Scopes)
+
+	for _, value := range []string{"ban", "=suspend", "ban=delete", "ban=suspend:write", "ban=suspend,ban=revoke_tokens"} {
+		_, err := parseModerationActions(value)
+		suite.Error(err, value)
+	}
+}
+
+func (suite *ModerationWebhookTestSuite) TestConfig() {
+	config, err := DefaultModerationConfig()
+	suite.Require().NoError(err)
+	suite.Empty(config.Secret)
+	suite.Contains(config.Actions, "ban")
+	suite.Nil(NewModerationService(&AuthService{}, config), "the webhook is off without a secret")
+
+	suite.T().Setenv("MODERATION_WEBHOOK_SECRET", "short")
+	_, err = DefaultModerationConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("MODERATION_WEBHOOK_SECRET", moderationTestSecret)
+	suite.T().Setenv("MODERATION_ACTIONS", "suspend_account=suspend")
+	config, err = DefaultModerationConfig()
+	suite.Require().NoError(err)
+	suite.Equal(map[string][]ModerationStep{"suspend_account": {{Action: moderationSuspend, Scopes: []string{}}}}, config.Actions)
+}
+
+func TestModerationWebhookTestSuite(t *testing.T) {
+	suite.Run(t, new(ModerationWebhookTestSuite))
+}
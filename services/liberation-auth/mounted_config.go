@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql/driver"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var configReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_config_reloads_total",
+	Help: "Reloads of mounted configuration files, by setting and outcome (applied, failed).",
+}, []string{"key", "outcome"})
+
+// mountedPath is the file a setting is mounted at, if any: KEY_FILE names it explicitly,
+// otherwise CONFIG_DIR (a ConfigMap or Secret volume) may hold a file named KEY
+func mountedPath(key string) string {
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		return path
+	}
+	for _, dir := range strings.Split(os.Getenv("CONFIG_DIR"), ",") {
+		if dir = strings.TrimSpace(dir); dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, key)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// mountedValue reads a mounted setting; trailing newlines, which editors and
+// `kubectl create secret --from-file` leave behind, are trimmed
+func mountedValue(key string) (string, bool) {
+	path := mountedPath(key)
+	if path == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read %s from %s: %v", key, path, err)
+		return "", false
+	}
+	return strings.TrimRight(string(data), "\r\n"), true
+}
+
+// configWatcher re-reads mounted settings and applies the ones that changed. Kubernetes
+// updates ConfigMap and Secret volumes by swapping a ..data symlink, so files are compared
+// by content on a short poll rather than by watching inodes.
+type configWatcher struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	watches []*configWatch
+}
+
+type configWatch struct {
+	key    string
+	digest [sha256.Size]byte
+	apply  func(value string) error
+}
+
+func newConfigWatcher(interval time.Duration) *configWatcher {
+	return &configWatcher{interval: interval}
+}
+
+// watch calls apply whenever the mounted value of key changes. Settings that come from
+// plain environment variables cannot change, so they are not watched.
+func (cw *configWatcher) watch(key string, apply func(value string) error) {
+	if cw == nil || os.Getenv(key) != "" || mountedPath(key) == "" {
+		return
+	}
+	value, _ := mountedValue(key)
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.watches = append(cw.watches, &configWatch{key: key, digest: sha256.Sum256([]byte(value)), apply: apply})
+}
+
+// Run polls until ctx is cancelled
+func (cw *configWatcher) Run(ctx context.Context) {
+	if cw == nil || cw.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cw.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cw.check()
+		}
+	}
+}
+
+// check applies every watched setting whose content changed since the last check. A
+// failed apply keeps the old digest, so the next poll retries it.
+func (cw *configWatcher) check() {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	for _, w := range cw.watches {
+		value, ok := mountedValue(w.key)
+		if !ok {
+			continue
+		}
+		digest := sha256.Sum256([]byte(value))
+		if digest == w.digest {
+			continue
+		}
+		if err := w.apply(value); err != nil {
+			configReloads.WithLabelValues(w.key, "failed").Inc()
+			log.Printf("Failed to reload %s: %v", w.key, err)
+			continue
+		}
+		w.digest = digest
+		configReloads.WithLabelValues(w.key, "applied").Inc()
+		log.Printf("Reloaded %s from %s", w.key, mountedPath(w.key))
+	}
+}
+
+// parseRSAPrivateKey reads a PEM RSA key in PKCS#1 or PKCS#8 form
+func parseRSAPrivateKey(value string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(value))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("key is not an RSA key")
+	}
+	return key, nil
+}
+
+// databaseDSN is DATABASE_URL with DATABASE_PASSWORD, if set, substituted for its password,
+// so a Secret can hold the password alone
+func databaseDSN(dbURL, password string) (string, error) {
+	if password == "" {
+		return dbURL, nil
+	}
+	parsed, err := url.Parse(dbURL)
+	if err != nil || parsed.Scheme == "" {
+		return "", errors.New("DATABASE_PASSWORD requires DATABASE_URL in URL form")
+	}
+	username := ""
+	if parsed.User != nil {
+		username = parsed.User.Username()
+	}
+	parsed.User = url.UserPassword(username, password)
+	return parsed.String(), nil
+}
+
+// reloadingConnector opens each new connection with the current DSN, so a rotated
+// database password takes effect without a restart. Open connections keep their
+// credentials until the pool retires them.
+type reloadingConnector struct {
+	mu  sync.RWMutex
+	dsn string
+}
+
+func newReloadingConnector(dsn string) (*reloadingConnector, error) {
+	if _, err := pq.NewConnector(dsn); err != nil {
+		return nil, err
+	}
+	return &reloadingConnector{dsn: dsn}, nil
+}
+
+// SetDSN validates dsn and uses it for connections opened from now on
+func (rc *reloadingConnector) SetDSN(dsn string) error {
+	if _, err := pq.NewConnector(dsn); err != nil {
+		return fmt.Errorf("invalid database URL: %w", err)
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.dsn = dsn
+	return nil
+}
+
+func (rc *reloadingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	rc.mu.RLock()
+	dsn := rc.dsn
+	rc.mu.RUnlock()
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (rc *reloadingConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notification is an account message for a user, rendered and delivered by the operator's mailer
+type Notification struct {
+	Type     string                 `json:"type"`
+	UserID   uuid.UUID              `json:"user_id"`
+	Email    string                 `json:"email"`
+	Username string                 `json:"username"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	// Locale is the recipient's preferred locale, and Message the notification's summary in it
+	Locale  string `json:"locale,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Notifier delivers account notifications such as inactivity warnings and activity digests
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// NewNotifierFromEnv selects a delivery provider via NOTIFY_PROVIDER (webhook or log)
+func NewNotifierFromEnv() (Notifier, error) {
+	switch provider := getEnv("NOTIFY_PROVIDER", "log"); provider {
+	case "webhook":
+		notifier := &WebhookNotifier{
+			URL:        getEnv("NOTIFY_WEBHOOK_URL", ""),
+			Secret:     getEnv("NOTIFY_WEBHOOK_SECRET", ""),
+			httpClient: &http.Client{Timeout: 10 * time.Second},
+		}
+		if notifier.URL == "" {
+			return nil, fmt.Errorf("webhook notification provider requires NOTIFY_WEBHOOK_URL")
+		}
+		return notifier, nil
+	case "log":
+		return &LogNotifier{}, nil
+	default:
+		return nil, fmt.Errorf("unknown notification provider %q", provider)
+	}
+}
+
+// WebhookNotifier posts notifications to an operator-provided endpoint, signed like OTP webhooks
+type WebhookNotifier struct {
+	URL        string
+	Secret     string
+	httpClient *http.Client
+}
+
+// Name returns the provider name
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Notify delivers the notification payload to the webhook
+func (n *WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"notification": notification,
+		"timestamp":    time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach notification webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// LogNotifier writes notifications to the service log for local development
+type LogNotifier struct{}
+
+// Name returns the provider name
+func (n *LogNotifier) Name() string {
+	return "log"
+}
+
+// Notify logs the notification instead of delivering it
+func (n *LogNotifier) Notify(ctx context.Context, notification Notification) error {
+	log.Printf("Notification %s for %s: %v", notification.Type, notification.UserID, notification.Data)
+	return nil
+}
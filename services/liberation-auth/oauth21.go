@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"nuclear-ao3/shared/models"
+)
+
+// oauth21GrantTypes are the grants OAuth 2.1 keeps; implicit and password are removed
+var oauth21GrantTypes = []string{"authorization_code", "refresh_token", "client_credentials"}
+
+// OAuth21Config switches the service to the OAuth 2.1 profile
+type OAuth21Config struct {
+	// Enabled allows only the code response type, requires S256 PKCE for every client
+	// and refuses registrations that rely on removed features
+	Enabled bool
+	// MaxAccessTokenTTL caps access token lifetimes regardless of the client's setting
+	MaxAccessTokenTTL time.Duration
+}
+
+// DefaultOAuth21Config reads OAUTH21_MODE and OAUTH21_MAX_ACCESS_TOKEN_TTL from the environment
+func DefaultOAuth21Config() (OAuth21Config, error) {
+	config := OAuth21Config{Enabled: getEnv("OAUTH21_MODE", "false") == "true"}
+
+	var err error
+	if config.MaxAccessTokenTTL, err = time.ParseDuration(getEnv("OAUTH21_MAX_ACCESS_TOKEN_TTL", "1h")); err != nil || config.MaxAccessTokenTTL <= 0 {
+		return config, fmt.Errorf("OAUTH21_MAX_ACCESS_TOKEN_TTL must be a positive duration")
+	}
+	return config, nil
+}
+
+// accessTokenTTL is how long an access token for a client lives
+func (cfg OAuth21Config) accessTokenTTL(clientTTLSeconds int) time.Duration {
+	ttl := time.Duration(clientTTLSeconds) * time.Second
+	if cfg.Enabled && ttl > cfg.MaxAccessTokenTTL {
+		return cfg.MaxAccessTokenTTL
+	}
+	return ttl
+}
+
+// responseTypes lists the response types advertised in discovery
+func (cfg OAuth21Config) responseTypes() []string {
+	if cfg.Enabled {
+		return []string{"code"}
+	}
+	return []string{"code", "code id_token"}
+}
+
+// responseModes lists the response modes advertised in discovery; the fragment
+// mode only exists for implicit and hybrid responses
+func (cfg OAuth21Config) responseModes() []string {
+	if cfg.Enabled {
+		return []string{"query", "form_post"}
+	}
+	return []string{"query", "fragment", "form_post"}
+}
+
+// clientIssues lists what an OAuth 2.1 deployment would refuse about a client's registration
+func (cfg OAuth21Config) clientIssues(grantTypes, responseTypes, redirectURIs []string) []string {
+	var issues []string
+	for _, grant := range grantTypes {
+		if !contains(oauth21GrantTypes, grant) {
+			issues = append(issues, fmt.Sprintf("grant type %q is removed in OAuth 2.1", grant))
+		}
+	}
+	for _, responseType := range responseTypes {
+		if responseType != "code" {
+			issues = append(issues, fmt.Sprintf("response type %q is removed in OAuth 2.1", responseType))
+		}
+	}
+	// Loopback port matching is part of OAuth 2.1; other patterns are not
+	for _, uri := range redirectURIs {
+		pattern, err := parseRedirectPattern(uri)
+		switch {
+		case err != nil:
+			issues = append(issues, fmt.Sprintf("redirect URI %q is invalid: %v", uri, err))
+		case pattern.wildcard != "":
+			issues = append(issues, fmt.Sprintf("redirect URI %q is a pattern; OAuth 2.1 requires exact matching", uri))
+		}
+	}
+	return issues
+}
+
+// ClientComplianceReport describes how a client would fare in OAuth 2.1 mode
+type ClientComplianceReport struct {
+	ClientID uuid.UUID `json:"client_id"`
+	Name     string    `json:"name"`
+	IsPublic bool      `json:"is_public"`
+	// Breaking lists problems that make requests fail once OAuth 2.1 mode is on
+	Breaking []string `json:"breaking"`
+	// Changes lists behaviour that changes without failing, such as shorter token lifetimes
+	Changes []string `json:"changes"`
+}
+
+// oauth21Compliance checks every active client against the OAuth 2.1 profile. Codes issued
+// in the last week show which clients skip PKCE or send plain challenges.
+func (as *AuthService) oauth21Compliance(ctx context.Context) ([]ClientComplianceReport, error) {
+	rows, err := as.db.QueryContext(ctx, `
+		SELECT c.client_id, c.client_name, c.is_public, c.grant_types, c.response_types, c.redirect_uris, c.access_token_ttl,
+			COUNT(a.code) FILTER (WHERE COALESCE(a.code_challenge, '') = ''),
+			COUNT(a.code) FILTER (WHERE a.code_challenge_method = 'plain')
+		FROM oauth_clients c
+		LEFT JOIN authorization_codes a ON a.client_id = c.client_id AND a.created_at > NOW() - INTERVAL '7 days'
+		WHERE c.is_active = true
+		GROUP BY c.client_id, c.client_name, c.is_public, c.grant_types, c.response_types, c.redirect_uris, c.access_token_ttl
+		ORDER BY c.client_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []ClientComplianceReport{}
+	for rows.Next() {
+		var report ClientComplianceReport
+		var grantTypes, responseTypes, redirectURIs []string
+		var accessTTL, withoutPKCE, plain int
+		if err := rows.Scan(&report.ClientID, &report.Name, &report.IsPublic, pq.Array(&grantTypes), pq.Array(&responseTypes),
+			pq.Array(&redirectURIs), &accessTTL, &withoutPKCE, &plain); err != nil {
+			return nil, err
+		}
+
+		report.Breaking = as.oauth21.clientIssues(grantTypes, responseTypes, redirectURIs)
+		if withoutPKCE > 0 {
+			report.Breaking = append(report.Breaking, fmt.Sprintf("%d authorization codes in the last 7 days were issued without PKCE", withoutPKCE))
+		}
+		if plain > 0 {
+			report.Breaking = append(report.Breaking, fmt.Sprintf("%d authorization codes in the last 7 days used plain PKCE challenges", plain))
+		}
+		if ttl := time.Duration(accessTTL) * time.Second; as.oauth21.MaxAccessTokenTTL > 0 && ttl > as.oauth21.MaxAccessTokenTTL {
+			report.Changes = append(report.Changes, fmt.Sprintf("access tokens capped from %s to %s", ttl, as.oauth21.MaxAccessTokenTTL))
+		}
+		if len(report.Breaking) == 0 && len(report.Changes) == 0 {
+			continue
+		}
+		if report.Breaking == nil {
+			report.Breaking = []string{}
+		}
+		if report.Changes == nil {
+			report.Changes = []string{}
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// warnOAuth21Clients logs the clients that OAuth 2.1 mode breaks, so operators see them at startup
+func (as *AuthService) warnOAuth21Clients() {
+	reports, err := as.oauth21Compliance(context.Background())
+	if err != nil {
+		log.Printf("WARNING: could not check clients against OAuth 2.1 mode: %v", err)
+		return
+	}
+	for _, report := range reports {
+		for _, issue := range report.Breaking {
+			log.Printf("WARNING: OAuth 2.1 mode breaks client %s (%s): %s", report.Name, report.ClientID, issue)
+		}
+		for _, change := range report.Changes {
+			log.Printf("OAuth 2.1 mode changes client %s (%s): %s", report.Name, report.ClientID, change)
+		}
+	}
+}
+
+// AdminOAuthCompliance lists the clients OAuth 2.1 mode affects; it works with the mode off,
+// so the impact can be reviewed before switching
+func (as *AuthService) AdminOAuthCompliance(c *gin.Context) {
+	reports, err := as.oauth21Compliance(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check clients"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"oauth21_mode":         as.oauth21.Enabled,
+		"max_access_token_ttl": as.oauth21.MaxAccessTokenTTL.String(),
+		"clients":              reports,
+	})
+}
+
+// checkOAuth21Registration refuses client registrations that depend on features OAuth 2.1 removes
+func (as *AuthService) checkOAuth21Registration(req *models.ClientRegistrationRequest) error {
+	if !as.oauth21.Enabled {
+		return nil
+	}
+	if issues := as.oauth21.clientIssues(req.GrantTypes, req.ResponseTypes, req.RedirectURIs); len(issues) > 0 {
+		return fmt.Errorf("%s", strings.Join(issues, "; "))
+	}
+	if limit := int(as.oauth21.MaxAccessTokenTTL.Seconds()); req.AccessTokenTTL > limit {
+		req.AccessTokenTTL = limit
+	}
+	return nil
+}
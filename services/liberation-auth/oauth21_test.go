@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OAuth21TestSuite struct {
+	suite.Suite
+	config OAuth21Config
+}
+
+func (suite *OAuth21TestSuite) SetupTest() {
+	suite.config = OAuth21Config{Enabled: true, MaxAccessTokenTTL: time.Hour}
+}
+
+func (suite *OAuth21TestSuite) TestClientIssues() {
+	issues := suite.config.clientIssues(
+		[]string{"authorization_code", "implicit", "password"},
+		[]string{"code", "token"},
+		[]string{"https://app.example.com/callback", "https://app.example.com/cb#done", "https://*.example.com/cb"},
+	)
+	suite.Len(issues, 5)
+
+	suite.Empty(suite.config.clientIssues(
+		[]string{"authorization_code", "refresh_token"},
+		[]string{"code"},
+		[]string{"https://app.example.com/callback", "http://127.0.0.1/callback", "org.example.app:/callback"},
+	))
+}
+
+func (suite *OAuth21TestSuite) TestAccessTokenLifetimeCap() {
+	suite.Equal(time.Hour, suite.config.accessTokenTTL(86400))
+	suite.Equal(15*time.Minute, suite.config.accessTokenTTL(900))
+
+	suite.config.Enabled = false
+	suite.Equal(24*time.Hour, suite.config.accessTokenTTL(86400))
+}
+
+func (suite *OAuth21TestSuite) TestDiscoveryDropsLegacyResponses() {
+	suite.Equal([]string{"code"}, suite.config.responseTypes())
+	suite.NotContains(suite.config.responseModes(), "fragment")
+
+	suite.Contains(OAuth21Config{}.responseTypes(), "code id_token")
+}
+
+func (suite *OAuth21TestSuite) TestPKCELockedForEveryClient() {
+	optional, plain := false, false
+	policy := PKCEPolicy{RequireAll: true, S256Only: true, Locked: true}
+	requirement := policy.forClient(false, &ClientPKCEPolicy{Required: &optional, S256Only: &plain})
+
+	suite.Equal(pkceRequirement{Required: true, S256Only: true}, requirement)
+	suite.Equal([]string{"S256"}, policy.challengeMethods())
+}
+
+func TestOAuth21TestSuite(t *testing.T) {
+	suite.Run(t, new(OAuth21TestSuite))
+}
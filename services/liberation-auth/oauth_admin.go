@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"nuclear-ao3/shared/models"
+
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
@@ -29,23 +32,31 @@ func (as *AuthService) ShowConsent(c *gin.Context) {
 	consentJSON, err := as.redis.Get(c.Request.Context(), "consent:"+consentID).Result()
 	if err != nil {
 		c.HTML(http.StatusNotFound, "error.html", gin.H{
-			"Error": "Consent request not found or expired",
+			"Error":    "Consent request not found or expired",
+			"Branding": as.branding.resolve(c.Request.Context(), nil),
 		})
 		return
 	}
 
+	// Pages are styled for the requesting client; unknown clients get the default branding
+	var consent struct {
+		Client models.OAuthClient `json:"client"`
+	}
+	json.Unmarshal([]byte(consentJSON), &consent)
+
 	// Render consent screen (this would be a proper HTML template)
 	c.HTML(http.StatusOK, "consent.html", gin.H{
 		"ConsentID": consentID,
 		"Data":      consentJSON,
+		"CSRFToken": c.GetString("csrf_token"),
+		"Branding":  as.branding.resolve(c.Request.Context(), &consent.Client.ID),
 	})
 }
 
 func (as *AuthService) ProcessConsent(c *gin.Context) {
 	consentID := c.Param("consent_id")
-	approved := c.PostForm("approved") == "true"
 
-	as.processConsent(c, consentID, approved)
+	as.processConsent(c, consentID, consentDecisionFromForm(c))
 }
 
 // User consent management
@@ -58,13 +69,13 @@ func (as *AuthService) GetUserConsents(c *gin.Context) {
 
 	query := `
 		SELECT uc.id, uc.client_id, oc.client_name, oc.description, oc.website, oc.logo_url,
-			uc.scopes, uc.granted_at, uc.expires_at
+			uc.scopes, uc.declined_scopes, uc.withheld_claims, uc.granted_at, uc.expires_at
 		FROM user_consents uc
 		JOIN oauth_clients oc ON uc.client_id = oc.client_id
 		WHERE uc.user_id = $1 AND uc.is_revoked = false
 		ORDER BY uc.granted_at DESC`
 
-	rows, err := as.db.Query(query, userID)
+	rows, err := as.db.QueryContext(c.Request.Context(), query, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch consents"})
 		return
@@ -76,26 +87,28 @@ func (as *AuthService) GetUserConsents(c *gin.Context) {
 		var consent gin.H
 		var id, clientID uuid.UUID
 		var clientName, description, website, logoURL string
-		var scopes []string
+		var scopes, declinedScopes, withheldClaims []string
 		var grantedAt time.Time
 		var expiresAt *time.Time
 
 		err := rows.Scan(&id, &clientID, &clientName, &description, &website, &logoURL,
-			pq.Array(&scopes), &grantedAt, &expiresAt)
+			pq.Array(&scopes), pq.Array(&declinedScopes), pq.Array(&withheldClaims), &grantedAt, &expiresAt)
 		if err != nil {
 			continue
 		}
 
 		consent = gin.H{
-			"id":          id,
-			"client_id":   clientID,
-			"client_name": clientName,
-			"description": description,
-			"website":     website,
-			"logo_url":    logoURL,
-			"scopes":      scopes,
-			"granted_at":  grantedAt,
-			"expires_at":  expiresAt,
+			"id":              id,
+			"client_id":       clientID,
+			"client_name":     clientName,
+			"description":     description,
+			"website":         website,
+			"logo_url":        logoURL,
+			"scopes":          scopes,
+			"declined_scopes": declinedScopes,
+			"withheld_claims": withheldClaims,
+			"granted_at":      grantedAt,
+			"expires_at":      expiresAt,
 		}
 
 		consents = append(consents, consent)
@@ -124,7 +137,7 @@ func (as *AuthService) RevokeConsent(c *gin.Context) {
 		SET is_revoked = true, revoked_at = NOW() 
 		WHERE id = $1 AND user_id = $2`
 
-	result, err := as.db.Exec(query, consentUUID, userID)
+	result, err := as.db.ExecContext(c.Request.Context(), query, consentUUID, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke consent"})
 		return
@@ -138,13 +151,14 @@ func (as *AuthService) RevokeConsent(c *gin.Context) {
 
 	// Also revoke all active tokens for this client
 	var clientID uuid.UUID
-	as.db.QueryRow("SELECT client_id FROM user_consents WHERE id = $1", consentUUID).Scan(&clientID)
+	as.db.QueryRowContext(c.Request.Context(), "SELECT client_id FROM user_consents WHERE id = $1", consentUUID).Scan(&clientID)
 
 	revokeQuery := `
 		UPDATE oauth_access_tokens 
 		SET is_revoked = true, revoked_at = NOW() 
 		WHERE user_id = $1 AND client_id = $2 AND is_revoked = false`
-	as.db.Exec(revokeQuery, userID, clientID)
+	as.db.ExecContext(c.Request.Context(), revokeQuery, userID, clientID)
+	as.publishRevocation(RevocationEvent{Type: revocationGrant, UserID: fmt.Sprint(userID), ClientID: clientID.String()})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Consent revoked successfully"})
 }
@@ -165,7 +179,7 @@ func (as *AuthService) GetAuthorizedApplications(c *gin.Context) {
 		GROUP BY oc.client_id, oc.client_name, oc.description, oc.website, oc.logo_url
 		ORDER BY last_used DESC NULLS LAST`
 
-	rows, err := as.db.Query(query, userID)
+	rows, err := as.db.QueryContext(c.Request.Context(), query, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch applications"})
 		return
@@ -230,9 +244,11 @@ func (as *AuthService) RevokeApplication(c *gin.Context) {
 		SET is_revoked = true, revoked_at = NOW() 
 		WHERE user_id = $1 AND client_id = $2 AND is_revoked = false`
 
-	_, err1 := as.db.Exec(tokenQuery, userID, clientUUID)
-	_, err2 := as.db.Exec(refreshQuery, userID, clientUUID)
-	_, err3 := as.db.Exec(consentQuery, userID, clientUUID)
+	_, err1 := as.db.ExecContext(c.Request.Context(), tokenQuery, userID, clientUUID)
+	_, err2 := as.db.ExecContext(c.Request.Context(), refreshQuery, userID, clientUUID)
+	_, err3 := as.db.ExecContext(c.Request.Context(), consentQuery, userID, clientUUID)
+
+	as.publishRevocation(RevocationEvent{Type: revocationGrant, UserID: fmt.Sprint(userID), ClientID: clientUUID.String()})
 
 	if err1 != nil || err2 != nil || err3 != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke application access"})
@@ -261,7 +277,7 @@ func (as *AuthService) AdminListClients(c *gin.Context) {
 		ORDER BY oc.created_at DESC
 		LIMIT $1 OFFSET $2`
 
-	rows, err := as.db.Query(query, limit, offset)
+	rows, err := as.db.QueryContext(c.Request.Context(), query, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch clients"})
 		return
@@ -298,7 +314,7 @@ func (as *AuthService) AdminListClients(c *gin.Context) {
 
 	// Get total count
 	var total int
-	as.db.QueryRow("SELECT COUNT(*) FROM oauth_clients").Scan(&total)
+	as.db.QueryRowContext(c.Request.Context(), "SELECT COUNT(*) FROM oauth_clients").Scan(&total)
 
 	c.JSON(http.StatusOK, gin.H{
 		"clients": clients,
@@ -319,7 +335,7 @@ func (as *AuthService) AdminGetClient(c *gin.Context) {
 		return
 	}
 
-	client, err := as.getClientByID(clientUUID.String())
+	client, err := as.getClientByID(c.Request.Context(), clientUUID.String())
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
 		return
@@ -347,6 +363,9 @@ func (as *AuthService) AdminGetClient(c *gin.Context) {
 		"created_at":        client.CreatedAt,
 		"updated_at":        client.UpdatedAt,
 	}
+	if format, err := as.clientAccessTokenFormat(c.Request.Context(), clientUUID); err == nil {
+		clientData["access_token_format"] = format
+	}
 
 	c.JSON(http.StatusOK, gin.H{"client": clientData})
 }
@@ -394,14 +413,28 @@ func (as *AuthService) AdminUpdateClient(c *gin.Context) {
 		argIndex++
 	}
 
+	// "jwt" issues RFC 9068 JWT access tokens from the next grant on; tokens already issued keep their format
+	if format, exists := updates["access_token_format"]; exists {
+		if format, ok := format.(string); !ok || !validAccessTokenFormat(format) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "access_token_format must be \"opaque\" or \"jwt\""})
+			return
+		}
+		query += fmt.Sprintf(", access_token_format = $%d", argIndex)
+		args = append(args, format)
+		argIndex++
+	}
+
 	query += fmt.Sprintf(" WHERE client_id = $%d", argIndex)
 	args = append(args, clientUUID)
 
-	_, err = as.db.Exec(query, args...)
+	_, err = as.db.ExecContext(c.Request.Context(), query, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update client"})
 		return
 	}
+	as.forgetClient(c.Request.Context(), clientUUID.String())
+
+	as.publishRevocation(RevocationEvent{Type: revocationClient, ClientID: clientUUID.String()})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Client updated successfully"})
 }
@@ -416,7 +449,7 @@ func (as *AuthService) AdminDeleteClient(c *gin.Context) {
 
 	// Check if client is first-party (can't delete)
 	var isFirstParty bool
-	err = as.db.QueryRow("SELECT is_first_party FROM oauth_clients WHERE client_id = $1", clientUUID).Scan(&isFirstParty)
+	err = as.db.QueryRowContext(c.Request.Context(), "SELECT is_first_party FROM oauth_clients WHERE client_id = $1", clientUUID).Scan(&isFirstParty)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
 		return
@@ -426,14 +459,23 @@ func (as *AuthService) AdminDeleteClient(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete first-party clients"})
 		return
 	}
+	if as.previewDryRun(c, "delete_client", clientUUID.String(),
+		[]string{"The client is deactivated rather than deleted, and its tokens stop working at once"},
+		append([]dryRunQuery{{entity: "clients", action: "deactivate", id: "client_id", args: []interface{}{clientUUID},
+			from: "FROM oauth_clients WHERE client_id = $1 AND is_active = true"}},
+			liveTokenQueries("invalidate", "client_id = $1", clientUUID)...)...) {
+		return
+	}
 
 	// Soft delete (deactivate) instead of hard delete to preserve audit trail
 	query := `UPDATE oauth_clients SET is_active = false, updated_at = NOW() WHERE client_id = $1`
-	_, err = as.db.Exec(query, clientUUID)
+	_, err = as.db.ExecContext(c.Request.Context(), query, clientUUID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete client"})
 		return
 	}
+	as.forgetClient(c.Request.Context(), clientUUID.String())
+	as.publishRevocation(RevocationEvent{Type: revocationClient, ClientID: clientUUID.String()})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Client deleted successfully"})
 }
@@ -448,7 +490,7 @@ func (as *AuthService) AdminResetClientSecret(c *gin.Context) {
 
 	// Check if client is confidential
 	var isPublic bool
-	err = as.db.QueryRow("SELECT is_public FROM oauth_clients WHERE client_id = $1", clientUUID).Scan(&isPublic)
+	err = as.db.QueryRowContext(c.Request.Context(), "SELECT is_public FROM oauth_clients WHERE client_id = $1", clientUUID).Scan(&isPublic)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
 		return
@@ -458,6 +500,12 @@ func (as *AuthService) AdminResetClientSecret(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Public clients don't have secrets"})
 		return
 	}
+	if as.previewDryRun(c, "reset_client_secret", clientUUID.String(),
+		[]string{"The current secret stops working at once; refresh tokens need the new secret"},
+		dryRunQuery{entity: "access_tokens", action: "revoke", id: "id", args: []interface{}{clientUUID},
+			from: "FROM oauth_access_tokens WHERE client_id = $1 AND is_revoked = false"}) {
+		return
+	}
 
 	// Generate new secret
 	newSecret, err := generateClientSecret()
@@ -474,18 +522,20 @@ func (as *AuthService) AdminResetClientSecret(c *gin.Context) {
 	}
 
 	query := `UPDATE oauth_clients SET client_secret = $1, updated_at = NOW() WHERE client_id = $2`
-	_, err = as.db.Exec(query, string(hashedSecret), clientUUID)
+	_, err = as.db.ExecContext(c.Request.Context(), query, string(hashedSecret), clientUUID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update secret"})
 		return
 	}
+	as.forgetClient(c.Request.Context(), clientUUID.String())
 
 	// Revoke all existing tokens for this client
 	revokeQuery := `
 		UPDATE oauth_access_tokens 
 		SET is_revoked = true, revoked_at = NOW() 
 		WHERE client_id = $1 AND is_revoked = false`
-	as.db.Exec(revokeQuery, clientUUID)
+	as.db.ExecContext(c.Request.Context(), revokeQuery, clientUUID)
+	as.publishRevocation(RevocationEvent{Type: revocationClient, ClientID: clientUUID.String()})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":       "Client secret reset successfully",
@@ -527,7 +577,7 @@ func (as *AuthService) AdminListTokens(c *gin.Context) {
 	query += fmt.Sprintf(" ORDER BY at.created_at DESC LIMIT $%d OFFSET $%d", argIndex, argIndex+1)
 	args = append(args, limit, offset)
 
-	rows, err := as.db.Query(query, args...)
+	rows, err := as.db.QueryContext(c.Request.Context(), query, args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
 		return
@@ -574,13 +624,18 @@ func (as *AuthService) AdminRevokeToken(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
 		return
 	}
+	if as.previewDryRun(c, "revoke_token", tokenUUID.String(), nil,
+		dryRunQuery{entity: "access_tokens", action: "revoke", id: "id", args: []interface{}{tokenUUID},
+			from: "FROM oauth_access_tokens WHERE id = $1", missing: "Token not found"}) {
+		return
+	}
 
 	query := `
 		UPDATE oauth_access_tokens 
 		SET is_revoked = true, revoked_at = NOW() 
 		WHERE id = $1`
 
-	result, err := as.db.Exec(query, tokenUUID)
+	result, err := as.db.ExecContext(c.Request.Context(), query, tokenUUID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
 		return
@@ -591,6 +646,7 @@ func (as *AuthService) AdminRevokeToken(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
 		return
 	}
+	as.publishRevocation(RevocationEvent{Type: revocationToken, ID: tokenUUID.String()})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
 }
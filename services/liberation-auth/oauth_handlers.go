@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -32,12 +34,9 @@ func (as *AuthService) WellKnownOIDC(c *gin.Context) {
 		RevocationEndpoint:    baseURL + "/auth/revoke",
 		IntrospectionEndpoint: baseURL + "/auth/introspect",
 
-		ScopesSupported: []string{
-			"openid", "profile", "email", "read", "write", "works:manage",
-			"comments:write", "bookmarks:manage", "collections:manage",
-		},
-		ResponseTypesSupported: []string{"code", "code id_token"},
-		ResponseModesSupported: []string{"query", "fragment", "form_post"},
+		ScopesSupported:        supportedScopes,
+		ResponseTypesSupported: as.oauth21.responseTypes(),
+		ResponseModesSupported: as.oauth21.responseModes(),
 		GrantTypesSupported: []string{
 			"authorization_code", "refresh_token", "client_credentials",
 		},
@@ -46,11 +45,11 @@ func (as *AuthService) WellKnownOIDC(c *gin.Context) {
 		TokenEndpointAuthMethodsSupported: []string{
 			"client_secret_basic", "client_secret_post", "none",
 		},
-		CodeChallengeMethodsSupported: []string{"S256", "plain"},
+		CodeChallengeMethodsSupported: as.pkce.challengeMethods(),
 		ClaimsSupported: []string{
 			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
 			"name", "preferred_username", "email", "email_verified",
-			"ao3_username", "ao3_display_name", "ao3_roles", "ao3_join_date",
+			"ao3_username", "ao3_display_name", "ao3_roles", "ao3_join_date", "locale",
 		},
 		ServiceDocumentation: baseURL + "/docs/oauth2",
 		OpPolicyURI:          baseURL + "/terms",
@@ -76,7 +75,7 @@ func (as *AuthService) WellKnownOAuth2(c *gin.Context) {
 		"response_types_supported":              []string{"code"},
 		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
 		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
-		"code_challenge_methods_supported":      []string{"S256", "plain"},
+		"code_challenge_methods_supported":      as.pkce.challengeMethods(),
 	}
 
 	c.Header("Cache-Control", "public, max-age=3600")
@@ -139,6 +138,11 @@ func (as *AuthService) RegisterClient(c *gin.Context) {
 		ownerID = &uid
 	}
 
+	// Clients of a hosted tenant's members count against its plan
+	if ownerID != nil && !as.tenants.admitMember(c, *ownerID, quotaClients) {
+		return
+	}
+
 	// Set defaults
 	if req.AccessTokenTTL == 0 {
 		req.AccessTokenTTL = 86400 // 24 hours (dev-friendly)
@@ -146,6 +150,13 @@ func (as *AuthService) RegisterClient(c *gin.Context) {
 	if req.RefreshTokenTTL == 0 {
 		req.RefreshTokenTTL = 2592000 // 30 days
 	}
+	if err := as.checkOAuth21Registration(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_client_metadata",
+			"error_description": err.Error(),
+		})
+		return
+	}
 
 	// Create client
 	client := &models.OAuthClient{
@@ -190,7 +201,7 @@ func (as *AuthService) RegisterClient(c *gin.Context) {
 			is_active, created_at, updated_at
 		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)`
 
-	_, err := as.db.Exec(query,
+	_, err := as.db.ExecContext(c.Request.Context(), query,
 		client.ID, client.Secret, client.Name, client.Description, client.Website, client.LogoURL,
 		pq.Array(client.RedirectURIs), pq.Array(client.Scopes), pq.Array(client.GrantTypes),
 		pq.Array(client.ResponseTypes), client.IsPublic, client.IsConfidential,
@@ -239,8 +250,19 @@ func (as *AuthService) Authorize(c *gin.Context) {
 		return
 	}
 
+	prompt, err := parseAuthorizePrompt(c)
+	if err != nil {
+		as.redirectWithError(c, req.RedirectURI, req.State, "invalid_request", err.Error())
+		return
+	}
+
+	as.authorize(c, req, prompt)
+}
+
+// authorize validates an authorization request and either parks it for login or issues a code
+func (as *AuthService) authorize(c *gin.Context, req models.AuthorizeRequest, prompt authorizePrompt) {
 	// Validate client
-	client, err := as.getClientByID(req.ClientID)
+	client, err := as.getClientByID(c.Request.Context(), req.ClientID)
 	if err != nil {
 		as.redirectWithError(c, req.RedirectURI, req.State, "invalid_client", "Invalid client")
 		return
@@ -251,8 +273,8 @@ func (as *AuthService) Authorize(c *gin.Context) {
 		return
 	}
 
-	// Validate redirect URI
-	if !contains(client.RedirectURIs, req.RedirectURI) {
+	// Validate redirect URI: exact, or a loopback port / permitted wildcard (see redirect_uris.go)
+	if !as.redirectURIAllowed(client, req.RedirectURI) {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":             "invalid_request",
 			"error_description": "Invalid redirect_uri",
@@ -260,8 +282,8 @@ func (as *AuthService) Authorize(c *gin.Context) {
 		return
 	}
 
-	// Validate response type
-	if !contains(client.ResponseTypes, req.ResponseType) {
+	// Validate response type; OAuth 2.1 mode only issues codes
+	if !contains(client.ResponseTypes, req.ResponseType) || (as.oauth21.Enabled && req.ResponseType != "code") {
 		as.redirectWithError(c, req.RedirectURI, req.State, "unsupported_response_type", "Response type not supported")
 		return
 	}
@@ -273,31 +295,63 @@ func (as *AuthService) Authorize(c *gin.Context) {
 		return
 	}
 
-	// Validate PKCE for public clients
-	if client.IsPublic && req.CodeChallenge == "" {
-		as.redirectWithError(c, req.RedirectURI, req.State, "invalid_request", "PKCE required for public clients")
+	// Validate PKCE against the client's policy; public clients always need it
+	method, err := as.pkceRequirementFor(c.Request.Context(), client).check(req.CodeChallenge, req.CodeChallengeMethod)
+	if err != nil {
+		as.redirectWithError(c, req.RedirectURI, req.State, "invalid_request", err.Error())
 		return
 	}
+	req.CodeChallengeMethod = method
 
 	// Check if user is authenticated
 	userID := as.getAuthenticatedUser(c)
 	if userID == nil {
-		// Store authorization request and redirect to login
-		authReqID := as.storeAuthorizationRequest(req)
-		loginURL := fmt.Sprintf("/login?auth_request=%s", authReqID)
-		c.Redirect(http.StatusFound, loginURL)
+		if prompt.has(promptNone) {
+			as.promptNoneError(c, req, "login_required", "User is not logged in")
+			return
+		}
+		as.redirectToLogin(c, req, prompt)
 		return
 	}
 
-	// Check consent (skip for trusted clients)
-	if !client.IsTrusted && !as.hasValidConsent(*userID, client.ID, requestedScopes) {
+	// prompt=login, select_account and max_age may demand a fresh login despite the session
+	if prompt.demandsLogin() || prompt.LoginSince != nil {
+		user, err := as.getUserByID(c.Request.Context(), *userID)
+		if err != nil || prompt.needsLogin(user, time.Now()) {
+			if prompt.has(promptNone) {
+				as.promptNoneError(c, req, "login_required", "Re-authentication is required")
+				return
+			}
+			as.redirectToLogin(c, req, prompt)
+			return
+		}
+	}
+
+	// Check consent (skip for trusted clients unless prompt=consent asks for it)
+	if prompt.has(promptConsent) || (!client.IsTrusted && !as.hasValidConsent(c.Request.Context(), *userID, client.ID, requestedScopes)) {
+		if prompt.has(promptNone) {
+			as.promptNoneError(c, req, "consent_required", "User has not consented to the requested scopes")
+			return
+		}
 		// Show consent screen
 		as.showConsentScreen(c, client, requestedScopes, req)
 		return
 	}
 
+	// Scopes the user declined earlier are left out of the code
+	if !client.IsTrusted {
+		if grant := as.getConsentGrant(c.Request.Context(), *userID, client.ID); grant != nil {
+			effective := grant.effectiveScopes(requestedScopes)
+			if len(effective) == 0 {
+				as.redirectWithError(c, req.RedirectURI, req.State, "access_denied", "User declined all requested scopes")
+				return
+			}
+			req.Scope = strings.Join(effective, " ")
+		}
+	}
+
 	// Generate authorization code
-	code, err := as.generateAuthorizationCode(*userID, client.ID, req)
+	code, err := as.generateAuthorizationCode(c.Request.Context(), *userID, client.ID, req)
 	if err != nil {
 		as.redirectWithError(c, req.RedirectURI, req.State, "server_error", "Failed to generate authorization code")
 		return
@@ -308,6 +362,21 @@ func (as *AuthService) Authorize(c *gin.Context) {
 	c.Redirect(http.StatusFound, callbackURL)
 }
 
+// redirectToLogin parks an authorization request and sends the browser to the login page
+func (as *AuthService) redirectToLogin(c *gin.Context, req models.AuthorizeRequest, prompt authorizePrompt) {
+	authReqID, expiresAt, err := as.storeAuthorizationRequest(c, req, prompt)
+	if err != nil {
+		as.redirectWithError(c, req.RedirectURI, req.State, "server_error", "Failed to store authorization request")
+		return
+	}
+	loginURL := fmt.Sprintf("/login?auth_request=%s&expires_at=%d", authReqID, expiresAt.Unix())
+	if prompt.demandsLogin() {
+		// Tell the login page not to skip the form for an existing session
+		loginURL += "&prompt=login"
+	}
+	c.Redirect(http.StatusFound, loginURL)
+}
+
 // Token endpoint
 
 func (as *AuthService) Token(c *gin.Context) {
@@ -347,7 +416,7 @@ func (as *AuthService) handleAuthorizationCodeGrant(c *gin.Context, req models.T
 	}
 
 	// Validate authorization code
-	authCode, err := as.validateAuthorizationCode(req.Code, client.ID, req.RedirectURI, req.CodeVerifier)
+	authCode, err := as.validateAuthorizationCode(c.Request.Context(), req.Code, client.ID, req.RedirectURI, req.CodeVerifier)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
 			Error:            "invalid_grant",
@@ -356,8 +425,45 @@ func (as *AuthService) handleAuthorizationCodeGrant(c *gin.Context, req models.T
 		return
 	}
 
+	// Mobile apps can register the device they run on with the first tokens they get
+	device, ok := as.devices.fromRequest(c)
+	if !ok {
+		return
+	}
+
+	// Bind the tokens to the resource servers named in the request, or the client's defaults
+	audience, scopes, err := as.selectAudience(c.Request.Context(), client.ID, c.PostFormArray("resource"), nil, authCode.Scopes)
+	if err != nil {
+		writeAudienceError(c, err)
+		return
+	}
+
+	// Deployments with authorization policies get the final say on the grant
+	scopes, ok = as.authorizeGrant(c, "authorization_code", client, &authCode.UserID, scopes, audience)
+	if !ok {
+		as.markCodeAsUsed(c.Request.Context(), authCode.Code)
+		return
+	}
+
+	// Each authorization starts a session, which has to fit under the user's session limit
+	if err := as.sessionLimits.admit(c, authCode.UserID, client.ID); err != nil {
+		if errors.Is(err, errSessionLimitReached) {
+			as.markCodeAsUsed(c.Request.Context(), authCode.Code)
+			c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
+				Error:            "invalid_grant",
+				ErrorDescription: "Too many active sessions; sign out on another device and try again",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.TokenErrorResponse{
+			Error:            "server_error",
+			ErrorDescription: "Failed to check session limit",
+		})
+		return
+	}
+
 	// Generate tokens
-	accessToken, refreshToken, err := as.generateTokens(authCode.UserID, client.ID, authCode.Scopes, c.ClientIP(), c.GetHeader("User-Agent"))
+	accessToken, refreshToken, err := as.generateTokens(c.Request.Context(), authCode.UserID, client.ID, scopes, audience, c.ClientIP(), c.GetHeader("User-Agent"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.TokenErrorResponse{
 			Error:            "server_error",
@@ -369,7 +475,7 @@ func (as *AuthService) handleAuthorizationCodeGrant(c *gin.Context, req models.T
 	// Generate ID token for OIDC
 	var idToken string
 	if contains(authCode.Scopes, "openid") {
-		idToken, err = as.generateIDToken(authCode.UserID, client.ID, authCode.Nonce, authCode.Scopes)
+		idToken, err = as.generateIDToken(c.Request.Context(), authCode.UserID, client.ID, authCode.Nonce, authCode.Scopes)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.TokenErrorResponse{
 				Error:            "server_error",
@@ -380,7 +486,8 @@ func (as *AuthService) handleAuthorizationCodeGrant(c *gin.Context, req models.T
 	}
 
 	// Mark code as used
-	as.markCodeAsUsed(authCode.Code)
+	as.markCodeAsUsed(c.Request.Context(), authCode.Code)
+	as.devices.register(c, device, authCode.UserID, client.ID, refreshToken.ID)
 
 	// Build response
 	response := models.TokenResponse{
@@ -388,14 +495,14 @@ func (as *AuthService) handleAuthorizationCodeGrant(c *gin.Context, req models.T
 		TokenType:    "Bearer",
 		ExpiresIn:    int(time.Until(accessToken.ExpiresAt).Seconds()),
 		RefreshToken: refreshToken.Token,
-		Scope:        strings.Join(authCode.Scopes, " "),
+		Scope:        strings.Join(scopes, " "),
 	}
 
 	if idToken != "" {
 		response.IDToken = idToken
 	}
 
-	c.JSON(http.StatusOK, response)
+	as.writeTokenResponse(c, client.ID, authCode.UserID, response, refreshToken.ExpiresAt)
 }
 
 func (as *AuthService) handleRefreshTokenGrant(c *gin.Context, req models.TokenRequest) {
@@ -410,11 +517,25 @@ func (as *AuthService) handleRefreshTokenGrant(c *gin.Context, req models.TokenR
 	}
 
 	// Validate refresh token
-	refreshToken, err := as.validateRefreshToken(req.RefreshToken, client.ID)
+	refreshToken, err := as.validateRefreshToken(c.Request.Context(), req.RefreshToken, client.ID)
 	if err != nil {
+		description := "Invalid refresh token"
+		if notice := as.sessionLimits.evictionNotice(c.Request.Context(), req.RefreshToken, client.ID); notice != "" {
+			description = notice
+		}
 		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
 			Error:            "invalid_grant",
-			ErrorDescription: "Invalid refresh token",
+			ErrorDescription: description,
+		})
+		return
+	}
+
+	// Refresh tokens bound to a device stop working once the user signs the device out
+	deviceID, active := as.devices.boundDevice(c.Request.Context(), refreshToken.ID)
+	if !active {
+		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
+			Error:            "invalid_grant",
+			ErrorDescription: "This device was signed out",
 		})
 		return
 	}
@@ -433,8 +554,24 @@ func (as *AuthService) handleRefreshTokenGrant(c *gin.Context, req models.TokenR
 		scopes = requestedScopes
 	}
 
+	// The new pair keeps the grant's audience, or narrows it to the resources requested
+	bound, err := as.refreshTokenAudience(c.Request.Context(), refreshToken.ID)
+	if err != nil {
+		writeAudienceError(c, err)
+		return
+	}
+	audience, scopes, err := as.selectAudience(c.Request.Context(), client.ID, c.PostFormArray("resource"), bound, scopes)
+	if err != nil {
+		writeAudienceError(c, err)
+		return
+	}
+	scopes, ok := as.authorizeGrant(c, "refresh_token", client, &refreshToken.UserID, scopes, audience)
+	if !ok {
+		return
+	}
+
 	// Generate new tokens
-	newAccessToken, newRefreshToken, err := as.generateTokens(refreshToken.UserID, client.ID, scopes, c.ClientIP(), c.GetHeader("User-Agent"))
+	newAccessToken, newRefreshToken, err := as.generateTokens(c.Request.Context(), refreshToken.UserID, client.ID, scopes, audience, c.ClientIP(), c.GetHeader("User-Agent"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.TokenErrorResponse{
 			Error:            "server_error",
@@ -444,12 +581,13 @@ func (as *AuthService) handleRefreshTokenGrant(c *gin.Context, req models.TokenR
 	}
 
 	// Revoke old refresh token
-	as.revokeRefreshToken(refreshToken.ID)
+	as.revokeRefreshToken(c.Request.Context(), refreshToken.ID)
+	as.devices.rebind(c, deviceID, newRefreshToken.ID)
 
 	// Generate new ID token for OIDC
 	var idToken string
 	if contains(scopes, "openid") {
-		idToken, err = as.generateIDToken(refreshToken.UserID, client.ID, "", scopes)
+		idToken, err = as.generateIDToken(c.Request.Context(), refreshToken.UserID, client.ID, "", scopes)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.TokenErrorResponse{
 				Error:            "server_error",
@@ -472,7 +610,7 @@ func (as *AuthService) handleRefreshTokenGrant(c *gin.Context, req models.TokenR
 		response.IDToken = idToken
 	}
 
-	c.JSON(http.StatusOK, response)
+	as.writeTokenResponse(c, client.ID, refreshToken.UserID, response, newRefreshToken.ExpiresAt)
 }
 
 func (as *AuthService) handleClientCredentialsGrant(c *gin.Context, req models.TokenRequest) {
@@ -509,6 +647,16 @@ func (as *AuthService) handleClientCredentialsGrant(c *gin.Context, req models.T
 		scopes = requestedScopes
 	}
 
+	audience, scopes, err := as.selectAudience(c.Request.Context(), client.ID, c.PostFormArray("resource"), nil, scopes)
+	if err != nil {
+		writeAudienceError(c, err)
+		return
+	}
+	scopes, ok := as.authorizeGrant(c, "client_credentials", client, nil, scopes, audience)
+	if !ok {
+		return
+	}
+
 	// Generate access token (no refresh token for client credentials)
 	tokenID := uuid.New()
 	tokenStr, err := generateSecureToken()
@@ -520,7 +668,7 @@ func (as *AuthService) handleClientCredentialsGrant(c *gin.Context, req models.T
 		return
 	}
 
-	expiresAt := time.Now().Add(time.Duration(client.AccessTokenTTL) * time.Second)
+	expiresAt := time.Now().Add(as.oauth21.accessTokenTTL(client.AccessTokenTTL))
 
 	accessToken := &models.OAuthAccessToken{
 		ID:        tokenID,
@@ -535,8 +683,16 @@ func (as *AuthService) handleClientCredentialsGrant(c *gin.Context, req models.T
 		CreatedAt: time.Now(),
 	}
 
+	if err := as.signAccessToken(c.Request.Context(), accessToken, audience); err != nil {
+		c.JSON(http.StatusInternalServerError, models.TokenErrorResponse{
+			Error:            "server_error",
+			ErrorDescription: "Failed to generate token",
+		})
+		return
+	}
+
 	// Store access token
-	err = as.storeAccessToken(accessToken)
+	err = as.storeAccessToken(c.Request.Context(), accessToken, audience)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.TokenErrorResponse{
 			Error:            "server_error",
@@ -562,6 +718,7 @@ func (as *AuthService) UserInfo(c *gin.Context) {
 	// Extract access token
 	token := as.extractBearerToken(c.GetHeader("Authorization"))
 	if token == "" {
+		setBearerChallenge(c, "", "", "")
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error":             "invalid_token",
 			"error_description": "Missing or invalid access token",
@@ -570,34 +727,29 @@ func (as *AuthService) UserInfo(c *gin.Context) {
 	}
 
 	// Validate access token
-	accessToken, err := as.validateAccessToken(token)
+	accessToken, scopes, err := as.validateAccessTokenScopes(c.Request.Context(), token)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error":             "invalid_token",
-			"error_description": "Invalid access token",
-		})
+		abortWithBearerError(c, http.StatusUnauthorized, bearerErrorInvalidToken, accessTokenDenialReason(err), "")
 		return
 	}
 
 	// Check if profile scope is present
-	if !contains(accessToken.Scopes, "profile") && !contains(accessToken.Scopes, "openid") {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error":             "insufficient_scope",
-			"error_description": "Profile scope required",
-		})
+	if !scopes.hasAny("profile", "openid") {
+		abortWithBearerError(c, http.StatusForbidden, bearerErrorInsufficientScope, "Profile scope required", "openid profile")
 		return
 	}
 
 	// Get user info (only for user-based tokens)
 	if accessToken.UserID == nil {
-		c.JSON(http.StatusBadRequest, gin.H{
+		setBearerChallenge(c, bearerErrorInvalidToken, "User info not available for client credentials tokens", "")
+		c.JSON(http.StatusUnauthorized, gin.H{
 			"error":             "invalid_token",
 			"error_description": "User info not available for client credentials tokens",
 		})
 		return
 	}
 
-	user, err := as.getUserByID(*accessToken.UserID)
+	user, err := as.getUserByID(c.Request.Context(), *accessToken.UserID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":             "server_error",
@@ -611,7 +763,7 @@ func (as *AuthService) UserInfo(c *gin.Context) {
 		Subject: accessToken.UserID.String(),
 	}
 
-	if contains(accessToken.Scopes, "profile") {
+	if scopes.has("profile") {
 		userInfo.Name = user.DisplayName
 		userInfo.PreferredUsername = user.Username
 		userInfo.Profile = fmt.Sprintf("https://ao3.example.com/users/%s", user.Username)
@@ -621,26 +773,32 @@ func (as *AuthService) UserInfo(c *gin.Context) {
 		userInfo.AO3JoinDate = user.CreatedAt.Unix()
 
 		// Get user roles
-		if roles, err := as.getUserRoles(user.ID); err == nil {
+		if roles, err := as.getUserRoles(c.Request.Context(), user.ID); err == nil {
 			userInfo.AO3Roles = roles
 		}
 
 		// Get user statistics
-		if stats, err := as.getUserStats(user.ID); err == nil {
+		if stats, err := as.getUserStats(c.Request.Context(), user.ID); err == nil {
 			userInfo.AO3WorkCount = stats.WorkCount
 			userInfo.AO3BookmarkCount = stats.BookmarkCount
 		}
 	}
 
-	if contains(accessToken.Scopes, "email") {
+	if scopes.has("email") {
 		userInfo.Email = user.Email
 		userInfo.EmailVerified = user.IsVerified
 	}
+	as.withholdUserInfo(c.Request.Context(), *accessToken.UserID, accessToken.ClientID, &userInfo)
+	claims := claimsMap(userInfo)
+	if scopes.has("profile") {
+		claims["locale"] = matchLocale(as.userLocale(c.Request.Context(), *accessToken.UserID))
+	}
+	as.applyClaimsPolicy(c.Request.Context(), accessToken.ClientID, claimTargetUserInfo, accessToken.Scopes, claims)
 
 	// Update last used timestamp
-	go as.updateTokenLastUsed(accessToken.ID)
+	go as.updateTokenLastUsed(context.WithoutCancel(c.Request.Context()), accessToken.ID)
 
-	c.JSON(http.StatusOK, userInfo)
+	c.JSON(http.StatusOK, claims)
 }
 
 // Token introspection
@@ -656,7 +814,7 @@ func (as *AuthService) Introspect(c *gin.Context) {
 	}
 
 	// Authenticate client
-	_, err := as.authenticateClient(req.ClientID, req.ClientSecret, c.Request)
+	introspector, err := as.authenticateClient(req.ClientID, req.ClientSecret, c.Request)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error":             "invalid_client",
@@ -665,14 +823,61 @@ func (as *AuthService) Introspect(c *gin.Context) {
 		return
 	}
 
-	// Validate token
-	accessToken, err := as.validateAccessToken(req.Token)
+	// A registered resource server only sees tokens meant for it as active
+	server, err := as.resourceServerForClient(c.Request.Context(), introspector.ID)
 	if err != nil {
-		// Return inactive for invalid tokens
-		c.JSON(http.StatusOK, models.IntrospectResponse{Active: false})
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":             "server_error",
+			"error_description": "Failed to load resource server",
+		})
 		return
 	}
 
+	c.JSON(http.StatusOK, as.introspectToken(c.Request.Context(), server, req.Token))
+}
+
+// introspectToken builds the RFC 7662 response for one token as seen by server, which is
+// nil when the caller is not a registered resource server
+func (as *AuthService) introspectToken(ctx context.Context, server *ResourceServer, token string) interface{} {
+	accessToken, err := as.validateAccessToken(ctx, token)
+	if err != nil {
+		if guest := as.lookupGuestToken(ctx, token); guest != nil && (server == nil || as.audience.allows(nil, server.Identifier)) {
+			return gin.H{
+				"active":         true,
+				"scope":          strings.Join(guest.Scopes, " "),
+				"sub":            guest.Subject,
+				"token_type":     "Bearer",
+				"exp":            guest.ExpiresAt.Unix(),
+				"iat":            guest.CreatedAt.Unix(),
+				"jti":            guest.ID.String(),
+				"guest":          true,
+				"correlation_id": guest.CorrelationID,
+			}
+		}
+
+		// First-party JWTs are active until they expire or their user signs out everywhere
+		if claims, userID, err := as.validateUserJWT(ctx, token, as.audience.firstParty()); err == nil && (server == nil || server.Identifier == as.audience.firstParty()) {
+			return gin.H{
+				"active":     true,
+				"scope":      strings.Join(claims.Scope, " "),
+				"sub":        userID.String(),
+				"aud":        as.audience.firstParty(),
+				"token_type": "Bearer",
+				"exp":        claims.ExpiresAt.Unix(),
+				"iat":        claims.IssuedAt.Unix(),
+				"jti":        claims.ID,
+			}
+		}
+
+		// Return inactive for invalid tokens
+		return models.IntrospectResponse{Active: false}
+	}
+
+	audience, err := as.accessTokenAudience(ctx, accessToken.ID)
+	if err != nil || (server != nil && !as.audience.allows(audience, server.Identifier)) {
+		return models.IntrospectResponse{Active: false}
+	}
+
 	// Build introspection response
 	response := models.IntrospectResponse{
 		Active:   true,
@@ -682,10 +887,9 @@ func (as *AuthService) Introspect(c *gin.Context) {
 
 	// Add user info if available (not for client credentials)
 	if accessToken.UserID != nil {
-		user, err := as.getUserByID(*accessToken.UserID)
+		user, err := as.getUserByID(ctx, *accessToken.UserID)
 		if err != nil {
-			c.JSON(http.StatusOK, models.IntrospectResponse{Active: false})
-			return
+			return models.IntrospectResponse{Active: false}
 		}
 		response.Username = user.Username
 		response.Subject = accessToken.UserID.String()
@@ -696,7 +900,12 @@ func (as *AuthService) Introspect(c *gin.Context) {
 	response.IssuedAt = accessToken.CreatedAt.Unix()
 	response.JWTID = accessToken.ID.String()
 
-	c.JSON(http.StatusOK, response)
+	claims := claimsMap(response)
+	if len(audience) > 0 {
+		claims["aud"] = audienceClaim(audience)
+	}
+	as.applyClaimsPolicy(ctx, accessToken.ClientID, claimTargetAccessToken, accessToken.Scopes, claims)
+	return claims
 }
 
 // Token revocation
@@ -727,14 +936,14 @@ func (as *AuthService) Revoke(c *gin.Context) {
 
 	// Try to revoke as refresh token first, then access token
 	if tokenTypeHint == "refresh_token" || tokenTypeHint == "" {
-		if as.revokeRefreshTokenByValue(token) {
+		if as.revokeRefreshTokenByValue(c.Request.Context(), token) {
 			c.Status(http.StatusOK)
 			return
 		}
 	}
 
 	if tokenTypeHint == "access_token" || tokenTypeHint == "" {
-		if as.revokeAccessTokenByValue(token) {
+		if as.revokeAccessTokenByValue(c.Request.Context(), token) || as.revokeGuestToken(c.Request.Context(), token) {
 			c.Status(http.StatusOK)
 			return
 		}
@@ -746,8 +955,21 @@ func (as *AuthService) Revoke(c *gin.Context) {
 
 // Helper functions
 
-func (as *AuthService) getClientByID(clientID string) (*models.OAuthClient, error) {
-	client := &models.OAuthClient{}
+// getClientByID returns an active client from the read cache, or from the database. Callers
+// get their own copy, so changing it does not change what other requests see.
+func (as *AuthService) getClientByID(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	if as.caches == nil {
+		client, err := as.loadClient(ctx, clientID)
+		return &client, err
+	}
+	client, err := as.caches.clients.Get(ctx, clientID, func(ctx context.Context) (models.OAuthClient, error) {
+		return as.loadClient(ctx, clientID)
+	})
+	return &client, err
+}
+
+func (as *AuthService) loadClient(ctx context.Context, clientID string) (models.OAuthClient, error) {
+	var client models.OAuthClient
 	query := `
 		SELECT client_id, client_secret, client_name, description, website, logo_url,
 			redirect_uris, scopes, grant_types, response_types, is_public, is_confidential,
@@ -756,7 +978,7 @@ func (as *AuthService) getClientByID(clientID string) (*models.OAuthClient, erro
 		FROM oauth_clients 
 		WHERE client_id = $1 AND is_active = true`
 
-	err := as.db.QueryRow(query, clientID).Scan(
+	err := as.db.QueryRowContext(ctx, query, clientID).Scan(
 		&client.ID, &client.Secret, &client.Name, &client.Description, &client.Website, &client.LogoURL,
 		pq.Array(&client.RedirectURIs), pq.Array(&client.Scopes), pq.Array(&client.GrantTypes),
 		pq.Array(&client.ResponseTypes), &client.IsPublic, &client.IsConfidential,
@@ -783,24 +1005,24 @@ func generateSecureToken() (string, error) {
 }
 
 func isValidRedirectURI(uri string, isPublic bool) bool {
-	parsed, err := url.Parse(uri)
-	if err != nil {
+	pattern, err := parseRedirectPattern(uri)
+	if err != nil || pattern.scheme == "" {
 		return false
 	}
 
-	// For public clients, allow localhost and custom schemes
+	// For public clients, allow loopback and custom schemes
 	if isPublic {
-		if parsed.Scheme == "http" && (parsed.Hostname() == "localhost" || parsed.Hostname() == "127.0.0.1") {
+		if pattern.scheme == "http" && pattern.loopback {
 			return true
 		}
-		if parsed.Scheme != "https" && parsed.Scheme != "http" {
-			return true // Custom schemes for mobile apps
+		if pattern.scheme != "https" && pattern.scheme != "http" {
+			return pattern.wildcard == "" // Custom schemes for mobile apps
 		}
 	}
 
-	// For confidential clients, require HTTPS (except localhost)
-	if parsed.Scheme != "https" {
-		return parsed.Scheme == "http" && (parsed.Hostname() == "localhost" || parsed.Hostname() == "127.0.0.1")
+	// For confidential clients, require HTTPS (except loopback)
+	if pattern.scheme != "https" {
+		return pattern.scheme == "http" && pattern.loopback
 	}
 
 	return true
@@ -23,7 +23,7 @@ import (
 // Client authentication
 
 func (as *AuthService) authenticateClient(clientID, clientSecret string, r *http.Request) (*models.OAuthClient, error) {
-	client, err := as.getClientByID(clientID)
+	client, err := as.getClientByID(r.Context(), clientID)
 	if err != nil {
 		return nil, fmt.Errorf("client not found")
 	}
@@ -57,32 +57,6 @@ func (as *AuthService) authenticateClient(clientID, clientSecret string, r *http
 	return client, nil
 }
 
-// Authorization request handling
-
-func (as *AuthService) storeAuthorizationRequest(req models.AuthorizeRequest) string {
-	requestID := uuid.New().String()
-
-	// Store in Redis with 10 minute expiry
-	reqJSON, _ := json.Marshal(req)
-	as.redis.Set(context.Background(), fmt.Sprintf("auth_req:%s", requestID), reqJSON, time.Minute*10)
-
-	return requestID
-}
-
-func (as *AuthService) getAuthorizationRequest(requestID string) (*models.AuthorizeRequest, error) {
-	reqJSON, err := as.redis.Get(context.Background(), fmt.Sprintf("auth_req:%s", requestID)).Result()
-	if err != nil {
-		return nil, err
-	}
-
-	var req models.AuthorizeRequest
-	if err := json.Unmarshal([]byte(reqJSON), &req); err != nil {
-		return nil, err
-	}
-
-	return &req, nil
-}
-
 func (as *AuthService) getAuthenticatedUser(c *gin.Context) *uuid.UUID {
 	// Check if user_id is already set by middleware
 	if userID, exists := c.Get("user_id"); exists {
@@ -103,11 +77,9 @@ func (as *AuthService) getAuthenticatedUser(c *gin.Context) *uuid.UUID {
 	if authHeader != "" {
 		tokenString := extractBearerToken(authHeader)
 		if tokenString != "" {
-			if claims, err := as.jwt.ValidateToken(tokenString); err == nil {
-				if userID, err := uuid.Parse(claims.Subject); err == nil {
-					c.Set("user_id", userID)
-					return &userID
-				}
+			if _, userID, err := as.validateUserJWT(c.Request.Context(), tokenString, as.audience.firstParty()); err == nil {
+				c.Set("user_id", userID)
+				return &userID
 			}
 		}
 	}
@@ -119,10 +91,18 @@ func (as *AuthService) getAuthenticatedUser(c *gin.Context) *uuid.UUID {
 		}
 	}
 
+	if as.conformance != nil {
+		return as.conformance.autoLogin(c)
+	}
+
 	return nil
 }
 
 func (as *AuthService) getUserFromSession(sessionID string) *uuid.UUID {
+	if userID := as.tokenCache.getSession(sessionID); userID != nil {
+		return userID
+	}
+
 	// Get user ID from Redis session
 	userIDStr, err := as.redis.Get(context.Background(), fmt.Sprintf("session:%s", sessionID)).Result()
 	if err != nil {
@@ -134,33 +114,26 @@ func (as *AuthService) getUserFromSession(sessionID string) *uuid.UUID {
 		return nil
 	}
 
+	as.tokenCache.putSession(sessionID, userID)
 	return &userID
 }
 
 // Consent management
 
-func (as *AuthService) hasValidConsent(userID, clientID uuid.UUID, scopes []string) bool {
-	// Check if user has already consented to these scopes for this client
-	query := `
-		SELECT scopes FROM user_consents 
-		WHERE user_id = $1 AND client_id = $2 AND is_revoked = false 
-		AND (expires_at IS NULL OR expires_at > NOW())`
-
-	var consentedScopes []string
-	err := as.db.QueryRow(query, userID, clientID).Scan(pq.Array(&consentedScopes))
-	if err != nil {
-		return false
-	}
-
-	// Check if all requested scopes are covered by consent
-	return as.isScopeSubset(scopes, consentedScopes)
+func (as *AuthService) hasValidConsent(ctx context.Context, userID, clientID uuid.UUID, scopes []string) bool {
+	// The user must have granted or declined every requested scope for this client
+	grant := as.getConsentGrant(ctx, userID, clientID)
+	return grant != nil && grant.covers(scopes)
 }
 
 func (as *AuthService) showConsentScreen(c *gin.Context, client *models.OAuthClient, scopes []string, req models.AuthorizeRequest) {
-	// Build scope descriptions
+	// Build scope descriptions in the user's locale, falling back to the scope's own description
+	locale := as.requestLocale(c)
 	scopeDescriptions := make(map[string]string)
 	for _, scope := range scopes {
-		if scopeInfo, exists := models.AO3OAuthScopes[scope]; exists {
+		if description, ok := lookupMessage(locale, "consent.scope."+scope); ok {
+			scopeDescriptions[scope] = description
+		} else if scopeInfo, exists := models.AO3OAuthScopes[scope]; exists {
 			scopeDescriptions[scope] = scopeInfo.Description
 		}
 	}
@@ -180,7 +153,7 @@ func (as *AuthService) showConsentScreen(c *gin.Context, client *models.OAuthCli
 	// For test mode, automatically approve consent; in production, render HTML
 	if gin.Mode() == gin.TestMode {
 		// Auto-approve consent for testing
-		as.processConsent(c, consentID, true)
+		as.processConsent(c, consentID, consentDecision{Approved: true})
 		return
 	}
 
@@ -190,14 +163,18 @@ func (as *AuthService) showConsentScreen(c *gin.Context, client *models.OAuthCli
 		"consent_required": true,
 		"consent_id":       consentID,
 		"client_name":      client.Name,
+		"locale":           locale,
+		"message":          translate(locale, "consent.prompt", client.Name),
 		"scopes":           scopes,
 		"scope_descriptions": scopeDescriptions,
+		"withholdable_claims": withholdableClaimNames(),
+		"branding":            as.branding.resolve(c.Request.Context(), &client.ID),
 		"consent_url":      fmt.Sprintf("/auth/consent/%s", consentID),
 		"cancel_url":       req.RedirectURI + "?error=access_denied&state=" + req.State,
 	})
 }
 
-func (as *AuthService) processConsent(c *gin.Context, consentID string, approved bool) {
+func (as *AuthService) processConsent(c *gin.Context, consentID string, decision consentDecision) {
 	// Get consent data
 	consentJSON, err := as.redis.Get(context.Background(), fmt.Sprintf("consent:%s", consentID)).Result()
 	if err != nil {
@@ -225,19 +202,26 @@ func (as *AuthService) processConsent(c *gin.Context, consentID string, approved
 		return
 	}
 
-	if !approved {
+	req := consentData.AuthorizeRequest
+	if !decision.Approved {
 		// User denied consent
-		req := consentData.AuthorizeRequest
 		as.redirectWithError(c, req.RedirectURI, req.State, "access_denied", "User denied access")
 		return
 	}
 
+	// The user may approve only some of the requested scopes
+	granted, declined := decision.grantedScopes(scopes)
+	if len(granted) == 0 {
+		as.redirectWithError(c, req.RedirectURI, req.State, "access_denied", "User granted none of the requested scopes")
+		return
+	}
+
 	// Store consent
-	as.storeUserConsent(*userID, clientID, scopes)
+	as.storeUserConsent(c.Request.Context(), *userID, clientID, granted, declined, decision.WithheldClaims)
 
-	// Continue with authorization
-	req := consentData.AuthorizeRequest
-	code, err := as.generateAuthorizationCode(*userID, clientID, req)
+	// Continue with authorization; the code carries only the granted scopes
+	req.Scope = strings.Join(granted, " ")
+	code, err := as.generateAuthorizationCode(c.Request.Context(), *userID, clientID, req)
 	if err != nil {
 		as.redirectWithError(c, req.RedirectURI, req.State, "server_error", "Failed to generate code")
 		return
@@ -247,20 +231,9 @@ func (as *AuthService) processConsent(c *gin.Context, consentID string, approved
 	c.Redirect(http.StatusFound, callbackURL)
 }
 
-func (as *AuthService) storeUserConsent(userID, clientID uuid.UUID, scopes []string) error {
-	query := `
-		INSERT INTO user_consents (id, user_id, client_id, scopes, granted_at, is_revoked)
-		VALUES ($1, $2, $3, $4, $5, false)
-		ON CONFLICT (user_id, client_id) 
-		DO UPDATE SET scopes = $4, granted_at = $5, is_revoked = false`
-
-	_, err := as.db.Exec(query, uuid.New(), userID, clientID, pq.Array(scopes), time.Now())
-	return err
-}
-
 // Authorization code management
 
-func (as *AuthService) generateAuthorizationCode(userID, clientID uuid.UUID, req models.AuthorizeRequest) (string, error) {
+func (as *AuthService) generateAuthorizationCode(ctx context.Context, userID, clientID uuid.UUID, req models.AuthorizeRequest) (string, error) {
 	// Generate secure code
 	codeBytes := make([]byte, 32)
 	if _, err := rand.Read(codeBytes); err != nil {
@@ -289,8 +262,8 @@ func (as *AuthService) generateAuthorizationCode(userID, clientID uuid.UUID, req
 			code_challenge, code_challenge_method, expires_at, created_at
 		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 
-	_, err := as.db.Exec(query,
-		authCode.Code, authCode.ClientID, authCode.UserID, authCode.RedirectURI,
+	_, err := as.db.ExecContext(ctx, query,
+		hashStoredToken(authCode.Code), authCode.ClientID, authCode.UserID, authCode.RedirectURI,
 		pq.Array(authCode.Scopes), authCode.State, authCode.Nonce,
 		authCode.CodeChallenge, authCode.CodeChallengeMethod,
 		authCode.ExpiresAt, authCode.CreatedAt)
@@ -298,16 +271,16 @@ func (as *AuthService) generateAuthorizationCode(userID, clientID uuid.UUID, req
 	return code, err
 }
 
-func (as *AuthService) validateAuthorizationCode(code string, clientID uuid.UUID, redirectURI, codeVerifier string) (*models.AuthorizationCode, error) {
+func (as *AuthService) validateAuthorizationCode(ctx context.Context, code string, clientID uuid.UUID, redirectURI, codeVerifier string) (*models.AuthorizationCode, error) {
 	authCode := &models.AuthorizationCode{}
 
 	query := `
 		SELECT code, client_id, user_id, redirect_uri, scopes, state, nonce,
 			code_challenge, code_challenge_method, expires_at, used_at, created_at
 		FROM authorization_codes 
-		WHERE code = $1 AND client_id = $2 AND used_at IS NULL`
+		WHERE code = ANY($1) AND client_id = $2 AND used_at IS NULL`
 
-	err := as.db.QueryRow(query, code, clientID).Scan(
+	err := as.db.QueryRowContext(ctx, query, pq.Array(as.tokenStorage.lookupValues(code)), clientID).Scan(
 		&authCode.Code, &authCode.ClientID, &authCode.UserID, &authCode.RedirectURI,
 		pq.Array(&authCode.Scopes), &authCode.State, &authCode.Nonce,
 		&authCode.CodeChallenge, &authCode.CodeChallengeMethod,
@@ -327,7 +300,11 @@ func (as *AuthService) validateAuthorizationCode(code string, clientID uuid.UUID
 		return nil, fmt.Errorf("redirect URI mismatch")
 	}
 
-	// Validate PKCE if present
+	// Validate PKCE if present. A verifier for a code issued without a challenge is
+	// refused too, so an attacker cannot downgrade a PKCE flow by dropping the challenge.
+	if authCode.CodeChallenge == "" && codeVerifier != "" {
+		return nil, fmt.Errorf("code verifier sent for a code issued without PKCE")
+	}
 	if authCode.CodeChallenge != "" {
 		if codeVerifier == "" {
 			return nil, fmt.Errorf("code verifier required")
@@ -347,21 +324,24 @@ func (as *AuthService) validatePKCE(codeChallenge, codeChallengeMethod, codeVeri
 		hash := sha256.Sum256([]byte(codeVerifier))
 		computed := base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(hash[:])
 		return computed == codeChallenge
-	case "plain":
+	case "plain", "":
 		return codeVerifier == codeChallenge
 	default:
 		return false
 	}
 }
 
-func (as *AuthService) markCodeAsUsed(code string) {
+// markCodeAsUsed takes the code as stored, i.e. AuthorizationCode.Code from validateAuthorizationCode
+func (as *AuthService) markCodeAsUsed(ctx context.Context, code string) {
 	query := `UPDATE authorization_codes SET used_at = NOW() WHERE code = $1`
-	as.db.Exec(query, code)
+	as.db.ExecContext(ctx, query, code)
 }
 
 // Token management
 
-func (as *AuthService) generateTokens(userID, clientID uuid.UUID, scopes []string, ipAddress, userAgent string) (*models.OAuthAccessToken, *models.OAuthRefreshToken, error) {
+// generateTokens issues an access and refresh token pair; audience is the resource servers
+// the pair is bound to, empty for tokens without an audience
+func (as *AuthService) generateTokens(ctx context.Context, userID, clientID uuid.UUID, scopes, audience []string, ipAddress, userAgent string) (*models.OAuthAccessToken, *models.OAuthRefreshToken, error) {
 	// Generate access token
 	accessTokenStr, err := generateSecureToken()
 	if err != nil {
@@ -374,11 +354,18 @@ func (as *AuthService) generateTokens(userID, clientID uuid.UUID, scopes []strin
 	}
 
 	// Get client to determine TTL
-	client, err := as.getClientByID(clientID.String())
+	client, err := as.getClientByID(ctx, clientID.String())
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// Scopes restricted by moderation are left out of new grants
+	if as.moderation != nil {
+		if scopes, err = as.moderation.allowedScopes(ctx, userID, scopes); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	accessToken := &models.OAuthAccessToken{
 		ID:        uuid.New(),
 		Token:     accessTokenStr,
@@ -386,7 +373,7 @@ func (as *AuthService) generateTokens(userID, clientID uuid.UUID, scopes []strin
 		ClientID:  clientID,
 		Scopes:    scopes,
 		TokenType: "Bearer",
-		ExpiresAt: time.Now().Add(time.Duration(client.AccessTokenTTL) * time.Second),
+		ExpiresAt: time.Now().Add(as.oauth21.accessTokenTTL(client.AccessTokenTTL)),
 		IPAddress: ipAddress,
 		UserAgent: userAgent,
 		CreatedAt: time.Now(),
@@ -403,83 +390,123 @@ func (as *AuthService) generateTokens(userID, clientID uuid.UUID, scopes []strin
 		CreatedAt:     time.Now(),
 	}
 
+	if err := as.signAccessToken(ctx, accessToken, audience); err != nil {
+		return nil, nil, err
+	}
+
 	// Store tokens in database
-	if err := as.storeAccessToken(accessToken); err != nil {
+	if err := as.storeAccessToken(ctx, accessToken, audience); err != nil {
 		return nil, nil, err
 	}
 
-	if err := as.storeRefreshToken(refreshToken); err != nil {
+	if err := as.storeRefreshToken(ctx, refreshToken, audience); err != nil {
 		return nil, nil, err
 	}
 
 	return accessToken, refreshToken, nil
 }
 
-func (as *AuthService) storeAccessToken(token *models.OAuthAccessToken) error {
+func (as *AuthService) storeAccessToken(ctx context.Context, token *models.OAuthAccessToken, audience []string) error {
 	query := `
 		INSERT INTO oauth_access_tokens (
 			id, token, user_id, client_id, scopes, token_type, expires_at,
-			is_revoked, ip_address, user_agent, created_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, false, $8, $9, $10)`
+			is_revoked, ip_address, user_agent, created_at, audience
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, false, $8, $9, $10, $11)`
 
-	_, err := as.db.Exec(query,
-		token.ID, token.Token, token.UserID, token.ClientID, pq.Array(token.Scopes),
-		token.TokenType, token.ExpiresAt, token.IPAddress, token.UserAgent, token.CreatedAt)
+	_, err := as.db.ExecContext(ctx, query,
+		token.ID, hashStoredToken(token.Token), token.UserID, token.ClientID, pq.Array(token.Scopes),
+		token.TokenType, token.ExpiresAt, token.IPAddress, token.UserAgent, token.CreatedAt,
+		pq.Array(nonNilAudience(audience)))
 
 	return err
 }
 
-func (as *AuthService) storeRefreshToken(token *models.OAuthRefreshToken) error {
+func (as *AuthService) storeRefreshToken(ctx context.Context, token *models.OAuthRefreshToken, audience []string) error {
 	query := `
 		INSERT INTO oauth_refresh_tokens (
 			id, token, access_token_id, user_id, client_id, scopes, expires_at,
-			is_revoked, created_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, false, $8)`
+			is_revoked, created_at, audience
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, false, $8, $9)`
 
-	_, err := as.db.Exec(query,
-		token.ID, token.Token, token.AccessTokenID, token.UserID, token.ClientID,
-		pq.Array(token.Scopes), token.ExpiresAt, token.CreatedAt)
+	_, err := as.db.ExecContext(ctx, query,
+		token.ID, hashStoredToken(token.Token), token.AccessTokenID, token.UserID, token.ClientID,
+		pq.Array(token.Scopes), token.ExpiresAt, token.CreatedAt, pq.Array(nonNilAudience(audience)))
 
 	return err
 }
 
-func (as *AuthService) validateAccessToken(token string) (*models.OAuthAccessToken, error) {
+func (as *AuthService) validateAccessToken(ctx context.Context, token string) (*models.OAuthAccessToken, error) {
+	accessToken, _, err := as.validateAccessTokenScopes(ctx, token)
+	return accessToken, err
+}
+
+// validateAccessTokenScopes validates a token and returns its scopes as a bitmap, so
+// scope checks on hot paths are bit tests rather than scans of the scope list
+func (as *AuthService) validateAccessTokenScopes(ctx context.Context, token string) (*models.OAuthAccessToken, scopeSet, error) {
+	if cached, scopes := as.tokenCache.getTokenScopes(token); cached != nil {
+		tokenCacheLookups.WithLabelValues("local_hit").Inc()
+		return cached, scopes, nil
+	}
+	result := "miss"
+	var generation int64
+	if as.sharedTokens != nil {
+		cached, current, err := as.sharedTokens.get(ctx, token)
+		generation = current
+		switch {
+		case err != nil:
+			result = "redis_error"
+		case cached != nil:
+			tokenCacheLookups.WithLabelValues("redis_hit").Inc()
+			scopes := scopeBits.set(cached.Scopes)
+			as.tokenCache.putTokenScopes(token, cached, scopes)
+			return cached, scopes, nil
+		}
+	}
+	tokenCacheLookups.WithLabelValues(result).Inc()
+
 	accessToken := &models.OAuthAccessToken{}
 
 	query := `
 		SELECT id, token, user_id, client_id, scopes, token_type, expires_at,
 			is_revoked, last_used, ip_address, user_agent, created_at
 		FROM oauth_access_tokens 
-		WHERE token = $1 AND is_revoked = false`
+		WHERE token = ANY($1) AND is_revoked = false`
 
-	err := as.db.QueryRow(query, token).Scan(
+	err := as.hotQueries().QueryRowContext(ctx, query, pq.Array(as.tokenStorage.lookupValues(token))).Scan(
 		&accessToken.ID, &accessToken.Token, &accessToken.UserID, &accessToken.ClientID,
 		pq.Array(&accessToken.Scopes), &accessToken.TokenType, &accessToken.ExpiresAt,
 		&accessToken.IsRevoked, &accessToken.LastUsed, &accessToken.IPAddress,
 		&accessToken.UserAgent, &accessToken.CreatedAt)
 
 	if err != nil {
-		return nil, fmt.Errorf("invalid access token")
+		return nil, scopeSet{}, errAccessTokenInvalid
 	}
 
 	// Check expiry
 	if time.Now().After(accessToken.ExpiresAt) {
-		return nil, fmt.Errorf("access token expired")
+		return nil, scopeSet{}, errAccessTokenExpired
 	}
 
-	return accessToken, nil
+	scopes := scopeBits.set(accessToken.Scopes)
+	as.tokenCache.putTokenScopes(token, accessToken, scopes)
+	if as.sharedTokens != nil && result == "miss" {
+		// A failed write only costs other replicas a query; redis_error lookups show the outage.
+		// After a redis_error there is no generation to guard the write, so none is attempted.
+		_ = as.sharedTokens.put(ctx, token, accessToken, generation)
+	}
+	return accessToken, scopes, nil
 }
 
-func (as *AuthService) validateRefreshToken(token string, clientID uuid.UUID) (*models.OAuthRefreshToken, error) {
+func (as *AuthService) validateRefreshToken(ctx context.Context, token string, clientID uuid.UUID) (*models.OAuthRefreshToken, error) {
 	refreshToken := &models.OAuthRefreshToken{}
 
 	query := `
 		SELECT id, token, access_token_id, user_id, client_id, scopes, expires_at,
 			is_revoked, last_used, created_at
 		FROM oauth_refresh_tokens 
-		WHERE token = $1 AND client_id = $2 AND is_revoked = false`
+		WHERE token = ANY($1) AND client_id = $2 AND is_revoked = false`
 
-	err := as.db.QueryRow(query, token, clientID).Scan(
+	err := as.hotQueries().QueryRowContext(ctx, query, pq.Array(as.tokenStorage.lookupValues(token)), clientID).Scan(
 		&refreshToken.ID, &refreshToken.Token, &refreshToken.AccessTokenID,
 		&refreshToken.UserID, &refreshToken.ClientID, pq.Array(&refreshToken.Scopes),
 		&refreshToken.ExpiresAt, &refreshToken.IsRevoked, &refreshToken.LastUsed,
@@ -499,8 +526,8 @@ func (as *AuthService) validateRefreshToken(token string, clientID uuid.UUID) (*
 
 // OIDC ID Token generation
 
-func (as *AuthService) generateIDToken(userID, clientID uuid.UUID, nonce string, scopes []string) (string, error) {
-	user, err := as.getUserByID(userID)
+func (as *AuthService) generateIDToken(ctx context.Context, userID, clientID uuid.UUID, nonce string, scopes []string) (string, error) {
+	user, err := as.getUserByID(ctx, userID)
 	if err != nil {
 		return "", err
 	}
@@ -535,12 +562,12 @@ func (as *AuthService) generateIDToken(userID, clientID uuid.UUID, nonce string,
 		claims.AO3JoinDate = user.CreatedAt.Unix()
 
 		// Get user roles
-		if roles, err := as.getUserRoles(user.ID); err == nil {
+		if roles, err := as.getUserRoles(ctx, user.ID); err == nil {
 			claims.AO3Roles = roles
 		}
 
 		// Get user statistics
-		if stats, err := as.getUserStats(user.ID); err == nil {
+		if stats, err := as.getUserStats(ctx, user.ID); err == nil {
 			claims.AO3WorkCount = stats.WorkCount
 			claims.AO3BookmarkCount = stats.BookmarkCount
 		}
@@ -553,7 +580,7 @@ func (as *AuthService) generateIDToken(userID, clientID uuid.UUID, nonce string,
 	}
 
 	// Create and sign JWT
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+	tokenClaims := jwt.MapClaims{
 		"iss":                claims.Issuer,
 		"sub":                claims.Subject,
 		"aud":                claims.Audience,
@@ -573,21 +600,29 @@ func (as *AuthService) generateIDToken(userID, clientID uuid.UUID, nonce string,
 		"ao3_join_date":      claims.AO3JoinDate,
 		"ao3_work_count":     claims.AO3WorkCount,
 		"ao3_bookmark_count": claims.AO3BookmarkCount,
-	})
+	}
+	if contains(scopes, "profile") {
+		tokenClaims["locale"] = matchLocale(as.userLocale(ctx, userID))
+	}
+	as.withholdClaims(ctx, userID, clientID, tokenClaims)
+	as.applyClaimsPolicy(ctx, clientID, claimTargetIDToken, scopes, tokenClaims)
 
-	return token.SignedString(as.jwt.privateKey)
+	privateKey, keyID := as.jwt.signingKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims)
+	token.Header["kid"] = keyID
+	return token.SignedString(privateKey)
 }
 
 // Token revocation
 
-func (as *AuthService) revokeRefreshToken(tokenID uuid.UUID) {
+func (as *AuthService) revokeRefreshToken(ctx context.Context, tokenID uuid.UUID) {
 	query := `UPDATE oauth_refresh_tokens SET is_revoked = true, revoked_at = NOW() WHERE id = $1`
-	as.db.Exec(query, tokenID)
+	as.db.ExecContext(ctx, query, tokenID)
 }
 
-func (as *AuthService) revokeRefreshTokenByValue(token string) bool {
-	query := `UPDATE oauth_refresh_tokens SET is_revoked = true, revoked_at = NOW() WHERE token = $1`
-	result, err := as.db.Exec(query, token)
+func (as *AuthService) revokeRefreshTokenByValue(ctx context.Context, token string) bool {
+	query := `UPDATE oauth_refresh_tokens SET is_revoked = true, revoked_at = NOW() WHERE token = ANY($1)`
+	result, err := as.db.ExecContext(ctx, query, pq.Array(as.tokenStorage.lookupValues(token)))
 	if err != nil {
 		return false
 	}
@@ -596,27 +631,27 @@ func (as *AuthService) revokeRefreshTokenByValue(token string) bool {
 	return rowsAffected > 0
 }
 
-func (as *AuthService) revokeAccessTokenByValue(token string) bool {
-	query := `UPDATE oauth_access_tokens SET is_revoked = true, revoked_at = NOW() WHERE token = $1`
-	result, err := as.db.Exec(query, token)
-	if err != nil {
+func (as *AuthService) revokeAccessTokenByValue(ctx context.Context, token string) bool {
+	query := `UPDATE oauth_access_tokens SET is_revoked = true, revoked_at = NOW() WHERE token = ANY($1) RETURNING id`
+	var tokenID uuid.UUID
+	if err := as.db.QueryRowContext(ctx, query, pq.Array(as.tokenStorage.lookupValues(token))).Scan(&tokenID); err != nil {
 		return false
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	return rowsAffected > 0
+	as.publishRevocation(RevocationEvent{Type: revocationToken, ID: tokenID.String()})
+	return true
 }
 
 // Utility functions
 
-func (as *AuthService) updateTokenLastUsed(tokenID uuid.UUID) {
+func (as *AuthService) updateTokenLastUsed(ctx context.Context, tokenID uuid.UUID) {
 	query := `UPDATE oauth_access_tokens SET last_used = NOW() WHERE id = $1`
-	as.db.Exec(query, tokenID)
+	as.hotQueries().ExecContext(ctx, query, tokenID)
 }
 
-func (as *AuthService) getUserRoles(userID uuid.UUID) ([]string, error) {
+func (as *AuthService) getUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
 	query := `SELECT role FROM user_roles WHERE user_id = $1`
-	rows, err := as.db.Query(query, userID)
+	rows, err := as.hotQueries().QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -633,17 +668,3 @@ func (as *AuthService) getUserRoles(userID uuid.UUID) ([]string, error) {
 
 	return roles, nil
 }
-
-func (as *AuthService) getUserStats(userID uuid.UUID) (*UserStats, error) {
-	stats := &UserStats{}
-
-	// Get work count
-	workQuery := `SELECT COUNT(*) FROM works WHERE user_id = $1 AND status = 'posted'`
-	as.db.QueryRow(workQuery, userID).Scan(&stats.WorkCount)
-
-	// Get bookmark count
-	bookmarkQuery := `SELECT COUNT(*) FROM bookmarks WHERE user_id = $1`
-	as.db.QueryRow(bookmarkQuery, userID).Scan(&stats.BookmarkCount)
-
-	return stats, nil
-}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// OTP purposes; codes issued for one purpose are never accepted for another
+const (
+	otpPurposePhoneVerify   = "phone_verify"
+	otpPurposePasswordReset = "password_reset"
+	otpPurposeStepUp        = "step_up"
+)
+
+var (
+	errOTPInvalid         = errors.New("invalid or expired code")
+	errOTPTooManyAttempts = errors.New("too many incorrect codes")
+	errOTPRateLimited     = errors.New("too many codes requested")
+	errPhoneInvalid       = errors.New("phone number must be in international format, e.g. +14155550100")
+	errPhoneCountry       = errors.New("SMS delivery is not available for this country")
+)
+
+// otpRateLimitError is errOTPRateLimited with the limit that refused the code, so the
+// response can tell the client when to ask again
+type otpRateLimitError struct {
+	headers *RateLimitHeaders
+}
+
+func (e *otpRateLimitError) Error() string { return errOTPRateLimited.Error() }
+
+func (e *otpRateLimitError) Unwrap() error { return errOTPRateLimited }
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+// countryCallingCodes maps ISO 3166 country codes to E.164 prefixes for the allowlist
+var countryCallingCodes = map[string]string{
+	"US": "1", "CA": "1", "GB": "44", "IE": "353", "AU": "61", "NZ": "64",
+	"DE": "49", "FR": "33", "ES": "34", "IT": "39", "NL": "31", "BE": "32",
+	"SE": "46", "NO": "47", "DK": "45", "FI": "358", "PT": "351", "AT": "43",
+	"CH": "41", "PL": "48", "BR": "55", "MX": "52", "AR": "54", "IN": "91",
+	"JP": "81", "KR": "82", "SG": "65", "ZA": "27",
+}
+
+// OTPConfig controls one-time passcode delivery and verification
+type OTPConfig struct {
+	// AllowedCountries restricts SMS delivery to these ISO country codes; empty allows all
+	AllowedCountries []string
+	CodeTTL          time.Duration
+	MaxAttempts      int
+	// SendLimit caps codes per user and purpose within SendWindow
+	SendLimit  int
+	SendWindow time.Duration
+	// PhoneSendLimit caps codes to a single number per hour, across accounts
+	PhoneSendLimit int
+	// IPSendLimit caps codes requested from a single IP address per hour, across accounts
+	// and numbers, so one client cannot spread texts over many accounts
+	IPSendLimit int
+	StepUpTTL   time.Duration
+}
+
+// DefaultOTPConfig reads OTP settings from the environment
+func DefaultOTPConfig() OTPConfig {
+	var countries []string
+	for _, country := range strings.Split(getEnv("OTP_ALLOWED_COUNTRIES", ""), ",") {
+		if country = strings.ToUpper(strings.TrimSpace(country)); country != "" {
+			countries = append(countries, country)
+		}
+	}
+	maxAttempts, err := strconv.Atoi(getEnv("OTP_MAX_ATTEMPTS", "5"))
+	if err != nil || maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+
+	return OTPConfig{
+		AllowedCountries: countries,
+		CodeTTL:          10 * time.Minute,
+		MaxAttempts:      maxAttempts,
+		SendLimit:        3,
+		SendWindow:       15 * time.Minute,
+		PhoneSendLimit:   5,
+		IPSendLimit:      10,
+		StepUpTTL:        15 * time.Minute,
+	}
+}
+
+// OTPService issues and verifies one-time passcodes delivered over SMS
+type OTPService struct {
+	as      *AuthService
+	sender  OTPSender
+	config  OTPConfig
+	limiter *RateLimitManager
+}
+
+// NewOTPService creates a new OTP service
+func NewOTPService(as *AuthService, sender OTPSender, config OTPConfig) *OTPService {
+	return &OTPService{
+		as:      as,
+		sender:  sender,
+		config:  config,
+		limiter: &RateLimitManager{redisClient: as.redis, serviceName: "auth-service"},
+	}
+}
+
+type otpRecord struct {
+	CodeHash string `json:"code_hash"`
+	Phone    string `json:"phone"`
+}
+
+// normalizePhone strips formatting and enforces E.164 and the country allowlist
+func (s *OTPService) normalizePhone(phone string) (string, error) {
+	phone = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' || r == '(' || r == ')' || r == '.' {
+			return -1
+		}
+		return r
+	}, phone)
+	if !e164Pattern.MatchString(phone) {
+		return "", errPhoneInvalid
+	}
+
+	if len(s.config.AllowedCountries) == 0 {
+		return phone, nil
+	}
+	for _, country := range s.config.AllowedCountries {
+		if code, ok := countryCallingCodes[country]; ok && strings.HasPrefix(phone, "+"+code) {
+			return phone, nil
+		}
+	}
+	return "", errPhoneCountry
+}
+
+// send generates a code for (purpose, user), stores its hash and delivers it. ip is the
+// address the code was requested from.
+func (s *OTPService) send(ctx context.Context, purpose string, userID uuid.UUID, phone, ip string) error {
+	window := RateLimitConfig{Tier: "otp", Requests: s.config.SendLimit, Window: s.config.SendWindow}
+	if headers, err := s.limiter.checkLimitWithConfig(fmt.Sprintf("rate_limit:auth-service:otp:%s:%s", purpose, userID), window); err != nil {
+		return &otpRateLimitError{headers: headers}
+	}
+	perPhone := RateLimitConfig{Tier: "otp_phone", Requests: s.config.PhoneSendLimit, Window: time.Hour}
+	if headers, err := s.limiter.checkLimitWithConfig(fmt.Sprintf("rate_limit:auth-service:otp_phone:%s", phone), perPhone); err != nil {
+		return &otpRateLimitError{headers: headers}
+	}
+	perIP := RateLimitConfig{Tier: "otp_ip", Requests: s.config.IPSendLimit, Window: time.Hour}
+	if headers, err := s.limiter.checkLimitWithConfig(fmt.Sprintf("rate_limit:auth-service:otp_ip:%s", ip), perIP); err != nil {
+		return &otpRateLimitError{headers: headers}
+	}
+
+	code, err := generateOTPCode()
+	if err != nil {
+		return err
+	}
+
+	record, _ := json.Marshal(otpRecord{CodeHash: hashOTP(purpose, userID, code), Phone: phone})
+	pipe := s.as.redis.TxPipeline()
+	pipe.Set(ctx, otpKey(purpose, userID), record, s.config.CodeTTL)
+	pipe.Del(ctx, otpAttemptsKey(purpose, userID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store code: %w", err)
+	}
+
+	message := fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(s.config.CodeTTL.Minutes()))
+	if err := s.sender.Send(ctx, phone, message); err != nil {
+		s.as.redis.Del(ctx, otpKey(purpose, userID))
+		return fmt.Errorf("failed to deliver code via %s: %w", s.sender.Name(), err)
+	}
+	return nil
+}
+
+// verify checks a submitted code, consuming it on success and after too many failures
+func (s *OTPService) verify(ctx context.Context, purpose string, userID uuid.UUID, code string) (*otpRecord, error) {
+	recordJSON, err := s.as.redis.Get(ctx, otpKey(purpose, userID)).Result()
+	if err != nil {
+		return nil, errOTPInvalid
+	}
+
+	attempts, err := s.as.redis.Incr(ctx, otpAttemptsKey(purpose, userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	s.as.redis.Expire(ctx, otpAttemptsKey(purpose, userID), s.config.CodeTTL)
+	if attempts > int64(s.config.MaxAttempts) {
+		s.as.redis.Del(ctx, otpKey(purpose, userID), otpAttemptsKey(purpose, userID))
+		return nil, errOTPTooManyAttempts
+	}
+
+	var record otpRecord
+	if err := json.Unmarshal([]byte(recordJSON), &record); err != nil {
+		return nil, errOTPInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(hashOTP(purpose, userID, code)), []byte(record.CodeHash)) != 1 {
+		return nil, errOTPInvalid
+	}
+
+	s.as.redis.Del(ctx, otpKey(purpose, userID), otpAttemptsKey(purpose, userID))
+	return &record, nil
+}
+
+// verifiedPhone returns the user's verified number, or "" when there is none
+func (s *OTPService) verifiedPhone(ctx context.Context, userID uuid.UUID) string {
+	var phone string
+	err := s.as.db.QueryRowContext(ctx, `SELECT phone FROM user_phone_numbers WHERE user_id = $1 AND verified_at IS NOT NULL`, userID).Scan(s.as.pii.scan(piiPhone, &phone))
+	if err != nil {
+		return ""
+	}
+	return phone
+}
+
+// hasRecentStepUp reports whether the user passed an OTP challenge within StepUpTTL
+func (s *OTPService) hasRecentStepUp(userID uuid.UUID) bool {
+	exists, err := s.as.redis.Exists(context.Background(), fmt.Sprintf("step_up:%s", userID)).Result()
+	return err == nil && exists > 0
+}
+
+// RequireStepUpMiddleware demands a recent OTP step-up for sensitive operations.
+// Users without a verified phone are let through since they have no second factor to prove.
+func RequireStepUpMiddleware(otp *OTPService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := c.Get("user_id")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			c.Abort()
+			return
+		}
+		uid := userID.(uuid.UUID)
+		if otp.verifiedPhone(c.Request.Context(), uid) != "" && !otp.hasRecentStepUp(uid) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":             "step_up_required",
+				"error_description": "Confirm this action with a code sent to your phone",
+				"step_up_url":       "/api/v1/auth/step-up/otp",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// OTP endpoints
+
+// GetPhone returns the caller's phone number and verification state
+func (s *OTPService) GetPhone(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var phone string
+	var verifiedAt sql.NullTime
+	err := s.as.db.QueryRowContext(c.Request.Context(), `SELECT phone, verified_at FROM user_phone_numbers WHERE user_id = $1`, userID).Scan(s.as.pii.scan(piiPhone, &phone), &verifiedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No phone number on file"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch phone number"})
+		return
+	}
+
+	response := gin.H{"phone": maskPhone(phone), "verified": verifiedAt.Valid}
+	if verifiedAt.Valid {
+		response["verified_at"] = verifiedAt.Time
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SetPhone stores a new, unverified phone number and sends a verification code
+func (s *OTPService) SetPhone(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req struct {
+		Phone string `json:"phone" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	phone, err := s.normalizePhone(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_phone", "error_description": err.Error()})
+		return
+	}
+
+	_, err = s.as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO user_phone_numbers (user_id, phone, verified_at, created_at, updated_at)
+		VALUES ($1, $2, NULL, NOW(), NOW())
+		ON CONFLICT (user_id) DO UPDATE SET phone = $2, verified_at = NULL, updated_at = NOW()`, userID, s.as.pii.value(piiPhone, phone))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save phone number"})
+		return
+	}
+
+	if err := s.send(c.Request.Context(), otpPurposePhoneVerify, userID, phone, c.ClientIP()); err != nil {
+		s.otpError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"phone":      maskPhone(phone),
+		"verified":   false,
+		"expires_in": int(s.config.CodeTTL.Seconds()),
+	})
+}
+
+// VerifyPhone confirms a phone number with the code sent to it
+func (s *OTPService) VerifyPhone(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	record, err := s.verify(c.Request.Context(), otpPurposePhoneVerify, userID, req.Code)
+	if err != nil {
+		s.otpError(c, err)
+		return
+	}
+
+	// The number may have changed since the code was sent; only verify the one it went to.
+	// Stored numbers are encrypted with a random nonce, so compare after decrypting and
+	// update on the exact stored value.
+	var stored, phone string
+	err = s.as.db.QueryRowContext(c.Request.Context(), `SELECT phone FROM user_phone_numbers WHERE user_id = $1`, userID).Scan(&stored)
+	if err == sql.ErrNoRows {
+		s.otpError(c, errOTPInvalid)
+		return
+	}
+	if err == nil {
+		phone, err = s.as.pii.decrypt(piiPhone, stored)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify phone number"})
+		return
+	}
+	if phone != record.Phone {
+		s.otpError(c, errOTPInvalid)
+		return
+	}
+	result, err := s.as.db.ExecContext(c.Request.Context(), `UPDATE user_phone_numbers SET verified_at = NOW(), updated_at = NOW() WHERE user_id = $1 AND phone = $2`,
+		userID, stored)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify phone number"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		s.otpError(c, errOTPInvalid)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"phone": maskPhone(record.Phone), "verified": true})
+}
+
+// DeletePhone removes the caller's phone number
+func (s *OTPService) DeletePhone(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	if _, err := s.as.db.ExecContext(c.Request.Context(), `DELETE FROM user_phone_numbers WHERE user_id = $1`, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove phone number"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "phone number removed"})
+}
+
+// AdminResetMFA removes a locked-out user's second factor: their phone number, pending codes
+// and any step-up in progress. They can sign in with their password and enrol a new phone.
+func (s *OTPService) AdminResetMFA(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	result, err := s.as.db.ExecContext(ctx, `DELETE FROM user_phone_numbers WHERE user_id = $1`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset MFA"})
+		return
+	}
+	removed, _ := result.RowsAffected()
+	keys := []string{fmt.Sprintf("step_up:%s", userID)}
+	for _, purpose := range []string{otpPurposePhoneVerify, otpPurposePasswordReset, otpPurposeStepUp} {
+		keys = append(keys, otpKey(purpose, userID), otpAttemptsKey(purpose, userID))
+	}
+	s.as.redis.Del(ctx, keys...)
+
+	details := map[string]interface{}{"user_id": userID.String(), "phone_removed": removed > 0}
+	if req.Reason != "" {
+		details["reason"] = req.Reason
+	}
+	var adminID *uuid.UUID
+	if value, ok := c.Get("user_id"); ok {
+		if id, err := uuid.Parse(fmt.Sprint(value)); err == nil {
+			adminID = &id
+		}
+	}
+	s.as.recordSecurityEvent(c, adminID, securityEventMFAReset, adminAuditDetails(c, details))
+	// The user sees the reset among their own events too
+	s.as.recordSecurityEvent(c, &userID, securityEventMFAReset, map[string]interface{}{"by_admin": true})
+
+	c.JSON(http.StatusOK, gin.H{"message": "MFA reset", "phone_removed": removed > 0})
+}
+
+// RequestPasswordResetSMS texts a reset code to the account's verified phone.
+// The response is the same whether or not the account exists.
+func (s *OTPService) RequestPasswordResetSMS(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	var userID uuid.UUID
+	if err := s.as.db.QueryRowContext(c.Request.Context(), `SELECT id FROM users WHERE (email = $1 OR email_bidx = ANY($2)) AND is_active = true`,
+		req.Email, pq.Array(s.as.pii.lookupIndexes(piiUserEmail, req.Email))).Scan(&userID); err == nil {
+		if phone := s.verifiedPhone(c.Request.Context(), userID); phone != "" {
+			if err := s.send(c.Request.Context(), otpPurposePasswordReset, userID, phone, c.ClientIP()); err != nil {
+				log.Printf("Password reset SMS for user %s not sent: %v", userID, err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the account has a verified phone number, a code has been sent"})
+}
+
+// ConfirmPasswordResetSMS sets a new password after checking the texted code
+func (s *OTPService) ConfirmPasswordResetSMS(c *gin.Context) {
+	var req struct {
+		Email       string `json:"email" binding:"required"`
+		Code        string `json:"code" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required,min=8"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	var userID uuid.UUID
+	if err := s.as.db.QueryRowContext(c.Request.Context(), `SELECT id FROM users WHERE (email = $1 OR email_bidx = ANY($2)) AND is_active = true`,
+		req.Email, pq.Array(s.as.pii.lookupIndexes(piiUserEmail, req.Email))).Scan(&userID); err != nil {
+		s.otpError(c, errOTPInvalid)
+		return
+	}
+	if _, err := s.verify(c.Request.Context(), otpPurposePasswordReset, userID, req.Code); err != nil {
+		s.otpError(c, err)
+		return
+	}
+
+	hashedPassword, err := s.as.passwords.generate(c.Request.Context(), req.NewPassword)
+	if err == errPasswordHashOverloaded {
+		rejectOverloaded(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	epoch, err := s.as.setPassword(c.Request.Context(), userID, string(hashedPassword))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	s.as.signedOutEverywhere(userID, epoch)
+	s.as.recordSecurityEvent(c, &userID, securityEventPasswordReset, map[string]interface{}{"method": "sms"})
+
+	c.JSON(http.StatusOK, gin.H{"message": "password reset confirmed"})
+}
+
+// RequestStepUp texts a step-up code to the caller's verified phone
+func (s *OTPService) RequestStepUp(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	phone := s.verifiedPhone(c.Request.Context(), userID)
+	if phone == "" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":             "phone_not_verified",
+			"error_description": "Add and verify a phone number first",
+		})
+		return
+	}
+	if err := s.send(c.Request.Context(), otpPurposeStepUp, userID, phone, c.ClientIP()); err != nil {
+		s.otpError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"phone":      maskPhone(phone),
+		"expires_in": int(s.config.CodeTTL.Seconds()),
+	})
+}
+
+// VerifyStepUp records a successful step-up for StepUpTTL
+func (s *OTPService) VerifyStepUp(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	if _, err := s.verify(c.Request.Context(), otpPurposeStepUp, userID, req.Code); err != nil {
+		s.otpError(c, err)
+		return
+	}
+
+	until := time.Now().Add(s.config.StepUpTTL)
+	s.as.redis.Set(c.Request.Context(), fmt.Sprintf("step_up:%s", userID), until.Unix(), s.config.StepUpTTL)
+
+	c.JSON(http.StatusOK, gin.H{"step_up_until": until.Unix()})
+}
+
+func (s *OTPService) otpError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errOTPRateLimited):
+		var limited *otpRateLimitError
+		if errors.As(err, &limited) {
+			limited.headers.apply(c, true)
+		}
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate_limit_exceeded", "error_description": err.Error()})
+	case errors.Is(err, errOTPTooManyAttempts):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too_many_attempts", "error_description": "Request a new code"})
+	case errors.Is(err, errOTPInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_code", "error_description": err.Error()})
+	default:
+		log.Printf("OTP delivery failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "delivery_failed", "error_description": "Could not send the code, try again later"})
+	}
+}
+
+func otpKey(purpose string, userID uuid.UUID) string {
+	return fmt.Sprintf("otp:%s:%s", purpose, userID)
+}
+
+func otpAttemptsKey(purpose string, userID uuid.UUID) string {
+	return fmt.Sprintf("otp_attempts:%s:%s", purpose, userID)
+}
+
+// hashOTP binds a code to its purpose and user so a leaked hash cannot be reused elsewhere
+func hashOTP(purpose string, userID uuid.UUID, code string) string {
+	sum := sha256.Sum256([]byte(purpose + ":" + userID.String() + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateOTPCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// maskPhone keeps the country code and last two digits
+func maskPhone(phone string) string {
+	if len(phone) <= 5 {
+		return phone
+	}
+	return phone[:2] + strings.Repeat("•", len(phone)-4) + phone[len(phone)-2:]
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OTPSender delivers one-time passcodes to a phone number
+type OTPSender interface {
+	Name() string
+	Send(ctx context.Context, phone, message string) error
+}
+
+// NewOTPSenderFromEnv selects a delivery provider via OTP_PROVIDER (twilio, webhook or log).
+// OTP_FALLBACK_PROVIDER names a second provider that is tried when the first fails.
+func NewOTPSenderFromEnv() (OTPSender, error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	primary, err := newOTPSender(getEnv("OTP_PROVIDER", "log"), httpClient)
+	if err != nil {
+		return nil, err
+	}
+	fallbackProvider := getEnv("OTP_FALLBACK_PROVIDER", "")
+	if fallbackProvider == "" || fallbackProvider == primary.Name() {
+		return primary, nil
+	}
+	fallback, err := newOTPSender(fallbackProvider, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("fallback: %w", err)
+	}
+	return &FallbackSender{Primary: primary, Fallback: fallback}, nil
+}
+
+func newOTPSender(provider string, httpClient *http.Client) (OTPSender, error) {
+	switch provider {
+	case "twilio":
+		sender := &TwilioSender{
+			AccountSID: getEnv("TWILIO_ACCOUNT_SID", ""),
+			AuthToken:  getEnv("TWILIO_AUTH_TOKEN", ""),
+			From:       getEnv("TWILIO_FROM_NUMBER", ""),
+			BaseURL:    getEnv("TWILIO_API_URL", "https://api.twilio.com"),
+			httpClient: httpClient,
+		}
+		if sender.AccountSID == "" || sender.AuthToken == "" || sender.From == "" {
+			return nil, fmt.Errorf("twilio OTP provider requires TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM_NUMBER")
+		}
+		return sender, nil
+	case "webhook":
+		sender := &WebhookSender{
+			URL:        getEnv("OTP_WEBHOOK_URL", ""),
+			Secret:     getEnv("OTP_WEBHOOK_SECRET", ""),
+			httpClient: httpClient,
+		}
+		if sender.URL == "" {
+			return nil, fmt.Errorf("webhook OTP provider requires OTP_WEBHOOK_URL")
+		}
+		return sender, nil
+	case "log":
+		return &LogSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown OTP provider %q", provider)
+	}
+}
+
+// FallbackSender delivers through Fallback when Primary fails, so one provider's outage
+// does not lock users out of password resets and step-up
+type FallbackSender struct {
+	Primary  OTPSender
+	Fallback OTPSender
+}
+
+// Name returns both provider names
+func (s *FallbackSender) Name() string {
+	return s.Primary.Name() + "+" + s.Fallback.Name()
+}
+
+// Send tries the primary provider, then the fallback
+func (s *FallbackSender) Send(ctx context.Context, phone, message string) error {
+	err := s.Primary.Send(ctx, phone, message)
+	if err == nil {
+		return nil
+	}
+	log.Printf("OTP delivery via %s failed, falling back to %s: %v", s.Primary.Name(), s.Fallback.Name(), err)
+	if fallbackErr := s.Fallback.Send(ctx, phone, message); fallbackErr != nil {
+		return fmt.Errorf("%s: %v; %s: %w", s.Primary.Name(), err, s.Fallback.Name(), fallbackErr)
+	}
+	return nil
+}
+
+// TwilioSender sends SMS through the Twilio Messages API
+type TwilioSender struct {
+	AccountSID string
+	AuthToken  string
+	From       string
+	BaseURL    string
+	httpClient *http.Client
+}
+
+// Name returns the provider name
+func (s *TwilioSender) Name() string {
+	return "twilio"
+}
+
+// Send delivers an SMS
+func (s *TwilioSender) Send(ctx context.Context, phone, message string) error {
+	form := url.Values{
+		"To":   {phone},
+		"From": {s.From},
+		"Body": {message},
+	}
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimSuffix(s.BaseURL, "/"), s.AccountSID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.AccountSID, s.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach twilio: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("twilio returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// WebhookSender posts OTP messages to an operator-provided endpoint.
+// When a secret is set the body is signed in X-Signature-256 as sha256=<hex hmac>.
+type WebhookSender struct {
+	URL        string
+	Secret     string
+	httpClient *http.Client
+}
+
+// Name returns the provider name
+func (s *WebhookSender) Name() string {
+	return "webhook"
+}
+
+// Send delivers the message payload to the webhook
+func (s *WebhookSender) Send(ctx context.Context, phone, message string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"to":        phone,
+		"message":   message,
+		"timestamp": time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach OTP webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTP webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// LogSender writes messages to the service log for local development
+type LogSender struct{}
+
+// Name returns the provider name
+func (s *LogSender) Name() string {
+	return "log"
+}
+
+// Send logs the message instead of delivering it
+func (s *LogSender) Send(ctx context.Context, phone, message string) error {
+	log.Printf("OTP for %s: %s", phone, message)
+	return nil
+}
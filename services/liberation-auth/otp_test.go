@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+// recordingSender keeps the messages it is asked to deliver, or fails with err
+type recordingSender struct {
+	name     string
+	err      error
+	messages []string
+}
+
+func (s *recordingSender) Name() string { return s.name }
+
+func (s *recordingSender) Send(ctx context.Context, phone, message string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+var otpCodePattern = regexp.MustCompile(`\d{6}`)
+
+type OTPTestSuite struct {
+	suite.Suite
+	server *miniredis.Miniredis
+	redis  *redis.Client
+	sender *recordingSender
+	otp    *OTPService
+}
+
+func (suite *OTPTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.server = miniredis.RunT(suite.T())
+	suite.redis = redis.NewClient(&redis.Options{Addr: suite.server.Addr()})
+	suite.sender = &recordingSender{name: "test"}
+	suite.otp = NewOTPService(&AuthService{redis: suite.redis}, suite.sender, OTPConfig{
+		CodeTTL: 10 * time.Minute, MaxAttempts: 3,
+		SendLimit: 2, SendWindow: 15 * time.Minute, PhoneSendLimit: 3, IPSendLimit: 4,
+		StepUpTTL: 15 * time.Minute,
+	})
+}
+
+func (suite *OTPTestSuite) TearDownTest() {
+	suite.redis.Close()
+}
+
+// lastCode is the code in the last message delivered
+func (suite *OTPTestSuite) lastCode() string {
+	suite.Require().NotEmpty(suite.sender.messages)
+	return otpCodePattern.FindString(suite.sender.messages[len(suite.sender.messages)-1])
+}
+
+func (suite *OTPTestSuite) TestCodeIsAcceptedOnceForItsPurpose() {
+	ctx := context.Background()
+	userID := uuid.New()
+	suite.Require().NoError(suite.otp.send(ctx, otpPurposeStepUp, userID, "+14155550100", "192.0.2.1"))
+	code := suite.lastCode()
+
+	_, err := suite.otp.verify(ctx, otpPurposePasswordReset, userID, code)
+	suite.ErrorIs(err, errOTPInvalid, "a step-up code must not reset a password")
+	_, err = suite.otp.verify(ctx, otpPurposeStepUp, uuid.New(), code)
+	suite.ErrorIs(err, errOTPInvalid, "codes belong to one user")
+
+	record, err := suite.otp.verify(ctx, otpPurposeStepUp, userID, code)
+	suite.Require().NoError(err)
+	suite.Equal("+14155550100", record.Phone)
+	_, err = suite.otp.verify(ctx, otpPurposeStepUp, userID, code)
+	suite.ErrorIs(err, errOTPInvalid)
+}
+
+func (suite *OTPTestSuite) TestCodesExpire() {
+	ctx := context.Background()
+	userID := uuid.New()
+	suite.Require().NoError(suite.otp.send(ctx, otpPurposeStepUp, userID, "+14155550100", "192.0.2.1"))
+	code := suite.lastCode()
+
+	suite.server.FastForward(10*time.Minute + time.Second)
+	_, err := suite.otp.verify(ctx, otpPurposeStepUp, userID, code)
+	suite.ErrorIs(err, errOTPInvalid)
+}
+
+func (suite *OTPTestSuite) TestTooManyWrongCodesLockTheCodeOut() {
+	ctx := context.Background()
+	userID := uuid.New()
+	suite.Require().NoError(suite.otp.send(ctx, otpPurposeStepUp, userID, "+14155550100", "192.0.2.1"))
+	code := suite.lastCode()
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := suite.otp.verify(ctx, otpPurposeStepUp, userID, wrong)
+		suite.ErrorIs(err, errOTPInvalid)
+	}
+	_, err := suite.otp.verify(ctx, otpPurposeStepUp, userID, code)
+	suite.ErrorIs(err, errOTPTooManyAttempts, "the right code is refused once the attempts are used up")
+	_, err = suite.otp.verify(ctx, otpPurposeStepUp, userID, code)
+	suite.ErrorIs(err, errOTPInvalid, "the locked code is gone")
+
+	// A new code starts a new count
+	suite.Require().NoError(suite.otp.send(ctx, otpPurposeStepUp, userID, "+14155550100", "192.0.2.1"))
+	_, err = suite.otp.verify(ctx, otpPurposeStepUp, userID, suite.lastCode())
+	suite.NoError(err)
+}
+
+func (suite *OTPTestSuite) TestSendLimits() {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name  string
+		sends func() error
+	}{
+		{"per user and purpose", func() error {
+			userID := uuid.New()
+			for i := 0; i < 2; i++ {
+				if err := suite.otp.send(ctx, otpPurposeStepUp, userID, "+14155550101", "192.0.2.10"); err != nil {
+					return err
+				}
+			}
+			// Another purpose has its own allowance
+			if err := suite.otp.send(ctx, otpPurposePhoneVerify, userID, "+14155550101", "192.0.2.10"); err != nil {
+				return err
+			}
+			return suite.otp.send(ctx, otpPurposeStepUp, userID, "+14155550101", "192.0.2.10")
+		}},
+		{"per destination across accounts", func() error {
+			for i := 0; i < 3; i++ {
+				if err := suite.otp.send(ctx, otpPurposePasswordReset, uuid.New(), "+14155550102", "192.0.2.20"); err != nil {
+					return err
+				}
+			}
+			return suite.otp.send(ctx, otpPurposePasswordReset, uuid.New(), "+14155550102", "192.0.2.21")
+		}},
+		{"per IP across accounts and numbers", func() error {
+			for _, phone := range []string{"+14155550103", "+14155550104", "+14155550105", "+14155550106"} {
+				if err := suite.otp.send(ctx, otpPurposePasswordReset, uuid.New(), phone, "192.0.2.30"); err != nil {
+					return err
+				}
+			}
+			return suite.otp.send(ctx, otpPurposePasswordReset, uuid.New(), "+14155550107", "192.0.2.30")
+		}},
+	} {
+		err := tc.sends()
+		suite.ErrorIs(err, errOTPRateLimited, tc.name)
+
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		suite.otp.otpError(c, err)
+		suite.Equal(http.StatusTooManyRequests, recorder.Code, tc.name)
+		suite.NotEmpty(recorder.Header().Get("Retry-After"), tc.name)
+	}
+}
+
+func (suite *OTPTestSuite) TestFailedDeliveryLeavesNoCode() {
+	ctx := context.Background()
+	userID := uuid.New()
+	suite.sender.err = errors.New("provider down")
+	err := suite.otp.send(ctx, otpPurposeStepUp, userID, "+14155550100", "192.0.2.1")
+	suite.ErrorContains(err, "via test")
+	suite.Equal(int64(0), suite.redis.Exists(ctx, otpKey(otpPurposeStepUp, userID)).Val())
+}
+
+func (suite *OTPTestSuite) TestCountryAllowlist() {
+	suite.otp.config.AllowedCountries = []string{"GB", "IE"}
+	for _, tc := range []struct {
+		phone string
+		want  string
+		err   error
+	}{
+		{"+44 20 7946 0958", "+442079460958", nil},
+		{"+353 (1) 555-0100", "+35315550100", nil},
+		{"+14155550100", "", errPhoneCountry},
+		{"020 7946 0958", "", errPhoneInvalid},
+		{"+0123456789", "", errPhoneInvalid},
+	} {
+		phone, err := suite.otp.normalizePhone(tc.phone)
+		suite.Equal(tc.want, phone, tc.phone)
+		suite.ErrorIs(err, tc.err, tc.phone)
+	}
+
+	suite.otp.config.AllowedCountries = nil
+	phone, err := suite.otp.normalizePhone("+14155550100")
+	suite.NoError(err, "an empty allowlist allows every country")
+	suite.Equal("+14155550100", phone)
+}
+
+func TestOTPTestSuite(t *testing.T) {
+	suite.Run(t, new(OTPTestSuite))
+}
+
+func TestOTPProviders(t *testing.T) {
+	var got struct {
+		path, user, password, signature string
+		form                            map[string][]string
+		body                            []byte
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.path = r.URL.Path
+		got.user, got.password, _ = r.BasicAuth()
+		got.signature = r.Header.Get("X-Signature-256")
+		if r.Header.Get("Content-Type") == "application/json" {
+			got.body, _ = io.ReadAll(r.Body)
+		} else {
+			r.ParseForm()
+			got.form = r.PostForm
+		}
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	twilio := &TwilioSender{AccountSID: "AC123", AuthToken: "token", From: "+15005550006", BaseURL: server.URL + "/", httpClient: server.Client()}
+	if err := twilio.Send(ctx, "+14155550100", "code 123456"); err != nil {
+		t.Fatal(err)
+	}
+	if got.path != "/2010-04-01/Accounts/AC123/Messages.json" || got.user != "AC123" || got.password != "token" {
+		t.Errorf("twilio request to %s as %s", got.path, got.user)
+	}
+	if got.form["To"][0] != "+14155550100" || got.form["From"][0] != "+15005550006" || got.form["Body"][0] != "code 123456" {
+		t.Errorf("twilio form %v", got.form)
+	}
+
+	webhook := &WebhookSender{URL: server.URL + "/otp", Secret: "s3cret", httpClient: server.Client()}
+	if err := webhook.Send(ctx, "+14155550100", "code 123456"); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(got.body)
+	if got.signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("webhook signature %q does not match the body", got.signature)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(got.body, &payload); err != nil || payload["to"] != "+14155550100" || payload["message"] != "code 123456" {
+		t.Errorf("webhook payload %s", got.body)
+	}
+
+	failing := &WebhookSender{URL: server.URL + "/otp?fail=1", httpClient: server.Client()}
+	if err := failing.Send(ctx, "+14155550100", "code 123456"); err == nil {
+		t.Error("a 503 from the webhook should fail delivery")
+	}
+
+	fallback := &recordingSender{name: "backup"}
+	sender := &FallbackSender{Primary: failing, Fallback: fallback}
+	if err := sender.Send(ctx, "+14155550100", "code 123456"); err != nil || len(fallback.messages) != 1 {
+		t.Errorf("fallback delivered %d messages: %v", len(fallback.messages), err)
+	}
+	sender = &FallbackSender{Primary: webhook, Fallback: fallback}
+	if err := sender.Send(ctx, "+14155550100", "code 654321"); err != nil || len(fallback.messages) != 1 {
+		t.Errorf("the fallback was used although the primary delivered: %v", err)
+	}
+	sender = &FallbackSender{Primary: failing, Fallback: &recordingSender{name: "backup", err: errors.New("down")}}
+	if err := sender.Send(ctx, "+14155550100", "code 123456"); err == nil {
+		t.Error("delivery succeeded with both providers down")
+	}
+}
+
+func TestOTPSenderFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		env  map[string]string
+		name string
+		err  bool
+	}{
+		{map[string]string{}, "log", false},
+		{map[string]string{"OTP_PROVIDER": "webhook", "OTP_WEBHOOK_URL": "https://sms.example"}, "webhook", false},
+		{map[string]string{"OTP_PROVIDER": "webhook", "OTP_WEBHOOK_URL": "https://sms.example", "OTP_FALLBACK_PROVIDER": "log"}, "webhook+log", false},
+		{map[string]string{"OTP_PROVIDER": "log", "OTP_FALLBACK_PROVIDER": "log"}, "log", false},
+		{map[string]string{"OTP_PROVIDER": "twilio"}, "", true},
+		{map[string]string{"OTP_PROVIDER": "log", "OTP_FALLBACK_PROVIDER": "carrier-pigeon"}, "", true},
+	} {
+		for _, key := range []string{"OTP_PROVIDER", "OTP_FALLBACK_PROVIDER", "OTP_WEBHOOK_URL", "TWILIO_ACCOUNT_SID"} {
+			t.Setenv(key, tc.env[key])
+		}
+		sender, err := NewOTPSenderFromEnv()
+		if (err != nil) != tc.err {
+			t.Errorf("%v: error %v", tc.env, err)
+			continue
+		}
+		if err == nil && sender.Name() != tc.name {
+			t.Errorf("%v: provider %s, want %s", tc.env, sender.Name(), tc.name)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errPasswordHashOverloaded means a password hash was refused to keep within the latency budget
+var errPasswordHashOverloaded = errors.New("password hashing is over its latency budget")
+
+var (
+	passwordHashQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "liberation_auth_password_hash_queue_depth",
+		Help: "Password hashes waiting for a worker",
+	})
+	passwordHashInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "liberation_auth_password_hash_in_flight",
+		Help: "Password hashes being computed",
+	})
+	passwordHashDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "liberation_auth_password_hash_duration_seconds",
+		Help:    "Time spent computing password hashes, excluding the queue",
+		Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+	passwordHashShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "liberation_auth_password_hash_shed_total",
+		Help: "Password hashes refused with 429 to protect the latency budget, by reason",
+	}, []string{"reason"})
+)
+
+// PasswordHashConfig bounds the CPU spent on password hashing
+type PasswordHashConfig struct {
+	// Workers is how many hashes run at once; it defaults to the number of CPUs
+	Workers int
+	// QueueSize is how many hashes may wait for a worker before new ones are refused
+	QueueSize int
+	// LatencyBudget is the longest a login may spend queueing and hashing. Requests that
+	// would exceed it are refused up front. Zero disables the budget.
+	LatencyBudget time.Duration
+	// Cost is the bcrypt cost of new hashes when calibration is off
+	Cost int
+	// TargetLatency turns on calibration: at startup the highest cost between MinCost and
+	// MaxCost that hashes within it on this host is chosen and persisted. Zero uses Cost.
+	TargetLatency    time.Duration
+	MinCost, MaxCost int
+	// Recalibrate benchmarks again even though a cost was persisted for the same target
+	Recalibrate bool
+}
+
+// DefaultPasswordHashConfig reads PASSWORD_HASH_WORKERS, PASSWORD_HASH_QUEUE,
+// PASSWORD_HASH_LATENCY_BUDGET, PASSWORD_HASH_COST, PASSWORD_HASH_TARGET_LATENCY,
+// PASSWORD_HASH_MIN_COST, PASSWORD_HASH_MAX_COST and PASSWORD_HASH_RECALIBRATE from the environment
+func DefaultPasswordHashConfig() (PasswordHashConfig, error) {
+	config := PasswordHashConfig{Recalibrate: getEnv("PASSWORD_HASH_RECALIBRATE", "false") == "true"}
+
+	var err error
+	if config.Workers, err = strconv.Atoi(getEnv("PASSWORD_HASH_WORKERS", strconv.Itoa(runtime.NumCPU()))); err != nil || config.Workers < 1 {
+		return config, fmt.Errorf("PASSWORD_HASH_WORKERS must be a positive integer")
+	}
+	if config.QueueSize, err = strconv.Atoi(getEnv("PASSWORD_HASH_QUEUE", strconv.Itoa(config.Workers*16))); err != nil || config.QueueSize < 0 {
+		return config, fmt.Errorf("PASSWORD_HASH_QUEUE must be a non-negative integer")
+	}
+	if config.LatencyBudget, err = time.ParseDuration(getEnv("PASSWORD_HASH_LATENCY_BUDGET", "2s")); err != nil || config.LatencyBudget < 0 {
+		return config, fmt.Errorf("PASSWORD_HASH_LATENCY_BUDGET must be a non-negative duration")
+	}
+	if config.Cost, err = strconv.Atoi(getEnv("PASSWORD_HASH_COST", strconv.Itoa(bcrypt.DefaultCost))); err != nil || config.Cost < bcrypt.MinCost || config.Cost > bcrypt.MaxCost {
+		return config, fmt.Errorf("PASSWORD_HASH_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if config.TargetLatency, err = time.ParseDuration(getEnv("PASSWORD_HASH_TARGET_LATENCY", "0s")); err != nil || config.TargetLatency < 0 {
+		return config, fmt.Errorf("PASSWORD_HASH_TARGET_LATENCY must be a non-negative duration")
+	}
+	if config.MinCost, err = strconv.Atoi(getEnv("PASSWORD_HASH_MIN_COST", strconv.Itoa(bcrypt.DefaultCost))); err != nil || config.MinCost < bcrypt.MinCost {
+		return config, fmt.Errorf("PASSWORD_HASH_MIN_COST must be at least %d", bcrypt.MinCost)
+	}
+	if config.MaxCost, err = strconv.Atoi(getEnv("PASSWORD_HASH_MAX_COST", "14")); err != nil || config.MaxCost < config.MinCost || config.MaxCost > bcrypt.MaxCost {
+		return config, fmt.Errorf("PASSWORD_HASH_MAX_COST must be between PASSWORD_HASH_MIN_COST and %d", bcrypt.MaxCost)
+	}
+	if config.LatencyBudget > 0 && config.TargetLatency > config.LatencyBudget {
+		return config, fmt.Errorf("PASSWORD_HASH_TARGET_LATENCY must not exceed PASSWORD_HASH_LATENCY_BUDGET")
+	}
+	return config, nil
+}
+
+// passwordHasher runs bcrypt on a bounded pool of workers. A nil hasher hashes inline.
+type passwordHasher struct {
+	config PasswordHashConfig
+	slots  chan struct{}
+	// pending counts hashes waiting or running
+	pending atomic.Int64
+	// average is a moving average of hash durations in nanoseconds, used to predict queue wait
+	average atomic.Int64
+	// parameters are what new hashes are made with, set once at startup
+	parameters passwordHashParameters
+}
+
+func newPasswordHasher(config PasswordHashConfig) *passwordHasher {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	return &passwordHasher{
+		config:     config,
+		slots:      make(chan struct{}, config.Workers),
+		parameters: configuredPasswordHashParameters(config),
+	}
+}
+
+// cost is the bcrypt cost of new hashes
+func (h *passwordHasher) cost() int {
+	if h == nil || h.parameters.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.parameters.Cost
+}
+
+// predictedLatency estimates how long a hash joining the queue behind ahead others will take
+func (h *passwordHasher) predictedLatency(ahead int64) time.Duration {
+	rounds := ahead/int64(h.config.Workers) + 1
+	return time.Duration(rounds * h.average.Load())
+}
+
+// run executes fn on a worker, or refuses with errPasswordHashOverloaded when the queue is
+// full or the wait would break the latency budget
+func (h *passwordHasher) run(ctx context.Context, operation string, fn func()) error {
+	if h == nil {
+		fn()
+		return nil
+	}
+
+	ahead := h.pending.Add(1) - 1
+	defer h.pending.Add(-1)
+
+	if ahead >= int64(h.config.Workers+h.config.QueueSize) {
+		passwordHashShed.WithLabelValues("queue_full").Inc()
+		return errPasswordHashOverloaded
+	}
+	budget := h.config.LatencyBudget
+	if budget > 0 && h.predictedLatency(ahead) > budget {
+		passwordHashShed.WithLabelValues("budget").Inc()
+		return errPasswordHashOverloaded
+	}
+
+	passwordHashQueueDepth.Inc()
+	var deadline <-chan time.Time
+	if budget > 0 {
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	select {
+	case h.slots <- struct{}{}:
+		passwordHashQueueDepth.Dec()
+	case <-deadline:
+		passwordHashQueueDepth.Dec()
+		passwordHashShed.WithLabelValues("budget").Inc()
+		return errPasswordHashOverloaded
+	case <-ctx.Done():
+		passwordHashQueueDepth.Dec()
+		passwordHashShed.WithLabelValues("canceled").Inc()
+		return ctx.Err()
+	}
+	defer func() { <-h.slots }()
+
+	passwordHashInFlight.Inc()
+	start := time.Now()
+	fn()
+	elapsed := time.Since(start)
+	passwordHashInFlight.Dec()
+	passwordHashDuration.WithLabelValues(operation).Observe(elapsed.Seconds())
+
+	// Weight new samples by 1/8 so a single slow hash does not trigger shedding
+	if previous := h.average.Load(); previous == 0 {
+		h.average.Store(int64(elapsed))
+	} else {
+		h.average.Store(previous + (int64(elapsed)-previous)/8)
+	}
+	return nil
+}
+
+// compare checks a password against its bcrypt hash on the worker pool
+func (h *passwordHasher) compare(ctx context.Context, hash, password string) error {
+	var compareErr error
+	if err := h.run(ctx, "compare", func() {
+		compareErr = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}); err != nil {
+		return err
+	}
+	return compareErr
+}
+
+// generate hashes a new password at the configured or calibrated cost on the worker pool
+func (h *passwordHasher) generate(ctx context.Context, password string) ([]byte, error) {
+	var hash []byte
+	var generateErr error
+	cost := h.cost()
+	if err := h.run(ctx, "generate", func() {
+		hash, generateErr = bcrypt.GenerateFromPassword([]byte(password), cost)
+	}); err != nil {
+		return nil, err
+	}
+	return hash, generateErr
+}
+
+// rejectOverloaded answers a request whose password hash was shed
+func rejectOverloaded(c *gin.Context) {
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":             "temporarily_unavailable",
+		"error_description": "Too many sign-ins are being processed; retry shortly",
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type PasswordHashingTestSuite struct {
+	suite.Suite
+}
+
+func (suite *PasswordHashingTestSuite) TestCompareAndGenerate() {
+	hasher := newPasswordHasher(PasswordHashConfig{Workers: 2, QueueSize: 4, LatencyBudget: time.Minute})
+	hash, err := hasher.generate(context.Background(), "correct horse")
+	suite.Require().NoError(err)
+
+	suite.NoError(hasher.compare(context.Background(), string(hash), "correct horse"))
+	suite.ErrorIs(hasher.compare(context.Background(), string(hash), "wrong"), bcrypt.ErrMismatchedHashAndPassword)
+}
+
+func (suite *PasswordHashingTestSuite) TestNilHasherRunsInline() {
+	var hasher *passwordHasher
+	ran := false
+	suite.NoError(hasher.run(context.Background(), "compare", func() { ran = true }))
+	suite.True(ran)
+}
+
+func (suite *PasswordHashingTestSuite) TestFullQueueIsShed() {
+	hasher := newPasswordHasher(PasswordHashConfig{Workers: 1, QueueSize: 0})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		hasher.run(context.Background(), "compare", func() {
+			close(started)
+			<-release
+		})
+	}()
+	<-started
+
+	suite.ErrorIs(hasher.run(context.Background(), "compare", func() {}), errPasswordHashOverloaded)
+	close(release)
+	wg.Wait()
+	suite.NoError(hasher.run(context.Background(), "compare", func() {}))
+}
+
+func (suite *PasswordHashingTestSuite) TestPredictedLatencyIsShed() {
+	hasher := newPasswordHasher(PasswordHashConfig{Workers: 2, QueueSize: 100, LatencyBudget: time.Second})
+	hasher.average.Store(int64(400 * time.Millisecond))
+
+	suite.Equal(400*time.Millisecond, hasher.predictedLatency(1))
+	suite.Equal(1200*time.Millisecond, hasher.predictedLatency(4))
+
+	hasher.pending.Store(4)
+	suite.ErrorIs(hasher.run(context.Background(), "compare", func() {}), errPasswordHashOverloaded)
+}
+
+func (suite *PasswordHashingTestSuite) TestWaitBeyondBudgetIsShed() {
+	hasher := newPasswordHasher(PasswordHashConfig{Workers: 1, QueueSize: 1, LatencyBudget: 20 * time.Millisecond})
+	hasher.slots <- struct{}{}
+	defer func() { <-hasher.slots }()
+
+	suite.ErrorIs(hasher.run(context.Background(), "compare", func() {}), errPasswordHashOverloaded)
+	suite.Zero(hasher.pending.Load())
+}
+
+func (suite *PasswordHashingTestSuite) TestCalibrationPicksHighestCostWithinTarget() {
+	// Each cost doubles the work, from 20ms at cost 10
+	measured := []int{}
+	measure := func(cost int) time.Duration {
+		measured = append(measured, cost)
+		return 20 * time.Millisecond << (cost - 10)
+	}
+
+	cost, elapsed := calibrateBcryptCost(150*time.Millisecond, 10, 14, measure)
+	suite.Equal(12, cost)
+	suite.Equal(80*time.Millisecond, elapsed)
+	suite.Equal([]int{10, 11, 12, 13}, measured, "stops at the first cost over target")
+
+	cost, _ = calibrateBcryptCost(time.Second, 10, 11, measure)
+	suite.Equal(11, cost, "never above the maximum")
+
+	cost, elapsed = calibrateBcryptCost(5*time.Millisecond, 10, 14, measure)
+	suite.Equal(10, cost, "never below the minimum")
+	suite.Equal(20*time.Millisecond, elapsed)
+}
+
+func (suite *PasswordHashingTestSuite) TestGenerateUsesChosenCost() {
+	hasher := newPasswordHasher(PasswordHashConfig{Workers: 1, Cost: bcrypt.MinCost})
+	hash, err := hasher.generate(context.Background(), "correct horse")
+	suite.Require().NoError(err)
+	cost, err := bcrypt.Cost(hash)
+	suite.Require().NoError(err)
+	suite.Equal(bcrypt.MinCost, cost)
+	suite.Equal(passwordHashSourceConfigured, hasher.parameters.Source)
+
+	var inline *passwordHasher
+	suite.Equal(bcrypt.DefaultCost, inline.cost())
+}
+
+func (suite *PasswordHashingTestSuite) TestCalibrationSettings() {
+	config, err := DefaultPasswordHashConfig()
+	suite.Require().NoError(err)
+	suite.Equal(bcrypt.DefaultCost, config.Cost)
+	suite.Zero(config.TargetLatency, "calibration is opt-in")
+	suite.Equal(bcrypt.DefaultCost, config.MinCost)
+	suite.Equal(14, config.MaxCost)
+
+	suite.T().Setenv("PASSWORD_HASH_TARGET_LATENCY", "150ms")
+	config, err = DefaultPasswordHashConfig()
+	suite.Require().NoError(err)
+	suite.Equal(150*time.Millisecond, config.TargetLatency)
+
+	suite.T().Setenv("PASSWORD_HASH_MAX_COST", "9")
+	_, err = DefaultPasswordHashConfig()
+	suite.ErrorContains(err, "PASSWORD_HASH_MAX_COST")
+
+	suite.T().Setenv("PASSWORD_HASH_MAX_COST", "14")
+	suite.T().Setenv("PASSWORD_HASH_TARGET_LATENCY", "5s")
+	_, err = DefaultPasswordHashConfig()
+	suite.ErrorContains(err, "PASSWORD_HASH_LATENCY_BUDGET")
+}
+
+func TestPasswordHashing(t *testing.T) {
+	suite.Run(t, new(PasswordHashingTestSuite))
+}
+
+// Benchmarks for choosing hashing settings: go test -run '^$' -bench 'Password'
+
+func BenchmarkPasswordBcrypt(b *testing.B) {
+	password := []byte("correct horse battery staple")
+	for _, cost := range []int{10, 11, 12, 13} {
+		hash, err := bcrypt.GenerateFromPassword(password, cost)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("cost=%d", cost), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bcrypt.CompareHashAndPassword(hash, password)
+			}
+		})
+	}
+}
+
+func BenchmarkPasswordArgon2id(b *testing.B) {
+	password := []byte("correct horse battery staple")
+	salt := []byte("0123456789abcdef")
+	for _, settings := range []struct {
+		time, memoryKiB uint32
+		threads         uint8
+	}{
+		{1, 19 * 1024, 1},
+		{2, 19 * 1024, 1},
+		{1, 46 * 1024, 1},
+		{1, 64 * 1024, 4},
+	} {
+		b.Run(fmt.Sprintf("t=%d,m=%dMiB,p=%d", settings.time, settings.memoryKiB/1024, settings.threads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				argon2.IDKey(password, salt, settings.time, settings.memoryKiB, settings.threads, 32)
+			}
+		})
+	}
+}
+
+func BenchmarkPasswordPoolParallel(b *testing.B) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.DefaultCost)
+	if err != nil {
+		b.Fatal(err)
+	}
+	config, err := DefaultPasswordHashConfig()
+	if err != nil {
+		b.Fatal(err)
+	}
+	config.LatencyBudget = 0
+	config.QueueSize = 1 << 20
+	hasher := newPasswordHasher(config)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			hasher.compare(context.Background(), string(hash), "correct horse")
+		}
+	})
+}
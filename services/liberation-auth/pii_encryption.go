@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/hkdf"
+)
+
+// encryptedPIIPrefix marks column values holding ciphertext; it is followed by the key ID,
+// a colon and base64(nonce || sealed value). Anything else is a plaintext legacy value.
+const encryptedPIIPrefix = "enc:v1:"
+
+// piiMigrationBatchSize bounds how many rows each migration query reads
+const piiMigrationBatchSize = 500
+
+// Encrypted columns, named table.column. The name is bound into the derived keys and the
+// ciphertext, so a value copied into another column does not decrypt.
+const (
+	piiUserEmail    = "users.email"
+	piiUserLocation = "users.location"
+	piiPhone        = "user_phone_numbers.phone"
+)
+
+// encryptedPIIColumns are migrated and rotated by the pii_encryption job, batched by key
+var encryptedPIIColumns = []struct {
+	name, table, column, key string
+	// index is the blind index column kept alongside, if the column is searchable
+	index string
+}{
+	{piiUserEmail, "users", "email", "id", "email_bidx"},
+	{piiUserLocation, "users", "location", "id", ""},
+	{piiPhone, "user_phone_numbers", "phone", "user_id", ""},
+}
+
+var piiKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+var (
+	errPIIKeyUnknown  = errors.New("value is encrypted with an unknown key")
+	errPIICiphertext  = errors.New("malformed encrypted value")
+	errPIIUnavailable = errors.New("value is encrypted but PII encryption is not configured")
+)
+
+var plaintextPIIRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "liberation_auth_pii_unencrypted_remaining",
+	Help: "Values not yet encrypted with the active PII key, by column. Old keys can be retired once all are zero.",
+}, []string{"column"})
+
+// PIIEncryptionConfig holds the master keys sensitive columns are encrypted under
+type PIIEncryptionConfig struct {
+	// Keys are 32-byte master keys by ID. Only ActiveKey encrypts; the rest still decrypt
+	// and match blind indexes until the pii_encryption job has rotated every row.
+	Keys      map[string][]byte
+	ActiveKey string
+}
+
+// DefaultPIIEncryptionConfig reads PII_ENCRYPTION_KEYS, a comma-separated list of
+// id:base64-key pairs, and PII_ENCRYPTION_ACTIVE_KEY, which defaults to the first key. The
+// keys are meant to be data keys released by the KMS into a mounted file
+// (PII_ENCRYPTION_KEYS_FILE), not literals in the environment. Without keys, columns are
+// stored in plaintext as before.
+func DefaultPIIEncryptionConfig() (PIIEncryptionConfig, error) {
+	config := PIIEncryptionConfig{Keys: map[string][]byte{}}
+	for _, entry := range strings.Split(getEnv("PII_ENCRYPTION_KEYS", ""), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found || !piiKeyIDPattern.MatchString(id) {
+			return config, fmt.Errorf("PII_ENCRYPTION_KEYS entries must look like id:base64-key, with ids of letters, digits, _ and -")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return config, fmt.Errorf("PII_ENCRYPTION_KEYS: key %s must be 32 bytes, base64 encoded", id)
+		}
+		if _, duplicate := config.Keys[id]; duplicate {
+			return config, fmt.Errorf("PII_ENCRYPTION_KEYS: key %s is listed twice", id)
+		}
+		config.Keys[id] = key
+		if config.ActiveKey == "" {
+			config.ActiveKey = id
+		}
+	}
+	if active := getEnv("PII_ENCRYPTION_ACTIVE_KEY", ""); active != "" {
+		if _, ok := config.Keys[active]; !ok {
+			return config, fmt.Errorf("PII_ENCRYPTION_ACTIVE_KEY %s is not in PII_ENCRYPTION_KEYS", active)
+		}
+		config.ActiveKey = active
+	}
+	return config, nil
+}
+
+// piiCipher encrypts sensitive columns with AES-256-GCM and computes HMAC-SHA256 blind
+// indexes for the searchable ones. Keys for each column are derived from the master keys
+// with HKDF. A nil cipher stores and matches plaintext.
+type piiCipher struct {
+	active  string
+	aeads   map[string]map[string]cipher.AEAD // key ID -> column -> AEAD
+	indexes map[string]map[string][]byte      // key ID -> column -> HMAC key
+	// order lists key IDs with the active key first, for blind index lookups
+	order []string
+}
+
+// newPIICipher derives the column keys; it returns nil when no keys are configured
+func newPIICipher(config PIIEncryptionConfig) (*piiCipher, error) {
+	if len(config.Keys) == 0 {
+		return nil, nil
+	}
+	pc := &piiCipher{
+		active:  config.ActiveKey,
+		aeads:   map[string]map[string]cipher.AEAD{},
+		indexes: map[string]map[string][]byte{},
+		order:   []string{config.ActiveKey},
+	}
+	for id, master := range config.Keys {
+		if id != config.ActiveKey {
+			pc.order = append(pc.order, id)
+		}
+		pc.aeads[id] = map[string]cipher.AEAD{}
+		pc.indexes[id] = map[string][]byte{}
+		for _, target := range encryptedPIIColumns {
+			block, err := aes.NewCipher(derivePIIKey(master, "encryption", target.name))
+			if err != nil {
+				return nil, err
+			}
+			if pc.aeads[id][target.name], err = cipher.NewGCM(block); err != nil {
+				return nil, err
+			}
+			pc.indexes[id][target.name] = derivePIIKey(master, "blind index", target.name)
+		}
+	}
+	return pc, nil
+}
+
+func derivePIIKey(master []byte, purpose, column string) []byte {
+	key := make([]byte, 32)
+	reader := hkdf.New(sha256.New, master, nil, []byte("liberation-auth pii "+purpose+" "+column))
+	if _, err := io.ReadFull(reader, key); err != nil {
+		panic(err) // HKDF only fails when asked for more than 255 blocks
+	}
+	return key
+}
+
+// encrypt seals a value for storage; empty values stay empty
+func (pc *piiCipher) encrypt(column, plaintext string) (string, error) {
+	if pc == nil || plaintext == "" {
+		return plaintext, nil
+	}
+	aead := pc.aeads[pc.active][column]
+	if aead == nil {
+		return "", fmt.Errorf("%s is not an encrypted column", column)
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(column))
+	return encryptedPIIPrefix + pc.active + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a stored value; plaintext legacy values are returned unchanged
+func (pc *piiCipher) decrypt(column, stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedPIIPrefix) {
+		return stored, nil
+	}
+	if pc == nil {
+		return "", errPIIUnavailable
+	}
+	id, encoded, found := strings.Cut(strings.TrimPrefix(stored, encryptedPIIPrefix), ":")
+	if !found {
+		return "", errPIICiphertext
+	}
+	aead := pc.aeads[id][column]
+	if aead == nil {
+		return "", fmt.Errorf("%w %q", errPIIKeyUnknown, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return "", errPIICiphertext
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(column))
+	if err != nil {
+		return "", errPIICiphertext
+	}
+	return string(plaintext), nil
+}
+
+// normalizePII is the form blind indexes are computed over, so lookups ignore case and padding
+func normalizePII(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+func (pc *piiCipher) blindIndexWith(id, column, value string) string {
+	mac := hmac.New(sha256.New, pc.indexes[id][column])
+	mac.Write([]byte(normalizePII(value)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// index is the blind index stored alongside a value; it is NULL without encryption
+func (pc *piiCipher) index(column, value string) interface{} {
+	if pc == nil || value == "" {
+		return nil
+	}
+	return pc.blindIndexWith(pc.active, column, value)
+}
+
+// lookupIndexes are the blind indexes a value may be stored under, one per key, for use
+// with = ANY($n) next to a match on the plaintext column for rows not yet migrated
+func (pc *piiCipher) lookupIndexes(column, value string) []string {
+	if pc == nil {
+		return []string{}
+	}
+	indexes := make([]string, 0, len(pc.order))
+	for _, id := range pc.order {
+		indexes = append(indexes, pc.blindIndexWith(id, column, value))
+	}
+	return indexes
+}
+
+// value encrypts a query argument when it is sent to the database
+func (pc *piiCipher) value(column, plaintext string) driver.Valuer {
+	return piiValue{cipher: pc, column: column, plaintext: plaintext}
+}
+
+// scan decrypts a column into dest, a *string or *sql.NullString, as it is read
+func (pc *piiCipher) scan(column string, dest interface{}) sql.Scanner {
+	return piiScanner{cipher: pc, column: column, dest: dest}
+}
+
+type piiValue struct {
+	cipher    *piiCipher
+	column    string
+	plaintext string
+}
+
+func (v piiValue) Value() (driver.Value, error) {
+	return v.cipher.encrypt(v.column, v.plaintext)
+}
+
+type piiScanner struct {
+	cipher *piiCipher
+	column string
+	dest   interface{}
+}
+
+func (s piiScanner) Scan(src interface{}) error {
+	var stored string
+	switch value := src.(type) {
+	case nil:
+		return s.assign("", false)
+	case string:
+		stored = value
+	case []byte:
+		stored = string(value)
+	default:
+		return fmt.Errorf("cannot decrypt %T into %s", src, s.column)
+	}
+	plaintext, err := s.cipher.decrypt(s.column, stored)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.column, err)
+	}
+	return s.assign(plaintext, true)
+}
+
+func (s piiScanner) assign(value string, valid bool) error {
+	switch dest := s.dest.(type) {
+	case *string:
+		*dest = value
+	case *sql.NullString:
+		*dest = sql.NullString{String: value, Valid: valid}
+	case **string:
+		if valid {
+			*dest = &value
+		} else {
+			*dest = nil
+		}
+	default:
+		return fmt.Errorf("cannot decrypt %s into %T", s.column, s.dest)
+	}
+	return nil
+}
+
+// encryptPIIColumns encrypts plaintext values and re-encrypts values under retired keys,
+// refreshing blind indexes as it goes. Rows are walked in key order, so a value that cannot
+// be decrypted is counted and skipped rather than retried forever.
+func (as *AuthService) encryptPIIColumns(ctx context.Context) (map[string]int64, map[string]int64, error) {
+	encrypted, failed := map[string]int64{}, map[string]int64{}
+	for _, target := range encryptedPIIColumns {
+		last := "00000000-0000-0000-0000-000000000000"
+		for {
+			rows, err := as.db.QueryContext(ctx, fmt.Sprintf(`
+				SELECT %[3]s::text, %[2]s FROM %[1]s
+				WHERE %[3]s > $1 AND COALESCE(%[2]s, '') <> '' AND %[2]s NOT LIKE $2
+				ORDER BY %[3]s LIMIT %[4]d`, target.table, target.column, target.key, piiMigrationBatchSize),
+				last, encryptedPIIPrefix+as.pii.active+":%")
+			if err != nil {
+				return encrypted, failed, fmt.Errorf("%s: %w", target.name, err)
+			}
+			type pending struct{ key, stored string }
+			var batch []pending
+			for rows.Next() {
+				var row pending
+				if err := rows.Scan(&row.key, &row.stored); err != nil {
+					rows.Close()
+					return encrypted, failed, fmt.Errorf("%s: %w", target.name, err)
+				}
+				batch = append(batch, row)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return encrypted, failed, fmt.Errorf("%s: %w", target.name, err)
+			}
+
+			for _, row := range batch {
+				last = row.key
+				plaintext, err := as.pii.decrypt(target.name, row.stored)
+				if err != nil {
+					failed[target.name]++
+					continue
+				}
+				ciphertext, err := as.pii.encrypt(target.name, plaintext)
+				if err != nil {
+					return encrypted, failed, err
+				}
+
+				// Only replace the value that was read, in case the row changed meanwhile
+				statement := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2 AND %s = $3`, target.table, target.column, target.key, target.column)
+				args := []interface{}{ciphertext, row.key, row.stored}
+				if target.index != "" {
+					statement = fmt.Sprintf(`UPDATE %s SET %s = $1, %s = $4 WHERE %s = $2 AND %s = $3`, target.table, target.column, target.index, target.key, target.column)
+					args = append(args, as.pii.index(target.name, plaintext))
+				}
+				if _, err := as.db.ExecContext(ctx, statement, args...); err != nil {
+					return encrypted, failed, fmt.Errorf("%s: %w", target.name, err)
+				}
+				encrypted[target.name]++
+			}
+			if len(batch) < piiMigrationBatchSize {
+				break
+			}
+		}
+	}
+	return encrypted, failed, nil
+}
+
+// countUnencryptedPII reports how many values per column are not under the active key
+func (as *AuthService) countUnencryptedPII(ctx context.Context) (map[string]int64, error) {
+	remaining := map[string]int64{}
+	for _, target := range encryptedPIIColumns {
+		var count int64
+		err := as.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE COALESCE(%s, '') <> '' AND %s NOT LIKE $1`,
+			target.table, target.column, target.column), encryptedPIIPrefix+as.pii.active+":%").Scan(&count)
+		if err != nil {
+			return remaining, fmt.Errorf("%s: %w", target.name, err)
+		}
+		remaining[target.name] = count
+	}
+	return remaining, nil
+}
+
+// EncryptPII is the background job that encrypts legacy plaintext and rotates values to the
+// active key. Run it on demand with POST /admin/jobs/pii_encryption/run after adding a key.
+func (as *AuthService) EncryptPII(ctx context.Context) (string, error) {
+	encrypted, failed, err := as.encryptPIIColumns(ctx)
+	if err != nil {
+		return "", err
+	}
+	remaining, err := as.countUnencryptedPII(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, target := range encryptedPIIColumns {
+		plaintextPIIRemaining.WithLabelValues(target.name).Set(float64(remaining[target.name]))
+		part := fmt.Sprintf("%s: %d encrypted, %d left", target.name, encrypted[target.name], remaining[target.name])
+		if failed[target.name] > 0 {
+			part += fmt.Sprintf(", %d undecryptable", failed[target.name])
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; "), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PIIEncryptionTestSuite struct {
+	suite.Suite
+	cipher *piiCipher
+}
+
+func piiTestKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, 32)
+}
+
+func (suite *PIIEncryptionTestSuite) SetupTest() {
+	var err error
+	suite.cipher, err = newPIICipher(PIIEncryptionConfig{Keys: map[string][]byte{"k1": piiTestKey(1)}, ActiveKey: "k1"})
+	suite.Require().NoError(err)
+}
+
+func (suite *PIIEncryptionTestSuite) TestRoundTrip() {
+	stored, err := suite.cipher.encrypt(piiUserEmail, "reader@example.org")
+	suite.Require().NoError(err)
+	suite.True(strings.HasPrefix(stored, encryptedPIIPrefix+"k1:"))
+	suite.NotContains(stored, "reader")
+
+	again, err := suite.cipher.encrypt(piiUserEmail, "reader@example.org")
+	suite.Require().NoError(err)
+	suite.NotEqual(stored, again, "nonces must differ between writes")
+
+	plaintext, err := suite.cipher.decrypt(piiUserEmail, stored)
+	suite.Require().NoError(err)
+	suite.Equal("reader@example.org", plaintext)
+}
+
+func (suite *PIIEncryptionTestSuite) TestPlaintextPassesThrough() {
+	plaintext, err := suite.cipher.decrypt(piiUserEmail, "legacy@example.org")
+	suite.NoError(err)
+	suite.Equal("legacy@example.org", plaintext)
+
+	var disabled *piiCipher
+	stored, err := disabled.encrypt(piiUserEmail, "reader@example.org")
+	suite.NoError(err)
+	suite.Equal("reader@example.org", stored)
+	suite.Nil(disabled.index(piiUserEmail, "reader@example.org"))
+	suite.Empty(disabled.lookupIndexes(piiUserEmail, "reader@example.org"))
+
+	encrypted, _ := suite.cipher.encrypt(piiUserEmail, "reader@example.org")
+	_, err = disabled.decrypt(piiUserEmail, encrypted)
+	suite.ErrorIs(err, errPIIUnavailable)
+}
+
+func (suite *PIIEncryptionTestSuite) TestCiphertextIsBoundToItsColumn() {
+	stored, err := suite.cipher.encrypt(piiUserEmail, "reader@example.org")
+	suite.Require().NoError(err)
+
+	_, err = suite.cipher.decrypt(piiUserLocation, stored)
+	suite.ErrorIs(err, errPIICiphertext)
+
+	sealed, _ := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedPIIPrefix+"k1:"))
+	sealed[len(sealed)-1] ^= 1
+	_, err = suite.cipher.decrypt(piiUserEmail, encryptedPIIPrefix+"k1:"+base64.RawStdEncoding.EncodeToString(sealed))
+	suite.ErrorIs(err, errPIICiphertext)
+
+	_, err = suite.cipher.decrypt(piiUserEmail, encryptedPIIPrefix+"k9:AAAA")
+	suite.ErrorIs(err, errPIIKeyUnknown)
+}
+
+func (suite *PIIEncryptionTestSuite) TestRotationKeepsOldValuesReadable() {
+	old, err := suite.cipher.encrypt(piiUserEmail, "reader@example.org")
+	suite.Require().NoError(err)
+	oldIndex := suite.cipher.index(piiUserEmail, "reader@example.org")
+
+	rotated, err := newPIICipher(PIIEncryptionConfig{
+		Keys:      map[string][]byte{"k1": piiTestKey(1), "k2": piiTestKey(2)},
+		ActiveKey: "k2",
+	})
+	suite.Require().NoError(err)
+
+	plaintext, err := rotated.decrypt(piiUserEmail, old)
+	suite.Require().NoError(err)
+	suite.Equal("reader@example.org", plaintext)
+
+	fresh, err := rotated.encrypt(piiUserEmail, "reader@example.org")
+	suite.Require().NoError(err)
+	suite.True(strings.HasPrefix(fresh, encryptedPIIPrefix+"k2:"))
+
+	// Lookups match rows under either key, active key first, ignoring case and padding
+	indexes := rotated.lookupIndexes(piiUserEmail, "  Reader@Example.org ")
+	suite.Require().Len(indexes, 2)
+	suite.Equal(rotated.index(piiUserEmail, "reader@example.org"), indexes[0])
+	suite.Equal(oldIndex, indexes[1])
+}
+
+func (suite *PIIEncryptionTestSuite) TestScannerHandlesNull() {
+	stored, _ := suite.cipher.encrypt(piiUserLocation, "Lisbon")
+
+	var location sql.NullString
+	suite.Require().NoError(suite.cipher.scan(piiUserLocation, &location).Scan([]byte(stored)))
+	suite.Equal(sql.NullString{String: "Lisbon", Valid: true}, location)
+	suite.Require().NoError(suite.cipher.scan(piiUserLocation, &location).Scan(nil))
+	suite.False(location.Valid)
+
+	var optional *string
+	suite.Require().NoError(suite.cipher.scan(piiUserLocation, &optional).Scan(stored))
+	suite.Require().NotNil(optional)
+	suite.Equal("Lisbon", *optional)
+	suite.Require().NoError(suite.cipher.scan(piiUserLocation, &optional).Scan(nil))
+	suite.Nil(optional)
+
+	value, err := suite.cipher.value(piiUserLocation, "").Value()
+	suite.NoError(err)
+	suite.Equal("", value)
+}
+
+func (suite *PIIEncryptionTestSuite) TestConfigFromEnvironment() {
+	t := suite.T()
+	k1 := base64.StdEncoding.EncodeToString(piiTestKey(1))
+	k2 := base64.StdEncoding.EncodeToString(piiTestKey(2))
+
+	t.Setenv("PII_ENCRYPTION_KEYS", "2024:"+k1+", 2025:"+k2)
+	config, err := DefaultPIIEncryptionConfig()
+	suite.Require().NoError(err)
+	suite.Len(config.Keys, 2)
+	suite.Equal("2024", config.ActiveKey)
+
+	t.Setenv("PII_ENCRYPTION_ACTIVE_KEY", "2025")
+	config, err = DefaultPIIEncryptionConfig()
+	suite.Require().NoError(err)
+	suite.Equal("2025", config.ActiveKey)
+
+	t.Setenv("PII_ENCRYPTION_ACTIVE_KEY", "2026")
+	_, err = DefaultPIIEncryptionConfig()
+	suite.Error(err)
+
+	t.Setenv("PII_ENCRYPTION_ACTIVE_KEY", "")
+	for _, keys := range []string{"2024", "2024:" + base64.StdEncoding.EncodeToString([]byte("short")), "a b:" + k1, "x:" + k1 + ",x:" + k2} {
+		t.Setenv("PII_ENCRYPTION_KEYS", keys)
+		_, err = DefaultPIIEncryptionConfig()
+		suite.Error(err, keys)
+	}
+}
+
+func TestPIIEncryptionTestSuite(t *testing.T) {
+	suite.Run(t, new(PIIEncryptionTestSuite))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"nuclear-ao3/shared/models"
+)
+
+// PKCE code challenge methods (RFC 7636)
+const (
+	pkceMethodS256  = "S256"
+	pkceMethodPlain = "plain"
+)
+
+// PKCEPolicy is the service-wide PKCE policy. Public clients always need PKCE;
+// the zero value keeps that minimum and accepts both challenge methods.
+type PKCEPolicy struct {
+	// RequireAll requires PKCE for confidential clients too, as OAuth 2.1 recommends
+	RequireAll bool
+	// S256Only rejects plain challenges
+	S256Only bool
+	// Locked forces both requirements for every client; per-client overrides cannot relax it
+	Locked bool
+}
+
+// DefaultPKCEPolicy reads PKCE_REQUIRED and PKCE_METHODS from the environment
+func DefaultPKCEPolicy() (PKCEPolicy, error) {
+	var policy PKCEPolicy
+	switch required := getEnv("PKCE_REQUIRED", "public"); required {
+	case "public":
+	case "all":
+		policy.RequireAll = true
+	default:
+		return policy, fmt.Errorf("PKCE_REQUIRED must be \"public\" or \"all\", got %q", required)
+	}
+
+	methods := strings.FieldsFunc(getEnv("PKCE_METHODS", "S256,plain"), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if !contains(methods, pkceMethodS256) {
+		return policy, fmt.Errorf("PKCE_METHODS must include S256")
+	}
+	for _, method := range methods {
+		if method != pkceMethodS256 && method != pkceMethodPlain {
+			return policy, fmt.Errorf("PKCE_METHODS: unknown method %q", method)
+		}
+	}
+	policy.S256Only = !contains(methods, pkceMethodPlain)
+	return policy, nil
+}
+
+// challengeMethods lists the methods advertised in discovery
+func (p PKCEPolicy) challengeMethods() []string {
+	if p.S256Only || p.Locked {
+		return []string{pkceMethodS256}
+	}
+	return []string{pkceMethodS256, pkceMethodPlain}
+}
+
+// ClientPKCEPolicy overrides the service-wide policy for one client. Nil fields inherit it.
+type ClientPKCEPolicy struct {
+	ClientID  uuid.UUID  `json:"client_id"`
+	Required  *bool      `json:"required"`
+	S256Only  *bool      `json:"s256_only"`
+	UpdatedBy *uuid.UUID `json:"updated_by,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// pkceRequirement is the policy in force for a particular client
+type pkceRequirement struct {
+	Required bool `json:"required"`
+	S256Only bool `json:"s256_only"`
+}
+
+// forClient merges a client's override into the service-wide policy
+func (p PKCEPolicy) forClient(isPublic bool, override *ClientPKCEPolicy) pkceRequirement {
+	requirement := pkceRequirement{Required: p.RequireAll, S256Only: p.S256Only}
+	if override != nil {
+		if override.Required != nil {
+			requirement.Required = *override.Required
+		}
+		if override.S256Only != nil {
+			requirement.S256Only = *override.S256Only
+		}
+	}
+	// Public clients have no secret, so PKCE is their only protection against code interception
+	if isPublic {
+		requirement.Required = true
+	}
+	if p.Locked {
+		requirement.Required, requirement.S256Only = true, true
+	}
+	return requirement
+}
+
+// check validates the PKCE parameters of an authorization request and returns the
+// challenge method to store; RFC 7636 makes an omitted method mean plain.
+func (r pkceRequirement) check(challenge, method string) (string, error) {
+	if challenge == "" {
+		if method != "" {
+			return "", fmt.Errorf("code_challenge_method requires a code_challenge")
+		}
+		if r.Required {
+			return "", fmt.Errorf("PKCE is required for this client; send a code_challenge using S256")
+		}
+		return "", nil
+	}
+
+	if method == "" {
+		method = pkceMethodPlain
+	}
+	switch method {
+	case pkceMethodS256:
+	case pkceMethodPlain:
+		if r.S256Only {
+			return "", fmt.Errorf("code_challenge_method plain is not allowed; use S256")
+		}
+	default:
+		return "", fmt.Errorf("unsupported code_challenge_method %q", method)
+	}
+
+	if len(challenge) < 43 || len(challenge) > 128 || strings.IndexFunc(challenge, func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("-._~", r))
+	}) >= 0 {
+		return "", fmt.Errorf("code_challenge must be 43-128 characters of [A-Za-z0-9-._~]")
+	}
+	return method, nil
+}
+
+// getClientPKCEPolicy returns a client's override, or nil if it follows the service-wide policy
+func (as *AuthService) getClientPKCEPolicy(ctx context.Context, clientID uuid.UUID) (*ClientPKCEPolicy, error) {
+	policy := &ClientPKCEPolicy{ClientID: clientID}
+	var required, s256Only sql.NullBool
+	err := as.db.QueryRowContext(ctx, `
+		SELECT required, s256_only, updated_by, updated_at
+		FROM client_pkce_policies WHERE client_id = $1`, clientID).
+		Scan(&required, &s256Only, &policy.UpdatedBy, &policy.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if required.Valid {
+		policy.Required = &required.Bool
+	}
+	if s256Only.Valid {
+		policy.S256Only = &s256Only.Bool
+	}
+	return policy, nil
+}
+
+// pkceRequirementFor returns the PKCE policy in force for a client
+func (as *AuthService) pkceRequirementFor(ctx context.Context, client *models.OAuthClient) pkceRequirement {
+	var override *ClientPKCEPolicy
+	if as.db != nil {
+		var err error
+		if override, err = as.getClientPKCEPolicy(ctx, client.ID); err != nil {
+			log.Printf("Failed to load PKCE policy for client %s: %v", client.ID, err)
+		}
+	}
+	return as.pkce.forClient(client.IsPublic, override)
+}
+
+// Admin API
+
+func (as *AuthService) AdminGetPKCEPolicy(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	override, err := as.getClientPKCEPolicy(c.Request.Context(), client.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load PKCE policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"override":  override,
+		"effective": as.pkce.forClient(client.IsPublic, override),
+		"is_public": client.IsPublic,
+	})
+}
+
+func (as *AuthService) AdminPutPKCEPolicy(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	var policy ClientPKCEPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if client.IsPublic && policy.Required != nil && !*policy.Required {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PKCE cannot be made optional for public clients"})
+		return
+	}
+	if as.pkce.Locked && ((policy.Required != nil && !*policy.Required) || (policy.S256Only != nil && !*policy.S256Only)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "OAuth 2.1 mode requires S256 PKCE for every client"})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	_, err := as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO client_pkce_policies (client_id, required, s256_only, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (client_id)
+		DO UPDATE SET required = $2, s256_only = $3, updated_by = $4, updated_at = NOW()`,
+		client.ID, policy.Required, policy.S256Only, adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save PKCE policy"})
+		return
+	}
+
+	stored, err := as.getClientPKCEPolicy(c.Request.Context(), client.ID)
+	if err != nil || stored == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load PKCE policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"override":  stored,
+		"effective": as.pkce.forClient(client.IsPublic, stored),
+		"is_public": client.IsPublic,
+	})
+}
+
+func (as *AuthService) AdminDeletePKCEPolicy(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	if _, err := as.db.ExecContext(c.Request.Context(), `DELETE FROM client_pkce_policies WHERE client_id = $1`, client.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete PKCE policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "PKCE policy removed; client follows the service-wide policy"})
+}
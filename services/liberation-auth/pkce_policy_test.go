@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PKCEPolicyTestSuite struct {
+	suite.Suite
+}
+
+var testChallenge = strings.Repeat("a", 43)
+
+func (suite *PKCEPolicyTestSuite) TestPublicClientsAlwaysRequirePKCE() {
+	optional := false
+	requirement := PKCEPolicy{}.forClient(true, &ClientPKCEPolicy{Required: &optional})
+	suite.True(requirement.Required)
+
+	_, err := requirement.check("", "")
+	suite.Error(err)
+}
+
+func (suite *PKCEPolicyTestSuite) TestConfidentialClientsFollowPolicy() {
+	_, err := PKCEPolicy{}.forClient(false, nil).check("", "")
+	suite.NoError(err)
+
+	_, err = PKCEPolicy{RequireAll: true}.forClient(false, nil).check("", "")
+	suite.Error(err)
+
+	// A per-client override wins over the service-wide setting
+	required := true
+	_, err = PKCEPolicy{}.forClient(false, &ClientPKCEPolicy{Required: &required}).check("", "")
+	suite.Error(err)
+}
+
+func (suite *PKCEPolicyTestSuite) TestPlainChallenges() {
+	method, err := PKCEPolicy{}.forClient(true, nil).check(testChallenge, "")
+	suite.NoError(err)
+	suite.Equal(pkceMethodPlain, method, "an omitted method means plain")
+
+	_, err = PKCEPolicy{S256Only: true}.forClient(true, nil).check(testChallenge, "plain")
+	suite.Error(err)
+	_, err = PKCEPolicy{S256Only: true}.forClient(true, nil).check(testChallenge, "")
+	suite.Error(err)
+
+	method, err = PKCEPolicy{S256Only: true}.forClient(true, nil).check(testChallenge, "S256")
+	suite.NoError(err)
+	suite.Equal(pkceMethodS256, method)
+}
+
+func (suite *PKCEPolicyTestSuite) TestMalformedChallenges() {
+	requirement := PKCEPolicy{}.forClient(true, nil)
+	for _, challenge := range []string{"short", strings.Repeat("a", 129), strings.Repeat("a", 42) + "+"} {
+		_, err := requirement.check(challenge, "S256")
+		suite.Error(err, challenge)
+	}
+
+	_, err := requirement.check(testChallenge, "S512")
+	suite.Error(err)
+	_, err = PKCEPolicy{}.forClient(false, nil).check("", "S256")
+	suite.Error(err, "a method without a challenge")
+}
+
+func (suite *PKCEPolicyTestSuite) TestDiscoveryMethods() {
+	suite.Equal([]string{"S256", "plain"}, PKCEPolicy{}.challengeMethods())
+	suite.Equal([]string{"S256"}, PKCEPolicy{S256Only: true}.challengeMethods())
+}
+
+func (suite *PKCEPolicyTestSuite) TestEnvironment() {
+	suite.T().Setenv("PKCE_REQUIRED", "all")
+	suite.T().Setenv("PKCE_METHODS", "S256")
+	policy, err := DefaultPKCEPolicy()
+	suite.Require().NoError(err)
+	suite.Equal(PKCEPolicy{RequireAll: true, S256Only: true}, policy)
+
+	suite.T().Setenv("PKCE_METHODS", "plain")
+	_, err = DefaultPKCEPolicy()
+	suite.Error(err)
+}
+
+func TestPKCEPolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(PKCEPolicyTestSuite))
+}
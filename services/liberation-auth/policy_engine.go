@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Policy engine modes
+const (
+	// policyModeShadow evaluates policies next to the built-in logic and reports where they
+	// disagree, but the built-in logic decides
+	policyModeShadow = "shadow"
+	// policyModeEnforce lets policies decide wherever they define a result
+	policyModeEnforce = "enforce"
+)
+
+// Decision points policies can answer
+const (
+	policyDecisionScopes  = "scopes"
+	policyDecisionAdmin   = "admin"
+	policyDecisionProfile = "profile"
+)
+
+// policyQueries are the Rego rules each decision point reads. A decision without a rule in
+// the loaded policies falls back to the built-in logic.
+var policyQueries = map[string]string{
+	policyDecisionScopes:  "data.liberation.authz.scopes",
+	policyDecisionAdmin:   "data.liberation.authz.admin",
+	policyDecisionProfile: "data.liberation.authz.profile",
+}
+
+// policyNamePattern keeps stored policy names usable in URLs and logs
+var policyNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,99}$`)
+
+// Outcomes of one policy decision
+const (
+	policyOutcomeMatch     = "match"
+	policyOutcomeMismatch  = "mismatch"
+	policyOutcomeUndefined = "undefined"
+	policyOutcomeError     = "error"
+)
+
+var policyDecisionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_policy_decisions_total",
+	Help: "Policy engine decisions, by decision point and outcome (match, mismatch, undefined, error). Mismatches are decisions where the policy and the built-in logic disagree.",
+}, []string{"decision", "outcome"})
+
+var policyReloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_policy_reloads_total",
+	Help: "Policy reloads, by outcome (loaded, failed).",
+}, []string{"outcome"})
+
+// PolicyEngineConfig controls the embedded Rego policy engine
+type PolicyEngineConfig struct {
+	// Mode is shadow or enforce; the engine is off without one
+	Mode string
+	// Dir holds .rego files loaded alongside the policies stored in the database
+	Dir            string
+	ReloadInterval time.Duration
+	// DecisionLog logs every decision with its input, not just disagreements
+	DecisionLog bool
+}
+
+// DefaultPolicyEngineConfig reads POLICY_ENGINE_MODE, POLICY_DIR, POLICY_RELOAD_INTERVAL and
+// POLICY_DECISION_LOG
+func DefaultPolicyEngineConfig() (PolicyEngineConfig, error) {
+	config := PolicyEngineConfig{
+		Mode:        getEnv("POLICY_ENGINE_MODE", ""),
+		Dir:         getEnv("POLICY_DIR", ""),
+		DecisionLog: getEnv("POLICY_DECISION_LOG", "false") == "true",
+	}
+	switch config.Mode {
+	case "", "off":
+		config.Mode = ""
+		return config, nil
+	case policyModeShadow, policyModeEnforce:
+	default:
+		return config, fmt.Errorf("POLICY_ENGINE_MODE must be off, shadow or enforce")
+	}
+	var err error
+	if config.ReloadInterval, err = time.ParseDuration(getEnv("POLICY_RELOAD_INTERVAL", "30s")); err != nil || config.ReloadInterval <= 0 {
+		return config, fmt.Errorf("POLICY_RELOAD_INTERVAL must be a positive duration")
+	}
+	if config.Dir != "" {
+		if info, err := os.Stat(config.Dir); err != nil || !info.IsDir() {
+			return config, fmt.Errorf("POLICY_DIR %s is not a directory", config.Dir)
+		}
+	}
+	return config, nil
+}
+
+// policyModule is one Rego source file
+type policyModule struct {
+	Name string `json:"name"`
+	// Source is file for POLICY_DIR and database for policies managed through the admin API
+	Source    string     `json:"source"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	text      string
+}
+
+// compiledPolicies is one consistent set of loaded policies
+type compiledPolicies struct {
+	revision string
+	modules  []policyModule
+	queries  map[string]rego.PreparedEvalQuery
+	loadedAt time.Time
+}
+
+// PolicyEngine evaluates Rego policies for authorization decisions that are otherwise made
+// by built-in logic
+type PolicyEngine struct {
+	as     *AuthService
+	config PolicyEngineConfig
+
+	mu        sync.RWMutex
+	current   *compiledPolicies
+	lastError string
+}
+
+// NewPolicyEngine returns nil when POLICY_ENGINE_MODE is off
+func NewPolicyEngine(as *AuthService, config PolicyEngineConfig) *PolicyEngine {
+	if config.Mode == "" {
+		return nil
+	}
+	return &PolicyEngine{as: as, config: config}
+}
+
+// loadModules reads POLICY_DIR and the authorization_policies table
+func (e *PolicyEngine) loadModules(ctx context.Context) ([]policyModule, error) {
+	var modules []policyModule
+	if e.config.Dir != "" {
+		paths, err := filepath.Glob(filepath.Join(e.config.Dir, "*.rego"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			modules = append(modules, policyModule{Name: filepath.Base(path), Source: "file", text: string(text)})
+		}
+	}
+	rows, err := e.as.db.QueryContext(ctx, `SELECT name, module, updated_at FROM authorization_policies ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		module := policyModule{Source: "database"}
+		var updatedAt time.Time
+		if err := rows.Scan(&module.Name, &module.text, &updatedAt); err != nil {
+			return nil, err
+		}
+		module.UpdatedAt = &updatedAt
+		modules = append(modules, module)
+	}
+	return modules, rows.Err()
+}
+
+// moduleKey is the name a module is compiled under; files and stored policies may share names
+func (m policyModule) moduleKey() string {
+	return m.Source + "/" + m.Name
+}
+
+// policyRevision identifies a set of modules by content
+func policyRevision(modules []policyModule) string {
+	sorted := append([]policyModule(nil), modules...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].moduleKey() < sorted[j].moduleKey() })
+	hash := sha256.New()
+	for _, module := range sorted {
+		fmt.Fprintf(hash, "%s\x00%s\x00", module.moduleKey(), module.text)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// compilePolicies compiles modules and prepares a query for every decision point
+func compilePolicies(ctx context.Context, modules []policyModule) (*compiledPolicies, error) {
+	sources := make(map[string]string, len(modules))
+	for _, module := range modules {
+		sources[module.moduleKey()] = module.text
+	}
+	compiler, err := ast.CompileModules(sources)
+	if err != nil {
+		return nil, err
+	}
+	compiled := &compiledPolicies{
+		revision: policyRevision(modules),
+		modules:  modules,
+		queries:  make(map[string]rego.PreparedEvalQuery, len(policyQueries)),
+		loadedAt: time.Now(),
+	}
+	for decision, query := range policyQueries {
+		prepared, err := rego.New(rego.Query(query), rego.Compiler(compiler)).PrepareForEval(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", decision, err)
+		}
+		compiled.queries[decision] = prepared
+	}
+	return compiled, nil
+}
+
+// Reload loads and compiles the policies if they changed. Policies that fail to compile are
+// reported and the previous ones stay in force.
+func (e *PolicyEngine) Reload(ctx context.Context) error {
+	modules, err := e.loadModules(ctx)
+	if err == nil {
+		e.mu.RLock()
+		unchanged := e.current != nil && e.current.revision == policyRevision(modules)
+		e.mu.RUnlock()
+		if unchanged {
+			return nil
+		}
+	}
+	var compiled *compiledPolicies
+	if err == nil {
+		compiled, err = compilePolicies(ctx, modules)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		policyReloadsTotal.WithLabelValues("failed").Inc()
+		e.lastError = err.Error()
+		return err
+	}
+	policyReloadsTotal.WithLabelValues("loaded").Inc()
+	log.Printf("Loaded %d authorization policies, revision %s", len(modules), compiled.revision)
+	e.current, e.lastError = compiled, ""
+	return nil
+}
+
+// Run reloads policies every POLICY_RELOAD_INTERVAL until ctx is cancelled
+func (e *PolicyEngine) Run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	if err := e.Reload(ctx); err != nil {
+		log.Printf("Failed to load authorization policies: %v", err)
+	}
+	ticker := time.NewTicker(e.config.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Reload(ctx); err != nil {
+				log.Printf("Failed to reload authorization policies, keeping the previous ones: %v", err)
+			}
+		}
+	}
+}
+
+// evaluate runs a decision's policy. It returns the policy's result when there is one, and
+// whether the caller should use it: only in enforce mode, and never after an error.
+func (e *PolicyEngine) evaluate(ctx context.Context, decision string, input map[string]interface{}, builtin interface{}) (interface{}, bool) {
+	e.mu.RLock()
+	current := e.current
+	e.mu.RUnlock()
+	if current == nil {
+		return nil, false
+	}
+
+	outcome := policyOutcomeMatch
+	var result interface{}
+	results, err := current.queries[decision].Eval(ctx, rego.EvalInput(input))
+	switch {
+	case err != nil:
+		outcome = policyOutcomeError
+		log.Printf("Policy %s failed at revision %s: %v", decision, current.revision, err)
+	case len(results) == 0 || len(results[0].Expressions) == 0:
+		outcome = policyOutcomeUndefined
+	default:
+		result = results[0].Expressions[0].Value
+		if !samePolicyResult(result, builtin) {
+			outcome = policyOutcomeMismatch
+		}
+	}
+	policyDecisionsTotal.WithLabelValues(decision, outcome).Inc()
+
+	if outcome == policyOutcomeMismatch || e.config.DecisionLog {
+		entry, _ := json.Marshal(map[string]interface{}{
+			"decision": decision, "revision": current.revision, "mode": e.config.Mode, "outcome": outcome,
+			"input": input, "result": result, "builtin": builtin,
+		})
+		log.Printf("Policy decision: %s", entry)
+	}
+	return result, e.config.Mode == policyModeEnforce && outcome != policyOutcomeError && outcome != policyOutcomeUndefined
+}
+
+// samePolicyResult compares a Rego value with a built-in one by their JSON forms, so
+// []string{"read"} and []interface{}{"read"} agree
+func samePolicyResult(result, builtin interface{}) bool {
+	a, errA := json.Marshal(result)
+	b, errB := json.Marshal(builtin)
+	if errA != nil || errB != nil {
+		return false
+	}
+	var x, y interface{}
+	json.Unmarshal(a, &x)
+	json.Unmarshal(b, &y)
+	return reflect.DeepEqual(x, y)
+}
+
+// grantScopes asks the scopes policy which of the scopes the built-in checks allowed should
+// be granted. A policy can only narrow a grant, never add to it.
+func (e *PolicyEngine) grantScopes(ctx context.Context, grantType string, clientID uuid.UUID, userID *uuid.UUID, scopes []string) []string {
+	if e == nil {
+		return scopes
+	}
+	input := map[string]interface{}{"grant_type": grantType, "client_id": clientID, "user_id": userID, "scopes": scopes}
+	if userID != nil {
+		input["roles"], _ = e.as.getUserRoles(ctx, *userID)
+	}
+	result, use := e.evaluate(ctx, policyDecisionScopes, input, scopes)
+	if !use {
+		return scopes
+	}
+	values, ok := result.([]interface{})
+	if !ok {
+		log.Printf("Scopes policy returned %T; expected an array of scopes", result)
+		return scopes
+	}
+	allowed := make([]string, 0, len(values))
+	for _, value := range values {
+		if scope, ok := value.(string); ok {
+			allowed = append(allowed, scope)
+		}
+	}
+	return intersectScopes(scopes, allowed)
+}
+
+// profileAccess asks the profile policy whether a viewer may see a profile. The result is
+// one of visible, hidden and concealed.
+func (e *PolicyEngine) profileAccess(ctx context.Context, rel profileRelationship, visibility string) profileAccess {
+	builtin := profileAccessFor(rel, visibility)
+	if e == nil {
+		return builtin
+	}
+	input := map[string]interface{}{
+		"visibility": visibility,
+		"viewer": map[string]interface{}{
+			"self": rel.Self, "signed_in": rel.SignedIn, "friends": rel.Friends, "blocked": rel.Blocked,
+		},
+	}
+	result, use := e.evaluate(ctx, policyDecisionProfile, input, profileAccessNames[builtin])
+	if !use {
+		return builtin
+	}
+	for access, name := range profileAccessNames {
+		if result == name {
+			return access
+		}
+	}
+	log.Printf("Profile policy returned %v; expected visible, hidden or concealed", result)
+	return builtin
+}
+
+var profileAccessNames = map[profileAccess]string{
+	profileVisible:   "visible",
+	profileHidden:    "hidden",
+	profileConcealed: "concealed",
+}
+
+// PolicyAdminMiddleware lets the admin policy decide who may use each admin route. The
+// built-in rule admits every caller RequireRoleMiddleware admitted. The policy routes are
+// exempt, so a policy that locks everyone out can still be fixed.
+func PolicyAdminMiddleware(authService *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		e := authService.policies
+		if e == nil || strings.Contains(c.FullPath(), "/admin/policies") {
+			c.Next()
+			return
+		}
+		input := map[string]interface{}{
+			"method":  c.Request.Method,
+			"route":   c.FullPath(),
+			"service": isServiceCall(c),
+		}
+		if permission, ok := c.Get(adminPermissionKey); ok {
+			input["permission"] = permission
+		}
+		if value, ok := c.Get("user_id"); ok {
+			if userID, err := uuid.Parse(fmt.Sprint(value)); err == nil {
+				input["user_id"] = userID
+				input["roles"], _ = authService.getUserRoles(c.Request.Context(), userID)
+			}
+		}
+		result, use := e.evaluate(c.Request.Context(), policyDecisionAdmin, input, true)
+		if use && result != true {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "insufficient_permissions",
+				"error_description": "Not allowed by the admin access policy",
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Admin API
+
+// AdminListPolicies shows the loaded policies and the engine's state
+func (e *PolicyEngine) AdminListPolicies(c *gin.Context) {
+	e.mu.RLock()
+	current, lastError := e.current, e.lastError
+	e.mu.RUnlock()
+
+	body := gin.H{"mode": e.config.Mode, "decisions": policyQueries, "last_error": lastError, "modules": []policyModule{}}
+	if current != nil {
+		body["revision"] = current.revision
+		body["loaded_at"] = current.loadedAt
+		if current.modules != nil {
+			body["modules"] = current.modules
+		}
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// AdminPutPolicy stores a Rego module. It is compiled with the other policies first, so a
+// module that would break them is rejected rather than stored.
+func (e *PolicyEngine) AdminPutPolicy(c *gin.Context) {
+	name := c.Param("name")
+	if !policyNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Policy names are lowercase letters, digits, '.', '_' and '-'"})
+		return
+	}
+	var req struct {
+		Module string `json:"module" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "module is required"})
+		return
+	}
+	ctx := c.Request.Context()
+	modules, err := e.loadModules(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load policies"})
+		return
+	}
+	candidate := []policyModule{{Name: name, Source: "database", text: req.Module}}
+	for _, module := range modules {
+		if module.moduleKey() != candidate[0].moduleKey() {
+			candidate = append(candidate, module)
+		}
+	}
+	if _, err := compilePolicies(ctx, candidate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Policy does not compile", "details": err.Error()})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	if _, err := e.as.db.ExecContext(ctx, `
+		INSERT INTO authorization_policies (name, module, updated_by, updated_at) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (name) DO UPDATE SET module = $2, updated_by = $3, updated_at = NOW()`,
+		name, req.Module, adminID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save policy"})
+		return
+	}
+	e.respondReloaded(c, gin.H{"name": name, "saved": true})
+}
+
+// AdminDeletePolicy removes a stored module
+func (e *PolicyEngine) AdminDeletePolicy(c *gin.Context) {
+	result, err := e.as.db.ExecContext(c.Request.Context(), `DELETE FROM authorization_policies WHERE name = $1`, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete policy"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Policy not found"})
+		return
+	}
+	e.respondReloaded(c, gin.H{"name": c.Param("name"), "deleted": true})
+}
+
+// respondReloaded applies a change on this replica at once; the others pick it up on their
+// next reload
+func (e *PolicyEngine) respondReloaded(c *gin.Context, body gin.H) {
+	if err := e.Reload(c.Request.Context()); err != nil {
+		body["reload_error"] = err.Error()
+	}
+	e.mu.RLock()
+	if e.current != nil {
+		body["revision"] = e.current.revision
+	}
+	e.mu.RUnlock()
+	c.JSON(http.StatusOK, body)
+}
+
+// AdminEvaluatePolicy runs one decision against the loaded policies without acting on it,
+// to try a policy out before enforcing it
+func (e *PolicyEngine) AdminEvaluatePolicy(c *gin.Context) {
+	var req struct {
+		Decision string                 `json:"decision" binding:"required"`
+		Input    map[string]interface{} `json:"input"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || policyQueries[req.Decision] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "decision must be scopes, admin or profile"})
+		return
+	}
+	e.mu.RLock()
+	current := e.current
+	e.mu.RUnlock()
+	if current == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No policies are loaded"})
+		return
+	}
+	results, err := current.queries[req.Decision].Eval(c.Request.Context(), rego.EvalInput(req.Input))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Policy evaluation failed", "details": err.Error()})
+		return
+	}
+	body := gin.H{"decision": req.Decision, "revision": current.revision, "defined": false}
+	if len(results) > 0 && len(results[0].Expressions) > 0 {
+		body["defined"] = true
+		body["result"] = results[0].Expressions[0].Value
+	}
+	c.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+// testPolicy never grants write, only admits admins to the admin API, and hides every
+// profile from anonymous viewers
+const testPolicy = `package liberation.authz
+
+import future.keywords.if
+import future.keywords.in
+
+scopes := [s | some s in input.scopes; s != "write"]
+
+default admin := false
+
+admin if "admin" in input.roles
+
+profile := "hidden" if not input.viewer.signed_in
+`
+
+type PolicyEngineTestSuite struct {
+	suite.Suite
+}
+
+func (suite *PolicyEngineTestSuite) engine(mode string, source string) *PolicyEngine {
+	engine := NewPolicyEngine(&AuthService{}, PolicyEngineConfig{Mode: mode})
+	compiled, err := compilePolicies(context.Background(), []policyModule{{Name: "authz.rego", Source: "file", text: source}})
+	suite.Require().NoError(err)
+	engine.current = compiled
+	return engine
+}
+
+func (suite *PolicyEngineTestSuite) TestConfig() {
+	config, err := DefaultPolicyEngineConfig()
+	suite.Require().NoError(err)
+	suite.Nil(NewPolicyEngine(&AuthService{}, config), "the engine is off by default")
+
+	suite.T().Setenv("POLICY_ENGINE_MODE", "shadow")
+	config, err = DefaultPolicyEngineConfig()
+	suite.Require().NoError(err)
+	suite.Equal(policyModeShadow, config.Mode)
+
+	suite.T().Setenv("POLICY_ENGINE_MODE", "audit")
+	_, err = DefaultPolicyEngineConfig()
+	suite.ErrorContains(err, "POLICY_ENGINE_MODE")
+
+	suite.T().Setenv("POLICY_ENGINE_MODE", "enforce")
+	suite.T().Setenv("POLICY_DIR", "/nonexistent/policies")
+	_, err = DefaultPolicyEngineConfig()
+	suite.ErrorContains(err, "POLICY_DIR")
+}
+
+func (suite *PolicyEngineTestSuite) TestCompile() {
+	_, err := compilePolicies(context.Background(), []policyModule{{Name: "broken.rego", Source: "database", text: "package liberation.authz\n\nscopes := ["}})
+	suite.Error(err)
+
+	compiled, err := compilePolicies(context.Background(), nil)
+	suite.Require().NoError(err, "no policies at all is valid; every decision is undefined")
+	suite.Len(compiled.queries, len(policyQueries))
+
+	a := []policyModule{{Name: "a.rego", Source: "file", text: "package a"}, {Name: "b.rego", Source: "database", text: "package b"}}
+	b := []policyModule{a[1], a[0]}
+	suite.Equal(policyRevision(a), policyRevision(b), "the revision does not depend on load order")
+	b[0].text = "package c"
+	suite.NotEqual(policyRevision(a), policyRevision(b))
+}
+
+func (suite *PolicyEngineTestSuite) TestSamePolicyResult() {
+	suite.True(samePolicyResult([]interface{}{"read"}, []string{"read"}))
+	suite.True(samePolicyResult(true, true))
+	suite.False(samePolicyResult([]interface{}{}, []string{"read"}))
+	suite.False(samePolicyResult("visible", "hidden"))
+}
+
+func (suite *PolicyEngineTestSuite) TestGrantScopes() {
+	ctx := context.Background()
+	requested := []string{"read", "write"}
+
+	shadow := suite.engine(policyModeShadow, testPolicy)
+	suite.Equal(requested, shadow.grantScopes(ctx, "client_credentials", uuid.New(), nil, requested), "shadow mode only reports")
+
+	enforce := suite.engine(policyModeEnforce, testPolicy)
+	suite.Equal([]string{"read"}, enforce.grantScopes(ctx, "client_credentials", uuid.New(), nil, requested))
+
+	widening := suite.engine(policyModeEnforce, "package liberation.authz\n\nscopes := [\"read\", \"admin\"]")
+	suite.Equal([]string{"read"}, widening.grantScopes(ctx, "client_credentials", uuid.New(), nil, requested), "a policy cannot add scopes")
+
+	undefined := suite.engine(policyModeEnforce, "package liberation.other")
+	suite.Equal(requested, undefined.grantScopes(ctx, "client_credentials", uuid.New(), nil, requested))
+
+	var off *PolicyEngine
+	suite.Equal(requested, off.grantScopes(ctx, "client_credentials", uuid.New(), nil, requested))
+}
+
+func (suite *PolicyEngineTestSuite) TestProfileAccess() {
+	ctx := context.Background()
+	anonymous := profileRelationship{}
+	signedIn := profileRelationship{SignedIn: true}
+
+	enforce := suite.engine(policyModeEnforce, testPolicy)
+	suite.Equal(profileHidden, enforce.profileAccess(ctx, anonymous, profileVisibilityPublic))
+	suite.Equal(profileVisible, enforce.profileAccess(ctx, signedIn, profileVisibilityPublic), "undefined falls back to the built-in policy")
+
+	shadow := suite.engine(policyModeShadow, testPolicy)
+	suite.Equal(profileVisible, shadow.profileAccess(ctx, anonymous, profileVisibilityPublic))
+
+	invalid := suite.engine(policyModeEnforce, "package liberation.authz\n\nprofile := \"maybe\"")
+	suite.Equal(profileVisible, invalid.profileAccess(ctx, anonymous, profileVisibilityPublic), "an unknown answer is ignored")
+}
+
+func (suite *PolicyEngineTestSuite) TestAdminMiddleware() {
+	gin.SetMode(gin.TestMode)
+	serve := func(engine *PolicyEngine, route string) int {
+		r := gin.New()
+		r.Use(PolicyAdminMiddleware(&AuthService{policies: engine}))
+		r.GET(route, func(c *gin.Context) { c.Status(http.StatusOK) })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, route, nil))
+		return w.Code
+	}
+
+	suite.Equal(http.StatusForbidden, serve(suite.engine(policyModeEnforce, testPolicy), "/api/v1/admin/users"))
+	suite.Equal(http.StatusOK, serve(suite.engine(policyModeEnforce, testPolicy), "/api/v1/admin/policies"), "the policy routes stay reachable")
+	suite.Equal(http.StatusOK, serve(suite.engine(policyModeShadow, testPolicy), "/api/v1/admin/users"))
+	suite.Equal(http.StatusOK, serve(nil, "/api/v1/admin/users"))
+}
+
+func TestPolicyEngineTestSuite(t *testing.T) {
+	suite.Run(t, new(PolicyEngineTestSuite))
+}
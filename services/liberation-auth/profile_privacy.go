@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Profile visibility levels, stored in user_preferences.profile_visibility
+const (
+	profileVisibilityPublic  = "public"
+	profileVisibilityUsers   = "users"
+	profileVisibilityFriends = "friends"
+	profileVisibilityPrivate = "private"
+)
+
+// profileAccess is the outcome of checking a viewer against a profile
+type profileAccess int
+
+const (
+	profileVisible profileAccess = iota
+	// profileHidden: the viewer may know the profile exists but not see it
+	profileHidden
+	// profileConcealed: the viewer is blocked and is told the profile does not exist
+	profileConcealed
+)
+
+// profileRelationship is how a viewer stands towards a profile's owner
+type profileRelationship struct {
+	Self     bool
+	SignedIn bool
+	Friends  bool
+	// Blocked is a full block in either direction
+	Blocked bool
+}
+
+// profileAccessFor is the privacy policy every endpoint that exposes a profile goes through.
+// Blocks win over everything but the owner's own view, and unknown visibility levels are
+// treated as private.
+func profileAccessFor(rel profileRelationship, visibility string) profileAccess {
+	if rel.Self {
+		return profileVisible
+	}
+	if rel.Blocked {
+		return profileConcealed
+	}
+	switch visibility {
+	case "", profileVisibilityPublic:
+		return profileVisible
+	case profileVisibilityUsers:
+		if rel.SignedIn {
+			return profileVisible
+		}
+	case profileVisibilityFriends:
+		if rel.Friends {
+			return profileVisible
+		}
+	}
+	return profileHidden
+}
+
+// profileOwner is the profile a request is about
+type profileOwner struct {
+	ID           uuid.UUID
+	Username     string
+	Visibility   string
+	Relationship profileRelationship
+}
+
+// viewerID returns the signed-in user, or nil for anonymous viewers. JWTAuthMiddleware
+// stores a uuid.UUID; older callers store the string form.
+func viewerID(c *gin.Context) *uuid.UUID {
+	switch value := c.Value("user_id").(type) {
+	case uuid.UUID:
+		return &value
+	case string:
+		if parsed, err := uuid.Parse(value); err == nil {
+			return &parsed
+		}
+	}
+	return nil
+}
+
+// profileRelationships loads the viewer's relationship to each owner in one query
+func (as *AuthService) profileRelationships(ctx context.Context, viewer *uuid.UUID, owners []uuid.UUID) (map[uuid.UUID]profileRelationship, error) {
+	relationships := make(map[uuid.UUID]profileRelationship, len(owners))
+	if viewer == nil {
+		for _, owner := range owners {
+			relationships[owner] = profileRelationship{}
+		}
+		return relationships, nil
+	}
+
+	ownerIDs := make([]string, len(owners))
+	for i, owner := range owners {
+		ownerIDs[i] = owner.String()
+	}
+	rows, err := as.db.QueryContext(ctx, `
+		SELECT o.id,
+			EXISTS(SELECT 1 FROM user_relationships r WHERE r.status = 'accepted'
+				AND ((r.requester_id = $1 AND r.addressee_id = o.id) OR (r.requester_id = o.id AND r.addressee_id = $1))),
+			EXISTS(SELECT 1 FROM user_blocks b WHERE b.block_type = 'full'
+				AND ((b.blocker_id = $1 AND b.blocked_id = o.id) OR (b.blocker_id = o.id AND b.blocked_id = $1)))
+		FROM unnest($2::uuid[]) AS o(id)`, *viewer, pq.Array(ownerIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var owner uuid.UUID
+		rel := profileRelationship{SignedIn: true}
+		if err := rows.Scan(&owner, &rel.Friends, &rel.Blocked); err != nil {
+			return nil, err
+		}
+		rel.Self = owner == *viewer
+		relationships[owner] = rel
+	}
+	return relationships, rows.Err()
+}
+
+// enforceProfilePrivacy resolves the :username in the path and applies the privacy policy to
+// it. When the profile may not be seen it writes the response and returns false. The result
+// is kept on the context, so handlers behind ProfilePrivacyMiddleware do not check twice.
+func (as *AuthService) enforceProfilePrivacy(c *gin.Context) (*profileOwner, bool) {
+	if owner, ok := c.Get("profile_owner"); ok {
+		return owner.(*profileOwner), true
+	}
+
+	username := c.Param("username")
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username is required"})
+		return nil, false
+	}
+	owner := &profileOwner{}
+	var visibility sql.NullString
+	err := as.db.QueryRowContext(c.Request.Context(), `
+		SELECT u.id, u.username, up.profile_visibility
+		FROM users u LEFT JOIN user_preferences up ON u.id = up.user_id
+		WHERE u.username = $1 AND u.is_active = true`, username).Scan(&owner.ID, &owner.Username, &visibility)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user profile"})
+		return nil, false
+	}
+	owner.Visibility = visibility.String
+
+	relationships, err := as.profileRelationships(c.Request.Context(), viewerID(c), []uuid.UUID{owner.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user profile"})
+		return nil, false
+	}
+	owner.Relationship = relationships[owner.ID]
+
+	switch as.policies.profileAccess(c.Request.Context(), owner.Relationship, owner.Visibility) {
+	case profileConcealed:
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	case profileHidden:
+		c.JSON(http.StatusForbidden, gin.H{"error": "This profile is private"})
+		return nil, false
+	}
+	c.Set("profile_owner", owner)
+	return owner, true
+}
+
+// ProfilePrivacyMiddleware guards every route under /users/:username with the profile
+// privacy policy
+func ProfilePrivacyMiddleware(authService *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := authService.enforceProfilePrivacy(c); !ok {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// visibleProfiles filters a list of profiles, such as search results or a friend list, down
+// to those the viewer may see. Each entry is checked on its own terms: a friend with a
+// private profile is left out of their friends' lists for everyone else.
+func (as *AuthService) visibleProfiles(ctx context.Context, viewer *uuid.UUID, profiles []profileOwner) ([]profileOwner, error) {
+	ids := make([]uuid.UUID, len(profiles))
+	for i, profile := range profiles {
+		ids[i] = profile.ID
+	}
+	relationships, err := as.profileRelationships(ctx, viewer, ids)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]profileOwner, 0, len(profiles))
+	for _, profile := range profiles {
+		profile.Relationship = relationships[profile.ID]
+		if as.policies.profileAccess(ctx, profile.Relationship, profile.Visibility) == profileVisible {
+			visible = append(visible, profile)
+		}
+	}
+	return visible, nil
+}
+
+// GetUserFriends lists a user's friends, leaving out those the viewer may not see
+func (as *AuthService) GetUserFriends(c *gin.Context) {
+	owner, ok := as.enforceProfilePrivacy(c)
+	if !ok {
+		return
+	}
+
+	rows, err := as.db.QueryContext(c.Request.Context(), `
+		SELECT u.id, u.username, up.profile_visibility
+		FROM user_relationships r
+		JOIN users u ON u.id = CASE WHEN r.requester_id = $1 THEN r.addressee_id ELSE r.requester_id END
+		LEFT JOIN user_preferences up ON u.id = up.user_id
+		WHERE (r.requester_id = $1 OR r.addressee_id = $1) AND r.status = 'accepted' AND u.is_active = true
+		ORDER BY u.username`, owner.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve friends"})
+		return
+	}
+	defer rows.Close()
+	var friends []profileOwner
+	for rows.Next() {
+		var friend profileOwner
+		var visibility sql.NullString
+		if err := rows.Scan(&friend.ID, &friend.Username, &visibility); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve friends"})
+			return
+		}
+		friend.Visibility = visibility.String
+		friends = append(friends, friend)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve friends"})
+		return
+	}
+
+	visible, err := as.visibleProfiles(c.Request.Context(), viewerID(c), friends)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve friends"})
+		return
+	}
+	usernames := make([]string, len(visible))
+	for i, friend := range visible {
+		usernames[i] = friend.Username
+	}
+	c.JSON(http.StatusOK, gin.H{"friends": usernames})
+}
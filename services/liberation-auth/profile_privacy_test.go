@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProfilePrivacyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ProfilePrivacyTestSuite) TestPolicyMatrix() {
+	anonymous := profileRelationship{}
+	signedIn := profileRelationship{SignedIn: true}
+	friend := profileRelationship{SignedIn: true, Friends: true}
+	owner := profileRelationship{SignedIn: true, Self: true}
+	blocked := profileRelationship{SignedIn: true, Blocked: true}
+	blockedFriend := profileRelationship{SignedIn: true, Friends: true, Blocked: true}
+
+	matrix := []struct {
+		viewer     string
+		rel        profileRelationship
+		visibility map[string]profileAccess
+	}{
+		{"anonymous", anonymous, map[string]profileAccess{
+			"": profileVisible, "public": profileVisible, "users": profileHidden, "friends": profileHidden, "private": profileHidden,
+		}},
+		{"signed in", signedIn, map[string]profileAccess{
+			"": profileVisible, "public": profileVisible, "users": profileVisible, "friends": profileHidden, "private": profileHidden,
+		}},
+		{"friend", friend, map[string]profileAccess{
+			"": profileVisible, "public": profileVisible, "users": profileVisible, "friends": profileVisible, "private": profileHidden,
+		}},
+		{"owner", owner, map[string]profileAccess{
+			"": profileVisible, "public": profileVisible, "users": profileVisible, "friends": profileVisible, "private": profileVisible,
+		}},
+		{"blocked", blocked, map[string]profileAccess{
+			"": profileConcealed, "public": profileConcealed, "users": profileConcealed, "friends": profileConcealed, "private": profileConcealed,
+		}},
+		{"blocked friend", blockedFriend, map[string]profileAccess{
+			"public": profileConcealed, "friends": profileConcealed,
+		}},
+	}
+	for _, row := range matrix {
+		for visibility, want := range row.visibility {
+			suite.Equal(want, profileAccessFor(row.rel, visibility), fmt.Sprintf("%s viewing a %q profile", row.viewer, visibility))
+		}
+	}
+}
+
+func (suite *ProfilePrivacyTestSuite) TestUnknownVisibilityIsPrivate() {
+	suite.Equal(profileHidden, profileAccessFor(profileRelationship{SignedIn: true, Friends: true}, "followers"))
+	suite.Equal(profileVisible, profileAccessFor(profileRelationship{Self: true}, "followers"))
+}
+
+func (suite *ProfilePrivacyTestSuite) TestListsAreFilteredPerProfile() {
+	// Anonymous viewers need no relationship lookup, so no database is involved
+	as := &AuthService{}
+	profiles := []profileOwner{
+		{ID: uuid.New(), Username: "open", Visibility: "public"},
+		{ID: uuid.New(), Username: "members", Visibility: "users"},
+		{ID: uuid.New(), Username: "unset"},
+		{ID: uuid.New(), Username: "hidden", Visibility: "private"},
+	}
+	visible, err := as.visibleProfiles(context.Background(), nil, profiles)
+	suite.Require().NoError(err)
+	suite.Len(visible, 2)
+	suite.Equal("open", visible[0].Username)
+	suite.Equal("unset", visible[1].Username)
+}
+
+func TestProfilePrivacy(t *testing.T) {
+	suite.Run(t, new(ProfilePrivacyTestSuite))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"liberation-profiling"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultProfilingConfig reads PROFILING_PPROF, PROFILING_BLOCK_RATE, PROFILING_MUTEX_FRACTION
+// and the PROFILING_PUSH_* settings. pprof is only served on the dedicated admin listener,
+// so PROFILING_PPROF requires ADMIN_LISTEN_ADDR.
+func DefaultProfilingConfig(admin AdminListenerConfig) (profiling.Config, error) {
+	config := profiling.Config{Push: profiling.PushConfig{AppName: "liberation-auth"}}
+	config.ApplyEnv("PROFILING_")
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	if config.Pprof && !admin.Separate() {
+		return config, fmt.Errorf("PROFILING_PPROF requires ADMIN_LISTEN_ADDR; pprof is never served on the public listener")
+	}
+	return config, nil
+}
+
+// registerProfilingRoutes serves /debug/pprof on the admin router when enabled
+func registerProfilingRoutes(routes routeGroup, authService *AuthService) {
+	if !authService.profiling.Pprof {
+		return
+	}
+	debug := routes.Group(profiling.PathPrefix)
+	debug.Any("/*profile", gin.WrapH(profiling.Handler()))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"nuclear-ao3/shared/models"
+)
+
+// OIDC prompt values (OpenID Connect Core 1.0, section 3.1.2.1)
+const (
+	promptNone          = "none"
+	promptLogin         = "login"
+	promptConsent       = "consent"
+	promptSelectAccount = "select_account"
+)
+
+var promptNoneFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_prompt_none_failures_total",
+	Help: "Silent (prompt=none) authorization requests that needed user interaction, by error.",
+}, []string{"error"})
+
+// authorizePrompt carries the prompt and max_age parameters of an authorization request.
+// It travels with parked requests so the checks are repeated after login.
+type authorizePrompt struct {
+	Values []string `json:"values,omitempty"`
+	MaxAge *int     `json:"max_age,omitempty"`
+	// LoginSince is set on resumed requests that demanded a fresh login: the user
+	// must have logged in after the request was parked
+	LoginSince *time.Time `json:"login_since,omitempty"`
+}
+
+// parseAuthorizePrompt reads prompt and max_age from the query string or form
+func parseAuthorizePrompt(c *gin.Context) (authorizePrompt, error) {
+	var prompt authorizePrompt
+
+	raw := c.Query("prompt")
+	if raw == "" {
+		raw = c.PostForm("prompt")
+	}
+	for _, value := range strings.Fields(raw) {
+		switch value {
+		case promptNone, promptLogin, promptConsent, promptSelectAccount:
+			if !contains(prompt.Values, value) {
+				prompt.Values = append(prompt.Values, value)
+			}
+		default:
+			return prompt, fmt.Errorf("unsupported prompt value %q", value)
+		}
+	}
+	if prompt.has(promptNone) && len(prompt.Values) > 1 {
+		return prompt, fmt.Errorf("prompt=none cannot be combined with other values")
+	}
+
+	rawMaxAge := c.Query("max_age")
+	if rawMaxAge == "" {
+		rawMaxAge = c.PostForm("max_age")
+	}
+	if rawMaxAge != "" {
+		maxAge, err := strconv.Atoi(rawMaxAge)
+		if err != nil || maxAge < 0 {
+			return prompt, fmt.Errorf("max_age must be a non-negative number of seconds")
+		}
+		prompt.MaxAge = &maxAge
+	}
+	return prompt, nil
+}
+
+func (p authorizePrompt) has(value string) bool {
+	return contains(p.Values, value)
+}
+
+// demandsLogin reports whether the request asks for re-authentication regardless of the session.
+// There is one session per browser, so select_account is served by logging in again.
+func (p authorizePrompt) demandsLogin() bool {
+	return p.has(promptLogin) || p.has(promptSelectAccount) || p.MaxAge != nil
+}
+
+// needsLogin reports whether the authenticated user must log in again before a code is issued
+func (p authorizePrompt) needsLogin(user *models.User, now time.Time) bool {
+	if p.LoginSince != nil {
+		return user.LastLoginAt == nil || user.LastLoginAt.Before(*p.LoginSince)
+	}
+	if p.has(promptLogin) || p.has(promptSelectAccount) {
+		return true
+	}
+	if p.MaxAge != nil {
+		return user.LastLoginAt == nil || now.Sub(*user.LastLoginAt) > time.Duration(*p.MaxAge)*time.Second
+	}
+	return false
+}
+
+// resumed returns the prompt to apply once a parked request is resumed after login
+func (p authorizePrompt) resumed(parkedAt time.Time) authorizePrompt {
+	// prompt=consent still applies; login-related values are satisfied by the login itself
+	resumed := authorizePrompt{}
+	if p.has(promptConsent) {
+		resumed.Values = []string{promptConsent}
+	}
+	if p.demandsLogin() {
+		resumed.LoginSince = &parkedAt
+	}
+	return resumed
+}
+
+// promptNoneError redirects a prompt=none request that would need user interaction.
+// Silent renewal clients treat login_required, consent_required and interaction_required alike.
+func (as *AuthService) promptNoneError(c *gin.Context, req models.AuthorizeRequest, errorCode, description string) {
+	promptNoneFailuresTotal.WithLabelValues(errorCode).Inc()
+	as.redirectWithError(c, req.RedirectURI, req.State, errorCode, description)
+}
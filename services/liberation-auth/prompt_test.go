@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type PromptTestSuite struct {
+	suite.Suite
+}
+
+func (suite *PromptTestSuite) parse(query string) (authorizePrompt, error) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/auth/authorize?"+query, nil)
+	return parseAuthorizePrompt(c)
+}
+
+func (suite *PromptTestSuite) TestParsing() {
+	prompt, err := suite.parse("prompt=login%20consent&max_age=300")
+	suite.Require().NoError(err)
+	suite.True(prompt.has(promptLogin))
+	suite.True(prompt.has(promptConsent))
+	suite.Equal(300, *prompt.MaxAge)
+
+	_, err = suite.parse("prompt=none%20login")
+	suite.Error(err, "none is exclusive")
+	_, err = suite.parse("prompt=always")
+	suite.Error(err)
+	_, err = suite.parse("max_age=-1")
+	suite.Error(err)
+}
+
+func (suite *PromptTestSuite) TestMaxAge() {
+	now := time.Now()
+	loggedIn := now.Add(-10 * time.Minute)
+	user := &models.User{LastLoginAt: &loggedIn}
+
+	long, short := 3600, 60
+	suite.False(authorizePrompt{MaxAge: &long}.needsLogin(user, now))
+	suite.True(authorizePrompt{MaxAge: &short}.needsLogin(user, now))
+	suite.True(authorizePrompt{MaxAge: &long}.needsLogin(&models.User{}, now), "never logged in")
+}
+
+func (suite *PromptTestSuite) TestResumedRequestNeedsLoginAfterParking() {
+	parkedAt := time.Now().Add(-time.Minute)
+	resumed := authorizePrompt{Values: []string{promptLogin, promptConsent}}.resumed(parkedAt)
+
+	suite.Equal([]string{promptConsent}, resumed.Values)
+	before, after := parkedAt.Add(-time.Hour), parkedAt.Add(time.Second)
+	suite.True(resumed.needsLogin(&models.User{LastLoginAt: &before}, time.Now()))
+	suite.False(resumed.needsLogin(&models.User{LastLoginAt: &after}, time.Now()))
+
+	// A request parked only because there was no session has nothing left to check
+	suite.Nil(authorizePrompt{}.resumed(parkedAt).LoginSince)
+}
+
+func (suite *PromptTestSuite) TestSensitiveConsentExpiresSooner() {
+	config := ConsentConfig{SensitiveScopes: []string{"write"}, SensitiveTTL: 90 * 24 * time.Hour}
+	grantedAt := time.Now()
+
+	suite.Nil(config.expiresAt(grantedAt, []string{"openid", "read"}))
+	suite.Equal(grantedAt.Add(90*24*time.Hour), *config.expiresAt(grantedAt, []string{"read", "write"}))
+
+	config.TTL = 24 * time.Hour
+	suite.Equal(grantedAt.Add(24*time.Hour), *config.expiresAt(grantedAt, []string{"read", "write"}))
+}
+
+func TestPromptTestSuite(t *testing.T) {
+	suite.Run(t, new(PromptTestSuite))
+}
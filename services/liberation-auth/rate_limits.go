@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitStatus is one limit that applies to the caller, as reported by GetLimits
+type rateLimitStatus struct {
+	RateLimitHeaders
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// GetLimits reports the limits that apply to the caller and what is left of them, without
+// charging anything, so clients can pace themselves instead of running into 429s. Callers
+// are told apart as RateLimitMiddleware tells them apart, so the request limit includes this
+// request.
+func (as *AuthService) GetLimits(guest GuestTokenConfig) gin.HandlerFunc {
+	limiter := &RateLimitManager{redisClient: as.redis, serviceName: "auth-service"}
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		clientIP := GetClientIP(c.Request)
+		key, config := limiter.rateLimitKey(ExtractOAuthInfo(c.Request), clientIP)
+		limits := gin.H{
+			"requests": rateLimitStatus{*limiter.peekLimit(ctx, key, config), int(config.Window.Seconds())},
+		}
+		if guest.Enabled {
+			config := RateLimitConfig{Tier: "guest", Requests: guest.PerIPLimit, Window: guest.PerIPWindow}
+			limits["guest_tokens"] = rateLimitStatus{
+				*limiter.peekLimit(ctx, "rate_limit:auth-service:guest:"+clientIP, config),
+				int(config.Window.Seconds()),
+			}
+		}
+		c.JSON(http.StatusOK, limits)
+	}
+}
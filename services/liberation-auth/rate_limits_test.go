@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimitsTestSuite struct {
+	suite.Suite
+	server *miniredis.Miniredis
+	redis  *redis.Client
+}
+
+func (suite *RateLimitsTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.server = miniredis.RunT(suite.T())
+	suite.redis = redis.NewClient(&redis.Options{Addr: suite.server.Addr()})
+}
+
+func (suite *RateLimitsTestSuite) TearDownTest() {
+	suite.redis.Close()
+}
+
+func (suite *RateLimitsTestSuite) TestRefusalsCarryRetryAfter() {
+	limiter := &RateLimitManager{redisClient: suite.redis, serviceName: "auth-service"}
+	limit := RateLimitConfig{Tier: "test", Requests: 1, Window: time.Minute}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	headers, err := limiter.checkLimitWithConfig("rate_limit:test", limit)
+	suite.Require().NoError(err)
+	headers.apply(c, false)
+	suite.Equal("0", recorder.Header().Get("X-RateLimit-Remaining"))
+	suite.Empty(recorder.Header().Get("Retry-After"), "allowed requests are not told to retry")
+
+	recorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	headers, err = limiter.checkLimitWithConfig("rate_limit:test", limit)
+	suite.Require().Error(err)
+	headers.apply(c, true)
+	suite.Equal("1", recorder.Header().Get("X-RateLimit-Limit"))
+	suite.Equal(strconv.FormatInt(headers.Reset, 10), recorder.Header().Get("X-RateLimit-Reset"))
+	retryAfter, err := strconv.Atoi(recorder.Header().Get("Retry-After"))
+	suite.Require().NoError(err)
+	suite.GreaterOrEqual(retryAfter, 1)
+	suite.LessOrEqual(retryAfter, 60, "the window is a minute long")
+}
+
+func (suite *RateLimitsTestSuite) TestRetryAfterIsAtLeastOneSecond() {
+	headers := RateLimitHeaders{Reset: time.Now().Add(-time.Minute).Unix()}
+	suite.Equal(1, headers.RetryAfter())
+	headers.Reset = time.Now().Add(90 * time.Second).Unix()
+	suite.InDelta(90, headers.RetryAfter(), 1)
+}
+
+func (suite *RateLimitsTestSuite) TestLimitsChargeNothing() {
+	as := &AuthService{redis: suite.redis}
+	router := gin.New()
+	router.GET("/limits", as.GetLimits(GuestTokenConfig{Enabled: true, PerIPLimit: 10, PerIPWindow: time.Hour}))
+
+	limiter := &RateLimitManager{redisClient: suite.redis, serviceName: "auth-service"}
+	key, config := limiter.rateLimitKey(&ClientRateLimitInfo{}, "192.0.2.1")
+	_, err := limiter.checkLimitWithCost(key, config, 3)
+	suite.Require().NoError(err)
+
+	var limits struct {
+		Requests    rateLimitStatus  `json:"requests"`
+		GuestTokens *rateLimitStatus `json:"guest_tokens"`
+	}
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/limits", nil)
+		request.RemoteAddr = "192.0.2.1:1234"
+		router.ServeHTTP(recorder, request)
+		suite.Require().Equal(http.StatusOK, recorder.Code)
+		suite.Require().NoError(json.Unmarshal(recorder.Body.Bytes(), &limits))
+
+		suite.Equal(string(RateLimitTierAnonymous), limits.Requests.Tier)
+		suite.Equal(config.Requests-3, limits.Requests.Remaining, "reading the limits does not spend them")
+		suite.Equal(60, limits.Requests.WindowSeconds)
+	}
+	suite.Require().NotNil(limits.GuestTokens)
+	suite.Equal(10, limits.GuestTokens.Remaining)
+	suite.Equal(3600, limits.GuestTokens.WindowSeconds)
+}
+
+func TestRateLimits(t *testing.T) {
+	suite.Run(t, new(RateLimitsTestSuite))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"liberation-cache"
+	"nuclear-ao3/shared/models"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+// ReadCacheConfig sizes the caches in front of client lookups and profile reads
+type ReadCacheConfig struct {
+	Enabled  bool
+	Clients  cache.Config
+	Profiles cache.Config
+}
+
+// DefaultReadCacheConfig reads READ_CACHE_ENABLED, READ_CACHE_MAX_ENTRIES, READ_CACHE_LOCAL_TTL
+// (30s) and READ_CACHE_REMOTE_TTL (5m). The local TTL bounds how long a replica can serve a
+// value whose invalidation it missed; the remote TTL bounds profile counts kept by other
+// services, which are not invalidated here. A remote TTL of 0 keeps nothing in Redis.
+func DefaultReadCacheConfig() (ReadCacheConfig, error) {
+	config := ReadCacheConfig{
+		Enabled:  getEnv("READ_CACHE_ENABLED", "true") == "true",
+		Clients:  cache.DefaultConfig("oauth_clients"),
+		Profiles: cache.DefaultConfig("user_profiles"),
+	}
+	maxEntries, err := strconv.Atoi(getEnv("READ_CACHE_MAX_ENTRIES", strconv.Itoa(config.Clients.MaxEntries)))
+	if err != nil {
+		return config, fmt.Errorf("READ_CACHE_MAX_ENTRIES must be a number: %w", err)
+	}
+	localTTL, err := time.ParseDuration(getEnv("READ_CACHE_LOCAL_TTL", "30s"))
+	if err != nil {
+		return config, fmt.Errorf("READ_CACHE_LOCAL_TTL must be a duration such as 30s: %w", err)
+	}
+	remoteTTL, err := time.ParseDuration(getEnv("READ_CACHE_REMOTE_TTL", "5m"))
+	if err != nil {
+		return config, fmt.Errorf("READ_CACHE_REMOTE_TTL must be a duration such as 5m: %w", err)
+	}
+	if localTTL%time.Second != 0 || remoteTTL%time.Second != 0 {
+		return config, fmt.Errorf("READ_CACHE_LOCAL_TTL and READ_CACHE_REMOTE_TTL must be whole seconds")
+	}
+	for _, c := range []*cache.Config{&config.Clients, &config.Profiles} {
+		c.MaxEntries = maxEntries
+		c.LocalTTLSeconds = int(localTTL / time.Second)
+		c.RemoteTTLSeconds = int(remoteTTL / time.Second)
+		if err := c.Validate(); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// readCaches hold OAuth clients by ID and user profiles by user ID in process and in Redis.
+// Writers invalidate them with forgetClient and forgetProfiles, which reach every replica.
+type readCaches struct {
+	clients  *cache.Cache[models.OAuthClient]
+	profiles *cache.Cache[models.UserProfile]
+}
+
+// newReadCaches returns nil when the caches are disabled
+func newReadCaches(config ReadCacheConfig, rdb *redis.Client, pii *piiCipher) *readCaches {
+	if !config.Enabled {
+		return nil
+	}
+	var remote cache.Remote
+	if rdb != nil {
+		remote = redisCacheRemote{rdb: rdb}
+	}
+	return &readCaches{
+		clients:  cache.New[models.OAuthClient](config.Clients, remote),
+		profiles: cache.New[models.UserProfile](config.Profiles, remote).WithCodec(profileCodec{pii: pii}),
+	}
+}
+
+// run applies invalidations from other replicas until ctx is cancelled
+func (rc *readCaches) run(ctx context.Context) {
+	if rc == nil {
+		return
+	}
+	go rc.clients.Listen(ctx)
+	rc.profiles.Listen(ctx)
+}
+
+// forgetClient drops a client from every replica's cache; call it after changing or
+// deleting the client's row
+func (as *AuthService) forgetClient(ctx context.Context, clientID string) {
+	if as.caches == nil {
+		return
+	}
+	if err := as.caches.clients.Invalidate(ctx, clientID); err != nil {
+		log.Printf("Failed to invalidate cached client %s: %v", clientID, err)
+	}
+}
+
+// forgetProfiles drops users' profiles from every replica's cache; call it after changing
+// anything GetUserProfile returns
+func (as *AuthService) forgetProfiles(ctx context.Context, userIDs ...uuid.UUID) {
+	if as.caches == nil {
+		return
+	}
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = userID.String()
+	}
+	if err := as.caches.profiles.Invalidate(ctx, keys...); err != nil {
+		log.Printf("Failed to invalidate cached profiles %v: %v", keys, err)
+	}
+}
+
+// profileCodec keeps the profile's location encrypted in Redis as it is in Postgres
+type profileCodec struct {
+	pii *piiCipher
+}
+
+func (pc profileCodec) Encode(profile models.UserProfile) ([]byte, error) {
+	location, err := pc.pii.encrypt(piiUserLocation, profile.Location)
+	if err != nil {
+		return nil, err
+	}
+	profile.Location = location
+	return cache.GobCodec[models.UserProfile]{}.Encode(profile)
+}
+
+func (pc profileCodec) Decode(data []byte) (models.UserProfile, error) {
+	profile, err := cache.GobCodec[models.UserProfile]{}.Decode(data)
+	if err != nil {
+		return profile, err
+	}
+	profile.Location, err = pc.pii.decrypt(piiUserLocation, profile.Location)
+	return profile, err
+}
+
+// redisCacheRemote is the shared tier of the read caches
+type redisCacheRemote struct {
+	rdb *redis.Client
+}
+
+func (r redisCacheRemote) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := r.rdb.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, cache.ErrMiss
+	}
+	return data, err
+}
+
+func (r redisCacheRemote) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.rdb.Set(ctx, key, value, ttl).Err()
+}
+
+func (r redisCacheRemote) Delete(ctx context.Context, keys ...string) error {
+	return r.rdb.Del(ctx, keys...).Err()
+}
+
+func (r redisCacheRemote) Publish(ctx context.Context, channel, message string) error {
+	return r.rdb.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe resubscribes after errors until ctx is cancelled. Messages published while the
+// subscription is down are lost; the local TTL bounds how long that leaves a value stale.
+func (r redisCacheRemote) Subscribe(ctx context.Context, channel string, deliver func(message string)) error {
+	pubsub := r.rdb.Subscribe(ctx, channel)
+	defer pubsub.Close()
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Cache subscription error on %s: %v", channel, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if m, ok := msg.(*redis.Message); ok {
+			deliver(m.Payload)
+		}
+	}
+}
+
+// readCacheCollector exports the read caches' stats to Prometheus
+type readCacheCollector struct {
+	caches *readCaches
+
+	lookups, invalidations, remoteErrors, entries *prometheus.Desc
+}
+
+func newReadCacheCollector(caches *readCaches) *readCacheCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc("liberation_auth_read_cache_"+name, help, append([]string{"cache"}, labels...), nil)
+	}
+	return &readCacheCollector{
+		caches:        caches,
+		lookups:       desc("lookups_total", "Lookups, by where the value came from", "result"),
+		invalidations: desc("invalidations_total", "Keys invalidated here or by other replicas"),
+		remoteErrors:  desc("remote_errors_total", "Redis reads and writes that failed and were skipped"),
+		entries:       desc("entries", "Values held in process"),
+	}
+}
+
+func (c *readCacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{c.lookups, c.invalidations, c.remoteErrors, c.entries} {
+		ch <- desc
+	}
+}
+
+func (c *readCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, s := range map[string]cache.Stats{
+		"oauth_clients": c.caches.clients.Stats(),
+		"user_profiles": c.caches.profiles.Stats(),
+	} {
+		metric := func(desc *prometheus.Desc, kind prometheus.ValueType, value float64, labels ...string) {
+			ch <- prometheus.MustNewConstMetric(desc, kind, value, append([]string{name}, labels...)...)
+		}
+		metric(c.lookups, prometheus.CounterValue, float64(s.LocalHits), "local_hit")
+		metric(c.lookups, prometheus.CounterValue, float64(s.RemoteHits), "remote_hit")
+		metric(c.lookups, prometheus.CounterValue, float64(s.Loads), "load")
+		metric(c.lookups, prometheus.CounterValue, float64(s.SharedLoads), "shared_load")
+		metric(c.invalidations, prometheus.CounterValue, float64(s.Invalidations))
+		metric(c.remoteErrors, prometheus.CounterValue, float64(s.RemoteErrors))
+		metric(c.entries, prometheus.GaugeValue, float64(s.Entries))
+	}
+}
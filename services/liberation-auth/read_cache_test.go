@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type ReadCacheTestSuite struct {
+	suite.Suite
+	server *miniredis.Miniredis
+	rdb    *redis.Client
+}
+
+func (suite *ReadCacheTestSuite) SetupTest() {
+	suite.server = miniredis.RunT(suite.T())
+	suite.rdb = redis.NewClient(&redis.Options{Addr: suite.server.Addr()})
+	suite.T().Cleanup(func() { suite.rdb.Close() })
+}
+
+// replica returns a service with read caches on the shared Redis, listening for invalidations
+func (suite *ReadCacheTestSuite) replica(pii *piiCipher) *AuthService {
+	config, err := DefaultReadCacheConfig()
+	suite.Require().NoError(err)
+	as := &AuthService{caches: newReadCaches(config, suite.rdb, pii)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.T().Cleanup(cancel)
+	go as.caches.run(ctx)
+	suite.Eventually(func() bool {
+		channels := suite.server.PubSubNumSub(as.caches.clients.Channel(), as.caches.profiles.Channel())
+		return channels[as.caches.clients.Channel()] > 0 && channels[as.caches.profiles.Channel()] > 0
+	}, time.Second, 5*time.Millisecond)
+	return as
+}
+
+func (suite *ReadCacheTestSuite) TestConfig() {
+	config, err := DefaultReadCacheConfig()
+	suite.Require().NoError(err)
+	suite.True(config.Enabled)
+	suite.Equal(30, config.Clients.LocalTTLSeconds)
+	suite.Equal(300, config.Profiles.RemoteTTLSeconds)
+	suite.NotEqual(config.Clients.Name, config.Profiles.Name, "caches must not share keys")
+
+	suite.T().Setenv("READ_CACHE_LOCAL_TTL", "10s")
+	suite.T().Setenv("READ_CACHE_REMOTE_TTL", "0s")
+	suite.T().Setenv("READ_CACHE_MAX_ENTRIES", "50")
+	config, err = DefaultReadCacheConfig()
+	suite.Require().NoError(err)
+	suite.Equal(10, config.Profiles.LocalTTLSeconds)
+	suite.Equal(0, config.Clients.RemoteTTLSeconds)
+	suite.Equal(50, config.Profiles.MaxEntries)
+
+	for env, invalid := range map[string]string{
+		"READ_CACHE_LOCAL_TTL":   "1500ms",
+		"READ_CACHE_REMOTE_TTL":  "soon",
+		"READ_CACHE_MAX_ENTRIES": "0",
+	} {
+		suite.T().Setenv(env, invalid)
+		_, err = DefaultReadCacheConfig()
+		suite.Error(err, env)
+		suite.T().Setenv(env, map[string]string{"READ_CACHE_LOCAL_TTL": "10s", "READ_CACHE_REMOTE_TTL": "0s", "READ_CACHE_MAX_ENTRIES": "50"}[env])
+	}
+
+	suite.T().Setenv("READ_CACHE_ENABLED", "false")
+	config, err = DefaultReadCacheConfig()
+	suite.Require().NoError(err)
+	suite.Nil(newReadCaches(config, suite.rdb, nil))
+	(&AuthService{}).forgetClient(context.Background(), "ignored")
+}
+
+func (suite *ReadCacheTestSuite) TestClientsAreSharedAndInvalidatedAcrossReplicas() {
+	ctx := context.Background()
+	first, second := suite.replica(nil), suite.replica(nil)
+	clientID := uuid.New()
+	loads := 0
+	load := func(secret string) func(context.Context) (models.OAuthClient, error) {
+		return func(context.Context) (models.OAuthClient, error) {
+			loads++
+			return models.OAuthClient{ID: clientID, Secret: secret, RedirectURIs: []string{"https://app.example.org/cb"}}, nil
+		}
+	}
+
+	client, err := first.caches.clients.Get(ctx, clientID.String(), load("old-hash"))
+	suite.Require().NoError(err)
+	suite.Equal("old-hash", client.Secret)
+	client, err = second.caches.clients.Get(ctx, clientID.String(), load("old-hash"))
+	suite.Require().NoError(err)
+	suite.Equal("old-hash", client.Secret, "the hashed secret survives the trip through Redis")
+	suite.Equal([]string{"https://app.example.org/cb"}, client.RedirectURIs)
+	suite.Equal(1, loads, "the second replica found the client in Redis")
+
+	// Resetting the secret on one replica must reach the other before it checks a secret again
+	first.forgetClient(ctx, clientID.String())
+	suite.Eventually(func() bool { return second.caches.clients.Stats().Invalidations == 1 }, time.Second, 5*time.Millisecond)
+	client, err = second.caches.clients.Get(ctx, clientID.String(), load("new-hash"))
+	suite.Require().NoError(err)
+	suite.Equal("new-hash", client.Secret)
+	suite.Equal(2, loads)
+}
+
+func (suite *ReadCacheTestSuite) TestMissingClientsAreNotCached() {
+	ctx := context.Background()
+	as := suite.replica(nil)
+	for i := 0; i < 2; i++ {
+		_, err := as.caches.clients.Get(ctx, "unknown", func(context.Context) (models.OAuthClient, error) {
+			return models.OAuthClient{}, errors.New("sql: no rows in result set")
+		})
+		suite.Error(err)
+	}
+	suite.Equal(int64(2), as.caches.clients.Stats().Loads, "a client registered later is found at once")
+}
+
+func (suite *ReadCacheTestSuite) TestProfileLocationsAreEncryptedInRedis() {
+	ctx := context.Background()
+	pii, err := newPIICipher(PIIEncryptionConfig{Keys: map[string][]byte{"k1": piiTestKey(1)}, ActiveKey: "k1"})
+	suite.Require().NoError(err)
+	first, second := suite.replica(pii), suite.replica(pii)
+
+	userID := uuid.New()
+	_, err = first.caches.profiles.Get(ctx, userID.String(), func(context.Context) (models.UserProfile, error) {
+		return models.UserProfile{ID: userID, Username: "ada", Location: "Lisbon"}, nil
+	})
+	suite.Require().NoError(err)
+
+	stored, err := suite.rdb.Get(ctx, "cache:user_profiles:"+userID.String()).Result()
+	suite.Require().NoError(err)
+	suite.NotContains(stored, "Lisbon")
+
+	profile, err := second.caches.profiles.Get(ctx, userID.String(), func(context.Context) (models.UserProfile, error) {
+		suite.Fail("the profile should come from Redis")
+		return models.UserProfile{}, nil
+	})
+	suite.Require().NoError(err)
+	suite.Equal("Lisbon", profile.Location)
+
+	second.forgetProfiles(ctx, userID)
+	suite.False(suite.server.Exists("cache:user_profiles:" + userID.String()))
+	suite.Eventually(func() bool { return first.caches.profiles.Stats().Entries == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func (suite *ReadCacheTestSuite) TestCollector() {
+	as := suite.replica(nil)
+	as.caches.clients.Get(context.Background(), "c1", func(context.Context) (models.OAuthClient, error) {
+		return models.OAuthClient{}, nil
+	})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newReadCacheCollector(as.caches))
+	families, err := registry.Gather()
+	suite.Require().NoError(err)
+
+	samples := map[string]int{}
+	for _, family := range families {
+		samples[family.GetName()] = len(family.GetMetric())
+	}
+	suite.Equal(map[string]int{
+		"liberation_auth_read_cache_lookups_total":       8,
+		"liberation_auth_read_cache_invalidations_total": 2,
+		"liberation_auth_read_cache_remote_errors_total": 2,
+		"liberation_auth_read_cache_entries":             2,
+	}, samples)
+	suite.Equal(int64(1), as.caches.clients.Stats().Loads)
+}
+
+func TestReadCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(ReadCacheTestSuite))
+}
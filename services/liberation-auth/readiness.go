@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadinessConfig covers how the service starts, reloads and stops under an orchestrator
+type ReadinessConfig struct {
+	// StartupTimeout bounds how long dependencies are retried before giving up
+	StartupTimeout time.Duration
+	// RetryInitial and RetryMax bound the exponential backoff between attempts
+	RetryInitial time.Duration
+	RetryMax     time.Duration
+	// DrainDelay is how long /health/ready fails before the listener closes, giving
+	// endpoint controllers and load balancers time to stop sending new requests
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds how long in-flight requests get to finish
+	ShutdownTimeout time.Duration
+	// ReloadInterval is how often mounted configuration files are checked; zero disables reloading
+	ReloadInterval time.Duration
+}
+
+// DefaultReadinessConfig reads STARTUP_TIMEOUT, STARTUP_RETRY_INITIAL, STARTUP_RETRY_MAX,
+// SHUTDOWN_DRAIN_DELAY, SHUTDOWN_TIMEOUT and CONFIG_RELOAD_INTERVAL from the environment
+func DefaultReadinessConfig() (ReadinessConfig, error) {
+	config := ReadinessConfig{}
+	durations := []struct {
+		key    string
+		value  string
+		target *time.Duration
+	}{
+		{"STARTUP_TIMEOUT", "2m", &config.StartupTimeout},
+		{"STARTUP_RETRY_INITIAL", "500ms", &config.RetryInitial},
+		{"STARTUP_RETRY_MAX", "15s", &config.RetryMax},
+		{"SHUTDOWN_DRAIN_DELAY", "5s", &config.DrainDelay},
+		{"SHUTDOWN_TIMEOUT", "20s", &config.ShutdownTimeout},
+		{"CONFIG_RELOAD_INTERVAL", "10s", &config.ReloadInterval},
+	}
+	for _, d := range durations {
+		value, err := time.ParseDuration(getEnv(d.key, d.value))
+		if err != nil || value < 0 {
+			return config, fmt.Errorf("%s must be a non-negative duration", d.key)
+		}
+		*d.target = value
+	}
+	if config.RetryInitial <= 0 || config.RetryMax < config.RetryInitial {
+		return config, fmt.Errorf("STARTUP_RETRY_MAX must be at least STARTUP_RETRY_INITIAL, which must be positive")
+	}
+	return config, nil
+}
+
+// retryWithBackoff calls attempt until it succeeds or the startup timeout passes, doubling
+// the wait each time with jitter. Dependencies that come up alongside the service (a
+// database pod still starting, a DNS record not yet published) are waited for rather than
+// crashing the process into CrashLoopBackOff.
+func retryWithBackoff(ctx context.Context, config ReadinessConfig, name string, attempt func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, config.StartupTimeout)
+	defer cancel()
+
+	wait := config.RetryInitial
+	for tries := 1; ; tries++ {
+		err := attempt(ctx)
+		if err == nil {
+			if tries > 1 {
+				log.Printf("Connected to %s after %d attempts", name, tries)
+			}
+			return nil
+		}
+		// Jitter keeps a fleet of replicas from retrying in lockstep
+		sleep := wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		log.Printf("Waiting for %s (attempt %d, retrying in %s): %v", name, tries, sleep.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s not reachable after %d attempts: %w", name, tries, err)
+		case <-time.After(sleep):
+		}
+		if wait *= 2; wait > config.RetryMax {
+			wait = config.RetryMax
+		}
+	}
+}
+
+// readiness tracks where the process is in its lifecycle for the startup and readiness probes
+type readiness struct {
+	config   ReadinessConfig
+	started  atomic.Bool
+	draining atomic.Bool
+}
+
+// MarkStarted is called once every listener and background worker is running
+func (r *readiness) MarkStarted() {
+	if r != nil {
+		r.started.Store(true)
+	}
+}
+
+// Drain fails the readiness probe so the pod is taken out of rotation before shutdown
+func (r *readiness) Drain() {
+	if r != nil {
+		r.draining.Store(true)
+	}
+}
+
+// StartupHandler serves /health/startup: 503 until initialization finishes. Point the
+// startupProbe here so slow dependency retries are not mistaken for a hung process.
+func (as *AuthService) StartupHandler(c *gin.Context) {
+	if as.readiness != nil && !as.readiness.started.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "started"})
+}
+
+// ReadyHandler serves /health/ready: 503 while draining or when the database or Redis
+// cannot be reached, so traffic only goes to replicas that can serve it
+func (as *AuthService) ReadyHandler(c *gin.Context) {
+	status, checks := as.readinessStatus(c.Request.Context())
+	code := http.StatusOK
+	if status != "ready" {
+		code = http.StatusServiceUnavailable
+	}
+	if checks == nil {
+		c.JSON(code, gin.H{"status": status})
+		return
+	}
+	c.JSON(code, gin.H{"status": status, "checks": checks})
+}
+
+// readinessStatus returns ready, draining, starting or unavailable, and the result of each
+// dependency check; checks are nil while draining or starting
+func (as *AuthService) readinessStatus(ctx context.Context) (string, gin.H) {
+	if as.readiness != nil {
+		if as.readiness.draining.Load() {
+			return "draining", nil
+		}
+		if !as.readiness.started.Load() {
+			return "starting", nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	checks := gin.H{}
+	ready := true
+	if as.db != nil {
+		if err := as.db.PingContext(ctx); err != nil {
+			checks["database"], ready = err.Error(), false
+		} else {
+			checks["database"] = "ok"
+		}
+	}
+	if as.redis != nil {
+		if err := as.redis.Ping(ctx).Err(); err != nil {
+			checks["redis"], ready = err.Error(), false
+		} else {
+			checks["redis"] = "ok"
+		}
+	}
+	if !ready {
+		return "unavailable", checks
+	}
+	return "ready", checks
+}
+
+// Shutdown drains and stops the listeners on SIGTERM: readiness fails first, then after
+// the drain delay keep-alives are turned off and in-flight requests get up to the shutdown
+// timeout to finish. A preStop sleep is unnecessary; the drain delay plays its part.
+func (r *readiness) Shutdown(servers ...*http.Server) error {
+	r.Drain()
+	if r.config.DrainDelay > 0 {
+		log.Printf("Draining for %s before closing listeners", r.config.DrainDelay)
+		time.Sleep(r.config.DrainDelay)
+	}
+	for _, srv := range servers {
+		if srv != nil {
+			srv.SetKeepAlivesEnabled(false)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.ShutdownTimeout)
+	defer cancel()
+	var firstErr error
+	for _, srv := range servers {
+		if srv == nil {
+			continue
+		}
+		if err := srv.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type ReadinessTestSuite struct {
+	suite.Suite
+	config ReadinessConfig
+}
+
+func (suite *ReadinessTestSuite) SetupSuite() {
+	gin.SetMode(gin.TestMode)
+	suite.config = ReadinessConfig{StartupTimeout: time.Second, RetryInitial: time.Millisecond, RetryMax: 4 * time.Millisecond}
+}
+
+func (suite *ReadinessTestSuite) TestRetryWaitsForDependency() {
+	attempts := 0
+	err := retryWithBackoff(context.Background(), suite.config, "database", func(context.Context) error {
+		if attempts++; attempts < 4 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	suite.NoError(err)
+	suite.Equal(4, attempts)
+}
+
+func (suite *ReadinessTestSuite) TestRetryGivesUpAfterStartupTimeout() {
+	config := suite.config
+	config.StartupTimeout = 20 * time.Millisecond
+	err := retryWithBackoff(context.Background(), config, "database", func(context.Context) error {
+		return errors.New("connection refused")
+	})
+	suite.ErrorContains(err, "connection refused")
+}
+
+func (suite *ReadinessTestSuite) TestDefaultConfigRejectsBadBackoff() {
+	suite.T().Setenv("STARTUP_RETRY_INITIAL", "10s")
+	suite.T().Setenv("STARTUP_RETRY_MAX", "1s")
+	_, err := DefaultReadinessConfig()
+	suite.Error(err)
+}
+
+func (suite *ReadinessTestSuite) TestProbesFollowLifecycle() {
+	as := &AuthService{readiness: &readiness{}}
+	router := gin.New()
+	router.GET("/health/startup", as.StartupHandler)
+	router.GET("/health/ready", as.ReadyHandler)
+	status := func(path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	suite.Equal(http.StatusServiceUnavailable, status("/health/startup"))
+	suite.Equal(http.StatusServiceUnavailable, status("/health/ready"))
+
+	as.readiness.MarkStarted()
+	suite.Equal(http.StatusOK, status("/health/startup"))
+	suite.Equal(http.StatusOK, status("/health/ready"))
+
+	suite.NoError(as.readiness.Shutdown())
+	suite.Equal(http.StatusOK, status("/health/startup"))
+	suite.Equal(http.StatusServiceUnavailable, status("/health/ready"))
+}
+
+func (suite *ReadinessTestSuite) TestMountedValues() {
+	dir := suite.T().TempDir()
+	suite.Require().NoError(os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("from-file\n"), 0o600))
+	suite.Require().NoError(os.WriteFile(filepath.Join(dir, "READINESS_TEST_DIR"), []byte("from-dir"), 0o600))
+	suite.T().Setenv("READINESS_TEST_FILE_FILE", filepath.Join(dir, "secret.txt"))
+	suite.T().Setenv("CONFIG_DIR", dir)
+
+	suite.Equal("from-file", getEnv("READINESS_TEST_FILE", "default"))
+	suite.Equal("from-dir", getEnv("READINESS_TEST_DIR", "default"))
+	suite.Equal("default", getEnv("READINESS_TEST_MISSING", "default"))
+
+	suite.T().Setenv("READINESS_TEST_DIR", "from-env")
+	suite.Equal("from-env", getEnv("READINESS_TEST_DIR", "default"))
+}
+
+func (suite *ReadinessTestSuite) TestWatcherAppliesChangedFiles() {
+	path := filepath.Join(suite.T().TempDir(), "password")
+	suite.Require().NoError(os.WriteFile(path, []byte("first"), 0o600))
+	suite.T().Setenv("READINESS_TEST_PASSWORD_FILE", path)
+
+	var applied []string
+	failNext := false
+	watcher := newConfigWatcher(time.Minute)
+	watcher.watch("READINESS_TEST_PASSWORD", func(value string) error {
+		if failNext {
+			failNext = false
+			return errors.New("rejected")
+		}
+		applied = append(applied, value)
+		return nil
+	})
+
+	watcher.check()
+	suite.Empty(applied)
+
+	suite.Require().NoError(os.WriteFile(path, []byte("second"), 0o600))
+	failNext = true
+	watcher.check()
+	suite.Empty(applied)
+	watcher.check()
+	suite.Equal([]string{"second"}, applied)
+
+	watcher.check()
+	suite.Equal([]string{"second"}, applied)
+}
+
+func (suite *ReadinessTestSuite) TestDatabaseDSN() {
+	dsn, err := databaseDSN("postgres://ao3_user:old@db:5432/ao3?sslmode=disable", "n3w/p@ss")
+	suite.Require().NoError(err)
+	suite.Equal("postgres://ao3_user:n3w%2Fp%40ss@db:5432/ao3?sslmode=disable", dsn)
+
+	dsn, err = databaseDSN("host=db user=ao3", "")
+	suite.NoError(err)
+	suite.Equal("host=db user=ao3", dsn)
+
+	_, err = databaseDSN("host=db user=ao3", "secret")
+	suite.Error(err)
+
+	connector, err := newReloadingConnector("postgres://ao3_user@db/ao3")
+	suite.Require().NoError(err)
+	suite.Error(connector.SetDSN("postgres://db:notaport/ao3"))
+	suite.NoError(connector.SetDSN("postgres://ao3_user:rotated@db/ao3"))
+}
+
+func (suite *ReadinessTestSuite) TestRotatedSigningKeyStillVerifiesOldTokens() {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(newKey)
+	suite.Require().NoError(err)
+	parsed, err := parseRSAPrivateKey(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})))
+	suite.Require().NoError(err)
+	suite.True(parsed.Equal(newKey))
+
+	manager := NewJWTManagerWithKey(oldKey, "issuer")
+	before, err := manager.GenerateToken(uuid.New(), "aud", nil, time.Hour)
+	suite.Require().NoError(err)
+
+	manager.Rotate(parsed)
+	after, err := manager.GenerateToken(uuid.New(), "aud", nil, time.Hour)
+	suite.Require().NoError(err)
+
+	_, err = manager.ValidateToken(before)
+	suite.NoError(err)
+	_, err = manager.ValidateToken(after)
+	suite.NoError(err)
+	suite.Len(manager.GetJWKS()["keys"], 2)
+
+	// A second rotation retires the original key
+	newest, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	manager.Rotate(newest)
+	_, err = manager.ValidateToken(before)
+	suite.Error(err)
+	_, err = manager.ValidateToken(after)
+	suite.NoError(err)
+}
+
+func TestReadiness(t *testing.T) {
+	suite.Run(t, new(ReadinessTestSuite))
+}
@@ -0,0 +1,1207 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Trusted contact designations; a contact only counts once they have accepted
+const (
+	recoveryContactPending  = "pending"
+	recoveryContactAccepted = "accepted"
+)
+
+// Recovery request states. pending and approved are open; the rest are final.
+const (
+	recoveryPending   = "pending"
+	recoveryApproved  = "approved"
+	recoveryDenied    = "denied"
+	recoveryCompleted = "completed"
+	recoveryCancelled = "cancelled"
+	recoveryExpired   = "expired"
+	recoveryFailed    = "failed"
+)
+
+const (
+	// recoveryCompletionWindow is how long an approved request can be completed once its delay has passed
+	recoveryCompletionWindow = 24 * time.Hour
+	// recoveryMaxAttempts fails a request after this many wrong secrets or approval codes
+	recoveryMaxAttempts = 5
+	// recoveryDecisionLimit caps approvals and denials per contact per hour
+	recoveryDecisionLimit = 20
+	// recoveryStartIPLimit caps recovery requests from one address per hour, across accounts
+	recoveryStartIPLimit = 10
+)
+
+var errRecoveryNotFound = errors.New("invalid or expired recovery request")
+
+var recoveryEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_recovery_events_total",
+	Help: "Delegated account recovery events, by event.",
+}, []string{"event"})
+
+// RecoveryConfig controls delegated recovery through trusted contacts
+type RecoveryConfig struct {
+	Enabled bool
+	// MaxContacts is how many trusted contacts a user may designate
+	MaxContacts int
+	// Threshold is how many contacts must approve a recovery
+	Threshold int
+	// Window is how long a request waits for its approvals
+	Window time.Duration
+	// Delay separates the last approval from completion, so the owner can still cancel
+	Delay time.Duration
+	// ContactMinAge keeps contacts accepted shortly before a request from approving it
+	ContactMinAge time.Duration
+	// StartLimit caps recovery requests per account per day
+	StartLimit int
+}
+
+// DefaultRecoveryConfig reads RECOVERY_* from the environment
+func DefaultRecoveryConfig() (RecoveryConfig, error) {
+	config := RecoveryConfig{Enabled: getEnv("RECOVERY_CONTACTS_ENABLED", "false") == "true"}
+
+	var err error
+	if config.MaxContacts, err = strconv.Atoi(getEnv("RECOVERY_MAX_CONTACTS", "5")); err != nil || config.MaxContacts < 1 || config.MaxContacts > 10 {
+		return config, fmt.Errorf("RECOVERY_MAX_CONTACTS must be between 1 and 10")
+	}
+	if config.Threshold, err = strconv.Atoi(getEnv("RECOVERY_THRESHOLD", "3")); err != nil || config.Threshold < 1 || config.Threshold > config.MaxContacts {
+		return config, fmt.Errorf("RECOVERY_THRESHOLD must be between 1 and RECOVERY_MAX_CONTACTS (%d)", config.MaxContacts)
+	}
+	if config.Window, err = time.ParseDuration(getEnv("RECOVERY_WINDOW", "72h")); err != nil || config.Window <= 0 {
+		return config, fmt.Errorf("RECOVERY_WINDOW must be a positive duration such as 72h")
+	}
+	if config.Delay, err = time.ParseDuration(getEnv("RECOVERY_DELAY", "24h")); err != nil || config.Delay < 0 {
+		return config, fmt.Errorf("RECOVERY_DELAY must be a duration such as 24h")
+	}
+	if config.ContactMinAge, err = time.ParseDuration(getEnv("RECOVERY_CONTACT_MIN_AGE", "72h")); err != nil || config.ContactMinAge < 0 {
+		return config, fmt.Errorf("RECOVERY_CONTACT_MIN_AGE must be a duration such as 72h")
+	}
+	if config.StartLimit, err = strconv.Atoi(getEnv("RECOVERY_START_LIMIT", "3")); err != nil || config.StartLimit < 1 {
+		return config, fmt.Errorf("RECOVERY_START_LIMIT must be a positive number of requests per day")
+	}
+	return config, nil
+}
+
+// tally decides a pending request's state from its contacts' decisions. One denial ends it:
+// a contact who does not recognise the request is the strongest sign of an attack.
+func (cfg RecoveryConfig) tally(approvals, denials int) string {
+	switch {
+	case denials > 0:
+		return recoveryDenied
+	case approvals >= cfg.Threshold:
+		return recoveryApproved
+	default:
+		return recoveryPending
+	}
+}
+
+// contactCounts reports whether a contact accepted at acceptedAt may decide a request made at requestedAt
+func (cfg RecoveryConfig) contactCounts(acceptedAt *time.Time, requestedAt time.Time) bool {
+	return acceptedAt != nil && !acceptedAt.After(requestedAt.Add(-cfg.ContactMinAge))
+}
+
+// RecoveryService runs delegated account recovery: a locked-out user asks their trusted
+// contacts, who vouch for them with a code the user passes on out of band
+type RecoveryService struct {
+	as       *AuthService
+	config   RecoveryConfig
+	notifier Notifier
+	limiter  *RateLimitManager
+}
+
+// NewRecoveryService returns nil when trusted-contact recovery is disabled
+func NewRecoveryService(as *AuthService, config RecoveryConfig, notifier Notifier) *RecoveryService {
+	if !config.Enabled {
+		return nil
+	}
+	return &RecoveryService{
+		as:       as,
+		config:   config,
+		notifier: notifier,
+		limiter:  &RateLimitManager{redisClient: as.redis, serviceName: "auth-service"},
+	}
+}
+
+// RecoveryContact is one trusted contact, or one user who trusts the caller
+type RecoveryContact struct {
+	UserID     uuid.UUID  `json:"user_id"`
+	Username   string     `json:"username"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+// RecoveryRequest is one attempt to recover an account
+type RecoveryRequest struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	Status    string    `json:"status"`
+	Threshold int       `json:"threshold"`
+	Approvals int       `json:"approvals"`
+	// IPAddress and UserAgent describe the requester; they are left out of contacts' views
+	IPAddress   string     `json:"ip_address,omitempty"`
+	UserAgent   string     `json:"user_agent,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AvailableAt *time.Time `json:"available_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// open reports whether the request can still be approved or completed at now
+func (r *RecoveryRequest) open(now time.Time) bool {
+	return (r.Status == recoveryPending || r.Status == recoveryApproved) && now.Before(r.ExpiresAt)
+}
+
+// generateRecoveryCode returns the code contacts type in to approve, formatted for reading aloud
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	return code[:4] + "-" + code[4:], nil
+}
+
+// hashRecoveryValue hashes a request's secret or code; codes survive retyping, and the
+// request ID keeps a code from matching any other request
+func hashRecoveryValue(requestID uuid.UUID, value string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(value)))
+	sum := sha256.Sum256([]byte(requestID.String() + ":" + normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func recoveryValueMatches(requestID uuid.UUID, value, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashRecoveryValue(requestID, value)), []byte(hash)) == 1
+}
+
+// recordEvent appends to the recovery audit trail. c supplies the address and user agent
+// when the event comes from a request.
+func (s *RecoveryService) recordEvent(ctx context.Context, db sqlExecer, c *gin.Context, requestID *uuid.UUID, userID uuid.UUID, actorID *uuid.UUID, event, detail string) error {
+	var ip, userAgent string
+	if c != nil {
+		ip, userAgent = c.ClientIP(), c.GetHeader("User-Agent")
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO recovery_events (request_id, user_id, actor_id, event, detail, ip_address, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())`, requestID, userID, actorID, event, detail, ip, userAgent)
+	if err == nil {
+		recoveryEventsTotal.WithLabelValues(event).Inc()
+	}
+	return err
+}
+
+// notify tells users about a recovery. Delivery is best effort: a failed notification is
+// logged, not retried.
+func (s *RecoveryService) notify(ctx context.Context, kind string, userIDs []uuid.UUID, data map[string]interface{}) {
+	if len(userIDs) == 0 {
+		return
+	}
+	ids := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = id.String()
+	}
+	rows, err := s.as.db.QueryContext(ctx, `SELECT id, email, username FROM users WHERE id = ANY($1::uuid[])`, pq.Array(ids))
+	if err != nil {
+		log.Printf("Failed to load recipients of %s notification: %v", kind, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		notification := Notification{Type: kind, Data: data}
+		if err := rows.Scan(&notification.UserID, s.as.pii.scan(piiUserEmail, &notification.Email), &notification.Username); err != nil {
+			continue
+		}
+		if err := s.notifier.Notify(ctx, notification); err != nil {
+			log.Printf("Failed to deliver %s notification to user %s: %v", kind, notification.UserID, err)
+		}
+	}
+}
+
+// contacts lists a user's trusted contacts, or with trusting set, the users who trust them
+func (s *RecoveryService) contacts(ctx context.Context, userID uuid.UUID, trusting bool) ([]RecoveryContact, error) {
+	self, other := "rc.user_id", "rc.contact_id"
+	if trusting {
+		self, other = other, self
+	}
+	rows, err := s.as.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT u.id, u.username, rc.status, rc.created_at, rc.accepted_at
+		FROM recovery_contacts rc JOIN users u ON u.id = %s
+		WHERE %s = $1 AND u.is_active = true
+		ORDER BY rc.created_at`, other, self), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	contacts := []RecoveryContact{}
+	for rows.Next() {
+		var contact RecoveryContact
+		if err := rows.Scan(&contact.UserID, &contact.Username, &contact.Status, &contact.CreatedAt, &contact.AcceptedAt); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+// eligibleContacts returns the contacts who may decide a request made at requestedAt
+func (s *RecoveryService) eligibleContacts(ctx context.Context, userID uuid.UUID, requestedAt time.Time) ([]uuid.UUID, error) {
+	contacts, err := s.contacts(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	var eligible []uuid.UUID
+	for _, contact := range contacts {
+		if contact.Status == recoveryContactAccepted && s.config.contactCounts(contact.AcceptedAt, requestedAt) {
+			eligible = append(eligible, contact.UserID)
+		}
+	}
+	return eligible, nil
+}
+
+const recoveryRequestColumns = `r.id, r.user_id, u.username, r.status, r.threshold, r.approvals,
+	r.ip_address, r.user_agent, r.expires_at, r.available_at, r.created_at, r.resolved_at`
+
+func scanRecoveryRequest(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*RecoveryRequest, error) {
+	request := &RecoveryRequest{}
+	err := row.Scan(append([]interface{}{&request.ID, &request.UserID, &request.Username, &request.Status,
+		&request.Threshold, &request.Approvals, &request.IPAddress, &request.UserAgent, &request.ExpiresAt,
+		&request.AvailableAt, &request.CreatedAt, &request.ResolvedAt}, extra...)...)
+	return request, err
+}
+
+func (s *RecoveryService) queryRequests(ctx context.Context, where string, args ...interface{}) ([]RecoveryRequest, error) {
+	rows, err := s.as.db.QueryContext(ctx, `SELECT `+recoveryRequestColumns+`
+		FROM recovery_requests r JOIN users u ON u.id = r.user_id
+		WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	requests := []RecoveryRequest{}
+	for rows.Next() {
+		request, err := scanRecoveryRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *request)
+	}
+	return requests, rows.Err()
+}
+
+// Trusted contacts
+
+// GetRecovery returns the caller's contacts, the users who trust them, their own recent
+// requests and the requests waiting for their decision
+func (s *RecoveryService) GetRecovery(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	ctx := c.Request.Context()
+
+	contacts, err := s.contacts(ctx, userID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load trusted contacts"})
+		return
+	}
+	trustedBy, err := s.contacts(ctx, userID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load trusted contacts"})
+		return
+	}
+	requests, err := s.queryRequests(ctx, `r.user_id = $1 AND r.created_at > NOW() - INTERVAL '30 days' ORDER BY r.created_at DESC`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recovery requests"})
+		return
+	}
+	awaiting, err := s.queryRequests(ctx, `
+		r.status = 'pending' AND r.expires_at > NOW()
+		AND EXISTS (SELECT 1 FROM recovery_contacts rc WHERE rc.user_id = r.user_id AND rc.contact_id = $1
+			AND rc.status = 'accepted' AND rc.accepted_at <= r.created_at - $2 * INTERVAL '1 second')
+		AND NOT EXISTS (SELECT 1 FROM recovery_approvals a WHERE a.request_id = r.id AND a.contact_id = $1)
+		ORDER BY r.created_at`, userID, s.config.ContactMinAge.Seconds())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recovery requests"})
+		return
+	}
+	for i := range awaiting {
+		awaiting[i].IPAddress, awaiting[i].UserAgent = "", ""
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"contacts":           contacts,
+		"trusted_by":         trustedBy,
+		"requests":           requests,
+		"awaiting_decision":  awaiting,
+		"threshold":          s.config.Threshold,
+		"max_contacts":       s.config.MaxContacts,
+		"recovery_available": countAccepted(contacts) >= s.config.Threshold,
+	})
+}
+
+func countAccepted(contacts []RecoveryContact) int {
+	accepted := 0
+	for _, contact := range contacts {
+		if contact.Status == recoveryContactAccepted {
+			accepted++
+		}
+	}
+	return accepted
+}
+
+// AddContact asks another user to be a trusted contact; they must accept before they count
+func (s *RecoveryService) AddContact(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	ctx := c.Request.Context()
+
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	var contactID uuid.UUID
+	err := s.as.db.QueryRowContext(ctx, `SELECT id FROM users WHERE username = $1 AND is_active = true`, req.Username).Scan(&contactID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add trusted contact"})
+		return
+	}
+	if contactID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot be your own trusted contact"})
+		return
+	}
+
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add trusted contact"})
+		return
+	}
+	defer tx.Rollback()
+	// Serialise additions per user so the contact limit holds
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "recovery_contacts:"+userID.String()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add trusted contact"})
+		return
+	}
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM recovery_contacts WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add trusted contact"})
+		return
+	}
+	if count >= s.config.MaxContacts {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("You can have at most %d trusted contacts", s.config.MaxContacts)})
+		return
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO recovery_contacts (user_id, contact_id, status, created_at)
+		VALUES ($1, $2, $3, NOW()) ON CONFLICT DO NOTHING`, userID, contactID, recoveryContactPending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add trusted contact"})
+		return
+	}
+	if added, _ := result.RowsAffected(); added == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Already a trusted contact"})
+		return
+	}
+	if err := s.recordEvent(ctx, tx, c, nil, userID, &userID, "contact_added", req.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add trusted contact"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add trusted contact"})
+		return
+	}
+
+	// The owner hears about every change too, in case someone else is using their session
+	s.notify(ctx, "recovery_contact_invited", []uuid.UUID{contactID}, map[string]interface{}{"requested_by": userID})
+	s.notify(ctx, "recovery_contacts_changed", []uuid.UUID{userID}, map[string]interface{}{"added": req.Username})
+	c.JSON(http.StatusCreated, gin.H{"user_id": contactID, "username": req.Username, "status": recoveryContactPending})
+}
+
+// RemoveContact removes one of the caller's trusted contacts
+func (s *RecoveryService) RemoveContact(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	contactID, err := uuid.Parse(c.Param("contact_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return
+	}
+	s.endDesignation(c, userID, contactID, userID, "contact_removed")
+}
+
+// AcceptDesignation agrees to vouch for the user who designated the caller
+func (s *RecoveryService) AcceptDesignation(c *gin.Context) {
+	contactID := c.MustGet("user_id").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	result, err := s.as.db.ExecContext(ctx, `
+		UPDATE recovery_contacts SET status = $3, accepted_at = NOW()
+		WHERE user_id = $1 AND contact_id = $2 AND status = $4`, userID, contactID, recoveryContactAccepted, recoveryContactPending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept"})
+		return
+	}
+	if accepted, _ := result.RowsAffected(); accepted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending request to be a trusted contact"})
+		return
+	}
+	if err := s.recordEvent(ctx, s.as.db, c, nil, userID, &contactID, "contact_accepted", ""); err != nil {
+		log.Printf("Failed to record recovery event: %v", err)
+	}
+	s.notify(ctx, "recovery_contacts_changed", []uuid.UUID{userID}, map[string]interface{}{"accepted_by": contactID})
+	c.JSON(http.StatusOK, gin.H{"message": "You are now a trusted contact"})
+}
+
+// LeaveDesignation declines a pending designation or stops being someone's trusted contact
+func (s *RecoveryService) LeaveDesignation(c *gin.Context) {
+	contactID := c.MustGet("user_id").(uuid.UUID)
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	s.endDesignation(c, userID, contactID, contactID, "contact_left")
+}
+
+func (s *RecoveryService) endDesignation(c *gin.Context, userID, contactID, actorID uuid.UUID, event string) {
+	ctx := c.Request.Context()
+	result, err := s.as.db.ExecContext(ctx, `DELETE FROM recovery_contacts WHERE user_id = $1 AND contact_id = $2`, userID, contactID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove trusted contact"})
+		return
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trusted contact not found"})
+		return
+	}
+	if err := s.recordEvent(ctx, s.as.db, c, nil, userID, &actorID, event, contactID.String()); err != nil {
+		log.Printf("Failed to record recovery event: %v", err)
+	}
+	s.notify(ctx, "recovery_contacts_changed", []uuid.UUID{userID}, map[string]interface{}{"removed": contactID})
+	c.JSON(http.StatusOK, gin.H{"message": "Trusted contact removed"})
+}
+
+// Recovery requests
+
+// StartRecovery opens a recovery request for an account. The requester gets a secret to
+// keep, which completes the recovery, and a code to read to their contacts, who need it to
+// approve. Any open request for the account is superseded.
+func (s *RecoveryService) StartRecovery(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	perIP := RateLimitConfig{Tier: "recovery_ip", Requests: recoveryStartIPLimit, Window: time.Hour}
+	if headers, err := s.limiter.checkLimitWithConfig("rate_limit:auth-service:recovery_ip:"+c.ClientIP(), perIP); err != nil {
+		headers.apply(c, true)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate_limit_exceeded", "error_description": "Too many recovery requests; try again later"})
+		return
+	}
+
+	unavailable := gin.H{
+		"error":             "recovery_unavailable",
+		"error_description": "This account cannot be recovered through trusted contacts",
+	}
+	var userID uuid.UUID
+	if err := s.as.db.QueryRowContext(ctx, `SELECT id FROM users WHERE username = $1 AND is_active = true`, req.Username).Scan(&userID); err != nil {
+		c.JSON(http.StatusBadRequest, unavailable)
+		return
+	}
+	now := time.Now()
+	contacts, err := s.eligibleContacts(ctx, userID, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if len(contacts) < s.config.Threshold {
+		c.JSON(http.StatusBadRequest, unavailable)
+		return
+	}
+
+	perAccount := RateLimitConfig{Tier: "recovery", Requests: s.config.StartLimit, Window: 24 * time.Hour}
+	if headers, err := s.limiter.checkLimitWithConfig("rate_limit:auth-service:recovery:"+userID.String(), perAccount); err != nil {
+		headers.apply(c, true)
+		if err := s.recordEvent(ctx, s.as.db, c, nil, userID, nil, "rate_limited", "start"); err != nil {
+			log.Printf("Failed to record recovery event: %v", err)
+		}
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate_limit_exceeded", "error_description": "Too many recovery requests for this account; try again tomorrow"})
+		return
+	}
+
+	requestID := uuid.New()
+	secret, err := generateSecureToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	code, err := generateRecoveryCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	expiresAt := now.Add(s.config.Window)
+
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	defer tx.Rollback()
+	superseded, err := tx.QueryContext(ctx, `
+		UPDATE recovery_requests SET status = $2, resolved_at = NOW(), resolution = 'superseded'
+		WHERE user_id = $1 AND status IN ('pending', 'approved') RETURNING id`, userID, recoveryCancelled)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	var supersededIDs []uuid.UUID
+	for superseded.Next() {
+		var id uuid.UUID
+		if err := superseded.Scan(&id); err == nil {
+			supersededIDs = append(supersededIDs, id)
+		}
+	}
+	superseded.Close()
+	for i := range supersededIDs {
+		if err := s.recordEvent(ctx, tx, c, &supersededIDs[i], userID, nil, "superseded", requestID.String()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO recovery_requests (id, user_id, secret_hash, code_hash, status, threshold, ip_address, user_agent, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		requestID, userID, hashRecoveryValue(requestID, secret), hashRecoveryValue(requestID, code), recoveryPending,
+		s.config.Threshold, c.ClientIP(), c.GetHeader("User-Agent"), expiresAt, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if err := s.recordEvent(ctx, tx, c, &requestID, userID, nil, "requested", fmt.Sprintf("%d eligible contacts", len(contacts))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	data := map[string]interface{}{"request_id": requestID, "username": req.Username, "expires_at": expiresAt}
+	s.notify(ctx, "recovery_approval_requested", contacts, data)
+	s.notify(ctx, "recovery_requested", []uuid.UUID{userID}, data)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"request_id": requestID,
+		"secret":     secret,
+		"code":       code,
+		"threshold":  s.config.Threshold,
+		"expires_at": expiresAt,
+		"message":    "Keep the secret private. Read the code to your trusted contacts; each of them needs it to approve.",
+	})
+}
+
+// requestBySecret loads a request for its requester. Unknown requests and wrong secrets get
+// the same error; wrong secrets count towards failing the request.
+func (s *RecoveryService) requestBySecret(c *gin.Context, secret string) (*RecoveryRequest, error) {
+	requestID, err := uuid.Parse(c.Param("request_id"))
+	if err != nil || secret == "" {
+		return nil, errRecoveryNotFound
+	}
+	ctx := c.Request.Context()
+	var secretHash string
+	request, err := scanRecoveryRequest(s.as.db.QueryRowContext(ctx, `SELECT `+recoveryRequestColumns+`, r.secret_hash
+		FROM recovery_requests r JOIN users u ON u.id = r.user_id WHERE r.id = $1`, requestID), &secretHash)
+	if err == sql.ErrNoRows {
+		return nil, errRecoveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !recoveryValueMatches(request.ID, secret, secretHash) {
+		if err := s.countFailure(ctx, c, request, "secret_rejected"); err != nil {
+			log.Printf("Failed to record recovery failure: %v", err)
+		}
+		return nil, errRecoveryNotFound
+	}
+	return request, nil
+}
+
+// countFailure records a wrong secret or code, failing the request after recoveryMaxAttempts
+func (s *RecoveryService) countFailure(ctx context.Context, c *gin.Context, request *RecoveryRequest, event string) error {
+	var attempts int
+	var status string
+	err := s.as.db.QueryRowContext(ctx, `
+		UPDATE recovery_requests SET failed_attempts = failed_attempts + 1,
+			status = CASE WHEN failed_attempts + 1 >= $2 AND status IN ('pending', 'approved') THEN $3 ELSE status END,
+			resolved_at = CASE WHEN failed_attempts + 1 >= $2 AND status IN ('pending', 'approved') THEN NOW() ELSE resolved_at END
+		WHERE id = $1 RETURNING failed_attempts, status`, request.ID, recoveryMaxAttempts, recoveryFailed).Scan(&attempts, &status)
+	if err != nil {
+		return err
+	}
+	if err := s.recordEvent(ctx, s.as.db, c, &request.ID, request.UserID, nil, event, fmt.Sprintf("attempt %d", attempts)); err != nil {
+		return err
+	}
+	if attempts == recoveryMaxAttempts && status == recoveryFailed {
+		if err := s.recordEvent(ctx, s.as.db, c, &request.ID, request.UserID, nil, recoveryFailed, "too many wrong secrets or codes"); err != nil {
+			return err
+		}
+		s.notify(ctx, "recovery_failed", []uuid.UUID{request.UserID}, map[string]interface{}{"request_id": request.ID})
+	}
+	return nil
+}
+
+func recoveryError(c *gin.Context, err error) {
+	if errors.Is(err, errRecoveryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+}
+
+// GetRecoveryStatus shows the requester how far their request has got. The secret travels
+// in X-Recovery-Secret so it stays out of access logs.
+func (s *RecoveryService) GetRecoveryStatus(c *gin.Context) {
+	request, err := s.requestBySecret(c, c.GetHeader("X-Recovery-Secret"))
+	if err != nil {
+		recoveryError(c, err)
+		return
+	}
+	if request.Status == recoveryPending && !request.open(time.Now()) {
+		request.Status = recoveryExpired
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":       request.Status,
+		"approvals":    request.Approvals,
+		"threshold":    request.Threshold,
+		"expires_at":   request.ExpiresAt,
+		"available_at": request.AvailableAt,
+	})
+}
+
+// CompleteRecovery sets a new password once enough contacts have approved and the delay has
+// passed. Every session and token the account had is revoked.
+func (s *RecoveryService) CompleteRecovery(c *gin.Context) {
+	var req struct {
+		Secret      string `json:"secret" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required,min=8"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	request, err := s.requestBySecret(c, req.Secret)
+	if err != nil {
+		recoveryError(c, err)
+		return
+	}
+	now := time.Now()
+	if request.Status != recoveryApproved || !request.open(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "not_approved", "error_description": "The request is " + request.Status + " and cannot be completed"})
+		return
+	}
+	if request.AvailableAt != nil && now.Before(*request.AvailableAt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "too_early", "error_description": "The request can be completed from " + request.AvailableAt.Format(time.RFC3339), "available_at": request.AvailableAt})
+		return
+	}
+
+	hashedPassword, err := s.as.passwords.generate(ctx, req.NewPassword)
+	if err == errPasswordHashOverloaded {
+		rejectOverloaded(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	defer tx.Rollback()
+	// The owner may have cancelled while the password was hashing
+	result, err := tx.ExecContext(ctx, `
+		UPDATE recovery_requests SET status = $2, resolved_at = NOW(), resolution = 'completed'
+		WHERE id = $1 AND status = $3 AND expires_at > NOW()`, request.ID, recoveryCompleted, recoveryApproved)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if completed, _ := result.RowsAffected(); completed == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "not_approved", "error_description": "The request can no longer be completed"})
+		return
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2`, string(hashedPassword), request.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	if err := revokeUserTokens(ctx, tx, request.UserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	epoch, err := bumpSignOutEpoch(ctx, tx, request.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if err := s.recordEvent(ctx, tx, c, &request.ID, request.UserID, nil, recoveryCompleted, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	s.as.signedOutEverywhere(request.UserID, epoch)
+	s.as.recordSecurityEvent(c, &request.UserID, securityEventPasswordReset, map[string]interface{}{"method": "trusted_contacts", "request_id": request.ID})
+	s.notify(ctx, "recovery_completed", []uuid.UUID{request.UserID}, map[string]interface{}{"request_id": request.ID})
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset; sign in with your new password"})
+}
+
+// Decide records a trusted contact's approval or denial. Approving needs the code the
+// requester was given; contacts who cannot get it from them should deny.
+func (s *RecoveryService) Decide(decision string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		contactID := c.MustGet("user_id").(uuid.UUID)
+		requestID, err := uuid.Parse(c.Param("request_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+			return
+		}
+		var req struct {
+			Code string `json:"code"`
+		}
+		if decision == "approve" {
+			if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "The code from the person recovering their account is required"})
+				return
+			}
+		}
+		ctx := c.Request.Context()
+
+		perContact := RateLimitConfig{Tier: "recovery_decision", Requests: recoveryDecisionLimit, Window: time.Hour}
+		if headers, err := s.limiter.checkLimitWithConfig("rate_limit:auth-service:recovery_decision:"+contactID.String(), perContact); err != nil {
+			headers.apply(c, true)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate_limit_exceeded"})
+			return
+		}
+
+		tx, err := s.as.db.BeginTx(ctx, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record decision"})
+			return
+		}
+		defer tx.Rollback()
+
+		var codeHash string
+		request, err := scanRecoveryRequest(tx.QueryRowContext(ctx, `SELECT `+recoveryRequestColumns+`, r.code_hash
+			FROM recovery_requests r JOIN users u ON u.id = r.user_id WHERE r.id = $1 FOR UPDATE OF r`, requestID), &codeHash)
+		if err != nil && err != sql.ErrNoRows {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record decision"})
+			return
+		}
+		// Requests the caller is not a counting contact for look the same as missing ones
+		var acceptedAt *time.Time
+		if err == nil {
+			err = tx.QueryRowContext(ctx, `
+				SELECT accepted_at FROM recovery_contacts WHERE user_id = $1 AND contact_id = $2 AND status = $3`,
+				request.UserID, contactID, recoveryContactAccepted).Scan(&acceptedAt)
+		}
+		if err != nil || !s.config.contactCounts(acceptedAt, request.CreatedAt) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Recovery request not found"})
+			return
+		}
+		if request.Status != recoveryPending || !request.open(time.Now()) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The request is no longer waiting for approval"})
+			return
+		}
+
+		if decision == "approve" && !recoveryValueMatches(request.ID, req.Code, codeHash) {
+			tx.Rollback()
+			if err := s.countFailure(ctx, c, request, "code_rejected"); err != nil {
+				log.Printf("Failed to record recovery failure: %v", err)
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_code", "error_description": "That is not the code for this request"})
+			return
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO recovery_approvals (request_id, contact_id, decision, ip_address, user_agent, created_at)
+			VALUES ($1, $2, $3, $4, $5, NOW()) ON CONFLICT DO NOTHING`,
+			request.ID, contactID, decision, c.ClientIP(), c.GetHeader("User-Agent"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record decision"})
+			return
+		}
+		if recorded, _ := result.RowsAffected(); recorded == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "You have already decided this request"})
+			return
+		}
+
+		var approvals, denials int
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FILTER (WHERE decision = 'approve'), COUNT(*) FILTER (WHERE decision = 'deny')
+			FROM recovery_approvals WHERE request_id = $1`, request.ID).Scan(&approvals, &denials); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record decision"})
+			return
+		}
+		status := s.config.tally(approvals, denials)
+		var availableAt *time.Time
+		expiresAt := request.ExpiresAt
+		if status == recoveryApproved {
+			available := time.Now().Add(s.config.Delay)
+			availableAt, expiresAt = &available, available.Add(recoveryCompletionWindow)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE recovery_requests SET status = $2, approvals = $3, available_at = $4, expires_at = $5,
+				resolved_at = CASE WHEN $2 = 'denied' THEN NOW() ELSE resolved_at END,
+				resolution = CASE WHEN $2 = 'denied' THEN 'denied by a contact' ELSE resolution END
+			WHERE id = $1`, request.ID, status, approvals, availableAt, expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record decision"})
+			return
+		}
+		event := "approved"
+		if decision == "deny" {
+			event = "denied"
+		}
+		if err := s.recordEvent(ctx, tx, c, &request.ID, request.UserID, &contactID, event, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record decision"})
+			return
+		}
+		if status == recoveryApproved {
+			if err := s.recordEvent(ctx, tx, c, &request.ID, request.UserID, nil, "threshold_met", fmt.Sprintf("%d approvals", approvals)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record decision"})
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record decision"})
+			return
+		}
+
+		switch status {
+		case recoveryApproved:
+			// Last chance for an owner who still has access to stop it
+			s.notify(ctx, "recovery_approved", []uuid.UUID{request.UserID}, map[string]interface{}{"request_id": request.ID, "available_at": availableAt})
+		case recoveryDenied:
+			s.notify(ctx, "recovery_denied", []uuid.UUID{request.UserID}, map[string]interface{}{"request_id": request.ID})
+		}
+		c.JSON(http.StatusOK, gin.H{"status": status, "approvals": approvals, "threshold": request.Threshold})
+	}
+}
+
+// CancelRequest lets an owner who still has access stop a recovery of their account
+func (s *RecoveryService) CancelRequest(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	requestID, err := uuid.Parse(c.Param("request_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+	s.cancel(c, requestID, &userID, &userID, "cancelled by the account owner")
+}
+
+func (s *RecoveryService) cancel(c *gin.Context, requestID uuid.UUID, ownerID, actorID *uuid.UUID, reason string) {
+	ctx := c.Request.Context()
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel recovery"})
+		return
+	}
+	defer tx.Rollback()
+	var userID uuid.UUID
+	err = tx.QueryRowContext(ctx, `
+		UPDATE recovery_requests SET status = $2, resolved_at = NOW(), resolved_by = $3, resolution = $4
+		WHERE id = $1 AND status IN ('pending', 'approved') AND ($5::uuid IS NULL OR user_id = $5)
+		RETURNING user_id`, requestID, recoveryCancelled, actorID, reason, ownerID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No open recovery request with that ID"})
+		return
+	}
+	if err == nil {
+		err = s.recordEvent(ctx, tx, c, &requestID, userID, actorID, recoveryCancelled, reason)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel recovery"})
+		return
+	}
+	s.notify(ctx, "recovery_cancelled", []uuid.UUID{userID}, map[string]interface{}{"request_id": requestID, "reason": reason})
+	c.JSON(http.StatusOK, gin.H{"message": "Recovery request cancelled"})
+}
+
+// ExpireRequests is the recovery_expiry background job
+func (s *RecoveryService) ExpireRequests(ctx context.Context) (string, error) {
+	rows, err := s.as.db.QueryContext(ctx, `
+		UPDATE recovery_requests SET status = $1, resolved_at = NOW(), resolution = 'expired'
+		WHERE status IN ('pending', 'approved') AND expires_at <= NOW()
+		RETURNING id, user_id`, recoveryExpired)
+	if err != nil {
+		return "", err
+	}
+	type expired struct{ id, userID uuid.UUID }
+	var requests []expired
+	for rows.Next() {
+		var request expired
+		if err := rows.Scan(&request.id, &request.userID); err != nil {
+			rows.Close()
+			return "", err
+		}
+		requests = append(requests, request)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	for _, request := range requests {
+		if err := s.recordEvent(ctx, s.as.db, nil, &request.id, request.userID, nil, recoveryExpired, ""); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%d recovery requests expired", len(requests)), nil
+}
+
+// Admin API
+
+// AdminListRecoveryRequests lists recovery requests, newest first, by ?status and ?user_id
+func (s *RecoveryService) AdminListRecoveryRequests(c *gin.Context) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		args = append(args, id)
+		conditions = append(conditions, fmt.Sprintf("r.user_id = $%d", len(args)))
+	}
+	if status := c.Query("status"); status != "" {
+		args = append(args, status)
+		conditions = append(conditions, fmt.Sprintf("r.status = $%d", len(args)))
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	requests, err := s.queryRequests(c.Request.Context(), fmt.Sprintf(`%s ORDER BY r.created_at DESC LIMIT $%d`,
+		strings.Join(conditions, " AND "), len(args)), args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recovery requests"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+// AdminGetRecoveryRequest returns a request with its contacts' decisions and its audit trail
+func (s *RecoveryService) AdminGetRecoveryRequest(c *gin.Context) {
+	requestID, err := uuid.Parse(c.Param("request_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+	ctx := c.Request.Context()
+	requests, err := s.queryRequests(ctx, `r.id = $1`, requestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recovery request"})
+		return
+	}
+	if len(requests) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recovery request not found"})
+		return
+	}
+
+	decisions := []gin.H{}
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT a.contact_id, u.username, a.decision, a.ip_address, a.user_agent, a.created_at
+		FROM recovery_approvals a LEFT JOIN users u ON u.id = a.contact_id
+		WHERE a.request_id = $1 ORDER BY a.created_at`, requestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recovery request"})
+		return
+	}
+	for rows.Next() {
+		var contactID uuid.UUID
+		var username sql.NullString
+		var decision, ip, userAgent string
+		var createdAt time.Time
+		if err := rows.Scan(&contactID, &username, &decision, &ip, &userAgent, &createdAt); err != nil {
+			continue
+		}
+		decisions = append(decisions, gin.H{
+			"contact_id": contactID, "username": username.String, "decision": decision,
+			"ip_address": ip, "user_agent": userAgent, "created_at": createdAt,
+		})
+	}
+	rows.Close()
+
+	events := []gin.H{}
+	rows, err = s.as.db.QueryContext(ctx, `
+		SELECT actor_id, event, detail, ip_address, user_agent, created_at
+		FROM recovery_events WHERE request_id = $1 ORDER BY created_at, id`, requestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recovery request"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var actorID *uuid.UUID
+		var event, detail, ip, userAgent string
+		var createdAt time.Time
+		if err := rows.Scan(&actorID, &event, &detail, &ip, &userAgent, &createdAt); err != nil {
+			continue
+		}
+		events = append(events, gin.H{
+			"actor_id": actorID, "event": event, "detail": detail,
+			"ip_address": ip, "user_agent": userAgent, "created_at": createdAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"request": requests[0], "decisions": decisions, "events": events})
+}
+
+// AdminCancelRecoveryRequest stops a recovery an admin believes is an attack
+func (s *RecoveryService) AdminCancelRecoveryRequest(c *gin.Context) {
+	requestID, err := uuid.Parse(c.Param("request_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return
+	}
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+	adminID := c.MustGet("user_id").(uuid.UUID)
+	s.cancel(c, requestID, nil, &adminID, req.Reason)
+}
+
+// AdminRecoveryAbuse surfaces patterns worth a look over the last ?days (default 7): accounts
+// with repeated requests, addresses requesting for several accounts, and contacts approving
+// for several accounts
+func (s *RecoveryService) AdminRecoveryAbuse(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days <= 0 || days > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 90"})
+		return
+	}
+	ctx := c.Request.Context()
+	query := func(statement string, scan func(rows *sql.Rows) (gin.H, error)) ([]gin.H, error) {
+		rows, err := s.as.db.QueryContext(ctx, statement, days)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		results := []gin.H{}
+		for rows.Next() {
+			result, err := scan(rows)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		return results, rows.Err()
+	}
+
+	byStatus, err := query(`
+		SELECT status, COUNT(*) FROM recovery_requests
+		WHERE created_at > NOW() - $1 * INTERVAL '1 day' GROUP BY status`, func(rows *sql.Rows) (gin.H, error) {
+		var status string
+		var count int
+		err := rows.Scan(&status, &count)
+		return gin.H{"status": status, "requests": count}, err
+	})
+	var accounts, addresses, contacts []gin.H
+	if err == nil {
+		accounts, err = query(`
+			SELECT r.user_id, u.username, COUNT(*), COUNT(DISTINCT r.ip_address)
+			FROM recovery_requests r JOIN users u ON u.id = r.user_id
+			WHERE r.created_at > NOW() - $1 * INTERVAL '1 day'
+			GROUP BY r.user_id, u.username HAVING COUNT(*) >= 2
+			ORDER BY COUNT(*) DESC LIMIT 50`, func(rows *sql.Rows) (gin.H, error) {
+			var userID uuid.UUID
+			var username string
+			var requests, ips int
+			err := rows.Scan(&userID, &username, &requests, &ips)
+			return gin.H{"user_id": userID, "username": username, "requests": requests, "ip_addresses": ips}, err
+		})
+	}
+	if err == nil {
+		addresses, err = query(`
+			SELECT ip_address, COUNT(DISTINCT user_id), COUNT(*)
+			FROM recovery_requests WHERE created_at > NOW() - $1 * INTERVAL '1 day'
+			GROUP BY ip_address HAVING COUNT(DISTINCT user_id) >= 2
+			ORDER BY COUNT(DISTINCT user_id) DESC LIMIT 50`, func(rows *sql.Rows) (gin.H, error) {
+			var ip string
+			var accounts, requests int
+			err := rows.Scan(&ip, &accounts, &requests)
+			return gin.H{"ip_address": ip, "accounts": accounts, "requests": requests}, err
+		})
+	}
+	if err == nil {
+		contacts, err = query(`
+			SELECT a.contact_id, u.username, COUNT(DISTINCT r.user_id)
+			FROM recovery_approvals a
+			JOIN recovery_requests r ON r.id = a.request_id
+			JOIN users u ON u.id = a.contact_id
+			WHERE a.decision = 'approve' AND a.created_at > NOW() - $1 * INTERVAL '1 day'
+			GROUP BY a.contact_id, u.username HAVING COUNT(DISTINCT r.user_id) >= 2
+			ORDER BY COUNT(DISTINCT r.user_id) DESC LIMIT 50`, func(rows *sql.Rows) (gin.H, error) {
+			var contactID uuid.UUID
+			var username string
+			var accounts int
+			err := rows.Scan(&contactID, &username, &accounts)
+			return gin.H{"contact_id": contactID, "username": username, "accounts_approved": accounts}, err
+		})
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load recovery activity"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"days":                      days,
+		"requests_by_status":        byStatus,
+		"repeated_accounts":         accounts,
+		"addresses_across_accounts": addresses,
+		"contacts_across_accounts":  contacts,
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type RecoveryContactsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RecoveryContactsTestSuite) TestTally() {
+	config := RecoveryConfig{Threshold: 2}
+	suite.Equal(recoveryPending, config.tally(0, 0))
+	suite.Equal(recoveryPending, config.tally(1, 0))
+	suite.Equal(recoveryApproved, config.tally(2, 0))
+	suite.Equal(recoveryDenied, config.tally(5, 1), "a single denial outweighs any number of approvals")
+}
+
+func (suite *RecoveryContactsTestSuite) TestOnlySettledContactsCount() {
+	config := RecoveryConfig{ContactMinAge: 72 * time.Hour}
+	requestedAt := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	settled := requestedAt.Add(-96 * time.Hour)
+	recent := requestedAt.Add(-time.Hour)
+
+	suite.True(config.contactCounts(&settled, requestedAt))
+	suite.False(config.contactCounts(&recent, requestedAt), "contacts added just before a request cannot approve it")
+	suite.False(config.contactCounts(nil, requestedAt), "contacts who have not accepted cannot approve")
+	suite.True(RecoveryConfig{}.contactCounts(&recent, requestedAt))
+}
+
+func (suite *RecoveryContactsTestSuite) TestCodes() {
+	code, err := generateRecoveryCode()
+	suite.Require().NoError(err)
+	suite.Regexp(regexp.MustCompile(`^[A-Z2-7]{4}-[A-Z2-7]{4}$`), code)
+
+	requestID := uuid.New()
+	hash := hashRecoveryValue(requestID, code)
+	suite.True(recoveryValueMatches(requestID, code, hash))
+	suite.True(recoveryValueMatches(requestID, " "+strings.ToLower(strings.ReplaceAll(code, "-", ""))+" ", hash), "codes survive being retyped")
+	suite.False(recoveryValueMatches(uuid.New(), code, hash), "a code only matches its own request")
+	suite.False(recoveryValueMatches(requestID, "AAAA-AAAA", hash))
+}
+
+func (suite *RecoveryContactsTestSuite) TestRequestIsOpen() {
+	now := time.Now()
+	suite.True((&RecoveryRequest{Status: recoveryPending, ExpiresAt: now.Add(time.Hour)}).open(now))
+	suite.True((&RecoveryRequest{Status: recoveryApproved, ExpiresAt: now.Add(time.Hour)}).open(now))
+	suite.False((&RecoveryRequest{Status: recoveryPending, ExpiresAt: now.Add(-time.Second)}).open(now))
+	suite.False((&RecoveryRequest{Status: recoveryCancelled, ExpiresAt: now.Add(time.Hour)}).open(now))
+}
+
+func (suite *RecoveryContactsTestSuite) TestMalformedRequestsAreRejectedBeforeTheDatabase() {
+	gin.SetMode(gin.TestMode)
+	s := &RecoveryService{as: &AuthService{}, config: RecoveryConfig{Threshold: 1}}
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", uuid.New()) })
+	router.GET("/recovery/:request_id", s.GetRecoveryStatus)
+	router.POST("/recovery/:request_id/complete", s.CompleteRecovery)
+	router.POST("/me/recovery/requests/:request_id/approve", s.Decide("approve"))
+	router.POST("/me/recovery/requests/:request_id/cancel", s.CancelRequest)
+
+	status := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	requestID := uuid.New().String()
+	suite.Equal(http.StatusNotFound, status(http.MethodGet, "/recovery/"+requestID, ""), "a missing secret looks like a missing request")
+	suite.Equal(http.StatusNotFound, status(http.MethodGet, "/recovery/not-a-uuid", ""))
+	suite.Equal(http.StatusBadRequest, status(http.MethodPost, "/recovery/"+requestID+"/complete", `{"secret": "s", "new_password": "short"}`))
+	suite.Equal(http.StatusBadRequest, status(http.MethodPost, "/me/recovery/requests/"+requestID+"/approve", `{}`))
+	suite.Equal(http.StatusBadRequest, status(http.MethodPost, "/me/recovery/requests/nope/cancel", ""))
+}
+
+func (suite *RecoveryContactsTestSuite) TestConfig() {
+	suite.T().Setenv("RECOVERY_CONTACTS_ENABLED", "true")
+	config, err := DefaultRecoveryConfig()
+	suite.Require().NoError(err)
+	suite.Equal(RecoveryConfig{Enabled: true, MaxContacts: 5, Threshold: 3, Window: 72 * time.Hour, Delay: 24 * time.Hour, ContactMinAge: 72 * time.Hour, StartLimit: 3}, config)
+
+	suite.T().Setenv("RECOVERY_MAX_CONTACTS", "2")
+	_, err = DefaultRecoveryConfig()
+	suite.Error(err, "the threshold cannot exceed the number of contacts")
+
+	suite.T().Setenv("RECOVERY_THRESHOLD", "2")
+	suite.T().Setenv("RECOVERY_WINDOW", "soon")
+	_, err = DefaultRecoveryConfig()
+	suite.Error(err)
+
+	suite.Nil(NewRecoveryService(&AuthService{}, RecoveryConfig{}, nil))
+}
+
+func TestRecoveryContacts(t *testing.T) {
+	suite.Run(t, new(RecoveryContactsTestSuite))
+}
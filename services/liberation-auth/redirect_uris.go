@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"nuclear-ao3/shared/models"
+)
+
+// RedirectURIConfig controls which registered redirect URIs may be patterns
+type RedirectURIConfig struct {
+	// SubdomainWildcards lets trusted clients register https://*.example.com/... URIs
+	SubdomainWildcards bool
+}
+
+// DefaultRedirectURIConfig reads REDIRECT_URI_SUBDOMAIN_WILDCARDS from the environment
+func DefaultRedirectURIConfig() RedirectURIConfig {
+	return RedirectURIConfig{SubdomainWildcards: getEnv("REDIRECT_URI_SUBDOMAIN_WILDCARDS", "false") == "true"}
+}
+
+// redirectPattern is a registered redirect URI. Besides exact URIs, two forms match more
+// than one URI: loopback URIs match any port (RFC 8252 section 7.3), with or without an
+// explicit ":*", and https://*.example.com matches exactly one extra label for trusted clients.
+type redirectPattern struct {
+	scheme   string
+	host     string
+	port     string
+	path     string
+	rawQuery string
+	// loopback hosts match on any port, as native apps listen on an ephemeral one
+	loopback bool
+	// wildcard holds the parent domain of a *. host
+	wildcard string
+}
+
+// parseRedirectPattern parses a registered redirect URI
+func parseRedirectPattern(raw string) (*redirectPattern, error) {
+	if strings.Contains(raw, "#") {
+		return nil, fmt.Errorf("redirect URI must not contain a fragment")
+	}
+
+	// url.Parse rejects a "*" port, so strip it before parsing
+	anyPort := false
+	if scheme, rest, found := strings.Cut(raw, "://"); found {
+		authorityEnd := strings.IndexAny(rest, "/?")
+		if authorityEnd < 0 {
+			authorityEnd = len(rest)
+		}
+		if authority := rest[:authorityEnd]; strings.HasSuffix(authority, ":*") {
+			anyPort = true
+			raw = scheme + "://" + strings.TrimSuffix(authority, ":*") + rest[authorityEnd:]
+		}
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect URI: %w", err)
+	}
+	if parsed.User != nil {
+		return nil, fmt.Errorf("redirect URI must not contain credentials")
+	}
+
+	pattern := &redirectPattern{
+		scheme:   parsed.Scheme,
+		host:     parsed.Hostname(),
+		port:     parsed.Port(),
+		path:     parsed.EscapedPath(),
+		rawQuery: parsed.RawQuery,
+		loopback: isLoopbackHost(parsed.Hostname()),
+	}
+
+	if strings.Contains(pattern.path+pattern.rawQuery, "*") {
+		return nil, fmt.Errorf("wildcards are only allowed in the leftmost host label")
+	}
+	if parent, found := strings.CutPrefix(pattern.host, "*."); found {
+		if pattern.scheme != "https" {
+			return nil, fmt.Errorf("subdomain wildcards require https")
+		}
+		if strings.Contains(parent, "*") || !strings.Contains(parent, ".") || net.ParseIP(parent) != nil {
+			return nil, fmt.Errorf("subdomain wildcards need a registrable parent domain such as *.example.com")
+		}
+		pattern.wildcard = parent
+	} else if strings.Contains(pattern.host, "*") {
+		return nil, fmt.Errorf("wildcards are only allowed in the leftmost host label")
+	}
+	if anyPort && !pattern.loopback {
+		return nil, fmt.Errorf("port wildcards are only allowed for loopback redirect URIs")
+	}
+	return pattern, nil
+}
+
+// isLoopbackHost reports whether a host is localhost or a loopback IP literal
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// matches reports whether a requested redirect URI falls under this pattern. Only the
+// loopback port and a wildcard label may differ; scheme, path and query must be identical.
+func (p *redirectPattern) matches(requested *url.URL, allowWildcard bool) bool {
+	if requested.Scheme != p.scheme || requested.EscapedPath() != p.path || requested.RawQuery != p.rawQuery {
+		return false
+	}
+
+	host := requested.Hostname()
+	switch {
+	case p.loopback:
+		// RFC 8252: loopback redirects use http and the same IP literal or name as registered
+		return p.scheme == "http" && host == p.host
+	case p.wildcard != "" && allowWildcard:
+		label, found := strings.CutSuffix(host, "."+p.wildcard)
+		return found && label != "" && !strings.Contains(label, ".") && requested.Port() == p.port
+	default:
+		return false
+	}
+}
+
+// redirectURIAllowed checks a requested redirect URI against a client's registrations.
+// Exact matches always pass; otherwise only loopback ports and, for trusted clients when
+// enabled outside OAuth 2.1 mode, subdomain wildcards may vary.
+func (as *AuthService) redirectURIAllowed(client *models.OAuthClient, requested string) bool {
+	if contains(client.RedirectURIs, requested) {
+		return true
+	}
+
+	parsed, err := url.Parse(requested)
+	if err != nil || parsed.User != nil || strings.ContainsAny(requested, "#*") {
+		return false
+	}
+
+	allowWildcard := client.IsTrusted && as.redirects.SubdomainWildcards && !as.oauth21.Enabled
+	for _, registered := range client.RedirectURIs {
+		pattern, err := parseRedirectPattern(registered)
+		if err != nil {
+			continue
+		}
+		if pattern.matches(parsed, allowWildcard) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"nuclear-ao3/shared/models"
+)
+
+// refreshCookiePath scopes refresh cookies to the endpoint that reads them, so browsers
+// never attach them to any other request
+const refreshCookiePath = "/auth/token/refresh-cookie"
+
+// RefreshCookieConfig controls how refresh tokens are set as cookies for clients that opt in
+type RefreshCookieConfig struct {
+	// Secret binds each refresh cookie to the CSRF token handed out with it
+	Secret []byte
+	// SameSite is Strict unless the single-page app runs on another site than liberation-auth
+	SameSite http.SameSite
+	// Secure marks cookies Secure even on plain HTTP requests, as behind a TLS-terminating proxy
+	Secure bool
+	// TrustedProxies are the reverse proxies believed about the request's scheme
+	TrustedProxies []*net.IPNet
+}
+
+// DefaultRefreshCookieConfig reads REFRESH_COOKIE_SAMESITE, sharing the CSRF secret
+func DefaultRefreshCookieConfig(csrf CSRFConfig) (RefreshCookieConfig, error) {
+	config := RefreshCookieConfig{Secret: csrf.Secret, Secure: csrf.SecureCookie, TrustedProxies: csrf.TrustedProxies}
+	switch sameSite := getEnv("REFRESH_COOKIE_SAMESITE", "strict"); sameSite {
+	case "strict":
+		config.SameSite = http.SameSiteStrictMode
+	case "lax":
+		config.SameSite = http.SameSiteLaxMode
+	case "none":
+		config.SameSite = http.SameSiteNoneMode
+		if getEnv("GIN_MODE", "debug") != "release" {
+			return config, fmt.Errorf("REFRESH_COOKIE_SAMESITE=none needs Secure cookies, which are only set with GIN_MODE=release")
+		}
+	default:
+		return config, fmt.Errorf("REFRESH_COOKIE_SAMESITE must be strict, lax or none, got %q", sameSite)
+	}
+	return config, nil
+}
+
+// refreshCookieName is per client, so several single-page apps can share a browser
+func refreshCookieName(clientID uuid.UUID) string {
+	return "refresh_token_" + clientID.String()
+}
+
+// csrfToken is the token a client must echo to use its refresh cookie. It is derived from
+// the refresh token, so it changes on every refresh and cannot be used with any other cookie.
+func (cfg RefreshCookieConfig) csrfToken(clientID uuid.UUID, refreshToken string) string {
+	mac := hmac.New(sha256.New, cfg.Secret)
+	mac.Write([]byte("refresh-cookie:" + clientID.String() + ":" + refreshToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (cfg RefreshCookieConfig) set(c *gin.Context, clientID uuid.UUID, refreshToken string, expiresAt time.Time) {
+	c.SetSameSite(cfg.SameSite)
+	c.SetCookie(refreshCookieName(clientID), refreshToken, int(time.Until(expiresAt).Seconds()), refreshCookiePath, "", cfg.Secure || c.Request.TLS != nil, true)
+}
+
+func (cfg RefreshCookieConfig) clear(c *gin.Context, clientID uuid.UUID) {
+	c.SetSameSite(cfg.SameSite)
+	c.SetCookie(refreshCookieName(clientID), "", -1, refreshCookiePath, "", cfg.Secure || c.Request.TLS != nil, true)
+}
+
+// clientOrigins are the origins of a client's redirect URIs, where its single-page app runs
+func clientOrigins(client *models.OAuthClient) []string {
+	var origins []string
+	for _, redirectURI := range client.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || parsed.Host == "" || strings.Contains(parsed.Host, "*") {
+			continue
+		}
+		origins = append(origins, strings.ToLower(parsed.Scheme+"://"+parsed.Host))
+	}
+	return origins
+}
+
+// refreshCookieEnabled reports whether a client has opted in to refresh cookies
+func (as *AuthService) refreshCookieEnabled(ctx context.Context, clientID uuid.UUID) bool {
+	if as.db == nil {
+		return false
+	}
+	var enabled bool
+	err := as.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM client_refresh_cookies WHERE client_id = $1)`, clientID).Scan(&enabled)
+	if err != nil {
+		log.Printf("Failed to load refresh cookie setting for client %s: %v", clientID, err)
+		return false
+	}
+	return enabled
+}
+
+// writeTokenResponse answers a token request that issued a refresh token for a user.
+// Clients that opted in get the refresh token as an httpOnly cookie instead of in the body,
+// together with the CSRF token that has to accompany it.
+func (as *AuthService) writeTokenResponse(c *gin.Context, clientID, userID uuid.UUID, response models.TokenResponse, refreshExpiresAt time.Time) {
+	granted := as.tokenResponseWithConsent(c.Request.Context(), response, userID, clientID)
+	if response.RefreshToken == "" || !as.refreshCookieEnabled(c.Request.Context(), clientID) {
+		c.JSON(http.StatusOK, granted)
+		return
+	}
+
+	as.refreshCookies.set(c, clientID, response.RefreshToken, refreshExpiresAt)
+	body := claimsMap(granted)
+	delete(body, "refresh_token")
+	body["refresh_cookie"] = true
+	body["csrf_token"] = as.refreshCookies.csrfToken(clientID, response.RefreshToken)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, body)
+}
+
+// refreshCookieRequest checks a request to the refresh cookie endpoint: the client must have
+// opted in, the request must come from one of its origins and carry the CSRF token issued
+// with the cookie. It returns the client and the refresh token from the cookie.
+func (as *AuthService) refreshCookieRequest(c *gin.Context) (*models.OAuthClient, string, bool) {
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		clientID = c.PostForm("client_id")
+	}
+	client, err := as.getClientByID(c.Request.Context(), clientID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.TokenErrorResponse{
+			Error:            "invalid_client",
+			ErrorDescription: "Client authentication failed",
+		})
+		return nil, "", false
+	}
+	if !as.refreshCookieEnabled(c.Request.Context(), client.ID) {
+		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
+			Error:            "unauthorized_client",
+			ErrorDescription: "This client does not use refresh cookies",
+		})
+		return nil, "", false
+	}
+
+	// Browsers always send Origin on cross-origin POSTs; a request without it or a Referer
+	// did not come from the client's app
+	if (c.GetHeader("Origin") == "" && c.GetHeader("Referer") == "") || !requestOriginTrusted(c.Request, clientOrigins(client), as.refreshCookies.TrustedProxies) {
+		abortCSRF(c, "csrf_origin_rejected", "Request origin is not one of the client's redirect URI origins")
+		return nil, "", false
+	}
+
+	refreshToken, err := c.Cookie(refreshCookieName(client.ID))
+	if err != nil || refreshToken == "" {
+		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
+			Error:            "invalid_grant",
+			ErrorDescription: "No refresh cookie; sign in again",
+		})
+		return nil, "", false
+	}
+	expected := as.refreshCookies.csrfToken(client.ID, refreshToken)
+	if !hmac.Equal([]byte(c.GetHeader("X-CSRF-Token")), []byte(expected)) {
+		abortCSRF(c, "csrf_token_invalid", "Missing or invalid CSRF token")
+		return nil, "", false
+	}
+	return client, refreshToken, true
+}
+
+// RefreshWithCookie issues a new access token from the refresh token in the client's
+// cookie. The refresh token rotates as on the token endpoint, and the cookie and CSRF token
+// with it. A cookie that no longer works is cleared.
+func (as *AuthService) RefreshWithCookie(c *gin.Context) {
+	client, refreshToken, ok := as.refreshCookieRequest(c)
+	if !ok {
+		return
+	}
+
+	if _, err := as.validateRefreshToken(c.Request.Context(), refreshToken, client.ID); err != nil {
+		description := "Invalid refresh token; sign in again"
+		if notice := as.sessionLimits.evictionNotice(c.Request.Context(), refreshToken, client.ID); notice != "" {
+			description = notice
+		}
+		as.refreshCookies.clear(c, client.ID)
+		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
+			Error:            "invalid_grant",
+			ErrorDescription: description,
+		})
+		return
+	}
+
+	as.handleRefreshTokenGrant(c, models.TokenRequest{
+		GrantType:    "refresh_token",
+		ClientID:     client.ID.String(),
+		RefreshToken: refreshToken,
+		Scope:        c.PostForm("scope"),
+	})
+}
+
+// SignOutRefreshCookie revokes the refresh token in the client's cookie and clears it
+func (as *AuthService) SignOutRefreshCookie(c *gin.Context) {
+	client, refreshToken, ok := as.refreshCookieRequest(c)
+	if !ok {
+		return
+	}
+	as.revokeRefreshTokenByValue(c.Request.Context(), refreshToken)
+	as.refreshCookies.clear(c, client.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// Admin API
+
+// adminRefreshCookieStatus describes a client's refresh cookie setting
+func (as *AuthService) adminRefreshCookieStatus(ctx context.Context, client *models.OAuthClient) (gin.H, error) {
+	var updatedBy *uuid.UUID
+	var updatedAt time.Time
+	err := as.db.QueryRowContext(ctx, `SELECT updated_by, updated_at FROM client_refresh_cookies WHERE client_id = $1`, client.ID).
+		Scan(&updatedBy, &updatedAt)
+	if err == sql.ErrNoRows {
+		return gin.H{"enabled": false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"enabled":    true,
+		"cookie":     refreshCookieName(client.ID),
+		"origins":    clientOrigins(client),
+		"updated_by": updatedBy,
+		"updated_at": updatedAt,
+	}, nil
+}
+
+func (as *AuthService) AdminGetRefreshCookie(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+	status, err := as.adminRefreshCookieStatus(c.Request.Context(), client)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load refresh cookie setting"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (as *AuthService) AdminPutRefreshCookie(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+	// The cookie endpoint only trusts requests from the client's redirect URI origins
+	if len(clientOrigins(client)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh cookies need a client with at least one redirect URI on a fixed origin"})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	_, err := as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO client_refresh_cookies (client_id, updated_by, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (client_id) DO UPDATE SET updated_by = $2, updated_at = NOW()`, client.ID, adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save refresh cookie setting"})
+		return
+	}
+	status, err := as.adminRefreshCookieStatus(c.Request.Context(), client)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load refresh cookie setting"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (as *AuthService) AdminDeleteRefreshCookie(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+	if _, err := as.db.ExecContext(c.Request.Context(), `DELETE FROM client_refresh_cookies WHERE client_id = $1`, client.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete refresh cookie setting"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Refresh cookies disabled; new refresh tokens are returned in the response body"})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+
+	"nuclear-ao3/shared/models"
+)
+
+type RefreshCookieTestSuite struct {
+	suite.Suite
+	config RefreshCookieConfig
+}
+
+func (suite *RefreshCookieTestSuite) SetupTest() {
+	suite.config = RefreshCookieConfig{Secret: []byte("test-secret"), SameSite: http.SameSiteStrictMode}
+}
+
+func (suite *RefreshCookieTestSuite) TestConfig() {
+	config, err := DefaultRefreshCookieConfig(CSRFConfig{Secret: []byte("s")})
+	suite.Require().NoError(err)
+	suite.Equal(http.SameSiteStrictMode, config.SameSite)
+
+	suite.T().Setenv("REFRESH_COOKIE_SAMESITE", "none")
+	_, err = DefaultRefreshCookieConfig(CSRFConfig{})
+	suite.Error(err, "SameSite=None cookies must be Secure")
+	suite.T().Setenv("GIN_MODE", "release")
+	config, err = DefaultRefreshCookieConfig(CSRFConfig{})
+	suite.Require().NoError(err)
+	suite.Equal(http.SameSiteNoneMode, config.SameSite)
+
+	suite.T().Setenv("REFRESH_COOKIE_SAMESITE", "sometimes")
+	_, err = DefaultRefreshCookieConfig(CSRFConfig{})
+	suite.Error(err)
+}
+
+func (suite *RefreshCookieTestSuite) TestCSRFTokenIsBoundToCookieAndClient() {
+	client := uuid.New()
+	token := suite.config.csrfToken(client, "refresh-1")
+	suite.Equal(token, suite.config.csrfToken(client, "refresh-1"))
+	suite.NotEqual(token, suite.config.csrfToken(client, "refresh-2"), "rotates with the refresh token")
+	suite.NotEqual(token, suite.config.csrfToken(uuid.New(), "refresh-1"))
+}
+
+func (suite *RefreshCookieTestSuite) TestCookieIsHTTPOnlyAndScopedToEndpoint() {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/token", nil)
+	client := uuid.New()
+
+	suite.config.set(c, client, "refresh-1", time.Now().Add(time.Hour))
+	cookies := w.Result().Cookies()
+	suite.Require().Len(cookies, 1)
+	suite.Equal(refreshCookieName(client), cookies[0].Name)
+	suite.True(cookies[0].HttpOnly)
+	suite.Equal(refreshCookiePath, cookies[0].Path)
+	suite.Equal(http.SameSiteStrictMode, cookies[0].SameSite)
+	suite.InDelta(3600, cookies[0].MaxAge, 5)
+}
+
+func (suite *RefreshCookieTestSuite) TestClientOrigins() {
+	client := &models.OAuthClient{RedirectURIs: []string{
+		"https://App.example.com/callback",
+		"https://app.example.com/silent",
+		"http://localhost:3000/cb",
+		"https://*.preview.example.com/cb",
+		"com.example.app:/callback",
+	}}
+	suite.Equal([]string{"https://app.example.com", "https://app.example.com", "http://localhost:3000"}, clientOrigins(client))
+}
+
+func TestRefreshCookieTestSuite(t *testing.T) {
+	suite.Run(t, new(RefreshCookieTestSuite))
+}
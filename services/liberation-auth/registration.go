@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Registration stages for progressive profiling
+const (
+	registrationStageMinimal  = "minimal"
+	registrationStageComplete = "complete"
+)
+
+// MinimalRegisterRequest creates an account with only credentials; the profile is completed later
+type MinimalRegisterRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	Password   string `json:"password" binding:"required,min=8"`
+	InviteCode string `json:"invite_code"`
+	AcceptTOS  bool   `json:"accept_tos"`
+}
+
+// CompleteRegistrationRequest fills in the profile fields skipped at signup
+type CompleteRegistrationRequest struct {
+	Username    string `json:"username" binding:"required"`
+	DisplayName string `json:"display_name"`
+}
+
+// createUserWithInvite inserts a user and redeems the invite, if any, in one transaction.
+// It enforces invite-only registration. A signup held by spam screening is created inactive
+// and queued for review.
+func (as *AuthService) createUserWithInvite(ctx context.Context, user *models.User, passwordHash, inviteCode, stage string, held *SpamVerdict) (*uuid.UUID, error) {
+	if inviteCode == "" && as.registration.InviteOnly {
+		return nil, errInviteRequired
+	}
+
+	tx, err := as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO users (id, username, email, email_bidx, password_hash, display_name, is_active, is_verified, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, false, $8, $9)`,
+		user.ID, user.Username, as.pii.value(piiUserEmail, user.Email), as.pii.index(piiUserEmail, user.Email),
+		passwordHash, user.DisplayName, held == nil, user.CreatedAt, user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if held != nil {
+		user.IsActive = false
+		if err := holdSignupForReview(ctx, tx, user.ID, held); err != nil {
+			return nil, err
+		}
+	}
+
+	var inviteID *uuid.UUID
+	if inviteCode != "" {
+		if inviteID, err = redeemInvite(ctx, tx, inviteCode, user.Email, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fully registered users without an invite need no tracking row
+	if stage != registrationStageComplete || inviteID != nil {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO user_registrations (user_id, stage, invite_id, created_at, completed_at)
+			VALUES ($1, $2, $3, $4, CASE WHEN $2 = 'complete' THEN $4::timestamp END)`,
+			user.ID, stage, inviteID, user.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return inviteID, tx.Commit()
+}
+
+// registrationErrorResponse maps account creation failures to API errors
+func registrationErrorResponse(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errInviteRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": "invite_required", "error_description": err.Error()})
+	case errors.Is(err, errInviteInvalid):
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid_invite", "error_description": err.Error()})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "user_exists"})
+	}
+}
+
+// RegisterMinimal creates an account from email and password alone
+func (as *AuthService) RegisterMinimal(c *gin.Context) {
+	var req MinimalRegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	// The username comes later, so only the address can be screened here
+	held, ok := as.spam.screenRegistration(c, signupText("", "", req.Email), req.InviteCode != "")
+	if !ok {
+		return
+	}
+
+	hashedPassword, err := as.passwords.generate(c.Request.Context(), req.Password)
+	if err == errPasswordHashOverloaded {
+		rejectOverloaded(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	now := time.Now()
+	user := &models.User{
+		ID:        uuid.New(),
+		Username:  placeholderUsername(),
+		Email:     strings.TrimSpace(req.Email),
+		IsActive:  true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if _, err := as.createUserWithInvite(c.Request.Context(), user, string(hashedPassword), req.InviteCode, registrationStageMinimal, held); err != nil {
+		registrationErrorResponse(c, err)
+		return
+	}
+	if held != nil {
+		as.recordSecurityEvent(c, &user.ID, securityEventRegistered, map[string]interface{}{"stage": registrationStageMinimal, "held_for_review": true, "spam_score": held.Score})
+		respondPendingReview(c, user)
+		return
+	}
+	as.recordSecurityEvent(c, &user.ID, securityEventRegistered, map[string]interface{}{"stage": registrationStageMinimal})
+
+	accessToken, err := as.issueUserToken(c.Request.Context(), user.ID, 30*24*time.Hour, nil) // 30 days
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token_generation_failed"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"user":           user,
+		"access_token":   accessToken,
+		"token_type":     "Bearer",
+		"expires_at":     now.Add(30 * 24 * time.Hour).Unix(),
+		"stage":          registrationStageMinimal,
+		"missing_fields": []string{"username", "display_name"},
+	})
+}
+
+// GetRegistrationStatus tells the frontend whether profile fields are still outstanding
+func (as *AuthService) GetRegistrationStatus(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var stage string
+	var inviteID *uuid.UUID
+	err := as.db.QueryRowContext(c.Request.Context(), `SELECT stage, invite_id FROM user_registrations WHERE user_id = $1`, userID).Scan(&stage, &inviteID)
+	if err == sql.ErrNoRows {
+		stage = registrationStageComplete
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch registration status"})
+		return
+	}
+
+	missing := []string{}
+	if stage != registrationStageComplete {
+		missing = append(missing, "username", "display_name")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stage":          stage,
+		"missing_fields": missing,
+		"invite_id":      inviteID,
+	})
+}
+
+// CompleteRegistration sets the username and display name for a minimally registered account
+func (as *AuthService) CompleteRegistration(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+
+	var req CompleteRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+	username, usernameErr := as.checkUsername(c.Request.Context(), req.Username)
+	if usernameErr != nil {
+		as.rejectUsername(c, usernameErr)
+		return
+	}
+	req.Username = username
+	if req.DisplayName == "" {
+		req.DisplayName = req.Username
+	}
+
+	var stage string
+	err := as.db.QueryRowContext(c.Request.Context(), `SELECT stage FROM user_registrations WHERE user_id = $1`, userID).Scan(&stage)
+	if err == sql.ErrNoRows || stage == registrationStageComplete {
+		c.JSON(http.StatusConflict, gin.H{"error": "registration_complete"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch registration status"})
+		return
+	}
+
+	tx, err := as.db.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(c.Request.Context(), `UPDATE users SET username = $1, display_name = $2, updated_at = NOW() WHERE id = $3`,
+		req.Username, req.DisplayName, userID); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "username_taken"})
+		return
+	}
+	if _, err := tx.ExecContext(c.Request.Context(), `UPDATE user_registrations SET stage = $1, completed_at = NOW() WHERE user_id = $2`,
+		registrationStageComplete, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	as.forgetProfiles(c.Request.Context(), userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"stage":        registrationStageComplete,
+		"username":     req.Username,
+		"display_name": req.DisplayName,
+	})
+}
+
+// placeholderUsername reserves a unique-looking handle until the user picks one
+func placeholderUsername() string {
+	b := make([]byte, 5)
+	rand.Read(b)
+	return "user_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var requestsAborted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_requests_aborted_total",
+	Help: "Requests whose context ended before the handler finished, by reason (deadline, client_gone).",
+}, []string{"reason"})
+
+// QueryTimeoutConfig bounds how long database work may run
+type QueryTimeoutConfig struct {
+	// RequestTimeout is the deadline on each request's context, which every query in a
+	// handler runs under; zero leaves requests without a deadline
+	RequestTimeout time.Duration
+	// StatementTimeout is enforced by Postgres on every statement, including background
+	// jobs; zero leaves the server default
+	StatementTimeout time.Duration
+}
+
+// DefaultQueryTimeoutConfig reads REQUEST_TIMEOUT and DB_STATEMENT_TIMEOUT from the environment
+func DefaultQueryTimeoutConfig() (QueryTimeoutConfig, error) {
+	config := QueryTimeoutConfig{}
+	var err error
+	if config.RequestTimeout, err = time.ParseDuration(getEnv("REQUEST_TIMEOUT", "10s")); err != nil || config.RequestTimeout < 0 {
+		return config, fmt.Errorf("REQUEST_TIMEOUT must be a non-negative duration")
+	}
+	if config.StatementTimeout, err = time.ParseDuration(getEnv("DB_STATEMENT_TIMEOUT", "15s")); err != nil || config.StatementTimeout < 0 {
+		return config, fmt.Errorf("DB_STATEMENT_TIMEOUT must be a non-negative duration")
+	}
+	return config, nil
+}
+
+// withStatementTimeout adds statement_timeout to a DSN as a connection parameter, unless the
+// DSN already sets one. Both URL and key=value DSNs are accepted.
+func withStatementTimeout(dsn string, timeout time.Duration) string {
+	if timeout <= 0 || strings.Contains(dsn, "statement_timeout") {
+		return dsn
+	}
+	milliseconds := strconv.FormatInt(timeout.Milliseconds(), 10)
+	if parsed, err := url.Parse(dsn); err == nil && (parsed.Scheme == "postgres" || parsed.Scheme == "postgresql") {
+		query := parsed.Query()
+		query.Set("statement_timeout", milliseconds)
+		parsed.RawQuery = query.Encode()
+		return parsed.String()
+	}
+	return strings.TrimSpace(dsn) + " statement_timeout=" + milliseconds
+}
+
+// RequestTimeoutMiddleware puts a deadline on the request context. Queries run with that
+// context, so they are cancelled when the deadline passes or the client disconnects, and a
+// slow database sheds abandoned work instead of queueing it behind the connection pool.
+func RequestTimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
+		}
+
+		c.Next()
+
+		switch err := ctx.Err(); {
+		case errors.Is(err, context.DeadlineExceeded):
+			requestsAborted.WithLabelValues("deadline").Inc()
+		case errors.Is(err, context.Canceled):
+			requestsAborted.WithLabelValues("client_gone").Inc()
+		}
+	}
+}
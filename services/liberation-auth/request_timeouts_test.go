@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+// stalledDatabase is a driver whose statements never finish on their own, like a Postgres
+// stuck behind a lock; they only end when their context does
+type stalledDatabase struct {
+	inFlight atomic.Int64
+}
+
+type stalledConn struct{ db *stalledDatabase }
+
+func (d *stalledDatabase) Connect(context.Context) (driver.Conn, error) { return stalledConn{d}, nil }
+func (d *stalledDatabase) Driver() driver.Driver                        { return nil }
+
+func (c stalledConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c stalledConn) Close() error                        { return nil }
+func (c stalledConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c stalledConn) stall(ctx context.Context) error {
+	c.db.inFlight.Add(1)
+	defer c.db.inFlight.Add(-1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (c stalledConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	return nil, c.stall(ctx)
+}
+
+func (c stalledConn) ExecContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	return nil, c.stall(ctx)
+}
+
+type RequestTimeoutTestSuite struct {
+	suite.Suite
+	stalled *stalledDatabase
+	db      *sql.DB
+	router  *gin.Engine
+}
+
+func (suite *RequestTimeoutTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.stalled = &stalledDatabase{}
+	suite.db = sql.OpenDB(suite.stalled)
+	suite.db.SetMaxOpenConns(2)
+
+	as := &AuthService{db: suite.db}
+	suite.router = gin.New()
+	suite.router.Use(RequestTimeoutMiddleware(50 * time.Millisecond))
+	suite.router.GET("/admin/security-events", as.GetAllSecurityEvents)
+}
+
+func (suite *RequestTimeoutTestSuite) TearDownTest() {
+	suite.db.Close()
+}
+
+func (suite *RequestTimeoutTestSuite) TestSlowQueriesAreAbortedNotQueued() {
+	// Ten times more requests than connections: without deadlines they would wait forever
+	started := time.Now()
+	var wg sync.WaitGroup
+	codes := make([]int, 20)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			suite.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/security-events", nil))
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+
+	suite.Less(time.Since(started), 2*time.Second)
+	for _, code := range codes {
+		suite.Equal(http.StatusInternalServerError, code)
+	}
+	suite.Zero(suite.stalled.inFlight.Load())
+	suite.Zero(suite.db.Stats().InUse)
+}
+
+func (suite *RequestTimeoutTestSuite) TestClientDisconnectCancelsQuery() {
+	router := gin.New()
+	router.Use(RequestTimeoutMiddleware(time.Minute))
+	router.GET("/admin/security-events", (&AuthService{db: suite.db}).GetAllSecurityEvents)
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/security-events", nil).WithContext(ctx))
+		close(done)
+	}()
+	suite.Eventually(func() bool { return suite.stalled.inFlight.Load() == 1 }, time.Second, time.Millisecond)
+
+	disconnect()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		suite.Fail("query kept running after the client went away")
+	}
+	suite.Zero(suite.stalled.inFlight.Load())
+}
+
+func (suite *RequestTimeoutTestSuite) TestStatementTimeoutInDSN() {
+	suite.Equal("postgres://u:p@db/ao3?sslmode=disable&statement_timeout=5000",
+		withStatementTimeout("postgres://u:p@db/ao3?sslmode=disable", 5*time.Second))
+	suite.Equal("host=db user=u statement_timeout=250", withStatementTimeout("host=db user=u", 250*time.Millisecond))
+	suite.Equal("postgres://db/ao3?statement_timeout=100", withStatementTimeout("postgres://db/ao3?statement_timeout=100", time.Second))
+	suite.Equal("postgres://db/ao3", withStatementTimeout("postgres://db/ao3", 0))
+}
+
+func (suite *RequestTimeoutTestSuite) TestConfigRejectsNegativeTimeouts() {
+	suite.T().Setenv("DB_STATEMENT_TIMEOUT", "-1s")
+	_, err := DefaultQueryTimeoutConfig()
+	suite.Error(err)
+}
+
+func TestRequestTimeouts(t *testing.T) {
+	suite.Run(t, new(RequestTimeoutTestSuite))
+}
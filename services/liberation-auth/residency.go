@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Backends that keep user data
+const (
+	residencyDatabase = "database"
+	residencyStorage  = "storage"
+)
+
+const securityEventResidencyChanged = "residency_changed"
+
+// errResidency is returned when a write would keep a user's data outside their region
+var errResidency = errors.New("data residency violation")
+
+var residencyRegionPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+
+var residencyRefusals = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_residency_refusals_total",
+	Help: "Writes refused because the backend is outside the user's region, by backend.",
+}, []string{"backend"})
+
+// ResidencyConfig declares where each backend keeps user data
+type ResidencyConfig struct {
+	Enabled bool
+	// Backends maps database and, with STORAGE_BACKEND, storage to their regions
+	Backends map[string]string
+	// DefaultRegion applies to users without a region of their own; empty leaves them unpinned
+	DefaultRegion string
+}
+
+// DefaultResidencyConfig reads RESIDENCY_ENABLED, RESIDENCY_BACKENDS ("database=eu,storage=eu")
+// and RESIDENCY_DEFAULT_REGION. Every backend in use must declare its region.
+func DefaultResidencyConfig() (ResidencyConfig, error) {
+	config := ResidencyConfig{
+		Enabled:       getEnv("RESIDENCY_ENABLED", "false") == "true",
+		Backends:      map[string]string{},
+		DefaultRegion: strings.TrimSpace(getEnv("RESIDENCY_DEFAULT_REGION", "")),
+	}
+	if !config.Enabled {
+		return config, nil
+	}
+	for _, entry := range strings.Split(getEnv("RESIDENCY_BACKENDS", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		backend, region, ok := strings.Cut(entry, "=")
+		backend, region = strings.TrimSpace(backend), strings.TrimSpace(region)
+		if !ok || (backend != residencyDatabase && backend != residencyStorage) || !residencyRegionPattern.MatchString(region) {
+			return config, fmt.Errorf("RESIDENCY_BACKENDS entries are database=region or storage=region, such as database=eu, got %q", entry)
+		}
+		config.Backends[backend] = region
+	}
+	for _, backend := range residencyBackendsInUse() {
+		if config.Backends[backend] == "" {
+			return config, fmt.Errorf("RESIDENCY_BACKENDS must declare the region of %s", backend)
+		}
+	}
+	if config.DefaultRegion != "" {
+		if !residencyRegionPattern.MatchString(config.DefaultRegion) {
+			return config, fmt.Errorf("RESIDENCY_DEFAULT_REGION must be a region such as eu or eu-west-1")
+		}
+		// Every user is stored in the database, so a default elsewhere could never be met
+		if config.DefaultRegion != config.Backends[residencyDatabase] {
+			return config, fmt.Errorf("RESIDENCY_DEFAULT_REGION is %s but the database is in %s", config.DefaultRegion, config.Backends[residencyDatabase])
+		}
+	}
+	return config, nil
+}
+
+// residencyBackendsInUse lists the backends this deployment keeps user data in
+func residencyBackendsInUse() []string {
+	backends := []string{residencyDatabase}
+	if getEnv("STORAGE_BACKEND", "") != "" {
+		backends = append(backends, residencyStorage)
+	}
+	return backends
+}
+
+// userPlacement is where a user's data may be kept and which backends are elsewhere
+type userPlacement struct {
+	UserID uuid.UUID `json:"user_id"`
+	// Region is empty for unpinned users
+	Region string `json:"region,omitempty"`
+	// Source is pinned for users with a region of their own and default otherwise
+	Source     string   `json:"source,omitempty"`
+	Violations []string `json:"violations,omitempty"`
+	Compliant  bool     `json:"compliant"`
+}
+
+// ResidencyService pins users to regions. Users are pinned by admins, or by
+// RESIDENCY_DEFAULT_REGION, and writes that would keep a pinned user's data in a backend
+// elsewhere are refused. It is nil unless RESIDENCY_ENABLED is set; a nil service allows
+// everything.
+type ResidencyService struct {
+	as     *AuthService
+	config ResidencyConfig
+	inUse  []string
+}
+
+// NewResidencyService returns nil when residency is disabled
+func NewResidencyService(as *AuthService, config ResidencyConfig) *ResidencyService {
+	if !config.Enabled {
+		return nil
+	}
+	return &ResidencyService{as: as, config: config, inUse: residencyBackendsInUse()}
+}
+
+// place resolves a user's region from their own pin or the default region
+func (s *ResidencyService) place(ctx context.Context, userID uuid.UUID) (userPlacement, error) {
+	placement := userPlacement{UserID: userID, Compliant: true}
+	var region string
+	err := s.as.db.QueryRowContext(ctx, `SELECT residency_region FROM users WHERE id = $1`, userID).Scan(&region)
+	if err != nil {
+		return placement, err
+	}
+	switch {
+	case region != "":
+		placement.Region, placement.Source = region, "pinned"
+	case s.config.DefaultRegion != "":
+		placement.Region, placement.Source = s.config.DefaultRegion, "default"
+	default:
+		return placement, nil
+	}
+	for _, backend := range s.inUse {
+		if s.config.Backends[backend] != placement.Region {
+			placement.Violations = append(placement.Violations, backend)
+		}
+	}
+	placement.Compliant = len(placement.Violations) == 0
+	return placement, nil
+}
+
+// check returns an error wrapping errResidency when the user is pinned to a region and the
+// backend keeps data elsewhere
+func (s *ResidencyService) check(ctx context.Context, userID uuid.UUID, backend string) error {
+	if s == nil {
+		return nil
+	}
+	placement, err := s.place(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if placement.Region == "" || s.config.Backends[backend] == placement.Region {
+		return nil
+	}
+	residencyRefusals.WithLabelValues(backend).Inc()
+	return fmt.Errorf("%w: the user's data is kept in %s but %s is in %s", errResidency, placement.Region, backend, s.config.Backends[backend])
+}
+
+// refuse writes the response for a failed check; it reports whether the write must stop
+func (s *ResidencyService) refuse(c *gin.Context, userID uuid.UUID, backend string) bool {
+	err := s.check(c.Request.Context(), userID, backend)
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errResidency):
+		c.JSON(http.StatusForbidden, gin.H{"error": "residency_violation", "error_description": err.Error()})
+	default:
+		log.Printf("Failed to check residency for %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error", "error_description": "Failed to check data residency"})
+	}
+	return true
+}
+
+// AdminGetResidency lists the backends' regions and how many users are pinned to each region
+func (s *ResidencyService) AdminGetResidency(c *gin.Context) {
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `
+		SELECT residency_region, COUNT(*) FROM users WHERE residency_region <> '' GROUP BY residency_region`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count pinned users"})
+		return
+	}
+	defer rows.Close()
+	pinned := map[string]int{}
+	for rows.Next() {
+		var region string
+		var count int
+		if err := rows.Scan(&region, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count pinned users"})
+			return
+		}
+		pinned[region] = count
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count pinned users"})
+		return
+	}
+
+	backends := make(map[string]string, len(s.inUse))
+	for _, backend := range s.inUse {
+		backends[backend] = s.config.Backends[backend]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"backends":       backends,
+		"default_region": s.config.DefaultRegion,
+		"pinned_users":   pinned,
+	})
+}
+
+// AdminGetUserResidency shows a user's region and the backends in use outside it
+func (s *ResidencyService) AdminGetUserResidency(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	placement, err := s.place(c.Request.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read residency"})
+		return
+	}
+	c.JSON(http.StatusOK, placement)
+}
+
+// AdminSetUserResidency pins a user to a region, or unpins them with an empty region. Users
+// can only be pinned to the database's region, since every account is kept there. Data
+// already in storage is not moved.
+func (s *ResidencyService) AdminSetUserResidency(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req struct {
+		Region *string `json:"region" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	region := strings.TrimSpace(*req.Region)
+	if region != "" {
+		if !residencyRegionPattern.MatchString(region) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Regions are lowercase letters, digits and dashes, such as eu or eu-west-1"})
+			return
+		}
+		if database := s.config.Backends[residencyDatabase]; region != database {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":             "residency_violation",
+				"error_description": fmt.Sprintf("the database is in %s, so users cannot be pinned to %s", database, region),
+			})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	var previous string
+	err = s.as.db.QueryRowContext(ctx, `
+		WITH old AS (SELECT residency_region FROM users WHERE id = $1)
+		UPDATE users SET residency_region = $2 WHERE id = $1
+		RETURNING (SELECT residency_region FROM old)`, userID, region).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update residency"})
+		return
+	}
+	if previous != region {
+		s.as.recordSecurityEvent(c, &userID, securityEventResidencyChanged, adminAuditDetails(c, map[string]interface{}{
+			"previous_region": previous,
+			"region":          region,
+		}))
+	}
+
+	placement, err := s.place(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read residency"})
+		return
+	}
+	c.JSON(http.StatusOK, placement)
+}
+
+// describe summarizes the backends' regions for startup logs and the doctor
+func (c ResidencyConfig) describe() string {
+	parts := make([]string, 0, len(c.Backends))
+	for backend, region := range c.Backends {
+		parts = append(parts, backend+" in "+region)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type ResidencyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ResidencyTestSuite) TestConfig() {
+	config, err := DefaultResidencyConfig()
+	suite.Require().NoError(err)
+	suite.False(config.Enabled)
+	suite.Nil(NewResidencyService(&AuthService{}, config))
+
+	suite.T().Setenv("RESIDENCY_ENABLED", "true")
+	_, err = DefaultResidencyConfig()
+	suite.ErrorContains(err, "region of database", "the database always keeps user data")
+
+	suite.T().Setenv("RESIDENCY_BACKENDS", "database=eu")
+	config, err = DefaultResidencyConfig()
+	suite.Require().NoError(err)
+	suite.Equal("database in eu", config.describe())
+
+	suite.T().Setenv("STORAGE_BACKEND", "s3")
+	_, err = DefaultResidencyConfig()
+	suite.ErrorContains(err, "region of storage", "storage keeps avatars and exports once configured")
+
+	suite.T().Setenv("RESIDENCY_BACKENDS", "database=eu, storage=us-east-1")
+	config, err = DefaultResidencyConfig()
+	suite.Require().NoError(err)
+	suite.Equal("database in eu, storage in us-east-1", config.describe())
+	suite.Equal([]string{residencyDatabase, residencyStorage}, NewResidencyService(&AuthService{}, config).inUse)
+
+	for _, invalid := range []string{"database", "cache=eu", "database=EU", "database=eu west"} {
+		suite.T().Setenv("RESIDENCY_BACKENDS", invalid)
+		_, err = DefaultResidencyConfig()
+		suite.ErrorContains(err, "RESIDENCY_BACKENDS", invalid)
+	}
+
+	suite.T().Setenv("RESIDENCY_BACKENDS", "database=eu,storage=eu")
+	suite.T().Setenv("RESIDENCY_DEFAULT_REGION", "us")
+	_, err = DefaultResidencyConfig()
+	suite.ErrorContains(err, "database is in eu", "a default the database cannot meet is refused")
+	suite.T().Setenv("RESIDENCY_DEFAULT_REGION", "eu")
+	config, err = DefaultResidencyConfig()
+	suite.Require().NoError(err)
+	suite.Equal("eu", config.DefaultRegion)
+}
+
+func (suite *ResidencyTestSuite) TestNilServiceAllowsEverything() {
+	var service *ResidencyService
+	suite.NoError(service.check(context.Background(), uuid.New(), residencyStorage))
+}
+
+func (suite *ResidencyTestSuite) TestSetUserResidencyValidation() {
+	gin.SetMode(gin.TestMode)
+	service := &ResidencyService{
+		as:     &AuthService{},
+		config: ResidencyConfig{Enabled: true, Backends: map[string]string{residencyDatabase: "eu"}},
+		inUse:  []string{residencyDatabase},
+	}
+	router := gin.New()
+	router.PUT("/users/:user_id/residency", service.AdminSetUserResidency)
+
+	cases := []struct {
+		path, body string
+		status     int
+		contains   string
+	}{
+		{"/users/not-a-uuid/residency", `{"region": "eu"}`, http.StatusBadRequest, "Invalid user ID"},
+		{"/users/" + uuid.NewString() + "/residency", `{}`, http.StatusBadRequest, "Invalid request format"},
+		{"/users/" + uuid.NewString() + "/residency", `{"region": "Europe"}`, http.StatusBadRequest, "lowercase"},
+		{"/users/" + uuid.NewString() + "/residency", `{"region": "us"}`, http.StatusConflict, "the database is in eu"},
+	}
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, tc.path, strings.NewReader(tc.body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+		suite.Equal(tc.status, recorder.Code, tc.body)
+		suite.Contains(recorder.Body.String(), tc.contains, tc.body)
+	}
+}
+
+func TestResidency(t *testing.T) {
+	suite.Run(t, new(ResidencyTestSuite))
+}
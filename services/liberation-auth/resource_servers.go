@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"nuclear-ao3/shared/models"
+)
+
+// defaultFirstPartyAudience is the aud of the JWTs the archive's own API has always accepted
+const defaultFirstPartyAudience = "nuclear-ao3"
+
+// AudienceConfig decides the aud of first-party JWTs and how strictly audiences are checked.
+// The zero value uses the default first-party audience and accepts tokens issued without one.
+type AudienceConfig struct {
+	// FirstParty is the aud of login, registration and guest JWTs, which JWTAuthMiddleware requires
+	FirstParty string
+	// Required rejects OAuth access tokens issued without an audience at every resource server
+	Required bool
+}
+
+// DefaultAudienceConfig reads JWT_AUDIENCE and REQUIRE_TOKEN_AUDIENCE from the environment
+func DefaultAudienceConfig() (AudienceConfig, error) {
+	config := AudienceConfig{
+		FirstParty: getEnv("JWT_AUDIENCE", defaultFirstPartyAudience),
+		Required:   getEnv("REQUIRE_TOKEN_AUDIENCE", "false") == "true",
+	}
+	if config.FirstParty == "" || strings.ContainsAny(config.FirstParty, " \t") {
+		return config, fmt.Errorf("JWT_AUDIENCE must be a single non-empty value, got %q", config.FirstParty)
+	}
+	return config, nil
+}
+
+func (cfg AudienceConfig) firstParty() string {
+	if cfg.FirstParty == "" {
+		return defaultFirstPartyAudience
+	}
+	return cfg.FirstParty
+}
+
+// allows reports whether a token bound to audience may be used at the resource server want.
+// Tokens issued without an audience are accepted everywhere unless Required is set.
+func (cfg AudienceConfig) allows(audience []string, want string) bool {
+	if len(audience) == 0 {
+		return !cfg.Required
+	}
+	return contains(audience, want)
+}
+
+// ResourceServer is an API that OAuth access tokens can be issued for (RFC 8707)
+type ResourceServer struct {
+	ID uuid.UUID `json:"id"`
+	// Identifier is the resource indicator clients pass and the aud tokens carry
+	Identifier string `json:"identifier"`
+	Name       string `json:"name"`
+	// AllowedScopes bounds the scopes of tokens for this server; empty allows every scope
+	AllowedScopes []string `json:"allowed_scopes"`
+	// ClientID is the OAuth client the server introspects with, if any; introspection then
+	// reports tokens for other audiences as inactive
+	ClientID  *uuid.UUID `json:"client_id,omitempty"`
+	CreatedBy *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// audienceScopes are kept whatever the audience: they concern this server's userinfo and ID tokens
+var audienceScopes = []string{"openid", "profile", "email"}
+
+// validResourceIdentifier applies RFC 8707: an absolute URI with no fragment. Resource servers
+// are network APIs, so a host is required, and queries are refused so identifiers compare as
+// plain strings.
+func validResourceIdentifier(identifier string) bool {
+	if identifier == "" || len(identifier) > 255 {
+		return false
+	}
+	parsed, err := url.Parse(identifier)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return false
+	}
+	return parsed.Fragment == "" && !strings.Contains(identifier, "#") && parsed.RawQuery == "" && !strings.Contains(identifier, "?")
+}
+
+// targetError rejects a token request's resource parameters (invalid_target, RFC 8707)
+type targetError string
+
+func (e targetError) Error() string { return string(e) }
+
+// resolveAudience picks the audience of a new token. Requested resources must lie within the
+// audience the grant is already bound to, if any; without a request the bound audience, then
+// the client's defaults, are used. An empty result is a token without an audience.
+func resolveAudience(requested, bound, defaults []string) ([]string, error) {
+	if len(requested) == 0 {
+		if len(bound) > 0 {
+			return bound, nil
+		}
+		return defaults, nil
+	}
+
+	audience := make([]string, 0, len(requested))
+	for _, resource := range requested {
+		if !validResourceIdentifier(resource) {
+			return nil, targetError("Resource " + resource + " is not an absolute URI")
+		}
+		if len(bound) > 0 && !contains(bound, resource) {
+			return nil, targetError("Resource " + resource + " is outside the original grant")
+		}
+		if !contains(audience, resource) {
+			audience = append(audience, resource)
+		}
+	}
+	return audience, nil
+}
+
+// narrowScopes drops the scopes none of the audience's servers accept
+func narrowScopes(scopes []string, servers []ResourceServer) []string {
+	if len(servers) == 0 {
+		return scopes
+	}
+	allowed := append([]string{}, audienceScopes...)
+	for _, server := range servers {
+		if len(server.AllowedScopes) == 0 {
+			return scopes
+		}
+		allowed = append(allowed, server.AllowedScopes...)
+	}
+	narrowed := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if contains(allowed, scope) {
+			narrowed = append(narrowed, scope)
+		}
+	}
+	return narrowed
+}
+
+// nonNilAudience stores a token without an audience as an empty array; the column is NOT NULL
+func nonNilAudience(audience []string) []string {
+	if audience == nil {
+		return []string{}
+	}
+	return audience
+}
+
+// audienceClaim renders an audience as RFC 7519 does: one value as a string, several as a list
+func audienceClaim(audience []string) interface{} {
+	if len(audience) == 1 {
+		return audience[0]
+	}
+	return audience
+}
+
+// selectAudience resolves a token request's resource parameters against the registry and
+// returns the token's audience and its scopes narrowed to what that audience accepts
+func (as *AuthService) selectAudience(ctx context.Context, clientID uuid.UUID, requested, bound, scopes []string) ([]string, []string, error) {
+	var defaults []string
+	if len(requested) == 0 && len(bound) == 0 {
+		var err error
+		if defaults, err = as.clientDefaultAudiences(ctx, clientID); err != nil {
+			return nil, nil, err
+		}
+	}
+	audience, err := resolveAudience(requested, bound, defaults)
+	if err != nil || len(audience) == 0 {
+		return nil, scopes, err
+	}
+
+	servers, err := as.resourceServersByIdentifier(ctx, audience)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, identifier := range audience {
+		if _, ok := servers[identifier]; !ok {
+			return nil, nil, targetError("Resource " + identifier + " is not registered")
+		}
+	}
+	list := make([]ResourceServer, 0, len(servers))
+	for _, server := range servers {
+		list = append(list, server)
+	}
+	narrowed := narrowScopes(scopes, list)
+	if len(narrowed) == 0 && len(scopes) > 0 {
+		return nil, nil, targetError("None of the granted scopes apply to the requested resources")
+	}
+	return audience, narrowed, nil
+}
+
+// writeAudienceError answers a token request selectAudience refused
+func writeAudienceError(c *gin.Context, err error) {
+	var target targetError
+	if errors.As(err, &target) {
+		c.JSON(http.StatusBadRequest, models.TokenErrorResponse{
+			Error:            "invalid_target",
+			ErrorDescription: string(target),
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, models.TokenErrorResponse{
+		Error:            "server_error",
+		ErrorDescription: "Failed to resolve the token audience",
+	})
+}
+
+// accessTokenAudience returns the audience an OAuth access token was issued for
+func (as *AuthService) accessTokenAudience(ctx context.Context, tokenID uuid.UUID) ([]string, error) {
+	var audience []string
+	err := as.db.QueryRowContext(ctx, `SELECT audience FROM oauth_access_tokens WHERE id = $1`, tokenID).
+		Scan(pq.Array(&audience))
+	return audience, err
+}
+
+// refreshTokenAudience returns the audience a refresh token's grant is bound to
+func (as *AuthService) refreshTokenAudience(ctx context.Context, tokenID uuid.UUID) ([]string, error) {
+	var audience []string
+	err := as.db.QueryRowContext(ctx, `SELECT audience FROM oauth_refresh_tokens WHERE id = $1`, tokenID).
+		Scan(pq.Array(&audience))
+	return audience, err
+}
+
+// clientDefaultAudiences returns the audience a client's tokens get when it names no resource
+func (as *AuthService) clientDefaultAudiences(ctx context.Context, clientID uuid.UUID) ([]string, error) {
+	var audiences []string
+	err := as.db.QueryRowContext(ctx, `SELECT audiences FROM client_default_audiences WHERE client_id = $1`, clientID).
+		Scan(pq.Array(&audiences))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return audiences, err
+}
+
+const resourceServerColumns = `id, identifier, name, allowed_scopes, client_id, created_by, created_at, updated_at`
+
+func scanResourceServer(row interface{ Scan(...interface{}) error }) (ResourceServer, error) {
+	var server ResourceServer
+	err := row.Scan(&server.ID, &server.Identifier, &server.Name, pq.Array(&server.AllowedScopes),
+		&server.ClientID, &server.CreatedBy, &server.CreatedAt, &server.UpdatedAt)
+	return server, err
+}
+
+// resourceServersByIdentifier loads the registered servers among identifiers
+func (as *AuthService) resourceServersByIdentifier(ctx context.Context, identifiers []string) (map[string]ResourceServer, error) {
+	rows, err := as.db.QueryContext(ctx, `SELECT `+resourceServerColumns+`
+		FROM resource_servers WHERE identifier = ANY($1)`, pq.Array(identifiers))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	servers := make(map[string]ResourceServer, len(identifiers))
+	for rows.Next() {
+		server, err := scanResourceServer(rows)
+		if err != nil {
+			return nil, err
+		}
+		servers[server.Identifier] = server
+	}
+	return servers, rows.Err()
+}
+
+// resourceServerForClient returns the server a client introspects for, or nil if it is not one
+func (as *AuthService) resourceServerForClient(ctx context.Context, clientID uuid.UUID) (*ResourceServer, error) {
+	server, err := scanResourceServer(as.db.QueryRowContext(ctx, `SELECT `+resourceServerColumns+`
+		FROM resource_servers WHERE client_id = $1`, clientID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &server, nil
+}
+
+// RequireAudience guards routes served on behalf of one resource server. It accepts OAuth
+// access tokens issued for audience and first-party JWTs whose aud is audience, and sets
+// user_id like JWTAuthMiddleware.
+func (as *AuthService) RequireAudience(audience string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := extractBearerToken(c.GetHeader("Authorization"))
+		if token == "" {
+			setBearerChallenge(c, "", "", "")
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":             "invalid_token",
+				"error_description": "Missing or invalid access token",
+			})
+			c.Abort()
+			return
+		}
+
+		if accessToken, _, err := as.validateAccessTokenScopes(c.Request.Context(), token); err == nil {
+			bound, err := as.accessTokenAudience(c.Request.Context(), accessToken.ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+				c.Abort()
+				return
+			}
+			if !as.audience.allows(bound, audience) {
+				abortWithBearerError(c, http.StatusUnauthorized, bearerErrorInvalidToken, "The access token is not intended for this resource", "")
+				return
+			}
+			if accessToken.UserID != nil {
+				c.Set("user_id", *accessToken.UserID)
+			}
+			c.Set("client_id", accessToken.ClientID)
+			c.Set("token_scopes", accessToken.Scopes)
+			c.Next()
+			return
+		}
+
+		claims, userID, err := as.validateUserJWT(c.Request.Context(), token, audience)
+		if err != nil {
+			abortWithJWTError(c, err)
+			return
+		}
+		c.Set("user_id", userID)
+		c.Set("token_claims", claims)
+		c.Next()
+	}
+}
+
+// Admin API
+
+func (as *AuthService) AdminListResourceServers(c *gin.Context) {
+	rows, err := as.db.QueryContext(c.Request.Context(), `SELECT `+resourceServerColumns+`
+		FROM resource_servers ORDER BY identifier`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list resource servers"})
+		return
+	}
+	defer rows.Close()
+	servers := []ResourceServer{}
+	for rows.Next() {
+		server, err := scanResourceServer(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list resource servers"})
+			return
+		}
+		servers = append(servers, server)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list resource servers"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"resource_servers": servers})
+}
+
+// resourceServerRequest is the body of AdminPutResourceServer
+type resourceServerRequest struct {
+	Identifier    string   `json:"identifier" binding:"required"`
+	Name          string   `json:"name" binding:"required"`
+	AllowedScopes []string `json:"allowed_scopes"`
+	ClientID      *string  `json:"client_id"`
+}
+
+// AdminPutResourceServer registers a resource server, or updates the one with the same identifier
+func (as *AuthService) AdminPutResourceServer(c *gin.Context) {
+	var req resourceServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if !validResourceIdentifier(req.Identifier) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "identifier must be an absolute URI without a query or fragment"})
+		return
+	}
+	if req.Identifier == as.audience.firstParty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "identifier is the first-party audience"})
+		return
+	}
+	for _, scope := range req.AllowedScopes {
+		if _, exists := models.AO3OAuthScopes[scope]; !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid scope: %s", scope)})
+			return
+		}
+	}
+	var clientID *uuid.UUID
+	if req.ClientID != nil && *req.ClientID != "" {
+		client, err := as.getClientByID(c.Request.Context(), *req.ClientID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "client_id is not a registered client"})
+			return
+		}
+		clientID = &client.ID
+	}
+	if req.AllowedScopes == nil {
+		req.AllowedScopes = []string{}
+	}
+
+	adminID, _ := c.Get("user_id")
+	server, err := scanResourceServer(as.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO resource_servers (id, identifier, name, allowed_scopes, client_id, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+		ON CONFLICT (identifier)
+		DO UPDATE SET name = $3, allowed_scopes = $4, client_id = $5, updated_at = NOW()
+		RETURNING `+resourceServerColumns,
+		uuid.New(), req.Identifier, req.Name, pq.Array(req.AllowedScopes), clientID, adminID))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "That client already introspects for another resource server"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save resource server"})
+		return
+	}
+	c.JSON(http.StatusOK, server)
+}
+
+// AdminDeleteResourceServer removes a server from the registry and from every client's
+// defaults. Tokens already issued for it stop refreshing.
+func (as *AuthService) AdminDeleteResourceServer(c *gin.Context) {
+	serverID, err := uuid.Parse(c.Param("server_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resource server ID"})
+		return
+	}
+	serverIdentifier := `(SELECT identifier FROM resource_servers WHERE id = $1)`
+	if as.previewDryRun(c, "delete_resource_server", serverID.String(), nil,
+		dryRunQuery{entity: "resource_servers", action: "delete", id: "identifier", args: []interface{}{serverID},
+			from: "FROM resource_servers WHERE id = $1", missing: "Resource server not found"},
+		dryRunQuery{entity: "client_default_audiences", action: "remove_audience", id: "client_id", args: []interface{}{serverID},
+			from: "FROM client_default_audiences WHERE " + serverIdentifier + " = ANY(audiences)"},
+		dryRunQuery{entity: "refresh_tokens", action: "invalidate", id: "id", args: []interface{}{serverID},
+			from: "FROM oauth_refresh_tokens WHERE is_revoked = false AND expires_at > NOW() AND " + serverIdentifier + " = ANY(audience)"}) {
+		return
+	}
+
+	tx, err := as.db.BeginTx(c.Request.Context(), nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete resource server"})
+		return
+	}
+	defer tx.Rollback()
+	var identifier string
+	err = tx.QueryRowContext(c.Request.Context(), `DELETE FROM resource_servers WHERE id = $1 RETURNING identifier`, serverID).Scan(&identifier)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Resource server not found"})
+		return
+	}
+	if err == nil {
+		_, err = tx.ExecContext(c.Request.Context(), `
+			UPDATE client_default_audiences SET audiences = array_remove(audiences, $1), updated_at = NOW()
+			WHERE $1 = ANY(audiences)`, identifier)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete resource server"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Resource server removed", "identifier": identifier})
+}
+
+func (as *AuthService) AdminGetClientAudiences(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	audiences, err := as.clientDefaultAudiences(c.Request.Context(), client.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load default audiences"})
+		return
+	}
+	if audiences == nil {
+		audiences = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"client_id": client.ID, "audiences": audiences})
+}
+
+func (as *AuthService) AdminPutClientAudiences(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Audiences []string `json:"audiences" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	servers, err := as.resourceServersByIdentifier(c.Request.Context(), req.Audiences)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save default audiences"})
+		return
+	}
+	for _, identifier := range req.Audiences {
+		if _, ok := servers[identifier]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Resource server %s is not registered", identifier)})
+			return
+		}
+	}
+
+	adminID, _ := c.Get("user_id")
+	_, err = as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO client_default_audiences (client_id, audiences, updated_by, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (client_id)
+		DO UPDATE SET audiences = $2, updated_by = $3, updated_at = NOW()`,
+		client.ID, pq.Array(req.Audiences), adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save default audiences"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"client_id": client.ID, "audiences": req.Audiences})
+}
+
+func (as *AuthService) AdminDeleteClientAudiences(c *gin.Context) {
+	client, ok := as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	if _, err := as.db.ExecContext(c.Request.Context(), `DELETE FROM client_default_audiences WHERE client_id = $1`, client.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete default audiences"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Default audiences removed; tokens without a resource parameter carry no audience"})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type ResourceServerTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ResourceServerTestSuite) TestResourceIdentifiers() {
+	for _, identifier := range []string{"https://api.example.com", "https://api.example.com/works"} {
+		suite.True(validResourceIdentifier(identifier), identifier)
+	}
+	for _, identifier := range []string{"", "api.example.com", "/works", "urn:archive:works", "https://api.example.com/#frag", "https://api.example.com/?v=1", "https://"} {
+		suite.False(validResourceIdentifier(identifier), identifier)
+	}
+}
+
+func (suite *ResourceServerTestSuite) TestAudienceResolution() {
+	works, comments := "https://works.example.com", "https://comments.example.com"
+
+	audience, err := resolveAudience(nil, nil, []string{works})
+	suite.Require().NoError(err)
+	suite.Equal([]string{works}, audience)
+
+	audience, err = resolveAudience(nil, []string{comments}, []string{works})
+	suite.Require().NoError(err)
+	suite.Equal([]string{comments}, audience, "a refreshed grant keeps its audience over the client defaults")
+
+	audience, err = resolveAudience([]string{works, works}, []string{works, comments}, nil)
+	suite.Require().NoError(err)
+	suite.Equal([]string{works}, audience)
+
+	_, err = resolveAudience([]string{comments}, []string{works}, nil)
+	suite.ErrorAs(err, new(targetError))
+	_, err = resolveAudience([]string{"not a uri"}, nil, nil)
+	suite.ErrorAs(err, new(targetError))
+
+	audience, err = resolveAudience(nil, nil, nil)
+	suite.Require().NoError(err)
+	suite.Empty(audience)
+}
+
+func (suite *ResourceServerTestSuite) TestScopesAreNarrowedToTheAudience() {
+	scopes := []string{"openid", "read", "write", "comments:write"}
+
+	suite.Equal(scopes, narrowScopes(scopes, nil))
+	suite.Equal([]string{"openid", "read"}, narrowScopes(scopes, []ResourceServer{{AllowedScopes: []string{"read"}}}))
+	suite.Equal([]string{"openid", "read", "comments:write"}, narrowScopes(scopes, []ResourceServer{
+		{AllowedScopes: []string{"read"}},
+		{AllowedScopes: []string{"comments:write"}},
+	}))
+	suite.Equal(scopes, narrowScopes(scopes, []ResourceServer{{AllowedScopes: []string{"read"}}, {}}))
+}
+
+func (suite *ResourceServerTestSuite) TestAudienceMatching() {
+	lenient := AudienceConfig{}
+	strict := AudienceConfig{Required: true}
+
+	suite.True(lenient.allows(nil, "https://works.example.com"))
+	suite.False(strict.allows(nil, "https://works.example.com"))
+	suite.True(strict.allows([]string{"https://works.example.com"}, "https://works.example.com"))
+	suite.False(lenient.allows([]string{"https://comments.example.com"}, "https://works.example.com"))
+
+	suite.Equal("https://works.example.com", audienceClaim([]string{"https://works.example.com"}))
+	suite.Equal([]string{"a", "b"}, audienceClaim([]string{"a", "b"}))
+	suite.Equal(defaultFirstPartyAudience, lenient.firstParty())
+}
+
+func (suite *ResourceServerTestSuite) TestFirstPartyMiddlewareRejectsOtherAudiences() {
+	manager, err := NewJWTManager("test-secret", "test-issuer")
+	suite.Require().NoError(err)
+	as := &AuthService{jwt: manager, audience: AudienceConfig{FirstParty: "archive"}, tokenCache: newTokenCache(time.Minute, 10)}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/me", JWTAuthMiddleware(as), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	status := func(audience string) int {
+		userID := uuid.New()
+		as.tokenCache.putEpoch(userID, 0)
+		token, err := manager.GenerateToken(userID, audience, []string{"user"}, time.Minute)
+		suite.Require().NoError(err)
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	suite.Equal(http.StatusOK, status("archive"))
+	suite.Equal(http.StatusUnauthorized, status("https://works.example.com"))
+}
+
+func (suite *ResourceServerTestSuite) TestConfig() {
+	suite.T().Setenv("JWT_AUDIENCE", "two words")
+	_, err := DefaultAudienceConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("JWT_AUDIENCE", "archive")
+	suite.T().Setenv("REQUIRE_TOKEN_AUDIENCE", "true")
+	config, err := DefaultAudienceConfig()
+	suite.Require().NoError(err)
+	suite.Equal(AudienceConfig{FirstParty: "archive", Required: true}, config)
+}
+
+func TestResourceServers(t *testing.T) {
+	suite.Run(t, new(ResourceServerTestSuite))
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Revocation event types broadcast between replicas
+const (
+	revocationToken   = "token"
+	revocationSession = "session"
+	revocationClient  = "client"
+	revocationUser    = "user"
+	// revocationGrant covers everything one user granted one client
+	revocationGrant = "grant"
+)
+
+const (
+	revocationChannel = "auth:revocations"
+	// revocationLogKey keeps recent events so replicas can catch up after a dropped subscription
+	revocationLogKey       = "auth:revocations:log"
+	revocationLogRetention = time.Hour
+)
+
+// RevocationEvent tells every replica to forget cached validation results
+type RevocationEvent struct {
+	Type     string    `json:"type"`
+	ID       string    `json:"id,omitempty"`
+	UserID   string    `json:"user_id,omitempty"`
+	ClientID string    `json:"client_id,omitempty"`
+	Origin   string    `json:"origin"`
+	At       time.Time `json:"at"`
+	// Epoch is the user's new sign-out epoch, for user revocations that raised it
+	Epoch int64 `json:"epoch,omitempty"`
+}
+
+// instanceID identifies this replica in revocation events
+var instanceID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s", host, uuid.New().String()[:8])
+}()
+
+type cachedAccessToken struct {
+	token models.OAuthAccessToken
+	// scopes is token.Scopes as a bitmap, built once when the token is cached
+	scopes   scopeSet
+	cachedAt time.Time
+}
+
+// tokenCache memoises access token validation for a short TTL.
+// A nil cache is valid and caches nothing.
+type tokenCache struct {
+	mu       sync.RWMutex
+	ttl      time.Duration
+	maxSize  int
+	tokens   map[string]*cachedAccessToken
+	sessions map[string]cachedSession
+	epochs   map[uuid.UUID]cachedEpoch
+}
+
+type cachedSession struct {
+	userID   uuid.UUID
+	cachedAt time.Time
+}
+
+// newTokenCache creates a cache; ttl <= 0 disables caching
+func newTokenCache(ttl time.Duration, maxSize int) *tokenCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &tokenCache{
+		ttl:      ttl,
+		maxSize:  maxSize,
+		tokens:   make(map[string]*cachedAccessToken),
+		sessions: make(map[string]cachedSession),
+		epochs:   make(map[uuid.UUID]cachedEpoch),
+	}
+}
+
+func tokenCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (tc *tokenCache) getToken(token string) *models.OAuthAccessToken {
+	accessToken, _ := tc.getTokenScopes(token)
+	return accessToken
+}
+
+// getTokenScopes returns a cached token together with its scope bitmap
+func (tc *tokenCache) getTokenScopes(token string) (*models.OAuthAccessToken, scopeSet) {
+	if tc == nil {
+		return nil, scopeSet{}
+	}
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	entry := tc.tokens[tokenCacheKey(token)]
+	if entry == nil || time.Since(entry.cachedAt) > tc.ttl || time.Now().After(entry.token.ExpiresAt) {
+		return nil, scopeSet{}
+	}
+	copied := entry.token
+	return &copied, entry.scopes
+}
+
+func (tc *tokenCache) putToken(token string, accessToken *models.OAuthAccessToken) {
+	tc.putTokenScopes(token, accessToken, scopeBits.set(accessToken.Scopes))
+}
+
+func (tc *tokenCache) putTokenScopes(token string, accessToken *models.OAuthAccessToken, scopes scopeSet) {
+	if tc == nil {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if len(tc.tokens) >= tc.maxSize {
+		tc.evictStaleLocked()
+	}
+	if len(tc.tokens) < tc.maxSize {
+		tc.tokens[tokenCacheKey(token)] = &cachedAccessToken{token: *accessToken, scopes: scopes, cachedAt: time.Now()}
+	}
+}
+
+func (tc *tokenCache) getSession(sessionID string) *uuid.UUID {
+	if tc == nil {
+		return nil
+	}
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	entry, ok := tc.sessions[sessionID]
+	if !ok || time.Since(entry.cachedAt) > tc.ttl {
+		return nil
+	}
+	userID := entry.userID
+	return &userID
+}
+
+func (tc *tokenCache) putSession(sessionID string, userID uuid.UUID) {
+	if tc == nil {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if len(tc.sessions) >= tc.maxSize {
+		tc.evictStaleLocked()
+	}
+	if len(tc.sessions) < tc.maxSize {
+		tc.sessions[sessionID] = cachedSession{userID: userID, cachedAt: time.Now()}
+	}
+}
+
+// invalidate drops every cached entry the event applies to
+func (tc *tokenCache) invalidate(event RevocationEvent) int {
+	if tc == nil {
+		return 0
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	removed := 0
+	if event.Type == revocationSession {
+		if _, ok := tc.sessions[event.ID]; ok {
+			delete(tc.sessions, event.ID)
+			removed++
+		}
+		return removed
+	}
+
+	for key, entry := range tc.tokens {
+		if revocationMatches(event, &entry.token) {
+			delete(tc.tokens, key)
+			removed++
+		}
+	}
+	if event.Type == revocationUser {
+		for sessionID, entry := range tc.sessions {
+			if entry.userID.String() == event.UserID {
+				delete(tc.sessions, sessionID)
+				removed++
+			}
+		}
+		removed += tc.invalidateEpochLocked(event)
+	}
+	return removed
+}
+
+func (tc *tokenCache) clear() {
+	if tc == nil {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	tc.tokens = make(map[string]*cachedAccessToken)
+	tc.sessions = make(map[string]cachedSession)
+	tc.epochs = make(map[uuid.UUID]cachedEpoch)
+}
+
+func (tc *tokenCache) evictStaleLocked() {
+	for key, entry := range tc.tokens {
+		if time.Since(entry.cachedAt) > tc.ttl {
+			delete(tc.tokens, key)
+		}
+	}
+	for key, entry := range tc.sessions {
+		if time.Since(entry.cachedAt) > tc.ttl {
+			delete(tc.sessions, key)
+		}
+	}
+	for key, entry := range tc.epochs {
+		if time.Since(entry.cachedAt) > tc.ttl {
+			delete(tc.epochs, key)
+		}
+	}
+}
+
+func revocationMatches(event RevocationEvent, token *models.OAuthAccessToken) bool {
+	userMatches := token.UserID != nil && token.UserID.String() == event.UserID
+	clientMatches := token.ClientID.String() == event.ClientID
+
+	switch event.Type {
+	case revocationToken:
+		return token.ID.String() == event.ID
+	case revocationClient:
+		return clientMatches
+	case revocationUser:
+		return userMatches
+	case revocationGrant:
+		return userMatches && clientMatches
+	}
+	return false
+}
+
+// publishRevocation invalidates local caches and the shared token cache, and tells the other
+// replicas to do the same.
+// Events are also logged so replicas with a dropped subscription can catch up.
+func (as *AuthService) publishRevocation(event RevocationEvent) {
+	event.Origin = instanceID
+	event.At = time.Now()
+	as.tokenCache.invalidate(event)
+
+	// The shared tier is deleted here, once, rather than by every replica that hears the event
+	ctx := context.Background()
+	if err := as.sharedTokens.invalidate(ctx, event); err != nil {
+		log.Printf("Failed to drop %s revocation from the shared token cache: %v", event.Type, err)
+	}
+
+	if as.redis == nil {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	pipe := as.redis.Pipeline()
+	pipe.ZAdd(ctx, revocationLogKey, redis.Z{Score: float64(event.At.UnixNano()), Member: payload})
+	pipe.ZRemRangeByScore(ctx, revocationLogKey, "-inf", fmt.Sprintf("%d", event.At.Add(-revocationLogRetention).UnixNano()))
+	pipe.Publish(ctx, revocationChannel, payload)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to broadcast %s revocation: %v", event.Type, err)
+	}
+}
+
+// runRevocationListener applies revocations from other replicas until ctx is cancelled.
+// Every (re)subscription triggers a reconciliation sweep of the events missed meanwhile.
+func (as *AuthService) runRevocationListener(ctx context.Context) {
+	if as.tokenCache == nil {
+		return
+	}
+
+	pubsub := as.redis.Subscribe(ctx, revocationChannel)
+	defer pubsub.Close()
+
+	lastSeen := time.Now()
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Revocation subscription error: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind == "subscribe" {
+				lastSeen = as.reconcileRevocations(ctx, lastSeen)
+			}
+		case *redis.Message:
+			var event RevocationEvent
+			if err := json.Unmarshal([]byte(m.Payload), &event); err != nil {
+				continue
+			}
+			if event.Origin != instanceID {
+				as.tokenCache.invalidate(event)
+			}
+			if event.At.After(lastSeen) {
+				lastSeen = event.At
+			}
+		}
+	}
+}
+
+// reconcileRevocations replays logged events newer than since. If the gap is older than the
+// log retains, the whole cache is dropped instead. It returns the new high-water mark.
+func (as *AuthService) reconcileRevocations(ctx context.Context, since time.Time) time.Time {
+	now := time.Now()
+	if now.Sub(since) > revocationLogRetention {
+		as.tokenCache.clear()
+		return now
+	}
+
+	entries, err := as.redis.ZRangeByScore(ctx, revocationLogKey, &redis.ZRangeBy{
+		Min: fmt.Sprintf("%d", since.Add(-time.Second).UnixNano()),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		log.Printf("Revocation reconciliation failed, clearing cache: %v", err)
+		as.tokenCache.clear()
+		return now
+	}
+
+	applied := 0
+	for _, entry := range entries {
+		var event RevocationEvent
+		if json.Unmarshal([]byte(entry), &event) == nil {
+			applied += as.tokenCache.invalidate(event)
+		}
+	}
+	if applied > 0 {
+		log.Printf("Revocation reconciliation evicted %d cached entries", applied)
+	}
+	return now
+}
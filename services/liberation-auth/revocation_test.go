@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type RevocationCacheTestSuite struct {
+	suite.Suite
+	cache  *tokenCache
+	userID uuid.UUID
+	client uuid.UUID
+}
+
+func (suite *RevocationCacheTestSuite) SetupTest() {
+	suite.cache = newTokenCache(time.Minute, 100)
+	suite.userID = uuid.New()
+	suite.client = uuid.New()
+}
+
+func (suite *RevocationCacheTestSuite) cacheToken(value string, userID *uuid.UUID, clientID uuid.UUID) *models.OAuthAccessToken {
+	token := &models.OAuthAccessToken{
+		ID:        uuid.New(),
+		Token:     value,
+		UserID:    userID,
+		ClientID:  clientID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	suite.cache.putToken(value, token)
+	return token
+}
+
+func (suite *RevocationCacheTestSuite) TestTokenEventEvictsOnlyThatToken() {
+	first := suite.cacheToken("first", &suite.userID, suite.client)
+	suite.cacheToken("second", &suite.userID, suite.client)
+
+	removed := suite.cache.invalidate(RevocationEvent{Type: revocationToken, ID: first.ID.String()})
+
+	suite.Equal(1, removed)
+	suite.Nil(suite.cache.getToken("first"))
+	suite.NotNil(suite.cache.getToken("second"))
+}
+
+func (suite *RevocationCacheTestSuite) TestGrantEventNeedsUserAndClient() {
+	otherClient := uuid.New()
+	suite.cacheToken("granted", &suite.userID, suite.client)
+	suite.cacheToken("other-client", &suite.userID, otherClient)
+	suite.cacheToken("machine", nil, suite.client)
+
+	suite.cache.invalidate(RevocationEvent{Type: revocationGrant, UserID: suite.userID.String(), ClientID: suite.client.String()})
+
+	suite.Nil(suite.cache.getToken("granted"))
+	suite.NotNil(suite.cache.getToken("other-client"))
+	suite.NotNil(suite.cache.getToken("machine"))
+}
+
+func (suite *RevocationCacheTestSuite) TestClientAndUserEvents() {
+	suite.cacheToken("machine", nil, suite.client)
+	suite.cacheToken("user", &suite.userID, uuid.New())
+	suite.cache.putSession("session-1", suite.userID)
+
+	suite.cache.invalidate(RevocationEvent{Type: revocationClient, ClientID: suite.client.String()})
+	suite.Nil(suite.cache.getToken("machine"))
+	suite.NotNil(suite.cache.getToken("user"))
+
+	suite.cache.invalidate(RevocationEvent{Type: revocationUser, UserID: suite.userID.String()})
+	suite.Nil(suite.cache.getToken("user"))
+	suite.Nil(suite.cache.getSession("session-1"))
+}
+
+func (suite *RevocationCacheTestSuite) TestExpiredTokensAreNotServed() {
+	token := suite.cacheToken("short", &suite.userID, suite.client)
+	token.ExpiresAt = time.Now().Add(-time.Second)
+	suite.cache.putToken("short", token)
+
+	suite.Nil(suite.cache.getToken("short"))
+}
+
+func (suite *RevocationCacheTestSuite) TestDisabledCacheIsSafe() {
+	cache := newTokenCache(0, 100)
+
+	cache.putToken("value", &models.OAuthAccessToken{ExpiresAt: time.Now().Add(time.Hour)})
+	suite.Nil(cache.getToken("value"))
+	suite.Equal(0, cache.invalidate(RevocationEvent{Type: revocationUser}))
+}
+
+func TestRevocationCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(RevocationCacheTestSuite))
+}
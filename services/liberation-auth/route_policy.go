@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeAccess says who a route admits
+type routeAccess string
+
+const (
+	// accessPublic routes admit anyone: discovery, health, sign-in and registration
+	accessPublic routeAccess = "public"
+	// accessCredential routes admit anyone, and the handler checks the credential the request
+	// carries: a client secret, a token, a signed message or a one-time secret
+	accessCredential routeAccess = "credential"
+	// accessUser routes need a first-party access token, checked by JWTAuthMiddleware
+	accessUser routeAccess = "user"
+	// accessAdmin routes need an admin role granting the route's permission, or a trusted service
+	accessAdmin routeAccess = "admin"
+	// accessInternal routes are only served on the admin listener, behind ADMIN_ALLOWED_IPS
+	accessInternal routeAccess = "internal"
+)
+
+// routePolicies says who may call each route, keyed by method and full path; routes
+// registered with Any use the method ANY. Routes are registered through a routeGroup,
+// which adds the middleware each route's access calls for and refuses routes missing
+// here, so a new endpoint cannot be served before someone decides who may call it.
+var routePolicies = map[string]routeAccess{
+	// Health, metrics and signed download URLs
+	"GET /health":         accessPublic,
+	"GET /health/startup": accessPublic,
+	"GET /health/ready":   accessPublic,
+	"GET /health/deep":    accessCredential,
+	"GET /metrics":        accessPublic,
+	"GET /metrics-docs":   accessPublic,
+	"ANY /files/*key":     accessCredential,
+	// The dashboard page holds no data; it reads the admin API with the admin's own token
+	"GET /dashboard/*file": accessPublic,
+
+	// Registration, sign-in and account recovery
+	"POST /api/v1/auth/register":                      accessPublic,
+	"POST /api/v1/auth/register/minimal":              accessPublic,
+	"POST /api/v1/auth/invites/check":                 accessPublic,
+	"POST /api/v1/auth/login":                         accessPublic,
+	"POST /api/v1/auth/refresh":                       accessCredential,
+	"POST /api/v1/auth/reset-password":                accessPublic,
+	"POST /api/v1/auth/reset-password/confirm":        accessCredential,
+	"POST /api/v1/auth/reset-password/sms":            accessPublic,
+	"POST /api/v1/auth/reset-password/sms/confirm":    accessCredential,
+	"POST /api/v1/auth/webhooks/moderation":           accessCredential,
+	"GET /api/v1/auth/branding":                       accessPublic,
+	"GET /api/v1/auth/locales":                        accessPublic,
+	"GET /api/v1/auth/limits":                         accessPublic,
+	"GET /api/v1/auth/branding/:branding_id/logo":     accessPublic,
+	"POST /api/v1/auth/recovery":                      accessPublic,
+	"GET /api/v1/auth/recovery/:request_id":           accessCredential,
+	"POST /api/v1/auth/recovery/:request_id/complete": accessCredential,
+	"POST /api/v1/auth/verify-email":                  accessCredential,
+	"POST /api/v1/auth/resend-verification":           accessPublic,
+	"GET /api/v1/auth/users/:user_id/avatar":          accessPublic,
+
+	// The signed-in user's own account
+	"POST /api/v1/auth/logout":                                   accessUser,
+	"POST /api/v1/auth/logout-all":                               accessUser,
+	"GET /api/v1/auth/me":                                        accessUser,
+	"PUT /api/v1/auth/me":                                        accessUser,
+	"POST /api/v1/auth/change-password":                          accessUser,
+	"GET /api/v1/auth/sessions":                                  accessUser,
+	"DELETE /api/v1/auth/sessions/:session_id":                   accessUser,
+	"GET /api/v1/auth/security-events":                           accessUser,
+	"GET /api/v1/auth/me/registration":                           accessUser,
+	"GET /api/v1/auth/me/locale":                                 accessUser,
+	"PUT /api/v1/auth/me/locale":                                 accessUser,
+	"PUT /api/v1/auth/me/registration":                           accessUser,
+	"POST /api/v1/auth/invites":                                  accessUser,
+	"GET /api/v1/auth/invites":                                   accessUser,
+	"GET /api/v1/auth/invites/:invite_id":                        accessUser,
+	"DELETE /api/v1/auth/invites/:invite_id":                     accessUser,
+	"GET /api/v1/auth/me/phone":                                  accessUser,
+	"PUT /api/v1/auth/me/phone":                                  accessUser,
+	"POST /api/v1/auth/me/phone/verify":                          accessUser,
+	"DELETE /api/v1/auth/me/phone":                               accessUser,
+	"POST /api/v1/auth/step-up/otp":                              accessUser,
+	"POST /api/v1/auth/step-up/verify":                           accessUser,
+	"POST /api/v1/auth/guest/upgrade":                            accessUser,
+	"PUT /api/v1/auth/me/avatar":                                 accessUser,
+	"DELETE /api/v1/auth/me/avatar":                              accessUser,
+	"POST /api/v1/auth/me/export":                                accessUser,
+	"GET /api/v1/auth/me/exports":                                accessUser,
+	"GET /api/v1/auth/me/recovery":                               accessUser,
+	"POST /api/v1/auth/me/recovery/contacts":                     accessUser,
+	"DELETE /api/v1/auth/me/recovery/contacts/:contact_id":       accessUser,
+	"POST /api/v1/auth/me/recovery/designations/:user_id/accept": accessUser,
+	"DELETE /api/v1/auth/me/recovery/designations/:user_id":      accessUser,
+	"POST /api/v1/auth/me/recovery/requests/:request_id/cancel":  accessUser,
+	"POST /api/v1/auth/me/recovery/requests/:request_id/approve": accessUser,
+	"POST /api/v1/auth/me/recovery/requests/:request_id/deny":    accessUser,
+	"GET /api/v1/auth/me/activity":                               accessUser,
+	"PUT /api/v1/auth/me/activity-digest":                        accessUser,
+	"GET /api/v1/auth/me/tenant":                                 accessUser,
+	"GET /api/v1/auth/me/devices":                                accessUser,
+	"DELETE /api/v1/auth/me/devices/:device_id":                  accessUser,
+	"GET /api/v1/auth/developer/clients/:client_id/usage":        accessUser,
+	"POST /api/v1/auth/capabilities":                             accessUser,
+	"GET /api/v1/auth/capabilities":                              accessUser,
+	"GET /api/v1/auth/capabilities/:grant_id":                    accessUser,
+	"DELETE /api/v1/auth/capabilities/:grant_id":                 accessUser,
+
+	// Conformance suite hooks, only registered in conformance mode
+	"POST /conformance/setup": accessPublic,
+	"POST /conformance/reset": accessPublic,
+
+	// SAML IdP; requests and responses carry their own signatures
+	"GET /saml/metadata": accessPublic,
+	"GET /saml/sso":      accessCredential,
+	"POST /saml/sso":     accessCredential,
+	"GET /saml/slo":      accessCredential,
+	"POST /saml/slo":     accessCredential,
+
+	// OAuth2 and OIDC; token endpoints authenticate the client or token they are given
+	"GET /.well-known/openid-configuration":       accessPublic,
+	"GET /.well-known/oauth-authorization-server": accessPublic,
+	"GET /auth/authorize":                         accessPublic,
+	"POST /auth/authorize":                        accessPublic,
+	"GET /auth/authorize/requests/:request_id":    accessPublic,
+	"GET /auth/authorize/resume":                  accessPublic,
+	"POST /auth/token":                            accessCredential,
+	"POST /auth/token/refresh-cookie":             accessCredential,
+	"DELETE /auth/token/refresh-cookie":           accessCredential,
+	"GET /auth/userinfo":                          accessCredential,
+	"POST /auth/userinfo":                         accessCredential,
+	"POST /auth/introspect":                       accessCredential,
+	"POST /auth/introspect/batch":                 accessCredential,
+	"POST /auth/revoke":                           accessCredential,
+	"POST /auth/guest":                            accessPublic,
+	"ANY /auth/forward":                           accessCredential,
+	"POST /auth/capabilities/verify":              accessCredential,
+	"ANY /auth/capabilities/forward":              accessCredential,
+	"POST /auth/register-client":                  accessPublic,
+	"GET /auth/jwks":                              accessPublic,
+	"GET /auth/consent/:consent_id":               accessPublic,
+	"POST /auth/consent/:consent_id":              accessPublic,
+	"GET /auth/csrf":                              accessPublic,
+
+	// Consents and applications the signed-in user granted
+	"GET /auth/consents":                              accessUser,
+	"DELETE /auth/consents/:consent_id":               accessUser,
+	"GET /auth/authorized-applications":               accessUser,
+	"DELETE /auth/authorized-applications/:client_id": accessUser,
+
+	// The admin API, on the public or admin listener; adminRoutePermissions names the
+	// permission each route needs
+	"GET /api/v1/auth/admin/search":                                             accessAdmin,
+	"GET /api/v1/auth/admin/users":                                              accessAdmin,
+	"GET /api/v1/auth/admin/users/:user_id":                                     accessAdmin,
+	"PUT /api/v1/auth/admin/users/:user_id":                                     accessAdmin,
+	"POST /api/v1/auth/admin/users/:user_id/roles":                              accessAdmin,
+	"DELETE /api/v1/auth/admin/users/:user_id/roles/:role":                      accessAdmin,
+	"GET /api/v1/auth/admin/users/:user_id/consent-history":                     accessAdmin,
+	"POST /api/v1/auth/admin/users/:user_id/erase":                              accessAdmin,
+	"GET /api/v1/auth/admin/users/:user_id/deletion":                            accessAdmin,
+	"POST /api/v1/auth/admin/users/:user_id/deletion/retry":                     accessAdmin,
+	"GET /api/v1/auth/admin/user-deletions":                                     accessAdmin,
+	"POST /api/v1/auth/admin/users/:user_id/mfa/reset":                          accessAdmin,
+	"GET /api/v1/auth/admin/security-events":                                    accessAdmin,
+	"GET /api/v1/auth/admin/metrics":                                            accessAdmin,
+	"GET /api/v1/auth/admin/config":                                             accessAdmin,
+	"GET /api/v1/auth/admin/security-events/export":                             accessAdmin,
+	"GET /api/v1/auth/admin/dashboard":                                          accessAdmin,
+	"GET /api/v1/auth/admin/canary":                                             accessAdmin,
+	"GET /api/v1/auth/admin/residency":                                          accessAdmin,
+	"GET /api/v1/auth/admin/users/:user_id/residency":                           accessAdmin,
+	"PUT /api/v1/auth/admin/users/:user_id/residency":                           accessAdmin,
+	"POST /api/v1/auth/admin/compliance/snapshots":                              accessAdmin,
+	"GET /api/v1/auth/admin/compliance/snapshots":                               accessAdmin,
+	"GET /api/v1/auth/admin/compliance/snapshots/:snapshot_id":                  accessAdmin,
+	"GET /api/v1/auth/admin/invites":                                            accessAdmin,
+	"GET /api/v1/auth/admin/usernames/reserved":                                 accessAdmin,
+	"POST /api/v1/auth/admin/usernames/reserved":                                accessAdmin,
+	"DELETE /api/v1/auth/admin/usernames/reserved/:name":                        accessAdmin,
+	"GET /api/v1/auth/admin/usernames/check":                                    accessAdmin,
+	"GET /api/v1/auth/admin/jobs":                                               accessAdmin,
+	"PUT /api/v1/auth/admin/jobs/:name":                                         accessAdmin,
+	"POST /api/v1/auth/admin/jobs/:name/run":                                    accessAdmin,
+	"POST /api/v1/auth/admin/jobs/:name/steal":                                  accessAdmin,
+	"GET /api/v1/auth/admin/saml/service-providers":                             accessAdmin,
+	"PUT /api/v1/auth/admin/saml/service-providers":                             accessAdmin,
+	"DELETE /api/v1/auth/admin/saml/service-providers/:sp_id":                   accessAdmin,
+	"POST /api/v1/auth/admin/users/:user_id/reactivate":                         accessAdmin,
+	"POST /api/v1/auth/admin/lifecycle/run":                                     accessAdmin,
+	"GET /api/v1/auth/admin/lifecycle/events":                                   accessAdmin,
+	"GET /api/v1/auth/admin/lifecycle/exemptions":                               accessAdmin,
+	"PUT /api/v1/auth/admin/lifecycle/exemptions/:user_id":                      accessAdmin,
+	"DELETE /api/v1/auth/admin/lifecycle/exemptions/:user_id":                   accessAdmin,
+	"GET /api/v1/auth/admin/moderation/events":                                  accessAdmin,
+	"GET /api/v1/auth/admin/users/:user_id/moderation":                          accessAdmin,
+	"GET /api/v1/auth/admin/policies":                                           accessAdmin,
+	"PUT /api/v1/auth/admin/policies/:name":                                     accessAdmin,
+	"DELETE /api/v1/auth/admin/policies/:name":                                  accessAdmin,
+	"POST /api/v1/auth/admin/policies/evaluate":                                 accessAdmin,
+	"GET /api/v1/auth/admin/signup-reviews":                                     accessAdmin,
+	"POST /api/v1/auth/admin/signup-reviews/:user_id/approve":                   accessAdmin,
+	"POST /api/v1/auth/admin/signup-reviews/:user_id/reject":                    accessAdmin,
+	"PUT /api/v1/auth/admin/users/:user_id/spam-label":                          accessAdmin,
+	"GET /api/v1/auth/admin/recovery/requests":                                  accessAdmin,
+	"GET /api/v1/auth/admin/recovery/requests/:request_id":                      accessAdmin,
+	"POST /api/v1/auth/admin/recovery/requests/:request_id/cancel":              accessAdmin,
+	"GET /api/v1/auth/admin/recovery/abuse":                                     accessAdmin,
+	"GET /api/v1/auth/admin/tenants":                                            accessAdmin,
+	"POST /api/v1/auth/admin/tenants":                                           accessAdmin,
+	"GET /api/v1/auth/admin/tenants/:tenant_id":                                 accessAdmin,
+	"PUT /api/v1/auth/admin/tenants/:tenant_id":                                 accessAdmin,
+	"DELETE /api/v1/auth/admin/tenants/:tenant_id":                              accessAdmin,
+	"PUT /api/v1/auth/admin/tenants/:tenant_id/members/:user_id":                accessAdmin,
+	"DELETE /api/v1/auth/admin/tenants/:tenant_id/members/:user_id":             accessAdmin,
+	"POST /api/v1/auth/admin/tenants/:tenant_id/usage":                          accessAdmin,
+	"GET /api/v1/auth/admin/tenant-usage":                                       accessAdmin,
+	"GET /api/v1/auth/admin/tenants/:tenant_id/provisioning":                    accessAdmin,
+	"POST /api/v1/auth/admin/tenants/:tenant_id/provisioning/rotate-key":        accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients":                                      accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id":                           accessAdmin,
+	"PUT /api/v1/auth/admin/oauth/clients/:client_id":                           accessAdmin,
+	"DELETE /api/v1/auth/admin/oauth/clients/:client_id":                        accessAdmin,
+	"POST /api/v1/auth/admin/oauth/clients/:client_id/reset-secret":             accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/history":                   accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/history/:version":          accessAdmin,
+	"POST /api/v1/auth/admin/oauth/clients/:client_id/history/:version/restore": accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/as-of":                     accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/diff":                      accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/claims-policy":             accessAdmin,
+	"PUT /api/v1/auth/admin/oauth/clients/:client_id/claims-policy":             accessAdmin,
+	"DELETE /api/v1/auth/admin/oauth/clients/:client_id/claims-policy":          accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/pkce-policy":               accessAdmin,
+	"GET /api/v1/auth/admin/oauth/compliance":                                   accessAdmin,
+	"PUT /api/v1/auth/admin/oauth/clients/:client_id/pkce-policy":               accessAdmin,
+	"DELETE /api/v1/auth/admin/oauth/clients/:client_id/pkce-policy":            accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/refresh-cookie":            accessAdmin,
+	"PUT /api/v1/auth/admin/oauth/clients/:client_id/refresh-cookie":            accessAdmin,
+	"DELETE /api/v1/auth/admin/oauth/clients/:client_id/refresh-cookie":         accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/audiences":                 accessAdmin,
+	"PUT /api/v1/auth/admin/oauth/clients/:client_id/audiences":                 accessAdmin,
+	"DELETE /api/v1/auth/admin/oauth/clients/:client_id/audiences":              accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/branding":                  accessAdmin,
+	"PUT /api/v1/auth/admin/oauth/clients/:client_id/branding":                  accessAdmin,
+	"DELETE /api/v1/auth/admin/oauth/clients/:client_id/branding":               accessAdmin,
+	"GET /api/v1/auth/admin/branding":                                           accessAdmin,
+	"PUT /api/v1/auth/admin/branding/:branding_id":                              accessAdmin,
+	"DELETE /api/v1/auth/admin/branding/:branding_id":                           accessAdmin,
+	"PUT /api/v1/auth/admin/branding/:branding_id/logo":                         accessAdmin,
+	"DELETE /api/v1/auth/admin/branding/:branding_id/logo":                      accessAdmin,
+	"GET /api/v1/auth/admin/oauth/clients/:client_id/session-limit":             accessAdmin,
+	"PUT /api/v1/auth/admin/oauth/clients/:client_id/session-limit":             accessAdmin,
+	"DELETE /api/v1/auth/admin/oauth/clients/:client_id/session-limit":          accessAdmin,
+	"GET /api/v1/auth/admin/oauth/resource-servers":                             accessAdmin,
+	"PUT /api/v1/auth/admin/oauth/resource-servers":                             accessAdmin,
+	"DELETE /api/v1/auth/admin/oauth/resource-servers/:server_id":               accessAdmin,
+	"GET /api/v1/auth/admin/oauth/tokens":                                       accessAdmin,
+	"DELETE /api/v1/auth/admin/oauth/tokens/:token_id":                          accessAdmin,
+	"POST /api/v1/auth/admin/oauth/revocations":                                 accessAdmin,
+	"GET /api/v1/auth/admin/oauth/revocations/:job_id":                          accessAdmin,
+	"GET /api/v1/auth/admin/oauth/revocations/:job_id/report":                   accessAdmin,
+
+	// Profiles, served only on the admin listener
+	"ANY /debug/pprof/*profile": accessInternal,
+}
+
+// routeGroup registers routes on a gin group behind the guards their policies call for
+type routeGroup struct {
+	group       *gin.RouterGroup
+	authService *AuthService
+}
+
+func newRouteGroup(group *gin.RouterGroup, authService *AuthService) routeGroup {
+	return routeGroup{group: group, authService: authService}
+}
+
+// Group creates a subgroup; handlers run for every route in it, before the route's guards
+func (g routeGroup) Group(relativePath string, handlers ...gin.HandlerFunc) routeGroup {
+	return newRouteGroup(g.group.Group(relativePath, handlers...), g.authService)
+}
+
+// handle registers a route behind its guards. A route without a policy is a programming
+// error, reported the way gin reports conflicting routes.
+func (g routeGroup) handle(method, relativePath string, handlers ...gin.HandlerFunc) {
+	key := method + " " + strings.TrimSuffix(g.group.BasePath(), "/") + relativePath
+	access, ok := routePolicies[key]
+	if !ok {
+		panic(fmt.Sprintf("route %s has no entry in routePolicies", key))
+	}
+	handlers = append(g.guards(access), handlers...)
+	if method == "ANY" {
+		g.group.Any(relativePath, handlers...)
+		return
+	}
+	g.group.Handle(method, relativePath, handlers...)
+}
+
+// guards are the middleware a route with the given access runs before its handlers
+func (g routeGroup) guards(access routeAccess) []gin.HandlerFunc {
+	as := g.authService
+	var guards []gin.HandlerFunc
+	switch access {
+	case accessUser:
+		guards = append(guards, JWTAuthMiddleware(as))
+	case accessAdmin:
+		if len(as.adminListener.AllowedNetworks) > 0 {
+			guards = append(guards, AdminAllowlistMiddleware(as.adminListener.AllowedNetworks))
+		}
+		guards = append(guards,
+			ServiceIdentityMiddleware(as),
+			JWTAuthMiddleware(as),
+			AdminPermissionMiddleware(as, g.group.BasePath()),
+			PolicyAdminMiddleware(as),
+		)
+	case accessInternal:
+		if len(as.adminListener.AllowedNetworks) > 0 {
+			guards = append(guards, AdminAllowlistMiddleware(as.adminListener.AllowedNetworks))
+		}
+	}
+	return guards
+}
+
+func (g routeGroup) GET(relativePath string, handlers ...gin.HandlerFunc) {
+	g.handle(http.MethodGet, relativePath, handlers...)
+}
+
+func (g routeGroup) POST(relativePath string, handlers ...gin.HandlerFunc) {
+	g.handle(http.MethodPost, relativePath, handlers...)
+}
+
+func (g routeGroup) PUT(relativePath string, handlers ...gin.HandlerFunc) {
+	g.handle(http.MethodPut, relativePath, handlers...)
+}
+
+func (g routeGroup) DELETE(relativePath string, handlers ...gin.HandlerFunc) {
+	g.handle(http.MethodDelete, relativePath, handlers...)
+}
+
+func (g routeGroup) Any(relativePath string, handlers ...gin.HandlerFunc) {
+	g.handle("ANY", relativePath, handlers...)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"liberation-storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type RoutePolicyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RoutePolicyTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.T().Setenv("GUEST_TOKENS_ENABLED", "true")
+	suite.T().Setenv("FORWARD_AUTH_ENABLED", "true")
+}
+
+// fullService enables every optional subsystem, so the routers register every route
+func (suite *RoutePolicyTestSuite) fullService() *AuthService {
+	jwtManager, err := NewJWTManager("test-secret", "test-issuer")
+	suite.Require().NoError(err)
+	return &AuthService{
+		jwt:          jwtManager,
+		otp:          &OTPService{},
+		files:        &FileService{store: &storage.LocalStore{}, config: FileConfig{MountPath: "/files"}},
+		conformance:  &ConformanceService{},
+		probe:        &HealthProbe{},
+		canary:       &CanaryService{},
+		lifecycle:    &LifecycleService{},
+		jobs:         &JobRunner{},
+		saml:         &SAMLService{},
+		capabilities: &CapabilityService{},
+		recovery:     &RecoveryService{},
+		moderation:   &ModerationService{},
+		spam:         &SpamService{},
+		policies:     &PolicyEngine{},
+		devices:      &DeviceService{},
+		tenants:      &TenantService{},
+		provisioning: &ProvisioningService{},
+		residency:    &ResidencyService{},
+		dashboard:    &DashboardService{},
+	}
+}
+
+// routers are the public router and, with the admin API moved off it, the admin router
+func (suite *RoutePolicyTestSuite) routers() []*gin.Engine {
+	public := suite.fullService()
+	admin := suite.fullService()
+	admin.adminListener = AdminListenerConfig{Addr: "127.0.0.1:9443"}
+	admin.profiling.Pprof = true
+	return []*gin.Engine{setupRouter(public), setupAdminRouter(admin)}
+}
+
+// policyFor finds the routePolicies entry behind a route gin reports
+func policyFor(route gin.RouteInfo) (string, routeAccess, bool) {
+	for _, key := range []string{route.Method + " " + route.Path, "ANY " + route.Path} {
+		if access, ok := routePolicies[key]; ok {
+			return key, access, true
+		}
+	}
+	return "", "", false
+}
+
+func (suite *RoutePolicyTestSuite) TestEveryRouteHasAPolicy() {
+	used := make(map[string]bool)
+	for _, router := range suite.routers() {
+		for _, route := range router.Routes() {
+			key, _, ok := policyFor(route)
+			if suite.True(ok, "%s %s has no entry in routePolicies", route.Method, route.Path) {
+				used[key] = true
+			}
+		}
+	}
+	for key := range routePolicies {
+		suite.True(used[key], "routePolicies lists %s, which no router registers", key)
+	}
+}
+
+func (suite *RoutePolicyTestSuite) TestProtectedRoutesRefuseAnonymousCallers() {
+	checked := 0
+	for _, router := range suite.routers() {
+		for _, route := range router.Routes() {
+			_, access, _ := policyFor(route)
+			if access != accessUser && access != accessAdmin {
+				continue
+			}
+			path := strings.NewReplacer(":", "x", "*", "x").Replace(route.Path)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(route.Method, path, nil))
+			suite.Equal(http.StatusUnauthorized, w.Code, "%s %s is %s but answered an anonymous caller", route.Method, route.Path, access)
+			checked++
+		}
+	}
+	suite.Greater(checked, 150)
+}
+
+func (suite *RoutePolicyTestSuite) TestAdminAllowlistGuardsInternalRoutes() {
+	networks, err := parseNetworks("10.0.0.0/8")
+	suite.Require().NoError(err)
+	service := suite.fullService()
+	service.adminListener = AdminListenerConfig{Addr: "127.0.0.1:9443", AllowedNetworks: networks}
+	service.profiling.Pprof = true
+	router := setupAdminRouter(service)
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/heap", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *RoutePolicyTestSuite) TestRoutesWithoutPolicyAreRefused() {
+	routes := newRouteGroup(&gin.New().RouterGroup, &AuthService{})
+	suite.PanicsWithValue("route GET /api/v1/auth/unlisted has no entry in routePolicies", func() {
+		routes.Group("/api/v1/auth").GET("/unlisted", func(c *gin.Context) {})
+	})
+}
+
+func TestRoutePolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(RoutePolicyTestSuite))
+}
@@ -114,6 +114,24 @@ run_performance_tests() {
     fi
 }
 
+# Run the OpenID Foundation conformance profiles against a local instance
+run_conformance_tests() {
+    if [ -z "$CONFORMANCE_SUITE_URL" ]; then
+        print_error "CONFORMANCE_SUITE_URL is not set; start the conformance suite first (see OAUTH_TESTING.md)"
+        return 1
+    fi
+    
+    print_status "Running OIDC conformance profiles against $CONFORMANCE_SUITE_URL..."
+    
+    if go test -v -tags conformance -timeout=60m -run="TestConformance" . 2>&1 | tee "test_results_conformance.log"; then
+        print_success "Conformance profiles passed"
+        return 0
+    else
+        print_error "Conformance profiles failed"
+        return 1
+    fi
+}
+
 # Generate test coverage report
 generate_coverage() {
     print_status "Generating test coverage report..."
@@ -163,6 +181,7 @@ main() {
     SKIP_PERFORMANCE=false
     COVERAGE_ONLY=false
     CLEAN_ONLY=false
+    CONFORMANCE=false
     
     while [[ $# -gt 0 ]]; do
         case $1 in
@@ -178,12 +197,17 @@ main() {
                 CLEAN_ONLY=true
                 shift
                 ;;
+            --conformance)
+                CONFORMANCE=true
+                shift
+                ;;
             --help)
                 echo "Usage: $0 [OPTIONS]"
                 echo "Options:"
                 echo "  --skip-performance  Skip performance tests"
                 echo "  --coverage-only     Only generate coverage report"
                 echo "  --clean-only        Only cleanup test data"
+                echo "  --conformance       Run the OpenID Foundation conformance profiles (needs CONFORMANCE_SUITE_URL)"
                 echo "  --help              Show this help message"
                 exit 0
                 ;;
@@ -200,6 +224,11 @@ main() {
         exit 0
     fi
     
+    if [ "$CONFORMANCE" = true ]; then
+        run_conformance_tests
+        exit $?
+    fi
+    
     if [ "$COVERAGE_ONLY" = true ]; then
         setup_test_env
         generate_coverage
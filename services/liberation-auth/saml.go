@@ -0,0 +1,732 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"nuclear-ao3/shared/models"
+)
+
+// samlRequestTTL bounds how long an AuthnRequest stays parked while the user logs in
+const samlRequestTTL = 10 * time.Minute
+
+// samlAttributeSources are the user fields an attribute mapping may draw on
+var samlAttributeSources = []string{"id", "username", "email", "display_name", "roles"}
+
+// defaultSAMLAttributeMapping is used for service providers registered without a mapping
+var defaultSAMLAttributeMapping = map[string]string{
+	"uid":         "username",
+	"email":       "email",
+	"displayName": "display_name",
+	"roles":       "roles",
+}
+
+// SAMLConfig controls the SAML 2.0 identity provider bridge for partners without OIDC support
+type SAMLConfig struct {
+	Enabled  bool
+	EntityID string
+	// BaseURL is where the SSO, SLO and metadata endpoints are served
+	BaseURL string
+	// KeyFile and CertFile hold the signing key and its self-signed certificate; both are
+	// created on first start so the certificate SPs pin stays the same across restarts
+	KeyFile  string
+	CertFile string
+	// AssertionTTL is how long an assertion may be presented to the SP
+	AssertionTTL time.Duration
+	// LoginURL is where users without a session are sent; return_to brings them back
+	LoginURL string
+}
+
+// DefaultSAMLConfig reads SAML IdP settings from the environment
+func DefaultSAMLConfig() (SAMLConfig, error) {
+	baseURL := getEnv("BASE_URL", "https://ao3.example.com")
+	config := SAMLConfig{
+		Enabled:  getEnv("SAML_IDP_ENABLED", "false") == "true",
+		EntityID: getEnv("SAML_ENTITY_ID", baseURL+"/saml/metadata"),
+		BaseURL:  baseURL,
+		KeyFile:  getEnv("SAML_KEY_FILE", "saml-signing-key.pem"),
+		CertFile: getEnv("SAML_CERT_FILE", "saml-signing-cert.pem"),
+		LoginURL: getEnv("SAML_LOGIN_URL", baseURL+"/login"),
+	}
+
+	var err error
+	if config.AssertionTTL, err = time.ParseDuration(getEnv("SAML_ASSERTION_TTL", "5m")); err != nil || config.AssertionTTL <= 0 {
+		return config, fmt.Errorf("SAML_ASSERTION_TTL must be a positive duration")
+	}
+	return config, nil
+}
+
+// SAMLServiceProvider is a registered SAML relying party
+type SAMLServiceProvider struct {
+	ID       uuid.UUID `json:"id"`
+	EntityID string    `json:"entity_id"`
+	Name     string    `json:"name"`
+	// ACSURL receives assertions over the HTTP-POST binding
+	ACSURL     string `json:"acs_url"`
+	SLOURL     string `json:"slo_url,omitempty"`
+	SLOBinding string `json:"slo_binding,omitempty"`
+	// Certificate verifies signed requests; when set, Redirect binding requests must be signed
+	Certificate  string `json:"certificate,omitempty"`
+	NameIDFormat string `json:"name_id_format"`
+	// AttributeMapping maps SAML attribute names to user fields (id, username, email, display_name, roles)
+	AttributeMapping map[string]string `json:"attribute_mapping"`
+	CreatedBy        *uuid.UUID        `json:"created_by,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+}
+
+// validate checks a registration and fills in defaults
+func (sp *SAMLServiceProvider) validate() error {
+	if sp.EntityID == "" {
+		return fmt.Errorf("entity_id is required")
+	}
+	acs, err := url.Parse(sp.ACSURL)
+	if err != nil || acs.Scheme != "https" && !isLoopbackHost(acs.Hostname()) {
+		return fmt.Errorf("acs_url must be an https URL")
+	}
+	if sp.SLOURL != "" {
+		if slo, err := url.Parse(sp.SLOURL); err != nil || slo.Scheme != "https" && !isLoopbackHost(slo.Hostname()) {
+			return fmt.Errorf("slo_url must be an https URL")
+		}
+		if sp.SLOBinding == "" {
+			sp.SLOBinding = samlBindingRedirect
+		}
+		if sp.SLOBinding != samlBindingRedirect && sp.SLOBinding != samlBindingPOST {
+			return fmt.Errorf("slo_binding must be the HTTP-Redirect or HTTP-POST binding")
+		}
+	}
+	if sp.Certificate != "" {
+		if _, err := parseSAMLCertificate(sp.Certificate); err != nil {
+			return fmt.Errorf("certificate: %w", err)
+		}
+	}
+	switch sp.NameIDFormat {
+	case "":
+		sp.NameIDFormat = samlNameIDPersistent
+	case samlNameIDPersistent, samlNameIDEmail:
+	default:
+		return fmt.Errorf("name_id_format must be persistent or emailAddress")
+	}
+	if len(sp.AttributeMapping) == 0 {
+		sp.AttributeMapping = defaultSAMLAttributeMapping
+	}
+	for name, source := range sp.AttributeMapping {
+		if !contains(samlAttributeSources, source) {
+			return fmt.Errorf("attribute %q: unknown source %q (use %s)", name, source, strings.Join(samlAttributeSources, ", "))
+		}
+	}
+	return nil
+}
+
+// SAMLService issues signed SAML assertions for users with a liberation-auth session
+type SAMLService struct {
+	as     *AuthService
+	config SAMLConfig
+	key    *rsa.PrivateKey
+	cert   *x509.Certificate
+}
+
+// NewSAMLService returns nil when the SAML bridge is disabled
+func NewSAMLService(as *AuthService, config SAMLConfig) (*SAMLService, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	key, err := loadOrCreateSigningKey(config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+	cert, err := loadOrCreateSAMLCertificate(config.CertFile, key, config.EntityID)
+	if err != nil {
+		return nil, fmt.Errorf("signing certificate: %w", err)
+	}
+	return &SAMLService{as: as, config: config, key: key, cert: cert}, nil
+}
+
+// loadOrCreateSAMLCertificate reads the IdP certificate, creating a ten-year self-signed one
+// for the key if the file does not exist. SPs pin the certificate, not a CA.
+func loadOrCreateSAMLCertificate(path string, key *rsa.PrivateKey, entityID string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s does not contain a PEM block", path)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if public, ok := cert.PublicKey.(*rsa.PublicKey); !ok || !public.Equal(&key.PublicKey) {
+			return nil, fmt.Errorf("%s does not match the signing key", path)
+		}
+		return cert, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	commonName := entityID
+	if parsed, err := url.Parse(entityID); err == nil && parsed.Hostname() != "" {
+		commonName = parsed.Hostname()
+	}
+	certTemplate := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"liberation-auth SAML IdP"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, certTemplate, certTemplate, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// Service provider registry
+
+func scanSAMLServiceProvider(row interface{ Scan(...interface{}) error }) (*SAMLServiceProvider, error) {
+	sp := &SAMLServiceProvider{}
+	var mapping []byte
+	if err := row.Scan(&sp.ID, &sp.EntityID, &sp.Name, &sp.ACSURL, &sp.SLOURL, &sp.SLOBinding, &sp.Certificate,
+		&sp.NameIDFormat, &mapping, &sp.CreatedBy, &sp.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mapping, &sp.AttributeMapping); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+const samlServiceProviderColumns = `id, entity_id, name, acs_url, slo_url, slo_binding, certificate,
+	name_id_format, attribute_mapping, created_by, created_at`
+
+func (s *SAMLService) getServiceProvider(ctx context.Context, entityID string) (*SAMLServiceProvider, error) {
+	return scanSAMLServiceProvider(s.as.db.QueryRowContext(ctx,
+		`SELECT `+samlServiceProviderColumns+` FROM saml_service_providers WHERE entity_id = $1`, entityID))
+}
+
+// SSO and SLO endpoints
+
+// samlParkedRequest is an AuthnRequest waiting for the user to log in
+type samlParkedRequest struct {
+	Request    string    `json:"request"`
+	RelayState string    `json:"relay_state"`
+	ParkedAt   time.Time `json:"parked_at"`
+}
+
+// samlError answers a SAML request that cannot be trusted enough to reply to the SP
+func samlError(c *gin.Context, status int, description string) {
+	c.JSON(status, gin.H{"error": "invalid_saml_request", "error_description": description})
+}
+
+// readSAMLMessage extracts a SAMLRequest from either binding. When the SP registered a
+// certificate the request must be signed: over the query string for the Redirect binding,
+// and with an enveloped signature for the POST binding.
+func readSAMLMessage(c *gin.Context, sp func(ctx context.Context, issuer string) (*SAMLServiceProvider, error), into interface{ issuer() string }) ([]byte, string, *SAMLServiceProvider, error) {
+	deflated := c.Request.Method == http.MethodGet
+	value, relayState := c.Query("SAMLRequest"), c.Query("RelayState")
+	if !deflated {
+		value, relayState = c.PostForm("SAMLRequest"), c.PostForm("RelayState")
+	}
+	if value == "" {
+		return nil, "", nil, fmt.Errorf("SAMLRequest is required")
+	}
+	message, err := decodeSAMLMessage(value, deflated)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err := xml.Unmarshal(message, into); err != nil {
+		return nil, "", nil, fmt.Errorf("malformed SAML request: %w", err)
+	}
+	issuer := into.issuer()
+	provider, err := sp(c.Request.Context(), issuer)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("unknown service provider %q", issuer)
+	}
+	if provider.Certificate == "" {
+		return message, relayState, provider, nil
+	}
+
+	cert, err := parseSAMLCertificate(provider.Certificate)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if deflated {
+		if err := verifyRedirectSignature(c.Request.URL.RawQuery, "SAMLRequest", cert); err != nil {
+			return nil, "", nil, fmt.Errorf("request signature: %w", err)
+		}
+		return message, relayState, provider, nil
+	}
+	if err := verifyEnvelopedSignature(message, cert); err != nil {
+		return nil, "", nil, fmt.Errorf("request signature: %w", err)
+	}
+	return message, relayState, provider, nil
+}
+
+func (r *samlAuthnRequest) issuer() string  { return strings.TrimSpace(r.Issuer) }
+func (r *samlLogoutRequest) issuer() string { return strings.TrimSpace(r.Issuer) }
+
+// SSO receives AuthnRequests over the HTTP-Redirect and HTTP-POST bindings and answers
+// with a signed assertion over HTTP-POST. Users without a session log in first.
+func (s *SAMLService) SSO(c *gin.Context) {
+	ctx := c.Request.Context()
+	var request samlAuthnRequest
+	var message []byte
+	var relayState string
+	var parkedAt time.Time
+	var sp *SAMLServiceProvider
+
+	if parkedID := c.Query("saml_request"); parkedID != "" {
+		stored, err := s.as.redis.Get(ctx, "saml_req:"+parkedID).Result()
+		var parked samlParkedRequest
+		if err != nil || json.Unmarshal([]byte(stored), &parked) != nil {
+			samlError(c, http.StatusGone, "The sign-in request expired; return to the application and start again")
+			return
+		}
+		if err := xml.Unmarshal([]byte(parked.Request), &request); err != nil {
+			samlError(c, http.StatusBadRequest, "Malformed SAML request")
+			return
+		}
+		if sp, err = s.getServiceProvider(c.Request.Context(), request.issuer()); err != nil {
+			samlError(c, http.StatusBadRequest, "Unknown service provider")
+			return
+		}
+		relayState, parkedAt = parked.RelayState, parked.ParkedAt
+	} else {
+		var err error
+		if message, relayState, sp, err = readSAMLMessage(c, s.getServiceProvider, &request); err != nil {
+			samlError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if request.AssertionConsumerServiceURL != "" && request.AssertionConsumerServiceURL != sp.ACSURL {
+		samlError(c, http.StatusBadRequest, "AssertionConsumerServiceURL does not match the registered one")
+		return
+	}
+	if request.ProtocolBinding != "" && request.ProtocolBinding != samlBindingPOST {
+		samlError(c, http.StatusBadRequest, "Only the HTTP-POST binding is supported for responses")
+		return
+	}
+
+	// Park new requests so they survive the trip through the login page
+	if message != nil && (request.ForceAuthn || s.as.getAuthenticatedUser(c) == nil) {
+		s.parkForLogin(c, message, relayState)
+		return
+	}
+
+	userID := s.as.getAuthenticatedUser(c)
+	if userID == nil {
+		samlError(c, http.StatusUnauthorized, "Log in before resuming the sign-in request")
+		return
+	}
+	user, err := s.as.getUserByID(c.Request.Context(), *userID)
+	if err != nil || !user.IsActive {
+		samlError(c, http.StatusForbidden, "This account cannot sign in")
+		return
+	}
+	authnInstant := time.Now()
+	if user.LastLoginAt != nil {
+		authnInstant = *user.LastLoginAt
+	}
+	if request.ForceAuthn && authnInstant.Before(parkedAt) {
+		samlError(c, http.StatusUnauthorized, "The service provider requires a fresh login")
+		return
+	}
+	roles, err := s.as.getUserRoles(c.Request.Context(), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	response, err := s.buildResponse(sp, request.ID, user, roles, authnInstant, s.sessionIndex(c))
+	if err != nil {
+		log.Printf("Failed to sign SAML response for %s: %v", sp.EntityID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	renderSAMLPost(c, sp.ACSURL, "SAMLResponse", base64.StdEncoding.EncodeToString(response), relayState)
+}
+
+// parkForLogin stores an AuthnRequest and sends the user to log in, returning to SSO afterwards
+func (s *SAMLService) parkForLogin(c *gin.Context, message []byte, relayState string) {
+	parkedID := uuid.New().String()
+	parked, _ := json.Marshal(samlParkedRequest{Request: string(message), RelayState: relayState, ParkedAt: time.Now()})
+	if err := s.as.redis.Set(c.Request.Context(), "saml_req:"+parkedID, parked, samlRequestTTL).Err(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "temporarily_unavailable"})
+		return
+	}
+	returnTo := s.config.BaseURL + "/saml/sso?saml_request=" + url.QueryEscape(parkedID)
+	separator := "?"
+	if strings.Contains(s.config.LoginURL, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, s.config.LoginURL+separator+"return_to="+url.QueryEscape(returnTo))
+}
+
+// sessionIndex ties assertions to the browser session, without revealing the session ID
+func (s *SAMLService) sessionIndex(c *gin.Context) string {
+	sessionID, err := c.Cookie("session_id")
+	if err != nil || sessionID == "" {
+		return samlID()
+	}
+	sum := sha256.Sum256([]byte("saml-session:" + sessionID))
+	return "_" + hex.EncodeToString(sum[:16])
+}
+
+// samlAttributeValues resolves a mapped source to attribute values
+func samlAttributeValues(source string, user *models.User, roles []string) []string {
+	var value string
+	switch source {
+	case "id":
+		value = user.ID.String()
+	case "username":
+		value = user.Username
+	case "email":
+		value = user.Email
+	case "display_name":
+		value = user.DisplayName
+	case "roles":
+		return roles
+	}
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// buildResponse creates a Response whose assertion is signed with the IdP key
+func (s *SAMLService) buildResponse(sp *SAMLServiceProvider, inResponseTo string, user *models.User, roles []string, authnInstant time.Time, sessionIndex string) ([]byte, error) {
+	now := time.Now().UTC()
+	instant := now.Format(time.RFC3339)
+	notOnOrAfter := now.Add(s.config.AssertionTTL).Format(time.RFC3339)
+
+	nameID := user.ID.String()
+	if sp.NameIDFormat == samlNameIDEmail {
+		nameID = user.Email
+	}
+
+	confirmationData := map[string]string{"NotOnOrAfter": notOnOrAfter, "Recipient": sp.ACSURL}
+	responseAttrs := map[string]string{"ID": samlID(), "Version": "2.0", "IssueInstant": instant, "Destination": sp.ACSURL}
+	if inResponseTo != "" {
+		confirmationData["InResponseTo"] = inResponseTo
+		responseAttrs["InResponseTo"] = inResponseTo
+	}
+
+	names := make([]string, 0, len(sp.AttributeMapping))
+	for name := range sp.AttributeMapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var attributes []*xmlNode
+	for _, name := range names {
+		values := samlAttributeValues(sp.AttributeMapping[name], user, roles)
+		if len(values) == 0 {
+			continue
+		}
+		attribute := newXMLNode("saml:Attribute", map[string]string{"Name": name, "NameFormat": samlAttrNameBasic})
+		for _, value := range values {
+			attribute.children = append(attribute.children, xmlText("saml:AttributeValue", value))
+		}
+		attributes = append(attributes, attribute)
+	}
+
+	assertion := newXMLNode("saml:Assertion", map[string]string{"ID": samlID(), "Version": "2.0", "IssueInstant": instant},
+		xmlText("saml:Issuer", s.config.EntityID),
+		newXMLNode("saml:Subject", nil,
+			&xmlNode{name: "saml:NameID", attrs: map[string]string{"Format": sp.NameIDFormat}, text: nameID},
+			newXMLNode("saml:SubjectConfirmation", map[string]string{"Method": samlBearer},
+				newXMLNode("saml:SubjectConfirmationData", confirmationData),
+			),
+		),
+		newXMLNode("saml:Conditions", map[string]string{"NotBefore": now.Add(-time.Minute).Format(time.RFC3339), "NotOnOrAfter": notOnOrAfter},
+			newXMLNode("saml:AudienceRestriction", nil, xmlText("saml:Audience", sp.EntityID)),
+		),
+		newXMLNode("saml:AuthnStatement", map[string]string{"AuthnInstant": authnInstant.UTC().Format(time.RFC3339), "SessionIndex": sessionIndex},
+			newXMLNode("saml:AuthnContext", nil, xmlText("saml:AuthnContextClassRef", samlAuthnPassword)),
+		),
+	).declare("saml", samlAssertionNS)
+	if len(attributes) > 0 {
+		assertion.children = append(assertion.children, newXMLNode("saml:AttributeStatement", nil, attributes...))
+	}
+	if err := signXMLNode(assertion, s.key, s.cert); err != nil {
+		return nil, err
+	}
+
+	response := newXMLNode("samlp:Response", responseAttrs,
+		xmlText("saml:Issuer", s.config.EntityID),
+		newXMLNode("samlp:Status", nil, newXMLNode("samlp:StatusCode", map[string]string{"Value": samlStatusSuccess})),
+		assertion,
+	).declare("samlp", samlProtocolNS).declare("saml", samlAssertionNS)
+	return response.canonical(), nil
+}
+
+var samlPostTemplate = template.Must(template.New("saml-post").Parse(`<!DOCTYPE html>
+<html><head><title>Signing in…</title></head>
+<body>
+<form method="post" action="{{.URL}}">
+<input type="hidden" name="{{.Param}}" value="{{.Value}}">
+{{if .RelayState}}<input type="hidden" name="RelayState" value="{{.RelayState}}">{{end}}
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+<script nonce="{{.Nonce}}">document.forms[0].submit()</script>
+</body></html>`))
+
+// renderSAMLPost sends a message to an SP over the HTTP-POST binding: a self-submitting form
+func renderSAMLPost(c *gin.Context, target, param, value, relayState string) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	encodedNonce := base64.StdEncoding.EncodeToString(nonce)
+
+	var page bytes.Buffer
+	samlPostTemplate.Execute(&page, map[string]string{
+		"URL": target, "Param": param, "Value": value, "RelayState": relayState, "Nonce": encodedNonce,
+	})
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Security-Policy", fmt.Sprintf("default-src 'none'; script-src 'nonce-%s'; form-action %s", encodedNonce, target))
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+// SLO handles SP-initiated single logout over either binding: it ends the user's
+// liberation-auth session and answers the SP on its registered logout endpoint
+func (s *SAMLService) SLO(c *gin.Context) {
+	// LogoutResponses only arrive for IdP-initiated logout, which is not offered
+	if c.Query("SAMLResponse") != "" || c.PostForm("SAMLResponse") != "" {
+		c.JSON(http.StatusOK, gin.H{"message": "signed out"})
+		return
+	}
+
+	var request samlLogoutRequest
+	_, relayState, sp, err := readSAMLMessage(c, s.getServiceProvider, &request)
+	if err != nil {
+		samlError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	status := samlStatusSuccess
+	if sessionID, err := c.Cookie("session_id"); err == nil && sessionID != "" {
+		if owner := s.as.getUserFromSession(sessionID); owner != nil {
+			if s.nameIDMatches(c.Request.Context(), sp, strings.TrimSpace(request.NameID), *owner) {
+				s.endSession(c.Request.Context(), sessionID, *owner)
+				c.SetCookie("session_id", "", -1, "/", "", true, true)
+			} else {
+				status = samlStatusRequester
+			}
+		}
+	}
+
+	if sp.SLOURL == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "signed out"})
+		return
+	}
+
+	response := newXMLNode("samlp:LogoutResponse", map[string]string{
+		"ID": samlID(), "Version": "2.0", "IssueInstant": time.Now().UTC().Format(time.RFC3339),
+		"Destination": sp.SLOURL, "InResponseTo": request.ID,
+	},
+		xmlText("saml:Issuer", s.config.EntityID),
+		newXMLNode("samlp:Status", nil, newXMLNode("samlp:StatusCode", map[string]string{"Value": status})),
+	).declare("samlp", samlProtocolNS).declare("saml", samlAssertionNS)
+
+	if sp.SLOBinding == samlBindingPOST {
+		if err := signXMLNode(response, s.key, s.cert); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+		renderSAMLPost(c, sp.SLOURL, "SAMLResponse", base64.StdEncoding.EncodeToString(response.canonical()), relayState)
+		return
+	}
+	query, err := signedRedirectQuery("SAMLResponse", string(response.canonical()), relayState, s.key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	separator := "?"
+	if strings.Contains(sp.SLOURL, "?") {
+		separator = "&"
+	}
+	c.Redirect(http.StatusFound, sp.SLOURL+separator+query)
+}
+
+// nameIDMatches reports whether a LogoutRequest's NameID names the session's user
+func (s *SAMLService) nameIDMatches(ctx context.Context, sp *SAMLServiceProvider, nameID string, userID uuid.UUID) bool {
+	if sp.NameIDFormat != samlNameIDEmail {
+		return nameID == userID.String()
+	}
+	user, err := s.as.getUserByID(ctx, userID)
+	return err == nil && strings.EqualFold(user.Email, nameID)
+}
+
+// endSession removes a browser session on every replica
+func (s *SAMLService) endSession(ctx context.Context, sessionID string, userID uuid.UUID) {
+	s.as.redis.Del(ctx, fmt.Sprintf("session:%s", sessionID))
+	s.as.publishRevocation(RevocationEvent{Type: revocationSession, ID: sessionID, UserID: userID.String()})
+}
+
+// Metadata describes the IdP for SP configuration
+func (s *SAMLService) Metadata(c *gin.Context) {
+	endpoints := func(name, path string) []*xmlNode {
+		return []*xmlNode{
+			newXMLNode("md:"+name, map[string]string{"Binding": samlBindingRedirect, "Location": s.config.BaseURL + path}),
+			newXMLNode("md:"+name, map[string]string{"Binding": samlBindingPOST, "Location": s.config.BaseURL + path}),
+		}
+	}
+	descriptor := newXMLNode("md:IDPSSODescriptor", map[string]string{
+		"WantAuthnRequestsSigned":    "false",
+		"protocolSupportEnumeration": samlProtocolNS,
+	},
+		newXMLNode("md:KeyDescriptor", map[string]string{"use": "signing"},
+			newXMLNode("ds:KeyInfo", nil,
+				newXMLNode("ds:X509Data", nil,
+					xmlText("ds:X509Certificate", base64.StdEncoding.EncodeToString(s.cert.Raw)),
+				),
+			).declare("ds", xmlDSigNS),
+		),
+	)
+	descriptor.children = append(descriptor.children, endpoints("SingleLogoutService", "/saml/slo")...)
+	descriptor.children = append(descriptor.children,
+		xmlText("md:NameIDFormat", samlNameIDPersistent),
+		xmlText("md:NameIDFormat", samlNameIDEmail),
+	)
+	descriptor.children = append(descriptor.children, endpoints("SingleSignOnService", "/saml/sso")...)
+
+	metadata := newXMLNode("md:EntityDescriptor", map[string]string{"entityID": s.config.EntityID}, descriptor).
+		declare("md", samlMetadataNS)
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, "application/samlmetadata+xml", append([]byte(xml.Header), metadata.canonical()...))
+}
+
+// Admin API
+
+// samlServiceProviderRequest registers an SP from its metadata, from explicit fields, or
+// both, in which case the explicit fields win
+type samlServiceProviderRequest struct {
+	SAMLServiceProvider
+	Metadata string `json:"metadata"`
+}
+
+func (s *SAMLService) AdminListServiceProviders(c *gin.Context) {
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `SELECT `+samlServiceProviderColumns+` FROM saml_service_providers ORDER BY name, entity_id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list service providers"})
+		return
+	}
+	defer rows.Close()
+
+	providers := []*SAMLServiceProvider{}
+	for rows.Next() {
+		sp, err := scanSAMLServiceProvider(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list service providers"})
+			return
+		}
+		providers = append(providers, sp)
+	}
+	c.JSON(http.StatusOK, gin.H{"service_providers": providers})
+}
+
+func (s *SAMLService) AdminPutServiceProvider(c *gin.Context) {
+	var req samlServiceProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	sp := req.SAMLServiceProvider
+	if req.Metadata != "" {
+		parsed, err := parseSPMetadata([]byte(req.Metadata))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if sp.EntityID == "" {
+			sp.EntityID = parsed.EntityID
+		}
+		if sp.ACSURL == "" {
+			sp.ACSURL = parsed.ACSURL
+		}
+		if sp.SLOURL == "" {
+			sp.SLOURL, sp.SLOBinding = parsed.SLOURL, parsed.SLOBinding
+		}
+		if sp.Certificate == "" {
+			sp.Certificate = parsed.Certificate
+		}
+		if sp.NameIDFormat == "" {
+			sp.NameIDFormat = parsed.NameIDFormat
+		}
+	}
+	if err := sp.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mapping, _ := json.Marshal(sp.AttributeMapping)
+	adminID, _ := c.Get("user_id")
+	row := s.as.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO saml_service_providers (id, entity_id, name, acs_url, slo_url, slo_binding, certificate,
+			name_id_format, attribute_mapping, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
+		ON CONFLICT (entity_id) DO UPDATE SET name = $3, acs_url = $4, slo_url = $5, slo_binding = $6,
+			certificate = $7, name_id_format = $8, attribute_mapping = $9
+		RETURNING `+samlServiceProviderColumns,
+		uuid.New(), sp.EntityID, sp.Name, sp.ACSURL, sp.SLOURL, sp.SLOBinding, sp.Certificate, sp.NameIDFormat, mapping, adminID)
+	stored, err := scanSAMLServiceProvider(row)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save service provider"})
+		return
+	}
+	c.JSON(http.StatusOK, stored)
+}
+
+func (s *SAMLService) AdminDeleteServiceProvider(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("sp_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service provider ID"})
+		return
+	}
+	if s.as.previewDryRun(c, "delete_saml_service_provider", id.String(), nil,
+		dryRunQuery{entity: "saml_service_providers", action: "delete", id: "entity_id", args: []interface{}{id},
+			from: "FROM saml_service_providers WHERE id = $1", missing: "Service provider not found"}) {
+		return
+	}
+	result, err := s.as.db.ExecContext(c.Request.Context(), `DELETE FROM saml_service_providers WHERE id = $1`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete service provider"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service provider not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Service provider removed"})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/suite"
+
+	"nuclear-ao3/shared/models"
+)
+
+type SAMLTestSuite struct {
+	suite.Suite
+	service *SAMLService
+	sp      *SAMLServiceProvider
+	user    *models.User
+}
+
+func (suite *SAMLTestSuite) SetupSuite() {
+	dir := suite.T().TempDir()
+	config := SAMLConfig{
+		Enabled:      true,
+		EntityID:     "https://auth.example.com/saml/metadata",
+		BaseURL:      "https://auth.example.com",
+		KeyFile:      filepath.Join(dir, "key.pem"),
+		CertFile:     filepath.Join(dir, "cert.pem"),
+		AssertionTTL: 5 * time.Minute,
+	}
+	service, err := NewSAMLService(&AuthService{}, config)
+	suite.Require().NoError(err)
+	suite.service = service
+
+	suite.sp = &SAMLServiceProvider{EntityID: "https://partner.example.net/sp", ACSURL: "https://partner.example.net/acs"}
+	suite.Require().NoError(suite.sp.validate())
+	suite.user = &models.User{ID: uuid.New(), Username: "reader", Email: "reader@example.com", DisplayName: "A & B <Reader>"}
+}
+
+// spCredentials creates a signing key and self-signed certificate for a test SP
+func (suite *SAMLTestSuite) spCredentials() (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Minute), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	suite.Require().NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	suite.Require().NoError(err)
+	return key, cert
+}
+
+// validateWithGoxmldsig checks the signature on the element at path with goxmldsig, an
+// XML-DSig implementation independent of the one that signed it
+func validateWithGoxmldsig(document []byte, path string, cert *x509.Certificate) error {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(document); err != nil {
+		return err
+	}
+	element := doc.FindElement(path)
+	if element == nil {
+		return fmt.Errorf("no element at %s", path)
+	}
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: []*x509.Certificate{cert}})
+	_, err := validator.Validate(element)
+	return err
+}
+
+// signWithGoxmldsig adds an enveloped signature to a request the way a goxmldsig-based SP does
+func (suite *SAMLTestSuite) signWithGoxmldsig(message string, key *rsa.PrivateKey, cert *x509.Certificate) string {
+	doc := etree.NewDocument()
+	suite.Require().NoError(doc.ReadFromString(message))
+	signer := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}))
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	signed, err := signer.SignEnveloped(doc.Root())
+	suite.Require().NoError(err)
+	doc.SetRoot(signed)
+	out, err := doc.WriteToString()
+	suite.Require().NoError(err)
+	return out
+}
+
+func (suite *SAMLTestSuite) TestResponseAssertionIsSigned() {
+	response, err := suite.service.buildResponse(suite.sp, "_request1", suite.user, []string{"user", "editor"}, time.Now(), "_session")
+	suite.Require().NoError(err)
+	document := string(response)
+
+	suite.NoError(validateWithGoxmldsig(response, "/Response/Assertion", suite.service.cert))
+	tampered := strings.Replace(document, "<saml:Audience>https://partner.example.net/sp<", "<saml:Audience>https://evil.example.com/sp<", 1)
+	suite.Error(validateWithGoxmldsig([]byte(tampered), "/Response/Assertion", suite.service.cert))
+	suite.Contains(document, `InResponseTo="_request1"`)
+	suite.Contains(document, `<saml:Audience>https://partner.example.net/sp</saml:Audience>`)
+	suite.Contains(document, `A &amp; B &lt;Reader&gt;`)
+
+	var parsed struct {
+		Assertion struct {
+			NameID     string `xml:"Subject>NameID"`
+			Attributes []struct {
+				Name   string   `xml:"Name,attr"`
+				Values []string `xml:"AttributeValue"`
+			} `xml:"AttributeStatement>Attribute"`
+		} `xml:"Assertion"`
+	}
+	suite.Require().NoError(xml.Unmarshal(response, &parsed))
+	suite.Equal(suite.user.ID.String(), parsed.Assertion.NameID)
+
+	attributes := map[string][]string{}
+	for _, attribute := range parsed.Assertion.Attributes {
+		attributes[attribute.Name] = attribute.Values
+	}
+	suite.Equal([]string{"reader"}, attributes["uid"])
+	suite.Equal([]string{"user", "editor"}, attributes["roles"])
+	suite.Equal([]string{"A & B <Reader>"}, attributes["displayName"])
+}
+
+func (suite *SAMLTestSuite) TestCertificateIsReusedAcrossRestarts() {
+	again, err := NewSAMLService(&AuthService{}, suite.service.config)
+	suite.Require().NoError(err)
+	suite.Equal(suite.service.cert.Raw, again.cert.Raw)
+}
+
+func (suite *SAMLTestSuite) TestRedirectSignatureRoundTrip() {
+	spKey, spCert := suite.spCredentials()
+	query, err := signedRedirectQuery("SAMLRequest", `<samlp:LogoutRequest/>`, "state/1", spKey)
+	suite.Require().NoError(err)
+	suite.NoError(verifyRedirectSignature(query, "SAMLRequest", spCert))
+
+	tampered := strings.Replace(query, "RelayState=state%2F1", "RelayState=state%2F2", 1)
+	suite.Error(verifyRedirectSignature(tampered, "SAMLRequest", spCert))
+	suite.Error(verifyRedirectSignature("SAMLRequest=abc", "SAMLRequest", spCert))
+
+	values, err := url.ParseQuery(query)
+	suite.Require().NoError(err)
+	message, err := decodeSAMLMessage(values.Get("SAMLRequest"), true)
+	suite.Require().NoError(err)
+	suite.Equal(`<samlp:LogoutRequest/>`, string(message))
+}
+
+func (suite *SAMLTestSuite) TestPOSTRequestsMustBeSignedWhenSPHasCertificate() {
+	spKey, spCert := suite.spCredentials()
+	sp := &SAMLServiceProvider{EntityID: suite.sp.EntityID, ACSURL: suite.sp.ACSURL, Certificate: base64.StdEncoding.EncodeToString(spCert.Raw)}
+	lookup := func(ctx context.Context, issuer string) (*SAMLServiceProvider, error) {
+		if issuer != sp.EntityID {
+			return nil, fmt.Errorf("unknown")
+		}
+		return sp, nil
+	}
+	read := func(message string) error {
+		form := url.Values{"SAMLRequest": {base64.StdEncoding.EncodeToString([]byte(message))}}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/saml/sso", strings.NewReader(form.Encode()))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		var request samlAuthnRequest
+		_, _, _, err := readSAMLMessage(c, lookup, &request)
+		return err
+	}
+
+	request := `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_request1" Version="2.0" AssertionConsumerServiceURL="https://partner.example.net/acs"><saml:Issuer>https://partner.example.net/sp</saml:Issuer></samlp:AuthnRequest>`
+	signed := suite.signWithGoxmldsig(request, spKey, spCert)
+	suite.NoError(read(signed))
+	suite.Error(read(request))
+	suite.Error(read(strings.Replace(signed, "https://partner.example.net/acs", "https://evil.example.com/acs", 1)))
+
+	otherKey, otherCert := suite.spCredentials()
+	suite.Error(read(suite.signWithGoxmldsig(request, otherKey, otherCert)))
+
+	sp.Certificate = ""
+	suite.NoError(read(request))
+}
+
+func (suite *SAMLTestSuite) TestDecodeRejectsOversizedMessages() {
+	var buf bytes.Buffer
+	writer, _ := flate.NewWriter(&buf, flate.BestCompression)
+	writer.Write(make([]byte, samlMaxInflated+10))
+	writer.Close()
+
+	_, err := decodeSAMLMessage(base64.StdEncoding.EncodeToString(buf.Bytes()), true)
+	suite.Error(err)
+}
+
+func (suite *SAMLTestSuite) TestParseSPMetadata() {
+	metadata := `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" xmlns:ds="http://www.w3.org/2000/09/xmldsig#" entityID="https://partner.example.net/sp">
+  <md:SPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="encryption"><ds:KeyInfo><ds:X509Data><ds:X509Certificate>ENC</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>
+    <md:KeyDescriptor use="signing"><ds:KeyInfo><ds:X509Data><ds:X509Certificate>
+      SIGNING
+    </ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>
+    <md:SingleLogoutService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://partner.example.net/slo"/>
+    <md:NameIDFormat>urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress</md:NameIDFormat>
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Artifact" Location="https://partner.example.net/artifact" index="0"/>
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://partner.example.net/acs" index="1"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>`
+
+	sp, err := parseSPMetadata([]byte(metadata))
+	suite.Require().NoError(err)
+	suite.Equal("https://partner.example.net/sp", sp.EntityID)
+	suite.Equal("https://partner.example.net/acs", sp.ACSURL)
+	suite.Equal("https://partner.example.net/slo", sp.SLOURL)
+	suite.Equal(samlBindingRedirect, sp.SLOBinding)
+	suite.Equal("SIGNING", sp.Certificate)
+	suite.Equal(samlNameIDEmail, sp.NameIDFormat)
+
+	_, err = parseSPMetadata([]byte(`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="x"/>`))
+	suite.Error(err)
+}
+
+func (suite *SAMLTestSuite) TestValidateServiceProvider() {
+	suite.Error((&SAMLServiceProvider{EntityID: "sp", ACSURL: "http://partner.example.net/acs"}).validate())
+	suite.Error((&SAMLServiceProvider{EntityID: "sp", ACSURL: "https://partner.example.net/acs", AttributeMapping: map[string]string{"pw": "password_hash"}}).validate())
+	suite.NoError((&SAMLServiceProvider{EntityID: "sp", ACSURL: "http://127.0.0.1:8080/acs"}).validate())
+}
+
+func TestSAML(t *testing.T) {
+	suite.Run(t, new(SAMLTestSuite))
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// SAML and XML-DSig identifiers
+const (
+	samlAssertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlProtocolNS  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlMetadataNS  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	xmlDSigNS       = "http://www.w3.org/2000/09/xmldsig#"
+
+	samlBindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	samlBindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	samlStatusSuccess   = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlStatusRequester = "urn:oasis:names:tc:SAML:2.0:status:Requester"
+
+	samlNameIDPersistent = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	samlNameIDEmail      = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	samlAttrNameBasic    = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+	samlAuthnPassword    = "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"
+	samlBearer           = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	xmlExcC14N       = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	xmlEnveloped     = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	xmlRSASHA256     = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	xmlRSASHA1       = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	xmlDigestSHA256  = "http://www.w3.org/2001/04/xmlenc#sha256"
+	samlMaxInflated  = 1 << 20
+	samlIDRandomSize = 20
+)
+
+// xmlNode is an element we generate. It renders in exclusive canonical form (exc-c14n
+// without comments) as long as namespaces are declared on the elements that signatures
+// cover and attributes are unqualified, so the bytes we digest are the bytes a verifier
+// computes and no general-purpose canonicalizer is needed.
+type xmlNode struct {
+	name string
+	// ns declares prefixes on this element, prefix -> URI
+	ns       map[string]string
+	attrs    map[string]string
+	text     string
+	children []*xmlNode
+}
+
+func newXMLNode(name string, attrs map[string]string, children ...*xmlNode) *xmlNode {
+	return &xmlNode{name: name, attrs: attrs, children: children}
+}
+
+func xmlText(name, text string) *xmlNode {
+	return &xmlNode{name: name, text: text}
+}
+
+func (n *xmlNode) declare(prefix, uri string) *xmlNode {
+	if n.ns == nil {
+		n.ns = map[string]string{}
+	}
+	n.ns[prefix] = uri
+	return n
+}
+
+// render writes the element in canonical form: namespace declarations sorted by prefix,
+// then attributes sorted by name, explicit end tags and c14n escaping
+func (n *xmlNode) render(buf *bytes.Buffer) {
+	buf.WriteString("<" + n.name)
+	prefixes := make([]string, 0, len(n.ns))
+	for prefix := range n.ns {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		fmt.Fprintf(buf, ` xmlns:%s="%s"`, prefix, escapeXMLAttr(n.ns[prefix]))
+	}
+	names := make([]string, 0, len(n.attrs))
+	for name := range n.attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(buf, ` %s="%s"`, name, escapeXMLAttr(n.attrs[name]))
+	}
+	buf.WriteString(">")
+	buf.WriteString(escapeXMLText(n.text))
+	for _, child := range n.children {
+		child.render(buf)
+	}
+	buf.WriteString("</" + n.name + ">")
+}
+
+func (n *xmlNode) canonical() []byte {
+	var buf bytes.Buffer
+	n.render(&buf)
+	return buf.Bytes()
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeXMLText(s string) string { return xmlTextEscaper.Replace(s) }
+func escapeXMLAttr(s string) string { return xmlAttrEscaper.Replace(s) }
+
+// samlID returns a fresh XML ID; IDs must not start with a digit
+func samlID() string {
+	raw := make([]byte, samlIDRandomSize)
+	rand.Read(raw)
+	return "_" + hex.EncodeToString(raw)
+}
+
+// signXMLNode adds an enveloped XML-DSig signature to an element carrying an ID attribute.
+// The signature goes after the element's first child, which SAML requires to be the Issuer.
+func signXMLNode(node *xmlNode, key *rsa.PrivateKey, cert *x509.Certificate) error {
+	digest := sha256.Sum256(node.canonical())
+
+	signedInfo := newXMLNode("ds:SignedInfo", nil,
+		newXMLNode("ds:CanonicalizationMethod", map[string]string{"Algorithm": xmlExcC14N}),
+		newXMLNode("ds:SignatureMethod", map[string]string{"Algorithm": xmlRSASHA256}),
+		newXMLNode("ds:Reference", map[string]string{"URI": "#" + node.attrs["ID"]},
+			newXMLNode("ds:Transforms", nil,
+				newXMLNode("ds:Transform", map[string]string{"Algorithm": xmlEnveloped}),
+				newXMLNode("ds:Transform", map[string]string{"Algorithm": xmlExcC14N}),
+			),
+			newXMLNode("ds:DigestMethod", map[string]string{"Algorithm": xmlDigestSHA256}),
+			xmlText("ds:DigestValue", base64.StdEncoding.EncodeToString(digest[:])),
+		),
+	).declare("ds", xmlDSigNS)
+
+	hashed := sha256.Sum256(signedInfo.canonical())
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+
+	signatureNode := newXMLNode("ds:Signature", nil,
+		signedInfo,
+		xmlText("ds:SignatureValue", base64.StdEncoding.EncodeToString(signature)),
+		newXMLNode("ds:KeyInfo", nil,
+			newXMLNode("ds:X509Data", nil,
+				xmlText("ds:X509Certificate", base64.StdEncoding.EncodeToString(cert.Raw)),
+			),
+		),
+	).declare("ds", xmlDSigNS)
+
+	children := append([]*xmlNode{}, node.children[:1]...)
+	children = append(children, signatureNode)
+	node.children = append(children, node.children[1:]...)
+	return nil
+}
+
+// decodeSAMLMessage decodes a SAMLRequest or SAMLResponse parameter. The Redirect binding
+// deflates before base64-encoding; the POST binding only base64-encodes.
+func decodeSAMLMessage(value string, deflated bool) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("SAML message is not base64: %w", err)
+	}
+	if !deflated {
+		return raw, nil
+	}
+	inflated, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(raw)), samlMaxInflated+1))
+	if err != nil {
+		return nil, fmt.Errorf("SAML message is not deflated: %w", err)
+	}
+	if len(inflated) > samlMaxInflated {
+		return nil, fmt.Errorf("SAML message is too large")
+	}
+	return inflated, nil
+}
+
+// encodeSAMLRedirect deflates and base64-encodes a message for the Redirect binding
+func encodeSAMLRedirect(message []byte) string {
+	var buf bytes.Buffer
+	writer, _ := flate.NewWriter(&buf, flate.BestCompression)
+	writer.Write(message)
+	writer.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// signedRedirectQuery builds a Redirect binding query string signed with RSA-SHA256. The
+// signature covers the URL-encoded parameters in this exact order (SAML bindings 3.4.4.1).
+func signedRedirectQuery(param, message, relayState string, key *rsa.PrivateKey) (string, error) {
+	query := param + "=" + url.QueryEscape(encodeSAMLRedirect([]byte(message)))
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	query += "&SigAlg=" + url.QueryEscape(xmlRSASHA256)
+
+	hashed := sha256.Sum256([]byte(query))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return query + "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature)), nil
+}
+
+// verifyRedirectSignature checks a Redirect binding signature against the raw query string,
+// since the signature covers the parameters exactly as the SP encoded them
+func verifyRedirectSignature(rawQuery, param string, cert *x509.Certificate) error {
+	values := map[string]string{}
+	for _, pair := range strings.Split(rawQuery, "&") {
+		name, value, _ := strings.Cut(pair, "=")
+		values[name] = value
+	}
+	if values["Signature"] == "" || values["SigAlg"] == "" {
+		return fmt.Errorf("request is not signed")
+	}
+
+	signed := param + "=" + values[param]
+	if relayState, ok := values["RelayState"]; ok {
+		signed += "&RelayState=" + relayState
+	}
+	signed += "&SigAlg=" + values["SigAlg"]
+
+	encoded, err := url.QueryUnescape(values["Signature"])
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("signature is not base64: %w", err)
+	}
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("only RSA signing certificates are supported")
+	}
+
+	algorithm, _ := url.QueryUnescape(values["SigAlg"])
+	switch algorithm {
+	case xmlRSASHA256:
+		hashed := sha256.Sum256([]byte(signed))
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature)
+	case xmlRSASHA1:
+		hashed := sha1.Sum([]byte(signed))
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA1, hashed[:], signature)
+	default:
+		return fmt.Errorf("unsupported SigAlg %q", algorithm)
+	}
+}
+
+// verifyEnvelopedSignature checks the enveloped signature on the root element of a POST
+// binding message. The reference must name the root, so the signature covers everything
+// the request structs read.
+func verifyEnvelopedSignature(message []byte, cert *x509.Certificate) error {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(message); err != nil || doc.Root() == nil {
+		return fmt.Errorf("malformed SAML message")
+	}
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: []*x509.Certificate{cert}})
+	_, err := validator.Validate(doc.Root())
+	if errors.Is(err, dsig.ErrMissingSignature) {
+		return fmt.Errorf("request is not signed")
+	}
+	return err
+}
+
+// parseSAMLCertificate accepts a certificate as PEM or as the bare base64 found in metadata
+func parseSAMLCertificate(value string) (*x509.Certificate, error) {
+	value = strings.TrimSpace(value)
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		return x509.ParseCertificate(block.Bytes)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+	if err != nil {
+		return nil, fmt.Errorf("certificate is neither PEM nor base64")
+	}
+	return x509.ParseCertificate(der)
+}
+
+// samlAuthnRequest is the part of an AuthnRequest the IdP acts on
+type samlAuthnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	ForceAuthn                  bool     `xml:"ForceAuthn,attr"`
+	Issuer                      string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+}
+
+// samlLogoutRequest is the part of a LogoutRequest the IdP acts on
+type samlLogoutRequest struct {
+	XMLName        xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol LogoutRequest"`
+	ID             string   `xml:"ID,attr"`
+	Version        string   `xml:"Version,attr"`
+	Issuer         string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameID         string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	SessionIndexes []string `xml:"SessionIndex"`
+}
+
+// samlEntityDescriptor is the part of SP metadata needed to register a service provider
+type samlEntityDescriptor struct {
+	XMLName  xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID string   `xml:"entityID,attr"`
+	SP       *struct {
+		KeyDescriptors []struct {
+			Use         string `xml:"use,attr"`
+			Certificate string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		SingleLogoutServices []samlEndpoint `xml:"SingleLogoutService"`
+		NameIDFormats        []string       `xml:"NameIDFormat"`
+		AssertionServices    []samlEndpoint `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+type samlEndpoint struct {
+	Binding   string `xml:"Binding,attr"`
+	Location  string `xml:"Location,attr"`
+	IsDefault bool   `xml:"isDefault,attr"`
+}
+
+// parseSPMetadata turns SP metadata into a service provider registration. Only the
+// HTTP-POST binding is used for assertions, since signed responses do not fit in a URL.
+func parseSPMetadata(metadata []byte) (*SAMLServiceProvider, error) {
+	var descriptor samlEntityDescriptor
+	if err := xml.Unmarshal(metadata, &descriptor); err != nil {
+		return nil, fmt.Errorf("invalid SP metadata: %w", err)
+	}
+	if descriptor.EntityID == "" || descriptor.SP == nil {
+		return nil, fmt.Errorf("metadata has no entityID or SPSSODescriptor")
+	}
+
+	sp := &SAMLServiceProvider{EntityID: descriptor.EntityID}
+	for _, acs := range descriptor.SP.AssertionServices {
+		if acs.Binding == samlBindingPOST && (sp.ACSURL == "" || acs.IsDefault) {
+			sp.ACSURL = acs.Location
+		}
+	}
+	if sp.ACSURL == "" {
+		return nil, fmt.Errorf("metadata has no HTTP-POST AssertionConsumerService")
+	}
+	for _, slo := range descriptor.SP.SingleLogoutServices {
+		if slo.Binding == samlBindingRedirect || (slo.Binding == samlBindingPOST && sp.SLOURL == "") {
+			sp.SLOURL, sp.SLOBinding = slo.Location, slo.Binding
+		}
+	}
+	for _, key := range descriptor.SP.KeyDescriptors {
+		if key.Use == "" || key.Use == "signing" {
+			sp.Certificate = strings.Join(strings.Fields(key.Certificate), "")
+			break
+		}
+	}
+	for _, format := range descriptor.SP.NameIDFormats {
+		if format == samlNameIDEmail {
+			sp.NameIDFormat = samlNameIDEmail
+		}
+	}
+	return sp, nil
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// schemaStatements creates tables owned by the auth service itself.
+// The core users/oauth tables are managed by the shared platform migrations.
+var schemaStatements = []string{
+	`CREATE TABLE IF NOT EXISTS user_invites (
+		id UUID PRIMARY KEY,
+		code_hash TEXT NOT NULL UNIQUE,
+		code_prefix TEXT NOT NULL,
+		created_by UUID NOT NULL,
+		email TEXT,
+		roles TEXT[] NOT NULL DEFAULT '{}',
+		note TEXT NOT NULL DEFAULT '',
+		max_uses INTEGER NOT NULL DEFAULT 1,
+		uses INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_invites_created_by ON user_invites (created_by)`,
+	`CREATE TABLE IF NOT EXISTS user_invite_redemptions (
+		invite_id UUID NOT NULL REFERENCES user_invites(id) ON DELETE CASCADE,
+		user_id UUID NOT NULL,
+		redeemed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (invite_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_registrations (
+		user_id UUID PRIMARY KEY,
+		stage TEXT NOT NULL,
+		invite_id UUID,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		completed_at TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_phone_numbers (
+		user_id UUID PRIMARY KEY,
+		phone TEXT NOT NULL,
+		verified_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_avatars (
+		user_id UUID PRIMARY KEY,
+		object_key TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS client_claim_policies (
+		client_id UUID PRIMARY KEY,
+		allowed_claims TEXT[],
+		mappings JSONB NOT NULL DEFAULT '[]',
+		updated_by UUID,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS security_events (
+		id UUID PRIMARY KEY,
+		user_id UUID,
+		event_type TEXT NOT NULL,
+		ip_address TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		details JSONB NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_security_events_user ON security_events (user_id, created_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS idx_security_events_created ON security_events (created_at DESC)`,
+	`CREATE TABLE IF NOT EXISTS saml_service_providers (
+		id UUID PRIMARY KEY,
+		entity_id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL DEFAULT '',
+		acs_url TEXT NOT NULL,
+		slo_url TEXT NOT NULL DEFAULT '',
+		slo_binding TEXT NOT NULL DEFAULT '',
+		certificate TEXT NOT NULL DEFAULT '',
+		name_id_format TEXT NOT NULL,
+		attribute_mapping JSONB NOT NULL DEFAULT '{}',
+		created_by UUID,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS client_pkce_policies (
+		client_id UUID PRIMARY KEY,
+		required BOOLEAN,
+		s256_only BOOLEAN,
+		updated_by UUID,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS client_refresh_cookies (
+		client_id UUID PRIMARY KEY,
+		updated_by UUID,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS token_revocation_jobs (
+		id UUID PRIMARY KEY,
+		criteria JSONB NOT NULL,
+		reason TEXT NOT NULL,
+		dry_run BOOLEAN NOT NULL DEFAULT false,
+		status TEXT NOT NULL,
+		access_revoked INTEGER NOT NULL DEFAULT 0,
+		refresh_revoked INTEGER NOT NULL DEFAULT 0,
+		batches INTEGER NOT NULL DEFAULT 0,
+		requested_by UUID,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP,
+		error TEXT,
+		report JSONB
+	)`,
+	`CREATE TABLE IF NOT EXISTS reserved_usernames (
+		skeleton TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		kind TEXT NOT NULL DEFAULT 'reserved',
+		reason TEXT NOT NULL DEFAULT '',
+		created_by UUID,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_activity (
+		user_id UUID PRIMARY KEY,
+		last_active_at TIMESTAMP NOT NULL,
+		stage TEXT NOT NULL DEFAULT 'active',
+		stage_changed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		digest_opt_in BOOLEAN NOT NULL DEFAULT false,
+		last_digest_at TIMESTAMP,
+		computed_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_activity_last_active ON user_activity (last_active_at)`,
+	`CREATE TABLE IF NOT EXISTS account_lifecycle_events (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		details JSONB,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_account_lifecycle_events_user ON account_lifecycle_events (user_id, created_at)`,
+	`CREATE TABLE IF NOT EXISTS lifecycle_exemptions (
+		user_id UUID PRIMARY KEY,
+		reason TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS job_leases (
+		job TEXT PRIMARY KEY,
+		owner TEXT,
+		expires_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		acquired_at TIMESTAMP,
+		running_since TIMESTAMP,
+		run_requested_at TIMESTAMP,
+		last_run_at TIMESTAMP,
+		last_run_by TEXT,
+		last_status TEXT,
+		last_error TEXT,
+		last_detail TEXT,
+		last_duration_ms BIGINT
+	)`,
+	// Cron schedules: the scheduled time each run covered, and admins' overrides
+	`ALTER TABLE job_leases ADD COLUMN IF NOT EXISTS last_scheduled_for TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS schedule TEXT,
+		ADD COLUMN IF NOT EXISTS enabled BOOLEAN`,
+	`CREATE TABLE IF NOT EXISTS capability_grants (
+		id UUID PRIMARY KEY,
+		granted_by UUID NOT NULL,
+		resource TEXT NOT NULL,
+		permissions TEXT[] NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		max_uses INTEGER,
+		uses INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP,
+		last_used_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_capability_grants_granted_by ON capability_grants (granted_by, created_at DESC)`,
+	`CREATE TABLE IF NOT EXISTS capability_grant_events (
+		id BIGSERIAL PRIMARY KEY,
+		grant_id UUID NOT NULL REFERENCES capability_grants(id) ON DELETE CASCADE,
+		event TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		ip_address TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_capability_grant_events_grant ON capability_grant_events (grant_id, created_at DESC)`,
+	`CREATE TABLE IF NOT EXISTS client_usage_daily (
+		client_id UUID NOT NULL,
+		day DATE NOT NULL,
+		authorizations INTEGER NOT NULL DEFAULT 0,
+		authorizing_users INTEGER NOT NULL DEFAULT 0,
+		token_grants INTEGER NOT NULL DEFAULT 0,
+		active_tokens INTEGER NOT NULL DEFAULT 0,
+		revocations INTEGER NOT NULL DEFAULT 0,
+		token_requests INTEGER NOT NULL DEFAULT 0,
+		token_errors INTEGER NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (client_id, day)
+	)`,
+	`CREATE TABLE IF NOT EXISTS resource_servers (
+		id UUID PRIMARY KEY,
+		identifier TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		allowed_scopes TEXT[] NOT NULL DEFAULT '{}',
+		client_id UUID UNIQUE,
+		created_by UUID,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS client_default_audiences (
+		client_id UUID PRIMARY KEY,
+		audiences TEXT[] NOT NULL DEFAULT '{}',
+		updated_by UUID,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	// Token audiences: the token tables belong to the platform migrations too
+	`DO $$ BEGIN
+		IF to_regclass('oauth_access_tokens') IS NOT NULL THEN
+			ALTER TABLE oauth_access_tokens ADD COLUMN IF NOT EXISTS audience TEXT[] NOT NULL DEFAULT '{}';
+		END IF;
+		IF to_regclass('oauth_refresh_tokens') IS NOT NULL THEN
+			ALTER TABLE oauth_refresh_tokens ADD COLUMN IF NOT EXISTS audience TEXT[] NOT NULL DEFAULT '{}';
+		END IF;
+	END $$`,
+	`CREATE TABLE IF NOT EXISTS client_session_limits (
+		client_id UUID PRIMARY KEY,
+		max_sessions INTEGER NOT NULL,
+		policy TEXT NOT NULL,
+		updated_by UUID,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	// Why a refresh token was revoked, so evicted sessions can be told apart
+	`DO $$ BEGIN
+		IF to_regclass('oauth_refresh_tokens') IS NOT NULL THEN
+			ALTER TABLE oauth_refresh_tokens ADD COLUMN IF NOT EXISTS revoked_reason TEXT;
+		END IF;
+	END $$`,
+	`CREATE TABLE IF NOT EXISTS recovery_contacts (
+		user_id UUID NOT NULL,
+		contact_id UUID NOT NULL,
+		status TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		accepted_at TIMESTAMP,
+		PRIMARY KEY (user_id, contact_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_recovery_contacts_contact ON recovery_contacts (contact_id)`,
+	`CREATE TABLE IF NOT EXISTS recovery_requests (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL,
+		secret_hash TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		status TEXT NOT NULL,
+		threshold INTEGER NOT NULL,
+		approvals INTEGER NOT NULL DEFAULT 0,
+		failed_attempts INTEGER NOT NULL DEFAULT 0,
+		ip_address TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMP NOT NULL,
+		available_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		resolved_at TIMESTAMP,
+		resolved_by UUID,
+		resolution TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_recovery_requests_user ON recovery_requests (user_id, created_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS idx_recovery_requests_open ON recovery_requests (expires_at) WHERE status IN ('pending', 'approved')`,
+	`CREATE TABLE IF NOT EXISTS recovery_approvals (
+		request_id UUID NOT NULL REFERENCES recovery_requests(id) ON DELETE CASCADE,
+		contact_id UUID NOT NULL,
+		decision TEXT NOT NULL,
+		ip_address TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (request_id, contact_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS recovery_events (
+		id BIGSERIAL PRIMARY KEY,
+		request_id UUID,
+		user_id UUID NOT NULL,
+		actor_id UUID,
+		event TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		ip_address TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_recovery_events_request ON recovery_events (request_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_recovery_events_user ON recovery_events (user_id, created_at DESC)`,
+	// Branding for hosted pages; "default" applies to clients without a profile of their own
+	`CREATE TABLE IF NOT EXISTS branding_profiles (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		primary_color TEXT NOT NULL DEFAULT '',
+		accent_color TEXT NOT NULL DEFAULT '',
+		background_color TEXT NOT NULL DEFAULT '',
+		text_color TEXT NOT NULL DEFAULT '',
+		support_url TEXT NOT NULL DEFAULT '',
+		support_email TEXT NOT NULL DEFAULT '',
+		privacy_url TEXT NOT NULL DEFAULT '',
+		terms_url TEXT NOT NULL DEFAULT '',
+		logo_key TEXT,
+		updated_by UUID,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS client_branding (
+		client_id UUID PRIMARY KEY,
+		branding_id TEXT NOT NULL REFERENCES branding_profiles (id) ON DELETE CASCADE,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	// Processed moderation webhook events, keyed by the sender's event ID for idempotency
+	`CREATE TABLE IF NOT EXISTS moderation_events (
+		event_id TEXT PRIMARY KEY,
+		action TEXT NOT NULL,
+		user_id UUID,
+		status TEXT NOT NULL,
+		applied TEXT[] NOT NULL DEFAULT '{}',
+		detail TEXT NOT NULL DEFAULT '',
+		reason TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT '',
+		received_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_moderation_events_user ON moderation_events (user_id, received_at DESC)`,
+	`CREATE TABLE IF NOT EXISTS user_moderation (
+		user_id UUID PRIMARY KEY,
+		suspended BOOLEAN NOT NULL DEFAULT false,
+		restricted_scopes TEXT[] NOT NULL DEFAULT '{}',
+		last_event_id TEXT,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	// Blind index for looking users up by email once addresses are encrypted (see pii_encryption.go)
+	`DO $$ BEGIN
+		IF to_regclass('users') IS NOT NULL THEN
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS email_bidx TEXT;
+			CREATE INDEX IF NOT EXISTS idx_users_email_bidx ON users (email_bidx);
+		END IF;
+	END $$`,
+	// Sign-out epochs revoke every first-party JWT issued before them (see signout_epoch.go)
+	`DO $$ BEGIN
+		IF to_regclass('users') IS NOT NULL THEN
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS signout_epoch BIGINT NOT NULL DEFAULT 0;
+		END IF;
+	END $$`,
+	// Preferred locale for localized messages and the locale claim (see locales.go)
+	`DO $$ BEGIN
+		IF to_regclass('user_preferences') IS NOT NULL THEN
+			ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS preferred_locale TEXT;
+		END IF;
+	END $$`,
+	// Partial consent: user_consents belongs to the platform migrations, so only extend it once it exists
+	`DO $$ BEGIN
+		IF to_regclass('user_consents') IS NOT NULL THEN
+			ALTER TABLE user_consents ADD COLUMN IF NOT EXISTS declined_scopes TEXT[] NOT NULL DEFAULT '{}';
+			ALTER TABLE user_consents ADD COLUMN IF NOT EXISTS withheld_claims TEXT[] NOT NULL DEFAULT '{}';
+		END IF;
+	END $$`,
+	// Hosted tenants, their members and the usage billing is charged from
+	`CREATE TABLE IF NOT EXISTS tenants (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		plan TEXT NOT NULL,
+		trial_plan TEXT NOT NULL DEFAULT '',
+		trial_ends_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS tenant_members (
+		user_id UUID PRIMARY KEY,
+		tenant_id TEXT NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+		added_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_tenant_members_tenant ON tenant_members (tenant_id)`,
+	// One row per tenant, metric and month; peak is the highest value of the month
+	`CREATE TABLE IF NOT EXISTS tenant_usage (
+		tenant_id TEXT NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+		metric TEXT NOT NULL,
+		period DATE NOT NULL,
+		value BIGINT NOT NULL DEFAULT 0,
+		peak BIGINT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (tenant_id, metric, period)
+	)`,
+	`CREATE TABLE IF NOT EXISTS tenant_quota_breaches (
+		id BIGSERIAL PRIMARY KEY,
+		tenant_id TEXT NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+		metric TEXT NOT NULL,
+		started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		grace_ends_at TIMESTAMP NOT NULL,
+		resolved_at TIMESTAMP
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_tenant_quota_breaches_open ON tenant_quota_breaches (tenant_id, metric) WHERE resolved_at IS NULL`,
+	`CREATE TABLE IF NOT EXISTS imported_users (
+		source_id TEXT PRIMARY KEY,
+		user_id UUID NOT NULL,
+		password_reset_required BOOLEAN NOT NULL,
+		imported_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS signup_reviews (
+		user_id UUID PRIMARY KEY,
+		score DOUBLE PRECISION NOT NULL,
+		matches JSONB NOT NULL DEFAULT '[]',
+		status TEXT NOT NULL,
+		decided_by UUID,
+		decided_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_signup_reviews_status ON signup_reviews (status, created_at)`,
+	`CREATE TABLE IF NOT EXISTS signup_spam_labels (
+		user_id UUID PRIMARY KEY,
+		label TEXT NOT NULL,
+		labelled_by UUID,
+		labelled_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS authorization_policies (
+		name TEXT PRIMARY KEY,
+		module TEXT NOT NULL,
+		updated_by UUID,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_devices (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL,
+		client_id UUID NOT NULL,
+		platform TEXT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		app_version TEXT NOT NULL DEFAULT '',
+		push_token TEXT,
+		ip_address TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		last_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		revoked_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_devices_user ON user_devices (user_id) WHERE revoked_at IS NULL`,
+	// Refresh tokens issued to a registered device
+	`DO $$ BEGIN
+		IF to_regclass('oauth_refresh_tokens') IS NOT NULL THEN
+			ALTER TABLE oauth_refresh_tokens ADD COLUMN IF NOT EXISTS device_id UUID;
+			CREATE INDEX IF NOT EXISTS idx_oauth_refresh_tokens_device ON oauth_refresh_tokens (device_id) WHERE device_id IS NOT NULL;
+		END IF;
+	END $$`,
+	// Versions of client configuration and consents, recorded by trigger whichever code path
+	// writes them. Client secrets are left out.
+	`CREATE TABLE IF NOT EXISTS config_history (
+		id BIGSERIAL PRIMARY KEY,
+		table_name TEXT NOT NULL,
+		row_key TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		operation TEXT NOT NULL,
+		row_data JSONB NOT NULL,
+		changed_by UUID,
+		changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_config_history_version ON config_history (table_name, row_key, version)`,
+	`CREATE OR REPLACE FUNCTION record_config_history() RETURNS trigger AS $$
+	DECLARE
+		v_data JSONB;
+		v_key TEXT;
+		v_column TEXT;
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			v_data := to_jsonb(OLD) - 'client_secret';
+		ELSE
+			v_data := to_jsonb(NEW) - 'client_secret';
+		END IF;
+		IF TG_OP = 'UPDATE' AND v_data = to_jsonb(OLD) - 'client_secret' THEN
+			RETURN NULL;
+		END IF;
+		FOREACH v_column IN ARRAY TG_ARGV LOOP
+			v_key := concat_ws('/', v_key, v_data ->> v_column);
+		END LOOP;
+		INSERT INTO config_history (table_name, row_key, version, operation, row_data, changed_by, changed_at)
+		SELECT TG_TABLE_NAME, v_key, COALESCE(MAX(h.version), 0) + 1, TG_OP, v_data,
+			NULLIF(current_setting('liberation_auth.actor', true), '')::uuid, clock_timestamp()
+		FROM config_history h WHERE h.table_name = TG_TABLE_NAME AND h.row_key = v_key;
+		RETURN NULL;
+	END $$ LANGUAGE plpgsql`,
+	// Both tables belong to the platform migrations; rows that predate history get a SNAPSHOT version
+	`DO $$ BEGIN
+		IF to_regclass('oauth_clients') IS NOT NULL THEN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'oauth_clients_history') THEN
+				CREATE TRIGGER oauth_clients_history AFTER INSERT OR UPDATE OR DELETE ON oauth_clients
+					FOR EACH ROW EXECUTE FUNCTION record_config_history('client_id');
+			END IF;
+			INSERT INTO config_history (table_name, row_key, version, operation, row_data)
+			SELECT 'oauth_clients', c.client_id::text, 1, 'SNAPSHOT', to_jsonb(c) - 'client_secret' FROM oauth_clients c
+			WHERE NOT EXISTS (SELECT 1 FROM config_history h WHERE h.table_name = 'oauth_clients' AND h.row_key = c.client_id::text);
+		END IF;
+		IF to_regclass('user_consents') IS NOT NULL THEN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'user_consents_history') THEN
+				CREATE TRIGGER user_consents_history AFTER INSERT OR UPDATE OR DELETE ON user_consents
+					FOR EACH ROW EXECUTE FUNCTION record_config_history('user_id', 'client_id');
+			END IF;
+			INSERT INTO config_history (table_name, row_key, version, operation, row_data)
+			SELECT 'user_consents', u.user_id::text || '/' || u.client_id::text, 1, 'SNAPSHOT', to_jsonb(u) FROM user_consents u
+			WHERE NOT EXISTS (SELECT 1 FROM config_history h WHERE h.table_name = 'user_consents' AND h.row_key = u.user_id::text || '/' || u.client_id::text);
+		END IF;
+	END $$`,
+	// Expired access tokens are rolled up here before TOKEN_RETENTION_DAYS deletes them; client tokens use the nil user
+	`CREATE TABLE IF NOT EXISTS token_usage_daily (
+		day DATE NOT NULL,
+		user_id UUID NOT NULL,
+		client_id UUID NOT NULL,
+		tokens INTEGER NOT NULL DEFAULT 0,
+		revoked INTEGER NOT NULL DEFAULT 0,
+		scopes TEXT[] NOT NULL DEFAULT '{}',
+		ip_hashes TEXT[] NOT NULL DEFAULT '{}',
+		last_used_at TIMESTAMP,
+		PRIMARY KEY (day, user_id, client_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_token_usage_daily_client ON token_usage_daily (client_id, day)`,
+	`CREATE TABLE IF NOT EXISTS revoked_token_tombstones (
+		id UUID PRIMARY KEY,
+		token TEXT NOT NULL,
+		user_id UUID,
+		client_id UUID NOT NULL,
+		scopes TEXT[] NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_revoked_token_tombstones_token ON revoked_token_tombstones (token)`,
+	`CREATE INDEX IF NOT EXISTS idx_revoked_token_tombstones_revoked ON revoked_token_tombstones (revoked_at)`,
+	// The calibrated cost of new password hashes, shared by every replica
+	`CREATE TABLE IF NOT EXISTS password_hash_parameters (
+		algorithm TEXT PRIMARY KEY,
+		cost INTEGER NOT NULL,
+		target_latency_ms BIGINT NOT NULL,
+		measured_latency_ms BIGINT NOT NULL,
+		host TEXT NOT NULL DEFAULT '',
+		calibrated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	// Tenants' namespaces and keys in liberation-ai; rows outlive deleted tenants until they are torn down there
+	`CREATE TABLE IF NOT EXISTS tenant_provisioning (
+		tenant_id TEXT PRIMARY KEY,
+		state TEXT NOT NULL,
+		namespace TEXT NOT NULL DEFAULT '',
+		key_hint TEXT NOT NULL DEFAULT '',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		provisioned_at TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_deletions (
+		user_id UUID PRIMARY KEY,
+		state TEXT NOT NULL,
+		requested_by TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		completed_at TIMESTAMP,
+		purged_at TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_deletions_state ON user_deletions(state, requested_at)`,
+	`CREATE TABLE IF NOT EXISTS user_deletion_acks (
+		user_id UUID NOT NULL REFERENCES user_deletions(user_id) ON DELETE CASCADE,
+		service TEXT NOT NULL,
+		state TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		acknowledged_at TIMESTAMP,
+		receipt JSONB,
+		PRIMARY KEY (user_id, service)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_user_deletion_acks_due ON user_deletion_acks(next_attempt_at) WHERE state = 'pending'`,
+	`CREATE TABLE IF NOT EXISTS canary_runs (
+		id BIGSERIAL PRIMARY KEY,
+		checked_at TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		duration_ms DOUBLE PRECISION NOT NULL,
+		steps JSONB NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_canary_runs_checked_at ON canary_runs(checked_at DESC)`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS residency_region TEXT NOT NULL DEFAULT ''`,
+	// Clients opt into RFC 9068 JWT access tokens; the clients table belongs to the platform migrations
+	`DO $$ BEGIN
+		IF to_regclass('oauth_clients') IS NOT NULL THEN
+			ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS access_token_format TEXT NOT NULL DEFAULT 'opaque';
+		END IF;
+	END $$`,
+}
+
+// ensureSchema applies the service's idempotent schema statements. Index builds on large
+// tables can outlast DB_STATEMENT_TIMEOUT, so each statement runs with the timeout lifted.
+func ensureSchema(db *sql.DB) error {
+	for _, statement := range schemaStatements {
+		if err := applySchemaStatement(db, statement); err != nil {
+			return fmt.Errorf("failed to apply schema: %w", err)
+		}
+	}
+	return nil
+}
+
+func applySchemaStatement(db *sql.DB, statement string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SET LOCAL statement_timeout = 0`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(statement); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
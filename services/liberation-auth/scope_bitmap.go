@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"nuclear-ao3/shared/models"
+)
+
+// supportedScopes are the scopes advertised in discovery
+var supportedScopes = []string{
+	"openid", "profile", "email", "read", "write", "works:manage",
+	"comments:write", "bookmarks:manage", "collections:manage",
+}
+
+// maxRegisteredScopes bounds the registry; scopes past it are kept by name instead of by bit
+const maxRegisteredScopes = 512
+
+// scopeRegistry assigns each known scope a bit so a token's scopes can be tested without
+// scanning its scope list. Bits are only meaningful within one process and are never stored.
+type scopeRegistry struct {
+	mu    sync.RWMutex
+	bits  map[string]int
+	names []string
+}
+
+// scopeBits is the registry for this process, seeded with every scope the service issues
+var scopeBits = newScopeRegistry(supportedScopes, []string{"admin", "tags:wrangle"}, registeredOAuthScopes())
+
+// registeredOAuthScopes lists the AO3 scope catalogue in a stable order
+func registeredOAuthScopes() []string {
+	names := make([]string, 0, len(models.AO3OAuthScopes))
+	for name := range models.AO3OAuthScopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newScopeRegistry(groups ...[]string) *scopeRegistry {
+	r := &scopeRegistry{bits: map[string]int{}}
+	for _, group := range groups {
+		for _, scope := range group {
+			r.register(scope)
+		}
+	}
+	return r
+}
+
+// register returns a scope's bit, assigning the next free one to a scope seen for the first time
+func (r *scopeRegistry) register(scope string) (int, bool) {
+	r.mu.RLock()
+	bit, ok := r.bits[scope]
+	r.mu.RUnlock()
+	if ok {
+		return bit, true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if bit, ok := r.bits[scope]; ok {
+		return bit, true
+	}
+	if len(r.names) >= maxRegisteredScopes {
+		return 0, false
+	}
+	bit = len(r.names)
+	r.bits[scope] = bit
+	r.names = append(r.names, scope)
+	return bit, true
+}
+
+// lookup returns a scope's bit without registering it
+func (r *scopeRegistry) lookup(scope string) (int, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	bit, ok := r.bits[scope]
+	return bit, ok
+}
+
+// set converts a scope list into a bitmap. Token scopes were validated at issuance,
+// so registering the ones the catalogue does not know is bounded by what clients hold.
+func (r *scopeRegistry) set(scopes []string) scopeSet {
+	var set scopeSet
+	for _, scope := range scopes {
+		bit, ok := r.register(scope)
+		if !ok {
+			set.overflow = append(set.overflow, scope)
+			continue
+		}
+		for len(set.words) <= bit/64 {
+			set.words = append(set.words, 0)
+		}
+		set.words[bit/64] |= 1 << (bit % 64)
+	}
+	set.registry = r
+	return set
+}
+
+// scopeSet is a token's scopes as a bitmap over a registry. The zero value holds no scopes.
+type scopeSet struct {
+	registry *scopeRegistry
+	words    []uint64
+	// overflow holds scopes that did not fit in the registry
+	overflow []string
+}
+
+// has reports whether the set contains a scope
+func (s scopeSet) has(scope string) bool {
+	if s.registry != nil {
+		if bit, ok := s.registry.lookup(scope); ok {
+			return bit/64 < len(s.words) && s.words[bit/64]&(1<<(bit%64)) != 0
+		}
+	}
+	return contains(s.overflow, scope)
+}
+
+// hasAny reports whether the set contains at least one of the scopes
+func (s scopeSet) hasAny(scopes ...string) bool {
+	for _, scope := range scopes {
+		if s.has(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAll reports whether the set contains every one of the scopes
+func (s scopeSet) hasAll(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !s.has(scope) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+
+	"nuclear-ao3/shared/models"
+)
+
+type ScopeBitmapTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ScopeBitmapTestSuite) TestSetMatchesScopeList() {
+	registry := newScopeRegistry(supportedScopes)
+	set := registry.set([]string{"openid", "email", "works:manage"})
+
+	suite.True(set.has("openid"))
+	suite.True(set.has("works:manage"))
+	suite.True(set.hasAll("openid", "email"))
+	suite.True(set.hasAny("profile", "email"))
+
+	suite.False(set.has("profile"))
+	suite.False(set.hasAll("openid", "profile"))
+	suite.False(set.has("unregistered"))
+	suite.False(scopeSet{}.has("openid"))
+}
+
+func (suite *ScopeBitmapTestSuite) TestUnknownScopesGetBits() {
+	registry := newScopeRegistry(supportedScopes)
+	set := registry.set([]string{"partner:sync"})
+
+	suite.True(set.has("partner:sync"))
+	suite.Empty(set.overflow)
+	suite.False(registry.set([]string{"read"}).has("partner:sync"))
+}
+
+func (suite *ScopeBitmapTestSuite) TestOverflowFallsBackToNames() {
+	registry := newScopeRegistry()
+	for i := 0; i < maxRegisteredScopes; i++ {
+		registry.register(uuid.NewString())
+	}
+	set := registry.set([]string{"late"})
+
+	suite.True(set.has("late"))
+	suite.False(set.has("other"))
+	suite.Equal([]string{"late"}, set.overflow)
+}
+
+func (suite *ScopeBitmapTestSuite) TestCacheKeepsBitmapWithToken() {
+	cache := newTokenCache(time.Minute, 10)
+	cache.putToken("token", &models.OAuthAccessToken{
+		ID:        uuid.New(),
+		Scopes:    []string{"read", "profile"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+
+	token, scopes := cache.getTokenScopes("token")
+	suite.Require().NotNil(token)
+	suite.Equal([]string{"read", "profile"}, token.Scopes)
+	suite.True(scopes.has("profile"))
+	suite.False(scopes.has("email"))
+}
+
+func (suite *ScopeBitmapTestSuite) TestRateLimitTierUsesBitmap() {
+	info := &ClientRateLimitInfo{ClientID: "client", Scopes: []string{"read", "tags:wrangle"}}
+	suite.Equal(RateLimitTierAdmin, info.DetermineRateLimitTier())
+
+	info = &ClientRateLimitInfo{ClientID: "client", Scopes: []string{"read"}}
+	suite.Equal(RateLimitTierPublic, info.DetermineRateLimitTier())
+}
+
+func TestScopeBitmap(t *testing.T) {
+	suite.Run(t, new(ScopeBitmapTestSuite))
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+// Security event types
+const (
+	securityEventLoginSucceeded      = "login_succeeded"
+	securityEventLoginFailed         = "login_failed"
+	securityEventLoginLocked         = "login_locked"
+	securityEventRegistered          = "registered"
+	securityEventPasswordReset       = "password_reset"
+	securityEventPasswordChanged     = "password_changed"
+	securityEventSessionRevoked      = "session_revoked"
+	securityEventModerated           = "moderation_applied"
+	securityEventSessionEvicted      = "session_evicted"
+	securityEventSignedOutEverywhere = "signed_out_everywhere"
+	securityEventAdminSearch         = "admin_search"
+	securityEventIssuanceDenied      = "token_issuance_denied"
+	securityEventDeviceRegistered    = "device_registered"
+	securityEventDeviceRevoked       = "device_revoked"
+	securityEventAdminAccessDenied   = "admin_access_denied"
+	securityEventMFAReset            = "mfa_reset"
+)
+
+var (
+	securityEventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "liberation_auth_security_events_published_total",
+		Help: "Security events recorded, by path (stream, inline when the stream is backed up or unreachable, dropped).",
+	}, []string{"path"})
+	securityEventsWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "liberation_auth_security_events_written_total",
+		Help: "Security events taken off the stream, by outcome (written, duplicate, malformed, failed).",
+	}, []string{"outcome"})
+	securityEventBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "liberation_auth_security_event_backlog",
+		Help: "Security events waiting in the stream, as last seen by this instance.",
+	})
+)
+
+// SecurityEventConfig controls the stream that buffers security events on their way to Postgres
+type SecurityEventConfig struct {
+	Stream string
+	Group  string
+	// BatchSize is how many events the writer inserts per transaction
+	BatchSize int
+	// HighWater is the backlog at which new events bypass the stream and are written inline,
+	// slowing callers down instead of letting the stream grow without bound
+	HighWater int64
+	// ClaimIdle is how long an event may sit unacknowledged with another consumer before
+	// this one takes it over, which is how events held by a crashed replica are replayed
+	ClaimIdle time.Duration
+	// Block is how long the writer waits for new events per read
+	Block time.Duration
+}
+
+// DefaultSecurityEventConfig reads SECURITY_EVENTS_STREAM, SECURITY_EVENTS_BATCH_SIZE,
+// SECURITY_EVENTS_HIGH_WATER and SECURITY_EVENTS_CLAIM_IDLE from the environment
+func DefaultSecurityEventConfig() (SecurityEventConfig, error) {
+	config := SecurityEventConfig{
+		Stream: getEnv("SECURITY_EVENTS_STREAM", "security_events"),
+		Group:  "security-event-writers",
+		Block:  2 * time.Second,
+	}
+	var err error
+	if config.BatchSize, err = strconv.Atoi(getEnv("SECURITY_EVENTS_BATCH_SIZE", "100")); err != nil || config.BatchSize <= 0 {
+		return config, fmt.Errorf("SECURITY_EVENTS_BATCH_SIZE must be a positive integer")
+	}
+	if config.HighWater, err = strconv.ParseInt(getEnv("SECURITY_EVENTS_HIGH_WATER", "50000"), 10, 64); err != nil || config.HighWater <= 0 {
+		return config, fmt.Errorf("SECURITY_EVENTS_HIGH_WATER must be a positive integer")
+	}
+	if config.ClaimIdle, err = time.ParseDuration(getEnv("SECURITY_EVENTS_CLAIM_IDLE", "1m")); err != nil || config.ClaimIdle <= 0 {
+		return config, fmt.Errorf("SECURITY_EVENTS_CLAIM_IDLE must be a positive duration")
+	}
+	return config, nil
+}
+
+// securityEvent is one row of the security_events table. The ID is assigned when the event
+// is recorded, so a replayed stream entry inserts nothing the second time.
+type securityEvent struct {
+	ID        uuid.UUID              `json:"id"`
+	UserID    *uuid.UUID             `json:"user_id,omitempty"`
+	Type      string                 `json:"event_type"`
+	IPAddress string                 `json:"ip_address,omitempty"`
+	UserAgent string                 `json:"user_agent,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// securityEventPipeline moves security events from request handlers to Postgres through a
+// Redis stream. Handlers pay for one XADD; a consumer group on every replica does the
+// inserts. Entries are acknowledged and deleted only after their insert commits, so an
+// event is written at least once, and inserts ignore IDs that already exist.
+type securityEventPipeline struct {
+	redis    *redis.Client
+	config   SecurityEventConfig
+	consumer string
+	// insert writes a batch idempotently; it is the database in production
+	insert func(ctx context.Context, events []securityEvent) error
+	// backedUp is set while the backlog is over the high-water mark
+	backedUp atomic.Bool
+}
+
+func newSecurityEventPipeline(rdb *redis.Client, db *sql.DB, config SecurityEventConfig) *securityEventPipeline {
+	return &securityEventPipeline{
+		redis:    rdb,
+		config:   config,
+		consumer: instanceID,
+		insert: func(ctx context.Context, events []securityEvent) error {
+			return insertSecurityEvents(ctx, db, events)
+		},
+	}
+}
+
+// insertSecurityEvents writes a batch in one transaction, skipping events already written
+func insertSecurityEvents(ctx context.Context, db *sql.DB, events []securityEvent) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, event := range events {
+		details, err := json.Marshal(event.Details)
+		if err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO security_events (id, user_id, event_type, ip_address, user_agent, details, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (id) DO NOTHING`,
+			event.ID, event.UserID, event.Type, event.IPAddress, event.UserAgent, details, event.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if inserted, _ := result.RowsAffected(); inserted == 0 {
+			securityEventsWritten.WithLabelValues("duplicate").Inc()
+		}
+	}
+	return tx.Commit()
+}
+
+// recordSecurityEvent notes something security-relevant about a request. It never fails the
+// request: when the stream is backed up or unreachable the event is written inline instead.
+func (as *AuthService) recordSecurityEvent(c *gin.Context, userID *uuid.UUID, eventType string, details map[string]interface{}) {
+	event := securityEvent{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Type:      eventType,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Details:   details,
+		CreatedAt: time.Now().UTC(),
+	}
+	ctx := c.Request.Context()
+	if as.events != nil && !as.events.backedUp.Load() {
+		err := as.events.publish(ctx, event)
+		if err == nil {
+			securityEventsPublished.WithLabelValues("stream").Inc()
+			return
+		}
+		log.Printf("Security event stream unavailable, writing inline: %v", err)
+	}
+	if as.db == nil {
+		securityEventsPublished.WithLabelValues("dropped").Inc()
+		return
+	}
+	if err := insertSecurityEvents(ctx, as.db, []securityEvent{event}); err != nil {
+		securityEventsPublished.WithLabelValues("dropped").Inc()
+		log.Printf("Failed to record security event %s: %v", eventType, err)
+		return
+	}
+	securityEventsPublished.WithLabelValues("inline").Inc()
+}
+
+// publish appends an event to the stream and notes the backlog it leaves behind
+func (p *securityEventPipeline) publish(ctx context.Context, event securityEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	pipe := p.redis.Pipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{Stream: p.config.Stream, Values: map[string]interface{}{"event": payload}})
+	length := pipe.XLen(ctx, p.config.Stream)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	p.observeBacklog(length.Val())
+	return nil
+}
+
+// observeBacklog turns inline writes on above the high-water mark and off again once the
+// writers have drained the stream to half of it
+func (p *securityEventPipeline) observeBacklog(length int64) {
+	securityEventBacklog.Set(float64(length))
+	switch {
+	case length >= p.config.HighWater:
+		if !p.backedUp.Swap(true) {
+			log.Printf("Security event backlog at %d; writing new events inline until it drains", length)
+		}
+	case length < p.config.HighWater/2:
+		p.backedUp.Store(false)
+	}
+}
+
+// Run consumes the stream until ctx is cancelled. On start it replays what this consumer
+// read but never acknowledged before a restart; while running it also claims entries left
+// pending by consumers that have gone away.
+func (p *securityEventPipeline) Run(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	err := p.redis.XGroupCreateMkStream(ctx, p.config.Stream, p.config.Group, "0").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		log.Printf("Security event writer disabled: %v", err)
+		return
+	}
+
+	replay := true
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		var err error
+		switch {
+		case replay:
+			// Our own pending entries, oldest first; an empty result means we are caught up
+			var processed int
+			processed, err = p.readGroup(ctx, "0", 0)
+			replay = err != nil || processed > 0
+		case time.Since(lastClaim) >= p.config.ClaimIdle/2:
+			err = p.claimAbandoned(ctx)
+			lastClaim = time.Now()
+		default:
+			_, err = p.readGroup(ctx, ">", p.config.Block)
+		}
+		if err != nil && ctx.Err() == nil {
+			// Whatever failed stays pending and is replayed once the database is back
+			log.Printf("Security event writer: %v", err)
+			replay = true
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+		if length, err := p.redis.XLen(ctx, p.config.Stream).Result(); err == nil {
+			p.observeBacklog(length)
+		}
+	}
+}
+
+// readGroup reads and writes one batch: "0" re-reads this consumer's pending entries, ">" new ones
+func (p *securityEventPipeline) readGroup(ctx context.Context, start string, block time.Duration) (int, error) {
+	args := &redis.XReadGroupArgs{
+		Group:    p.config.Group,
+		Consumer: p.consumer,
+		Streams:  []string{p.config.Stream, start},
+		Count:    int64(p.config.BatchSize),
+		Block:    block,
+	}
+	if block == 0 {
+		args.Block = -1
+	}
+	streams, err := p.redis.XReadGroup(ctx, args).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	processed := 0
+	for _, stream := range streams {
+		if err := p.write(ctx, stream.Messages); err != nil {
+			return processed, err
+		}
+		processed += len(stream.Messages)
+	}
+	return processed, nil
+}
+
+// claimAbandoned takes over entries another consumer read but did not acknowledge within ClaimIdle
+func (p *securityEventPipeline) claimAbandoned(ctx context.Context) error {
+	start := "0-0"
+	for {
+		messages, next, err := p.redis.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   p.config.Stream,
+			Group:    p.config.Group,
+			Consumer: p.consumer,
+			MinIdle:  p.config.ClaimIdle,
+			Start:    start,
+			Count:    int64(p.config.BatchSize),
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(messages) > 0 {
+			log.Printf("Security event writer claimed %d abandoned events", len(messages))
+			if err := p.write(ctx, messages); err != nil {
+				return err
+			}
+		}
+		if next == "0-0" || next == start {
+			return nil
+		}
+		start = next
+	}
+}
+
+// write inserts a batch, then acknowledges and deletes it. Malformed entries are dropped so
+// they cannot block the stream.
+func (p *securityEventPipeline) write(ctx context.Context, messages []redis.XMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	events := make([]securityEvent, 0, len(messages))
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+		payload, _ := message.Values["event"].(string)
+		var event securityEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil || event.ID == uuid.Nil {
+			securityEventsWritten.WithLabelValues("malformed").Inc()
+			log.Printf("Dropping malformed security event %s", message.ID)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if len(events) > 0 {
+		if err := p.insert(ctx, events); err != nil {
+			securityEventsWritten.WithLabelValues("failed").Add(float64(len(events)))
+			return fmt.Errorf("writing %d events: %w", len(events), err)
+		}
+		securityEventsWritten.WithLabelValues("written").Add(float64(len(events)))
+	}
+
+	pipe := p.redis.Pipeline()
+	pipe.XAck(ctx, p.config.Stream, p.config.Group, ids...)
+	pipe.XDel(ctx, p.config.Stream, ids...)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// listSecurityEvents returns the newest events, optionally for one user and of one type
+func (as *AuthService) listSecurityEvents(ctx context.Context, userID *uuid.UUID, eventType string, limit int) ([]securityEvent, error) {
+	rows, err := as.db.QueryContext(ctx, `
+		SELECT id, user_id, event_type, ip_address, user_agent, details, created_at
+		FROM security_events
+		WHERE ($1::uuid IS NULL OR user_id = $1) AND ($2 = '' OR event_type = $2)
+		ORDER BY created_at DESC
+		LIMIT $3`, userID, eventType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []securityEvent{}
+	for rows.Next() {
+		var event securityEvent
+		var details []byte
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.IPAddress, &event.UserAgent, &details, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(details, &event.Details)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// securityEventLimit reads ?limit, defaulting to 50 and capped at 500
+func securityEventLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		return 50
+	}
+	if limit > 500 {
+		return 500
+	}
+	return limit
+}
+
+// GetSecurityEvents lists the caller's own security events, newest first
+func (as *AuthService) GetSecurityEvents(c *gin.Context) {
+	value, _ := c.Get("user_id")
+	userID, err := uuid.Parse(fmt.Sprint(value))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	events, err := as.listSecurityEvents(c.Request.Context(), &userID, c.Query("type"), securityEventLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// GetAllSecurityEvents lists security events across users, filtered by ?user_id and ?type
+func (as *AuthService) GetAllSecurityEvents(c *gin.Context) {
+	var userID *uuid.UUID
+	if value := c.Query("user_id"); value != "" {
+		parsed, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		userID = &parsed
+	}
+	events, err := as.listSecurityEvents(c.Request.Context(), userID, c.Query("type"), securityEventLimit(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list security events"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
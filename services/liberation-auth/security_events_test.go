@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type SecurityEventTestSuite struct {
+	suite.Suite
+	server *miniredis.Miniredis
+	redis  *redis.Client
+
+	mu      sync.Mutex
+	written map[uuid.UUID]int
+	failing bool
+}
+
+func (suite *SecurityEventTestSuite) SetupTest() {
+	suite.server = miniredis.RunT(suite.T())
+	suite.redis = redis.NewClient(&redis.Options{Addr: suite.server.Addr()})
+	suite.written = map[uuid.UUID]int{}
+	suite.failing = false
+}
+
+func (suite *SecurityEventTestSuite) TearDownTest() {
+	suite.redis.Close()
+}
+
+// pipeline builds a writer whose sink counts inserts per event ID
+func (suite *SecurityEventTestSuite) pipeline(consumer string) *securityEventPipeline {
+	return &securityEventPipeline{
+		redis:    suite.redis,
+		consumer: consumer,
+		config: SecurityEventConfig{
+			Stream: "security_events", Group: "security-event-writers",
+			BatchSize: 10, HighWater: 4, ClaimIdle: 20 * time.Millisecond, Block: 10 * time.Millisecond,
+		},
+		insert: func(ctx context.Context, events []securityEvent) error {
+			suite.mu.Lock()
+			defer suite.mu.Unlock()
+			if suite.failing {
+				return errors.New("database unavailable")
+			}
+			for _, event := range events {
+				suite.written[event.ID]++
+			}
+			return nil
+		},
+	}
+}
+
+func (suite *SecurityEventTestSuite) writtenCount() int {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	return len(suite.written)
+}
+
+func (suite *SecurityEventTestSuite) publish(p *securityEventPipeline, n int) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, n)
+	for i := 0; i < n; i++ {
+		event := securityEvent{ID: uuid.New(), Type: securityEventLoginFailed, CreatedAt: time.Now()}
+		suite.Require().NoError(p.publish(context.Background(), event))
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+// runUntil runs the writer until cond holds or a second passes
+func (suite *SecurityEventTestSuite) runUntil(p *securityEventPipeline, cond func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	suite.Eventually(cond, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func (suite *SecurityEventTestSuite) TestEventsReachTheSinkAndLeaveTheStream() {
+	writer := suite.pipeline("a")
+	ids := suite.publish(writer, 3)
+
+	suite.runUntil(writer, func() bool { return suite.writtenCount() == 3 })
+	for _, id := range ids {
+		suite.Equal(1, suite.written[id])
+	}
+	suite.Equal(int64(0), suite.redis.XLen(context.Background(), "security_events").Val())
+}
+
+func (suite *SecurityEventTestSuite) TestFailedWritesAreRetried() {
+	writer := suite.pipeline("a")
+	suite.publish(writer, 2)
+	suite.failing = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		writer.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	suite.Equal(0, suite.writtenCount())
+	suite.Equal(int64(2), suite.redis.XLen(context.Background(), "security_events").Val())
+
+	suite.mu.Lock()
+	suite.failing = false
+	suite.mu.Unlock()
+	suite.Eventually(func() bool { return suite.writtenCount() == 2 }, 3*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func (suite *SecurityEventTestSuite) TestPendingEventsAreReplayedAfterRestart() {
+	writer := suite.pipeline("a")
+	ctx := context.Background()
+	suite.Require().NoError(suite.redis.XGroupCreateMkStream(ctx, "security_events", "security-event-writers", "0").Err())
+	ids := suite.publish(writer, 2)
+
+	// The previous process read the entries and died before writing them
+	suite.Require().NoError(suite.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "security-event-writers", Consumer: "a", Streams: []string{"security_events", ">"}, Block: -1,
+	}).Err())
+
+	suite.runUntil(writer, func() bool { return suite.writtenCount() == 2 })
+	suite.Equal(1, suite.written[ids[0]])
+}
+
+func (suite *SecurityEventTestSuite) TestAbandonedEventsAreClaimed() {
+	ctx := context.Background()
+	suite.Require().NoError(suite.redis.XGroupCreateMkStream(ctx, "security_events", "security-event-writers", "0").Err())
+	suite.publish(suite.pipeline("gone"), 2)
+	suite.Require().NoError(suite.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "security-event-writers", Consumer: "gone", Streams: []string{"security_events", ">"}, Block: -1,
+	}).Err())
+	time.Sleep(30 * time.Millisecond)
+
+	suite.runUntil(suite.pipeline("b"), func() bool { return suite.writtenCount() == 2 })
+}
+
+func (suite *SecurityEventTestSuite) TestMalformedEntriesAreDropped() {
+	writer := suite.pipeline("a")
+	ctx := context.Background()
+	suite.Require().NoError(suite.redis.XAdd(ctx, &redis.XAddArgs{Stream: "security_events", Values: map[string]interface{}{"event": "{not json"}}).Err())
+	suite.publish(writer, 1)
+
+	suite.runUntil(writer, func() bool {
+		return suite.writtenCount() == 1 && suite.redis.XLen(ctx, "security_events").Val() == 0
+	})
+}
+
+func (suite *SecurityEventTestSuite) TestBackpressureSwitchesToInlineWrites() {
+	writer := suite.pipeline("a")
+	suite.publish(writer, 3)
+	suite.False(writer.backedUp.Load())
+	suite.publish(writer, 1)
+	suite.True(writer.backedUp.Load())
+
+	// Backed up with no database to fall back on, the event is dropped rather than queued
+	as := &AuthService{events: writer}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/v1/auth/login", nil)
+	as.recordSecurityEvent(c, nil, securityEventLoginFailed, nil)
+	suite.Equal(int64(4), suite.redis.XLen(context.Background(), "security_events").Val())
+
+	writer.observeBacklog(3)
+	suite.True(writer.backedUp.Load())
+	writer.observeBacklog(1)
+	suite.False(writer.backedUp.Load())
+}
+
+func TestSecurityEvents(t *testing.T) {
+	suite.Run(t, new(SecurityEventTestSuite))
+}
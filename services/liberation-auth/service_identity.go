@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"liberation-serviceauth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// serviceIdentityKey holds the calling service's name once its token is verified
+const serviceIdentityKey = "service_identity"
+
+var serviceCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_service_calls_total",
+	Help: "Requests authenticated with a service token, by calling service and outcome (accepted, rejected).",
+}, []string{"service", "outcome"})
+
+// DefaultServiceIdentityConfig reads SERVICE_IDENTITY_NAME, SERVICE_IDENTITY_PRIVATE_KEY_FILE,
+// SERVICE_IDENTITY_TRUSTED_KEYS and SERVICE_IDENTITY_TOKEN_TTL_SECONDS. Service tokens are
+// neither minted nor accepted unless a key is configured.
+func DefaultServiceIdentityConfig() serviceauth.Config {
+	config := serviceauth.Config{Name: "liberation-auth"}
+	config.ApplyEnv("SERVICE_IDENTITY_")
+	return config
+}
+
+// NewServiceIdentity loads the service keys; it returns nil when service identity is not configured
+func NewServiceIdentity(config serviceauth.Config) (*serviceauth.Identity, error) {
+	if !config.Enabled() {
+		return nil, nil
+	}
+	return serviceauth.New(config)
+}
+
+// ServiceIdentityMiddleware lets trusted services call the routes behind it with a service
+// token instead of an admin's JWT. Requests without a service token continue to the
+// usual authentication; invalid service tokens are rejected here.
+func ServiceIdentityMiddleware(authService *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authService.services == nil {
+			c.Next()
+			return
+		}
+
+		claims, err := authService.services.VerifyRequest(c.Request)
+		if errors.Is(err, serviceauth.ErrNotServiceToken) {
+			c.Next()
+			return
+		}
+		if err != nil {
+			log.Printf("Rejected service token on %s: %v", c.Request.URL.Path, err)
+			serviceCallsTotal.WithLabelValues("unknown", "rejected").Inc()
+			abortWithBearerError(c, http.StatusUnauthorized, bearerErrorInvalidToken, "Invalid service token", "")
+			return
+		}
+
+		serviceCallsTotal.WithLabelValues(claims.Issuer, "accepted").Inc()
+		c.Set(serviceIdentityKey, claims.Issuer)
+		c.Next()
+	}
+}
+
+// isServiceCall reports whether the request was authenticated with a service token
+func isServiceCall(c *gin.Context) bool {
+	_, ok := c.Get(serviceIdentityKey)
+	return ok
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"liberation-serviceauth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type ServiceIdentityTestSuite struct {
+	suite.Suite
+	ai     *serviceauth.Identity
+	router *gin.Engine
+}
+
+func (suite *ServiceIdentityTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	public, private, err := ed25519.GenerateKey(nil)
+	suite.Require().NoError(err)
+	suite.ai = serviceauth.NewIdentity("liberation-ai", private, nil)
+
+	authService := &AuthService{
+		services: serviceauth.NewIdentity("liberation-auth", nil, map[string]ed25519.PublicKey{"liberation-ai": public}),
+	}
+	suite.router = gin.New()
+	registerAdminRoutes(newRouteGroup(&suite.router.RouterGroup, authService).Group("/api/v1/auth/admin"), authService)
+	suite.router.GET("/admin/whoami", ServiceIdentityMiddleware(authService), JWTAuthMiddleware(authService), RequireRoleMiddleware("admin"),
+		func(c *gin.Context) { c.String(http.StatusOK, c.GetString(serviceIdentityKey)) })
+}
+
+func (suite *ServiceIdentityTestSuite) get(token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/admin/whoami", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	return w
+}
+
+func (suite *ServiceIdentityTestSuite) TestTrustedServiceActsAsAdmin() {
+	token, err := suite.ai.Mint("liberation-auth")
+	suite.Require().NoError(err)
+
+	w := suite.get(token)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("liberation-ai", w.Body.String())
+}
+
+func (suite *ServiceIdentityTestSuite) TestTokensForOtherServicesAreRejected() {
+	token, err := suite.ai.Mint("liberation-metrics")
+	suite.Require().NoError(err)
+
+	w := suite.get(token)
+	suite.Equal(http.StatusUnauthorized, w.Code)
+	suite.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token")
+}
+
+func (suite *ServiceIdentityTestSuite) TestRequestsWithoutServiceTokenNeedAnAdmin() {
+	suite.Equal(http.StatusUnauthorized, suite.get("").Code)
+}
+
+func (suite *ServiceIdentityTestSuite) TestDisabledWithoutKeys() {
+	suite.T().Setenv("SERVICE_IDENTITY_PRIVATE_KEY_FILE", "")
+	suite.T().Setenv("SERVICE_IDENTITY_TRUSTED_KEYS", "")
+	identity, err := NewServiceIdentity(DefaultServiceIdentityConfig())
+	suite.NoError(err)
+	suite.Nil(identity)
+}
+
+func TestServiceIdentityTestSuite(t *testing.T) {
+	suite.Run(t, new(ServiceIdentityTestSuite))
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Policies for a sign-in that would go over the session limit
+const (
+	sessionLimitReject      = "reject"
+	sessionLimitEvictOldest = "evict_oldest"
+)
+
+// sessionLimitRevokedReason marks refresh tokens revoked to make room for a newer session,
+// so a later refresh can tell the user why they were signed out
+const sessionLimitRevokedReason = "session_limit"
+
+// errSessionLimitReached is returned by admit under the reject policy
+var errSessionLimitReached = errors.New("too many active sessions")
+
+var sessionLimitOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_session_limit_total",
+	Help: "New sessions checked against the concurrency limit, by outcome (admitted, evicted, rejected).",
+}, []string{"outcome"})
+
+// SessionLimitConfig caps how many sessions a user can hold at once. A session is an active
+// refresh token; clients with an override in client_session_limits are counted on their own.
+type SessionLimitConfig struct {
+	// MaxSessions is the default limit; 0 turns it off for clients without an override
+	MaxSessions int
+	// Policy is reject or evict_oldest
+	Policy string
+}
+
+// DefaultSessionLimitConfig reads SESSION_LIMIT and SESSION_LIMIT_POLICY
+func DefaultSessionLimitConfig() (SessionLimitConfig, error) {
+	config := SessionLimitConfig{Policy: getEnv("SESSION_LIMIT_POLICY", sessionLimitEvictOldest)}
+	var err error
+	if config.MaxSessions, err = strconv.Atoi(getEnv("SESSION_LIMIT", "10")); err != nil || config.MaxSessions < 0 {
+		return config, fmt.Errorf("SESSION_LIMIT must be a non-negative integer")
+	}
+	if !validSessionLimitPolicy(config.Policy) {
+		return config, fmt.Errorf("SESSION_LIMIT_POLICY must be %s or %s", sessionLimitReject, sessionLimitEvictOldest)
+	}
+	return config, nil
+}
+
+func validSessionLimitPolicy(policy string) bool {
+	return policy == sessionLimitReject || policy == sessionLimitEvictOldest
+}
+
+// sessionLimit is the limit that applies to one client
+type sessionLimit struct {
+	MaxSessions int    `json:"max_sessions"`
+	Policy      string `json:"policy"`
+	// Override is set when the client has its own limit, which counts only its sessions
+	Override bool `json:"override"`
+}
+
+// activeSession is a refresh token counted against a limit
+type activeSession struct {
+	ID            uuid.UUID
+	AccessTokenID uuid.UUID
+	ClientID      uuid.UUID
+	CreatedAt     time.Time
+}
+
+// SessionLimitService holds users to a number of concurrent sessions
+type SessionLimitService struct {
+	as     *AuthService
+	config SessionLimitConfig
+}
+
+// NewSessionLimitService always returns a service: per-client overrides apply even when the
+// default limit is off
+func NewSessionLimitService(as *AuthService, config SessionLimitConfig) *SessionLimitService {
+	return &SessionLimitService{as: as, config: config}
+}
+
+// limitFor returns the client's override, or the default limit
+func (s *SessionLimitService) limitFor(ctx context.Context, clientID uuid.UUID) (sessionLimit, error) {
+	limit := sessionLimit{MaxSessions: s.config.MaxSessions, Policy: s.config.Policy}
+	err := s.as.db.QueryRowContext(ctx, `SELECT max_sessions, policy FROM client_session_limits WHERE client_id = $1`, clientID).
+		Scan(&limit.MaxSessions, &limit.Policy)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return limit, nil
+	case err != nil:
+		return limit, err
+	}
+	limit.Override = true
+	return limit, nil
+}
+
+// activeSessions lists a user's sessions that count against the client's limit, oldest first.
+// Refreshing rotates the refresh token, so "oldest" is the session least recently refreshed.
+func (s *SessionLimitService) activeSessions(ctx context.Context, userID, clientID uuid.UUID, limit sessionLimit) ([]activeSession, error) {
+	query := `
+		SELECT t.id, t.access_token_id, t.client_id, t.created_at FROM oauth_refresh_tokens t
+		WHERE t.user_id = $1 AND t.is_revoked = false AND t.expires_at > NOW()
+			AND NOT EXISTS (SELECT 1 FROM client_session_limits l WHERE l.client_id = t.client_id)
+		ORDER BY t.created_at`
+	args := []interface{}{userID}
+	if limit.Override {
+		query = `
+			SELECT id, access_token_id, client_id, created_at FROM oauth_refresh_tokens
+			WHERE user_id = $1 AND client_id = $2 AND is_revoked = false AND expires_at > NOW()
+			ORDER BY created_at`
+		args = append(args, clientID)
+	}
+	rows, err := s.as.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []activeSession
+	for rows.Next() {
+		var session activeSession
+		if err := rows.Scan(&session.ID, &session.AccessTokenID, &session.ClientID, &session.CreatedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// sessionsToEvict returns the oldest sessions that must go for one more to fit under max
+func sessionsToEvict(sessions []activeSession, max int) []activeSession {
+	if max <= 0 || len(sessions) < max {
+		return nil
+	}
+	return sessions[:len(sessions)-max+1]
+}
+
+// admit makes room for a new session before its tokens are issued. Under the reject policy
+// it returns errSessionLimitReached; otherwise it signs out the oldest sessions. Concurrent
+// sign-ins are not serialised, so a user can briefly hold one or two more than the limit.
+func (s *SessionLimitService) admit(c *gin.Context, userID, clientID uuid.UUID) error {
+	ctx := c.Request.Context()
+	limit, err := s.limitFor(ctx, clientID)
+	if err != nil || limit.MaxSessions == 0 {
+		return err
+	}
+	sessions, err := s.activeSessions(ctx, userID, clientID, limit)
+	if err != nil {
+		return err
+	}
+	evict := sessionsToEvict(sessions, limit.MaxSessions)
+	if len(evict) == 0 {
+		sessionLimitOutcomes.WithLabelValues("admitted").Inc()
+		return nil
+	}
+	if limit.Policy == sessionLimitReject {
+		sessionLimitOutcomes.WithLabelValues("rejected").Inc()
+		return errSessionLimitReached
+	}
+
+	refreshIDs := make([]uuid.UUID, len(evict))
+	accessIDs := make([]uuid.UUID, len(evict))
+	for i, session := range evict {
+		refreshIDs[i], accessIDs[i] = session.ID, session.AccessTokenID
+	}
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE oauth_refresh_tokens SET is_revoked = true, revoked_at = NOW(), revoked_reason = $2
+		WHERE id = ANY($1) AND is_revoked = false`, pq.Array(refreshIDs), sessionLimitRevokedReason); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE oauth_access_tokens SET is_revoked = true, revoked_at = NOW()
+		WHERE id = ANY($1) AND is_revoked = false`, pq.Array(accessIDs)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, session := range evict {
+		s.as.publishRevocation(RevocationEvent{Type: revocationToken, ID: session.AccessTokenID.String()})
+		s.as.recordSecurityEvent(c, &userID, securityEventSessionEvicted, map[string]interface{}{
+			"client_id":     session.ClientID.String(),
+			"signed_in_at":  session.CreatedAt.UTC().Format(time.RFC3339),
+			"session_limit": limit.MaxSessions,
+			"message":       fmt.Sprintf("Signed out because you signed in somewhere else and your account allows %d active sessions", limit.MaxSessions),
+		})
+	}
+	sessionLimitOutcomes.WithLabelValues("evicted").Add(float64(len(evict)))
+	log.Printf("Session limit: signed out %d session(s) of user %s to admit a new one", len(evict), userID)
+	return nil
+}
+
+// evictionNotice explains a refresh token that stopped working because it was evicted or its
+// device was signed out, or returns "" for tokens that are unknown or were revoked otherwise
+func (s *SessionLimitService) evictionNotice(ctx context.Context, token string, clientID uuid.UUID) string {
+	var reason sql.NullString
+	err := s.as.db.QueryRowContext(ctx, `
+		SELECT revoked_reason FROM oauth_refresh_tokens WHERE token = ANY($1) AND client_id = $2`,
+		pq.Array(s.as.tokenStorage.lookupValues(token)), clientID).Scan(&reason)
+	if err != nil {
+		return ""
+	}
+	switch reason.String {
+	case sessionLimitRevokedReason:
+		return "This session was signed out because the account signed in elsewhere and reached its limit of active sessions"
+	case deviceRevokedReason:
+		return "This device was signed out"
+	}
+	return ""
+}
+
+// AdminGetClientSessionLimit shows the limit a client's sign-ins are held to
+func (s *SessionLimitService) AdminGetClientSessionLimit(c *gin.Context) {
+	client, ok := s.as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+	limit, err := s.limitFor(c.Request.Context(), client.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session limit"})
+		return
+	}
+	c.JSON(http.StatusOK, limit)
+}
+
+// AdminPutClientSessionLimit gives a client its own limit, e.g. a higher one for service
+// accounts that hold a session per worker; max_sessions 0 exempts the client entirely
+func (s *SessionLimitService) AdminPutClientSessionLimit(c *gin.Context) {
+	client, ok := s.as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		MaxSessions *int   `json:"max_sessions" binding:"required"`
+		Policy      string `json:"policy"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_sessions is required"})
+		return
+	}
+	if *req.MaxSessions < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_sessions must not be negative"})
+		return
+	}
+	if req.Policy == "" {
+		req.Policy = s.config.Policy
+	}
+	if !validSessionLimitPolicy(req.Policy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "policy must be reject or evict_oldest"})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	_, err := s.as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO client_session_limits (client_id, max_sessions, policy, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (client_id)
+		DO UPDATE SET max_sessions = $2, policy = $3, updated_by = $4, updated_at = NOW()`,
+		client.ID, *req.MaxSessions, req.Policy, adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session limit"})
+		return
+	}
+	c.JSON(http.StatusOK, sessionLimit{MaxSessions: *req.MaxSessions, Policy: req.Policy, Override: true})
+}
+
+// AdminDeleteClientSessionLimit puts a client back under the default limit
+func (s *SessionLimitService) AdminDeleteClientSessionLimit(c *gin.Context) {
+	client, ok := s.as.adminOAuthClient(c)
+	if !ok {
+		return
+	}
+	if _, err := s.as.db.ExecContext(c.Request.Context(), `DELETE FROM client_session_limits WHERE client_id = $1`, client.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete session limit"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
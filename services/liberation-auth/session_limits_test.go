@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type SessionLimitTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SessionLimitTestSuite) TestOldestSessionsMakeRoom() {
+	start := time.Now().Add(-time.Hour)
+	sessions := make([]activeSession, 5)
+	for i := range sessions {
+		sessions[i] = activeSession{ID: uuid.New(), CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+	}
+
+	suite.Empty(sessionsToEvict(sessions, 6), "room for one more")
+	suite.Equal(sessions[:1], sessionsToEvict(sessions, 5), "at the limit the oldest goes")
+	suite.Equal(sessions[:3], sessionsToEvict(sessions, 3), "a lowered limit catches up in one go")
+	suite.Empty(sessionsToEvict(sessions, 0), "0 is no limit")
+	suite.Empty(sessionsToEvict(nil, 1))
+}
+
+func (suite *SessionLimitTestSuite) TestConfig() {
+	config, err := DefaultSessionLimitConfig()
+	suite.Require().NoError(err)
+	suite.Equal(SessionLimitConfig{MaxSessions: 10, Policy: sessionLimitEvictOldest}, config)
+
+	suite.T().Setenv("SESSION_LIMIT", "0")
+	suite.T().Setenv("SESSION_LIMIT_POLICY", "reject")
+	config, err = DefaultSessionLimitConfig()
+	suite.Require().NoError(err)
+	suite.Equal(SessionLimitConfig{MaxSessions: 0, Policy: sessionLimitReject}, config)
+
+	suite.T().Setenv("SESSION_LIMIT_POLICY", "evict_newest")
+	_, err = DefaultSessionLimitConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("SESSION_LIMIT_POLICY", "reject")
+	suite.T().Setenv("SESSION_LIMIT", "-1")
+	_, err = DefaultSessionLimitConfig()
+	suite.Error(err)
+}
+
+func TestSessionLimitTestSuite(t *testing.T) {
+	suite.Run(t, new(SessionLimitTestSuite))
+}
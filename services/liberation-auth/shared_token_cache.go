@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"liberation-cache"
+	"nuclear-ao3/shared/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+var tokenCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_token_cache_lookups_total",
+	Help: "Access token validations, by where the token was found: local_hit, redis_hit, miss, or redis_error when Redis failed and Postgres answered",
+}, []string{"result"})
+
+const sharedTokenCachePrefix = "auth:token_cache:"
+
+// sharedTokenGeneration counts revocations. A validation reads it before going to Postgres and
+// writes its result only if no revocation ran meanwhile, so a racing revocation cannot be undone.
+const sharedTokenGeneration = sharedTokenCachePrefix + "generation"
+
+// DefaultSharedTokenCacheTTL reads TOKEN_CACHE_REDIS_TTL (5m), how long a validated access
+// token is kept in Redis for every replica. Revocations delete entries at once.
+// 0 keeps validations in process only.
+func DefaultSharedTokenCacheTTL() (time.Duration, error) {
+	ttl, err := time.ParseDuration(getEnv("TOKEN_CACHE_REDIS_TTL", "5m"))
+	if err != nil {
+		return 0, fmt.Errorf("TOKEN_CACHE_REDIS_TTL must be a duration such as 5m: %w", err)
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("TOKEN_CACHE_REDIS_TTL must not be negative")
+	}
+	return ttl, nil
+}
+
+// sharedTokenCache keeps validated access tokens in Redis, so a token validated by one replica
+// is not looked up in Postgres by the others. Entries are keyed by the token's hash and indexed
+// by token ID, user and client, so every kind of revocation can find them.
+// A nil cache is valid and caches nothing.
+type sharedTokenCache struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+// newSharedTokenCache returns nil without Redis or when ttl is 0
+func newSharedTokenCache(rdb *redis.Client, ttl time.Duration) *sharedTokenCache {
+	if rdb == nil || ttl <= 0 {
+		return nil
+	}
+	return &sharedTokenCache{rdb: rdb, ttl: ttl}
+}
+
+func sharedTokenKey(token string) string {
+	return sharedTokenCachePrefix + tokenCacheKey(token)
+}
+
+func sharedTokenIndex(kind, id string) string {
+	return sharedTokenCachePrefix + kind + ":" + id
+}
+
+// get returns a cached token, or nil with a nil error on a miss. It also returns the
+// revocation generation, which a miss passes to put once the token is loaded.
+func (sc *sharedTokenCache) get(ctx context.Context, token string) (*models.OAuthAccessToken, int64, error) {
+	pipe := sc.rdb.Pipeline()
+	entry := pipe.Get(ctx, sharedTokenKey(token))
+	generation := pipe.Get(ctx, sharedTokenGeneration)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, err
+	}
+	current, err := generation.Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, err
+	}
+
+	data, err := entry.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, current, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	accessToken, err := cache.GobCodec[models.OAuthAccessToken]{}.Decode(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if time.Now().After(accessToken.ExpiresAt) {
+		return nil, current, nil
+	}
+	return &accessToken, current, nil
+}
+
+// put caches a token until the TTL passes or the token expires, whichever is first. Nothing is
+// written if a revocation ran since get returned generation, since the token may be revoked.
+func (sc *sharedTokenCache) put(ctx context.Context, token string, accessToken *models.OAuthAccessToken, generation int64) error {
+	ttl := sc.ttl
+	if untilExpiry := time.Until(accessToken.ExpiresAt); untilExpiry < ttl {
+		ttl = untilExpiry
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := cache.GobCodec[models.OAuthAccessToken]{}.Encode(*accessToken)
+	if err != nil {
+		return err
+	}
+
+	key := sharedTokenKey(token)
+	indexes := []string{
+		sharedTokenIndex("token", accessToken.ID.String()),
+		sharedTokenIndex("client", accessToken.ClientID.String()),
+	}
+	if accessToken.UserID != nil {
+		indexes = append(indexes, sharedTokenIndex("user", accessToken.UserID.String()))
+	}
+
+	err = sc.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, sharedTokenGeneration).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if current != generation {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, ttl)
+			for _, index := range indexes {
+				pipe.SAdd(ctx, index, key)
+				pipe.Expire(ctx, index, sc.ttl)
+			}
+			return nil
+		})
+		return err
+	}, sharedTokenGeneration)
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil
+	}
+	return err
+}
+
+// invalidate deletes every entry the event applies to. Sessions are not cached here.
+func (sc *sharedTokenCache) invalidate(ctx context.Context, event RevocationEvent) error {
+	if sc == nil {
+		return nil
+	}
+
+	var keys []string
+	var err error
+	switch event.Type {
+	case revocationToken:
+		keys, err = sc.dropIndex(ctx, sharedTokenIndex("token", event.ID))
+	case revocationClient:
+		keys, err = sc.dropIndex(ctx, sharedTokenIndex("client", event.ClientID))
+	case revocationUser:
+		keys, err = sc.dropIndex(ctx, sharedTokenIndex("user", event.UserID))
+	case revocationGrant:
+		keys, err = sc.rdb.SInter(ctx, sharedTokenIndex("user", event.UserID), sharedTokenIndex("client", event.ClientID)).Result()
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The generation moves even when nothing is cached yet, to stop validations in flight
+	pipe := sc.rdb.TxPipeline()
+	pipe.Incr(ctx, sharedTokenGeneration)
+	if len(keys) > 0 {
+		pipe.Del(ctx, keys...)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// dropIndex returns an index's members and deletes the index
+func (sc *sharedTokenCache) dropIndex(ctx context.Context, index string) ([]string, error) {
+	pipe := sc.rdb.TxPipeline()
+	members := pipe.SMembers(ctx, index)
+	pipe.Del(ctx, index)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return members.Val(), nil
+}
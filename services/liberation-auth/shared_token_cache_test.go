@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nuclear-ao3/shared/models"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/suite"
+)
+
+type SharedTokenCacheTestSuite struct {
+	suite.Suite
+	server *miniredis.Miniredis
+	rdb    *redis.Client
+	userID uuid.UUID
+	client uuid.UUID
+}
+
+func (suite *SharedTokenCacheTestSuite) SetupTest() {
+	suite.server = miniredis.RunT(suite.T())
+	suite.rdb = redis.NewClient(&redis.Options{Addr: suite.server.Addr()})
+	suite.T().Cleanup(func() { suite.rdb.Close() })
+	suite.userID = uuid.New()
+	suite.client = uuid.New()
+}
+
+// replica returns a service with its own in-process cache and the shared Redis tier, and no
+// database: a lookup that reaches Postgres panics
+func (suite *SharedTokenCacheTestSuite) replica() *AuthService {
+	return &AuthService{
+		tokenCache:   newTokenCache(time.Minute, 100),
+		sharedTokens: newSharedTokenCache(suite.rdb, 5*time.Minute),
+	}
+}
+
+func (suite *SharedTokenCacheTestSuite) cacheToken(as *AuthService, value string, userID *uuid.UUID, clientID uuid.UUID) *models.OAuthAccessToken {
+	token := &models.OAuthAccessToken{
+		ID:        uuid.New(),
+		Token:     value,
+		UserID:    userID,
+		ClientID:  clientID,
+		Scopes:    []string{"read"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	suite.Require().NoError(as.sharedTokens.put(context.Background(), value, token, suite.generation(as, value)))
+	return token
+}
+
+// generation returns what a validation that missed value would pass to put
+func (suite *SharedTokenCacheTestSuite) generation(as *AuthService, value string) int64 {
+	_, generation, err := as.sharedTokens.get(context.Background(), value)
+	suite.Require().NoError(err)
+	return generation
+}
+
+func (suite *SharedTokenCacheTestSuite) cached(as *AuthService, value string) bool {
+	token, _, err := as.sharedTokens.get(context.Background(), value)
+	suite.Require().NoError(err)
+	return token != nil
+}
+
+func tokenCacheLookupCount(result string) float64 {
+	families, _ := prometheus.DefaultGatherer.Gather()
+	for _, family := range families {
+		if family.GetName() != "liberation_auth_token_cache_lookups_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == result {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func (suite *SharedTokenCacheTestSuite) TestConfig() {
+	ttl, err := DefaultSharedTokenCacheTTL()
+	suite.Require().NoError(err)
+	suite.Equal(5*time.Minute, ttl)
+
+	suite.T().Setenv("TOKEN_CACHE_REDIS_TTL", "0")
+	ttl, err = DefaultSharedTokenCacheTTL()
+	suite.Require().NoError(err)
+	suite.Nil(newSharedTokenCache(suite.rdb, ttl))
+	suite.Nil(newSharedTokenCache(nil, time.Minute))
+	suite.NoError((*sharedTokenCache)(nil).invalidate(context.Background(), RevocationEvent{Type: revocationClient}))
+
+	for _, invalid := range []string{"soon", "-1m"} {
+		suite.T().Setenv("TOKEN_CACHE_REDIS_TTL", invalid)
+		_, err = DefaultSharedTokenCacheTTL()
+		suite.Error(err, invalid)
+	}
+}
+
+func (suite *SharedTokenCacheTestSuite) TestReplicasShareValidatedTokens() {
+	first, second := suite.replica(), suite.replica()
+	token := suite.cacheToken(first, "shared-token", &suite.userID, suite.client)
+	redisHits, localHits := tokenCacheLookupCount("redis_hit"), tokenCacheLookupCount("local_hit")
+
+	validated, scopes, err := second.validateAccessTokenScopes(context.Background(), "shared-token")
+	suite.Require().NoError(err)
+	suite.Equal(token.ID, validated.ID)
+	suite.True(scopes.has("read"))
+	suite.Equal(redisHits+1, tokenCacheLookupCount("redis_hit"))
+
+	// The Redis hit also warms the replica's own cache
+	_, _, err = second.validateAccessTokenScopes(context.Background(), "shared-token")
+	suite.Require().NoError(err)
+	suite.Equal(localHits+1, tokenCacheLookupCount("local_hit"))
+}
+
+func (suite *SharedTokenCacheTestSuite) TestEntriesAreKeyedByHashAndExpireWithTheToken() {
+	as := suite.replica()
+	token := suite.cacheToken(as, "raw-secret-token", &suite.userID, suite.client)
+
+	for _, key := range suite.server.Keys() {
+		suite.NotContains(key, "raw-secret-token")
+	}
+	suite.True(suite.server.Exists(sharedTokenKey("raw-secret-token")))
+	suite.Equal(5*time.Minute, suite.server.TTL(sharedTokenKey("raw-secret-token")))
+
+	token.ExpiresAt = time.Now().Add(time.Minute)
+	suite.Require().NoError(as.sharedTokens.put(context.Background(), "raw-secret-token", token, suite.generation(as, "raw-secret-token")))
+	suite.LessOrEqual(suite.server.TTL(sharedTokenKey("raw-secret-token")), time.Minute)
+
+	token.ExpiresAt = time.Now().Add(-time.Second)
+	suite.Require().NoError(as.sharedTokens.put(context.Background(), "expired-token", token, suite.generation(as, "expired-token")))
+	suite.False(suite.server.Exists(sharedTokenKey("expired-token")))
+}
+
+func (suite *SharedTokenCacheTestSuite) TestRevocationsDeleteSharedEntries() {
+	as := suite.replica()
+	otherClient, otherUser := uuid.New(), uuid.New()
+	revoked := suite.cacheToken(as, "revoked", &suite.userID, suite.client)
+	suite.cacheToken(as, "granted", &suite.userID, suite.client)
+	suite.cacheToken(as, "other-client", &suite.userID, otherClient)
+	suite.cacheToken(as, "machine", nil, otherClient)
+	suite.cacheToken(as, "other-user", &otherUser, uuid.New())
+
+	as.publishRevocation(RevocationEvent{Type: revocationToken, ID: revoked.ID.String()})
+	suite.False(suite.cached(as, "revoked"))
+	suite.True(suite.cached(as, "granted"))
+
+	as.publishRevocation(RevocationEvent{Type: revocationGrant, UserID: suite.userID.String(), ClientID: suite.client.String()})
+	suite.False(suite.cached(as, "granted"))
+	suite.True(suite.cached(as, "other-client"))
+
+	as.publishRevocation(RevocationEvent{Type: revocationClient, ClientID: otherClient.String()})
+	suite.False(suite.cached(as, "other-client"))
+	suite.False(suite.cached(as, "machine"))
+
+	as.publishRevocation(RevocationEvent{Type: revocationUser, UserID: otherUser.String()})
+	suite.False(suite.cached(as, "other-user"))
+	suite.False(suite.server.Exists(sharedTokenIndex("user", otherUser.String())))
+}
+
+func (suite *SharedTokenCacheTestSuite) TestValidationRacingARevocationIsNotCached() {
+	first, second := suite.replica(), suite.replica()
+	token := &models.OAuthAccessToken{
+		ID: uuid.New(), UserID: &suite.userID, ClientID: suite.client, ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	// The first replica misses and reads the token from Postgres while the second revokes it
+	generation := suite.generation(first, "raced")
+	second.publishRevocation(RevocationEvent{Type: revocationToken, ID: token.ID.String()})
+	suite.Require().NoError(first.sharedTokens.put(context.Background(), "raced", token, generation))
+	suite.False(suite.cached(first, "raced"))
+
+	// A validation that starts after the revocation is cached as usual
+	suite.Require().NoError(first.sharedTokens.put(context.Background(), "raced", token, suite.generation(first, "raced")))
+	suite.True(suite.cached(first, "raced"))
+}
+
+func (suite *SharedTokenCacheTestSuite) TestRedisErrorsFallBackToTheDatabase() {
+	as := suite.replica()
+	suite.server.Close()
+	before := tokenCacheLookupCount("redis_error")
+
+	// With no database either the lookup panics, which proves it went past Redis
+	suite.Panics(func() { as.validateAccessTokenScopes(context.Background(), "unknown") })
+	suite.Equal(before+1, tokenCacheLookupCount("redis_error"))
+}
+
+func TestSharedTokenCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(SharedTokenCacheTestSuite))
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// signOutEpochClaim carries the user's sign-out epoch in first-party JWTs. Each user has an
+// epoch, raised by "log out everywhere" and by password changes, and validation rejects
+// tokens issued at an earlier one. That revokes every stateless token of a user at once
+// with one integer per user, however many tokens are outstanding, instead of a denylist
+// entry per token.
+const signOutEpochClaim = "sep"
+
+var (
+	// errSignedOut rejects a token issued before the user's latest sign-out
+	errSignedOut = errors.New("token was issued before the user signed out everywhere")
+	// errTokenSubject rejects a token whose subject is not a user ID
+	errTokenSubject = errors.New("token subject is not a user ID")
+	// errSignOutEpochUnavailable means the user's epoch could not be read, so the token
+	// can be neither accepted nor rejected
+	errSignOutEpochUnavailable = errors.New("sign-out epoch unavailable")
+)
+
+type cachedEpoch struct {
+	epoch    int64
+	cachedAt time.Time
+}
+
+func (tc *tokenCache) getEpoch(userID uuid.UUID) (int64, bool) {
+	if tc == nil {
+		return 0, false
+	}
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	entry, ok := tc.epochs[userID]
+	if !ok || time.Since(entry.cachedAt) > tc.ttl {
+		return 0, false
+	}
+	return entry.epoch, true
+}
+
+// putEpoch caches an epoch read from the database. It never lowers a cached epoch, so a
+// read that raced a sign-out cannot bring signed-out tokens back.
+func (tc *tokenCache) putEpoch(userID uuid.UUID, epoch int64) {
+	if tc == nil {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if len(tc.epochs) >= tc.maxSize {
+		tc.evictStaleLocked()
+	}
+	if len(tc.epochs) < tc.maxSize {
+		tc.raiseEpochLocked(userID, epoch)
+	}
+}
+
+// raiseEpochLocked caches epoch unless a higher one is cached
+func (tc *tokenCache) raiseEpochLocked(userID uuid.UUID, epoch int64) {
+	if entry, ok := tc.epochs[userID]; !ok || entry.epoch <= epoch {
+		tc.epochs[userID] = cachedEpoch{epoch: epoch, cachedAt: time.Now()}
+	}
+}
+
+// invalidateEpochLocked applies a user revocation: one that signed the user out raises
+// their cached epoch, and any other drops it so it is read again
+func (tc *tokenCache) invalidateEpochLocked(event RevocationEvent) int {
+	userID, err := uuid.Parse(event.UserID)
+	if err != nil {
+		return 0
+	}
+	_, cached := tc.epochs[userID]
+	if event.Epoch > 0 {
+		tc.raiseEpochLocked(userID, event.Epoch)
+	} else {
+		delete(tc.epochs, userID)
+	}
+	if cached {
+		return 1
+	}
+	return 0
+}
+
+// signOutEpoch returns the user's current sign-out epoch
+func (as *AuthService) signOutEpoch(ctx context.Context, userID uuid.UUID) (int64, error) {
+	if epoch, ok := as.tokenCache.getEpoch(userID); ok {
+		return epoch, nil
+	}
+	var epoch int64
+	err := as.db.QueryRowContext(ctx, `SELECT signout_epoch FROM users WHERE id = $1`, userID).Scan(&epoch)
+	if err == sql.ErrNoRows {
+		// Deleted users have no tokens worth accepting
+		return 0, errSignedOut
+	}
+	if err != nil {
+		return 0, errSignOutEpochUnavailable
+	}
+	as.tokenCache.putEpoch(userID, epoch)
+	return epoch, nil
+}
+
+// issueUserToken issues a first-party JWT for a user at their current sign-out epoch.
+// extra carries any further private claims.
+func (as *AuthService) issueUserToken(ctx context.Context, userID uuid.UUID, expiresIn time.Duration, extra map[string]interface{}) (string, error) {
+	epoch, err := as.signOutEpoch(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	claims := map[string]interface{}{signOutEpochClaim: epoch}
+	for name, value := range extra {
+		claims[name] = value
+	}
+	return as.jwt.GenerateTokenWithClaims(userID, as.audience.firstParty(), []string{"user"}, expiresIn, claims)
+}
+
+// validateUserJWT validates a user's JWT for audience and rejects it when the user has
+// signed out everywhere since it was issued
+func (as *AuthService) validateUserJWT(ctx context.Context, token, audience string) (*TokenClaims, uuid.UUID, error) {
+	claims, err := as.jwt.ValidateTokenForAudience(token, audience)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, uuid.Nil, errTokenSubject
+	}
+	epoch, err := as.signOutEpoch(ctx, userID)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	if claims.SignOutEpoch < epoch {
+		return nil, uuid.Nil, errSignedOut
+	}
+	return claims, userID, nil
+}
+
+// abortWithJWTError answers a request whose JWT validateUserJWT rejected
+func abortWithJWTError(c *gin.Context, err error) {
+	if errors.Is(err, errSignOutEpochUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":             "temporarily_unavailable",
+			"error_description": "The access token cannot be checked right now; try again shortly",
+		})
+		c.Abort()
+		return
+	}
+	abortWithBearerError(c, http.StatusUnauthorized, bearerErrorInvalidToken, jwtDenialReason(err), "")
+}
+
+// bumpSignOutEpoch raises the user's sign-out epoch inside tx and returns the new epoch
+func bumpSignOutEpoch(ctx context.Context, tx *sql.Tx, userID uuid.UUID) (int64, error) {
+	var epoch int64
+	err := tx.QueryRowContext(ctx, `
+		UPDATE users SET signout_epoch = signout_epoch + 1, updated_at = NOW()
+		WHERE id = $1 RETURNING signout_epoch`, userID).Scan(&epoch)
+	return epoch, err
+}
+
+// setPassword replaces a user's password hash and signs them out everywhere, revoking their
+// OAuth tokens and raising their sign-out epoch, which it returns. Callers hand the epoch to
+// signedOutEverywhere.
+func (as *AuthService) setPassword(ctx context.Context, userID uuid.UUID, passwordHash string) (int64, error) {
+	tx, err := as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2`, passwordHash, userID); err != nil {
+		return 0, err
+	}
+	if err := revokeUserTokens(ctx, tx, userID); err != nil {
+		return 0, err
+	}
+	epoch, err := bumpSignOutEpoch(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return epoch, tx.Commit()
+}
+
+// signedOutEverywhere tells every replica about a raised epoch once its transaction has
+// committed, so the user's cached tokens and epoch are dropped everywhere
+func (as *AuthService) signedOutEverywhere(userID uuid.UUID, epoch int64) {
+	as.publishRevocation(RevocationEvent{Type: revocationUser, UserID: userID.String(), Epoch: epoch})
+}
+
+// LogoutEverywhere signs the caller out of every device: it raises their sign-out epoch,
+// which rejects every JWT issued before it, and revokes their OAuth tokens.
+//
+// The epoch only moves from the one the presented token was issued at, so replaying the
+// request with the same token, or racing it from two devices, signs out once; the loser
+// gets 401 like any other signed-out token. With keep_current the caller gets a fresh token
+// at the new epoch and stays signed in on this device.
+func (as *AuthService) LogoutEverywhere(c *gin.Context) {
+	var req struct {
+		KeepCurrent bool `json:"keep_current"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			return
+		}
+	}
+	userID := c.MustGet("user_id").(uuid.UUID)
+	claims, _ := c.Get("token_claims")
+	tokenClaims, ok := claims.(*TokenClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	tx, err := as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	defer tx.Rollback()
+
+	var epoch int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE users SET signout_epoch = signout_epoch + 1, updated_at = NOW()
+		WHERE id = $1 AND signout_epoch = $2 RETURNING signout_epoch`, userID, tokenClaims.SignOutEpoch).Scan(&epoch)
+	if err == sql.ErrNoRows {
+		abortWithBearerError(c, http.StatusUnauthorized, bearerErrorInvalidToken, jwtDenialReason(errSignedOut), "")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if err := revokeUserTokens(ctx, tx, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	as.signedOutEverywhere(userID, epoch)
+	as.recordSecurityEvent(c, &userID, securityEventSignedOutEverywhere, map[string]interface{}{"epoch": epoch, "kept_current": req.KeepCurrent})
+
+	response := gin.H{"message": "Signed out everywhere", "signout_epoch": epoch}
+	if req.KeepCurrent {
+		expiresIn := 30 * 24 * time.Hour
+		accessToken, err := as.issueUserToken(ctx, userID, expiresIn, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token_generation_failed"})
+			return
+		}
+		response["access_token"] = accessToken
+		response["token_type"] = "Bearer"
+		response["expires_at"] = time.Now().Add(expiresIn).Unix()
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type SignOutEpochTestSuite struct {
+	suite.Suite
+	as     *AuthService
+	userID uuid.UUID
+}
+
+// SetupTest caches the user's epoch, so nothing here needs the database
+func (suite *SignOutEpochTestSuite) SetupTest() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.Require().NoError(err)
+	suite.as = &AuthService{
+		jwt:        NewJWTManagerWithKey(key, "https://auth.example.org"),
+		audience:   AudienceConfig{FirstParty: "archive"},
+		tokenCache: newTokenCache(time.Minute, 100),
+	}
+	suite.userID = uuid.New()
+	suite.as.tokenCache.putEpoch(suite.userID, 3)
+}
+
+func (suite *SignOutEpochTestSuite) TestTokensCarryTheCurrentEpoch() {
+	token, err := suite.as.issueUserToken(context.Background(), suite.userID, time.Hour, map[string]interface{}{"correlation_id": "c-1"})
+	suite.Require().NoError(err)
+
+	claims, userID, err := suite.as.validateUserJWT(context.Background(), token, "archive")
+	suite.Require().NoError(err)
+	suite.Equal(suite.userID, userID)
+	suite.Equal(int64(3), claims.SignOutEpoch)
+	suite.Equal([]string{"user"}, claims.Scope)
+}
+
+func (suite *SignOutEpochTestSuite) TestSigningOutRejectsEarlierTokens() {
+	token, err := suite.as.issueUserToken(context.Background(), suite.userID, time.Hour, nil)
+	suite.Require().NoError(err)
+
+	suite.as.tokenCache.invalidate(RevocationEvent{Type: revocationUser, UserID: suite.userID.String(), Epoch: 4})
+	_, _, err = suite.as.validateUserJWT(context.Background(), token, "archive")
+	suite.ErrorIs(err, errSignedOut)
+	suite.Equal("The access token was signed out", jwtDenialReason(err))
+
+	fresh, err := suite.as.issueUserToken(context.Background(), suite.userID, time.Hour, nil)
+	suite.Require().NoError(err)
+	_, _, err = suite.as.validateUserJWT(context.Background(), fresh, "archive")
+	suite.NoError(err)
+}
+
+func (suite *SignOutEpochTestSuite) TestTokensWithoutEpochCountAsZero() {
+	legacy, err := suite.as.jwt.GenerateToken(suite.userID, "archive", []string{"user"}, time.Hour)
+	suite.Require().NoError(err)
+	claims, err := suite.as.jwt.ValidateToken(legacy)
+	suite.Require().NoError(err)
+	suite.Zero(claims.SignOutEpoch)
+
+	_, _, err = suite.as.validateUserJWT(context.Background(), legacy, "archive")
+	suite.ErrorIs(err, errSignedOut, "the user has signed out since epochs began")
+}
+
+func (suite *SignOutEpochTestSuite) TestCachedEpochNeverGoesBack() {
+	cache := suite.as.tokenCache
+	cache.invalidate(RevocationEvent{Type: revocationUser, UserID: suite.userID.String(), Epoch: 5})
+
+	// A read that started before the sign-out committed must not lower the epoch
+	cache.putEpoch(suite.userID, 4)
+	epoch, ok := cache.getEpoch(suite.userID)
+	suite.True(ok)
+	suite.Equal(int64(5), epoch)
+
+	// Most users never sign out, so epoch 0 is cached like any other
+	newcomer := uuid.New()
+	cache.putEpoch(newcomer, 0)
+	_, ok = cache.getEpoch(newcomer)
+	suite.True(ok)
+
+	// Other user revocations leave the epoch to be read again
+	cache.invalidate(RevocationEvent{Type: revocationUser, UserID: suite.userID.String()})
+	_, ok = cache.getEpoch(suite.userID)
+	suite.False(ok)
+}
+
+func TestSignOutEpochTestSuite(t *testing.T) {
+	suite.Run(t, new(SignOutEpochTestSuite))
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Screening actions for a signup, by rising risk
+const (
+	spamActionAllow   = "allow"
+	spamActionCaptcha = "captcha"
+	spamActionReview  = "review"
+)
+
+// Corpus labels
+const (
+	spamLabelSpam = "spam"
+	spamLabelHam  = "ham"
+)
+
+// Signup review states
+const (
+	signupReviewPending  = "pending"
+	signupReviewApproved = "approved"
+	signupReviewRejected = "rejected"
+)
+
+// spamScoringNeighbours is how many similar signups a score is computed from
+const spamScoringNeighbours = 10
+
+var spamScreeningsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_signup_spam_screenings_total",
+	Help: "Signups screened against the spam corpus, by outcome (allowed, captcha_required, captcha_passed, held, invited, error).",
+}, []string{"outcome"})
+
+var spamScoreHistogram = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "liberation_auth_signup_spam_score",
+	Help:    "Spam scores of screened signups.",
+	Buckets: []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+})
+
+// SpamScoringConfig controls screening of registrations against known spam in liberation-ai
+type SpamScoringConfig struct {
+	// URL is liberation-ai's base URL; screening is off without it
+	URL string
+	// Namespace holds the labelled signups that new ones are compared with
+	Namespace string
+	// APIKey is sent as X-API-Key, for liberation-ai deployments without service identity
+	APIKey string
+	// MinSimilarity ignores stored signups less similar than this
+	MinSimilarity float64
+	// CaptchaThreshold and ReviewThreshold are the scores from which a signup must solve a
+	// CAPTCHA, or is held for an admin to review
+	CaptchaThreshold float64
+	ReviewThreshold  float64
+	Timeout          time.Duration
+	Captcha          CaptchaConfig
+}
+
+// CaptchaConfig verifies CAPTCHA responses with a siteverify API, as offered by hCaptcha,
+// Cloudflare Turnstile and reCAPTCHA
+type CaptchaConfig struct {
+	VerifyURL string
+	Secret    string
+	// SiteKey is handed to the signup page so it can render the challenge
+	SiteKey string
+}
+
+// Enabled reports whether CAPTCHA responses can be verified
+func (cfg CaptchaConfig) Enabled() bool {
+	return cfg.VerifyURL != "" && cfg.Secret != ""
+}
+
+// DefaultSpamScoringConfig reads SPAM_SCORING_URL, SPAM_SCORING_NAMESPACE, SPAM_SCORING_API_KEY,
+// SPAM_SCORING_MIN_SIMILARITY, SPAM_SCORING_CAPTCHA_THRESHOLD, SPAM_SCORING_REVIEW_THRESHOLD,
+// SPAM_SCORING_TIMEOUT and the CAPTCHA_VERIFY_URL, CAPTCHA_SECRET and CAPTCHA_SITE_KEY settings
+func DefaultSpamScoringConfig() (SpamScoringConfig, error) {
+	config := SpamScoringConfig{
+		URL:       strings.TrimSuffix(getEnv("SPAM_SCORING_URL", ""), "/"),
+		Namespace: getEnv("SPAM_SCORING_NAMESPACE", "signup-spam"),
+		APIKey:    getEnv("SPAM_SCORING_API_KEY", ""),
+		Captcha: CaptchaConfig{
+			VerifyURL: getEnv("CAPTCHA_VERIFY_URL", ""),
+			Secret:    getEnv("CAPTCHA_SECRET", ""),
+			SiteKey:   getEnv("CAPTCHA_SITE_KEY", ""),
+		},
+	}
+	for name, setting := range map[string]struct {
+		target   *float64
+		fallback string
+	}{
+		"SPAM_SCORING_MIN_SIMILARITY":    {&config.MinSimilarity, "0.8"},
+		"SPAM_SCORING_CAPTCHA_THRESHOLD": {&config.CaptchaThreshold, "0.5"},
+		"SPAM_SCORING_REVIEW_THRESHOLD":  {&config.ReviewThreshold, "0.8"},
+	} {
+		value, err := strconv.ParseFloat(getEnv(name, setting.fallback), 64)
+		if err != nil || value < 0 || value > 1 {
+			return config, fmt.Errorf("%s must be a number between 0 and 1", name)
+		}
+		*setting.target = value
+	}
+	if config.CaptchaThreshold > config.ReviewThreshold {
+		return config, fmt.Errorf("SPAM_SCORING_CAPTCHA_THRESHOLD must not be above SPAM_SCORING_REVIEW_THRESHOLD")
+	}
+	var err error
+	if config.Timeout, err = time.ParseDuration(getEnv("SPAM_SCORING_TIMEOUT", "2s")); err != nil || config.Timeout <= 0 {
+		return config, fmt.Errorf("SPAM_SCORING_TIMEOUT must be a positive duration")
+	}
+	if config.URL != "" {
+		if parsed, err := url.Parse(config.URL); err != nil || parsed.Host == "" {
+			return config, fmt.Errorf("SPAM_SCORING_URL must be an absolute URL")
+		}
+	}
+	if (config.Captcha.VerifyURL == "") != (config.Captcha.Secret == "") {
+		return config, fmt.Errorf("CAPTCHA_VERIFY_URL and CAPTCHA_SECRET must be set together")
+	}
+	return config, nil
+}
+
+// SpamMatch is a stored signup that a new one resembles
+type SpamMatch struct {
+	ID         string  `json:"id"`
+	Label      string  `json:"label"`
+	Similarity float64 `json:"similarity"`
+}
+
+// SpamVerdict is the outcome of screening one signup
+type SpamVerdict struct {
+	// Score is the similarity-weighted share of close matches labelled spam, from 0 to 1
+	Score   float64     `json:"score"`
+	Action  string      `json:"action"`
+	Matches []SpamMatch `json:"matches,omitempty"`
+}
+
+// SpamService screens registrations against labelled signups in liberation-ai and keeps
+// that corpus up to date as admins decide on held signups
+type SpamService struct {
+	as     *AuthService
+	config SpamScoringConfig
+	client *http.Client
+}
+
+// NewSpamService returns nil when SPAM_SCORING_URL is not set. Calls to liberation-ai are
+// signed with the service identity when liberation-auth has one.
+func NewSpamService(as *AuthService, config SpamScoringConfig) *SpamService {
+	if config.URL == "" {
+		return nil
+	}
+	client := &http.Client{Timeout: config.Timeout}
+	if as.services != nil && as.services.CanMint() {
+		client.Transport = as.services.Transport("liberation-ai", nil)
+	}
+	return &SpamService{as: as, config: config, client: client}
+}
+
+// emailShape keeps an address's domain and the shape of its local part, e.g.
+// "aaaa.aaaaa99@example.com" for "john.smith42@example.com". Random-looking addresses from
+// signup bots share shapes, and the address itself never leaves liberation-auth.
+func emailShape(email string) string {
+	local, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	if !ok {
+		return ""
+	}
+	shape := []rune(local)
+	for i, r := range shape {
+		switch {
+		case unicode.IsLetter(r):
+			shape[i] = 'a'
+		case unicode.IsDigit(r):
+			shape[i] = '9'
+		}
+	}
+	return string(shape) + "@" + domain
+}
+
+// signupText is what a signup is embedded as, both when it is screened and when it is
+// added to the corpus
+func signupText(username, displayName, email string) string {
+	var b strings.Builder
+	if username != "" {
+		fmt.Fprintf(&b, "username: %s\n", username)
+	}
+	if displayName != "" && displayName != username {
+		fmt.Fprintf(&b, "display name: %s\n", displayName)
+	}
+	if shape := emailShape(email); shape != "" {
+		fmt.Fprintf(&b, "email: %s\n", shape)
+	}
+	return b.String()
+}
+
+// spamSearchResponse is the part of liberation-ai's search response scoring reads
+type spamSearchResponse struct {
+	Results []struct {
+		Vector struct {
+			ID       string                 `json:"id"`
+			Metadata map[string]interface{} `json:"metadata"`
+		} `json:"vector"`
+		Score float64 `json:"score"`
+	} `json:"results"`
+}
+
+// scoreMatches weighs the labelled matches at least minSimilarity alike. Matches without a
+// label are ignored, so a corpus of spam alone still scores 0 for unlike signups.
+func scoreMatches(response spamSearchResponse, minSimilarity float64) SpamVerdict {
+	var verdict SpamVerdict
+	var spam, total float64
+	for _, result := range response.Results {
+		label, _ := result.Vector.Metadata["label"].(string)
+		if result.Score < minSimilarity || (label != spamLabelSpam && label != spamLabelHam) {
+			continue
+		}
+		total += result.Score
+		if label == spamLabelSpam {
+			spam += result.Score
+		}
+		verdict.Matches = append(verdict.Matches, SpamMatch{ID: result.Vector.ID, Label: label, Similarity: result.Score})
+	}
+	if total > 0 {
+		verdict.Score = spam / total
+	}
+	return verdict
+}
+
+// decide picks the action for a score. Without a CAPTCHA provider, signups that would have
+// to solve one are held for review instead.
+func (cfg SpamScoringConfig) decide(score float64) string {
+	switch {
+	case score >= cfg.ReviewThreshold:
+		return spamActionReview
+	case score >= cfg.CaptchaThreshold && cfg.Captcha.Enabled():
+		return spamActionCaptcha
+	case score >= cfg.CaptchaThreshold:
+		return spamActionReview
+	}
+	return spamActionAllow
+}
+
+func (s *SpamService) authorize(req *http.Request) {
+	if s.config.APIKey != "" {
+		req.Header.Set("X-API-Key", s.config.APIKey)
+	}
+}
+
+// score compares a signup with the corpus
+func (s *SpamService) score(ctx context.Context, text string) (SpamVerdict, error) {
+	query := url.Values{
+		"q":         {text},
+		"namespace": {s.config.Namespace},
+		"limit":     {strconv.Itoa(spamScoringNeighbours)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.URL+"/v1/search?"+query.Encode(), nil)
+	if err != nil {
+		return SpamVerdict{}, err
+	}
+	s.authorize(req)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return SpamVerdict{}, err
+	}
+	defer resp.Body.Close()
+
+	// A corpus nobody has labelled anything in yet has nothing to compare with
+	if resp.StatusCode == http.StatusNotFound {
+		return SpamVerdict{Action: spamActionAllow}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return SpamVerdict{}, fmt.Errorf("liberation-ai search returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var response spamSearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return SpamVerdict{}, err
+	}
+	verdict := scoreMatches(response, s.config.MinSimilarity)
+	verdict.Action = s.config.decide(verdict.Score)
+	return verdict, nil
+}
+
+// verifyCaptcha checks a CAPTCHA response with the provider
+func (s *SpamService) verifyCaptcha(ctx context.Context, response, remoteIP string) bool {
+	form := url.Values{"secret": {s.config.Captcha.Secret}, "response": {response}, "remoteip": {remoteIP}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.Captcha.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// The provider is not a platform service, so it gets no service token
+	resp, err := (&http.Client{Timeout: s.config.Timeout}).Do(req)
+	if err != nil {
+		log.Printf("CAPTCHA verification failed: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool `json:"success"`
+	}
+	return resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&result) == nil && result.Success
+}
+
+// screenRegistration scores a signup before the account is created. It returns the verdict
+// when the signup must be held for review, and false when it has answered the request
+// because a CAPTCHA is required. Invited signups are not screened, and neither is anyone
+// when liberation-ai cannot be reached: screening fails open.
+func (s *SpamService) screenRegistration(c *gin.Context, text string, invited bool) (*SpamVerdict, bool) {
+	if s == nil {
+		return nil, true
+	}
+	if invited {
+		spamScreeningsTotal.WithLabelValues("invited").Inc()
+		return nil, true
+	}
+	verdict, err := s.score(c.Request.Context(), text)
+	if err != nil {
+		log.Printf("Spam scoring unavailable, allowing signup: %v", err)
+		spamScreeningsTotal.WithLabelValues("error").Inc()
+		return nil, true
+	}
+	spamScoreHistogram.Observe(verdict.Score)
+
+	switch verdict.Action {
+	case spamActionReview:
+		spamScreeningsTotal.WithLabelValues("held").Inc()
+		return &verdict, true
+	case spamActionCaptcha:
+		if token := c.GetHeader("X-Captcha-Token"); token != "" && s.verifyCaptcha(c.Request.Context(), token, c.ClientIP()) {
+			spamScreeningsTotal.WithLabelValues("captcha_passed").Inc()
+			return nil, true
+		}
+		spamScreeningsTotal.WithLabelValues("captcha_required").Inc()
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "captcha_required",
+			"error_description": "Solve the CAPTCHA and send its response in the X-Captcha-Token header",
+			"captcha_site_key":  s.config.Captcha.SiteKey,
+		})
+		return nil, false
+	}
+	spamScreeningsTotal.WithLabelValues("allowed").Inc()
+	return nil, true
+}
+
+// holdSignupForReview queues a new, inactive account for an admin, inside its registration
+func holdSignupForReview(ctx context.Context, tx *sql.Tx, userID uuid.UUID, verdict *SpamVerdict) error {
+	matches, err := json.Marshal(verdict.Matches)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO signup_reviews (user_id, score, matches, status, created_at)
+		VALUES ($1, $2, $3, $4, NOW())`, userID, verdict.Score, matches, signupReviewPending)
+	return err
+}
+
+// respondPendingReview answers a registration that was held: the account exists but gets
+// no tokens until an admin approves it
+func respondPendingReview(c *gin.Context, user interface{}) {
+	c.JSON(http.StatusAccepted, gin.H{
+		"user":              user,
+		"status":            "pending_review",
+		"error_description": "Your account will be reviewed by a moderator before you can sign in",
+	})
+}
+
+// pendingReview reports whether an inactive account is waiting for signup review
+func (s *SpamService) pendingReview(ctx context.Context, userID uuid.UUID) bool {
+	if s == nil {
+		return false
+	}
+	var pending bool
+	err := s.as.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM signup_reviews WHERE user_id = $1 AND status = $2)`,
+		userID, signupReviewPending).Scan(&pending)
+	return err == nil && pending
+}
+
+// spamCorpusDocumentID names a user's signup in the corpus, so relabelling replaces it
+func spamCorpusDocumentID(userID uuid.UUID) string {
+	return "signup-" + userID.String()
+}
+
+// label records an admin's verdict on a user's signup and adds the signup to the corpus,
+// so later signups like it are scored by it. The label is kept even if liberation-ai
+// cannot be reached; the returned error says the corpus was not updated.
+func (s *SpamService) label(ctx context.Context, userID uuid.UUID, label string, labelledBy interface{}) error {
+	var username, displayName, email string
+	err := s.as.db.QueryRowContext(ctx, `SELECT username, COALESCE(display_name, ''), email FROM users WHERE id = $1`, userID).
+		Scan(&username, &displayName, s.as.pii.scan(piiUserEmail, &email))
+	if err != nil {
+		return err
+	}
+	if _, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO signup_spam_labels (user_id, label, labelled_by, labelled_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET label = $2, labelled_by = $3, labelled_at = NOW()`, userID, label, labelledBy); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal([]map[string]interface{}{{
+		"id":       spamCorpusDocumentID(userID),
+		"content":  signupText(username, displayName, email),
+		"metadata": map[string]interface{}{"label": label, "source": "liberation-auth"},
+	}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		s.config.URL+"/v1/documents?namespace="+url.QueryEscape(s.config.Namespace), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.authorize(req)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errSpamCorpusUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: liberation-ai returned %d", errSpamCorpusUnavailable, resp.StatusCode)
+	}
+	return nil
+}
+
+// errSpamCorpusUnavailable means a label was saved but could not be added to the corpus
+var errSpamCorpusUnavailable = errors.New("spam corpus could not be updated")
+
+// labelResponse reports a label that was saved, and whether it reached the corpus
+func labelResponse(c *gin.Context, body gin.H, err error) {
+	if errors.Is(err, errSpamCorpusUnavailable) {
+		log.Printf("Failed to update spam corpus: %v", err)
+		body["corpus_updated"] = false
+		c.JSON(http.StatusOK, body)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save label"})
+		return
+	}
+	body["corpus_updated"] = true
+	c.JSON(http.StatusOK, body)
+}
+
+// Admin API
+
+// AdminListSignupReviews lists held signups, pending ones by default
+func (s *SpamService) AdminListSignupReviews(c *gin.Context) {
+	status := c.DefaultQuery("status", signupReviewPending)
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `
+		SELECT r.user_id, u.username, COALESCE(u.display_name, ''), r.score, r.matches, r.status, r.decided_by, r.decided_at, r.created_at
+		FROM signup_reviews r JOIN users u ON u.id = r.user_id
+		WHERE r.status = $1
+		ORDER BY r.created_at
+		LIMIT 200`, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list signup reviews"})
+		return
+	}
+	defer rows.Close()
+
+	reviews := []gin.H{}
+	for rows.Next() {
+		var userID uuid.UUID
+		var username, displayName, reviewStatus string
+		var score float64
+		var matches json.RawMessage
+		var decidedBy *uuid.UUID
+		var decidedAt *time.Time
+		var createdAt time.Time
+		if err := rows.Scan(&userID, &username, &displayName, &score, &matches, &reviewStatus, &decidedBy, &decidedAt, &createdAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list signup reviews"})
+			return
+		}
+		reviews = append(reviews, gin.H{
+			"user_id": userID, "username": username, "display_name": displayName, "score": score,
+			"matches": matches, "status": reviewStatus, "decided_by": decidedBy, "decided_at": decidedAt, "created_at": createdAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"reviews": reviews})
+}
+
+// decideReview settles a pending review. Approving activates the account; rejecting leaves it
+// locked. Either way the signup is labelled for the corpus.
+func (s *SpamService) decideReview(c *gin.Context, status string) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	adminID, _ := c.Get("user_id")
+	ctx := c.Request.Context()
+
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide signup review"})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE signup_reviews SET status = $2, decided_by = $3, decided_at = NOW()
+		WHERE user_id = $1 AND status = $4`, userID, status, adminID, signupReviewPending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide signup review"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending signup review for this user"})
+		return
+	}
+	if status == signupReviewApproved {
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET is_active = true, updated_at = NOW() WHERE id = $1`, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate account"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide signup review"})
+		return
+	}
+
+	label := spamLabelSpam
+	if status == signupReviewApproved {
+		label = spamLabelHam
+	}
+	err = s.label(ctx, userID, label, adminID)
+	labelResponse(c, gin.H{"user_id": userID, "status": status, "label": label}, err)
+}
+
+func (s *SpamService) AdminApproveSignup(c *gin.Context) {
+	s.decideReview(c, signupReviewApproved)
+}
+
+func (s *SpamService) AdminRejectSignup(c *gin.Context) {
+	s.decideReview(c, signupReviewRejected)
+}
+
+// AdminLabelSignup labels any user's signup as spam or ham, e.g. a spammer found after
+// signing up unnoticed, or an account that was wrongly held
+func (s *SpamService) AdminLabelSignup(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req struct {
+		Label string `json:"label" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Label != spamLabelSpam && req.Label != spamLabelHam) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label must be spam or ham"})
+		return
+	}
+	adminID, _ := c.Get("user_id")
+	err = s.label(c.Request.Context(), userID, req.Label, adminID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	labelResponse(c, gin.H{"user_id": userID, "label": req.Label}, err)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type SpamScoringTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SpamScoringTestSuite) TestEmailShape() {
+	suite.Equal("aaaa.aaaaa99@example.com", emailShape("John.Smith42@Example.com"))
+	suite.Equal("aaaaaaaa9a9a9aa@mail.test", emailShape("xkqwzpro4h7b2mn@mail.test"))
+	suite.Empty(emailShape("not-an-address"))
+}
+
+func (suite *SpamScoringTestSuite) TestSignupText() {
+	suite.Equal("username: quill\nemail: aaaaa@example.com\n", signupText("quill", "quill", "quill@example.com"))
+	suite.Equal("username: quill\ndisplay name: Quill Writes\nemail: aaaaa@example.com\n",
+		signupText("quill", "Quill Writes", "quill@example.com"))
+	suite.Equal("email: aaaaa@example.com\n", signupText("", "", "quill@example.com"))
+	suite.NotContains(signupText("quill", "", "quill@example.com"), "quill@", "the address itself is never sent")
+}
+
+func spamSearchResults(results ...interface{}) spamSearchResponse {
+	var response spamSearchResponse
+	body, _ := json.Marshal(map[string]interface{}{"results": results})
+	_ = json.Unmarshal(body, &response)
+	return response
+}
+
+func spamResult(id, label string, score float64) map[string]interface{} {
+	metadata := map[string]interface{}{}
+	if label != "" {
+		metadata["label"] = label
+	}
+	return map[string]interface{}{"vector": map[string]interface{}{"id": id, "metadata": metadata}, "score": score}
+}
+
+func (suite *SpamScoringTestSuite) TestScoreMatches() {
+	verdict := scoreMatches(spamSearchResults(
+		spamResult("signup-1", spamLabelSpam, 0.9),
+		spamResult("signup-2", spamLabelHam, 0.9),
+		spamResult("signup-3", spamLabelSpam, 0.5),
+		spamResult("signup-4", "", 0.95),
+	), 0.8)
+	suite.InDelta(0.5, verdict.Score, 1e-9)
+	suite.Len(verdict.Matches, 2, "distant and unlabelled matches are ignored")
+
+	suite.Zero(scoreMatches(spamSearchResults(), 0.8).Score)
+	suite.Equal(1.0, scoreMatches(spamSearchResults(spamResult("signup-1", spamLabelSpam, 0.85)), 0.8).Score)
+}
+
+func (suite *SpamScoringTestSuite) TestDecide() {
+	config := SpamScoringConfig{CaptchaThreshold: 0.5, ReviewThreshold: 0.8}
+	suite.Equal(spamActionAllow, config.decide(0.2))
+	suite.Equal(spamActionReview, config.decide(0.6), "without a CAPTCHA provider the middle band is reviewed")
+	suite.Equal(spamActionReview, config.decide(0.9))
+
+	config.Captcha = CaptchaConfig{VerifyURL: "https://captcha.example/siteverify", Secret: "secret"}
+	suite.Equal(spamActionCaptcha, config.decide(0.6))
+	suite.Equal(spamActionReview, config.decide(0.8))
+}
+
+func (suite *SpamScoringTestSuite) TestConfig() {
+	config, err := DefaultSpamScoringConfig()
+	suite.Require().NoError(err)
+	suite.Empty(config.URL)
+	suite.Equal("signup-spam", config.Namespace)
+	suite.Equal(2*time.Second, config.Timeout)
+	suite.Nil(NewSpamService(&AuthService{}, config), "screening is off without a URL")
+
+	suite.T().Setenv("SPAM_SCORING_CAPTCHA_THRESHOLD", "0.9")
+	_, err = DefaultSpamScoringConfig()
+	suite.Error(err, "the CAPTCHA band must sit below the review band")
+
+	suite.T().Setenv("SPAM_SCORING_CAPTCHA_THRESHOLD", "")
+	suite.T().Setenv("SPAM_SCORING_REVIEW_THRESHOLD", "80")
+	_, err = DefaultSpamScoringConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("SPAM_SCORING_REVIEW_THRESHOLD", "")
+	suite.T().Setenv("CAPTCHA_VERIFY_URL", "https://captcha.example/siteverify")
+	_, err = DefaultSpamScoringConfig()
+	suite.Error(err, "a verify URL is useless without its secret")
+}
+
+// screen runs one registration through screenRegistration against a fake liberation-ai
+func (suite *SpamScoringTestSuite) screen(spam *SpamService, captchaToken string, invited bool) (*SpamVerdict, bool, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/auth/register", nil)
+	if captchaToken != "" {
+		c.Request.Header.Set("X-Captcha-Token", captchaToken)
+	}
+	verdict, ok := spam.screenRegistration(c, signupText("quill", "", "quill@example.com"), invited)
+	return verdict, ok, w
+}
+
+func (suite *SpamScoringTestSuite) TestScreenRegistration() {
+	var results []interface{}
+	status := http.StatusOK
+	ai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("/v1/search", r.URL.Path)
+		suite.Equal("signup-spam", r.URL.Query().Get("namespace"))
+		suite.Equal("ai-key", r.Header.Get("X-API-Key"))
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	defer ai.Close()
+	captcha := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]bool{"success": r.FormValue("response") == "solved" && r.FormValue("secret") == "captcha-secret"})
+	}))
+	defer captcha.Close()
+
+	spam := NewSpamService(&AuthService{}, SpamScoringConfig{
+		URL: ai.URL, Namespace: "signup-spam", APIKey: "ai-key",
+		MinSimilarity: 0.8, CaptchaThreshold: 0.5, ReviewThreshold: 0.8, Timeout: time.Second,
+		Captcha: CaptchaConfig{VerifyURL: captcha.URL, Secret: "captcha-secret", SiteKey: "site-key"},
+	})
+
+	verdict, ok, _ := suite.screen(spam, "", false)
+	suite.True(ok)
+	suite.Nil(verdict, "an empty corpus allows everyone")
+
+	results = []interface{}{spamResult("signup-1", spamLabelSpam, 0.9), spamResult("signup-2", spamLabelHam, 0.9)}
+	_, ok, w := suite.screen(spam, "", false)
+	suite.False(ok)
+	suite.Equal(http.StatusForbidden, w.Code)
+	suite.Contains(w.Body.String(), "captcha_required")
+	suite.Contains(w.Body.String(), "site-key")
+
+	verdict, ok, _ = suite.screen(spam, "solved", false)
+	suite.True(ok)
+	suite.Nil(verdict)
+	_, ok, _ = suite.screen(spam, "guessed", false)
+	suite.False(ok)
+
+	results = []interface{}{spamResult("signup-1", spamLabelSpam, 0.95)}
+	verdict, ok, _ = suite.screen(spam, "solved", false)
+	suite.True(ok)
+	suite.Require().NotNil(verdict, "a CAPTCHA does not get past the review band")
+	suite.Equal(spamActionReview, verdict.Action)
+	suite.Equal("signup-1", verdict.Matches[0].ID)
+
+	verdict, ok, _ = suite.screen(spam, "", true)
+	suite.True(ok)
+	suite.Nil(verdict, "invited signups are not screened")
+
+	status = http.StatusNotFound
+	verdict, ok, _ = suite.screen(spam, "", false)
+	suite.True(ok)
+	suite.Nil(verdict, "a namespace nobody has labelled into yet allows everyone")
+
+	status = http.StatusInternalServerError
+	verdict, ok, _ = suite.screen(spam, "", false)
+	suite.True(ok)
+	suite.Nil(verdict, "screening fails open")
+
+	verdict, ok, _ = suite.screen(nil, "", false)
+	suite.True(ok)
+	suite.Nil(verdict)
+}
+
+func TestSpamScoringTestSuite(t *testing.T) {
+	suite.Run(t, new(SpamScoringTestSuite))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var statsLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_stats_lookups_total",
+	Help: "User statistics lookups for the ao3_work_count and ao3_bookmark_count claims, by outcome (hit, fetched, stale, failed).",
+}, []string{"outcome"})
+
+// errStatsUnavailable means no statistics source is configured; the claims are left out
+var errStatsUnavailable = errors.New("user statistics are not available")
+
+// StatsProvider supplies the work and bookmark counts behind the AO3 profile claims. They
+// belong to the archive, not to liberation-auth, so where they come from is a deployment choice.
+type StatsProvider interface {
+	UserStats(ctx context.Context, userID uuid.UUID) (*UserStats, error)
+}
+
+// StatsConfig selects and tunes the statistics source
+type StatsConfig struct {
+	// Provider is "http", "database" or "none"
+	Provider string
+	// ServiceURL is the stats service base; GET {ServiceURL}/users/{id}/stats returns
+	// {"work_count": n, "bookmark_count": n}
+	ServiceURL string
+	// ServiceToken, if set, is sent as a bearer token
+	ServiceToken string
+	Timeout      time.Duration
+	CacheTTL     time.Duration
+	CacheSize    int
+}
+
+// DefaultStatsConfig reads STATS_PROVIDER, STATS_SERVICE_URL, STATS_SERVICE_TOKEN,
+// STATS_TIMEOUT and STATS_CACHE_TTL. Without STATS_PROVIDER, a configured STATS_SERVICE_URL
+// selects http and the archive's tables are queried directly otherwise.
+func DefaultStatsConfig() (StatsConfig, error) {
+	config := StatsConfig{
+		Provider:     getEnv("STATS_PROVIDER", ""),
+		ServiceURL:   strings.TrimSuffix(getEnv("STATS_SERVICE_URL", ""), "/"),
+		ServiceToken: getEnv("STATS_SERVICE_TOKEN", ""),
+		CacheSize:    10000,
+	}
+	if config.Provider == "" {
+		config.Provider = "database"
+		if config.ServiceURL != "" {
+			config.Provider = "http"
+		}
+	}
+	var err error
+	if config.Timeout, err = time.ParseDuration(getEnv("STATS_TIMEOUT", "500ms")); err != nil || config.Timeout <= 0 {
+		return config, fmt.Errorf("STATS_TIMEOUT must be a positive duration")
+	}
+	if config.CacheTTL, err = time.ParseDuration(getEnv("STATS_CACHE_TTL", "5m")); err != nil || config.CacheTTL < 0 {
+		return config, fmt.Errorf("STATS_CACHE_TTL must be a non-negative duration")
+	}
+	switch config.Provider {
+	case "none", "database":
+	case "http":
+		if parsed, err := url.Parse(config.ServiceURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return config, fmt.Errorf("STATS_PROVIDER=http needs STATS_SERVICE_URL to be an http(s) URL")
+		}
+	default:
+		return config, fmt.Errorf("STATS_PROVIDER must be http, database or none")
+	}
+	return config, nil
+}
+
+// NewStatsProvider builds the configured provider; "none" returns nil
+func NewStatsProvider(config StatsConfig, db *sql.DB) StatsProvider {
+	switch config.Provider {
+	case "http":
+		return newHTTPStatsProvider(config)
+	case "database":
+		return &databaseStatsProvider{db: db}
+	}
+	return nil
+}
+
+// getUserStats returns the user's statistics, or errStatsUnavailable without a provider
+func (as *AuthService) getUserStats(ctx context.Context, userID uuid.UUID) (*UserStats, error) {
+	if as.stats == nil {
+		return nil, errStatsUnavailable
+	}
+	return as.stats.UserStats(ctx, userID)
+}
+
+// databaseStatsProvider counts rows in the archive's works and bookmarks tables. It only
+// works when liberation-auth shares the archive's database.
+type databaseStatsProvider struct {
+	db *sql.DB
+}
+
+func (p *databaseStatsProvider) UserStats(ctx context.Context, userID uuid.UUID) (*UserStats, error) {
+	stats := &UserStats{}
+	err := p.db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM works WHERE user_id = $1 AND status = 'posted'),
+			(SELECT COUNT(*) FROM bookmarks WHERE user_id = $1)`, userID).Scan(&stats.WorkCount, &stats.BookmarkCount)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// httpStatsProvider asks the archive's stats service and caches the answers. When the
+// service is slow or down, the last answer is served past its TTL rather than nothing.
+type httpStatsProvider struct {
+	config StatsConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[uuid.UUID]cachedStats
+}
+
+type cachedStats struct {
+	stats     UserStats
+	fetchedAt time.Time
+}
+
+func newHTTPStatsProvider(config StatsConfig) *httpStatsProvider {
+	return &httpStatsProvider{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		cache:  make(map[uuid.UUID]cachedStats),
+	}
+}
+
+func (p *httpStatsProvider) UserStats(ctx context.Context, userID uuid.UUID) (*UserStats, error) {
+	p.mu.Lock()
+	cached, ok := p.cache[userID]
+	p.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < p.config.CacheTTL {
+		statsLookups.WithLabelValues("hit").Inc()
+		return &cached.stats, nil
+	}
+
+	stats, err := p.fetch(ctx, userID)
+	if err != nil {
+		if ok {
+			statsLookups.WithLabelValues("stale").Inc()
+			return &cached.stats, nil
+		}
+		statsLookups.WithLabelValues("failed").Inc()
+		return nil, err
+	}
+	statsLookups.WithLabelValues("fetched").Inc()
+
+	if p.config.CacheTTL > 0 {
+		p.mu.Lock()
+		if len(p.cache) >= p.config.CacheSize {
+			p.evictLocked()
+		}
+		p.cache[userID] = cachedStats{stats: *stats, fetchedAt: time.Now()}
+		p.mu.Unlock()
+	}
+	return stats, nil
+}
+
+// evictLocked drops expired entries, or everything if none have expired yet
+func (p *httpStatsProvider) evictLocked() {
+	for userID, entry := range p.cache {
+		if time.Since(entry.fetchedAt) >= p.config.CacheTTL {
+			delete(p.cache, userID)
+		}
+	}
+	if len(p.cache) >= p.config.CacheSize {
+		p.cache = make(map[uuid.UUID]cachedStats)
+	}
+}
+
+func (p *httpStatsProvider) fetch(ctx context.Context, userID uuid.UUID) (*UserStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.ServiceURL+"/users/"+userID.String()+"/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.config.ServiceToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.ServiceToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stats service returned %s", resp.Status)
+	}
+	var stats UserStats
+	if err := json.NewDecoder(http.MaxBytesReader(nil, resp.Body, 64<<10)).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("decoding stats: %w", err)
+	}
+	return &stats, nil
+}
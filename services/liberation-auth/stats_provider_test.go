@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type StatsProviderTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	calls    atomic.Int64
+	failing  atomic.Bool
+	slow     atomic.Bool
+	provider *httpStatsProvider
+	userID   uuid.UUID
+}
+
+func (suite *StatsProviderTestSuite) SetupTest() {
+	suite.calls.Store(0)
+	suite.failing.Store(false)
+	suite.slow.Store(false)
+	suite.userID = uuid.New()
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.calls.Add(1)
+		if r.Header.Get("Authorization") != "Bearer stats-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if suite.slow.Load() {
+			time.Sleep(200 * time.Millisecond)
+		}
+		if suite.failing.Load() || r.URL.Path != "/users/"+suite.userID.String()+"/stats" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"work_count": 12, "bookmark_count": 340}`))
+	}))
+	suite.provider = newHTTPStatsProvider(StatsConfig{
+		Provider: "http", ServiceURL: suite.server.URL, ServiceToken: "stats-token",
+		Timeout: 50 * time.Millisecond, CacheTTL: time.Minute, CacheSize: 10,
+	})
+}
+
+func (suite *StatsProviderTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *StatsProviderTestSuite) TestFetchesAndCaches() {
+	for i := 0; i < 3; i++ {
+		stats, err := suite.provider.UserStats(context.Background(), suite.userID)
+		suite.Require().NoError(err)
+		suite.Equal(UserStats{WorkCount: 12, BookmarkCount: 340}, *stats)
+	}
+	suite.Equal(int64(1), suite.calls.Load())
+}
+
+func (suite *StatsProviderTestSuite) TestServesStaleWhenServiceFails() {
+	suite.provider.config.CacheTTL = time.Millisecond
+	_, err := suite.provider.UserStats(context.Background(), suite.userID)
+	suite.Require().NoError(err)
+	time.Sleep(5 * time.Millisecond)
+
+	suite.failing.Store(true)
+	stats, err := suite.provider.UserStats(context.Background(), suite.userID)
+	suite.Require().NoError(err)
+	suite.Equal(12, stats.WorkCount)
+	suite.Equal(int64(2), suite.calls.Load())
+
+	_, err = suite.provider.UserStats(context.Background(), uuid.New())
+	suite.Error(err)
+}
+
+func (suite *StatsProviderTestSuite) TestSlowServiceTimesOut() {
+	suite.slow.Store(true)
+	started := time.Now()
+	_, err := suite.provider.UserStats(context.Background(), suite.userID)
+	suite.Error(err)
+	suite.Less(time.Since(started), 150*time.Millisecond)
+}
+
+func (suite *StatsProviderTestSuite) TestCacheStaysBounded() {
+	suite.provider.config.CacheSize = 2
+	for i := 0; i < 5; i++ {
+		suite.provider.cache[uuid.New()] = cachedStats{fetchedAt: time.Now()}
+		if len(suite.provider.cache) >= 2 {
+			suite.provider.evictLocked()
+		}
+	}
+	suite.LessOrEqual(len(suite.provider.cache), 2)
+}
+
+func (suite *StatsProviderTestSuite) TestConfig() {
+	suite.T().Setenv("STATS_PROVIDER", "")
+	suite.T().Setenv("STATS_SERVICE_URL", "")
+	config, err := DefaultStatsConfig()
+	suite.Require().NoError(err)
+	suite.Equal("database", config.Provider)
+
+	suite.T().Setenv("STATS_SERVICE_URL", "https://archive.example.com/api/")
+	config, err = DefaultStatsConfig()
+	suite.Require().NoError(err)
+	suite.Equal("http", config.Provider)
+	suite.Equal("https://archive.example.com/api", config.ServiceURL)
+
+	suite.T().Setenv("STATS_SERVICE_URL", "archive:8080")
+	_, err = DefaultStatsConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("STATS_PROVIDER", "none")
+	config, err = DefaultStatsConfig()
+	suite.Require().NoError(err)
+	suite.Nil(NewStatsProvider(config, nil))
+
+	_, err = (&AuthService{}).getUserStats(context.Background(), suite.userID)
+	suite.ErrorIs(err, errStatsUnavailable)
+}
+
+func TestStatsProvider(t *testing.T) {
+	suite.Run(t, new(StatsProviderTestSuite))
+}
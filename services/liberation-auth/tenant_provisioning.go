@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Provisioning states of a tenant in tenant_provisioning
+const (
+	// provisioningPending tenants still need their namespace and key in liberation-ai
+	provisioningPending     = "pending"
+	provisioningProvisioned = "provisioned"
+	// provisioningDeprovisioning tenants were deleted here and still need tearing down there
+	provisioningDeprovisioning = "deprovisioning"
+)
+
+var tenantProvisioningCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_tenant_provisioning_total",
+	Help: "Provisioning calls to liberation-ai, by action (provision, rotate_key, deprovision) and outcome (ok, failed).",
+}, []string{"action", "outcome"})
+
+// ProvisioningConfig connects tenants to liberation-ai, which gives each one a namespace and
+// an API key
+type ProvisioningConfig struct {
+	// AIURL is liberation-ai's base URL; empty leaves provisioning off
+	AIURL   string
+	Timeout time.Duration
+	// ReconcileInterval is how often tenants are compared with liberation-ai's and drift repaired
+	ReconcileInterval time.Duration
+	// KeepData leaves a deleted tenant's namespaces in liberation-ai and only revokes its key
+	KeepData bool
+}
+
+// DefaultProvisioningConfig reads TENANT_PROVISIONING_AI_URL, TENANT_PROVISIONING_TIMEOUT,
+// TENANT_PROVISIONING_RECONCILE_INTERVAL and TENANT_PROVISIONING_KEEP_DATA
+func DefaultProvisioningConfig() (ProvisioningConfig, error) {
+	config := ProvisioningConfig{
+		AIURL:    strings.TrimSuffix(getEnv("TENANT_PROVISIONING_AI_URL", ""), "/"),
+		KeepData: getEnv("TENANT_PROVISIONING_KEEP_DATA", "false") == "true",
+	}
+	if config.AIURL != "" {
+		parsed, err := url.Parse(config.AIURL)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return config, fmt.Errorf("TENANT_PROVISIONING_AI_URL must be liberation-ai's http(s) URL")
+		}
+	}
+	var err error
+	if config.Timeout, err = time.ParseDuration(getEnv("TENANT_PROVISIONING_TIMEOUT", "10s")); err != nil || config.Timeout <= 0 {
+		return config, fmt.Errorf("TENANT_PROVISIONING_TIMEOUT must be a positive duration")
+	}
+	if config.ReconcileInterval, err = time.ParseDuration(getEnv("TENANT_PROVISIONING_RECONCILE_INTERVAL", "15m")); err != nil || config.ReconcileInterval < time.Minute {
+		return config, fmt.Errorf("TENANT_PROVISIONING_RECONCILE_INTERVAL must be at least 1m")
+	}
+	return config, nil
+}
+
+// ProvisioningService provisions tenants in liberation-ai as they are created and tears them
+// down as they are deleted. Calls are signed with the service identity. A call that fails
+// leaves the tenant pending, and the reconcile job retries it.
+type ProvisioningService struct {
+	as     *AuthService
+	config ProvisioningConfig
+	client *http.Client
+}
+
+// NewProvisioningService returns nil when provisioning is off. It needs a service identity
+// that can sign calls to liberation-ai.
+func NewProvisioningService(as *AuthService, config ProvisioningConfig) (*ProvisioningService, error) {
+	if config.AIURL == "" {
+		return nil, nil
+	}
+	if as.services == nil || !as.services.CanMint() {
+		return nil, fmt.Errorf("TENANT_PROVISIONING_AI_URL needs SERVICE_IDENTITY_PRIVATE_KEY_FILE to sign calls to liberation-ai")
+	}
+	client := &http.Client{Timeout: config.Timeout, Transport: as.services.Transport("liberation-ai", nil)}
+	return &ProvisioningService{as: as, config: config, client: client}, nil
+}
+
+// aiTenant is a tenant as liberation-ai's provisioning API describes it
+type aiTenant struct {
+	Tenant    string `json:"tenant"`
+	Namespace string `json:"namespace"`
+	KeyHint   string `json:"key_hint"`
+}
+
+// TenantProvisioning is a tenant's provisioning state. APIKey is only set in the response
+// that minted it.
+type TenantProvisioning struct {
+	TenantID      string     `json:"tenant_id"`
+	State         string     `json:"state"`
+	Namespace     string     `json:"namespace,omitempty"`
+	KeyHint       string     `json:"key_hint,omitempty"`
+	APIKey        string     `json:"api_key,omitempty"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	ProvisionedAt *time.Time `json:"provisioned_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// call sends a provisioning request to liberation-ai and decodes a 2xx answer into out
+func (s *ProvisioningService) call(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.config.AIURL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&failure)
+		return resp.StatusCode, fmt.Errorf("liberation-ai answered %s: %s", resp.Status, failure.Error)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("reading liberation-ai's answer: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// provision gives a tenant its namespace and key in liberation-ai, or a new key with rotate.
+// The key is returned in the result and is not stored here.
+func (s *ProvisioningService) provision(ctx context.Context, tenantID string, rotate bool) (*TenantProvisioning, error) {
+	action := "provision"
+	if rotate {
+		action = "rotate_key"
+	}
+	if _, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO tenant_provisioning (tenant_id, state) VALUES ($1, $2)
+		ON CONFLICT (tenant_id) DO UPDATE SET state = CASE
+			WHEN tenant_provisioning.state = $3 THEN $2 ELSE tenant_provisioning.state END`,
+		tenantID, provisioningPending, provisioningDeprovisioning); err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Tenant aiTenant `json:"tenant"`
+		APIKey string   `json:"api_key"`
+	}
+	_, err := s.call(ctx, http.MethodPut, "/v1/admin/provisioning/tenants/"+url.PathEscape(tenantID), map[string]bool{"rotate_key": rotate}, &result)
+	if err != nil {
+		tenantProvisioningCalls.WithLabelValues(action, "failed").Inc()
+		s.recordFailure(ctx, tenantID, err)
+		return nil, err
+	}
+	tenantProvisioningCalls.WithLabelValues(action, "ok").Inc()
+	if err := s.markProvisioned(ctx, tenantID, result.Tenant); err != nil {
+		return nil, err
+	}
+	state, err := s.state(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	state.APIKey = result.APIKey
+	return state, nil
+}
+
+// deprovision revokes a deleted tenant's key in liberation-ai and, unless KeepData is set,
+// deletes its namespaces there. The tenant stays deprovisioning until that succeeds.
+func (s *ProvisioningService) deprovision(ctx context.Context, tenantID string) error {
+	if _, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO tenant_provisioning (tenant_id, state) VALUES ($1, $2)
+		ON CONFLICT (tenant_id) DO UPDATE SET state = $2, updated_at = NOW()`,
+		tenantID, provisioningDeprovisioning); err != nil {
+		return err
+	}
+	path := "/v1/admin/provisioning/tenants/" + url.PathEscape(tenantID)
+	if s.config.KeepData {
+		path += "?keep_data=true"
+	}
+	status, err := s.call(ctx, http.MethodDelete, path, nil, nil)
+	if err != nil && status != http.StatusNotFound {
+		tenantProvisioningCalls.WithLabelValues("deprovision", "failed").Inc()
+		s.recordFailure(ctx, tenantID, err)
+		return err
+	}
+	tenantProvisioningCalls.WithLabelValues("deprovision", "ok").Inc()
+	_, err = s.as.db.ExecContext(ctx, `DELETE FROM tenant_provisioning WHERE tenant_id = $1 AND state = $2`, tenantID, provisioningDeprovisioning)
+	return err
+}
+
+func (s *ProvisioningService) markProvisioned(ctx context.Context, tenantID string, tenant aiTenant) error {
+	_, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO tenant_provisioning (tenant_id, state, namespace, key_hint, provisioned_at) VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (tenant_id) DO UPDATE SET state = $2, namespace = $3, key_hint = $4, attempts = 0, last_error = '',
+			provisioned_at = COALESCE(tenant_provisioning.provisioned_at, NOW()), updated_at = NOW()`,
+		tenantID, provisioningProvisioned, tenant.Namespace, tenant.KeyHint)
+	return err
+}
+
+func (s *ProvisioningService) recordFailure(ctx context.Context, tenantID string, cause error) {
+	log.Printf("Provisioning of tenant %s in liberation-ai failed: %v", tenantID, cause)
+	if _, err := s.as.db.ExecContext(ctx, `
+		UPDATE tenant_provisioning SET attempts = attempts + 1, last_error = $2, updated_at = NOW() WHERE tenant_id = $1`,
+		tenantID, cause.Error()); err != nil {
+		log.Printf("Failed to record provisioning failure of tenant %s: %v", tenantID, err)
+	}
+}
+
+// state loads a tenant's provisioning state
+func (s *ProvisioningService) state(ctx context.Context, tenantID string) (*TenantProvisioning, error) {
+	state := &TenantProvisioning{}
+	var provisionedAt sql.NullTime
+	err := s.as.db.QueryRowContext(ctx, `
+		SELECT tenant_id, state, namespace, key_hint, attempts, last_error, provisioned_at, updated_at
+		FROM tenant_provisioning WHERE tenant_id = $1`, tenantID).
+		Scan(&state.TenantID, &state.State, &state.Namespace, &state.KeyHint, &state.Attempts, &state.LastError, &provisionedAt, &state.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if provisionedAt.Valid {
+		state.ProvisionedAt = &provisionedAt.Time
+	}
+	return state, nil
+}
+
+// provisioningPlan is what reconciliation has to do to bring liberation-ai in line
+type provisioningPlan struct {
+	// provision are tenants liberation-ai does not have
+	provision []string
+	// adopt are tenants liberation-ai has that are not recorded as provisioned here
+	adopt []aiTenant
+	// deprovision are tenants liberation-ai has, or that are being torn down, that no longer exist here
+	deprovision []string
+}
+
+// planReconciliation compares the tenants here, their recorded states and liberation-ai's tenants
+func planReconciliation(tenants []string, states map[string]string, remote []aiTenant) provisioningPlan {
+	var plan provisioningPlan
+	exists := make(map[string]bool, len(tenants))
+	for _, id := range tenants {
+		exists[id] = true
+	}
+	provisioned := make(map[string]bool, len(remote))
+	for _, tenant := range remote {
+		provisioned[tenant.Tenant] = true
+		switch {
+		case !exists[tenant.Tenant]:
+			plan.deprovision = append(plan.deprovision, tenant.Tenant)
+		case states[tenant.Tenant] != provisioningProvisioned:
+			plan.adopt = append(plan.adopt, tenant)
+		}
+	}
+	for _, id := range tenants {
+		if !provisioned[id] {
+			plan.provision = append(plan.provision, id)
+		}
+	}
+	for id, state := range states {
+		if !exists[id] && !provisioned[id] && state == provisioningDeprovisioning {
+			plan.deprovision = append(plan.deprovision, id)
+		}
+	}
+	return plan
+}
+
+// ReconcileTenants repairs drift between tenants here and in liberation-ai: missing tenants
+// are provisioned, tenants that were deleted here are torn down there, and tenants
+// liberation-ai already has are recorded as provisioned. Tenants provisioned this way get a
+// key nobody has seen; an admin rotates it to hand one out.
+func (s *ProvisioningService) ReconcileTenants(ctx context.Context) (string, error) {
+	var remote struct {
+		Tenants []aiTenant `json:"tenants"`
+	}
+	if _, err := s.call(ctx, http.MethodGet, "/v1/admin/provisioning/tenants", nil, &remote); err != nil {
+		return "", err
+	}
+
+	rows, err := s.as.db.QueryContext(ctx, `SELECT id FROM tenants`)
+	if err != nil {
+		return "", err
+	}
+	var tenants []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return "", err
+		}
+		tenants = append(tenants, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	rows, err = s.as.db.QueryContext(ctx, `SELECT tenant_id, state FROM tenant_provisioning`)
+	if err != nil {
+		return "", err
+	}
+	states := map[string]string{}
+	for rows.Next() {
+		var id, state string
+		if err := rows.Scan(&id, &state); err != nil {
+			rows.Close()
+			return "", err
+		}
+		states[id] = state
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	plan := planReconciliation(tenants, states, remote.Tenants)
+	failed := 0
+	for _, id := range plan.provision {
+		if _, err := s.provision(ctx, id, false); err != nil {
+			failed++
+		}
+	}
+	for _, tenant := range plan.adopt {
+		if err := s.markProvisioned(ctx, tenant.Tenant, tenant); err != nil {
+			failed++
+		}
+	}
+	for _, id := range plan.deprovision {
+		if err := s.deprovision(ctx, id); err != nil {
+			failed++
+		}
+	}
+	summary := fmt.Sprintf("provisioned %d, adopted %d, deprovisioned %d, failed %d",
+		len(plan.provision), len(plan.adopt), len(plan.deprovision), failed)
+	if failed > 0 {
+		return summary, fmt.Errorf("%d tenants could not be reconciled", failed)
+	}
+	return summary, nil
+}
+
+// AdminGetTenantProvisioning shows whether a tenant has its namespace and key in liberation-ai
+func (s *ProvisioningService) AdminGetTenantProvisioning(c *gin.Context) {
+	state, err := s.state(c.Request.Context(), c.Param("tenant_id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant has not been provisioned"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load provisioning state"})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// AdminRotateTenantKey mints a new liberation-ai API key for a tenant, provisioning it first
+// if need be. The old key stops working at once; the new one is only in this response.
+func (s *ProvisioningService) AdminRotateTenantKey(c *gin.Context) {
+	if s.as.tenants.loadTenant(c) == nil {
+		return
+	}
+	state, err := s.provision(c.Request.Context(), c.Param("tenant_id"), true)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "liberation-ai could not rotate the key", "detail": err.Error()})
+		return
+	}
+	adminID, _ := c.Get("user_id")
+	log.Printf("Admin %v rotated the liberation-ai API key of tenant %s", adminID, state.TenantID)
+	c.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"liberation-serviceauth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type TenantProvisioningTestSuite struct {
+	suite.Suite
+}
+
+func (suite *TenantProvisioningTestSuite) TestConfig() {
+	config, err := DefaultProvisioningConfig()
+	suite.Require().NoError(err)
+	suite.Empty(config.AIURL)
+	suite.Equal(10*time.Second, config.Timeout)
+	suite.Equal(15*time.Minute, config.ReconcileInterval)
+	suite.False(config.KeepData)
+
+	service, err := NewProvisioningService(&AuthService{}, config)
+	suite.NoError(err)
+	suite.Nil(service, "provisioning is off without a URL")
+
+	suite.T().Setenv("TENANT_PROVISIONING_AI_URL", "http://liberation-ai:8080/")
+	config, err = DefaultProvisioningConfig()
+	suite.Require().NoError(err)
+	suite.Equal("http://liberation-ai:8080", config.AIURL)
+	_, err = NewProvisioningService(&AuthService{}, config)
+	suite.ErrorContains(err, "SERVICE_IDENTITY_PRIVATE_KEY_FILE", "calls must be signed")
+
+	suite.T().Setenv("TENANT_PROVISIONING_AI_URL", "liberation-ai")
+	_, err = DefaultProvisioningConfig()
+	suite.ErrorContains(err, "TENANT_PROVISIONING_AI_URL")
+
+	suite.T().Setenv("TENANT_PROVISIONING_AI_URL", "")
+	suite.T().Setenv("TENANT_PROVISIONING_RECONCILE_INTERVAL", "10s")
+	_, err = DefaultProvisioningConfig()
+	suite.ErrorContains(err, "TENANT_PROVISIONING_RECONCILE_INTERVAL")
+}
+
+func (suite *TenantProvisioningTestSuite) TestPlanReconciliation() {
+	plan := planReconciliation(
+		[]string{"acme", "globex", "initech"},
+		map[string]string{
+			"acme":     provisioningProvisioned,
+			"globex":   provisioningPending,
+			"hooli":    provisioningDeprovisioning,
+			"umbrella": provisioningDeprovisioning,
+		},
+		[]aiTenant{
+			{Tenant: "acme", Namespace: "acme/default"},
+			{Tenant: "globex", Namespace: "globex/default"},
+			{Tenant: "hooli", Namespace: "hooli/default"},
+			{Tenant: "stale", Namespace: "stale/default"},
+		},
+	)
+	suite.Equal([]string{"initech"}, plan.provision, "tenants liberation-ai lacks are provisioned")
+	suite.Equal([]aiTenant{{Tenant: "globex", Namespace: "globex/default"}}, plan.adopt, "tenants it already has are recorded")
+	suite.ElementsMatch([]string{"hooli", "stale", "umbrella"}, plan.deprovision,
+		"deleted tenants are torn down whether or not liberation-ai still lists them")
+
+	suite.Empty(planReconciliation(nil, nil, nil))
+}
+
+func (suite *TenantProvisioningTestSuite) TestCallsAreSigned() {
+	public, private, err := ed25519.GenerateKey(nil)
+	suite.Require().NoError(err)
+	ai := serviceauth.NewIdentity("liberation-ai", nil, map[string]ed25519.PublicKey{"liberation-auth": public})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/v1/admin/provisioning/tenants", func(c *gin.Context) {
+		if _, err := ai.VerifyRequest(c.Request); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tenants": []aiTenant{{Tenant: "acme", Namespace: "acme/default", KeyHint: "lai_0a1b2c"}}})
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	config := ProvisioningConfig{AIURL: server.URL, Timeout: time.Second, ReconcileInterval: time.Minute}
+	as := &AuthService{services: serviceauth.NewIdentity("liberation-auth", private, nil)}
+	service, err := NewProvisioningService(as, config)
+	suite.Require().NoError(err)
+
+	var listed struct {
+		Tenants []aiTenant `json:"tenants"`
+	}
+	status, err := service.call(context.Background(), http.MethodGet, "/v1/admin/provisioning/tenants", nil, &listed)
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusOK, status)
+	suite.Equal("lai_0a1b2c", listed.Tenants[0].KeyHint)
+
+	status, err = service.call(context.Background(), http.MethodDelete, "/v1/admin/provisioning/tenants/acme", nil, nil)
+	suite.Equal(http.StatusNotFound, status)
+	suite.ErrorContains(err, "404")
+}
+
+func TestTenantProvisioning(t *testing.T) {
+	suite.Run(t, new(TenantProvisioningTestSuite))
+}
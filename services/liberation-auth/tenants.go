@@ -0,0 +1,885 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Quota metrics. Users and clients are counted in this service's tables; vectors and
+// embeddings are reported by liberation-ai as it ingests.
+const (
+	quotaUsers      = "users"
+	quotaClients    = "clients"
+	quotaVectors    = "vectors"
+	quotaEmbeddings = "embeddings"
+)
+
+var quotaMetrics = []string{quotaUsers, quotaClients, quotaVectors, quotaEmbeddings}
+
+// monthlyMetric reports whether a metric counts what happened this month (UTC) rather than
+// what exists now
+func monthlyMetric(metric string) bool {
+	return metric == quotaEmbeddings
+}
+
+// Tenant IDs double as liberation-ai namespace prefixes, so they stay URL and path safe
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var errTenantNotFound = errors.New("tenant not found")
+
+var tenantQuotaDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_tenant_quota_decisions_total",
+	Help: "Tenant quota checks, by metric and outcome (allowed, grace, blocked).",
+}, []string{"metric", "outcome"})
+
+// TenantPlan caps each quota metric; a missing or zero limit is unlimited
+type TenantPlan map[string]int64
+
+// TenantConfig defines the plans hosted tenants are on and how exceeded limits are handled
+type TenantConfig struct {
+	Enabled bool
+	Plans   map[string]TenantPlan
+	// DefaultPlan is what new tenants are billed on once any trial ends
+	DefaultPlan string
+	// TrialPlan applies to new tenants for TrialDays; empty or 0 days means no trial
+	TrialPlan string
+	TrialDays int
+	// GracePeriod is how long a tenant over a limit keeps working before new usage is refused
+	GracePeriod time.Duration
+}
+
+// DefaultTenantConfig reads TENANCY_ENABLED, TENANT_DEFAULT_PLAN, TENANT_TRIAL_PLAN,
+// TENANT_TRIAL_DAYS and TENANT_GRACE_PERIOD. The built-in free and pro plans can be replaced,
+// and further plans added, with TENANT_PLAN_<NAME>="users=5,clients=2,vectors=10000,embeddings=100000".
+func DefaultTenantConfig() (TenantConfig, error) {
+	config := TenantConfig{
+		Enabled: getEnv("TENANCY_ENABLED", "false") == "true",
+		Plans: map[string]TenantPlan{
+			"free": {quotaUsers: 5, quotaClients: 2, quotaVectors: 10000, quotaEmbeddings: 100000},
+			"pro":  {quotaUsers: 50, quotaClients: 20, quotaVectors: 1000000, quotaEmbeddings: 5000000},
+		},
+		DefaultPlan: getEnv("TENANT_DEFAULT_PLAN", "free"),
+		TrialPlan:   getEnv("TENANT_TRIAL_PLAN", "pro"),
+	}
+	for _, variable := range os.Environ() {
+		key, value, _ := strings.Cut(variable, "=")
+		name, found := strings.CutPrefix(key, "TENANT_PLAN_")
+		if !found || name == "" {
+			continue
+		}
+		plan, err := parseTenantPlan(value)
+		if err != nil {
+			return config, fmt.Errorf("%s: %w", key, err)
+		}
+		config.Plans[strings.ToLower(name)] = plan
+	}
+
+	if _, ok := config.Plans[config.DefaultPlan]; !ok {
+		return config, fmt.Errorf("TENANT_DEFAULT_PLAN %q is not a plan", config.DefaultPlan)
+	}
+	if _, ok := config.Plans[config.TrialPlan]; config.TrialPlan != "" && !ok {
+		return config, fmt.Errorf("TENANT_TRIAL_PLAN %q is not a plan", config.TrialPlan)
+	}
+	var err error
+	if config.TrialDays, err = strconv.Atoi(getEnv("TENANT_TRIAL_DAYS", "14")); err != nil || config.TrialDays < 0 {
+		return config, fmt.Errorf("TENANT_TRIAL_DAYS must be a number of days, or 0 for no trial")
+	}
+	if config.GracePeriod, err = time.ParseDuration(getEnv("TENANT_GRACE_PERIOD", "168h")); err != nil || config.GracePeriod < 0 {
+		return config, fmt.Errorf("TENANT_GRACE_PERIOD must be a duration such as 168h, or 0 to refuse at once")
+	}
+	return config, nil
+}
+
+// parseTenantPlan reads limits written as metric=limit pairs separated by commas
+func parseTenantPlan(text string) (TenantPlan, error) {
+	plan := TenantPlan{}
+	for _, pair := range strings.Split(text, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		metric, value, _ := strings.Cut(pair, "=")
+		metric = strings.TrimSpace(metric)
+		if !isQuotaMetric(metric) {
+			return nil, fmt.Errorf("unknown metric %q; use %s", metric, strings.Join(quotaMetrics, ", "))
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("%s must be a limit of 0 or more", metric)
+		}
+		plan[metric] = limit
+	}
+	return plan, nil
+}
+
+func isQuotaMetric(metric string) bool {
+	for _, known := range quotaMetrics {
+		if metric == known {
+			return true
+		}
+	}
+	return false
+}
+
+// plan returns a plan's limits; tenants on a plan that was removed from the configuration
+// get the default plan's
+func (cfg TenantConfig) plan(name string) TenantPlan {
+	if plan, ok := cfg.Plans[name]; ok {
+		return plan
+	}
+	return cfg.Plans[cfg.DefaultPlan]
+}
+
+// Tenant is one customer of a hosted deployment
+type Tenant struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Plan        string     `json:"plan"`
+	TrialPlan   string     `json:"trial_plan,omitempty"`
+	TrialEndsAt *time.Time `json:"trial_ends_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// activePlan is the trial plan until the trial ends, then the tenant's own plan
+func (t Tenant) activePlan(now time.Time) string {
+	if t.TrialPlan != "" && t.TrialEndsAt != nil && now.Before(*t.TrialEndsAt) {
+		return t.TrialPlan
+	}
+	return t.Plan
+}
+
+// QuotaDecision is the outcome of checking one metric against a tenant's plan
+type QuotaDecision struct {
+	Metric string `json:"metric"`
+	// Limit is 0 when the plan does not cap the metric
+	Limit     int64 `json:"limit"`
+	Used      int64 `json:"used"`
+	Allowed   bool  `json:"allowed"`
+	OverLimit bool  `json:"over_limit"`
+	// GraceEndsAt is when an exceeded limit starts refusing new usage
+	GraceEndsAt *time.Time `json:"grace_ends_at,omitempty"`
+}
+
+// decideQuota decides whether usage may grow from used by adding. A tenant that goes over its
+// limit keeps working until graceEndsAt, the end of its open breach's grace period.
+func decideQuota(metric string, limit, used, adding int64, graceEndsAt *time.Time, now time.Time) QuotaDecision {
+	decision := QuotaDecision{Metric: metric, Limit: limit, Used: used, Allowed: true}
+	if limit == 0 || used+adding <= limit {
+		return decision
+	}
+	decision.OverLimit = true
+	decision.GraceEndsAt = graceEndsAt
+	decision.Allowed = graceEndsAt != nil && now.Before(*graceEndsAt)
+	return decision
+}
+
+func (d QuotaDecision) outcome() string {
+	switch {
+	case !d.Allowed:
+		return "blocked"
+	case d.OverLimit:
+		return "grace"
+	default:
+		return "allowed"
+	}
+}
+
+// usagePeriod is the first day of now's month, which usage is recorded against
+func usagePeriod(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// TenantService assigns users to tenants on plans, enforces the plans' soft quotas and keeps
+// the monthly usage billing is charged from
+type TenantService struct {
+	as     *AuthService
+	config TenantConfig
+}
+
+func NewTenantService(as *AuthService, config TenantConfig) *TenantService {
+	return &TenantService{as: as, config: config}
+}
+
+const tenantColumns = `id, name, plan, trial_plan, trial_ends_at, created_at, updated_at`
+
+func scanTenant(row interface{ Scan(...interface{}) error }) (*Tenant, error) {
+	var tenant Tenant
+	var trialEndsAt sql.NullTime
+	if err := row.Scan(&tenant.ID, &tenant.Name, &tenant.Plan, &tenant.TrialPlan, &trialEndsAt, &tenant.CreatedAt, &tenant.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if trialEndsAt.Valid {
+		tenant.TrialEndsAt = &trialEndsAt.Time
+	}
+	return &tenant, nil
+}
+
+func (s *TenantService) tenant(ctx context.Context, id string) (*Tenant, error) {
+	tenant, err := scanTenant(s.as.db.QueryRowContext(ctx, `SELECT `+tenantColumns+` FROM tenants WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errTenantNotFound
+	}
+	return tenant, err
+}
+
+// tenantOf returns the tenant a user belongs to, or nil when they belong to none
+func (s *TenantService) tenantOf(ctx context.Context, userID uuid.UUID) (*Tenant, error) {
+	tenant, err := scanTenant(s.as.db.QueryRowContext(ctx, `
+		SELECT `+tenantColumns+` FROM tenants
+		WHERE id = (SELECT tenant_id FROM tenant_members WHERE user_id = $1)`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return tenant, err
+}
+
+// usage returns the tenant's current value of every metric: members and their active clients
+// are counted here, vectors and this month's embeddings come from liberation-ai's reports
+func (s *TenantService) usage(ctx context.Context, tenantID string, now time.Time) (map[string]int64, error) {
+	usage := map[string]int64{quotaVectors: 0, quotaEmbeddings: 0}
+	var users, clients int64
+	err := s.as.db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM tenant_members WHERE tenant_id = $1),
+			(SELECT COUNT(*) FROM oauth_clients oc JOIN tenant_members tm ON tm.user_id = oc.owner_id
+			WHERE tm.tenant_id = $1 AND oc.is_active = true)`, tenantID).Scan(&users, &clients)
+	if err != nil {
+		return nil, err
+	}
+	usage[quotaUsers], usage[quotaClients] = users, clients
+
+	// Vectors are a running total, so the latest report stands until the next one
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (metric) metric, value FROM tenant_usage
+		WHERE tenant_id = $1 AND (metric = $2 OR metric = $3 AND period = $4)
+		ORDER BY metric, period DESC`, tenantID, quotaVectors, quotaEmbeddings, usagePeriod(now))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var metric string
+		var value int64
+		if err := rows.Scan(&metric, &value); err != nil {
+			return nil, err
+		}
+		usage[metric] = value
+	}
+	return usage, rows.Err()
+}
+
+// recordUsage stores a metric for this month: monthly metrics accumulate value, the others
+// are replaced by it. Either way peak keeps the month's highest value.
+func (s *TenantService) recordUsage(ctx context.Context, tenantID, metric string, value int64, now time.Time) error {
+	update := `value = EXCLUDED.value, peak = GREATEST(tenant_usage.peak, EXCLUDED.value)`
+	if monthlyMetric(metric) {
+		update = `value = tenant_usage.value + EXCLUDED.value, peak = tenant_usage.value + EXCLUDED.value`
+	}
+	_, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO tenant_usage (tenant_id, metric, period, value, peak, updated_at)
+		VALUES ($1, $2, $3, $4, $4, NOW())
+		ON CONFLICT (tenant_id, metric, period) DO UPDATE SET `+update+`, updated_at = NOW()`,
+		tenantID, metric, usagePeriod(now), value)
+	return err
+}
+
+// check decides whether the tenant's usage of metric may grow from used by adding. Going over
+// the limit opens a breach whose grace period starts now; coming back under it, by using less
+// or moving to a bigger plan, resolves the breach.
+func (s *TenantService) check(ctx context.Context, tenant *Tenant, metric string, used, adding int64) (QuotaDecision, error) {
+	now := time.Now().UTC()
+	limit := s.config.plan(tenant.activePlan(now))[metric]
+	var graceEndsAt *time.Time
+	if limit > 0 && used+adding > limit {
+		ends, err := s.openBreach(ctx, tenant.ID, metric, now)
+		if err != nil {
+			return QuotaDecision{}, err
+		}
+		graceEndsAt = &ends
+	} else if _, err := s.as.db.ExecContext(ctx, `
+		UPDATE tenant_quota_breaches SET resolved_at = NOW()
+		WHERE tenant_id = $1 AND metric = $2 AND resolved_at IS NULL`, tenant.ID, metric); err != nil {
+		return QuotaDecision{}, err
+	}
+	decision := decideQuota(metric, limit, used, adding, graceEndsAt, now)
+	tenantQuotaDecisions.WithLabelValues(metric, decision.outcome()).Inc()
+	return decision, nil
+}
+
+// openBreach returns when the grace period of the tenant's open breach of metric ends,
+// opening one if there is none
+func (s *TenantService) openBreach(ctx context.Context, tenantID, metric string, now time.Time) (time.Time, error) {
+	result, err := s.as.db.ExecContext(ctx, `
+		INSERT INTO tenant_quota_breaches (tenant_id, metric, started_at, grace_ends_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, metric) WHERE resolved_at IS NULL DO NOTHING`,
+		tenantID, metric, now, now.Add(s.config.GracePeriod))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if opened, _ := result.RowsAffected(); opened > 0 {
+		log.Printf("Tenant %s went over its %s limit; grace period ends %s", tenantID, metric, now.Add(s.config.GracePeriod).Format(time.RFC3339))
+	}
+	var graceEndsAt time.Time
+	err = s.as.db.QueryRowContext(ctx, `
+		SELECT grace_ends_at FROM tenant_quota_breaches
+		WHERE tenant_id = $1 AND metric = $2 AND resolved_at IS NULL`, tenantID, metric).Scan(&graceEndsAt)
+	return graceEndsAt, err
+}
+
+// quotas describes every metric of a tenant without changing any breach
+func (s *TenantService) quotas(ctx context.Context, tenant *Tenant) ([]QuotaDecision, error) {
+	now := time.Now().UTC()
+	usage, err := s.usage(ctx, tenant.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	breaches := map[string]time.Time{}
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT metric, grace_ends_at FROM tenant_quota_breaches
+		WHERE tenant_id = $1 AND resolved_at IS NULL`, tenant.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var metric string
+		var graceEndsAt time.Time
+		if err := rows.Scan(&metric, &graceEndsAt); err != nil {
+			return nil, err
+		}
+		breaches[metric] = graceEndsAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	plan := s.config.plan(tenant.activePlan(now))
+	quotas := make([]QuotaDecision, 0, len(quotaMetrics))
+	for _, metric := range quotaMetrics {
+		var graceEndsAt *time.Time
+		if ends, open := breaches[metric]; open {
+			graceEndsAt = &ends
+		}
+		quotas = append(quotas, decideQuota(metric, plan[metric], usage[metric], 0, graceEndsAt, now))
+	}
+	return quotas, nil
+}
+
+// admitMember checks that the user's tenant may have one more of metric before it is created.
+// Within the grace period of an exceeded limit the request goes ahead with an X-Quota-Warning
+// header; after it, the request is refused with 403. Users without a tenant are not metered,
+// and a failed check lets the request through.
+func (s *TenantService) admitMember(c *gin.Context, userID uuid.UUID, metric string) bool {
+	if s == nil {
+		return true
+	}
+	ctx := c.Request.Context()
+	tenant, err := s.tenantOf(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to look up tenant of %s: %v", userID, err)
+		return true
+	}
+	if tenant == nil {
+		return true
+	}
+	return s.admit(c, tenant, metric)
+}
+
+func (s *TenantService) admit(c *gin.Context, tenant *Tenant, metric string) bool {
+	ctx := c.Request.Context()
+	usage, err := s.usage(ctx, tenant.ID, time.Now())
+	if err != nil {
+		log.Printf("Failed to load usage of tenant %s: %v", tenant.ID, err)
+		return true
+	}
+	decision, err := s.check(ctx, tenant, metric, usage[metric], 1)
+	if err != nil {
+		log.Printf("Failed to check %s quota of tenant %s: %v", metric, tenant.ID, err)
+		return true
+	}
+	if !decision.Allowed {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":             "quota_exceeded",
+			"error_description": fmt.Sprintf("Tenant %s has reached its limit of %d %s", tenant.ID, decision.Limit, metric),
+			"quota":             decision,
+		})
+		return false
+	}
+	if decision.OverLimit {
+		c.Header("X-Quota-Warning", fmt.Sprintf("%s over limit of %d; refused after %s", metric, decision.Limit, decision.GraceEndsAt.Format(time.RFC3339)))
+	}
+	return true
+}
+
+// SnapshotUsage records every tenant's users, clients and vectors for the month and
+// re-checks each metric, so breaches open after a plan change and resolve once usage drops
+// or a new month resets embeddings
+func (s *TenantService) SnapshotUsage(ctx context.Context) (string, error) {
+	rows, err := s.as.db.QueryContext(ctx, `SELECT `+tenantColumns+` FROM tenants ORDER BY id`)
+	if err != nil {
+		return "", err
+	}
+	var tenants []*Tenant
+	for rows.Next() {
+		tenant, err := scanTenant(rows)
+		if err != nil {
+			rows.Close()
+			return "", err
+		}
+		tenants = append(tenants, tenant)
+	}
+	rows.Close()
+
+	over := 0
+	for _, tenant := range tenants {
+		now := time.Now()
+		usage, err := s.usage(ctx, tenant.ID, now)
+		if err != nil {
+			return "", fmt.Errorf("tenant %s: %w", tenant.ID, err)
+		}
+		for _, metric := range quotaMetrics {
+			if !monthlyMetric(metric) {
+				if err := s.recordUsage(ctx, tenant.ID, metric, usage[metric], now); err != nil {
+					return "", fmt.Errorf("tenant %s: %w", tenant.ID, err)
+				}
+			}
+			decision, err := s.check(ctx, tenant, metric, usage[metric], 0)
+			if err != nil {
+				return "", fmt.Errorf("tenant %s: %w", tenant.ID, err)
+			}
+			if decision.OverLimit {
+				over++
+			}
+		}
+	}
+	return fmt.Sprintf("%d tenants, %d limits exceeded", len(tenants), over), nil
+}
+
+// describe is a tenant as the API shows it, with its plan and quotas
+func (s *TenantService) describe(ctx context.Context, tenant *Tenant) (gin.H, error) {
+	quotas, err := s.quotas(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{"tenant": tenant, "active_plan": tenant.activePlan(time.Now().UTC()), "quotas": quotas}, nil
+}
+
+// GetMyTenant shows the caller's tenant, plan and quotas
+func (s *TenantService) GetMyTenant(c *gin.Context) {
+	userID := c.MustGet("user_id").(uuid.UUID)
+	tenant, err := s.tenantOf(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tenant"})
+		return
+	}
+	if tenant == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You do not belong to a tenant"})
+		return
+	}
+	described, err := s.describe(c.Request.Context(), tenant)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+	c.JSON(http.StatusOK, described)
+}
+
+// AdminListTenants lists every tenant with its active plan
+func (s *TenantService) AdminListTenants(c *gin.Context) {
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `SELECT `+tenantColumns+` FROM tenants ORDER BY id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tenants"})
+		return
+	}
+	defer rows.Close()
+	now := time.Now().UTC()
+	tenants := []gin.H{}
+	for rows.Next() {
+		tenant, err := scanTenant(rows)
+		if err != nil {
+			continue
+		}
+		tenants = append(tenants, gin.H{"tenant": tenant, "active_plan": tenant.activePlan(now)})
+	}
+	c.JSON(http.StatusOK, gin.H{"tenants": tenants, "plans": s.config.Plans})
+}
+
+// AdminCreateTenant creates a tenant on a plan, the default plan unless one is given. New
+// tenants start on the trial plan when a trial is configured, unless "trial" is false.
+func (s *TenantService) AdminCreateTenant(c *gin.Context) {
+	var req struct {
+		ID    string `json:"id" binding:"required"`
+		Name  string `json:"name"`
+		Plan  string `json:"plan"`
+		Trial *bool  `json:"trial"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if !tenantIDPattern.MatchString(req.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tenant IDs are lowercase letters, digits and dashes"})
+		return
+	}
+	if req.Plan == "" {
+		req.Plan = s.config.DefaultPlan
+	}
+	if _, ok := s.config.Plans[req.Plan]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown plan %q", req.Plan)})
+		return
+	}
+	trialPlan, trialEndsAt := "", (*time.Time)(nil)
+	if s.config.TrialPlan != "" && s.config.TrialDays > 0 && (req.Trial == nil || *req.Trial) {
+		ends := time.Now().UTC().AddDate(0, 0, s.config.TrialDays)
+		trialPlan, trialEndsAt = s.config.TrialPlan, &ends
+	}
+
+	tenant, err := scanTenant(s.as.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO tenants (id, name, plan, trial_plan, trial_ends_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+tenantColumns, req.ID, req.Name, req.Plan, trialPlan, trialEndsAt))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "A tenant with that ID already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tenant"})
+		return
+	}
+	response := gin.H{"tenant": tenant, "active_plan": tenant.activePlan(time.Now().UTC())}
+	// The tenant's liberation-ai API key is only ever in this response or a rotation's; a failed
+	// call leaves the tenant pending for the reconcile job
+	if s.as.provisioning != nil {
+		if provisioned, err := s.as.provisioning.provision(c.Request.Context(), tenant.ID, false); err != nil {
+			response["provisioning"] = gin.H{"state": provisioningPending, "error": err.Error()}
+		} else {
+			response["provisioning"] = provisioned
+		}
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// loadTenant reads the :tenant_id tenant, answering 404 or 500 itself when it cannot
+func (s *TenantService) loadTenant(c *gin.Context) *Tenant {
+	tenant, err := s.tenant(c.Request.Context(), c.Param("tenant_id"))
+	if errors.Is(err, errTenantNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tenant"})
+		return nil
+	}
+	return tenant
+}
+
+// AdminGetTenant shows a tenant with its quotas
+func (s *TenantService) AdminGetTenant(c *gin.Context) {
+	tenant := s.loadTenant(c)
+	if tenant == nil {
+		return
+	}
+	described, err := s.describe(c.Request.Context(), tenant)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+	c.JSON(http.StatusOK, described)
+}
+
+// AdminUpdateTenant renames a tenant, moves it to another plan or changes when its trial
+// ends; a trial_ends_at in the past ends the trial now
+func (s *TenantService) AdminUpdateTenant(c *gin.Context) {
+	var req struct {
+		Name        *string    `json:"name"`
+		Plan        *string    `json:"plan"`
+		TrialEndsAt *time.Time `json:"trial_ends_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.Plan != nil {
+		if _, ok := s.config.Plans[*req.Plan]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown plan %q", *req.Plan)})
+			return
+		}
+	}
+	tenant := s.loadTenant(c)
+	if tenant == nil {
+		return
+	}
+	if req.Name != nil {
+		tenant.Name = *req.Name
+	}
+	if req.Plan != nil {
+		tenant.Plan = *req.Plan
+	}
+	if req.TrialEndsAt != nil {
+		if tenant.TrialPlan == "" {
+			tenant.TrialPlan = s.config.TrialPlan
+		}
+		ends := req.TrialEndsAt.UTC()
+		tenant.TrialEndsAt = &ends
+	}
+
+	updated, err := scanTenant(s.as.db.QueryRowContext(c.Request.Context(), `
+		UPDATE tenants SET name = $2, plan = $3, trial_plan = $4, trial_ends_at = $5, updated_at = NOW()
+		WHERE id = $1 RETURNING `+tenantColumns, tenant.ID, tenant.Name, tenant.Plan, tenant.TrialPlan, tenant.TrialEndsAt))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tenant"})
+		return
+	}
+	adminID, _ := c.Get("user_id")
+	log.Printf("Admin %v updated tenant %s: plan %s, active plan %s", adminID, updated.ID, updated.Plan, updated.activePlan(time.Now().UTC()))
+	described, err := s.describe(c.Request.Context(), updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+	c.JSON(http.StatusOK, described)
+}
+
+// AdminDeleteTenant removes a tenant with its memberships, usage and breaches. Its users and
+// clients are kept and stop being metered. With provisioning, its liberation-ai key is revoked
+// and its namespaces there deleted, now or by the reconcile job.
+func (s *TenantService) AdminDeleteTenant(c *gin.Context) {
+	tenantID := c.Param("tenant_id")
+	result, err := s.as.db.ExecContext(c.Request.Context(), `DELETE FROM tenants WHERE id = $1`, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tenant"})
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+	response := gin.H{"message": "Tenant deleted"}
+	if s.as.provisioning != nil {
+		if err := s.as.provisioning.deprovision(c.Request.Context(), tenantID); err != nil {
+			response["provisioning"] = gin.H{"state": provisioningDeprovisioning, "error": err.Error()}
+		} else {
+			response["provisioning"] = gin.H{"state": "deprovisioned"}
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// AdminPutTenantMember adds a user to a tenant, within the tenant's users quota. A user
+// belongs to one tenant at most.
+func (s *TenantService) AdminPutTenantMember(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	tenant := s.loadTenant(c)
+	if tenant == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	var exists bool
+	if err := s.as.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	current, err := s.tenantOf(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load membership"})
+		return
+	}
+	if current != nil && current.ID == tenant.ID {
+		c.JSON(http.StatusOK, gin.H{"tenant_id": tenant.ID, "user_id": userID})
+		return
+	}
+	if current != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("User already belongs to tenant %s", current.ID)})
+		return
+	}
+	if !s.admit(c, tenant, quotaUsers) {
+		return
+	}
+	if _, err := s.as.db.ExecContext(ctx, `INSERT INTO tenant_members (user_id, tenant_id) VALUES ($1, $2)`, userID, tenant.ID); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "User already belongs to a tenant"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"tenant_id": tenant.ID, "user_id": userID})
+}
+
+// AdminDeleteTenantMember removes a user from a tenant
+func (s *TenantService) AdminDeleteTenantMember(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	result, err := s.as.db.ExecContext(c.Request.Context(), `DELETE FROM tenant_members WHERE tenant_id = $1 AND user_id = $2`, c.Param("tenant_id"), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of that tenant"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// AdminReportTenantUsage is how liberation-ai meters ingestion. It checks that usage may grow
+// by amount and records it when it may: embeddings are added to the month's count, while
+// vectors are set to current plus amount, current being the tenant's total before the write.
+// The decision is returned either way; the caller refuses the write when it is not allowed.
+func (s *TenantService) AdminReportTenantUsage(c *gin.Context) {
+	var req struct {
+		Metric  string `json:"metric" binding:"required"`
+		Amount  int64  `json:"amount"`
+		Current *int64 `json:"current"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.Metric != quotaVectors && req.Metric != quotaEmbeddings {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only vectors and embeddings are reported; users and clients are counted here"})
+		return
+	}
+	if req.Amount < 0 || req.Current != nil && *req.Current < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount and current cannot be negative"})
+		return
+	}
+	if req.Metric == quotaVectors && req.Current == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reports of vectors need the tenant's current total"})
+		return
+	}
+	tenant := s.loadTenant(c)
+	if tenant == nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	now := time.Now()
+	used := int64(0)
+	if req.Current != nil {
+		used = *req.Current
+	} else {
+		usage, err := s.usage(ctx, tenant.ID, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+			return
+		}
+		used = usage[req.Metric]
+	}
+	decision, err := s.check(ctx, tenant, req.Metric, used, req.Amount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check quota"})
+		return
+	}
+
+	// A refused vectors write still leaves the reported total as the tenant's latest count
+	record := used
+	switch {
+	case monthlyMetric(req.Metric) && decision.Allowed:
+		record = req.Amount
+	case monthlyMetric(req.Metric):
+		record = 0
+	case decision.Allowed:
+		record = used + req.Amount
+	}
+	if err := s.recordUsage(ctx, tenant.ID, req.Metric, record, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record usage"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenant_id": tenant.ID, "plan": tenant.activePlan(now.UTC()), "quota": decision})
+}
+
+// AdminTenantUsage exports a month of usage per tenant for billing: ?period=YYYY-MM, this
+// month by default. Each metric has its value at the end of the month (or now) and its peak;
+// embeddings are the month's total.
+func (s *TenantService) AdminTenantUsage(c *gin.Context) {
+	period := usagePeriod(time.Now())
+	if value := c.Query("period"); value != "" {
+		parsed, err := time.Parse("2006-01", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "period must be a month such as 2026-10"})
+			return
+		}
+		period = parsed
+	}
+
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `
+		SELECT t.id, t.plan, t.trial_plan, t.trial_ends_at, u.metric, u.value, u.peak
+		FROM tenants t JOIN tenant_usage u ON u.tenant_id = t.id
+		WHERE u.period = $1 ORDER BY t.id, u.metric`, period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+	defer rows.Close()
+
+	type metricUsage struct {
+		Value int64 `json:"value"`
+		Peak  int64 `json:"peak"`
+	}
+	type tenantUsage struct {
+		TenantID string                 `json:"tenant_id"`
+		Plan     string                 `json:"plan"`
+		Usage    map[string]metricUsage `json:"usage"`
+	}
+	// Trials are billed on the plan the tenant was on at the end of the month
+	monthEnd := period.AddDate(0, 1, 0).Add(-time.Second)
+	if now := time.Now().UTC(); now.Before(monthEnd) {
+		monthEnd = now
+	}
+	byTenant := map[string]*tenantUsage{}
+	for rows.Next() {
+		var tenant Tenant
+		var trialEndsAt sql.NullTime
+		var metric string
+		var usage metricUsage
+		if err := rows.Scan(&tenant.ID, &tenant.Plan, &tenant.TrialPlan, &trialEndsAt, &metric, &usage.Value, &usage.Peak); err != nil {
+			continue
+		}
+		if trialEndsAt.Valid {
+			tenant.TrialEndsAt = &trialEndsAt.Time
+		}
+		entry := byTenant[tenant.ID]
+		if entry == nil {
+			entry = &tenantUsage{TenantID: tenant.ID, Plan: tenant.activePlan(monthEnd), Usage: map[string]metricUsage{}}
+			byTenant[tenant.ID] = entry
+		}
+		entry.Usage[metric] = usage
+	}
+	tenants := make([]*tenantUsage, 0, len(byTenant))
+	for _, entry := range byTenant {
+		tenants = append(tenants, entry)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].TenantID < tenants[j].TenantID })
+	c.JSON(http.StatusOK, gin.H{"period": period.Format("2006-01"), "tenants": tenants})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TenantTestSuite struct {
+	suite.Suite
+}
+
+func (suite *TenantTestSuite) TestConfig() {
+	config, err := DefaultTenantConfig()
+	suite.Require().NoError(err)
+	suite.False(config.Enabled)
+	suite.Equal("free", config.DefaultPlan)
+	suite.Equal(int64(2), config.plan("free")[quotaClients])
+	suite.Equal(14, config.TrialDays)
+	suite.Equal(168*time.Hour, config.GracePeriod)
+
+	suite.T().Setenv("TENANT_PLAN_TEAM", "users=10, clients=0,embeddings=250000")
+	suite.T().Setenv("TENANT_DEFAULT_PLAN", "team")
+	config, err = DefaultTenantConfig()
+	suite.Require().NoError(err)
+	suite.Equal(TenantPlan{quotaUsers: 10, quotaClients: 0, quotaEmbeddings: 250000}, config.Plans["team"])
+	suite.Equal(config.Plans["team"], config.plan("retired"), "removed plans fall back to the default")
+
+	suite.T().Setenv("TENANT_PLAN_TEAM", "seats=10")
+	_, err = DefaultTenantConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("TENANT_PLAN_TEAM", "users=10")
+	suite.T().Setenv("TENANT_TRIAL_PLAN", "enterprise")
+	_, err = DefaultTenantConfig()
+	suite.Error(err)
+
+	suite.T().Setenv("TENANT_TRIAL_PLAN", "")
+	suite.T().Setenv("TENANT_GRACE_PERIOD", "a week")
+	_, err = DefaultTenantConfig()
+	suite.Error(err)
+}
+
+func (suite *TenantTestSuite) TestTrialPlan() {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	ends := now.AddDate(0, 0, 14)
+	tenant := Tenant{Plan: "free", TrialPlan: "pro", TrialEndsAt: &ends}
+	suite.Equal("pro", tenant.activePlan(now))
+	suite.Equal("free", tenant.activePlan(ends))
+	suite.Equal("free", Tenant{Plan: "free"}.activePlan(now))
+}
+
+func (suite *TenantTestSuite) TestDecideQuota() {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	decision := decideQuota(quotaVectors, 100, 90, 10, nil, now)
+	suite.True(decision.Allowed)
+	suite.False(decision.OverLimit)
+
+	suite.True(decideQuota(quotaVectors, 0, 1_000_000, 1, nil, now).Allowed, "a zero limit is unlimited")
+
+	grace := now.Add(time.Hour)
+	decision = decideQuota(quotaVectors, 100, 90, 20, &grace, now)
+	suite.True(decision.Allowed, "over the limit within the grace period")
+	suite.True(decision.OverLimit)
+	suite.Equal("grace", decision.outcome())
+
+	decision = decideQuota(quotaVectors, 100, 120, 1, &grace, grace)
+	suite.False(decision.Allowed, "refused once the grace period ends")
+	suite.Equal("blocked", decision.outcome())
+
+	suite.False(decideQuota(quotaUsers, 5, 5, 1, nil, now).Allowed, "no grace without an open breach")
+}
+
+func (suite *TenantTestSuite) TestUsagePeriod() {
+	local := time.FixedZone("UTC+10", 10*60*60)
+	suite.Equal(time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), usagePeriod(time.Date(2026, 10, 1, 5, 0, 0, 0, local)))
+	suite.True(monthlyMetric(quotaEmbeddings))
+	suite.False(monthlyMetric(quotaVectors))
+}
+
+func TestTenantTestSuite(t *testing.T) {
+	suite.Run(t, new(TenantTestSuite))
+}
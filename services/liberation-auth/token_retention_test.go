@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+)
+
+type TokenRetentionTestSuite struct {
+	suite.Suite
+}
+
+func (suite *TokenRetentionTestSuite) TestConfig() {
+	config, err := DefaultTokenRetentionConfig()
+	suite.Require().NoError(err)
+	suite.Zero(config.Days, "tokens are kept until retention is configured")
+	suite.Equal(365, config.TombstoneDays)
+
+	suite.T().Setenv("TOKEN_RETENTION_DAYS", "30")
+	_, err = DefaultTokenRetentionConfig()
+	suite.Error(err, "aggregates need an address key")
+
+	suite.T().Setenv("TOKEN_ROLLUP_IP_KEY", strings.Repeat("k", 32))
+	config, err = DefaultTokenRetentionConfig()
+	suite.Require().NoError(err)
+	suite.Equal(30, config.Days)
+
+	for name, value := range map[string]string{
+		"TOKEN_RETENTION_DAYS":   "1",
+		"TOKEN_TOMBSTONE_DAYS":   "0",
+		"TOKEN_PRUNE_BATCH":      "1000000",
+		"TOKEN_PARTITIONS_AHEAD": "none",
+	} {
+		suite.Run(name, func() {
+			suite.T().Setenv(name, value)
+			_, err := DefaultTokenRetentionConfig()
+			suite.Error(err)
+		})
+	}
+}
+
+func (suite *TokenRetentionTestSuite) TestPartitionNames() {
+	may := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	suite.Equal("oauth_access_tokens_y2024m05", tokenPartitionName(may))
+
+	month, ok := parseTokenPartitionName("oauth_access_tokens_y2024m05")
+	suite.True(ok)
+	suite.Equal(may, month)
+	suite.Equal(may, monthStart(time.Date(2024, 5, 31, 23, 59, 0, 0, time.UTC)))
+
+	for _, name := range []string{
+		"oauth_access_tokens_default",
+		"oauth_access_tokens_y2024m13",
+		"oauth_access_tokens_y24m05",
+		"oauth_refresh_tokens_y2024m05",
+	} {
+		_, ok := parseTokenPartitionName(name)
+		suite.False(ok, name)
+	}
+}
+
+func TestTokenRetentionTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenRetentionTestSuite))
+}
+
+// TokenRetentionDBTestSuite runs against TEST_DATABASE_URL. The token tables are copied into a
+// schema of their own, so pruning everything expired and swapping in partitions leaves the
+// shared tables alone.
+type TokenRetentionDBTestSuite struct {
+	suite.Suite
+	db     *sql.DB
+	as     *AuthService
+	userID uuid.UUID
+	client uuid.UUID
+}
+
+func (suite *TokenRetentionDBTestSuite) SetupTest() {
+	shared := openTestDatabase(suite.T())
+	schema := "token_retention_" + strings.ReplaceAll(uuid.NewString()[:8], "-", "")
+	statements := []string{`CREATE SCHEMA ` + schema}
+	for _, table := range []string{"oauth_access_tokens", "oauth_refresh_tokens", "revoked_token_tombstones", "token_usage_daily"} {
+		statements = append(statements, fmt.Sprintf(`CREATE TABLE %s.%s (LIKE %s INCLUDING ALL)`, schema, table, table))
+	}
+	statements = append(statements, `ALTER TABLE `+schema+`.oauth_refresh_tokens
+		ADD CONSTRAINT oauth_refresh_tokens_access_token_id_fkey FOREIGN KEY (access_token_id) REFERENCES `+schema+`.oauth_access_tokens (id)`)
+	for _, statement := range statements {
+		_, err := shared.Exec(statement)
+		suite.Require().NoError(err)
+	}
+	suite.T().Cleanup(func() { shared.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	separator := "?"
+	if strings.Contains(testDatabaseURL(), "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("postgres", testDatabaseURL()+separator+"search_path="+schema)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { db.Close() })
+	suite.db = db
+	suite.as = &AuthService{db: db, tokenRetention: TokenRetentionConfig{
+		Days: 2, TombstoneDays: 30, BatchSize: 2, IPKey: strings.Repeat("k", 32), PartitionsAhead: 1,
+	}}
+	suite.userID, suite.client = uuid.New(), uuid.New()
+}
+
+// accessToken stores an access token and returns its ID
+func (suite *TokenRetentionDBTestSuite) accessToken(created, expires time.Time, revoked bool, ip string, scopes ...string) uuid.UUID {
+	id := uuid.New()
+	var revokedAt interface{}
+	if revoked {
+		revokedAt = expires.Add(-time.Minute)
+	}
+	_, err := suite.db.Exec(`
+		INSERT INTO oauth_access_tokens (id, token, user_id, client_id, scopes, token_type, expires_at,
+			is_revoked, revoked_at, ip_address, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, 'Bearer', $6, $7, $8, $9, 'test', $10)`,
+		id, "token-"+id.String(), suite.userID, suite.client, pq.Array(scopes), expires, revoked, revokedAt, ip, created)
+	suite.Require().NoError(err)
+	return id
+}
+
+func (suite *TokenRetentionDBTestSuite) refreshToken(accessTokenID uuid.UUID, created, expires time.Time) {
+	id := uuid.New()
+	_, err := suite.db.Exec(`
+		INSERT INTO oauth_refresh_tokens (id, token, access_token_id, user_id, client_id, scopes, expires_at, is_revoked, created_at)
+		VALUES ($1, $2, $3, $4, $5, '{read}', $6, false, $7)`,
+		id, "refresh-"+id.String(), accessTokenID, suite.userID, suite.client, expires, created)
+	suite.Require().NoError(err)
+}
+
+func (suite *TokenRetentionDBTestSuite) count(query string, args ...interface{}) int {
+	var n int
+	suite.Require().NoError(suite.db.QueryRow(query, args...).Scan(&n))
+	return n
+}
+
+func (suite *TokenRetentionDBTestSuite) exists(table string) bool {
+	var name sql.NullString
+	suite.Require().NoError(suite.db.QueryRow(`SELECT to_regclass($1)::text`, table).Scan(&name))
+	return name.Valid
+}
+
+// rollup runs one batch of rollupAccessTokens
+func (suite *TokenRetentionDBTestSuite) rollup(cutoff time.Time) (deleted, buried, rolled int) {
+	config := suite.as.tokenRetention
+	suite.Require().NoError(suite.db.QueryRow(rollupAccessTokens, cutoff, 100, config.IPKey, uuid.Nil, tokenUsageMaxIPs).
+		Scan(&deleted, &buried, &rolled))
+	return deleted, buried, rolled
+}
+
+func (suite *TokenRetentionDBTestSuite) TestRollup() {
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day()-5, 12, 0, 0, 0, time.UTC)
+	expired := day.Add(time.Hour)
+	first := suite.accessToken(day, expired, false, "192.0.2.1", "read")
+	suite.accessToken(day.Add(time.Minute), expired, false, "192.0.2.2", "read", "write")
+	revoked := suite.accessToken(day.Add(2*time.Minute), expired, true, "192.0.2.1", "read")
+	refreshed := suite.accessToken(day, expired, false, "192.0.2.3", "read")
+	suite.refreshToken(refreshed, day, now.Add(time.Hour))
+	live := suite.accessToken(now, now.Add(time.Hour), false, "192.0.2.4", "read")
+
+	deleted, buried, rolled := suite.rollup(now)
+	suite.Equal(3, deleted, "tokens a refresh token points at, and live ones, stay")
+	suite.Equal(1, buried)
+	suite.Equal(1, rolled, "one aggregate per day, user and client")
+	suite.Equal(2, suite.count(`SELECT COUNT(*) FROM oauth_access_tokens WHERE id IN ($1, $2)`, refreshed, live))
+
+	var tokens, revokedCount int
+	var scopes, ipHashes []string
+	suite.Require().NoError(suite.db.QueryRow(`
+		SELECT tokens, revoked, scopes, ip_hashes FROM token_usage_daily WHERE day = $1 AND user_id = $2 AND client_id = $3`,
+		day.Format(clientUsageDay), suite.userID, suite.client).Scan(&tokens, &revokedCount, pq.Array(&scopes), pq.Array(&ipHashes)))
+	suite.Equal(3, tokens)
+	suite.Equal(1, revokedCount)
+	suite.Equal([]string{"read", "write"}, scopes)
+	suite.Len(ipHashes, 2, "each address is kept once")
+	for _, hash := range ipHashes {
+		suite.Len(hash, 64)
+		suite.NotContains(hash, "192.0.2")
+	}
+
+	var token string
+	var tombstoneUser uuid.UUID
+	suite.Require().NoError(suite.db.QueryRow(`SELECT token, user_id FROM revoked_token_tombstones WHERE id = $1`, revoked).
+		Scan(&token, &tombstoneUser))
+	suite.Equal("token-"+revoked.String(), token)
+	suite.Equal(suite.userID, tombstoneUser)
+	suite.Zero(suite.count(`SELECT COUNT(*) FROM revoked_token_tombstones WHERE id = $1`, first), "only revoked tokens are tombstoned")
+
+	// A later batch from the same day adds to the aggregate rather than replacing it
+	suite.accessToken(day.Add(3*time.Minute), expired, false, "192.0.2.1", "admin")
+	deleted, _, _ = suite.rollup(now)
+	suite.Equal(1, deleted)
+	suite.Require().NoError(suite.db.QueryRow(`
+		SELECT tokens, scopes, ip_hashes FROM token_usage_daily WHERE day = $1 AND user_id = $2 AND client_id = $3`,
+		day.Format(clientUsageDay), suite.userID, suite.client).Scan(&tokens, pq.Array(&scopes), pq.Array(&ipHashes)))
+	suite.Equal(4, tokens)
+	suite.Equal([]string{"admin", "read", "write"}, scopes)
+	suite.Len(ipHashes, 2, "a known address is not added twice")
+}
+
+func (suite *TokenRetentionDBTestSuite) TestPruneInBatches() {
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -10)
+	for i := 0; i < 5; i++ {
+		suite.accessToken(old.Add(time.Duration(i)*time.Minute), old.Add(time.Hour), i == 0, "192.0.2.1", "read")
+	}
+	// The expired refresh token goes first, which frees its access token for the rollup
+	held := suite.accessToken(old, old.Add(time.Hour), false, "192.0.2.1", "read")
+	suite.refreshToken(held, old, old.Add(2*time.Hour))
+	live := suite.accessToken(now, now.Add(time.Hour), false, "192.0.2.1", "read")
+
+	// Tombstones are kept for TombstoneDays after their revocation
+	for _, revokedAt := range []time.Time{now.AddDate(0, 0, -40), now.AddDate(0, 0, -31), now.AddDate(0, 0, -1)} {
+		_, err := suite.db.Exec(`
+			INSERT INTO revoked_token_tombstones (id, token, user_id, client_id, scopes, created_at, expires_at, revoked_at)
+			VALUES ($1, 'old', $2, $3, '{}', $4, $4, $4)`, uuid.New(), suite.userID, suite.client, revokedAt)
+		suite.Require().NoError(err)
+	}
+
+	summary, err := suite.as.PruneTokens(context.Background())
+	suite.Require().NoError(err)
+	suite.Contains(summary, "6 access tokens rolled into")
+	suite.Contains(summary, "(1 tombstoned), 1 refresh tokens and 2 tombstones pruned")
+	suite.NotContains(summary, "partitions", "the table is not partitioned")
+	suite.Equal(1, suite.count(`SELECT COUNT(*) FROM oauth_access_tokens`))
+	suite.Equal(1, suite.count(`SELECT COUNT(*) FROM oauth_access_tokens WHERE id = $1`, live))
+	suite.Zero(suite.count(`SELECT COUNT(*) FROM oauth_refresh_tokens`))
+	suite.Equal(2, suite.count(`SELECT COUNT(*) FROM revoked_token_tombstones`), "the fresh tombstone and the one just buried")
+	suite.Equal(6, suite.count(`SELECT COALESCE(SUM(tokens), 0) FROM token_usage_daily`))
+
+	// pruneInBatches keeps going until a batch comes back short
+	for i := 0; i < 5; i++ {
+		_, err := suite.db.Exec(`
+			INSERT INTO revoked_token_tombstones (id, token, client_id, created_at, expires_at, revoked_at)
+			VALUES ($1, 'old', $2, $3, $3, $3)`, uuid.New(), suite.client, now.AddDate(0, 0, -60))
+		suite.Require().NoError(err)
+	}
+	pruned, err := pruneInBatches(context.Background(), suite.db, `
+		DELETE FROM revoked_token_tombstones WHERE id IN (
+			SELECT id FROM revoked_token_tombstones WHERE revoked_at < $1 LIMIT $2)`, 2, now.AddDate(0, 0, -30))
+	suite.Require().NoError(err)
+	suite.Equal(int64(5), pruned)
+}
+
+func (suite *TokenRetentionDBTestSuite) TestPartitionSwap() {
+	now := time.Now().UTC()
+	current := monthStart(now)
+	oldMonth := current.AddDate(0, -3, 0)
+	old := suite.accessToken(oldMonth.Add(time.Hour), oldMonth.Add(2*time.Hour), false, "192.0.2.1", "read")
+	recent := suite.accessToken(now, now.Add(time.Hour), false, "192.0.2.1", "read")
+	suite.refreshToken(recent, now, now.Add(24*time.Hour))
+
+	var output strings.Builder
+	suite.Require().NoError(partitionTokenTable(context.Background(), suite.db, oldMonth, current.AddDate(0, 1, 0), &output))
+	partitioned, err := tokenTablePartitioned(context.Background(), suite.db)
+	suite.Require().NoError(err)
+	suite.True(partitioned)
+	suite.True(suite.exists("oauth_access_tokens_unpartitioned"), "the old table is kept for the operator")
+	suite.True(suite.exists("oauth_access_tokens_default"))
+	suite.Equal(2, suite.count(`SELECT COUNT(*) FROM oauth_access_tokens WHERE id IN ($1, $2)`, old, recent))
+	suite.Equal(1, suite.count(`SELECT COUNT(*) FROM `+tokenPartitionName(oldMonth)+` WHERE id = $1`, old))
+
+	// Foreign keys cannot point at the partitioned table; they are dropped and listed, not recreated
+	suite.Contains(output.String(), "dropped foreign key oauth_refresh_tokens_access_token_id_fkey on oauth_refresh_tokens")
+	suite.Zero(suite.count(`SELECT COUNT(*) FROM pg_constraint WHERE contype = 'f' AND conrelid = 'oauth_refresh_tokens'::regclass`))
+
+	// Empty past months are dropped; the month still holding a token stays until it is rolled up
+	created, dropped, err := maintainTokenPartitions(context.Background(), suite.db, now, now, 2)
+	suite.Require().NoError(err)
+	suite.Equal(3, created)
+	suite.Equal(2, dropped)
+	suite.True(suite.exists(tokenPartitionName(current.AddDate(0, 2, 0))))
+	suite.False(suite.exists(tokenPartitionName(oldMonth.AddDate(0, 1, 0))))
+	suite.True(suite.exists(tokenPartitionName(oldMonth)))
+
+	deleted, _, _ := suite.rollup(now)
+	suite.Equal(1, deleted)
+	_, dropped, err = maintainTokenPartitions(context.Background(), suite.db, now, now, 2)
+	suite.Require().NoError(err)
+	suite.Equal(1, dropped)
+	suite.False(suite.exists(tokenPartitionName(oldMonth)))
+	suite.Equal(1, suite.count(`SELECT COUNT(*) FROM oauth_access_tokens WHERE id = $1`, recent))
+}
+
+func TestTokenRetentionDBTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenRetentionDBTestSuite))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// storedTokenPrefix marks token columns holding a SHA-256 hash rather than the token itself
+const storedTokenPrefix = "sha256:"
+
+// tokenHashBatchSize bounds each backfill UPDATE so it never holds long row locks
+const tokenHashBatchSize = 1000
+
+// hashedTokenColumns are the secrets stored at rest, keyed by table, with the primary key used to batch the backfill
+var hashedTokenColumns = []struct {
+	table, column, key string
+}{
+	{"oauth_access_tokens", "token", "id"},
+	{"oauth_refresh_tokens", "token", "id"},
+	{"authorization_codes", "code", "code"},
+}
+
+var plaintextTokensRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "liberation_auth_plaintext_tokens_remaining",
+	Help: "Rows still holding a plaintext token or code, by table. Dual reads can be turned off once all are zero.",
+}, []string{"table"})
+
+// TokenStorageConfig controls how tokens and codes are looked up while legacy rows are migrated
+type TokenStorageConfig struct {
+	// DualRead also matches the plaintext value, for rows written before tokens were hashed.
+	// Turn it off once liberation_auth_plaintext_tokens_remaining is zero everywhere.
+	DualRead bool
+}
+
+// DefaultTokenStorageConfig reads TOKEN_HASH_DUAL_READ from the environment
+func DefaultTokenStorageConfig() TokenStorageConfig {
+	return TokenStorageConfig{DualRead: getEnv("TOKEN_HASH_DUAL_READ", "true") != "false"}
+}
+
+// hashStoredToken is the form of a token or code kept in Postgres. Tokens carry 256 bits of
+// randomness, so an unsalted SHA-256 is enough to make a leaked row useless.
+func hashStoredToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return storedTokenPrefix + hex.EncodeToString(sum[:])
+}
+
+// lookupValues are the stored values a presented token may match, for use with = ANY($n)
+func (cfg TokenStorageConfig) lookupValues(token string) []string {
+	if cfg.DualRead && !strings.HasPrefix(token, storedTokenPrefix) {
+		return []string{hashStoredToken(token), token}
+	}
+	return []string{hashStoredToken(token)}
+}
+
+// hashLegacyTokens replaces plaintext tokens and codes with their hashes, a batch at a time.
+// Postgres computes the same digest as hashStoredToken, so rows never leave the database.
+func hashLegacyTokens(ctx context.Context, db *sql.DB) (map[string]int64, error) {
+	hashed := map[string]int64{}
+	for _, target := range hashedTokenColumns {
+		statement := fmt.Sprintf(`
+			UPDATE %[1]s SET %[2]s = '%[4]s' || encode(sha256(convert_to(%[2]s, 'UTF8')), 'hex')
+			WHERE %[3]s IN (SELECT %[3]s FROM %[1]s WHERE %[2]s NOT LIKE '%[4]s%%' LIMIT %[5]d)`,
+			target.table, target.column, target.key, storedTokenPrefix, tokenHashBatchSize)
+		for {
+			result, err := db.ExecContext(ctx, statement)
+			if err != nil {
+				return hashed, fmt.Errorf("%s: %w", target.table, err)
+			}
+			rows, _ := result.RowsAffected()
+			hashed[target.table] += rows
+			if rows < tokenHashBatchSize {
+				break
+			}
+		}
+	}
+	return hashed, nil
+}
+
+// countPlaintextTokens reports how many rows per table still hold a plaintext secret
+func countPlaintextTokens(ctx context.Context, db *sql.DB) (map[string]int64, error) {
+	remaining := map[string]int64{}
+	for _, target := range hashedTokenColumns {
+		var count int64
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s NOT LIKE '%s%%'`,
+			target.table, target.column, storedTokenPrefix)).Scan(&count)
+		if err != nil {
+			return remaining, fmt.Errorf("%s: %w", target.table, err)
+		}
+		remaining[target.table] = count
+	}
+	return remaining, nil
+}
+
+// HashLegacyTokens is the background job that migrates plaintext rows written before hashing
+func (as *AuthService) HashLegacyTokens(ctx context.Context) (string, error) {
+	hashed, err := hashLegacyTokens(ctx, as.db)
+	if err != nil {
+		return "", err
+	}
+	remaining, err := countPlaintextTokens(ctx, as.db)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, target := range hashedTokenColumns {
+		plaintextTokensRemaining.WithLabelValues(target.table).Set(float64(remaining[target.table]))
+		parts = append(parts, fmt.Sprintf("%s: %d hashed, %d left", target.table, hashed[target.table], remaining[target.table]))
+	}
+	return strings.Join(parts, "; "), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TokenStorageTestSuite struct {
+	suite.Suite
+}
+
+func (suite *TokenStorageTestSuite) TestHashMatchesPostgresDigest() {
+	// The backfill computes 'sha256:' || encode(sha256(convert_to(token, 'UTF8')), 'hex') in SQL
+	sum := sha256.Sum256([]byte("opaque-token"))
+	suite.Equal("sha256:"+hex.EncodeToString(sum[:]), hashStoredToken("opaque-token"))
+}
+
+func (suite *TokenStorageTestSuite) TestLookupValues() {
+	token := "opaque-token"
+
+	suite.Equal([]string{hashStoredToken(token)}, TokenStorageConfig{}.lookupValues(token))
+	suite.Equal([]string{hashStoredToken(token), token}, TokenStorageConfig{DualRead: true}.lookupValues(token))
+
+	// A presented hash never matches itself as a plaintext row
+	stored := hashStoredToken(token)
+	suite.Equal([]string{hashStoredToken(stored)}, TokenStorageConfig{DualRead: true}.lookupValues(stored))
+}
+
+func TestTokenStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenStorageTestSuite))
+}
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// User deletion states. A deletion is erasing until every downstream service has
+// acknowledged it, and failed once one of them has run out of attempts.
+const (
+	deletionErasing   = "erasing"
+	deletionCompleted = "completed"
+	deletionFailed    = "failed"
+)
+
+// Acknowledgment states of one downstream service
+const (
+	deletionAckPending      = "pending"
+	deletionAckAcknowledged = "acknowledged"
+	deletionAckFailed       = "failed"
+)
+
+// deletionBatchSize is how many pending acknowledgments one saga run delivers
+const deletionBatchSize = 100
+
+// deletionPurges remove what an anonymized account still holds about the user. They run once
+// every service has acknowledged, so support can trace the account while erasure is owed.
+var deletionPurges = []string{
+	`DELETE FROM user_devices WHERE user_id = $1`,
+	`DELETE FROM token_usage_daily WHERE user_id = $1`,
+	`DELETE FROM recovery_contacts WHERE user_id = $1 OR contact_id = $1`,
+	`DELETE FROM imported_users WHERE user_id = $1`,
+}
+
+const securityEventUserErased = "user_erased"
+
+var userDeletionCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "liberation_auth_user_deletion_calls_total",
+	Help: "Erasure calls to downstream services for deleted users, by service and outcome (acknowledged, retrying, failed).",
+}, []string{"service", "outcome"})
+
+// DeletionSagaConfig lists the services that hold data about users and must erase it when an
+// account is deleted
+type DeletionSagaConfig struct {
+	// Services maps each service's name, which its calls are signed for, to the URL its
+	// erasure call is POSTed to
+	Services map[string]string
+	Timeout  time.Duration
+	// RetryInterval is how often pending erasures are delivered, and the first retry delay;
+	// each failure doubles the delay, up to an hour
+	RetryInterval time.Duration
+	// MaxAttempts is how many times a service is called before the deletion is marked failed
+	MaxAttempts int
+}
+
+// DefaultDeletionSagaConfig reads USER_DELETION_SERVICES ("liberation-ai=http://liberation-ai:8080/v1/admin/erasures"),
+// USER_DELETION_TIMEOUT, USER_DELETION_RETRY_INTERVAL and USER_DELETION_MAX_ATTEMPTS
+func DefaultDeletionSagaConfig() (DeletionSagaConfig, error) {
+	config := DeletionSagaConfig{Services: map[string]string{}}
+	for _, entry := range strings.Split(getEnv("USER_DELETION_SERVICES", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(entry, "=")
+		parsed, err := url.Parse(strings.TrimSpace(target))
+		if !ok || strings.TrimSpace(name) == "" || err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return config, fmt.Errorf("USER_DELETION_SERVICES entries are name=http(s)://erasure-url, got %q", entry)
+		}
+		config.Services[strings.TrimSpace(name)] = parsed.String()
+	}
+	var err error
+	if config.Timeout, err = time.ParseDuration(getEnv("USER_DELETION_TIMEOUT", "10s")); err != nil || config.Timeout <= 0 {
+		return config, fmt.Errorf("USER_DELETION_TIMEOUT must be a positive duration")
+	}
+	if config.RetryInterval, err = time.ParseDuration(getEnv("USER_DELETION_RETRY_INTERVAL", "1m")); err != nil || config.RetryInterval < time.Second {
+		return config, fmt.Errorf("USER_DELETION_RETRY_INTERVAL must be at least 1s")
+	}
+	if config.MaxAttempts, err = strconv.Atoi(getEnv("USER_DELETION_MAX_ATTEMPTS", "10")); err != nil || config.MaxAttempts < 1 {
+		return config, fmt.Errorf("USER_DELETION_MAX_ATTEMPTS must be a positive number")
+	}
+	return config, nil
+}
+
+// serviceNames lists the configured services in order
+func (c DeletionSagaConfig) serviceNames() []string {
+	names := make([]string, 0, len(c.Services))
+	for name := range c.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// retryDelay is how long to wait before the next call after attempts failed ones
+func (c DeletionSagaConfig) retryDelay(attempts int) time.Duration {
+	delay := c.RetryInterval
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
+
+// DeletionService runs the user deletion saga. Deleting an account anonymizes it here and, in
+// the same transaction, records an acknowledgment owed by every downstream service. The saga
+// job then calls each service until it acknowledges the erasure, so a service that is down
+// erases the user once it is back. When the last one acknowledges, the deletion completes and
+// the account's remaining personal data is purged. Support reads the deletion's status to
+// confirm the user is gone everywhere.
+type DeletionService struct {
+	as      *AuthService
+	config  DeletionSagaConfig
+	clients map[string]*http.Client
+}
+
+// NewDeletionService signs each service's calls for that service. Downstream services need a
+// service identity that can sign.
+func NewDeletionService(as *AuthService, config DeletionSagaConfig) (*DeletionService, error) {
+	s := &DeletionService{as: as, config: config, clients: map[string]*http.Client{}}
+	if len(config.Services) == 0 {
+		return s, nil
+	}
+	if as.services == nil || !as.services.CanMint() {
+		return nil, fmt.Errorf("USER_DELETION_SERVICES needs SERVICE_IDENTITY_PRIVATE_KEY_FILE to sign erasure calls")
+	}
+	for name := range config.Services {
+		s.clients[name] = &http.Client{Timeout: config.Timeout, Transport: as.services.Transport(name, nil)}
+	}
+	return s, nil
+}
+
+// anonymizeUser strips an account of what identifies the user and revokes its consents and
+// tokens. The account row stays, so references to it keep working. It returns the key of the
+// avatar to delete from storage once the transaction commits.
+func (as *AuthService) anonymizeUser(ctx context.Context, tx *sql.Tx, userID uuid.UUID) (sql.NullString, error) {
+	var avatarKey sql.NullString
+	placeholder := "deleted_" + strings.ReplaceAll(userID.String(), "-", "")
+	email := placeholder + "@anonymized.invalid"
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET username = $2, email = $3, email_bidx = $4, display_name = '', password_hash = '',
+			is_active = false, updated_at = NOW()
+		WHERE id = $1`, userID, placeholder, as.pii.value(piiUserEmail, email), as.pii.index(piiUserEmail, email)); err != nil {
+		return avatarKey, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_phone_numbers WHERE user_id = $1`, userID); err != nil {
+		return avatarKey, err
+	}
+	err := tx.QueryRowContext(ctx, `DELETE FROM user_avatars WHERE user_id = $1 RETURNING object_key`, userID).Scan(&avatarKey)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return avatarKey, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE user_consents SET is_revoked = true, revoked_at = NOW()
+		WHERE user_id = $1 AND is_revoked = false`, userID); err != nil {
+		return avatarKey, err
+	}
+	return avatarKey, revokeUserTokens(ctx, tx, userID)
+}
+
+// start records a deletion and the acknowledgments it is owed, in the transaction that
+// anonymizes the account. Deleting a user again starts over, so services erase anything
+// stored since. Without services the deletion completes at once.
+func (s *DeletionService) start(ctx context.Context, tx *sql.Tx, userID uuid.UUID, actor, reason string) error {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_deletions (user_id, state, requested_by, reason, requested_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET state = $2, requested_by = $3, reason = $4, requested_at = NOW(),
+			completed_at = NULL, purged_at = NULL`,
+		userID, deletionErasing, actor, reason); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_deletion_acks WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, service := range s.config.serviceNames() {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO user_deletion_acks (user_id, service, state, next_attempt_at) VALUES ($1, $2, $3, NOW())`,
+			userID, service, deletionAckPending); err != nil {
+			return err
+		}
+	}
+	return settleDeletion(ctx, tx, userID)
+}
+
+// deliver calls one service to erase a user and records its answer
+func (s *DeletionService) deliver(ctx context.Context, userID uuid.UUID, service string, attempts int) error {
+	target, client := s.config.Services[service], s.clients[service]
+	if client == nil {
+		// The service was removed from USER_DELETION_SERVICES; nobody is left to call
+		err := fmt.Errorf("%s is no longer configured", service)
+		s.recordAttempt(ctx, userID, service, deletionAckFailed, attempts+1, err, nil)
+		return err
+	}
+
+	body, _ := json.Marshal(map[string]string{"user_id": userID.String()})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var receipt json.RawMessage
+	resp, err := client.Do(req)
+	if err == nil {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("%s answered %s: %s", service, resp.Status, strings.TrimSpace(string(data)))
+		} else if json.Valid(data) {
+			receipt = data
+		}
+	}
+
+	attempts++
+	switch {
+	case err == nil:
+		userDeletionCalls.WithLabelValues(service, "acknowledged").Inc()
+		s.recordAttempt(ctx, userID, service, deletionAckAcknowledged, attempts, nil, receipt)
+	case attempts >= s.config.MaxAttempts:
+		userDeletionCalls.WithLabelValues(service, "failed").Inc()
+		log.Printf("Erasure of user %s in %s failed after %d attempts: %v", userID, service, attempts, err)
+		s.recordAttempt(ctx, userID, service, deletionAckFailed, attempts, err, nil)
+	default:
+		userDeletionCalls.WithLabelValues(service, "retrying").Inc()
+		s.recordAttempt(ctx, userID, service, deletionAckPending, attempts, err, nil)
+	}
+	return err
+}
+
+func (s *DeletionService) recordAttempt(ctx context.Context, userID uuid.UUID, service, state string, attempts int, cause error, receipt json.RawMessage) {
+	lastError := ""
+	if cause != nil {
+		lastError = cause.Error()
+	}
+	if receipt == nil {
+		receipt = json.RawMessage("null")
+	}
+	if _, err := s.as.db.ExecContext(ctx, `
+		UPDATE user_deletion_acks SET state = $3, attempts = $4, last_error = $5, receipt = COALESCE($6::jsonb, receipt),
+			next_attempt_at = $7, acknowledged_at = CASE WHEN $3 = $8 THEN NOW() END
+		WHERE user_id = $1 AND service = $2`,
+		userID, service, state, attempts, lastError, string(receipt), time.Now().Add(s.config.retryDelay(attempts)), deletionAckAcknowledged); err != nil {
+		log.Printf("Failed to record erasure of user %s in %s: %v", userID, service, err)
+		return
+	}
+	if err := s.settle(ctx, userID); err != nil {
+		log.Printf("Failed to update deletion of user %s: %v", userID, err)
+	}
+}
+
+// settle derives a deletion's state from its acknowledgments
+func (s *DeletionService) settle(ctx context.Context, userID uuid.UUID) error {
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := settleDeletion(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// settleDeletion updates a deletion's state and, the first time it completes, purges the account
+func settleDeletion(ctx context.Context, db sqlExecer, userID uuid.UUID) error {
+	if _, err := db.ExecContext(ctx, `
+		UPDATE user_deletions d SET
+			state = CASE
+				WHEN EXISTS (SELECT 1 FROM user_deletion_acks a WHERE a.user_id = d.user_id AND a.state = $2) THEN $4
+				WHEN EXISTS (SELECT 1 FROM user_deletion_acks a WHERE a.user_id = d.user_id AND a.state = $3) THEN $5
+				ELSE $6 END,
+			completed_at = CASE
+				WHEN EXISTS (SELECT 1 FROM user_deletion_acks a WHERE a.user_id = d.user_id AND a.state <> $7) THEN NULL
+				ELSE COALESCE(d.completed_at, NOW()) END
+		WHERE d.user_id = $1`,
+		userID, deletionAckFailed, deletionAckPending, deletionFailed, deletionErasing, deletionCompleted, deletionAckAcknowledged); err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, `
+		UPDATE user_deletions SET purged_at = NOW() WHERE user_id = $1 AND state = $2 AND purged_at IS NULL`,
+		userID, deletionCompleted)
+	if err != nil {
+		return err
+	}
+	if purged, _ := result.RowsAffected(); purged == 0 {
+		return nil
+	}
+	for _, purge := range deletionPurges {
+		if _, err := db.ExecContext(ctx, purge, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatch delivers every pending acknowledgment of one user now
+func (s *DeletionService) dispatch(ctx context.Context, userID uuid.UUID) {
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT service, attempts FROM user_deletion_acks WHERE user_id = $1 AND state = $2`, userID, deletionAckPending)
+	if err != nil {
+		log.Printf("Failed to load erasures of user %s: %v", userID, err)
+		return
+	}
+	pending := map[string]int{}
+	for rows.Next() {
+		var service string
+		var attempts int
+		if rows.Scan(&service, &attempts) == nil {
+			pending[service] = attempts
+		}
+	}
+	rows.Close()
+	for service, attempts := range pending {
+		s.deliver(ctx, userID, service, attempts)
+	}
+}
+
+// RunSaga delivers the erasures that are due; it runs as the user_deletion_saga background job
+func (s *DeletionService) RunSaga(ctx context.Context) (string, error) {
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT user_id, service, attempts FROM user_deletion_acks
+		WHERE state = $1 AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at LIMIT $2`, deletionAckPending, deletionBatchSize)
+	if err != nil {
+		return "", err
+	}
+	type due struct {
+		userID   uuid.UUID
+		service  string
+		attempts int
+	}
+	var batch []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.userID, &d.service, &d.attempts); err != nil {
+			rows.Close()
+			return "", err
+		}
+		batch = append(batch, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	failed := 0
+	for _, d := range batch {
+		if err := s.deliver(ctx, d.userID, d.service, d.attempts); err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("delivered %d erasures, %d acknowledged", len(batch), len(batch)-failed), nil
+}
+
+// UserDeletion is a deletion's status with each service's acknowledgment
+type UserDeletion struct {
+	UserID      uuid.UUID     `json:"user_id"`
+	State       string        `json:"state"`
+	RequestedBy string        `json:"requested_by"`
+	Reason      string        `json:"reason,omitempty"`
+	RequestedAt time.Time     `json:"requested_at"`
+	CompletedAt *time.Time    `json:"completed_at,omitempty"`
+	PurgedAt    *time.Time    `json:"purged_at,omitempty"`
+	Services    []DeletionAck `json:"services"`
+}
+
+// DeletionAck is one service's part in a deletion. Receipt is what the service reported
+// erasing.
+type DeletionAck struct {
+	Service        string          `json:"service"`
+	State          string          `json:"state"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	AcknowledgedAt *time.Time      `json:"acknowledged_at,omitempty"`
+	Receipt        json.RawMessage `json:"receipt,omitempty"`
+}
+
+// status loads a user's deletion
+func (s *DeletionService) status(ctx context.Context, userID uuid.UUID) (*UserDeletion, error) {
+	deletion := &UserDeletion{UserID: userID, Services: []DeletionAck{}}
+	if err := s.as.db.QueryRowContext(ctx, `
+		SELECT state, requested_by, reason, requested_at, completed_at, purged_at FROM user_deletions WHERE user_id = $1`, userID).
+		Scan(&deletion.State, &deletion.RequestedBy, &deletion.Reason, &deletion.RequestedAt, &deletion.CompletedAt, &deletion.PurgedAt); err != nil {
+		return nil, err
+	}
+	rows, err := s.as.db.QueryContext(ctx, `
+		SELECT service, state, attempts, last_error, next_attempt_at, acknowledged_at, receipt
+		FROM user_deletion_acks WHERE user_id = $1 ORDER BY service`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ack DeletionAck
+		var receipt []byte
+		if err := rows.Scan(&ack.Service, &ack.State, &ack.Attempts, &ack.LastError, &ack.NextAttemptAt, &ack.AcknowledgedAt, &receipt); err != nil {
+			return nil, err
+		}
+		if ack.State != deletionAckPending {
+			ack.NextAttemptAt = nil
+		}
+		if len(receipt) > 0 && string(receipt) != "null" {
+			ack.Receipt = receipt
+		}
+		deletion.Services = append(deletion.Services, ack)
+	}
+	return deletion, rows.Err()
+}
+
+// deletionUserID parses :user_id, answering 400 itself when it is not a UUID
+func deletionUserID(c *gin.Context) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// AdminEraseUser deletes an account: it is anonymized here at once and every downstream
+// service is asked to erase the user's data. The response is the deletion's status; services
+// that could not be reached are retried by the saga job.
+func (s *DeletionService) AdminEraseUser(c *gin.Context) {
+	userID, ok := deletionUserID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+	}
+	warnings := []string{"The account is anonymized, not removed; its username and email are replaced"}
+	for _, service := range s.config.serviceNames() {
+		warnings = append(warnings, service+" is asked to erase the user's data")
+	}
+	if s.as.previewDryRun(c, "erase_user", userID.String(), warnings,
+		append([]dryRunQuery{
+			{entity: "users", action: "anonymize", id: "id", args: []interface{}{userID},
+				from: "FROM users WHERE id = $1", missing: "User not found"},
+			{entity: "consents", action: "revoke", id: "client_id", args: []interface{}{userID},
+				from: "FROM user_consents WHERE user_id = $1 AND is_revoked = false"},
+		}, liveTokenQueries("revoke", "user_id = $1", userID)...)...) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	adminID, _ := c.Get("user_id")
+	actor := "admin:" + fmt.Sprint(adminID)
+	if isServiceCall(c) {
+		service, _ := c.Get(serviceIdentityKey)
+		actor = "service:" + fmt.Sprint(service)
+	}
+
+	tx, err := s.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase user"})
+		return
+	}
+	defer tx.Rollback()
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists); err != nil || !exists {
+		if err == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase user"})
+		}
+		return
+	}
+	avatarKey, err := s.as.anonymizeUser(ctx, tx, userID)
+	if err == nil {
+		err = s.start(ctx, tx, userID, actor, req.Reason)
+	}
+	if err == nil {
+		// Lifecycle jobs leave anonymized accounts alone
+		if _, err = tx.ExecContext(ctx, `UPDATE user_activity SET stage = $2, stage_changed_at = NOW() WHERE user_id = $1`, userID, lifecycleAnonymized); err == nil {
+			err = recordLifecycleEvent(ctx, tx, userID, lifecycleActionAnonymize, actor, req.Reason, map[string]interface{}{"erased": true})
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Failed to erase user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase user"})
+		return
+	}
+
+	s.as.publishRevocation(RevocationEvent{Type: revocationUser, UserID: userID.String()})
+	s.as.forgetProfiles(ctx, userID)
+	if avatarKey.Valid && s.as.files != nil {
+		if err := s.as.files.store.Delete(ctx, avatarKey.String); err != nil {
+			log.Printf("Failed to delete avatar object %s: %v", avatarKey.String, err)
+		}
+	}
+	var auditUserID *uuid.UUID
+	if id, err := uuid.Parse(fmt.Sprint(adminID)); err == nil {
+		auditUserID = &id
+	}
+	s.as.recordSecurityEvent(c, auditUserID, securityEventUserErased, adminAuditDetails(c, map[string]interface{}{
+		"user_id": userID.String(),
+		"reason":  req.Reason,
+	}))
+
+	// Services that answer now spare the user a wait for the saga job
+	s.dispatch(ctx, userID)
+	deletion, err := s.status(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusAccepted, gin.H{"user_id": userID, "state": deletionErasing})
+		return
+	}
+	status := http.StatusAccepted
+	if deletion.State == deletionCompleted {
+		status = http.StatusOK
+	}
+	c.JSON(status, deletion)
+}
+
+// AdminGetUserDeletion shows whether a deleted user has been erased everywhere
+func (s *DeletionService) AdminGetUserDeletion(c *gin.Context) {
+	userID, ok := deletionUserID(c)
+	if !ok {
+		return
+	}
+	deletion, err := s.status(c.Request.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User has not been deleted"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load deletion"})
+		return
+	}
+	c.JSON(http.StatusOK, deletion)
+}
+
+// AdminRetryUserDeletion gives services that ran out of attempts a fresh set and calls every
+// service still owing an acknowledgment now
+func (s *DeletionService) AdminRetryUserDeletion(c *gin.Context) {
+	userID, ok := deletionUserID(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	result, err := s.as.db.ExecContext(ctx, `
+		UPDATE user_deletion_acks SET state = $2, attempts = 0, next_attempt_at = NOW()
+		WHERE user_id = $1 AND state <> $3`, userID, deletionAckPending, deletionAckAcknowledged)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry deletion"})
+		return
+	}
+	if retried, _ := result.RowsAffected(); retried > 0 {
+		s.settle(ctx, userID)
+		s.dispatch(ctx, userID)
+	}
+	s.AdminGetUserDeletion(c)
+}
+
+// AdminListUserDeletions lists deletions, newest first, optionally in one state
+func (s *DeletionService) AdminListUserDeletions(c *gin.Context) {
+	state := c.Query("state")
+	rows, err := s.as.db.QueryContext(c.Request.Context(), `
+		SELECT d.user_id, d.state, d.requested_by, d.requested_at, d.completed_at,
+			COUNT(a.service) FILTER (WHERE a.state <> $2)
+		FROM user_deletions d LEFT JOIN user_deletion_acks a ON a.user_id = d.user_id
+		WHERE $1 = '' OR d.state = $1
+		GROUP BY d.user_id ORDER BY d.requested_at DESC LIMIT 100`, state, deletionAckAcknowledged)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deletions"})
+		return
+	}
+	defer rows.Close()
+	deletions := []gin.H{}
+	for rows.Next() {
+		var userID uuid.UUID
+		var deletionState, requestedBy string
+		var requestedAt time.Time
+		var completedAt *time.Time
+		var outstanding int
+		if err := rows.Scan(&userID, &deletionState, &requestedBy, &requestedAt, &completedAt, &outstanding); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deletions"})
+			return
+		}
+		deletions = append(deletions, gin.H{
+			"user_id":              userID,
+			"state":                deletionState,
+			"requested_by":         requestedBy,
+			"requested_at":         requestedAt,
+			"completed_at":         completedAt,
+			"outstanding_services": outstanding,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"deletions": deletions})
+}
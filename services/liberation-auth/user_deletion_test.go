@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+type UserDeletionTestSuite struct {
+	suite.Suite
+}
+
+func (suite *UserDeletionTestSuite) TestConfig() {
+	config, err := DefaultDeletionSagaConfig()
+	suite.Require().NoError(err)
+	suite.Empty(config.Services)
+	suite.Equal(10*time.Second, config.Timeout)
+	suite.Equal(time.Minute, config.RetryInterval)
+	suite.Equal(10, config.MaxAttempts)
+
+	service, err := NewDeletionService(&AuthService{}, config)
+	suite.NoError(err, "without services there is nothing to sign")
+	suite.NotNil(service, "lifecycle anonymization records deletions even without services")
+
+	suite.T().Setenv("USER_DELETION_SERVICES", "liberation-ai=http://liberation-ai:8080/v1/admin/erasures, billing=https://billing/erase")
+	config, err = DefaultDeletionSagaConfig()
+	suite.Require().NoError(err)
+	suite.Equal([]string{"billing", "liberation-ai"}, config.serviceNames())
+	suite.Equal("http://liberation-ai:8080/v1/admin/erasures", config.Services["liberation-ai"])
+	_, err = NewDeletionService(&AuthService{}, config)
+	suite.ErrorContains(err, "SERVICE_IDENTITY_PRIVATE_KEY_FILE", "erasure calls must be signed")
+
+	for _, invalid := range []string{"liberation-ai", "=http://liberation-ai", "liberation-ai=liberation-ai:8080"} {
+		suite.T().Setenv("USER_DELETION_SERVICES", invalid)
+		_, err = DefaultDeletionSagaConfig()
+		suite.ErrorContains(err, "USER_DELETION_SERVICES", invalid)
+	}
+
+	suite.T().Setenv("USER_DELETION_SERVICES", "")
+	suite.T().Setenv("USER_DELETION_MAX_ATTEMPTS", "0")
+	_, err = DefaultDeletionSagaConfig()
+	suite.ErrorContains(err, "USER_DELETION_MAX_ATTEMPTS")
+}
+
+func (suite *UserDeletionTestSuite) TestRetryDelay() {
+	config := DeletionSagaConfig{RetryInterval: time.Minute}
+	suite.Equal(time.Minute, config.retryDelay(1))
+	suite.Equal(2*time.Minute, config.retryDelay(2))
+	suite.Equal(16*time.Minute, config.retryDelay(5))
+	suite.Equal(time.Hour, config.retryDelay(7), "capped at an hour")
+	suite.Equal(time.Hour, config.retryDelay(1000))
+}
+
+func TestUserDeletion(t *testing.T) {
+	suite.Run(t, new(UserDeletionTestSuite))
+}
+
+// UserDeletionSagaTestSuite runs against TEST_DATABASE_URL, with the downstream services faked
+type UserDeletionSagaTestSuite struct {
+	suite.Suite
+	db         *sql.DB
+	router     *gin.Engine
+	failing    map[string]*atomic.Bool
+	calls      map[string]*atomic.Int32
+	downstream map[string]*httptest.Server
+}
+
+func (suite *UserDeletionSagaTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.db = openTestDatabase(suite.T())
+	suite.failing = map[string]*atomic.Bool{}
+	suite.calls = map[string]*atomic.Int32{}
+	suite.downstream = map[string]*httptest.Server{}
+	for _, name := range []string{"billing", "liberation-ai"} {
+		failing, calls := &atomic.Bool{}, &atomic.Int32{}
+		suite.failing[name], suite.calls[name] = failing, calls
+		suite.downstream[name] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if failing.Load() {
+				http.Error(w, "erasure backend down", http.StatusServiceUnavailable)
+				return
+			}
+			json.NewEncoder(w).Encode(gin.H{"user_id": body["user_id"], "erased": 3})
+		}))
+		suite.T().Cleanup(suite.downstream[name].Close)
+	}
+	suite.useServices("billing", "liberation-ai")
+}
+
+// useServices routes the admin endpoints to a saga that calls the named services
+func (suite *UserDeletionSagaTestSuite) useServices(names ...string) *DeletionService {
+	as := &AuthService{db: suite.db, tokenCache: newTokenCache(time.Minute, 100)}
+	deletions := &DeletionService{as: as, clients: map[string]*http.Client{}, config: DeletionSagaConfig{
+		Services: map[string]string{}, Timeout: time.Second, RetryInterval: time.Second, MaxAttempts: 2,
+	}}
+	for _, name := range names {
+		deletions.config.Services[name] = suite.downstream[name].URL
+		deletions.clients[name] = suite.downstream[name].Client()
+	}
+	as.deletions = deletions
+
+	suite.router = gin.New()
+	suite.router.Use(func(c *gin.Context) { c.Set("user_id", uuid.New()) })
+	suite.router.POST("/users/:user_id/erase", deletions.AdminEraseUser)
+	suite.router.GET("/users/:user_id/deletion", deletions.AdminGetUserDeletion)
+	suite.router.POST("/users/:user_id/deletion/retry", deletions.AdminRetryUserDeletion)
+	return deletions
+}
+
+// createDeletableUser creates a user with a device and a recovery contact for the purge to remove
+func (suite *UserDeletionSagaTestSuite) createDeletableUser() uuid.UUID {
+	userID := createTestUser(suite.T(), suite.db)
+	_, err := suite.db.Exec(`
+		INSERT INTO user_devices (id, user_id, client_id, platform, ip_address) VALUES ($1, $2, $3, 'ios', '192.0.2.1')`,
+		uuid.New(), userID, uuid.New())
+	suite.Require().NoError(err)
+	_, err = suite.db.Exec(`INSERT INTO recovery_contacts (user_id, contact_id, status) VALUES ($1, $2, 'accepted')`, userID, uuid.New())
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() {
+		for _, table := range []string{"user_deletions", "user_devices", "recovery_contacts", "user_activity", "account_lifecycle_events", "security_events"} {
+			suite.db.Exec(`DELETE FROM `+table+` WHERE user_id = $1`, userID)
+		}
+	})
+	return userID
+}
+
+func (suite *UserDeletionSagaTestSuite) do(method, path string) (int, UserDeletion) {
+	recorder := httptest.NewRecorder()
+	suite.router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	var deletion UserDeletion
+	json.Unmarshal(recorder.Body.Bytes(), &deletion)
+	return recorder.Code, deletion
+}
+
+// leftovers counts the user's rows the final purge removes
+func (suite *UserDeletionSagaTestSuite) leftovers(userID uuid.UUID) int {
+	var count int
+	suite.Require().NoError(suite.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM user_devices WHERE user_id = $1) + (SELECT COUNT(*) FROM recovery_contacts WHERE user_id = $1)`,
+		userID).Scan(&count))
+	return count
+}
+
+// runDue makes every pending erasure due and runs the saga job once
+func (suite *UserDeletionSagaTestSuite) runDue(deletions *DeletionService) {
+	_, err := suite.db.Exec(`UPDATE user_deletion_acks SET next_attempt_at = NOW() WHERE state = $1`, deletionAckPending)
+	suite.Require().NoError(err)
+	_, err = deletions.RunSaga(context.Background())
+	suite.Require().NoError(err)
+}
+
+func (suite *UserDeletionSagaTestSuite) TestBothPhases() {
+	deletions := suite.useServices("billing", "liberation-ai")
+	userID := suite.createDeletableUser()
+	suite.failing["billing"].Store(true)
+
+	// The account is anonymized at once and reachable services acknowledge in the same request
+	status, deletion := suite.do(http.MethodPost, "/users/"+userID.String()+"/erase")
+	suite.Require().Equal(http.StatusAccepted, status)
+	suite.Equal(deletionErasing, deletion.State)
+	suite.Require().Len(deletion.Services, 2)
+	billing, ai := deletion.Services[0], deletion.Services[1]
+	suite.Equal(deletionAckPending, billing.State)
+	suite.Equal(1, billing.Attempts)
+	suite.Contains(billing.LastError, "503")
+	suite.NotNil(billing.NextAttemptAt)
+	suite.Equal(deletionAckAcknowledged, ai.State)
+	suite.JSONEq(`{"user_id": "`+userID.String()+`", "erased": 3}`, string(ai.Receipt))
+
+	var username string
+	var active bool
+	suite.Require().NoError(suite.db.QueryRow(`SELECT username, is_active FROM users WHERE id = $1`, userID).Scan(&username, &active))
+	suite.Equal("deleted_"+strings.ReplaceAll(userID.String(), "-", ""), username)
+	suite.False(active)
+	suite.Equal(2, suite.leftovers(userID), "nothing is purged while erasure is owed")
+	suite.Nil(deletion.PurgedAt)
+
+	// The saga job delivers the missing acknowledgment and completes the deletion
+	suite.failing["billing"].Store(false)
+	suite.runDue(deletions)
+	status, deletion = suite.do(http.MethodGet, "/users/"+userID.String()+"/deletion")
+	suite.Require().Equal(http.StatusOK, status)
+	suite.Equal(deletionCompleted, deletion.State)
+	suite.NotNil(deletion.CompletedAt)
+	suite.Equal(deletionAckAcknowledged, deletion.Services[0].State)
+	suite.Equal(2, deletion.Services[0].Attempts)
+	suite.Nil(deletion.Services[0].NextAttemptAt)
+	suite.Equal(int32(1), suite.calls["liberation-ai"].Load(), "acknowledged services are not called again")
+
+	suite.NotNil(deletion.PurgedAt)
+	suite.Zero(suite.leftovers(userID))
+}
+
+func (suite *UserDeletionSagaTestSuite) TestRetryAfterFailedAcknowledgement() {
+	deletions := suite.useServices("billing")
+	userID := suite.createDeletableUser()
+	suite.failing["billing"].Store(true)
+
+	suite.do(http.MethodPost, "/users/"+userID.String()+"/erase")
+	suite.runDue(deletions)
+	_, deletion := suite.do(http.MethodGet, "/users/"+userID.String()+"/deletion")
+	suite.Equal(deletionFailed, deletion.State, "billing ran out of attempts")
+	suite.Equal(deletionAckFailed, deletion.Services[0].State)
+	suite.Equal(2, deletion.Services[0].Attempts)
+
+	suite.runDue(deletions)
+	suite.Equal(int32(2), suite.calls["billing"].Load(), "the job leaves failed services alone")
+	suite.Equal(2, suite.leftovers(userID))
+
+	suite.failing["billing"].Store(false)
+	status, deletion := suite.do(http.MethodPost, "/users/"+userID.String()+"/deletion/retry")
+	suite.Require().Equal(http.StatusOK, status)
+	suite.Equal(deletionCompleted, deletion.State)
+	suite.Equal(deletionAckAcknowledged, deletion.Services[0].State)
+	suite.Equal(1, deletion.Services[0].Attempts, "a retry starts a fresh set of attempts")
+	suite.NotNil(deletion.PurgedAt)
+	suite.Zero(suite.leftovers(userID))
+}
+
+func (suite *UserDeletionSagaTestSuite) TestWithoutServicesCompletesAtOnce() {
+	suite.useServices()
+	userID := suite.createDeletableUser()
+
+	status, deletion := suite.do(http.MethodPost, "/users/"+userID.String()+"/erase")
+	suite.Require().Equal(http.StatusOK, status)
+	suite.Equal(deletionCompleted, deletion.State)
+	suite.Empty(deletion.Services)
+	suite.NotNil(deletion.PurgedAt)
+	suite.Zero(suite.leftovers(userID))
+}
+
+func (suite *UserDeletionSagaTestSuite) TestUnknownUsers() {
+	status, _ := suite.do(http.MethodPost, "/users/"+uuid.NewString()+"/erase")
+	suite.Equal(http.StatusNotFound, status)
+	status, _ = suite.do(http.MethodGet, "/users/"+uuid.NewString()+"/deletion")
+	suite.Equal(http.StatusNotFound, status)
+	status, _ = suite.do(http.MethodGet, "/users/not-a-uuid/deletion")
+	suite.Equal(http.StatusBadRequest, status)
+}
+
+func TestUserDeletionSaga(t *testing.T) {
+	suite.Run(t, new(UserDeletionSagaTestSuite))
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// importResetHash is stored for imported users whose password hash cannot be reused. It is
+// not a bcrypt hash, so no password matches it until the user follows their reset link.
+const importResetHash = "!import-reset-required"
+
+// importPreferenceColumns are the user_preferences columns an import may fill
+var importPreferenceColumns = map[string]bool{
+	"profile_visibility":  true,
+	"work_visibility":     true,
+	"comment_permissions": true,
+	"skin_theme":          true,
+}
+
+// ImportTable maps an external table to the columns the import reads. Each column value is
+// a column name or SQL expression over Table, which may itself be a join.
+type ImportTable struct {
+	Table   string            `json:"table"`
+	Columns map[string]string `json:"columns"`
+}
+
+// RoleImport maps external role assignments; Map turns external role names into roles here,
+// and roles it leaves out are not imported
+type RoleImport struct {
+	ImportTable
+	Map map[string]string `json:"map"`
+}
+
+// UserImportMapping describes an external user database, such as an AO3 or other devise
+// application, and how its users map onto liberation-auth
+type UserImportMapping struct {
+	// Source is the external Postgres URL; IMPORT_SOURCE_URL overrides it
+	Source string `json:"source"`
+	// Users needs id, username, email and password_hash columns, and may map display_name,
+	// verified, active and created_at
+	Users ImportTable `json:"users"`
+	// Roles needs user_id and role columns
+	Roles *RoleImport `json:"roles,omitempty"`
+	// Preferences needs a user_id column; the others are user_preferences columns
+	Preferences *ImportTable `json:"preferences,omitempty"`
+	// Pepper is set when the external app peppered its hashes (devise config.pepper), which
+	// makes them unusable here, so every user gets a reset link
+	Pepper bool `json:"pepper"`
+	// ResetURL is where reset links point; the token is added as ?token=
+	ResetURL string `json:"reset_url"`
+	// ResetTTL is how long reset links work, e.g. "168h"
+	ResetTTL string `json:"reset_ttl"`
+}
+
+// validate checks the mapping names every column the import needs
+func (m *UserImportMapping) validate() error {
+	if m.Users.Table == "" {
+		return errors.New("users.table is required")
+	}
+	for _, column := range []string{"id", "username", "email", "password_hash"} {
+		if m.Users.Columns[column] == "" {
+			return fmt.Errorf("users.columns.%s is required", column)
+		}
+	}
+	if m.Roles != nil && (m.Roles.Table == "" || m.Roles.Columns["user_id"] == "" || m.Roles.Columns["role"] == "") {
+		return errors.New("roles needs a table and user_id and role columns")
+	}
+	if m.Preferences != nil {
+		if m.Preferences.Table == "" || m.Preferences.Columns["user_id"] == "" {
+			return errors.New("preferences needs a table and a user_id column")
+		}
+		for column := range m.Preferences.Columns {
+			if column != "user_id" && !importPreferenceColumns[column] {
+				return fmt.Errorf("preferences.columns.%s is not a user_preferences column", column)
+			}
+		}
+	}
+	if m.ResetTTL != "" {
+		if _, err := time.ParseDuration(m.ResetTTL); err != nil {
+			return fmt.Errorf("reset_ttl: %v", err)
+		}
+	}
+	return nil
+}
+
+// loadUserImportMapping reads a mapping file and fills in defaults
+func loadUserImportMapping(path string) (*UserImportMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mapping UserImportMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if source := getEnv("IMPORT_SOURCE_URL", ""); source != "" {
+		mapping.Source = source
+	}
+	if mapping.Source == "" {
+		return nil, errors.New("set source in the mapping or IMPORT_SOURCE_URL")
+	}
+	if mapping.ResetURL == "" {
+		mapping.ResetURL = strings.TrimSuffix(getEnv("BASE_URL", "https://ao3.example.com"), "/") + "/reset-password"
+	}
+	if mapping.ResetTTL == "" {
+		mapping.ResetTTL = "168h"
+	}
+	return &mapping, mapping.validate()
+}
+
+// selectColumns builds a query selecting each mapped column under its own name. Columns
+// are listed in order so rows scan the same way every time.
+func (t ImportTable) selectColumns(names []string) string {
+	selected := make([]string, len(names))
+	for i, name := range names {
+		selected[i] = "(" + t.Columns[name] + ") AS " + pq.QuoteIdentifier(name)
+	}
+	return "SELECT " + strings.Join(selected, ", ") + " FROM " + t.Table
+}
+
+// mappedColumns lists the columns of names that the table maps
+func (t ImportTable) mappedColumns(names ...string) []string {
+	var mapped []string
+	for _, name := range names {
+		if t.Columns[name] != "" {
+			mapped = append(mapped, name)
+		}
+	}
+	return mapped
+}
+
+// importedUser is one user read from the external database
+type importedUser struct {
+	SourceID     string
+	Username     string
+	Email        string
+	PasswordHash string
+	DisplayName  string
+	Verified     bool
+	Active       bool
+	CreatedAt    time.Time
+}
+
+// reusablePasswordHash reports whether a hash can be checked here as it is: bcrypt, in any
+// of the $2a$, $2b$ and $2y$ variants, and not peppered
+func reusablePasswordHash(hash string, peppered bool) bool {
+	if peppered || !strings.HasPrefix(hash, "$2") {
+		return false
+	}
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+// ImportConflict is a user that was not imported, and why
+type ImportConflict struct {
+	SourceID string `json:"source_id"`
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+}
+
+// ImportReport summarises an import run
+type ImportReport struct {
+	DryRun          bool             `json:"dry_run"`
+	Imported        int              `json:"imported"`
+	HashesKept      int              `json:"hashes_kept"`
+	ResetsRequired  int              `json:"resets_required"`
+	AlreadyImported int              `json:"already_imported"`
+	Conflicts       []ImportConflict `json:"conflicts"`
+	// UnmappedRoles counts role assignments skipped because the mapping has no role for them
+	UnmappedRoles map[string]int `json:"unmapped_roles,omitempty"`
+	// ResetNotificationsFailed lists users whose reset link could not be sent
+	ResetNotificationsFailed []string `json:"reset_notifications_failed,omitempty"`
+}
+
+func (r *ImportReport) conflict(user importedUser, reason string) {
+	r.Conflicts = append(r.Conflicts, ImportConflict{SourceID: user.SourceID, Username: user.Username, Reason: reason})
+}
+
+func (r *ImportReport) print(w io.Writer) {
+	if r.DryRun {
+		fmt.Fprintln(w, "🧪 Dry run; nothing was written and no emails were sent")
+	}
+	fmt.Fprintf(w, "✅ %d users imported (%d kept their password, %d need a reset)\n", r.Imported, r.HashesKept, r.ResetsRequired)
+	fmt.Fprintf(w, "⏭️  %d already imported\n", r.AlreadyImported)
+	if len(r.Conflicts) > 0 {
+		fmt.Fprintf(w, "⚠️  %d conflicts\n", len(r.Conflicts))
+		for _, conflict := range r.Conflicts {
+			fmt.Fprintf(w, "   %s %s: %s\n", conflict.SourceID, conflict.Username, conflict.Reason)
+		}
+	}
+	if len(r.UnmappedRoles) > 0 {
+		roles := make([]string, 0, len(r.UnmappedRoles))
+		for role, count := range r.UnmappedRoles {
+			roles = append(roles, fmt.Sprintf("%s (%d)", role, count))
+		}
+		sort.Strings(roles)
+		fmt.Fprintf(w, "⚠️  Roles not in the mapping were skipped: %s\n", strings.Join(roles, ", "))
+	}
+	if len(r.ResetNotificationsFailed) > 0 {
+		fmt.Fprintf(w, "❌ %d reset links could not be sent: %s\n", len(r.ResetNotificationsFailed), strings.Join(r.ResetNotificationsFailed, ", "))
+	}
+}
+
+// UserImporter copies users from an external database
+type UserImporter struct {
+	as       *AuthService
+	mapping  *UserImportMapping
+	source   *sql.DB
+	notifier Notifier
+	dryRun   bool
+}
+
+// Run imports every user not imported before, in source ID order, so an interrupted run
+// can be started again. limit stops after that many users; zero imports them all.
+func (im *UserImporter) Run(ctx context.Context, limit int) (*ImportReport, error) {
+	report := &ImportReport{DryRun: im.dryRun, Conflicts: []ImportConflict{}, UnmappedRoles: map[string]int{}}
+	users := im.mapping.Users
+	columns := append([]string{"id", "username", "email", "password_hash"},
+		users.mappedColumns("display_name", "verified", "active", "created_at")...)
+	query := users.selectColumns(columns) + " ORDER BY 1"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := im.source.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("reading users: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user, err := scanImportedUser(rows, columns)
+		if err != nil {
+			return nil, fmt.Errorf("reading users: %w", err)
+		}
+		if err := im.importUser(ctx, user, report); err != nil {
+			return report, fmt.Errorf("importing user %s: %w", user.SourceID, err)
+		}
+	}
+	return report, rows.Err()
+}
+
+func scanImportedUser(rows *sql.Rows, columns []string) (importedUser, error) {
+	user := importedUser{Verified: true, Active: true, CreatedAt: time.Now()}
+	var displayName sql.NullString
+	var verified, active sql.NullBool
+	var createdAt sql.NullTime
+	targets := map[string]interface{}{
+		"id": &user.SourceID, "username": &user.Username, "email": &user.Email, "password_hash": &user.PasswordHash,
+		"display_name": &displayName, "verified": &verified, "active": &active, "created_at": &createdAt,
+	}
+	dest := make([]interface{}, len(columns))
+	for i, column := range columns {
+		dest[i] = targets[column]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return user, err
+	}
+	if displayName.Valid {
+		user.DisplayName = displayName.String
+	}
+	if verified.Valid {
+		user.Verified = verified.Bool
+	}
+	if active.Valid {
+		user.Active = active.Bool
+	}
+	if createdAt.Valid {
+		user.CreatedAt = createdAt.Time
+	}
+	user.Email = strings.TrimSpace(user.Email)
+	return user, nil
+}
+
+// importUser imports one user with their roles and preferences in one transaction, and
+// sends a reset link afterwards when their password hash cannot be kept
+func (im *UserImporter) importUser(ctx context.Context, user importedUser, report *ImportReport) error {
+	var existing uuid.UUID
+	err := im.as.db.QueryRowContext(ctx, `SELECT user_id FROM imported_users WHERE source_id = $1`, user.SourceID).Scan(&existing)
+	if err == nil {
+		report.AlreadyImported++
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	username, nameErr := im.as.checkUsername(ctx, user.Username)
+	if nameErr != nil {
+		report.conflict(user, nameErr.Code+": "+nameErr.Description)
+		return nil
+	}
+	if user.Email == "" {
+		report.conflict(user, "missing_email")
+		return nil
+	}
+	var taken string
+	err = im.as.db.QueryRowContext(ctx, `
+		SELECT CASE WHEN lower(username) = lower($1) THEN 'username_taken' ELSE 'email_taken' END
+		FROM users WHERE lower(username) = lower($1) OR email = $2 OR email_bidx = ANY($3)
+		LIMIT 1`, username, user.Email, pq.Array(im.as.pii.lookupIndexes(piiUserEmail, user.Email))).Scan(&taken)
+	if err == nil {
+		report.conflict(user, taken)
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	userID := uuid.New()
+	passwordHash := user.PasswordHash
+	resetRequired := !reusablePasswordHash(passwordHash, im.mapping.Pepper)
+	if resetRequired {
+		passwordHash = importResetHash
+	}
+	displayName := user.DisplayName
+	if displayName == "" {
+		displayName = username
+	}
+
+	tx, err := im.as.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO users (id, username, email, email_bidx, password_hash, display_name, is_active, is_verified, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())`,
+		userID, username, im.as.pii.value(piiUserEmail, user.Email), im.as.pii.index(piiUserEmail, user.Email),
+		passwordHash, displayName, user.Active, user.Verified, user.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			report.conflict(user, "duplicate: "+pqErr.Constraint)
+			return nil
+		}
+		return err
+	}
+	if err := im.importRoles(ctx, tx, user, userID, report); err != nil {
+		return fmt.Errorf("roles: %w", err)
+	}
+	if err := im.importPreferences(ctx, tx, user, userID); err != nil {
+		return fmt.Errorf("preferences: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO imported_users (source_id, user_id, password_reset_required) VALUES ($1, $2, $3)`,
+		user.SourceID, userID, resetRequired); err != nil {
+		return err
+	}
+
+	var resetToken string
+	resetExpires := time.Now().Add(im.resetTTL())
+	if resetRequired {
+		if resetToken, err = generateSecureToken(); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, NOW())`,
+			userID, hashStoredToken(resetToken), resetExpires); err != nil {
+			return err
+		}
+	}
+
+	report.Imported++
+	if resetRequired {
+		report.ResetsRequired++
+	} else {
+		report.HashesKept++
+	}
+	if im.dryRun {
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if resetRequired {
+		err := im.notifier.Notify(ctx, Notification{
+			Type:     "password_reset_required",
+			UserID:   userID,
+			Email:    user.Email,
+			Username: username,
+			Data:     map[string]interface{}{"reset_url": im.resetLink(resetToken), "expires_at": resetExpires, "reason": "account_imported"},
+		})
+		if err != nil {
+			log.Printf("Failed to send reset link to imported user %s: %v", userID, err)
+			report.ResetNotificationsFailed = append(report.ResetNotificationsFailed, user.SourceID)
+		}
+	}
+	return nil
+}
+
+func (im *UserImporter) resetTTL() time.Duration {
+	ttl, _ := time.ParseDuration(im.mapping.ResetTTL)
+	return ttl
+}
+
+// resetLink adds the reset token to the mapping's reset URL
+func (im *UserImporter) resetLink(token string) string {
+	link, err := url.Parse(im.mapping.ResetURL)
+	if err != nil {
+		return im.mapping.ResetURL + "?token=" + url.QueryEscape(token)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// importRoles copies the user's mapped roles
+func (im *UserImporter) importRoles(ctx context.Context, tx *sql.Tx, user importedUser, userID uuid.UUID, report *ImportReport) error {
+	roles := im.mapping.Roles
+	if roles == nil {
+		return nil
+	}
+	rows, err := im.source.QueryContext(ctx, roles.selectColumns([]string{"role"})+" WHERE ("+roles.Columns["user_id"]+")::text = $1", user.SourceID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var external string
+		if err := rows.Scan(&external); err != nil {
+			return err
+		}
+		role, ok := roles.Map[external]
+		if !ok {
+			report.UnmappedRoles[external]++
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO user_roles (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userID, role); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// importPreferences copies the user's preferences, if they have any
+func (im *UserImporter) importPreferences(ctx context.Context, tx *sql.Tx, user importedUser, userID uuid.UUID) error {
+	preferences := im.mapping.Preferences
+	if preferences == nil {
+		return nil
+	}
+	var columns []string
+	for column := range preferences.Columns {
+		if column != "user_id" {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	sort.Strings(columns)
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err := im.source.QueryRowContext(ctx, preferences.selectColumns(columns)+" WHERE ("+preferences.Columns["user_id"]+")::text = $1 LIMIT 1", user.SourceID).Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	insertColumns := []string{"user_id"}
+	placeholders := []string{"$1"}
+	args := []interface{}{userID}
+	for i, column := range columns {
+		if !values[i].Valid {
+			continue
+		}
+		args = append(args, values[i].String)
+		insertColumns = append(insertColumns, column)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_preferences (`+strings.Join(insertColumns, ", ")+`, created_at, updated_at)
+		VALUES (`+strings.Join(placeholders, ", ")+`, NOW(), NOW()) ON CONFLICT (user_id) DO NOTHING`, args...)
+	return err
+}
+
+// runUserImport runs `liberation-auth import-users` and returns the process exit code
+func runUserImport(args []string, w io.Writer) int {
+	flags := flag.NewFlagSet("import-users", flag.ContinueOnError)
+	flags.SetOutput(w)
+	mappingPath := flags.String("mapping", "import-mapping.json", "JSON file mapping the external schema")
+	dryRun := flags.Bool("dry-run", false, "check every user and report conflicts without writing or emailing")
+	reportPath := flags.String("report", "", "also write the report as JSON to this file")
+	limit := flags.Int("limit", 0, "import at most this many users, for a trial run")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	mapping, err := loadUserImportMapping(*mappingPath)
+	if err != nil {
+		fmt.Fprintln(w, "❌ Invalid mapping:", err)
+		return 2
+	}
+	source, err := sql.Open("postgres", mapping.Source)
+	if err != nil {
+		fmt.Fprintln(w, "❌ Invalid source:", err)
+		return 2
+	}
+	defer source.Close()
+
+	notifier, err := NewNotifierFromEnv()
+	if err != nil {
+		fmt.Fprintln(w, "❌ Invalid notification settings:", err)
+		return 2
+	}
+	authService := NewAuthService()
+	defer authService.Close()
+
+	importer := &UserImporter{as: authService, mapping: mapping, source: source, notifier: authService.localizeNotifications(notifier), dryRun: *dryRun}
+	report, err := importer.Run(context.Background(), *limit)
+	if report != nil {
+		report.print(w)
+		if *reportPath != "" {
+			if data, marshalErr := json.MarshalIndent(report, "", "  "); marshalErr == nil {
+				if writeErr := os.WriteFile(*reportPath, data, 0o600); writeErr != nil {
+					fmt.Fprintln(w, "❌ Failed to write report:", writeErr)
+				}
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(w, "❌ Import stopped:", err)
+		fmt.Fprintln(w, "   Users imported so far are kept; run the import again to continue.")
+		return 1
+	}
+	return 0
+}
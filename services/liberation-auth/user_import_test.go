@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type UserImportTestSuite struct {
+	suite.Suite
+}
+
+// ao3Mapping maps the users, roles and preferences tables of an AO3 database
+const ao3Mapping = `{
+	"source": "postgres://readonly@legacy/otwarchive",
+	"users": {
+		"table": "users",
+		"columns": {
+			"id": "id",
+			"username": "login",
+			"email": "email",
+			"password_hash": "encrypted_password",
+			"verified": "confirmed_at IS NOT NULL",
+			"created_at": "created_at"
+		}
+	},
+	"roles": {
+		"table": "roles_users JOIN roles ON roles.id = roles_users.role_id",
+		"columns": {"user_id": "roles_users.user_id", "role": "roles.name"},
+		"map": {"admin": "admin", "tag_wrangler": "tag_wrangler"}
+	},
+	"preferences": {
+		"table": "preferences",
+		"columns": {"user_id": "user_id", "skin_theme": "skin_id::text"}
+	}
+}`
+
+func (suite *UserImportTestSuite) writeMapping(content string) string {
+	path := filepath.Join(suite.T().TempDir(), "mapping.json")
+	suite.Require().NoError(os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func (suite *UserImportTestSuite) TestLoadMapping() {
+	suite.T().Setenv("BASE_URL", "https://archive.example.org/")
+	mapping, err := loadUserImportMapping(suite.writeMapping(ao3Mapping))
+	suite.Require().NoError(err)
+	suite.Equal("https://archive.example.org/reset-password", mapping.ResetURL)
+	suite.Equal("168h", mapping.ResetTTL)
+
+	suite.T().Setenv("IMPORT_SOURCE_URL", "postgres://import@replica/otwarchive")
+	mapping, err = loadUserImportMapping(suite.writeMapping(ao3Mapping))
+	suite.Require().NoError(err)
+	suite.Equal("postgres://import@replica/otwarchive", mapping.Source, "the environment keeps credentials out of the file")
+}
+
+func (suite *UserImportTestSuite) TestMappingNeedsCoreColumns() {
+	_, err := loadUserImportMapping(suite.writeMapping(`{"source": "postgres://legacy", "users": {"table": "users", "columns": {"id": "id", "username": "login", "email": "email"}}}`))
+	suite.ErrorContains(err, "password_hash")
+
+	_, err = loadUserImportMapping(suite.writeMapping(`{"source": "postgres://legacy", "users": {"table": "users", "columns": {"id": "id", "username": "login", "email": "email", "password_hash": "encrypted_password"}},
+		"preferences": {"table": "preferences", "columns": {"user_id": "user_id", "is_admin": "true"}}}`))
+	suite.ErrorContains(err, "is_admin", "only user_preferences columns may be written")
+}
+
+func (suite *UserImportTestSuite) TestSelectColumns() {
+	mapping, err := loadUserImportMapping(suite.writeMapping(ao3Mapping))
+	suite.Require().NoError(err)
+
+	columns := mapping.Users.mappedColumns("display_name", "verified", "active", "created_at")
+	suite.Equal([]string{"verified", "created_at"}, columns)
+	suite.Equal(`SELECT (login) AS "username", (confirmed_at IS NOT NULL) AS "verified" FROM users`,
+		mapping.Users.selectColumns([]string{"username", "verified"}))
+}
+
+func (suite *UserImportTestSuite) TestReusablePasswordHash() {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret-password"), bcrypt.MinCost)
+	suite.Require().NoError(err)
+	suite.True(reusablePasswordHash(string(hash), false))
+
+	// Devise writes $2a$; PHP and some Rails setups write $2y$
+	suite.True(reusablePasswordHash("$2y$"+string(hash[4:]), false))
+	suite.False(reusablePasswordHash(string(hash), true), "peppered hashes cannot be checked here")
+	suite.False(reusablePasswordHash("5f4dcc3b5aa765d61d8327deb882cf99", false))
+	suite.False(reusablePasswordHash("$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA", false))
+	suite.False(reusablePasswordHash(importResetHash, false))
+}
+
+func (suite *UserImportTestSuite) TestResetLink() {
+	importer := &UserImporter{mapping: &UserImportMapping{ResetURL: "https://archive.example.org/reset?lang=en"}}
+	suite.Equal("https://archive.example.org/reset?lang=en&token=abc%2B%2F%3D", importer.resetLink("abc+/="))
+}
+
+func TestUserImportTestSuite(t *testing.T) {
+	suite.Run(t, new(UserImportTestSuite))
+}
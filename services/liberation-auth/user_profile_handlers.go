@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
 	"strconv"
@@ -12,20 +13,36 @@ import (
 
 // GetUserProfile retrieves a user's public profile with AO3-style features
 func (s *AuthService) GetUserProfile(c *gin.Context) {
-	username := c.Param("username")
-	if username == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Username is required"})
+	owner, ok := s.enforceProfilePrivacy(c)
+	if !ok {
 		return
 	}
 
-	// Get current user ID for permission checks
-	var viewerID *uuid.UUID
-	if userIDValue := c.GetString("user_id"); userIDValue != "" {
-		if parsedID, err := uuid.Parse(userIDValue); err == nil {
-			viewerID = &parsedID
-		}
+	profile, err := s.userProfile(c.Request.Context(), owner.ID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user profile"})
+		return
 	}
 
+	c.JSON(http.StatusOK, profile)
+}
+
+// userProfile returns a user's profile from the read cache, or from the database. Privacy is
+// enforced by the caller, so the cached profile is the same whoever asks.
+func (s *AuthService) userProfile(ctx context.Context, userID uuid.UUID) (models.UserProfile, error) {
+	if s.caches == nil {
+		return s.loadUserProfile(ctx, userID)
+	}
+	return s.caches.profiles.Get(ctx, userID.String(), func(ctx context.Context) (models.UserProfile, error) {
+		return s.loadUserProfile(ctx, userID)
+	})
+}
+
+func (s *AuthService) loadUserProfile(ctx context.Context, userID uuid.UUID) (models.UserProfile, error) {
 	// Get user basic info and profile settings
 	query := `
 		SELECT 
@@ -38,7 +55,7 @@ func (s *AuthService) GetUserProfile(c *gin.Context) {
 		FROM users u
 		LEFT JOIN user_preferences up ON u.id = up.user_id
 		LEFT JOIN user_statistics us ON u.id = us.user_id
-		WHERE u.username = $1 AND u.is_active = true
+		WHERE u.id = $1
 	`
 
 	var profile models.UserProfile
@@ -46,8 +63,8 @@ func (s *AuthService) GetUserProfile(c *gin.Context) {
 	var profileVisibility, workVisibility, commentPermissions sql.NullString
 	var lastWorkDate sql.NullTime
 
-	err := s.db.QueryRow(query, username).Scan(
-		&profile.ID, &profile.Username, &displayName, &bio, &location, &website,
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&profile.ID, &profile.Username, &displayName, &bio, s.pii.scan(piiUserLocation, &location), &website,
 		&profile.IsVerified, &profile.CreatedAt,
 		&profileVisibility, &workVisibility, &commentPermissions,
 		&profile.WorksCount, &profile.SeriesCount, &profile.BookmarksCount, &profile.CommentsCount,
@@ -55,13 +72,8 @@ func (s *AuthService) GetUserProfile(c *gin.Context) {
 		&lastWorkDate, &profile.CreatedAt,
 	)
 
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user profile"})
-		return
+		return profile, err
 	}
 
 	// Handle nullable fields
@@ -81,35 +93,9 @@ func (s *AuthService) GetUserProfile(c *gin.Context) {
 		profile.LastWorkDate = &lastWorkDate.Time
 	}
 
-	// Set default visibility if not set
-	visibility := "public"
-	if profileVisibility.Valid {
-		visibility = profileVisibility.String
-	}
-
-	// Check if viewer can see this profile
-	areFriends := false
-	if viewerID != nil {
-		// Check friendship status
-		var friendshipStatus string
-		err = s.db.QueryRow(`
-			SELECT status FROM user_relationships 
-			WHERE ((requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1))
-			AND status = 'accepted'
-		`, *viewerID, profile.ID).Scan(&friendshipStatus)
-		if err == nil {
-			areFriends = true
-		}
-	}
-
-	if !models.CanViewProfile(viewerID, profile.ID, visibility, areFriends) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "This profile is private"})
-		return
-	}
-
 	// Get user's pseudonyms
 	pseudQuery := `SELECT name FROM user_pseudonyms WHERE user_id = $1 ORDER BY is_default DESC, created_at ASC`
-	rows, err := s.db.Query(pseudQuery, profile.ID)
+	rows, err := s.db.QueryContext(ctx, pseudQuery, profile.ID)
 	if err == nil {
 		defer rows.Close()
 		var pseudonyms []string
@@ -124,13 +110,13 @@ func (s *AuthService) GetUserProfile(c *gin.Context) {
 
 	// Get friends count
 	var friendsCount int
-	s.db.QueryRow(`
+	s.db.QueryRowContext(ctx, `
 		SELECT COUNT(*) FROM user_relationships 
 		WHERE (requester_id = $1 OR addressee_id = $1) AND status = 'accepted'
 	`, profile.ID).Scan(&friendsCount)
 	profile.FriendsCount = friendsCount
 
-	c.JSON(http.StatusOK, profile)
+	return profile, nil
 }
 
 // UpdateUserProfile updates the current user's profile
@@ -170,7 +156,7 @@ func (s *AuthService) UpdateUserProfile(c *gin.Context) {
 	}
 	if req.Location != nil {
 		setParts = append(setParts, "location = $"+strconv.Itoa(argCount))
-		args = append(args, *req.Location)
+		args = append(args, s.pii.value(piiUserLocation, *req.Location))
 		argCount++
 	}
 	if req.Website != nil {
@@ -185,11 +171,12 @@ func (s *AuthService) UpdateUserProfile(c *gin.Context) {
 		args = append(args, userID)
 
 		query := "UPDATE users SET " + joinStrings(setParts, ", ") + " WHERE id = $" + strconv.Itoa(argCount)
-		_, err = s.db.Exec(query, args...)
+		_, err = s.db.ExecContext(c.Request.Context(), query, args...)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
 			return
 		}
+		s.forgetProfiles(c.Request.Context(), userID)
 	}
 
 	// Update user preferences if provided
@@ -227,7 +214,7 @@ func (s *AuthService) UpdateUserProfile(c *gin.Context) {
 			VALUES ($` + strconv.Itoa(prefsArgCount) + `, ` + joinStrings(getPrefsPlaceholders(&req, prefsArgCount), ", ") + `, NOW(), NOW())
 			ON CONFLICT (user_id) DO UPDATE SET ` + joinStrings(prefsParts, ", ")
 
-		_, err = s.db.Exec(prefsQuery, prefsArgs...)
+		_, err = s.db.ExecContext(c.Request.Context(), prefsQuery, prefsArgs...)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
 			return
@@ -261,10 +248,16 @@ func (s *AuthService) CreateUserPseudonym(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pseudonym data"})
 		return
 	}
+	name, nameErr := s.checkUsername(c.Request.Context(), req.Name)
+	if nameErr != nil {
+		s.rejectUsername(c, nameErr)
+		return
+	}
+	req.Name = name
 
 	// Check if pseudonym name is already taken
 	var exists bool
-	err = s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM user_pseudonyms WHERE name = $1)", req.Name).Scan(&exists)
+	err = s.db.QueryRowContext(c.Request.Context(), "SELECT EXISTS(SELECT 1 FROM user_pseudonyms WHERE name = $1)", req.Name).Scan(&exists)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check pseudonym availability"})
 		return
@@ -276,13 +269,13 @@ func (s *AuthService) CreateUserPseudonym(c *gin.Context) {
 
 	// If this is the user's first pseudonym or they want it as default, make it default
 	var pseudCount int
-	s.db.QueryRow("SELECT COUNT(*) FROM user_pseudonyms WHERE user_id = $1", userID).Scan(&pseudCount)
+	s.db.QueryRowContext(c.Request.Context(), "SELECT COUNT(*) FROM user_pseudonyms WHERE user_id = $1", userID).Scan(&pseudCount)
 
 	isDefault := req.IsDefault || pseudCount == 0
 
 	// If making this default, unset other defaults
 	if isDefault {
-		s.db.Exec("UPDATE user_pseudonyms SET is_default = false WHERE user_id = $1", userID)
+		s.db.ExecContext(c.Request.Context(), "UPDATE user_pseudonyms SET is_default = false WHERE user_id = $1", userID)
 	}
 
 	// Create the pseudonym
@@ -292,15 +285,16 @@ func (s *AuthService) CreateUserPseudonym(c *gin.Context) {
 		VALUES ($1, $2, $3, $4, $5, $6, NOW())
 	`
 
-	_, err = s.db.Exec(query, pseudonymID, userID, req.Name, isDefault, req.Description, req.IconURL)
+	_, err = s.db.ExecContext(c.Request.Context(), query, pseudonymID, userID, req.Name, isDefault, req.Description, req.IconURL)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pseudonym"})
 		return
 	}
+	s.forgetProfiles(c.Request.Context(), userID)
 
 	// Return the created pseudonym
 	var pseudonym models.UserPseudonym
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(c.Request.Context(), `
 		SELECT id, user_id, name, is_default, description, icon_url, created_at
 		FROM user_pseudonyms WHERE id = $1
 	`, pseudonymID).Scan(
@@ -336,7 +330,7 @@ func (s *AuthService) GetUserPseudonyms(c *gin.Context) {
 		ORDER BY is_default DESC, created_at ASC
 	`
 
-	rows, err := s.db.Query(query, userID)
+	rows, err := s.db.QueryContext(c.Request.Context(), query, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve pseudonyms"})
 		return
@@ -381,7 +375,7 @@ func (s *AuthService) SendFriendRequest(c *gin.Context) {
 
 	// Get target user ID
 	var targetUserID uuid.UUID
-	err = s.db.QueryRow("SELECT id FROM users WHERE username = $1 AND is_active = true", targetUsername).Scan(&targetUserID)
+	err = s.db.QueryRowContext(c.Request.Context(), "SELECT id FROM users WHERE username = $1 AND is_active = true", targetUsername).Scan(&targetUserID)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -399,7 +393,7 @@ func (s *AuthService) SendFriendRequest(c *gin.Context) {
 
 	// Check if relationship already exists
 	var existingStatus string
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(c.Request.Context(), `
 		SELECT status FROM user_relationships 
 		WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)
 	`, userID, targetUserID).Scan(&existingStatus)
@@ -425,7 +419,7 @@ func (s *AuthService) SendFriendRequest(c *gin.Context) {
 		VALUES ($1, $2, $3, 'pending', NOW(), NOW())
 	`
 
-	_, err = s.db.Exec(query, relationshipID, userID, targetUserID)
+	_, err = s.db.ExecContext(c.Request.Context(), query, relationshipID, userID, targetUserID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send friend request"})
 		return
@@ -469,7 +463,7 @@ func (s *AuthService) RespondToFriendRequest(c *gin.Context) {
 	// Verify this is a pending request to this user
 	var requesterID uuid.UUID
 	var status string
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(c.Request.Context(), `
 		SELECT requester_id, status FROM user_relationships 
 		WHERE id = $1 AND addressee_id = $2
 	`, relationshipID, userID).Scan(&requesterID, &status)
@@ -494,7 +488,7 @@ func (s *AuthService) RespondToFriendRequest(c *gin.Context) {
 		newStatus = "accepted"
 	}
 
-	_, err = s.db.Exec(`
+	_, err = s.db.ExecContext(c.Request.Context(), `
 		UPDATE user_relationships 
 		SET status = $1, updated_at = NOW() 
 		WHERE id = $2
@@ -504,6 +498,9 @@ func (s *AuthService) RespondToFriendRequest(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update friend request"})
 		return
 	}
+	if newStatus == "accepted" {
+		s.forgetProfiles(c.Request.Context(), userID, requesterID)
+	}
 
 	message := "Friend request rejected"
 	if newStatus == "accepted" {
@@ -544,7 +541,7 @@ func (s *AuthService) BlockUser(c *gin.Context) {
 
 	// Get target user ID
 	var targetUserID uuid.UUID
-	err = s.db.QueryRow("SELECT id FROM users WHERE username = $1 AND is_active = true", targetUsername).Scan(&targetUserID)
+	err = s.db.QueryRowContext(c.Request.Context(), "SELECT id FROM users WHERE username = $1 AND is_active = true", targetUsername).Scan(&targetUserID)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -561,10 +558,11 @@ func (s *AuthService) BlockUser(c *gin.Context) {
 	}
 
 	// Remove any existing friendship
-	s.db.Exec(`
+	s.db.ExecContext(c.Request.Context(), `
 		DELETE FROM user_relationships 
 		WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)
 	`, userID, targetUserID)
+	s.forgetProfiles(c.Request.Context(), userID, targetUserID)
 
 	// Create or update block
 	blockID := uuid.New()
@@ -577,7 +575,7 @@ func (s *AuthService) BlockUser(c *gin.Context) {
 			created_at = NOW()
 	`
 
-	_, err = s.db.Exec(query, blockID, userID, targetUserID, req.BlockType, req.Reason)
+	_, err = s.db.ExecContext(c.Request.Context(), query, blockID, userID, targetUserID, req.BlockType, req.Reason)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
 		return
@@ -608,7 +606,7 @@ func (s *AuthService) UnblockUser(c *gin.Context) {
 
 	// Get target user ID
 	var targetUserID uuid.UUID
-	err = s.db.QueryRow("SELECT id FROM users WHERE username = $1 AND is_active = true", targetUsername).Scan(&targetUserID)
+	err = s.db.QueryRowContext(c.Request.Context(), "SELECT id FROM users WHERE username = $1 AND is_active = true", targetUsername).Scan(&targetUserID)
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
@@ -619,7 +617,7 @@ func (s *AuthService) UnblockUser(c *gin.Context) {
 	}
 
 	// Remove the block
-	result, err := s.db.Exec("DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2", userID, targetUserID)
+	result, err := s.db.ExecContext(c.Request.Context(), "DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2", userID, targetUserID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
 		return
@@ -676,7 +674,7 @@ func (s *AuthService) GetUserDashboard(c *gin.Context) {
 	var displayName sql.NullString
 	var lastPublished sql.NullTime
 
-	err = s.db.QueryRow(query, userID).Scan(
+	err = s.db.QueryRowContext(c.Request.Context(), query, userID).Scan(
 		&dashboard.ID, &dashboard.Username, &displayName,
 		&dashboard.UnreadNotifications, &dashboard.PublishedWorks, &dashboard.DraftWorks,
 		&dashboard.Bookmarks, &dashboard.Series, &dashboard.TotalHits,
@@ -54,6 +54,7 @@ func (suite *UserProfileHandlersTestSuite) SetupSuite() {
 	api := suite.router.Group("/api/v1")
 	{
 		api.GET("/users/:username", suite.authService.GetUserProfile)
+		api.GET("/users/:username/friends", ProfilePrivacyMiddleware(suite.authService), suite.authService.GetUserFriends)
 		api.PUT("/profile", suite.authService.UpdateUserProfile)
 		api.POST("/pseudonyms", suite.authService.CreateUserPseudonym)
 		api.GET("/pseudonyms", suite.authService.GetUserPseudonyms)
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Reserved name kinds. Blocked names also match when they appear inside a longer name.
+const (
+	reservedKindReserved = "reserved"
+	reservedKindBlocked  = "blocked"
+)
+
+// builtinReservedNames can never be registered, whatever the database says
+var builtinReservedNames = []string{
+	"admin", "administrator", "root", "system", "staff", "moderator", "support", "help", "security", "abuse",
+	"api", "auth", "oauth", "login", "logout", "register", "signup", "settings", "account", "www", "mail",
+	"ao3", "official", "null", "undefined", "anonymous",
+}
+
+// confusables folds characters that render like Latin letters or digits onto one form,
+// following the UTS #39 skeleton idea for the scripts seen in practice
+var confusables = map[rune]string{
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'к': "k", 'м': "m", 'н': "h", 'о': "o", 'р': "p",
+	'с': "c", 'т': "t", 'у': "y", 'х': "x", 'ѕ': "s", 'і': "l", 'ї': "l", 'ј': "j", 'ԁ': "d", 'ԛ': "q", 'ԝ': "w",
+	// Greek
+	'α': "a", 'β': "b", 'ε': "e", 'η': "n", 'ι': "l", 'κ': "k", 'ν': "v", 'ο': "o", 'ρ': "p", 'τ': "t", 'υ': "u", 'χ': "x",
+	// Latin look-alikes
+	'0': "o", '1': "l", 'i': "l", '|': "l", '3': "e", '5': "s", '$': "s", '@': "a",
+}
+
+// UsernamePolicy decides which usernames and pseudonyms are acceptable
+type UsernamePolicy struct {
+	MinLength int
+	MaxLength int
+	// AllowUnicode accepts letters and digits from any script, one script per name
+	AllowUnicode bool
+	// Punctuation lists the symbols allowed between letters
+	Punctuation string
+	// BlocklistFile seeds blocked names (one per line) at startup
+	BlocklistFile string
+}
+
+var defaultUsernamePolicy = UsernamePolicy{MinLength: 3, MaxLength: 40, Punctuation: "_-"}
+
+// DefaultUsernamePolicy reads username rules from the environment
+func DefaultUsernamePolicy() (UsernamePolicy, error) {
+	policy := defaultUsernamePolicy
+	policy.AllowUnicode = getEnv("USERNAME_ALLOW_UNICODE", "false") == "true"
+	policy.Punctuation = getEnv("USERNAME_PUNCTUATION", policy.Punctuation)
+	policy.BlocklistFile = getEnv("USERNAME_BLOCKLIST_FILE", "")
+
+	var err error
+	if policy.MinLength, err = strconv.Atoi(getEnv("USERNAME_MIN_LENGTH", strconv.Itoa(policy.MinLength))); err != nil || policy.MinLength < 1 {
+		return policy, fmt.Errorf("USERNAME_MIN_LENGTH must be a positive number")
+	}
+	if policy.MaxLength, err = strconv.Atoi(getEnv("USERNAME_MAX_LENGTH", strconv.Itoa(policy.MaxLength))); err != nil || policy.MaxLength < policy.MinLength {
+		return policy, fmt.Errorf("USERNAME_MAX_LENGTH must be a number no smaller than USERNAME_MIN_LENGTH")
+	}
+	for _, r := range policy.Punctuation {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return policy, fmt.Errorf("USERNAME_PUNCTUATION may only contain symbols")
+		}
+	}
+	return policy, nil
+}
+
+// usernameError is a rejected name, reported to the client as error and error_description
+type usernameError struct {
+	Code        string
+	Description string
+	// message and args render Description from the catalogs in other locales
+	message string
+	args    []interface{}
+}
+
+// newUsernameError describes a rejected name with a catalog message, in English by default
+func newUsernameError(code, message string, args ...interface{}) *usernameError {
+	return &usernameError{Code: code, Description: translate(defaultLocale, message, args...), message: message, args: args}
+}
+
+func (e *usernameError) Error() string { return e.Description }
+
+// normalizeUsername applies NFKC so full-width and compatibility forms collapse to their plain letters
+func normalizeUsername(name string) string {
+	return norm.NFKC.String(strings.TrimSpace(name))
+}
+
+// validate checks a normalised name against the character and length rules
+func (p UsernamePolicy) validate(name string) *usernameError {
+	length := len([]rune(name))
+	if length < p.MinLength || length > p.MaxLength {
+		return newUsernameError("invalid_username", "username.length", p.MinLength, p.MaxLength)
+	}
+
+	var script *unicode.RangeTable
+	for i, r := range []rune(name) {
+		switch {
+		case strings.ContainsRune(p.Punctuation, r):
+			if i == 0 || i == length-1 {
+				return newUsernameError("invalid_username", "username.edges")
+			}
+		case r < unicode.MaxASCII && unicode.IsDigit(r):
+		case r < unicode.MaxASCII && unicode.IsLetter(r):
+			if script != nil && script != unicode.Latin {
+				return newUsernameError("invalid_username", "username.scripts")
+			}
+			script = unicode.Latin
+		case p.AllowUnicode && (unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)):
+			if rs := scriptOf(r); rs != nil {
+				if script != nil && script != rs {
+					return newUsernameError("invalid_username", "username.scripts")
+				}
+				script = rs
+			}
+		default:
+			message := "username.characters"
+			if p.AllowUnicode {
+				message = "username.script_characters"
+			}
+			if p.Punctuation != "" {
+				return newUsernameError("invalid_username", message+"_punctuation", strings.Join(strings.Split(p.Punctuation, ""), " "))
+			}
+			return newUsernameError("invalid_username", message)
+		}
+	}
+	return nil
+}
+
+// scriptOf returns the script of a letter, or nil for digits and marks that go with any script.
+// Kana and Hangul are reported as Han since Japanese and Korean names mix them.
+func scriptOf(r rune) *unicode.RangeTable {
+	if !unicode.IsLetter(r) {
+		return nil
+	}
+	for _, table := range []*unicode.RangeTable{unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Bopomofo} {
+		if unicode.Is(table, r) {
+			return unicode.Han
+		}
+	}
+	for _, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return table
+		}
+	}
+	return nil
+}
+
+// usernameSkeleton reduces a name to the form used for reserved-name and look-alike matching:
+// lowercase, no accents or punctuation, and confusable characters folded together
+func usernameSkeleton(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(strings.ToLower(name)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if folded, ok := confusables[r]; ok {
+			b.WriteString(folded)
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return strings.ReplaceAll(b.String(), "rn", "m")
+}
+
+// checkUsername normalises a username or pseudonym and rejects it if it breaks the
+// policy or resembles a reserved or blocked name
+func (as *AuthService) checkUsername(ctx context.Context, name string) (string, *usernameError) {
+	policy := as.usernames
+	if policy.MaxLength == 0 {
+		policy = defaultUsernamePolicy
+	}
+
+	normalized := normalizeUsername(name)
+	if err := policy.validate(normalized); err != nil {
+		return "", err
+	}
+
+	skeleton := usernameSkeleton(normalized)
+	for _, reserved := range builtinReservedNames {
+		if skeleton == usernameSkeleton(reserved) {
+			return "", newUsernameError("username_reserved", "username.reserved")
+		}
+	}
+	if as.db == nil {
+		return normalized, nil
+	}
+
+	var kind string
+	err := as.db.QueryRowContext(ctx, `
+		SELECT kind FROM reserved_usernames
+		WHERE skeleton = $1 OR (kind = 'blocked' AND strpos($1, skeleton) > 0)
+		ORDER BY kind LIMIT 1`, skeleton).Scan(&kind)
+	switch {
+	case err == sql.ErrNoRows:
+		return normalized, nil
+	case err != nil:
+		log.Printf("Failed to check reserved usernames: %v", err)
+		return "", newUsernameError("server_error", "username.check_failed")
+	case kind == reservedKindBlocked:
+		return "", newUsernameError("username_unavailable", "username.unavailable")
+	default:
+		return "", newUsernameError("username_reserved", "username.reserved")
+	}
+}
+
+// rejectUsername writes the response for a rejected name
+func (as *AuthService) rejectUsername(c *gin.Context, err *usernameError) {
+	status := http.StatusBadRequest
+	if err.Code == "server_error" {
+		status = http.StatusInternalServerError
+	}
+	description := err.Description
+	if err.message != "" {
+		description = translate(as.requestLocale(c), err.message, err.args...)
+	}
+	c.JSON(status, gin.H{"error": err.Code, "error_description": description})
+}
+
+// seedBlockedUsernames loads the blocklist file into the registry; existing entries are kept
+func (as *AuthService) seedBlockedUsernames(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	seeded := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		skeleton := usernameSkeleton(normalizeUsername(line))
+		if skeleton == "" {
+			continue
+		}
+		result, err := as.db.Exec(`
+			INSERT INTO reserved_usernames (skeleton, name, kind, reason, created_at)
+			VALUES ($1, $2, 'blocked', 'blocklist file', NOW())
+			ON CONFLICT (skeleton) DO NOTHING`, skeleton, line)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			seeded++
+		}
+	}
+	if seeded > 0 {
+		log.Printf("Added %d blocked names from %s", seeded, path)
+	}
+	return scanner.Err()
+}
+
+// Admin API
+
+// AdminListReservedUsernames lists the registry; blocked names are included so moderators can audit them
+func (as *AuthService) AdminListReservedUsernames(c *gin.Context) {
+	query := `SELECT skeleton, name, kind, reason, created_by, created_at FROM reserved_usernames`
+	var args []interface{}
+	if kind := c.Query("kind"); kind != "" {
+		query += ` WHERE kind = $1`
+		args = append(args, kind)
+	}
+	rows, err := as.db.QueryContext(c.Request.Context(), query+` ORDER BY kind, name`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list reserved names"})
+		return
+	}
+	defer rows.Close()
+
+	names := []gin.H{}
+	for rows.Next() {
+		var skeleton, name, kind, reason string
+		var createdBy *uuid.UUID
+		var createdAt sql.NullTime
+		if err := rows.Scan(&skeleton, &name, &kind, &reason, &createdBy, &createdAt); err != nil {
+			continue
+		}
+		names = append(names, gin.H{
+			"name": name, "skeleton": skeleton, "kind": kind, "reason": reason,
+			"created_by": createdBy, "created_at": createdAt.Time,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"reserved": names, "builtin": builtinReservedNames})
+}
+
+// AdminReserveUsername adds a reserved or blocked name
+func (as *AuthService) AdminReserveUsername(c *gin.Context) {
+	var req struct {
+		Name   string `json:"name" binding:"required"`
+		Kind   string `json:"kind"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = reservedKindReserved
+	}
+	if req.Kind != reservedKindReserved && req.Kind != reservedKindBlocked {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be reserved or blocked"})
+		return
+	}
+	skeleton := usernameSkeleton(normalizeUsername(req.Name))
+	if skeleton == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name has no letters or digits"})
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	_, err := as.db.ExecContext(c.Request.Context(), `
+		INSERT INTO reserved_usernames (skeleton, name, kind, reason, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (skeleton) DO UPDATE SET name = $2, kind = $3, reason = $4, created_by = $5`,
+		skeleton, req.Name, req.Kind, req.Reason, adminID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reserve name"})
+		return
+	}
+
+	// Existing accounts are not renamed, but admins should know about them
+	var taken []string
+	if rows, err := as.db.QueryContext(c.Request.Context(), `SELECT username FROM users WHERE lower(username) = lower($1)`, normalizeUsername(req.Name)); err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var username string
+			if rows.Scan(&username) == nil {
+				taken = append(taken, username)
+			}
+		}
+	}
+	c.JSON(http.StatusCreated, gin.H{"name": req.Name, "skeleton": skeleton, "kind": req.Kind, "existing_users": taken})
+}
+
+// AdminReleaseUsername removes a name from the registry
+func (as *AuthService) AdminReleaseUsername(c *gin.Context) {
+	skeleton := usernameSkeleton(normalizeUsername(c.Param("name")))
+	result, err := as.db.ExecContext(c.Request.Context(), `DELETE FROM reserved_usernames WHERE skeleton = $1`, skeleton)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release name"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Name is not in the registry"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Name released"})
+}
+
+// AdminCheckUsername reports whether a name would be accepted, and why not
+func (as *AuthService) AdminCheckUsername(c *gin.Context) {
+	name := c.Query("name")
+	normalized, err := as.checkUsername(c.Request.Context(), name)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"name": name, "allowed": false, "error": err.Code, "error_description": err.Description,
+			"skeleton": usernameSkeleton(normalizeUsername(name))})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name, "allowed": true, "normalized": normalized, "skeleton": usernameSkeleton(normalized)})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type UsernamePolicyTestSuite struct {
+	suite.Suite
+	authService *AuthService
+}
+
+func (suite *UsernamePolicyTestSuite) SetupTest() {
+	suite.authService = &AuthService{}
+}
+
+func (suite *UsernamePolicyTestSuite) rejection(name string) string {
+	_, err := suite.authService.checkUsername(context.Background(), name)
+	if err == nil {
+		return ""
+	}
+	return err.Code
+}
+
+func (suite *UsernamePolicyTestSuite) TestDefaultRules() {
+	suite.Equal("", suite.rejection("tag_wrangler"))
+	suite.Equal("", suite.rejection("testadmin"), "reserved names only match exactly")
+	suite.Equal("invalid_username", suite.rejection("ab"))
+	suite.Equal("invalid_username", suite.rejection("_leading"))
+	suite.Equal("invalid_username", suite.rejection("has space"))
+	suite.Equal("invalid_username", suite.rejection("café"), "non-ASCII letters need USERNAME_ALLOW_UNICODE")
+}
+
+func (suite *UsernamePolicyTestSuite) TestNormalizationAndConfusables() {
+	normalized, err := suite.authService.checkUsername(context.Background(), "ｒｅａｄｅｒ")
+	suite.Nil(err)
+	suite.Equal("reader", normalized, "full-width letters are folded by NFKC")
+
+	suite.Equal("username_reserved", suite.rejection("Admin"))
+	suite.Equal("username_reserved", suite.rejection("adm1n"))
+	suite.Equal("username_reserved", suite.rejection("ad-min"))
+	suite.Equal("username_reserved", suite.rejection("rnoderator"), "rn reads as m")
+}
+
+func (suite *UsernamePolicyTestSuite) TestUnicodeNamesUseOneScript() {
+	suite.authService.usernames = UsernamePolicy{MinLength: 2, MaxLength: 40, AllowUnicode: true, Punctuation: "_-"}
+
+	suite.Equal("", suite.rejection("café"))
+	suite.Equal("", suite.rejection("ひらがなカタカナ漢字"))
+	suite.Equal("", suite.rejection("Пушкин"))
+	suite.Equal("invalid_username", suite.rejection("pаypal"), "Cyrillic а inside a Latin name")
+	suite.Equal("username_reserved", suite.rejection("ао3"), "an all-Cyrillic look-alike still maps to ao3")
+}
+
+func (suite *UsernamePolicyTestSuite) TestPolicySettings() {
+	suite.T().Setenv("USERNAME_MIN_LENGTH", "5")
+	suite.T().Setenv("USERNAME_MAX_LENGTH", "4")
+	_, err := DefaultUsernamePolicy()
+	suite.Error(err)
+
+	suite.T().Setenv("USERNAME_MAX_LENGTH", "20")
+	suite.T().Setenv("USERNAME_PUNCTUATION", "_ ")
+	_, err = DefaultUsernamePolicy()
+	suite.Error(err, "spaces are not punctuation")
+}
+
+func TestUsernamePolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(UsernamePolicyTestSuite))
+}
@@ -0,0 +1,166 @@
+// Package anonymize prepares analytics exports for sharing outside the operations team.
+//
+// Each export gets its own random salt, used to replace user identifiers with pseudonyms and
+// discarded once the export is written, so pseudonyms from two exports cannot be joined.
+// Rows describing fewer than K distinct users are suppressed (k-anonymity), and small
+// counts are perturbed with Laplace noise, the mechanism of differential privacy for counts.
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config controls how exports are anonymized
+type Config struct {
+	// K is the k-anonymity threshold: rows shared by fewer than K distinct users are suppressed
+	K int `yaml:"k" json:"k"`
+	// Epsilon is the privacy budget of each noised count; smaller adds more noise. 0 adds none.
+	Epsilon float64 `yaml:"epsilon" json:"epsilon"`
+	// NoiseBelow limits noise to counts under it, where one user's activity stands out;
+	// 0 noises every count
+	NoiseBelow int `yaml:"noise_below" json:"noise_below"`
+}
+
+// DefaultConfig suppresses rows of fewer than 5 users and noises counts under 100 with epsilon 1
+func DefaultConfig() Config {
+	return Config{K: 5, Epsilon: 1, NoiseBelow: 100}
+}
+
+// ApplyEnv overrides settings from environment variables named prefix + K, EPSILON and NOISE_BELOW
+func (c *Config) ApplyEnv(prefix string) {
+	if value, err := strconv.Atoi(os.Getenv(prefix + "K")); err == nil {
+		c.K = value
+	}
+	if value, err := strconv.ParseFloat(os.Getenv(prefix+"EPSILON"), 64); err == nil {
+		c.Epsilon = value
+	}
+	if value, err := strconv.Atoi(os.Getenv(prefix + "NOISE_BELOW")); err == nil {
+		c.NoiseBelow = value
+	}
+}
+
+// Validate reports settings that would export identifiable data
+func (c Config) Validate() error {
+	switch {
+	case c.K < 2:
+		return fmt.Errorf("k must be at least 2")
+	case c.Epsilon < 0 || math.IsNaN(c.Epsilon) || math.IsInf(c.Epsilon, 0):
+		return fmt.Errorf("epsilon must be a non-negative number")
+	case c.NoiseBelow < 0:
+		return fmt.Errorf("noise_below must not be negative")
+	}
+	return nil
+}
+
+// Manifest describes how an export was anonymized; it is safe to publish with the export
+type Manifest struct {
+	ExportID    string    `json:"export_id"`
+	K           int       `json:"k"`
+	Epsilon     float64   `json:"epsilon"`
+	NoiseBelow  int       `json:"noise_below"`
+	Suppressed  int       `json:"suppressed"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// Export anonymizes one export. It is safe for concurrent use.
+type Export struct {
+	config Config
+	salt   []byte
+	id     string
+	at     time.Time
+
+	mu         sync.Mutex
+	suppressed int
+}
+
+// New starts an export with a fresh salt
+func New(config Config) (*Export, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Export{config: config, salt: salt, id: hex.EncodeToString(id), at: time.Now().UTC()}, nil
+}
+
+// ID identifies the export, e.g. in file names; it reveals nothing about the salt
+func (e *Export) ID() string {
+	return e.id
+}
+
+// Pseudonym replaces an identifier with one stable within this export only. Empty
+// identifiers stay empty.
+func (e *Export) Pseudonym(identifier string) string {
+	if identifier == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, e.salt)
+	mac.Write([]byte(identifier))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Suppress reports whether a row shared by this many distinct users must be left out, and
+// counts it in the manifest when it must
+func (e *Export) Suppress(users int) bool {
+	if users >= e.config.K {
+		return false
+	}
+	e.mu.Lock()
+	e.suppressed++
+	e.mu.Unlock()
+	return true
+}
+
+// Count returns n with Laplace noise of scale 1/epsilon when it is below noise_below,
+// rounded and never negative. Each user must add at most one to the count for epsilon to
+// hold; callers count distinct users or cap each user's contribution.
+func (e *Export) Count(n int) int {
+	if e.config.Epsilon == 0 || (e.config.NoiseBelow > 0 && n >= e.config.NoiseBelow) {
+		return n
+	}
+	noised := int(math.Round(float64(n) + laplace(1/e.config.Epsilon)))
+	return max(noised, 0)
+}
+
+// Manifest describes the export so far; take it after the last row is written
+func (e *Export) Manifest() Manifest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return Manifest{
+		ExportID:    e.id,
+		K:           e.config.K,
+		Epsilon:     e.config.Epsilon,
+		NoiseBelow:  e.config.NoiseBelow,
+		Suppressed:  e.suppressed,
+		GeneratedAt: e.at,
+	}
+}
+
+// laplace samples Laplace(0, scale) from a cryptographic source, so the noise cannot be
+// predicted and subtracted
+func laplace(scale float64) float64 {
+	var b [8]byte
+	rand.Read(b[:])
+	// Uniform in (-0.5, 0.5), excluding the endpoints where the logarithm diverges
+	u := (float64(binary.BigEndian.Uint64(b[:])>>11)+0.5)/(1<<53) - 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
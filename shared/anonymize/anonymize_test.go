@@ -0,0 +1,106 @@
+package anonymize
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPseudonymsRotatePerExport(t *testing.T) {
+	first, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	second, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if first.Pseudonym("user-1") != first.Pseudonym("user-1") {
+		t.Fatal("pseudonyms must be stable within an export")
+	}
+	if first.Pseudonym("user-1") == first.Pseudonym("user-2") {
+		t.Fatal("different users must get different pseudonyms")
+	}
+	if first.Pseudonym("user-1") == second.Pseudonym("user-1") {
+		t.Fatal("pseudonyms must not be linkable across exports")
+	}
+	if first.Pseudonym("") != "" {
+		t.Fatal("empty identifiers must stay empty")
+	}
+	if first.ID() == second.ID() {
+		t.Fatal("exports must have distinct IDs")
+	}
+}
+
+func TestSuppressBelowK(t *testing.T) {
+	export, err := New(Config{K: 3})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if !export.Suppress(2) || export.Suppress(3) || export.Suppress(10) {
+		t.Fatal("rows of fewer than k users must be suppressed, the rest kept")
+	}
+	if export.Manifest().Suppressed != 1 {
+		t.Fatalf("manifest counted %d suppressed rows, want 1", export.Manifest().Suppressed)
+	}
+}
+
+func TestCountNoisesSmallCounts(t *testing.T) {
+	export, err := New(Config{K: 2, Epsilon: 0.5, NoiseBelow: 100})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if export.Count(100) != 100 || export.Count(5000) != 5000 {
+		t.Fatal("counts at or above noise_below must be exact")
+	}
+
+	const samples = 20000
+	changed, sum := 0, 0.0
+	for i := 0; i < samples; i++ {
+		noised := export.Count(50)
+		if noised < 0 {
+			t.Fatalf("noised count %d is negative", noised)
+		}
+		if noised != 50 {
+			changed++
+		}
+		sum += float64(noised)
+	}
+	if changed < samples/2 {
+		t.Fatalf("only %d of %d small counts were noised", changed, samples)
+	}
+	// Laplace noise is centred on zero; scale 2 gives a standard error of the mean near 0.02
+	if mean := sum / samples; math.Abs(mean-50) > 0.2 {
+		t.Fatalf("noise is biased: mean %.3f, want about 50", mean)
+	}
+}
+
+func TestCountWithoutEpsilonIsExact(t *testing.T) {
+	export, err := New(Config{K: 2})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if export.Count(1) != 1 {
+		t.Fatal("epsilon 0 must add no noise")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, config := range []Config{
+		{K: 1, Epsilon: 1},
+		{K: 5, Epsilon: -1},
+		{K: 5, Epsilon: math.Inf(1)},
+		{K: 5, NoiseBelow: -1},
+	} {
+		if config.Validate() == nil {
+			t.Errorf("%+v should be invalid", config)
+		}
+		if _, err := New(config); err == nil {
+			t.Errorf("New(%+v) should fail", config)
+		}
+	}
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+}
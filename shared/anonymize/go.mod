@@ -0,0 +1,3 @@
+module liberation-anonymize
+
+go 1.21
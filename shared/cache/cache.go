@@ -0,0 +1,317 @@
+// Package cache keeps loaded values in two tiers shared by the platform services.
+//
+// The first tier is an in-process LRU with a short TTL. The second, optional, is a Remote
+// such as Redis that every replica reads, so a value loaded by one replica is found by the
+// others. Invalidating a key drops it from both tiers and publishes the key on the cache's
+// channel; replicas running Listen drop it from their own first tier. Concurrent loads of
+// one key are de-duplicated, so a burst of requests for a cold key reaches the database once.
+//
+// The first tier's TTL bounds how stale a replica can be when an invalidation is missed,
+// for example while its subscription reconnects, so it should be short.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrMiss is returned by a Remote for keys it does not hold
+var ErrMiss = errors.New("cache miss")
+
+// Remote is the second tier, shared by every replica of a service
+type Remote interface {
+	// Get returns ErrMiss for keys that are not stored or have expired
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe calls deliver with every message published on channel until ctx is done
+	Subscribe(ctx context.Context, channel string, deliver func(message string)) error
+}
+
+// Config sizes a cache and bounds the age of what it returns
+type Config struct {
+	// Name prefixes the cache's remote keys and names its invalidation channel
+	Name string `yaml:"-" json:"name"`
+	// MaxEntries caps the first tier; the least recently used entries are evicted
+	MaxEntries int `yaml:"max_entries" json:"max_entries"`
+	// LocalTTLSeconds is how long a value is served from the first tier
+	LocalTTLSeconds int `yaml:"local_ttl_seconds" json:"local_ttl_seconds"`
+	// RemoteTTLSeconds is how long a value is kept in the remote tier; 0 keeps nothing there
+	RemoteTTLSeconds int `yaml:"remote_ttl_seconds" json:"remote_ttl_seconds"`
+}
+
+// DefaultConfig keeps up to 10,000 values for 30 seconds in process and 5 minutes remotely
+func DefaultConfig(name string) Config {
+	return Config{Name: name, MaxEntries: 10000, LocalTTLSeconds: 30, RemoteTTLSeconds: 300}
+}
+
+// Validate reports settings that would cache nothing or cache forever
+func (c Config) Validate() error {
+	switch {
+	case c.Name == "":
+		return fmt.Errorf("cache name is required")
+	case c.MaxEntries < 1:
+		return fmt.Errorf("cache %s: max entries must be positive", c.Name)
+	case c.LocalTTLSeconds < 1:
+		return fmt.Errorf("cache %s: local TTL must be at least a second", c.Name)
+	case c.RemoteTTLSeconds < 0:
+		return fmt.Errorf("cache %s: remote TTL cannot be negative", c.Name)
+	}
+	return nil
+}
+
+func (c Config) localTTL() time.Duration {
+	return time.Duration(c.LocalTTLSeconds) * time.Second
+}
+
+func (c Config) remoteTTL() time.Duration {
+	return time.Duration(c.RemoteTTLSeconds) * time.Second
+}
+
+// Stats counts where values came from since the cache was created
+type Stats struct {
+	Entries       int   `json:"entries"`
+	LocalHits     int64 `json:"local_hits"`
+	RemoteHits    int64 `json:"remote_hits"`
+	Loads         int64 `json:"loads"`
+	SharedLoads   int64 `json:"shared_loads"`
+	Invalidations int64 `json:"invalidations"`
+	RemoteErrors  int64 `json:"remote_errors"`
+}
+
+// Codec turns values into the bytes kept in the remote tier
+type Codec[V any] interface {
+	Encode(value V) ([]byte, error)
+	Decode(data []byte) (V, error)
+}
+
+// GobCodec encodes values with encoding/gob, which keeps every exported field whatever its
+// JSON tags say. It is the default.
+type GobCodec[V any] struct{}
+
+// Encode gob-encodes the value
+func (GobCodec[V]) Encode(value V) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&value)
+	return buf.Bytes(), err
+}
+
+// Decode gob-decodes a value
+func (GobCodec[V]) Decode(data []byte) (V, error) {
+	var value V
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// Cache holds values of one type. It is safe for concurrent use.
+type Cache[V any] struct {
+	config Config
+	remote Remote
+	codec  Codec[V]
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries *lru[V]
+	flights map[string]*flight[V]
+	// generation is bumped by every invalidation; loads that started before one do not
+	// store what they loaded, since it may be what was just invalidated
+	generation uint64
+	stats      Stats
+}
+
+// flight is a load in progress that callers asking for the same key wait on
+type flight[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// invalidation is what the cache publishes on its channel
+type invalidation struct {
+	Keys []string `json:"keys"`
+}
+
+// New creates a cache. remote may be nil to keep values in process only.
+func New[V any](config Config, remote Remote) *Cache[V] {
+	return &Cache[V]{
+		config:  config,
+		remote:  remote,
+		codec:   GobCodec[V]{},
+		now:     time.Now,
+		entries: newLRU[V](config.MaxEntries),
+		flights: make(map[string]*flight[V]),
+	}
+}
+
+// WithCodec replaces the codec of the remote tier; it must be called before the cache is used
+func (c *Cache[V]) WithCodec(codec Codec[V]) *Cache[V] {
+	c.codec = codec
+	return c
+}
+
+// Channel is where the cache publishes invalidated keys
+func (c *Cache[V]) Channel() string {
+	return "cache:" + c.config.Name + ":invalidate"
+}
+
+func (c *Cache[V]) remoteKey(key string) string {
+	return "cache:" + c.config.Name + ":" + key
+}
+
+// Get returns the value for key from the first tier, then the remote tier, and otherwise
+// from load. Callers asking for a key that is being loaded wait for that load instead of
+// starting their own. Errors from load are returned and not cached; a remote tier that
+// fails is skipped.
+func (c *Cache[V]) Get(ctx context.Context, key string, load func(ctx context.Context) (V, error)) (V, error) {
+	c.mu.Lock()
+	if value, ok := c.entries.get(key, c.now()); ok {
+		c.stats.LocalHits++
+		c.mu.Unlock()
+		return value, nil
+	}
+	if f, ok := c.flights[key]; ok {
+		c.stats.SharedLoads++
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.value, f.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+	f := &flight[V]{done: make(chan struct{})}
+	c.flights[key] = f
+	generation := c.generation
+	c.mu.Unlock()
+
+	fromRemote := false
+	f.value, fromRemote, f.err = c.fetch(ctx, key, generation, load)
+
+	c.mu.Lock()
+	delete(c.flights, key)
+	if f.err == nil && c.generation == generation {
+		c.entries.put(key, f.value, c.now().Add(c.config.localTTL()))
+	}
+	if fromRemote {
+		c.stats.RemoteHits++
+	} else {
+		c.stats.Loads++
+	}
+	c.mu.Unlock()
+	close(f.done)
+	return f.value, f.err
+}
+
+// fetch reads key from the remote tier, or loads it and stores it there unless it was
+// invalidated while loading
+func (c *Cache[V]) fetch(ctx context.Context, key string, generation uint64, load func(ctx context.Context) (V, error)) (V, bool, error) {
+	if c.remote != nil {
+		data, err := c.remote.Get(ctx, c.remoteKey(key))
+		if err == nil {
+			value, err := c.codec.Decode(data)
+			if err == nil {
+				return value, true, nil
+			}
+			c.remoteFailed("decode", key, err)
+		} else if !errors.Is(err, ErrMiss) {
+			c.remoteFailed("read", key, err)
+		}
+	}
+
+	value, err := load(ctx)
+	if err != nil || c.remote == nil || c.config.RemoteTTLSeconds <= 0 {
+		return value, false, err
+	}
+	c.mu.Lock()
+	invalidated := c.generation != generation
+	c.mu.Unlock()
+	if invalidated {
+		return value, false, nil
+	}
+	if data, err := c.codec.Encode(value); err != nil {
+		c.remoteFailed("encode", key, err)
+	} else if err := c.remote.Set(ctx, c.remoteKey(key), data, c.config.remoteTTL()); err != nil {
+		c.remoteFailed("write", key, err)
+	}
+	return value, false, nil
+}
+
+func (c *Cache[V]) remoteFailed(action, key string, err error) {
+	c.mu.Lock()
+	c.stats.RemoteErrors++
+	c.mu.Unlock()
+	log.Printf("cache %s: failed to %s %s: %v", c.config.Name, action, key, err)
+}
+
+// Invalidate drops keys from both tiers and tells the other replicas to drop them. Loads
+// in progress finish but do not store what they loaded. The first tier is cleared even when
+// the remote tier fails, and the error is returned.
+func (c *Cache[V]) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	c.drop(keys)
+	if c.remote == nil {
+		return nil
+	}
+
+	remoteKeys := make([]string, len(keys))
+	for i, key := range keys {
+		remoteKeys[i] = c.remoteKey(key)
+	}
+	if err := c.remote.Delete(ctx, remoteKeys...); err != nil {
+		return fmt.Errorf("cache %s: %w", c.config.Name, err)
+	}
+	message, err := json.Marshal(invalidation{Keys: keys})
+	if err != nil {
+		return err
+	}
+	if err := c.remote.Publish(ctx, c.Channel(), string(message)); err != nil {
+		return fmt.Errorf("cache %s: %w", c.config.Name, err)
+	}
+	return nil
+}
+
+func (c *Cache[V]) drop(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.stats.Invalidations += int64(len(keys))
+	for _, key := range keys {
+		c.entries.remove(key)
+	}
+}
+
+// Listen drops keys other replicas invalidate from the first tier until ctx is done. It
+// returns at once when there is no remote tier.
+func (c *Cache[V]) Listen(ctx context.Context) error {
+	if c.remote == nil {
+		return nil
+	}
+	return c.remote.Subscribe(ctx, c.Channel(), func(message string) {
+		var msg invalidation
+		if err := json.Unmarshal([]byte(message), &msg); err != nil {
+			log.Printf("cache %s: ignoring invalidation %q: %v", c.config.Name, message, err)
+			return
+		}
+		c.drop(msg.Keys)
+	})
+}
+
+// Stats returns the cache's counters
+func (c *Cache[V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.entries.len()
+	return stats
+}
@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type profile struct {
+	Name  string
+	Email string `json:"-"`
+	Tags  []string
+}
+
+func testConfig() Config {
+	return Config{Name: "profiles", MaxEntries: 2, LocalTTLSeconds: 60, RemoteTTLSeconds: 3600}
+}
+
+// loader returns a load function that counts its calls
+func loader(calls *int32, value profile) func(context.Context) (profile, error) {
+	return func(context.Context) (profile, error) {
+		atomic.AddInt32(calls, 1)
+		return value, nil
+	}
+}
+
+// listening starts Listen on every cache and waits until the remote has their subscriptions
+func listening(t *testing.T, remote *MemoryRemote, caches ...*Cache[profile]) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	for _, c := range caches {
+		go c.Listen(ctx)
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		remote.mu.Lock()
+		subscribed := len(remote.subscribers[caches[0].Channel()])
+		remote.mu.Unlock()
+		if subscribed == len(caches) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d caches subscribed", subscribed, len(caches))
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, config := range []Config{testConfig(), DefaultConfig("clients")} {
+		if err := config.Validate(); err != nil {
+			t.Fatalf("valid config: %v", err)
+		}
+	}
+	for name, mutate := range map[string]func(*Config){
+		"name":        func(c *Config) { c.Name = "" },
+		"max entries": func(c *Config) { c.MaxEntries = 0 },
+		"local ttl":   func(c *Config) { c.LocalTTLSeconds = 0 },
+		"remote ttl":  func(c *Config) { c.RemoteTTLSeconds = -1 },
+	} {
+		config := testConfig()
+		mutate(&config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestGetUsesBothTiers(t *testing.T) {
+	ctx := context.Background()
+	remote := NewMemoryRemote()
+	first := New[profile](testConfig(), remote)
+	second := New[profile](testConfig(), remote)
+	var calls int32
+	load := loader(&calls, profile{Name: "ada", Email: "ada@example.org", Tags: []string{"admin"}})
+
+	for i := 0; i < 2; i++ {
+		if _, err := first.Get(ctx, "ada", load); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second replica finds the value in the remote tier, with fields JSON would drop
+	got, err := second.Get(ctx, "ada", load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != "ada@example.org" || len(got.Tags) != 1 {
+		t.Fatalf("remote value lost fields: %+v", got)
+	}
+	if calls != 1 {
+		t.Fatalf("loaded %d times, want 1", calls)
+	}
+	if stats := first.Stats(); stats.LocalHits != 1 || stats.Loads != 1 {
+		t.Errorf("first stats: %+v", stats)
+	}
+	if stats := second.Stats(); stats.RemoteHits != 1 || stats.Loads != 0 {
+		t.Errorf("second stats: %+v", stats)
+	}
+}
+
+func TestLocalTierExpiresAndEvicts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := New[profile](testConfig(), nil)
+	c.now = func() time.Time { return now }
+	var calls int32
+
+	c.Get(ctx, "a", loader(&calls, profile{Name: "a"}))
+	now = now.Add(time.Minute)
+	c.Get(ctx, "a", loader(&calls, profile{Name: "a"}))
+	if calls != 2 {
+		t.Fatalf("expired entry was served: %d loads", calls)
+	}
+
+	c.Get(ctx, "b", loader(&calls, profile{Name: "b"}))
+	c.Get(ctx, "a", loader(&calls, profile{Name: "a"}))
+	c.Get(ctx, "c", loader(&calls, profile{Name: "c"}))
+	if stats := c.Stats(); stats.Entries != 2 {
+		t.Fatalf("entries = %d, want 2", stats.Entries)
+	}
+	// b was the least recently used, so it was evicted and a was kept
+	calls = 0
+	c.Get(ctx, "a", loader(&calls, profile{Name: "a"}))
+	c.Get(ctx, "b", loader(&calls, profile{Name: "b"}))
+	if calls != 1 {
+		t.Fatalf("loads after eviction = %d, want 1", calls)
+	}
+}
+
+func TestConcurrentLoadsAreShared(t *testing.T) {
+	c := New[profile](testConfig(), NewMemoryRemote())
+	release := make(chan struct{})
+	var calls int32
+	load := func(context.Context) (profile, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return profile{Name: "ada"}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]profile, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = c.Get(context.Background(), "ada", load)
+		}(i)
+	}
+	for deadline := time.Now().Add(time.Second); c.Stats().SharedLoads < int64(len(results)-1); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("callers did not wait on the load: %+v", c.Stats())
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("loaded %d times, want 1", calls)
+	}
+	for _, result := range results {
+		if result.Name != "ada" {
+			t.Fatalf("caller got %+v", result)
+		}
+	}
+}
+
+func TestLoadErrorsAreNotCached(t *testing.T) {
+	ctx := context.Background()
+	c := New[profile](testConfig(), NewMemoryRemote())
+	failure := errors.New("database down")
+	if _, err := c.Get(ctx, "ada", func(context.Context) (profile, error) { return profile{}, failure }); !errors.Is(err, failure) {
+		t.Fatalf("got %v, want the load error", err)
+	}
+	var calls int32
+	if got, err := c.Get(ctx, "ada", loader(&calls, profile{Name: "ada"})); err != nil || got.Name != "ada" || calls != 1 {
+		t.Fatalf("got %+v, %v after %d loads", got, err, calls)
+	}
+}
+
+func TestInvalidateReachesOtherReplicas(t *testing.T) {
+	ctx := context.Background()
+	remote := NewMemoryRemote()
+	first := New[profile](testConfig(), remote)
+	second := New[profile](testConfig(), remote)
+	listening(t, remote, first, second)
+
+	var calls int32
+	first.Get(ctx, "ada", loader(&calls, profile{Name: "old"}))
+	second.Get(ctx, "ada", loader(&calls, profile{Name: "old"}))
+
+	if err := first.Invalidate(ctx, "ada"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := second.Get(ctx, "ada", loader(&calls, profile{Name: "new"}))
+	if got.Name != "new" {
+		t.Fatalf("second replica served %q after invalidation", got.Name)
+	}
+	got, _ = first.Get(ctx, "ada", loader(&calls, profile{Name: "newer"}))
+	if got.Name != "new" {
+		t.Fatalf("first replica got %q, want the value the second stored remotely", got.Name)
+	}
+	if stats := second.Stats(); stats.Invalidations != 1 {
+		t.Errorf("second replica invalidations = %d, want 1", stats.Invalidations)
+	}
+}
+
+func TestInvalidateDuringLoadDiscardsResult(t *testing.T) {
+	ctx := context.Background()
+	remote := NewMemoryRemote()
+	c := New[profile](testConfig(), remote)
+	loaded := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		<-loaded
+		c.Invalidate(ctx, "ada")
+		close(release)
+	}()
+	got, err := c.Get(ctx, "ada", func(context.Context) (profile, error) {
+		close(loaded)
+		<-release
+		return profile{Name: "stale"}, nil
+	})
+	if err != nil || got.Name != "stale" {
+		t.Fatalf("the caller should still get its load: %+v, %v", got, err)
+	}
+
+	var calls int32
+	if got, _ := c.Get(ctx, "ada", loader(&calls, profile{Name: "fresh"})); got.Name != "fresh" {
+		t.Fatalf("stale load was cached in either tier: %+v", got)
+	}
+}
+
+func TestRemoteEntriesExpire(t *testing.T) {
+	ctx := context.Background()
+	remote := NewMemoryRemote()
+	now := time.Now()
+	remote.now = func() time.Time { return now }
+	if err := remote.Set(ctx, "k", []byte("v"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := remote.Get(ctx, "k"); err != nil || string(data) != "v" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+	now = now.Add(time.Second)
+	if _, err := remote.Get(ctx, "k"); !errors.Is(err, ErrMiss) {
+		t.Fatalf("expired key: got %v, want ErrMiss", err)
+	}
+}
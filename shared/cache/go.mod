@@ -0,0 +1,3 @@
+module liberation-cache
+
+go 1.21
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+// lru is the first tier: a bounded map whose least recently used entry is evicted first.
+// It is not safe for concurrent use; Cache guards it.
+type lru[V any] struct {
+	max   int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+func newLRU[V any](max int) *lru[V] {
+	if max < 1 {
+		max = 1
+	}
+	return &lru[V]{max: max, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns an entry that has not expired and marks it as the most recently used
+func (l *lru[V]) get(key string, now time.Time) (V, bool) {
+	element, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	entry := element.Value.(*lruEntry[V])
+	if !now.Before(entry.expiresAt) {
+		l.order.Remove(element)
+		delete(l.items, key)
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (l *lru[V]) put(key string, value V, expiresAt time.Time) {
+	if element, ok := l.items[key]; ok {
+		entry := element.Value.(*lruEntry[V])
+		entry.value, entry.expiresAt = value, expiresAt
+		l.order.MoveToFront(element)
+		return
+	}
+	l.items[key] = l.order.PushFront(&lruEntry[V]{key: key, value: value, expiresAt: expiresAt})
+	for l.order.Len() > l.max {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+func (l *lru[V]) remove(key string) {
+	if element, ok := l.items[key]; ok {
+		l.order.Remove(element)
+		delete(l.items, key)
+	}
+}
+
+func (l *lru[V]) len() int {
+	return l.order.Len()
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryRemote is a Remote kept in process. Caches sharing one behave like replicas sharing
+// Redis, which makes it useful in tests and for single-instance deployments.
+type MemoryRemote struct {
+	now func() time.Time
+
+	mu          sync.Mutex
+	values      map[string]memoryValue
+	subscribers map[string]map[int]func(string)
+	nextID      int
+}
+
+type memoryValue struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewMemoryRemote creates an empty MemoryRemote
+func NewMemoryRemote() *MemoryRemote {
+	return &MemoryRemote{
+		now:         time.Now,
+		values:      make(map[string]memoryValue),
+		subscribers: make(map[string]map[int]func(string)),
+	}
+}
+
+// Get returns a copy of the stored value, or ErrMiss
+func (m *MemoryRemote) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	if !ok || !m.now().Before(value.expiresAt) {
+		delete(m.values, key)
+		return nil, ErrMiss
+	}
+	return append([]byte(nil), value.data...), nil
+}
+
+// Set stores a copy of value until ttl passes
+func (m *MemoryRemote) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = memoryValue{data: append([]byte(nil), value...), expiresAt: m.now().Add(ttl)}
+	return nil
+}
+
+// Delete removes keys; missing keys are ignored
+func (m *MemoryRemote) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.values, key)
+	}
+	return nil
+}
+
+// Publish delivers message to the channel's subscribers before returning
+func (m *MemoryRemote) Publish(_ context.Context, channel, message string) error {
+	m.mu.Lock()
+	deliver := make([]func(string), 0, len(m.subscribers[channel]))
+	for _, subscriber := range m.subscribers[channel] {
+		deliver = append(deliver, subscriber)
+	}
+	m.mu.Unlock()
+	for _, subscriber := range deliver {
+		subscriber(message)
+	}
+	return nil
+}
+
+// Subscribe registers deliver and blocks until ctx is done
+func (m *MemoryRemote) Subscribe(ctx context.Context, channel string, deliver func(message string)) error {
+	m.mu.Lock()
+	id := m.nextID
+	m.nextID++
+	if m.subscribers[channel] == nil {
+		m.subscribers[channel] = make(map[int]func(string))
+	}
+	m.subscribers[channel][id] = deliver
+	m.mu.Unlock()
+
+	<-ctx.Done()
+
+	m.mu.Lock()
+	delete(m.subscribers[channel], id)
+	m.mu.Unlock()
+	return ctx.Err()
+}
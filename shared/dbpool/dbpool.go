@@ -0,0 +1,103 @@
+// Package dbpool configures and watches the database/sql connection pools of the platform
+// services.
+//
+// Config sizes a pool and bounds the life of its connections. Monitor samples the pool's
+// statistics for metrics and, on every check, compares them with the previous sample: when
+// requests waited too long for a connection, or idle connections were closed only to be
+// opened again, it says which setting to change. StatementCache keeps the prepared
+// statements of hot queries, so Postgres parses them once per connection rather than on
+// every call.
+package dbpool
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config sizes a connection pool
+type Config struct {
+	// MaxOpenConns caps the connections open at once, in use or idle
+	MaxOpenConns int `yaml:"max_open_conns" json:"max_open_conns"`
+	// MaxIdleConns is how many connections are kept open between requests
+	MaxIdleConns int `yaml:"max_idle_conns" json:"max_idle_conns"`
+	// ConnMaxLifetimeSeconds recycles connections after this long; 0 keeps them forever
+	ConnMaxLifetimeSeconds int `yaml:"conn_max_lifetime_seconds" json:"conn_max_lifetime_seconds"`
+	// ConnMaxIdleTimeSeconds closes connections idle for this long; 0 keeps them
+	ConnMaxIdleTimeSeconds int `yaml:"conn_max_idle_time_seconds" json:"conn_max_idle_time_seconds"`
+	// StatementCacheSize is how many prepared statements a StatementCache keeps; 0 prepares
+	// nothing and runs every query directly
+	StatementCacheSize int `yaml:"statement_cache_size" json:"statement_cache_size"`
+	// WaitWarningMillis is the average wait for a connection above which a check advises
+	// a bigger pool
+	WaitWarningMillis int `yaml:"wait_warning_millis" json:"wait_warning_millis"`
+	// CheckIntervalSeconds is how often a running Monitor checks the pool
+	CheckIntervalSeconds int `yaml:"check_interval_seconds" json:"check_interval_seconds"`
+}
+
+// DefaultConfig is the pool the services used before it was configurable: 25 connections,
+// 5 kept idle and recycled hourly, with 100 prepared statements and a warning when requests
+// wait 50ms on average, checked every minute
+func DefaultConfig() Config {
+	return Config{
+		MaxOpenConns:           25,
+		MaxIdleConns:           5,
+		ConnMaxLifetimeSeconds: 3600,
+		StatementCacheSize:     100,
+		WaitWarningMillis:      50,
+		CheckIntervalSeconds:   60,
+	}
+}
+
+// ApplyEnv overrides settings from environment variables named prefix + MAX_OPEN_CONNS,
+// MAX_IDLE_CONNS, CONN_MAX_LIFETIME_SECONDS, CONN_MAX_IDLE_TIME_SECONDS,
+// STATEMENT_CACHE_SIZE, WAIT_WARNING_MILLIS and CHECK_INTERVAL_SECONDS. Values that are
+// not integers are left for Validate to reject.
+func (c *Config) ApplyEnv(prefix string) {
+	settings := map[string]*int{
+		"MAX_OPEN_CONNS":             &c.MaxOpenConns,
+		"MAX_IDLE_CONNS":             &c.MaxIdleConns,
+		"CONN_MAX_LIFETIME_SECONDS":  &c.ConnMaxLifetimeSeconds,
+		"CONN_MAX_IDLE_TIME_SECONDS": &c.ConnMaxIdleTimeSeconds,
+		"STATEMENT_CACHE_SIZE":       &c.StatementCacheSize,
+		"WAIT_WARNING_MILLIS":        &c.WaitWarningMillis,
+		"CHECK_INTERVAL_SECONDS":     &c.CheckIntervalSeconds,
+	}
+	for name, setting := range settings {
+		if value, ok := os.LookupEnv(prefix + name); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				parsed = -1
+			}
+			*setting = parsed
+		}
+	}
+}
+
+// Validate reports settings database/sql would misread, such as a negative size meaning
+// unlimited
+func (c Config) Validate() error {
+	switch {
+	case c.MaxOpenConns < 1:
+		return fmt.Errorf("max_open_conns must be at least 1")
+	case c.MaxIdleConns < 0 || c.MaxIdleConns > c.MaxOpenConns:
+		return fmt.Errorf("max_idle_conns must be between 0 and max_open_conns (%d)", c.MaxOpenConns)
+	case c.ConnMaxLifetimeSeconds < 0 || c.ConnMaxIdleTimeSeconds < 0:
+		return fmt.Errorf("conn_max_lifetime_seconds and conn_max_idle_time_seconds cannot be negative")
+	case c.StatementCacheSize < 0:
+		return fmt.Errorf("statement_cache_size cannot be negative")
+	case c.WaitWarningMillis < 1 || c.CheckIntervalSeconds < 1:
+		return fmt.Errorf("wait_warning_millis and check_interval_seconds must be positive")
+	}
+	return nil
+}
+
+// Apply sizes db's pool
+func (c Config) Apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(c.ConnMaxLifetimeSeconds) * time.Second)
+	db.SetConnMaxIdleTime(time.Duration(c.ConnMaxIdleTimeSeconds) * time.Second)
+}
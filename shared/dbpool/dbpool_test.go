@@ -0,0 +1,183 @@
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingDriver answers every query with no rows and counts statements
+type countingDriver struct {
+	prepared atomic.Int64
+	closed   atomic.Int64
+}
+
+type countingConn struct{ d *countingDriver }
+type countingStmt struct{ d *countingDriver }
+type emptyRows struct{}
+
+func (d *countingDriver) Open(string) (driver.Conn, error) { return countingConn{d}, nil }
+
+func (c countingConn) Prepare(string) (driver.Stmt, error) {
+	c.d.prepared.Add(1)
+	return countingStmt{c.d}, nil
+}
+func (c countingConn) Close() error              { return nil }
+func (c countingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (s countingStmt) Close() error {
+	s.d.closed.Add(1)
+	return nil
+}
+func (s countingStmt) NumInput() int                              { return -1 }
+func (s countingStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (s countingStmt) Query([]driver.Value) (driver.Rows, error)  { return emptyRows{}, nil }
+
+func (emptyRows) Columns() []string         { return []string{"n"} }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+type connector struct{ d *countingDriver }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return c.d.Open("") }
+func (c connector) Driver() driver.Driver                        { return c.d }
+
+func openCounting(t *testing.T) (*sql.DB, *countingDriver) {
+	d := &countingDriver{}
+	db := sql.OpenDB(connector{d})
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db, d
+}
+
+func TestConfig(t *testing.T) {
+	config := DefaultConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+
+	t.Setenv("DB_POOL_MAX_OPEN_CONNS", "40")
+	t.Setenv("DB_POOL_MAX_IDLE_CONNS", "10")
+	t.Setenv("DB_POOL_STATEMENT_CACHE_SIZE", "0")
+	config.ApplyEnv("DB_POOL_")
+	if config.MaxOpenConns != 40 || config.MaxIdleConns != 10 || config.StatementCacheSize != 0 {
+		t.Fatalf("environment not applied: %+v", config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("config is invalid: %v", err)
+	}
+
+	t.Setenv("DB_POOL_MAX_IDLE_CONNS", "lots")
+	config.ApplyEnv("DB_POOL_")
+	if err := config.Validate(); err == nil {
+		t.Fatal("a value that is not a number was accepted")
+	}
+
+	config = DefaultConfig()
+	config.MaxIdleConns = config.MaxOpenConns + 1
+	if err := config.Validate(); err == nil {
+		t.Fatal("more idle connections than open ones were accepted")
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	config := DefaultConfig()
+	previous := sql.DBStats{MaxOpenConnections: 25, WaitCount: 10, WaitDuration: time.Second}
+
+	quick := previous
+	quick.WaitCount, quick.WaitDuration = 20, time.Second+100*time.Millisecond
+	if advice := Advise(config, previous, quick); len(advice) != 0 {
+		t.Fatalf("short waits drew advice: %v", advice)
+	}
+
+	slow := previous
+	slow.WaitCount, slow.WaitDuration = 20, 3*time.Second
+	advice := Advise(config, previous, slow)
+	if len(advice) != 1 || !strings.Contains(advice[0], "10 requests waited 200ms") || !strings.Contains(advice[0], "max_open_conns") {
+		t.Fatalf("slow waits: %v", advice)
+	}
+
+	churn := previous
+	churn.MaxIdleClosed = 100
+	advice = Advise(config, previous, churn)
+	if len(advice) != 1 || !strings.Contains(advice[0], "max_idle_conns is 5") {
+		t.Fatalf("idle churn: %v", advice)
+	}
+}
+
+func TestStatementCache(t *testing.T) {
+	db, d := openCounting(t)
+	ctx := context.Background()
+	cache := NewStatementCache(db, 2)
+
+	for i := 0; i < 3; i++ {
+		rows, err := cache.QueryContext(ctx, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	if hits, misses := cache.Counts(); hits != 2 || misses != 1 || d.prepared.Load() != 1 {
+		t.Fatalf("hits %d, misses %d, prepared %d", hits, misses, d.prepared.Load())
+	}
+
+	// The least recently used statement is closed to make room
+	var n int
+	if err := cache.QueryRowContext(ctx, "SELECT 2").Scan(&n); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+	if _, err := cache.ExecContext(ctx, "DELETE 3"); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 2 || d.closed.Load() != 1 {
+		t.Fatalf("%d statements cached, %d closed", cache.Len(), d.closed.Load())
+	}
+
+	cache.Close()
+	if cache.Len() != 0 || d.closed.Load() != 3 {
+		t.Fatalf("%d statements cached, %d closed after Close", cache.Len(), d.closed.Load())
+	}
+}
+
+func TestDisabledStatementCache(t *testing.T) {
+	db, _ := openCounting(t)
+	cache := NewStatementCache(db, 0)
+	if _, err := cache.ExecContext(context.Background(), "DELETE 1"); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 0 {
+		t.Fatalf("%d statements cached by a disabled cache", cache.Len())
+	}
+	if hits, misses := cache.Counts(); hits != 0 || misses != 0 {
+		t.Fatalf("disabled cache counted %d hits and %d misses", hits, misses)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	db, _ := openCounting(t)
+	cache := NewStatementCache(db, 10)
+	cache.ExecContext(context.Background(), "DELETE 1")
+	monitor := NewMonitor("primary", db, DefaultConfig(), cache)
+
+	var b strings.Builder
+	if err := WriteMetrics(&b, "liberation_ai", monitor); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE liberation_ai_db_pool_connections gauge",
+		`liberation_ai_db_pool_connections{pool="primary",state="idle"} 1`,
+		`liberation_ai_db_pool_max_open_connections{pool="primary"} 1`,
+		`liberation_ai_db_pool_statement_cache_total{pool="primary",result="miss"} 1`,
+		`liberation_ai_db_pool_closed_total{pool="primary",reason="max_lifetime"} 0`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, b.String())
+		}
+	}
+}
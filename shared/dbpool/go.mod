@@ -0,0 +1,3 @@
+module liberation-dbpool
+
+go 1.21
@@ -0,0 +1,193 @@
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats is a pool's state when it was sampled. Counts ending in Total are since the pool opened.
+type Stats struct {
+	Name    string `json:"name"`
+	MaxOpen int    `json:"max_open"`
+	Open    int    `json:"open"`
+	InUse   int    `json:"in_use"`
+	Idle    int    `json:"idle"`
+	// Utilization is InUse over MaxOpen
+	Utilization float64 `json:"utilization"`
+	// WaitTotal counts requests that waited for a connection, for WaitSecondsTotal in all
+	WaitTotal              int64   `json:"wait_total"`
+	WaitSecondsTotal       float64 `json:"wait_seconds_total"`
+	MaxIdleClosedTotal     int64   `json:"max_idle_closed_total"`
+	MaxIdleTimeClosedTotal int64   `json:"max_idle_time_closed_total"`
+	MaxLifetimeClosedTotal int64   `json:"max_lifetime_closed_total"`
+	StatementHitsTotal     int64   `json:"statement_hits_total"`
+	StatementMissesTotal   int64   `json:"statement_misses_total"`
+	// Advice is what the last check recommended; empty while the pool looks right
+	Advice []string `json:"advice"`
+}
+
+// Monitor samples a pool for metrics and advises on its size. It is safe for concurrent use.
+type Monitor struct {
+	name       string
+	db         *sql.DB
+	config     Config
+	statements *StatementCache
+
+	mu     sync.Mutex
+	last   sql.DBStats
+	advice []string
+}
+
+// NewMonitor watches db, sized by config, under name. statements is the pool's statement
+// cache, if it has one.
+func NewMonitor(name string, db *sql.DB, config Config, statements *StatementCache) *Monitor {
+	return &Monitor{name: name, db: db, config: config, statements: statements, last: db.Stats(), advice: []string{}}
+}
+
+// Name is the pool's name in metrics and log lines
+func (m *Monitor) Name() string {
+	return m.name
+}
+
+// Stats samples the pool
+func (m *Monitor) Stats() Stats {
+	current := m.db.Stats()
+	stats := Stats{
+		Name:                   m.name,
+		MaxOpen:                current.MaxOpenConnections,
+		Open:                   current.OpenConnections,
+		InUse:                  current.InUse,
+		Idle:                   current.Idle,
+		WaitTotal:              current.WaitCount,
+		WaitSecondsTotal:       current.WaitDuration.Seconds(),
+		MaxIdleClosedTotal:     current.MaxIdleClosed,
+		MaxIdleTimeClosedTotal: current.MaxIdleTimeClosed,
+		MaxLifetimeClosedTotal: current.MaxLifetimeClosed,
+	}
+	if current.MaxOpenConnections > 0 {
+		stats.Utilization = float64(current.InUse) / float64(current.MaxOpenConnections)
+	}
+	if m.statements != nil {
+		stats.StatementHitsTotal, stats.StatementMissesTotal = m.statements.Counts()
+	}
+	m.mu.Lock()
+	stats.Advice = m.advice
+	m.mu.Unlock()
+	return stats
+}
+
+// Check compares the pool with the previous check and returns advice on its settings
+func (m *Monitor) Check() []string {
+	current := m.db.Stats()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advice = Advise(m.config, m.last, current)
+	m.last = current
+	return m.advice
+}
+
+// Start checks the pool every CheckIntervalSeconds until ctx ends, passing advice to logf
+func (m *Monitor) Start(ctx context.Context, logf func(format string, args ...interface{})) {
+	go func() {
+		ticker := time.NewTicker(time.Duration(m.config.CheckIntervalSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, advice := range m.Check() {
+					logf("Database pool %s: %s", m.name, advice)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Advise explains what to change when, between two samples of a pool, requests waited too
+// long for a connection or connections were closed only for want of room to keep them
+func Advise(config Config, previous, current sql.DBStats) []string {
+	advice := []string{}
+	if waits := current.WaitCount - previous.WaitCount; waits > 0 {
+		average := (current.WaitDuration - previous.WaitDuration) / time.Duration(waits)
+		if average > time.Duration(config.WaitWarningMillis)*time.Millisecond {
+			advice = append(advice, fmt.Sprintf(
+				"%d requests waited %s on average for one of %d connections; raise max_open_conns if Postgres max_connections allows, or shorten the queries holding connections",
+				waits, average.Round(time.Millisecond), current.MaxOpenConnections))
+		}
+	}
+	// Closing more than a full pool's worth of connections in one interval is churn: they
+	// were needed again soon after
+	churn := int64(current.MaxOpenConnections)
+	if closed := current.MaxIdleClosed - previous.MaxIdleClosed; closed > churn {
+		advice = append(advice, fmt.Sprintf(
+			"%d connections were closed because max_idle_conns is %d; raise it toward max_open_conns to keep them between bursts",
+			closed, config.MaxIdleConns))
+	}
+	if closed := current.MaxIdleTimeClosed - previous.MaxIdleTimeClosed; closed > churn {
+		advice = append(advice, fmt.Sprintf(
+			"%d connections were closed after %ds idle; raise conn_max_idle_time_seconds or set it to 0",
+			closed, config.ConnMaxIdleTimeSeconds))
+	}
+	return advice
+}
+
+// WriteMetrics writes the pools' stats in the Prometheus text format, with metric names
+// starting namespace_db_pool_
+func WriteMetrics(w io.Writer, namespace string, monitors ...*Monitor) error {
+	if len(monitors) == 0 {
+		return nil
+	}
+	stats := make([]Stats, len(monitors))
+	for i, m := range monitors {
+		stats[i] = m.Stats()
+	}
+
+	var b strings.Builder
+	family := func(name, kind, help string, samples func(s Stats) []string) {
+		fmt.Fprintf(&b, "\n# HELP %s_db_pool_%s %s\n# TYPE %s_db_pool_%s %s\n", namespace, name, help, namespace, name, kind)
+		for _, s := range stats {
+			for _, sample := range samples(s) {
+				fmt.Fprintf(&b, "%s_db_pool_%s{pool=%q%s\n", namespace, name, s.Name, sample)
+			}
+		}
+	}
+	family("connections", "gauge", "Connections by state", func(s Stats) []string {
+		return []string{
+			fmt.Sprintf(`,state="in_use"} %d`, s.InUse),
+			fmt.Sprintf(`,state="idle"} %d`, s.Idle),
+		}
+	})
+	family("max_open_connections", "gauge", "Most connections the pool may open", func(s Stats) []string {
+		return []string{fmt.Sprintf("} %d", s.MaxOpen)}
+	})
+	family("utilization_ratio", "gauge", "Connections in use over the most the pool may open", func(s Stats) []string {
+		return []string{fmt.Sprintf("} %g", s.Utilization)}
+	})
+	family("waits_total", "counter", "Requests that waited for a connection", func(s Stats) []string {
+		return []string{fmt.Sprintf("} %d", s.WaitTotal)}
+	})
+	family("wait_seconds_total", "counter", "Time spent waiting for connections", func(s Stats) []string {
+		return []string{fmt.Sprintf("} %g", s.WaitSecondsTotal)}
+	})
+	family("closed_total", "counter", "Connections closed by the pool, by limit reached", func(s Stats) []string {
+		return []string{
+			fmt.Sprintf(`,reason="max_idle"} %d`, s.MaxIdleClosedTotal),
+			fmt.Sprintf(`,reason="max_idle_time"} %d`, s.MaxIdleTimeClosedTotal),
+			fmt.Sprintf(`,reason="max_lifetime"} %d`, s.MaxLifetimeClosedTotal),
+		}
+	})
+	family("statement_cache_total", "counter", "Prepared statement lookups, by result", func(s Stats) []string {
+		return []string{
+			fmt.Sprintf(`,result="hit"} %d`, s.StatementHitsTotal),
+			fmt.Sprintf(`,result="miss"} %d`, s.StatementMissesTotal),
+		}
+	})
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,157 @@
+package dbpool
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+)
+
+// StatementCache runs queries through prepared statements, keeping the most recently used
+// ones. database/sql prepares a statement on each connection the first time it runs there,
+// so a cached query is parsed once per connection. A cache of size 0 runs every query
+// directly on the pool. It is safe for concurrent use.
+type StatementCache struct {
+	db   *sql.DB
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds *cachedStatement, most recently used first
+	order *list.List
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cachedStatement struct {
+	query string
+	stmt  *sql.Stmt
+	// users is how many calls are starting a query on stmt; an evicted statement is closed
+	// when the last of them has started, since running queries keep it open themselves
+	users   int
+	evicted bool
+}
+
+// NewStatementCache keeps up to size prepared statements for db
+func NewStatementCache(db *sql.DB, size int) *StatementCache {
+	return &StatementCache{db: db, size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Counts returns how many queries found their statement prepared, and how many prepared it
+func (c *StatementCache) Counts() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// Len is how many statements are prepared
+func (c *StatementCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// QueryContext runs a query that returns rows
+func (c *StatementCache) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if c.size == 0 {
+		return c.db.QueryContext(ctx, query, args...)
+	}
+	entry, err := c.acquire(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(entry)
+	return entry.stmt.QueryContext(ctx, args...)
+}
+
+// QueryRowContext runs a query that returns at most one row
+func (c *StatementCache) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if c.size == 0 {
+		return c.db.QueryRowContext(ctx, query, args...)
+	}
+	entry, err := c.acquire(ctx, query)
+	if err != nil {
+		// sql.Row cannot be built with an error, so the query runs unprepared to report it
+		return c.db.QueryRowContext(ctx, query, args...)
+	}
+	defer c.release(entry)
+	return entry.stmt.QueryRowContext(ctx, args...)
+}
+
+// ExecContext runs a query that returns no rows
+func (c *StatementCache) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if c.size == 0 {
+		return c.db.ExecContext(ctx, query, args...)
+	}
+	entry, err := c.acquire(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(entry)
+	return entry.stmt.ExecContext(ctx, args...)
+}
+
+// acquire finds or prepares query's statement and marks it in use
+func (c *StatementCache) acquire(ctx context.Context, query string) (*cachedStatement, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[query]; ok {
+		c.order.MoveToFront(element)
+		entry := element.Value.(*cachedStatement)
+		entry.users++
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return entry, nil
+	}
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Another call may have prepared it meanwhile
+	if element, ok := c.entries[query]; ok {
+		stmt.Close()
+		entry := element.Value.(*cachedStatement)
+		entry.users++
+		return entry, nil
+	}
+	entry := &cachedStatement{query: query, stmt: stmt, users: 1}
+	c.entries[query] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		evicted := c.order.Remove(oldest).(*cachedStatement)
+		delete(c.entries, evicted.query)
+		evicted.evicted = true
+		if evicted.users == 0 {
+			evicted.stmt.Close()
+		}
+	}
+	return entry, nil
+}
+
+func (c *StatementCache) release(entry *cachedStatement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.users--
+	if entry.evicted && entry.users == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// Close closes every prepared statement
+func (c *StatementCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.order.Len() > 0 {
+		entry := c.order.Remove(c.order.Front()).(*cachedStatement)
+		delete(c.entries, entry.query)
+		entry.evicted = true
+		if entry.users == 0 {
+			entry.stmt.Close()
+		}
+	}
+}
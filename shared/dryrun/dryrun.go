@@ -0,0 +1,80 @@
+// Package dryrun previews destructive admin operations.
+//
+// Every endpoint that deletes, revokes or resets accepts ?dry_run=true. Instead of acting,
+// it answers with a Plan: what the operation would touch, how many of each kind and a few
+// examples, so an admin can check the blast radius before running it for real. Plans are
+// built from the same selection the operation uses, but nothing is locked, so the real run
+// may find slightly different rows if data changes in between.
+package dryrun
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Param is the query parameter that asks for a preview
+const Param = "dry_run"
+
+// SampleSize is the most examples listed per kind of entity
+const SampleSize = 10
+
+// Requested reports whether the request asks for a preview
+func Requested(r *http.Request) bool {
+	preview, _ := strconv.ParseBool(r.URL.Query().Get(Param))
+	return preview
+}
+
+// Effect is one kind of entity an operation would change
+type Effect struct {
+	// Entity names what is affected, e.g. "access_tokens"
+	Entity string `json:"entity"`
+	// Action is what would happen to it, e.g. "delete" or "revoke"
+	Action string `json:"action"`
+	Count  int64  `json:"count"`
+	// Sample identifies up to SampleSize of the affected entities
+	Sample []string `json:"sample"`
+}
+
+// Plan describes what a destructive operation would do
+type Plan struct {
+	// DryRun is always true, so a preview cannot be mistaken for the real response
+	DryRun    bool     `json:"dry_run"`
+	Operation string   `json:"operation"`
+	Target    string   `json:"target,omitempty"`
+	Effects   []Effect `json:"effects"`
+	// Warnings describe consequences that are not counted, e.g. caches that are cleared
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// New starts a plan for an operation on target
+func New(operation, target string) *Plan {
+	return &Plan{DryRun: true, Operation: operation, Target: target, Effects: []Effect{}}
+}
+
+// Add records that count entities would have action applied, with some of them as examples.
+// Samples beyond SampleSize are dropped.
+func (p *Plan) Add(entity, action string, count int64, sample []string) *Plan {
+	if len(sample) > SampleSize {
+		sample = sample[:SampleSize]
+	}
+	if sample == nil {
+		sample = []string{}
+	}
+	p.Effects = append(p.Effects, Effect{Entity: entity, Action: action, Count: count, Sample: sample})
+	return p
+}
+
+// Warn records a consequence the effects do not show
+func (p *Plan) Warn(warning string) *Plan {
+	p.Warnings = append(p.Warnings, warning)
+	return p
+}
+
+// Total is the number of entities the operation would change
+func (p *Plan) Total() int64 {
+	var total int64
+	for _, effect := range p.Effects {
+		total += effect.Count
+	}
+	return total
+}
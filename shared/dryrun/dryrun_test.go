@@ -0,0 +1,55 @@
+package dryrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequested(t *testing.T) {
+	for target, want := range map[string]bool{
+		"/admin/clients/1":              false,
+		"/admin/clients/1?dry_run=true": true,
+		"/admin/clients/1?dry_run=1":    true,
+		"/admin/clients/1?dry_run=no":   false,
+		"/admin/clients/1?dry_run=":     false,
+	} {
+		if got := Requested(httptest.NewRequest("DELETE", target, nil)); got != want {
+			t.Errorf("Requested(%s) = %t, want %t", target, got, want)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	sample := make([]string, 25)
+	for i := range sample {
+		sample[i] = fmt.Sprintf("token-%d", i)
+	}
+	plan := New("delete_client", "client-1").
+		Add("clients", "deactivate", 1, []string{"client-1"}).
+		Add("access_tokens", "revoke", 25, sample).
+		Add("refresh_tokens", "revoke", 0, nil).
+		Warn("cached tokens are invalidated on every replica")
+
+	if plan.Total() != 26 {
+		t.Fatalf("total %d, want 26", plan.Total())
+	}
+	if len(plan.Effects[1].Sample) != SampleSize {
+		t.Fatalf("sample of %d, want %d", len(plan.Effects[1].Sample), SampleSize)
+	}
+
+	encoded, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(encoded, &decoded)
+	if decoded["dry_run"] != true {
+		t.Fatal("plans must say they are dry runs")
+	}
+	effects := decoded["effects"].([]interface{})
+	if empty := effects[2].(map[string]interface{})["sample"]; empty == nil {
+		t.Fatal("empty samples must encode as [], not null")
+	}
+}
@@ -0,0 +1,3 @@
+module liberation-dryrun
+
+go 1.21
@@ -0,0 +1,3 @@
+module liberation-profiling
+
+go 1.21
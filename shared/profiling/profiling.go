@@ -0,0 +1,271 @@
+// Package profiling exposes the Go runtime profiles of the platform services and ships them
+// to a continuous profiling server.
+//
+// Handler serves net/http/pprof and is meant for a service's admin-only listener, where
+// Parca and other pull-based profilers can scrape it. Pusher periodically records a CPU and
+// a heap profile and uploads them to a server speaking Pyroscope's /ingest API.
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PathPrefix is where Handler serves the profiles
+const PathPrefix = "/debug/pprof/"
+
+const (
+	// DefaultPushInterval is how often profiles are uploaded
+	DefaultPushInterval = time.Minute
+	// DefaultCPUDuration is how long each CPU profile records
+	DefaultCPUDuration = 10 * time.Second
+)
+
+// Config controls the pprof endpoints and continuous profiling export
+type Config struct {
+	// Pprof serves /debug/pprof on the service's admin-only listener
+	Pprof bool `yaml:"pprof" json:"pprof"`
+	// BlockRate and MutexFraction enable the block and mutex profiles; 0 leaves them off
+	BlockRate     int `yaml:"block_rate" json:"block_rate"`
+	MutexFraction int `yaml:"mutex_fraction" json:"mutex_fraction"`
+	// Push uploads profiles continuously; it is off without a URL
+	Push PushConfig `yaml:"push" json:"push"`
+}
+
+// PushConfig describes a Pyroscope-compatible ingestion server
+type PushConfig struct {
+	// URL is the server's base URL, e.g. http://pyroscope:4040
+	URL string `yaml:"url" json:"url"`
+	// AppName names the application profiles are filed under
+	AppName string `yaml:"app_name" json:"app_name"`
+	// Tags are attached to every upload, e.g. region or instance
+	Tags map[string]string `yaml:"tags" json:"tags"`
+	// AuthToken is sent as a bearer token when set
+	AuthToken       string `yaml:"auth_token" json:"auth_token"`
+	IntervalSeconds int    `yaml:"interval_seconds" json:"interval_seconds"`
+	CPUSeconds      int    `yaml:"cpu_seconds" json:"cpu_seconds"`
+}
+
+// ApplyEnv overrides settings from environment variables named prefix + PPROF, BLOCK_RATE,
+// MUTEX_FRACTION, PUSH_URL, PUSH_APP_NAME, PUSH_TAGS, PUSH_AUTH_TOKEN,
+// PUSH_INTERVAL_SECONDS and PUSH_CPU_SECONDS. PUSH_TAGS is a comma-separated list of
+// name=value pairs.
+func (c *Config) ApplyEnv(prefix string) {
+	if value, ok := os.LookupEnv(prefix + "PPROF"); ok {
+		c.Pprof, _ = strconv.ParseBool(value)
+	}
+	if value, ok := os.LookupEnv(prefix + "BLOCK_RATE"); ok {
+		c.BlockRate, _ = strconv.Atoi(value)
+	}
+	if value, ok := os.LookupEnv(prefix + "MUTEX_FRACTION"); ok {
+		c.MutexFraction, _ = strconv.Atoi(value)
+	}
+	if value, ok := os.LookupEnv(prefix + "PUSH_URL"); ok {
+		c.Push.URL = value
+	}
+	if value, ok := os.LookupEnv(prefix + "PUSH_APP_NAME"); ok {
+		c.Push.AppName = value
+	}
+	if value, ok := os.LookupEnv(prefix + "PUSH_TAGS"); ok {
+		c.Push.Tags = map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			if name, tag, found := strings.Cut(strings.TrimSpace(pair), "="); found {
+				c.Push.Tags[strings.TrimSpace(name)] = strings.TrimSpace(tag)
+			}
+		}
+	}
+	if value, ok := os.LookupEnv(prefix + "PUSH_AUTH_TOKEN"); ok {
+		c.Push.AuthToken = value
+	}
+	if value, ok := os.LookupEnv(prefix + "PUSH_INTERVAL_SECONDS"); ok {
+		c.Push.IntervalSeconds, _ = strconv.Atoi(value)
+	}
+	if value, ok := os.LookupEnv(prefix + "PUSH_CPU_SECONDS"); ok {
+		c.Push.CPUSeconds, _ = strconv.Atoi(value)
+	}
+}
+
+// Validate reports settings that cannot work
+func (c Config) Validate() error {
+	if c.BlockRate < 0 || c.MutexFraction < 0 {
+		return fmt.Errorf("block_rate and mutex_fraction may not be negative")
+	}
+	if c.Push.URL == "" {
+		return nil
+	}
+	if parsed, err := url.Parse(c.Push.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("push url %q is not an http(s) URL", c.Push.URL)
+	}
+	if c.Push.AppName == "" {
+		return fmt.Errorf("push app_name is required")
+	}
+	interval, cpu := c.Push.intervals()
+	if cpu >= interval {
+		return fmt.Errorf("push cpu_seconds (%s) must be shorter than interval_seconds (%s)", cpu, interval)
+	}
+	return nil
+}
+
+// Apply enables the runtime's block and mutex profiles as configured
+func (c Config) Apply() {
+	if c.BlockRate > 0 {
+		runtime.SetBlockProfileRate(c.BlockRate)
+	}
+	if c.MutexFraction > 0 {
+		runtime.SetMutexProfileFraction(c.MutexFraction)
+	}
+}
+
+func (p PushConfig) intervals() (interval, cpu time.Duration) {
+	interval, cpu = DefaultPushInterval, DefaultCPUDuration
+	if p.IntervalSeconds > 0 {
+		interval = time.Duration(p.IntervalSeconds) * time.Second
+	}
+	if p.CPUSeconds > 0 {
+		cpu = time.Duration(p.CPUSeconds) * time.Second
+	}
+	return interval, cpu
+}
+
+// Handler serves the net/http/pprof endpoints under PathPrefix. It must only be mounted on
+// listeners that are not reachable from the public internet.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathPrefix, pprof.Index)
+	mux.HandleFunc(PathPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PathPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(PathPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(PathPrefix+"trace", pprof.Trace)
+	return mux
+}
+
+// Pusher uploads CPU and heap profiles to a Pyroscope-compatible server
+type Pusher struct {
+	config   PushConfig
+	client   *http.Client
+	interval time.Duration
+	cpu      time.Duration
+}
+
+// NewPusher creates a pusher; it returns nil when no push URL is configured
+func NewPusher(config Config) *Pusher {
+	if config.Push.URL == "" {
+		return nil
+	}
+	interval, cpu := config.Push.intervals()
+	return &Pusher{
+		config:   config.Push,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		interval: interval,
+		cpu:      cpu,
+	}
+}
+
+// Start uploads profiles every interval until ctx is cancelled
+func (p *Pusher) Start(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			if err := p.Push(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Continuous profiling: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Push records one CPU profile and one heap profile and uploads both
+func (p *Pusher) Push(ctx context.Context) error {
+	var cpu bytes.Buffer
+	from := time.Now()
+	// Fails while someone is fetching /debug/pprof/profile; the heap profile still goes out
+	cpuErr := runtimepprof.StartCPUProfile(&cpu)
+	if cpuErr == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.cpu):
+		}
+		runtimepprof.StopCPUProfile()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		cpuErr = p.upload(ctx, "cpu", from, time.Now(), cpu.Bytes())
+	}
+
+	var heap bytes.Buffer
+	now := time.Now()
+	if err := runtimepprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+		return err
+	}
+	if err := p.upload(ctx, "heap", now, now, heap.Bytes()); err != nil {
+		return err
+	}
+	if cpuErr != nil {
+		return fmt.Errorf("cpu profile: %w", cpuErr)
+	}
+	return nil
+}
+
+func (p *Pusher) upload(ctx context.Context, kind string, from, until time.Time, profile []byte) error {
+	query := url.Values{}
+	query.Set("name", p.name())
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	query.Set("format", "pprof")
+	query.Set("spyName", "gospy")
+	if kind == "cpu" {
+		query.Set("sampleRate", "100")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.config.URL, "/")+"/ingest?"+query.Encode(), bytes.NewReader(profile))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if p.config.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.AuthToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s profile: %w", kind, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s profile: ingest answered %s", kind, resp.Status)
+	}
+	return nil
+}
+
+// name renders the application name with its tags, e.g. liberation-auth{region=eu}
+func (p *Pusher) name() string {
+	if len(p.config.Tags) == 0 {
+		return p.config.AppName
+	}
+	tags := make([]string, 0, len(p.config.Tags))
+	for name, value := range p.config.Tags {
+		tags = append(tags, name+"="+value)
+	}
+	sort.Strings(tags)
+	return p.config.AppName + "{" + strings.Join(tags, ",") + "}"
+}
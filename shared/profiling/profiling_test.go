@@ -0,0 +1,93 @@
+package profiling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHandlerServesProfiles(t *testing.T) {
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	for _, path := range []string{"", "heap?debug=1", "goroutine?debug=1", "cmdline"} {
+		resp, err := http.Get(server.URL + PathPrefix + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %s", path, resp.Status)
+		}
+	}
+}
+
+func TestPushUploadsCPUAndHeap(t *testing.T) {
+	var mu sync.Mutex
+	var names []string
+	empty := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/ingest" || r.URL.Query().Get("format") != "pprof" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		names = append(names, r.URL.Query().Get("name"))
+		if len(body) == 0 {
+			empty++
+		}
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	pusher := NewPusher(Config{Push: PushConfig{
+		URL:        server.URL,
+		AppName:    "liberation-auth",
+		Tags:       map[string]string{"region": "eu", "instance": "a"},
+		AuthToken:  "secret",
+		CPUSeconds: 1,
+	}})
+	if err := pusher.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	if len(names) != 2 || empty > 0 {
+		t.Fatalf("expected a cpu and a heap upload, got %v", names)
+	}
+	if names[0] != "liberation-auth{instance=a,region=eu}" {
+		t.Fatalf("unexpected application name %q", names[0])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if NewPusher(Config{}) != nil {
+		t.Fatal("pusher created without a URL")
+	}
+	for _, config := range []Config{
+		{Push: PushConfig{URL: "pyroscope:4040", AppName: "a"}},
+		{Push: PushConfig{URL: "http://pyroscope:4040"}},
+		{Push: PushConfig{URL: "http://pyroscope:4040", AppName: "a", IntervalSeconds: 5, CPUSeconds: 10}},
+		{BlockRate: -1},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("accepted %+v", config)
+		}
+	}
+
+	t.Setenv("PROFILING_PUSH_URL", "http://pyroscope:4040")
+	t.Setenv("PROFILING_PUSH_APP_NAME", "liberation-ai")
+	t.Setenv("PROFILING_PUSH_TAGS", "region=eu, instance=b")
+	var config Config
+	config.ApplyEnv("PROFILING_")
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if config.Push.Tags["instance"] != "b" || !strings.HasPrefix(config.Push.URL, "http://") {
+		t.Fatalf("environment not applied: %+v", config.Push)
+	}
+}
@@ -0,0 +1,3 @@
+module liberation-scheduler
+
+go 1.21
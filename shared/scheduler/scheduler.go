@@ -0,0 +1,411 @@
+// Package scheduler runs background jobs on cron schedules.
+//
+// Every job has a built-in schedule that configuration can override, along with a jitter
+// that spreads runs out, a catch-up policy for runs missed while the service was down, and
+// an enabled switch. Admins can change the schedule and the switch at runtime; those changes
+// and the result of each job's last run are kept in a Store.
+//
+// Scheduler runs jobs in process, for services with a single instance. Services that run
+// several replicas elect a leader per job themselves and use Timing to decide when a job is
+// due, so both kinds of service schedule jobs the same way.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrUnknownJob is returned for job names that were never registered
+var ErrUnknownJob = errors.New("unknown job")
+
+// Func runs a job once and returns a short summary of what it did
+type Func func(ctx context.Context) (string, error)
+
+// Result describes one run of a job
+type Result struct {
+	// ScheduledFor is the scheduled time the run covered; manual runs keep the previous one
+	ScheduledFor time.Time `json:"scheduled_for"`
+	StartedAt    time.Time `json:"started_at"`
+	DurationMS   int64     `json:"duration_ms"`
+	// Status is success or failure
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Manual bool   `json:"manual,omitempty"`
+}
+
+// State is what a Store keeps about a job
+type State struct {
+	LastRun *Result `json:"last_run,omitempty"`
+	// Schedule and Enabled are an admin's overrides of the configured settings
+	Schedule string `json:"schedule,omitempty"`
+	Enabled  *bool  `json:"enabled,omitempty"`
+}
+
+// Store persists job state
+type Store interface {
+	Load(ctx context.Context) (map[string]State, error)
+	Save(ctx context.Context, name string, state State) error
+}
+
+// MemoryStore keeps job state until the process exits
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]State
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: map[string]State{}}
+}
+
+// Load returns a copy of every job's state
+func (m *MemoryStore) Load(ctx context.Context) (map[string]State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := make(map[string]State, len(m.states))
+	for name, state := range m.states {
+		states[name] = state
+	}
+	return states, nil
+}
+
+// Save replaces a job's state
+func (m *MemoryStore) Save(ctx context.Context, name string, state State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[name] = state
+	return nil
+}
+
+// FileStore keeps job state in a JSON file, rewritten atomically on every change
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore stores state at path; the file and its directory are created on first save
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load reads every job's state; a missing file means no job has run yet
+func (f *FileStore) Load(ctx context.Context) (map[string]State, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.read()
+}
+
+func (f *FileStore) read() (map[string]State, error) {
+	states := map[string]State{}
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", f.path, err)
+	}
+	return states, nil
+}
+
+// Save replaces a job's state
+func (f *FileStore) Save(ctx context.Context, name string, state State) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	states, err := f.read()
+	if err != nil {
+		return err
+	}
+	states[name] = state
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return err
+	}
+	temp := f.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, f.path)
+}
+
+// Status describes a job for the admin API
+type Status struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	// Overridden is true when an admin replaced the configured schedule
+	Overridden    bool       `json:"overridden"`
+	JitterSeconds float64    `json:"jitter_seconds"`
+	CatchUp       CatchUp    `json:"catch_up"`
+	Enabled       bool       `json:"enabled"`
+	Running       bool       `json:"running"`
+	NextRunAt     *time.Time `json:"next_run_at,omitempty"`
+	LastRun       *Result    `json:"last_run,omitempty"`
+}
+
+// Update changes a job at runtime. Nil fields are left alone; an empty Schedule restores
+// the configured one.
+type Update struct {
+	Schedule *string `json:"schedule"`
+	Enabled  *bool   `json:"enabled"`
+}
+
+type job struct {
+	name string
+	// configured is the timing from the built-in schedule and configuration
+	configured Timing
+	timing     Timing
+	run        Func
+	state      State
+	running    bool
+	manual     bool
+}
+
+// Scheduler runs registered jobs in this process
+type Scheduler struct {
+	config Config
+	store  Store
+	mu     sync.Mutex
+	jobs   map[string]*job
+	order  []string
+	wake   chan struct{}
+	now    func() time.Time
+}
+
+// New creates a scheduler; jobs are added with Register before Start
+func New(config Config, store Store) *Scheduler {
+	return &Scheduler{config: config, store: store, jobs: map[string]*job{}, wake: make(chan struct{}, 1), now: time.Now}
+}
+
+// Register adds a job whose built-in schedule is spec; configuration may override it
+func (s *Scheduler) Register(name, spec string, run Func) error {
+	timing, err := s.config.Timing(name, spec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.jobs[name]; exists {
+		return fmt.Errorf("job %s registered twice", name)
+	}
+	s.jobs[name] = &job{name: name, configured: timing, timing: timing, run: run}
+	s.order = append(s.order, name)
+	return nil
+}
+
+// Start loads persisted state and runs jobs as they fall due until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) error {
+	states, err := s.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("loading job state: %w", err)
+	}
+
+	s.mu.Lock()
+	for name, state := range states {
+		if j := s.jobs[name]; j != nil {
+			if err := j.apply(state); err != nil {
+				log.Printf("Ignoring stored schedule of job %s: %v", name, err)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	go s.loop(ctx)
+	return nil
+}
+
+// apply restores a stored state, including an admin's overrides
+func (j *job) apply(state State) error {
+	j.state = state
+	j.timing = j.configured
+	if state.Enabled != nil {
+		j.timing.Enabled = *state.Enabled
+	}
+	if state.Schedule == "" {
+		return nil
+	}
+	timing, err := j.timing.WithSpec(state.Schedule)
+	if err != nil {
+		j.state.Schedule = ""
+		return err
+	}
+	j.timing = timing
+	return nil
+}
+
+func (j *job) last() time.Time {
+	if j.state.LastRun == nil {
+		return time.Time{}
+	}
+	return j.state.LastRun.ScheduledFor
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	for {
+		// Sleep until the next job is due, but wake every minute to notice clock changes
+		wait := time.Minute
+		s.mu.Lock()
+		now := s.now()
+		for _, name := range s.order {
+			j := s.jobs[name]
+			if j.running {
+				continue
+			}
+			if j.manual {
+				j.manual = false
+				s.start(ctx, j, j.last(), true)
+				continue
+			}
+			if !j.timing.Enabled {
+				continue
+			}
+			if covers, due := j.timing.Due(j.last(), now, DefaultGrace); due {
+				s.start(ctx, j, covers, false)
+				continue
+			}
+			if next := j.timing.Next(j.last(), now, DefaultGrace); !next.IsZero() && next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+		}
+		s.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// start runs a job in the background; s.mu must be held
+func (s *Scheduler) start(ctx context.Context, j *job, covers time.Time, manual bool) {
+	j.running = true
+	go func() {
+		started := s.now()
+		detail, err := j.run(ctx)
+		result := &Result{
+			ScheduledFor: covers,
+			StartedAt:    started,
+			DurationMS:   s.now().Sub(started).Milliseconds(),
+			Status:       "success",
+			Detail:       detail,
+			Manual:       manual,
+		}
+		if manual && covers.IsZero() {
+			// A job's first run may be manual; later runs are scheduled from it
+			result.ScheduledFor = started
+		}
+		if err != nil {
+			result.Status, result.Error = "failure", err.Error()
+			log.Printf("Background job %s failed after %dms: %v", j.name, result.DurationMS, err)
+		}
+
+		s.mu.Lock()
+		j.running = false
+		j.state.LastRun = result
+		state := j.state
+		s.mu.Unlock()
+
+		// Not bound to ctx: a run cut short by shutdown is still recorded
+		if err := s.store.Save(context.Background(), j.name, state); err != nil {
+			log.Printf("Failed to record run of job %s: %v", j.name, err)
+		}
+		s.signal()
+	}()
+}
+
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Trigger runs a job as soon as it is not already running, even if it is disabled
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[name]
+	if j == nil {
+		return ErrUnknownJob
+	}
+	j.manual = true
+	s.signal()
+	return nil
+}
+
+// Update changes a job's schedule or enables or disables it, and persists the change
+func (s *Scheduler) Update(ctx context.Context, name string, update Update) (Status, error) {
+	s.mu.Lock()
+	j := s.jobs[name]
+	if j == nil {
+		s.mu.Unlock()
+		return Status{}, ErrUnknownJob
+	}
+	previous, state := j.state, j.state
+	if update.Schedule != nil {
+		state.Schedule = *update.Schedule
+	}
+	if update.Enabled != nil {
+		state.Enabled = update.Enabled
+	}
+	if err := j.apply(state); err != nil {
+		j.apply(previous)
+		s.mu.Unlock()
+		return Status{}, err
+	}
+	status := s.status(j)
+	s.mu.Unlock()
+
+	s.signal()
+	return status, s.store.Save(ctx, name, state)
+}
+
+// Jobs describes every job in registration order
+func (s *Scheduler) Jobs() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]Status, 0, len(s.order))
+	for _, name := range s.order {
+		statuses = append(statuses, s.status(s.jobs[name]))
+	}
+	return statuses
+}
+
+// status describes a job; s.mu must be held
+func (s *Scheduler) status(j *job) Status {
+	status := Status{
+		Name:          j.name,
+		Schedule:      j.timing.Spec,
+		Overridden:    j.state.Schedule != "",
+		JitterSeconds: j.timing.Jitter.Seconds(),
+		CatchUp:       j.timing.CatchUp,
+		Enabled:       j.timing.Enabled,
+		Running:       j.running,
+		LastRun:       j.state.LastRun,
+	}
+	if j.timing.Enabled {
+		if next := j.timing.Next(j.last(), s.now(), DefaultGrace); !next.IsZero() {
+			status.NextRunAt = &next
+		}
+	}
+	return status
+}
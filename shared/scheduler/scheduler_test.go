@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func at(text string) time.Time {
+	t, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseCron(t *testing.T) {
+	for _, test := range []struct {
+		spec, after, next string
+	}{
+		{"*/15 * * * *", "2026-03-01T10:07:00Z", "2026-03-01T10:15:00Z"},
+		{"0 3 * * *", "2026-03-01T03:00:00Z", "2026-03-02T03:00:00Z"},
+		{"@hourly", "2026-03-01T10:07:30Z", "2026-03-01T11:00:00Z"},
+		{"30 9 * * mon-fri", "2026-03-06T10:00:00Z", "2026-03-09T09:30:00Z"},
+		{"0 0 * * 7", "2026-03-02T00:00:00Z", "2026-03-08T00:00:00Z"},
+		{"0 0 1 jan,jul *", "2026-03-01T00:00:00Z", "2026-07-01T00:00:00Z"},
+		// Day of month and day of week both restricted: either matches
+		{"0 12 13 * fri", "2026-03-01T00:00:00Z", "2026-03-06T12:00:00Z"},
+		{"0 0 29 2 *", "2026-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"5/20 0 * * *", "2026-03-01T00:30:00Z", "2026-03-01T00:45:00Z"},
+	} {
+		schedule, err := Parse(test.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", test.spec, err)
+		}
+		if next := schedule.Next(at(test.after)); !next.Equal(at(test.next)) {
+			t.Errorf("%q after %s: got %s, want %s", test.spec, test.after, next.Format(time.RFC3339), test.next)
+		}
+	}
+
+	every, err := Parse("@every 90m")
+	if err != nil || every.Next(at("2026-03-01T10:00:00Z")) != at("2026-03-01T11:30:00Z") {
+		t.Fatalf("@every 90m: %v", err)
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "10-5 * * * *", "*/0 * * * *", "0 0 31 2 *", "@every 0s", "@fortnightly"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) should fail", spec)
+		}
+	}
+}
+
+func TestDue(t *testing.T) {
+	daily := func(policy CatchUp) Timing {
+		schedule, _ := Parse("0 3 * * *")
+		return Timing{Schedule: schedule, CatchUp: policy, Enabled: true}
+	}
+	last := at("2026-03-01T03:00:00Z")
+
+	if covers, due := daily(CatchUpOnce).Due(time.Time{}, last, DefaultGrace); !due || !covers.Equal(last) {
+		t.Fatal("a job that never ran is due at once")
+	}
+	if _, due := daily(CatchUpOnce).Due(last, at("2026-03-02T02:59:00Z"), DefaultGrace); due {
+		t.Fatal("not due before the next scheduled time")
+	}
+
+	// Down from the 2nd until 10:00 on the 4th: three runs missed
+	now := at("2026-03-04T10:00:00Z")
+	if covers, due := daily(CatchUpOnce).Due(last, now, DefaultGrace); !due || !covers.Equal(now) {
+		t.Fatalf("once: got %s %t", covers, due)
+	}
+	if _, due := daily(CatchUpSkip).Due(last, now, DefaultGrace); due {
+		t.Fatal("skip drops runs missed by more than the grace period")
+	}
+	if _, due := daily(CatchUpSkip).Due(last, at("2026-03-02T03:02:00Z"), DefaultGrace); !due {
+		t.Fatal("skip still runs a job that is only a little late")
+	}
+	if next := daily(CatchUpSkip).Next(last, now, DefaultGrace); !next.Equal(at("2026-03-05T03:00:00Z")) {
+		t.Fatalf("skip waits for the next scheduled time, got %s", next)
+	}
+
+	covers, due := daily(CatchUpAll).Due(last, now, DefaultGrace)
+	replayed := 0
+	for due {
+		replayed++
+		covers, due = daily(CatchUpAll).Due(covers, now, DefaultGrace)
+	}
+	if replayed != 3 {
+		t.Fatalf("all replays each missed run, got %d", replayed)
+	}
+	if covers, due := daily(CatchUpAll).Due(last, last.AddDate(0, 0, MaxCatchUp+5), DefaultGrace); !due || !covers.Equal(last.AddDate(0, 0, MaxCatchUp+5)) {
+		t.Fatal("all runs once after missing more than MaxCatchUp runs")
+	}
+}
+
+func TestJitterShiftsTheSchedule(t *testing.T) {
+	config := Config{Jobs: map[string]JobConfig{"sweep": {JitterSeconds: 600}}}
+	timing, err := config.Timing("sweep", "@hourly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timing.Jitter <= 0 || timing.Jitter >= 10*time.Minute {
+		t.Fatalf("jitter %s out of range", timing.Jitter)
+	}
+	again, _ := config.Timing("sweep", "@hourly")
+	if again.Jitter != timing.Jitter {
+		t.Fatal("jitter must be the same on every replica")
+	}
+
+	last := at("2026-03-01T10:00:00Z").Add(timing.Jitter)
+	if _, due := timing.Due(last, at("2026-03-01T11:00:00Z"), DefaultGrace); due {
+		t.Fatal("a jittered run waits for its delay")
+	}
+	if covers, due := timing.Due(last, at("2026-03-01T11:00:00Z").Add(timing.Jitter), DefaultGrace); !due || !covers.Equal(at("2026-03-01T11:00:00Z").Add(timing.Jitter)) {
+		t.Fatal("a jittered run is due once its delay has passed")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("JOB_EXPORT_CLEANUP_SCHEDULE", "0 3 * * *")
+	t.Setenv("JOB_EXPORT_CLEANUP_CATCH_UP", "skip")
+	t.Setenv("JOB_CLIENT_USAGE_ROLLUP_ENABLED", "false")
+	t.Setenv("JOB_LEASE_TTL", "30s")
+
+	var config Config
+	config.ApplyEnv("JOB_")
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Jobs) != 2 {
+		t.Fatalf("unrelated variables must be ignored, got %v", config.Jobs)
+	}
+	cleanup, _ := config.Timing("export_cleanup", "@every 15m")
+	if cleanup.Spec != "0 3 * * *" || cleanup.CatchUp != CatchUpSkip || !cleanup.Enabled {
+		t.Fatalf("export_cleanup: %+v", cleanup)
+	}
+	rollup, _ := config.Timing("client_usage_rollup", "@hourly")
+	if rollup.Enabled || rollup.CatchUp != CatchUpOnce {
+		t.Fatalf("client_usage_rollup: %+v", rollup)
+	}
+
+	t.Setenv("JOB_EXPORT_CLEANUP_CATCH_UP", "sometimes")
+	config.ApplyEnv("JOB_")
+	if err := config.Validate(); err == nil {
+		t.Fatal("unknown catch-up policies must be rejected")
+	}
+}
+
+func TestSchedulerRunsAndPersists(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "state", "jobs.json"))
+	scheduler := New(Config{}, store)
+	ran := make(chan struct{}, 10)
+	if err := scheduler.Register("sweep", "@daily", func(ctx context.Context) (string, error) {
+		ran <- struct{}{}
+		return "swept 3 objects", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Register("sweep", "@daily", nil); err == nil {
+		t.Fatal("jobs register once")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := scheduler.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Never run before, so it runs at once
+	waitFor(t, ran)
+	waitUntil(t, func() bool { return scheduler.Jobs()[0].LastRun != nil })
+
+	disabled := false
+	status, err := scheduler.Update(ctx, "sweep", Update{Enabled: &disabled})
+	if err != nil || status.Enabled || status.NextRunAt != nil {
+		t.Fatalf("disable: %+v %v", status, err)
+	}
+	bad := "every day"
+	if _, err := scheduler.Update(ctx, "sweep", Update{Schedule: &bad}); err == nil {
+		t.Fatal("invalid schedules must be rejected")
+	}
+	if err := scheduler.Trigger("missing"); err != ErrUnknownJob {
+		t.Fatalf("got %v", err)
+	}
+	if err := scheduler.Trigger("sweep"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, ran)
+	waitUntil(t, func() bool { return scheduler.Jobs()[0].LastRun.Manual })
+
+	states, err := store.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := states["sweep"]
+	if state.Enabled == nil || *state.Enabled || state.Schedule != "" || state.LastRun.Detail != "swept 3 objects" {
+		t.Fatalf("stored state: %+v", state)
+	}
+}
+
+func waitFor(t *testing.T, ran chan struct{}) {
+	t.Helper()
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job did not run")
+	}
+}
+
+func waitUntil(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatal("condition not met")
+}
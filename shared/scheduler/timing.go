@@ -0,0 +1,214 @@
+package scheduler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CatchUp decides what happens to runs that were missed while no instance was running a job
+type CatchUp string
+
+const (
+	// CatchUpOnce runs a job once for any number of missed runs
+	CatchUpOnce CatchUp = "once"
+	// CatchUpSkip drops missed runs; the job waits for its next scheduled time
+	CatchUpSkip CatchUp = "skip"
+	// CatchUpAll replays each missed run in order, up to MaxCatchUp of them. A job that missed
+	// more runs than that runs once instead.
+	CatchUpAll CatchUp = "all"
+)
+
+// MaxCatchUp is the most missed runs CatchUpAll replays
+const MaxCatchUp = 24
+
+// DefaultGrace is how late a run may start and still count as on time rather than missed
+const DefaultGrace = 5 * time.Minute
+
+// JobConfig overrides a job's built-in schedule
+type JobConfig struct {
+	// Schedule is a cron expression, a descriptor such as @daily, or @every <duration>
+	Schedule string `yaml:"schedule" json:"schedule,omitempty"`
+	// JitterSeconds delays each run by up to this much. The delay is fixed per job, so
+	// replicas agree on it and runs stay evenly spaced.
+	JitterSeconds int     `yaml:"jitter_seconds" json:"jitter_seconds,omitempty"`
+	CatchUp       CatchUp `yaml:"catch_up" json:"catch_up,omitempty"`
+	// Enabled defaults to true
+	Enabled *bool `yaml:"enabled" json:"enabled,omitempty"`
+}
+
+// Config holds the schedule overrides of every job, keyed by job name
+type Config struct {
+	Jobs map[string]JobConfig `yaml:"jobs" json:"jobs"`
+	// StateFile keeps last-run results and admin changes across restarts, for schedulers
+	// without a database. Without it they are kept in memory.
+	StateFile string `yaml:"state_file" json:"state_file"`
+}
+
+// ApplyEnv overrides settings from environment variables. prefix + STATE_FILE sets the state
+// file; prefix + <JOB>_SCHEDULE, _JITTER_SECONDS, _CATCH_UP and _ENABLED override one job,
+// e.g. JOB_EXPORT_CLEANUP_SCHEDULE="0 3 * * *" for the export_cleanup job.
+func (c *Config) ApplyEnv(prefix string) {
+	if value, ok := os.LookupEnv(prefix + "STATE_FILE"); ok {
+		c.StateFile = value
+	}
+	for _, variable := range os.Environ() {
+		key, value, _ := strings.Cut(variable, "=")
+		rest, found := strings.CutPrefix(key, prefix)
+		if !found {
+			continue
+		}
+		for _, setting := range []string{"_SCHEDULE", "_JITTER_SECONDS", "_CATCH_UP", "_ENABLED"} {
+			name, found := strings.CutSuffix(rest, setting)
+			if !found || name == "" {
+				continue
+			}
+			name = strings.ToLower(name)
+			if c.Jobs == nil {
+				c.Jobs = map[string]JobConfig{}
+			}
+			job := c.Jobs[name]
+			switch setting {
+			case "_SCHEDULE":
+				job.Schedule = value
+			case "_JITTER_SECONDS":
+				job.JitterSeconds, _ = strconv.Atoi(value)
+			case "_CATCH_UP":
+				job.CatchUp = CatchUp(strings.ToLower(value))
+			case "_ENABLED":
+				enabled, _ := strconv.ParseBool(value)
+				job.Enabled = &enabled
+			}
+			c.Jobs[name] = job
+			break
+		}
+	}
+}
+
+// Validate checks every job override
+func (c Config) Validate() error {
+	for name, job := range c.Jobs {
+		if job.Schedule != "" {
+			if _, err := Parse(job.Schedule); err != nil {
+				return fmt.Errorf("job %s: %w", name, err)
+			}
+		}
+		if job.JitterSeconds < 0 {
+			return fmt.Errorf("job %s: jitter_seconds cannot be negative", name)
+		}
+		if err := job.CatchUp.validate(); err != nil {
+			return fmt.Errorf("job %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (p CatchUp) validate() error {
+	switch p {
+	case "", CatchUpOnce, CatchUpSkip, CatchUpAll:
+		return nil
+	}
+	return fmt.Errorf("catch_up must be once, skip or all, not %q", p)
+}
+
+// Timing is when a job runs once its configuration is applied
+type Timing struct {
+	Spec     string
+	Schedule Schedule
+	// Jitter is the delay actually applied to every run of the job
+	Jitter  time.Duration
+	CatchUp CatchUp
+	Enabled bool
+}
+
+// Timing applies the configured overrides to a job whose built-in schedule is spec
+func (c Config) Timing(name, spec string) (Timing, error) {
+	job := c.Jobs[name]
+	if job.Schedule != "" {
+		spec = job.Schedule
+	}
+	schedule, err := Parse(spec)
+	if err != nil {
+		return Timing{}, fmt.Errorf("job %s: %w", name, err)
+	}
+	timing := Timing{Spec: spec, Schedule: schedule, CatchUp: job.CatchUp, Enabled: job.Enabled == nil || *job.Enabled}
+	if timing.CatchUp == "" {
+		timing.CatchUp = CatchUpOnce
+	}
+	if job.JitterSeconds > 0 {
+		timing.Jitter = jitter(name, time.Duration(job.JitterSeconds)*time.Second)
+	}
+	return timing, nil
+}
+
+// WithSpec returns the timing with its schedule replaced, e.g. by an admin's override
+func (t Timing) WithSpec(spec string) (Timing, error) {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return t, err
+	}
+	t.Spec, t.Schedule = spec, schedule
+	return t, nil
+}
+
+// jitter picks a job's fixed delay in [0, max) from its name
+func jitter(name string, max time.Duration) time.Duration {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return time.Duration(hash.Sum64() % uint64(max))
+}
+
+// Due decides whether a job should run at now. last is the scheduled time the previous run
+// covered, or zero if the job never ran; a job that never ran is due at once. When due, it
+// returns the scheduled time this run covers, which is recorded as the next call's last.
+func (t Timing) Due(last, now time.Time, grace time.Duration) (time.Time, bool) {
+	if last.IsZero() {
+		return now, true
+	}
+	// Jitter shifts the whole schedule, so evaluate it unshifted
+	last, now = last.Add(-t.Jitter), now.Add(-t.Jitter)
+
+	next := t.Schedule.Next(last)
+	if next.IsZero() || next.After(now) {
+		return time.Time{}, false
+	}
+	switch t.CatchUp {
+	case CatchUpAll:
+		missed := 1
+		for following := t.Schedule.Next(next); !following.IsZero() && !following.After(now); following = t.Schedule.Next(following) {
+			if missed++; missed > MaxCatchUp {
+				return now.Add(t.Jitter), true
+			}
+		}
+		return next.Add(t.Jitter), true
+	case CatchUpSkip:
+		// Only a scheduled time within grace of now is on time
+		from := last
+		if window := now.Add(-grace); window.After(from) {
+			from = window
+		}
+		if onTime := t.Schedule.Next(from); onTime.IsZero() || onTime.After(now) {
+			return time.Time{}, false
+		}
+	}
+	return now.Add(t.Jitter), true
+}
+
+// Next returns when a job last run for the scheduled time last is next due
+func (t Timing) Next(last, now time.Time, grace time.Duration) time.Time {
+	if _, due := t.Due(last, now, grace); due {
+		return now
+	}
+	next := t.Schedule.Next(last.Add(-t.Jitter))
+	if !next.After(now.Add(-t.Jitter)) {
+		// Missed runs that are skipped: the job waits for its next scheduled time
+		next = t.Schedule.Next(now.Add(-t.Jitter))
+	}
+	if next.IsZero() {
+		return next
+	}
+	return next.Add(t.Jitter)
+}
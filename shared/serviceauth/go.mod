@@ -0,0 +1,3 @@
+module liberation-serviceauth
+
+go 1.21
@@ -0,0 +1,343 @@
+// Package serviceauth lets the platform services call each other's internal and admin APIs
+// without sharing static admin tokens.
+//
+// Each service holds an Ed25519 private key and mints short-lived JWTs addressed to the
+// service it calls. The callee trusts a fixed set of callers, each by its public key, and
+// accepts a token only when it names the callee in its audience.
+package serviceauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TokenType is the JWT typ header of service tokens, which tells them apart from user tokens
+const TokenType = "service+jwt"
+
+// AudiencePrefix prefixes the callee's name in a token's audience. It cannot collide with
+// OAuth resource identifiers or first-party JWT audiences.
+const AudiencePrefix = "urn:liberation:service:"
+
+const (
+	// DefaultTTL is how long minted tokens are valid
+	DefaultTTL = time.Minute
+	// MaxTTL is the longest lifetime a verifier accepts, however the token was minted
+	MaxTTL = 5 * time.Minute
+	// Leeway allows for clock skew between services
+	Leeway = 30 * time.Second
+)
+
+var (
+	// ErrNotServiceToken is returned for tokens that are not service tokens, e.g. user JWTs
+	ErrNotServiceToken = errors.New("not a service token")
+
+	// ErrUntrusted is returned when the calling service is not trusted
+	ErrUntrusted = errors.New("service is not trusted")
+
+	// ErrInvalidToken is returned for malformed, forged, expired or misaddressed tokens
+	ErrInvalidToken = errors.New("invalid service token")
+
+	// ErrCannotMint is returned when no private key is configured
+	ErrCannotMint = errors.New("no service private key configured")
+)
+
+// Config describes a service's identity and the services it accepts calls from
+type Config struct {
+	// Name identifies this service, e.g. liberation-auth
+	Name string `yaml:"name" json:"name"`
+	// PrivateKeyFile is a PEM-encoded Ed25519 private key (PKCS #8); without it the
+	// service can verify tokens but not mint them
+	PrivateKeyFile string `yaml:"private_key_file" json:"private_key_file"`
+	// TrustedKeys maps each service allowed to call this one to its PEM-encoded public key file
+	TrustedKeys map[string]string `yaml:"trusted_keys" json:"trusted_keys"`
+	// TokenTTLSeconds is how long minted tokens are valid
+	TokenTTLSeconds int `yaml:"token_ttl_seconds" json:"token_ttl_seconds"`
+}
+
+// Enabled reports whether the service mints or accepts service tokens
+func (c Config) Enabled() bool {
+	return c.PrivateKeyFile != "" || len(c.TrustedKeys) > 0
+}
+
+// ApplyEnv overrides settings from environment variables named prefix + NAME,
+// PRIVATE_KEY_FILE, TRUSTED_KEYS and TOKEN_TTL_SECONDS. TRUSTED_KEYS is a comma-separated
+// list of name=path pairs.
+func (c *Config) ApplyEnv(prefix string) {
+	if value, ok := os.LookupEnv(prefix + "NAME"); ok {
+		c.Name = value
+	}
+	if value, ok := os.LookupEnv(prefix + "PRIVATE_KEY_FILE"); ok {
+		c.PrivateKeyFile = value
+	}
+	if value, ok := os.LookupEnv(prefix + "TRUSTED_KEYS"); ok {
+		c.TrustedKeys = map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			if name, path, found := strings.Cut(strings.TrimSpace(pair), "="); found {
+				c.TrustedKeys[strings.TrimSpace(name)] = strings.TrimSpace(path)
+			}
+		}
+	}
+	if value, ok := os.LookupEnv(prefix + "TOKEN_TTL_SECONDS"); ok {
+		c.TokenTTLSeconds, _ = strconv.Atoi(value)
+	}
+}
+
+// Claims are the contents of a verified service token
+type Claims struct {
+	// Issuer is the calling service
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Identity mints tokens as one service and verifies tokens from the services it trusts
+type Identity struct {
+	name    string
+	key     ed25519.PrivateKey
+	trusted map[string]ed25519.PublicKey
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// New loads the keys named by config
+func New(config Config) (*Identity, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("service name is required")
+	}
+	ttl := time.Duration(config.TokenTTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if ttl > MaxTTL {
+		return nil, fmt.Errorf("token_ttl_seconds may be at most %d", int(MaxTTL.Seconds()))
+	}
+
+	identity := &Identity{name: config.Name, trusted: map[string]ed25519.PublicKey{}, ttl: ttl, now: time.Now}
+	if config.PrivateKeyFile != "" {
+		key, err := LoadPrivateKey(config.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		identity.key = key
+	}
+	for service, path := range config.TrustedKeys {
+		key, err := LoadPublicKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("trusted key for %s: %w", service, err)
+		}
+		identity.trusted[service] = key
+	}
+	return identity, nil
+}
+
+// NewIdentity creates an identity from keys already in memory; key may be nil
+func NewIdentity(name string, key ed25519.PrivateKey, trusted map[string]ed25519.PublicKey) *Identity {
+	if trusted == nil {
+		trusted = map[string]ed25519.PublicKey{}
+	}
+	return &Identity{name: name, key: key, trusted: trusted, ttl: DefaultTTL, now: time.Now}
+}
+
+// Name is the service this identity mints tokens for
+func (id *Identity) Name() string {
+	return id.name
+}
+
+// CanMint reports whether a private key is configured
+func (id *Identity) CanMint() bool {
+	return id.key != nil
+}
+
+// Trusted lists the services whose tokens are accepted
+func (id *Identity) Trusted() []string {
+	services := make([]string, 0, len(id.trusted))
+	for service := range id.trusted {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// Mint returns a token for calling the target service
+func (id *Identity) Mint(target string) (string, error) {
+	if id.key == nil {
+		return "", ErrCannotMint
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	now := id.now()
+	head, _ := json.Marshal(header{Algorithm: "EdDSA", Type: TokenType, KeyID: id.name})
+	body, _ := json.Marshal(Claims{
+		Issuer:    id.name,
+		Subject:   id.name,
+		Audience:  AudiencePrefix + target,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(id.ttl).Unix(),
+		ID:        hex.EncodeToString(nonce),
+	})
+	signingInput := encode(head) + "." + encode(body)
+	return signingInput + "." + encode(ed25519.Sign(id.key, []byte(signingInput))), nil
+}
+
+// Verify checks that token was minted by a trusted service for this one, and has not expired
+func (id *Identity) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrNotServiceToken
+	}
+	var head header
+	if err := decodeJSON(parts[0], &head); err != nil || head.Type != TokenType {
+		return nil, ErrNotServiceToken
+	}
+	if head.Algorithm != "EdDSA" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, head.Algorithm)
+	}
+	key, ok := id.trusted[head.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUntrusted, head.KeyID)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	now := id.now()
+	issuedAt, expiresAt := time.Unix(claims.IssuedAt, 0), time.Unix(claims.ExpiresAt, 0)
+	switch {
+	case claims.Issuer != head.KeyID || claims.Subject != head.KeyID:
+		return nil, fmt.Errorf("%w: issuer does not match the signing key", ErrInvalidToken)
+	case claims.Audience != AudiencePrefix+id.name:
+		return nil, fmt.Errorf("%w: addressed to %q", ErrInvalidToken, claims.Audience)
+	case now.After(expiresAt.Add(Leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case issuedAt.After(now.Add(Leeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case expiresAt.Sub(issuedAt) > MaxTTL:
+		return nil, fmt.Errorf("%w: lifetime exceeds %s", ErrInvalidToken, MaxTTL)
+	}
+	return &claims, nil
+}
+
+// VerifyRequest verifies the bearer token in r's Authorization header. It returns
+// ErrNotServiceToken when the request carries no service token, so callers can fall
+// back to their other authentication.
+func (id *Identity) VerifyRequest(r *http.Request) (*Claims, error) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "bearer ") {
+		return nil, ErrNotServiceToken
+	}
+	return id.Verify(strings.TrimSpace(authorization[7:]))
+}
+
+// Transport returns a RoundTripper that adds a freshly minted token for target to every
+// request. base defaults to http.DefaultTransport.
+func (id *Identity) Transport(target string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{identity: id, target: target, base: base}
+}
+
+type transport struct {
+	identity *Identity
+	target   string
+	base     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, err := t.identity.Mint(t.target)
+	if err != nil {
+		return nil, err
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(r)
+}
+
+// LoadPrivateKey reads a PEM-encoded Ed25519 private key, as written by
+// `openssl genpkey -algorithm ed25519`
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+	return key, nil
+}
+
+// LoadPublicKey reads a PEM-encoded Ed25519 public key, as written by `openssl pkey -pubout`
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 public key", path)
+	}
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeJSON(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
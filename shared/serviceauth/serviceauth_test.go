@@ -0,0 +1,137 @@
+package serviceauth
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newPair(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return public, private
+}
+
+func TestMintAndVerify(t *testing.T) {
+	aiPublic, aiPrivate := newPair(t)
+	ai := NewIdentity("liberation-ai", aiPrivate, nil)
+	auth := NewIdentity("liberation-auth", nil, map[string]ed25519.PublicKey{"liberation-ai": aiPublic})
+
+	token, err := ai.Mint("liberation-auth")
+	if err != nil {
+		t.Fatalf("Mint: %v", err)
+	}
+	claims, err := auth.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Issuer != "liberation-ai" || claims.Audience != AudiencePrefix+"liberation-auth" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := auth.Mint("liberation-ai"); !errors.Is(err, ErrCannotMint) {
+		t.Fatalf("identity without a key minted a token: %v", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	aiPublic, aiPrivate := newPair(t)
+	_, otherPrivate := newPair(t)
+	trusted := map[string]ed25519.PublicKey{"liberation-ai": aiPublic}
+	auth := NewIdentity("liberation-auth", nil, trusted)
+
+	ai := NewIdentity("liberation-ai", aiPrivate, nil)
+	forger := NewIdentity("liberation-ai", otherPrivate, nil)
+	stranger := NewIdentity("liberation-metrics", otherPrivate, nil)
+	expired := NewIdentity("liberation-ai", aiPrivate, nil)
+	expired.now = func() time.Time { return time.Now().Add(-time.Hour) }
+
+	misaddressed, _ := ai.Mint("liberation-metrics")
+	forged, _ := forger.Mint("liberation-auth")
+	untrusted, _ := stranger.Mint("liberation-auth")
+	stale, _ := expired.Mint("liberation-auth")
+	valid, _ := ai.Mint("liberation-auth")
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + encode([]byte(`{"iss":"liberation-ai","sub":"liberation-ai","aud":"`+AudiencePrefix+`liberation-auth","exp":9999999999}`)) + "." + parts[2]
+
+	cases := map[string]struct {
+		token string
+		err   error
+	}{
+		"user jwt":     {"eyJhbGciOiJIUzI1NiJ9.e30.c2ln", ErrNotServiceToken},
+		"opaque":       {"abc123", ErrNotServiceToken},
+		"misaddressed": {misaddressed, ErrInvalidToken},
+		"forged":       {forged, ErrInvalidToken},
+		"untrusted":    {untrusted, ErrUntrusted},
+		"expired":      {stale, ErrInvalidToken},
+		"tampered":     {tampered, ErrInvalidToken},
+	}
+	for name, tc := range cases {
+		if _, err := auth.Verify(tc.token); !errors.Is(err, tc.err) {
+			t.Errorf("%s: got %v, want %v", name, err, tc.err)
+		}
+	}
+}
+
+func TestTransportAuthenticatesRequests(t *testing.T) {
+	aiPublic, aiPrivate := newPair(t)
+	ai := NewIdentity("liberation-ai", aiPrivate, nil)
+	auth := NewIdentity("liberation-auth", nil, map[string]ed25519.PublicKey{"liberation-ai": aiPublic})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := auth.VerifyRequest(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: ai.Transport("liberation-auth", nil)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+}
+
+func TestNewLoadsPEMKeys(t *testing.T) {
+	public, private := newPair(t)
+	dir := t.TempDir()
+	privateDER, _ := x509.MarshalPKCS8PrivateKey(private)
+	publicDER, _ := x509.MarshalPKIXPublicKey(public)
+	os.WriteFile(filepath.Join(dir, "ai.key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600)
+	os.WriteFile(filepath.Join(dir, "ai.pub"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o644)
+
+	t.Setenv("TEST_SERVICE_NAME", "liberation-ai")
+	t.Setenv("TEST_SERVICE_PRIVATE_KEY_FILE", filepath.Join(dir, "ai.key"))
+	t.Setenv("TEST_SERVICE_TRUSTED_KEYS", "liberation-ai="+filepath.Join(dir, "ai.pub"))
+	var config Config
+	config.ApplyEnv("TEST_SERVICE_")
+
+	identity, err := New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	token, _ := identity.Mint("liberation-ai")
+	if _, err := identity.Verify(token); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	config.TokenTTLSeconds = int(MaxTTL.Seconds()) + 1
+	if _, err := New(config); err == nil {
+		t.Fatal("accepted a token lifetime above MaxTTL")
+	}
+}
@@ -0,0 +1,15 @@
+package storage
+
+// NewGCSStore creates a store for Google Cloud Storage using the XML API's
+// S3 interoperability with HMAC keys. Encryption mode kms expects a full
+// Cloud KMS key name (projects/.../cryptoKeys/...).
+func NewGCSStore(cfg Config) (*S3Store, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	if cfg.Region == "" {
+		cfg.Region = "auto"
+	}
+	return newS3CompatibleStore("gcs", endpoint, false, cfg)
+}
@@ -0,0 +1,3 @@
+module liberation-storage
+
+go 1.21
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// LifecycleRule expires objects under Prefix once they are older than MaxAge
+type LifecycleRule struct {
+	Prefix string        `yaml:"prefix" json:"prefix"`
+	MaxAge time.Duration `yaml:"max_age" json:"max_age"`
+}
+
+// LifecycleHooks let consumers react to cleanup. BeforeDelete may veto a deletion by
+// returning false (e.g. an export that is still being downloaded); AfterDelete runs once
+// the object is gone so database references can be cleared.
+type LifecycleHooks struct {
+	BeforeDelete func(ctx context.Context, object Object) bool
+	AfterDelete  func(ctx context.Context, object Object)
+}
+
+// Janitor applies lifecycle rules to a store. Providers with native lifecycle
+// policies can do the same server-side; the janitor keeps behaviour identical
+// across backends, including local disk.
+type Janitor struct {
+	Store Store
+	Rules []LifecycleRule
+	Hooks LifecycleHooks
+	now   func() time.Time
+}
+
+// NewJanitor creates a janitor for store
+func NewJanitor(store Store, rules []LifecycleRule, hooks LifecycleHooks) *Janitor {
+	return &Janitor{Store: store, Rules: rules, Hooks: hooks, now: time.Now}
+}
+
+// Sweep deletes every expired object once and returns how many were removed
+func (j *Janitor) Sweep(ctx context.Context) (int, error) {
+	removed := 0
+	for _, rule := range j.Rules {
+		if rule.MaxAge <= 0 {
+			continue
+		}
+		objects, err := j.Store.List(ctx, rule.Prefix)
+		if err != nil {
+			return removed, err
+		}
+
+		cutoff := j.now().Add(-rule.MaxAge)
+		for _, object := range objects {
+			if !object.LastModified.Before(cutoff) {
+				continue
+			}
+			if j.Hooks.BeforeDelete != nil && !j.Hooks.BeforeDelete(ctx, object) {
+				continue
+			}
+			if err := j.Store.Delete(ctx, object.Key); err != nil {
+				return removed, err
+			}
+			removed++
+			if j.Hooks.AfterDelete != nil {
+				j.Hooks.AfterDelete(ctx, object)
+			}
+		}
+	}
+	return removed, nil
+}
+
+// Run sweeps every interval until ctx is cancelled
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if removed, err := j.Sweep(ctx); err != nil {
+			log.Printf("Storage lifecycle sweep failed: %v", err)
+		} else if removed > 0 {
+			log.Printf("Storage lifecycle sweep removed %d objects", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// localMetaDir holds per-object metadata sidecars below the store root
+const localMetaDir = ".meta"
+
+// LocalStore keeps objects on the local filesystem. Signed URLs point at BaseURL,
+// where the owning service is expected to mount the store as an http.Handler.
+type LocalStore struct {
+	root       string
+	baseURL    string
+	secret     []byte
+	key        []byte
+	encryption Encryption
+}
+
+type localMeta struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Size        int64             `json:"size"`
+	ETag        string            `json:"etag"`
+	Encrypted   bool              `json:"encrypted,omitempty"`
+}
+
+// NewLocalStore creates a store rooted at cfg.Root
+func NewLocalStore(cfg Config) (*LocalStore, error) {
+	if cfg.Root == "" {
+		return nil, errors.New("local storage requires a root directory")
+	}
+	if err := os.MkdirAll(filepath.Join(cfg.Root, localMetaDir), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage root: %w", err)
+	}
+
+	store := &LocalStore{
+		root:       cfg.Root,
+		baseURL:    strings.TrimSuffix(cfg.BaseURL, "/"),
+		secret:     []byte(cfg.SigningSecret),
+		encryption: cfg.Encryption,
+	}
+	if cfg.EncryptionKey != "" {
+		key, err := decodeKey(cfg.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		store.key = key
+	}
+	if cfg.Encryption.Mode == EncryptionKMS {
+		return nil, fmt.Errorf("local storage: kms encryption: %w", ErrUnsupported)
+	}
+	if cfg.Encryption.Mode == EncryptionAES256 && store.key == nil {
+		return nil, errors.New("local storage: AES256 encryption requires an encryption key")
+	}
+	return store, nil
+}
+
+// Backend returns the backend name
+func (s *LocalStore) Backend() string {
+	return "local"
+}
+
+func (s *LocalStore) objectPath(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *LocalStore) metaPath(key string) string {
+	return filepath.Join(s.root, localMetaDir, filepath.FromSlash(key)+".json")
+}
+
+// Put writes an object atomically
+func (s *LocalStore) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (*Object, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	encryption := opts.Encryption.orDefault(s.encryption)
+	switch encryption.Mode {
+	case EncryptionKMS:
+		return nil, fmt.Errorf("local storage: kms encryption: %w", ErrUnsupported)
+	case EncryptionAES256:
+		if s.key == nil {
+			return nil, errors.New("local storage: AES256 encryption requires an encryption key")
+		}
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	meta := localMeta{
+		ContentType: opts.ContentType,
+		Metadata:    opts.Metadata,
+		Size:        int64(len(data)),
+		ETag:        hex.EncodeToString(sum[:16]),
+	}
+	if encryption.Mode == EncryptionAES256 {
+		if data, err = s.seal(data); err != nil {
+			return nil, err
+		}
+		meta.Encrypted = true
+	}
+
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(s.objectPath(key), data); err != nil {
+		return nil, diskFull(err)
+	}
+	if err := writeFileAtomic(s.metaPath(key), metaBytes); err != nil {
+		return nil, diskFull(err)
+	}
+	return s.Stat(ctx, key)
+}
+
+// Get opens an object for reading
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	object, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := s.readMeta(key)
+
+	file, err := os.Open(s.objectPath(key))
+	if err != nil {
+		return nil, nil, notFound(err)
+	}
+	if !meta.Encrypted {
+		return file, object, nil
+	}
+
+	defer file.Close()
+	sealed, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := s.open(sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NopCloser(bytes.NewReader(plain)), object, nil
+}
+
+// Stat returns object metadata without reading the body
+func (s *LocalStore) Stat(ctx context.Context, key string) (*Object, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(s.objectPath(key))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if info.IsDir() {
+		return nil, ErrNotFound
+	}
+	return s.object(key, info), nil
+}
+
+// Delete removes an object; deleting a missing object is not an error
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(s.objectPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(s.metaPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List returns every object whose key starts with prefix
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			if path != s.root && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, *s.object(key, info))
+		return nil
+	})
+	return objects, err
+}
+
+// SignedURL returns a URL under BaseURL that ServeHTTP accepts until expiry
+func (s *LocalStore) SignedURL(ctx context.Context, key, method string, expiry time.Duration) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	if s.baseURL == "" || len(s.secret) == 0 {
+		return "", errors.New("local storage: signed URLs require a base URL and signing secret")
+	}
+	method = strings.ToUpper(method)
+	if method != http.MethodGet && method != http.MethodPut {
+		return "", fmt.Errorf("local storage: signed %s URLs: %w", method, ErrUnsupported)
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {s.sign(method, key, expires)},
+	}
+	return s.baseURL + "/" + escapePath(key) + "?" + query.Encode(), nil
+}
+
+// ServeHTTP serves GET, HEAD and PUT requests carrying a valid signature.
+// The request path, after any mount prefix has been stripped, is the object key.
+func (s *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+
+	expires := r.URL.Query().Get("expires")
+	signature := r.URL.Query().Get("signature")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt || len(s.secret) == 0 ||
+		!hmac.Equal([]byte(signature), []byte(s.sign(method, key, expires))) {
+		http.Error(w, "invalid or expired signature", http.StatusForbidden)
+		return
+	}
+
+	switch method {
+	case http.MethodGet:
+		body, object, err := s.Get(r.Context(), key)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		defer body.Close()
+		if object.ContentType != "" {
+			w.Header().Set("Content-Type", object.ContentType)
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+		w.Header().Set("ETag", `"`+object.ETag+`"`)
+		if r.Method == http.MethodHead {
+			return
+		}
+		io.Copy(w, body)
+	case http.MethodPut:
+		object, err := s.Put(r.Context(), key, r.Body, PutOptions{ContentType: r.Header.Get("Content-Type")})
+		if err != nil {
+			http.Error(w, "upload failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", `"`+object.ETag+`"`)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *LocalStore) sign(method, key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s", method, key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *LocalStore) object(key string, info fs.FileInfo) *Object {
+	meta := s.readMeta(key)
+	object := &Object{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  meta.ContentType,
+		ETag:         meta.ETag,
+		LastModified: info.ModTime(),
+		Metadata:     meta.Metadata,
+	}
+	if meta.Size > 0 || meta.ETag != "" {
+		object.Size = meta.Size
+	}
+	return object
+}
+
+func (s *LocalStore) readMeta(key string) localMeta {
+	var meta localMeta
+	if data, err := os.ReadFile(s.metaPath(key)); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	return meta
+}
+
+func (s *LocalStore) seal(plain []byte) ([]byte, error) {
+	gcm, err := s.gcm()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (s *LocalStore) open(sealed []byte) ([]byte, error) {
+	gcm, err := s.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("local storage: encrypted object is truncated")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("local storage: failed to decrypt object: %w", err)
+	}
+	return plain, nil
+}
+
+func (s *LocalStore) gcm() (cipher.AEAD, error) {
+	if s.key == nil {
+		return nil, errors.New("local storage: object is encrypted but no encryption key is configured")
+	}
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic writes through a temporary file so readers never see partial objects
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func notFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// diskFull marks writes that failed for lack of space so they match ErrQuotaExceeded
+func diskFull(err error) error {
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("local storage: %w: %w", ErrQuotaExceeded, err)
+	}
+	return err
+}
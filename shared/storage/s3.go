@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Store talks to S3-compatible object storage over its REST API
+type S3Store struct {
+	backend     string
+	bucket      string
+	endpoint    *url.URL
+	virtualHost bool
+	signer      sigv4
+	encryption  Encryption
+	metaPrefix  string
+	httpClient  *http.Client
+	now         func() time.Time
+}
+
+// NewS3Store creates a store for Amazon S3 or an S3-compatible endpoint.
+// Virtual-hosted addressing is used against AWS unless ForcePathStyle is set or the
+// bucket name contains dots; custom endpoints always use path-style addressing.
+func NewS3Store(cfg Config) (*S3Store, error) {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	endpoint := cfg.Endpoint
+	virtualHost := false
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+		virtualHost = !cfg.ForcePathStyle && !strings.Contains(cfg.Bucket, ".")
+	}
+	return newS3CompatibleStore("s3", endpoint, virtualHost, cfg)
+}
+
+func newS3CompatibleStore(backend, endpoint string, virtualHost bool, cfg Config) (*S3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("%s storage requires a bucket", backend)
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("%s storage requires an access key id and secret", backend)
+	}
+	parsed, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("%s storage: invalid endpoint %q", backend, endpoint)
+	}
+
+	store := &S3Store{
+		backend:     backend,
+		bucket:      cfg.Bucket,
+		endpoint:    parsed,
+		virtualHost: virtualHost,
+		signer:      sigv4{accessKey: cfg.AccessKeyID, secretKey: cfg.SecretAccessKey, region: cfg.Region},
+		encryption:  cfg.Encryption,
+		metaPrefix:  "X-Amz-Meta-",
+		httpClient:  &http.Client{Timeout: 5 * time.Minute},
+		now:         time.Now,
+	}
+	if backend == "gcs" {
+		store.metaPrefix = "X-Goog-Meta-"
+	}
+	if err := store.encryptionHeaders(http.Header{}, cfg.Encryption); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Backend returns the backend name
+func (s *S3Store) Backend() string {
+	return s.backend
+}
+
+func (s *S3Store) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	path := strings.TrimSuffix(s.endpoint.Path, "/")
+	rawPath := strings.TrimSuffix(s.endpoint.EscapedPath(), "/")
+	if s.virtualHost {
+		u.Host = s.bucket + "." + u.Host
+	} else {
+		path += "/" + s.bucket
+		rawPath += "/" + escapePath(s.bucket)
+	}
+	u.Path = path + "/" + key
+	u.RawPath = rawPath + "/" + escapePath(key)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// encryptionHeaders applies the server-side encryption headers for e
+func (s *S3Store) encryptionHeaders(header http.Header, e Encryption) error {
+	switch e.Mode {
+	case EncryptionNone:
+	case EncryptionAES256:
+		// GCS always encrypts at rest with Google-managed keys
+		if s.backend == "s3" {
+			header.Set("X-Amz-Server-Side-Encryption", "AES256")
+		}
+	case EncryptionKMS:
+		if s.backend == "gcs" {
+			if e.KMSKeyID == "" {
+				return errors.New("gcs storage: kms encryption requires a kms key name")
+			}
+			header.Set("X-Goog-Encryption-Kms-Key-Name", e.KMSKeyID)
+			return nil
+		}
+		header.Set("X-Amz-Server-Side-Encryption", "aws:kms")
+		if e.KMSKeyID != "" {
+			header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", e.KMSKeyID)
+		}
+	default:
+		return fmt.Errorf("unknown encryption mode %q", e.Mode)
+	}
+	return nil
+}
+
+func (s *S3Store) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = unsignedPayload
+	}
+	s.signer.signRequest(req, payloadHash, s.now())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s storage: %w", s.backend, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, s.responseError(method, resp)
+	}
+	return resp, nil
+}
+
+func (s *S3Store) responseError(method string, resp *http.Response) error {
+	var apiError struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	result := &APIError{Backend: s.backend, Method: method, StatusCode: resp.StatusCode}
+	if xml.Unmarshal(data, &apiError) == nil && apiError.Code != "" {
+		result.Code, result.Message = apiError.Code, apiError.Message
+	}
+	return result
+}
+
+// quotaCodes are the error codes S3-compatible services use for a full bucket or account
+var quotaCodes = map[string]bool{
+	"QuotaExceeded":                  true,
+	"XMinioStorageFull":              true,
+	"XMinioAdminBucketQuotaExceeded": true,
+}
+
+// APIError is an error response from an S3-compatible service. It matches ErrNotFound and
+// ErrQuotaExceeded with errors.Is when the response means either.
+type APIError struct {
+	Backend    string
+	Method     string
+	StatusCode int
+	// Code and Message are empty when the response had no XML error body
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s storage: %s returned %d %s: %s", e.Backend, e.Method, e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s storage: %s returned %d", e.Backend, e.Method, e.StatusCode)
+}
+
+// Is lets callers test the error against the package's sentinel errors
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound || e.Code == "NoSuchKey"
+	case ErrQuotaExceeded:
+		return e.StatusCode == http.StatusInsufficientStorage || quotaCodes[e.Code]
+	}
+	return false
+}
+
+// Put uploads an object
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (*Object, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if opts.ContentType != "" {
+		header.Set("Content-Type", opts.ContentType)
+	}
+	for name, value := range opts.Metadata {
+		header.Set(s.metaPrefix+name, value)
+	}
+	if err := s.encryptionHeaders(header, opts.Encryption.orDefault(s.encryption)); err != nil {
+		return nil, err
+	}
+
+	body, size, err := sizedBody(body, opts.Size)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, nil), body, size, header)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return &Object{
+		Key:          key,
+		Size:         size,
+		ContentType:  opts.ContentType,
+		ETag:         strings.Trim(resp.Header.Get("ETag"), `"`),
+		LastModified: s.now(),
+		Metadata:     opts.Metadata,
+	}, nil
+}
+
+// Get downloads an object
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key, nil), nil, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, s.objectFromHeader(key, resp.Header), nil
+}
+
+// Stat fetches object metadata with a HEAD request
+func (s *S3Store) Stat(ctx context.Context, key string) (*Object, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(key, nil), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return s.objectFromHeader(key, resp.Header), nil
+}
+
+// Delete removes an object; deleting a missing object is not an error
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, nil), nil, 0, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List pages through ListObjectsV2 for every key under prefix
+func (s *S3Store) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL("", query), nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				ETag         string    `xml:"ETag"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s storage: failed to decode listing: %w", s.backend, err)
+		}
+
+		for _, item := range page.Contents {
+			objects = append(objects, Object{
+				Key:          item.Key,
+				Size:         item.Size,
+				ETag:         strings.Trim(item.ETag, `"`),
+				LastModified: item.LastModified,
+			})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// SignedURL returns a presigned URL; expiry is capped at seven days
+func (s *S3Store) SignedURL(ctx context.Context, key, method string, expiry time.Duration) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	method = strings.ToUpper(method)
+	if method != http.MethodGet && method != http.MethodPut {
+		return "", fmt.Errorf("%s storage: signed %s URLs: %w", s.backend, method, ErrUnsupported)
+	}
+	return s.signer.presign(method, s.objectURL(key, nil), expiry, s.now()), nil
+}
+
+func (s *S3Store) objectFromHeader(key string, header http.Header) *Object {
+	object := &Object{
+		Key:         key,
+		ContentType: header.Get("Content-Type"),
+		ETag:        strings.Trim(header.Get("ETag"), `"`),
+	}
+	object.Size, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	object.LastModified, _ = http.ParseTime(header.Get("Last-Modified"))
+	for name := range header {
+		if strings.HasPrefix(name, s.metaPrefix) {
+			if object.Metadata == nil {
+				object.Metadata = make(map[string]string)
+			}
+			object.Metadata[strings.ToLower(strings.TrimPrefix(name, s.metaPrefix))] = header.Get(name)
+		}
+	}
+	return object
+}
@@ -0,0 +1,212 @@
+// Package storage provides a vendor-neutral object store used by the platform services
+// for user exports, avatars and raw ingestion archives.
+//
+// Backends:
+//   - local: files on disk, with HMAC-signed URLs served by LocalStore itself
+//   - s3:    Amazon S3 or any S3-compatible service (MinIO, R2, ...) via SigV4
+//   - gcs:   Google Cloud Storage through its S3-interoperable XML API with HMAC keys
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when an object does not exist
+	ErrNotFound = errors.New("object not found")
+
+	// ErrInvalidKey is returned for keys that are empty, absolute or escape the store
+	ErrInvalidKey = errors.New("invalid object key")
+
+	// ErrUnsupported is returned when a backend cannot honour an option
+	ErrUnsupported = errors.New("operation not supported by backend")
+
+	// ErrQuotaExceeded is returned when a write fails because the disk, bucket or account
+	// is full
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// Server-side encryption modes
+const (
+	EncryptionNone = ""
+	// EncryptionAES256 uses provider-managed keys (SSE-S3, GCS default, local AES-GCM)
+	EncryptionAES256 = "AES256"
+	// EncryptionKMS uses a customer-managed key held in the provider's KMS
+	EncryptionKMS = "kms"
+)
+
+// Object describes a stored object
+type Object struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"content_type,omitempty"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified time.Time         `json:"last_modified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// Encryption selects server-side encryption for an upload
+type Encryption struct {
+	Mode     string `yaml:"mode" json:"mode"`
+	KMSKeyID string `yaml:"kms_key_id" json:"kms_key_id,omitempty"`
+}
+
+// PutOptions controls how an object is written
+type PutOptions struct {
+	ContentType string
+	Metadata    map[string]string
+	// Size is the body length when known; unknown sizes are buffered before upload
+	Size int64
+	// Encryption overrides the store default when Mode is set
+	Encryption *Encryption
+}
+
+// Store is implemented by every storage backend
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) (*Object, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	Stat(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// SignedURL returns a time-limited URL allowing method (GET or PUT) on key without credentials
+	SignedURL(ctx context.Context, key, method string, expiry time.Duration) (string, error)
+	Backend() string
+}
+
+// Config selects and configures a backend
+type Config struct {
+	Backend string `yaml:"backend" json:"backend"`
+
+	// Object store settings (s3, gcs)
+	Bucket          string `yaml:"bucket" json:"bucket"`
+	Region          string `yaml:"region" json:"region"`
+	Endpoint        string `yaml:"endpoint" json:"endpoint"`
+	ForcePathStyle  bool   `yaml:"force_path_style" json:"force_path_style"`
+	AccessKeyID     string `yaml:"access_key_id" json:"-"`
+	SecretAccessKey string `yaml:"secret_access_key" json:"-"`
+
+	// Local settings
+	Root    string `yaml:"root" json:"root"`
+	BaseURL string `yaml:"base_url" json:"base_url"`
+	// SigningSecret signs local URLs
+	SigningSecret string `yaml:"signing_secret" json:"-"`
+	// EncryptionKey is a base64 32-byte key used for local AES256 encryption at rest
+	EncryptionKey string `yaml:"encryption_key" json:"-"`
+
+	// Encryption is applied to every upload unless overridden per object
+	Encryption Encryption `yaml:"encryption" json:"encryption"`
+}
+
+// Enabled reports whether a backend has been configured
+func (c Config) Enabled() bool {
+	return c.Backend != ""
+}
+
+// ApplyEnv overrides settings from environment variables named prefix + BACKEND, BUCKET,
+// REGION, ENDPOINT, FORCE_PATH_STYLE, ACCESS_KEY_ID, SECRET_ACCESS_KEY, ROOT, BASE_URL,
+// SIGNING_SECRET, ENCRYPTION_KEY, ENCRYPTION and KMS_KEY_ID
+func (c *Config) ApplyEnv(prefix string) {
+	fields := map[string]*string{
+		"BACKEND":           &c.Backend,
+		"BUCKET":            &c.Bucket,
+		"REGION":            &c.Region,
+		"ENDPOINT":          &c.Endpoint,
+		"ACCESS_KEY_ID":     &c.AccessKeyID,
+		"SECRET_ACCESS_KEY": &c.SecretAccessKey,
+		"ROOT":              &c.Root,
+		"BASE_URL":          &c.BaseURL,
+		"SIGNING_SECRET":    &c.SigningSecret,
+		"ENCRYPTION_KEY":    &c.EncryptionKey,
+		"ENCRYPTION":        &c.Encryption.Mode,
+		"KMS_KEY_ID":        &c.Encryption.KMSKeyID,
+	}
+	for name, field := range fields {
+		if value, ok := os.LookupEnv(prefix + name); ok {
+			*field = value
+		}
+	}
+	if value, ok := os.LookupEnv(prefix + "FORCE_PATH_STYLE"); ok {
+		c.ForcePathStyle, _ = strconv.ParseBool(value)
+	}
+}
+
+// New constructs the configured backend
+func New(cfg Config) (Store, error) {
+	switch cfg.Encryption.Mode {
+	case EncryptionNone, EncryptionAES256, EncryptionKMS:
+	default:
+		return nil, fmt.Errorf("unknown encryption mode %q", cfg.Encryption.Mode)
+	}
+
+	switch cfg.Backend {
+	case "local":
+		return NewLocalStore(cfg)
+	case "s3":
+		return NewS3Store(cfg)
+	case "gcs":
+		return NewGCSStore(cfg)
+	case "":
+		return nil, errors.New("storage backend is not configured")
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// ValidateKey rejects keys that could escape the store or collide with internal files
+func ValidateKey(key string) error {
+	if key == "" || len(key) > 1024 || strings.HasPrefix(key, "/") || strings.ContainsAny(key, "\\\x00") {
+		return ErrInvalidKey
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return ErrInvalidKey
+		}
+	}
+	if strings.HasPrefix(key, localMetaDir+"/") {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+func (e *Encryption) orDefault(fallback Encryption) Encryption {
+	if e == nil || e.Mode == EncryptionNone {
+		return fallback
+	}
+	return *e
+}
+
+// decodeKey parses a base64 encoded 32-byte key
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// sizedBody returns a reader with a known length, buffering the body when necessary
+func sizedBody(body io.Reader, size int64) (io.Reader, int64, error) {
+	if size > 0 {
+		return body, size, nil
+	}
+	if b, ok := body.(interface{ Len() int }); ok {
+		return body, int64(b.Len()), nil
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}